@@ -109,6 +109,17 @@ func getCustomServeFlags() []string {
 	if logCompress != defaultLogCompress {
 		result = append(result, "--"+logCompressFlag+"=true")
 	}
+	if logSyslogAddress != defaultLogSyslogAddress {
+		result = append(result, "--"+logSyslogAddressFlag)
+		result = append(result, logSyslogAddress)
+	}
+	if logSyslogFacility != defaultLogSyslogFacility {
+		result = append(result, "--"+logSyslogFacilityFlag)
+		result = append(result, logSyslogFacility)
+	}
+	if logSyslogOnly != defaultLogSyslogOnly {
+		result = append(result, "--"+logSyslogOnlyFlag+"=true")
+	}
 	if graceTime != defaultGraceTime {
 		result = append(result, "--"+graceTimeFlag)
 		result = append(result, strconv.Itoa(graceTime))
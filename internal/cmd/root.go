@@ -44,6 +44,12 @@ const (
 	logLevelKey              = "log_level"
 	logUTCTimeFlag           = "log-utc-time"
 	logUTCTimeKey            = "log_utc_time"
+	logSyslogAddressFlag     = "log-syslog-address"
+	logSyslogAddressKey      = "log_syslog_address"
+	logSyslogFacilityFlag    = "log-syslog-facility"
+	logSyslogFacilityKey     = "log_syslog_facility"
+	logSyslogOnlyFlag        = "log-syslog-only"
+	logSyslogOnlyKey         = "log_syslog_only"
 	loadDataFromFlag         = "loaddata-from"
 	loadDataFromKey          = "loaddata_from"
 	loadDataModeFlag         = "loaddata-mode"
@@ -63,6 +69,9 @@ const (
 	defaultLogCompress       = false
 	defaultLogLevel          = "debug"
 	defaultLogUTCTime        = false
+	defaultLogSyslogAddress  = ""
+	defaultLogSyslogFacility = "daemon"
+	defaultLogSyslogOnly     = false
 	defaultLoadDataFrom      = ""
 	defaultLoadDataMode      = 1
 	defaultLoadDataQuotaScan = 0
@@ -80,6 +89,9 @@ var (
 	logCompress       bool
 	logLevel          string
 	logUTCTime        bool
+	logSyslogAddress  string
+	logSyslogFacility string
+	logSyslogOnly     bool
 	loadDataFrom      string
 	loadDataMode      int
 	loadDataQuotaScan int
@@ -253,6 +265,39 @@ using SFTPGO_LOG_UTC_TIME env var too.
 `)
 	viper.BindPFlag(logUTCTimeKey, cmd.Flags().Lookup(logUTCTimeFlag)) //nolint:errcheck
 
+	viper.SetDefault(logSyslogAddressKey, defaultLogSyslogAddress)
+	viper.BindEnv(logSyslogAddressKey, "SFTPGO_LOG_SYSLOG_ADDRESS") //nolint:errcheck
+	cmd.Flags().StringVar(&logSyslogAddress, logSyslogAddressFlag, viper.GetString(logSyslogAddressKey),
+		`Address of the syslog server to send logs
+to, for example udp://127.0.0.1:514,
+tcp://127.0.0.1:514 or unixgram:///dev/log.
+Leave empty to disable the syslog output.
+This flag can be set using
+SFTPGO_LOG_SYSLOG_ADDRESS env var too.
+`)
+	viper.BindPFlag(logSyslogAddressKey, cmd.Flags().Lookup(logSyslogAddressFlag)) //nolint:errcheck
+
+	viper.SetDefault(logSyslogFacilityKey, defaultLogSyslogFacility)
+	viper.BindEnv(logSyslogFacilityKey, "SFTPGO_LOG_SYSLOG_FACILITY") //nolint:errcheck
+	cmd.Flags().StringVar(&logSyslogFacility, logSyslogFacilityFlag, viper.GetString(logSyslogFacilityKey),
+		`Syslog facility, for example daemon, auth,
+local0. This flag can be set using
+SFTPGO_LOG_SYSLOG_FACILITY env var too. It is
+unused if log-syslog-address is empty.
+`)
+	viper.BindPFlag(logSyslogFacilityKey, cmd.Flags().Lookup(logSyslogFacilityFlag)) //nolint:errcheck
+
+	viper.SetDefault(logSyslogOnlyKey, defaultLogSyslogOnly)
+	viper.BindEnv(logSyslogOnlyKey, "SFTPGO_LOG_SYSLOG_ONLY") //nolint:errcheck
+	cmd.Flags().BoolVar(&logSyslogOnly, logSyslogOnlyFlag, viper.GetBool(logSyslogOnlyKey),
+		`Send logs to syslog only instead of writing
+them to the log file or the standard output
+too. This flag can be set using
+SFTPGO_LOG_SYSLOG_ONLY env var too. It is
+unused if log-syslog-address is empty.
+`)
+	viper.BindPFlag(logSyslogOnlyKey, cmd.Flags().Lookup(logSyslogOnlyFlag)) //nolint:errcheck
+
 	addBaseLoadDataFlags(cmd)
 
 	viper.SetDefault(loadDataQuotaScanKey, defaultLoadDataQuotaScan)
@@ -55,6 +55,9 @@ Please take a look at the usage below to customize the startup options`,
 				LogCompress:       logCompress,
 				LogLevel:          logLevel,
 				LogUTCTime:        logUTCTime,
+				LogSyslogAddress:  logSyslogAddress,
+				LogSyslogFacility: logSyslogFacility,
+				LogSyslogOnly:     logSyslogOnly,
 				LoadDataFrom:      loadDataFrom,
 				LoadDataMode:      loadDataMode,
 				LoadDataQuotaScan: loadDataQuotaScan,
@@ -123,6 +126,12 @@ func checkServeParamsFromEnvFiles(configDir string) { //nolint:gocyclo
 					logLevel = v
 				case "SFTPGO_LOG_UTC_TIME":
 					setBoolFromEnv(&logUTCTime, v)
+				case "SFTPGO_LOG_SYSLOG_ADDRESS":
+					logSyslogAddress = v
+				case "SFTPGO_LOG_SYSLOG_FACILITY":
+					logSyslogFacility = v
+				case "SFTPGO_LOG_SYSLOG_ONLY":
+					setBoolFromEnv(&logSyslogOnly, v)
 				case "SFTPGO_CONFIG_FILE":
 					configFile = v
 				case "SFTPGO_LOADDATA_FROM":
@@ -42,6 +42,9 @@ var (
 				LogCompress:       logCompress,
 				LogLevel:          logLevel,
 				LogUTCTime:        logUTCTime,
+				LogSyslogAddress:  logSyslogAddress,
+				LogSyslogFacility: logSyslogFacility,
+				LogSyslogOnly:     logSyslogOnly,
 				LoadDataFrom:      loadDataFrom,
 				LoadDataMode:      loadDataMode,
 				LoadDataQuotaScan: loadDataQuotaScan,
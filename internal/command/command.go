@@ -28,6 +28,10 @@ const (
 	defaultTimeout = 30
 )
 
+// WaitDelay defines how long to wait for the I/O pipes to be closed after a timed out
+// command is killed. Child processes could keep the pipes open after the hook exits
+const WaitDelay = 2 * time.Second
+
 // Supported hook names
 const (
 	HookFsActions           = "fs_actions"
@@ -41,12 +45,16 @@ const (
 	HookPostLogin           = "post_login"
 	HookExternalAuth        = "external_auth"
 	HookKeyboardInteractive = "keyboard_interactive"
+	HookUploadScan          = "upload_scan"
+	HookPreDelete           = "pre_delete"
+	HookPreRename           = "pre_rename"
 )
 
 var (
 	config         Config
 	supportedHooks = []string{HookFsActions, HookProviderActions, HookStartup, HookPostConnect, HookPostDisconnect,
-		HookDataRetention, HookCheckPassword, HookPreLogin, HookPostLogin, HookExternalAuth, HookKeyboardInteractive}
+		HookDataRetention, HookCheckPassword, HookPreLogin, HookPostLogin, HookExternalAuth, HookKeyboardInteractive,
+		HookUploadScan, HookPreDelete, HookPreRename}
 )
 
 // Command define the configuration for a specific commands
@@ -0,0 +1,73 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"errors"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	accountLockedEventName   = "Account locked"
+	accountUnlockedEventName = "Account unlocked"
+)
+
+// UpdateAccountLockout records the result of a login attempt for the account
+// lockout. Login attempts for non-existent users and attempts rejected without
+// checking the credentials are ignored
+func UpdateAccountLockout(username, ip, protocol string, err error) {
+	if !dataprovider.IsAccountLockoutEnabled() || username == "" {
+		return
+	}
+	if err != nil && (errors.Is(err, util.ErrNotFound) || errors.Is(err, dataprovider.ErrAccountLocked) ||
+		errors.Is(err, ErrPublicKeyRequired) || errors.Is(err, dataprovider.ErrLoginBudgetExhausted)) {
+		return
+	}
+	switch dataprovider.UpdateAccountLockout(username, err == nil) {
+	case dataprovider.AccountLockoutLocked:
+		logger.Warn(logSender, "", "account %q locked after too many consecutive login failures, last attempt from ip %q, protocol %s",
+			username, ip, protocol)
+		handleAccountLockoutEvent(username, accountLockedEventName, ip, protocol)
+	case dataprovider.AccountLockoutExpired:
+		logger.Info(logSender, "", "lockout expired for account %q", username)
+		handleAccountLockoutEvent(username, accountUnlockedEventName, ip, protocol)
+	}
+}
+
+// UnlockAccount clears the lockout state for the account with the specified
+// username. It returns false if the account was not locked and had no login failures
+func UnlockAccount(username, executor, ip string) bool {
+	if !dataprovider.UnlockAccount(username) {
+		return false
+	}
+	logger.Info(logSender, "", "lockout cleared for account %q by %q, ip: %q", username, executor, ip)
+	handleAccountLockoutEvent(username, accountUnlockedEventName, ip, "")
+	return true
+}
+
+func handleAccountLockoutEvent(username, event, ip, protocol string) {
+	eventManager.handleAccountLockoutEvent(EventParams{
+		Name:      username,
+		Event:     event,
+		Protocol:  protocol,
+		IP:        ip,
+		Timestamp: time.Now(),
+		Status:    1,
+	})
+}
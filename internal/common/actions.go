@@ -20,6 +20,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"os/exec"
@@ -40,6 +41,9 @@ import (
 	"github.com/drakkan/sftpgo/v2/internal/plugin"
 )
 
+// maximum size of the hook response body or output to log
+const maxHookOutputSize = 4096
+
 var (
 	errUnexpectedHTTResponse = errors.New("unexpected HTTP hook response code")
 	hooksConcurrencyGuard    = make(chan struct{}, 150)
@@ -84,6 +88,18 @@ func InitializeActionHandler(handler ActionHandler) {
 // - 1 executed using an external hook
 // - 2 executed using the event manager
 func ExecutePreAction(conn *BaseConnection, operation, filePath, virtualPath string, fileSize int64, openFlags int) (int, error) {
+	return executePreAction(conn, operation, filePath, virtualPath, "", "", fileSize, openFlags)
+}
+
+// executePreAction executes a pre-* action with an optional target path.
+// The pre-delete and pre-rename actions are not executed for internal operations,
+// such as data retention checks and event actions
+func executePreAction(conn *BaseConnection, operation, filePath, virtualPath, target, virtualTarget string,
+	fileSize int64, openFlags int,
+) (int, error) {
+	if conn.isInternal() && (operation == operationPreDelete || operation == operationPreRename) {
+		return 0, nil
+	}
 	var event *notifier.FsEvent
 	hasNotifiersPlugin := plugin.Handler.HasNotifiers()
 	hasHook := slices.Contains(Config.Actions.ExecuteOn, operation)
@@ -92,7 +108,7 @@ func ExecutePreAction(conn *BaseConnection, operation, filePath, virtualPath str
 		return 0, nil
 	}
 	dateTime := time.Now()
-	event = newActionNotification(&conn.User, operation, filePath, virtualPath, "", "", "",
+	event = newActionNotification(&conn.User, operation, filePath, virtualPath, target, virtualTarget, "",
 		conn.protocol, conn.GetRemoteIP(), conn.ID, fileSize, openFlags, conn.getNotificationStatus(nil), 0, dateTime, nil)
 	if hasNotifiersPlugin {
 		plugin.Handler.NotifyFsEvent(event)
@@ -283,14 +299,18 @@ func (h *defaultActionHandler) handleHTTP(event *notifier.FsEvent) error {
 	var b bytes.Buffer
 	_ = json.NewEncoder(&b).Encode(event)
 
-	resp, err := httpclient.RetryablePost(Config.Actions.Hook, "application/json", &b)
+	resp, err := httpclient.RetryablePostForHook(getActionHookName(event.Action), Config.Actions.Hook,
+		"application/json", &b)
 	if err == nil {
 		respCode = resp.StatusCode
-		resp.Body.Close()
 
 		if respCode != http.StatusOK {
 			err = errUnexpectedHTTResponse
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, maxHookOutputSize))
+			logger.Warn(event.Protocol, "", "unexpected response code %d for operation %q, path %q, URL: %s, body: %q",
+				respCode, event.Action, event.VirtualPath, u.Redacted(), body)
 		}
+		resp.Body.Close()
 	}
 
 	logger.Debug(event.Protocol, "", "notified operation %q to URL: %s status code: %d, elapsed: %s err: %v",
@@ -307,15 +327,25 @@ func (h *defaultActionHandler) handleCommand(event *notifier.FsEvent) error {
 		return err
 	}
 
-	timeout, env, args := command.GetConfig(Config.Actions.Hook, command.HookFsActions)
+	timeout, env, args := command.GetConfig(Config.Actions.Hook, getActionHookName(event.Action))
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
+	output := &hookOutput{}
 	cmd := exec.CommandContext(ctx, Config.Actions.Hook, args...)
 	cmd.Env = append(env, notificationAsEnvVars(event)...)
+	cmd.Stdout = output
+	cmd.Stderr = output
 
 	startTime := time.Now()
 	err := cmd.Run()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			logger.Warn(event.Protocol, "", "command %q for operation %q, path %q, exit code: %d, output: %q",
+				Config.Actions.Hook, event.Action, event.VirtualPath, exitErr.ExitCode(), output.String())
+		}
+	}
 
 	logger.Debug(event.Protocol, "", "executed command %q, elapsed: %s, error: %v",
 		Config.Actions.Hook, time.Since(startTime), err)
@@ -323,6 +353,40 @@ func (h *defaultActionHandler) handleCommand(event *notifier.FsEvent) error {
 	return err
 }
 
+// getActionHookName returns the hook name used to get the timeouts for the
+// specified action. The pre-delete and pre-rename actions can have their own timeouts
+func getActionHookName(action string) string {
+	switch action {
+	case operationPreDelete:
+		return command.HookPreDelete
+	case operationPreRename:
+		return command.HookPreRename
+	default:
+		return command.HookFsActions
+	}
+}
+
+// hookOutput stores the first maxHookOutputSize bytes written by a hook,
+// the remaining output is discarded
+type hookOutput struct {
+	buf bytes.Buffer
+}
+
+func (o *hookOutput) Write(p []byte) (int, error) {
+	if remaining := maxHookOutputSize - o.buf.Len(); remaining > 0 {
+		if len(p) > remaining {
+			o.buf.Write(p[:remaining])
+		} else {
+			o.buf.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+func (o *hookOutput) String() string {
+	return o.buf.String()
+}
+
 func notificationAsEnvVars(event *notifier.FsEvent) []string {
 	result := []string{
 		fmt.Sprintf("SFTPGO_ACTION=%s", event.Action),
@@ -15,12 +15,14 @@
 package common
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"testing"
 	"time"
 
@@ -30,6 +32,7 @@ import (
 	"github.com/sftpgo/sdk/plugin/notifier"
 	"github.com/stretchr/testify/assert"
 
+	"github.com/drakkan/sftpgo/v2/internal/command"
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
 	"github.com/drakkan/sftpgo/v2/internal/plugin"
 	"github.com/drakkan/sftpgo/v2/internal/vfs"
@@ -191,6 +194,58 @@ func TestActionCMD(t *testing.T) {
 	Config.Actions = actionsCopy
 }
 
+func TestPreActionsInternalConnections(t *testing.T) {
+	if runtime.GOOS == osWindows {
+		t.Skip("this test is not available on Windows")
+	}
+	actionsCopy := Config.Actions
+
+	hookCmd, err := exec.LookPath("false")
+	assert.NoError(t, err)
+	Config.Actions = ProtocolActions{
+		ExecuteOn: []string{operationPreDelete, operationPreRename},
+		Hook:      hookCmd,
+	}
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: "username",
+		},
+	}
+	c := NewBaseConnection("id", ProtocolSFTP, "", "", user)
+	_, err = executePreAction(c, operationPreDelete, "path", "vpath", "", "", 0, 0)
+	assert.Error(t, err)
+	_, err = executePreAction(c, operationPreRename, "path", "vpath", "target", "vtarget", 0, 0)
+	assert.Error(t, err)
+	for _, protocol := range []string{ProtocolDataRetention, protocolEventAction} {
+		c = NewBaseConnection("id", protocol, "", "", user)
+		status, err := executePreAction(c, operationPreDelete, "path", "vpath", "", "", 0, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, status)
+		status, err = executePreAction(c, operationPreRename, "path", "vpath", "target", "vtarget", 0, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, status)
+	}
+
+	assert.Equal(t, command.HookPreDelete, getActionHookName(operationPreDelete))
+	assert.Equal(t, command.HookPreRename, getActionHookName(operationPreRename))
+	assert.Equal(t, command.HookFsActions, getActionHookName(operationUpload))
+
+	output := &hookOutput{}
+	n, err := output.Write(bytes.Repeat([]byte("a"), maxHookOutputSize-1))
+	assert.NoError(t, err)
+	assert.Equal(t, maxHookOutputSize-1, n)
+	n, err = output.Write([]byte("bc"))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	n, err = output.Write([]byte("d"))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Len(t, output.String(), maxHookOutputSize)
+	assert.True(t, strings.HasSuffix(output.String(), "ab"))
+
+	Config.Actions = actionsCopy
+}
+
 func TestWrongActions(t *testing.T) {
 	actionsCopy := Config.Actions
 
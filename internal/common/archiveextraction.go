@@ -0,0 +1,427 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/xid"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+// supported archive formats for the automatic extraction
+const (
+	archiveFormatZip   = "zip"
+	archiveFormatTar   = "tar"
+	archiveFormatTarGz = "tar.gz"
+)
+
+// maximum number of entry errors included in the extract notification metadata
+const maxExtractionErrors = 10
+
+var errExtractionSizeExceeded = errors.New("archive uncompressed size exceeds the allowed limit")
+
+// getArchiveFormat returns the archive format based on the file extension,
+// an empty string means that the format is not supported
+func getArchiveFormat(name string) string {
+	name = strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return archiveFormatZip
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return archiveFormatTarGz
+	case strings.HasSuffix(name, ".tar"):
+		return archiveFormatTar
+	default:
+		return ""
+	}
+}
+
+// getExtractionEntryPath returns the cleaned relative path for an archive
+// entry. Absolute paths and paths outside the target directory are rejected
+func getExtractionEntryPath(name string) (string, error) {
+	if name == "" || strings.Contains(name, "\\") || path.IsAbs(name) {
+		return "", fmt.Errorf("invalid entry name %q", name)
+	}
+	for _, elem := range strings.Split(name, "/") {
+		if elem == ".." {
+			return "", fmt.Errorf("entry %q points outside the target directory", name)
+		}
+	}
+	entryPath := path.Clean(name)
+	if entryPath == "." {
+		return "", fmt.Errorf("invalid entry name %q", name)
+	}
+	return entryPath, nil
+}
+
+// startArchiveExtraction starts the extraction of the uploaded file in the
+// background if it is inside a virtual folder with the automatic extraction
+// enabled and its name matches the configured patterns
+func startArchiveExtraction(conn *BaseConnection, virtualPath string) {
+	folder, err := conn.User.GetVirtualFolderForPath(path.Dir(virtualPath))
+	if err != nil || !folder.Extraction.Matches(path.Base(virtualPath)) {
+		return
+	}
+	if getArchiveFormat(virtualPath) == "" {
+		conn.Log(logger.LevelWarn, "unable to extract %q, unsupported archive format", virtualPath)
+		return
+	}
+	go extractArchive(conn.User.Username, conn.localAddr, conn.remoteAddr, virtualPath)
+}
+
+func extractArchive(username, localAddr, remoteAddr, virtualPath string) {
+	user, err := dataprovider.GetUserWithGroupSettings(username, "")
+	if err != nil {
+		logger.Warn(logSender, "", "unable to extract %q, error loading user %q: %v", virtualPath, username, err)
+		return
+	}
+	connectionID := fmt.Sprintf("%s_%s", protocolArchiveExtraction, xid.New().String())
+	if err := user.CheckFsRoot(connectionID); err != nil {
+		user.CloseFs() //nolint:errcheck
+		logger.Warn(logSender, connectionID, "unable to extract %q, unable to check root fs for user %q: %v",
+			virtualPath, username, err)
+		return
+	}
+	conn := NewBaseConnection(connectionID, protocolArchiveExtraction, localAddr, remoteAddr, user)
+	defer conn.CloseFS() //nolint:errcheck
+
+	folder, err := conn.User.GetVirtualFolderForPath(path.Dir(virtualPath))
+	if err != nil || !folder.Extraction.Matches(path.Base(virtualPath)) {
+		conn.Log(logger.LevelInfo, "extraction no longer enabled for %q", virtualPath)
+		return
+	}
+	targetDir := path.Dir(virtualPath)
+	if folder.Extraction.TargetDir != "" {
+		targetDir = path.Join(folder.VirtualPath, folder.Extraction.TargetDir)
+	}
+	e := &archiveExtractor{
+		conn:        conn,
+		settings:    folder.Extraction,
+		virtualPath: virtualPath,
+		targetDir:   targetDir,
+	}
+	e.run()
+}
+
+// archiveExtractor extracts an archive through the vfs. The extracted files
+// are subject to the permissions, file patterns filters and quota of the user
+// who uploaded the archive
+type archiveExtractor struct {
+	conn           *BaseConnection
+	settings       vfs.FolderExtraction
+	virtualPath    string
+	targetDir      string
+	archiveSize    int64
+	maxSize        int64
+	entries        int
+	extractedFiles int
+	extractedSize  int64
+	failedEntries  int
+	errors         []string
+}
+
+func (e *archiveExtractor) run() {
+	startTime := time.Now()
+	e.conn.Log(logger.LevelInfo, "extraction started for archive %q, target dir %q", e.virtualPath, e.targetDir)
+	err := e.extract()
+	if err == nil && e.failedEntries > 0 {
+		err = fmt.Errorf("unable to extract %d entries", e.failedEntries)
+	}
+	if err == nil && e.settings.DeleteArchive {
+		err = e.removeArchive()
+	}
+	elapsed := time.Since(startTime).Nanoseconds() / 1000000
+	e.conn.Log(logger.LevelInfo, "extraction completed for archive %q, extracted files: %d, extracted size: %d bytes, "+
+		"failed entries: %d, elapsed: %s, err: %v", e.virtualPath, e.extractedFiles, e.extractedSize, e.failedEntries,
+		time.Since(startTime), err)
+
+	var fsPath, fsTargetPath string
+	if _, p, errFs := e.conn.GetFsAndResolvedPath(e.virtualPath); errFs == nil {
+		fsPath = p
+	}
+	if _, p, errFs := e.conn.GetFsAndResolvedPath(e.targetDir); errFs == nil {
+		fsTargetPath = p
+	}
+	ExecuteActionNotification(e.conn, operationExtract, fsPath, e.virtualPath, fsTargetPath, e.targetDir, "", //nolint:errcheck
+		e.archiveSize, err, elapsed, e.getMetadata())
+}
+
+func (e *archiveExtractor) getMetadata() map[string]string {
+	metadata := map[string]string{
+		"extracted_files": strconv.Itoa(e.extractedFiles),
+		"extracted_size":  strconv.FormatInt(e.extractedSize, 10),
+	}
+	if len(e.errors) > 0 {
+		metadata["errors"] = strings.Join(e.errors, "; ")
+	}
+	return metadata
+}
+
+func (e *archiveExtractor) addEntryError(name string, err error) {
+	e.conn.Log(logger.LevelWarn, "unable to extract entry %q from archive %q: %v", name, e.virtualPath, err)
+	e.failedEntries++
+	if len(e.errors) < maxExtractionErrors {
+		e.errors = append(e.errors, fmt.Sprintf("%s: %v", name, err))
+	}
+}
+
+func (e *archiveExtractor) extract() error {
+	fs, fsPath, err := e.conn.GetFsAndResolvedPath(e.virtualPath)
+	if err != nil {
+		return err
+	}
+	info, err := fs.Stat(fsPath)
+	if err != nil {
+		return e.conn.GetFsError(fs, err)
+	}
+	e.archiveSize = info.Size()
+	e.maxSize = e.settings.GetMaxSize()
+	if ratioLimit := e.archiveSize * int64(e.settings.GetMaxRatio()); ratioLimit >= 0 && ratioLimit < e.maxSize {
+		e.maxSize = ratioLimit
+	}
+	if err := e.conn.CheckParentDirs(e.targetDir); err != nil {
+		return err
+	}
+	f, r, cancelFn, err := fs.Open(fsPath, 0)
+	if err != nil {
+		return e.conn.GetFsError(fs, err)
+	}
+	if cancelFn != nil {
+		defer cancelFn()
+	}
+	var reader io.ReadCloser = r
+	if f != nil {
+		reader = f
+	}
+	defer reader.Close()
+
+	switch getArchiveFormat(e.virtualPath) {
+	case archiveFormatZip:
+		if f != nil {
+			return e.extractZip(f, e.archiveSize)
+		}
+		// zip archives require random access, the pipe is stored to a
+		// temporary file
+		tempFile, err := os.CreateTemp(Config.TempPath, "extract")
+		if err != nil {
+			return err
+		}
+		defer func() {
+			tempFile.Close()
+			os.Remove(tempFile.Name())
+		}()
+		size, err := io.Copy(tempFile, reader)
+		if err != nil {
+			return err
+		}
+		return e.extractZip(tempFile, size)
+	case archiveFormatTarGz:
+		gzReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return fmt.Errorf("unable to read gzip archive: %w", err)
+		}
+		defer gzReader.Close()
+		return e.extractTar(gzReader)
+	case archiveFormatTar:
+		return e.extractTar(reader)
+	default:
+		return fmt.Errorf("unsupported archive format for %q", e.virtualPath)
+	}
+}
+
+func (e *archiveExtractor) extractZip(reader io.ReaderAt, size int64) error {
+	zipReader, err := zip.NewReader(reader, size)
+	if err != nil && !errors.Is(err, zip.ErrInsecurePath) {
+		return fmt.Errorf("unable to read zip archive: %w", err)
+	}
+	if len(zipReader.File) > e.settings.GetMaxEntries() {
+		return fmt.Errorf("too many entries in archive: %d, max allowed: %d", len(zipReader.File),
+			e.settings.GetMaxEntries())
+	}
+	var declaredSize uint64
+	for _, zf := range zipReader.File {
+		declaredSize += zf.UncompressedSize64
+	}
+	if declaredSize > uint64(e.maxSize) {
+		return fmt.Errorf("%w, declared size: %d, max allowed: %d", errExtractionSizeExceeded, declaredSize, e.maxSize)
+	}
+	for _, zf := range zipReader.File {
+		err := e.extractEntry(zf.Name, zf.Mode(), int64(zf.UncompressedSize64), func() (io.ReadCloser, error) {
+			return zf.Open()
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *archiveExtractor) extractTar(reader io.Reader) error {
+	tarReader := tar.NewReader(reader)
+	for {
+		hdr, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil && !errors.Is(err, tar.ErrInsecurePath) {
+			return fmt.Errorf("unable to read tar archive: %w", err)
+		}
+		err = e.extractEntry(hdr.Name, hdr.FileInfo().Mode(), hdr.Size, func() (io.ReadCloser, error) {
+			return io.NopCloser(tarReader), nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// extractEntry extracts a single archive entry. Errors affecting only the
+// entry are recorded, the returned error aborts the extraction
+func (e *archiveExtractor) extractEntry(name string, mode os.FileMode, size int64,
+	openFn func() (io.ReadCloser, error),
+) error {
+	e.entries++
+	if e.entries > e.settings.GetMaxEntries() {
+		return fmt.Errorf("too many entries in archive, max allowed: %d", e.settings.GetMaxEntries())
+	}
+	entryPath, err := getExtractionEntryPath(name)
+	if err != nil {
+		e.addEntryError(name, err)
+		return nil
+	}
+	virtualPath := path.Join(e.targetDir, entryPath)
+	if mode.IsDir() {
+		if err := e.conn.CheckParentDirs(virtualPath); err != nil {
+			e.addEntryError(name, err)
+		}
+		return nil
+	}
+	if !mode.IsRegular() {
+		e.conn.Log(logger.LevelDebug, "skipping non regular entry %q in archive %q", name, e.virtualPath)
+		return nil
+	}
+	if virtualPath == e.virtualPath {
+		e.addEntryError(name, errors.New("the entry would overwrite the archive"))
+		return nil
+	}
+	if ok, _ := e.conn.User.IsFileAllowed(virtualPath); !ok {
+		e.addEntryError(name, e.conn.GetPermissionDeniedError())
+		return nil
+	}
+	if size > e.maxSize-e.extractedSize {
+		return fmt.Errorf("%w, max allowed: %d", errExtractionSizeExceeded, e.maxSize)
+	}
+	maxFileSize := e.conn.User.GetMaxUploadFileSize(virtualPath)
+	if maxFileSize > 0 && size > maxFileSize {
+		e.addEntryError(name, fmt.Errorf("entry size %d exceeds the allowed limit: %d", size, maxFileSize))
+		return nil
+	}
+	if err := e.conn.CheckParentDirs(path.Dir(virtualPath)); err != nil {
+		e.addEntryError(name, err)
+		return nil
+	}
+	entryReader, err := openFn()
+	if err != nil {
+		e.addEntryError(name, err)
+		return nil
+	}
+	defer entryReader.Close()
+
+	written, err := e.writeEntry(virtualPath, entryReader, size, maxFileSize)
+	if err != nil {
+		if e.conn.IsQuotaExceededError(err) || errors.Is(err, errExtractionSizeExceeded) {
+			return err
+		}
+		e.addEntryError(name, err)
+		return nil
+	}
+	e.extractedFiles++
+	e.extractedSize += written
+	return nil
+}
+
+func (e *archiveExtractor) writeEntry(virtualPath string, reader io.Reader, size, maxFileSize int64) (int64, error) {
+	writer, numFiles, truncatedSize, cancelFn, err := getFileWriter(e.conn, virtualPath, size)
+	if err != nil {
+		return 0, err
+	}
+	defer cancelFn()
+
+	// the declared size cannot be trusted, the limits are enforced while copying
+	limit := e.maxSize - e.extractedSize
+	limitErr := errExtractionSizeExceeded
+	if maxFileSize > 0 && maxFileSize < limit {
+		limit = maxFileSize
+		limitErr = fmt.Errorf("entry size exceeds the allowed limit: %d", maxFileSize)
+	}
+	q, _ := e.conn.HasSpace(numFiles > 0, false, virtualPath)
+	if remaining := q.GetRemainingSize() + truncatedSize; q.QuotaSize > 0 && remaining < limit {
+		limit = remaining
+		limitErr = e.conn.GetQuotaExceededError()
+	}
+	written, errCopy := io.Copy(writer, io.LimitReader(reader, limit+1))
+	if errCopy == nil && written > limit {
+		errCopy = limitErr
+	}
+	errClose := writer.Close()
+	if errCopy == nil {
+		errCopy = errClose
+	}
+	fs, fsPath, err := e.conn.GetFsAndResolvedPath(virtualPath)
+	if err != nil {
+		return 0, err
+	}
+	if errCopy != nil {
+		// remove the partial file, if this fails we can't do anything
+		errRemove := fs.Remove(fsPath, false)
+		e.conn.Log(logger.LevelDebug, "removing partial file %q after extraction error, result: %v", virtualPath, errRemove)
+		if errRemove == nil && numFiles == 0 {
+			updateUserQuotaAfterFileWrite(e.conn, virtualPath, -1, -truncatedSize)
+		}
+		return 0, errCopy
+	}
+	info, err := fs.Stat(fsPath)
+	if err != nil {
+		return 0, e.conn.GetFsError(fs, err)
+	}
+	updateUserQuotaAfterFileWrite(e.conn, virtualPath, numFiles, info.Size()-truncatedSize)
+	return info.Size(), nil
+}
+
+func (e *archiveExtractor) removeArchive() error {
+	fs, fsPath, err := e.conn.GetFsAndResolvedPath(e.virtualPath)
+	if err != nil {
+		return err
+	}
+	info, err := fs.Lstat(fsPath)
+	if err != nil {
+		return e.conn.GetFsError(fs, err)
+	}
+	return e.conn.RemoveFile(fs, fsPath, e.virtualPath, info)
+}
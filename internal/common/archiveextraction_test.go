@@ -0,0 +1,281 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sftpgo/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+type testArchiveEntry struct {
+	name    string
+	content []byte
+}
+
+func getTestZipArchive(t *testing.T, entries []testArchiveEntry) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, e := range entries {
+		f, err := w.Create(e.name)
+		require.NoError(t, err)
+		_, err = f.Write(e.content)
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func getTestTarGzArchive(t *testing.T, entries []testArchiveEntry) []byte {
+	var buf bytes.Buffer
+	gzWriter := gzip.NewWriter(&buf)
+	w := tar.NewWriter(gzWriter)
+	for _, e := range entries {
+		err := w.WriteHeader(&tar.Header{
+			Name:     e.name,
+			Mode:     0644,
+			Size:     int64(len(e.content)),
+			Typeflag: tar.TypeReg,
+		})
+		require.NoError(t, err)
+		_, err = w.Write(e.content)
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+	require.NoError(t, gzWriter.Close())
+	return buf.Bytes()
+}
+
+func TestGetArchiveFormat(t *testing.T) {
+	assert.Equal(t, archiveFormatZip, getArchiveFormat("batch.ZIP"))
+	assert.Equal(t, archiveFormatTar, getArchiveFormat("batch.tar"))
+	assert.Equal(t, archiveFormatTarGz, getArchiveFormat("batch.tar.gz"))
+	assert.Equal(t, archiveFormatTarGz, getArchiveFormat("batch.tgz"))
+	assert.Empty(t, getArchiveFormat("batch.rar"))
+	assert.Empty(t, getArchiveFormat("batch.gz"))
+}
+
+func TestGetExtractionEntryPath(t *testing.T) {
+	for name, expected := range map[string]string{
+		"file.txt":          "file.txt",
+		"dir/file.txt":      "dir/file.txt",
+		"./dir//file.txt":   "dir/file.txt",
+		"dir/":              "dir",
+		"dir/..file/a.txt":  "dir/..file/a.txt",
+		"dir/file..txt":     "dir/file..txt",
+		"dir/sub/./file.md": "dir/sub/file.md",
+	} {
+		p, err := getExtractionEntryPath(name)
+		if assert.NoError(t, err, name) {
+			assert.Equal(t, expected, p)
+		}
+	}
+	for _, name := range []string{"", ".", "/etc/passwd", "../file.txt", "dir/../../file.txt", "dir/../file.txt",
+		"..\\file.txt", "dir\\file.txt"} {
+		_, err := getExtractionEntryPath(name)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestFolderExtractionValidation(t *testing.T) {
+	folder := vfs.BaseVirtualFolder{
+		Name:       "extraction_validation",
+		MappedPath: filepath.Join(os.TempDir(), "extraction_validation"),
+		Extraction: vfs.FolderExtraction{
+			Patterns: []string{"sub/*.zip"},
+		},
+	}
+	err := dataprovider.AddFolder(&folder, "", "", "")
+	assert.Error(t, err)
+	folder.Extraction.Patterns = []string{"[a-"}
+	err = dataprovider.AddFolder(&folder, "", "", "")
+	assert.Error(t, err)
+	folder.Extraction.Patterns = []string{"*.zip"}
+	folder.Extraction.MaxEntries = -1
+	err = dataprovider.AddFolder(&folder, "", "", "")
+	assert.Error(t, err)
+	folder.Extraction.MaxEntries = 0
+	folder.Extraction.MaxSize = -1
+	err = dataprovider.AddFolder(&folder, "", "", "")
+	assert.Error(t, err)
+	folder.Extraction.MaxSize = 0
+	folder.Extraction.MaxRatio = -1
+	err = dataprovider.AddFolder(&folder, "", "", "")
+	assert.Error(t, err)
+	folder.Extraction = vfs.FolderExtraction{
+		Patterns:      []string{" *.zip ", "*.zip", "", "*.tar.gz"},
+		TargetDir:     "extracted/",
+		MaxEntries:    10,
+		DeleteArchive: true,
+	}
+	err = dataprovider.AddFolder(&folder, "", "", "")
+	require.NoError(t, err)
+	folder, err = dataprovider.GetFolderByName(folder.Name)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"*.zip", "*.tar.gz"}, folder.Extraction.Patterns)
+	assert.Equal(t, "/extracted", folder.Extraction.TargetDir)
+	assert.Equal(t, 10, folder.Extraction.GetMaxEntries())
+	assert.Equal(t, vfs.DefaultExtractionMaxSize, folder.Extraction.GetMaxSize())
+	assert.Equal(t, vfs.DefaultExtractionMaxRatio, folder.Extraction.GetMaxRatio())
+	assert.True(t, folder.Extraction.DeleteArchive)
+	// without patterns the extraction is disabled and the other settings are reset
+	folder.Extraction.Patterns = nil
+	err = dataprovider.UpdateFolder(&folder, nil, nil, "", "", "")
+	require.NoError(t, err)
+	folder, err = dataprovider.GetFolderByName(folder.Name)
+	require.NoError(t, err)
+	assert.Equal(t, vfs.FolderExtraction{}, folder.Extraction)
+	err = dataprovider.DeleteFolder(folder.Name, "", "", "")
+	assert.NoError(t, err)
+}
+
+func TestArchiveExtraction(t *testing.T) {
+	mappedPath := filepath.Join(os.TempDir(), "archive_extraction")
+	folder := vfs.BaseVirtualFolder{
+		Name:       "archive_extraction",
+		MappedPath: mappedPath,
+		Extraction: vfs.FolderExtraction{
+			Patterns:   []string{"*.zip", "*.tar.gz"},
+			TargetDir:  "/out",
+			MaxEntries: 4,
+		},
+	}
+	err := dataprovider.AddFolder(&folder, "", "", "")
+	require.NoError(t, err)
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username:  "archive_extraction_user",
+			Password:  "pwd",
+			HomeDir:   filepath.Join(os.TempDir(), "archive_extraction_user"),
+			Status:    1,
+			QuotaSize: 1048576,
+			Permissions: map[string][]string{
+				"/": {dataprovider.PermAny},
+			},
+		},
+		Filters: dataprovider.UserFilters{
+			BaseUserFilters: sdk.BaseUserFilters{
+				FilePatterns: []sdk.PatternsFilter{
+					{
+						Path:            "/archives/out",
+						DeniedPatterns:  []string{"*.exe"},
+						DenyPolicy:      sdk.DenyPolicyDefault,
+						AllowedPatterns: []string{},
+					},
+				},
+			},
+		},
+		VirtualFolders: []vfs.VirtualFolder{
+			{
+				BaseVirtualFolder: vfs.BaseVirtualFolder{
+					Name: folder.Name,
+				},
+				VirtualPath: "/archives",
+				QuotaSize:   -1,
+				QuotaFiles:  -1,
+			},
+		},
+	}
+	err = dataprovider.AddUser(&user, "", "", "")
+	require.NoError(t, err)
+
+	writeArchive := func(name string, content []byte) {
+		err := os.MkdirAll(mappedPath, os.ModePerm)
+		require.NoError(t, err)
+		err = os.WriteFile(filepath.Join(mappedPath, name), content, 0666)
+		require.NoError(t, err)
+	}
+	outDir := filepath.Join(mappedPath, "out")
+	content := bytes.Repeat([]byte("a"), 512)
+
+	writeArchive("batch.zip", getTestZipArchive(t, []testArchiveEntry{
+		{name: "file1.txt", content: content},
+		{name: "sub/file2.txt", content: []byte("data")},
+		{name: "../escaped.txt", content: []byte("data")},
+		{name: "denied.exe", content: []byte("data")},
+	}))
+	extractArchive(user.Username, "", "", "/archives/batch.zip")
+	assert.FileExists(t, filepath.Join(outDir, "file1.txt"))
+	assert.FileExists(t, filepath.Join(outDir, "sub", "file2.txt"))
+	assert.NoFileExists(t, filepath.Join(mappedPath, "escaped.txt"))
+	assert.NoFileExists(t, filepath.Join(outDir, "denied.exe"))
+	// some entries failed, the archive is not removed
+	assert.FileExists(t, filepath.Join(mappedPath, "batch.zip"))
+	err = os.RemoveAll(outDir)
+	assert.NoError(t, err)
+	// too many entries
+	writeArchive("batch.zip", getTestZipArchive(t, []testArchiveEntry{
+		{name: "file1.txt", content: content},
+		{name: "file2.txt", content: content},
+		{name: "file3.txt", content: content},
+		{name: "file4.txt", content: content},
+		{name: "file5.txt", content: content},
+	}))
+	extractArchive(user.Username, "", "", "/archives/batch.zip")
+	assert.NoFileExists(t, filepath.Join(outDir, "file1.txt"))
+	// the compression ratio exceeds the limit
+	folder.Extraction.MaxRatio = 2
+	folder.Extraction.DeleteArchive = true
+	err = dataprovider.UpdateFolder(&folder, nil, nil, "", "", "")
+	require.NoError(t, err)
+	writeArchive("batch.tar.gz", getTestTarGzArchive(t, []testArchiveEntry{
+		{name: "file1.txt", content: bytes.Repeat(content, 100)},
+	}))
+	extractArchive(user.Username, "", "", "/archives/batch.tar.gz")
+	assert.NoFileExists(t, filepath.Join(outDir, "file1.txt"))
+	assert.FileExists(t, filepath.Join(mappedPath, "batch.tar.gz"))
+	// successful extraction, the archive is removed
+	folder.Extraction.MaxRatio = 1000
+	err = dataprovider.UpdateFolder(&folder, nil, nil, "", "", "")
+	require.NoError(t, err)
+	extractArchive(user.Username, "", "", "/archives/batch.tar.gz")
+	assert.FileExists(t, filepath.Join(outDir, "file1.txt"))
+	assert.NoFileExists(t, filepath.Join(mappedPath, "batch.tar.gz"))
+	user, err = dataprovider.UserExists(user.Username, "")
+	require.NoError(t, err)
+	assert.Greater(t, user.UsedQuotaSize, int64(len(content)*100))
+	// quota exceeded
+	user.QuotaSize = 1024
+	err = dataprovider.UpdateUser(&user, "", "", "")
+	require.NoError(t, err)
+	writeArchive("batch.zip", getTestZipArchive(t, []testArchiveEntry{
+		{name: "file2.txt", content: content},
+	}))
+	extractArchive(user.Username, "", "", "/archives/batch.zip")
+	assert.NoFileExists(t, filepath.Join(outDir, "file2.txt"))
+	assert.FileExists(t, filepath.Join(mappedPath, "batch.zip"))
+	// missing user
+	extractArchive("missing_user", "", "", "/archives/batch.zip")
+
+	err = dataprovider.DeleteUser(user.Username, "", "", "")
+	assert.NoError(t, err)
+	err = dataprovider.DeleteFolder(folder.Name, "", "", "")
+	assert.NoError(t, err)
+	err = os.RemoveAll(mappedPath)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
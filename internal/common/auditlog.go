@@ -0,0 +1,116 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/metric"
+)
+
+// operations recorded in the audit log only
+const (
+	auditOperationChmod = "chmod"
+	auditOperationChown = "chown"
+)
+
+// AuditLogConfig defines the configuration for the audit log of the file operations.
+// The audit log is written to a dedicated file, separate from the service log,
+// and contains a JSON record for each completed upload, download, delete,
+// rename, mkdir, rmdir and permission change
+type AuditLogConfig struct {
+	// Set to true to enable the audit log
+	Enabled bool `json:"enabled" mapstructure:"enabled"`
+	// Absolute path to the audit log file
+	FilePath string `json:"file_path" mapstructure:"file_path"`
+	// Maximum size in megabytes of the audit log file before it gets rotated
+	MaxSize int `json:"max_size" mapstructure:"max_size"`
+	// Maximum number of old audit log files to retain
+	MaxBackups int `json:"max_backups" mapstructure:"max_backups"`
+	// Maximum number of days to retain old audit log files
+	MaxAge int `json:"max_age" mapstructure:"max_age"`
+	// Compress determines if the rotated audit log files should be compressed using gzip
+	Compress bool `json:"compress" mapstructure:"compress"`
+	// Number of audit records that can be queued waiting to be written
+	BufferSize int `json:"buffer_size" mapstructure:"buffer_size"`
+	// If true the audit records are dropped, and counted, if the queue is full or
+	// the audit log file cannot be written. By default the file operations wait
+	// for the audit records to be queued
+	DropOnFailure bool `json:"drop_on_failure" mapstructure:"drop_on_failure"`
+}
+
+func (c *AuditLogConfig) initialize() error {
+	if !c.Enabled {
+		logger.CloseAuditLogger()
+		return nil
+	}
+	err := logger.InitAuditLogger(logger.AuditLoggerConfig{
+		FilePath:      c.FilePath,
+		MaxSize:       c.MaxSize,
+		MaxBackups:    c.MaxBackups,
+		MaxAge:        c.MaxAge,
+		Compress:      c.Compress,
+		BufferSize:    c.BufferSize,
+		DropOnFailure: c.DropOnFailure,
+		OnDrop:        metric.AddAuditRecordDropped,
+	})
+	if err != nil {
+		return err
+	}
+	logger.Info(logSender, "", "audit log initialized, file path %q, drop on failure: %t", c.FilePath, c.DropOnFailure)
+	return nil
+}
+
+// auditLog records a completed file operation in the audit log, if enabled
+func (c *BaseConnection) auditLog(operation, virtualPath, virtualTargetPath string, size, elapsed int64, err error) {
+	if !logger.IsAuditLogEnabled() {
+		return
+	}
+	logger.AuditLog(c.newAuditRecord(operation, virtualPath, virtualTargetPath, size, elapsed, err))
+}
+
+// auditUploadLog records a completed upload in the audit log, if enabled,
+// including the checksum if available
+func (c *BaseConnection) auditUploadLog(virtualPath string, size, elapsed int64, checksum string, err error) {
+	if !logger.IsAuditLogEnabled() {
+		return
+	}
+	record := c.newAuditRecord(operationUpload, virtualPath, "", size, elapsed, err)
+	if err == nil {
+		record.SHA256 = checksum
+	}
+	logger.AuditLog(record)
+}
+
+func (c *BaseConnection) newAuditRecord(operation, virtualPath, virtualTargetPath string, size, elapsed int64,
+	err error,
+) *logger.AuditRecord {
+	record := &logger.AuditRecord{
+		Username:          c.User.Username,
+		ConnectionID:      c.ID,
+		Protocol:          c.protocol,
+		IP:                c.GetRemoteIP(),
+		Operation:         operation,
+		VirtualPath:       virtualPath,
+		VirtualTargetPath: virtualTargetPath,
+		Size:              size,
+		Elapsed:           elapsed,
+		Status:            1,
+	}
+	if err != nil {
+		record.Status = 0
+		record.Error = err.Error()
+	}
+	return record
+}
@@ -0,0 +1,93 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sftpgo/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+)
+
+func TestAuditLog(t *testing.T) {
+	c := AuditLogConfig{
+		Enabled:  true,
+		FilePath: "relative.log",
+	}
+	err := c.initialize()
+	assert.Error(t, err)
+	assert.False(t, logger.IsAuditLogEnabled())
+
+	c.FilePath = filepath.Join(os.TempDir(), "audit", "audit.log")
+	err = c.initialize()
+	require.NoError(t, err)
+	assert.True(t, logger.IsAuditLogEnabled())
+
+	conn := NewBaseConnection("id", ProtocolSFTP, "", "127.0.0.1:1234", dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: "audit_user",
+		},
+	})
+	conn.auditLog(operationUpload, "/file", "", 100, 10, nil)
+	conn.auditLog(operationRename, "/file", "/file1", 100, 1, errors.New("rename error"))
+	conn.auditUploadLog("/file2", 10, 1, "abcd", nil)
+
+	c.Enabled = false
+	err = c.initialize()
+	require.NoError(t, err)
+	assert.False(t, logger.IsAuditLogEnabled())
+	// the audit log is disabled, this record is not written
+	conn.auditLog(operationDelete, "/file1", "", 100, 1, nil)
+
+	f, err := os.Open(c.FilePath)
+	require.NoError(t, err)
+	var records []logger.AuditRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var record logger.AuditRecord
+		err = json.Unmarshal(scanner.Bytes(), &record)
+		assert.NoError(t, err)
+		records = append(records, record)
+	}
+	err = f.Close()
+	assert.NoError(t, err)
+	if assert.Len(t, records, 3) {
+		assert.Equal(t, "audit_user", records[0].Username)
+		assert.Equal(t, "127.0.0.1", records[0].IP)
+		assert.Equal(t, ProtocolSFTP, records[0].Protocol)
+		assert.Equal(t, operationUpload, records[0].Operation)
+		assert.Equal(t, int64(100), records[0].Size)
+		assert.Equal(t, 1, records[0].Status)
+		assert.False(t, records[0].Timestamp.IsZero())
+		assert.Equal(t, operationRename, records[1].Operation)
+		assert.Equal(t, "/file1", records[1].VirtualTargetPath)
+		assert.Equal(t, 0, records[1].Status)
+		assert.Equal(t, "rename error", records[1].Error)
+		assert.Empty(t, records[1].SHA256)
+		assert.Equal(t, operationUpload, records[2].Operation)
+		assert.Equal(t, "abcd", records[2].SHA256)
+	}
+	err = os.RemoveAll(filepath.Dir(c.FilePath))
+	assert.NoError(t, err)
+}
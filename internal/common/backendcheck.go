@@ -0,0 +1,226 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/sftpgo/sdk"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+// Steps for the backend checks
+const (
+	BackendCheckStepConnect = "connect"
+	BackendCheckStepList    = "list"
+	BackendCheckStepWrite   = "write"
+	BackendCheckStepRemove  = "remove"
+)
+
+const (
+	// DefaultBackendCheckTimeout defines the default timeout for a backend check
+	DefaultBackendCheckTimeout = 30 * time.Second
+	// MaxBackendCheckTimeout defines the maximum allowed timeout for a backend check
+	MaxBackendCheckTimeout = 5 * time.Minute
+	// prefix for the probe file written if the write test is requested
+	backendCheckProbePrefix = ".sftpgo_probe_"
+)
+
+var (
+	// content for the probe file
+	backendCheckProbeContent = []byte("SFTPGo backend check")
+	errBackendCheckTimeout   = errors.New("backend check timed out")
+)
+
+// BackendCheckStep defines the result for a backend check step
+type BackendCheckStep struct {
+	Name string `json:"name"`
+	// Latency as milliseconds
+	Latency int64 `json:"latency"`
+	// Backend error, if any, reported verbatim
+	Error string `json:"error,omitempty"`
+}
+
+// BackendCheckResult defines the result for a folder backend check
+type BackendCheckResult struct {
+	Provider sdk.FilesystemProvider `json:"provider"`
+	Success  bool                   `json:"success"`
+	// Total latency as milliseconds
+	Latency int64 `json:"latency"`
+	// Executed steps, the last one failed if the check was not successful
+	Steps []BackendCheckStep `json:"steps"`
+	// Error for the failed step or the timeout error
+	Error string `json:"error,omitempty"`
+}
+
+// backendCheck checks the filesystem for a virtual folder. The check runs
+// in its own goroutine so the caller can stop waiting after the timeout.
+// Each step is sent to the steps channel, the channel is closed when the
+// check completes
+type backendCheck struct {
+	folder    vfs.VirtualFolder
+	writeTest bool
+	steps     chan BackendCheckStep
+}
+
+func (c *backendCheck) runStep(name string, fn func() error) bool {
+	startTime := time.Now()
+	err := fn()
+	step := BackendCheckStep{
+		Name:    name,
+		Latency: time.Since(startTime).Milliseconds(),
+	}
+	if err != nil {
+		step.Error = err.Error()
+	}
+	c.steps <- step
+	return err == nil
+}
+
+func (c *backendCheck) run() {
+	defer close(c.steps)
+
+	var fs vfs.Fs
+	if !c.runStep(BackendCheckStepConnect, func() error {
+		var err error
+		fs, err = c.folder.GetFilesystem("backend_check", nil)
+		return err
+	}) {
+		return
+	}
+	defer fs.Close()
+
+	if !c.runStep(BackendCheckStepList, func() error {
+		return listBackendRoot(fs)
+	}) {
+		return
+	}
+	if !c.writeTest {
+		return
+	}
+	probePath, err := fs.ResolvePath("/" + backendCheckProbePrefix + util.GenerateUniqueID())
+	if err != nil {
+		c.runStep(BackendCheckStepWrite, func() error { return err })
+		return
+	}
+	if !c.runStep(BackendCheckStepWrite, func() error {
+		return writeBackendProbe(fs, probePath)
+	}) {
+		// a partially written probe must not be left on the backend
+		if err := fs.Remove(probePath, false); err != nil && !fs.IsNotExist(err) {
+			logger.Warn(logSender, "", "unable to remove the backend check probe %q: %v", probePath, err)
+		}
+		return
+	}
+	c.runStep(BackendCheckStepRemove, func() error {
+		return fs.Remove(probePath, false)
+	})
+}
+
+func listBackendRoot(fs vfs.Fs) error {
+	rootPath, err := fs.ResolvePath("/")
+	if err != nil {
+		return err
+	}
+	lister, err := fs.ReadDir(rootPath)
+	if err != nil {
+		return err
+	}
+	defer lister.Close()
+
+	_, err = lister.Next(vfs.ListerBatchSize)
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
+
+func writeBackendProbe(fs vfs.Fs, probePath string) error {
+	f, w, cancelFn, err := fs.Create(probePath, 0, 0)
+	if err != nil {
+		return err
+	}
+	if cancelFn != nil {
+		defer cancelFn()
+	}
+	var writer io.WriteCloser = w
+	if f != nil {
+		writer = f
+	}
+	_, err = writer.Write(backendCheckProbeContent)
+	errClose := writer.Close()
+	if err != nil {
+		return err
+	}
+	return errClose
+}
+
+// CheckFolderBackend instantiates the filesystem for the specified folder and
+// performs a minimal round trip: the root directory is listed and, if
+// writeTest is true, a small probe file is written and removed. The probe
+// file is removed even if the check fails. The folder secrets must be
+// encrypted as for a saved folder. If the check does not complete within
+// the specified timeout a timeout error is returned, the check continues
+// in background so that the probe file is removed anyway
+func CheckFolderBackend(folder vfs.BaseVirtualFolder, writeTest bool, timeout time.Duration) BackendCheckResult {
+	if timeout <= 0 {
+		timeout = DefaultBackendCheckTimeout
+	}
+	timeout = min(timeout, MaxBackendCheckTimeout)
+	result := BackendCheckResult{
+		Provider: folder.FsConfig.Provider,
+		Steps:    []BackendCheckStep{},
+	}
+	check := &backendCheck{
+		folder: vfs.VirtualFolder{
+			BaseVirtualFolder: folder,
+			VirtualPath:       "/",
+		},
+		writeTest: writeTest,
+		steps:     make(chan BackendCheckStep, 4),
+	}
+	startTime := time.Now()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	go check.run()
+
+	for {
+		select {
+		case step, ok := <-check.steps:
+			if !ok {
+				result.Latency = time.Since(startTime).Milliseconds()
+				result.Success = result.Error == ""
+				return result
+			}
+			result.Steps = append(result.Steps, step)
+			if step.Error != "" {
+				result.Error = step.Error
+			}
+		case <-timer.C:
+			result.Latency = time.Since(startTime).Milliseconds()
+			result.Error = fmt.Sprintf("%v after %s", errBackendCheckTimeout, timeout)
+			logger.Warn(logSender, "", "check for folder %q, provider %d: %s", folder.Name,
+				folder.FsConfig.Provider, result.Error)
+			return result
+		}
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sftpgo/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/kms"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+func TestCheckFolderBackend(t *testing.T) {
+	mappedPath := filepath.Join(os.TempDir(), "backend_check")
+	err := os.MkdirAll(mappedPath, os.ModePerm)
+	require.NoError(t, err)
+	folder := vfs.BaseVirtualFolder{
+		Name:       "backend_check",
+		MappedPath: mappedPath,
+	}
+	result := CheckFolderBackend(folder, false, 0)
+	assert.True(t, result.Success)
+	assert.Empty(t, result.Error)
+	assert.Equal(t, sdk.LocalFilesystemProvider, result.Provider)
+	if assert.Len(t, result.Steps, 2) {
+		assert.Equal(t, BackendCheckStepConnect, result.Steps[0].Name)
+		assert.Equal(t, BackendCheckStepList, result.Steps[1].Name)
+	}
+	result = CheckFolderBackend(folder, true, time.Minute)
+	assert.True(t, result.Success)
+	if assert.Len(t, result.Steps, 4) {
+		assert.Equal(t, BackendCheckStepWrite, result.Steps[2].Name)
+		assert.Equal(t, BackendCheckStepRemove, result.Steps[3].Name)
+	}
+	// the probe file must be removed
+	entries, err := os.ReadDir(mappedPath)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 0)
+	// the crypted filesystem requires an encrypted passphrase as for saved folders
+	folder.FsConfig = vfs.Filesystem{
+		Provider: sdk.CryptedFilesystemProvider,
+		CryptConfig: vfs.CryptFsConfig{
+			Passphrase: kms.NewPlainSecret("secret"),
+		},
+	}
+	err = dataprovider.ValidateFolder(&folder)
+	require.NoError(t, err)
+	result = CheckFolderBackend(folder, true, 0)
+	assert.True(t, result.Success, result.Error)
+	assert.Equal(t, sdk.CryptedFilesystemProvider, result.Provider)
+	assert.Len(t, result.Steps, 4)
+	entries, err = os.ReadDir(mappedPath)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 0)
+
+	err = os.RemoveAll(mappedPath)
+	assert.NoError(t, err)
+	// the backend error is reported verbatim for the failed step
+	result = CheckFolderBackend(folder, true, 0)
+	assert.False(t, result.Success)
+	if assert.Len(t, result.Steps, 2) {
+		assert.Equal(t, BackendCheckStepList, result.Steps[1].Name)
+		assert.Equal(t, result.Error, result.Steps[1].Error)
+		assert.Contains(t, result.Error, mappedPath)
+	}
+	folder = vfs.BaseVirtualFolder{
+		Name: "backend_check_sftp",
+		FsConfig: vfs.Filesystem{
+			Provider: sdk.SFTPFilesystemProvider,
+			SFTPConfig: vfs.SFTPFsConfig{
+				BaseSFTPFsConfig: sdk.BaseSFTPFsConfig{
+					Endpoint: "127.0.0.1:1",
+					Username: "user",
+				},
+				Password: kms.NewPlainSecret("pwd"),
+			},
+		},
+	}
+	err = dataprovider.ValidateFolder(&folder)
+	require.NoError(t, err)
+	result = CheckFolderBackend(folder, false, 0)
+	assert.False(t, result.Success)
+	if assert.Len(t, result.Steps, 1) {
+		assert.Equal(t, BackendCheckStepConnect, result.Steps[0].Name)
+		assert.NotEmpty(t, result.Steps[0].Error)
+	}
+}
+
+func TestCheckFolderBackendTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	folder := vfs.BaseVirtualFolder{
+		Name: "backend_check_http",
+		FsConfig: vfs.Filesystem{
+			Provider: sdk.HTTPFilesystemProvider,
+			HTTPConfig: vfs.HTTPFsConfig{
+				BaseHTTPFsConfig: sdk.BaseHTTPFsConfig{
+					Endpoint: server.URL + "/api/v1",
+					Username: "user",
+				},
+				Password: kms.NewPlainSecret("pwd"),
+			},
+		},
+	}
+	err := dataprovider.ValidateFolder(&folder)
+	require.NoError(t, err)
+	result := CheckFolderBackend(folder, true, 100*time.Millisecond)
+	assert.False(t, result.Success)
+	assert.Equal(t, sdk.HTTPFilesystemProvider, result.Provider)
+	assert.Contains(t, result.Error, errBackendCheckTimeout.Error())
+	assert.Less(t, result.Latency, int64(500))
+	// the timeout is limited
+	result = CheckFolderBackend(folder, false, 2*MaxBackendCheckTimeout)
+	assert.False(t, result.Success)
+	if assert.Len(t, result.Steps, 2) {
+		assert.Equal(t, BackendCheckStepList, result.Steps[1].Name)
+		assert.Equal(t, result.Error, result.Steps[1].Error)
+	}
+}
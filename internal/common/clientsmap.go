@@ -37,7 +37,8 @@ func (c *clientsMap) add(source string) {
 	c.clients[source]++
 }
 
-func (c *clientsMap) remove(source string) {
+// remove removes the specified source and returns true if it was mapped
+func (c *clientsMap) remove(source string) bool {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -45,12 +46,13 @@ func (c *clientsMap) remove(source string) {
 		c.totalConnections.Add(-1)
 		c.clients[source]--
 		if val > 1 {
-			return
+			return true
 		}
 		delete(c.clients, source)
-	} else {
-		logger.Warn(logSender, "", "cannot remove client %v it is not mapped", source)
+		return true
 	}
+	logger.Warn(logSender, "", "cannot remove client %v it is not mapped", source)
+	return false
 }
 
 func (c *clientsMap) getTotal() int32 {
@@ -44,12 +44,12 @@ func TestClientsMap(t *testing.T) {
 	assert.Equal(t, 4, m.getTotalFrom(ip1))
 	assert.Equal(t, 2, m.getTotalFrom(ip2))
 
-	m.remove(ip2)
+	assert.True(t, m.remove(ip2))
 	assert.Equal(t, int32(5), m.getTotal())
 	assert.Equal(t, 4, m.getTotalFrom(ip1))
 	assert.Equal(t, 1, m.getTotalFrom(ip2))
 
-	m.remove("unknown")
+	assert.False(t, m.remove("unknown"))
 	assert.Equal(t, int32(5), m.getTotal())
 	assert.Equal(t, 4, m.getTotalFrom(ip1))
 	assert.Equal(t, 1, m.getTotalFrom(ip2))
@@ -16,7 +16,9 @@
 package common
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -41,6 +43,7 @@ import (
 	"github.com/drakkan/sftpgo/v2/internal/httpclient"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/metric"
+	"github.com/drakkan/sftpgo/v2/internal/mfa"
 	"github.com/drakkan/sftpgo/v2/internal/plugin"
 	"github.com/drakkan/sftpgo/v2/internal/smtp"
 	"github.com/drakkan/sftpgo/v2/internal/util"
@@ -69,11 +72,16 @@ const (
 	operationFirstUpload   = "first-upload"
 	operationDelete        = "delete"
 	operationCopy          = "copy"
+	operationExtract       = "extract"
+	// transfer quota window events
+	operationTransferQuotaWarning   = "transfer-quota-warning"
+	operationTransferQuotaExhausted = "transfer-quota-exhausted"
 	// Pre-download action name
 	OperationPreDownload = "pre-download"
 	// Pre-upload action name
 	OperationPreUpload = "pre-upload"
 	operationPreDelete = "pre-delete"
+	operationPreRename = "pre-rename"
 	operationRename    = "rename"
 	operationMkdir     = "mkdir"
 	operationRmdir     = "rmdir"
@@ -110,6 +118,9 @@ const (
 	ProtocolDataRetention = "DataRetention"
 	ProtocolOIDC          = "OIDC"
 	protocolEventAction   = "EventAction"
+	// used for the automatic extraction of the uploaded archives, the events
+	// are not ignored by the event rules as for protocolEventAction
+	protocolArchiveExtraction = "ArchiveExtraction"
 )
 
 // Upload modes
@@ -120,6 +131,7 @@ const (
 	UploadModeS3StoreOnError        = 4
 	UploadModeGCSStoreOnError       = 8
 	UploadModeAzureBlobStoreOnError = 16
+	UploadModeCloudAtomic           = 32
 )
 
 func init() {
@@ -132,6 +144,7 @@ func init() {
 	Connections.perUserConns = make(map[string]int)
 	Connections.mapping = make(map[string]int)
 	Connections.sshMapping = make(map[string]int)
+	dataprovider.SetRefreshFilesystemsCallback(Connections.RefreshFilesystems)
 }
 
 // errors definitions
@@ -149,6 +162,7 @@ var (
 	ErrInternalFailure   = errors.New("internal failure")
 	ErrTransferAborted   = errors.New("transfer aborted")
 	ErrShuttingDown      = errors.New("the service is shutting down")
+	ErrAbortedByClient   = errors.New("operation aborted, the client disconnected")
 	errNoTransfer        = errors.New("requested transfer not found")
 	errTransferMismatch  = errors.New("transfer mismatch")
 )
@@ -190,6 +204,7 @@ func Initialize(c Configuration, isShared int) error {
 	Config.idleTimeoutAsDuration = time.Duration(Config.IdleTimeout) * time.Minute
 	startPeriodicChecks(periodicTimeoutCheckInterval, isShared)
 	Config.defender = nil
+	Config.loginFailures = nil
 	Config.allowList = nil
 	Config.rateLimitersList = nil
 	rateLimiters = make(map[string][]*rateLimiter)
@@ -229,6 +244,9 @@ func Initialize(c Configuration, isShared int) error {
 		logger.Info(logSender, "", "defender initialized with config %+v", c.DefenderConfig)
 		Config.defender = defender
 	}
+	if err := c.LoginFailures.initialize(); err != nil {
+		return fmt.Errorf("login failures initialization error: %w", err)
+	}
 	if c.AllowListStatus > 0 {
 		allowList, err := dataprovider.NewIPList(dataprovider.IPListTypeAllowList)
 		if err != nil {
@@ -243,13 +261,65 @@ func Initialize(c Configuration, isShared int) error {
 	if err := c.EventManager.validate(); err != nil {
 		return err
 	}
+	if err := c.AuditLog.initialize(); err != nil {
+		return fmt.Errorf("audit log initialization error: %w", err)
+	}
+	if err := c.EventStream.initialize(); err != nil {
+		return fmt.Errorf("event stream initialization error: %w", err)
+	}
+	if err := c.Tracing.initialize(); err != nil {
+		return fmt.Errorf("tracing initialization error: %w", err)
+	}
+	if err := c.FolderRetention.validate(); err != nil {
+		return fmt.Errorf("folder retention configuration error: %w", err)
+	}
+	scheduleFoldersRetention()
+	if err := c.QuotaReconciliation.validate(); err != nil {
+		return fmt.Errorf("quota reconciliation configuration error: %w", err)
+	}
+	scheduleQuotaReconciliation()
+	if err := c.UploadScan.validate(); err != nil {
+		return err
+	}
+	if err := c.UploadContentFilter.validate(); err != nil {
+		return err
+	}
+	if err := c.UploadChecksum.validate(); err != nil {
+		return err
+	}
+	if err := vfs.SetS3TransferDefaults(vfs.S3TransferDefaults(c.S3Transfers)); err != nil {
+		return fmt.Errorf("invalid S3 transfers configuration: %w", err)
+	}
+	if err := vfs.SetBufferPoolConfig(vfs.BufferPoolConfig(c.BufferPool)); err != nil {
+		return err
+	}
+	if err := c.MultipartCleanup.validate(); err != nil {
+		return err
+	}
+	scheduleMultipartCleanup()
+	if err := vfs.SetListingConfig(vfs.ListingConfig(c.Listing)); err != nil {
+		return fmt.Errorf("invalid listing configuration: %w", err)
+	}
+	if err := vfs.SetOutboundProxy(vfs.OutboundProxyConfig(c.OutboundProxy)); err != nil {
+		return err
+	}
+	if err := vfs.SetOutboundBindAddress(c.OutboundBindAddress); err != nil {
+		return err
+	}
+	if err := c.ReEncryption.validate(); err != nil {
+		return fmt.Errorf("re-encryption configuration error: %w", err)
+	}
 	vfs.SetTempPath(c.TempPath)
 	dataprovider.SetTempPath(c.TempPath)
 	vfs.SetAllowSelfConnections(c.AllowSelfConnections)
 	vfs.SetRenameMode(c.RenameMode)
 	vfs.SetReadMetadataMode(c.Metadata.Read)
+	vfs.SetContentTypeDetection(c.ContentType.Enabled, c.ContentType.Sniff)
 	vfs.SetResumeMaxSize(c.ResumeMaxSize)
 	vfs.SetUploadMode(c.UploadMode)
+	if err := vfs.SetAtomicUploadPrefix(c.AtomicUploadPrefix); err != nil {
+		return err
+	}
 	dataprovider.SetAllowSelfConnections(c.AllowSelfConnections)
 	dataprovider.EnabledActionCommands = c.EventManager.EnabledCommands
 	transfersChecker = getTransfersChecker(isShared)
@@ -418,6 +488,18 @@ func AddDefenderEvent(ip, protocol string, event HostEvent) bool {
 	return Config.defender.AddEvent(ip, protocol, event)
 }
 
+// GetDefenderEventForLoginError returns the defender event to add for a failed login
+func GetDefenderEventForLoginError(err error) HostEvent {
+	if errors.Is(err, util.ErrNotFound) {
+		return HostEventUserNotFound
+	}
+	lockout := mfa.GetLockoutConfig()
+	if lockout.GenerateDefenderEvents && errors.Is(err, dataprovider.ErrTooManySecondFactorAttempts) {
+		return HostEventLimitExceeded
+	}
+	return HostEventLoginFailed
+}
+
 func reloadProviderConfigs() {
 	configs, err := dataprovider.GetConfigs()
 	if err != nil {
@@ -441,6 +523,8 @@ func startPeriodicChecks(duration time.Duration, isShared int) {
 	_, err := eventScheduler.AddFunc(spec, Connections.checkTransfers)
 	util.PanicOnError(err)
 	logger.Info(logSender, "", "scheduled overquota transfers check, schedule %q", spec)
+	_, err = eventScheduler.AddFunc(spec, checkLoginFailures)
+	util.PanicOnError(err)
 	if isShared == 1 {
 		logger.Info(logSender, "", "add reload configs task")
 		_, err := eventScheduler.AddFunc("@every 10m", reloadProviderConfigs)
@@ -470,6 +554,7 @@ type ActiveTransfer interface {
 	SetTimes(fsPath string, atime time.Time, mtime time.Time) bool
 	GetTruncatedSize() int64
 	HasSizeLimit() bool
+	GetExpectedSize() int64
 }
 
 // ActiveConnection defines the interface for the current active connections
@@ -485,6 +570,7 @@ type ActiveConnection interface {
 	GetConnectionTime() time.Time
 	GetLastActivity() time.Time
 	GetCommand() string
+	GetRecordingPath() string
 	Disconnect() error
 	AddTransfer(t ActiveTransfer)
 	RemoveTransfer(t ActiveTransfer)
@@ -492,6 +578,19 @@ type ActiveConnection interface {
 	SignalTransferClose(transferID int64, err error)
 	CloseFS() error
 	isAccessAllowed() bool
+	refreshFilesystems(user *dataprovider.User)
+	sampleTransfers(now time.Time)
+}
+
+// openHandlesCounter is implemented by the connections tracking their open
+// files and directories
+type openHandlesCounter interface {
+	GetOpenHandles() int
+}
+
+// userGetter is implemented by the connections with an associated user
+type userGetter interface {
+	getUser() *dataprovider.User
 }
 
 // StatAttributes defines the attributes for set stat commands
@@ -515,6 +614,14 @@ type ConnectionTransfer struct {
 	HasSizeLimit  bool   `json:"-"`
 	ULSize        int64  `json:"-"`
 	DLSize        int64  `json:"-"`
+	// expected size for the transfer, -1 if unknown
+	ExpectedSize int64 `json:"expected_size"`
+	// completion percentage, -1 if the expected size is unknown
+	Percentage float64 `json:"percentage"`
+	// current throughput as bytes per second
+	Throughput int64 `json:"throughput"`
+	// estimated time to completion as seconds, -1 if unknown
+	ETA int64 `json:"eta"`
 }
 
 // EventManagerConfig defines the configuration for the EventManager
@@ -541,6 +648,76 @@ type MetadataConfig struct {
 	Read int `json:"read" mapstructure:"read"`
 }
 
+// ContentTypeConfig defines the content type detection for the files uploaded
+// to the cloud storage backends. If disabled the content type is only inferred
+// from the file extension
+type ContentTypeConfig struct {
+	// If enabled, the content type overrides defined within the filesystem
+	// configurations are applied
+	Enabled bool `json:"enabled" mapstructure:"enabled"`
+	// If enabled, the first 512 bytes of the uploaded files are inspected if the
+	// content type cannot be inferred from the file extension
+	Sniff bool `json:"sniff" mapstructure:"sniff"`
+}
+
+// S3TransfersConfig defines the default multipart settings for the S3
+// filesystems. The settings defined within the filesystem configurations take
+// precedence. Each upload buffers up to (upload_concurrency + 1) parts in memory
+type S3TransfersConfig struct {
+	// Upload part size in MB, 0 means the SDK default (5MB)
+	UploadPartSize int64 `json:"upload_part_size" mapstructure:"upload_part_size"`
+	// Number of parts uploaded in parallel, 0 means the SDK default (5)
+	UploadConcurrency int `json:"upload_concurrency" mapstructure:"upload_concurrency"`
+	// Download part size in MB, 0 means the SDK default (5MB)
+	DownloadPartSize int64 `json:"download_part_size" mapstructure:"download_part_size"`
+	// Number of parts downloaded in parallel, 0 means the SDK default (5)
+	DownloadConcurrency int `json:"download_concurrency" mapstructure:"download_concurrency"`
+}
+
+// BufferPoolConfig defines the pool for the buffers used by the transfers.
+// The pool is shared by the SCP and SSH commands transfers and by the S3
+// multipart uploads, so concurrent transfers reuse the buffers and the memory
+// used for them is bounded
+type BufferPoolConfig struct {
+	// Maximum size, in MB, for the pooled buffers. Transfers wait for a free
+	// buffer if the pool is exhausted. 0 means disabled, buffers are allocated
+	// for each transfer
+	MaxSize int64 `json:"max_size" mapstructure:"max_size"`
+	// Maximum time, in seconds, to wait for a free buffer. After this time the
+	// buffer is allocated outside the pool. 0 means 10 seconds
+	MaxWait int `json:"max_wait" mapstructure:"max_wait"`
+}
+
+// ListingConfig defines the directory listing settings
+type ListingConfig struct {
+	// Number of entries requested for each listing page, 0 means the default (5000).
+	// The memory used by each listing is bounded by the page size
+	PageSize int `json:"page_size" mapstructure:"page_size"`
+	// Maximum number of entries returned when listing a directory. 0 means no limit.
+	// It can be overridden for each user and virtual folder filesystem
+	MaxEntries int `json:"max_entries" mapstructure:"max_entries"`
+	// Action to take if a listing exceeds the maximum allowed entries:
+	// 0 means the listing fails, 1 means the listing is truncated and a
+	// "...truncated" entry is added
+	MaxEntriesMode int `json:"max_entries_mode" mapstructure:"max_entries_mode"`
+	// Time to live, as seconds, for the S3 listing cache. The entries listed
+	// within a connection are used to answer the following stat requests
+	// for the same files. 0 means disabled
+	CacheTTL int `json:"cache_ttl" mapstructure:"cache_ttl"`
+}
+
+// OutboundProxyConfig defines the default proxy for the connections to the
+// SFTP and HTTP filesystems backends
+type OutboundProxyConfig struct {
+	// Proxy URL. Supported schemes: socks5, socks5h, http, https.
+	// For socks5h the host names are resolved by the proxy. Empty means no proxy.
+	// It can be overridden for each filesystem, "direct" disables the proxy
+	URL string `json:"url" mapstructure:"url"`
+	// Username and password for the proxy authentication, if required
+	Username string `json:"username" mapstructure:"username"`
+	Password string `json:"password" mapstructure:"password"`
+}
+
 // Configuration defines configuration parameters common to all supported protocols
 type Configuration struct {
 	// Maximum idle timeout as minutes. If a client is idle for a time that exceeds this setting it will be disconnected.
@@ -558,7 +735,15 @@ type Configuration struct {
 	// 4 means files for S3 backend are stored even if a client-side upload error is detected.
 	// 8 means files for Google Cloud Storage backend are stored even if a client-side upload error is detected.
 	// 16 means files for Azure Blob backend are stored even if a client-side upload error is detected.
+	// 32 means atomic uploads for S3 and Google Cloud Storage backends: the files are uploaded to a
+	// temporary object, inside AtomicUploadPrefix, and moved to the requested path, using a server-side
+	// copy, after the upload scan hook, if any. If there is an upload error, or the file is rejected,
+	// the temporary object is deleted. Resuming uploads is not supported in this mode.
 	UploadMode int `json:"upload_mode" mapstructure:"upload_mode"`
+	// AtomicUploadPrefix defines the prefix, relative to the key prefix of each filesystem, for the
+	// temporary objects of the atomic uploads to S3 and Google Cloud Storage. This prefix is hidden
+	// from the directory listings and is not accessible by clients. Leave empty for the default ".sftpgo-tmp"
+	AtomicUploadPrefix string `json:"atomic_upload_prefix" mapstructure:"atomic_upload_prefix"`
 	// Actions to execute for SFTP file operations and SSH commands
 	Actions ProtocolActions `json:"actions" mapstructure:"actions"`
 	// SetstatMode 0 means "normal mode": requests for changing permissions and owner/group are executed.
@@ -566,6 +751,7 @@ type Configuration struct {
 	// 2 means "ignore mode for cloud fs": requests for changing permissions and owner/group are
 	// silently ignored for cloud based filesystem such as S3, GCS, Azure Blob. Requests  for changing
 	// modification times are ignored for cloud based filesystem if they are not supported.
+	// Virtual folders configured to record the attributes as object metadata ignore this setting.
 	SetstatMode int `json:"setstat_mode" mapstructure:"setstat_mode"`
 	// RenameMode defines how to handle directory renames. By default, renaming of non-empty directories
 	// is not allowed for cloud storage providers (S3, GCS, Azure Blob). Set to 1 to enable recursive
@@ -612,6 +798,10 @@ type Configuration struct {
 	// and before he tries to login. It allows you to reject the connection based on the source
 	// ip address. Leave empty do disable.
 	PostConnectHook string `json:"post_connect_hook" mapstructure:"post_connect_hook"`
+	// If enabled, the post connect hook is notified, in the background, after the SSH handshake
+	// too. The notification includes the client version and the negotiated algorithms and its
+	// result is ignored.
+	PostConnectHookNotifyHandshake bool `json:"post_connect_hook_notify_handshake" mapstructure:"post_connect_hook_notify_handshake"`
 	// Absolute path to an external program or an HTTP URL to invoke after an SSH/FTP connection ends.
 	// Leave empty do disable.
 	PostDisconnectHook string `json:"post_disconnect_hook" mapstructure:"post_disconnect_hook"`
@@ -632,6 +822,8 @@ type Configuration struct {
 	AllowSelfConnections int `json:"allow_self_connections" mapstructure:"allow_self_connections"`
 	// Defender configuration
 	DefenderConfig DefenderConfig `json:"defender" mapstructure:"defender"`
+	// Login failures tracking per username
+	LoginFailures LoginFailuresConfig `json:"login_failures" mapstructure:"login_failures"`
 	// Rate limiter configurations
 	RateLimitersConfig []RateLimiterConfig `json:"rate_limiters" mapstructure:"rate_limiters"`
 	// Umask for new uploads. Leave blank to use the system default.
@@ -644,11 +836,46 @@ type Configuration struct {
 	TZ string `json:"tz" mapstructure:"tz"`
 	// Metadata configuration
 	Metadata MetadataConfig `json:"metadata" mapstructure:"metadata"`
+	// Content type detection for uploads to cloud storage backends
+	ContentType ContentTypeConfig `json:"content_type" mapstructure:"content_type"`
 	// EventManager configuration
-	EventManager          EventManagerConfig `json:"event_manager" mapstructure:"event_manager"`
+	EventManager EventManagerConfig `json:"event_manager" mapstructure:"event_manager"`
+	// Audit log configuration
+	AuditLog AuditLogConfig `json:"audit_log" mapstructure:"audit_log"`
+	// Event stream configuration
+	EventStream EventStreamConfig `json:"event_stream" mapstructure:"event_stream"`
+	// OpenTelemetry tracing configuration
+	Tracing TracingConfig `json:"tracing" mapstructure:"tracing"`
+	// Automatic retention for the virtual folders
+	FolderRetention FolderRetentionConfig `json:"folder_retention" mapstructure:"folder_retention"`
+	// Scheduled quota scans for the virtual folders
+	QuotaReconciliation QuotaReconciliationConfig `json:"quota_reconciliation" mapstructure:"quota_reconciliation"`
+	// Hook to scan the uploaded files before accepting them
+	UploadScan UploadScanConfig `json:"upload_scan" mapstructure:"upload_scan"`
+	// Content inspection for the initial bytes of the uploaded files
+	UploadContentFilter UploadContentFilterConfig `json:"upload_content_filter" mapstructure:"upload_content_filter"`
+	// SHA-256 checksums for the completed uploads
+	UploadChecksum UploadChecksumConfig `json:"upload_checksum" mapstructure:"upload_checksum"`
+	// Default multipart settings for the S3 filesystems
+	S3Transfers S3TransfersConfig `json:"s3_transfers" mapstructure:"s3_transfers"`
+	// Shared pool for the transfer buffers
+	BufferPool BufferPoolConfig `json:"buffer_pool" mapstructure:"buffer_pool"`
+	// Periodic cleanup of the incomplete S3 multipart uploads
+	MultipartCleanup MultipartCleanupConfig `json:"multipart_cleanup" mapstructure:"multipart_cleanup"`
+	// Directory listing settings
+	Listing ListingConfig `json:"listing" mapstructure:"listing"`
+	// Default proxy for the SFTP and HTTP filesystems
+	OutboundProxy OutboundProxyConfig `json:"outbound_proxy" mapstructure:"outbound_proxy"`
+	// Re-encryption of the encrypted filesystems after a passphrase rotation
+	ReEncryption ReEncryptionConfig `json:"reencryption" mapstructure:"reencryption"`
+	// Default local IP address for the connections to the S3, GCS, Azure Blob,
+	// SFTP and HTTP storage backends. It can be overridden for each filesystem.
+	// Empty means the address is chosen by the operating system
+	OutboundBindAddress   string `json:"outbound_bind_address" mapstructure:"outbound_bind_address"`
 	idleTimeoutAsDuration time.Duration
 	idleLoginTimeout      time.Duration
 	defender              Defender
+	loginFailures         *loginFailuresTracker
 	allowList             *dataprovider.IPList
 	rateLimitersList      *dataprovider.IPList
 	proxyAllowed          []func(net.IP) bool
@@ -660,8 +887,21 @@ func (c *Configuration) IsAtomicUploadEnabled() bool {
 	return c.UploadMode&UploadModeAtomic != 0 || c.UploadMode&UploadModeAtomicWithResume != 0
 }
 
+// IsAtomicUploadEnabledFor returns true if the uploads to the specified
+// filesystem must be written to a temporary path and moved to the
+// requested one when completed
+func (c *Configuration) IsAtomicUploadEnabledFor(fs vfs.Fs) bool {
+	if vfs.HasCloudAtomicUploads(fs) {
+		return true
+	}
+	return c.IsAtomicUploadEnabled() && fs.IsAtomicUploadSupported()
+}
+
+// initializeProxyProtocol parses the allowed and skipped IP addresses and ranges.
+// They are parsed even if the global proxy protocol is disabled, the proxy
+// protocol can be enabled for specific bindings
 func (c *Configuration) initializeProxyProtocol() error {
-	if c.ProxyProtocol > 0 {
+	if c.ProxyProtocol > 0 || len(c.ProxyAllowed) > 0 || len(c.ProxySkipped) > 0 {
 		allowed, err := util.ParseAllowedIPAndRanges(c.ProxyAllowed)
 		if err != nil {
 			return fmt.Errorf("invalid proxy allowed: %w", err)
@@ -679,9 +919,16 @@ func (c *Configuration) initializeProxyProtocol() error {
 // GetProxyListener returns a wrapper for the given listener that supports the
 // HAProxy Proxy Protocol
 func (c *Configuration) GetProxyListener(listener net.Listener) (net.Listener, error) {
-	if c.ProxyProtocol > 0 {
+	return c.GetProxyListenerWithPolicy(listener, c.ProxyProtocol)
+}
+
+// GetProxyListenerWithPolicy returns a wrapper for the given listener that supports
+// the HAProxy Proxy Protocol using the specified policy instead of the global one.
+// 1 means proxy header optional, 2 means proxy header required
+func (c *Configuration) GetProxyListenerWithPolicy(listener net.Listener, proxyProtocol int) (net.Listener, error) {
+	if proxyProtocol > 0 {
 		defaultPolicy := proxyproto.REQUIRE
-		if c.ProxyProtocol == 1 {
+		if proxyProtocol == 1 {
 			defaultPolicy = proxyproto.IGNORE
 		}
 
@@ -694,6 +941,19 @@ func (c *Configuration) GetProxyListener(listener net.Listener) (net.Listener, e
 	return nil, errors.New("proxy protocol not configured")
 }
 
+// IsProxyHeaderMissing returns true if the proxy header is required for the
+// given connection and the client did not send it. The header is read, if not
+// already done, so this method can block up to the proxy header read timeout
+func IsProxyHeaderMissing(conn net.Conn) bool {
+	proxyConn, ok := conn.(*proxyproto.Conn)
+	if !ok {
+		return false
+	}
+	// a zero length read returns the error, if any, reading the proxy header
+	_, err := proxyConn.Read(nil)
+	return errors.Is(err, proxyproto.ErrNoProxyProtocol)
+}
+
 // GetRateLimitersStatus returns the rate limiters status
 func (c *Configuration) GetRateLimitersStatus() (bool, []string) {
 	enabled := false
@@ -751,7 +1011,9 @@ func (c *Configuration) ExecuteStartupHook() error {
 	return nil
 }
 
-func (c *Configuration) executePostDisconnectHook(remoteAddr, protocol, username, connID string, connectionTime time.Time) {
+func (c *Configuration) executePostDisconnectHook(remoteAddr, protocol, username, connID, recordingPath string,
+	connectionTime time.Time,
+) {
 	startNewHook()
 	defer hookEnded()
 
@@ -770,6 +1032,9 @@ func (c *Configuration) executePostDisconnectHook(remoteAddr, protocol, username
 		q.Add("protocol", protocol)
 		q.Add("username", username)
 		q.Add("connection_duration", strconv.FormatInt(connDuration, 10))
+		if recordingPath != "" {
+			q.Add("recording", recordingPath)
+		}
 		url.RawQuery = q.Encode()
 		startTime := time.Now()
 		resp, err := httpclient.RetryableGet(url.String())
@@ -796,68 +1061,153 @@ func (c *Configuration) executePostDisconnectHook(remoteAddr, protocol, username
 		fmt.Sprintf("SFTPGO_CONNECTION_IP=%s", ipAddr),
 		fmt.Sprintf("SFTPGO_CONNECTION_USERNAME=%s", username),
 		fmt.Sprintf("SFTPGO_CONNECTION_DURATION=%d", connDuration),
-		fmt.Sprintf("SFTPGO_CONNECTION_PROTOCOL=%s", protocol))
+		fmt.Sprintf("SFTPGO_CONNECTION_PROTOCOL=%s", protocol),
+		fmt.Sprintf("SFTPGO_CONNECTION_RECORDING=%s", recordingPath))
 	err := cmd.Run()
 	logger.Debug(protocol, connID, "Post disconnect hook executed, elapsed: %s error: %v", time.Since(startTime), err)
 }
 
-func (c *Configuration) checkPostDisconnectHook(remoteAddr, protocol, username, connID string, connectionTime time.Time) {
+func (c *Configuration) checkPostDisconnectHook(remoteAddr, protocol, username, connID, recordingPath string,
+	connectionTime time.Time,
+) {
 	if c.PostDisconnectHook == "" {
 		return
 	}
 	if !slices.Contains(disconnHookProtocols, protocol) {
 		return
 	}
-	go c.executePostDisconnectHook(remoteAddr, protocol, username, connID, connectionTime)
+	go c.executePostDisconnectHook(remoteAddr, protocol, username, connID, recordingPath, connectionTime)
+}
+
+// Post connect hook events
+const (
+	// PostConnectEventConnect is the event for the hook executed when a new
+	// connection is accepted, the connection is rejected if the hook fails
+	PostConnectEventConnect = "connect"
+	// PostConnectEventHandshake is the event for the notification sent after
+	// the SSH handshake, the hook result is ignored
+	PostConnectEventHandshake = "handshake"
+)
+
+// PostConnectInfo defines the connection details sent to the post connect hook
+type PostConnectInfo struct {
+	Event    string `json:"event"`
+	IP       string `json:"ip"`
+	Protocol string `json:"protocol"`
+	// LocalAddress is the address, including the port, the client connected to
+	LocalAddress string `json:"local_address,omitempty"`
+	// the following fields are set for the SSH handshake notification only
+	ClientVersion string `json:"client_version,omitempty"`
+	KeyExchange   string `json:"kex_algo,omitempty"`
+	Cipher        string `json:"cipher,omitempty"`
+	MAC           string `json:"mac,omitempty"`
+	HostKeyAlgo   string `json:"host_key_algo,omitempty"`
+}
+
+func (i *PostConnectInfo) getEnv() []string {
+	return []string{
+		fmt.Sprintf("SFTPGO_CONNECTION_IP=%s", i.IP),
+		fmt.Sprintf("SFTPGO_CONNECTION_PROTOCOL=%s", i.Protocol),
+		fmt.Sprintf("SFTPGO_CONNECTION_EVENT=%s", i.Event),
+		fmt.Sprintf("SFTPGO_CONNECTION_LOCAL_ADDRESS=%s", i.LocalAddress),
+		fmt.Sprintf("SFTPGO_CONNECTION_CLIENT_VERSION=%s", i.ClientVersion),
+		fmt.Sprintf("SFTPGO_CONNECTION_KEX_ALGO=%s", i.KeyExchange),
+		fmt.Sprintf("SFTPGO_CONNECTION_CIPHER=%s", i.Cipher),
+		fmt.Sprintf("SFTPGO_CONNECTION_MAC=%s", i.MAC),
+		fmt.Sprintf("SFTPGO_CONNECTION_HOST_KEY_ALGO=%s", i.HostKeyAlgo),
+	}
 }
 
 // ExecutePostConnectHook executes the post connect hook if defined
 func (c *Configuration) ExecutePostConnectHook(ipAddr, protocol string) error {
+	return c.ExecutePostConnectHookWithInfo(&PostConnectInfo{
+		IP:       ipAddr,
+		Protocol: protocol,
+	})
+}
+
+// ExecutePostConnectHookWithInfo executes the post connect hook, if defined,
+// for a new connection with the specified details
+func (c *Configuration) ExecutePostConnectHookWithInfo(info *PostConnectInfo) error {
 	if c.PostConnectHook == "" {
 		return nil
 	}
+	info.Event = PostConnectEventConnect
+	if err := c.executePostConnectHook(info); err != nil {
+		logger.Warn(info.Protocol, "", "Login from ip %q denied, %v", info.IP, err)
+		return getPermissionDeniedError(info.Protocol)
+	}
+	return nil
+}
+
+// NotifyPostConnectHandshake notifies the post connect hook, if defined and if
+// the handshake notifications are enabled, about a completed SSH handshake.
+// The hook is executed in the background and its result is ignored
+func (c *Configuration) NotifyPostConnectHandshake(info *PostConnectInfo, connectionID string) {
+	if c.PostConnectHook == "" || !c.PostConnectHookNotifyHandshake {
+		return
+	}
+	info.Event = PostConnectEventHandshake
+	go func() {
+		startNewHook()
+		defer hookEnded()
+
+		startTime := time.Now()
+		err := c.executePostConnectHook(info)
+		logger.Debug(info.Protocol, connectionID, "Post connect hook handshake notification executed, elapsed: %s, "+
+			"error: %v", time.Since(startTime), err)
+	}()
+}
+
+// executePostConnectHook executes the post connect hook. The HTTP hook receives
+// the IP and protocol as query parameters and all the connection details as
+// JSON body, the external program receives them as environment variables
+func (c *Configuration) executePostConnectHook(info *PostConnectInfo) error {
 	if strings.HasPrefix(c.PostConnectHook, "http") {
 		var url *url.URL
 		url, err := url.Parse(c.PostConnectHook)
 		if err != nil {
-			logger.Warn(protocol, "", "Login from ip %q denied, invalid post connect hook %q: %v",
-				ipAddr, c.PostConnectHook, err)
-			return getPermissionDeniedError(protocol)
+			return fmt.Errorf("invalid post connect hook %q: %w", c.PostConnectHook, err)
 		}
 		q := url.Query()
-		q.Add("ip", ipAddr)
-		q.Add("protocol", protocol)
+		q.Add("ip", info.IP)
+		q.Add("protocol", info.Protocol)
 		url.RawQuery = q.Encode()
 
-		resp, err := httpclient.RetryableGet(url.String())
+		body, err := json.Marshal(info)
 		if err != nil {
-			logger.Warn(protocol, "", "Login from ip %q denied, error executing post connect hook: %v", ipAddr, err)
-			return getPermissionDeniedError(protocol)
+			return err
+		}
+		resp, err := httpclient.RetryableGetWithBodyForHook(command.HookPostConnect, url.String(),
+			"application/json", bytes.NewReader(body))
+		if err != nil {
+			if util.IsTimeoutError(err) {
+				metric.AddHookTimeout(command.HookPostConnect)
+			}
+			return fmt.Errorf("error executing post connect hook: %w", err)
 		}
 		defer resp.Body.Close()
 		if resp.StatusCode != http.StatusOK {
-			logger.Warn(protocol, "", "Login from ip %q denied, post connect hook response code: %v", ipAddr, resp.StatusCode)
-			return getPermissionDeniedError(protocol)
+			return fmt.Errorf("post connect hook response code: %v", resp.StatusCode)
 		}
 		return nil
 	}
 	if !filepath.IsAbs(c.PostConnectHook) {
-		err := fmt.Errorf("invalid post connect hook %q", c.PostConnectHook)
-		logger.Warn(protocol, "", "Login from ip %q denied: %v", ipAddr, err)
-		return getPermissionDeniedError(protocol)
+		return fmt.Errorf("invalid post connect hook %q", c.PostConnectHook)
 	}
 	timeout, env, args := command.GetConfig(c.PostConnectHook, command.HookPostConnect)
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
 	cmd := exec.CommandContext(ctx, c.PostConnectHook, args...)
-	cmd.Env = append(env,
-		fmt.Sprintf("SFTPGO_CONNECTION_IP=%s", ipAddr),
-		fmt.Sprintf("SFTPGO_CONNECTION_PROTOCOL=%s", protocol))
+	cmd.WaitDelay = command.WaitDelay
+	cmd.Env = append(env, info.getEnv()...)
 	err := cmd.Run()
 	if err != nil {
-		logger.Warn(protocol, "", "Login from ip %q denied, connect hook error: %v", ipAddr, err)
-		return getPermissionDeniedError(protocol)
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			metric.AddHookTimeout(command.HookPostConnect)
+		}
+		return fmt.Errorf("connect hook error: %w", err)
 	}
 	return nil
 }
@@ -1000,6 +1350,7 @@ func (conns *ActiveConnections) Add(c ActiveConnection) error {
 	conns.mapping[c.GetID()] = len(conns.connections)
 	conns.connections = append(conns.connections, c)
 	metric.UpdateActiveConnectionsSize(len(conns.connections))
+	metric.AddActiveSession(c.GetProtocol())
 	logger.Debug(c.GetProtocol(), c.GetID(), "connection added, local address %q, remote address %q, num open connections: %d",
 		c.GetLocalAddress(), c.GetRemoteAddress(), len(conns.connections))
 	return nil
@@ -1053,27 +1404,71 @@ func (conns *ActiveConnections) Remove(connectionID string) {
 		}
 		conns.removeUserConnection(conn.GetUsername())
 		metric.UpdateActiveConnectionsSize(lastIdx)
+		metric.RemoveActiveSession(conn.GetProtocol())
 		logger.Debug(conn.GetProtocol(), conn.GetID(), "connection removed, local address %q, remote address %q close fs error: %v, num open connections: %d",
 			conn.GetLocalAddress(), conn.GetRemoteAddress(), err, lastIdx)
 		if conn.GetProtocol() == ProtocolFTP && conn.GetUsername() == "" && !slices.Contains(ftpLoginCommands, conn.GetCommand()) {
 			ip := util.GetIPFromRemoteAddress(conn.GetRemoteAddress())
-			logger.ConnectionFailedLog("", ip, dataprovider.LoginMethodNoAuthTried, ProtocolFTP,
+			logger.ConnectionFailedLog("", ip, dataprovider.LoginMethodNoAuthTried, ProtocolFTP, conn.GetID(),
 				dataprovider.ErrNoAuthTried.Error())
 			metric.AddNoAuthTried()
 			AddDefenderEvent(ip, ProtocolFTP, HostEventNoLoginTried)
 			dataprovider.ExecutePostLoginHook(&dataprovider.User{}, dataprovider.LoginMethodNoAuthTried, ip,
 				ProtocolFTP, dataprovider.ErrNoAuthTried)
+			dataprovider.AddLoginEvent("", ip, ProtocolFTP, dataprovider.LoginMethodNoAuthTried, "",
+				dataprovider.ErrNoAuthTried)
 			plugin.Handler.NotifyLogEvent(notifier.LogEventTypeNoLoginTried, ProtocolFTP, "", ip, "",
 				dataprovider.ErrNoAuthTried)
 		}
 		Config.checkPostDisconnectHook(conn.GetRemoteAddress(), conn.GetProtocol(), conn.GetUsername(),
-			conn.GetID(), conn.GetConnectionTime())
+			conn.GetID(), conn.GetRecordingPath(), conn.GetConnectionTime())
 		return
 	}
 
 	logger.Debug(logSender, "", "connection id %q to remove not found!", connectionID)
 }
 
+// getUser returns the user associated with the active connection with the specified id
+func (conns *ActiveConnections) getUser(connectionID string) (*dataprovider.User, bool) {
+	conns.RLock()
+	defer conns.RUnlock()
+
+	idx, ok := conns.mapping[connectionID]
+	if !ok {
+		return nil, false
+	}
+	if c, ok := conns.connections[idx].(userGetter); ok {
+		return c.getUser(), true
+	}
+	return nil, false
+}
+
+// RefreshFilesystems replaces the cloud storage filesystems for the active
+// connections of the specified user, so that the updated configuration,
+// for example rotated credentials, is used without reconnecting
+func (conns *ActiveConnections) RefreshFilesystems(username string) {
+	conns.RLock()
+	var connections []ActiveConnection
+	for _, c := range conns.connections {
+		if c.GetUsername() == username {
+			connections = append(connections, c)
+		}
+	}
+	conns.RUnlock()
+
+	if len(connections) == 0 {
+		return
+	}
+	user, err := dataprovider.GetUserWithGroupSettings(username, "")
+	if err != nil {
+		logger.Warn(logSender, "", "unable to refresh the filesystems for user %q: %v", username, err)
+		return
+	}
+	for _, c := range connections {
+		c.refreshFilesystems(&user)
+	}
+}
+
 // Close closes an active connection.
 // It returns true on success
 func (conns *ActiveConnections) Close(connectionID, role string) bool {
@@ -1104,6 +1499,7 @@ func (conns *ActiveConnections) AddSSHConnection(c *SSHConnection) {
 
 	conns.sshMapping[c.GetID()] = len(conns.sshConnections)
 	conns.sshConnections = append(conns.sshConnections, c)
+	metric.UpdateActiveSSHConnectionsSize(len(conns.sshConnections))
 	logger.Debug(logSender, c.GetID(), "ssh connection added, num open connections: %d", len(conns.sshConnections))
 }
 
@@ -1121,6 +1517,7 @@ func (conns *ActiveConnections) RemoveSSHConnection(connectionID string) {
 		if idx != lastIdx {
 			conns.sshMapping[conns.sshConnections[idx].GetID()] = idx
 		}
+		metric.UpdateActiveSSHConnectionsSize(lastIdx)
 		logger.Debug(logSender, connectionID, "ssh connection removed, num open ssh connections: %d", lastIdx)
 		return
 	}
@@ -1186,6 +1583,10 @@ func (conns *ActiveConnections) checkTransfers() {
 
 	conns.RLock()
 
+	now := time.Now()
+	for _, c := range conns.connections {
+		c.sampleTransfers(now)
+	}
 	if len(conns.connections) < 2 {
 		conns.RUnlock()
 		return
@@ -1242,11 +1643,14 @@ func (conns *ActiveConnections) checkTransfers() {
 // AddClientConnection stores a new client connection
 func (conns *ActiveConnections) AddClientConnection(ipAddr string) {
 	conns.clients.add(ipAddr)
+	metric.AddClientConnection()
 }
 
 // RemoveClientConnection removes a disconnected client from the tracked ones
 func (conns *ActiveConnections) RemoveClientConnection(ipAddr string) {
-	conns.clients.remove(ipAddr)
+	if conns.clients.remove(ipAddr) {
+		metric.RemoveClientConnection()
+	}
 }
 
 // GetClientConnections returns the total number of client connections
@@ -1363,6 +1767,9 @@ func (conns *ActiveConnections) GetStats(role string) []ConnectionStatus {
 				Transfers:      c.GetTransfers(),
 				Node:           node,
 			}
+			if counter, ok := c.(openHandlesCounter); ok {
+				stat.OpenHandles = counter.GetOpenHandles()
+			}
 			stats = append(stats, stat)
 		}
 	}
@@ -1391,6 +1798,8 @@ type ConnectionStatus struct {
 	Transfers []ConnectionTransfer `json:"active_transfers,omitempty"`
 	// SSH command or WebDAV method
 	Command string `json:"command,omitempty"`
+	// Number of open files and directories, only reported for SFTP connections
+	OpenHandles int `json:"open_handles,omitempty"`
 	// Node identifier, omitted for single node installations
 	Node string `json:"node,omitempty"`
 }
@@ -18,7 +18,10 @@ import (
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -1081,6 +1084,85 @@ func TestQuotaScansRole(t *testing.T) {
 	assert.Len(t, QuotaScans.GetUsersQuotaScans(""), 0)
 }
 
+func TestConnectionSource(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	getConn := func(header *proxyproto.Header) (net.Conn, net.Conn) {
+		client, err := net.Dial("tcp", listener.Addr().String())
+		require.NoError(t, err)
+		if header != nil {
+			_, err = header.WriteTo(client)
+			require.NoError(t, err)
+		}
+		_, err = client.Write([]byte("data"))
+		require.NoError(t, err)
+		conn, err := listener.Accept()
+		require.NoError(t, err)
+		return client, conn
+	}
+	clientAddr := &net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 4321}
+	proxyHeader := proxyproto.HeaderProxyFromAddrs(2, clientAddr, listener.Addr())
+	// direct connection
+	client, conn := getConn(nil)
+	untrack := TrackConnectionSource(conn)
+	source := GetConnectionSource(conn.RemoteAddr())
+	assert.Equal(t, "127.0.0.1", source.PeerIP)
+	assert.Equal(t, dataprovider.SourceAddrDirect, source.Provenance)
+	untrack()
+	assert.Empty(t, GetConnectionSource(conn.RemoteAddr()).Provenance)
+	client.Close()
+	conn.Close()
+	// header from a trusted proxy
+	client, conn = getConn(proxyHeader)
+	conn = proxyproto.NewConn(conn, proxyproto.WithPolicy(proxyproto.USE))
+	untrack = TrackConnectionSource(conn)
+	source = GetConnectionSource(conn.RemoteAddr())
+	assert.Equal(t, "127.0.0.1", source.PeerIP)
+	assert.Equal(t, dataprovider.SourceAddrProxy, source.Provenance)
+	assert.Equal(t, clientAddr.String(), conn.RemoteAddr().String())
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("data"), buf)
+	untrack()
+	client.Close()
+	conn.Close()
+	// header ignored, not a trusted proxy
+	client, conn = getConn(proxyHeader)
+	conn = proxyproto.NewConn(conn, proxyproto.WithPolicy(proxyproto.IGNORE))
+	untrack = TrackConnectionSource(conn)
+	source = GetConnectionSource(conn.RemoteAddr())
+	assert.Equal(t, "127.0.0.1", source.PeerIP)
+	assert.Equal(t, dataprovider.SourceAddrUntrusted, source.Provenance)
+	assert.NotEqual(t, clientAddr.String(), conn.RemoteAddr().String())
+	untrack()
+	client.Close()
+	conn.Close()
+	// trusted proxy without header
+	client, conn = getConn(nil)
+	conn = proxyproto.NewConn(conn, proxyproto.WithPolicy(proxyproto.USE))
+	untrack = TrackConnectionSource(conn)
+	assert.Equal(t, dataprovider.SourceAddrUntrusted, GetConnectionSource(conn.RemoteAddr()).Provenance)
+	untrack()
+	client.Close()
+	conn.Close()
+	// not tracked
+	source = GetConnectionSource(clientAddr)
+	assert.Empty(t, source.PeerIP)
+	assert.Empty(t, source.Provenance)
+	assert.Empty(t, GetConnectionSource(nil).Provenance)
+
+	u := dataprovider.User{}
+	assert.True(t, u.IsSourceAddrProvenanceAllowed(""))
+	u.Filters.RequireTrustedSourceAddr = true
+	assert.True(t, u.IsSourceAddrProvenanceAllowed(dataprovider.SourceAddrDirect))
+	assert.True(t, u.IsSourceAddrProvenanceAllowed(dataprovider.SourceAddrProxy))
+	assert.False(t, u.IsSourceAddrProvenanceAllowed(dataprovider.SourceAddrUntrusted))
+	assert.False(t, u.IsSourceAddrProvenanceAllowed(""))
+}
+
 func TestProxyPolicy(t *testing.T) {
 	addr := net.TCPAddr{}
 	downstream := net.TCPAddr{IP: net.ParseIP("1.1.1.1")}
@@ -1173,6 +1255,37 @@ func TestProxyProtocolVersion(t *testing.T) {
 	assert.NotNil(t, proxyListener.ConnPolicy)
 }
 
+func TestProxyListenerWithPolicy(t *testing.T) {
+	c := Configuration{
+		ProxyProtocol: 0,
+	}
+	_, err := c.GetProxyListenerWithPolicy(nil, 0)
+	assert.Error(t, err)
+	listener, err := c.GetProxyListenerWithPolicy(nil, 2)
+	assert.NoError(t, err)
+	_, ok := listener.(*proxyproto.Listener)
+	assert.True(t, ok)
+
+	assert.False(t, IsProxyHeaderMissing(nil))
+	for _, policy := range []proxyproto.Policy{proxyproto.USE, proxyproto.REQUIRE} {
+		server, client := net.Pipe()
+		go func() {
+			_, _ = client.Write([]byte("SSH-2.0-client\r\n"))
+		}()
+		conn := proxyproto.NewConn(server, proxyproto.WithPolicy(policy))
+		assert.Equal(t, policy == proxyproto.REQUIRE, IsProxyHeaderMissing(conn))
+		if policy == proxyproto.USE {
+			// the data sent by the client is not consumed
+			buf := make([]byte, 3)
+			_, err = io.ReadFull(conn, buf)
+			assert.NoError(t, err)
+			assert.Equal(t, "SSH", string(buf))
+		}
+		client.Close()
+		server.Close()
+	}
+}
+
 func TestStartupHook(t *testing.T) {
 	Config.StartupHook = ""
 
@@ -1204,29 +1317,29 @@ func TestPostDisconnectHook(t *testing.T) {
 	Config.PostDisconnectHook = "http://127.0.0.1/"
 
 	remoteAddr := "127.0.0.1:80"
-	Config.checkPostDisconnectHook(remoteAddr, ProtocolHTTP, "", "", time.Now())
-	Config.checkPostDisconnectHook(remoteAddr, ProtocolSFTP, "", "", time.Now())
+	Config.checkPostDisconnectHook(remoteAddr, ProtocolHTTP, "", "", "", time.Now())
+	Config.checkPostDisconnectHook(remoteAddr, ProtocolSFTP, "", "", "", time.Now())
 
 	Config.PostDisconnectHook = "http://bar\x7f.com/"
-	Config.executePostDisconnectHook(remoteAddr, ProtocolSFTP, "", "", time.Now())
+	Config.executePostDisconnectHook(remoteAddr, ProtocolSFTP, "", "", "", time.Now())
 
 	Config.PostDisconnectHook = fmt.Sprintf("http://%v", httpAddr)
-	Config.executePostDisconnectHook(remoteAddr, ProtocolSFTP, "", "", time.Now())
+	Config.executePostDisconnectHook(remoteAddr, ProtocolSFTP, "", "", "", time.Now())
 
 	Config.PostDisconnectHook = "relativePath"
-	Config.executePostDisconnectHook(remoteAddr, ProtocolSFTP, "", "", time.Now())
+	Config.executePostDisconnectHook(remoteAddr, ProtocolSFTP, "", "", "", time.Now())
 
 	if runtime.GOOS == osWindows {
 		Config.PostDisconnectHook = "C:\\a\\bad\\command"
-		Config.executePostDisconnectHook(remoteAddr, ProtocolSFTP, "", "", time.Now())
+		Config.executePostDisconnectHook(remoteAddr, ProtocolSFTP, "", "", "", time.Now())
 	} else {
 		Config.PostDisconnectHook = "/invalid/path"
-		Config.executePostDisconnectHook(remoteAddr, ProtocolSFTP, "", "", time.Now())
+		Config.executePostDisconnectHook(remoteAddr, ProtocolSFTP, "", "", "", time.Now())
 
 		hookCmd, err := exec.LookPath("true")
 		assert.NoError(t, err)
 		Config.PostDisconnectHook = hookCmd
-		Config.executePostDisconnectHook(remoteAddr, ProtocolSFTP, "", "", time.Now())
+		Config.executePostDisconnectHook(remoteAddr, ProtocolSFTP, "", "", "", time.Now())
 	}
 	Config.PostDisconnectHook = ""
 }
@@ -1269,6 +1382,76 @@ func TestPostConnectHook(t *testing.T) {
 	Config.PostConnectHook = ""
 }
 
+func TestPostConnectHookInfo(t *testing.T) {
+	infoCh := make(chan PostConnectInfo, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var info PostConnectInfo
+		err := json.NewDecoder(r.Body).Decode(&info)
+		assert.NoError(t, err)
+		assert.Equal(t, info.IP, r.URL.Query().Get("ip"))
+		assert.Equal(t, info.Protocol, r.URL.Query().Get("protocol"))
+		infoCh <- info
+		if info.LocalAddress == "127.0.0.1:2023" {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer server.Close()
+
+	Config.PostConnectHook = server.URL
+	t.Cleanup(func() {
+		Config.PostConnectHook = ""
+		Config.PostConnectHookNotifyHandshake = false
+	})
+
+	err := Config.ExecutePostConnectHookWithInfo(&PostConnectInfo{
+		IP:           "::ffff:127.0.0.1",
+		Protocol:     ProtocolSSH,
+		LocalAddress: "127.0.0.1:2022",
+	})
+	assert.NoError(t, err)
+	info := <-infoCh
+	assert.Equal(t, PostConnectEventConnect, info.Event)
+	assert.Equal(t, "127.0.0.1:2022", info.LocalAddress)
+	assert.Empty(t, info.ClientVersion)
+	err = Config.ExecutePostConnectHookWithInfo(&PostConnectInfo{
+		IP:           "127.0.0.1",
+		Protocol:     ProtocolSSH,
+		LocalAddress: "127.0.0.1:2023",
+	})
+	assert.Error(t, err)
+	<-infoCh
+
+	handshakeInfo := PostConnectInfo{
+		IP:            "127.0.0.1",
+		Protocol:      ProtocolSSH,
+		LocalAddress:  "127.0.0.1:2023",
+		ClientVersion: "SSH-2.0-OpenSSH_9.9",
+		KeyExchange:   "curve25519-sha256",
+		Cipher:        "aes128-gcm@openssh.com",
+		HostKeyAlgo:   "ssh-ed25519",
+	}
+	// handshake notifications are disabled by default
+	Config.NotifyPostConnectHandshake(&handshakeInfo, "connID")
+	Config.PostConnectHookNotifyHandshake = true
+	Config.NotifyPostConnectHandshake(&handshakeInfo, "connID")
+	select {
+	case info = <-infoCh:
+		assert.Equal(t, PostConnectEventHandshake, info.Event)
+		assert.Equal(t, handshakeInfo, info)
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "handshake notification not received")
+	}
+	assert.Eventually(t, func() bool {
+		return activeHooks.Load() == 0
+	}, 2*time.Second, 50*time.Millisecond)
+	assert.Len(t, infoCh, 0)
+
+	env := handshakeInfo.getEnv()
+	assert.Contains(t, env, "SFTPGO_CONNECTION_EVENT=handshake")
+	assert.Contains(t, env, "SFTPGO_CONNECTION_CLIENT_VERSION=SSH-2.0-OpenSSH_9.9")
+	assert.Contains(t, env, "SFTPGO_CONNECTION_LOCAL_ADDRESS=127.0.0.1:2023")
+}
+
 func TestCryptoConvertFileInfo(t *testing.T) {
 	name := "name"
 	fs, err := vfs.NewCryptFs("connID1", os.TempDir(), "", vfs.CryptFsConfig{
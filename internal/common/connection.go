@@ -15,6 +15,7 @@
 package common
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -33,6 +34,7 @@ import (
 
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/metric"
 	"github.com/drakkan/sftpgo/v2/internal/util"
 	"github.com/drakkan/sftpgo/v2/internal/vfs"
 )
@@ -47,6 +49,8 @@ type BaseConnection struct {
 	// unique ID for a transfer.
 	// This field is accessed atomically so we put it at the beginning of the struct to achieve 64 bit alignment
 	transferID atomic.Int64
+	// set when the client disconnects, the running filesystem operations are canceled
+	clientDisconnected atomic.Bool
 	// Unique identifier for the connection
 	ID string
 	// user associated with this connection if any
@@ -58,6 +62,12 @@ type BaseConnection struct {
 	localAddr  string
 	sync.RWMutex
 	activeTransfers []ActiveTransfer
+	// sampled sizes for the active transfers, by transfer ID
+	transfersProgress map[int64]*transferProgress
+	// read-only virtual files generated on the fly
+	generatedFiles []GeneratedFile
+	// path to the session recording file, if any
+	recordingPath string
 }
 
 // NewBaseConnection returns a new BaseConnection
@@ -83,7 +93,16 @@ func NewBaseConnection(id, protocol, localAddr, remoteAddr string, user dataprov
 
 // Log outputs a log entry to the configured logger
 func (c *BaseConnection) Log(level logger.LogLevel, format string, v ...any) {
-	logger.Log(level, c.protocol, c.ID, format, v...)
+	logger.ConnectionLog(level, c.protocol, c.ID, c.IsDebugLogForced(), nil, format, v...)
+}
+
+// IsDebugLogForced returns true if a debug log override matches
+// the username or the source IP for this connection
+func (c *BaseConnection) IsDebugLogForced() bool {
+	if debugLogOverrides.size.Load() == 0 {
+		return false
+	}
+	return debugLogOverrides.isDebugForced(c.User.Username, c.GetRemoteIP())
 }
 
 // GetTransferID returns an unique transfer ID for this connection
@@ -111,7 +130,35 @@ func (c *BaseConnection) GetMaxSessions() int {
 	return c.User.MaxSessions
 }
 
+func (c *BaseConnection) getUser() *dataprovider.User {
+	return &c.User
+}
+
+// SetRecordingPath sets the path to the session recording file
+func (c *BaseConnection) SetRecordingPath(recordingPath string) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.recordingPath = recordingPath
+}
+
+// GetRecordingPath returns the path to the session recording file,
+// empty if the session is not recorded
+func (c *BaseConnection) GetRecordingPath() string {
+	c.RLock()
+	defer c.RUnlock()
+
+	return c.recordingPath
+}
+
 // isAccessAllowed returns true if the user's access conditions are met
+// isInternal returns true if the connection is used for internally initiated
+// operations, such as data retention checks, event actions and archives extraction
+func (c *BaseConnection) isInternal() bool {
+	return c.protocol == ProtocolDataRetention || c.protocol == protocolEventAction ||
+		c.protocol == protocolArchiveExtraction
+}
+
 func (c *BaseConnection) isAccessAllowed() bool {
 	if err := c.User.CheckLoginConditions(); err != nil {
 		return false
@@ -157,9 +204,53 @@ func (c *BaseConnection) CloseFS() error {
 	return c.User.CloseFs()
 }
 
+// SignalClientDisconnected cancels the long running filesystem operations,
+// such as listings, server side copies and recursive deletes, in progress for
+// this connection. The protocol handlers call it as soon as the client
+// disconnects, they may still be waiting for the operations to finish
+func (c *BaseConnection) SignalClientDisconnected() {
+	if c.clientDisconnected.CompareAndSwap(false, true) {
+		c.Log(logger.LevelDebug, "client disconnected, canceling the running filesystem operations")
+		c.User.CancelFsOperations()
+	}
+}
+
+// isAbortedByClient returns true if err is caused by a filesystem operation
+// canceled because the client disconnected
+func (c *BaseConnection) isAbortedByClient(err error) bool {
+	return c.clientDisconnected.Load() && errors.Is(err, context.Canceled)
+}
+
+// getAbortedError returns ErrAbortedByClient if err is caused by a filesystem
+// operation canceled because the client disconnected, otherwise err
+func (c *BaseConnection) getAbortedError(err error) error {
+	if c.isAbortedByClient(err) {
+		return ErrAbortedByClient
+	}
+	return err
+}
+
+// logFsError logs a filesystem error. The operations canceled because the
+// client disconnected are not backend errors and so they are logged as aborted
+func (c *BaseConnection) logFsError(err error, format string, v ...any) {
+	if c.isAbortedByClient(err) {
+		c.Log(logger.LevelInfo, format+": %v", append(v, ErrAbortedByClient)...)
+		return
+	}
+	c.Log(logger.LevelError, format+": %+v", append(v, err)...)
+}
+
+func (c *BaseConnection) refreshFilesystems(user *dataprovider.User) {
+	virtualPaths := c.User.RefreshFilesystems(user, c.ID)
+	if len(virtualPaths) > 0 {
+		c.Log(logger.LevelInfo, "filesystems refreshed after a configuration update, virtual paths: %v", virtualPaths)
+	}
+}
+
 // AddTransfer associates a new transfer to this connection
 func (c *BaseConnection) AddTransfer(t ActiveTransfer) {
 	Connections.transfers.add(c.User.Username)
+	metric.AddActiveTransfer(t.GetType())
 
 	c.Lock()
 	defer c.Unlock()
@@ -192,7 +283,9 @@ func (c *BaseConnection) AddTransfer(t ActiveTransfer) {
 
 // RemoveTransfer removes the specified transfer from the active ones
 func (c *BaseConnection) RemoveTransfer(t ActiveTransfer) {
-	Connections.transfers.remove(c.User.Username)
+	if Connections.transfers.remove(c.User.Username) {
+		metric.RemoveActiveTransfer(t.GetType())
+	}
 
 	c.Lock()
 	defer c.Unlock()
@@ -200,6 +293,7 @@ func (c *BaseConnection) RemoveTransfer(t ActiveTransfer) {
 	if t.HasSizeLimit() {
 		go transfersChecker.RemoveTransfer(t.GetID(), c.ID)
 	}
+	delete(c.transfersProgress, t.GetID())
 
 	for idx, transfer := range c.activeTransfers {
 		if transfer.GetID() == t.GetID() {
@@ -233,6 +327,7 @@ func (c *BaseConnection) GetTransfers() []ConnectionTransfer {
 	c.RLock()
 	defer c.RUnlock()
 
+	now := time.Now()
 	transfers := make([]ConnectionTransfer, 0, len(c.activeTransfers))
 	for _, t := range c.activeTransfers {
 		var operationType string
@@ -242,21 +337,48 @@ func (c *BaseConnection) GetTransfers() []ConnectionTransfer {
 		case TransferUpload:
 			operationType = operationUpload
 		}
-		transfers = append(transfers, ConnectionTransfer{
+		size := t.GetSize()
+		transfer := ConnectionTransfer{
 			ID:            t.GetID(),
 			OperationType: operationType,
 			StartTime:     util.GetTimeAsMsSinceEpoch(t.GetStartTime()),
-			Size:          t.GetSize(),
+			Size:          size,
 			VirtualPath:   t.GetVirtualPath(),
 			HasSizeLimit:  t.HasSizeLimit(),
 			ULSize:        t.GetUploadedSize(),
 			DLSize:        t.GetDownloadedSize(),
-		})
+			ExpectedSize:  t.GetExpectedSize(),
+			Throughput:    c.transfersProgress[t.GetID()].getThroughput(now, t.GetStartTime(), size),
+		}
+		setTransferProgress(&transfer)
+		transfers = append(transfers, transfer)
 	}
 
 	return transfers
 }
 
+// sampleTransfers records the current size for the active transfers,
+// the samples are used to compute the throughput
+func (c *BaseConnection) sampleTransfers(now time.Time) {
+	c.Lock()
+	defer c.Unlock()
+
+	if len(c.activeTransfers) == 0 {
+		return
+	}
+	if c.transfersProgress == nil {
+		c.transfersProgress = make(map[int64]*transferProgress)
+	}
+	for _, t := range c.activeTransfers {
+		progress, ok := c.transfersProgress[t.GetID()]
+		if !ok {
+			progress = &transferProgress{}
+			c.transfersProgress[t.GetID()] = progress
+		}
+		progress.add(now, t.GetSize())
+	}
+}
+
 // SignalTransfersAbort signals to the active transfers to exit as soon as possible
 func (c *BaseConnection) SignalTransfersAbort() error {
 	c.RLock()
@@ -399,10 +521,13 @@ func (c *BaseConnection) CreateDir(virtualPath string, checkFilePatterns bool) e
 	startTime := time.Now()
 	if err := fs.Mkdir(fsPath); err != nil {
 		c.Log(logger.LevelError, "error creating dir: %q error: %+v", fsPath, err)
-		return c.GetFsError(fs, err)
+		err = c.GetFsError(fs, err)
+		c.auditLog(operationMkdir, virtualPath, "", 0, time.Since(startTime).Milliseconds(), err)
+		return err
 	}
 	vfs.SetPathPermissions(fs, fsPath, c.User.GetUID(), c.User.GetGID())
 	elapsed := time.Since(startTime).Nanoseconds() / 1000000
+	c.auditLog(operationMkdir, virtualPath, "", 0, elapsed, nil)
 
 	logger.CommandLog(mkdirLogSender, fsPath, "", c.User.Username, "", c.ID, c.protocol, -1, -1, "", "", "", -1,
 		c.localAddr, c.remoteAddr, elapsed)
@@ -424,16 +549,29 @@ func (c *BaseConnection) IsRemoveFileAllowed(virtualPath string) error {
 
 // RemoveFile removes a file at the specified fsPath
 func (c *BaseConnection) RemoveFile(fs vfs.Fs, fsPath, virtualPath string, info os.FileInfo) error {
-	if err := c.IsRemoveFileAllowed(virtualPath); err != nil {
+	status, err := c.checkRemoveFile(fsPath, virtualPath, info.Size())
+	if err != nil {
 		return err
 	}
+	return c.removeFile(fs, fsPath, virtualPath, info, status)
+}
 
-	size := info.Size()
+// checkRemoveFile checks if the file can be removed and executes the pre-delete
+// action. It returns the pre-delete action status
+func (c *BaseConnection) checkRemoveFile(fsPath, virtualPath string, size int64) (int, error) {
+	if err := c.IsRemoveFileAllowed(virtualPath); err != nil {
+		return 0, err
+	}
 	status, err := ExecutePreAction(c, operationPreDelete, fsPath, virtualPath, size, 0)
 	if err != nil {
-		c.Log(logger.LevelDebug, "delete for file %q denied by pre action: %v", virtualPath, err)
-		return c.GetPermissionDeniedError()
+		c.Log(logger.LevelInfo, "delete for file %q denied by pre action: %v", virtualPath, err)
+		return status, c.GetPermissionDeniedError()
 	}
+	return status, nil
+}
+
+func (c *BaseConnection) removeFile(fs vfs.Fs, fsPath, virtualPath string, info os.FileInfo, status int) error {
+	size := info.Size()
 	updateQuota := true
 	startTime := time.Now()
 	if err := fs.Remove(fsPath, false); err != nil {
@@ -442,24 +580,122 @@ func (c *BaseConnection) RemoveFile(fs vfs.Fs, fsPath, virtualPath string, info
 			c.Log(logger.LevelDebug, "file deleted from the hook, status: %d", status)
 			updateQuota = (status == 1)
 		} else {
-			c.Log(logger.LevelError, "failed to remove file/symlink %q: %+v", fsPath, err)
-			return c.GetFsError(fs, err)
+			c.logFsError(err, "failed to remove file/symlink %q", fsPath)
+			err = c.GetFsError(fs, err)
+			c.auditLog(operationDelete, virtualPath, "", size, time.Since(startTime).Milliseconds(), err)
+			return err
 		}
 	}
 	elapsed := time.Since(startTime).Nanoseconds() / 1000000
+	c.fileRemoved(fsPath, virtualPath, size, elapsed)
+	if updateQuota && info.Mode()&os.ModeSymlink == 0 {
+		c.updateQuotaAfterRemove(path.Dir(virtualPath), 1, size)
+	}
+	ExecuteActionNotification(c, operationDelete, fsPath, virtualPath, "", "", "", size, nil, elapsed, nil) //nolint:errcheck
+	return nil
+}
 
+// fileRemoved logs the removal of a file
+func (c *BaseConnection) fileRemoved(fsPath, virtualPath string, size, elapsed int64) {
+	c.auditLog(operationDelete, virtualPath, "", size, elapsed, nil)
 	logger.CommandLog(removeLogSender, fsPath, "", c.User.Username, "", c.ID, c.protocol, -1, -1, "", "", "", -1,
 		c.localAddr, c.remoteAddr, elapsed)
-	if updateQuota && info.Mode()&os.ModeSymlink == 0 {
-		vfolder, err := c.User.GetVirtualFolderForPath(path.Dir(virtualPath))
-		if err == nil {
-			dataprovider.UpdateUserFolderQuota(&vfolder, &c.User, -1, -size, false)
-		} else {
-			dataprovider.UpdateUserQuota(&c.User, -1, -size, false) //nolint:errcheck
+}
+
+// updateQuotaAfterRemove updates the quota for the specified number of files,
+// and their total size, removed from the specified virtual directory
+func (c *BaseConnection) updateQuotaAfterRemove(virtualDir string, numFiles int, size int64) {
+	if numFiles == 0 {
+		return
+	}
+	vfolder, err := c.User.GetVirtualFolderForPath(virtualDir)
+	if err == nil {
+		dataprovider.UpdateUserFolderQuota(&vfolder, &c.User, -numFiles, -size, false)
+	} else {
+		dataprovider.UpdateUserQuota(&c.User, -numFiles, -size, false) //nolint:errcheck
+	}
+}
+
+// removeFiles removes the specified files, listed from the same virtual
+// directory, using the batch remover. The files that the pre-delete action may
+// have already removed are removed one by one. The quota is updated only for
+// the files actually removed
+func (c *BaseConnection) removeFiles(fs vfs.FsBatchRemover, virtualDir string, files []os.FileInfo) error {
+	if len(files) == 0 {
+		return nil
+	}
+	fsPaths := make([]string, 0, len(files))
+	entries := make(map[string]os.FileInfo, len(files))
+	for _, info := range files {
+		virtualPath := path.Join(virtualDir, info.Name())
+		fsPath, err := fs.ResolvePath(virtualPath)
+		if err != nil {
+			return c.GetFsError(fs, err)
+		}
+		status, err := c.checkRemoveFile(fsPath, virtualPath, info.Size())
+		if err != nil {
+			return err
+		}
+		if status > 0 {
+			if err := c.removeFile(fs, fsPath, virtualPath, info, status); err != nil {
+				return err
+			}
+			continue
 		}
+		fsPaths = append(fsPaths, fsPath)
+		entries[fsPath] = info
 	}
-	ExecuteActionNotification(c, operationDelete, fsPath, virtualPath, "", "", "", size, nil, elapsed, nil) //nolint:errcheck
-	return nil
+	if len(fsPaths) == 0 {
+		return nil
+	}
+
+	startTime := time.Now()
+	removed, err := fs.RemoveFiles(fsPaths)
+	elapsed := time.Since(startTime).Nanoseconds() / 1000000
+	var numFiles int
+	var size int64
+	for _, fsPath := range removed {
+		info := entries[fsPath]
+		virtualPath := path.Join(virtualDir, info.Name())
+		c.fileRemoved(fsPath, virtualPath, info.Size(), elapsed)
+		if info.Mode()&os.ModeSymlink == 0 {
+			numFiles++
+			size += info.Size()
+		}
+	}
+	c.updateQuotaAfterRemove(virtualDir, numFiles, size)
+	for _, fsPath := range removed {
+		info := entries[fsPath]
+		ExecuteActionNotification(c, operationDelete, fsPath, path.Join(virtualDir, info.Name()), "", "", "", //nolint:errcheck
+			info.Size(), nil, elapsed, nil)
+	}
+	if err == nil {
+		return nil
+	}
+	var batchErr *vfs.BatchError
+	if !errors.As(err, &batchErr) {
+		c.logFsError(err, "failed to remove files in dir %q", virtualDir)
+		return c.GetFsError(fs, err)
+	}
+	failed := make(map[string]error)
+	for fsPath, removeErr := range batchErr.Failed {
+		info := entries[fsPath]
+		virtualPath := path.Join(virtualDir, info.Name())
+		if fs.IsNotExist(removeErr) {
+			c.Log(logger.LevelDebug, "file %q to remove not found, skipping", virtualPath)
+			continue
+		}
+		c.logFsError(removeErr, "failed to remove file/symlink %q", fsPath)
+		c.auditLog(operationDelete, virtualPath, "", info.Size(), elapsed, c.GetFsError(fs, removeErr))
+		failed[virtualPath] = c.getAbortedError(removeErr)
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	if c.isAbortedByClient(err) {
+		return c.GetGenericError(ErrAbortedByClient)
+	}
+	return c.GetGenericError(&vfs.BatchError{Operation: "remove", Failed: failed})
 }
 
 // IsRemoveDirAllowed returns an error if removing this directory is not allowed
@@ -517,10 +753,13 @@ func (c *BaseConnection) RemoveDir(virtualPath string) error {
 
 	startTime := time.Now()
 	if err := fs.Remove(fsPath, true); err != nil {
-		c.Log(logger.LevelError, "failed to remove directory %q: %+v", fsPath, err)
-		return c.GetFsError(fs, err)
+		c.logFsError(err, "failed to remove directory %q", fsPath)
+		err = c.GetFsError(fs, err)
+		c.auditLog(operationRmdir, virtualPath, "", 0, time.Since(startTime).Milliseconds(), err)
+		return err
 	}
 	elapsed := time.Since(startTime).Nanoseconds() / 1000000
+	c.auditLog(operationRmdir, virtualPath, "", 0, elapsed, nil)
 
 	logger.CommandLog(rmdirLogSender, fsPath, "", c.User.Username, "", c.ID, c.protocol, -1, -1, "", "", "", -1,
 		c.localAddr, c.remoteAddr, elapsed)
@@ -548,6 +787,9 @@ func (c *BaseConnection) doRecursiveRemove(fs vfs.Fs, fsPath, virtualPath string
 			return fmt.Errorf("unable to get lister for dir %q: %w", virtualPath, err)
 		}
 		defer lister.Close()
+		// the files within a directory are on the same filesystem as the directory,
+		// only the directories can be virtual folders
+		batchRemover, hasBatchRemover := vfs.GetBatchRemover(fs)
 
 		for {
 			entries, err := lister.Next(vfs.ListerBatchSize)
@@ -555,12 +797,20 @@ func (c *BaseConnection) doRecursiveRemove(fs vfs.Fs, fsPath, virtualPath string
 			if err != nil && !finished {
 				return fmt.Errorf("unable to get content for dir %q: %w", virtualPath, err)
 			}
+			var files []os.FileInfo
 			for _, fi := range entries {
+				if hasBatchRemover && !fi.IsDir() {
+					files = append(files, fi)
+					continue
+				}
 				targetPath := path.Join(virtualPath, fi.Name())
 				if err := c.doRecursiveRemoveDirEntry(targetPath, fi, recursion); err != nil {
 					return err
 				}
 			}
+			if err := c.removeFiles(batchRemover, virtualPath, files); err != nil {
+				return err
+			}
 			if finished {
 				lister.Close()
 				break
@@ -646,6 +896,7 @@ func (c *BaseConnection) copyFile(virtualSourcePath, virtualTargetPath string, s
 			updateUserQuotaAfterFileWrite(c, virtualTargetPath, numFiles, sizeDiff)
 			logger.CommandLog(copyLogSender, fsSourcePath, fsTargetPath, c.User.Username, "", c.ID, c.protocol, -1, -1,
 				"", "", "", srcInfo.Size(), c.localAddr, c.remoteAddr, elapsed)
+			err = c.getAbortedError(err)
 			ExecuteActionNotification(c, operationCopy, fsSourcePath, virtualSourcePath, fsTargetPath, virtualTargetPath, "", srcInfo.Size(), err, elapsed, nil) //nolint:errcheck
 			return err
 		}
@@ -843,6 +1094,15 @@ func (c *BaseConnection) renameInternal(virtualSourcePath, virtualTargetPath str
 		c.Log(logger.LevelInfo, "denying cross rename due to space limit")
 		return c.GetGenericError(ErrQuotaExceeded)
 	}
+	var srcSize int64
+	if srcInfo.Mode().IsRegular() {
+		srcSize = srcInfo.Size()
+	}
+	if _, err := executePreAction(c, operationPreRename, fsSourcePath, virtualSourcePath, fsTargetPath,
+		virtualTargetPath, srcSize, 0); err != nil {
+		c.Log(logger.LevelInfo, "rename %q -> %q denied by pre action: %v", virtualSourcePath, virtualTargetPath, err)
+		return c.GetPermissionDeniedError()
+	}
 	if checkParentDestination {
 		c.CheckParentDirs(path.Dir(virtualTargetPath)) //nolint:errcheck
 	}
@@ -852,11 +1112,14 @@ func (c *BaseConnection) renameInternal(virtualSourcePath, virtualTargetPath str
 
 	files, size, err := fsDst.Rename(fsSourcePath, fsTargetPath, checks)
 	if err != nil {
-		c.Log(logger.LevelError, "failed to rename %q -> %q: %+v", fsSourcePath, fsTargetPath, err)
-		return c.GetFsError(fsSrc, err)
+		c.logFsError(err, "failed to rename %q -> %q", fsSourcePath, fsTargetPath)
+		err = c.GetFsError(fsSrc, err)
+		c.auditLog(operationRename, virtualSourcePath, virtualTargetPath, size, time.Since(startTime).Milliseconds(), err)
+		return err
 	}
 	vfs.SetPathPermissions(fsDst, fsTargetPath, c.User.GetUID(), c.User.GetGID())
 	elapsed := time.Since(startTime).Nanoseconds() / 1000000
+	c.auditLog(operationRename, virtualSourcePath, virtualTargetPath, size, elapsed, nil)
 	c.updateQuotaAfterRename(fsDst, virtualSourcePath, virtualTargetPath, fsTargetPath, initialSize, files, size) //nolint:errcheck
 	logger.CommandLog(renameLogSender, fsSourcePath, fsTargetPath, c.User.Username, "", c.ID, c.protocol, -1, -1,
 		"", "", "", -1, c.localAddr, c.remoteAddr, elapsed)
@@ -958,7 +1221,7 @@ func (c *BaseConnection) doStatInternal(virtualPath string, mode int, checkFileP
 		return nil, c.GetFsError(fs, err)
 	}
 	if convertResult && vfs.IsCryptOsFs(fs) {
-		info = fs.(*vfs.CryptFs).ConvertFileInfo(info)
+		info = vfs.UnwrapFs(fs).(*vfs.CryptFs).ConvertFileInfo(info)
 	}
 	return info, nil
 }
@@ -977,6 +1240,10 @@ func (c *BaseConnection) createDirIfMissing(name string) error {
 }
 
 func (c *BaseConnection) ignoreSetStat(fs vfs.Fs) bool {
+	if vfs.IsAttrsFs(fs) {
+		// the folder is explicitly configured to record the attributes
+		return false
+	}
 	if Config.SetstatMode == 1 {
 		return true
 	}
@@ -986,8 +1253,8 @@ func (c *BaseConnection) ignoreSetStat(fs vfs.Fs) bool {
 	return false
 }
 
-func (c *BaseConnection) handleChmod(fs vfs.Fs, fsPath, pathForPerms string, attributes *StatAttributes) error {
-	if !c.User.HasPerm(dataprovider.PermChmod, pathForPerms) {
+func (c *BaseConnection) handleChmod(fs vfs.Fs, fsPath, virtualPath string, attributes *StatAttributes) error {
+	if !c.User.HasPerm(dataprovider.PermChmod, path.Dir(virtualPath)) {
 		return c.GetPermissionDeniedError()
 	}
 	if c.ignoreSetStat(fs) {
@@ -996,29 +1263,51 @@ func (c *BaseConnection) handleChmod(fs vfs.Fs, fsPath, pathForPerms string, att
 	startTime := time.Now()
 	if err := fs.Chmod(c.getRealFsPath(fsPath), attributes.Mode); err != nil {
 		c.Log(logger.LevelError, "failed to chmod path %q, mode: %v, err: %+v", fsPath, attributes.Mode.String(), err)
-		return c.GetFsError(fs, err)
+		err = c.GetFsError(fs, err)
+		c.auditLog(auditOperationChmod, virtualPath, "", 0, time.Since(startTime).Milliseconds(), err)
+		return err
 	}
 	elapsed := time.Since(startTime).Nanoseconds() / 1000000
+	c.auditLog(auditOperationChmod, virtualPath, "", 0, elapsed, nil)
 	logger.CommandLog(chmodLogSender, fsPath, "", c.User.Username, attributes.Mode.String(), c.ID, c.protocol,
 		-1, -1, "", "", "", -1, c.localAddr, c.remoteAddr, elapsed)
 	return nil
 }
 
-func (c *BaseConnection) handleChown(fs vfs.Fs, fsPath, pathForPerms string, attributes *StatAttributes) error {
-	if !c.User.HasPerm(dataprovider.PermChown, pathForPerms) {
+func (c *BaseConnection) handleChown(fs vfs.Fs, fsPath, virtualPath string, attributes *StatAttributes) error {
+	policy := c.User.Filters.ChownPolicy
+	if policy.Mode == dataprovider.ChownPolicyIgnore {
+		c.Log(logger.LevelDebug, "chown request for path %q, uid: %v, gid: %v, ignored by the user policy",
+			virtualPath, attributes.UID, attributes.GID)
+		return nil
+	}
+	if !c.User.HasPerm(dataprovider.PermChown, path.Dir(virtualPath)) {
 		return c.GetPermissionDeniedError()
 	}
 	if c.ignoreSetStat(fs) {
 		return nil
 	}
+	uid, gid := attributes.UID, attributes.GID
+	if policy.Mode == dataprovider.ChownPolicyMap {
+		c.Log(logger.LevelDebug, "chown request for path %q, uid: %v, gid: %v, mapped to uid: %v, gid: %v",
+			virtualPath, uid, gid, policy.UID, policy.GID)
+		uid, gid = policy.UID, policy.GID
+	}
 	startTime := time.Now()
-	if err := fs.Chown(c.getRealFsPath(fsPath), attributes.UID, attributes.GID); err != nil {
-		c.Log(logger.LevelError, "failed to chown path %q, uid: %v, gid: %v, err: %+v", fsPath, attributes.UID,
-			attributes.GID, err)
-		return c.GetFsError(fs, err)
+	if err := fs.Chown(c.getRealFsPath(fsPath), uid, gid); err != nil {
+		if policy.Mode == dataprovider.ChownPolicyMap && errors.Is(err, os.ErrPermission) {
+			// the process is not allowed to change the owner
+			c.Log(logger.LevelDebug, "mapped chown for path %q not applied: %v", virtualPath, err)
+			return nil
+		}
+		c.Log(logger.LevelError, "failed to chown path %q, uid: %v, gid: %v, err: %+v", fsPath, uid, gid, err)
+		err = c.GetFsError(fs, err)
+		c.auditLog(auditOperationChown, virtualPath, "", 0, time.Since(startTime).Milliseconds(), err)
+		return err
 	}
 	elapsed := time.Since(startTime).Nanoseconds() / 1000000
-	logger.CommandLog(chownLogSender, fsPath, "", c.User.Username, "", c.ID, c.protocol, attributes.UID, attributes.GID,
+	c.auditLog(auditOperationChown, virtualPath, "", 0, elapsed, nil)
+	logger.CommandLog(chownLogSender, fsPath, "", c.User.Username, "", c.ID, c.protocol, uid, gid,
 		"", "", "", -1, c.localAddr, c.remoteAddr, elapsed)
 	return nil
 }
@@ -1027,7 +1316,7 @@ func (c *BaseConnection) handleChtimes(fs vfs.Fs, fsPath, pathForPerms string, a
 	if !c.User.HasPerm(dataprovider.PermChtimes, pathForPerms) {
 		return c.GetPermissionDeniedError()
 	}
-	if Config.SetstatMode == 1 {
+	if Config.SetstatMode == 1 && !vfs.IsAttrsFs(fs) {
 		return nil
 	}
 	startTime := time.Now()
@@ -1067,13 +1356,13 @@ func (c *BaseConnection) SetStat(virtualPath string, attributes *StatAttributes)
 	}
 
 	if attributes.Flags&StatAttrPerms != 0 {
-		if err = c.handleChmod(fs, fsPath, pathForPerms, attributes); err != nil {
+		if err = c.handleChmod(fs, fsPath, virtualPath, attributes); err != nil {
 			return err
 		}
 	}
 
 	if attributes.Flags&StatAttrUIDGID != 0 {
-		if err = c.handleChown(fs, fsPath, pathForPerms, attributes); err != nil {
+		if err = c.handleChown(fs, fsPath, virtualPath, attributes); err != nil {
 			return err
 		}
 	}
@@ -1341,22 +1630,23 @@ func (c *BaseConnection) hasSpaceForCrossRename(fs vfs.Fs, quotaResult vfs.Quota
 	return true
 }
 
-// GetMaxWriteSize returns the allowed size for an upload or an error
-// if no enough size is available for a resume/append
+// GetMaxWriteSize returns the allowed size for an upload to the specified virtual
+// path or an error if no enough size is available for a resume/append
 func (c *BaseConnection) GetMaxWriteSize(quotaResult vfs.QuotaCheckResult, isResume bool, fileSize int64,
-	isUploadResumeSupported bool,
+	isUploadResumeSupported bool, virtualPath string,
 ) (int64, error) {
 	maxWriteSize := quotaResult.GetRemainingSize()
+	maxUploadFileSize := c.User.GetMaxUploadFileSize(virtualPath)
 
 	if isResume {
 		if !isUploadResumeSupported {
 			return 0, c.GetOpUnsupportedError()
 		}
-		if c.User.Filters.MaxUploadFileSize > 0 && c.User.Filters.MaxUploadFileSize <= fileSize {
+		if maxUploadFileSize > 0 && maxUploadFileSize <= fileSize {
 			return 0, c.GetQuotaExceededError()
 		}
-		if c.User.Filters.MaxUploadFileSize > 0 {
-			maxUploadSize := c.User.Filters.MaxUploadFileSize - fileSize
+		if maxUploadFileSize > 0 {
+			maxUploadSize := maxUploadFileSize - fileSize
 			if maxUploadSize < maxWriteSize || maxWriteSize == 0 {
 				maxWriteSize = maxUploadSize
 			}
@@ -1365,8 +1655,8 @@ func (c *BaseConnection) GetMaxWriteSize(quotaResult vfs.QuotaCheckResult, isRes
 		if maxWriteSize > 0 {
 			maxWriteSize += fileSize
 		}
-		if c.User.Filters.MaxUploadFileSize > 0 && (c.User.Filters.MaxUploadFileSize < maxWriteSize || maxWriteSize == 0) {
-			maxWriteSize = c.User.Filters.MaxUploadFileSize
+		if maxUploadFileSize > 0 && (maxUploadFileSize < maxWriteSize || maxWriteSize == 0) {
+			maxWriteSize = maxUploadFileSize
 		}
 	}
 
@@ -1389,6 +1679,7 @@ func (c *BaseConnection) checkUserQuota() (dataprovider.TransferQuota, int, int6
 		AllowedDLSize:    0,
 		AllowedTotalSize: 0,
 	}
+	c.checkTransferQuotaWindow(&result)
 	if !c.User.HasTransferQuotaRestrictions() {
 		return result, -1, -1
 	}
@@ -1411,6 +1702,24 @@ func (c *BaseConnection) checkUserQuota() (dataprovider.TransferQuota, int, int6
 	return result, usedFiles, usedSize
 }
 
+func (c *BaseConnection) checkTransferQuotaWindow(result *dataprovider.TransferQuota) {
+	window := &c.User.Filters.TransferQuotaWindow
+	if !window.IsEnabled() || dataprovider.GetQuotaTracking() == 0 {
+		return
+	}
+	result.WindowSize = window.GetSize()
+	status, err := dataprovider.GetTransferQuotaWindowStatus(&c.User)
+	if err != nil {
+		c.Log(logger.LevelError, "error getting transfer quota window for %q: %v", c.User.Username, err)
+		result.AllowedWindowSize = -1
+		return
+	}
+	result.AllowedWindowSize = status.Remaining
+	if window.EnforceDuringTransfer && status.Remaining > 0 {
+		result.MaxWindowTransferSize = status.Remaining + window.GetGraceSize()
+	}
+}
+
 // HasSpace checks user's quota usage
 func (c *BaseConnection) HasSpace(checkFiles, getUsage bool, requestPath string) (vfs.QuotaCheckResult,
 	dataprovider.TransferQuota,
@@ -1700,7 +2009,8 @@ func (c *BaseConnection) IsQuotaExceededError(err error) bool {
 func isSFTPGoError(err error) bool {
 	return errors.Is(err, ErrPermissionDenied) || errors.Is(err, ErrNotExist) || errors.Is(err, ErrOpUnsupported) ||
 		errors.Is(err, ErrQuotaExceeded) || errors.Is(err, ErrReadQuotaExceeded) ||
-		errors.Is(err, vfs.ErrStorageSizeUnavailable) || errors.Is(err, ErrShuttingDown)
+		errors.Is(err, vfs.ErrStorageSizeUnavailable) || errors.Is(err, ErrShuttingDown) ||
+		errors.Is(err, vfs.ErrTooManyEntries) || errors.Is(err, ErrAbortedByClient)
 }
 
 // GetGenericError returns an appropriate generic error for the connection protocol
@@ -1733,6 +2043,9 @@ func (c *BaseConnection) GetGenericError(err error) error {
 
 // GetFsError converts a filesystem error to a protocol error
 func (c *BaseConnection) GetFsError(fs vfs.Fs, err error) error {
+	if c.isAbortedByClient(err) {
+		return c.GetGenericError(ErrAbortedByClient)
+	}
 	if fs.IsNotExist(err) {
 		return c.GetNotExistError()
 	} else if fs.IsPermission(err) {
@@ -15,6 +15,7 @@
 package common
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -163,6 +164,48 @@ func TestSetStatMode(t *testing.T) {
 	Config.SetstatMode = oldSetStatMode
 }
 
+func TestChownPolicy(t *testing.T) {
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			HomeDir: filepath.Clean(os.TempDir()),
+		},
+	}
+	user.Permissions = make(map[string][]string)
+	user.Permissions["/"] = []string{dataprovider.PermListItems, dataprovider.PermDownload}
+	fs := vfs.NewOsFs("", user.GetHomeDir(), "", nil)
+	missingPath := filepath.Join(user.GetHomeDir(), "missing_chown_path")
+	attrs := &StatAttributes{
+		Flags: StatAttrUIDGID,
+		UID:   os.Getuid(),
+		GID:   os.Getgid(),
+	}
+	conn := NewBaseConnection("", ProtocolSFTP, "", "", user)
+	err := conn.handleChown(fs, missingPath, "/missing_chown_path", attrs)
+	assert.ErrorIs(t, err, sftp.ErrSSHFxPermissionDenied)
+
+	conn.User.Filters.ChownPolicy.Mode = dataprovider.ChownPolicyIgnore
+	err = conn.handleChown(fs, missingPath, "/missing_chown_path", attrs)
+	assert.NoError(t, err)
+
+	conn.User.Permissions["/"] = []string{dataprovider.PermAny}
+	conn.User.Filters.ChownPolicy = dataprovider.ChownPolicy{
+		Mode: dataprovider.ChownPolicyMap,
+		UID:  -1,
+		GID:  os.Getgid(),
+	}
+	err = conn.handleChown(fs, missingPath, "/missing_chown_path", attrs)
+	assert.ErrorIs(t, err, sftp.ErrSSHFxNoSuchFile)
+	if runtime.GOOS != osWindows {
+		testFile := filepath.Join(user.GetHomeDir(), "chown_policy_file")
+		err = os.WriteFile(testFile, []byte("data"), os.ModePerm)
+		assert.NoError(t, err)
+		err = conn.handleChown(fs, testFile, "/chown_policy_file", attrs)
+		assert.NoError(t, err)
+		err = os.Remove(testFile)
+		assert.NoError(t, err)
+	}
+}
+
 func TestRecursiveRenameWalkError(t *testing.T) {
 	fs := vfs.NewOsFs("", filepath.Clean(os.TempDir()), "", nil)
 	conn := NewBaseConnection("", ProtocolWebDAV, "", "", dataprovider.User{
@@ -454,6 +497,30 @@ func TestErrorsMapping(t *testing.T) {
 	}
 }
 
+func TestAbortedByClient(t *testing.T) {
+	fs := vfs.NewOsFs("", os.TempDir(), "", nil)
+	conn := NewBaseConnection("", ProtocolSFTP, "", "", dataprovider.User{BaseUser: sdk.BaseUser{HomeDir: os.TempDir()}})
+	errCanceled := fmt.Errorf("list error: %w", context.Canceled)
+	err := conn.GetFsError(fs, errCanceled)
+	assert.NotErrorIs(t, err, ErrAbortedByClient)
+	assert.Equal(t, errCanceled, conn.getAbortedError(errCanceled))
+
+	conn.SignalClientDisconnected()
+	// signaling again has no effect
+	conn.SignalClientDisconnected()
+	err = conn.GetFsError(fs, errCanceled)
+	assert.ErrorIs(t, err, sftp.ErrSSHFxFailure)
+	assert.ErrorIs(t, err, ErrAbortedByClient)
+	assert.Equal(t, ErrAbortedByClient, conn.getAbortedError(errCanceled))
+	// other errors are not affected
+	err = conn.GetFsError(fs, os.ErrNotExist)
+	assert.ErrorIs(t, err, sftp.ErrSSHFxNoSuchFile)
+	assert.Equal(t, os.ErrClosed, conn.getAbortedError(os.ErrClosed))
+	conn.SetProtocol(ProtocolWebDAV)
+	err = conn.GetFsError(fs, errCanceled)
+	assert.Equal(t, ErrAbortedByClient, err)
+}
+
 func TestMaxWriteSize(t *testing.T) {
 	permissions := make(map[string][]string)
 	permissions["/"] = []string{dataprovider.PermAny}
@@ -470,42 +537,105 @@ func TestMaxWriteSize(t *testing.T) {
 	quotaResult := vfs.QuotaCheckResult{
 		HasSpace: true,
 	}
-	size, err := conn.GetMaxWriteSize(quotaResult, false, 0, fs.IsUploadResumeSupported())
+	size, err := conn.GetMaxWriteSize(quotaResult, false, 0, fs.IsUploadResumeSupported(), "/file.txt")
 	assert.NoError(t, err)
 	assert.Equal(t, int64(0), size)
 
 	conn.User.Filters.MaxUploadFileSize = 100
-	size, err = conn.GetMaxWriteSize(quotaResult, false, 0, fs.IsUploadResumeSupported())
+	size, err = conn.GetMaxWriteSize(quotaResult, false, 0, fs.IsUploadResumeSupported(), "/file.txt")
 	assert.NoError(t, err)
 	assert.Equal(t, int64(100), size)
 
 	quotaResult.QuotaSize = 1000
-	size, err = conn.GetMaxWriteSize(quotaResult, false, 50, fs.IsUploadResumeSupported())
+	size, err = conn.GetMaxWriteSize(quotaResult, false, 50, fs.IsUploadResumeSupported(), "/file.txt")
 	assert.NoError(t, err)
 	assert.Equal(t, int64(100), size)
 
 	quotaResult.QuotaSize = 1000
 	quotaResult.UsedSize = 990
-	size, err = conn.GetMaxWriteSize(quotaResult, false, 50, fs.IsUploadResumeSupported())
+	size, err = conn.GetMaxWriteSize(quotaResult, false, 50, fs.IsUploadResumeSupported(), "/file.txt")
 	assert.NoError(t, err)
 	assert.Equal(t, int64(60), size)
 
 	quotaResult.QuotaSize = 0
 	quotaResult.UsedSize = 0
-	size, err = conn.GetMaxWriteSize(quotaResult, true, 100, fs.IsUploadResumeSupported())
+	size, err = conn.GetMaxWriteSize(quotaResult, true, 100, fs.IsUploadResumeSupported(), "/file.txt")
 	assert.True(t, conn.IsQuotaExceededError(err))
 	assert.Equal(t, int64(0), size)
 
-	size, err = conn.GetMaxWriteSize(quotaResult, true, 10, fs.IsUploadResumeSupported())
+	size, err = conn.GetMaxWriteSize(quotaResult, true, 10, fs.IsUploadResumeSupported(), "/file.txt")
 	assert.NoError(t, err)
 	assert.Equal(t, int64(90), size)
 
 	fs = newMockOsFs(true, fs.ConnectionID(), user.GetHomeDir(), "", nil)
-	size, err = conn.GetMaxWriteSize(quotaResult, true, 100, fs.IsUploadResumeSupported())
+	size, err = conn.GetMaxWriteSize(quotaResult, true, 100, fs.IsUploadResumeSupported(), "/file.txt")
 	assert.EqualError(t, err, ErrOpUnsupported.Error())
 	assert.Equal(t, int64(0), size)
 }
 
+func TestMaxUploadFileSizePrecedence(t *testing.T) {
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: userTestUsername,
+			HomeDir:  filepath.Clean(os.TempDir()),
+		},
+		VirtualFolders: []vfs.VirtualFolder{
+			{
+				BaseVirtualFolder: vfs.BaseVirtualFolder{
+					Name:              "vfolder",
+					MappedPath:        filepath.Join(os.TempDir(), "vfolder"),
+					MaxUploadFileSize: 1000,
+				},
+				VirtualPath: "/vdir",
+			},
+			{
+				BaseVirtualFolder: vfs.BaseVirtualFolder{
+					Name:       "vfolder1",
+					MappedPath: filepath.Join(os.TempDir(), "vfolder1"),
+				},
+				VirtualPath: "/vdir1",
+			},
+		},
+	}
+	user.Filters.MaxUploadFileSize = 100
+	user.Filters.UploadSizeLimits = []dataprovider.UploadSizeLimit{
+		{
+			Pattern: "*.iso",
+			MaxSize: 10,
+		},
+		{
+			Pattern: "/vdir/sub/*",
+			MaxSize: 20,
+		},
+	}
+	conn := NewBaseConnection("", ProtocolSFTP, "", "", user)
+	quotaResult := vfs.QuotaCheckResult{
+		HasSpace: true,
+	}
+	for virtualPath, expected := range map[string]int64{
+		"/file.txt":              100,
+		"/vdir1/file.txt":        100,
+		"/vdir/file.txt":         1000,
+		"/vdir/sub/dir/file.txt": 1000,
+		"/vdir/sub/file.txt":     20,
+		"/vdir/sub/file.iso":     10,
+		"/file.iso":              10,
+	} {
+		size, err := conn.GetMaxWriteSize(quotaResult, false, 0, true, virtualPath)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, size, virtualPath)
+	}
+	size, err := conn.GetMaxWriteSize(quotaResult, true, 400, true, "/vdir/file.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(600), size)
+	_, err = conn.GetMaxWriteSize(quotaResult, true, 400, true, "/file.txt")
+	assert.True(t, conn.IsQuotaExceededError(err))
+	conn.User.Filters.MaxUploadFileSize = 0
+	size, err = conn.GetMaxWriteSize(quotaResult, false, 0, true, "/file.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), size)
+}
+
 func TestCheckParentDirsErrors(t *testing.T) {
 	permissions := make(map[string][]string)
 	permissions["/"] = []string{dataprovider.PermAny}
@@ -204,6 +204,9 @@ func (c *RetentionCheck) updateUserPermissions() {
 	for k := range c.conn.User.Permissions {
 		c.conn.User.Permissions[k] = []string{dataprovider.PermAny}
 	}
+	for idx := range c.conn.User.VirtualFolders {
+		c.conn.User.VirtualFolders[idx].Permissions = nil
+	}
 }
 
 func (c *RetentionCheck) getFolderRetention(folderPath string) (dataprovider.FolderRetention, error) {
@@ -53,6 +53,7 @@ type Defender interface {
 	GetBanTime(ip string) (*time.Time, error)
 	GetScore(ip string) (int, error)
 	DeleteHost(ip string) bool
+	ImportHosts(hosts []dataprovider.DefenderEntry, replace bool, protocol string) error
 	DelayLogin(err error)
 }
 
@@ -174,6 +175,23 @@ func (d *baseDefender) logBan(ip, protocol string) {
 		Send()
 }
 
+// notifyBans logs the specified bans and notifies the event manager, as for
+// the bans triggered by the host score
+func (d *baseDefender) notifyBans(hosts []dataprovider.DefenderEntry, protocol string) {
+	for idx := range hosts {
+		if hosts[idx].BanTime.IsZero() {
+			continue
+		}
+		d.logBan(hosts[idx].IP, protocol)
+		eventManager.handleIPBlockedEvent(EventParams{
+			Event:     ipBlockedEventName,
+			IP:        hosts[idx].IP,
+			Timestamp: time.Now(),
+			Status:    1,
+		})
+	}
+}
+
 // DelayLogin applies the configured login delay.
 func (d *baseDefender) DelayLogin(err error) {
 	if err == nil {
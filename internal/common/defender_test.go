@@ -26,6 +26,7 @@ import (
 	"github.com/yl2chen/cidranger"
 
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/util"
 )
 
 func TestBasicDefender(t *testing.T) {
@@ -641,3 +642,197 @@ func inc(ip net.IP) {
 		}
 	}
 }
+
+func TestDefenderImportExport(t *testing.T) {
+	_, err := ExportDefender(true)
+	assert.ErrorIs(t, err, util.ErrMethodDisabled)
+	err = ImportDefender(&DefenderExport{}, &DefenderImportOptions{})
+	assert.ErrorIs(t, err, util.ErrMethodDisabled)
+	err = BanDefenderNetworks([]string{"192.168.1.0/30"}, 10, ProtocolHTTP)
+	assert.ErrorIs(t, err, util.ErrMethodDisabled)
+
+	config := DefenderConfig{
+		Enabled:            true,
+		Driver:             DefenderDriverMemory,
+		BanTime:            10,
+		BanTimeIncrement:   50,
+		Threshold:          10,
+		ScoreInvalid:       2,
+		ScoreValid:         1,
+		ScoreLimitExceeded: 3,
+		ObservationTime:    15,
+		EntriesSoftLimit:   10,
+		EntriesHardLimit:   20,
+	}
+	d, err := newInMemoryDefender(&config)
+	require.NoError(t, err)
+	oldDefender := Config.defender
+	oldDefenderConfig := Config.DefenderConfig
+	Config.defender = d
+	Config.DefenderConfig = config
+	t.Cleanup(func() {
+		Config.defender = oldDefender
+		Config.DefenderConfig = oldDefenderConfig
+	})
+	defender := d.(*memoryDefender)
+	safeEntry := dataprovider.IPListEntry{
+		IPOrNet: "172.16.20.0/24",
+		Type:    dataprovider.IPListTypeDefender,
+		Mode:    dataprovider.ListModeAllow,
+	}
+	err = dataprovider.AddIPListEntry(&safeEntry, "", "", "")
+	require.NoError(t, err)
+	assert.False(t, defender.AddEvent("172.16.10.1", ProtocolSSH, HostEventUserNotFound))
+
+	data, err := ExportDefender(true)
+	require.NoError(t, err)
+	require.Len(t, data.Hosts, 1)
+	assert.Equal(t, "172.16.10.1", data.Hosts[0].IP)
+	assert.Equal(t, 2, data.Hosts[0].Score)
+	require.Len(t, data.SafeList, 1)
+	assert.Equal(t, safeEntry.IPOrNet, data.SafeList[0].IPOrNet)
+	data, err = ExportDefender(false)
+	require.NoError(t, err)
+	assert.Len(t, data.Hosts, 1)
+	assert.Nil(t, data.SafeList)
+
+	banTime := time.Now().Add(1 * time.Hour)
+	for _, invalid := range []DefenderExport{
+		{Hosts: []dataprovider.DefenderEntry{{IP: "invalid"}}},
+		{Hosts: []dataprovider.DefenderEntry{{IP: "172.16.10.2", Score: -1}}},
+		{Hosts: []dataprovider.DefenderEntry{{IP: "172.16.10.2"}}},
+		{Hosts: []dataprovider.DefenderEntry{{IP: "172.16.10.2", Score: 10}}},
+		{Hosts: []dataprovider.DefenderEntry{{IP: "172.16.10.2", Score: 1, BanTime: banTime}}},
+		{Hosts: []dataprovider.DefenderEntry{{IP: "172.16.10.2", Score: 1}, {IP: "172.16.10.2", Score: 2}}},
+		{Hosts: []dataprovider.DefenderEntry{{IP: "172.16.30.2", BanTime: banTime}},
+			SafeList: []dataprovider.IPListEntry{{IPOrNet: "172.16.30.0/24"}}},
+		{SafeList: []dataprovider.IPListEntry{{IPOrNet: "172.16.30.0/24"}, {IPOrNet: "172.16.30.1"}}},
+		{SafeList: []dataprovider.IPListEntry{{IPOrNet: "172.16.30.0/24", Mode: dataprovider.ListModeDeny}}},
+		{SafeList: []dataprovider.IPListEntry{{IPOrNet: "172.16.30.0/24", Type: dataprovider.IPListTypeAllowList}}},
+		{SafeList: []dataprovider.IPListEntry{{IPOrNet: "172.16.30.0/33"}}},
+	} {
+		err = ImportDefender(&invalid, &DefenderImportOptions{})
+		assert.ErrorIs(t, err, util.ErrValidation, "%+v", invalid)
+	}
+	tooMany := DefenderExport{}
+	for idx := 0; idx <= config.EntriesHardLimit; idx++ {
+		tooMany.Hosts = append(tooMany.Hosts, dataprovider.DefenderEntry{
+			IP:    fmt.Sprintf("10.10.10.%d", idx),
+			Score: 1,
+		})
+	}
+	err = ImportDefender(&tooMany, &DefenderImportOptions{})
+	assert.ErrorIs(t, err, util.ErrValidation)
+	// nothing was applied
+	hosts, err := defender.GetHosts()
+	require.NoError(t, err)
+	assert.Len(t, hosts, 1)
+	// hosts in the current safe list are skipped
+	for _, opts := range []DefenderImportOptions{{HostsOnly: true}, {}} {
+		err = ImportDefender(&DefenderExport{
+			Hosts: []dataprovider.DefenderEntry{{IP: "172.16.20.5", BanTime: banTime}},
+		}, &opts)
+		require.NoError(t, err)
+		assert.False(t, defender.IsBanned("172.16.20.5", ProtocolSSH))
+		_, err = defender.GetHost("172.16.20.5")
+		assert.ErrorIs(t, err, util.ErrNotFound)
+	}
+
+	err = ImportDefender(&DefenderExport{
+		Hosts: []dataprovider.DefenderEntry{
+			{IP: "172.16.10.2", BanTime: banTime},
+			{IP: "172.16.10.3", Score: 3},
+			{IP: "172.16.10.4", BanTime: time.Now().Add(-1 * time.Minute)},
+		},
+		SafeList: []dataprovider.IPListEntry{{IPOrNet: "172.16.40.1"}},
+	}, &DefenderImportOptions{Protocol: ProtocolHTTP})
+	require.NoError(t, err)
+	assert.True(t, defender.IsBanned("172.16.10.2", ProtocolSSH))
+	score, err := defender.GetScore("172.16.10.3")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, score)
+	score, err = defender.GetScore("172.16.10.1")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, score)
+	_, err = defender.GetHost("172.16.10.4")
+	assert.ErrorIs(t, err, util.ErrNotFound)
+	assert.True(t, defender.IsSafe("172.16.40.1", ProtocolSSH))
+	assert.True(t, defender.IsSafe("172.16.20.1", ProtocolSSH))
+
+	err = ImportDefender(&DefenderExport{
+		Hosts: []dataprovider.DefenderEntry{
+			{IP: "172.16.10.5", BanTime: banTime},
+		},
+		SafeList: []dataprovider.IPListEntry{{IPOrNet: "172.16.40.1/32", Description: "updated"}},
+	}, &DefenderImportOptions{Replace: true, Protocol: ProtocolHTTP})
+	require.NoError(t, err)
+	hosts, err = defender.GetHosts()
+	require.NoError(t, err)
+	require.Len(t, hosts, 1)
+	assert.Equal(t, "172.16.10.5", hosts[0].IP)
+	assert.False(t, defender.IsSafe("172.16.20.1", ProtocolSSH))
+	entry, err := dataprovider.IPListEntryExists("172.16.40.1/32", dataprovider.IPListTypeDefender)
+	require.NoError(t, err)
+	assert.Equal(t, "updated", entry.Description)
+	// in hosts only mode the safe list is never modified
+	err = ImportDefender(&DefenderExport{
+		SafeList: []dataprovider.IPListEntry{{IPOrNet: "172.16.50.1"}},
+	}, &DefenderImportOptions{HostsOnly: true, Protocol: ProtocolHTTP})
+	assert.ErrorIs(t, err, util.ErrValidation)
+	err = ImportDefender(&DefenderExport{
+		Hosts: []dataprovider.DefenderEntry{
+			{IP: "172.16.10.6", BanTime: banTime},
+		},
+	}, &DefenderImportOptions{Replace: true, HostsOnly: true, Protocol: ProtocolHTTP})
+	require.NoError(t, err)
+	assert.True(t, defender.IsBanned("172.16.10.6", ProtocolSSH))
+	assert.False(t, defender.IsBanned("172.16.10.5", ProtocolSSH))
+	assert.True(t, defender.IsSafe("172.16.40.1", ProtocolSSH))
+
+	for _, networks := range [][]string{
+		nil,
+		{"invalid"},
+		{"10.1.1.0/24"},
+		{"10.1.1.0/30", "10.1.1.2"},
+		{"2001:db8::/64"},
+	} {
+		err = BanDefenderNetworks(networks, 10, ProtocolHTTP)
+		assert.ErrorIs(t, err, util.ErrValidation, "%v", networks)
+	}
+	err = BanDefenderNetworks([]string{"10.1.1.0/30"}, 0, ProtocolHTTP)
+	assert.ErrorIs(t, err, util.ErrValidation)
+	err = BanDefenderNetworks([]string{"10.1.1.0/30", "::ffff:10.1.2.1", "172.16.40.1"}, 10, ProtocolHTTP)
+	require.NoError(t, err)
+	for _, ip := range []string{"10.1.1.0", "10.1.1.1", "10.1.1.2", "10.1.1.3", "10.1.2.1"} {
+		assert.True(t, defender.IsBanned(ip, ProtocolSSH), ip)
+	}
+	assert.False(t, defender.IsBanned("10.1.1.4", ProtocolSSH))
+	// hosts in the safe list are not banned
+	banTimePtr, err := defender.GetBanTime("172.16.40.1")
+	assert.NoError(t, err)
+	assert.Nil(t, banTimePtr)
+
+	err = dataprovider.DeleteIPListEntry("172.16.40.1/32", dataprovider.IPListTypeDefender, "", "", "")
+	assert.NoError(t, err)
+	_, err = dataprovider.IPListEntryExists(safeEntry.IPOrNet, dataprovider.IPListTypeDefender)
+	assert.ErrorIs(t, err, util.ErrNotFound)
+}
+
+func TestParseDefenderNetwork(t *testing.T) {
+	prefix, err := parseDefenderNetwork("192.168.1.1")
+	assert.NoError(t, err)
+	assert.Equal(t, "192.168.1.1/32", prefix.String())
+	prefix, err = parseDefenderNetwork("192.168.1.1/24")
+	assert.NoError(t, err)
+	assert.Equal(t, "192.168.1.0/24", prefix.String())
+	prefix, err = parseDefenderNetwork("::ffff:192.168.1.1/120")
+	assert.NoError(t, err)
+	assert.Equal(t, "192.168.1.0/24", prefix.String())
+	prefix, err = parseDefenderNetwork("2001:db8::1")
+	assert.NoError(t, err)
+	assert.Equal(t, "2001:db8::1/128", prefix.String())
+	_, err = parseDefenderNetwork("::ffff:192.168.1.1/64")
+	assert.Error(t, err)
+	_, err = parseDefenderNetwork("invalid/24")
+	assert.Error(t, err)
+}
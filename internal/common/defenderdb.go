@@ -20,6 +20,7 @@ import (
 
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/metric"
 	"github.com/drakkan/sftpgo/v2/internal/util"
 )
 
@@ -84,7 +85,23 @@ func (d *dbDefender) DeleteHost(ip string) bool {
 	if _, err := d.GetHost(ip); err != nil {
 		return false
 	}
-	return dataprovider.DeleteDefenderHost(ip) == nil
+	if err := dataprovider.DeleteDefenderHost(ip); err != nil {
+		return false
+	}
+	d.updateBannedHostsMetric()
+	return true
+}
+
+// ImportHosts adds the specified, already validated, hosts in a single
+// transaction. Hosts with a ban time are banned, the score of the other ones
+// is added as a new event. If replace is true the existing hosts are removed
+func (d *dbDefender) ImportHosts(hosts []dataprovider.DefenderEntry, replace bool, protocol string) error {
+	if err := dataprovider.ImportDefenderHosts(hosts, replace); err != nil {
+		return err
+	}
+	d.updateBannedHostsMetric()
+	d.notifyBans(hosts, protocol)
+	return nil
 }
 
 // AddEvent adds an event for the given IP.
@@ -107,6 +124,7 @@ func (d *dbDefender) AddEvent(ip, protocol string, event HostEvent) bool {
 		banTime := time.Now().Add(time.Duration(d.config.BanTime) * time.Minute)
 		err = dataprovider.SetDefenderBanTime(ip, util.GetTimeAsMsSinceEpoch(banTime))
 		if err == nil {
+			d.updateBannedHostsMetric()
 			eventManager.handleIPBlockedEvent(EventParams{
 				Event:     ipBlockedEventName,
 				IP:        ip,
@@ -155,8 +173,26 @@ func (d *dbDefender) cleanup() {
 		if err := dataprovider.CleanupDefender(util.GetTimeAsMsSinceEpoch(expireTime)); err != nil {
 			logger.Error(logSender, "", "defender cleanup error, reset last cleanup to %v", lastCleanup)
 			d.setLastCleanup(lastCleanup)
+			return
+		}
+		d.updateBannedHostsMetric()
+	}
+}
+
+// updateBannedHostsMetric sets the banned hosts metric. The hosts are shared
+// between SFTPGo instances so they are counted from the data provider
+func (d *dbDefender) updateBannedHostsMetric() {
+	hosts, err := d.GetHosts()
+	if err != nil {
+		return
+	}
+	banned := 0
+	for idx := range hosts {
+		if hosts[idx].BanTime.After(time.Now()) {
+			banned++
 		}
 	}
+	metric.UpdateDefenderBannedHosts(banned)
 }
 
 func (d *dbDefender) getStartObservationTime() int64 {
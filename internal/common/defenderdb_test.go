@@ -20,6 +20,7 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
 	"github.com/drakkan/sftpgo/v2/internal/util"
@@ -307,6 +308,52 @@ func TestDbDefenderCleanup(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestDbDefenderImportHosts(t *testing.T) {
+	if !isDbDefenderSupported() {
+		t.Skip("this test is not supported with the current database provider")
+	}
+	config := &DefenderConfig{
+		Enabled:            true,
+		BanTime:            10,
+		BanTimeIncrement:   2,
+		Threshold:          5,
+		ScoreInvalid:       2,
+		ScoreValid:         1,
+		ScoreLimitExceeded: 3,
+		ObservationTime:    15,
+		EntriesSoftLimit:   1,
+		EntriesHardLimit:   10,
+	}
+	d, err := newDBDefender(config)
+	require.NoError(t, err)
+	defender := d.(*dbDefender)
+	assert.False(t, defender.AddEvent("172.16.50.1", ProtocolSSH, HostEventUserNotFound))
+
+	banTime := time.Now().Add(10 * time.Minute)
+	err = defender.ImportHosts([]dataprovider.DefenderEntry{
+		{IP: "172.16.50.2", BanTime: banTime},
+		{IP: "172.16.50.3", Score: 3},
+	}, false, ProtocolHTTP)
+	require.NoError(t, err)
+	assert.True(t, defender.IsBanned("172.16.50.2", ProtocolSSH))
+	score, err := defender.GetScore("172.16.50.3")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, score)
+	score, err = defender.GetScore("172.16.50.1")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, score)
+
+	err = defender.ImportHosts([]dataprovider.DefenderEntry{
+		{IP: "172.16.50.4", BanTime: banTime},
+	}, true, ProtocolHTTP)
+	require.NoError(t, err)
+	hosts, err := defender.GetHosts()
+	require.NoError(t, err)
+	require.Len(t, hosts, 1)
+	assert.Equal(t, "172.16.50.4", hosts[0].IP)
+	assert.True(t, defender.DeleteHost("172.16.50.4"))
+}
+
 func isDbDefenderSupported() bool {
 	// SQLite shares the implementation with other SQL-based provider but it makes no sense
 	// to use it outside test cases
@@ -0,0 +1,398 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	// maximum ban time, in minutes, for the bans added using BanDefenderNetworks
+	maxDefenderBanMinutes = 525600
+	// page size used to read the defender safe list from the data provider
+	defenderSafeListPageSize = 500
+)
+
+var errDefenderDisabled = util.NewMethodDisabledError("defender is disabled")
+
+// DefenderExport defines the defender state that can be exported and imported
+type DefenderExport struct {
+	// Hosts that are banned or for which some violations have been detected.
+	// Banned hosts have a ban time, the other ones a score
+	Hosts []dataprovider.DefenderEntry `json:"hosts"`
+	// SafeList defines the defender IP list entries in allow mode, the hosts
+	// matching these entries are never banned
+	SafeList []dataprovider.IPListEntry `json:"safe_list,omitempty"`
+}
+
+// DefenderImportOptions defines the options for a defender import
+type DefenderImportOptions struct {
+	// If true the existing hosts and safe list entries are replaced with the
+	// imported ones, otherwise the imported ones are merged with the existing state
+	Replace bool
+	// If true only the hosts are imported and the safe list is left untouched,
+	// also in replace mode. The import fails if safe list entries are included
+	HostsOnly bool
+	// Protocol to use to log the imported bans
+	Protocol string
+	// Executor, IPAddress and Role identify who requested the import, they are
+	// used for the safe list changes
+	Executor  string
+	IPAddress string
+	Role      string
+}
+
+// ExportDefender returns the defender hosts and, if includeSafeList is true,
+// the safe list
+func ExportDefender(includeSafeList bool) (DefenderExport, error) {
+	if Config.defender == nil {
+		return DefenderExport{}, errDefenderDisabled
+	}
+	hosts, err := Config.defender.GetHosts()
+	if err != nil {
+		return DefenderExport{}, err
+	}
+	if hosts == nil {
+		hosts = make([]dataprovider.DefenderEntry, 0)
+	}
+	data := DefenderExport{
+		Hosts: hosts,
+	}
+	if includeSafeList {
+		data.SafeList, err = getDefenderSafeList()
+		if err != nil {
+			return DefenderExport{}, err
+		}
+	}
+	return data, nil
+}
+
+// ImportDefender imports the specified defender hosts and safe list.
+// The whole document is validated before applying any change and the safe
+// list changes are reverted if the hosts cannot be imported. The imported bans
+// are logged and notified as the bans triggered by the host score
+func ImportDefender(data *DefenderExport, opts *DefenderImportOptions) error {
+	if Config.defender == nil {
+		return errDefenderDisabled
+	}
+	if opts.HostsOnly && len(data.SafeList) > 0 {
+		return util.NewValidationError("safe list entries cannot be imported in hosts only mode")
+	}
+	safeNetworks, err := validateDefenderSafeList(data.SafeList)
+	if err != nil {
+		return err
+	}
+	hosts, err := validateDefenderImportHosts(data.Hosts, safeNetworks, opts)
+	if err != nil {
+		return err
+	}
+	undo := func() {}
+	if !opts.HostsOnly {
+		undo, err = importDefenderSafeList(data.SafeList, opts)
+		if err != nil {
+			return err
+		}
+	}
+	if err := Config.defender.ImportHosts(hosts, opts.Replace, opts.Protocol); err != nil {
+		logger.Warn(logSender, "", "unable to import defender hosts, reverting the safe list changes: %v", err)
+		undo()
+		return err
+	}
+	logger.Info(logSender, "", "defender state imported by %q, hosts: %d, safe list entries: %d, replace: %t",
+		opts.Executor, len(hosts), len(data.SafeList), opts.Replace)
+	return nil
+}
+
+// BanDefenderNetworks bans all the IP addresses included in the specified
+// networks, or single IP addresses, for the given minutes. The addresses in
+// the defender safe list are skipped
+func BanDefenderNetworks(networks []string, minutes int, protocol string) error {
+	if Config.defender == nil {
+		return errDefenderDisabled
+	}
+	if minutes <= 0 || minutes > maxDefenderBanMinutes {
+		return util.NewValidationError(fmt.Sprintf("invalid ban time %d, it must be between 1 and %d minutes",
+			minutes, maxDefenderBanMinutes))
+	}
+	if len(networks) == 0 {
+		return util.NewValidationError("no network to ban")
+	}
+	prefixes := make([]netip.Prefix, 0, len(networks))
+	numAddresses := 0
+	for _, network := range networks {
+		prefix, err := parseDefenderNetwork(network)
+		if err != nil {
+			return err
+		}
+		if err := checkDefenderNetworkOverlaps(prefix, prefixes); err != nil {
+			return err
+		}
+		hostBits := prefix.Addr().BitLen() - prefix.Bits()
+		if hostBits > 30 || numAddresses+(1<<hostBits) > Config.DefenderConfig.EntriesHardLimit {
+			return util.NewValidationError(fmt.Sprintf("too many addresses to ban, the limit is %d",
+				Config.DefenderConfig.EntriesHardLimit))
+		}
+		numAddresses += 1 << hostBits
+		prefixes = append(prefixes, prefix)
+	}
+	banTime := time.Now().Add(time.Duration(minutes) * time.Minute)
+	hosts := make([]dataprovider.DefenderEntry, 0, numAddresses)
+	for _, prefix := range prefixes {
+		for addr := prefix.Addr(); prefix.Contains(addr); addr = addr.Next() {
+			ip := addr.String()
+			if Config.defender.IsSafe(ip, protocol) {
+				logger.Debug(logSender, "", "skip ban for ip %q, it is in the defender safe list", ip)
+				continue
+			}
+			hosts = append(hosts, dataprovider.DefenderEntry{
+				IP:      ip,
+				BanTime: banTime,
+			})
+		}
+	}
+	return Config.defender.ImportHosts(hosts, false, protocol)
+}
+
+func getDefenderSafeList() ([]dataprovider.IPListEntry, error) {
+	result := make([]dataprovider.IPListEntry, 0)
+	from := ""
+	for {
+		entries, err := dataprovider.GetIPListEntries(dataprovider.IPListTypeDefender, "", from,
+			dataprovider.OrderASC, defenderSafeListPageSize)
+		if err != nil {
+			return nil, err
+		}
+		for idx := range entries {
+			if entries[idx].Mode == dataprovider.ListModeAllow {
+				entries[idx].PrepareForRendering()
+				result = append(result, entries[idx])
+			}
+		}
+		if len(entries) < defenderSafeListPageSize {
+			return result, nil
+		}
+		from = entries[len(entries)-1].IPOrNet
+	}
+}
+
+// parseDefenderNetwork parses the given network, or single IP address, and
+// returns the masked prefix
+func parseDefenderNetwork(network string) (netip.Prefix, error) {
+	if !strings.Contains(network, "/") {
+		addr, err := netip.ParseAddr(network)
+		if err != nil {
+			return netip.Prefix{}, util.NewValidationError(fmt.Sprintf("invalid IP %q", network))
+		}
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(network)
+	if err != nil {
+		return netip.Prefix{}, util.NewValidationError(fmt.Sprintf("invalid network %q: %v", network, err))
+	}
+	if prefix.Addr().Is4In6() {
+		if prefix.Bits() < 96 {
+			return netip.Prefix{}, util.NewValidationError(fmt.Sprintf("invalid network %q", network))
+		}
+		prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+	}
+	return prefix.Masked(), nil
+}
+
+func checkDefenderNetworkOverlaps(prefix netip.Prefix, prefixes []netip.Prefix) error {
+	for _, p := range prefixes {
+		if p.Overlaps(prefix) {
+			return util.NewValidationError(fmt.Sprintf("network %q overlaps with %q", prefix, p))
+		}
+	}
+	return nil
+}
+
+// validateDefenderSafeList validates and normalizes the given safe list entries
+// and returns the matching networks
+func validateDefenderSafeList(entries []dataprovider.IPListEntry) ([]netip.Prefix, error) {
+	if len(entries) > Config.DefenderConfig.EntriesHardLimit {
+		return nil, util.NewValidationError(fmt.Sprintf("too many safe list entries, the limit is %d",
+			Config.DefenderConfig.EntriesHardLimit))
+	}
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for idx := range entries {
+		entry := &entries[idx]
+		if entry.Type == 0 {
+			entry.Type = dataprovider.IPListTypeDefender
+		}
+		if entry.Mode == 0 {
+			entry.Mode = dataprovider.ListModeAllow
+		}
+		if entry.Type != dataprovider.IPListTypeDefender || entry.Mode != dataprovider.ListModeAllow {
+			return nil, util.NewValidationError(fmt.Sprintf("safe list entry %q must be a defender entry in allow mode",
+				entry.IPOrNet))
+		}
+		prefix, err := parseDefenderNetwork(entry.IPOrNet)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkDefenderNetworkOverlaps(prefix, prefixes); err != nil {
+			return nil, err
+		}
+		entry.IPOrNet = prefix.String()
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
+}
+
+// validateDefenderImportHosts validates and normalizes the hosts to import.
+// Expired bans are skipped. Hosts included in the imported safe list are
+// rejected, the ones included in the current safe list are skipped unless it
+// is replaced by the imported one
+func validateDefenderImportHosts(hosts []dataprovider.DefenderEntry, safeNetworks []netip.Prefix,
+	opts *DefenderImportOptions,
+) ([]dataprovider.DefenderEntry, error) {
+	if len(hosts) > Config.DefenderConfig.EntriesHardLimit {
+		return nil, util.NewValidationError(fmt.Sprintf("too many hosts, the limit is %d",
+			Config.DefenderConfig.EntriesHardLimit))
+	}
+	result := make([]dataprovider.DefenderEntry, 0, len(hosts))
+	ips := make(map[string]bool)
+	for _, host := range hosts {
+		addr, err := netip.ParseAddr(host.IP)
+		if err != nil {
+			return nil, util.NewValidationError(fmt.Sprintf("invalid host IP %q", host.IP))
+		}
+		ip := addr.Unmap().String()
+		if ips[ip] {
+			return nil, util.NewValidationError(fmt.Sprintf("duplicated host %q", ip))
+		}
+		ips[ip] = true
+		if err := validateDefenderImportHost(&host); err != nil {
+			return nil, err
+		}
+		for _, prefix := range safeNetworks {
+			if prefix.Contains(addr.Unmap()) {
+				return nil, util.NewValidationError(fmt.Sprintf("host %q is included in the safe list entry %q",
+					ip, prefix))
+			}
+		}
+		if !host.BanTime.IsZero() && host.BanTime.Before(time.Now()) {
+			logger.Debug(logSender, "", "skip import for host %q, the ban is expired", ip)
+			continue
+		}
+		if (opts.HostsOnly || !opts.Replace) && Config.defender.IsSafe(ip, opts.Protocol) {
+			logger.Debug(logSender, "", "skip import for host %q, it is in the defender safe list", ip)
+			continue
+		}
+		result = append(result, dataprovider.DefenderEntry{
+			IP:      ip,
+			Score:   host.Score,
+			BanTime: host.BanTime,
+		})
+	}
+	return result, nil
+}
+
+func validateDefenderImportHost(host *dataprovider.DefenderEntry) error {
+	if host.Score < 0 {
+		return util.NewValidationError(fmt.Sprintf("invalid score %d for host %q", host.Score, host.IP))
+	}
+	if host.BanTime.IsZero() {
+		if host.Score == 0 {
+			return util.NewValidationError(fmt.Sprintf("host %q has neither a score nor a ban time", host.IP))
+		}
+		if host.Score >= Config.DefenderConfig.Threshold {
+			return util.NewValidationError(fmt.Sprintf("score %d for host %q must be lower than the threshold %d, set a ban time to ban the host",
+				host.Score, host.IP, Config.DefenderConfig.Threshold))
+		}
+		return nil
+	}
+	if host.Score > 0 {
+		return util.NewValidationError(fmt.Sprintf("host %q cannot have both a score and a ban time", host.IP))
+	}
+	return nil
+}
+
+// importDefenderSafeList adds, updates and, in replace mode, removes the safe
+// list entries. The returned function reverts the applied changes
+func importDefenderSafeList(entries []dataprovider.IPListEntry, opts *DefenderImportOptions) (func(), error) {
+	var rollback []func() error
+	undo := func() {
+		for idx := len(rollback) - 1; idx >= 0; idx-- {
+			if err := rollback[idx](); err != nil {
+				logger.Error(logSender, "", "unable to revert a defender safe list change: %v", err)
+			}
+		}
+	}
+	imported := make(map[string]bool)
+	for idx := range entries {
+		entry := entries[idx]
+		imported[entry.IPOrNet] = true
+		existing, err := dataprovider.IPListEntryExists(entry.IPOrNet, dataprovider.IPListTypeDefender)
+		if err == nil {
+			if existing.Mode == entry.Mode && existing.Protocols == entry.Protocols &&
+				existing.Description == entry.Description {
+				continue
+			}
+			if err := dataprovider.UpdateIPListEntry(&entry, opts.Executor, opts.IPAddress, opts.Role); err != nil {
+				undo()
+				return nil, err
+			}
+			rollback = append(rollback, func() error {
+				return dataprovider.UpdateIPListEntry(&existing, opts.Executor, opts.IPAddress, opts.Role)
+			})
+			continue
+		}
+		if !errors.Is(err, util.ErrNotFound) {
+			undo()
+			return nil, err
+		}
+		if err := dataprovider.AddIPListEntry(&entry, opts.Executor, opts.IPAddress, opts.Role); err != nil {
+			undo()
+			return nil, err
+		}
+		rollback = append(rollback, func() error {
+			return dataprovider.DeleteIPListEntry(entry.IPOrNet, entry.Type, opts.Executor, opts.IPAddress, opts.Role)
+		})
+	}
+	if !opts.Replace {
+		return undo, nil
+	}
+	current, err := getDefenderSafeList()
+	if err != nil {
+		undo()
+		return nil, err
+	}
+	for idx := range current {
+		entry := current[idx]
+		if imported[entry.IPOrNet] {
+			continue
+		}
+		err := dataprovider.DeleteIPListEntry(entry.IPOrNet, entry.Type, opts.Executor, opts.IPAddress, opts.Role)
+		if err != nil {
+			undo()
+			return nil, err
+		}
+		rollback = append(rollback, func() error {
+			return dataprovider.AddIPListEntry(&entry, opts.Executor, opts.IPAddress, opts.Role)
+		})
+	}
+	return undo, nil
+}
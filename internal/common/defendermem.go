@@ -20,6 +20,7 @@ import (
 	"time"
 
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/metric"
 	"github.com/drakkan/sftpgo/v2/internal/util"
 )
 
@@ -158,6 +159,7 @@ func (d *memoryDefender) DeleteHost(ip string) bool {
 
 	if _, ok := d.banned[ip]; ok {
 		delete(d.banned, ip)
+		metric.UpdateDefenderBannedHosts(len(d.banned))
 		return true
 	}
 
@@ -169,6 +171,42 @@ func (d *memoryDefender) DeleteHost(ip string) bool {
 	return false
 }
 
+// ImportHosts adds the specified, already validated, hosts. Hosts with a ban
+// time are banned, the score of the other ones is added as a new event.
+// If replace is true the existing hosts are removed
+func (d *memoryDefender) ImportHosts(hosts []dataprovider.DefenderEntry, replace bool, protocol string) error {
+	d.Lock()
+
+	if replace {
+		d.hosts = make(map[string]hostScore)
+		d.banned = make(map[string]time.Time)
+	}
+	now := time.Now()
+	for _, host := range hosts {
+		if !host.BanTime.IsZero() {
+			delete(d.hosts, host.IP)
+			d.banned[host.IP] = host.BanTime
+			continue
+		}
+		delete(d.banned, host.IP)
+		hs := d.hosts[host.IP]
+		hs.Events = append(hs.Events, hostEvent{
+			dateTime: now,
+			score:    host.Score,
+		})
+		hs.TotalScore += host.Score
+		d.hosts[host.IP] = hs
+	}
+	d.cleanupBanned()
+	d.cleanupHosts()
+	metric.UpdateDefenderBannedHosts(len(d.banned))
+
+	d.Unlock()
+
+	d.notifyBans(hosts, protocol)
+	return nil
+}
+
 // AddEvent adds an event for the given IP.
 // This method must be called for clients not yet banned.
 // Returns true if the IP is in the defender's safe list.
@@ -186,6 +224,7 @@ func (d *memoryDefender) AddEvent(ip, protocol string, event HostEvent) bool {
 			return false
 		}
 		delete(d.banned, ip)
+		metric.UpdateDefenderBannedHosts(len(d.banned))
 	}
 
 	score := d.getScore(event)
@@ -215,6 +254,7 @@ func (d *memoryDefender) AddEvent(ip, protocol string, event HostEvent) bool {
 			d.banned[ip] = time.Now().Add(time.Duration(d.config.BanTime) * time.Minute)
 			delete(d.hosts, ip)
 			d.cleanupBanned()
+			metric.UpdateDefenderBannedHosts(len(d.banned))
 			eventManager.handleIPBlockedEvent(EventParams{
 				Event:     ipBlockedEventName,
 				IP:        ip,
@@ -56,6 +56,7 @@ import (
 
 const (
 	ipBlockedEventName       = "IP Blocked"
+	loginFailuresEventName   = "Login failures threshold"
 	maxAttachmentsSize       = int64(10 * 1024 * 1024)
 	objDataPlaceholder       = "{{.ObjectData}}"
 	objDataPlaceholderString = "{{.ObjectDataString}}"
@@ -74,7 +75,7 @@ var (
 	multipartQuoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
 	fsEventsWithSize      = []string{operationPreDelete, OperationPreUpload, operationDelete,
 		operationCopy, operationDownload, operationFirstUpload, operationFirstDownload,
-		operationUpload}
+		operationUpload, operationTransferQuotaWarning, operationTransferQuotaExhausted, operationExtract}
 )
 
 func init() {
@@ -120,15 +121,17 @@ func HandleIDPLoginEvent(params EventParams, customFields *map[string]any) (*dat
 // eventRulesContainer stores event rules by trigger
 type eventRulesContainer struct {
 	sync.RWMutex
-	lastLoad          atomic.Int64
-	FsEvents          []dataprovider.EventRule
-	ProviderEvents    []dataprovider.EventRule
-	Schedules         []dataprovider.EventRule
-	IPBlockedEvents   []dataprovider.EventRule
-	CertificateEvents []dataprovider.EventRule
-	IPDLoginEvents    []dataprovider.EventRule
-	schedulesMapping  map[string][]cron.EntryID
-	concurrencyGuard  chan struct{}
+	lastLoad             atomic.Int64
+	FsEvents             []dataprovider.EventRule
+	ProviderEvents       []dataprovider.EventRule
+	Schedules            []dataprovider.EventRule
+	IPBlockedEvents      []dataprovider.EventRule
+	CertificateEvents    []dataprovider.EventRule
+	IPDLoginEvents       []dataprovider.EventRule
+	LoginFailuresEvents  []dataprovider.EventRule
+	AccountLockoutEvents []dataprovider.EventRule
+	schedulesMapping     map[string][]cron.EntryID
+	concurrencyGuard     chan struct{}
 }
 
 func (r *eventRulesContainer) addAsyncTask() {
@@ -205,6 +208,24 @@ func (r *eventRulesContainer) removeRuleInternal(name string) {
 			return
 		}
 	}
+	for idx := range r.LoginFailuresEvents {
+		if r.LoginFailuresEvents[idx].Name == name {
+			lastIdx := len(r.LoginFailuresEvents) - 1
+			r.LoginFailuresEvents[idx] = r.LoginFailuresEvents[lastIdx]
+			r.LoginFailuresEvents = r.LoginFailuresEvents[:lastIdx]
+			eventManagerLog(logger.LevelDebug, "removed rule %q from login failures events", name)
+			return
+		}
+	}
+	for idx := range r.AccountLockoutEvents {
+		if r.AccountLockoutEvents[idx].Name == name {
+			lastIdx := len(r.AccountLockoutEvents) - 1
+			r.AccountLockoutEvents[idx] = r.AccountLockoutEvents[lastIdx]
+			r.AccountLockoutEvents = r.AccountLockoutEvents[:lastIdx]
+			eventManagerLog(logger.LevelDebug, "removed rule %q from account lockout events", name)
+			return
+		}
+	}
 	for idx := range r.Schedules {
 		if r.Schedules[idx].Name == name {
 			if schedules, ok := r.schedulesMapping[name]; ok {
@@ -253,6 +274,12 @@ func (r *eventRulesContainer) addUpdateRuleInternal(rule dataprovider.EventRule)
 	case dataprovider.EventTriggerIDPLogin:
 		r.IPDLoginEvents = append(r.IPDLoginEvents, rule)
 		eventManagerLog(logger.LevelDebug, "added rule %q to IDP login events", rule.Name)
+	case dataprovider.EventTriggerLoginFailures:
+		r.LoginFailuresEvents = append(r.LoginFailuresEvents, rule)
+		eventManagerLog(logger.LevelDebug, "added rule %q to login failures events", rule.Name)
+	case dataprovider.EventTriggerAccountLockout:
+		r.AccountLockoutEvents = append(r.AccountLockoutEvents, rule)
+		eventManagerLog(logger.LevelDebug, "added rule %q to account lockout events", rule.Name)
 	case dataprovider.EventTriggerSchedule:
 		for _, schedule := range rule.Conditions.Schedules {
 			cronSpec := schedule.GetCronSpec()
@@ -294,8 +321,9 @@ func (r *eventRulesContainer) loadRules() {
 			r.addUpdateRuleInternal(rule)
 		}
 	}
-	eventManagerLog(logger.LevelDebug, "event rules updated, fs events: %d, provider events: %d, schedules: %d, ip blocked events: %d, certificate events: %d, IDP login events: %d",
-		len(r.FsEvents), len(r.ProviderEvents), len(r.Schedules), len(r.IPBlockedEvents), len(r.CertificateEvents), len(r.IPDLoginEvents))
+	eventManagerLog(logger.LevelDebug, "event rules updated, fs events: %d, provider events: %d, schedules: %d, ip blocked events: %d, certificate events: %d, IDP login events: %d, login failures events: %d, account lockout events: %d",
+		len(r.FsEvents), len(r.ProviderEvents), len(r.Schedules), len(r.IPBlockedEvents), len(r.CertificateEvents), len(r.IPDLoginEvents),
+		len(r.LoginFailuresEvents), len(r.AccountLockoutEvents))
 
 	r.setLastLoadTime(modTime)
 }
@@ -519,6 +547,50 @@ func (r *eventRulesContainer) handleIPBlockedEvent(params EventParams) {
 	}
 }
 
+func (r *eventRulesContainer) handleLoginFailuresEvent(params EventParams) {
+	r.RLock()
+	defer r.RUnlock()
+
+	if len(r.LoginFailuresEvents) == 0 {
+		return
+	}
+	var rules []dataprovider.EventRule
+	for _, rule := range r.LoginFailuresEvents {
+		if err := rule.CheckActionsConsistency(""); err == nil {
+			rules = append(rules, rule)
+		} else {
+			eventManagerLog(logger.LevelWarn, "rule %q skipped: %v, event %q",
+				rule.Name, err, params.Event)
+		}
+	}
+
+	if len(rules) > 0 {
+		go executeAsyncRulesActions(rules, params)
+	}
+}
+
+func (r *eventRulesContainer) handleAccountLockoutEvent(params EventParams) {
+	r.RLock()
+	defer r.RUnlock()
+
+	if len(r.AccountLockoutEvents) == 0 {
+		return
+	}
+	var rules []dataprovider.EventRule
+	for _, rule := range r.AccountLockoutEvents {
+		if err := rule.CheckActionsConsistency(""); err == nil {
+			rules = append(rules, rule)
+		} else {
+			eventManagerLog(logger.LevelWarn, "rule %q skipped: %v, event %q",
+				rule.Name, err, params.Event)
+		}
+	}
+
+	if len(rules) > 0 {
+		go executeAsyncRulesActions(rules, params)
+	}
+}
+
 func (r *eventRulesContainer) handleCertificateEvent(params EventParams) {
 	r.RLock()
 	defer r.RUnlock()
@@ -1656,6 +1728,9 @@ func getUserForEventAction(user dataprovider.User) (dataprovider.User, error) {
 	for k := range user.Permissions {
 		user.Permissions[k] = []string{dataprovider.PermAny}
 	}
+	for idx := range user.VirtualFolders {
+		user.VirtualFolders[idx].Permissions = nil
+	}
 	return user, nil
 }
 
@@ -2272,7 +2347,7 @@ func executeFoldersQuotaResetRuleAction(conditions dataprovider.ConditionOptions
 			failures = append(failures, folder.Name)
 			continue
 		}
-		err = dataprovider.UpdateVirtualFolderQuota(&folder, numFiles, size, true)
+		err = dataprovider.UpdateVirtualFolderQuotaScan(&folder, numFiles, size)
 		if err != nil {
 			eventManagerLog(logger.LevelError, "error updating quota for folder %q: %v", folder.Name, err)
 			params.AddError(fmt.Errorf("error updating quota for folder %q: %w", folder.Name, err))
@@ -2493,7 +2568,7 @@ func executePwdExpirationCheckForUser(user *dataprovider.User, config dataprovid
 			return nil
 		}
 	}
-	if user.Filters.PasswordExpiration == 0 {
+	if user.GetPasswordExpiration() == 0 {
 		eventManagerLog(logger.LevelDebug, "password expiration not set for user %q skipping check", user.Username)
 		return nil
 	}
@@ -15,6 +15,7 @@
 package common
 
 import (
+	"sync"
 	"time"
 
 	"github.com/robfig/cron/v3"
@@ -25,18 +26,33 @@ import (
 )
 
 var (
-	eventScheduler *cron.Cron
+	// eventSchedulerMu protects the eventScheduler pointer, it is replaced
+	// while the maintenance jobs status could be requested
+	eventSchedulerMu sync.RWMutex
+	eventScheduler   *cron.Cron
 )
 
+func getEventScheduler() *cron.Cron {
+	eventSchedulerMu.RLock()
+	defer eventSchedulerMu.RUnlock()
+
+	return eventScheduler
+}
+
 func stopEventScheduler() {
-	if eventScheduler != nil {
-		eventScheduler.Stop()
-		eventScheduler = nil
+	eventSchedulerMu.Lock()
+	scheduler := eventScheduler
+	eventScheduler = nil
+	eventSchedulerMu.Unlock()
+
+	if scheduler != nil {
+		scheduler.Stop()
 	}
 }
 
 func startEventScheduler() {
 	stopEventScheduler()
+	resetMaintenanceJobs()
 
 	options := []cron.Option{
 		cron.WithLogger(cron.DiscardLogger),
@@ -46,9 +62,13 @@ func startEventScheduler() {
 		options = append(options, cron.WithLocation(time.UTC))
 	}
 
-	eventScheduler = cron.New(options...)
+	scheduler := cron.New(options...)
+	eventSchedulerMu.Lock()
+	eventScheduler = scheduler
+	eventSchedulerMu.Unlock()
+
 	eventManager.loadRules()
-	_, err := eventScheduler.AddFunc("@every 10m", eventManager.loadRules)
+	_, err := scheduler.AddFunc("@every 10m", eventManager.loadRules)
 	util.PanicOnError(err)
-	eventScheduler.Start()
+	scheduler.Start()
 }
@@ -0,0 +1,205 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"errors"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/metric"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// Reasons for the denied connections reported in the event stream
+const (
+	ConnectionDenyReasonBanned          = "banned"
+	ConnectionDenyReasonLimit           = "connection_limit"
+	ConnectionDenyReasonRateLimited     = "rate_limited"
+	ConnectionDenyReasonPostConnectHook = "post_connect_hook"
+	ConnectionDenyReasonProxyHeader     = "proxy_header_missing"
+)
+
+// Error classes for the failed authentication attempts reported in the event stream
+const (
+	authErrorClassUserNotFound    = "user_not_found"
+	authErrorClassTooManyAttempts = "too_many_attempts"
+	authErrorClassPublicKeyNeeded = "public_key_required"
+	authErrorClassPasswordExpired = "password_expired"
+	authErrorClassAccountLocked   = "account_locked"
+	authErrorClassLoginFailed     = "login_failed"
+)
+
+// EventStreamConfig defines the configuration for the event stream. The event
+// stream is a JSON Lines file, or a named pipe, with a JSON object for each
+// connection, authentication and session event. It is written asynchronously
+// and the events are dropped, and counted, if the queue is full
+type EventStreamConfig struct {
+	// Set to true to enable the event stream
+	Enabled bool `json:"enabled" mapstructure:"enabled"`
+	// Absolute path to the event stream file or named pipe
+	FilePath string `json:"file_path" mapstructure:"file_path"`
+	// Maximum size in megabytes of the event stream file before it gets rotated
+	MaxSize int `json:"max_size" mapstructure:"max_size"`
+	// Maximum number of old event stream files to retain
+	MaxBackups int `json:"max_backups" mapstructure:"max_backups"`
+	// Maximum number of days to retain old event stream files
+	MaxAge int `json:"max_age" mapstructure:"max_age"`
+	// Compress determines if the rotated event stream files should be compressed using gzip
+	Compress bool `json:"compress" mapstructure:"compress"`
+	// Number of events that can be queued waiting to be written
+	BufferSize int `json:"buffer_size" mapstructure:"buffer_size"`
+}
+
+func (c *EventStreamConfig) initialize() error {
+	if !c.Enabled {
+		logger.CloseEventStream()
+		return nil
+	}
+	err := logger.InitEventStream(logger.EventStreamConfig{
+		FilePath:   c.FilePath,
+		MaxSize:    c.MaxSize,
+		MaxBackups: c.MaxBackups,
+		MaxAge:     c.MaxAge,
+		Compress:   c.Compress,
+		BufferSize: c.BufferSize,
+		OnDrop:     metric.AddEventStreamEventDropped,
+	})
+	if err != nil {
+		return err
+	}
+	logger.Info(logSender, "", "event stream initialized, file path %q", c.FilePath)
+	return nil
+}
+
+// SessionCounters defines the counters reported in the event stream when a session ends
+type SessionCounters struct {
+	Channels      int64
+	BytesReceived int64
+	BytesSent     int64
+}
+
+// EmitConnectionAccepted adds a connection accepted event to the event stream, if enabled
+func EmitConnectionAccepted(ip, localAddr, protocol string) {
+	if !logger.IsEventStreamEnabled() {
+		return
+	}
+	logger.EmitStreamEvent(newConnectionStreamEvent(logger.StreamEventConnectionAccepted, ip, localAddr, protocol))
+}
+
+// EmitConnectionDenied adds a connection denied event to the event stream, if enabled
+func EmitConnectionDenied(ip, localAddr, protocol, reason string) {
+	if !logger.IsEventStreamEnabled() {
+		return
+	}
+	event := newConnectionStreamEvent(logger.StreamEventConnectionDenied, ip, localAddr, protocol)
+	event.Reason = reason
+	logger.EmitStreamEvent(event)
+}
+
+// EmitAuthAttempt adds an authentication attempt event to the event stream, if enabled
+func EmitAuthAttempt(ip, protocol, username, loginMethod string, err error) {
+	if !logger.IsEventStreamEnabled() {
+		return
+	}
+	logger.EmitStreamEvent(newAuthStreamEvent(ip, protocol, username, loginMethod, err))
+}
+
+// EmitSessionStart adds a session start event to the event stream, if enabled
+func EmitSessionStart(connectionID, ip, protocol, username, clientVersion string) {
+	if !logger.IsEventStreamEnabled() {
+		return
+	}
+	logger.EmitStreamEvent(&logger.StreamEvent{
+		Event:         logger.StreamEventSessionStart,
+		Protocol:      protocol,
+		IP:            ip,
+		ConnectionID:  connectionID,
+		Username:      username,
+		ClientVersion: clientVersion,
+	})
+}
+
+// EmitSessionEnd adds a session end event to the event stream, if enabled
+func EmitSessionEnd(connectionID, ip, protocol, username string, startTime time.Time, counters SessionCounters) {
+	if !logger.IsEventStreamEnabled() {
+		return
+	}
+	logger.EmitStreamEvent(newSessionEndStreamEvent(connectionID, ip, protocol, username, startTime, counters))
+}
+
+func newConnectionStreamEvent(event, ip, localAddr, protocol string) *logger.StreamEvent {
+	return &logger.StreamEvent{
+		Event:        event,
+		Protocol:     protocol,
+		IP:           ip,
+		LocalAddress: localAddr,
+	}
+}
+
+func newAuthStreamEvent(ip, protocol, username, loginMethod string, err error) *logger.StreamEvent {
+	status := 1
+	event := &logger.StreamEvent{
+		Event:       logger.StreamEventAuthAttempt,
+		Protocol:    protocol,
+		IP:          ip,
+		Username:    username,
+		LoginMethod: loginMethod,
+		Status:      &status,
+	}
+	if err != nil {
+		status = 0
+		event.ErrorClass = getAuthErrorClass(err)
+		event.Error = err.Error()
+	}
+	return event
+}
+
+func newSessionEndStreamEvent(connectionID, ip, protocol, username string, startTime time.Time,
+	counters SessionCounters,
+) *logger.StreamEvent {
+	duration := time.Since(startTime).Milliseconds()
+	return &logger.StreamEvent{
+		Event:         logger.StreamEventSessionEnd,
+		Protocol:      protocol,
+		IP:            ip,
+		ConnectionID:  connectionID,
+		Username:      username,
+		Duration:      &duration,
+		Channels:      &counters.Channels,
+		BytesReceived: &counters.BytesReceived,
+		BytesSent:     &counters.BytesSent,
+	}
+}
+
+func getAuthErrorClass(err error) string {
+	if errors.Is(err, util.ErrNotFound) {
+		return authErrorClassUserNotFound
+	}
+	if errors.Is(err, dataprovider.ErrTooManySecondFactorAttempts) {
+		return authErrorClassTooManyAttempts
+	}
+	if errors.Is(err, ErrPublicKeyRequired) {
+		return authErrorClassPublicKeyNeeded
+	}
+	if errors.Is(err, dataprovider.ErrPasswordExpired) {
+		return authErrorClassPasswordExpired
+	}
+	if errors.Is(err, dataprovider.ErrAccountLocked) {
+		return authErrorClassAccountLocked
+	}
+	return authErrorClassLoginFailed
+}
@@ -0,0 +1,158 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+func TestEventStream(t *testing.T) {
+	c := EventStreamConfig{
+		Enabled:  true,
+		FilePath: "relative.jsonl",
+	}
+	err := c.initialize()
+	assert.Error(t, err)
+	assert.False(t, logger.IsEventStreamEnabled())
+	err = logger.RotateEventStreamFile()
+	assert.Error(t, err)
+
+	c.FilePath = filepath.Join(os.TempDir(), "event_stream", "events.jsonl")
+	err = c.initialize()
+	require.NoError(t, err)
+	assert.True(t, logger.IsEventStreamEnabled())
+
+	EmitConnectionAccepted("127.0.0.1", "127.0.0.1:2022", ProtocolSSH)
+	EmitConnectionDenied("127.0.0.2", "127.0.0.1:2022", ProtocolSSH, ConnectionDenyReasonBanned)
+	EmitAuthAttempt("127.0.0.1", ProtocolSSH, "stream_user", dataprovider.LoginMethodPassword, nil)
+	EmitAuthAttempt("127.0.0.1", ProtocolSSH, "stream_user", dataprovider.LoginMethodPassword,
+		util.NewRecordNotFoundError("not found"))
+	EmitSessionStart("SFTP_id", "127.0.0.1", ProtocolSSH, "stream_user", "SSH-2.0-client")
+	EmitSessionEnd("SFTP_id", "127.0.0.1", ProtocolSSH, "stream_user", time.Now().Add(-time.Second),
+		SessionCounters{Channels: 2, BytesReceived: 100, BytesSent: 200})
+	err = logger.RotateEventStreamFile()
+	assert.NoError(t, err)
+
+	c.Enabled = false
+	err = c.initialize()
+	require.NoError(t, err)
+	assert.False(t, logger.IsEventStreamEnabled())
+	// the event stream is disabled, this event is not written
+	EmitConnectionAccepted("127.0.0.1", "127.0.0.1:2022", ProtocolSSH)
+
+	commonKeys := []string{"schema_version", "timestamp", "event", "protocol", "ip"}
+	expectedKeys := map[string][]string{
+		logger.StreamEventConnectionAccepted: {"local_address"},
+		logger.StreamEventConnectionDenied:   {"local_address", "reason"},
+		logger.StreamEventSessionStart:       {"connection_id", "username", "client_version"},
+		logger.StreamEventSessionEnd: {"connection_id", "username", "duration_ms", "channels", "bytes_received",
+			"bytes_sent"},
+	}
+	// the rotated files and the current one
+	matches, err := filepath.Glob(filepath.Join(filepath.Dir(c.FilePath), "*.jsonl"))
+	require.NoError(t, err)
+	slices.Sort(matches)
+	var events []map[string]any
+	for _, name := range matches {
+		f, err := os.Open(name)
+		require.NoError(t, err)
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var event map[string]any
+			err = json.Unmarshal(scanner.Bytes(), &event)
+			assert.NoError(t, err)
+			events = append(events, event)
+		}
+		err = f.Close()
+		assert.NoError(t, err)
+	}
+	require.Len(t, events, 6)
+	for idx, event := range events {
+		assert.Equal(t, float64(logger.EventStreamSchemaVersion), event["schema_version"])
+		eventType := event["event"].(string)
+		keys := slices.Clone(commonKeys)
+		if eventType == logger.StreamEventAuthAttempt {
+			keys = append(keys, "username", "login_method", "status")
+			if event["status"] == float64(0) {
+				keys = append(keys, "error_class", "error")
+			}
+		} else {
+			keys = append(keys, expectedKeys[eventType]...)
+		}
+		assert.Len(t, event, len(keys), fmt.Sprintf("event %d: %+v", idx, event))
+		for _, key := range keys {
+			assert.Contains(t, event, key, fmt.Sprintf("event %d: %+v", idx, event))
+		}
+	}
+	assert.Equal(t, logger.StreamEventConnectionAccepted, events[0]["event"])
+	assert.Equal(t, ConnectionDenyReasonBanned, events[1]["reason"])
+	assert.Equal(t, float64(1), events[2]["status"])
+	assert.Equal(t, float64(0), events[3]["status"])
+	assert.Equal(t, authErrorClassUserNotFound, events[3]["error_class"])
+	assert.Equal(t, "SSH-2.0-client", events[4]["client_version"])
+	assert.Equal(t, float64(2), events[5]["channels"])
+	assert.Equal(t, float64(100), events[5]["bytes_received"])
+	assert.Equal(t, float64(200), events[5]["bytes_sent"])
+	assert.GreaterOrEqual(t, events[5]["duration_ms"], float64(1000))
+
+	err = os.RemoveAll(filepath.Dir(c.FilePath))
+	assert.NoError(t, err)
+}
+
+func TestEventStreamDroppedEvents(t *testing.T) {
+	c := EventStreamConfig{
+		Enabled:    true,
+		FilePath:   filepath.Join(os.TempDir(), "event_stream_drop", "events.jsonl"),
+		BufferSize: 1,
+	}
+	err := c.initialize()
+	require.NoError(t, err)
+
+	dropped := logger.GetEventStreamDroppedEvents()
+	for range 1000 {
+		EmitConnectionAccepted("127.0.0.1", "127.0.0.1:2022", ProtocolSSH)
+	}
+	assert.Greater(t, logger.GetEventStreamDroppedEvents(), dropped)
+
+	c.Enabled = false
+	err = c.initialize()
+	require.NoError(t, err)
+	err = os.RemoveAll(filepath.Dir(c.FilePath))
+	assert.NoError(t, err)
+}
+
+func TestAuthErrorClass(t *testing.T) {
+	assert.Equal(t, authErrorClassUserNotFound, getAuthErrorClass(util.NewRecordNotFoundError("not found")))
+	assert.Equal(t, authErrorClassTooManyAttempts, getAuthErrorClass(dataprovider.ErrTooManySecondFactorAttempts))
+	assert.Equal(t, authErrorClassPublicKeyNeeded, getAuthErrorClass(ErrPublicKeyRequired))
+	assert.Equal(t, authErrorClassPasswordExpired, getAuthErrorClass(dataprovider.ErrPasswordExpired))
+	assert.Equal(t, authErrorClassAccountLocked, getAuthErrorClass(dataprovider.ErrAccountLocked))
+	assert.Equal(t, authErrorClassLoginFailed, getAuthErrorClass(errors.New("invalid credentials")))
+}
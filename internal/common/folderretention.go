@@ -0,0 +1,284 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/sftpgo/sdk"
+	"golang.org/x/time/rate"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+// name of the maintenance job for the folders retention check
+const folderRetentionJobName = "folders_retention"
+
+// FolderRetentionConfig defines the configuration for the automatic
+// retention of the virtual folders
+type FolderRetentionConfig struct {
+	// Interval between two retention checks as minutes. 0 means disabled
+	CheckInterval int `json:"check_interval" mapstructure:"check_interval"`
+	// Maximum number of folders checked concurrently
+	MaxConcurrency int `json:"max_concurrency" mapstructure:"max_concurrency"`
+	// Maximum number of backend operations, listings and removals, per second
+	// for all the folders checked concurrently. 0 means no limit
+	MaxOpsPerSecond int `json:"max_ops_per_second" mapstructure:"max_ops_per_second"`
+}
+
+func (c *FolderRetentionConfig) validate() error {
+	if c.CheckInterval < 0 {
+		return fmt.Errorf("invalid check interval: %d", c.CheckInterval)
+	}
+	if c.MaxConcurrency < 0 {
+		return fmt.Errorf("invalid max concurrency: %d", c.MaxConcurrency)
+	}
+	if c.MaxOpsPerSecond < 0 {
+		return fmt.Errorf("invalid max operations per second: %d", c.MaxOpsPerSecond)
+	}
+	return nil
+}
+
+func (c *FolderRetentionConfig) getMaxConcurrency() int {
+	if c.MaxConcurrency == 0 {
+		return 1
+	}
+	return c.MaxConcurrency
+}
+
+func (c *FolderRetentionConfig) getLimiter() *rate.Limiter {
+	if c.MaxOpsPerSecond == 0 {
+		return rate.NewLimiter(rate.Inf, 0)
+	}
+	return rate.NewLimiter(rate.Limit(c.MaxOpsPerSecond), c.MaxOpsPerSecond)
+}
+
+func scheduleFoldersRetention() {
+	if Config.FolderRetention.CheckInterval == 0 {
+		return
+	}
+	interval := time.Duration(Config.FolderRetention.CheckInterval) * time.Minute
+	scheduleMaintenanceJob(folderRetentionJobName, interval, checkFoldersRetention)
+}
+
+func checkFoldersRetention(ctx context.Context) error {
+	folders, err := dataprovider.GetFoldersWithRetention()
+	if err != nil {
+		return fmt.Errorf("unable to get the folders with retention: %w", err)
+	}
+	if len(folders) == 0 {
+		return nil
+	}
+	logger.Debug(logSender, "", "start retention check for %d folders", len(folders))
+	limiter := Config.FolderRetention.getLimiter()
+	sem := make(chan struct{}, Config.FolderRetention.getMaxConcurrency())
+	var wg sync.WaitGroup
+
+	for _, folder := range folders {
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(folder vfs.BaseVirtualFolder) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			check := newFolderCleanup(ctx, folder, limiter)
+			check.run()
+		}(folder)
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("retention check interrupted: %w", err)
+	}
+	logger.Debug(logSender, "", "retention check completed for %d folders", len(folders))
+	return nil
+}
+
+// folderCleanup removes the expired files inside a virtual folder.
+// The folder is mounted as "/<folder name>" for a connection without a
+// user, so the removals are notified as for any other connection
+type folderCleanup struct {
+	ctx          context.Context
+	folder       vfs.BaseVirtualFolder
+	virtualPath  string
+	conn         *BaseConnection
+	limiter      *rate.Limiter
+	expiration   time.Time
+	deletedFiles int
+	deletedSize  int64
+	errors       int
+}
+
+func newFolderCleanup(ctx context.Context, folder vfs.BaseVirtualFolder, limiter *rate.Limiter) *folderCleanup {
+	virtualPath := path.Join("/", folder.Name)
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Status:  1,
+			HomeDir: os.TempDir(),
+			Permissions: map[string][]string{
+				"/": {dataprovider.PermAny},
+			},
+		},
+		VirtualFolders: []vfs.VirtualFolder{
+			{
+				BaseVirtualFolder: folder,
+				VirtualPath:       virtualPath,
+			},
+		},
+	}
+	conn := NewBaseConnection(fmt.Sprintf("folder_retention_%s", folder.Name), ProtocolDataRetention, "", "", user)
+
+	return &folderCleanup{
+		ctx:         ctx,
+		folder:      folder,
+		virtualPath: virtualPath,
+		conn:        conn,
+		limiter:     limiter,
+		expiration:  time.Now().Add(-time.Duration(folder.Retention.Hours) * time.Hour),
+	}
+}
+
+func (c *folderCleanup) run() {
+	defer c.conn.CloseFS() //nolint:errcheck
+
+	startTime := time.Now()
+	c.conn.Log(logger.LevelInfo, "retention check started for folder %q, retention: %d hours, preserve dirs? %t, dry run? %t",
+		c.folder.Name, c.folder.Retention.Hours, c.folder.Retention.PreserveDirs, c.folder.Retention.DryRun)
+	err := c.cleanupDir(c.virtualPath, 0)
+	c.conn.Log(logger.LevelInfo, "retention check completed for folder %q, deleted files: %d, deleted size: %d bytes, "+
+		"errors: %d, elapsed: %s, err: %v", c.folder.Name, c.deletedFiles, c.deletedSize, c.errors,
+		time.Since(startTime), err)
+}
+
+func (c *folderCleanup) wait() error {
+	return c.limiter.Wait(c.ctx)
+}
+
+func (c *folderCleanup) cleanupDir(dirPath string, recursion int) error {
+	if recursion >= util.MaxRecursion {
+		c.conn.Log(logger.LevelError, "folder retention check skipped, recursion too deep for %q: %d",
+			dirPath, recursion)
+		return util.ErrRecursionTooDeep
+	}
+	recursion++
+
+	if err := c.wait(); err != nil {
+		return err
+	}
+	lister, err := c.conn.ListDir(dirPath)
+	if err != nil {
+		if err == c.conn.GetNotExistError() {
+			c.conn.Log(logger.LevelDebug, "directory %q does not exist, retention check skipped", dirPath)
+			return nil
+		}
+		return err
+	}
+	defer lister.Close()
+
+	for {
+		files, err := lister.Next(vfs.ListerBatchSize)
+		finished := errors.Is(err, io.EOF)
+		if err := lister.convertError(err); err != nil {
+			c.conn.Log(logger.LevelError, "unable to list dir %q: %v", dirPath, err)
+			return err
+		}
+		for _, info := range files {
+			virtualPath := path.Join(dirPath, info.Name())
+			if info.IsDir() {
+				if err := c.cleanupDir(virtualPath, recursion); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := c.removeFile(virtualPath, info); err != nil {
+				if errors.Is(err, context.Canceled) {
+					return err
+				}
+				c.errors++
+			}
+		}
+		if finished {
+			break
+		}
+		if err := c.wait(); err != nil {
+			return err
+		}
+	}
+
+	lister.Close()
+	c.checkEmptyDirRemoval(dirPath)
+	return nil
+}
+
+func (c *folderCleanup) removeFile(virtualPath string, info os.FileInfo) error {
+	uploadTime := vfs.GetUploadTime(info)
+	if !uploadTime.Before(c.expiration) {
+		return nil
+	}
+	if c.folder.Retention.DryRun {
+		c.conn.Log(logger.LevelInfo, "dry run, file %q would be removed, upload time: %v, size: %d",
+			virtualPath, uploadTime, info.Size())
+		c.deletedFiles++
+		c.deletedSize += info.Size()
+		return nil
+	}
+	if err := c.wait(); err != nil {
+		return err
+	}
+	fs, fsPath, err := c.conn.GetFsAndResolvedPath(virtualPath)
+	if err != nil {
+		c.conn.Log(logger.LevelError, "unable to resolve file %q: %v", virtualPath, err)
+		return err
+	}
+	if err := c.conn.RemoveFile(fs, fsPath, virtualPath, info); err != nil {
+		c.conn.Log(logger.LevelError, "unable to remove file %q, upload time: %v: %v", virtualPath, uploadTime, err)
+		return err
+	}
+	c.conn.Log(logger.LevelInfo, "removed file %q, upload time: %v, size: %d", virtualPath, uploadTime, info.Size())
+	c.deletedFiles++
+	c.deletedSize += info.Size()
+	return nil
+}
+
+func (c *folderCleanup) checkEmptyDirRemoval(dirPath string) {
+	if dirPath == c.virtualPath || c.folder.Retention.PreserveDirs || c.folder.Retention.DryRun {
+		return
+	}
+	lister, err := c.conn.ListDir(dirPath)
+	if err != nil {
+		return
+	}
+	files, err := lister.Next(1)
+	lister.Close()
+	if len(files) == 0 && errors.Is(err, io.EOF) {
+		err = c.conn.RemoveDir(dirPath)
+		c.conn.Log(logger.LevelDebug, "tried to remove empty dir %q, error: %v", dirPath, err)
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+func TestFolderRetentionConfig(t *testing.T) {
+	c := FolderRetentionConfig{}
+	assert.NoError(t, c.validate())
+	assert.Equal(t, 1, c.getMaxConcurrency())
+	assert.Equal(t, rate.Inf, c.getLimiter().Limit())
+	c.MaxConcurrency = 3
+	c.MaxOpsPerSecond = 10
+	assert.NoError(t, c.validate())
+	assert.Equal(t, 3, c.getMaxConcurrency())
+	assert.Equal(t, rate.Limit(10), c.getLimiter().Limit())
+	c.CheckInterval = -1
+	assert.Error(t, c.validate())
+	c.CheckInterval = 0
+	c.MaxConcurrency = -1
+	assert.Error(t, c.validate())
+	c.MaxConcurrency = 0
+	c.MaxOpsPerSecond = -1
+	assert.Error(t, c.validate())
+}
+
+func TestFolderRetentionValidation(t *testing.T) {
+	folder := vfs.BaseVirtualFolder{
+		Name:       "retention_validation",
+		MappedPath: filepath.Join(os.TempDir(), "retention_validation"),
+		Retention: vfs.FolderRetention{
+			Hours: -1,
+		},
+	}
+	err := dataprovider.AddFolder(&folder, "", "", "")
+	assert.Error(t, err)
+	folder.Retention.Hours = 0
+	folder.Retention.DryRun = true
+	folder.Retention.PreserveDirs = true
+	err = dataprovider.AddFolder(&folder, "", "", "")
+	assert.NoError(t, err)
+	folder, err = dataprovider.GetFolderByName(folder.Name)
+	assert.NoError(t, err)
+	assert.Equal(t, vfs.FolderRetention{}, folder.Retention)
+	folder.MappedPath = filepath.Join(os.TempDir(), "%username%")
+	folder.Retention.Hours = 1
+	err = dataprovider.UpdateFolder(&folder, nil, nil, "", "", "")
+	assert.Error(t, err)
+	err = dataprovider.DeleteFolder(folder.Name, "", "", "")
+	assert.NoError(t, err)
+}
+
+func TestFoldersRetention(t *testing.T) {
+	mappedPath := filepath.Join(os.TempDir(), "folder_retention")
+	folder := vfs.BaseVirtualFolder{
+		Name:       "folder_retention",
+		MappedPath: mappedPath,
+		Retention: vfs.FolderRetention{
+			Hours:  1,
+			DryRun: true,
+		},
+	}
+	err := dataprovider.AddFolder(&folder, "", "", "")
+	require.NoError(t, err)
+
+	oldTime := time.Now().Add(-2 * time.Hour)
+	createFile := func(name string, isOld bool) string {
+		p := filepath.Join(mappedPath, name)
+		err := os.MkdirAll(filepath.Dir(p), os.ModePerm)
+		require.NoError(t, err)
+		err = os.WriteFile(p, []byte("data"), 0666)
+		require.NoError(t, err)
+		if isOld {
+			err = os.Chtimes(p, oldTime, oldTime)
+			require.NoError(t, err)
+		}
+		return p
+	}
+	oldFile := createFile("old.txt", true)
+	newFile := createFile("new.txt", false)
+	oldSubFile := createFile(filepath.Join("sub1", "old.txt"), true)
+	newSubFile := createFile(filepath.Join("sub2", "new.txt"), false)
+
+	err = checkFoldersRetention(context.Background())
+	assert.NoError(t, err)
+	assert.FileExists(t, oldFile)
+	assert.FileExists(t, newFile)
+	assert.FileExists(t, oldSubFile)
+	assert.FileExists(t, newSubFile)
+
+	folder.Retention.DryRun = false
+	folder.Retention.PreserveDirs = true
+	err = dataprovider.UpdateFolder(&folder, nil, nil, "", "", "")
+	require.NoError(t, err)
+	err = checkFoldersRetention(context.Background())
+	assert.NoError(t, err)
+	assert.NoFileExists(t, oldFile)
+	assert.FileExists(t, newFile)
+	assert.NoFileExists(t, oldSubFile)
+	assert.DirExists(t, filepath.Dir(oldSubFile))
+	assert.FileExists(t, newSubFile)
+
+	folder.Retention.PreserveDirs = false
+	err = dataprovider.UpdateFolder(&folder, nil, nil, "", "", "")
+	require.NoError(t, err)
+	oldSubFile = createFile(filepath.Join("sub1", "sub", "old.txt"), true)
+	err = checkFoldersRetention(context.Background())
+	assert.NoError(t, err)
+	assert.NoDirExists(t, filepath.Dir(oldSubFile))
+	assert.NoDirExists(t, filepath.Join(mappedPath, "sub1"))
+	assert.FileExists(t, newFile)
+	assert.FileExists(t, newSubFile)
+	assert.DirExists(t, mappedPath)
+	// an interrupted check must not remove files
+	oldFile = createFile("old.txt", true)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = checkFoldersRetention(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.FileExists(t, oldFile)
+	// a check already in progress must be skipped
+	job := newMaintenanceJob(folderRetentionJobName, time.Hour, checkFoldersRetention)
+	job.running.Store(true)
+	job.run()
+	assert.FileExists(t, oldFile)
+	job.running.Store(false)
+
+	err = dataprovider.DeleteFolder(folder.Name, "", "", "")
+	assert.NoError(t, err)
+	err = os.RemoveAll(mappedPath)
+	assert.NoError(t, err)
+}
+
+func TestFolderCleanupErrors(t *testing.T) {
+	folder := vfs.BaseVirtualFolder{
+		Name:       "folder_cleanup_errors",
+		MappedPath: filepath.Join(os.TempDir(), "folder_cleanup_errors"),
+		Retention: vfs.FolderRetention{
+			Hours: 24,
+		},
+	}
+	c := newFolderCleanup(context.Background(), folder, Config.FolderRetention.getLimiter())
+	// the mapped path does not exist
+	err := c.cleanupDir(c.virtualPath, 0)
+	assert.NoError(t, err)
+
+	err = c.cleanupDir(c.virtualPath, util.MaxRecursion)
+	assert.ErrorIs(t, err, util.ErrRecursionTooDeep)
+
+	err = c.removeFile("/missing", vfs.NewFileInfo("missing", false, 10, time.Now().Add(-48*time.Hour), false))
+	assert.Error(t, err)
+	assert.Equal(t, 0, c.deletedFiles)
+	assert.NoError(t, c.conn.CloseFS())
+}
@@ -0,0 +1,379 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/eikenb/pipeat"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+// Supported generators for the virtual generated files
+const (
+	// GeneratorManifest lists the files inside the directory containing the
+	// generated file, and its sub directories, with their sizes and checksums
+	GeneratorManifest = "manifest"
+	// GeneratorQuotaReport reports the used and the allowed quota for the user
+	// and its virtual folders
+	GeneratorQuotaReport = "quota_report"
+)
+
+// GeneratedFileFunc writes the content of the generated file at the
+// specified virtual path for the given connection
+type GeneratedFileFunc func(conn *BaseConnection, virtualPath string, w io.Writer) error
+
+var (
+	fileGeneratorsMu sync.RWMutex
+	fileGenerators   = map[string]GeneratedFileFunc{
+		GeneratorManifest:    generateManifest,
+		GeneratorQuotaReport: generateQuotaReport,
+	}
+)
+
+// RegisterFileGenerator registers a generator for the virtual generated files.
+// A generator with the same name is replaced
+func RegisterFileGenerator(name string, fn GeneratedFileFunc) {
+	fileGeneratorsMu.Lock()
+	defer fileGeneratorsMu.Unlock()
+
+	fileGenerators[name] = fn
+}
+
+func getFileGenerator(name string) (GeneratedFileFunc, bool) {
+	fileGeneratorsMu.RLock()
+	defer fileGeneratorsMu.RUnlock()
+
+	fn, ok := fileGenerators[name]
+	return fn, ok
+}
+
+// GeneratedFile defines a read-only virtual file whose content is produced
+// by an internal generator each time the file is opened
+type GeneratedFile struct {
+	// Virtual path for the file, for example "/MANIFEST.json"
+	Path string `json:"path" mapstructure:"path"`
+	// Generator name, for example "manifest" or "quota_report"
+	Generator string `json:"generator" mapstructure:"generator"`
+}
+
+// ValidateGeneratedFiles validates the specified generated files and returns
+// them with cleaned paths
+func ValidateGeneratedFiles(files []GeneratedFile) ([]GeneratedFile, error) {
+	var result []GeneratedFile
+	for _, f := range files {
+		if f.Path == "" && f.Generator == "" {
+			continue
+		}
+		f.Path = util.CleanPath(f.Path)
+		if f.Path == "/" {
+			return nil, fmt.Errorf("invalid generated file path %q", f.Path)
+		}
+		if _, ok := getFileGenerator(f.Generator); !ok {
+			return nil, fmt.Errorf("unsupported generator %q for generated file %q", f.Generator, f.Path)
+		}
+		if slices.ContainsFunc(result, func(g GeneratedFile) bool { return g.Path == f.Path }) {
+			return nil, fmt.Errorf("duplicated generated file %q", f.Path)
+		}
+		result = append(result, f)
+	}
+	return result, nil
+}
+
+// SetGeneratedFiles sets the virtual generated files for this connection
+func (c *BaseConnection) SetGeneratedFiles(files []GeneratedFile) {
+	c.generatedFiles = files
+}
+
+func (c *BaseConnection) getGeneratedFile(virtualPath string) (GeneratedFile, bool) {
+	if len(c.generatedFiles) == 0 || virtualPath == "" {
+		return GeneratedFile{}, false
+	}
+	virtualPath = util.CleanPath(virtualPath)
+	for _, f := range c.generatedFiles {
+		if f.Path == virtualPath {
+			return f, true
+		}
+	}
+	return GeneratedFile{}, false
+}
+
+// IsGeneratedFile returns true if the specified virtual path is a generated file.
+// Generated files are read-only and hide any real file with the same path
+func (c *BaseConnection) IsGeneratedFile(virtualPath string) bool {
+	_, ok := c.getGeneratedFile(virtualPath)
+	return ok
+}
+
+func (c *BaseConnection) getGeneratedFileInfo(f GeneratedFile) os.FileInfo {
+	// the content is produced at open time so the size is unknown
+	info := vfs.NewFileInfo(path.Base(f.Path), false, 0, c.GetConnectionTime(), false)
+	info.SetMode(0444)
+	return info
+}
+
+// GetGeneratedFileInfo returns the info for the generated file at the specified path
+func (c *BaseConnection) GetGeneratedFileInfo(virtualPath string) (os.FileInfo, error) {
+	f, ok := c.getGeneratedFile(virtualPath)
+	if !ok {
+		return nil, c.GetNotExistError()
+	}
+	if !c.User.HasPerm(dataprovider.PermListItems, path.Dir(f.Path)) {
+		return nil, c.GetPermissionDeniedError()
+	}
+	if ok, policy := c.User.IsFileAllowed(f.Path); !ok {
+		return nil, c.GetErrorForDeniedFile(policy)
+	}
+	return c.getGeneratedFileInfo(f), nil
+}
+
+// GetGeneratedFilesInfo returns the info for the generated files inside the
+// specified directory that the user is allowed to see
+func (c *BaseConnection) GetGeneratedFilesInfo(virtualDirPath string) []os.FileInfo {
+	var result []os.FileInfo
+	virtualDirPath = util.CleanPath(virtualDirPath)
+	for _, f := range c.generatedFiles {
+		if path.Dir(f.Path) != virtualDirPath {
+			continue
+		}
+		if ok, _ := c.User.IsFileAllowed(f.Path); !ok {
+			continue
+		}
+		result = append(result, c.getGeneratedFileInfo(f))
+	}
+	return result
+}
+
+// OpenGeneratedFile starts the generator for the specified generated file and
+// returns a reader for its content. The content is streamed while it is produced
+func (c *BaseConnection) OpenGeneratedFile(virtualPath string) (*pipeat.PipeReaderAt, error) {
+	f, ok := c.getGeneratedFile(virtualPath)
+	if !ok {
+		return nil, c.GetNotExistError()
+	}
+	if !c.User.HasPerm(dataprovider.PermDownload, path.Dir(f.Path)) {
+		return nil, c.GetPermissionDeniedError()
+	}
+	if ok, policy := c.User.IsFileAllowed(f.Path); !ok {
+		return nil, c.GetErrorForDeniedFile(policy)
+	}
+	fn, ok := getFileGenerator(f.Generator)
+	if !ok {
+		c.Log(logger.LevelError, "generator %q for file %q is not registered", f.Generator, f.Path)
+		return nil, c.GetGenericError(ErrGenericFailure)
+	}
+	r, w, err := pipeat.Pipe()
+	if err != nil {
+		c.Log(logger.LevelError, "unable to create pipe for generated file %q: %v", f.Path, err)
+		return nil, c.GetGenericError(err)
+	}
+
+	go func() {
+		startTime := time.Now()
+		bw := bufio.NewWriterSize(w, 32768)
+		err := fn(c, f.Path, bw)
+		if err == nil {
+			err = bw.Flush()
+		}
+		w.CloseWithError(err) //nolint:errcheck
+		c.Log(logger.LevelDebug, "generated file %q, generator %q, size: %d, elapsed: %s, err: %v",
+			f.Path, f.Generator, w.GetWrittenBytes(), time.Since(startTime), err)
+	}()
+
+	return r, nil
+}
+
+type manifestEntry struct {
+	Path     string `json:"path"`
+	Size     int64  `json:"size"`
+	ModTime  string `json:"mtime"`
+	Checksum string `json:"sha256,omitempty"`
+}
+
+// generateManifest writes a JSON document listing the files inside the directory
+// containing the generated file. Directories the user cannot list are skipped,
+// checksums are only included for files the user is allowed to read
+func generateManifest(conn *BaseConnection, virtualPath string, w io.Writer) error {
+	baseDir := path.Dir(virtualPath)
+	if _, err := fmt.Fprintf(w, `{"generated_at":%q,"path":%q,"files":[`,
+		time.Now().UTC().Format(time.RFC3339), baseDir); err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	isFirst := true
+	dirs := []string{baseDir}
+	for len(dirs) > 0 {
+		dirPath := dirs[len(dirs)-1]
+		dirs = dirs[:len(dirs)-1]
+
+		subDirs, err := writeManifestDir(conn, dirPath, enc, w, &isFirst)
+		if err != nil {
+			return err
+		}
+		dirs = append(dirs, subDirs...)
+	}
+	_, err := io.WriteString(w, "]}\n")
+	return err
+}
+
+func writeManifestDir(conn *BaseConnection, dirPath string, enc *json.Encoder, w io.Writer, isFirst *bool) ([]string, error) {
+	lister, err := conn.ListDir(dirPath)
+	if err != nil {
+		conn.Log(logger.LevelDebug, "manifest, skipping directory %q: %v", dirPath, err)
+		return nil, nil
+	}
+	defer lister.Close()
+
+	var subDirs []string
+	for {
+		files, err := lister.Next(vfs.ListerBatchSize)
+		finished := errors.Is(err, io.EOF)
+		if err := lister.convertError(err); err != nil {
+			return nil, fmt.Errorf("unable to list directory %q: %w", dirPath, err)
+		}
+		for _, info := range files {
+			entryPath := path.Join(dirPath, info.Name())
+			if info.IsDir() {
+				subDirs = append(subDirs, entryPath)
+				continue
+			}
+			if !info.Mode().IsRegular() || conn.IsGeneratedFile(entryPath) {
+				continue
+			}
+			entry := manifestEntry{
+				Path:    entryPath,
+				Size:    info.Size(),
+				ModTime: info.ModTime().UTC().Format(time.RFC3339),
+			}
+			entry.Checksum = getManifestChecksum(conn, entryPath)
+			if !*isFirst {
+				if _, err := io.WriteString(w, ","); err != nil {
+					return nil, err
+				}
+			}
+			*isFirst = false
+			// Encode appends a newline, this is valid JSON and keeps the manifest
+			// readable, one file per line
+			if err := enc.Encode(&entry); err != nil {
+				return nil, err
+			}
+		}
+		if finished {
+			return subDirs, nil
+		}
+	}
+}
+
+func getManifestChecksum(conn *BaseConnection, virtualPath string) string {
+	if ok, _ := conn.User.IsFileAllowed(virtualPath); !ok {
+		return ""
+	}
+	reader, cancelFn, err := getFileReader(conn, virtualPath)
+	if err != nil {
+		return ""
+	}
+	defer cancelFn()
+	defer reader.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, reader); err != nil {
+		conn.Log(logger.LevelDebug, "manifest, unable to compute the checksum for %q: %v", virtualPath, err)
+		return ""
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+type folderQuotaReport struct {
+	VirtualPath    string `json:"virtual_path"`
+	UsedQuotaSize  int64  `json:"used_quota_size"`
+	UsedQuotaFiles int    `json:"used_quota_files"`
+	QuotaSize      int64  `json:"quota_size"`
+	QuotaFiles     int    `json:"quota_files"`
+}
+
+type quotaReport struct {
+	GeneratedAt              string              `json:"generated_at"`
+	Username                 string              `json:"username"`
+	UsedQuotaSize            int64               `json:"used_quota_size"`
+	UsedQuotaFiles           int                 `json:"used_quota_files"`
+	QuotaSize                int64               `json:"quota_size"`
+	QuotaFiles               int                 `json:"quota_files"`
+	UsedUploadDataTransfer   int64               `json:"used_upload_data_transfer"`
+	UsedDownloadDataTransfer int64               `json:"used_download_data_transfer"`
+	UploadDataTransfer       int64               `json:"upload_data_transfer"`
+	DownloadDataTransfer     int64               `json:"download_data_transfer"`
+	TotalDataTransfer        int64               `json:"total_data_transfer"`
+	Folders                  []folderQuotaReport `json:"folders,omitempty"`
+}
+
+// generateQuotaReport writes a JSON document with the used and allowed quota.
+// Used sizes are in bytes, data transfer limits are in MB, 0 means unlimited
+func generateQuotaReport(conn *BaseConnection, _ string, w io.Writer) error {
+	user := &conn.User
+	report := quotaReport{
+		GeneratedAt:              time.Now().UTC().Format(time.RFC3339),
+		Username:                 user.Username,
+		UsedQuotaSize:            user.UsedQuotaSize,
+		UsedQuotaFiles:           user.UsedQuotaFiles,
+		QuotaSize:                user.QuotaSize,
+		QuotaFiles:               user.QuotaFiles,
+		UsedUploadDataTransfer:   user.UsedUploadDataTransfer,
+		UsedDownloadDataTransfer: user.UsedDownloadDataTransfer,
+		UploadDataTransfer:       user.UploadDataTransfer,
+		DownloadDataTransfer:     user.DownloadDataTransfer,
+		TotalDataTransfer:        user.TotalDataTransfer,
+	}
+	files, size, ulSize, dlSize, err := dataprovider.GetUsedQuota(user.Username)
+	if err == nil {
+		report.UsedQuotaFiles = files
+		report.UsedQuotaSize = size
+		report.UsedUploadDataTransfer = ulSize
+		report.UsedDownloadDataTransfer = dlSize
+	} else {
+		conn.Log(logger.LevelWarn, "quota report, unable to get the used quota: %v", err)
+	}
+	for _, folder := range user.VirtualFolders {
+		folderReport := folderQuotaReport{
+			VirtualPath:    folder.VirtualPath,
+			UsedQuotaSize:  folder.UsedQuotaSize,
+			UsedQuotaFiles: folder.UsedQuotaFiles,
+			QuotaSize:      folder.QuotaSize,
+			QuotaFiles:     folder.QuotaFiles,
+		}
+		if files, size, err := dataprovider.GetUsedVirtualFolderQuota(folder.Name); err == nil {
+			folderReport.UsedQuotaFiles = files
+			folderReport.UsedQuotaSize = size
+		}
+		report.Folders = append(report.Folders, folderReport)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(&report)
+}
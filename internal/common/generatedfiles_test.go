@@ -0,0 +1,214 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sftpgo/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+)
+
+func TestValidateGeneratedFiles(t *testing.T) {
+	files, err := ValidateGeneratedFiles(nil)
+	assert.NoError(t, err)
+	assert.Len(t, files, 0)
+	_, err = ValidateGeneratedFiles([]GeneratedFile{{Path: "/", Generator: GeneratorManifest}})
+	assert.Error(t, err)
+	_, err = ValidateGeneratedFiles([]GeneratedFile{{Path: "/file", Generator: "unknown"}})
+	assert.Error(t, err)
+	_, err = ValidateGeneratedFiles([]GeneratedFile{
+		{Path: "/file", Generator: GeneratorManifest},
+		{Path: "file", Generator: GeneratorQuotaReport},
+	})
+	assert.Error(t, err)
+	files, err = ValidateGeneratedFiles([]GeneratedFile{
+		{Path: "MANIFEST.json", Generator: GeneratorManifest},
+		{},
+		{Path: "/dir/../quota.json", Generator: GeneratorQuotaReport},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []GeneratedFile{
+		{Path: "/MANIFEST.json", Generator: GeneratorManifest},
+		{Path: "/quota.json", Generator: GeneratorQuotaReport},
+	}, files)
+
+	RegisterFileGenerator("custom", func(_ *BaseConnection, _ string, w io.Writer) error {
+		_, err := w.Write([]byte("custom"))
+		return err
+	})
+	defer func() {
+		fileGeneratorsMu.Lock()
+		delete(fileGenerators, "custom")
+		fileGeneratorsMu.Unlock()
+	}()
+	_, err = ValidateGeneratedFiles([]GeneratedFile{{Path: "/custom", Generator: "custom"}})
+	assert.NoError(t, err)
+}
+
+func TestGeneratedFiles(t *testing.T) {
+	homeDir := filepath.Join(os.TempDir(), "generated_files_user")
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username:  "generated_files_user",
+			HomeDir:   homeDir,
+			QuotaSize: 1048576,
+		},
+	}
+	user.Permissions = map[string][]string{
+		"/":        {dataprovider.PermAny},
+		"/private": {dataprovider.PermUpload},
+		"/nocheck": {dataprovider.PermListItems},
+	}
+	user.Filters.FilePatterns = []sdk.PatternsFilter{
+		{
+			Path:            "/hidden",
+			DeniedPatterns:  []string{"*"},
+			DenyPolicy:      sdk.DenyPolicyHide,
+			AllowedPatterns: []string{},
+		},
+	}
+	content := []byte("generated files content")
+	for _, p := range []string{"file1", "sub/file2", "private/file3", "nocheck/file4"} {
+		err := os.MkdirAll(filepath.Dir(filepath.Join(homeDir, p)), os.ModePerm)
+		require.NoError(t, err)
+		err = os.WriteFile(filepath.Join(homeDir, p), content, 0644)
+		require.NoError(t, err)
+	}
+	defer os.RemoveAll(homeDir)
+
+	conn := NewBaseConnection("", ProtocolWebDAV, "", "", user)
+	assert.False(t, conn.IsGeneratedFile("/MANIFEST.json"))
+	conn.SetGeneratedFiles([]GeneratedFile{
+		{Path: "/MANIFEST.json", Generator: GeneratorManifest},
+		{Path: "/quota.json", Generator: GeneratorQuotaReport},
+		{Path: "/private/MANIFEST.json", Generator: GeneratorManifest},
+		{Path: "/hidden/quota.json", Generator: GeneratorQuotaReport},
+		{Path: "/missing", Generator: "missing"},
+	})
+	assert.True(t, conn.IsGeneratedFile("MANIFEST.json"))
+	assert.False(t, conn.IsGeneratedFile("/file1"))
+	assert.Len(t, conn.GetGeneratedFilesInfo("/"), 3)
+	assert.Len(t, conn.GetGeneratedFilesInfo("/hidden"), 0)
+	info, err := conn.GetGeneratedFileInfo("/MANIFEST.json")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "MANIFEST.json", info.Name())
+		assert.Equal(t, int64(0), info.Size())
+		assert.Equal(t, os.FileMode(0444), info.Mode())
+	}
+	_, err = conn.GetGeneratedFileInfo("/private/MANIFEST.json")
+	assert.ErrorIs(t, err, os.ErrPermission)
+	_, err = conn.GetGeneratedFileInfo("/hidden/quota.json")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	_, err = conn.GetGeneratedFileInfo("/file1")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	_, err = conn.OpenGeneratedFile("/file1")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	_, err = conn.OpenGeneratedFile("/private/MANIFEST.json")
+	assert.ErrorIs(t, err, os.ErrPermission)
+	_, err = conn.OpenGeneratedFile("/missing")
+	assert.Error(t, err)
+
+	r, err := conn.OpenGeneratedFile("/MANIFEST.json")
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.NoError(t, r.Close())
+	var manifest struct {
+		Path  string          `json:"path"`
+		Files []manifestEntry `json:"files"`
+	}
+	err = json.Unmarshal(data, &manifest)
+	require.NoError(t, err, string(data))
+	assert.Equal(t, "/", manifest.Path)
+	checksum := sha256.Sum256(content)
+	entries := make(map[string]manifestEntry)
+	for _, e := range manifest.Files {
+		entries[e.Path] = e
+	}
+	// the private directory cannot be listed
+	assert.Len(t, entries, 3)
+	for _, p := range []string{"/file1", "/sub/file2"} {
+		if assert.Contains(t, entries, p) {
+			assert.Equal(t, int64(len(content)), entries[p].Size)
+			assert.Equal(t, hex.EncodeToString(checksum[:]), entries[p].Checksum)
+		}
+	}
+	// files that cannot be read are listed without a checksum
+	if assert.Contains(t, entries, "/nocheck/file4") {
+		assert.Empty(t, entries["/nocheck/file4"].Checksum)
+	}
+
+	r, err = conn.OpenGeneratedFile("/quota.json")
+	require.NoError(t, err)
+	data, err = io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.NoError(t, r.Close())
+	var report quotaReport
+	err = json.Unmarshal(data, &report)
+	require.NoError(t, err, string(data))
+	assert.Equal(t, user.Username, report.Username)
+	assert.Equal(t, user.QuotaSize, report.QuotaSize)
+}
+
+func TestGeneratedFileErrors(t *testing.T) {
+	errGenerate := errors.New("generate error")
+	RegisterFileGenerator("error", func(_ *BaseConnection, _ string, w io.Writer) error {
+		// more than the internal buffer size so it is written before failing
+		if _, err := w.Write(bytes.Repeat([]byte("a"), 65536)); err != nil {
+			return err
+		}
+		return errGenerate
+	})
+	defer func() {
+		fileGeneratorsMu.Lock()
+		delete(fileGenerators, "error")
+		fileGeneratorsMu.Unlock()
+	}()
+
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: "generated_files_user",
+			HomeDir:  filepath.Join(os.TempDir(), "generated_files_user"),
+		},
+	}
+	user.Permissions = map[string][]string{
+		"/": {dataprovider.PermAny},
+	}
+	conn := NewBaseConnection("", ProtocolSFTP, "", "", user)
+	conn.SetGeneratedFiles([]GeneratedFile{{Path: "/error", Generator: "error"}})
+	r, err := conn.OpenGeneratedFile("/error")
+	require.NoError(t, err)
+	_, err = io.ReadAll(r)
+	assert.ErrorIs(t, err, errGenerate)
+	assert.NoError(t, r.Close())
+	// listing errors for the base directory are not fatal
+	var buf bytes.Buffer
+	err = generateManifest(conn, "/MANIFEST.json", &buf)
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), `"files":[]}`)
+	assert.Empty(t, getManifestChecksum(conn, "/missing"))
+}
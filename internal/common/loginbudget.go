@@ -0,0 +1,74 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"errors"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+)
+
+const (
+	loginBudgetExhaustedEventName = "Login budget exhausted"
+)
+
+// ConsumeLogin consumes a login from the budget of the specified user, if
+// limited. It must be called after all the other login checks succeed.
+// An account lockout event is generated if the budget is exhausted
+func ConsumeLogin(user *dataprovider.User, ip, protocol string) error {
+	err := dataprovider.ConsumeLogin(user)
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, dataprovider.ErrLoginBudgetExhausted) {
+		logger.Info(logSender, "", "login refused for user %q, ip %q, protocol %s: %v", user.Username, ip, protocol, err)
+		handleAccountLockoutEvent(user.Username, loginBudgetExhaustedEventName, ip, protocol)
+		return err
+	}
+	logger.Warn(logSender, "", "unable to consume a login for user %q: %v", user.Username, err)
+	return ErrInternalFailure
+}
+
+// HandleSessionEnd must be called when a session for the specified user ends,
+// the user is disabled if it must be disabled after its first session
+func HandleSessionEnd(user *dataprovider.User, ip, protocol string) {
+	if !user.Filters.DisableAfterFirstSession {
+		return
+	}
+	storedUser, err := dataprovider.UserExists(user.Username, "")
+	if err != nil {
+		logger.Warn(logSender, "", "unable to disable user %q after its first session: %v", user.Username, err)
+		return
+	}
+	if storedUser.Status == 0 || !storedUser.Filters.DisableAfterFirstSession {
+		return
+	}
+	storedUser.Status = 0
+	if err := dataprovider.UpdateUser(&storedUser, dataprovider.ActionExecutorSystem, ip, ""); err != nil {
+		logger.Warn(logSender, "", "unable to disable user %q after its first session: %v", user.Username, err)
+		return
+	}
+	logger.Info(logSender, "", "user %q disabled after its first session, ip %q, protocol %s", user.Username, ip, protocol)
+}
+
+// HandleConnectionSessionEnd calls HandleSessionEnd for the user associated with
+// the active connection with the specified id. It must be called before removing
+// the connection
+func HandleConnectionSessionEnd(connectionID, ip, protocol string) {
+	if user, ok := Connections.getUser(connectionID); ok {
+		HandleSessionEnd(user, ip, protocol)
+	}
+}
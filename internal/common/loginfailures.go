@@ -0,0 +1,346 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"cmp"
+	"container/list"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/metric"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// ErrPublicKeyRequired is returned for password and keyboard interactive
+// logins if the account is in the elevated state after too many login failures
+var ErrPublicKeyRequired = errors.New("too many login failures for this account, only public key authentication is allowed")
+
+// LoginFailuresConfig defines the tracking of the login failures per username.
+// The defender tracks the source IPs, credential stuffing attacks instead hit
+// the same username from many different IPs. An account enters the elevated
+// state if the login failures, within the observation time, reach the threshold
+type LoginFailuresConfig struct {
+	// Set to true to enable the login failures tracking
+	Enabled bool `json:"enabled" mapstructure:"enabled"`
+	// Number of login failures, within the observation time, after which
+	// the account enters the elevated state and the "Login failures threshold"
+	// event is triggered
+	Threshold int `json:"threshold" mapstructure:"threshold"`
+	// ObservationTime is the time window, in minutes, for the login failures
+	ObservationTime int `json:"observation_time" mapstructure:"observation_time"`
+	// ElevatedTime is the number of minutes an account stays in the elevated state
+	ElevatedTime int `json:"elevated_time" mapstructure:"elevated_time"`
+	// If true, password and keyboard interactive SSH logins are rejected while
+	// an account is in the elevated state, public key authentication is required
+	RequirePublicKey bool `json:"require_public_key" mapstructure:"require_public_key"`
+	// Maximum number of tracked usernames, the least recently used ones are evicted
+	EntriesLimit int `json:"entries_limit" mapstructure:"entries_limit"`
+}
+
+func (c *LoginFailuresConfig) validate() error {
+	if c.Threshold <= 0 {
+		return fmt.Errorf("invalid threshold %d", c.Threshold)
+	}
+	if c.ObservationTime <= 0 {
+		return fmt.Errorf("invalid observation time %d", c.ObservationTime)
+	}
+	if c.ElevatedTime <= 0 {
+		return fmt.Errorf("invalid elevated time %d", c.ElevatedTime)
+	}
+	if c.EntriesLimit <= 0 {
+		return fmt.Errorf("invalid entries limit %d", c.EntriesLimit)
+	}
+	return nil
+}
+
+func (c *LoginFailuresConfig) initialize() error {
+	metric.UpdateLoginFailuresElevatedAccounts(0)
+	if !c.Enabled {
+		return nil
+	}
+	if err := c.validate(); err != nil {
+		return err
+	}
+	Config.loginFailures = newLoginFailuresTracker(*c)
+	logger.Info(logSender, "", "login failures tracking initialized with config %+v", *c)
+	return nil
+}
+
+// LoginFailuresEntry defines the login failures for a username
+type LoginFailuresEntry struct {
+	Username string `json:"username"`
+	// Login failures within the observation time
+	Failures int `json:"failures"`
+	// Login failures since the username is tracked
+	TotalFailures int64     `json:"total_failures"`
+	LastIP        string    `json:"last_ip"`
+	LastProtocol  string    `json:"last_protocol"`
+	LastFailure   time.Time `json:"last_failure"`
+	// Zero if the account is not in the elevated state
+	ElevatedUntil time.Time `json:"elevated_until,omitempty"`
+}
+
+type loginFailures struct {
+	username      string
+	failures      []time.Time
+	totalFailures int64
+	lastIP        string
+	lastProtocol  string
+	elevatedUntil time.Time
+}
+
+func (f *loginFailures) isElevated(now time.Time) bool {
+	return f.elevatedUntil.After(now)
+}
+
+// removeExpired removes the failures outside the observation time
+func (f *loginFailures) removeExpired(observationTime time.Duration, now time.Time) {
+	idx := 0
+	for idx < len(f.failures) && now.Sub(f.failures[idx]) > observationTime {
+		idx++
+	}
+	f.failures = f.failures[idx:]
+}
+
+func (f *loginFailures) getEntry(now time.Time) LoginFailuresEntry {
+	entry := LoginFailuresEntry{
+		Username:      f.username,
+		Failures:      len(f.failures),
+		TotalFailures: f.totalFailures,
+		LastIP:        f.lastIP,
+		LastProtocol:  f.lastProtocol,
+	}
+	if len(f.failures) > 0 {
+		entry.LastFailure = f.failures[len(f.failures)-1]
+	}
+	if f.isElevated(now) {
+		entry.ElevatedUntil = f.elevatedUntil
+	}
+	return entry
+}
+
+// loginFailuresTracker tracks the login failures per username in a bounded LRU
+type loginFailuresTracker struct {
+	config          LoginFailuresConfig
+	observationTime time.Duration
+	elevatedTime    time.Duration
+	mu              sync.Mutex
+	entries         map[string]*list.Element
+	lru             *list.List
+}
+
+func newLoginFailuresTracker(config LoginFailuresConfig) *loginFailuresTracker {
+	return &loginFailuresTracker{
+		config:          config,
+		observationTime: time.Duration(config.ObservationTime) * time.Minute,
+		elevatedTime:    time.Duration(config.ElevatedTime) * time.Minute,
+		entries:         make(map[string]*list.Element),
+		lru:             list.New(),
+	}
+}
+
+// addFailure records a login failure and returns true if the account just
+// entered the elevated state
+func (t *loginFailuresTracker) addFailure(username, ip, protocol string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	var f *loginFailures
+	if elem, ok := t.entries[username]; ok {
+		t.lru.MoveToFront(elem)
+		f = elem.Value.(*loginFailures)
+	} else {
+		f = &loginFailures{username: username}
+		t.entries[username] = t.lru.PushFront(f)
+		if t.lru.Len() > t.config.EntriesLimit {
+			oldest := t.lru.Back()
+			t.lru.Remove(oldest)
+			delete(t.entries, oldest.Value.(*loginFailures).username)
+		}
+	}
+	f.removeExpired(t.observationTime, now)
+	f.failures = append(f.failures, now)
+	if len(f.failures) > t.config.Threshold {
+		// the failures above the threshold are not needed
+		f.failures = f.failures[len(f.failures)-t.config.Threshold:]
+	}
+	f.totalFailures++
+	f.lastIP = ip
+	f.lastProtocol = protocol
+	if len(f.failures) >= t.config.Threshold && !f.isElevated(now) {
+		f.elevatedUntil = now.Add(t.elevatedTime)
+		return true
+	}
+	return false
+}
+
+// addSuccess decays the login failures for the given username, the most
+// recent half is kept. The elevated state is not affected
+func (t *loginFailuresTracker) addSuccess(username string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	elem, ok := t.entries[username]
+	if !ok {
+		return
+	}
+	f := elem.Value.(*loginFailures)
+	f.failures = f.failures[(len(f.failures)+1)/2:]
+	if len(f.failures) == 0 && !f.isElevated(time.Now()) {
+		t.lru.Remove(elem)
+		delete(t.entries, username)
+	}
+}
+
+func (t *loginFailuresTracker) isElevated(username string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	elem, ok := t.entries[username]
+	if !ok {
+		return false
+	}
+	return elem.Value.(*loginFailures).isElevated(time.Now())
+}
+
+func (t *loginFailuresTracker) countElevated() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	count := 0
+	for _, elem := range t.entries {
+		if elem.Value.(*loginFailures).isElevated(now) {
+			count++
+		}
+	}
+	return count
+}
+
+// getMostAttacked returns up to limit usernames ordered by the login failures
+// within the observation time and then by the total login failures
+func (t *loginFailuresTracker) getMostAttacked(limit int) []LoginFailuresEntry {
+	t.mu.Lock()
+	now := time.Now()
+	result := make([]LoginFailuresEntry, 0, len(t.entries))
+	for _, elem := range t.entries {
+		f := elem.Value.(*loginFailures)
+		f.removeExpired(t.observationTime, now)
+		result = append(result, f.getEntry(now))
+	}
+	t.mu.Unlock()
+
+	slices.SortFunc(result, func(a, b LoginFailuresEntry) int {
+		if c := cmp.Compare(b.Failures, a.Failures); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(b.TotalFailures, a.TotalFailures); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Username, b.Username)
+	})
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+	return result
+}
+
+// cleanup removes the usernames with no recent failures, not in the
+// elevated state, and updates the elevated accounts metric
+func (t *loginFailuresTracker) cleanup() {
+	t.mu.Lock()
+	now := time.Now()
+	for username, elem := range t.entries {
+		f := elem.Value.(*loginFailures)
+		f.removeExpired(t.observationTime, now)
+		if len(f.failures) == 0 && !f.isElevated(now) {
+			t.lru.Remove(elem)
+			delete(t.entries, username)
+		}
+	}
+	t.mu.Unlock()
+
+	metric.UpdateLoginFailuresElevatedAccounts(t.countElevated())
+}
+
+func checkLoginFailures() {
+	if tracker := Config.loginFailures; tracker != nil {
+		tracker.cleanup()
+	}
+}
+
+// AddLoginResult records the result of a login attempt for the login failures
+// tracking. Login attempts for non-existent users are ignored
+func AddLoginResult(username, ip, protocol string, err error) {
+	tracker := Config.loginFailures
+	if tracker == nil || username == "" {
+		return
+	}
+	if err == nil {
+		tracker.addSuccess(username)
+		return
+	}
+	if errors.Is(err, util.ErrNotFound) || errors.Is(err, ErrPublicKeyRequired) || errors.Is(err, dataprovider.ErrAccountLocked) {
+		return
+	}
+	if !tracker.addFailure(username, ip, protocol) {
+		return
+	}
+	logger.Warn(logSender, "", "too many login failures for user %q, last attempt from ip %q, protocol %s, elevated state for %d minutes",
+		username, ip, protocol, tracker.config.ElevatedTime)
+	metric.UpdateLoginFailuresElevatedAccounts(tracker.countElevated())
+	eventManager.handleLoginFailuresEvent(EventParams{
+		Name:      username,
+		Event:     loginFailuresEventName,
+		Protocol:  protocol,
+		IP:        ip,
+		Timestamp: time.Now(),
+		Status:    1,
+	})
+}
+
+// CheckLoginFailuresRestrictions returns ErrPublicKeyRequired if the given SSH
+// login method is not allowed because the account is in the elevated state
+func CheckLoginFailuresRestrictions(username, loginMethod string) error {
+	tracker := Config.loginFailures
+	if tracker == nil || !tracker.config.RequirePublicKey {
+		return nil
+	}
+	if loginMethod != dataprovider.LoginMethodPassword && loginMethod != dataprovider.SSHLoginMethodKeyboardInteractive {
+		return nil
+	}
+	if tracker.isElevated(username) {
+		return ErrPublicKeyRequired
+	}
+	return nil
+}
+
+// GetMostAttackedUsernames returns up to limit usernames with the most login
+// failures, a limit <= 0 means no limit. An empty list is returned if the login
+// failures tracking is disabled
+func GetMostAttackedUsernames(limit int) []LoginFailuresEntry {
+	tracker := Config.loginFailures
+	if tracker == nil {
+		return nil
+	}
+	return tracker.getMostAttacked(limit)
+}
@@ -0,0 +1,174 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+func TestLoginFailuresConfig(t *testing.T) {
+	c := LoginFailuresConfig{
+		Enabled:         true,
+		ObservationTime: 1,
+		ElevatedTime:    1,
+		EntriesLimit:    1,
+	}
+	assert.Error(t, c.initialize())
+	c.Threshold = 1
+	c.ObservationTime = 0
+	assert.Error(t, c.initialize())
+	c.ObservationTime = 1
+	c.ElevatedTime = 0
+	assert.Error(t, c.initialize())
+	c.ElevatedTime = 1
+	c.EntriesLimit = 0
+	assert.Error(t, c.initialize())
+	assert.Nil(t, Config.loginFailures)
+	c.EntriesLimit = 1
+	assert.NoError(t, c.initialize())
+	assert.NotNil(t, Config.loginFailures)
+	Config.loginFailures = nil
+	// disabled
+	assert.Nil(t, GetMostAttackedUsernames(0))
+	AddLoginResult("user", "127.0.0.1", ProtocolSSH, errors.New("login failed"))
+	assert.NoError(t, CheckLoginFailuresRestrictions("user", dataprovider.LoginMethodPassword))
+}
+
+func TestLoginFailures(t *testing.T) {
+	Config.loginFailures = newLoginFailuresTracker(LoginFailuresConfig{
+		Enabled:          true,
+		Threshold:        3,
+		ObservationTime:  30,
+		ElevatedTime:     60,
+		RequirePublicKey: true,
+		EntriesLimit:     3,
+	})
+	defer func() {
+		Config.loginFailures = nil
+	}()
+
+	loginErr := errors.New("login failed")
+	username := "attacked_user"
+	for i := range 2 {
+		AddLoginResult(username, fmt.Sprintf("192.168.1.%d", i), ProtocolSSH, loginErr)
+	}
+	// non-existent users and empty usernames are ignored
+	AddLoginResult("missing_user", "127.0.0.1", ProtocolSSH, util.NewRecordNotFoundError("not found"))
+	AddLoginResult("", "127.0.0.1", ProtocolSSH, loginErr)
+	entries := GetMostAttackedUsernames(0)
+	require.Len(t, entries, 1)
+	assert.Equal(t, username, entries[0].Username)
+	assert.Equal(t, 2, entries[0].Failures)
+	assert.Equal(t, "192.168.1.1", entries[0].LastIP)
+	assert.True(t, entries[0].ElevatedUntil.IsZero())
+	assert.NoError(t, CheckLoginFailuresRestrictions(username, dataprovider.LoginMethodPassword))
+	// a successful login decays the failures
+	AddLoginResult(username, "127.0.0.1", ProtocolFTP, nil)
+	entries = GetMostAttackedUsernames(0)
+	require.Len(t, entries, 1)
+	assert.Equal(t, 1, entries[0].Failures)
+	assert.Equal(t, int64(2), entries[0].TotalFailures)
+
+	for i := range 2 {
+		AddLoginResult(username, fmt.Sprintf("10.0.0.%d", i), ProtocolWebDAV, loginErr)
+	}
+	entries = GetMostAttackedUsernames(0)
+	require.Len(t, entries, 1)
+	assert.Equal(t, 3, entries[0].Failures)
+	assert.Equal(t, ProtocolWebDAV, entries[0].LastProtocol)
+	assert.False(t, entries[0].ElevatedUntil.IsZero())
+	assert.Equal(t, 1, Config.loginFailures.countElevated())
+	err := CheckLoginFailuresRestrictions(username, dataprovider.LoginMethodPassword)
+	assert.ErrorIs(t, err, ErrPublicKeyRequired)
+	err = CheckLoginFailuresRestrictions(username, dataprovider.SSHLoginMethodKeyboardInteractive)
+	assert.ErrorIs(t, err, ErrPublicKeyRequired)
+	assert.NoError(t, CheckLoginFailuresRestrictions(username, dataprovider.SSHLoginMethodPublicKey))
+	assert.NoError(t, CheckLoginFailuresRestrictions(username, dataprovider.SSHLoginMethodKeyAndPassword))
+	assert.NoError(t, CheckLoginFailuresRestrictions("other_user", dataprovider.LoginMethodPassword))
+	// the rejected logins are not counted
+	AddLoginResult(username, "10.0.0.1", ProtocolSSH, ErrPublicKeyRequired)
+	entries = GetMostAttackedUsernames(0)
+	require.Len(t, entries, 1)
+	assert.Equal(t, int64(4), entries[0].TotalFailures)
+	// further failures do not change the elevated state
+	elevatedUntil := entries[0].ElevatedUntil
+	AddLoginResult(username, "10.0.0.1", ProtocolSSH, loginErr)
+	entries = GetMostAttackedUsernames(0)
+	require.Len(t, entries, 1)
+	assert.Equal(t, 3, entries[0].Failures)
+	assert.Equal(t, elevatedUntil, entries[0].ElevatedUntil)
+	// a successful login does not remove the elevated state
+	AddLoginResult(username, "127.0.0.1", ProtocolSSH, nil)
+	AddLoginResult(username, "127.0.0.1", ProtocolSSH, nil)
+	AddLoginResult(username, "127.0.0.1", ProtocolSSH, nil)
+	assert.ErrorIs(t, CheckLoginFailuresRestrictions(username, dataprovider.LoginMethodPassword), ErrPublicKeyRequired)
+
+	AddLoginResult("user1", "127.0.0.1", ProtocolSSH, loginErr)
+	AddLoginResult("user2", "127.0.0.1", ProtocolSSH, loginErr)
+	AddLoginResult("user2", "127.0.0.1", ProtocolSSH, loginErr)
+	entries = GetMostAttackedUsernames(2)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "user2", entries[0].Username)
+	// the successful logins decayed the failures for the attacked user
+	assert.Equal(t, "user1", entries[1].Username)
+	// the least recently used username is evicted
+	AddLoginResult("user3", "127.0.0.1", ProtocolSSH, loginErr)
+	entries = GetMostAttackedUsernames(0)
+	require.Len(t, entries, 3)
+	for _, entry := range entries {
+		assert.NotEqual(t, username, entry.Username)
+	}
+	assert.NoError(t, CheckLoginFailuresRestrictions(username, dataprovider.LoginMethodPassword))
+}
+
+func TestLoginFailuresCleanup(t *testing.T) {
+	tracker := newLoginFailuresTracker(LoginFailuresConfig{
+		Enabled:         true,
+		Threshold:       2,
+		ObservationTime: 1,
+		ElevatedTime:    1,
+		EntriesLimit:    10,
+	})
+	assert.False(t, tracker.addFailure("user1", "127.0.0.1", ProtocolSSH))
+	assert.True(t, tracker.addFailure("user1", "127.0.0.1", ProtocolSSH))
+	assert.False(t, tracker.addFailure("user2", "127.0.0.1", ProtocolSSH))
+	tracker.cleanup()
+	assert.Len(t, tracker.getMostAttacked(0), 2)
+	// simulate expired failures and elevated state
+	tracker.mu.Lock()
+	for _, elem := range tracker.entries {
+		f := elem.Value.(*loginFailures)
+		for idx := range f.failures {
+			f.failures[idx] = f.failures[idx].Add(-2 * time.Minute)
+		}
+		f.elevatedUntil = time.Time{}
+	}
+	tracker.mu.Unlock()
+	tracker.cleanup()
+	assert.Len(t, tracker.getMostAttacked(0), 0)
+	assert.Equal(t, 0, tracker.countElevated())
+	// a success for an untracked username is a no-op
+	tracker.addSuccess("user1")
+	assert.False(t, tracker.isElevated("user1"))
+}
@@ -0,0 +1,155 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	// MaxDebugLogOverrides defines the maximum number of debug log overrides
+	MaxDebugLogOverrides = 100
+)
+
+var debugLogOverrides debugLogOverridesList
+
+// DebugLogOverride defines a username and/or a source IP for which the
+// connection logs are written at debug level regardless of the configured
+// log level. If both the username and the IP are set, both must match
+type DebugLogOverride struct {
+	Username string `json:"username,omitempty"`
+	IP       string `json:"ip,omitempty"`
+	// Expiration as unix timestamp in milliseconds, 0 means no expiration
+	ExpiresAt int64 `json:"expires_at,omitempty"`
+}
+
+func (o *DebugLogOverride) validate() error {
+	if o.Username == "" && o.IP == "" {
+		return util.NewValidationError("a username or an IP address is required")
+	}
+	if o.IP != "" && net.ParseIP(o.IP) == nil {
+		return util.NewValidationError(fmt.Sprintf("invalid IP address %q", o.IP))
+	}
+	if o.ExpiresAt < 0 {
+		return util.NewValidationError(fmt.Sprintf("invalid expiration %d", o.ExpiresAt))
+	}
+	return nil
+}
+
+func (o *DebugLogOverride) isExpired(now int64) bool {
+	return o.ExpiresAt > 0 && o.ExpiresAt < now
+}
+
+func (o *DebugLogOverride) matches(username, ip string) bool {
+	if o.Username != "" && o.Username != username {
+		return false
+	}
+	if o.IP != "" && o.IP != ip {
+		return false
+	}
+	return true
+}
+
+type debugLogOverridesList struct {
+	sync.RWMutex
+	// size allows to skip the lock when no override is defined,
+	// this is the common case
+	size      atomic.Int32
+	overrides []DebugLogOverride
+}
+
+func (l *debugLogOverridesList) get() []DebugLogOverride {
+	l.RLock()
+	defer l.RUnlock()
+
+	now := util.GetTimeAsMsSinceEpoch(time.Now())
+	result := make([]DebugLogOverride, 0, len(l.overrides))
+	for _, o := range l.overrides {
+		if !o.isExpired(now) {
+			result = append(result, o)
+		}
+	}
+	return result
+}
+
+func (l *debugLogOverridesList) set(overrides []DebugLogOverride) error {
+	if len(overrides) > MaxDebugLogOverrides {
+		return util.NewValidationError(fmt.Sprintf("too many debug log overrides: %d, max allowed: %d",
+			len(overrides), MaxDebugLogOverrides))
+	}
+	now := util.GetTimeAsMsSinceEpoch(time.Now())
+	validOverrides := make([]DebugLogOverride, 0, len(overrides))
+	for _, o := range overrides {
+		if err := o.validate(); err != nil {
+			return err
+		}
+		if o.isExpired(now) {
+			continue
+		}
+		validOverrides = append(validOverrides, o)
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	l.overrides = validOverrides
+	l.size.Store(int32(len(validOverrides)))
+	return nil
+}
+
+func (l *debugLogOverridesList) isDebugForced(username, ip string) bool {
+	if l.size.Load() == 0 {
+		return false
+	}
+
+	l.RLock()
+	defer l.RUnlock()
+
+	now := util.GetTimeAsMsSinceEpoch(time.Now())
+	for _, o := range l.overrides {
+		if !o.isExpired(now) && o.matches(username, ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetDebugLogOverrides returns the configured, not expired, debug log overrides
+func GetDebugLogOverrides() []DebugLogOverride {
+	return debugLogOverrides.get()
+}
+
+// SetDebugLogOverrides replaces the debug log overrides with the specified ones.
+// An empty list removes all the existing overrides
+func SetDebugLogOverrides(overrides []DebugLogOverride) error {
+	if err := debugLogOverrides.set(overrides); err != nil {
+		return err
+	}
+	logger.Info(logSender, "", "debug log overrides updated, active overrides: %d", debugLogOverrides.size.Load())
+	return nil
+}
+
+// IsDebugLogForced returns true if the connection logs for the specified
+// username and IP must be written at debug level
+func IsDebugLogForced(username, ip string) bool {
+	return debugLogOverrides.isDebugForced(username, ip)
+}
@@ -0,0 +1,96 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/sftpgo/sdk"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+func TestDebugLogOverrides(t *testing.T) {
+	assert.Len(t, GetDebugLogOverrides(), 0)
+	assert.False(t, IsDebugLogForced("user", "127.0.0.1"))
+
+	now := time.Now()
+	err := SetDebugLogOverrides([]DebugLogOverride{
+		{
+			Username: "user1",
+		},
+		{
+			IP: "192.168.1.1",
+		},
+		{
+			Username: "user2",
+			IP:       "192.168.1.2",
+		},
+		{
+			Username:  "user3",
+			ExpiresAt: util.GetTimeAsMsSinceEpoch(now.Add(-1 * time.Minute)),
+		},
+		{
+			Username:  "user4",
+			ExpiresAt: util.GetTimeAsMsSinceEpoch(now.Add(1 * time.Minute)),
+		},
+	})
+	assert.NoError(t, err)
+	// the expired override is not stored
+	assert.Len(t, GetDebugLogOverrides(), 4)
+	assert.True(t, IsDebugLogForced("user1", "127.0.0.1"))
+	assert.True(t, IsDebugLogForced("user", "192.168.1.1"))
+	assert.True(t, IsDebugLogForced("user2", "192.168.1.2"))
+	assert.False(t, IsDebugLogForced("user2", "192.168.1.3"))
+	assert.False(t, IsDebugLogForced("user3", "127.0.0.1"))
+	assert.True(t, IsDebugLogForced("user4", "127.0.0.1"))
+
+	conn := NewBaseConnection("", ProtocolSFTP, "", "10.8.0.1:1234", dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: "user1",
+		},
+	})
+	assert.True(t, conn.IsDebugLogForced())
+	conn = NewBaseConnection("", ProtocolSFTP, "", "192.168.1.2:1234", dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: "user",
+		},
+	})
+	assert.False(t, conn.IsDebugLogForced())
+
+	err = SetDebugLogOverrides([]DebugLogOverride{{}})
+	assert.ErrorIs(t, err, util.ErrValidation)
+	err = SetDebugLogOverrides([]DebugLogOverride{{IP: "invalid"}})
+	assert.ErrorIs(t, err, util.ErrValidation)
+	err = SetDebugLogOverrides([]DebugLogOverride{{Username: "user", ExpiresAt: -1}})
+	assert.ErrorIs(t, err, util.ErrValidation)
+	var overrides []DebugLogOverride
+	for i := 0; i <= MaxDebugLogOverrides; i++ {
+		overrides = append(overrides, DebugLogOverride{Username: fmt.Sprintf("user%d", i)})
+	}
+	err = SetDebugLogOverrides(overrides)
+	assert.ErrorIs(t, err, util.ErrValidation)
+	// the previous overrides are preserved after a validation error
+	assert.Len(t, GetDebugLogOverrides(), 4)
+
+	err = SetDebugLogOverrides(nil)
+	assert.NoError(t, err)
+	assert.Len(t, GetDebugLogOverrides(), 0)
+	assert.False(t, IsDebugLogForced("user1", "127.0.0.1"))
+}
@@ -0,0 +1,306 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/robfig/cron/v3"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/metric"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	// prefix for the data provider tasks used as leases for the maintenance jobs
+	maintenanceJobTaskPrefix = "__job_"
+	// interval to renew the lease while a job is running
+	maintenanceJobLeaseRenewInterval = time.Minute
+	// a lease not renewed within this time is expired, for example
+	// because the instance running the job died
+	maintenanceJobLeaseTimeout = 3 * time.Minute
+	// maximum allowed difference between the due time and the scheduled run
+	maintenanceJobDueTolerance = 5 * time.Second
+)
+
+var (
+	maintenanceJobsMu sync.RWMutex
+	maintenanceJobs   []*maintenanceJob
+)
+
+// MaintenanceJobStatus defines the status of a maintenance job on this instance
+type MaintenanceJobStatus struct {
+	Name string `json:"name"`
+	// Interval between two executions as seconds
+	Interval int64 `json:"interval"`
+	Running  bool  `json:"running"`
+	// Last execution start time as unix timestamp in milliseconds.
+	// 0 means that the job was never executed on this instance
+	LastRun int64 `json:"last_run,omitempty"`
+	// Last execution duration as milliseconds
+	LastDuration int64  `json:"last_duration,omitempty"`
+	LastError    string `json:"last_error,omitempty"`
+	// Next scheduled execution as unix timestamp in milliseconds
+	NextRun  int64 `json:"next_run,omitempty"`
+	Runs     int64 `json:"runs"`
+	Failures int64 `json:"failures"`
+	// Executions skipped because the job was running on this or another instance
+	Skipped int64 `json:"skipped"`
+}
+
+// maintenanceJob is a named job executed at a fixed interval.
+// For shared data providers a lease, stored as data provider task, makes
+// sure that the job runs on a single instance at a time
+type maintenanceJob struct {
+	name     string
+	interval time.Duration
+	// the context is canceled if the lease is lost while the job is running
+	fn                 func(ctx context.Context) error
+	leaseRenewInterval time.Duration
+	running            atomic.Bool
+	entryID            cron.EntryID
+	mu                 sync.RWMutex
+	status             MaintenanceJobStatus
+}
+
+func newMaintenanceJob(name string, interval time.Duration, fn func(ctx context.Context) error) *maintenanceJob {
+	return &maintenanceJob{
+		name:               name,
+		interval:           interval,
+		fn:                 fn,
+		leaseRenewInterval: maintenanceJobLeaseRenewInterval,
+		status: MaintenanceJobStatus{
+			Name:     name,
+			Interval: int64(interval / time.Second),
+		},
+	}
+}
+
+func (j *maintenanceJob) getTaskName() string {
+	return maintenanceJobTaskPrefix + j.name
+}
+
+// getNextDueTime returns the time after which the lease can be acquired again
+// for a run started at the specified time. The lease stores this time, so the
+// job runs once per interval even if the instances have offset schedules
+func (j *maintenanceJob) getNextDueTime(startTime time.Time) time.Time {
+	return startTime.Add(j.interval - min(maintenanceJobDueTolerance, j.interval/10))
+}
+
+func (j *maintenanceJob) run() {
+	if !j.running.CompareAndSwap(false, true) {
+		logger.Info(logSender, "", "maintenance job %q already in progress, skipping", j.name)
+		j.addSkipped()
+		return
+	}
+	defer j.running.Store(false)
+
+	ctx, release, ok := j.acquireLease()
+	if !ok {
+		j.addSkipped()
+		return
+	}
+	defer release()
+
+	startTime := time.Now()
+	err := j.fn(ctx)
+	elapsed := time.Since(startTime)
+	j.setResult(startTime, elapsed, err)
+	metric.AddMaintenanceJobRun(j.name, elapsed, err)
+	if err != nil {
+		logger.Warn(logSender, "", "maintenance job %q failed after %s: %v", j.name, elapsed, err)
+		return
+	}
+	logger.Debug(logSender, "", "maintenance job %q completed in %s", j.name, elapsed)
+}
+
+// acquireLease returns true if the job can run on this instance. The returned
+// context is canceled if the lease cannot be renewed while the job is running,
+// the returned function must be called when the job completes
+func (j *maintenanceJob) acquireLease() (context.Context, func(), bool) {
+	providerConf := dataprovider.GetProviderConfig()
+	if providerConf.GetShared() == 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		return ctx, cancel, true
+	}
+	return j.acquireProviderLease()
+}
+
+func (j *maintenanceJob) acquireProviderLease() (context.Context, func(), bool) {
+	taskName := j.getTaskName()
+	task, err := dataprovider.GetTaskByName(taskName)
+	if err != nil {
+		if !errors.Is(err, util.ErrNotFound) {
+			logger.Warn(logSender, "", "unable to get the lease for maintenance job %q: %v", j.name, err)
+			return nil, nil, false
+		}
+		if err := dataprovider.AddTask(taskName); err != nil {
+			logger.Warn(logSender, "", "unable to add the lease for maintenance job %q: %v", j.name, err)
+			return nil, nil, false
+		}
+		task = dataprovider.Task{
+			Name: taskName,
+		}
+	}
+	// the lease timestamp is the time after which the lease can be acquired:
+	// the lease expiration while the job is running, the next due time otherwise
+	notBefore := util.GetTimeFromMsecSinceEpoch(task.UpdateAt)
+	startTime := time.Now()
+	if notBefore.After(startTime) {
+		logger.Debug(logSender, "", "lease for maintenance job %q not available before %s, skip execution",
+			j.name, notBefore)
+		return nil, nil, false
+	}
+	// the version check makes sure that only one instance acquires an expired lease
+	err = dataprovider.UpdateTaskWithTimestamp(taskName, task.Version, startTime.Add(maintenanceJobLeaseTimeout))
+	if err != nil {
+		logger.Info(logSender, "", "unable to acquire the lease for maintenance job %q, skip execution, err: %v",
+			j.name, err)
+		return nil, nil, false
+	}
+	// each successful update increments the version. Renewals and release are
+	// version checked too, so a lease expired and acquired by another instance
+	// is never overwritten
+	version := task.Version + 1
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		ticker := time.NewTicker(j.leaseRenewInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				err := dataprovider.UpdateTaskWithTimestamp(taskName, version, time.Now().Add(maintenanceJobLeaseTimeout))
+				if err != nil {
+					logger.Warn(logSender, "", "unable to renew the lease for maintenance job %q, canceling execution: %v",
+						j.name, err)
+					cancel()
+					return
+				}
+				version++
+				logger.Debug(logSender, "", "renewed the lease for maintenance job %q", j.name)
+			}
+		}
+	}()
+
+	return ctx, func() {
+		cancel()
+		wg.Wait()
+		// a job running longer than its interval can be acquired again as soon as it completes
+		if err := dataprovider.UpdateTaskWithTimestamp(taskName, version, j.getNextDueTime(startTime)); err != nil {
+			logger.Warn(logSender, "", "unable to release the lease for maintenance job %q: %v", j.name, err)
+		}
+	}, true
+}
+
+func (j *maintenanceJob) setResult(startTime time.Time, elapsed time.Duration, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.status.Runs++
+	j.status.LastRun = util.GetTimeAsMsSinceEpoch(startTime)
+	j.status.LastDuration = elapsed.Milliseconds()
+	j.status.LastError = ""
+	if err != nil {
+		j.status.Failures++
+		j.status.LastError = err.Error()
+	}
+}
+
+func (j *maintenanceJob) addSkipped() {
+	j.mu.Lock()
+	j.status.Skipped++
+	j.mu.Unlock()
+
+	metric.AddMaintenanceJobSkipped(j.name)
+}
+
+func (j *maintenanceJob) getStatus() MaintenanceJobStatus {
+	j.mu.RLock()
+	status := j.status
+	j.mu.RUnlock()
+
+	status.Running = j.running.Load()
+	if scheduler := getEventScheduler(); scheduler != nil {
+		if next := scheduler.Entry(j.entryID).Next; !next.IsZero() {
+			status.NextRun = util.GetTimeAsMsSinceEpoch(next)
+		}
+	}
+	return status
+}
+
+// scheduleMaintenanceJob schedules fn to run at the specified interval,
+// replacing any existing job with the same name
+func scheduleMaintenanceJob(name string, interval time.Duration, fn func(ctx context.Context) error) {
+	job := newMaintenanceJob(name, interval, fn)
+	spec := fmt.Sprintf("@every %s", interval)
+	scheduler := getEventScheduler()
+	entryID, err := scheduler.AddFunc(spec, job.run)
+	util.PanicOnError(err)
+	job.entryID = entryID
+
+	maintenanceJobsMu.Lock()
+	defer maintenanceJobsMu.Unlock()
+
+	maintenanceJobs = slices.DeleteFunc(maintenanceJobs, func(j *maintenanceJob) bool {
+		if j.name == name {
+			scheduler.Remove(j.entryID)
+			return true
+		}
+		return false
+	})
+	maintenanceJobs = append(maintenanceJobs, job)
+	logger.Info(logSender, "", "scheduled maintenance job %q, schedule %q", name, spec)
+}
+
+// resetMaintenanceJobs removes all the jobs, they are scheduled again
+// after a scheduler restart
+func resetMaintenanceJobs() {
+	maintenanceJobsMu.Lock()
+	defer maintenanceJobsMu.Unlock()
+
+	maintenanceJobs = nil
+}
+
+// GetMaintenanceJobsStatus returns the status of the scheduled maintenance jobs
+func GetMaintenanceJobsStatus() []MaintenanceJobStatus {
+	maintenanceJobsMu.RLock()
+	defer maintenanceJobsMu.RUnlock()
+
+	result := make([]MaintenanceJobStatus, 0, len(maintenanceJobs))
+	for _, job := range maintenanceJobs {
+		result = append(result, job.getStatus())
+	}
+	slices.SortFunc(result, func(a, b MaintenanceJobStatus) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return result
+}
@@ -0,0 +1,209 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+func TestMaintenanceJobRun(t *testing.T) {
+	var jobErr error
+	calls := 0
+	job := newMaintenanceJob("test_job", 2*time.Hour, func(_ context.Context) error {
+		calls++
+		return jobErr
+	})
+	assert.Equal(t, maintenanceJobTaskPrefix+"test_job", job.getTaskName())
+	now := time.Now()
+	assert.Equal(t, 2*time.Hour-maintenanceJobDueTolerance, job.getNextDueTime(now).Sub(now))
+	assert.Equal(t, 55*time.Second, newMaintenanceJob("a", time.Minute, nil).getNextDueTime(now).Sub(now))
+	// a non shared provider does not require a lease
+	job.run()
+	assert.Equal(t, 1, calls)
+	status := job.getStatus()
+	assert.Equal(t, "test_job", status.Name)
+	assert.Equal(t, int64(7200), status.Interval)
+	assert.False(t, status.Running)
+	assert.Greater(t, status.LastRun, int64(0))
+	assert.Empty(t, status.LastError)
+	assert.Equal(t, int64(1), status.Runs)
+	assert.Equal(t, int64(0), status.Failures)
+	assert.Equal(t, int64(0), status.NextRun)
+
+	jobErr = errors.New("job error")
+	job.run()
+	assert.Equal(t, 2, calls)
+	status = job.getStatus()
+	assert.Equal(t, jobErr.Error(), status.LastError)
+	assert.Equal(t, int64(2), status.Runs)
+	assert.Equal(t, int64(1), status.Failures)
+	// the last error is cleared after a successful run
+	jobErr = nil
+	job.run()
+	status = job.getStatus()
+	assert.Empty(t, status.LastError)
+	assert.Equal(t, int64(3), status.Runs)
+	assert.Equal(t, int64(1), status.Failures)
+	// a job already in progress must be skipped
+	job.running.Store(true)
+	job.run()
+	assert.Equal(t, 3, calls)
+	status = job.getStatus()
+	assert.True(t, status.Running)
+	assert.Equal(t, int64(1), status.Skipped)
+	job.running.Store(false)
+}
+
+func TestScheduleMaintenanceJob(t *testing.T) {
+	startEventScheduler()
+	defer stopEventScheduler()
+
+	jobName := "test_scheduled_job"
+	fn := func(_ context.Context) error { return nil }
+	scheduleMaintenanceJob(jobName, time.Hour, fn)
+	// scheduling a job with the same name replaces the existing one
+	scheduleMaintenanceJob(jobName, 2*time.Hour, fn)
+
+	var job *maintenanceJob
+	maintenanceJobsMu.RLock()
+	for _, j := range maintenanceJobs {
+		if j.name == jobName {
+			assert.Nil(t, job)
+			job = j
+		}
+	}
+	maintenanceJobsMu.RUnlock()
+	require.NotNil(t, job)
+
+	idx := slices.IndexFunc(GetMaintenanceJobsStatus(), func(s MaintenanceJobStatus) bool {
+		return s.Name == jobName
+	})
+	require.GreaterOrEqual(t, idx, 0)
+	status := GetMaintenanceJobsStatus()[idx]
+	assert.Equal(t, int64(7200), status.Interval)
+	assert.Greater(t, status.NextRun, util.GetTimeAsMsSinceEpoch(time.Now().Add(time.Hour)))
+	assert.Equal(t, int64(0), status.LastRun)
+
+	// restarting the scheduler removes the jobs
+	startEventScheduler()
+	assert.Len(t, GetMaintenanceJobsStatus(), 0)
+}
+
+func TestMaintenanceJobLease(t *testing.T) {
+	providerConf := dataprovider.GetProviderConfig()
+	providerConf.IsShared = 1
+	if providerConf.GetShared() == 0 {
+		t.Skip("this test is not supported with the current database provider")
+	}
+	err := dataprovider.Close()
+	assert.NoError(t, err)
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	require.NoError(t, err)
+
+	calls := 0
+	job := newMaintenanceJob("test_lease_job", time.Minute, func(_ context.Context) error {
+		calls++
+		return nil
+	})
+	job.run()
+	assert.Equal(t, 1, calls)
+	task, err := dataprovider.GetTaskByName(job.getTaskName())
+	assert.NoError(t, err)
+	// the lease stores the next due time
+	assert.Greater(t, task.UpdateAt, util.GetTimeAsMsSinceEpoch(time.Now().Add(50*time.Second)))
+	// the job is not due yet, another run must be skipped
+	job.run()
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, int64(1), job.getStatus().Skipped)
+	// another instance with an offset schedule cannot run the job again within the interval
+	err = dataprovider.SetTaskTimestamp(job.getTaskName(), time.Now().Add(30*time.Second))
+	assert.NoError(t, err)
+	job.run()
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, int64(2), job.getStatus().Skipped)
+	// a due lease is acquired
+	err = dataprovider.SetTaskTimestamp(job.getTaskName(), time.Now().Add(-time.Second))
+	assert.NoError(t, err)
+	job.run()
+	assert.Equal(t, 2, calls)
+	// only one instance can acquire a due lease
+	task, err = dataprovider.GetTaskByName(job.getTaskName())
+	assert.NoError(t, err)
+	err = dataprovider.UpdateTaskWithTimestamp(job.getTaskName(), task.Version, time.Now())
+	assert.NoError(t, err)
+	err = dataprovider.UpdateTaskWithTimestamp(job.getTaskName(), task.Version, time.Now())
+	assert.Error(t, err)
+
+	err = dataprovider.Close()
+	assert.NoError(t, err)
+	providerConf.IsShared = 0
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.NoError(t, err)
+}
+
+func TestMaintenanceJobLeaseRenewal(t *testing.T) {
+	job := newMaintenanceJob("test_lease_renewal_job", time.Minute, nil)
+	job.leaseRenewInterval = 50 * time.Millisecond
+	taskName := job.getTaskName()
+	if _, err := dataprovider.GetTaskByName(taskName); err == nil {
+		err = dataprovider.SetTaskTimestamp(taskName, time.Now().Add(-time.Second))
+		require.NoError(t, err)
+	}
+
+	ctx, release, ok := job.acquireProviderLease()
+	require.True(t, ok)
+	acquired, err := dataprovider.GetTaskByName(taskName)
+	require.NoError(t, err)
+	// the lease is renewed while the job is running
+	assert.Eventually(t, func() bool {
+		task, err := dataprovider.GetTaskByName(taskName)
+		return err == nil && task.Version > acquired.Version
+	}, time.Second, 10*time.Millisecond)
+	assert.NoError(t, ctx.Err())
+	release()
+	task, err := dataprovider.GetTaskByName(taskName)
+	require.NoError(t, err)
+	assert.Greater(t, task.UpdateAt, util.GetTimeAsMsSinceEpoch(time.Now().Add(50*time.Second)))
+	// another instance acquires the expired lease, the renewal must fail and cancel the job
+	err = dataprovider.SetTaskTimestamp(taskName, time.Now().Add(-time.Second))
+	require.NoError(t, err)
+	ctx, release, ok = job.acquireProviderLease()
+	require.True(t, ok)
+	task, err = dataprovider.GetTaskByName(taskName)
+	require.NoError(t, err)
+	otherInstanceExpiration := time.Now().Add(maintenanceJobLeaseTimeout)
+	err = dataprovider.UpdateTaskWithTimestamp(taskName, task.Version, otherInstanceExpiration)
+	require.NoError(t, err)
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		assert.Fail(t, "the job must be canceled if the lease cannot be renewed")
+	}
+	release()
+	// the lease of the other instance must be preserved
+	task, err = dataprovider.GetTaskByName(taskName)
+	require.NoError(t, err)
+	assert.Equal(t, util.GetTimeAsMsSinceEpoch(otherInstanceExpiration), task.UpdateAt)
+}
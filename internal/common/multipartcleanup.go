@@ -0,0 +1,132 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+// name of the maintenance job for the multipart uploads cleanup
+const multipartCleanupJobName = "s3_multipart_cleanup"
+
+// MultipartCleanupConfig defines the configuration for the periodic cleanup
+// of the incomplete S3 multipart uploads, left for example by server restarts.
+// For shared data providers the cleanup runs on a single instance at a time
+type MultipartCleanupConfig struct {
+	// Interval between two cleanups as minutes. 0 means disabled
+	CheckInterval int `json:"check_interval" mapstructure:"check_interval"`
+	// Multipart uploads initiated more than the specified hours ago are aborted
+	MaxAge int `json:"max_age" mapstructure:"max_age"`
+	// Maximum number of S3 requests, listings and aborts, per second.
+	// 0 means no limit
+	MaxOpsPerSecond int `json:"max_ops_per_second" mapstructure:"max_ops_per_second"`
+}
+
+func (c *MultipartCleanupConfig) validate() error {
+	if c.CheckInterval < 0 {
+		return fmt.Errorf("invalid multipart cleanup check interval: %d", c.CheckInterval)
+	}
+	if c.CheckInterval > 0 && c.MaxAge < 1 {
+		return fmt.Errorf("invalid multipart cleanup max age: %d", c.MaxAge)
+	}
+	if c.MaxOpsPerSecond < 0 {
+		return fmt.Errorf("invalid multipart cleanup max operations per second: %d", c.MaxOpsPerSecond)
+	}
+	return nil
+}
+
+func (c *MultipartCleanupConfig) getLimiter() *rate.Limiter {
+	if c.MaxOpsPerSecond == 0 {
+		return rate.NewLimiter(rate.Inf, 0)
+	}
+	return rate.NewLimiter(rate.Limit(c.MaxOpsPerSecond), 1)
+}
+
+func scheduleMultipartCleanup() {
+	if Config.MultipartCleanup.CheckInterval == 0 {
+		return
+	}
+	interval := time.Duration(Config.MultipartCleanup.CheckInterval) * time.Minute
+	scheduleMaintenanceJob(multipartCleanupJobName, interval, cleanupMultipartUploads)
+}
+
+func cleanupMultipartUploads(ctx context.Context) error {
+	filesystems, err := dataprovider.GetS3Filesystems()
+	if err != nil {
+		return fmt.Errorf("unable to get the S3 filesystems: %w", err)
+	}
+	filesystems = getUniqueS3Filesystems(filesystems)
+	initiatedBefore := time.Now().Add(-time.Duration(Config.MultipartCleanup.MaxAge) * time.Hour)
+	limiter := Config.MultipartCleanup.getLimiter()
+	total := 0
+	logger.Debug(logSender, "", "start multipart uploads cleanup for %d S3 filesystems", len(filesystems))
+
+	for _, config := range filesystems {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("multipart uploads cleanup interrupted, aborted uploads: %d: %w", total, err)
+		}
+		aborted, err := abortIncompleteUploads(ctx, config, initiatedBefore, limiter)
+		if err != nil {
+			logger.Warn(logSender, "", "multipart uploads cleanup error, bucket %q, key prefix %q: %v",
+				config.S3Config.Bucket, config.S3Config.KeyPrefix, err)
+		}
+		total += aborted
+	}
+	logger.Info(logSender, "", "multipart uploads cleanup completed for %d S3 filesystems, aborted uploads: %d",
+		len(filesystems), total)
+	return nil
+}
+
+func abortIncompleteUploads(ctx context.Context, config vfs.Filesystem, initiatedBefore time.Time,
+	limiter *rate.Limiter,
+) (int, error) {
+	fs, err := vfs.NewS3Fs("multipart_cleanup", "", "", config.S3Config)
+	if err != nil {
+		return 0, err
+	}
+	defer fs.Close()
+
+	cleaner, ok := fs.(vfs.MultipartUploadsCleaner)
+	if !ok {
+		return 0, errors.New("multipart uploads cleanup not supported")
+	}
+	return cleaner.AbortIncompleteUploads(ctx, initiatedBefore, limiter)
+}
+
+// getUniqueS3Filesystems removes the filesystems pointing to the same bucket
+// and key prefix, so each of them is checked once
+func getUniqueS3Filesystems(filesystems []vfs.Filesystem) []vfs.Filesystem {
+	seen := make(map[string]bool)
+	result := make([]vfs.Filesystem, 0, len(filesystems))
+	for _, config := range filesystems {
+		key := fmt.Sprintf("%s|%s|%s|%s", config.S3Config.Endpoint, config.S3Config.Region,
+			config.S3Config.Bucket, config.S3Config.KeyPrefix)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		result = append(result, config)
+	}
+	return result
+}
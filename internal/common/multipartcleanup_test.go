@@ -0,0 +1,66 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"testing"
+
+	"github.com/sftpgo/sdk"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/time/rate"
+
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+func TestMultipartCleanupConfig(t *testing.T) {
+	c := MultipartCleanupConfig{}
+	assert.NoError(t, c.validate())
+	assert.Equal(t, rate.Inf, c.getLimiter().Limit())
+	c.CheckInterval = -1
+	assert.Error(t, c.validate())
+	c.CheckInterval = 60
+	assert.Error(t, c.validate())
+	c.MaxAge = 24
+	c.MaxOpsPerSecond = -1
+	assert.Error(t, c.validate())
+	c.MaxOpsPerSecond = 5
+	assert.NoError(t, c.validate())
+	assert.Equal(t, rate.Limit(5), c.getLimiter().Limit())
+}
+
+func TestUniqueS3Filesystems(t *testing.T) {
+	getFs := func(bucket, prefix string) vfs.Filesystem {
+		return vfs.Filesystem{
+			Provider: sdk.S3FilesystemProvider,
+			S3Config: vfs.S3FsConfig{
+				BaseS3FsConfig: sdk.BaseS3FsConfig{
+					Bucket:    bucket,
+					Region:    "us-east-1",
+					KeyPrefix: prefix,
+				},
+			},
+		}
+	}
+	filesystems := getUniqueS3Filesystems([]vfs.Filesystem{
+		getFs("bucket1", "user1/"),
+		getFs("bucket1", "user2/"),
+		getFs("bucket1", "user1/"),
+		getFs("bucket2", "user1/"),
+	})
+	if assert.Len(t, filesystems, 3) {
+		assert.Equal(t, "user2/", filesystems[1].S3Config.KeyPrefix)
+		assert.Equal(t, "bucket2", filesystems[2].S3Config.Bucket)
+	}
+}
@@ -15,9 +15,13 @@
 package common_test
 
 import (
+	"archive/zip"
 	"bufio"
 	"bytes"
+	"crypto/ed25519"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -26,6 +30,7 @@ import (
 	"math"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
 	"path"
@@ -34,9 +39,13 @@ import (
 	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
 	_ "github.com/go-sql-driver/mysql"
 	_ "github.com/jackc/pgx/v5/stdlib"
 	_ "github.com/mattn/go-sqlite3"
@@ -142,6 +151,8 @@ func TestMain(m *testing.M) {
 
 	os.Setenv("SFTPGO_DATA_PROVIDER__CREATE_DEFAULT_ADMIN", "1")
 	os.Setenv("SFTPGO_COMMON__ALLOW_SELF_CONNECTIONS", "1")
+	os.Setenv("SFTPGO_DATA_PROVIDER__LOGIN_EVENTS__ENABLED", "1")
+	os.Setenv("SFTPGO_DATA_PROVIDER__CHANGE_FEED__ENABLED", "1")
 	os.Setenv("SFTPGO_DEFAULT_ADMIN_USERNAME", "admin")
 	os.Setenv("SFTPGO_DEFAULT_ADMIN_PASSWORD", "password")
 	err := config.LoadConfig(configDir, "")
@@ -533,6 +544,93 @@ func TestCheckFsAfterUpdate(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestCloudCredentialsRotation(t *testing.T) {
+	var accessKey atomic.Value
+	accessKey.Store("key1")
+	// minimal S3 server accepting only the current access key
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.Contains(auth, fmt.Sprintf("Credential=%s/", accessKey.Load())) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if r.Method != http.MethodHead {
+			w.WriteHeader(http.StatusNotImplemented)
+			return
+		}
+		w.Header().Set("Content-Length", "100")
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		w.Header().Set("ETag", `"etag"`)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	folderName := "s3folder"
+	vdirPath := "/vdirs3"
+	f := vfs.BaseVirtualFolder{
+		Name: folderName,
+		FsConfig: vfs.Filesystem{
+			Provider: sdk.S3FilesystemProvider,
+			S3Config: vfs.S3FsConfig{
+				BaseS3FsConfig: sdk.BaseS3FsConfig{
+					Bucket:         "bucket",
+					Region:         "us-east-1",
+					AccessKey:      "key1",
+					Endpoint:       server.URL,
+					ForcePathStyle: true,
+				},
+				AccessSecret: kms.NewPlainSecret("secret1"),
+			},
+		},
+	}
+	folder, _, err := httpdtest.AddFolder(f, http.StatusCreated)
+	assert.NoError(t, err)
+	u := getTestUser()
+	u.VirtualFolders = append(u.VirtualFolders, vfs.VirtualFolder{
+		BaseVirtualFolder: vfs.BaseVirtualFolder{
+			Name: folderName,
+		},
+		VirtualPath: vdirPath,
+		QuotaFiles:  -1,
+		QuotaSize:   -1,
+	})
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	conn, client, err := getSftpClient(user)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		info, err := client.Stat(path.Join(vdirPath, testFileName))
+		if assert.NoError(t, err) {
+			assert.Equal(t, int64(100), info.Size())
+		}
+		// rotate the credentials on the server side, the old ones are rejected
+		accessKey.Store("key2")
+		_, err = client.Stat(path.Join(vdirPath, testFileName))
+		assert.Error(t, err)
+		// update the folder, the existing session must use the new credentials
+		folder.FsConfig.S3Config.AccessKey = "key2"
+		folder.FsConfig.S3Config.AccessSecret = kms.NewPlainSecret("secret2")
+		_, _, err = httpdtest.UpdateFolder(folder, http.StatusOK)
+		assert.NoError(t, err)
+		info, err = client.Stat(path.Join(vdirPath, testFileName))
+		if assert.NoError(t, err) {
+			assert.Equal(t, int64(100), info.Size())
+		}
+		// the local filesystem is not affected
+		err = checkBasicSFTP(client)
+		assert.NoError(t, err)
+	}
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveFolder(vfs.BaseVirtualFolder{Name: folderName}, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestLoginAccessTime(t *testing.T) {
 	u := getTestUser()
 	u.Filters.AccessTime = []sdk.TimePeriod{
@@ -572,6 +670,169 @@ func TestLoginAccessTime(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestLoginEvents(t *testing.T) {
+	u := getTestUser()
+	// login events are kept after removing the user, a unique username
+	// ensures that only the events generated by this test are returned
+	u.Username = "login_events_" + xid.New().String()
+	u.HomeDir = filepath.Join(homeBasePath, u.Username)
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	user.Password = "wrong password"
+	_, _, err = getSftpClient(user)
+	assert.Error(t, err)
+	user.Password = defaultPassword
+	conn, client, err := getSftpClient(user)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+		assert.NoError(t, checkBasicSFTP(client))
+	}
+	assert.Eventually(t, func() bool {
+		events, err := dataprovider.GetLoginEvents(dataprovider.LoginEventFilter{
+			Username: user.Username,
+		})
+		return err == nil && len(events) == 2
+	}, 5*time.Second, 100*time.Millisecond)
+
+	events, err := dataprovider.GetLoginEvents(dataprovider.LoginEventFilter{
+		Username: user.Username,
+		Status:   dataprovider.LoginEventStatusFailed,
+	})
+	assert.NoError(t, err)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, "127.0.0.1", events[0].IP)
+		assert.Equal(t, common.ProtocolSSH, events[0].Protocol)
+		assert.Equal(t, dataprovider.LoginMethodPassword, events[0].LoginMethod)
+		assert.NotEmpty(t, events[0].ClientVersion)
+		assert.NotEmpty(t, events[0].Error)
+	}
+	events, err = dataprovider.GetLoginEvents(dataprovider.LoginEventFilter{
+		Username:       user.Username,
+		Status:         dataprovider.LoginEventStatusOK,
+		StartTimestamp: util.GetTimeAsMsSinceEpoch(time.Now().Add(-1 * time.Hour)),
+		Order:          dataprovider.OrderASC,
+	})
+	assert.NoError(t, err)
+	if assert.Len(t, events, 1) {
+		assert.Empty(t, events[0].Error)
+	}
+	events, err = dataprovider.GetLoginEvents(dataprovider.LoginEventFilter{
+		Username:     user.Username,
+		EndTimestamp: util.GetTimeAsMsSinceEpoch(time.Now().Add(-1 * time.Hour)),
+	})
+	assert.NoError(t, err)
+	assert.Len(t, events, 0)
+	_, err = dataprovider.GetLoginEvents(dataprovider.LoginEventFilter{
+		Status: 10,
+	})
+	assert.Error(t, err)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestObjectChanges(t *testing.T) {
+	prefix := "change_feed_" + xid.New().String()
+	var mu sync.Mutex
+	var received []dataprovider.ObjectChange
+	dataprovider.AddObjectChangeSubscriber(func(change dataprovider.ObjectChange) {
+		if !strings.HasPrefix(change.ObjectName, prefix) {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+
+		received = append(received, change)
+	})
+	g := dataprovider.Group{
+		BaseGroup: sdk.BaseGroup{
+			Name: prefix + "_group",
+		},
+	}
+	group, _, err := httpdtest.AddGroup(g, http.StatusCreated)
+	assert.NoError(t, err)
+	f := vfs.BaseVirtualFolder{
+		Name:       prefix + "_folder",
+		MappedPath: filepath.Join(os.TempDir(), prefix),
+	}
+	folder, _, err := httpdtest.AddFolder(f, http.StatusCreated)
+	assert.NoError(t, err)
+	u := getTestUser()
+	u.Username = prefix + "_user"
+	u.HomeDir = filepath.Join(homeBasePath, u.Username)
+	u.Groups = []sdk.GroupMapping{
+		{
+			Name: group.Name,
+			Type: sdk.GroupTypePrimary,
+		},
+	}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	user.AdditionalInfo = "updated"
+	_, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	folder.Description = "updated"
+	_, _, err = httpdtest.UpdateFolder(folder, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveGroup(group, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveFolder(folder, http.StatusOK)
+	assert.NoError(t, err)
+
+	expected := []struct {
+		objectType string
+		name       string
+		action     string
+	}{
+		{"group", group.Name, "add"},
+		{"folder", folder.Name, "add"},
+		{"user", user.Username, "add"},
+		{"user", user.Username, "update"},
+		{"folder", folder.Name, "update"},
+		{"user", user.Username, "delete"},
+		{"group", group.Name, "delete"},
+		{"folder", folder.Name, "delete"},
+	}
+	mu.Lock()
+	changes := slices.Clone(received)
+	mu.Unlock()
+	require.Len(t, changes, len(expected))
+	for idx, e := range expected {
+		assert.Equal(t, e.objectType, changes[idx].ObjectType)
+		assert.Equal(t, e.name, changes[idx].ObjectName)
+		assert.Equal(t, e.action, changes[idx].Action)
+		assert.Equal(t, "admin", changes[idx].Actor)
+		assert.Greater(t, changes[idx].Timestamp, int64(0))
+		if idx > 0 {
+			assert.Greater(t, changes[idx].ID, changes[idx-1].ID)
+		}
+	}
+	// the changes since a sequence identifier match the notified ones
+	feed, _, err := httpdtest.GetObjectChanges(changes[0].ID-1, 0, http.StatusOK)
+	assert.NoError(t, err)
+	feed = slices.DeleteFunc(feed, func(c dataprovider.ObjectChange) bool {
+		return !strings.HasPrefix(c.ObjectName, prefix)
+	})
+	assert.Equal(t, changes, feed)
+	feed, _, err = httpdtest.GetObjectChanges(changes[0].ID-1, 1, http.StatusOK)
+	assert.NoError(t, err)
+	if assert.Len(t, feed, 1) {
+		assert.Equal(t, changes[0], feed[0])
+	}
+	feed, err = dataprovider.GetObjectChanges(changes[len(changes)-1].ID, 10)
+	assert.NoError(t, err)
+	for _, c := range feed {
+		assert.Greater(t, c.ID, changes[len(changes)-1].ID)
+	}
+	_, _, err = httpdtest.GetObjectChanges(-1, 0, http.StatusBadRequest)
+	assert.NoError(t, err)
+}
+
 func TestSetStat(t *testing.T) {
 	u := getTestUser()
 	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
@@ -2722,6 +2983,155 @@ func TestTransferQuotaLimits(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestTransferQuotaWindow(t *testing.T) {
+	smtpCfg := smtp.Config{
+		Host:          "127.0.0.1",
+		Port:          2525,
+		From:          "notify@example.com",
+		TemplatesPath: "templates",
+	}
+	err := smtpCfg.Initialize(configDir, true)
+	require.NoError(t, err)
+	a1 := dataprovider.BaseEventAction{
+		Name: "action1",
+		Type: dataprovider.ActionTypeEmail,
+		Options: dataprovider.BaseEventActionOptions{
+			EmailConfig: dataprovider.EventActionEmailConfig{
+				Recipients: []string{"test@example.com"},
+				Subject:    `"{{.Event}}" from "{{.Name}}"`,
+				Body:       "Used size: {{.FileSize}}",
+			},
+		},
+	}
+	action1, _, err := httpdtest.AddEventAction(a1, http.StatusCreated)
+	assert.NoError(t, err)
+	r1 := dataprovider.EventRule{
+		Name:    "test transfer quota rule",
+		Status:  1,
+		Trigger: dataprovider.EventTriggerFsEvent,
+		Conditions: dataprovider.EventConditions{
+			FsEvents: []string{"transfer-quota-warning", "transfer-quota-exhausted"},
+		},
+		Actions: []dataprovider.EventAction{
+			{
+				BaseEventAction: dataprovider.BaseEventAction{
+					Name: action1.Name,
+				},
+				Order: 1,
+			},
+		},
+	}
+	rule1, _, err := httpdtest.AddEventRule(r1, http.StatusCreated)
+	assert.NoError(t, err)
+
+	u := getTestUser()
+	u.Filters.TransferQuotaWindow = dataprovider.TransferQuotaWindow{
+		Period:   "yearly",
+		Size:     1,
+		Timezone: "Europe/Rome",
+	}
+	_, _, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+	u.Filters.TransferQuotaWindow.Period = dataprovider.TransferQuotaWindowDaily
+	u.Filters.TransferQuotaWindow.Timezone = "Invalid/Zone"
+	_, _, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+	u.Filters.TransferQuotaWindow.Timezone = "Europe/Rome"
+	u.Filters.TransferQuotaWindow.EnforceDuringTransfer = true
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	status, _, err := httpdtest.GetTransferQuotaWindow(user, http.StatusOK)
+	assert.NoError(t, err)
+	assert.Equal(t, dataprovider.TransferQuotaWindowDaily, status.Period)
+	assert.Equal(t, int64(1048576), status.Size)
+	assert.Equal(t, int64(1048576), status.Remaining)
+	assert.Equal(t, int64(0), status.Used)
+	assert.Greater(t, status.End, status.Start)
+	conn, client, err := getSftpClient(user)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		testFileSize := int64(870000)
+		lastReceivedEmail.reset()
+		err = writeSFTPFileNoCheck(testFileName, testFileSize, client)
+		assert.NoError(t, err)
+		assert.Eventually(t, func() bool {
+			return lastReceivedEmail.get().From != ""
+		}, 1500*time.Millisecond, 100*time.Millisecond)
+		email := lastReceivedEmail.get()
+		assert.Contains(t, email.Data, fmt.Sprintf(`Subject: "transfer-quota-warning" from "%s"`, user.Username))
+		assert.Contains(t, email.Data, fmt.Sprintf("Used size: %d", testFileSize))
+
+		status, _, err = httpdtest.GetTransferQuotaWindow(user, http.StatusOK)
+		assert.NoError(t, err)
+		assert.Equal(t, testFileSize, status.Used)
+		assert.Equal(t, status.Size-testFileSize, status.Remaining)
+		// the download is aborted once the remaining budget is exhausted
+		lastReceivedEmail.reset()
+		f, err := client.Open(testFileName)
+		if assert.NoError(t, err) {
+			_, err = io.Copy(io.Discard, f)
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), common.ErrReadQuotaExceeded.Error())
+			}
+			f.Close() //nolint:errcheck
+		}
+		assert.Eventually(t, func() bool {
+			return lastReceivedEmail.get().From != ""
+		}, 1500*time.Millisecond, 100*time.Millisecond)
+		email = lastReceivedEmail.get()
+		assert.Contains(t, email.Data, fmt.Sprintf(`Subject: "transfer-quota-exhausted" from "%s"`, user.Username))
+		// new transfers are denied
+		lastReceivedEmail.reset()
+		_, err = client.Open(testFileName)
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), common.ErrReadQuotaExceeded.Error())
+		}
+		err = writeSFTPFile(testFileName, testFileSize, client)
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), common.ErrQuotaExceeded.Error())
+		}
+		// each threshold is notified once per window
+		assert.Never(t, func() bool {
+			return lastReceivedEmail.get().From != ""
+		}, 1000*time.Millisecond, 100*time.Millisecond)
+		status, _, err = httpdtest.GetTransferQuotaWindow(user, http.StatusOK)
+		assert.NoError(t, err)
+		assert.LessOrEqual(t, status.Remaining, int64(0))
+	}
+	// a bigger budget allows new transfers
+	user.Filters.TransferQuotaWindow.Size = 10
+	_, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	conn, client, err = getSftpClient(user)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		err = writeSFTPFile(testFileName, 65535, client)
+		assert.NoError(t, err)
+	}
+	user.Filters.TransferQuotaWindow = dataprovider.TransferQuotaWindow{}
+	_, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	_, _, err = httpdtest.GetTransferQuotaWindow(user, http.StatusNotFound)
+	assert.NoError(t, err)
+
+	_, err = httpdtest.RemoveEventRule(rule1, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveEventAction(action1, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+
+	smtpCfg = smtp.Config{}
+	err = smtpCfg.Initialize(configDir, true)
+	require.NoError(t, err)
+}
+
 func TestVirtualFoldersLink(t *testing.T) {
 	u := getTestUser()
 	mappedPath1 := filepath.Join(os.TempDir(), "vdir1")
@@ -7715,53 +8125,43 @@ func TestEventRuleIPBlocked(t *testing.T) {
 	assert.NoError(t, err)
 }
 
-func TestEventRuleRotateLog(t *testing.T) {
+func TestEventRuleLoginFailures(t *testing.T) {
+	oldConfig := config.GetCommonConfig()
+
+	cfg := config.GetCommonConfig()
+	cfg.LoginFailures.Enabled = true
+	cfg.LoginFailures.Threshold = 2
+	cfg.LoginFailures.RequirePublicKey = true
+
+	err := common.Initialize(cfg, 0)
+	assert.NoError(t, err)
+
 	smtpCfg := smtp.Config{
 		Host:          "127.0.0.1",
 		Port:          2525,
 		From:          "notification@example.com",
 		TemplatesPath: "templates",
 	}
-	err := smtpCfg.Initialize(configDir, true)
+	err = smtpCfg.Initialize(configDir, true)
 	require.NoError(t, err)
 
-	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
-	assert.NoError(t, err)
-
 	a1 := dataprovider.BaseEventAction{
-		Name: "a1",
-		Type: dataprovider.ActionTypeRotateLogs,
-	}
-	action1, _, err := httpdtest.AddEventAction(a1, http.StatusCreated)
-	assert.NoError(t, err)
-	a2 := dataprovider.BaseEventAction{
-		Name: "a2",
+		Name: "action1",
 		Type: dataprovider.ActionTypeEmail,
 		Options: dataprovider.BaseEventActionOptions{
 			EmailConfig: dataprovider.EventActionEmailConfig{
-				Recipients: []string{"success@example.net"},
-				Subject:    `OK`,
-				Body:       "OK action",
+				Recipients: []string{"test5@example.com"},
+				Subject:    `New "{{.Event}}"`,
+				Body:       "User: {{.Name}} IP: {{.IP}} Protocol: {{.Protocol}}",
 			},
 		},
 	}
-	action2, _, err := httpdtest.AddEventAction(a2, http.StatusCreated)
+	action1, _, err := httpdtest.AddEventAction(a1, http.StatusCreated)
 	assert.NoError(t, err)
-
 	r1 := dataprovider.EventRule{
-		Name:    "rule1",
+		Name:    "test rule login failures",
 		Status:  1,
-		Trigger: dataprovider.EventTriggerFsEvent,
-		Conditions: dataprovider.EventConditions{
-			FsEvents: []string{"mkdir"},
-			Options: dataprovider.ConditionOptions{
-				Names: []dataprovider.ConditionPattern{
-					{
-						Pattern: user.Username,
-					},
-				},
-			},
-		},
+		Trigger: dataprovider.EventTriggerLoginFailures,
 		Actions: []dataprovider.EventAction{
 			{
 				BaseEventAction: dataprovider.BaseEventAction{
@@ -7769,39 +8169,44 @@ func TestEventRuleRotateLog(t *testing.T) {
 				},
 				Order: 1,
 			},
-			{
-				BaseEventAction: dataprovider.BaseEventAction{
-					Name: action2.Name,
-				},
-				Order: 2,
-			},
 		},
 	}
-	rule1, resp, err := httpdtest.AddEventRule(r1, http.StatusCreated)
-	assert.NoError(t, err, string(resp))
-	conn, client, err := getSftpClient(user)
-	if assert.NoError(t, err) {
-		defer conn.Close()
-		defer client.Close()
+	rule1, _, err := httpdtest.AddEventRule(r1, http.StatusCreated)
+	assert.NoError(t, err)
 
-		lastReceivedEmail.reset()
-		err := client.Mkdir("just a test dir")
-		assert.NoError(t, err)
-		// just check that the action is executed
-		assert.Eventually(t, func() bool {
-			return lastReceivedEmail.get().From != ""
-		}, 1500*time.Millisecond, 100*time.Millisecond)
-		email := lastReceivedEmail.get()
-		assert.Len(t, email.To, 1)
-		assert.Contains(t, email.To, "success@example.net")
+	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
+	assert.NoError(t, err)
+	lastReceivedEmail.reset()
+
+	for i := 0; i < 2; i++ {
+		user.Password = "wrong_pwd"
+		_, _, err = getSftpClient(user)
+		assert.Error(t, err)
+	}
+	assert.Eventually(t, func() bool {
+		return lastReceivedEmail.get().From != ""
+	}, 3000*time.Millisecond, 100*time.Millisecond)
+	email := lastReceivedEmail.get()
+	assert.True(t, slices.Contains(email.To, "test5@example.com"))
+	assert.Contains(t, email.Data, `Subject: New "Login failures threshold"`)
+	assert.Contains(t, email.Data, fmt.Sprintf("User: %s IP: 127.0.0.1 Protocol: SSH", user.Username))
+	// only public key authentication is allowed now
+	user.Password = defaultPassword
+	_, _, err = getSftpClient(user)
+	assert.Error(t, err)
+	entries := common.GetMostAttackedUsernames(1)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, user.Username, entries[0].Username)
+		assert.Equal(t, 2, entries[0].Failures)
+		assert.False(t, entries[0].ElevatedUntil.IsZero())
 	}
+	err = common.CheckLoginFailuresRestrictions(user.Username, dataprovider.LoginMethodPassword)
+	assert.ErrorIs(t, err, common.ErrPublicKeyRequired)
 
 	_, err = httpdtest.RemoveEventRule(rule1, http.StatusOK)
 	assert.NoError(t, err)
 	_, err = httpdtest.RemoveEventAction(action1, http.StatusOK)
 	assert.NoError(t, err)
-	_, err = httpdtest.RemoveEventAction(action2, http.StatusOK)
-	assert.NoError(t, err)
 	_, err = httpdtest.RemoveUser(user, http.StatusOK)
 	assert.NoError(t, err)
 	err = os.RemoveAll(user.GetHomeDir())
@@ -7810,61 +8215,52 @@ func TestEventRuleRotateLog(t *testing.T) {
 	smtpCfg = smtp.Config{}
 	err = smtpCfg.Initialize(configDir, true)
 	require.NoError(t, err)
+
+	err = common.Initialize(oldConfig, 0)
+	assert.NoError(t, err)
 }
 
-func TestEventRuleInactivityCheck(t *testing.T) {
+func TestAccountLockout(t *testing.T) {
+	err := dataprovider.Close()
+	assert.NoError(t, err)
+	err = config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	providerConf := config.GetProviderConf()
+	providerConf.BackupsPath = backupsPath
+	providerConf.AccountLockout.MaxFailures = 3
+	providerConf.AccountLockout.Duration = 0
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.Error(t, err)
+	providerConf.AccountLockout.Duration = 15
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.NoError(t, err)
+
 	smtpCfg := smtp.Config{
 		Host:          "127.0.0.1",
 		Port:          2525,
 		From:          "notification@example.com",
 		TemplatesPath: "templates",
 	}
-	err := smtpCfg.Initialize(configDir, true)
+	err = smtpCfg.Initialize(configDir, true)
 	require.NoError(t, err)
 
-	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
-	assert.NoError(t, err)
-
 	a1 := dataprovider.BaseEventAction{
-		Name: "a1",
-		Type: dataprovider.ActionTypeUserInactivityCheck,
-		Options: dataprovider.BaseEventActionOptions{
-			UserInactivityConfig: dataprovider.EventActionUserInactivity{
-				DisableThreshold: 10,
-				DeleteThreshold:  20,
-			},
-		},
-	}
-	action1, _, err := httpdtest.AddEventAction(a1, http.StatusCreated)
-	assert.NoError(t, err)
-	a2 := dataprovider.BaseEventAction{
-		Name: "a2",
+		Name: "action1",
 		Type: dataprovider.ActionTypeEmail,
 		Options: dataprovider.BaseEventActionOptions{
 			EmailConfig: dataprovider.EventActionEmailConfig{
-				Recipients: []string{"success@example.net"},
-				Subject:    `OK`,
-				Body:       "OK action",
+				Recipients: []string{"test6@example.com"},
+				Subject:    `New "{{.Event}}"`,
+				Body:       "User: {{.Name}} IP: {{.IP}} Protocol: {{.Protocol}}",
 			},
 		},
 	}
-	action2, _, err := httpdtest.AddEventAction(a2, http.StatusCreated)
+	action1, _, err := httpdtest.AddEventAction(a1, http.StatusCreated)
 	assert.NoError(t, err)
-
 	r1 := dataprovider.EventRule{
-		Name:    "rule1",
+		Name:    "test rule account lockout",
 		Status:  1,
-		Trigger: dataprovider.EventTriggerFsEvent,
-		Conditions: dataprovider.EventConditions{
-			FsEvents: []string{"mkdir"},
-			Options: dataprovider.ConditionOptions{
-				Names: []dataprovider.ConditionPattern{
-					{
-						Pattern: user.Username,
-					},
-				},
-			},
-		},
+		Trigger: dataprovider.EventTriggerAccountLockout,
 		Actions: []dataprovider.EventAction{
 			{
 				BaseEventAction: dataprovider.BaseEventAction{
@@ -7872,39 +8268,112 @@ func TestEventRuleInactivityCheck(t *testing.T) {
 				},
 				Order: 1,
 			},
-			{
-				BaseEventAction: dataprovider.BaseEventAction{
-					Name: action2.Name,
-				},
-				Order: 2,
-			},
 		},
 	}
-	rule1, resp, err := httpdtest.AddEventRule(r1, http.StatusCreated)
-	assert.NoError(t, err, string(resp))
+	rule1, _, err := httpdtest.AddEventRule(r1, http.StatusCreated)
+	assert.NoError(t, err)
+
+	u := getTestUser()
+	u.PublicKeys = []string{testPubKey}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	// a failed login followed by a successful one resets the counter
+	user.Password = "wrong_pwd"
+	_, _, err = getSftpClient(user)
+	assert.Error(t, err)
+	assert.Equal(t, 1, dataprovider.GetAccountLockout(user.Username).Failures)
+	user.Password = defaultPassword
 	conn, client, err := getSftpClient(user)
 	if assert.NoError(t, err) {
-		defer conn.Close()
-		defer client.Close()
+		assert.NoError(t, checkBasicSFTP(client))
+		client.Close()
+		conn.Close()
+	}
+	assert.Nil(t, dataprovider.GetAccountLockout(user.Username))
+	// multiple public keys within the same connection count as a single failure
+	var signers []ssh.Signer
+	for range 3 {
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+		signer, err := ssh.NewSignerFromKey(privateKey)
+		require.NoError(t, err)
+		signers = append(signers, signer)
+	}
+	_, _, err = getCustomAuthSftpClient(user, []ssh.AuthMethod{ssh.PublicKeys(signers...)})
+	assert.Error(t, err)
+	assert.Eventually(t, func() bool {
+		lockout := dataprovider.GetAccountLockout(user.Username)
+		return lockout != nil && lockout.Failures == 1
+	}, 1*time.Second, 50*time.Millisecond)
+	lastReceivedEmail.reset()
+	user.Password = "wrong_pwd"
+	for range 2 {
+		_, _, err = getSftpClient(user)
+		assert.Error(t, err)
+	}
+	assert.Eventually(t, func() bool {
+		return lastReceivedEmail.get().From != ""
+	}, 3000*time.Millisecond, 100*time.Millisecond)
+	email := lastReceivedEmail.get()
+	assert.True(t, slices.Contains(email.To, "test6@example.com"))
+	assert.Contains(t, email.Data, `Subject: New "Account locked"`)
+	assert.Contains(t, email.Data, fmt.Sprintf("User: %s IP: 127.0.0.1 Protocol: SSH", user.Username))
+	// all the authentications are rejected while the account is locked
+	user.Password = defaultPassword
+	_, _, err = getSftpClient(user)
+	assert.Error(t, err)
+	signer, err := ssh.ParsePrivateKey([]byte(testPrivateKey))
+	assert.NoError(t, err)
+	_, _, err = getCustomAuthSftpClient(user, []ssh.AuthMethod{ssh.PublicKeys(signer)})
+	assert.Error(t, err)
+	_, err = dataprovider.CheckUserAndPass(user.Username, defaultPassword, "127.0.0.1", common.ProtocolFTP)
+	assert.ErrorIs(t, err, dataprovider.ErrAccountLocked)
+	// the rejected attempts are not counted
+	lockout := dataprovider.GetAccountLockout(user.Username)
+	if assert.NotNil(t, lockout) {
+		assert.Equal(t, 0, lockout.Failures)
+		assert.Greater(t, lockout.LockedUntil, util.GetTimeAsMsSinceEpoch(time.Now()))
+	}
+	userGet, _, err := httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+	assert.NoError(t, err)
+	if assert.NotNil(t, userGet.Lockout) {
+		assert.Equal(t, lockout.LockedUntil, userGet.Lockout.LockedUntil)
+	}
+	// the lockout state is never stored
+	userGet.Password = ""
+	_, _, err = httpdtest.UpdateUser(userGet, http.StatusOK, "")
+	assert.NoError(t, err)
+	providerUser, err := dataprovider.UserExists(user.Username, "")
+	assert.NoError(t, err)
+	assert.Nil(t, providerUser.Lockout)
+	assert.ErrorIs(t, dataprovider.CheckAccountLockout(user.Username), dataprovider.ErrAccountLocked)
 
-		lastReceivedEmail.reset()
-		err := client.Mkdir("just a test dir")
-		assert.NoError(t, err)
-		// just check that the action is executed
-		assert.Eventually(t, func() bool {
-			return lastReceivedEmail.get().From != ""
-		}, 1500*time.Millisecond, 100*time.Millisecond)
-		email := lastReceivedEmail.get()
-		assert.Len(t, email.To, 1)
-		assert.Contains(t, email.To, "success@example.net")
+	lastReceivedEmail.reset()
+	_, err = httpdtest.UnlockUser(user.Username, http.StatusOK)
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return lastReceivedEmail.get().From != ""
+	}, 3000*time.Millisecond, 100*time.Millisecond)
+	email = lastReceivedEmail.get()
+	assert.Contains(t, email.Data, `Subject: New "Account unlocked"`)
+	_, err = httpdtest.UnlockUser(user.Username, http.StatusBadRequest)
+	assert.NoError(t, err)
+	_, err = httpdtest.UnlockUser("missing_user", http.StatusNotFound)
+	assert.NoError(t, err)
+	userGet, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+	assert.NoError(t, err)
+	assert.Nil(t, userGet.Lockout)
+	conn, client, err = getSftpClient(user)
+	if assert.NoError(t, err) {
+		assert.NoError(t, checkBasicSFTP(client))
+		client.Close()
+		conn.Close()
 	}
 
 	_, err = httpdtest.RemoveEventRule(rule1, http.StatusOK)
 	assert.NoError(t, err)
 	_, err = httpdtest.RemoveEventAction(action1, http.StatusOK)
 	assert.NoError(t, err)
-	_, err = httpdtest.RemoveEventAction(action2, http.StatusOK)
-	assert.NoError(t, err)
 	_, err = httpdtest.RemoveUser(user, http.StatusOK)
 	assert.NoError(t, err)
 	err = os.RemoveAll(user.GetHomeDir())
@@ -7913,9 +8382,122 @@ func TestEventRuleInactivityCheck(t *testing.T) {
 	smtpCfg = smtp.Config{}
 	err = smtpCfg.Initialize(configDir, true)
 	require.NoError(t, err)
+
+	err = dataprovider.Close()
+	assert.NoError(t, err)
+	err = config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	providerConf = config.GetProviderConf()
+	providerConf.BackupsPath = backupsPath
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.NoError(t, err)
 }
 
-func TestEventRulePasswordExpiration(t *testing.T) {
+func TestAccountLockoutConcurrency(t *testing.T) {
+	checkConcurrentFailures := func(providerConf dataprovider.Config, username string) {
+		err := dataprovider.Close()
+		assert.NoError(t, err)
+		err = dataprovider.Initialize(providerConf, configDir, true)
+		require.NoError(t, err)
+
+		updateLockout := func(numFailures int) []int {
+			var wg sync.WaitGroup
+			results := make([]int, numFailures)
+			start := make(chan struct{})
+			for idx := range numFailures {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+
+					<-start
+					results[idx] = dataprovider.UpdateAccountLockout(username, false)
+				}()
+			}
+			close(start)
+			wg.Wait()
+			return results
+		}
+
+		// no failure is lost
+		updateLockout(providerConf.AccountLockout.MaxFailures - 1)
+		lockout := dataprovider.GetAccountLockout(username)
+		if assert.NotNil(t, lockout) {
+			assert.Equal(t, providerConf.AccountLockout.MaxFailures-1, lockout.Failures)
+			assert.Equal(t, int64(0), lockout.LockedUntil)
+		}
+		assert.True(t, dataprovider.UnlockAccount(username))
+		// the account is locked exactly once
+		results := updateLockout(10 * providerConf.AccountLockout.MaxFailures)
+		numLocked := 0
+		for _, result := range results {
+			if result == dataprovider.AccountLockoutLocked {
+				numLocked++
+			}
+		}
+		assert.Equal(t, 1, numLocked)
+		assert.ErrorIs(t, dataprovider.CheckAccountLockout(username), dataprovider.ErrAccountLocked)
+		assert.True(t, dataprovider.UnlockAccount(username))
+	}
+
+	providerConf := dataprovider.GetProviderConfig()
+	providerConf.AccountLockout.MaxFailures = 5
+	providerConf.AccountLockout.Duration = 15
+	checkConcurrentFailures(providerConf, "concurrent_lockout_user")
+
+	providerConf.IsShared = 1
+	if providerConf.GetShared() == 1 {
+		checkConcurrentFailures(providerConf, "concurrent_lockout_shared_user")
+	}
+	// concurrent updates of a shared session, only unchanged sessions are replaced
+	if _, err := dataprovider.GetSharedSession("missing", dataprovider.SessionTypeAccountLockout); !errors.Is(err, dataprovider.ErrNotImplemented) {
+		key := "concurrent_session"
+		dataprovider.DeleteSharedSession(key, dataprovider.SessionTypeAccountLockout) //nolint:errcheck
+		var wg sync.WaitGroup
+		for range 20 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				for range 100 {
+					var counter int
+					var previousData []byte
+					session, err := dataprovider.GetSharedSession(key, dataprovider.SessionTypeAccountLockout)
+					if err == nil {
+						previousData = session.Data.([]byte)
+						assert.NoError(t, json.Unmarshal(previousData, &counter))
+					}
+					session.Key = key
+					session.Type = dataprovider.SessionTypeAccountLockout
+					session.Data = counter + 1
+					session.Timestamp = util.GetTimeAsMsSinceEpoch(time.Now().Add(time.Hour))
+					replaced, err := dataprovider.ReplaceSharedSession(session, previousData)
+					if !assert.NoError(t, err) || replaced {
+						return
+					}
+				}
+				assert.Fail(t, "unable to replace the shared session")
+			}()
+		}
+		wg.Wait()
+		session, err := dataprovider.GetSharedSession(key, dataprovider.SessionTypeAccountLockout)
+		if assert.NoError(t, err) {
+			assert.Equal(t, "20", string(session.Data.([]byte)))
+		}
+		err = dataprovider.DeleteSharedSession(key, dataprovider.SessionTypeAccountLockout)
+		assert.NoError(t, err)
+	}
+
+	err := dataprovider.Close()
+	assert.NoError(t, err)
+	err = config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	providerConf = config.GetProviderConf()
+	providerConf.BackupsPath = backupsPath
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.NoError(t, err)
+}
+
+func TestLoginBudget(t *testing.T) {
 	smtpCfg := smtp.Config{
 		Host:          "127.0.0.1",
 		Port:          2525,
@@ -7925,282 +8507,1381 @@ func TestEventRulePasswordExpiration(t *testing.T) {
 	err := smtpCfg.Initialize(configDir, true)
 	require.NoError(t, err)
 
-	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
-	assert.NoError(t, err)
 	a1 := dataprovider.BaseEventAction{
-		Name: "a1",
+		Name: "action1",
 		Type: dataprovider.ActionTypeEmail,
 		Options: dataprovider.BaseEventActionOptions{
 			EmailConfig: dataprovider.EventActionEmailConfig{
-				Recipients: []string{"failure@example.net"},
-				Subject:    `Failure`,
-				Body:       "Failure action",
+				Recipients: []string{"test7@example.com"},
+				Subject:    `New "{{.Event}}"`,
+				Body:       "User: {{.Name}} IP: {{.IP}} Protocol: {{.Protocol}}",
 			},
 		},
 	}
 	action1, _, err := httpdtest.AddEventAction(a1, http.StatusCreated)
 	assert.NoError(t, err)
-	a2 := dataprovider.BaseEventAction{
-		Name: "a2",
-		Type: dataprovider.ActionTypePasswordExpirationCheck,
-		Options: dataprovider.BaseEventActionOptions{
-			PwdExpirationConfig: dataprovider.EventActionPasswordExpiration{
-				Threshold: 10,
-			},
-		},
-	}
-	action2, _, err := httpdtest.AddEventAction(a2, http.StatusCreated)
-	assert.NoError(t, err)
-	a3 := dataprovider.BaseEventAction{
-		Name: "a3",
-		Type: dataprovider.ActionTypeEmail,
-		Options: dataprovider.BaseEventActionOptions{
-			EmailConfig: dataprovider.EventActionEmailConfig{
-				Recipients: []string{"success@example.net"},
-				Subject:    `OK`,
-				Body:       "OK action",
-			},
-		},
-	}
-	action3, _, err := httpdtest.AddEventAction(a3, http.StatusCreated)
-	assert.NoError(t, err)
-
 	r1 := dataprovider.EventRule{
-		Name:    "rule1",
+		Name:    "test rule login budget",
 		Status:  1,
-		Trigger: dataprovider.EventTriggerFsEvent,
-		Conditions: dataprovider.EventConditions{
-			FsEvents: []string{"mkdir"},
-		},
+		Trigger: dataprovider.EventTriggerAccountLockout,
 		Actions: []dataprovider.EventAction{
 			{
 				BaseEventAction: dataprovider.BaseEventAction{
-					Name: action2.Name,
+					Name: action1.Name,
+				},
+				Order: 1,
+			},
+		},
+	}
+	rule1, _, err := httpdtest.AddEventRule(r1, http.StatusCreated)
+	assert.NoError(t, err)
+
+	u := getTestUser()
+	u.Filters.MaxLogins = 2
+	// WebDAV must be denied for users with a login budget
+	_, _, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+	u.Filters.DeniedProtocols = []string{common.ProtocolWebDAV}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	if assert.NotNil(t, user.RemainingLogins) {
+		assert.Equal(t, 2, *user.RemainingLogins)
+	}
+	conn, client, err := getSftpClient(user)
+	if assert.NoError(t, err) {
+		assert.NoError(t, checkBasicSFTP(client))
+		client.Close()
+		conn.Close()
+	}
+	userGet, _, err := httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, userGet.UsedLogins)
+	if assert.NotNil(t, userGet.RemainingLogins) {
+		assert.Equal(t, 1, *userGet.RemainingLogins)
+	}
+	// two concurrent logins race for the last slot, only one can succeed
+	lastReceivedEmail.reset()
+	var wg sync.WaitGroup
+	var successfulLogins atomic.Int32
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			conn, client, err := getSftpClient(user)
+			if err == nil {
+				successfulLogins.Add(1)
+				client.Close()
+				conn.Close()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), successfulLogins.Load())
+	assert.Eventually(t, func() bool {
+		return lastReceivedEmail.get().From != ""
+	}, 3000*time.Millisecond, 100*time.Millisecond)
+	email := lastReceivedEmail.get()
+	assert.True(t, slices.Contains(email.To, "test7@example.com"))
+	assert.Contains(t, email.Data, `Subject: New "Login budget exhausted"`)
+	assert.Contains(t, email.Data, fmt.Sprintf("User: %s IP: 127.0.0.1 Protocol: SSH", user.Username))
+	// the rejected logins are not counted
+	userGet, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, userGet.UsedLogins)
+	if assert.NotNil(t, userGet.RemainingLogins) {
+		assert.Equal(t, 0, *userGet.RemainingLogins)
+	}
+	assert.Nil(t, dataprovider.GetAccountLockout(user.Username))
+	assert.ErrorIs(t, dataprovider.ConsumeLogin(&user), dataprovider.ErrLoginBudgetExhausted)
+	// the counter is preserved on update and the budget can be increased
+	userGet.Password = ""
+	userGet.Filters.MaxLogins = 3
+	userGet.UsedLogins = 0
+	user, _, err = httpdtest.UpdateUser(userGet, http.StatusOK, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, user.UsedLogins)
+	conn, client, err = getSftpClient(user)
+	if assert.NoError(t, err) {
+		assert.NoError(t, checkBasicSFTP(client))
+		client.Close()
+		conn.Close()
+	}
+	_, _, err = getSftpClient(user)
+	assert.Error(t, err)
+	// expired users cannot login and the budget is not consumed
+	user.Filters.MaxLogins = 4
+	user.ExpirationDate = util.GetTimeAsMsSinceEpoch(time.Now().Add(-1 * time.Hour))
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	_, _, err = getSftpClient(user)
+	assert.Error(t, err)
+	user.ExpirationDate = 0
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, user.UsedLogins)
+	if assert.NotNil(t, user.RemainingLogins) {
+		assert.Equal(t, 1, *user.RemainingLogins)
+	}
+
+	_, err = httpdtest.RemoveEventRule(rule1, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveEventAction(action1, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+
+	smtpCfg = smtp.Config{}
+	err = smtpCfg.Initialize(configDir, true)
+	require.NoError(t, err)
+}
+
+func TestDisableAfterFirstSession(t *testing.T) {
+	u := getTestUser()
+	u.Filters.DisableAfterFirstSession = true
+	u.Filters.DeniedProtocols = []string{common.ProtocolWebDAV}
+	// HTTP must be denied too
+	_, _, err := httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+	u.Filters.DeniedProtocols = []string{common.ProtocolWebDAV, common.ProtocolHTTP}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	conn, client, err := getSftpClient(user)
+	if assert.NoError(t, err) {
+		assert.NoError(t, checkBasicSFTP(client))
+		// a new session is allowed while the first one is still active
+		conn1, client1, err := getSftpClient(user)
+		if assert.NoError(t, err) {
+			client1.Close()
+			conn1.Close()
+		}
+		client.Close()
+		conn.Close()
+	}
+	assert.Eventually(t, func() bool {
+		user, err := dataprovider.UserExists(user.Username, "")
+		return err == nil && user.Status == 0
+	}, 3*time.Second, 100*time.Millisecond)
+	_, _, err = getSftpClient(user)
+	assert.Error(t, err)
+	// an admin can enable the user again
+	user.Status = 1
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	conn, client, err = getSftpClient(user)
+	if assert.NoError(t, err) {
+		assert.NoError(t, checkBasicSFTP(client))
+		client.Close()
+		conn.Close()
+	}
+	assert.Eventually(t, func() bool {
+		user, err := dataprovider.UserExists(user.Username, "")
+		return err == nil && user.Status == 0
+	}, 3*time.Second, 100*time.Millisecond)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestEventRuleRotateLog(t *testing.T) {
+	smtpCfg := smtp.Config{
+		Host:          "127.0.0.1",
+		Port:          2525,
+		From:          "notification@example.com",
+		TemplatesPath: "templates",
+	}
+	err := smtpCfg.Initialize(configDir, true)
+	require.NoError(t, err)
+
+	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
+	assert.NoError(t, err)
+
+	a1 := dataprovider.BaseEventAction{
+		Name: "a1",
+		Type: dataprovider.ActionTypeRotateLogs,
+	}
+	action1, _, err := httpdtest.AddEventAction(a1, http.StatusCreated)
+	assert.NoError(t, err)
+	a2 := dataprovider.BaseEventAction{
+		Name: "a2",
+		Type: dataprovider.ActionTypeEmail,
+		Options: dataprovider.BaseEventActionOptions{
+			EmailConfig: dataprovider.EventActionEmailConfig{
+				Recipients: []string{"success@example.net"},
+				Subject:    `OK`,
+				Body:       "OK action",
+			},
+		},
+	}
+	action2, _, err := httpdtest.AddEventAction(a2, http.StatusCreated)
+	assert.NoError(t, err)
+
+	r1 := dataprovider.EventRule{
+		Name:    "rule1",
+		Status:  1,
+		Trigger: dataprovider.EventTriggerFsEvent,
+		Conditions: dataprovider.EventConditions{
+			FsEvents: []string{"mkdir"},
+			Options: dataprovider.ConditionOptions{
+				Names: []dataprovider.ConditionPattern{
+					{
+						Pattern: user.Username,
+					},
+				},
+			},
+		},
+		Actions: []dataprovider.EventAction{
+			{
+				BaseEventAction: dataprovider.BaseEventAction{
+					Name: action1.Name,
 				},
 				Order: 1,
 			},
 			{
 				BaseEventAction: dataprovider.BaseEventAction{
-					Name: action3.Name,
+					Name: action2.Name,
 				},
 				Order: 2,
 			},
+		},
+	}
+	rule1, resp, err := httpdtest.AddEventRule(r1, http.StatusCreated)
+	assert.NoError(t, err, string(resp))
+	conn, client, err := getSftpClient(user)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		lastReceivedEmail.reset()
+		err := client.Mkdir("just a test dir")
+		assert.NoError(t, err)
+		// just check that the action is executed
+		assert.Eventually(t, func() bool {
+			return lastReceivedEmail.get().From != ""
+		}, 1500*time.Millisecond, 100*time.Millisecond)
+		email := lastReceivedEmail.get()
+		assert.Len(t, email.To, 1)
+		assert.Contains(t, email.To, "success@example.net")
+	}
+
+	_, err = httpdtest.RemoveEventRule(rule1, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveEventAction(action1, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveEventAction(action2, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+
+	smtpCfg = smtp.Config{}
+	err = smtpCfg.Initialize(configDir, true)
+	require.NoError(t, err)
+}
+
+func TestEventRuleInactivityCheck(t *testing.T) {
+	smtpCfg := smtp.Config{
+		Host:          "127.0.0.1",
+		Port:          2525,
+		From:          "notification@example.com",
+		TemplatesPath: "templates",
+	}
+	err := smtpCfg.Initialize(configDir, true)
+	require.NoError(t, err)
+
+	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
+	assert.NoError(t, err)
+
+	a1 := dataprovider.BaseEventAction{
+		Name: "a1",
+		Type: dataprovider.ActionTypeUserInactivityCheck,
+		Options: dataprovider.BaseEventActionOptions{
+			UserInactivityConfig: dataprovider.EventActionUserInactivity{
+				DisableThreshold: 10,
+				DeleteThreshold:  20,
+			},
+		},
+	}
+	action1, _, err := httpdtest.AddEventAction(a1, http.StatusCreated)
+	assert.NoError(t, err)
+	a2 := dataprovider.BaseEventAction{
+		Name: "a2",
+		Type: dataprovider.ActionTypeEmail,
+		Options: dataprovider.BaseEventActionOptions{
+			EmailConfig: dataprovider.EventActionEmailConfig{
+				Recipients: []string{"success@example.net"},
+				Subject:    `OK`,
+				Body:       "OK action",
+			},
+		},
+	}
+	action2, _, err := httpdtest.AddEventAction(a2, http.StatusCreated)
+	assert.NoError(t, err)
+
+	r1 := dataprovider.EventRule{
+		Name:    "rule1",
+		Status:  1,
+		Trigger: dataprovider.EventTriggerFsEvent,
+		Conditions: dataprovider.EventConditions{
+			FsEvents: []string{"mkdir"},
+			Options: dataprovider.ConditionOptions{
+				Names: []dataprovider.ConditionPattern{
+					{
+						Pattern: user.Username,
+					},
+				},
+			},
+		},
+		Actions: []dataprovider.EventAction{
 			{
 				BaseEventAction: dataprovider.BaseEventAction{
 					Name: action1.Name,
 				},
-				Options: dataprovider.EventActionOptions{
-					IsFailureAction: true,
+				Order: 1,
+			},
+			{
+				BaseEventAction: dataprovider.BaseEventAction{
+					Name: action2.Name,
 				},
+				Order: 2,
 			},
 		},
-	}
-	rule1, resp, err := httpdtest.AddEventRule(r1, http.StatusCreated)
-	assert.NoError(t, err, string(resp))
-	dirName := "aTestDir"
-
+	}
+	rule1, resp, err := httpdtest.AddEventRule(r1, http.StatusCreated)
+	assert.NoError(t, err, string(resp))
+	conn, client, err := getSftpClient(user)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		lastReceivedEmail.reset()
+		err := client.Mkdir("just a test dir")
+		assert.NoError(t, err)
+		// just check that the action is executed
+		assert.Eventually(t, func() bool {
+			return lastReceivedEmail.get().From != ""
+		}, 1500*time.Millisecond, 100*time.Millisecond)
+		email := lastReceivedEmail.get()
+		assert.Len(t, email.To, 1)
+		assert.Contains(t, email.To, "success@example.net")
+	}
+
+	_, err = httpdtest.RemoveEventRule(rule1, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveEventAction(action1, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveEventAction(action2, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+
+	smtpCfg = smtp.Config{}
+	err = smtpCfg.Initialize(configDir, true)
+	require.NoError(t, err)
+}
+
+func TestEventRulePasswordExpiration(t *testing.T) {
+	smtpCfg := smtp.Config{
+		Host:          "127.0.0.1",
+		Port:          2525,
+		From:          "notification@example.com",
+		TemplatesPath: "templates",
+	}
+	err := smtpCfg.Initialize(configDir, true)
+	require.NoError(t, err)
+
+	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
+	assert.NoError(t, err)
+	a1 := dataprovider.BaseEventAction{
+		Name: "a1",
+		Type: dataprovider.ActionTypeEmail,
+		Options: dataprovider.BaseEventActionOptions{
+			EmailConfig: dataprovider.EventActionEmailConfig{
+				Recipients: []string{"failure@example.net"},
+				Subject:    `Failure`,
+				Body:       "Failure action",
+			},
+		},
+	}
+	action1, _, err := httpdtest.AddEventAction(a1, http.StatusCreated)
+	assert.NoError(t, err)
+	a2 := dataprovider.BaseEventAction{
+		Name: "a2",
+		Type: dataprovider.ActionTypePasswordExpirationCheck,
+		Options: dataprovider.BaseEventActionOptions{
+			PwdExpirationConfig: dataprovider.EventActionPasswordExpiration{
+				Threshold: 10,
+			},
+		},
+	}
+	action2, _, err := httpdtest.AddEventAction(a2, http.StatusCreated)
+	assert.NoError(t, err)
+	a3 := dataprovider.BaseEventAction{
+		Name: "a3",
+		Type: dataprovider.ActionTypeEmail,
+		Options: dataprovider.BaseEventActionOptions{
+			EmailConfig: dataprovider.EventActionEmailConfig{
+				Recipients: []string{"success@example.net"},
+				Subject:    `OK`,
+				Body:       "OK action",
+			},
+		},
+	}
+	action3, _, err := httpdtest.AddEventAction(a3, http.StatusCreated)
+	assert.NoError(t, err)
+
+	r1 := dataprovider.EventRule{
+		Name:    "rule1",
+		Status:  1,
+		Trigger: dataprovider.EventTriggerFsEvent,
+		Conditions: dataprovider.EventConditions{
+			FsEvents: []string{"mkdir"},
+		},
+		Actions: []dataprovider.EventAction{
+			{
+				BaseEventAction: dataprovider.BaseEventAction{
+					Name: action2.Name,
+				},
+				Order: 1,
+			},
+			{
+				BaseEventAction: dataprovider.BaseEventAction{
+					Name: action3.Name,
+				},
+				Order: 2,
+			},
+			{
+				BaseEventAction: dataprovider.BaseEventAction{
+					Name: action1.Name,
+				},
+				Options: dataprovider.EventActionOptions{
+					IsFailureAction: true,
+				},
+			},
+		},
+	}
+	rule1, resp, err := httpdtest.AddEventRule(r1, http.StatusCreated)
+	assert.NoError(t, err, string(resp))
+	dirName := "aTestDir"
+
+	conn, client, err := getSftpClient(user)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		lastReceivedEmail.reset()
+		err := client.Mkdir(dirName)
+		assert.NoError(t, err)
+		// the user has no password expiration, the check will be skipped and the ok action executed
+		assert.Eventually(t, func() bool {
+			return lastReceivedEmail.get().From != ""
+		}, 1500*time.Millisecond, 100*time.Millisecond)
+		email := lastReceivedEmail.get()
+		assert.Len(t, email.To, 1)
+		assert.Contains(t, email.To, "success@example.net")
+		err = client.RemoveDirectory(dirName)
+		assert.NoError(t, err)
+	}
+	user.Filters.PasswordExpiration = 20
+	_, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	conn, client, err = getSftpClient(user)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		lastReceivedEmail.reset()
+		err := client.Mkdir(dirName)
+		assert.NoError(t, err)
+		// the passowrd is not about to expire, the check will be skipped and the ok action executed
+		assert.Eventually(t, func() bool {
+			return lastReceivedEmail.get().From != ""
+		}, 1500*time.Millisecond, 100*time.Millisecond)
+		email := lastReceivedEmail.get()
+		assert.Len(t, email.To, 1)
+		assert.Contains(t, email.To, "success@example.net")
+		err = client.RemoveDirectory(dirName)
+		assert.NoError(t, err)
+	}
+	user.Filters.PasswordExpiration = 5
+	_, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	conn, client, err = getSftpClient(user)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		lastReceivedEmail.reset()
+		err := client.Mkdir(dirName)
+		assert.NoError(t, err)
+		// the passowrd is about to expire, the user has no email, the failure action will be executed
+		assert.Eventually(t, func() bool {
+			return lastReceivedEmail.get().From != ""
+		}, 1500*time.Millisecond, 100*time.Millisecond)
+		email := lastReceivedEmail.get()
+		assert.Len(t, email.To, 1)
+		assert.Contains(t, email.To, "failure@example.net")
+		err = client.RemoveDirectory(dirName)
+		assert.NoError(t, err)
+	}
+	// remove the success action
+	rule1.Actions = []dataprovider.EventAction{
+		{
+			BaseEventAction: dataprovider.BaseEventAction{
+				Name: action2.Name,
+			},
+			Order: 1,
+		},
+		{
+			BaseEventAction: dataprovider.BaseEventAction{
+				Name: action1.Name,
+			},
+			Options: dataprovider.EventActionOptions{
+				IsFailureAction: true,
+			},
+		},
+	}
+	_, _, err = httpdtest.UpdateEventRule(rule1, http.StatusOK)
+	assert.NoError(t, err)
+	user.Email = "user@example.net"
+	user.Filters.AdditionalEmails = []string{"additional@example.net"}
+	_, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	conn, client, err = getSftpClient(user)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		lastReceivedEmail.reset()
+		err := client.Mkdir(dirName)
+		assert.NoError(t, err)
+		// the passowrd expiration will be notified
+		assert.Eventually(t, func() bool {
+			return lastReceivedEmail.get().From != ""
+		}, 1500*time.Millisecond, 100*time.Millisecond)
+		email := lastReceivedEmail.get()
+		assert.Len(t, email.To, 2)
+		assert.Contains(t, email.To, user.Email)
+		assert.Contains(t, email.To, user.Filters.AdditionalEmails[0])
+		assert.Contains(t, email.Data, "your SFTPGo password expires in 5 days")
+		err = client.RemoveDirectory(dirName)
+		assert.NoError(t, err)
+	}
+
+	_, err = httpdtest.RemoveEventRule(rule1, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveEventAction(action1, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveEventAction(action2, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveEventAction(action3, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+
+	smtpCfg = smtp.Config{}
+	err = smtpCfg.Initialize(configDir, true)
+	require.NoError(t, err)
+}
+
+func TestSyncUploadAction(t *testing.T) {
+	if runtime.GOOS == osWindows {
+		t.Skip("this test is not available on Windows")
+	}
+	uploadScriptPath := filepath.Join(os.TempDir(), "upload.sh")
+	common.Config.Actions.ExecuteOn = []string{"upload"}
+	common.Config.Actions.ExecuteSync = []string{"upload"}
+	common.Config.Actions.Hook = uploadScriptPath
+
+	u := getTestUser()
+	u.QuotaFiles = 1000
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	movedFileName := "moved.dat"
+	movedPath := filepath.Join(user.HomeDir, movedFileName)
+	err = os.WriteFile(uploadScriptPath, getUploadScriptContent(movedPath, "", 0), 0755)
+	assert.NoError(t, err)
+	conn, client, err := getSftpClient(user)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		size := int64(32768)
+		err = writeSFTPFileNoCheck(testFileName, size, client)
+		assert.NoError(t, err)
+		_, err = client.Stat(testFileName)
+		assert.Error(t, err)
+		info, err := client.Stat(movedFileName)
+		if assert.NoError(t, err) {
+			assert.Equal(t, size, info.Size())
+		}
+		user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, user.UsedQuotaFiles)
+		assert.Equal(t, size, user.UsedQuotaSize)
+		// test some hook failure
+		// the uploaded file is moved and the hook fails, it will be not removed from the quota
+		err = os.WriteFile(uploadScriptPath, getUploadScriptContent(movedPath, "", 1), 0755)
+		assert.NoError(t, err)
+		err = writeSFTPFileNoCheck(testFileName+"_1", size, client)
+		assert.Error(t, err)
+		user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, user.UsedQuotaFiles)
+		assert.Equal(t, size*2, user.UsedQuotaSize)
+
+		// the uploaded file is not moved and the hook fails, the uploaded file will be deleted
+		// and removed from the quota
+		movedPath = filepath.Join(user.HomeDir, "missing dir", movedFileName)
+		err = os.WriteFile(uploadScriptPath, getUploadScriptContent(movedPath, "", 1), 0755)
+		assert.NoError(t, err)
+		err = writeSFTPFileNoCheck(testFileName+"_2", size, client)
+		assert.Error(t, err)
+		user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, user.UsedQuotaFiles)
+		assert.Equal(t, size*2, user.UsedQuotaSize)
+		// overwrite an existing file
+		_, err = client.Stat(movedFileName)
+		assert.NoError(t, err)
+		err = writeSFTPFileNoCheck(movedFileName, size, client)
+		assert.Error(t, err)
+		_, err = client.Stat(movedFileName)
+		assert.Error(t, err)
+		user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, user.UsedQuotaFiles)
+		assert.Equal(t, size, user.UsedQuotaSize)
+	}
+
+	err = os.Remove(uploadScriptPath)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+
+	common.Config.Actions.ExecuteOn = nil
+	common.Config.Actions.ExecuteSync = nil
+	common.Config.Actions.Hook = uploadScriptPath
+}
+
+func TestUploadScanHook(t *testing.T) {
+	if runtime.GOOS == osWindows {
+		t.Skip("this test is not available on Windows")
+	}
+	scanScriptPath := filepath.Join(os.TempDir(), "upload_scan.sh")
+	scanOutPath := filepath.Join(os.TempDir(), "upload_scan.out")
+	u := getTestUser()
+	u.QuotaFiles = 1000
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	u = getTestUser()
+	u.Username += "_crypt"
+	u.FsConfig.Provider = sdk.CryptedFilesystemProvider
+	u.FsConfig.CryptConfig.Passphrase = kms.NewPlainSecret(defaultPassword)
+	cryptUser, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	conn, client, err := getSftpClient(user)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		size := int64(32768)
+		common.Config.UploadScan.Hook = "/bin/false"
+		err = writeSFTPFileNoCheck(testFileName, size, client)
+		assert.ErrorIs(t, err, os.ErrPermission)
+		_, err = client.Stat(testFileName)
+		assert.ErrorIs(t, err, os.ErrNotExist)
+		user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, user.UsedQuotaFiles)
+		assert.Equal(t, int64(0), user.UsedQuotaSize)
+		// the file does not match the configured patterns
+		common.Config.UploadScan.Patterns = []string{"*.exe", "*.zip"}
+		err = writeSFTPFileNoCheck(testFileName, size, client)
+		assert.NoError(t, err)
+		common.Config.UploadScan.Patterns = []string{"*.dat"}
+		err = writeSFTPFileNoCheck(testFileName, size, client)
+		assert.ErrorIs(t, err, os.ErrPermission)
+		_, err = client.Stat(testFileName)
+		assert.ErrorIs(t, err, os.ErrNotExist)
+		common.Config.UploadScan.Patterns = nil
+		// the hook cannot be executed
+		common.Config.UploadScan.Hook = "/invalid/path"
+		err = writeSFTPFileNoCheck(testFileName, size, client)
+		assert.ErrorIs(t, err, os.ErrPermission)
+		common.Config.UploadScan.FailOpen = true
+		err = writeSFTPFileNoCheck(testFileName, size, client)
+		assert.NoError(t, err)
+		common.Config.UploadScan.FailOpen = false
+		err = os.WriteFile(scanScriptPath, getUploadScanScriptContent(scanOutPath), 0755)
+		assert.NoError(t, err)
+		common.Config.UploadScan.Hook = scanScriptPath
+		err = writeSFTPFileNoCheck(testFileName, size, client)
+		assert.NoError(t, err)
+		scanOut, err := os.ReadFile(scanOutPath)
+		assert.NoError(t, err)
+		assert.Contains(t, string(scanOut), filepath.Join(user.GetHomeDir(), testFileName))
+		// HTTP hook
+		common.Config.UploadScan.Hook = fmt.Sprintf("http://%s/404", httpAddr)
+		err = writeSFTPFileNoCheck(testFileName, size, client)
+		assert.ErrorIs(t, err, os.ErrPermission)
+		common.Config.UploadScan.Hook = "http://invalid:1234/"
+		err = writeSFTPFileNoCheck(testFileName, size, client)
+		assert.ErrorIs(t, err, os.ErrPermission)
+		common.Config.UploadScan.FailOpen = true
+		err = writeSFTPFileNoCheck(testFileName, size, client)
+		assert.NoError(t, err)
+		common.Config.UploadScan.FailOpen = false
+		common.Config.UploadScan.Hook = fmt.Sprintf("http://%s/", httpAddr)
+		err = writeSFTPFileNoCheck(testFileName, size, client)
+		assert.NoError(t, err)
+		user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, user.UsedQuotaFiles)
+		assert.Equal(t, size, user.UsedQuotaSize)
+	}
+	// for the crypt fs the decrypted content is streamed to the hook
+	conn, client, err = getSftpClient(cryptUser)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		size := int64(65535)
+		common.Config.UploadScan.Hook = scanScriptPath
+		err = writeSFTPFileNoCheck(testFileName, size, client)
+		assert.NoError(t, err)
+		info, err := os.Stat(scanOutPath + ".data")
+		if assert.NoError(t, err) {
+			assert.Equal(t, size, info.Size())
+		}
+	}
+
+	common.Config.UploadScan.Hook = ""
+	err = os.Remove(scanScriptPath)
+	assert.NoError(t, err)
+	err = os.Remove(scanOutPath)
+	assert.NoError(t, err)
+	err = os.Remove(scanOutPath + ".data")
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(cryptUser, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(cryptUser.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestUploadContentFilter(t *testing.T) {
+	u := getTestUser()
+	u.QuotaFiles = 1000
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	u = getTestSFTPUser()
+	u.QuotaFiles = 1000
+	sftpUser, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+
+	common.Config.UploadContentFilter = common.UploadContentFilterConfig{
+		Patterns:    []string{"*.dat"},
+		DeniedTypes: []string{common.ContentTypeELF},
+	}
+	elfContent := append([]byte("\x7fELF\x02\x01\x01"), make([]byte, 65535)...)
+	for _, u := range []dataprovider.User{user, sftpUser} {
+		conn, client, err := getSftpClient(u)
+		if assert.NoError(t, err) {
+			err = writeSFTPContent(testFileName, elfContent, client)
+			assert.ErrorIs(t, err, os.ErrPermission)
+			_, err = client.Stat(testFileName)
+			assert.ErrorIs(t, err, os.ErrNotExist)
+			// smaller than the inspection size
+			err = writeSFTPContent(testFileName, elfContent[:16], client)
+			assert.ErrorIs(t, err, os.ErrPermission)
+			_, err = client.Stat(testFileName)
+			assert.ErrorIs(t, err, os.ErrNotExist)
+			// the file does not match the configured patterns
+			err = writeSFTPContent(testFileName+".txt", elfContent, client)
+			assert.NoError(t, err)
+			err = writeSFTPContent(testFileName, elfContent[8:], client)
+			assert.NoError(t, err)
+			info, err := client.Stat(testFileName)
+			if assert.NoError(t, err) {
+				assert.Equal(t, int64(len(elfContent)-8), info.Size())
+			}
+			// the appended bytes are inspected with the existing ones
+			err = writeSFTPContent(testFileName, elfContent[:2], client)
+			assert.NoError(t, err)
+			f, err := client.OpenFile(testFileName, os.O_WRONLY|os.O_APPEND)
+			if assert.NoError(t, err) {
+				_, err = f.Seek(2, io.SeekStart)
+				assert.NoError(t, err)
+				_, err = f.Write(elfContent[2:32])
+				if err == nil {
+					err = f.Close()
+				} else {
+					f.Close()
+				}
+				assert.ErrorIs(t, err, os.ErrPermission)
+			}
+			_, err = client.Stat(testFileName)
+			assert.ErrorIs(t, err, os.ErrNotExist)
+			conn.Close()
+			client.Close()
+		}
+		if u.Username == user.Username {
+			user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+			assert.NoError(t, err)
+			assert.Equal(t, 1, user.UsedQuotaFiles)
+			assert.Equal(t, int64(len(elfContent)), user.UsedQuotaSize)
+			err = os.Remove(filepath.Join(user.GetHomeDir(), testFileName+".txt"))
+			assert.NoError(t, err)
+		}
+	}
+
+	common.Config.UploadContentFilter = common.UploadContentFilterConfig{}
+	_, err = httpdtest.RemoveUser(sftpUser, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestUploadSizeLimits(t *testing.T) {
+	if runtime.GOOS == osWindows {
+		t.Skip("this test is not available on Windows")
+	}
+	uploadScriptPath := filepath.Join(os.TempDir(), "upload_status.sh")
+	uploadOutPath := filepath.Join(os.TempDir(), "upload_status.out")
+	err := os.WriteFile(uploadScriptPath, getUploadStatusScriptContent(uploadOutPath), 0755)
+	require.NoError(t, err)
+	common.Config.Actions.ExecuteOn = []string{"upload"}
+	common.Config.Actions.ExecuteSync = []string{"upload"}
+	common.Config.Actions.Hook = uploadScriptPath
+
+	getUploadStatus := func() string {
+		data, err := os.ReadFile(uploadOutPath)
+		require.NoError(t, err)
+		return string(bytes.TrimSpace(data))
+	}
+
+	mappedPath := filepath.Join(os.TempDir(), "imagery")
+	folder, _, err := httpdtest.AddFolder(vfs.BaseVirtualFolder{
+		Name:              filepath.Base(mappedPath),
+		MappedPath:        mappedPath,
+		MaxUploadFileSize: 4096,
+	}, http.StatusCreated)
+	assert.NoError(t, err)
+	vdirPath := "/imagery"
+	u := getTestUser()
+	u.Filters.MaxUploadFileSize = 1024
+	u.Filters.UploadSizeLimits = []dataprovider.UploadSizeLimit{
+		{
+			Pattern: "/imagery/*.tmp",
+			MaxSize: 100,
+		},
+		{
+			Pattern: "*.iso",
+			MaxSize: 2048,
+		},
+	}
+	u.VirtualFolders = append(u.VirtualFolders, vfs.VirtualFolder{
+		BaseVirtualFolder: vfs.BaseVirtualFolder{
+			Name: folder.Name,
+		},
+		VirtualPath: vdirPath,
+		QuotaFiles:  -1,
+		QuotaSize:   -1,
+	})
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	conn, client, err := getSftpClient(user)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		content := make([]byte, 3072)
+		_, err = rand.Read(content)
+		assert.NoError(t, err)
+		// user level limit
+		err = writeSFTPContent(testFileName, content, client)
+		assert.Error(t, err)
+		_, err = client.Stat(testFileName)
+		assert.ErrorIs(t, err, os.ErrNotExist)
+		assert.Equal(t, "3 /"+testFileName, getUploadStatus())
+		err = writeSFTPContent(testFileName, content[:1024], client)
+		assert.NoError(t, err)
+		assert.Equal(t, "1 /"+testFileName, getUploadStatus())
+		// the folder limit overrides the user limit
+		err = writeSFTPContent(path.Join(vdirPath, testFileName), content, client)
+		assert.NoError(t, err)
+		// the pattern limits override the folder and user limits
+		err = writeSFTPContent(path.Join(vdirPath, "file.tmp"), content[:101], client)
+		assert.Error(t, err)
+		assert.Equal(t, "3 "+path.Join(vdirPath, "file.tmp"), getUploadStatus())
+		err = writeSFTPContent(path.Join(vdirPath, "file.iso"), content, client)
+		assert.Error(t, err)
+		_, err = client.Stat(path.Join(vdirPath, "file.iso"))
+		assert.ErrorIs(t, err, os.ErrNotExist)
+		err = writeSFTPContent("file.iso", content[:2048], client)
+		assert.NoError(t, err)
+		// the declared size is checked before receiving the data
+		f, err := client.Create(path.Join(vdirPath, "file.dat"))
+		if assert.NoError(t, err) {
+			err = f.Truncate(8192)
+			assert.Error(t, err)
+			err = f.Close()
+			assert.Error(t, err)
+		}
+		_, err = client.Stat(path.Join(vdirPath, "file.dat"))
+		assert.ErrorIs(t, err, os.ErrNotExist)
+		assert.Equal(t, "3 "+path.Join(vdirPath, "file.dat"), getUploadStatus())
+		f, err = client.Create(path.Join(vdirPath, "file.dat"))
+		if assert.NoError(t, err) {
+			err = f.Truncate(2048)
+			assert.NoError(t, err)
+			_, err = f.Write(content[:2048])
+			assert.NoError(t, err)
+			err = f.Close()
+			assert.NoError(t, err)
+		}
+		info, err := client.Stat(path.Join(vdirPath, "file.dat"))
+		if assert.NoError(t, err) {
+			assert.Equal(t, int64(2048), info.Size())
+		}
+	}
+
+	common.Config.Actions.ExecuteOn = nil
+	common.Config.Actions.ExecuteSync = nil
+	common.Config.Actions.Hook = ""
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveFolder(vfs.BaseVirtualFolder{Name: folder.Name}, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(mappedPath)
+	assert.NoError(t, err)
+	err = os.Remove(uploadScriptPath)
+	assert.NoError(t, err)
+	err = os.Remove(uploadOutPath)
+	assert.NoError(t, err)
+}
+
+func TestArchiveExtractionOnUpload(t *testing.T) {
+	if runtime.GOOS == osWindows {
+		t.Skip("this test is not available on Windows")
+	}
+	extractScriptPath := filepath.Join(os.TempDir(), "extract_status.sh")
+	extractOutPath := filepath.Join(os.TempDir(), "extract_status.out")
+	err := os.WriteFile(extractScriptPath, getUploadStatusScriptContent(extractOutPath), 0755)
+	require.NoError(t, err)
+	common.Config.Actions.ExecuteOn = []string{"extract"}
+	common.Config.Actions.ExecuteSync = []string{"extract"}
+	common.Config.Actions.Hook = extractScriptPath
+
+	getExtractStatus := func() string {
+		var status string
+		assert.Eventually(t, func() bool {
+			data, err := os.ReadFile(extractOutPath)
+			if err != nil {
+				return false
+			}
+			status = string(bytes.TrimSpace(data))
+			return true
+		}, 2*time.Second, 50*time.Millisecond)
+		err := os.Remove(extractOutPath)
+		assert.NoError(t, err)
+		return status
+	}
+
+	mappedPath := filepath.Join(os.TempDir(), "batches")
+	folder, _, err := httpdtest.AddFolder(vfs.BaseVirtualFolder{
+		Name:       filepath.Base(mappedPath),
+		MappedPath: mappedPath,
+		Extraction: vfs.FolderExtraction{
+			Patterns:      []string{"*.zip"},
+			TargetDir:     "/incoming",
+			DeleteArchive: true,
+		},
+	}, http.StatusCreated)
+	assert.NoError(t, err)
+	vdirPath := "/batches"
+	u := getTestUser()
+	u.VirtualFolders = append(u.VirtualFolders, vfs.VirtualFolder{
+		BaseVirtualFolder: vfs.BaseVirtualFolder{
+			Name: folder.Name,
+		},
+		VirtualPath: vdirPath,
+		QuotaFiles:  -1,
+		QuotaSize:   -1,
+	})
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	conn, client, err := getSftpClient(user)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		var buf bytes.Buffer
+		zipWriter := zip.NewWriter(&buf)
+		for _, name := range []string{"data.csv", "sub/data.csv", "../outside.csv"} {
+			f, err := zipWriter.Create(name)
+			assert.NoError(t, err)
+			_, err = f.Write([]byte("a,b,c"))
+			assert.NoError(t, err)
+		}
+		assert.NoError(t, zipWriter.Close())
+		// the zip slip entry is rejected, the other entries are extracted
+		// and the archive is not removed
+		err = writeSFTPContent(path.Join(vdirPath, "batch.zip"), buf.Bytes(), client)
+		assert.NoError(t, err)
+		assert.Equal(t, "2 "+path.Join(vdirPath, "batch.zip"), getExtractStatus())
+		_, err = client.Stat(path.Join(vdirPath, "incoming", "data.csv"))
+		assert.NoError(t, err)
+		_, err = client.Stat(path.Join(vdirPath, "incoming", "sub", "data.csv"))
+		assert.NoError(t, err)
+		_, err = client.Stat(path.Join(vdirPath, "outside.csv"))
+		assert.ErrorIs(t, err, os.ErrNotExist)
+		_, err = client.Stat(path.Join(vdirPath, "batch.zip"))
+		assert.NoError(t, err)
+
+		buf.Reset()
+		zipWriter = zip.NewWriter(&buf)
+		f, err := zipWriter.Create("report.csv")
+		assert.NoError(t, err)
+		_, err = f.Write([]byte("a,b,c"))
+		assert.NoError(t, err)
+		assert.NoError(t, zipWriter.Close())
+		err = writeSFTPContent(path.Join(vdirPath, "batch.zip"), buf.Bytes(), client)
+		assert.NoError(t, err)
+		assert.Equal(t, "1 "+path.Join(vdirPath, "batch.zip"), getExtractStatus())
+		_, err = client.Stat(path.Join(vdirPath, "incoming", "report.csv"))
+		assert.NoError(t, err)
+		_, err = client.Stat(path.Join(vdirPath, "batch.zip"))
+		assert.ErrorIs(t, err, os.ErrNotExist)
+		// files not matching the patterns or outside the folder are not extracted
+		err = writeSFTPContent(path.Join(vdirPath, "batch.zap"), buf.Bytes(), client)
+		assert.NoError(t, err)
+		err = writeSFTPContent("batch.zip", buf.Bytes(), client)
+		assert.NoError(t, err)
+		time.Sleep(200 * time.Millisecond)
+		assert.NoFileExists(t, extractOutPath)
+	}
+
+	common.Config.Actions.ExecuteOn = nil
+	common.Config.Actions.ExecuteSync = nil
+	common.Config.Actions.Hook = ""
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveFolder(vfs.BaseVirtualFolder{Name: folder.Name}, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(mappedPath)
+	assert.NoError(t, err)
+	err = os.Remove(extractScriptPath)
+	assert.NoError(t, err)
+}
+
+func TestPGPEncryptionOnUpload(t *testing.T) {
+	if runtime.GOOS == osWindows {
+		t.Skip("this test is not available on Windows")
+	}
+	uploadScriptPath := filepath.Join(os.TempDir(), "upload_pgp.sh")
+	uploadOutPath := filepath.Join(os.TempDir(), "upload_pgp.out")
+	content := []byte("#!/bin/sh\n\n")
+	content = append(content, []byte(fmt.Sprintf("echo \"$SFTPGO_ACTION_VIRTUAL_PATH $SFTPGO_ACTION_FILE_SIZE ${SFTPGO_ACTION_METADATA}\" > %v\n",
+		uploadOutPath))...)
+	content = append(content, []byte("exit 0")...)
+	err := os.WriteFile(uploadScriptPath, content, 0755)
+	require.NoError(t, err)
+	common.Config.Actions.ExecuteOn = []string{"upload"}
+	common.Config.Actions.ExecuteSync = []string{"upload"}
+	common.Config.Actions.Hook = uploadScriptPath
+
+	entity, err := openpgp.NewEntity("sftpgo", "", "sftpgo@example.com", &packet.Config{
+		Algorithm: packet.PubKeyAlgoEdDSA,
+	})
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	armorWriter, err := armor.Encode(&buf, openpgp.PublicKeyType, nil)
+	require.NoError(t, err)
+	require.NoError(t, entity.Serialize(armorWriter))
+	require.NoError(t, armorWriter.Close())
+
+	mappedPath := filepath.Join(os.TempDir(), "pgp_encrypted")
+	folder, _, err := httpdtest.AddFolder(vfs.BaseVirtualFolder{
+		Name:       filepath.Base(mappedPath),
+		MappedPath: mappedPath,
+		Transform: vfs.FolderTransform{
+			PGPPublicKeys: kms.NewPlainSecret(buf.String()),
+		},
+	}, http.StatusCreated)
+	assert.NoError(t, err)
+	vdirPath := "/secure"
+	u := getTestUser()
+	u.QuotaFiles = 100
+	u.Filters.UploadChecksum = true
+	u.VirtualFolders = append(u.VirtualFolders, vfs.VirtualFolder{
+		BaseVirtualFolder: vfs.BaseVirtualFolder{
+			Name: folder.Name,
+		},
+		VirtualPath: vdirPath,
+		QuotaFiles:  -1,
+		QuotaSize:   -1,
+	})
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
 	conn, client, err := getSftpClient(user)
 	if assert.NoError(t, err) {
 		defer conn.Close()
 		defer client.Close()
 
-		lastReceivedEmail.reset()
-		err := client.Mkdir(dirName)
+		data := make([]byte, 65535)
+		_, err = rand.Read(data)
 		assert.NoError(t, err)
-		// the user has no password expiration, the check will be skipped and the ok action executed
-		assert.Eventually(t, func() bool {
-			return lastReceivedEmail.get().From != ""
-		}, 1500*time.Millisecond, 100*time.Millisecond)
-		email := lastReceivedEmail.get()
-		assert.Len(t, email.To, 1)
-		assert.Contains(t, email.To, "success@example.net")
-		err = client.RemoveDirectory(dirName)
+		sum := sha256.Sum256(data)
+		for i := 0; i < 2; i++ {
+			err = writeSFTPContent(path.Join(vdirPath, testFileName), data, client)
+			assert.NoError(t, err)
+			// the size and the checksum refer to the plaintext
+			out, err := os.ReadFile(uploadOutPath)
+			assert.NoError(t, err)
+			assert.Contains(t, string(out), fmt.Sprintf("%s.pgp %d ", path.Join(vdirPath, testFileName), len(data)))
+			assert.Contains(t, string(out), hex.EncodeToString(sum[:]))
+		}
+		_, err = client.Stat(path.Join(vdirPath, testFileName))
+		assert.ErrorIs(t, err, os.ErrNotExist)
+		// downloads return the ciphertext
+		f, err := client.Open(path.Join(vdirPath, testFileName+".pgp"))
+		if assert.NoError(t, err) {
+			encrypted, err := io.ReadAll(f)
+			assert.NoError(t, err)
+			assert.NoError(t, f.Close())
+			md, err := openpgp.ReadMessage(bytes.NewReader(encrypted), openpgp.EntityList{entity}, nil, nil)
+			if assert.NoError(t, err) {
+				decrypted, err := io.ReadAll(md.UnverifiedBody)
+				assert.NoError(t, err)
+				assert.Equal(t, data, decrypted)
+			}
+		}
+		// the existing file is replaced
+		user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
 		assert.NoError(t, err)
+		assert.Equal(t, 1, user.UsedQuotaFiles)
+		// resume is not supported
+		f, err = client.OpenFile(path.Join(vdirPath, testFileName+".pgp"), os.O_WRONLY|os.O_APPEND)
+		if err == nil {
+			_, err = f.Write(data)
+			if err == nil {
+				err = f.Close()
+			} else {
+				f.Close()
+			}
+		}
+		assert.Error(t, err)
 	}
-	user.Filters.PasswordExpiration = 20
-	_, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+
+	common.Config.Actions.ExecuteOn = nil
+	common.Config.Actions.ExecuteSync = nil
+	common.Config.Actions.Hook = ""
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
 	assert.NoError(t, err)
-	conn, client, err = getSftpClient(user)
-	if assert.NoError(t, err) {
-		defer conn.Close()
-		defer client.Close()
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveFolder(vfs.BaseVirtualFolder{Name: folder.Name}, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(mappedPath)
+	assert.NoError(t, err)
+	err = os.Remove(uploadScriptPath)
+	assert.NoError(t, err)
+	err = os.Remove(uploadOutPath)
+	assert.NoError(t, err)
+}
 
-		lastReceivedEmail.reset()
-		err := client.Mkdir(dirName)
-		assert.NoError(t, err)
-		// the passowrd is not about to expire, the check will be skipped and the ok action executed
-		assert.Eventually(t, func() bool {
-			return lastReceivedEmail.get().From != ""
-		}, 1500*time.Millisecond, 100*time.Millisecond)
-		email := lastReceivedEmail.get()
-		assert.Len(t, email.To, 1)
-		assert.Contains(t, email.To, "success@example.net")
-		err = client.RemoveDirectory(dirName)
-		assert.NoError(t, err)
+func TestUploadChecksum(t *testing.T) {
+	if runtime.GOOS == osWindows {
+		t.Skip("this test is not available on Windows")
 	}
-	user.Filters.PasswordExpiration = 5
-	_, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	uploadScriptPath := filepath.Join(os.TempDir(), "upload_checksum.sh")
+	uploadOutPath := filepath.Join(os.TempDir(), "upload_checksum.out")
+	err := os.WriteFile(uploadScriptPath, getUploadChecksumScriptContent(uploadOutPath), 0755)
+	require.NoError(t, err)
+	common.Config.Actions.ExecuteOn = []string{"upload"}
+	common.Config.Actions.ExecuteSync = []string{"upload"}
+	common.Config.Actions.Hook = uploadScriptPath
+
+	getChecksum := func() string {
+		data, err := os.ReadFile(uploadOutPath)
+		require.NoError(t, err)
+		var metadata map[string]string
+		err = json.Unmarshal(bytes.TrimSpace(data), &metadata)
+		if err != nil {
+			return ""
+		}
+		return metadata["sha256"]
+	}
+
+	u := getTestUser()
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
 	assert.NoError(t, err)
-	conn, client, err = getSftpClient(user)
+	conn, client, err := getSftpClient(user)
 	if assert.NoError(t, err) {
 		defer conn.Close()
 		defer client.Close()
 
-		lastReceivedEmail.reset()
-		err := client.Mkdir(dirName)
+		content := make([]byte, 65535)
+		_, err = rand.Read(content)
 		assert.NoError(t, err)
-		// the passowrd is about to expire, the user has no email, the failure action will be executed
-		assert.Eventually(t, func() bool {
-			return lastReceivedEmail.get().From != ""
-		}, 1500*time.Millisecond, 100*time.Millisecond)
-		email := lastReceivedEmail.get()
-		assert.Len(t, email.To, 1)
-		assert.Contains(t, email.To, "failure@example.net")
-		err = client.RemoveDirectory(dirName)
+		sum := sha256.Sum256(content)
+		expected := hex.EncodeToString(sum[:])
+		// checksum disabled
+		err = writeSFTPContent(testFileName, content, client)
+		assert.NoError(t, err)
+		assert.Empty(t, getChecksum())
+		// checksum enabled for the matching patterns
+		common.Config.UploadChecksum.Patterns = []string{"*.dat"}
+		err = writeSFTPContent(testFileName, content, client)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, getChecksum())
+		common.Config.UploadChecksum.Patterns = []string{"*.zip"}
+		err = writeSFTPContent(testFileName, content, client)
+		assert.NoError(t, err)
+		assert.Empty(t, getChecksum())
+		common.Config.UploadChecksum.Patterns = nil
+		// checksum enabled for the user
+		user.Filters.UploadChecksum = true
+		_, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
 		assert.NoError(t, err)
 	}
-	// remove the success action
-	rule1.Actions = []dataprovider.EventAction{
-		{
-			BaseEventAction: dataprovider.BaseEventAction{
-				Name: action2.Name,
-			},
-			Order: 1,
-		},
-		{
-			BaseEventAction: dataprovider.BaseEventAction{
-				Name: action1.Name,
-			},
-			Options: dataprovider.EventActionOptions{
-				IsFailureAction: true,
-			},
-		},
-	}
-	_, _, err = httpdtest.UpdateEventRule(rule1, http.StatusOK)
-	assert.NoError(t, err)
-	user.Email = "user@example.net"
-	user.Filters.AdditionalEmails = []string{"additional@example.net"}
-	_, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
-	assert.NoError(t, err)
 	conn, client, err = getSftpClient(user)
 	if assert.NoError(t, err) {
 		defer conn.Close()
 		defer client.Close()
 
-		lastReceivedEmail.reset()
-		err := client.Mkdir(dirName)
+		content := make([]byte, 65535)
+		_, err = rand.Read(content)
 		assert.NoError(t, err)
-		// the passowrd expiration will be notified
-		assert.Eventually(t, func() bool {
-			return lastReceivedEmail.get().From != ""
-		}, 1500*time.Millisecond, 100*time.Millisecond)
-		email := lastReceivedEmail.get()
-		assert.Len(t, email.To, 2)
-		assert.Contains(t, email.To, user.Email)
-		assert.Contains(t, email.To, user.Filters.AdditionalEmails[0])
-		assert.Contains(t, email.Data, "your SFTPGo password expires in 5 days")
-		err = client.RemoveDirectory(dirName)
+		sum := sha256.Sum256(content)
+		expected := hex.EncodeToString(sum[:])
+		err = writeSFTPContent(testFileName, content, client)
 		assert.NoError(t, err)
+		assert.Equal(t, expected, getChecksum())
+		// non sequential writes, the uploaded file is read again
+		f, err := client.OpenFile(testFileName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+		if assert.NoError(t, err) {
+			_, err = f.WriteAt(content[32768:], 32768)
+			assert.NoError(t, err)
+			_, err = f.WriteAt(content[:32768], 0)
+			assert.NoError(t, err)
+			err = f.Close()
+			assert.NoError(t, err)
+			assert.Equal(t, expected, getChecksum())
+		}
+		// appended uploads
+		f, err = client.OpenFile(testFileName, os.O_WRONLY|os.O_APPEND)
+		if assert.NoError(t, err) {
+			_, err = f.WriteAt([]byte("appended"), int64(len(content)))
+			assert.NoError(t, err)
+			err = f.Close()
+			assert.NoError(t, err)
+			assert.Equal(t, "unavailable", getChecksum())
+		}
 	}
 
-	_, err = httpdtest.RemoveEventRule(rule1, http.StatusOK)
-	assert.NoError(t, err)
-	_, err = httpdtest.RemoveEventAction(action1, http.StatusOK)
-	assert.NoError(t, err)
-	_, err = httpdtest.RemoveEventAction(action2, http.StatusOK)
-	assert.NoError(t, err)
-	_, err = httpdtest.RemoveEventAction(action3, http.StatusOK)
-	assert.NoError(t, err)
 	_, err = httpdtest.RemoveUser(user, http.StatusOK)
 	assert.NoError(t, err)
 	err = os.RemoveAll(user.GetHomeDir())
 	assert.NoError(t, err)
-
-	smtpCfg = smtp.Config{}
-	err = smtpCfg.Initialize(configDir, true)
-	require.NoError(t, err)
+	err = os.Remove(uploadScriptPath)
+	assert.NoError(t, err)
+	err = os.Remove(uploadOutPath)
+	assert.NoError(t, err)
+	common.Config.Actions.ExecuteOn = nil
+	common.Config.Actions.ExecuteSync = nil
+	common.Config.Actions.Hook = ""
 }
 
-func TestSyncUploadAction(t *testing.T) {
+func TestPreDeleteRenameHooks(t *testing.T) {
 	if runtime.GOOS == osWindows {
 		t.Skip("this test is not available on Windows")
 	}
-	uploadScriptPath := filepath.Join(os.TempDir(), "upload.sh")
-	common.Config.Actions.ExecuteOn = []string{"upload"}
-	common.Config.Actions.ExecuteSync = []string{"upload"}
-	common.Config.Actions.Hook = uploadScriptPath
-
-	u := getTestUser()
-	u.QuotaFiles = 1000
-	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	preActionScriptPath := filepath.Join(os.TempDir(), "pre_action.sh")
+	preActionOutPath := filepath.Join(os.TempDir(), "pre_action.out")
+	err := os.WriteFile(preActionScriptPath, getPreActionDenyScriptContent(preActionOutPath), 0755)
 	assert.NoError(t, err)
-	movedFileName := "moved.dat"
-	movedPath := filepath.Join(user.HomeDir, movedFileName)
-	err = os.WriteFile(uploadScriptPath, getUploadScriptContent(movedPath, "", 0), 0755)
+	common.Config.Actions.ExecuteOn = []string{"pre-delete", "pre-rename"}
+	common.Config.Actions.Hook = preActionScriptPath
+
+	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
 	assert.NoError(t, err)
 	conn, client, err := getSftpClient(user)
 	if assert.NoError(t, err) {
 		defer conn.Close()
 		defer client.Close()
 
-		size := int64(32768)
-		err = writeSFTPFileNoCheck(testFileName, size, client)
-		assert.NoError(t, err)
-		_, err = client.Stat(testFileName)
-		assert.Error(t, err)
-		info, err := client.Stat(movedFileName)
-		if assert.NoError(t, err) {
-			assert.Equal(t, size, info.Size())
-		}
-		user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
-		assert.NoError(t, err)
-		assert.Equal(t, 1, user.UsedQuotaFiles)
-		assert.Equal(t, size, user.UsedQuotaSize)
-		// test some hook failure
-		// the uploaded file is moved and the hook fails, it will be not removed from the quota
-		err = os.WriteFile(uploadScriptPath, getUploadScriptContent(movedPath, "", 1), 0755)
+		deniedFileName := "deny_" + testFileName
+		err = writeSFTPFile(deniedFileName, 100, client)
 		assert.NoError(t, err)
-		err = writeSFTPFileNoCheck(testFileName+"_1", size, client)
-		assert.Error(t, err)
-		user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+		err = writeSFTPFile(testFileName, 100, client)
 		assert.NoError(t, err)
-		assert.Equal(t, 2, user.UsedQuotaFiles)
-		assert.Equal(t, size*2, user.UsedQuotaSize)
 
-		// the uploaded file is not moved and the hook fails, the uploaded file will be deleted
-		// and removed from the quota
-		movedPath = filepath.Join(user.HomeDir, "missing dir", movedFileName)
-		err = os.WriteFile(uploadScriptPath, getUploadScriptContent(movedPath, "", 1), 0755)
+		err = client.Remove(deniedFileName)
+		assert.ErrorIs(t, err, os.ErrPermission)
+		_, err = client.Stat(deniedFileName)
 		assert.NoError(t, err)
-		err = writeSFTPFileNoCheck(testFileName+"_2", size, client)
-		assert.Error(t, err)
-		user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+		err = client.Rename(deniedFileName, testFileName+"_renamed")
+		assert.ErrorIs(t, err, os.ErrPermission)
+		_, err = client.Stat(deniedFileName)
 		assert.NoError(t, err)
-		assert.Equal(t, 2, user.UsedQuotaFiles)
-		assert.Equal(t, size*2, user.UsedQuotaSize)
-		// overwrite an existing file
-		_, err = client.Stat(movedFileName)
+		// the target path is denied
+		err = client.Rename(testFileName, deniedFileName+"_renamed")
+		assert.ErrorIs(t, err, os.ErrPermission)
+		_, err = client.Stat(testFileName)
 		assert.NoError(t, err)
-		err = writeSFTPFileNoCheck(movedFileName, size, client)
-		assert.Error(t, err)
-		_, err = client.Stat(movedFileName)
-		assert.Error(t, err)
-		user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+
+		err = client.Rename(testFileName, testFileName+"_renamed")
 		assert.NoError(t, err)
-		assert.Equal(t, 1, user.UsedQuotaFiles)
-		assert.Equal(t, size, user.UsedQuotaSize)
+		out, err := os.ReadFile(preActionOutPath)
+		if assert.NoError(t, err) {
+			assert.Contains(t, string(out), "pre-rename /"+testFileName+" /"+testFileName+"_renamed 100")
+		}
+		err = client.Remove(testFileName + "_renamed")
+		assert.NoError(t, err)
+		out, err = os.ReadFile(preActionOutPath)
+		if assert.NoError(t, err) {
+			assert.Contains(t, string(out), "pre-delete /"+testFileName+"_renamed  100")
+		}
+		// the operation is denied if the hook cannot be executed
+		common.Config.Actions.Hook = "/invalid/path"
+		err = client.Remove(deniedFileName)
+		assert.ErrorIs(t, err, os.ErrPermission)
+		common.Config.Actions.Hook = preActionScriptPath
 	}
 
-	err = os.Remove(uploadScriptPath)
+	common.Config.Actions.ExecuteOn = nil
+	common.Config.Actions.Hook = ""
+	err = os.Remove(preActionScriptPath)
+	assert.NoError(t, err)
+	err = os.Remove(preActionOutPath)
 	assert.NoError(t, err)
 	_, err = httpdtest.RemoveUser(user, http.StatusOK)
 	assert.NoError(t, err)
 	err = os.RemoveAll(user.GetHomeDir())
 	assert.NoError(t, err)
-
-	common.Config.Actions.ExecuteOn = nil
-	common.Config.Actions.ExecuteSync = nil
-	common.Config.Actions.Hook = uploadScriptPath
 }
 
 func TestQuotaTrackDisabled(t *testing.T) {
@@ -9817,6 +11498,10 @@ func writeSFTPFileNoCheck(name string, size int64, client *sftp.Client) error {
 	if err != nil {
 		return err
 	}
+	return writeSFTPContent(name, content, client)
+}
+
+func writeSFTPContent(name string, content []byte, client *sftp.Client) error {
 	f, err := client.Create(name)
 	if err != nil {
 		return err
@@ -9851,6 +11536,38 @@ func getUploadScriptContent(movedPath, logFilePath string, exitStatus int) []byt
 	return content
 }
 
+func getUploadScanScriptContent(outFilePath string) []byte {
+	content := []byte("#!/bin/sh\n\n")
+	content = append(content, []byte(fmt.Sprintf("echo ${SFTPGO_ACTION_PATH} > %v\n", outFilePath))...)
+	content = append(content, []byte(fmt.Sprintf("cat > %v.data\n", outFilePath))...)
+	content = append(content, []byte("exit 0")...)
+	return content
+}
+
+func getUploadChecksumScriptContent(outFilePath string) []byte {
+	content := []byte("#!/bin/sh\n\n")
+	content = append(content, []byte(fmt.Sprintf("echo \"${SFTPGO_ACTION_METADATA}\" > %v\n", outFilePath))...)
+	content = append(content, []byte("exit 0")...)
+	return content
+}
+
+func getUploadStatusScriptContent(outFilePath string) []byte {
+	content := []byte("#!/bin/sh\n\n")
+	content = append(content, []byte(fmt.Sprintf("echo \"$SFTPGO_ACTION_STATUS $SFTPGO_ACTION_VIRTUAL_PATH\" > %v\n", outFilePath))...)
+	content = append(content, []byte("exit 0")...)
+	return content
+}
+
+func getPreActionDenyScriptContent(outFilePath string) []byte {
+	content := []byte("#!/bin/sh\n\n")
+	content = append(content, []byte(fmt.Sprintf("echo \"$SFTPGO_ACTION $SFTPGO_ACTION_VIRTUAL_PATH $SFTPGO_ACTION_VIRTUAL_TARGET $SFTPGO_ACTION_FILE_SIZE\" > %v\n", outFilePath))...)
+	content = append(content, []byte("case \"$SFTPGO_ACTION_VIRTUAL_PATH $SFTPGO_ACTION_VIRTUAL_TARGET\" in\n")...)
+	content = append(content, []byte("    *deny*) echo \"file not processed\"; exit 1;;\n")...)
+	content = append(content, []byte("esac\n")...)
+	content = append(content, []byte("exit 0")...)
+	return content
+}
+
 func getSaveProviderObjectScriptContent(outFilePath string, exitStatus int) []byte {
 	content := []byte("#!/bin/sh\n\n")
 	content = append(content, []byte(fmt.Sprintf("echo ${SFTPGO_OBJECT_DATA} > %v\n", outFilePath))...)
@@ -0,0 +1,137 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+// name of the maintenance job for the folders quota reconciliation
+const quotaReconciliationJobName = "folders_quota_reconciliation"
+
+// QuotaReconciliationConfig defines the configuration for the scheduled
+// quota scans of the virtual folders. Only the folders modified since their
+// last scan are scanned again, unless the last scan is older than the
+// configured full scan interval
+type QuotaReconciliationConfig struct {
+	// Interval between two reconciliations as minutes. 0 means disabled
+	CheckInterval int `json:"check_interval" mapstructure:"check_interval"`
+	// Folders not scanned within this interval, as hours, are scanned even
+	// if unmodified. 0 means that unmodified folders are never scanned again
+	FullScanInterval int `json:"full_scan_interval" mapstructure:"full_scan_interval"`
+	// Maximum number of folders scanned concurrently
+	MaxConcurrency int `json:"max_concurrency" mapstructure:"max_concurrency"`
+}
+
+func (c *QuotaReconciliationConfig) validate() error {
+	if c.CheckInterval < 0 {
+		return fmt.Errorf("invalid check interval: %d", c.CheckInterval)
+	}
+	if c.FullScanInterval < 0 {
+		return fmt.Errorf("invalid full scan interval: %d", c.FullScanInterval)
+	}
+	if c.MaxConcurrency < 0 {
+		return fmt.Errorf("invalid max concurrency: %d", c.MaxConcurrency)
+	}
+	return nil
+}
+
+func (c *QuotaReconciliationConfig) getMaxConcurrency() int {
+	if c.MaxConcurrency == 0 {
+		return 1
+	}
+	return c.MaxConcurrency
+}
+
+func (c *QuotaReconciliationConfig) getFullScanInterval() time.Duration {
+	return time.Duration(c.FullScanInterval) * time.Hour
+}
+
+func scheduleQuotaReconciliation() {
+	if Config.QuotaReconciliation.CheckInterval == 0 {
+		return
+	}
+	interval := time.Duration(Config.QuotaReconciliation.CheckInterval) * time.Minute
+	scheduleMaintenanceJob(quotaReconciliationJobName, interval, reconcileFoldersQuota)
+}
+
+func reconcileFoldersQuota(ctx context.Context) error {
+	folders, err := dataprovider.GetFoldersToReconcile(Config.QuotaReconciliation.getFullScanInterval())
+	if err != nil {
+		return fmt.Errorf("unable to get the folders to reconcile: %w", err)
+	}
+	if len(folders) == 0 {
+		return nil
+	}
+	logger.Debug(logSender, "", "start quota reconciliation for %d folders", len(folders))
+	sem := make(chan struct{}, Config.QuotaReconciliation.getMaxConcurrency())
+	var wg sync.WaitGroup
+
+	for _, folder := range folders {
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(folder vfs.BaseVirtualFolder) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			reconcileFolderQuota(folder)
+		}(folder)
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("quota reconciliation interrupted: %w", err)
+	}
+	logger.Debug(logSender, "", "quota reconciliation completed for %d folders", len(folders))
+	return nil
+}
+
+func reconcileFolderQuota(folder vfs.BaseVirtualFolder) {
+	if !QuotaScans.AddVFolderQuotaScan(folder.Name) {
+		logger.Debug(logSender, "", "quota scan already in progress for folder %q, skipping reconciliation",
+			folder.Name)
+		return
+	}
+	defer QuotaScans.RemoveVFolderQuotaScan(folder.Name)
+
+	f := vfs.VirtualFolder{
+		BaseVirtualFolder: folder,
+		VirtualPath:       "/",
+	}
+	startTime := time.Now()
+	numFiles, size, err := f.ScanQuota()
+	if err != nil {
+		logger.Warn(logSender, "", "error scanning quota for folder %q: %v", folder.Name, err)
+		return
+	}
+	if err := dataprovider.UpdateVirtualFolderQuotaScan(&folder, numFiles, size); err != nil {
+		logger.Warn(logSender, "", "unable to update quota scan for folder %q: %v", folder.Name, err)
+		return
+	}
+	logger.Info(logSender, "", "quota reconciled for folder %q, files: %d, size: %d, drift files: %d, drift size: %d, elapsed: %s",
+		folder.Name, numFiles, size, folder.QuotaScan.DriftFiles, folder.QuotaScan.DriftSize, time.Since(startTime))
+}
@@ -0,0 +1,160 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+func TestQuotaReconciliationConfig(t *testing.T) {
+	c := QuotaReconciliationConfig{}
+	assert.NoError(t, c.validate())
+	assert.Equal(t, 1, c.getMaxConcurrency())
+	assert.Equal(t, time.Duration(0), c.getFullScanInterval())
+	c.MaxConcurrency = 3
+	c.FullScanInterval = 24
+	assert.NoError(t, c.validate())
+	assert.Equal(t, 3, c.getMaxConcurrency())
+	assert.Equal(t, 24*time.Hour, c.getFullScanInterval())
+	c.CheckInterval = -1
+	assert.Error(t, c.validate())
+	c.CheckInterval = 0
+	c.FullScanInterval = -1
+	assert.Error(t, c.validate())
+	c.FullScanInterval = 0
+	c.MaxConcurrency = -1
+	assert.Error(t, c.validate())
+}
+
+func TestFoldersQuotaReconciliation(t *testing.T) {
+	mappedPath := filepath.Join(os.TempDir(), "quota_reconciliation")
+	folder := vfs.BaseVirtualFolder{
+		Name:       "quota_reconciliation",
+		MappedPath: mappedPath,
+	}
+	err := dataprovider.AddFolder(&folder, "", "", "")
+	require.NoError(t, err)
+	placeholderFolder := vfs.BaseVirtualFolder{
+		Name:       "quota_reconciliation_placeholder",
+		MappedPath: filepath.Join(os.TempDir(), "%username%"),
+	}
+	err = dataprovider.AddFolder(&placeholderFolder, "", "", "")
+	require.NoError(t, err)
+
+	createFile := func(name string) {
+		err := os.MkdirAll(mappedPath, os.ModePerm)
+		require.NoError(t, err)
+		err = os.WriteFile(filepath.Join(mappedPath, name), []byte("data"), 0666)
+		require.NoError(t, err)
+	}
+	isToReconcile := func(fullScanInterval time.Duration) bool {
+		folders, err := dataprovider.GetFoldersToReconcile(fullScanInterval)
+		require.NoError(t, err)
+		assert.False(t, slices.ContainsFunc(folders, func(f vfs.BaseVirtualFolder) bool {
+			return f.Name == placeholderFolder.Name
+		}))
+		return slices.ContainsFunc(folders, func(f vfs.BaseVirtualFolder) bool {
+			return f.Name == folder.Name
+		})
+	}
+	createFile("file1.txt")
+	createFile("file2.txt")
+	// never scanned
+	assert.True(t, isToReconcile(0))
+	// a reconciliation already in progress must be skipped
+	job := newMaintenanceJob(quotaReconciliationJobName, time.Hour, reconcileFoldersQuota)
+	job.running.Store(true)
+	job.run()
+	job.running.Store(false)
+	folder, err = dataprovider.GetFolderByName(folder.Name)
+	require.NoError(t, err)
+	assert.Nil(t, folder.QuotaScan)
+	// a manual scan in progress must be skipped
+	assert.True(t, QuotaScans.AddVFolderQuotaScan(folder.Name))
+	reconcileFolderQuota(folder)
+	assert.True(t, QuotaScans.RemoveVFolderQuotaScan(folder.Name))
+	folder, err = dataprovider.GetFolderByName(folder.Name)
+	require.NoError(t, err)
+	assert.Nil(t, folder.QuotaScan)
+	// an interrupted reconciliation must not scan the folders
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = reconcileFoldersQuota(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+	folder, err = dataprovider.GetFolderByName(folder.Name)
+	require.NoError(t, err)
+	assert.Nil(t, folder.QuotaScan)
+
+	err = reconcileFoldersQuota(context.Background())
+	assert.NoError(t, err)
+	folder, err = dataprovider.GetFolderByName(folder.Name)
+	require.NoError(t, err)
+	require.NotNil(t, folder.QuotaScan)
+	assert.Equal(t, 2, folder.UsedQuotaFiles)
+	assert.Equal(t, int64(8), folder.UsedQuotaSize)
+	assert.Equal(t, folder.QuotaScan.LastScan, folder.LastQuotaUpdate)
+	assert.Equal(t, 2, folder.QuotaScan.ScannedFiles)
+	assert.Equal(t, int64(8), folder.QuotaScan.ScannedSize)
+	assert.Equal(t, -2, folder.QuotaScan.DriftFiles)
+	assert.Equal(t, int64(-8), folder.QuotaScan.DriftSize)
+	assert.False(t, folder.IsModifiedSinceQuotaScan())
+	assert.False(t, isToReconcile(0))
+	assert.False(t, isToReconcile(time.Hour))
+	// updating the folder must preserve the scan results
+	folder.Description = "reconciled"
+	err = dataprovider.UpdateFolder(&folder, nil, nil, "", "", "")
+	require.NoError(t, err)
+	folder, err = dataprovider.GetFolderByName(folder.Name)
+	require.NoError(t, err)
+	require.NotNil(t, folder.QuotaScan)
+	assert.Equal(t, 2, folder.QuotaScan.ScannedFiles)
+
+	time.Sleep(10 * time.Millisecond)
+	assert.True(t, isToReconcile(time.Millisecond))
+	// the tracked usage is updated after the scan and counts a file that
+	// is not stored
+	err = dataprovider.UpdateVirtualFolderQuota(&folder, 2, 8, false)
+	require.NoError(t, err)
+	createFile("file3.txt")
+	assert.True(t, isToReconcile(0))
+	err = reconcileFoldersQuota(context.Background())
+	assert.NoError(t, err)
+	folder, err = dataprovider.GetFolderByName(folder.Name)
+	require.NoError(t, err)
+	require.NotNil(t, folder.QuotaScan)
+	assert.Equal(t, 3, folder.UsedQuotaFiles)
+	assert.Equal(t, int64(12), folder.UsedQuotaSize)
+	assert.Equal(t, 1, folder.QuotaScan.DriftFiles)
+	assert.Equal(t, int64(4), folder.QuotaScan.DriftSize)
+	assert.False(t, isToReconcile(0))
+
+	err = dataprovider.DeleteFolder(folder.Name, "", "", "")
+	assert.NoError(t, err)
+	err = dataprovider.DeleteFolder(placeholderFolder.Name, "", "", "")
+	assert.NoError(t, err)
+	err = os.RemoveAll(mappedPath)
+	assert.NoError(t, err)
+}
@@ -132,6 +132,47 @@ func (r *RateLimiterConfig) getLimiter() *rateLimiter {
 	return limiter
 }
 
+// SourceRateLimiter is a per-source rate limiter not bound to any protocol,
+// it can be used to apply stricter limits to specific clients
+type SourceRateLimiter struct {
+	limiter *rateLimiter
+}
+
+// NewSourceRateLimiter returns a per-source rate limiter using the average,
+// period, burst, generate_defender_events and entries limits settings from
+// the given configuration. The type and the protocols are ignored
+func NewSourceRateLimiter(config RateLimiterConfig) (*SourceRateLimiter, error) {
+	config.Type = int(rateLimiterTypeSource)
+	config.Protocols = nil
+	if config.Period == 0 {
+		config.Period = 1000
+	}
+	if config.Burst == 0 {
+		config.Burst = 1
+	}
+	if config.EntriesSoftLimit == 0 {
+		config.EntriesSoftLimit = 100
+	}
+	if config.EntriesHardLimit == 0 {
+		config.EntriesHardLimit = config.EntriesSoftLimit + config.EntriesSoftLimit/2
+	}
+	if config.Average <= 0 {
+		return nil, fmt.Errorf("invalid average %v. It must be > 0", config.Average)
+	}
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return &SourceRateLimiter{
+		limiter: config.getLimiter(),
+	}, nil
+}
+
+// Wait blocks until the limit allows one event to happen for the specified
+// source or returns an error if the time to wait exceeds the max allowed delay
+func (l *SourceRateLimiter) Wait(source, protocol string) (time.Duration, error) {
+	return l.limiter.Wait(source, protocol)
+}
+
 // RateLimiter defines a rate limiter
 type rateLimiter struct {
 	rate                   rate.Limit
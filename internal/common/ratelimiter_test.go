@@ -147,3 +147,30 @@ func TestLimiterCleanup(t *testing.T) {
 	_, ok = limiter.buckets.buckets[source4]
 	assert.True(t, ok)
 }
+
+func TestSourceRateLimiter(t *testing.T) {
+	_, err := NewSourceRateLimiter(RateLimiterConfig{})
+	assert.Error(t, err)
+	_, err = NewSourceRateLimiter(RateLimiterConfig{
+		Average:          1,
+		EntriesSoftLimit: 10,
+		EntriesHardLimit: 5,
+	})
+	assert.Error(t, err)
+	limiter, err := NewSourceRateLimiter(RateLimiterConfig{
+		Average:   1,
+		Type:      int(rateLimiterTypeGlobal),
+		Protocols: []string{ProtocolFTP},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, limiter.limiter.burst)
+	assert.Equal(t, 100, limiter.limiter.buckets.softLimit)
+	assert.Equal(t, 150, limiter.limiter.buckets.hardLimit)
+	source := "192.168.1.3"
+	_, err = limiter.Wait(source, ProtocolSSH)
+	assert.NoError(t, err)
+	_, err = limiter.Wait(source, ProtocolSSH)
+	assert.Error(t, err)
+	_, err = limiter.Wait(source+"1", ProtocolSSH)
+	assert.NoError(t, err)
+}
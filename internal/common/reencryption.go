@@ -0,0 +1,286 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rs/xid"
+	"github.com/sftpgo/sdk"
+	"golang.org/x/time/rate"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+// Supported re-encryption targets
+const (
+	ReEncryptionTargetUser   = "user"
+	ReEncryptionTargetFolder = "folder"
+)
+
+// Re-encryption statuses
+const (
+	ReEncryptionStatusRunning   = "running"
+	ReEncryptionStatusCompleted = "completed"
+	ReEncryptionStatusStopped   = "stopped"
+	ReEncryptionStatusFailed    = "failed"
+)
+
+// maximum number of failures stored for each re-encryption, all the
+// failures are logged
+const maxReEncryptionFailures = 100
+
+var (
+	// ErrReEncryptionInProgress is returned if a re-encryption is already
+	// running for the requested target
+	ErrReEncryptionInProgress = errors.New("a re-encryption is already in progress")
+	// ReEncryptions holds the running and the completed re-encryptions
+	ReEncryptions = ActiveReEncryptions{
+		jobs: make(map[string]*reEncryption),
+	}
+)
+
+// ReEncryptionConfig defines the configuration for the re-encryption of the
+// encrypted filesystems after a passphrase rotation
+type ReEncryptionConfig struct {
+	// Maximum read bandwidth, as KB/s, for each re-encryption. 0 means no limit
+	MaxBandwidth int `json:"max_bandwidth" mapstructure:"max_bandwidth"`
+}
+
+func (c *ReEncryptionConfig) validate() error {
+	if c.MaxBandwidth < 0 {
+		return fmt.Errorf("invalid max bandwidth: %d", c.MaxBandwidth)
+	}
+	return nil
+}
+
+func (c *ReEncryptionConfig) getLimiter() *rate.Limiter {
+	if c.MaxBandwidth == 0 {
+		return nil
+	}
+	limit := c.MaxBandwidth * 1024
+	return rate.NewLimiter(rate.Limit(limit), limit)
+}
+
+// ReEncryptionFailure defines a file that cannot be re-encrypted
+type ReEncryptionFailure struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// ReEncryptionStatus defines the progress for a re-encryption
+type ReEncryptionStatus struct {
+	// user or folder
+	Target string `json:"target"`
+	// username or folder name
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	// start and end time as unix timestamp in milliseconds
+	StartTime int64 `json:"start_time"`
+	EndTime   int64 `json:"end_time,omitempty"`
+	// number of files processed, re-encrypted or already using the current passphrase
+	ProcessedFiles   int   `json:"processed_files"`
+	ReEncryptedFiles int   `json:"reencrypted_files"`
+	ReEncryptedSize  int64 `json:"reencrypted_size"`
+	FailedFiles      int   `json:"failed_files"`
+	// the first failures, all the failures are logged
+	Failures []ReEncryptionFailure `json:"failures,omitempty"`
+	// error that stopped the re-encryption, if any
+	Error string `json:"error,omitempty"`
+}
+
+type reEncryption struct {
+	mu     sync.RWMutex
+	status ReEncryptionStatus
+	cancel context.CancelFunc
+}
+
+func (r *reEncryption) getStatus() ReEncryptionStatus {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	status := r.status
+	status.Failures = slices.Clone(r.status.Failures)
+	return status
+}
+
+func (r *reEncryption) isRunning() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.status.Status == ReEncryptionStatusRunning
+}
+
+func (r *reEncryption) update(virtualPath string, size int64, reEncrypted bool, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.status.ProcessedFiles++
+	if err != nil {
+		r.status.FailedFiles++
+		if len(r.status.Failures) < maxReEncryptionFailures {
+			r.status.Failures = append(r.status.Failures, ReEncryptionFailure{
+				Path:  virtualPath,
+				Error: err.Error(),
+			})
+		}
+		logger.Warn(logSender, "", "re-encryption for %s %q, unable to re-encrypt file %q: %v",
+			r.status.Target, r.status.Name, virtualPath, err)
+		return
+	}
+	if reEncrypted {
+		r.status.ReEncryptedFiles++
+		r.status.ReEncryptedSize += size
+		logger.Debug(logSender, "", "re-encryption for %s %q, file %q re-encrypted, size: %d",
+			r.status.Target, r.status.Name, virtualPath, size)
+	}
+}
+
+func (r *reEncryption) setCompleted(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.status.EndTime = util.GetTimeAsMsSinceEpoch(time.Now())
+	switch {
+	case err == nil:
+		r.status.Status = ReEncryptionStatusCompleted
+	case errors.Is(err, context.Canceled):
+		r.status.Status = ReEncryptionStatusStopped
+	default:
+		r.status.Status = ReEncryptionStatusFailed
+		r.status.Error = err.Error()
+	}
+	r.cancel = nil
+	logger.Info(logSender, "", "re-encryption for %s %q %s, processed files: %d, re-encrypted files: %d, "+
+		"re-encrypted size: %d, failed files: %d, err: %v", r.status.Target, r.status.Name, r.status.Status,
+		r.status.ProcessedFiles, r.status.ReEncryptedFiles, r.status.ReEncryptedSize, r.status.FailedFiles, err)
+}
+
+// ActiveReEncryptions holds the re-encryptions. The last re-encryption for
+// each target is kept after completion so its results can be inspected
+type ActiveReEncryptions struct {
+	sync.RWMutex
+	jobs map[string]*reEncryption
+}
+
+// Get returns the status for all the re-encryptions
+func (r *ActiveReEncryptions) Get() []ReEncryptionStatus {
+	r.RLock()
+	defer r.RUnlock()
+
+	result := make([]ReEncryptionStatus, 0, len(r.jobs))
+	for _, job := range r.jobs {
+		result = append(result, job.getStatus())
+	}
+	slices.SortFunc(result, func(a, b ReEncryptionStatus) int {
+		return cmp.Compare(a.StartTime, b.StartTime)
+	})
+	return result
+}
+
+// StartForUser starts the re-encryption for the home directory of the
+// specified user, it must use an encrypted filesystem with retired passphrases
+func (r *ActiveReEncryptions) StartForUser(username string) error {
+	user, err := dataprovider.GetUserWithGroupSettings(username, "")
+	if err != nil {
+		return err
+	}
+	return r.start(ReEncryptionTargetUser, user.Username, user.GetHomeDir(), user.FsConfig)
+}
+
+// StartForFolder starts the re-encryption for the specified virtual folder,
+// it must use an encrypted filesystem with retired passphrases
+func (r *ActiveReEncryptions) StartForFolder(name string) error {
+	folder, err := dataprovider.GetFolderByName(name)
+	if err != nil {
+		return err
+	}
+	return r.start(ReEncryptionTargetFolder, folder.Name, folder.MappedPath, folder.FsConfig)
+}
+
+// Stop stops the running re-encryption for the specified target.
+// It returns false if no re-encryption is running for the target
+func (r *ActiveReEncryptions) Stop(target, name string) bool {
+	r.RLock()
+	defer r.RUnlock()
+
+	job, ok := r.jobs[getReEncryptionKey(target, name)]
+	if !ok {
+		return false
+	}
+	job.mu.RLock()
+	defer job.mu.RUnlock()
+
+	if job.cancel == nil {
+		return false
+	}
+	job.cancel()
+	return true
+}
+
+func (r *ActiveReEncryptions) start(target, name, rootDir string, fsConfig vfs.Filesystem) error {
+	if fsConfig.Provider != sdk.CryptedFilesystemProvider {
+		return util.NewValidationError(fmt.Sprintf("%s %q does not use an encrypted filesystem", target, name))
+	}
+	if len(fsConfig.CryptConfig.RetiredPassphrases) == 0 {
+		return util.NewValidationError(fmt.Sprintf("%s %q has no retired passphrases", target, name))
+	}
+	connectionID := fmt.Sprintf("reencrypt_%s", xid.New().String())
+	fs, err := vfs.NewCryptFs(connectionID, rootDir, "", fsConfig.CryptConfig)
+	if err != nil {
+		return err
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	key := getReEncryptionKey(target, name)
+	if job, ok := r.jobs[key]; ok && job.isRunning() {
+		return ErrReEncryptionInProgress
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &reEncryption{
+		status: ReEncryptionStatus{
+			Target:    target,
+			Name:      name,
+			Status:    ReEncryptionStatusRunning,
+			StartTime: util.GetTimeAsMsSinceEpoch(time.Now()),
+		},
+		cancel: cancel,
+	}
+	r.jobs[key] = job
+	logger.Info(logSender, "", "starting re-encryption for %s %q, root dir %q", target, name, rootDir)
+
+	go func() {
+		defer cancel()
+
+		err := fs.(*vfs.CryptFs).ReEncrypt(ctx, Config.ReEncryption.getLimiter(), job.update)
+		job.setCompleted(err)
+	}()
+	return nil
+}
+
+func getReEncryptionKey(target, name string) string {
+	return target + "_" + name
+}
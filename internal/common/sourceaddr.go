@@ -0,0 +1,81 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"net"
+	"sync"
+
+	"github.com/pires/go-proxyproto"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// connection sources keyed by the remote address of the tracked connections.
+// The remote address is not wrapped since some libraries, for example the
+// SSH certificate checker, require a *net.TCPAddr
+var connectionSources sync.Map
+
+// TrackConnectionSource records the transport peer and the provenance of the
+// client address for the given connection. The returned function must be
+// called when the connection is closed. For proxy protocol connections the
+// proxy header is read, if not already done, so this function can block up to
+// the proxy header read timeout
+func TrackConnectionSource(conn net.Conn) func() {
+	addr := conn.RemoteAddr()
+	if addr == nil {
+		return func() {}
+	}
+	connectionSources.Store(addr, getConnectionSource(conn))
+	return func() {
+		connectionSources.Delete(addr)
+	}
+}
+
+func getConnectionSource(conn net.Conn) dataprovider.ConnectionSource {
+	source := dataprovider.ConnectionSource{
+		PeerIP:     util.GetIPFromRemoteAddress(conn.RemoteAddr().String()),
+		Provenance: dataprovider.SourceAddrDirect,
+	}
+	proxyConn, ok := conn.(*proxyproto.Conn)
+	if !ok {
+		// the connections from the proxy skipped hosts are not wrapped
+		return source
+	}
+	source.PeerIP = util.GetIPFromRemoteAddress(proxyConn.Raw().RemoteAddr().String())
+	header := proxyConn.ProxyHeader()
+	if header != nil && !header.Command.IsLocal() {
+		// the header is used for the trusted proxies only
+		source.Provenance = dataprovider.SourceAddrProxy
+		return source
+	}
+	// the header was ignored or not sent, the transport peer could be a proxy
+	source.Provenance = dataprovider.SourceAddrUntrusted
+	return source
+}
+
+// GetConnectionSource returns the transport peer IP and the provenance of the
+// client address for the connection with the given remote address. An empty
+// provenance means that the connection is not tracked
+func GetConnectionSource(addr net.Addr) dataprovider.ConnectionSource {
+	if addr == nil {
+		return dataprovider.ConnectionSource{}
+	}
+	if source, ok := connectionSources.Load(addr); ok {
+		return source.(dataprovider.ConnectionSource)
+	}
+	return dataprovider.ConnectionSource{}
+}
@@ -0,0 +1,46 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"github.com/drakkan/sftpgo/v2/internal/tracing"
+)
+
+// TracingConfig defines the configuration for the OpenTelemetry tracing.
+// If enabled, a span is created for each SSH connection with child spans
+// for each SFTP request and storage backend call
+type TracingConfig struct {
+	// OTLP HTTP exporter endpoint as host:port, for example "localhost:4318".
+	// Leave empty to disable tracing
+	Endpoint string `json:"endpoint" mapstructure:"endpoint"`
+	// URL path for the exporter endpoint. Leave empty to use "/v1/traces"
+	URLPath string `json:"url_path" mapstructure:"url_path"`
+	// Set to true to connect to the exporter endpoint using HTTP instead of HTTPS
+	Insecure bool `json:"insecure" mapstructure:"insecure"`
+	// Sampling strategy: "ratio" or "parent_based"
+	Sampler string `json:"sampler" mapstructure:"sampler"`
+	// Fraction of the traces to sample, from 0 to 1
+	SamplingRatio float64 `json:"sampling_ratio" mapstructure:"sampling_ratio"`
+}
+
+func (c *TracingConfig) initialize() error {
+	return tracing.Initialize(tracing.Config{
+		Endpoint:      c.Endpoint,
+		URLPath:       c.URLPath,
+		Insecure:      c.Insecure,
+		Sampler:       c.Sampler,
+		SamplingRatio: c.SamplingRatio,
+	})
+}
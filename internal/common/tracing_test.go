@@ -0,0 +1,84 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"os"
+	"testing"
+
+	"github.com/sftpgo/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/tracing"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+func TestTracedFs(t *testing.T) {
+	c := TracingConfig{
+		Endpoint:      "127.0.0.1:4318",
+		Insecure:      true,
+		Sampler:       tracing.SamplerRatio,
+		SamplingRatio: 1,
+	}
+	err := c.initialize()
+	require.NoError(t, err)
+
+	connectionID := "traced_fs"
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: "user",
+			HomeDir:  os.TempDir(),
+		},
+	}
+	fs, err := user.GetFilesystem(connectionID)
+	require.NoError(t, err)
+	_, ok := fs.(*vfs.OsFs)
+	assert.False(t, ok)
+	_, ok = vfs.UnwrapFs(fs).(*vfs.OsFs)
+	assert.True(t, ok)
+	_, ok = fs.(vfs.FsRealPather)
+	assert.True(t, ok)
+	assert.True(t, vfs.IsLocalOsFs(fs))
+	assert.True(t, vfs.FsOpenReturnsFile(fs))
+	assert.False(t, vfs.IsBufferedLocalOrSFTPFs(fs))
+
+	ctx, span := tracing.StartConnectionSpan(connectionID, user.Username, "127.0.0.1", "")
+	tracing.AddConnectionContext(ctx, connectionID)
+	_, err = fs.Stat(os.TempDir())
+	assert.NoError(t, err)
+	_, err = fs.Lstat(os.TempDir() + "_missing")
+	assert.Error(t, err)
+	tracing.RemoveConnectionContext(connectionID)
+	span.End()
+	err = user.CloseFs()
+	assert.NoError(t, err)
+
+	c.Endpoint = ""
+	err = c.initialize()
+	require.NoError(t, err)
+	user = dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: "user",
+			HomeDir:  os.TempDir(),
+		},
+	}
+	fs, err = user.GetFilesystem(connectionID)
+	require.NoError(t, err)
+	_, ok = fs.(*vfs.OsFs)
+	assert.True(t, ok)
+	assert.Equal(t, fs, vfs.UnwrapFs(fs))
+}
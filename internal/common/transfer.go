@@ -36,29 +36,33 @@ var (
 
 // BaseTransfer contains protocols common transfer details for an upload or a download.
 type BaseTransfer struct {
-	ID              int64
-	BytesSent       atomic.Int64
-	BytesReceived   atomic.Int64
-	Fs              vfs.Fs
-	File            vfs.File
-	Connection      *BaseConnection
-	cancelFn        func()
-	fsPath          string
-	effectiveFsPath string
-	requestPath     string
-	ftpMode         string
-	start           time.Time
-	MaxWriteSize    int64
-	MinWriteOffset  int64
-	InitialSize     int64
-	truncatedSize   int64
-	isNewFile       bool
-	transferType    int
-	AbortTransfer   atomic.Bool
-	aTime           time.Time
-	mTime           time.Time
-	transferQuota   dataprovider.TransferQuota
-	metadata        map[string]string
+	ID               int64
+	BytesSent        atomic.Int64
+	BytesReceived    atomic.Int64
+	expectedSize     atomic.Int64
+	Fs               vfs.Fs
+	File             vfs.File
+	Connection       *BaseConnection
+	cancelFn         func()
+	fsPath           string
+	effectiveFsPath  string
+	requestPath      string
+	ftpMode          string
+	start            time.Time
+	MaxWriteSize     int64
+	MinWriteOffset   int64
+	InitialSize      int64
+	truncatedSize    int64
+	isNewFile        bool
+	transferType     int
+	AbortTransfer    atomic.Bool
+	aTime            time.Time
+	mTime            time.Time
+	transferQuota    dataprovider.TransferQuota
+	metadata         map[string]string
+	checksum         *uploadChecksum
+	contentInspector *uploadContentInspector
+	uploadScanned    bool
 	sync.Mutex
 	errAbort    error
 	ErrTransfer error
@@ -90,11 +94,49 @@ func NewBaseTransfer(file vfs.File, conn *BaseConnection, cancelFn func(), fsPat
 	t.AbortTransfer.Store(false)
 	t.BytesSent.Store(0)
 	t.BytesReceived.Store(0)
+	t.expectedSize.Store(t.getDownloadExpectedSize())
+	if transferType == TransferUpload && Config.UploadChecksum.isEnabledFor(conn, requestPath) {
+		// the checksum is not available for resumed and appended uploads
+		t.checksum = newUploadChecksum(minWriteOffset > 0 || initialSize > truncatedSize)
+	}
+	if transferType == TransferUpload && Config.UploadContentFilter.isEnabledForPath(requestPath) {
+		t.initContentInspector(minWriteOffset > 0 || initialSize > truncatedSize, initialSize)
+	}
 
 	conn.AddTransfer(t)
 	return t
 }
 
+// getDownloadExpectedSize returns the size of the file to download for the local
+// filesystems, a stat is cheap here. For the other filesystems the expected
+// size is unknown unless set by the protocol handler
+func (t *BaseTransfer) getDownloadExpectedSize() int64 {
+	if t.transferType != TransferDownload || !vfs.IsLocalOrCryptoFs(t.Fs) {
+		return -1
+	}
+	var info fs.FileInfo
+	var err error
+	if t.File != nil {
+		info, err = t.File.Stat()
+	} else {
+		info, err = t.Fs.Stat(t.fsPath)
+	}
+	if err != nil {
+		return -1
+	}
+	return info.Size()
+}
+
+// SetExpectedSize sets the expected size for the transfer, -1 means unknown
+func (t *BaseTransfer) SetExpectedSize(size int64) {
+	t.expectedSize.Store(size)
+}
+
+// GetExpectedSize returns the expected size for the transfer, -1 if unknown
+func (t *BaseTransfer) GetExpectedSize() int64 {
+	return t.expectedSize.Load()
+}
+
 // GetTransferQuota returns data transfer quota limits
 func (t *BaseTransfer) GetTransferQuota() dataprovider.TransferQuota {
 	return t.transferQuota
@@ -232,6 +274,9 @@ func (t *BaseTransfer) ConvertError(err error) error {
 
 // CheckRead returns an error if read if not allowed
 func (t *BaseTransfer) CheckRead() error {
+	if t.isWindowQuotaExceeded() {
+		return t.Connection.GetReadQuotaExceededError()
+	}
 	if t.transferQuota.AllowedDLSize == 0 && t.transferQuota.AllowedTotalSize == 0 {
 		return nil
 	}
@@ -247,11 +292,23 @@ func (t *BaseTransfer) CheckRead() error {
 	return nil
 }
 
+// isWindowQuotaExceeded returns true if the transfer quota window budget,
+// plus the grace size, is exhausted and it must be enforced during the transfer
+func (t *BaseTransfer) isWindowQuotaExceeded() bool {
+	if t.transferQuota.MaxWindowTransferSize <= 0 {
+		return false
+	}
+	return t.BytesSent.Load()+t.BytesReceived.Load() > t.transferQuota.MaxWindowTransferSize
+}
+
 // CheckWrite returns an error if write if not allowed
 func (t *BaseTransfer) CheckWrite() error {
 	if t.MaxWriteSize > 0 && t.BytesReceived.Load() > t.MaxWriteSize {
 		return t.Connection.GetQuotaExceededError()
 	}
+	if t.isWindowQuotaExceeded() {
+		return t.Connection.GetQuotaExceededError()
+	}
 	if t.transferQuota.AllowedULSize == 0 && t.transferQuota.AllowedTotalSize == 0 {
 		return nil
 	}
@@ -267,14 +324,36 @@ func (t *BaseTransfer) CheckWrite() error {
 	return nil
 }
 
+// CheckDeclaredSize returns an error and aborts the upload if the size declared
+// by the client exceeds the maximum allowed size for the uploaded file
+func (t *BaseTransfer) CheckDeclaredSize(size int64) error {
+	maxUploadFileSize := t.Connection.User.GetMaxUploadFileSize(t.requestPath)
+	if maxUploadFileSize <= 0 || size <= maxUploadFileSize {
+		return nil
+	}
+	t.Connection.Log(logger.LevelInfo, "declared size %d for upload %q exceeds the max allowed size %d",
+		size, t.requestPath, maxUploadFileSize)
+	err := t.Connection.GetQuotaExceededError()
+	t.TransferError(err)
+	return err
+}
+
 // Truncate changes the size of the opened file.
 // Supported for local fs only
 func (t *BaseTransfer) Truncate(fsPath string, size int64) (int64, error) {
 	if fsPath == t.GetFsPath() {
+		if t.transferType == TransferUpload {
+			if err := t.CheckDeclaredSize(size); err != nil {
+				return 0, err
+			}
+		}
 		if t.File != nil {
 			initialSize := t.InitialSize
 			err := t.File.Truncate(size)
 			if err == nil {
+				if t.checksum != nil {
+					t.checksum.setNonContiguous()
+				}
 				t.Lock()
 				t.InitialSize = size
 				if t.MaxWriteSize > 0 {
@@ -287,6 +366,9 @@ func (t *BaseTransfer) Truncate(fsPath string, size int64) (int64, error) {
 							dataprovider.UpdateUserTransferQuota(&user, ulSize, dlSize, false) //nolint:errcheck
 						}(t.BytesReceived.Load(), t.BytesSent.Load(), t.Connection.User)
 					}
+					if t.transferQuota.WindowSize > 0 {
+						go t.updateTransferQuotaWindow(t.BytesReceived.Load())
+					}
 					t.BytesReceived.Store(0)
 				}
 				t.Unlock()
@@ -307,6 +389,24 @@ func (t *BaseTransfer) Truncate(fsPath string, size int64) (int64, error) {
 	return 0, errTransferMismatch
 }
 
+// CancelUploadOnError cancels the pending upload if the transfer failed or was
+// aborted, for example because the connection was closed. For cloud storage
+// backends this aborts the multipart upload instead of completing it with
+// partial contents. It must be called before closing the upload writer
+func (t *BaseTransfer) CancelUploadOnError() {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.transferType != TransferUpload || t.cancelFn == nil {
+		return
+	}
+	if t.ErrTransfer != nil || t.AbortTransfer.Load() {
+		t.Connection.Log(logger.LevelDebug, "cancel pending upload for file %q, transfer error: %v, aborted: %t",
+			t.fsPath, t.ErrTransfer, t.AbortTransfer.Load())
+		t.cancelFn()
+	}
+}
+
 // TransferError is called if there is an unexpected error.
 // For example network or client issues
 func (t *BaseTransfer) TransferError(err error) {
@@ -398,6 +498,9 @@ func (t *BaseTransfer) Close() error {
 		dataprovider.UpdateUserTransferQuota(&t.Connection.User, t.BytesReceived.Load(), //nolint:errcheck
 			t.BytesSent.Load(), false)
 	}
+	if t.transferQuota.WindowSize > 0 {
+		t.updateTransferQuotaWindow(t.BytesSent.Load() + t.BytesReceived.Load())
+	}
 	if (t.File != nil || vfs.IsLocalOsFs(t.Fs)) && t.Connection.IsQuotaExceededError(t.ErrTransfer) {
 		// if quota is exceeded we try to remove the partial file for uploads to local filesystem
 		err = t.Fs.Remove(t.effectiveFsPath, false)
@@ -408,7 +511,9 @@ func (t *BaseTransfer) Close() error {
 		t.Connection.Log(logger.LevelWarn, "upload denied due to space limit, delete temporary file: %q, deletion error: %v",
 			t.effectiveFsPath, err)
 	} else if t.isAtomicUpload() {
-		if t.ErrTransfer == nil || Config.UploadMode&UploadModeAtomicWithResume != 0 {
+		if vfs.HasCloudAtomicUploads(t.Fs) {
+			err = t.completeCloudAtomicUpload()
+		} else if t.ErrTransfer == nil || Config.UploadMode&UploadModeAtomicWithResume != 0 {
 			_, _, err = t.Fs.Rename(t.effectiveFsPath, t.fsPath, 0)
 			t.Connection.Log(logger.LevelDebug, "atomic upload completed, rename: %q -> %q, error: %v",
 				t.effectiveFsPath, t.fsPath, err)
@@ -430,6 +535,7 @@ func (t *BaseTransfer) Close() error {
 		logger.TransferLog(downloadLogSender, t.fsPath, elapsed, t.BytesSent.Load(), t.Connection.User.Username,
 			t.Connection.ID, t.Connection.protocol, t.Connection.localAddr, t.Connection.remoteAddr, t.ftpMode,
 			t.ErrTransfer)
+		t.Connection.auditLog(operationDownload, t.requestPath, "", t.BytesSent.Load(), elapsed, t.ErrTransfer)
 		ExecuteActionNotification(t.Connection, operationDownload, t.fsPath, t.requestPath, "", "", "", //nolint:errcheck
 			t.BytesSent.Load(), t.ErrTransfer, elapsed, t.metadata)
 	} else {
@@ -446,12 +552,20 @@ func (t *BaseTransfer) Close() error {
 		numFiles -= deletedFiles
 		t.Connection.Log(logger.LevelDebug, "upload file size %d, num files %d, deleted files %d, fs path %q",
 			uploadFileSize, numFiles, deletedFiles, t.fsPath)
+		numFiles, uploadFileSize = t.removeDeniedUpload(numFiles, uploadFileSize)
+		numFiles, uploadFileSize = t.executeUploadScanHook(numFiles, uploadFileSize)
+		numFiles, uploadFileSize = t.completePGPUpload(numFiles, uploadFileSize)
+		checksum := t.setUploadChecksum(t.getUploadEventSize(uploadFileSize))
 		numFiles, uploadFileSize = t.executeUploadHook(numFiles, uploadFileSize, elapsed)
 		t.updateQuota(numFiles, uploadFileSize)
 		t.updateTimes()
 		logger.TransferLog(uploadLogSender, t.fsPath, elapsed, t.BytesReceived.Load(), t.Connection.User.Username,
 			t.Connection.ID, t.Connection.protocol, t.Connection.localAddr, t.Connection.remoteAddr, t.ftpMode,
 			t.ErrTransfer)
+		t.Connection.auditUploadLog(t.requestPath, t.getUploadEventSize(uploadFileSize), elapsed, checksum, t.ErrTransfer)
+		if t.ErrTransfer == nil && err == nil {
+			startArchiveExtraction(t.Connection, t.requestPath)
+		}
 	}
 	if t.ErrTransfer != nil {
 		t.Connection.Log(logger.LevelError, "transfer error: %v, path: %q", t.ErrTransfer, t.fsPath)
@@ -467,6 +581,35 @@ func (t *BaseTransfer) isAtomicUpload() bool {
 	return t.transferType == TransferUpload && t.effectiveFsPath != t.fsPath
 }
 
+// completeCloudAtomicUpload scans the temporary object of an atomic upload to
+// a cloud storage backend and moves it to the requested path. The temporary
+// object is deleted if the upload fails or is rejected, so the requested path
+// never contains partial or rejected files
+func (t *BaseTransfer) completeCloudAtomicUpload() error {
+	t.executeAtomicUploadScanHook()
+	if t.ErrTransfer == nil {
+		_, _, err := t.Fs.Rename(t.effectiveFsPath, t.fsPath, t.Connection.GetCreateChecks(t.requestPath, true, false))
+		t.Connection.Log(logger.LevelDebug, "atomic upload completed, rename: %q -> %q, error: %v",
+			t.effectiveFsPath, t.fsPath, err)
+		if err == nil {
+			return nil
+		}
+		t.ErrTransfer = err
+	}
+	err := t.Fs.Remove(t.effectiveFsPath, false)
+	if t.Fs.IsNotExist(err) {
+		// the failed uploads may not create the temporary object
+		err = nil
+	}
+	t.Connection.Log(logger.LevelWarn, "atomic upload completed with error: \"%v\", delete temporary object: %q, deletion error: %v",
+		t.ErrTransfer, t.effectiveFsPath, err)
+	if err == nil {
+		t.BytesReceived.Store(0)
+		t.MinWriteOffset = 0
+	}
+	return err
+}
+
 func (t *BaseTransfer) updateTransferTimestamps(uploadFileSize, elapsed int64) {
 	if t.ErrTransfer != nil {
 		return
@@ -476,7 +619,7 @@ func (t *BaseTransfer) updateTransferTimestamps(uploadFileSize, elapsed int64) {
 			if err := dataprovider.UpdateUserTransferTimestamps(t.Connection.User.Username, true); err == nil {
 				t.Connection.uploadDone.Store(true)
 				ExecuteActionNotification(t.Connection, operationFirstUpload, t.fsPath, t.requestPath, "", //nolint:errcheck
-					"", "", uploadFileSize, t.ErrTransfer, elapsed, t.metadata)
+					"", "", t.getUploadEventSize(uploadFileSize), t.ErrTransfer, elapsed, t.metadata)
 			}
 		}
 		return
@@ -492,25 +635,50 @@ func (t *BaseTransfer) updateTransferTimestamps(uploadFileSize, elapsed int64) {
 
 func (t *BaseTransfer) executeUploadHook(numFiles int, fileSize, elapsed int64) (int, int64) {
 	err := ExecuteActionNotification(t.Connection, operationUpload, t.fsPath, t.requestPath, "", "", "",
-		fileSize, t.ErrTransfer, elapsed, t.metadata)
+		t.getUploadEventSize(fileSize), t.ErrTransfer, elapsed, t.metadata)
 	if err != nil {
 		if t.ErrTransfer == nil {
 			t.ErrTransfer = err
 		}
-		// try to remove the uploaded file
-		err = t.Fs.Remove(t.fsPath, false)
-		if err == nil {
-			numFiles--
-			fileSize = 0
-			t.BytesReceived.Store(0)
-			t.MinWriteOffset = 0
-		} else {
-			t.Connection.Log(logger.LevelWarn, "unable to remove path %q after upload hook failure: %v", t.fsPath, err)
-		}
+		return t.removeRejectedUpload(numFiles, fileSize)
 	}
 	return numFiles, fileSize
 }
 
+// removeRejectedUpload tries to remove the uploaded file after a hook failure
+func (t *BaseTransfer) removeRejectedUpload(numFiles int, fileSize int64) (int, int64) {
+	err := t.Fs.Remove(t.fsPath, false)
+	if err == nil {
+		numFiles--
+		fileSize = 0
+		t.BytesReceived.Store(0)
+		t.MinWriteOffset = 0
+	} else {
+		t.Connection.Log(logger.LevelWarn, "unable to remove path %q after upload hook failure: %v", t.fsPath, err)
+	}
+	return numFiles, fileSize
+}
+
+// updateTransferQuotaWindow adds the specified size to the transfer quota window
+// and notifies the usage thresholds reached
+func (t *BaseTransfer) updateTransferQuotaWindow(size int64) {
+	user := t.Connection.User
+	thresholds, used, err := dataprovider.UpdateTransferQuotaWindow(&user, size)
+	if err != nil {
+		t.Connection.Log(logger.LevelError, "unable to update the transfer quota window: %v", err)
+	}
+	for _, threshold := range thresholds {
+		operation := operationTransferQuotaWarning
+		if threshold >= 100 {
+			operation = operationTransferQuotaExhausted
+		}
+		t.Connection.Log(logger.LevelInfo, "transfer quota window usage threshold %d%% reached, used size: %d",
+			threshold, used)
+		ExecuteActionNotification(t.Connection, operation, t.fsPath, t.requestPath, "", "", "", //nolint:errcheck
+			used, nil, 0, nil)
+	}
+}
+
 func (t *BaseTransfer) getUploadedFiles() int {
 	numFiles := 0
 	if t.isNewFile {
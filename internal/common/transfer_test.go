@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
 	"testing"
 	"time"
 
@@ -342,6 +343,68 @@ func TestFTPMode(t *testing.T) {
 	assert.Equal(t, "active", transfer.ftpMode)
 }
 
+func TestTransferProgress(t *testing.T) {
+	testFile := filepath.Join(os.TempDir(), "transfer_progress_file")
+	err := os.WriteFile(testFile, make([]byte, 1000), os.ModePerm)
+	assert.NoError(t, err)
+	fs := vfs.NewOsFs("", os.TempDir(), "", nil)
+	conn := NewBaseConnection("", ProtocolSFTP, "", "", dataprovider.User{})
+	download := NewBaseTransfer(nil, conn, nil, testFile, testFile, "/transfer_progress_file", TransferDownload,
+		0, 0, 0, 0, false, fs, dataprovider.TransferQuota{})
+	assert.Equal(t, int64(1000), download.GetExpectedSize())
+	upload := NewBaseTransfer(nil, conn, nil, testFile, testFile, "/transfer_progress_file", TransferUpload,
+		0, 0, 0, 0, false, fs, dataprovider.TransferQuota{})
+	assert.Equal(t, int64(-1), upload.GetExpectedSize())
+
+	transfers := conn.GetTransfers()
+	if assert.Len(t, transfers, 2) {
+		for _, tr := range transfers {
+			if tr.ID == download.GetID() {
+				assert.Equal(t, float64(0), tr.Percentage)
+				assert.Equal(t, int64(-1), tr.ETA)
+			} else {
+				assert.Equal(t, float64(-1), tr.Percentage)
+				assert.Equal(t, int64(-1), tr.ETA)
+			}
+		}
+	}
+	// fill the samples ring, the throughput is computed from the oldest sample
+	now := time.Now()
+	for i := 0; i < transferProgressSamples; i++ {
+		download.BytesSent.Store(int64(i * 100))
+		conn.sampleTransfers(now.Add(time.Duration(i-transferProgressSamples) * time.Second))
+	}
+	download.BytesSent.Add(100)
+	transfers = conn.GetTransfers()
+	if assert.Len(t, transfers, 2) {
+		for _, tr := range transfers {
+			if tr.ID == download.GetID() {
+				assert.Equal(t, int64(500), tr.Size)
+				assert.Equal(t, float64(50), tr.Percentage)
+				assert.InDelta(t, 100, tr.Throughput, 1)
+				assert.InDelta(t, 5, tr.ETA, 1)
+			} else {
+				assert.Equal(t, int64(0), tr.Throughput)
+				assert.Equal(t, float64(-1), tr.Percentage)
+			}
+		}
+	}
+	upload.SetExpectedSize(0)
+	download.BytesSent.Store(1000)
+	for _, tr := range conn.GetTransfers() {
+		assert.Equal(t, float64(100), tr.Percentage)
+		assert.Equal(t, int64(0), tr.ETA)
+	}
+
+	err = download.Close()
+	assert.NoError(t, err)
+	err = upload.Close()
+	assert.NoError(t, err)
+	assert.Len(t, conn.transfersProgress, 0)
+	err = os.Remove(testFile)
+	assert.NoError(t, err)
+}
+
 func TestTransferQuota(t *testing.T) {
 	user := dataprovider.User{
 		BaseUser: sdk.BaseUser{
@@ -475,3 +538,109 @@ func TestUploadOutsideHomeRenameError(t *testing.T) {
 
 	Config.TempPath = oldTempPath
 }
+
+func TestCancelUploadOnError(t *testing.T) {
+	cancelled := false
+	cancelFn := func() {
+		cancelled = true
+	}
+	conn := NewBaseConnection("", ProtocolSFTP, "", "", dataprovider.User{})
+	fs := vfs.NewOsFs("", os.TempDir(), "", nil)
+	transfer := NewBaseTransfer(nil, conn, cancelFn, "", "", "", TransferUpload, 0, 0, 0, 0, true, fs,
+		dataprovider.TransferQuota{})
+	transfer.CancelUploadOnError()
+	assert.False(t, cancelled)
+	transfer.SignalClose(ErrTransferAborted)
+	transfer.CancelUploadOnError()
+	assert.True(t, cancelled)
+	conn.RemoveTransfer(transfer)
+
+	cancelled = false
+	transfer = NewBaseTransfer(nil, conn, cancelFn, "", "", "", TransferUpload, 0, 0, 0, 0, true, fs,
+		dataprovider.TransferQuota{})
+	transfer.ErrTransfer = os.ErrPermission
+	transfer.CancelUploadOnError()
+	assert.True(t, cancelled)
+	conn.RemoveTransfer(transfer)
+
+	cancelled = false
+	transfer = NewBaseTransfer(nil, conn, cancelFn, "", "", "", TransferDownload, 0, 0, 0, 0, true, fs,
+		dataprovider.TransferQuota{})
+	transfer.ErrTransfer = os.ErrPermission
+	transfer.CancelUploadOnError()
+	assert.False(t, cancelled)
+	conn.RemoveTransfer(transfer)
+	assert.Len(t, conn.GetTransfers(), 0)
+}
+
+func TestCloudAtomicUpload(t *testing.T) {
+	if runtime.GOOS == osWindows {
+		t.Skip("this test is not available on Windows")
+	}
+	configCopy := Config
+	Config.UploadMode = UploadModeCloudAtomic
+	vfs.SetUploadMode(Config.UploadMode)
+	defer func() {
+		Config = configCopy
+		vfs.SetUploadMode(Config.UploadMode)
+	}()
+
+	u := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: "cloud_atomic_user",
+			HomeDir:  os.TempDir(),
+		},
+	}
+	u.Permissions = make(map[string][]string)
+	u.Permissions["/"] = []string{dataprovider.PermAny}
+	// a local filesystem with the S3 name is enough to test the transfer handling
+	fs := newMockOsFs(true, "", os.TempDir(), "S3Fs", nil)
+	assert.True(t, Config.IsAtomicUploadEnabledFor(fs))
+	assert.False(t, Config.IsAtomicUploadEnabledFor(vfs.NewOsFs("", os.TempDir(), "", nil)))
+	assert.False(t, vfs.IsUploadResumeSupported(fs, 0))
+
+	conn := NewBaseConnection("", ProtocolSFTP, "", "", u)
+	finalPath := filepath.Join(os.TempDir(), "cloud_atomic_file")
+	tempPath := filepath.Join(os.TempDir(), "cloud_atomic_file.tmp")
+	upload := func(content string, errTransfer error) error {
+		err := os.WriteFile(tempPath, []byte(content), 0644)
+		require.NoError(t, err)
+		transfer := NewBaseTransfer(nil, conn, nil, finalPath, tempPath, "/cloud_atomic_file", TransferUpload,
+			0, 0, 0, 0, true, fs, dataprovider.TransferQuota{})
+		transfer.BytesReceived.Store(int64(len(content)))
+		transfer.ErrTransfer = errTransfer
+		return transfer.Close()
+	}
+
+	err := upload("data", nil)
+	assert.NoError(t, err)
+	assert.NoFileExists(t, tempPath)
+	assertFileContent(t, finalPath, "data")
+	// failed uploads don't modify the requested path
+	err = upload("new data", errors.New("upload error"))
+	assert.Error(t, err)
+	assert.NoFileExists(t, tempPath)
+	assertFileContent(t, finalPath, "data")
+	// the temporary object is scanned before moving it
+	Config.UploadScan.Hook = "/bin/false"
+	err = upload("new data", nil)
+	assert.ErrorIs(t, err, os.ErrPermission)
+	assert.NoFileExists(t, tempPath)
+	assertFileContent(t, finalPath, "data")
+	Config.UploadScan.Hook = "/bin/true"
+	err = upload("new data", nil)
+	assert.NoError(t, err)
+	assert.NoFileExists(t, tempPath)
+	assertFileContent(t, finalPath, "new data")
+
+	err = os.Remove(finalPath)
+	assert.NoError(t, err)
+	assert.Len(t, conn.GetTransfers(), 0)
+}
+
+func assertFileContent(t *testing.T, name, expected string) {
+	content, err := os.ReadFile(name)
+	if assert.NoError(t, err) {
+		assert.Equal(t, expected, string(content))
+	}
+}
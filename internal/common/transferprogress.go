@@ -0,0 +1,86 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"time"
+)
+
+// number of samples used to compute the throughput for an active transfer.
+// Transfers are sampled by the periodic transfers check, so the throughput
+// is computed over a sliding window of about transferProgressSamples minutes
+const transferProgressSamples = 5
+
+type transferProgressSample struct {
+	// unix timestamp in nanoseconds
+	time int64
+	size int64
+}
+
+// transferProgress is a ring of the transferred sizes sampled periodically,
+// it allows to compute the current throughput for a transfer without
+// tracking each read and write
+type transferProgress struct {
+	samples [transferProgressSamples]transferProgressSample
+	next    int
+	count   int
+}
+
+func (p *transferProgress) add(now time.Time, size int64) {
+	p.samples[p.next] = transferProgressSample{
+		time: now.UnixNano(),
+		size: size,
+	}
+	p.next = (p.next + 1) % transferProgressSamples
+	if p.count < transferProgressSamples {
+		p.count++
+	}
+}
+
+// getThroughput returns the throughput, as bytes per second, between the
+// oldest sample and now. Until the ring is full the transfer start is used
+// as oldest sample
+func (p *transferProgress) getThroughput(now, start time.Time, size int64) int64 {
+	oldest := transferProgressSample{
+		time: start.UnixNano(),
+	}
+	if p != nil && p.count == transferProgressSamples {
+		oldest = p.samples[p.next]
+	}
+	elapsed := now.UnixNano() - oldest.time
+	if elapsed <= 0 || size <= oldest.size {
+		return 0
+	}
+	return int64(float64(size-oldest.size) / (float64(elapsed) / float64(time.Second)))
+}
+
+// setTransferProgress sets the percentage and the estimated time to completion
+// for the specified transfer. Both are -1 if the expected size is unknown
+func setTransferProgress(t *ConnectionTransfer) {
+	t.Percentage = -1
+	t.ETA = -1
+	if t.ExpectedSize < 0 {
+		return
+	}
+	if t.ExpectedSize == 0 || t.Size >= t.ExpectedSize {
+		t.Percentage = 100
+		t.ETA = 0
+		return
+	}
+	t.Percentage = float64(t.Size*10000/t.ExpectedSize) / 100
+	if t.Throughput > 0 {
+		t.ETA = (t.ExpectedSize - t.Size) / t.Throughput
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"sync"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+const (
+	// uploadChecksumKey is the key used for the checksum in the upload notification metadata
+	uploadChecksumKey = "sha256"
+	// uploadChecksumMetadataKey is the key used for the checksum in the object metadata
+	uploadChecksumMetadataKey = vfs.ChecksumSHA256MetadataKey
+	// uploadChecksumUnavailable is published if the checksum cannot be computed,
+	// for example for resumed or appended uploads
+	uploadChecksumUnavailable = "unavailable"
+)
+
+var errUploadChecksumUnavailable = errors.New("upload checksum unavailable")
+
+// UploadChecksumConfig defines the configuration for the SHA-256 checksums of
+// the completed uploads. Checksums are computed for the uploads matching the
+// configured patterns and for all the uploads of the users with the
+// "upload_checksum" filter enabled
+type UploadChecksumConfig struct {
+	// Shell like patterns, matched against the file name, for the uploads to hash
+	// regardless of the user setting. Empty means no pattern
+	Patterns []string `json:"patterns" mapstructure:"patterns"`
+	// If enabled, the checksum is also saved as object metadata, using the
+	// "sftpgo_sha256" key, for the S3, Google Cloud Storage and Azure Blob backends
+	ObjectMetadata bool `json:"object_metadata" mapstructure:"object_metadata"`
+}
+
+func (c *UploadChecksumConfig) validate() error {
+	if err := validateFilePatterns(c.Patterns); err != nil {
+		return fmt.Errorf("invalid upload checksum patterns: %w", err)
+	}
+	return nil
+}
+
+func (c *UploadChecksumConfig) isEnabledFor(conn *BaseConnection, virtualPath string) bool {
+	if conn.User.Filters.UploadChecksum {
+		return true
+	}
+	return len(c.Patterns) > 0 && matchFilePatterns(c.Patterns, virtualPath)
+}
+
+// uploadChecksum computes the SHA-256 checksum while the data is written.
+// If the writes are not sequential the checksum is computed reading the
+// uploaded file after the transfer completes
+type uploadChecksum struct {
+	sync.Mutex
+	hash        hash.Hash
+	offset      int64
+	contiguous  bool
+	unavailable bool
+}
+
+func newUploadChecksum(unavailable bool) *uploadChecksum {
+	return &uploadChecksum{
+		hash:        sha256.New(),
+		contiguous:  true,
+		unavailable: unavailable,
+	}
+}
+
+// write adds the given data, written at the specified offset, to the checksum.
+// A negative offset means a sequential write
+func (c *uploadChecksum) write(p []byte, offset int64) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.unavailable || !c.contiguous || len(p) == 0 {
+		return
+	}
+	if offset >= 0 && offset != c.offset {
+		c.contiguous = false
+		return
+	}
+	c.hash.Write(p) //nolint:errcheck // hash.Write never returns an error
+	c.offset += int64(len(p))
+}
+
+func (c *uploadChecksum) setNonContiguous() {
+	c.Lock()
+	defer c.Unlock()
+
+	c.contiguous = false
+}
+
+// sum returns the hex encoded checksum if all the data were written
+// sequentially and the size matches the given one
+func (c *uploadChecksum) sum(size int64) (string, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.unavailable || !c.contiguous || c.offset != size {
+		return "", false
+	}
+	return hex.EncodeToString(c.hash.Sum(nil)), true
+}
+
+func (c *uploadChecksum) isUnavailable() bool {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.unavailable
+}
+
+// UpdateChecksum updates the upload checksum, if enabled, with the data written
+// at the given offset. A negative offset means a sequential write
+func (t *BaseTransfer) UpdateChecksum(p []byte, offset int64) {
+	if t.checksum != nil {
+		t.checksum.write(p, offset)
+	}
+}
+
+// computeUploadChecksum returns the hex encoded SHA-256 checksum for the
+// completed upload. The uploaded file is read again if the data were not
+// written sequentially
+func (t *BaseTransfer) computeUploadChecksum(fileSize int64) (string, error) {
+	if t.checksum.isUnavailable() {
+		return "", errUploadChecksumUnavailable
+	}
+	if digest, ok := t.checksum.sum(fileSize); ok {
+		return digest, nil
+	}
+	if vfs.IsPGPFs(t.Fs) {
+		// the stored file is encrypted, the plaintext cannot be read again
+		return "", errUploadChecksumUnavailable
+	}
+	t.Connection.Log(logger.LevelDebug, "non sequential writes for file %q, read it again to compute the checksum",
+		t.requestPath)
+	f, r, cancelFn, err := t.Fs.Open(t.fsPath, 0)
+	if err != nil {
+		return "", fmt.Errorf("unable to open the uploaded file: %w", err)
+	}
+	if cancelFn != nil {
+		defer cancelFn()
+	}
+	var reader io.Reader
+	if f != nil {
+		defer f.Close()
+		reader = f
+	} else {
+		defer r.Close()
+		reader = r
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, reader); err != nil {
+		return "", fmt.Errorf("unable to read the uploaded file: %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// setUploadChecksum computes the checksum for the completed upload, adds it
+// to the notification metadata and, if enabled, to the object metadata.
+// The returned value is empty if checksums are disabled
+func (t *BaseTransfer) setUploadChecksum(fileSize int64) string {
+	if t.checksum == nil || t.ErrTransfer != nil {
+		return ""
+	}
+	digest, err := t.computeUploadChecksum(fileSize)
+	if err != nil {
+		t.Connection.Log(logger.LevelDebug, "checksum not available for file %q: %v", t.requestPath, err)
+		digest = uploadChecksumUnavailable
+	}
+	metadata := make(map[string]string, len(t.metadata)+1)
+	for k, v := range t.metadata {
+		metadata[k] = v
+	}
+	metadata[uploadChecksumKey] = digest
+	t.metadata = metadata
+
+	// the checksum refers to the plaintext, so it is not saved for the files encrypted on upload
+	if err == nil && Config.UploadChecksum.ObjectMetadata && !vfs.IsPGPFs(t.Fs) {
+		if setter, ok := t.Fs.(vfs.MetadataSetter); ok {
+			err = setter.SetMetadata(t.fsPath, map[string]string{uploadChecksumMetadataKey: digest})
+			t.Connection.Log(logger.LevelDebug, "set checksum metadata for file %q, err: %v", t.fsPath, err)
+		}
+	}
+	return digest
+}
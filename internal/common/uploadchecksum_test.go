@@ -0,0 +1,81 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/sftpgo/sdk"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+)
+
+func TestUploadChecksumConfig(t *testing.T) {
+	conn := NewBaseConnection("", ProtocolSFTP, "", "", dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: "checksum_user",
+		},
+	})
+	c := UploadChecksumConfig{}
+	assert.NoError(t, c.validate())
+	assert.False(t, c.isEnabledFor(conn, "/file.txt"))
+	c.Patterns = []string{"[a-"}
+	assert.Error(t, c.validate())
+	c.Patterns = []string{" *.TXT"}
+	assert.NoError(t, c.validate())
+	assert.True(t, c.isEnabledFor(conn, "/dir/file.txt"))
+	assert.False(t, c.isEnabledFor(conn, "/dir/file.zip"))
+	conn.User.Filters.UploadChecksum = true
+	assert.True(t, c.isEnabledFor(conn, "/dir/file.zip"))
+}
+
+func TestUploadChecksumWrites(t *testing.T) {
+	data := []byte("sftpgo upload checksum")
+	sum := sha256.Sum256(data)
+	expected := hex.EncodeToString(sum[:])
+
+	c := newUploadChecksum(false)
+	c.write(data[:5], 0)
+	c.write(nil, 3)
+	c.write(data[5:10], -1)
+	c.write(data[10:], 10)
+	digest, ok := c.sum(int64(len(data)))
+	assert.True(t, ok)
+	assert.Equal(t, expected, digest)
+	// size mismatch
+	_, ok = c.sum(int64(len(data)) + 1)
+	assert.False(t, ok)
+
+	c = newUploadChecksum(false)
+	c.write(data[5:], 5)
+	c.write(data[:5], 0)
+	_, ok = c.sum(int64(len(data)))
+	assert.False(t, ok)
+
+	c = newUploadChecksum(false)
+	c.write(data, 0)
+	c.setNonContiguous()
+	_, ok = c.sum(int64(len(data)))
+	assert.False(t, ok)
+
+	c = newUploadChecksum(true)
+	c.write(data, 0)
+	assert.True(t, c.isUnavailable())
+	_, ok = c.sum(int64(len(data)))
+	assert.False(t, ok)
+}
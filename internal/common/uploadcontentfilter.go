@@ -0,0 +1,491 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+)
+
+const (
+	// ContentTypePE identifies Windows executables
+	ContentTypePE = "pe"
+	// ContentTypeELF identifies Linux and Unix executables
+	ContentTypeELF = "elf"
+	// ContentTypeMachO identifies macOS executables
+	ContentTypeMachO = "macho"
+	// ContentTypeZipExecutable identifies zip archives whose first entries are executables
+	ContentTypeZipExecutable = "zip_executable"
+	// uploadDeniedContentTypeKey is the key used for the detected content type
+	// in the upload notification metadata
+	uploadDeniedContentTypeKey = "denied_content_type"
+	defaultUploadInspectSize   = 512
+	maxUploadInspectSize       = 1048576
+)
+
+var (
+	errUploadContentDenied  = errors.New("upload denied by the content filter")
+	executableContentTypes  = []string{ContentTypePE, ContentTypeELF, ContentTypeMachO}
+	zipExecutableExtensions = []string{".exe", ".dll", ".scr", ".com", ".msi", ".bat", ".cmd", ".ps1", ".vbs", ".jar"}
+	machOMagics             = [][]byte{{0xfe, 0xed, 0xfa, 0xce}, {0xce, 0xfa, 0xed, 0xfe}, {0xfe, 0xed, 0xfa, 0xcf}, {0xcf, 0xfa, 0xed, 0xfe}}
+	machOFatMagic           = []byte{0xca, 0xfe, 0xba, 0xbe}
+	zipLocalFileHeaderMagic = []byte("PK\x03\x04")
+	elfMagic                = []byte("\x7fELF")
+	peMagic                 = []byte("MZ")
+	peSignature             = []byte("PE\x00\x00")
+	knownUploadContentTypes = append(slices.Clone(executableContentTypes), ContentTypeZipExecutable)
+)
+
+// UploadContentFilterConfig defines the content inspection for the uploaded
+// files. The initial bytes of the matching uploads are held and inspected
+// before writing them to the storage backend, uploads with a denied content
+// type are aborted and the client gets a permission denied error
+type UploadContentFilterConfig struct {
+	// Shell like patterns for the uploads to inspect. Patterns containing a slash
+	// are matched against the full virtual path, the others against the file name.
+	// Empty means all the uploads
+	Patterns []string `json:"patterns" mapstructure:"patterns"`
+	// Number of initial bytes held and inspected. 0 means 512
+	InspectSize int `json:"inspect_size" mapstructure:"inspect_size"`
+	// Denied content types: "pe", "elf" and "macho" for executables, "zip_executable"
+	// for zip archives containing executables, any other value is a MIME type,
+	// for example "application/pdf" or "image/*", matched against the type
+	// detected from the file contents. Empty means disabled
+	DeniedTypes []string `json:"denied_types" mapstructure:"denied_types"`
+}
+
+func (c *UploadContentFilterConfig) validate() error {
+	if len(c.DeniedTypes) == 0 {
+		return nil
+	}
+	for idx, pattern := range c.Patterns {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if _, err := path.Match(pattern, "abc"); err != nil {
+			return fmt.Errorf("invalid upload content filter pattern %q: %w", pattern, err)
+		}
+		c.Patterns[idx] = pattern
+	}
+	if c.InspectSize < 0 || c.InspectSize > maxUploadInspectSize {
+		return fmt.Errorf("invalid upload content filter inspect size %d, valid range: 0-%d", c.InspectSize,
+			maxUploadInspectSize)
+	}
+	for idx, contentType := range c.DeniedTypes {
+		contentType = strings.ToLower(strings.TrimSpace(contentType))
+		if !slices.Contains(knownUploadContentTypes, contentType) {
+			mediaType, _, err := mime.ParseMediaType(contentType)
+			if err != nil || !strings.Contains(mediaType, "/") {
+				return fmt.Errorf("invalid upload content filter denied type %q", contentType)
+			}
+			contentType = mediaType
+		}
+		c.DeniedTypes[idx] = contentType
+	}
+	return nil
+}
+
+func (c *UploadContentFilterConfig) isEnabledForPath(virtualPath string) bool {
+	if len(c.DeniedTypes) == 0 {
+		return false
+	}
+	if len(c.Patterns) == 0 {
+		return true
+	}
+	virtualPath = strings.ToLower(virtualPath)
+	name := path.Base(virtualPath)
+	for _, pattern := range c.Patterns {
+		toMatch := name
+		if strings.Contains(pattern, "/") {
+			toMatch = virtualPath
+		}
+		if matched, _ := path.Match(pattern, toMatch); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *UploadContentFilterConfig) getInspectSize() int {
+	if c.InspectSize == 0 {
+		return defaultUploadInspectSize
+	}
+	return c.InspectSize
+}
+
+// getDeniedType returns the denied content type detected in the given data
+// or an empty string if the data are allowed
+func (c *UploadContentFilterConfig) getDeniedType(data []byte) string {
+	var detectedMIMEType string
+	for _, contentType := range c.DeniedTypes {
+		switch contentType {
+		case ContentTypePE, ContentTypeELF, ContentTypeMachO:
+			if getExecutableType(data) == contentType {
+				return contentType
+			}
+		case ContentTypeZipExecutable:
+			if isZipWithExecutables(data) {
+				return contentType
+			}
+		default:
+			if detectedMIMEType == "" {
+				detectedMIMEType, _, _ = mime.ParseMediaType(http.DetectContentType(data))
+			}
+			if detectedMIMEType == contentType {
+				return detectedMIMEType
+			}
+			if prefix, ok := strings.CutSuffix(contentType, "/*"); ok && strings.HasPrefix(detectedMIMEType, prefix+"/") {
+				return detectedMIMEType
+			}
+		}
+	}
+	return ""
+}
+
+// getExecutableType returns the executable format detected from the magic
+// bytes or an empty string if the data are not an executable
+func getExecutableType(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, elfMagic):
+		return ContentTypeELF
+	case bytes.HasPrefix(data, peMagic):
+		// the PE header offset is at 0x3c, if it is not within the inspected
+		// data we consider the file as a DOS executable
+		if len(data) >= 0x40 {
+			offset := int(binary.LittleEndian.Uint32(data[0x3c:0x40]))
+			if offset+len(peSignature) <= len(data) && !bytes.Equal(data[offset:offset+len(peSignature)], peSignature) {
+				return ""
+			}
+		}
+		return ContentTypePE
+	case bytes.HasPrefix(data, machOFatMagic):
+		// Java class files have the same magic, they are followed by the
+		// version instead of the number of architectures
+		if len(data) >= 8 && binary.BigEndian.Uint32(data[4:8]) < 45 {
+			return ContentTypeMachO
+		}
+		return ""
+	}
+	for _, magic := range machOMagics {
+		if bytes.HasPrefix(data, magic) {
+			return ContentTypeMachO
+		}
+	}
+	return ""
+}
+
+// isZipWithExecutables returns true if the data are the initial bytes of a
+// zip archive and one of the entries within them is an executable. Only the
+// entries whose local header is included in the inspected data are checked
+func isZipWithExecutables(data []byte) bool {
+	for bytes.HasPrefix(data, zipLocalFileHeaderMagic) && len(data) >= 30 {
+		flags := binary.LittleEndian.Uint16(data[6:8])
+		method := binary.LittleEndian.Uint16(data[8:10])
+		compressedSize := int(binary.LittleEndian.Uint32(data[18:22]))
+		nameLen := int(binary.LittleEndian.Uint16(data[26:28]))
+		extraLen := int(binary.LittleEndian.Uint16(data[28:30]))
+		if 30+nameLen > len(data) {
+			return false
+		}
+		name := strings.ToLower(string(data[30 : 30+nameLen]))
+		if slices.Contains(zipExecutableExtensions, path.Ext(name)) {
+			return true
+		}
+		dataStart := 30 + nameLen + extraLen
+		if method == 0 && dataStart < len(data) && getExecutableType(data[dataStart:]) != "" {
+			return true
+		}
+		// bit 3 means that the sizes follow the compressed data
+		if flags&0x08 != 0 || dataStart+compressedSize > len(data) {
+			return false
+		}
+		data = data[dataStart+compressedSize:]
+	}
+	return false
+}
+
+// uploadContentInspector holds the initial bytes of an upload until they are
+// enough to detect the content type. It keeps a copy of the initial bytes, so
+// later writes changing them, for example non sequential SFTP writes, are
+// inspected again
+type uploadContentInspector struct {
+	sync.Mutex
+	transfer *BaseTransfer
+	size     int
+	// initial bytes of the file, contiguous from offset 0
+	prefix []byte
+	// number of prefix bytes already written to the storage backend
+	written int
+	// offset for the next sequential write
+	offset  int64
+	flush   func(data []byte, offset int64) error
+	checked bool
+	err     error
+}
+
+func newUploadContentInspector(t *BaseTransfer, size int) *uploadContentInspector {
+	return &uploadContentInspector{
+		transfer: t,
+		size:     size,
+		prefix:   make([]byte, 0, size),
+	}
+}
+
+// setExisting sets the initial bytes for resumed and appended uploads
+func (i *uploadContentInspector) setExisting(data []byte, fileSize int64) {
+	i.prefix = append(i.prefix, data...)
+	i.written = len(data)
+	i.offset = fileSize
+	if len(i.prefix) == i.size {
+		i.check(len(i.prefix)) //nolint:errcheck // the error is returned for the next write
+	}
+}
+
+// inspect processes the data written at the given offset, a negative offset
+// means a sequential write. It returns true if the data are held and must not
+// be written by the caller. The held data are written, before returning, as
+// soon as the content type is allowed
+func (i *uploadContentInspector) inspect(p []byte, offset int64) (bool, error) {
+	i.Lock()
+	defer i.Unlock()
+
+	if offset < 0 {
+		offset = i.offset
+		i.offset += int64(len(p))
+	}
+	if i.err != nil {
+		return false, i.err
+	}
+	known := len(i.prefix)
+	if offset >= int64(i.size) || offset > int64(known) {
+		// the write does not change the known initial bytes. Without known
+		// bytes there is nothing to inspect, the content type detection would
+		// report text/plain, so the decision is deferred to the first
+		// contiguous bytes or to the upload completion
+		if !i.checked && known > 0 && offset < int64(i.size) {
+			return false, i.check(known)
+		}
+		return false, nil
+	}
+	end := min(int(offset)+len(p), i.size)
+	if end > known {
+		i.prefix = i.prefix[:end]
+	}
+	copy(i.prefix[offset:end], p)
+	if !i.checked && offset == int64(known) && len(i.prefix) < i.size {
+		return true, nil
+	}
+	// the held bytes are written before the data of this write
+	return false, i.check(known)
+}
+
+// complete inspects the held data, if the upload is smaller than the
+// inspection size, and writes them if allowed
+func (i *uploadContentInspector) complete() error {
+	i.Lock()
+	defer i.Unlock()
+
+	if i.checked || i.err != nil {
+		return i.err
+	}
+	return i.check(len(i.prefix))
+}
+
+// check inspects the known initial bytes and, if allowed, writes the held
+// bytes up to flushEnd. The following bytes are written by the caller
+func (i *uploadContentInspector) check(flushEnd int) error {
+	i.checked = true
+	if deniedType := Config.UploadContentFilter.getDeniedType(i.prefix); deniedType != "" {
+		i.transfer.Connection.Log(logger.LevelWarn, "upload of %q denied, detected content type %q",
+			i.transfer.requestPath, deniedType)
+		i.transfer.setDeniedContentType(deniedType)
+		i.err = fmt.Errorf("%w: %w, detected content type %q", os.ErrPermission, errUploadContentDenied, deniedType)
+		return i.err
+	}
+	if i.written < flushEnd && i.flush != nil {
+		if err := i.flush(i.prefix[i.written:flushEnd], int64(i.written)); err != nil {
+			i.err = err
+			return err
+		}
+	}
+	i.written = max(i.written, len(i.prefix))
+	return nil
+}
+
+func (i *uploadContentInspector) isDenied() bool {
+	i.Lock()
+	defer i.Unlock()
+
+	return errors.Is(i.err, errUploadContentDenied)
+}
+
+type inspectedUploadWriter struct {
+	io.WriteCloser
+	inspector *uploadContentInspector
+}
+
+func (w *inspectedUploadWriter) Write(p []byte) (int, error) {
+	held, err := w.inspector.inspect(p, -1)
+	if err != nil {
+		return 0, err
+	}
+	if held {
+		return len(p), nil
+	}
+	return w.WriteCloser.Write(p)
+}
+
+type inspectedUploadWriterAt struct {
+	UploadWriterAt
+	inspector *uploadContentInspector
+}
+
+func (w *inspectedUploadWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	held, err := w.inspector.inspect(p, off)
+	if err != nil {
+		return 0, err
+	}
+	if held {
+		return len(p), nil
+	}
+	return w.UploadWriterAt.WriteAt(p, off)
+}
+
+// initContentInspector enables the content inspection for the upload. For
+// resumed and appended uploads the initial bytes are read from the existing file
+func (t *BaseTransfer) initContentInspector(isResume bool, fileSize int64) {
+	t.contentInspector = newUploadContentInspector(t, Config.UploadContentFilter.getInspectSize())
+	if !isResume || fileSize <= 0 {
+		return
+	}
+	data, err := t.readExistingPrefix(min(int64(t.contentInspector.size), fileSize))
+	if err != nil {
+		t.Connection.Log(logger.LevelWarn, "unable to read the initial bytes of %q for the content inspection: %v",
+			t.requestPath, err)
+		t.contentInspector.checked = true
+		t.contentInspector.err = fmt.Errorf("%w: %w, unable to inspect the existing file", os.ErrPermission,
+			errUploadContentDenied)
+		return
+	}
+	t.contentInspector.setExisting(data, fileSize)
+}
+
+func (t *BaseTransfer) readExistingPrefix(size int64) ([]byte, error) {
+	f, r, cancelFn, err := t.Fs.Open(t.effectiveFsPath, 0)
+	if err != nil && t.Fs.IsNotExist(err) && t.effectiveFsPath != t.fsPath {
+		f, r, cancelFn, err = t.Fs.Open(t.fsPath, 0)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cancelFn != nil {
+		defer cancelFn()
+	}
+	var reader io.Reader
+	if f != nil {
+		defer f.Close()
+		reader = f
+	} else {
+		defer r.Close()
+		reader = r
+	}
+	data := make([]byte, size)
+	_, err = io.ReadFull(reader, data)
+	return data, err
+}
+
+// UploadWriterAt defines the writer used for uploads with random access
+type UploadWriterAt interface {
+	io.WriterAt
+	io.Closer
+}
+
+// WrapUploadWriter returns a writer that holds and inspects the initial bytes
+// of the upload, if the content filter is enabled for it, or the given writer
+func (t *BaseTransfer) WrapUploadWriter(w io.WriteCloser) io.WriteCloser {
+	if t.contentInspector == nil || w == nil {
+		return w
+	}
+	t.contentInspector.flush = func(data []byte, _ int64) error {
+		_, err := w.Write(data)
+		return err
+	}
+	return &inspectedUploadWriter{
+		WriteCloser: w,
+		inspector:   t.contentInspector,
+	}
+}
+
+// WrapUploadWriterAt is like WrapUploadWriter for uploads with random access
+func (t *BaseTransfer) WrapUploadWriterAt(w UploadWriterAt) UploadWriterAt {
+	if t.contentInspector == nil || w == nil {
+		return w
+	}
+	t.contentInspector.flush = func(data []byte, offset int64) error {
+		_, err := w.WriteAt(data, offset)
+		return err
+	}
+	return &inspectedUploadWriterAt{
+		UploadWriterAt: w,
+		inspector:      t.contentInspector,
+	}
+}
+
+// CompleteUploadInspection inspects and writes the initial bytes held for
+// uploads smaller than the inspection size. It must be called before closing
+// the upload writer. The transfer fails if the content type is denied
+func (t *BaseTransfer) CompleteUploadInspection() {
+	if t.contentInspector == nil || t.contentInspector.flush == nil {
+		return
+	}
+	if err := t.contentInspector.complete(); err != nil {
+		t.TransferError(err)
+	}
+}
+
+func (t *BaseTransfer) setDeniedContentType(contentType string) {
+	t.Lock()
+	defer t.Unlock()
+
+	metadata := make(map[string]string, len(t.metadata)+1)
+	for k, v := range t.metadata {
+		metadata[k] = v
+	}
+	metadata[uploadDeniedContentTypeKey] = contentType
+	t.metadata = metadata
+}
+
+// removeDeniedUpload removes the file created for an upload denied by the
+// content filter. Atomic uploads are already removed
+func (t *BaseTransfer) removeDeniedUpload(numFiles int, fileSize int64) (int, int64) {
+	if t.contentInspector == nil || !t.contentInspector.isDenied() || t.isAtomicUpload() {
+		return numFiles, fileSize
+	}
+	if _, err := t.Fs.Lstat(t.fsPath); err != nil {
+		// the cloud uploads are canceled and the object is not created
+		return numFiles, fileSize
+	}
+	return t.removeRejectedUpload(numFiles, fileSize)
+}
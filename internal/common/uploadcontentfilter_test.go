@@ -0,0 +1,325 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sftpgo/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+type contentFilterTestWriter struct {
+	bytes.Buffer
+	writes []int64
+}
+
+func (w *contentFilterTestWriter) Close() error {
+	return nil
+}
+
+func (w *contentFilterTestWriter) WriteAt(p []byte, off int64) (int, error) {
+	w.writes = append(w.writes, off)
+	if end := int(off) + len(p); end > w.Len() {
+		w.Write(make([]byte, end-w.Len())) //nolint:errcheck
+	}
+	copy(w.Bytes()[off:], p)
+	return len(p), nil
+}
+
+func getTestPEHeader() []byte {
+	data := make([]byte, 128)
+	copy(data, "MZ")
+	binary.LittleEndian.PutUint32(data[0x3c:], 0x40)
+	copy(data[0x40:], "PE\x00\x00")
+	return data
+}
+
+func getTestZip(t *testing.T, name string, method uint16, content []byte) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	f, err := w.CreateHeader(&zip.FileHeader{Name: name, Method: method})
+	require.NoError(t, err)
+	_, err = f.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestUploadContentFilterConfig(t *testing.T) {
+	c := UploadContentFilterConfig{}
+	assert.NoError(t, c.validate())
+	assert.False(t, c.isEnabledForPath("/file.dat"))
+	assert.Equal(t, defaultUploadInspectSize, c.getInspectSize())
+	c.DeniedTypes = []string{" ELF ", "application/PDF; charset=binary", "image/*"}
+	c.Patterns = []string{"[a-"}
+	assert.Error(t, c.validate())
+	c.Patterns = []string{" *.DAT", "/incoming/*"}
+	c.InspectSize = -1
+	assert.Error(t, c.validate())
+	c.InspectSize = maxUploadInspectSize + 1
+	assert.Error(t, c.validate())
+	c.InspectSize = 1024
+	assert.NoError(t, c.validate())
+	assert.Equal(t, []string{"elf", "application/pdf", "image/*"}, c.DeniedTypes)
+	assert.Equal(t, []string{"*.dat", "/incoming/*"}, c.Patterns)
+	assert.Equal(t, 1024, c.getInspectSize())
+	assert.True(t, c.isEnabledForPath("/dir/file.DAT"))
+	assert.True(t, c.isEnabledForPath("/Incoming/file.txt"))
+	assert.False(t, c.isEnabledForPath("/incoming/sub/file.txt"))
+	assert.False(t, c.isEnabledForPath("/dir/file.txt"))
+	c.Patterns = nil
+	assert.True(t, c.isEnabledForPath("/dir/file.txt"))
+	c.DeniedTypes = []string{"invalid"}
+	assert.Error(t, c.validate())
+}
+
+func TestUploadContentDetection(t *testing.T) {
+	assert.Equal(t, ContentTypeELF, getExecutableType([]byte("\x7fELF\x02\x01\x01")))
+	assert.Equal(t, ContentTypePE, getExecutableType(getTestPEHeader()))
+	// the PE header offset is outside the inspected data
+	assert.Equal(t, ContentTypePE, getExecutableType([]byte("MZ\x90\x00")))
+	notPE := getTestPEHeader()
+	copy(notPE[0x40:], "NOPE")
+	assert.Empty(t, getExecutableType(notPE))
+	assert.Equal(t, ContentTypeMachO, getExecutableType([]byte{0xcf, 0xfa, 0xed, 0xfe, 0x07, 0x00}))
+	assert.Equal(t, ContentTypeMachO, getExecutableType([]byte{0xca, 0xfe, 0xba, 0xbe, 0x00, 0x00, 0x00, 0x02}))
+	// Java class file
+	assert.Empty(t, getExecutableType([]byte{0xca, 0xfe, 0xba, 0xbe, 0x00, 0x00, 0x00, 0x41}))
+	assert.Empty(t, getExecutableType([]byte("plain text")))
+
+	assert.True(t, isZipWithExecutables(getTestZip(t, "setup.EXE", zip.Deflate, []byte("data"))))
+	assert.True(t, isZipWithExecutables(getTestZip(t, "tool", zip.Store, []byte("\x7fELF\x02\x01\x01"))))
+	assert.False(t, isZipWithExecutables(getTestZip(t, "tool", zip.Deflate, []byte("\x7fELF\x02\x01\x01"))))
+	assert.False(t, isZipWithExecutables(getTestZip(t, "readme.txt", zip.Store, []byte("text"))))
+	assert.False(t, isZipWithExecutables([]byte("PK\x03\x04")))
+
+	c := UploadContentFilterConfig{
+		DeniedTypes: []string{ContentTypePE, ContentTypeZipExecutable, "application/pdf", "image/*"},
+	}
+	require.NoError(t, c.validate())
+	assert.Equal(t, ContentTypePE, c.getDeniedType(getTestPEHeader()))
+	assert.Empty(t, c.getDeniedType([]byte("\x7fELF\x02\x01\x01")))
+	assert.Equal(t, ContentTypeZipExecutable, c.getDeniedType(getTestZip(t, "a.dll", zip.Deflate, []byte("data"))))
+	assert.Equal(t, "application/pdf", c.getDeniedType([]byte("%PDF-1.7\n")))
+	assert.Equal(t, "image/png", c.getDeniedType([]byte("\x89PNG\x0D\x0A\x1A\x0A")))
+	assert.Empty(t, c.getDeniedType([]byte("plain text")))
+	assert.Empty(t, c.getDeniedType(nil))
+}
+
+func TestUploadContentInspector(t *testing.T) {
+	oldConfig := Config.UploadContentFilter
+	t.Cleanup(func() {
+		Config.UploadContentFilter = oldConfig
+	})
+	Config.UploadContentFilter = UploadContentFilterConfig{
+		InspectSize: 8,
+		DeniedTypes: []string{ContentTypeELF},
+	}
+	require.NoError(t, Config.UploadContentFilter.validate())
+	conn := NewBaseConnection("", ProtocolSFTP, "", "", dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: "content_filter_user",
+		},
+	})
+	fs := vfs.NewOsFs("", os.TempDir(), "", nil)
+	newTransfer := func(requestPath string) *BaseTransfer {
+		tr := NewBaseTransfer(nil, conn, nil, "", "", requestPath, TransferUpload, 0, 0, 0, 0, true, fs,
+			dataprovider.TransferQuota{})
+		conn.RemoveTransfer(tr)
+		return tr
+	}
+	// the writes are held until the initial bytes are inspected
+	tr := newTransfer("/file.txt")
+	w := &contentFilterTestWriter{}
+	writer := tr.WrapUploadWriter(w)
+	n, err := writer.Write([]byte("abc"))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, 0, w.Len())
+	n, err = writer.Write([]byte("defghij"))
+	assert.NoError(t, err)
+	assert.Equal(t, 7, n)
+	assert.Equal(t, "abcdefghij", w.String())
+	_, err = writer.Write([]byte("k"))
+	assert.NoError(t, err)
+	tr.CompleteUploadInspection()
+	assert.NoError(t, tr.ErrTransfer)
+	assert.Equal(t, "abcdefghijk", w.String())
+	// upload smaller than the inspection size
+	tr = newTransfer("/file.txt")
+	w = &contentFilterTestWriter{}
+	writer = tr.WrapUploadWriter(w)
+	_, err = writer.Write([]byte("abc"))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, w.Len())
+	tr.CompleteUploadInspection()
+	assert.NoError(t, tr.ErrTransfer)
+	assert.Equal(t, "abc", w.String())
+	// denied upload
+	tr = newTransfer("/file.dat")
+	w = &contentFilterTestWriter{}
+	writer = tr.WrapUploadWriter(w)
+	_, err = writer.Write([]byte("\x7fEL"))
+	assert.NoError(t, err)
+	_, err = writer.Write([]byte("F\x02\x01\x01\x00\x00"))
+	assert.ErrorIs(t, err, os.ErrPermission)
+	assert.ErrorIs(t, err, errUploadContentDenied)
+	_, err = writer.Write([]byte("data"))
+	assert.ErrorIs(t, err, errUploadContentDenied)
+	assert.Equal(t, 0, w.Len())
+	assert.Equal(t, ContentTypeELF, tr.metadata[uploadDeniedContentTypeKey])
+	assert.True(t, tr.contentInspector.isDenied())
+	// small denied upload
+	tr = newTransfer("/file.dat")
+	w = &contentFilterTestWriter{}
+	writer = tr.WrapUploadWriter(w)
+	_, err = writer.Write([]byte("\x7fELF"))
+	assert.NoError(t, err)
+	tr.CompleteUploadInspection()
+	assert.ErrorIs(t, tr.ErrTransfer, errUploadContentDenied)
+	assert.Equal(t, 0, w.Len())
+	// random access writes
+	tr = newTransfer("/file.dat")
+	w = &contentFilterTestWriter{}
+	writerAt := tr.WrapUploadWriterAt(w)
+	_, err = writerAt.WriteAt([]byte("abcd"), 0)
+	assert.NoError(t, err)
+	assert.Empty(t, w.writes)
+	// a write after the initial bytes is not held and does not trigger the inspection
+	_, err = writerAt.WriteAt([]byte("0123"), 16)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{16}, w.writes)
+	// a gap within the initial bytes, the known bytes are inspected
+	_, err = writerAt.WriteAt([]byte("x"), 6)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{16, 0, 6}, w.writes)
+	_, err = writerAt.WriteAt([]byte("ef"), 4)
+	assert.NoError(t, err)
+	// the initial bytes are changed to a denied type
+	_, err = writerAt.WriteAt([]byte("\x7fELF"), 0)
+	assert.ErrorIs(t, err, errUploadContentDenied)
+	assert.Equal(t, []int64{16, 0, 6, 4}, w.writes)
+	// an out of order write before any initial byte is not inspected
+	Config.UploadContentFilter.DeniedTypes = []string{ContentTypeELF, "text/plain"}
+	tr = newTransfer("/file.dat")
+	w = &contentFilterTestWriter{}
+	writerAt = tr.WrapUploadWriterAt(w)
+	_, err = writerAt.WriteAt([]byte("abc"), 5)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{5}, w.writes)
+	_, err = writerAt.WriteAt([]byte("%PDF-"), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{5}, w.writes)
+	tr.CompleteUploadInspection()
+	assert.NoError(t, tr.ErrTransfer)
+	assert.Equal(t, []int64{5, 0}, w.writes)
+	// the first contiguous bytes are inspected
+	tr = newTransfer("/file.dat")
+	w = &contentFilterTestWriter{}
+	writerAt = tr.WrapUploadWriterAt(w)
+	_, err = writerAt.WriteAt([]byte("abc"), 5)
+	assert.NoError(t, err)
+	_, err = writerAt.WriteAt([]byte("text"), 0)
+	assert.NoError(t, err)
+	_, err = writerAt.WriteAt([]byte("x"), 6)
+	assert.ErrorIs(t, err, errUploadContentDenied)
+	assert.Equal(t, []int64{5}, w.writes)
+	Config.UploadContentFilter.DeniedTypes = []string{ContentTypeELF}
+	// a non matching path
+	Config.UploadContentFilter.Patterns = []string{"*.exe"}
+	tr = newTransfer("/file.dat")
+	assert.Nil(t, tr.contentInspector)
+	w = &contentFilterTestWriter{}
+	assert.Equal(t, w, tr.WrapUploadWriter(w))
+	assert.Equal(t, w, tr.WrapUploadWriterAt(w))
+	tr.CompleteUploadInspection()
+	assert.NoError(t, tr.ErrTransfer)
+}
+
+func TestUploadContentInspectorResume(t *testing.T) {
+	oldConfig := Config.UploadContentFilter
+	t.Cleanup(func() {
+		Config.UploadContentFilter = oldConfig
+	})
+	Config.UploadContentFilter = UploadContentFilterConfig{
+		InspectSize: 8,
+		DeniedTypes: []string{ContentTypeELF},
+	}
+	require.NoError(t, Config.UploadContentFilter.validate())
+	conn := NewBaseConnection("", ProtocolSFTP, "", "", dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: "content_filter_user",
+		},
+	})
+	fs := vfs.NewOsFs("", os.TempDir(), "", nil)
+	testFile := filepath.Join(os.TempDir(), "content_filter_resume")
+	t.Cleanup(func() {
+		os.Remove(testFile)
+	})
+	newTransfer := func(minWriteOffset, initialSize int64) *BaseTransfer {
+		tr := NewBaseTransfer(nil, conn, nil, testFile, testFile, "/content_filter_resume", TransferUpload,
+			minWriteOffset, initialSize, 0, 0, false, fs, dataprovider.TransferQuota{})
+		conn.RemoveTransfer(tr)
+		return tr
+	}
+	// the existing bytes and the appended ones are inspected together
+	err := os.WriteFile(testFile, []byte("\x7fEL"), 0666)
+	require.NoError(t, err)
+	tr := newTransfer(3, 3)
+	w := &contentFilterTestWriter{}
+	writer := tr.WrapUploadWriter(w)
+	_, err = writer.Write([]byte("F\x02\x01\x01\x00\x00"))
+	assert.ErrorIs(t, err, errUploadContentDenied)
+	assert.Equal(t, 0, w.Len())
+
+	err = os.WriteFile(testFile, []byte("abc"), 0666)
+	require.NoError(t, err)
+	tr = newTransfer(3, 3)
+	w = &contentFilterTestWriter{}
+	writerAt := tr.WrapUploadWriterAt(w)
+	_, err = writerAt.WriteAt([]byte("de"), 3)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, w.Len())
+	tr.CompleteUploadInspection()
+	assert.NoError(t, tr.ErrTransfer)
+	// only the appended bytes are written
+	assert.Equal(t, []int64{3}, w.writes)
+	// the existing bytes are enough for the inspection
+	err = os.WriteFile(testFile, []byte("\x7fELF\x02\x01\x01\x00\x00"), 0666)
+	require.NoError(t, err)
+	tr = newTransfer(0, 9)
+	w = &contentFilterTestWriter{}
+	writer = tr.WrapUploadWriter(w)
+	_, err = writer.Write([]byte("data"))
+	assert.ErrorIs(t, err, errUploadContentDenied)
+	// the existing file cannot be read
+	err = os.Remove(testFile)
+	require.NoError(t, err)
+	tr = newTransfer(3, 3)
+	w = &contentFilterTestWriter{}
+	writer = tr.WrapUploadWriter(w)
+	_, err = writer.Write([]byte("data"))
+	assert.ErrorIs(t, err, os.ErrPermission)
+}
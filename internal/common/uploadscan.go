@@ -0,0 +1,242 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/command"
+	"github.com/drakkan/sftpgo/v2/internal/httpclient"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/metric"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+const operationUploadScan = "upload-scan"
+
+// errUploadScanRejected is returned if the upload scan hook rejects a file
+var errUploadScanRejected = errors.New("file rejected by the upload scan hook")
+
+// UploadScanConfig defines the configuration for the hook executed synchronously
+// after an upload completes and before the result is returned to the client.
+// Uploads rejected by the hook are removed and the client gets a permission
+// denied error
+type UploadScanConfig struct {
+	// Absolute path to an external program or an HTTP URL. Leave empty to disable.
+	// For uploads to the local filesystem the external program gets the file path
+	// as environment variable, for the other storage backends the file content is
+	// streamed to its standard input. A non-zero exit code rejects the file.
+	// HTTP URLs are invoked using a POST request with the file content as body, a
+	// non-2xx response code rejects the file.
+	// The timeout is configured using the "upload_scan" hook name
+	Hook string `json:"hook" mapstructure:"hook"`
+	// Shell like patterns, matched against the file name, for the uploads to scan.
+	// Empty means all the uploads
+	Patterns []string `json:"patterns" mapstructure:"patterns"`
+	// If enabled, uploads are accepted if the hook cannot be executed or times out,
+	// otherwise they are rejected
+	FailOpen bool `json:"fail_open" mapstructure:"fail_open"`
+}
+
+func (c *UploadScanConfig) validate() error {
+	if c.Hook == "" {
+		return nil
+	}
+	if !strings.HasPrefix(c.Hook, "http") && !filepath.IsAbs(c.Hook) {
+		return fmt.Errorf("invalid upload scan hook %q", c.Hook)
+	}
+	if err := validateFilePatterns(c.Patterns); err != nil {
+		return fmt.Errorf("invalid upload scan patterns: %w", err)
+	}
+	return nil
+}
+
+func (c *UploadScanConfig) isEnabledForPath(virtualPath string) bool {
+	if c.Hook == "" {
+		return false
+	}
+	if len(c.Patterns) == 0 {
+		return true
+	}
+	return matchFilePatterns(c.Patterns, virtualPath)
+}
+
+// validateFilePatterns validates and normalizes, in place, the given shell like patterns
+func validateFilePatterns(patterns []string) error {
+	for idx, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if _, err := path.Match(pattern, "abc"); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		patterns[idx] = pattern
+	}
+	return nil
+}
+
+// matchFilePatterns returns true if the base name of the given virtual path,
+// case insensitive, matches at least one of the specified patterns
+func matchFilePatterns(patterns []string, virtualPath string) bool {
+	name := strings.ToLower(path.Base(virtualPath))
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// executeUploadScanHook runs the upload scan hook, if configured, and removes
+// the uploaded file if it is rejected
+func (t *BaseTransfer) executeUploadScanHook(numFiles int, fileSize int64) (int, int64) {
+	if t.ErrTransfer != nil || t.uploadScanned || !Config.UploadScan.isEnabledForPath(t.requestPath) {
+		return numFiles, fileSize
+	}
+	if t.isUploadAccepted(t.fsPath, fileSize) {
+		return numFiles, fileSize
+	}
+	// the protocol handlers convert this error in a permission denied error for the client
+	t.ErrTransfer = os.ErrPermission
+	return t.removeRejectedUpload(numFiles, fileSize)
+}
+
+// executeAtomicUploadScanHook runs the upload scan hook, if configured, against
+// the temporary object of a cloud atomic upload, before it is moved to the
+// requested path. The caller must delete the temporary object if rejected
+func (t *BaseTransfer) executeAtomicUploadScanHook() {
+	if t.ErrTransfer != nil || !Config.UploadScan.isEnabledForPath(t.requestPath) {
+		return
+	}
+	t.uploadScanned = true
+	if !t.isUploadAccepted(t.effectiveFsPath, t.BytesReceived.Load()) {
+		t.ErrTransfer = os.ErrPermission
+	}
+}
+
+// isUploadAccepted runs the upload scan hook reading the file content from
+// the specified path and returns false if the upload must be rejected
+func (t *BaseTransfer) isUploadAccepted(fsPath string, fileSize int64) bool {
+	startTime := time.Now()
+	err := t.scanUpload(fsPath, fileSize)
+	result := "accepted"
+	if err != nil {
+		if errors.Is(err, errUploadScanRejected) {
+			result = "rejected"
+		} else {
+			result = "error"
+		}
+	}
+	metric.UploadScanCompleted(time.Since(startTime), result)
+	t.Connection.Log(logger.LevelDebug, "upload scan hook executed for %q, result: %s, elapsed: %s, error: %v",
+		t.requestPath, result, time.Since(startTime), err)
+	if err == nil || (result == "error" && Config.UploadScan.FailOpen) {
+		return true
+	}
+	t.Connection.Log(logger.LevelWarn, "upload of %q denied by the upload scan hook: %v", t.requestPath, err)
+	return false
+}
+
+func (t *BaseTransfer) scanUpload(fsPath string, fileSize int64) error {
+	var reader io.Reader
+	if !vfs.IsLocalOsFs(t.Fs) || strings.HasPrefix(Config.UploadScan.Hook, "http") {
+		f, r, cancelFn, err := t.Fs.Open(fsPath, 0)
+		if err != nil {
+			return fmt.Errorf("unable to open the uploaded file: %w", err)
+		}
+		if cancelFn != nil {
+			defer cancelFn()
+		}
+		if f != nil {
+			defer f.Close()
+			reader = f
+		} else {
+			defer r.Close()
+			reader = r
+		}
+	}
+	event := newActionNotification(&t.Connection.User, operationUploadScan, t.fsPath, t.requestPath, "", "", "",
+		t.Connection.protocol, t.Connection.GetRemoteIP(), t.Connection.ID, fileSize, 0, 1, 0, time.Now(), t.metadata)
+	if strings.HasPrefix(Config.UploadScan.Hook, "http") {
+		return t.scanUploadHTTP(event.Username, event.FsProvider, fileSize, reader)
+	}
+	return t.scanUploadCommand(notificationAsEnvVars(event), reader)
+}
+
+func (t *BaseTransfer) scanUploadHTTP(username string, fsProvider int, fileSize int64, reader io.Reader) error {
+	u, err := url.Parse(Config.UploadScan.Hook)
+	if err != nil {
+		return fmt.Errorf("invalid upload scan hook %q: %w", Config.UploadScan.Hook, err)
+	}
+	q := u.Query()
+	q.Add("username", username)
+	q.Add("path", t.fsPath)
+	q.Add("virtual_path", t.requestPath)
+	q.Add("file_size", strconv.FormatInt(fileSize, 10))
+	q.Add("fs_provider", strconv.Itoa(fsProvider))
+	q.Add("protocol", t.Connection.protocol)
+	q.Add("ip", t.Connection.GetRemoteIP())
+	u.RawQuery = q.Encode()
+
+	ctx, cancel := context.WithTimeout(context.Background(), httpclient.GetHookTimeout(command.HookUploadScan))
+	defer cancel()
+
+	resp, err := httpclient.PostWithContext(ctx, u.String(), "application/octet-stream", reader)
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			metric.AddHookTimeout(command.HookUploadScan)
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("%w, response code: %d", errUploadScanRejected, resp.StatusCode)
+	}
+	return nil
+}
+
+func (t *BaseTransfer) scanUploadCommand(env []string, reader io.Reader) error {
+	timeout, cmdEnv, args := command.GetConfig(Config.UploadScan.Hook, command.HookUploadScan)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, Config.UploadScan.Hook, args...)
+	cmd.WaitDelay = command.WaitDelay
+	cmd.Env = append(cmdEnv, env...)
+	cmd.Stdin = reader
+	err := cmd.Run()
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			metric.AddHookTimeout(command.HookUploadScan)
+			return err
+		}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return fmt.Errorf("%w, exit code: %d", errUploadScanRejected, exitErr.ExitCode())
+		}
+	}
+	return err
+}
@@ -0,0 +1,40 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUploadScanConfig(t *testing.T) {
+	c := UploadScanConfig{}
+	assert.NoError(t, c.validate())
+	assert.False(t, c.isEnabledForPath("/file.exe"))
+	c.Hook = "relative/path"
+	assert.Error(t, c.validate())
+	c.Hook = "http://127.0.0.1:8080/scan"
+	c.Patterns = []string{"[a-"}
+	assert.Error(t, c.validate())
+	c.Patterns = []string{" *.EXE", "*.zip "}
+	assert.NoError(t, c.validate())
+	assert.Equal(t, []string{"*.exe", "*.zip"}, c.Patterns)
+	assert.True(t, c.isEnabledForPath("/dir/file.Exe"))
+	assert.True(t, c.isEnabledForPath("/archive.zip"))
+	assert.False(t, c.isEnabledForPath("/dir.zip/file.txt"))
+	c.Patterns = nil
+	assert.True(t, c.isEnabledForPath("/dir.zip/file.txt"))
+}
@@ -0,0 +1,80 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"path"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+// completePGPUpload renames the files encrypted on upload adding the PGP
+// extension, if required. Partial uploads are removed, the ciphertext cannot
+// be resumed
+func (t *BaseTransfer) completePGPUpload(numFiles int, fileSize int64) (int, int64) {
+	if !vfs.IsPGPFs(t.Fs) {
+		return numFiles, fileSize
+	}
+	if t.ErrTransfer != nil {
+		if _, err := t.Fs.Lstat(t.fsPath); err != nil {
+			// the cloud uploads are canceled and the object is not created
+			return numFiles, fileSize
+		}
+		t.Connection.Log(logger.LevelDebug, "removing partial PGP encrypted file %q", t.fsPath)
+		return t.removeRejectedUpload(numFiles, fileSize)
+	}
+	targetPath := vfs.GetPGPFileName(t.Fs, t.fsPath)
+	if targetPath == t.fsPath {
+		return numFiles, fileSize
+	}
+	targetRequestPath := vfs.GetPGPFileName(t.Fs, t.requestPath)
+	existingSize := int64(-1)
+	if info, err := t.Fs.Lstat(targetPath); err == nil {
+		if !info.Mode().IsRegular() || !t.Connection.User.HasPerm(dataprovider.PermOverwrite, path.Dir(t.requestPath)) {
+			t.Connection.Log(logger.LevelWarn, "unable to overwrite %q with the PGP encrypted upload", targetRequestPath)
+			t.ErrTransfer = t.Connection.GetPermissionDeniedError()
+			return t.removeRejectedUpload(numFiles, fileSize)
+		}
+		existingSize = info.Size()
+	}
+	_, _, err := t.Fs.Rename(t.fsPath, targetPath, 0)
+	t.Connection.Log(logger.LevelDebug, "PGP encrypted upload completed, rename: %q -> %q, error: %v",
+		t.fsPath, targetPath, err)
+	if err != nil {
+		t.ErrTransfer = err
+		return t.removeRejectedUpload(numFiles, fileSize)
+	}
+	if existingSize >= 0 {
+		// the existing file was replaced
+		updateUserQuotaAfterFileWrite(t.Connection, targetRequestPath, -1, -existingSize)
+	}
+	if t.effectiveFsPath == t.fsPath {
+		t.effectiveFsPath = targetPath
+	}
+	t.fsPath = targetPath
+	t.requestPath = targetRequestPath
+	return numFiles, fileSize
+}
+
+// getUploadEventSize returns the size to report in the upload events and logs.
+// The plaintext size is reported for the files encrypted on upload
+func (t *BaseTransfer) getUploadEventSize(fileSize int64) int64 {
+	if fileSize > 0 && vfs.IsPGPFs(t.Fs) {
+		return t.BytesReceived.Load()
+	}
+	return fileSize
+}
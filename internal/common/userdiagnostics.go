@@ -0,0 +1,243 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"fmt"
+	"path"
+	"slices"
+
+	"github.com/sftpgo/sdk"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+// Supported operations for the user diagnostics
+const (
+	DiagnosticOperationList      = "list"
+	DiagnosticOperationDownload  = "download"
+	DiagnosticOperationUpload    = "upload"
+	DiagnosticOperationOverwrite = "overwrite"
+	DiagnosticOperationDelete    = "delete"
+	DiagnosticOperationMkdir     = "mkdir"
+)
+
+// Rules that can deny an operation
+const (
+	DiagnosticRuleLoginConditions = "login_conditions"
+	DiagnosticRuleProtocol        = "protocol"
+	DiagnosticRulePermissions     = "permissions"
+	DiagnosticRulePatternsFilter  = "patterns_filter"
+	DiagnosticRuleQuota           = "quota"
+	DiagnosticRuleTransferQuota   = "transfer_quota"
+)
+
+var diagnosticOperations = []string{DiagnosticOperationList, DiagnosticOperationDownload, DiagnosticOperationUpload,
+	DiagnosticOperationOverwrite, DiagnosticOperationDelete, DiagnosticOperationMkdir}
+
+// UserDiagnosticPatternsFilter defines the patterns filter verdict for a path
+type UserDiagnosticPatternsFilter struct {
+	Allowed bool `json:"allowed"`
+	// Filter applying to the path, the path is empty if no filter applies
+	Filter sdk.PatternsFilter `json:"filter"`
+}
+
+// UserDiagnosticQuota defines the quota headroom for a path
+type UserDiagnosticQuota struct {
+	HasSpace bool `json:"has_space"`
+	// Name of the virtual folder with its own quota, empty if the user quota applies
+	FolderName string `json:"folder_name,omitempty"`
+	QuotaSize  int64  `json:"quota_size"`
+	QuotaFiles int    `json:"quota_files"`
+	UsedSize   int64  `json:"used_size"`
+	UsedFiles  int    `json:"used_files"`
+	// Remaining size and files, 0 if there is no limit
+	RemainingSize  int64 `json:"remaining_size"`
+	RemainingFiles int   `json:"remaining_files"`
+	// Maximum size for an uploaded file, 0 means unlimited
+	MaxUploadFileSize int64 `json:"max_upload_file_size"`
+	// Transfer quota verdicts
+	HasUploadTransferSpace   bool `json:"has_upload_transfer_space"`
+	HasDownloadTransferSpace bool `json:"has_download_transfer_space"`
+	// Remaining transfer quota as bytes, only meaningful if the related limit is set
+	RemainingUploadTransfer   int64 `json:"remaining_upload_transfer"`
+	RemainingDownloadTransfer int64 `json:"remaining_download_transfer"`
+	RemainingTotalTransfer    int64 `json:"remaining_total_transfer"`
+}
+
+// UserDiagnostic defines the effective configuration for a user and, optionally,
+// the verdict for an operation on a virtual path
+type UserDiagnostic struct {
+	// User with the group settings applied
+	User        dataprovider.User `json:"user"`
+	VirtualPath string            `json:"virtual_path,omitempty"`
+	Operation   string            `json:"operation,omitempty"`
+	Protocol    string            `json:"protocol,omitempty"`
+	// Virtual folder containing the virtual path, nil if the path is not
+	// inside a virtual folder
+	VirtualFolder  *vfs.VirtualFolder            `json:"virtual_folder,omitempty"`
+	Permissions    []string                      `json:"permissions,omitempty"`
+	PatternsFilter *UserDiagnosticPatternsFilter `json:"patterns_filter,omitempty"`
+	Quota          *UserDiagnosticQuota          `json:"quota,omitempty"`
+	Allowed        bool                          `json:"allowed"`
+	// The first rule denying the operation
+	DeniedBy string `json:"denied_by,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// deny records the denying rule, only the first one is kept
+func (d *UserDiagnostic) deny(rule, reason string) {
+	if !d.Allowed {
+		return
+	}
+	d.Allowed = false
+	d.DeniedBy = rule
+	d.Reason = reason
+}
+
+// GetUserDiagnostic returns the effective configuration for the specified user.
+// If a virtual path is specified, the diagnostic also includes the matched
+// virtual folder, the permissions, the patterns filter verdict and the quota
+// headroom for the path and whether the specified operation, using the specified
+// protocol, is allowed. The user does not need to be connected and nothing is
+// modified
+func GetUserDiagnostic(username, role, virtualPath, operation, protocol string) (UserDiagnostic, error) {
+	if virtualPath != "" {
+		virtualPath = util.CleanPath(virtualPath)
+		if operation == "" {
+			operation = DiagnosticOperationList
+		}
+		if !slices.Contains(diagnosticOperations, operation) {
+			return UserDiagnostic{}, util.NewValidationError(fmt.Sprintf("invalid operation %q", operation))
+		}
+	}
+	if protocol != "" && !slices.Contains(dataprovider.ValidProtocols, protocol) {
+		return UserDiagnostic{}, util.NewValidationError(fmt.Sprintf("invalid protocol %q", protocol))
+	}
+	user, err := dataprovider.GetUserWithEffectiveSettings(username, role)
+	if err != nil {
+		return UserDiagnostic{}, err
+	}
+	result := UserDiagnostic{
+		User:        user,
+		VirtualPath: virtualPath,
+		Protocol:    protocol,
+		Allowed:     true,
+	}
+	// the checks are executed in the same order as for a real connection
+	if err := user.CheckLoginConditions(); err != nil {
+		result.deny(DiagnosticRuleLoginConditions, err.Error())
+	}
+	if protocol != "" && slices.Contains(user.Filters.DeniedProtocols, protocol) {
+		result.deny(DiagnosticRuleProtocol, fmt.Sprintf("protocol %s is not allowed", protocol))
+	}
+	if virtualPath != "" {
+		result.Operation = operation
+		checkDiagnosticPath(&result)
+	}
+	return result, nil
+}
+
+func checkDiagnosticPath(d *UserDiagnostic) {
+	user := &d.User
+	if folder, err := user.GetVirtualFolderForPath(d.VirtualPath); err == nil {
+		d.VirtualFolder = &folder
+	}
+	// listing a directory requires the permissions for the directory itself,
+	// the other operations the ones for the parent directory
+	permsPath := path.Dir(d.VirtualPath)
+	if d.Operation == DiagnosticOperationList {
+		permsPath = d.VirtualPath
+	}
+	d.Permissions = user.GetPermissionsForPath(permsPath)
+	if d.VirtualPath != "/" {
+		filter, allowed := user.GetPatternsFilterForFile(d.VirtualPath)
+		d.PatternsFilter = &UserDiagnosticPatternsFilter{
+			Allowed: allowed,
+			Filter:  filter,
+		}
+	}
+	// overwriting a file does not change the number of files
+	d.Quota = getDiagnosticQuota(user, d.VirtualPath, d.Operation != DiagnosticOperationOverwrite)
+
+	if perms := getDiagnosticRequiredPerms(d.Operation); !slices.ContainsFunc(perms, func(perm string) bool {
+		return user.HasPerm(perm, permsPath)
+	}) {
+		d.deny(DiagnosticRulePermissions, fmt.Sprintf("missing permission %q for path %q", perms[0], permsPath))
+	}
+	if d.PatternsFilter != nil && !d.PatternsFilter.Allowed {
+		d.deny(DiagnosticRulePatternsFilter, fmt.Sprintf("denied by the patterns filter for path %q",
+			d.PatternsFilter.Filter.Path))
+	}
+	switch d.Operation {
+	case DiagnosticOperationUpload, DiagnosticOperationOverwrite:
+		if !d.Quota.HasSpace {
+			d.deny(DiagnosticRuleQuota, "disk quota exceeded")
+		}
+		if !d.Quota.HasUploadTransferSpace {
+			d.deny(DiagnosticRuleTransferQuota, "upload transfer quota exceeded")
+		}
+	case DiagnosticOperationDownload:
+		if !d.Quota.HasDownloadTransferSpace {
+			d.deny(DiagnosticRuleTransferQuota, "download transfer quota exceeded")
+		}
+	}
+}
+
+// getDiagnosticRequiredPerms returns the permissions that allow the specified
+// operation, any of them is enough
+func getDiagnosticRequiredPerms(operation string) []string {
+	switch operation {
+	case DiagnosticOperationDownload:
+		return []string{dataprovider.PermDownload}
+	case DiagnosticOperationUpload:
+		return []string{dataprovider.PermUpload}
+	case DiagnosticOperationOverwrite:
+		return []string{dataprovider.PermOverwrite}
+	case DiagnosticOperationDelete:
+		return []string{dataprovider.PermDeleteFiles, dataprovider.PermDelete}
+	case DiagnosticOperationMkdir:
+		return []string{dataprovider.PermCreateDirs}
+	default:
+		return []string{dataprovider.PermListItems}
+	}
+}
+
+func getDiagnosticQuota(user *dataprovider.User, virtualPath string, checkFiles bool) *UserDiagnosticQuota {
+	conn := NewBaseConnection("", ProtocolHTTP, "", "", *user)
+	quotaResult, transferQuota := conn.HasSpace(checkFiles, true, virtualPath)
+	result := &UserDiagnosticQuota{
+		HasSpace:          quotaResult.HasSpace,
+		QuotaSize:         quotaResult.QuotaSize,
+		QuotaFiles:        quotaResult.QuotaFiles,
+		UsedSize:          quotaResult.UsedSize,
+		UsedFiles:         quotaResult.UsedFiles,
+		RemainingSize:     quotaResult.GetRemainingSize(),
+		RemainingFiles:    quotaResult.GetRemainingFiles(),
+		MaxUploadFileSize: user.GetMaxUploadFileSize(virtualPath),
+
+		HasUploadTransferSpace:    transferQuota.HasUploadSpace(),
+		HasDownloadTransferSpace:  transferQuota.HasDownloadSpace(),
+		RemainingUploadTransfer:   transferQuota.AllowedULSize,
+		RemainingDownloadTransfer: transferQuota.AllowedDLSize,
+		RemainingTotalTransfer:    transferQuota.AllowedTotalSize,
+	}
+	if folder, err := user.GetVirtualFolderForPath(path.Dir(virtualPath)); err == nil && !folder.IsIncludedInUserQuota() {
+		result.FolderName = folder.Name
+	}
+	return result
+}
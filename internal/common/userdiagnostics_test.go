@@ -0,0 +1,231 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sftpgo/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+func TestUserDiagnostic(t *testing.T) {
+	folder1 := vfs.BaseVirtualFolder{
+		Name:       "diag_folder1",
+		MappedPath: filepath.Join(os.TempDir(), "diag_folder1"),
+	}
+	folder2 := vfs.BaseVirtualFolder{
+		Name:       "diag_folder2",
+		MappedPath: filepath.Join(os.TempDir(), "diag_folder2"),
+	}
+	for _, f := range []*vfs.BaseVirtualFolder{&folder1, &folder2} {
+		err := dataprovider.AddFolder(f, "", "", "")
+		require.NoError(t, err)
+	}
+	group := dataprovider.Group{
+		BaseGroup: sdk.BaseGroup{
+			Name: "diag_group",
+		},
+		UserSettings: dataprovider.GroupUserSettings{
+			BaseGroupUserSettings: sdk.BaseGroupUserSettings{
+				Filters: sdk.BaseUserFilters{
+					DeniedProtocols: []string{ProtocolFTP},
+					FilePatterns: []sdk.PatternsFilter{
+						{
+							Path:           "/vdir1/sub/vdir2",
+							DeniedPatterns: []string{"*.exe"},
+						},
+						{
+							Path:           "/vdir1",
+							DeniedPatterns: []string{"hidden"},
+							DenyPolicy:     sdk.DenyPolicyHide,
+						},
+					},
+				},
+			},
+		},
+		// the nested virtual folder is inherited from the group
+		VirtualFolders: []vfs.VirtualFolder{
+			{
+				BaseVirtualFolder: vfs.BaseVirtualFolder{
+					Name: folder2.Name,
+				},
+				VirtualPath: "/vdir1/sub/vdir2",
+				QuotaFiles:  10,
+			},
+		},
+	}
+	err := dataprovider.AddGroup(&group, "", "", "")
+	require.NoError(t, err)
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: "diag_user",
+			Password: "pwd",
+			HomeDir:  filepath.Join(os.TempDir(), "diag_user"),
+			Status:   1,
+			Permissions: map[string][]string{
+				"/":                {dataprovider.PermAny},
+				"/vdir1":           {dataprovider.PermListItems, dataprovider.PermDownload},
+				"/vdir1/sub/vdir2": {dataprovider.PermAny},
+			},
+		},
+		VirtualFolders: []vfs.VirtualFolder{
+			{
+				BaseVirtualFolder: vfs.BaseVirtualFolder{
+					Name: folder1.Name,
+				},
+				VirtualPath: "/vdir1",
+				QuotaSize:   -1,
+				QuotaFiles:  -1,
+			},
+		},
+		Groups: []sdk.GroupMapping{
+			{
+				Name: group.Name,
+				Type: sdk.GroupTypeSecondary,
+			},
+		},
+	}
+	err = dataprovider.AddUser(&user, "", "", "")
+	require.NoError(t, err)
+
+	d, err := GetUserDiagnostic(user.Username, "", "", "", "")
+	require.NoError(t, err)
+	assert.True(t, d.Allowed)
+	assert.Empty(t, d.Operation)
+	assert.Nil(t, d.VirtualFolder)
+	assert.Nil(t, d.Quota)
+	assert.Len(t, d.User.VirtualFolders, 2)
+	assert.Len(t, d.User.Filters.FilePatterns, 2)
+	assert.NotNil(t, d.User.EffectiveSources)
+	// the default operation is list
+	d, err = GetUserDiagnostic(user.Username, "", "vdir1/", "", ProtocolSSH)
+	require.NoError(t, err)
+	assert.True(t, d.Allowed)
+	assert.Equal(t, "/vdir1", d.VirtualPath)
+	assert.Equal(t, DiagnosticOperationList, d.Operation)
+	if assert.NotNil(t, d.VirtualFolder) {
+		assert.Equal(t, folder1.Name, d.VirtualFolder.Name)
+	}
+	assert.Equal(t, []string{dataprovider.PermListItems, dataprovider.PermDownload}, d.Permissions)
+	// the user permissions for the folder apply
+	d, err = GetUserDiagnostic(user.Username, "", "/vdir1/sub/file.txt", DiagnosticOperationUpload, "")
+	require.NoError(t, err)
+	assert.False(t, d.Allowed)
+	assert.Equal(t, DiagnosticRulePermissions, d.DeniedBy)
+	assert.Contains(t, d.Reason, dataprovider.PermUpload)
+	if assert.NotNil(t, d.VirtualFolder) {
+		assert.Equal(t, folder1.Name, d.VirtualFolder.Name)
+	}
+	if assert.NotNil(t, d.Quota) {
+		assert.Empty(t, d.Quota.FolderName)
+	}
+	d, err = GetUserDiagnostic(user.Username, "", "/vdir1/sub/file.txt", DiagnosticOperationDownload, "")
+	require.NoError(t, err)
+	assert.True(t, d.Allowed)
+	// nested virtual folder inherited from the group
+	d, err = GetUserDiagnostic(user.Username, "", "/vdir1/sub/vdir2/file.txt", DiagnosticOperationUpload, ProtocolSSH)
+	require.NoError(t, err)
+	assert.True(t, d.Allowed)
+	assert.Empty(t, d.DeniedBy)
+	if assert.NotNil(t, d.VirtualFolder) {
+		assert.Equal(t, folder2.Name, d.VirtualFolder.Name)
+	}
+	assert.Equal(t, []string{dataprovider.PermAny}, d.Permissions)
+	if assert.NotNil(t, d.PatternsFilter) {
+		assert.True(t, d.PatternsFilter.Allowed)
+		assert.Equal(t, "/vdir1/sub/vdir2", d.PatternsFilter.Filter.Path)
+	}
+	if assert.NotNil(t, d.Quota) {
+		assert.True(t, d.Quota.HasSpace)
+		assert.Equal(t, folder2.Name, d.Quota.FolderName)
+		assert.Equal(t, 10, d.Quota.QuotaFiles)
+		assert.Equal(t, 10, d.Quota.RemainingFiles)
+	}
+	// file patterns inherited from the group
+	d, err = GetUserDiagnostic(user.Username, "", "/vdir1/sub/vdir2/file.exe", DiagnosticOperationUpload, "")
+	require.NoError(t, err)
+	assert.False(t, d.Allowed)
+	assert.Equal(t, DiagnosticRulePatternsFilter, d.DeniedBy)
+	if assert.NotNil(t, d.PatternsFilter) {
+		assert.False(t, d.PatternsFilter.Allowed)
+		assert.Equal(t, "/vdir1/sub/vdir2", d.PatternsFilter.Filter.Path)
+	}
+	// a hidden parent directory denies the file
+	d, err = GetUserDiagnostic(user.Username, "", "/vdir1/hidden/file.txt", DiagnosticOperationDownload, "")
+	require.NoError(t, err)
+	assert.False(t, d.Allowed)
+	assert.Equal(t, DiagnosticRulePatternsFilter, d.DeniedBy)
+	if assert.NotNil(t, d.PatternsFilter) {
+		assert.Equal(t, "/vdir1", d.PatternsFilter.Filter.Path)
+		assert.Equal(t, sdk.DenyPolicyHide, d.PatternsFilter.Filter.DenyPolicy)
+	}
+	// the folder quota is exceeded
+	err = dataprovider.UpdateVirtualFolderQuota(&folder2, 10, 100, true)
+	require.NoError(t, err)
+	d, err = GetUserDiagnostic(user.Username, "", "/vdir1/sub/vdir2/file.txt", DiagnosticOperationUpload, "")
+	require.NoError(t, err)
+	assert.False(t, d.Allowed)
+	assert.Equal(t, DiagnosticRuleQuota, d.DeniedBy)
+	if assert.NotNil(t, d.Quota) {
+		assert.False(t, d.Quota.HasSpace)
+		assert.Equal(t, 10, d.Quota.UsedFiles)
+		assert.Equal(t, int64(100), d.Quota.UsedSize)
+	}
+	// overwriting a file does not require a new file
+	d, err = GetUserDiagnostic(user.Username, "", "/vdir1/sub/vdir2/file.txt", DiagnosticOperationOverwrite, "")
+	require.NoError(t, err)
+	assert.True(t, d.Allowed)
+	// protocol denied by the group, it is checked before the path
+	d, err = GetUserDiagnostic(user.Username, "", "/vdir1/sub/vdir2/file.exe", DiagnosticOperationUpload, ProtocolFTP)
+	require.NoError(t, err)
+	assert.False(t, d.Allowed)
+	assert.Equal(t, DiagnosticRuleProtocol, d.DeniedBy)
+	// login conditions are checked first
+	user.ExpirationDate = util.GetTimeAsMsSinceEpoch(time.Now().Add(-time.Hour))
+	err = dataprovider.UpdateUser(&user, "", "", "")
+	require.NoError(t, err)
+	d, err = GetUserDiagnostic(user.Username, "", "", "", ProtocolFTP)
+	require.NoError(t, err)
+	assert.False(t, d.Allowed)
+	assert.Equal(t, DiagnosticRuleLoginConditions, d.DeniedBy)
+	assert.Contains(t, d.Reason, "expired")
+
+	_, err = GetUserDiagnostic(user.Username, "", "/file.txt", "invalid", "")
+	var validationErr *util.ValidationError
+	assert.True(t, errors.As(err, &validationErr))
+	_, err = GetUserDiagnostic(user.Username, "", "/file.txt", DiagnosticOperationUpload, "invalid")
+	assert.True(t, errors.As(err, &validationErr))
+	_, err = GetUserDiagnostic("missing_diag_user", "", "", "", "")
+	assert.ErrorIs(t, err, util.ErrNotFound)
+
+	err = dataprovider.DeleteUser(user.Username, "", "", "")
+	assert.NoError(t, err)
+	err = dataprovider.DeleteGroup(group.Name, "", "", "")
+	assert.NoError(t, err)
+	for _, f := range []vfs.BaseVirtualFolder{folder1, folder2} {
+		err = dataprovider.DeleteFolder(f.Name, "", "", "")
+		assert.NoError(t, err)
+	}
+}
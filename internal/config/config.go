@@ -63,6 +63,7 @@ var (
 	defaultSFTPDBinding    = sftpd.Binding{
 		Address:          "",
 		Port:             2022,
+		Network:          "tcp",
 		ApplyProxyConfig: true,
 	}
 	defaultFTPDBinding = ftpd.Binding{
@@ -201,8 +202,9 @@ func Init() {
 	// create a default configuration to use if no config file is provided
 	globalConf = globalConfig{
 		Common: common.Configuration{
-			IdleTimeout: 15,
-			UploadMode:  0,
+			IdleTimeout:        15,
+			UploadMode:         0,
+			AtomicUploadPrefix: ".sftpgo-tmp",
 			Actions: common.ProtocolActions{
 				ExecuteOn:   []string{},
 				ExecuteSync: []string{},
@@ -240,6 +242,14 @@ func Init() {
 					PasswordFailed: 1000,
 				},
 			},
+			LoginFailures: common.LoginFailuresConfig{
+				Enabled:          false,
+				Threshold:        20,
+				ObservationTime:  30,
+				ElevatedTime:     60,
+				RequirePublicKey: false,
+				EntriesLimit:     10000,
+			},
 			RateLimitersConfig: []common.RateLimiterConfig{defaultRateLimiter},
 			Umask:              "",
 			ServerVersion:      "",
@@ -247,9 +257,93 @@ func Init() {
 			Metadata: common.MetadataConfig{
 				Read: 0,
 			},
+			ContentType: common.ContentTypeConfig{
+				Enabled: false,
+				Sniff:   false,
+			},
 			EventManager: common.EventManagerConfig{
 				EnabledCommands: []string{},
 			},
+			AuditLog: common.AuditLogConfig{
+				Enabled:       false,
+				FilePath:      "",
+				MaxSize:       10,
+				MaxBackups:    5,
+				MaxAge:        28,
+				Compress:      false,
+				BufferSize:    1024,
+				DropOnFailure: false,
+			},
+			EventStream: common.EventStreamConfig{
+				Enabled:    false,
+				FilePath:   "",
+				MaxSize:    10,
+				MaxBackups: 5,
+				MaxAge:     28,
+				Compress:   false,
+				BufferSize: 1024,
+			},
+			Tracing: common.TracingConfig{
+				Endpoint:      "",
+				URLPath:       "",
+				Insecure:      false,
+				Sampler:       "ratio",
+				SamplingRatio: 1,
+			},
+			FolderRetention: common.FolderRetentionConfig{
+				CheckInterval:   0,
+				MaxConcurrency:  2,
+				MaxOpsPerSecond: 50,
+			},
+			QuotaReconciliation: common.QuotaReconciliationConfig{
+				CheckInterval:    0,
+				FullScanInterval: 24,
+				MaxConcurrency:   2,
+			},
+			UploadScan: common.UploadScanConfig{
+				Hook:     "",
+				Patterns: nil,
+				FailOpen: false,
+			},
+			UploadContentFilter: common.UploadContentFilterConfig{
+				Patterns:    nil,
+				InspectSize: 512,
+				DeniedTypes: nil,
+			},
+			UploadChecksum: common.UploadChecksumConfig{
+				Patterns:       nil,
+				ObjectMetadata: false,
+			},
+			S3Transfers: common.S3TransfersConfig{
+				UploadPartSize:      0,
+				UploadConcurrency:   0,
+				DownloadPartSize:    0,
+				DownloadConcurrency: 0,
+			},
+			BufferPool: common.BufferPoolConfig{
+				MaxSize: 256,
+				MaxWait: 0,
+			},
+			MultipartCleanup: common.MultipartCleanupConfig{
+				CheckInterval:   0,
+				MaxAge:          24,
+				MaxOpsPerSecond: 10,
+			},
+			Listing: common.ListingConfig{
+				PageSize:       0,
+				MaxEntries:     0,
+				MaxEntriesMode: 0,
+				CacheTTL:       0,
+			},
+			OutboundProxy: common.OutboundProxyConfig{
+				URL:      "",
+				Username: "",
+				Password: "",
+			},
+			ReEncryption: common.ReEncryptionConfig{
+				MaxBandwidth: 0,
+			},
+			OutboundBindAddress: "",
 		},
 		ACME: acme.Configuration{
 			Email:      "",
@@ -271,7 +365,11 @@ func Init() {
 			Bindings:                          []sftpd.Binding{defaultSFTPDBinding},
 			MaxAuthTries:                      0,
 			HostKeys:                          []string{},
+			HostKeyPassphrases:                []string{},
+			HostKeyRSABits:                    3072,
+			HostKeyECDSACurve:                 "P-256",
 			HostCertificates:                  []string{},
+			HostCertificatesExpirationWarning: 15,
 			HostKeyAlgorithms:                 []string{},
 			KexAlgorithms:                     []string{},
 			MinDHGroupExchangeKeySize:         2048,
@@ -281,10 +379,31 @@ func Init() {
 			TrustedUserCAKeys:                 []string{},
 			RevokedUserCertsFile:              "",
 			LoginBannerFile:                   "",
+			MOTD: sftpd.MOTDConfig{
+				TemplateFile: "",
+				VirtualFile:  "",
+				MaxSize:      4096,
+			},
+			GeneratedFiles:     []common.GeneratedFile{},
+			ClientVersionRules: []sftpd.ClientVersionRule{},
+			Limits: sftpd.LimitsConfig{
+				MaxPacketLength: 262144,
+				MaxReadLength:   261120,
+				MaxWriteLength:  261120,
+				MaxOpenHandles:  256,
+			},
 			EnabledSSHCommands:                []string{},
 			KeyboardInteractiveAuthentication: true,
 			KeyboardInteractiveHook:           "",
 			PasswordAuthentication:            true,
+			UniformAuthFailures:               false,
+			OperationsLatencyMetrics:          true,
+			AuxiliaryFilesCheckInterval:       300,
+			SessionRecording: sftpd.SessionRecordingConfig{
+				PathTemplate: "",
+				MaxSize:      100,
+				FailOpen:     true,
+			},
 		},
 		FTPD: ftpd.Configuration{
 			Bindings:                 []ftpd.Binding{defaultFTPDBinding},
@@ -363,6 +482,26 @@ func Init() {
 			PostLoginScope:     0,
 			CheckPasswordHook:  "",
 			CheckPasswordScope: 0,
+			ExternalAuthCache: dataprovider.ExternalAuthCacheConfig{
+				Enabled: false,
+				TTL:     60,
+				MaxSize: 1000,
+			},
+			UserCache: dataprovider.UserCacheConfig{
+				Enabled: false,
+				TTL:     60,
+				MaxSize: 10000,
+			},
+			ReadReplicas: dataprovider.ReadReplicasConfig{
+				ConnectionStrings:   []string{},
+				HealthCheckInterval: 10,
+				AuthMaxLag:          5,
+				ListingsMaxLag:      30,
+			},
+			AccountLockout: dataprovider.AccountLockoutConfig{
+				MaxFailures: 0,
+				Duration:    15,
+			},
 			PasswordHashing: dataprovider.PasswordHashing{
 				Argon2Options: dataprovider.Argon2Options{
 					Memory:      65536,
@@ -382,6 +521,15 @@ func Init() {
 					MinEntropy: 0,
 				},
 			},
+			PasswordPolicy: dataprovider.PasswordPolicy{
+				MinLength:             0,
+				RequireUppercase:      false,
+				RequireLowercase:      false,
+				RequireDigit:          false,
+				RequireSpecial:        false,
+				BreachedPasswordsFile: "",
+				MaxAge:                0,
+			},
 			PasswordCaching:    true,
 			UpdateMode:         0,
 			DelayedQuotaUpdate: 0,
@@ -394,6 +542,19 @@ func Init() {
 				Proto: "http",
 			},
 			BackupsPath: "backups",
+			Archives: dataprovider.ArchiveConfig{
+				Folder:     "",
+				Passphrase: "",
+				Schedule:   "",
+			},
+			LoginEvents: dataprovider.LoginEventsConfig{
+				Enabled:        false,
+				RetentionHours: 720,
+			},
+			ChangeFeed: dataprovider.ChangeFeedConfig{
+				Enabled:        false,
+				RetentionHours: 720,
+			},
 		},
 		HTTPDConfig: httpd.Conf{
 			Bindings:              []httpd.Binding{defaultHTTPDBinding},
@@ -439,6 +600,7 @@ func Init() {
 			Certificates:   nil,
 			SkipTLSVerify:  false,
 			Headers:        nil,
+			Hooks:          nil,
 		},
 		CommandConfig: command.Config{
 			Timeout:  30,
@@ -454,6 +616,11 @@ func Init() {
 		},
 		MFAConfig: mfa.Config{
 			TOTP: []mfa.TOTPConfig{defaultTOTP},
+			Lockout: mfa.LockoutConfig{
+				MaxAttempts:            5,
+				Cooldown:               15,
+				GenerateDefenderEvents: false,
+			},
 		},
 		TelemetryConfig: telemetry.Conf{
 			BindPort:           0,
@@ -647,6 +814,10 @@ func getRedactedGlobalConf() globalConfig {
 	conf.Common.PostDisconnectHook = util.GetRedactedURL(conf.Common.PostDisconnectHook)
 	conf.Common.DataRetentionHook = util.GetRedactedURL(conf.Common.DataRetentionHook)
 	conf.SFTPD.KeyboardInteractiveHook = util.GetRedactedURL(conf.SFTPD.KeyboardInteractiveHook)
+	conf.SFTPD.HostKeyPassphrases = nil
+	for _, passphrase := range globalConf.SFTPD.HostKeyPassphrases {
+		conf.SFTPD.HostKeyPassphrases = append(conf.SFTPD.HostKeyPassphrases, getRedactedPassword(passphrase))
+	}
 	conf.HTTPDConfig.SigningPassphrase = getRedactedPassword(conf.HTTPDConfig.SigningPassphrase)
 	conf.HTTPDConfig.Setup.InstallationCode = getRedactedPassword(conf.HTTPDConfig.Setup.InstallationCode)
 	conf.ProviderConf.Password = getRedactedPassword(conf.ProviderConf.Password)
@@ -810,6 +981,16 @@ func resetInvalidConfigs() {
 		logger.Warn(logSender, "", "Non-fatal configuration error: %v", warn)
 		logger.WarnToConsole("Non-fatal configuration error: %v", warn)
 	}
+	for idx := range globalConf.SFTPD.Bindings {
+		binding := &globalConf.SFTPD.Bindings[idx]
+		if binding.ProxyProtocol < 0 || binding.ProxyProtocol > 2 {
+			warn := fmt.Sprintf("invalid proxy_protocol for SFTP binding %q, 0, 1 and 2 are supported, configured: %v "+
+				"reset proxy_protocol to 0", binding.GetAddress(), binding.ProxyProtocol)
+			binding.ProxyProtocol = 0
+			logger.Warn(logSender, "", "Non-fatal configuration error: %v", warn)
+			logger.WarnToConsole("Non-fatal configuration error: %v", warn)
+		}
+	}
 	if !isExternalAuthScopeValid() {
 		warn := fmt.Sprintf("invalid external_auth_scope: %v reset to 0", globalConf.ProviderConf.ExternalAuthScope)
 		globalConf.ProviderConf.ExternalAuthScope = 0
@@ -841,11 +1022,14 @@ func loadBindingsFromEnv() {
 		getRateLimitersFromEnv(idx)
 		getPluginsFromEnv(idx)
 		getSFTPDBindindFromEnv(idx)
+		getSFTPDGeneratedFilesFromEnv(idx)
+		getSFTPDClientVersionRulesFromEnv(idx)
 		getFTPDBindingFromEnv(idx)
 		getWebDAVDBindingFromEnv(idx)
 		getHTTPDBindingFromEnv(idx)
 		getHTTPClientCertificatesFromEnv(idx)
 		getHTTPClientHeadersFromEnv(idx)
+		getHTTPClientHooksFromEnv(idx)
 		getCommandConfigsFromEnv(idx)
 	}
 }
@@ -1102,6 +1286,48 @@ func getPluginsFromEnv(idx int) {
 	}
 }
 
+func getSFTPDBindingSocketOptionsFromEnv(idx int, binding *sftpd.Binding) bool {
+	isSet := false
+
+	tcpKeepAlive, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_SFTPD__BINDINGS__%v__TCP_KEEPALIVE", idx), 32)
+	if ok {
+		binding.TCPKeepAlive = int(tcpKeepAlive)
+		isSet = true
+	}
+
+	tcpKeepAliveInterval, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_SFTPD__BINDINGS__%v__TCP_KEEPALIVE_INTERVAL", idx), 32)
+	if ok {
+		binding.TCPKeepAliveInterval = int(tcpKeepAliveInterval)
+		isSet = true
+	}
+
+	tcpKeepAliveCount, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_SFTPD__BINDINGS__%v__TCP_KEEPALIVE_COUNT", idx), 32)
+	if ok {
+		binding.TCPKeepAliveCount = int(tcpKeepAliveCount)
+		isSet = true
+	}
+
+	tcpNoDelay, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_SFTPD__BINDINGS__%v__TCP_NODELAY", idx), 32)
+	if ok {
+		binding.TCPNoDelay = int(tcpNoDelay)
+		isSet = true
+	}
+
+	receiveBufferSize, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_SFTPD__BINDINGS__%v__RECEIVE_BUFFER_SIZE", idx), 32)
+	if ok {
+		binding.ReceiveBufferSize = int(receiveBufferSize)
+		isSet = true
+	}
+
+	sendBufferSize, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_SFTPD__BINDINGS__%v__SEND_BUFFER_SIZE", idx), 32)
+	if ok {
+		binding.SendBufferSize = int(sendBufferSize)
+		isSet = true
+	}
+
+	return isSet
+}
+
 func getSFTPDBindindFromEnv(idx int) {
 	binding := defaultSFTPDBinding
 	if len(globalConf.SFTPD.Bindings) > idx {
@@ -1122,12 +1348,28 @@ func getSFTPDBindindFromEnv(idx int) {
 		isSet = true
 	}
 
+	network, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_SFTPD__BINDINGS__%v__NETWORK", idx))
+	if ok {
+		binding.Network = network
+		isSet = true
+	}
+
 	applyProxyConfig, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_SFTPD__BINDINGS__%v__APPLY_PROXY_CONFIG", idx))
 	if ok {
 		binding.ApplyProxyConfig = applyProxyConfig
 		isSet = true
 	}
 
+	proxyProtocol, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_SFTPD__BINDINGS__%v__PROXY_PROTOCOL", idx), 32)
+	if ok {
+		binding.ProxyProtocol = int(proxyProtocol)
+		isSet = true
+	}
+
+	if getSFTPDBindingSocketOptionsFromEnv(idx, &binding) {
+		isSet = true
+	}
+
 	if isSet {
 		if len(globalConf.SFTPD.Bindings) > idx {
 			globalConf.SFTPD.Bindings[idx] = binding
@@ -1248,6 +1490,94 @@ func getFTPDBindingSecurityFromEnv(idx int, binding *ftpd.Binding) bool {
 	return isSet
 }
 
+func getSFTPDGeneratedFilesFromEnv(idx int) {
+	var generatedFile common.GeneratedFile
+	if len(globalConf.SFTPD.GeneratedFiles) > idx {
+		generatedFile = globalConf.SFTPD.GeneratedFiles[idx]
+	}
+
+	isSet := false
+
+	filePath, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_SFTPD__GENERATED_FILES__%v__PATH", idx))
+	if ok {
+		generatedFile.Path = filePath
+		isSet = true
+	}
+
+	generator, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_SFTPD__GENERATED_FILES__%v__GENERATOR", idx))
+	if ok {
+		generatedFile.Generator = generator
+		isSet = true
+	}
+
+	if isSet {
+		if len(globalConf.SFTPD.GeneratedFiles) > idx {
+			globalConf.SFTPD.GeneratedFiles[idx] = generatedFile
+		} else {
+			globalConf.SFTPD.GeneratedFiles = append(globalConf.SFTPD.GeneratedFiles, generatedFile)
+		}
+	}
+}
+
+func getSFTPDClientVersionRulesFromEnv(idx int) {
+	var rule sftpd.ClientVersionRule
+	if len(globalConf.SFTPD.ClientVersionRules) > idx {
+		rule = globalConf.SFTPD.ClientVersionRules[idx]
+	}
+
+	isSet := false
+
+	patterns, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_SFTPD__CLIENT_VERSION_RULES__%v__PATTERNS", idx))
+	if ok {
+		rule.Patterns = patterns
+		isSet = true
+	}
+
+	action, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_SFTPD__CLIENT_VERSION_RULES__%v__ACTION", idx))
+	if ok {
+		rule.Action = action
+		isSet = true
+	}
+
+	message, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_SFTPD__CLIENT_VERSION_RULES__%v__MESSAGE", idx))
+	if ok {
+		rule.Message = message
+		isSet = true
+	}
+
+	average, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_SFTPD__CLIENT_VERSION_RULES__%v__AVERAGE", idx), 64)
+	if ok {
+		rule.Average = average
+		isSet = true
+	}
+
+	period, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_SFTPD__CLIENT_VERSION_RULES__%v__PERIOD", idx), 64)
+	if ok {
+		rule.Period = period
+		isSet = true
+	}
+
+	burst, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_SFTPD__CLIENT_VERSION_RULES__%v__BURST", idx), 32)
+	if ok {
+		rule.Burst = int(burst)
+		isSet = true
+	}
+
+	generateEvents, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_SFTPD__CLIENT_VERSION_RULES__%v__GENERATE_DEFENDER_EVENTS", idx))
+	if ok {
+		rule.GenerateDefenderEvents = generateEvents
+		isSet = true
+	}
+
+	if isSet {
+		if len(globalConf.SFTPD.ClientVersionRules) > idx {
+			globalConf.SFTPD.ClientVersionRules[idx] = rule
+		} else {
+			globalConf.SFTPD.ClientVersionRules = append(globalConf.SFTPD.ClientVersionRules, rule)
+		}
+	}
+}
+
 func getFTPDBindingFromEnv(idx int) {
 	binding := getDefaultFTPDBinding(idx)
 	isSet := false
@@ -2016,6 +2346,37 @@ func getHTTPClientHeadersFromEnv(idx int) {
 	}
 }
 
+func getHTTPClientHooksFromEnv(idx int) {
+	hook := httpclient.Hook{}
+	if len(globalConf.HTTPConfig.Hooks) > idx {
+		hook = globalConf.HTTPConfig.Hooks[idx]
+	}
+
+	name, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_HTTP__HOOKS__%v__HOOK", idx))
+	if ok {
+		hook.Hook = name
+	}
+
+	timeout, ok := lookupFloatFromEnv(fmt.Sprintf("SFTPGO_HTTP__HOOKS__%v__TIMEOUT", idx))
+	if ok {
+		hook.Timeout = timeout
+	}
+
+	retryMax, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_HTTP__HOOKS__%v__RETRY_MAX", idx), 32)
+	if ok {
+		hookRetryMax := int(retryMax)
+		hook.RetryMax = &hookRetryMax
+	}
+
+	if hook.Hook != "" {
+		if len(globalConf.HTTPConfig.Hooks) > idx {
+			globalConf.HTTPConfig.Hooks[idx] = hook
+		} else {
+			globalConf.HTTPConfig.Hooks = append(globalConf.HTTPConfig.Hooks, hook)
+		}
+	}
+}
+
 func getCommandConfigsFromEnv(idx int) {
 	cfg := command.Command{}
 	if len(globalConf.CommandConfig.Commands) > idx {
@@ -2054,6 +2415,7 @@ func getCommandConfigsFromEnv(idx int) {
 func setViperDefaults() {
 	viper.SetDefault("common.idle_timeout", globalConf.Common.IdleTimeout)
 	viper.SetDefault("common.upload_mode", globalConf.Common.UploadMode)
+	viper.SetDefault("common.atomic_upload_prefix", globalConf.Common.AtomicUploadPrefix)
 	viper.SetDefault("common.actions.execute_on", globalConf.Common.Actions.ExecuteOn)
 	viper.SetDefault("common.actions.execute_sync", globalConf.Common.Actions.ExecuteSync)
 	viper.SetDefault("common.actions.hook", globalConf.Common.Actions.Hook)
@@ -2065,6 +2427,7 @@ func setViperDefaults() {
 	viper.SetDefault("common.proxy_allowed", globalConf.Common.ProxyAllowed)
 	viper.SetDefault("common.proxy_skipped", globalConf.Common.ProxySkipped)
 	viper.SetDefault("common.post_connect_hook", globalConf.Common.PostConnectHook)
+	viper.SetDefault("common.post_connect_hook_notify_handshake", globalConf.Common.PostConnectHookNotifyHandshake)
 	viper.SetDefault("common.post_disconnect_hook", globalConf.Common.PostDisconnectHook)
 	viper.SetDefault("common.data_retention_hook", globalConf.Common.DataRetentionHook)
 	viper.SetDefault("common.max_total_connections", globalConf.Common.MaxTotalConnections)
@@ -2085,11 +2448,71 @@ func setViperDefaults() {
 	viper.SetDefault("common.defender.entries_hard_limit", globalConf.Common.DefenderConfig.EntriesHardLimit)
 	viper.SetDefault("common.defender.login_delay.success", globalConf.Common.DefenderConfig.LoginDelay.Success)
 	viper.SetDefault("common.defender.login_delay.password_failed", globalConf.Common.DefenderConfig.LoginDelay.PasswordFailed)
+	viper.SetDefault("common.login_failures.enabled", globalConf.Common.LoginFailures.Enabled)
+	viper.SetDefault("common.login_failures.threshold", globalConf.Common.LoginFailures.Threshold)
+	viper.SetDefault("common.login_failures.observation_time", globalConf.Common.LoginFailures.ObservationTime)
+	viper.SetDefault("common.login_failures.elevated_time", globalConf.Common.LoginFailures.ElevatedTime)
+	viper.SetDefault("common.login_failures.require_public_key", globalConf.Common.LoginFailures.RequirePublicKey)
+	viper.SetDefault("common.login_failures.entries_limit", globalConf.Common.LoginFailures.EntriesLimit)
 	viper.SetDefault("common.umask", globalConf.Common.Umask)
 	viper.SetDefault("common.server_version", globalConf.Common.ServerVersion)
 	viper.SetDefault("common.tz", globalConf.Common.TZ)
 	viper.SetDefault("common.metadata.read", globalConf.Common.Metadata.Read)
+	viper.SetDefault("common.content_type.enabled", globalConf.Common.ContentType.Enabled)
+	viper.SetDefault("common.content_type.sniff", globalConf.Common.ContentType.Sniff)
 	viper.SetDefault("common.event_manager.enabled_commands", globalConf.Common.EventManager.EnabledCommands)
+	viper.SetDefault("common.audit_log.enabled", globalConf.Common.AuditLog.Enabled)
+	viper.SetDefault("common.audit_log.file_path", globalConf.Common.AuditLog.FilePath)
+	viper.SetDefault("common.audit_log.max_size", globalConf.Common.AuditLog.MaxSize)
+	viper.SetDefault("common.audit_log.max_backups", globalConf.Common.AuditLog.MaxBackups)
+	viper.SetDefault("common.audit_log.max_age", globalConf.Common.AuditLog.MaxAge)
+	viper.SetDefault("common.audit_log.compress", globalConf.Common.AuditLog.Compress)
+	viper.SetDefault("common.audit_log.buffer_size", globalConf.Common.AuditLog.BufferSize)
+	viper.SetDefault("common.audit_log.drop_on_failure", globalConf.Common.AuditLog.DropOnFailure)
+	viper.SetDefault("common.event_stream.enabled", globalConf.Common.EventStream.Enabled)
+	viper.SetDefault("common.event_stream.file_path", globalConf.Common.EventStream.FilePath)
+	viper.SetDefault("common.event_stream.max_size", globalConf.Common.EventStream.MaxSize)
+	viper.SetDefault("common.event_stream.max_backups", globalConf.Common.EventStream.MaxBackups)
+	viper.SetDefault("common.event_stream.max_age", globalConf.Common.EventStream.MaxAge)
+	viper.SetDefault("common.event_stream.compress", globalConf.Common.EventStream.Compress)
+	viper.SetDefault("common.event_stream.buffer_size", globalConf.Common.EventStream.BufferSize)
+	viper.SetDefault("common.tracing.endpoint", globalConf.Common.Tracing.Endpoint)
+	viper.SetDefault("common.tracing.url_path", globalConf.Common.Tracing.URLPath)
+	viper.SetDefault("common.tracing.insecure", globalConf.Common.Tracing.Insecure)
+	viper.SetDefault("common.tracing.sampler", globalConf.Common.Tracing.Sampler)
+	viper.SetDefault("common.tracing.sampling_ratio", globalConf.Common.Tracing.SamplingRatio)
+	viper.SetDefault("common.folder_retention.check_interval", globalConf.Common.FolderRetention.CheckInterval)
+	viper.SetDefault("common.folder_retention.max_concurrency", globalConf.Common.FolderRetention.MaxConcurrency)
+	viper.SetDefault("common.folder_retention.max_ops_per_second", globalConf.Common.FolderRetention.MaxOpsPerSecond)
+	viper.SetDefault("common.quota_reconciliation.check_interval", globalConf.Common.QuotaReconciliation.CheckInterval)
+	viper.SetDefault("common.quota_reconciliation.full_scan_interval", globalConf.Common.QuotaReconciliation.FullScanInterval)
+	viper.SetDefault("common.quota_reconciliation.max_concurrency", globalConf.Common.QuotaReconciliation.MaxConcurrency)
+	viper.SetDefault("common.upload_scan.hook", globalConf.Common.UploadScan.Hook)
+	viper.SetDefault("common.upload_scan.patterns", globalConf.Common.UploadScan.Patterns)
+	viper.SetDefault("common.upload_scan.fail_open", globalConf.Common.UploadScan.FailOpen)
+	viper.SetDefault("common.upload_content_filter.patterns", globalConf.Common.UploadContentFilter.Patterns)
+	viper.SetDefault("common.upload_content_filter.inspect_size", globalConf.Common.UploadContentFilter.InspectSize)
+	viper.SetDefault("common.upload_content_filter.denied_types", globalConf.Common.UploadContentFilter.DeniedTypes)
+	viper.SetDefault("common.upload_checksum.patterns", globalConf.Common.UploadChecksum.Patterns)
+	viper.SetDefault("common.upload_checksum.object_metadata", globalConf.Common.UploadChecksum.ObjectMetadata)
+	viper.SetDefault("common.s3_transfers.upload_part_size", globalConf.Common.S3Transfers.UploadPartSize)
+	viper.SetDefault("common.s3_transfers.upload_concurrency", globalConf.Common.S3Transfers.UploadConcurrency)
+	viper.SetDefault("common.s3_transfers.download_part_size", globalConf.Common.S3Transfers.DownloadPartSize)
+	viper.SetDefault("common.s3_transfers.download_concurrency", globalConf.Common.S3Transfers.DownloadConcurrency)
+	viper.SetDefault("common.buffer_pool.max_size", globalConf.Common.BufferPool.MaxSize)
+	viper.SetDefault("common.buffer_pool.max_wait", globalConf.Common.BufferPool.MaxWait)
+	viper.SetDefault("common.multipart_cleanup.check_interval", globalConf.Common.MultipartCleanup.CheckInterval)
+	viper.SetDefault("common.multipart_cleanup.max_age", globalConf.Common.MultipartCleanup.MaxAge)
+	viper.SetDefault("common.multipart_cleanup.max_ops_per_second", globalConf.Common.MultipartCleanup.MaxOpsPerSecond)
+	viper.SetDefault("common.listing.page_size", globalConf.Common.Listing.PageSize)
+	viper.SetDefault("common.listing.max_entries", globalConf.Common.Listing.MaxEntries)
+	viper.SetDefault("common.listing.max_entries_mode", globalConf.Common.Listing.MaxEntriesMode)
+	viper.SetDefault("common.listing.cache_ttl", globalConf.Common.Listing.CacheTTL)
+	viper.SetDefault("common.outbound_proxy.url", globalConf.Common.OutboundProxy.URL)
+	viper.SetDefault("common.outbound_proxy.username", globalConf.Common.OutboundProxy.Username)
+	viper.SetDefault("common.outbound_proxy.password", globalConf.Common.OutboundProxy.Password)
+	viper.SetDefault("common.reencryption.max_bandwidth", globalConf.Common.ReEncryption.MaxBandwidth)
+	viper.SetDefault("common.outbound_bind_address", globalConf.Common.OutboundBindAddress)
 	viper.SetDefault("acme.email", globalConf.ACME.Email)
 	viper.SetDefault("acme.key_type", globalConf.ACME.KeyType)
 	viper.SetDefault("acme.certs_path", globalConf.ACME.CertsPath)
@@ -2102,7 +2525,11 @@ func setViperDefaults() {
 	viper.SetDefault("acme.tls_alpn01_challenge.port", globalConf.ACME.TLSALPN01Challenge.Port)
 	viper.SetDefault("sftpd.max_auth_tries", globalConf.SFTPD.MaxAuthTries)
 	viper.SetDefault("sftpd.host_keys", globalConf.SFTPD.HostKeys)
+	viper.SetDefault("sftpd.host_key_passphrases", globalConf.SFTPD.HostKeyPassphrases)
+	viper.SetDefault("sftpd.host_key_rsa_bits", globalConf.SFTPD.HostKeyRSABits)
+	viper.SetDefault("sftpd.host_key_ecdsa_curve", globalConf.SFTPD.HostKeyECDSACurve)
 	viper.SetDefault("sftpd.host_certificates", globalConf.SFTPD.HostCertificates)
+	viper.SetDefault("sftpd.host_certificates_expiration_warning", globalConf.SFTPD.HostCertificatesExpirationWarning)
 	viper.SetDefault("sftpd.host_key_algorithms", globalConf.SFTPD.HostKeyAlgorithms)
 	viper.SetDefault("sftpd.kex_algorithms", globalConf.SFTPD.KexAlgorithms)
 	viper.SetDefault("sftpd.min_dh_group_exchange_key_size", globalConf.SFTPD.MinDHGroupExchangeKeySize)
@@ -2112,10 +2539,23 @@ func setViperDefaults() {
 	viper.SetDefault("sftpd.trusted_user_ca_keys", globalConf.SFTPD.TrustedUserCAKeys)
 	viper.SetDefault("sftpd.revoked_user_certs_file", globalConf.SFTPD.RevokedUserCertsFile)
 	viper.SetDefault("sftpd.login_banner_file", globalConf.SFTPD.LoginBannerFile)
+	viper.SetDefault("sftpd.motd.template_file", globalConf.SFTPD.MOTD.TemplateFile)
+	viper.SetDefault("sftpd.motd.virtual_file", globalConf.SFTPD.MOTD.VirtualFile)
+	viper.SetDefault("sftpd.motd.max_size", globalConf.SFTPD.MOTD.MaxSize)
+	viper.SetDefault("sftpd.limits.max_packet_length", globalConf.SFTPD.Limits.MaxPacketLength)
+	viper.SetDefault("sftpd.limits.max_read_length", globalConf.SFTPD.Limits.MaxReadLength)
+	viper.SetDefault("sftpd.limits.max_write_length", globalConf.SFTPD.Limits.MaxWriteLength)
+	viper.SetDefault("sftpd.limits.max_open_handles", globalConf.SFTPD.Limits.MaxOpenHandles)
 	viper.SetDefault("sftpd.enabled_ssh_commands", sftpd.GetDefaultSSHCommands())
 	viper.SetDefault("sftpd.keyboard_interactive_authentication", globalConf.SFTPD.KeyboardInteractiveAuthentication)
 	viper.SetDefault("sftpd.keyboard_interactive_auth_hook", globalConf.SFTPD.KeyboardInteractiveHook)
 	viper.SetDefault("sftpd.password_authentication", globalConf.SFTPD.PasswordAuthentication)
+	viper.SetDefault("sftpd.uniform_auth_failures", globalConf.SFTPD.UniformAuthFailures)
+	viper.SetDefault("sftpd.operations_latency_metrics", globalConf.SFTPD.OperationsLatencyMetrics)
+	viper.SetDefault("sftpd.session_recording.path_template", globalConf.SFTPD.SessionRecording.PathTemplate)
+	viper.SetDefault("sftpd.session_recording.max_size", globalConf.SFTPD.SessionRecording.MaxSize)
+	viper.SetDefault("sftpd.session_recording.fail_open", globalConf.SFTPD.SessionRecording.FailOpen)
+	viper.SetDefault("sftpd.auxiliary_files_check_interval", globalConf.SFTPD.AuxiliaryFilesCheckInterval)
 	viper.SetDefault("ftpd.banner_file", globalConf.FTPD.BannerFile)
 	viper.SetDefault("ftpd.active_transfers_port_non_20", globalConf.FTPD.ActiveTransfersPortNon20)
 	viper.SetDefault("ftpd.passive_port_range.start", globalConf.FTPD.PassivePortRange.Start)
@@ -2169,6 +2609,18 @@ func setViperDefaults() {
 	viper.SetDefault("data_provider.actions.hook", globalConf.ProviderConf.Actions.Hook)
 	viper.SetDefault("data_provider.external_auth_hook", globalConf.ProviderConf.ExternalAuthHook)
 	viper.SetDefault("data_provider.external_auth_scope", globalConf.ProviderConf.ExternalAuthScope)
+	viper.SetDefault("data_provider.external_auth_cache.enabled", globalConf.ProviderConf.ExternalAuthCache.Enabled)
+	viper.SetDefault("data_provider.external_auth_cache.ttl", globalConf.ProviderConf.ExternalAuthCache.TTL)
+	viper.SetDefault("data_provider.external_auth_cache.max_size", globalConf.ProviderConf.ExternalAuthCache.MaxSize)
+	viper.SetDefault("data_provider.user_cache.enabled", globalConf.ProviderConf.UserCache.Enabled)
+	viper.SetDefault("data_provider.user_cache.ttl", globalConf.ProviderConf.UserCache.TTL)
+	viper.SetDefault("data_provider.user_cache.max_size", globalConf.ProviderConf.UserCache.MaxSize)
+	viper.SetDefault("data_provider.read_replicas.connection_strings", globalConf.ProviderConf.ReadReplicas.ConnectionStrings)
+	viper.SetDefault("data_provider.read_replicas.health_check_interval", globalConf.ProviderConf.ReadReplicas.HealthCheckInterval)
+	viper.SetDefault("data_provider.read_replicas.auth_max_lag", globalConf.ProviderConf.ReadReplicas.AuthMaxLag)
+	viper.SetDefault("data_provider.read_replicas.listings_max_lag", globalConf.ProviderConf.ReadReplicas.ListingsMaxLag)
+	viper.SetDefault("data_provider.account_lockout.max_failures", globalConf.ProviderConf.AccountLockout.MaxFailures)
+	viper.SetDefault("data_provider.account_lockout.duration", globalConf.ProviderConf.AccountLockout.Duration)
 	viper.SetDefault("data_provider.pre_login_hook", globalConf.ProviderConf.PreLoginHook)
 	viper.SetDefault("data_provider.post_login_hook", globalConf.ProviderConf.PostLoginHook)
 	viper.SetDefault("data_provider.post_login_scope", globalConf.ProviderConf.PostLoginScope)
@@ -2181,6 +2633,13 @@ func setViperDefaults() {
 	viper.SetDefault("data_provider.password_hashing.algo", globalConf.ProviderConf.PasswordHashing.Algo)
 	viper.SetDefault("data_provider.password_validation.admins.min_entropy", globalConf.ProviderConf.PasswordValidation.Admins.MinEntropy)
 	viper.SetDefault("data_provider.password_validation.users.min_entropy", globalConf.ProviderConf.PasswordValidation.Users.MinEntropy)
+	viper.SetDefault("data_provider.password_policy.min_length", globalConf.ProviderConf.PasswordPolicy.MinLength)
+	viper.SetDefault("data_provider.password_policy.require_uppercase", globalConf.ProviderConf.PasswordPolicy.RequireUppercase)
+	viper.SetDefault("data_provider.password_policy.require_lowercase", globalConf.ProviderConf.PasswordPolicy.RequireLowercase)
+	viper.SetDefault("data_provider.password_policy.require_digit", globalConf.ProviderConf.PasswordPolicy.RequireDigit)
+	viper.SetDefault("data_provider.password_policy.require_special", globalConf.ProviderConf.PasswordPolicy.RequireSpecial)
+	viper.SetDefault("data_provider.password_policy.breached_passwords_file", globalConf.ProviderConf.PasswordPolicy.BreachedPasswordsFile)
+	viper.SetDefault("data_provider.password_policy.max_age", globalConf.ProviderConf.PasswordPolicy.MaxAge)
 	viper.SetDefault("data_provider.password_caching", globalConf.ProviderConf.PasswordCaching)
 	viper.SetDefault("data_provider.update_mode", globalConf.ProviderConf.UpdateMode)
 	viper.SetDefault("data_provider.delayed_quota_update", globalConf.ProviderConf.DelayedQuotaUpdate)
@@ -2191,6 +2650,13 @@ func setViperDefaults() {
 	viper.SetDefault("data_provider.node.port", globalConf.ProviderConf.Node.Port)
 	viper.SetDefault("data_provider.node.proto", globalConf.ProviderConf.Node.Proto)
 	viper.SetDefault("data_provider.backups_path", globalConf.ProviderConf.BackupsPath)
+	viper.SetDefault("data_provider.archives.folder", globalConf.ProviderConf.Archives.Folder)
+	viper.SetDefault("data_provider.archives.passphrase", globalConf.ProviderConf.Archives.Passphrase)
+	viper.SetDefault("data_provider.archives.schedule", globalConf.ProviderConf.Archives.Schedule)
+	viper.SetDefault("data_provider.login_events.enabled", globalConf.ProviderConf.LoginEvents.Enabled)
+	viper.SetDefault("data_provider.login_events.retention_hours", globalConf.ProviderConf.LoginEvents.RetentionHours)
+	viper.SetDefault("data_provider.change_feed.enabled", globalConf.ProviderConf.ChangeFeed.Enabled)
+	viper.SetDefault("data_provider.change_feed.retention_hours", globalConf.ProviderConf.ChangeFeed.RetentionHours)
 	viper.SetDefault("httpd.templates_path", globalConf.HTTPDConfig.TemplatesPath)
 	viper.SetDefault("httpd.static_files_path", globalConf.HTTPDConfig.StaticFilesPath)
 	viper.SetDefault("httpd.openapi_path", globalConf.HTTPDConfig.OpenAPIPath)
@@ -2230,6 +2696,9 @@ func setViperDefaults() {
 	viper.SetDefault("kms.secrets.url", globalConf.KMSConfig.Secrets.URL)
 	viper.SetDefault("kms.secrets.master_key", globalConf.KMSConfig.Secrets.MasterKeyString)
 	viper.SetDefault("kms.secrets.master_key_path", globalConf.KMSConfig.Secrets.MasterKeyPath)
+	viper.SetDefault("mfa.lockout.max_attempts", globalConf.MFAConfig.Lockout.MaxAttempts)
+	viper.SetDefault("mfa.lockout.cooldown", globalConf.MFAConfig.Lockout.Cooldown)
+	viper.SetDefault("mfa.lockout.generate_defender_events", globalConf.MFAConfig.Lockout.GenerateDefenderEvents)
 	viper.SetDefault("telemetry.bind_port", globalConf.TelemetryConfig.BindPort)
 	viper.SetDefault("telemetry.bind_address", globalConf.TelemetryConfig.BindAddress)
 	viper.SetDefault("telemetry.enable_profiler", globalConf.TelemetryConfig.EnableProfiler)
@@ -2274,6 +2743,18 @@ func lookupIntFromEnv(envName string, bitSize int) (int64, bool) {
 	return 0, false
 }
 
+func lookupFloatFromEnv(envName string) (float64, bool) {
+	value, ok := os.LookupEnv(envName)
+	if ok {
+		converted, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err == nil {
+			return converted, ok
+		}
+	}
+
+	return 0, false
+}
+
 func lookupStringListFromEnv(envName string) ([]string, bool) {
 	value, ok := os.LookupEnv(envName)
 	if ok {
@@ -17,10 +17,14 @@ package config_test
 import (
 	"crypto/rand"
 	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"slices"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/sftpgo/sdk/kms"
 	"github.com/spf13/viper"
@@ -87,6 +91,21 @@ func TestLoadConfigFileNotFound(t *testing.T) {
 	require.NoError(t, err)
 	mfaConf := config.GetMFAConfig()
 	require.Len(t, mfaConf.TOTP, 1)
+	require.Equal(t, 5, mfaConf.Lockout.MaxAttempts)
+	require.Equal(t, 15, mfaConf.Lockout.Cooldown)
+	require.False(t, config.GetProviderConf().ExternalAuthCache.Enabled)
+	require.Equal(t, 60, config.GetProviderConf().ExternalAuthCache.TTL)
+	require.Equal(t, 1000, config.GetProviderConf().ExternalAuthCache.MaxSize)
+	require.False(t, config.GetProviderConf().UserCache.Enabled)
+	require.Equal(t, 60, config.GetProviderConf().UserCache.TTL)
+	require.Equal(t, 10000, config.GetProviderConf().UserCache.MaxSize)
+	require.Len(t, config.GetProviderConf().ReadReplicas.ConnectionStrings, 0)
+	require.Equal(t, 10, config.GetProviderConf().ReadReplicas.HealthCheckInterval)
+	require.Equal(t, 5, config.GetProviderConf().ReadReplicas.AuthMaxLag)
+	require.Equal(t, 30, config.GetProviderConf().ReadReplicas.ListingsMaxLag)
+	require.Empty(t, config.GetProviderConf().Archives.Folder)
+	require.Empty(t, config.GetProviderConf().Archives.Passphrase)
+	require.Empty(t, config.GetProviderConf().Archives.Schedule)
 	require.Len(t, config.GetCommonConfig().RateLimitersConfig, 1)
 	require.Len(t, config.GetCommonConfig().RateLimitersConfig[0].Protocols, 4)
 	require.Len(t, config.GetHTTPDConfig().Bindings, 1)
@@ -364,7 +383,7 @@ func TestServiceToStart(t *testing.T) {
 	assert.NoError(t, err)
 	assert.True(t, config.HasServicesToStart())
 	sftpdConf := config.GetSFTPDConfig()
-	sftpdConf.Bindings[0].Port = 0
+	sftpdConf.Bindings[0].Port = -1
 	config.SetSFTPDConfig(sftpdConf)
 	// httpd service is enabled
 	assert.True(t, config.HasServicesToStart())
@@ -846,14 +865,32 @@ func TestSFTPDBindingsFromEnv(t *testing.T) {
 	os.Setenv("SFTPGO_SFTPD__BINDINGS__0__ADDRESS", "127.0.0.1")
 	os.Setenv("SFTPGO_SFTPD__BINDINGS__0__PORT", "2200")
 	os.Setenv("SFTPGO_SFTPD__BINDINGS__0__APPLY_PROXY_CONFIG", "false")
+	os.Setenv("SFTPGO_SFTPD__BINDINGS__0__PROXY_PROTOCOL", "2")
 	os.Setenv("SFTPGO_SFTPD__BINDINGS__3__ADDRESS", "127.0.1.1")
 	os.Setenv("SFTPGO_SFTPD__BINDINGS__3__PORT", "2203")
+	os.Setenv("SFTPGO_SFTPD__BINDINGS__3__PROXY_PROTOCOL", "3")
+	os.Setenv("SFTPGO_SFTPD__BINDINGS__3__NETWORK", "tcp6")
+	os.Setenv("SFTPGO_SFTPD__BINDINGS__3__TCP_KEEPALIVE", "1")
+	os.Setenv("SFTPGO_SFTPD__BINDINGS__3__TCP_KEEPALIVE_INTERVAL", "60")
+	os.Setenv("SFTPGO_SFTPD__BINDINGS__3__TCP_KEEPALIVE_COUNT", "5")
+	os.Setenv("SFTPGO_SFTPD__BINDINGS__3__TCP_NODELAY", "2")
+	os.Setenv("SFTPGO_SFTPD__BINDINGS__3__RECEIVE_BUFFER_SIZE", "262144")
+	os.Setenv("SFTPGO_SFTPD__BINDINGS__3__SEND_BUFFER_SIZE", "131072")
 	t.Cleanup(func() {
 		os.Unsetenv("SFTPGO_SFTPD__BINDINGS__0__ADDRESS")
 		os.Unsetenv("SFTPGO_SFTPD__BINDINGS__0__PORT")
 		os.Unsetenv("SFTPGO_SFTPD__BINDINGS__0__APPLY_PROXY_CONFIG")
+		os.Unsetenv("SFTPGO_SFTPD__BINDINGS__0__PROXY_PROTOCOL")
 		os.Unsetenv("SFTPGO_SFTPD__BINDINGS__3__ADDRESS")
 		os.Unsetenv("SFTPGO_SFTPD__BINDINGS__3__PORT")
+		os.Unsetenv("SFTPGO_SFTPD__BINDINGS__3__PROXY_PROTOCOL")
+		os.Unsetenv("SFTPGO_SFTPD__BINDINGS__3__NETWORK")
+		os.Unsetenv("SFTPGO_SFTPD__BINDINGS__3__TCP_KEEPALIVE")
+		os.Unsetenv("SFTPGO_SFTPD__BINDINGS__3__TCP_KEEPALIVE_INTERVAL")
+		os.Unsetenv("SFTPGO_SFTPD__BINDINGS__3__TCP_KEEPALIVE_COUNT")
+		os.Unsetenv("SFTPGO_SFTPD__BINDINGS__3__TCP_NODELAY")
+		os.Unsetenv("SFTPGO_SFTPD__BINDINGS__3__RECEIVE_BUFFER_SIZE")
+		os.Unsetenv("SFTPGO_SFTPD__BINDINGS__3__SEND_BUFFER_SIZE")
 	})
 
 	err := config.LoadConfig(configDir, "")
@@ -863,9 +900,85 @@ func TestSFTPDBindingsFromEnv(t *testing.T) {
 	require.Equal(t, 2200, bindings[0].Port)
 	require.Equal(t, "127.0.0.1", bindings[0].Address)
 	require.False(t, bindings[0].ApplyProxyConfig)
+	require.Equal(t, 2, bindings[0].ProxyProtocol)
+	require.Equal(t, 0, bindings[0].GetProxyProtocol())
 	require.Equal(t, 2203, bindings[1].Port)
 	require.Equal(t, "127.0.1.1", bindings[1].Address)
-	require.True(t, bindings[1].ApplyProxyConfig) // default value
+	require.True(t, bindings[1].ApplyProxyConfig)  // default value
+	require.Equal(t, 0, bindings[1].ProxyProtocol) // invalid values are reset
+	require.Equal(t, "tcp6", bindings[1].Network)
+	require.Equal(t, "tcp", bindings[0].Network) // default value
+	require.Equal(t, 1, bindings[1].TCPKeepAlive)
+	require.Equal(t, 60, bindings[1].TCPKeepAliveInterval)
+	require.Equal(t, 5, bindings[1].TCPKeepAliveCount)
+	require.Equal(t, 2, bindings[1].TCPNoDelay)
+	require.Equal(t, 262144, bindings[1].ReceiveBufferSize)
+	require.Equal(t, 131072, bindings[1].SendBufferSize)
+	require.Equal(t, 0, bindings[0].TCPKeepAlive)
+}
+
+func TestSFTPDGeneratedFilesFromEnv(t *testing.T) {
+	reset()
+
+	os.Setenv("SFTPGO_SFTPD__GENERATED_FILES__0__PATH", "/MANIFEST.json")
+	os.Setenv("SFTPGO_SFTPD__GENERATED_FILES__0__GENERATOR", "manifest")
+	os.Setenv("SFTPGO_SFTPD__GENERATED_FILES__2__PATH", "/quota.json")
+	os.Setenv("SFTPGO_SFTPD__GENERATED_FILES__2__GENERATOR", "quota_report")
+	t.Cleanup(func() {
+		os.Unsetenv("SFTPGO_SFTPD__GENERATED_FILES__0__PATH")
+		os.Unsetenv("SFTPGO_SFTPD__GENERATED_FILES__0__GENERATOR")
+		os.Unsetenv("SFTPGO_SFTPD__GENERATED_FILES__2__PATH")
+		os.Unsetenv("SFTPGO_SFTPD__GENERATED_FILES__2__GENERATOR")
+	})
+
+	err := config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	generatedFiles := config.GetSFTPDConfig().GeneratedFiles
+	require.Len(t, generatedFiles, 2)
+	require.Equal(t, "/MANIFEST.json", generatedFiles[0].Path)
+	require.Equal(t, "manifest", generatedFiles[0].Generator)
+	require.Equal(t, "/quota.json", generatedFiles[1].Path)
+	require.Equal(t, "quota_report", generatedFiles[1].Generator)
+}
+
+func TestSFTPDClientVersionRulesFromEnv(t *testing.T) {
+	reset()
+
+	os.Setenv("SFTPGO_SFTPD__CLIENT_VERSION_RULES__0__PATTERNS", "SSH-2.0-BadClient*, regexp:^SSH-2\\.0-libssh")
+	os.Setenv("SFTPGO_SFTPD__CLIENT_VERSION_RULES__0__ACTION", "deny")
+	os.Setenv("SFTPGO_SFTPD__CLIENT_VERSION_RULES__0__MESSAGE", "not allowed")
+	os.Setenv("SFTPGO_SFTPD__CLIENT_VERSION_RULES__0__GENERATE_DEFENDER_EVENTS", "true")
+	os.Setenv("SFTPGO_SFTPD__CLIENT_VERSION_RULES__1__PATTERNS", "SSH-2.0-Slow*")
+	os.Setenv("SFTPGO_SFTPD__CLIENT_VERSION_RULES__1__ACTION", "throttle")
+	os.Setenv("SFTPGO_SFTPD__CLIENT_VERSION_RULES__1__AVERAGE", "2")
+	os.Setenv("SFTPGO_SFTPD__CLIENT_VERSION_RULES__1__PERIOD", "60000")
+	os.Setenv("SFTPGO_SFTPD__CLIENT_VERSION_RULES__1__BURST", "3")
+	t.Cleanup(func() {
+		os.Unsetenv("SFTPGO_SFTPD__CLIENT_VERSION_RULES__0__PATTERNS")
+		os.Unsetenv("SFTPGO_SFTPD__CLIENT_VERSION_RULES__0__ACTION")
+		os.Unsetenv("SFTPGO_SFTPD__CLIENT_VERSION_RULES__0__MESSAGE")
+		os.Unsetenv("SFTPGO_SFTPD__CLIENT_VERSION_RULES__0__GENERATE_DEFENDER_EVENTS")
+		os.Unsetenv("SFTPGO_SFTPD__CLIENT_VERSION_RULES__1__PATTERNS")
+		os.Unsetenv("SFTPGO_SFTPD__CLIENT_VERSION_RULES__1__ACTION")
+		os.Unsetenv("SFTPGO_SFTPD__CLIENT_VERSION_RULES__1__AVERAGE")
+		os.Unsetenv("SFTPGO_SFTPD__CLIENT_VERSION_RULES__1__PERIOD")
+		os.Unsetenv("SFTPGO_SFTPD__CLIENT_VERSION_RULES__1__BURST")
+	})
+
+	err := config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	rules := config.GetSFTPDConfig().ClientVersionRules
+	require.Len(t, rules, 2)
+	require.Equal(t, []string{"SSH-2.0-BadClient*", `regexp:^SSH-2\.0-libssh`}, rules[0].Patterns)
+	require.Equal(t, "deny", rules[0].Action)
+	require.Equal(t, "not allowed", rules[0].Message)
+	require.True(t, rules[0].GenerateDefenderEvents)
+	require.Equal(t, []string{"SSH-2.0-Slow*"}, rules[1].Patterns)
+	require.Equal(t, "throttle", rules[1].Action)
+	require.Equal(t, int64(2), rules[1].Average)
+	require.Equal(t, int64(60000), rules[1].Period)
+	require.Equal(t, 3, rules[1].Burst)
+	require.False(t, rules[1].GenerateDefenderEvents)
 }
 
 func TestCommandsFromEnv(t *testing.T) {
@@ -1587,6 +1700,102 @@ func TestHTTPClientHeadersFromEnv(t *testing.T) {
 	require.Equal(t, "url9", config.GetHTTPConfig().Headers[1].URL)
 }
 
+func TestHTTPClientHooksFromEnv(t *testing.T) {
+	reset()
+
+	os.Setenv("SFTPGO_HTTP__HOOKS__0__HOOK", "external_auth")
+	os.Setenv("SFTPGO_HTTP__HOOKS__0__TIMEOUT", "2.5")
+	os.Setenv("SFTPGO_HTTP__HOOKS__1__HOOK", "post_login")
+	os.Setenv("SFTPGO_HTTP__HOOKS__1__RETRY_MAX", "5")
+	os.Setenv("SFTPGO_HTTP__HOOKS__2__TIMEOUT", "3")
+
+	t.Cleanup(func() {
+		os.Unsetenv("SFTPGO_HTTP__HOOKS__0__HOOK")
+		os.Unsetenv("SFTPGO_HTTP__HOOKS__0__TIMEOUT")
+		os.Unsetenv("SFTPGO_HTTP__HOOKS__1__HOOK")
+		os.Unsetenv("SFTPGO_HTTP__HOOKS__1__RETRY_MAX")
+		os.Unsetenv("SFTPGO_HTTP__HOOKS__2__TIMEOUT")
+	})
+
+	err := config.LoadConfig(configDir, "")
+	require.NoError(t, err)
+	httpConf := config.GetHTTPConfig()
+	require.Len(t, httpConf.Hooks, 2)
+	require.Equal(t, "external_auth", httpConf.Hooks[0].Hook)
+	require.Equal(t, 2.5, httpConf.Hooks[0].Timeout)
+	require.Nil(t, httpConf.Hooks[0].RetryMax)
+	require.Equal(t, "post_login", httpConf.Hooks[1].Hook)
+	require.Equal(t, float64(0), httpConf.Hooks[1].Timeout)
+	require.NotNil(t, httpConf.Hooks[1].RetryMax)
+	require.Equal(t, 5, *httpConf.Hooks[1].RetryMax)
+	err = httpConf.Initialize(configDir)
+	require.NoError(t, err)
+	require.Equal(t, 2500*time.Millisecond, httpclient.GetHookTimeout("external_auth"))
+	require.Equal(t, time.Duration(httpConf.Timeout*float64(time.Second)), httpclient.GetHookTimeout("post_login"))
+
+	httpConf.Hooks[1].Hook = "unknown"
+	err = httpConf.Initialize(configDir)
+	require.Error(t, err)
+	httpConf.Hooks[1].Hook = "post_login"
+	retryMax := -1
+	httpConf.Hooks[1].RetryMax = &retryMax
+	err = httpConf.Initialize(configDir)
+	require.Error(t, err)
+	retryMax = 0
+	httpConf.Hooks[0].Timeout = -1
+	err = httpConf.Initialize(configDir)
+	require.Error(t, err)
+}
+
+func TestHTTPClientHookRetryMax(t *testing.T) {
+	reset()
+
+	os.Setenv("SFTPGO_HTTP__RETRY_WAIT_MIN", "0")
+	os.Setenv("SFTPGO_HTTP__RETRY_WAIT_MAX", "0")
+	os.Setenv("SFTPGO_HTTP__RETRY_MAX", "2")
+	os.Setenv("SFTPGO_HTTP__HOOKS__0__HOOK", "post_login")
+	os.Setenv("SFTPGO_HTTP__HOOKS__0__RETRY_MAX", "0")
+	os.Setenv("SFTPGO_HTTP__HOOKS__1__HOOK", "post_connect")
+	os.Setenv("SFTPGO_HTTP__HOOKS__1__TIMEOUT", "5")
+
+	t.Cleanup(func() {
+		os.Unsetenv("SFTPGO_HTTP__RETRY_WAIT_MIN")
+		os.Unsetenv("SFTPGO_HTTP__RETRY_WAIT_MAX")
+		os.Unsetenv("SFTPGO_HTTP__RETRY_MAX")
+		os.Unsetenv("SFTPGO_HTTP__HOOKS__0__HOOK")
+		os.Unsetenv("SFTPGO_HTTP__HOOKS__0__RETRY_MAX")
+		os.Unsetenv("SFTPGO_HTTP__HOOKS__1__HOOK")
+		os.Unsetenv("SFTPGO_HTTP__HOOKS__1__TIMEOUT")
+	})
+
+	err := config.LoadConfig(configDir, "")
+	require.NoError(t, err)
+	httpConf := config.GetHTTPConfig()
+	require.Len(t, httpConf.Hooks, 2)
+	// an explicit 0 disables the retries
+	require.NotNil(t, httpConf.Hooks[0].RetryMax)
+	require.Equal(t, 0, *httpConf.Hooks[0].RetryMax)
+	require.Nil(t, httpConf.Hooks[1].RetryMax)
+	err = httpConf.Initialize(configDir)
+	require.NoError(t, err)
+
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	_, err = httpclient.RetryablePostForHook("post_login", server.URL, "application/json", nil)
+	assert.Error(t, err)
+	assert.Equal(t, int32(1), requests.Load())
+	// an unset value inherits the global retry max
+	requests.Store(0)
+	_, err = httpclient.RetryablePostForHook("post_connect", server.URL, "application/json", nil)
+	assert.Error(t, err)
+	assert.Equal(t, int32(3), requests.Load())
+}
+
 func TestConfigFromEnv(t *testing.T) {
 	reset()
 
@@ -0,0 +1,229 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"errors"
+	"time"
+
+	"github.com/sftpgo/sdk"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	// the consecutive login failures are forgotten after this time without failures
+	accountLockoutFailuresTTL = 24 * time.Hour
+)
+
+var (
+	// ErrAccountLocked is returned for any authentication attempt while the account is locked
+	ErrAccountLocked           = errors.New("account locked, too many consecutive login failures")
+	memoryAccountLockoutStates = newMemoryFailedAttemptsStore()
+)
+
+// Account lockout state changes
+const (
+	AccountLockoutUnchanged = iota
+	// The account was locked after a failed login
+	AccountLockoutLocked
+	// The lockout for the account expired
+	AccountLockoutExpired
+)
+
+// AccountLockoutConfig defines the lockout for the protocol users after
+// repeated login failures. Unlike the defender the failures are counted per
+// account, regardless of the source IP. In shared mode the lockout state is
+// stored within the data provider and so it is shared between all the instances
+type AccountLockoutConfig struct {
+	// Number of consecutive login failures after which the account is locked.
+	// A successful login resets the counter, the failures are forgotten after
+	// 24 hours without further failures. 0 means disabled
+	MaxFailures int `json:"max_failures" mapstructure:"max_failures"`
+	// Lockout duration as minutes
+	Duration int `json:"duration" mapstructure:"duration"`
+}
+
+// IsEnabled returns true if the account lockout is enabled
+func (c *AccountLockoutConfig) IsEnabled() bool {
+	return c.MaxFailures > 0
+}
+
+func (c *AccountLockoutConfig) validate() error {
+	if !c.IsEnabled() {
+		return nil
+	}
+	if c.Duration <= 0 {
+		return util.NewValidationError("account lockout duration must be greater than 0")
+	}
+	return nil
+}
+
+func (c *AccountLockoutConfig) getDuration() time.Duration {
+	return time.Duration(c.Duration) * time.Minute
+}
+
+// UserLockout defines the lockout state for a user
+type UserLockout struct {
+	// Consecutive login failures
+	Failures int `json:"failures"`
+	// Lockout expiration as unix timestamp in milliseconds, 0 if the account is not locked
+	LockedUntil int64 `json:"locked_until,omitempty"`
+}
+
+func getAccountLockoutStore() failedAttemptsStore {
+	if config.IsShared == 1 {
+		return &dbFailedAttemptsStore{sessionType: SessionTypeAccountLockout}
+	}
+	return memoryAccountLockoutStates
+}
+
+func getAccountLockoutKey(username string) string {
+	return "lockout_" + username
+}
+
+func newAccountLockoutError(username string) (User, error) {
+	return User{
+		BaseUser: sdk.BaseUser{
+			Username: username,
+		},
+	}, ErrAccountLocked
+}
+
+// IsAccountLockoutEnabled returns true if the account lockout is enabled
+func IsAccountLockoutEnabled() bool {
+	return config.AccountLockout.IsEnabled()
+}
+
+// CheckAccountLockout returns ErrAccountLocked if the account with the
+// specified username is locked
+func CheckAccountLockout(username string) error {
+	if !config.AccountLockout.IsEnabled() {
+		return nil
+	}
+	attempts, ok := getAccountLockoutStore().get(getAccountLockoutKey(username))
+	if ok && attempts.isLocked(util.GetTimeAsMsSinceEpoch(time.Now())) {
+		providerLog(logger.LevelDebug, "login not allowed for locked account %q, locked until %s",
+			username, util.GetTimeFromMsecSinceEpoch(attempts.LockedUntil).UTC().Format(time.RFC3339))
+		return ErrAccountLocked
+	}
+	return nil
+}
+
+// GetAccountLockout returns the lockout state for the account with the
+// specified username, nil means no recent login failures
+func GetAccountLockout(username string) *UserLockout {
+	if !config.AccountLockout.IsEnabled() {
+		return nil
+	}
+	attempts, ok := getAccountLockoutStore().get(getAccountLockoutKey(username))
+	if !ok {
+		return nil
+	}
+	lockout := &UserLockout{
+		Failures: attempts.Failures,
+	}
+	if attempts.isLocked(util.GetTimeAsMsSinceEpoch(time.Now())) {
+		lockout.LockedUntil = attempts.LockedUntil
+	}
+	if lockout.Failures == 0 && lockout.LockedUntil == 0 {
+		return nil
+	}
+	return lockout
+}
+
+// UpdateAccountLockout records the result of a login for the account with the
+// specified username and returns the lockout state change, if any. A failure
+// locks the account after the configured consecutive failures, a success
+// resets the counter
+func UpdateAccountLockout(username string, success bool) int {
+	if !config.AccountLockout.IsEnabled() {
+		return AccountLockoutUnchanged
+	}
+	store := getAccountLockoutStore()
+	key := getAccountLockoutKey(username)
+	if success {
+		attempts, ok := store.get(key)
+		if !ok || attempts.isLocked(util.GetTimeAsMsSinceEpoch(time.Now())) {
+			return AccountLockoutUnchanged
+		}
+		store.remove(key)
+		if attempts.LockedUntil > 0 {
+			providerLog(logger.LevelInfo, "lockout expired for account %q", username)
+			return AccountLockoutExpired
+		}
+		return AccountLockoutUnchanged
+	}
+	result := AccountLockoutUnchanged
+	var lockedUntil int64
+	err := store.update(key, func(attempts failedAttempts, _ bool) (failedAttempts, int64, bool) {
+		now := util.GetTimeAsMsSinceEpoch(time.Now())
+		result = AccountLockoutUnchanged
+		if attempts.isLocked(now) {
+			return attempts, 0, false
+		}
+		if attempts.LockedUntil > 0 {
+			attempts.LockedUntil = 0
+			result = AccountLockoutExpired
+		}
+		attempts.Failures++
+		attempts.UpdatedAt = now
+		if attempts.Failures >= config.AccountLockout.MaxFailures {
+			attempts.Failures = 0
+			attempts.LockedUntil = now + config.AccountLockout.getDuration().Milliseconds()
+			lockedUntil = attempts.LockedUntil
+			result = AccountLockoutLocked
+		}
+		// the entry is kept after the lockout expiration so the expiration can be detected
+		return attempts, attempts.getExpiration(accountLockoutFailuresTTL), true
+	})
+	if err != nil {
+		providerLog(logger.LevelError, "unable to update the lockout state for account %q: %v", username, err)
+		return AccountLockoutUnchanged
+	}
+	switch result {
+	case AccountLockoutExpired:
+		providerLog(logger.LevelInfo, "lockout expired for account %q", username)
+	case AccountLockoutLocked:
+		providerLog(logger.LevelInfo, "account %q locked until %s, consecutive login failures: %d",
+			username, util.GetTimeFromMsecSinceEpoch(lockedUntil).UTC().Format(time.RFC3339),
+			config.AccountLockout.MaxFailures)
+	}
+	return result
+}
+
+// UnlockAccount removes the lockout state for the account with the specified
+// username and returns true if the account was locked or had login failures
+func UnlockAccount(username string) bool {
+	if !config.AccountLockout.IsEnabled() {
+		return false
+	}
+	lockout := GetAccountLockout(username)
+	if lockout == nil {
+		return false
+	}
+	getAccountLockoutStore().remove(getAccountLockoutKey(username))
+	providerLog(logger.LevelInfo, "lockout state cleared for account %q, failures: %d, locked until: %d",
+		username, lockout.Failures, lockout.LockedUntil)
+	return true
+}
+
+func cleanupAccountLockout() {
+	if !config.AccountLockout.IsEnabled() {
+		return
+	}
+	getAccountLockoutStore().cleanup()
+}
@@ -43,17 +43,18 @@ const (
 )
 
 const (
-	actionObjectUser        = "user"
-	actionObjectFolder      = "folder"
-	actionObjectGroup       = "group"
-	actionObjectAdmin       = "admin"
-	actionObjectAPIKey      = "api_key"
-	actionObjectShare       = "share"
-	actionObjectEventAction = "event_action"
-	actionObjectEventRule   = "event_rule"
-	actionObjectRole        = "role"
-	actionObjectIPListEntry = "ip_list_entry"
-	actionObjectConfigs     = "configs"
+	actionObjectUser         = "user"
+	actionObjectFolder       = "folder"
+	actionObjectGroup        = "group"
+	actionObjectAdmin        = "admin"
+	actionObjectAPIKey       = "api_key"
+	actionObjectShare        = "share"
+	actionObjectEventAction  = "event_action"
+	actionObjectEventRule    = "event_rule"
+	actionObjectRole         = "role"
+	actionObjectIPListEntry  = "ip_list_entry"
+	actionObjectConfigs      = "configs"
+	actionObjectUserTemplate = "user_template"
 )
 
 var (
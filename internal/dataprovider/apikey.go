@@ -17,6 +17,7 @@ package dataprovider
 import (
 	"encoding/json"
 	"fmt"
+	"slices"
 	"strings"
 	"time"
 
@@ -36,6 +37,35 @@ const (
 	APIKeyScopeAdmin APIKeyScope = iota + 1
 	// the API key will be used for a user
 	APIKeyScopeUser
+	// the API key will be used for machine provisioning, it is not related to
+	// any admin and it is restricted to the granted permissions
+	APIKeyScopeMachine
+)
+
+// Supported permissions for API keys with machine scope
+const (
+	APIKeyPermUsersCreate   = "users:create"
+	APIKeyPermUsersRead     = "users:read"
+	APIKeyPermUsersUpdate   = "users:update"
+	APIKeyPermUsersDelete   = "users:delete"
+	APIKeyPermFoldersCreate = "folders:create"
+	APIKeyPermFoldersRead   = "folders:read"
+	APIKeyPermFoldersUpdate = "folders:update"
+	APIKeyPermFoldersDelete = "folders:delete"
+	APIKeyPermGroupsCreate  = "groups:create"
+	APIKeyPermGroupsRead    = "groups:read"
+	APIKeyPermGroupsUpdate  = "groups:update"
+	APIKeyPermGroupsDelete  = "groups:delete"
+)
+
+const (
+	apiKeyActorPrefix = "api_key:"
+)
+
+var (
+	validAPIKeyPerms = []string{APIKeyPermUsersCreate, APIKeyPermUsersRead, APIKeyPermUsersUpdate, APIKeyPermUsersDelete,
+		APIKeyPermFoldersCreate, APIKeyPermFoldersRead, APIKeyPermFoldersUpdate, APIKeyPermFoldersDelete,
+		APIKeyPermGroupsCreate, APIKeyPermGroupsRead, APIKeyPermGroupsUpdate, APIKeyPermGroupsDelete}
 )
 
 // APIKey defines a SFTPGo API key.
@@ -66,6 +96,11 @@ type APIKey struct {
 	// Admin username associated with this API key.
 	// If empty and the scope is APIKeyScopeAdmin the key is valid for any admin
 	Admin string `json:"admin,omitempty"`
+	// Permissions granted to an API key with machine scope
+	Permissions []string `json:"permissions,omitempty"`
+	// If set, an API key with machine scope can only manage the users
+	// whose username starts with this prefix and can only read groups and folders
+	UsernamePrefix string `json:"username_prefix,omitempty"`
 	// these fields are for internal use
 	userID   int64
 	adminID  int64
@@ -74,20 +109,22 @@ type APIKey struct {
 
 func (k *APIKey) getACopy() APIKey {
 	return APIKey{
-		ID:          k.ID,
-		KeyID:       k.KeyID,
-		Name:        k.Name,
-		Key:         k.Key,
-		Scope:       k.Scope,
-		CreatedAt:   k.CreatedAt,
-		UpdatedAt:   k.UpdatedAt,
-		LastUseAt:   k.LastUseAt,
-		ExpiresAt:   k.ExpiresAt,
-		Description: k.Description,
-		User:        k.User,
-		Admin:       k.Admin,
-		userID:      k.userID,
-		adminID:     k.adminID,
+		ID:             k.ID,
+		KeyID:          k.KeyID,
+		Name:           k.Name,
+		Key:            k.Key,
+		Scope:          k.Scope,
+		CreatedAt:      k.CreatedAt,
+		UpdatedAt:      k.UpdatedAt,
+		LastUseAt:      k.LastUseAt,
+		ExpiresAt:      k.ExpiresAt,
+		Description:    k.Description,
+		User:           k.User,
+		Admin:          k.Admin,
+		Permissions:    slices.Clone(k.Permissions),
+		UsernamePrefix: k.UsernamePrefix,
+		userID:         k.userID,
+		adminID:        k.adminID,
 	}
 }
 
@@ -139,6 +176,12 @@ func (k *APIKey) generateKey() {
 	k.plainKey = k.Key
 }
 
+// GetActor returns the name used to record the operations executed using
+// this API key, for example in the change feed and in the event logs
+func (k *APIKey) GetActor() string {
+	return apiKeyActorPrefix + k.KeyID
+}
+
 // DisplayKey returns the key to show to the user
 func (k *APIKey) DisplayKey() string {
 	return fmt.Sprintf("%v.%v", k.KeyID, k.plainKey)
@@ -148,9 +191,12 @@ func (k *APIKey) validate() error {
 	if k.Name == "" {
 		return util.NewValidationError("name is mandatory")
 	}
-	if k.Scope != APIKeyScopeAdmin && k.Scope != APIKeyScopeUser {
+	if k.Scope != APIKeyScopeAdmin && k.Scope != APIKeyScopeUser && k.Scope != APIKeyScopeMachine {
 		return util.NewValidationError(fmt.Sprintf("invalid scope: %v", k.Scope))
 	}
+	if err := k.validatePermissions(); err != nil {
+		return err
+	}
 	k.generateKey()
 	if err := k.hashKey(); err != nil {
 		return err
@@ -164,6 +210,10 @@ func (k *APIKey) validate() error {
 	if k.Scope == APIKeyScopeUser {
 		k.Admin = ""
 	}
+	if k.Scope == APIKeyScopeMachine {
+		k.User = ""
+		k.Admin = ""
+	}
 	if k.User != "" {
 		_, err := provider.userExists(k.User, "")
 		if err != nil {
@@ -179,6 +229,37 @@ func (k *APIKey) validate() error {
 	return nil
 }
 
+func (k *APIKey) validatePermissions() error {
+	if k.Scope != APIKeyScopeMachine {
+		k.Permissions = nil
+		k.UsernamePrefix = ""
+		return nil
+	}
+	k.Permissions = util.RemoveDuplicates(k.Permissions, true)
+	if len(k.Permissions) == 0 {
+		return util.NewValidationError("at least one permission is required for API keys with machine scope")
+	}
+	for _, perm := range k.Permissions {
+		if !slices.Contains(validAPIKeyPerms, perm) {
+			return util.NewValidationError(fmt.Sprintf("invalid API key permission: %q", perm))
+		}
+	}
+	k.UsernamePrefix = strings.TrimSpace(k.UsernamePrefix)
+	if len(k.UsernamePrefix) > 255 {
+		return util.NewValidationError("the username prefix cannot be longer than 255 characters")
+	}
+	if k.UsernamePrefix != "" {
+		// groups and folders are shared between users with any username
+		for _, perm := range k.Permissions {
+			if !strings.HasPrefix(perm, "users:") && perm != APIKeyPermGroupsRead && perm != APIKeyPermFoldersRead {
+				return util.NewValidationError(fmt.Sprintf("permission %q is not allowed for API keys with a username prefix",
+					perm))
+			}
+		}
+	}
+	return nil
+}
+
 // Authenticate tries to authenticate the provided plain key
 func (k *APIKey) Authenticate(plainKey string) error {
 	if k.ExpiresAt > 0 && k.ExpiresAt < util.GetTimeAsMsSinceEpoch(time.Now()) {
@@ -0,0 +1,733 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/minio/sio"
+	"github.com/robfig/cron/v3"
+	"github.com/rs/xid"
+	"golang.org/x/crypto/argon2"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+const (
+	// ArchiveVersion defines the version for the archive format
+	ArchiveVersion     = 1
+	archiveNamePrefix  = "sftpgo-archive-"
+	archiveTimeFormat  = "20060102T150405.000Z"
+	archiveEncryptedV2 = 2
+	// argon2id parameters for the archive key, RFC 9106 second recommended option
+	archiveKDFTime    = 3
+	archiveKDFMemory  = 64 * 1024
+	archiveKDFThreads = 4
+	// upper limits for the parameters read from an archive header
+	archiveKDFMaxTime   = 16
+	archiveKDFMaxMemory = 1024 * 1024
+	archiveSaltSize     = 32
+	archiveHeaderSize   = 8 + 1 + 4 + 4 + 1 + archiveSaltSize
+)
+
+// Supported modes to restore an archive
+const (
+	// ArchiveRestoreModeMerge adds the archived objects and updates the existing ones
+	ArchiveRestoreModeMerge = "merge"
+	// ArchiveRestoreModeReplace also deletes the existing objects not included in the archive
+	ArchiveRestoreModeReplace = "replace"
+)
+
+var (
+	// ErrArchiveInProgress is returned when an archive is requested while another one is running
+	ErrArchiveInProgress = errors.New("an archive is already in progress")
+	archiveNameRegex     = regexp.MustCompile(`^sftpgo-archive-[0-9]{8}T[0-9]{6}\.[0-9]{3}Z\.json(\.enc)?$`)
+	archiveEncryptMagic  = []byte("SFTPGOAE")
+	archiveInProgress    atomic.Bool
+)
+
+// ArchiveConfig defines the logical backups of the data provider objects.
+// The archives are versioned JSON files, optionally encrypted, saved on a
+// schedule or on demand. The secrets within the objects are archived in
+// their encrypted form
+type ArchiveConfig struct {
+	// Name of the virtual folder, defined in the data provider, where the archives
+	// are saved, for example a folder backed by an S3 bucket. Empty means the
+	// configured backups path
+	Folder string `json:"folder" mapstructure:"folder"`
+	// Passphrase used to encrypt the archives. Empty means no encryption.
+	// The same passphrase is required to restore the encrypted archives
+	Passphrase string `json:"passphrase" mapstructure:"passphrase"`
+	// Schedule for the archives in cron format, for example "0 2 * * *" or
+	// "@every 24h". Empty means that the archives are created on demand only
+	Schedule string `json:"schedule" mapstructure:"schedule"`
+}
+
+func (c *ArchiveConfig) validate() error {
+	if c.Schedule == "" {
+		return nil
+	}
+	if _, err := cron.ParseStandard(c.Schedule); err != nil {
+		return util.NewValidationError(fmt.Sprintf("invalid archive schedule %q: %v", c.Schedule, err))
+	}
+	return nil
+}
+
+// Archive defines a logical backup of the data provider objects
+type Archive struct {
+	// Archive format version
+	Version int `json:"version"`
+	// Creation time as unix timestamp in milliseconds
+	CreatedAt int64 `json:"created_at"`
+	// Driver of the data provider the archive was created from
+	Driver string     `json:"driver"`
+	Data   BackupData `json:"data"`
+}
+
+// ArchiveInfo defines a saved archive
+type ArchiveInfo struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	// Last modification time as unix timestamp in milliseconds
+	LastModified int64 `json:"last_modified"`
+	Encrypted    bool  `json:"encrypted"`
+}
+
+func getArchiveFs() (vfs.Fs, error) {
+	var fs vfs.Fs
+	connectionID := "archive_" + xid.New().String()
+	if config.Archives.Folder == "" {
+		fs = vfs.NewOsFs(connectionID, config.BackupsPath, "", nil)
+	} else {
+		folder, err := provider.getFolderByName(config.Archives.Folder)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get the archives folder %q: %w", config.Archives.Folder, err)
+		}
+		vfolder := vfs.VirtualFolder{
+			BaseVirtualFolder: folder,
+			VirtualPath:       "/",
+		}
+		fs, err = vfolder.GetFilesystem(connectionID, nil)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get the filesystem for the archives folder %q: %w",
+				config.Archives.Folder, err)
+		}
+	}
+	if !fs.CheckRootPath(ActionExecutorSystem, -1, -1) {
+		fs.Close()
+		return nil, errors.New("unable to create the archives root directory")
+	}
+	return fs, nil
+}
+
+// archiveKDFParams defines the argon2id parameters used to derive the archive
+// encryption key from the passphrase. They are saved in the archive header
+type archiveKDFParams struct {
+	time    uint32
+	memory  uint32
+	threads uint8
+	salt    []byte
+}
+
+func (p *archiveKDFParams) validate() error {
+	if p.time < 1 || p.time > archiveKDFMaxTime || p.memory < 8*uint32(p.threads) ||
+		p.memory > archiveKDFMaxMemory || p.threads < 1 {
+		return util.NewValidationError("invalid archive key derivation parameters")
+	}
+	return nil
+}
+
+func (p *archiveKDFParams) getKey() []byte {
+	return argon2.IDKey([]byte(config.Archives.Passphrase), p.salt, p.time, p.memory, p.threads, 32)
+}
+
+func (p *archiveKDFParams) getSIOConfig() sio.Config {
+	return sio.Config{
+		MinVersion: sio.Version20,
+		MaxVersion: sio.Version20,
+		Key:        p.getKey(),
+	}
+}
+
+// the header of an encrypted archive is: magic, encryption version, argon2id
+// time, memory in KiB and threads and the salt
+func (p *archiveKDFParams) getHeader() []byte {
+	header := make([]byte, 0, archiveHeaderSize)
+	header = append(header, archiveEncryptMagic...)
+	header = append(header, archiveEncryptedV2)
+	header = binary.BigEndian.AppendUint32(header, p.time)
+	header = binary.BigEndian.AppendUint32(header, p.memory)
+	header = append(header, p.threads)
+	return append(header, p.salt...)
+}
+
+func newArchiveKDFParams() (archiveKDFParams, error) {
+	params := archiveKDFParams{
+		time:    archiveKDFTime,
+		memory:  archiveKDFMemory,
+		threads: archiveKDFThreads,
+		salt:    make([]byte, archiveSaltSize),
+	}
+	_, err := io.ReadFull(rand.Reader, params.salt)
+	return params, err
+}
+
+// getArchiveWriter returns a writer that encrypts the data written to w, if a
+// passphrase is configured. The returned writer must be closed to finalize
+// the encryption, closing it does not close w
+func getArchiveWriter(w io.Writer) (io.WriteCloser, error) {
+	if config.Archives.Passphrase == "" {
+		return nopWriteCloser{w}, nil
+	}
+	params, err := newArchiveKDFParams()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(params.getHeader()); err != nil {
+		return nil, err
+	}
+	return sio.EncryptWriter(nopWriteCloser{w}, params.getSIOConfig())
+}
+
+// getArchiveReader returns a reader that decrypts r, if the archive is encrypted
+func getArchiveReader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(archiveEncryptMagic))
+	if err != nil || !bytes.Equal(magic, archiveEncryptMagic) {
+		return br, nil
+	}
+	if config.Archives.Passphrase == "" {
+		return nil, util.NewValidationError("the archive is encrypted and no passphrase is configured")
+	}
+	header := make([]byte, archiveHeaderSize)
+	if _, err := io.ReadFull(br, header[:len(archiveEncryptMagic)+1]); err != nil {
+		return nil, err
+	}
+	if header[len(archiveEncryptMagic)] != archiveEncryptedV2 {
+		return nil, util.NewValidationError("unsupported archive encryption")
+	}
+	if _, err := io.ReadFull(br, header[len(archiveEncryptMagic)+1:]); err != nil {
+		return nil, util.NewValidationError(fmt.Sprintf("invalid archive header: %v", err))
+	}
+	fields := header[len(archiveEncryptMagic)+1:]
+	params := archiveKDFParams{
+		time:    binary.BigEndian.Uint32(fields),
+		memory:  binary.BigEndian.Uint32(fields[4:]),
+		threads: fields[8],
+		salt:    fields[9:],
+	}
+	if err := params.validate(); err != nil {
+		return nil, err
+	}
+	return sio.DecryptReader(br, params.getSIOConfig())
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// writeArchive streams the archive, encrypted if required, to the specified
+// file and returns the number of bytes written
+func writeArchive(name string, archive *Archive) (int64, error) {
+	fs, err := getArchiveFs()
+	if err != nil {
+		return 0, err
+	}
+	defer fs.Close()
+
+	fsPath, err := fs.ResolvePath("/" + name)
+	if err != nil {
+		return 0, err
+	}
+	f, w, cancelFn, err := fs.Create(fsPath, 0, 0)
+	if err != nil {
+		return 0, err
+	}
+	if cancelFn != nil {
+		defer cancelFn()
+	}
+	var writer io.WriteCloser = w
+	if f != nil {
+		writer = f
+	}
+	bw := bufio.NewWriter(writer)
+	counter := &countingWriter{w: bw}
+	err = encodeArchive(counter, archive)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if errClose := writer.Close(); err == nil {
+		err = errClose
+	}
+	return counter.n, err
+}
+
+func encodeArchive(w io.Writer, archive *Archive) error {
+	aw, err := getArchiveWriter(w)
+	if err != nil {
+		return fmt.Errorf("unable to encrypt the archive: %w", err)
+	}
+	if err := json.NewEncoder(aw).Encode(archive); err != nil {
+		aw.Close()
+		return err
+	}
+	return aw.Close()
+}
+
+// CreateArchive saves an archive with the objects in the specified scopes,
+// empty scopes means all. The objects are dumped one type at a time, so no
+// long-running locks are held on the data provider
+func CreateArchive(scopes []string) (ArchiveInfo, error) {
+	var info ArchiveInfo
+	if err := validateArchiveScopes(scopes); err != nil {
+		return info, err
+	}
+	if !archiveInProgress.CompareAndSwap(false, true) {
+		return info, ErrArchiveInProgress
+	}
+	defer archiveInProgress.Store(false)
+
+	now := time.Now()
+	data, err := DumpData(scopes)
+	if err != nil {
+		providerLog(logger.LevelError, "unable to dump data for the archive: %v", err)
+		return info, fmt.Errorf("unable to dump data for the archive: %w", err)
+	}
+	info.Name = archiveNamePrefix + now.UTC().Format(archiveTimeFormat) + ".json"
+	if config.Archives.Passphrase != "" {
+		info.Name += ".enc"
+		info.Encrypted = true
+	}
+	info.Size, err = writeArchive(info.Name, &Archive{
+		Version:   ArchiveVersion,
+		CreatedAt: util.GetTimeAsMsSinceEpoch(now),
+		Driver:    config.Driver,
+		Data:      data,
+	})
+	if err != nil {
+		providerLog(logger.LevelError, "unable to save archive %q: %v", info.Name, err)
+		return info, fmt.Errorf("unable to save the archive: %w", err)
+	}
+	info.LastModified = util.GetTimeAsMsSinceEpoch(time.Now())
+	providerLog(logger.LevelInfo, "archive %q saved, size: %d, elapsed: %s", info.Name, info.Size, time.Since(now))
+	return info, nil
+}
+
+// GetArchives returns the saved archives sorted by name, so the oldest first
+func GetArchives() ([]ArchiveInfo, error) {
+	fs, err := getArchiveFs()
+	if err != nil {
+		return nil, err
+	}
+	defer fs.Close()
+
+	fsPath, err := fs.ResolvePath("/")
+	if err != nil {
+		return nil, err
+	}
+	lister, err := fs.ReadDir(fsPath)
+	if err != nil {
+		return nil, err
+	}
+	defer lister.Close()
+
+	var archives []ArchiveInfo
+	for {
+		entries, err := lister.Next(vfs.ListerBatchSize)
+		finished := errors.Is(err, io.EOF)
+		if err != nil && !finished {
+			return nil, err
+		}
+		for _, fi := range entries {
+			if fi.IsDir() || !archiveNameRegex.MatchString(fi.Name()) {
+				continue
+			}
+			archives = append(archives, ArchiveInfo{
+				Name:         fi.Name(),
+				Size:         fi.Size(),
+				LastModified: util.GetTimeAsMsSinceEpoch(fi.ModTime()),
+				Encrypted:    strings.HasSuffix(fi.Name(), ".enc"),
+			})
+		}
+		if finished {
+			break
+		}
+	}
+	slices.SortFunc(archives, func(a, b ArchiveInfo) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return archives, nil
+}
+
+// ReadArchive returns the archive with the specified name, decrypted if required
+func ReadArchive(name string) (Archive, error) {
+	var archive Archive
+	if !archiveNameRegex.MatchString(name) {
+		return archive, util.NewValidationError(fmt.Sprintf("invalid archive name %q", name))
+	}
+	fs, err := getArchiveFs()
+	if err != nil {
+		return archive, err
+	}
+	defer fs.Close()
+
+	fsPath, err := fs.ResolvePath("/" + name)
+	if err != nil {
+		return archive, err
+	}
+	f, r, cancelFn, err := fs.Open(fsPath, 0)
+	if err != nil {
+		if fs.IsNotExist(err) {
+			return archive, util.NewRecordNotFoundError(fmt.Sprintf("archive %q does not exist", name))
+		}
+		return archive, err
+	}
+	if cancelFn != nil {
+		defer cancelFn()
+	}
+	var reader io.ReadCloser = r
+	if f != nil {
+		reader = f
+	}
+	defer reader.Close()
+
+	archiveReader, err := getArchiveReader(reader)
+	if err != nil {
+		return archive, err
+	}
+	if err := json.NewDecoder(archiveReader).Decode(&archive); err != nil {
+		var sioErr sio.Error
+		if errors.As(err, &sioErr) {
+			return archive, util.NewValidationError(fmt.Sprintf("unable to decrypt the archive: %v", err))
+		}
+		return archive, util.NewValidationError(fmt.Sprintf("invalid archive %q: %v", name, err))
+	}
+	if archive.Version > ArchiveVersion {
+		return archive, util.NewValidationError(fmt.Sprintf("unsupported archive version %d", archive.Version))
+	}
+	// apply the same upgrades as for the dumps
+	archive.Data, err = upgradeDumpData(archive.Data)
+	return archive, err
+}
+
+func executeScheduledArchive() {
+	if _, err := CreateArchive(nil); err != nil {
+		providerLog(logger.LevelError, "scheduled archive failed: %v", err)
+	}
+}
+
+// ArchiveScopeReport defines the changes to the objects of a scope restoring an archive
+type ArchiveScopeReport struct {
+	Scope string `json:"scope"`
+	// archived objects that do not exist in the data provider
+	Added []string `json:"added,omitempty"`
+	// existing objects overwritten by the archived ones
+	Conflicts []string `json:"conflicts,omitempty"`
+	// existing objects not included in the archive, deleted in replace mode
+	Deleted []string `json:"deleted,omitempty"`
+	// validation errors for the archived objects, checked in dry-run mode
+	Errors []string `json:"errors,omitempty"`
+}
+
+// ArchiveRestoreReport defines the changes applied, or to apply in dry-run mode,
+// restoring an archive
+type ArchiveRestoreReport struct {
+	Archive string `json:"archive"`
+	Mode    string `json:"mode"`
+	DryRun  bool   `json:"dry_run"`
+	// the scopes are sorted in restore order, the deletions are applied in reverse order
+	Scopes []ArchiveScopeReport `json:"scopes"`
+}
+
+// archiveScopes defines the scopes in restore order, an object is restored after the
+// objects it refers to
+var archiveScopes = []string{DumpScopeConfigs, DumpScopeIPLists, DumpScopeRoles, DumpScopeFolders,
+	DumpScopeGroups, DumpScopeUsers, DumpScopeAdmins, DumpScopeAPIKeys, DumpScopeShares, DumpScopeActions,
+	DumpScopeRules, DumpScopeUserTemplates}
+
+func validateArchiveScopes(scopes []string) error {
+	for _, scope := range scopes {
+		if !slices.Contains(archiveScopes, scope) {
+			return util.NewValidationError(fmt.Sprintf("invalid scope %q", scope))
+		}
+	}
+	return nil
+}
+
+func getObjectNames[T any](objects []T, getName func(*T) string) []string {
+	names := make([]string, 0, len(objects))
+	for idx := range objects {
+		names = append(names, getName(&objects[idx]))
+	}
+	return names
+}
+
+func getBackupObjectNames(data *BackupData, scope string) []string {
+	switch scope {
+	case DumpScopeConfigs:
+		if data.Configs != nil && data.Configs.UpdatedAt > 0 {
+			return []string{DumpScopeConfigs}
+		}
+		return nil
+	case DumpScopeIPLists:
+		return getObjectNames(data.IPLists, func(e *IPListEntry) string { return e.getName() })
+	case DumpScopeRoles:
+		return getObjectNames(data.Roles, func(r *Role) string { return r.Name })
+	case DumpScopeFolders:
+		return getObjectNames(data.Folders, func(f *vfs.BaseVirtualFolder) string { return f.Name })
+	case DumpScopeGroups:
+		return getObjectNames(data.Groups, func(g *Group) string { return g.Name })
+	case DumpScopeUsers:
+		return getObjectNames(data.Users, func(u *User) string { return u.Username })
+	case DumpScopeAdmins:
+		return getObjectNames(data.Admins, func(a *Admin) string { return a.Username })
+	case DumpScopeAPIKeys:
+		return getObjectNames(data.APIKeys, func(k *APIKey) string { return k.KeyID })
+	case DumpScopeShares:
+		return getObjectNames(data.Shares, func(s *Share) string { return s.ShareID })
+	case DumpScopeActions:
+		return getObjectNames(data.EventActions, func(a *BaseEventAction) string { return a.Name })
+	case DumpScopeRules:
+		return getObjectNames(data.EventRules, func(r *EventRule) string { return r.Name })
+	default:
+		return getObjectNames(data.UserTemplates, func(t *UserTemplate) string { return t.Name })
+	}
+}
+
+// keepScopes removes the objects not included in the specified scopes
+func (d *BackupData) keepScopes(scopes []string) {
+	if !slices.Contains(scopes, DumpScopeConfigs) {
+		d.Configs = nil
+	}
+	if !slices.Contains(scopes, DumpScopeIPLists) {
+		d.IPLists = nil
+	}
+	if !slices.Contains(scopes, DumpScopeRoles) {
+		d.Roles = nil
+	}
+	if !slices.Contains(scopes, DumpScopeFolders) {
+		d.Folders = nil
+	}
+	if !slices.Contains(scopes, DumpScopeGroups) {
+		d.Groups = nil
+	}
+	if !slices.Contains(scopes, DumpScopeUsers) {
+		d.Users = nil
+	}
+	if !slices.Contains(scopes, DumpScopeAdmins) {
+		d.Admins = nil
+	}
+	if !slices.Contains(scopes, DumpScopeAPIKeys) {
+		d.APIKeys = nil
+	}
+	if !slices.Contains(scopes, DumpScopeShares) {
+		d.Shares = nil
+	}
+	if !slices.Contains(scopes, DumpScopeActions) {
+		d.EventActions = nil
+	}
+	if !slices.Contains(scopes, DumpScopeRules) {
+		d.EventRules = nil
+	}
+	if !slices.Contains(scopes, DumpScopeUserTemplates) {
+		d.UserTemplates = nil
+	}
+}
+
+// validateArchivedUsers validates copies of the archived users. The groups and the
+// role can be defined within the archive or in the data provider
+func validateArchivedUsers(data *BackupData) []string {
+	var errs []string
+	for idx := range data.Users {
+		user := data.Users[idx].getACopy()
+		for _, group := range user.Groups {
+			if !slices.ContainsFunc(data.Groups, func(g Group) bool { return g.Name == group.Name }) {
+				if _, err := provider.groupExists(group.Name); err != nil {
+					errs = append(errs, fmt.Sprintf("user %q: group %q does not exist", user.Username, group.Name))
+				}
+			}
+		}
+		if user.Role != "" && !slices.ContainsFunc(data.Roles, func(r Role) bool { return r.Name == user.Role }) {
+			if _, err := provider.roleExists(user.Role); err != nil {
+				errs = append(errs, fmt.Sprintf("user %q: role %q does not exist", user.Username, user.Role))
+			}
+		}
+		user.Groups = nil
+		if err := ValidateUser(&user); err != nil {
+			errs = append(errs, fmt.Sprintf("user %q: %v", user.Username, err))
+		}
+	}
+	return errs
+}
+
+func validateArchivedFolders(data *BackupData) []string {
+	var errs []string
+	for idx := range data.Folders {
+		folder := data.Folders[idx].GetACopy()
+		if err := ValidateFolder(&folder); err != nil {
+			errs = append(errs, fmt.Sprintf("folder %q: %v", folder.Name, err))
+		}
+	}
+	return errs
+}
+
+// PrepareArchiveRestore removes from the archive the objects not included in the
+// specified scopes, empty scopes means all, and returns the changes to apply
+// restoring it. The existing objects not included in the archive are reported
+// as deleted in replace mode, except the admin executing the restore.
+// The archived users and folders are validated in dry-run mode
+func PrepareArchiveRestore(archive *Archive, name string, scopes []string, replace, dryRun bool,
+	executor string,
+) (ArchiveRestoreReport, error) {
+	report := ArchiveRestoreReport{
+		Archive: name,
+		Mode:    ArchiveRestoreModeMerge,
+		DryRun:  dryRun,
+	}
+	if replace {
+		report.Mode = ArchiveRestoreModeReplace
+	}
+	if err := validateArchiveScopes(scopes); err != nil {
+		return report, err
+	}
+	if len(scopes) == 0 {
+		scopes = archiveScopes
+	}
+	archive.Data.keepScopes(scopes)
+	current, err := DumpData(scopes)
+	if err != nil {
+		return report, fmt.Errorf("unable to dump the existing data: %w", err)
+	}
+	for _, scope := range archiveScopes {
+		if slices.Contains(scopes, scope) {
+			report.Scopes = append(report.Scopes, getArchiveScopeReport(scope, &current, &archive.Data, replace,
+				dryRun, executor))
+		}
+	}
+	return report, nil
+}
+
+func getArchiveScopeReport(scope string, current, archived *BackupData, replace, dryRun bool,
+	executor string,
+) ArchiveScopeReport {
+	report := ArchiveScopeReport{
+		Scope: scope,
+	}
+	existingNames := getBackupObjectNames(current, scope)
+	archivedNames := getBackupObjectNames(archived, scope)
+	for _, name := range archivedNames {
+		if slices.Contains(existingNames, name) {
+			report.Conflicts = append(report.Conflicts, name)
+		} else {
+			report.Added = append(report.Added, name)
+		}
+	}
+	if replace && scope != DumpScopeConfigs {
+		for _, name := range existingNames {
+			if !slices.Contains(archivedNames, name) && (scope != DumpScopeAdmins || name != executor) {
+				report.Deleted = append(report.Deleted, name)
+			}
+		}
+	}
+	if dryRun {
+		switch scope {
+		case DumpScopeUsers:
+			report.Errors = validateArchivedUsers(archived)
+		case DumpScopeFolders:
+			report.Errors = validateArchivedFolders(archived)
+		}
+	}
+	return report
+}
+
+func deleteArchivedIPListEntry(name, executor, ipAddress, role string) error {
+	for _, listType := range []IPListType{IPListTypeAllowList, IPListTypeDefender, IPListTypeRateLimiterSafeList} {
+		if ipOrNet, ok := strings.CutPrefix(name, listType.AsString()+"-"); ok {
+			return DeleteIPListEntry(ipOrNet, listType, executor, ipAddress, role)
+		}
+	}
+	return util.NewValidationError(fmt.Sprintf("invalid IP list entry %q", name))
+}
+
+func deleteArchivedShare(shareID, executor, ipAddress, role string) error {
+	share, err := provider.shareExists(shareID, "")
+	if err != nil {
+		return err
+	}
+	err = provider.deleteShare(share)
+	if err == nil {
+		executeAction(operationDelete, executor, ipAddress, actionObjectShare, shareID, role, &share)
+	}
+	return err
+}
+
+// DeleteArchiveObject deletes an object reported as deleted restoring an archive in replace mode
+func DeleteArchiveObject(scope, name, executor, ipAddress, role string) error {
+	switch scope {
+	case DumpScopeIPLists:
+		return deleteArchivedIPListEntry(name, executor, ipAddress, role)
+	case DumpScopeRoles:
+		return DeleteRole(name, executor, ipAddress, role)
+	case DumpScopeFolders:
+		return DeleteFolder(name, executor, ipAddress, role)
+	case DumpScopeGroups:
+		return DeleteGroup(name, executor, ipAddress, role)
+	case DumpScopeUsers:
+		return DeleteUser(name, executor, ipAddress, role)
+	case DumpScopeAdmins:
+		return DeleteAdmin(name, executor, ipAddress, role)
+	case DumpScopeAPIKeys:
+		return DeleteAPIKey(name, executor, ipAddress, role)
+	case DumpScopeShares:
+		return deleteArchivedShare(name, executor, ipAddress, role)
+	case DumpScopeActions:
+		return DeleteEventAction(name, executor, ipAddress, role)
+	case DumpScopeRules:
+		return DeleteEventRule(name, executor, ipAddress, role)
+	case DumpScopeUserTemplates:
+		return DeleteUserTemplate(name, executor, ipAddress, role)
+	default:
+		return util.NewValidationError(fmt.Sprintf("objects in scope %q cannot be deleted", scope))
+	}
+}
@@ -19,6 +19,7 @@ package dataprovider
 import (
 	"bytes"
 	"crypto/x509"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -43,22 +44,27 @@ const (
 )
 
 var (
-	usersBucket     = []byte("users")
-	groupsBucket    = []byte("groups")
-	foldersBucket   = []byte("folders")
-	adminsBucket    = []byte("admins")
-	apiKeysBucket   = []byte("api_keys")
-	sharesBucket    = []byte("shares")
-	actionsBucket   = []byte("events_actions")
-	rulesBucket     = []byte("events_rules")
-	rolesBucket     = []byte("roles")
-	ipListsBucket   = []byte("ip_lists")
-	configsBucket   = []byte("configs")
-	dbVersionBucket = []byte("db_version")
-	dbVersionKey    = []byte("version")
-	configsKey      = []byte("configs")
-	boltBuckets     = [][]byte{usersBucket, groupsBucket, foldersBucket, adminsBucket, apiKeysBucket,
-		sharesBucket, actionsBucket, rulesBucket, rolesBucket, ipListsBucket, configsBucket, dbVersionBucket}
+	usersBucket       = []byte("users")
+	groupsBucket      = []byte("groups")
+	foldersBucket     = []byte("folders")
+	adminsBucket      = []byte("admins")
+	apiKeysBucket     = []byte("api_keys")
+	sharesBucket      = []byte("shares")
+	actionsBucket     = []byte("events_actions")
+	rulesBucket       = []byte("events_rules")
+	rolesBucket       = []byte("roles")
+	ipListsBucket     = []byte("ip_lists")
+	configsBucket     = []byte("configs")
+	loginEventsBucket = []byte("login_events")
+	windowsBucket     = []byte("transfer_windows")
+	templatesBucket   = []byte("user_templates")
+	changesBucket     = []byte("object_changes")
+	dbVersionBucket   = []byte("db_version")
+	dbVersionKey      = []byte("version")
+	configsKey        = []byte("configs")
+	boltBuckets       = [][]byte{usersBucket, groupsBucket, foldersBucket, adminsBucket, apiKeysBucket,
+		sharesBucket, actionsBucket, rulesBucket, rolesBucket, ipListsBucket, configsBucket, loginEventsBucket,
+		windowsBucket, templatesBucket, changesBucket, dbVersionBucket}
 )
 
 // BoltProvider defines the auth provider for bolt key/value store
@@ -288,6 +294,73 @@ func (p *BoltProvider) updateLastLogin(username string) error {
 	})
 }
 
+func (p *BoltProvider) consumeUserLogin(username string, maxLogins int) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := p.getUsersBucket(tx)
+		if err != nil {
+			return err
+		}
+		var u []byte
+		if u = bucket.Get([]byte(username)); u == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("username %q does not exist, unable to consume a login", username))
+		}
+		var user User
+		err = json.Unmarshal(u, &user)
+		if err != nil {
+			return err
+		}
+		if user.UsedLogins >= maxLogins {
+			return ErrLoginBudgetExhausted
+		}
+		user.UsedLogins++
+		buf, err := json.Marshal(user)
+		if err != nil {
+			return err
+		}
+		err = bucket.Put([]byte(username), buf)
+		if err != nil {
+			providerLog(logger.LevelWarn, "error consuming a login for user %q: %v", username, err)
+		} else {
+			providerLog(logger.LevelDebug, "login consumed for user %q", username)
+		}
+		return err
+	})
+}
+
+func (p *BoltProvider) updatePublicKeyLastUse(username, key string) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := p.getUsersBucket(tx)
+		if err != nil {
+			return err
+		}
+		var u []byte
+		if u = bucket.Get([]byte(username)); u == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("username %q does not exist, unable to update public key last use", username))
+		}
+		var user User
+		err = json.Unmarshal(u, &user)
+		if err != nil {
+			return err
+		}
+		keys := user.getPublicKeys()
+		if !setPublicKeyLastUse(keys, key) {
+			return util.NewRecordNotFoundError(fmt.Sprintf("public key not found for user %q", username))
+		}
+		user.setPublicKeys(keys)
+		buf, err := json.Marshal(user)
+		if err != nil {
+			return err
+		}
+		err = bucket.Put([]byte(username), buf)
+		if err != nil {
+			providerLog(logger.LevelWarn, "error updating public key last use for user %q: %v", username, err)
+		} else {
+			providerLog(logger.LevelDebug, "public key last use updated for user %q", username)
+		}
+		return err
+	})
+}
+
 func (p *BoltProvider) updateAdminLastLogin(username string) error {
 	return p.dbHandle.Update(func(tx *bolt.Tx) error {
 		bucket, err := p.getAdminsBucket(tx)
@@ -353,6 +426,119 @@ func (p *BoltProvider) updateTransferQuota(username string, uploadSize, download
 	})
 }
 
+func (p *BoltProvider) updateTransferWindowUsage(username string, start, end, size int64) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(windowsBucket)
+		if bucket == nil {
+			return fmt.Errorf("unable to find transfer windows bucket")
+		}
+		usage := transferWindowUsage{
+			Username: username,
+			Start:    start,
+			End:      end,
+		}
+		if u := bucket.Get([]byte(username)); u != nil {
+			var current transferWindowUsage
+			if err := json.Unmarshal(u, &current); err != nil {
+				return err
+			}
+			if current.Start == start {
+				usage = current
+			}
+		}
+		usage.Used += size
+		buf, err := json.Marshal(usage)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(username), buf)
+	})
+}
+
+func (p *BoltProvider) getTransferWindowUsage(username string) (transferWindowUsage, error) {
+	var usage transferWindowUsage
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(windowsBucket)
+		if bucket == nil {
+			return fmt.Errorf("unable to find transfer windows bucket")
+		}
+		u := bucket.Get([]byte(username))
+		if u == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("transfer window for user %q does not exist", username))
+		}
+		return json.Unmarshal(u, &usage)
+	})
+	return usage, err
+}
+
+func (p *BoltProvider) setTransferWindowNotified(username string, start int64, threshold int) (bool, error) {
+	updated := false
+	err := p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(windowsBucket)
+		if bucket == nil {
+			return fmt.Errorf("unable to find transfer windows bucket")
+		}
+		u := bucket.Get([]byte(username))
+		if u == nil {
+			return nil
+		}
+		var usage transferWindowUsage
+		if err := json.Unmarshal(u, &usage); err != nil {
+			return err
+		}
+		if usage.Start != start || usage.Notified >= threshold {
+			return nil
+		}
+		usage.Notified = threshold
+		buf, err := json.Marshal(usage)
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put([]byte(username), buf); err != nil {
+			return err
+		}
+		updated = true
+		return nil
+	})
+	return updated, err
+}
+
+func (p *BoltProvider) cleanupTransferWindowUsages(before int64) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(windowsBucket)
+		if bucket == nil {
+			return fmt.Errorf("unable to find transfer windows bucket")
+		}
+		var keys [][]byte
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			var usage transferWindowUsage
+			if err := json.Unmarshal(v, &usage); err != nil {
+				return err
+			}
+			if usage.End <= before {
+				keys = append(keys, k)
+			}
+		}
+		for _, k := range keys {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (p *BoltProvider) deleteTransferWindowUsage(username string) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(windowsBucket)
+		if bucket == nil {
+			return fmt.Errorf("unable to find transfer windows bucket")
+		}
+		return bucket.Delete([]byte(username))
+	})
+}
+
 func (p *BoltProvider) updateQuota(username string, filesAdd int, sizeAdd int64, reset bool) error {
 	return p.dbHandle.Update(func(tx *bolt.Tx) error {
 		bucket, err := p.getUsersBucket(tx)
@@ -675,12 +861,12 @@ func (p *BoltProvider) userExists(username, role string) (User, error) {
 	return user, err
 }
 
-func (p *BoltProvider) addUser(user *User) error {
+func (p *BoltProvider) addUser(user *User, change *ObjectChange) error {
 	err := ValidateUser(user)
 	if err != nil {
 		return err
 	}
-	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+	return p.updateWithObjectChange(change, func(tx *bolt.Tx) error {
 		bucket, err := p.getUsersBucket(tx)
 		if err != nil {
 			return err
@@ -716,6 +902,7 @@ func (p *BoltProvider) addUser(user *User) error {
 		user.LastLogin = 0
 		user.FirstDownload = 0
 		user.FirstUpload = 0
+		user.UsedLogins = 0
 		user.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
 		user.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
 		if err := p.addUserToRole(user.Username, user.Role, rolesBucket); err != nil {
@@ -747,12 +934,12 @@ func (p *BoltProvider) addUser(user *User) error {
 	})
 }
 
-func (p *BoltProvider) updateUser(user *User) error {
+func (p *BoltProvider) updateUser(user *User, change *ObjectChange) error {
 	err := ValidateUser(user)
 	if err != nil {
 		return err
 	}
-	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+	return p.updateWithObjectChange(change, func(tx *bolt.Tx) error {
 		bucket, err := p.getUsersBucket(tx)
 		if err != nil {
 			return err
@@ -778,6 +965,7 @@ func (p *BoltProvider) updateUser(user *User) error {
 		user.LastLogin = oldUser.LastLogin
 		user.FirstDownload = oldUser.FirstDownload
 		user.FirstUpload = oldUser.FirstUpload
+		user.UsedLogins = oldUser.UsedLogins
 		user.CreatedAt = oldUser.CreatedAt
 		user.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
 		buf, err := json.Marshal(user)
@@ -793,8 +981,8 @@ func (p *BoltProvider) updateUser(user *User) error {
 	})
 }
 
-func (p *BoltProvider) deleteUser(user User, _ bool) error {
-	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+func (p *BoltProvider) deleteUser(user User, _ bool, change *ObjectChange) error {
+	return p.updateWithObjectChange(change, func(tx *bolt.Tx) error {
 		bucket, err := p.getUsersBucket(tx)
 		if err != nil {
 			return err
@@ -1014,7 +1202,7 @@ func (p *BoltProvider) getUsersForQuotaCheck(toFetch map[string]bool) ([]User, e
 	return users, err
 }
 
-func (p *BoltProvider) getUsers(limit int, offset int, order, role string) ([]User, error) {
+func (p *BoltProvider) getUsers(limit int, offset int, order, role, usernamePrefix string) ([]User, error) {
 	users := make([]User, 0, limit)
 	var err error
 	if limit <= 0 {
@@ -1033,6 +1221,9 @@ func (p *BoltProvider) getUsers(limit int, offset int, order, role string) ([]Us
 		itNum := 0
 		if order == OrderASC {
 			for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+				if !bytes.HasPrefix(k, []byte(usernamePrefix)) {
+					continue
+				}
 				itNum++
 				if itNum <= offset {
 					continue
@@ -1052,6 +1243,9 @@ func (p *BoltProvider) getUsers(limit int, offset int, order, role string) ([]Us
 			}
 		} else {
 			for k, v := cursor.Last(); k != nil; k, v = cursor.Prev() {
+				if !bytes.HasPrefix(k, []byte(usernamePrefix)) {
+					continue
+				}
 				itNum++
 				if itNum <= offset {
 					continue
@@ -1162,12 +1356,12 @@ func (p *BoltProvider) getFolderByName(name string) (vfs.BaseVirtualFolder, erro
 	return folder, err
 }
 
-func (p *BoltProvider) addFolder(folder *vfs.BaseVirtualFolder) error {
+func (p *BoltProvider) addFolder(folder *vfs.BaseVirtualFolder, change *ObjectChange) error {
 	err := ValidateFolder(folder)
 	if err != nil {
 		return err
 	}
-	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+	return p.updateWithObjectChange(change, func(tx *bolt.Tx) error {
 		bucket, err := p.getFoldersBucket(tx)
 		if err != nil {
 			return err
@@ -1184,12 +1378,12 @@ func (p *BoltProvider) addFolder(folder *vfs.BaseVirtualFolder) error {
 	})
 }
 
-func (p *BoltProvider) updateFolder(folder *vfs.BaseVirtualFolder) error {
+func (p *BoltProvider) updateFolder(folder *vfs.BaseVirtualFolder, change *ObjectChange) error {
 	err := ValidateFolder(folder)
 	if err != nil {
 		return err
 	}
-	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+	return p.updateWithObjectChange(change, func(tx *bolt.Tx) error {
 		bucket, err := p.getFoldersBucket(tx)
 		if err != nil {
 			return err
@@ -1209,6 +1403,7 @@ func (p *BoltProvider) updateFolder(folder *vfs.BaseVirtualFolder) error {
 		folder.LastQuotaUpdate = oldFolder.LastQuotaUpdate
 		folder.UsedQuotaFiles = oldFolder.UsedQuotaFiles
 		folder.UsedQuotaSize = oldFolder.UsedQuotaSize
+		folder.QuotaScan = oldFolder.QuotaScan
 		folder.Users = oldFolder.Users
 		folder.Groups = oldFolder.Groups
 		buf, err := json.Marshal(folder)
@@ -1275,8 +1470,8 @@ func (p *BoltProvider) deleteFolderMappings(folder vfs.BaseVirtualFolder, usersB
 	return nil
 }
 
-func (p *BoltProvider) deleteFolder(baseFolder vfs.BaseVirtualFolder) error {
-	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+func (p *BoltProvider) deleteFolder(baseFolder vfs.BaseVirtualFolder, change *ObjectChange) error {
+	return p.updateWithObjectChange(change, func(tx *bolt.Tx) error {
 		bucket, err := p.getFoldersBucket(tx)
 		if err != nil {
 			return err
@@ -1338,6 +1533,33 @@ func (p *BoltProvider) updateFolderQuota(name string, filesAdd int, sizeAdd int6
 	})
 }
 
+func (p *BoltProvider) updateFolderQuotaScan(name string, scan vfs.FolderQuotaScan) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := p.getFoldersBucket(tx)
+		if err != nil {
+			return err
+		}
+		var f []byte
+		if f = bucket.Get([]byte(name)); f == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("folder %q does not exist, unable to update quota scan", name))
+		}
+		var folder vfs.BaseVirtualFolder
+		err = json.Unmarshal(f, &folder)
+		if err != nil {
+			return err
+		}
+		folder.UsedQuotaSize = scan.ScannedSize
+		folder.UsedQuotaFiles = scan.ScannedFiles
+		folder.LastQuotaUpdate = scan.LastScan
+		folder.QuotaScan = &scan
+		buf, err := json.Marshal(folder)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(folder.Name), buf)
+	})
+}
+
 func (p *BoltProvider) getUsedFolderQuota(name string) (int, int64, error) {
 	folder, err := p.getFolderByName(name)
 	if err != nil {
@@ -1476,11 +1698,11 @@ func (p *BoltProvider) groupExists(name string) (Group, error) {
 	return group, err
 }
 
-func (p *BoltProvider) addGroup(group *Group) error {
+func (p *BoltProvider) addGroup(group *Group, change *ObjectChange) error {
 	if err := group.validate(); err != nil {
 		return err
 	}
-	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+	return p.updateWithObjectChange(change, func(tx *bolt.Tx) error {
 		bucket, err := p.getGroupsBucket(tx)
 		if err != nil {
 			return err
@@ -1521,11 +1743,11 @@ func (p *BoltProvider) addGroup(group *Group) error {
 	})
 }
 
-func (p *BoltProvider) updateGroup(group *Group) error {
+func (p *BoltProvider) updateGroup(group *Group, change *ObjectChange) error {
 	if err := group.validate(); err != nil {
 		return err
 	}
-	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+	return p.updateWithObjectChange(change, func(tx *bolt.Tx) error {
 		bucket, err := p.getGroupsBucket(tx)
 		if err != nil {
 			return err
@@ -1571,8 +1793,8 @@ func (p *BoltProvider) updateGroup(group *Group) error {
 	})
 }
 
-func (p *BoltProvider) deleteGroup(group Group) error {
-	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+func (p *BoltProvider) deleteGroup(group Group, change *ObjectChange) error {
+	return p.updateWithObjectChange(change, func(tx *bolt.Tx) error {
 		bucket, err := p.getGroupsBucket(tx)
 		if err != nil {
 			return err
@@ -2110,6 +2332,10 @@ func (p *BoltProvider) cleanupDefender(_ int64) error {
 	return ErrNotImplemented
 }
 
+func (p *BoltProvider) importDefenderHosts(_ []DefenderEntry, _ bool) error {
+	return ErrNotImplemented
+}
+
 func (p *BoltProvider) addActiveTransfer(_ ActiveTransfer) error {
 	return ErrNotImplemented
 }
@@ -2142,6 +2368,10 @@ func (p *BoltProvider) getSharedSession(_ string, _ SessionType) (Session, error
 	return Session{}, ErrNotImplemented
 }
 
+func (p *BoltProvider) replaceSharedSession(_ Session, _ []byte) (bool, error) {
+	return false, ErrNotImplemented
+}
+
 func (p *BoltProvider) cleanupSharedSessions(_ SessionType, _ int64) error {
 	return ErrNotImplemented
 }
@@ -2628,11 +2858,15 @@ func (*BoltProvider) addTask(_ string) error {
 	return ErrNotImplemented
 }
 
-func (*BoltProvider) updateTask(_ string, _ int64) error {
+func (*BoltProvider) updateTask(_ string, _, _ int64) error {
+	return ErrNotImplemented
+}
+
+func (*BoltProvider) updateTaskTimestamp(_ string, _ int64) error {
 	return ErrNotImplemented
 }
 
-func (*BoltProvider) updateTaskTimestamp(_ string) error {
+func (*BoltProvider) deleteTask(_ string) error {
 	return ErrNotImplemented
 }
 
@@ -2840,6 +3074,166 @@ func (p *BoltProvider) dumpRoles() ([]Role, error) {
 	return roles, err
 }
 
+func (p *BoltProvider) userTemplateExists(name string) (UserTemplate, error) {
+	var template UserTemplate
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, err := p.getUserTemplatesBucket(tx)
+		if err != nil {
+			return err
+		}
+		t := bucket.Get([]byte(name))
+		if t == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("user template %q does not exist", name))
+		}
+		return json.Unmarshal(t, &template)
+	})
+	template.SetEmptySecretsIfNil()
+	return template, err
+}
+
+func (p *BoltProvider) addUserTemplate(template *UserTemplate) error {
+	if err := template.validate(); err != nil {
+		return err
+	}
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := p.getUserTemplatesBucket(tx)
+		if err != nil {
+			return err
+		}
+		if t := bucket.Get([]byte(template.Name)); t != nil {
+			return util.NewI18nError(
+				fmt.Errorf("%w: user template %q already exists", ErrDuplicatedKey, template.Name),
+				util.I18nErrorDuplicatedName,
+			)
+		}
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		template.ID = int64(id)
+		template.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		template.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		buf, err := json.Marshal(template)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(template.Name), buf)
+	})
+}
+
+func (p *BoltProvider) updateUserTemplate(template *UserTemplate) error {
+	if err := template.validate(); err != nil {
+		return err
+	}
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := p.getUserTemplatesBucket(tx)
+		if err != nil {
+			return err
+		}
+		var t []byte
+		if t = bucket.Get([]byte(template.Name)); t == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("user template %q does not exist", template.Name))
+		}
+		var oldTemplate UserTemplate
+		err = json.Unmarshal(t, &oldTemplate)
+		if err != nil {
+			return err
+		}
+		template.ID = oldTemplate.ID
+		template.CreatedAt = oldTemplate.CreatedAt
+		template.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		buf, err := json.Marshal(template)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(template.Name), buf)
+	})
+}
+
+func (p *BoltProvider) deleteUserTemplate(template UserTemplate) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := p.getUserTemplatesBucket(tx)
+		if err != nil {
+			return err
+		}
+		if t := bucket.Get([]byte(template.Name)); t == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("user template %q does not exist", template.Name))
+		}
+		return bucket.Delete([]byte(template.Name))
+	})
+}
+
+func (p *BoltProvider) getUserTemplates(limit int, offset int, order string) ([]UserTemplate, error) {
+	templates := make([]UserTemplate, 0, limit)
+	if limit <= 0 {
+		return templates, nil
+	}
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, err := p.getUserTemplatesBucket(tx)
+		if err != nil {
+			return err
+		}
+		cursor := bucket.Cursor()
+		itNum := 0
+		if order == OrderASC {
+			for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+				itNum++
+				if itNum <= offset {
+					continue
+				}
+				var template UserTemplate
+				err = json.Unmarshal(v, &template)
+				if err != nil {
+					return err
+				}
+				templates = append(templates, template)
+				if len(templates) >= limit {
+					break
+				}
+			}
+		} else {
+			for k, v := cursor.Last(); k != nil; k, v = cursor.Prev() {
+				itNum++
+				if itNum <= offset {
+					continue
+				}
+				var template UserTemplate
+				err = json.Unmarshal(v, &template)
+				if err != nil {
+					return err
+				}
+				templates = append(templates, template)
+				if len(templates) >= limit {
+					break
+				}
+			}
+		}
+		return nil
+	})
+	return templates, err
+}
+
+func (p *BoltProvider) dumpUserTemplates() ([]UserTemplate, error) {
+	templates := make([]UserTemplate, 0, 10)
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, err := p.getUserTemplatesBucket(tx)
+		if err != nil {
+			return err
+		}
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			var template UserTemplate
+			err = json.Unmarshal(v, &template)
+			if err != nil {
+				return err
+			}
+			templates = append(templates, template)
+		}
+		return err
+	})
+	return templates, err
+}
+
 func (p *BoltProvider) ipListEntryExists(ipOrNet string, listType IPListType) (IPListEntry, error) {
 	entry := IPListEntry{
 		IPOrNet: ipOrNet,
@@ -3101,6 +3495,163 @@ func (p *BoltProvider) setConfigs(configs *Configs) error {
 	})
 }
 
+func (p *BoltProvider) addLoginEvents(events []LoginEvent) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(loginEventsBucket)
+		if bucket == nil {
+			return fmt.Errorf("unable to find login events bucket")
+		}
+		for _, e := range events {
+			id, err := bucket.NextSequence()
+			if err != nil {
+				return err
+			}
+			e.ID = int64(id)
+			buf, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put(binary.BigEndian.AppendUint64(nil, id), buf); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (p *BoltProvider) getLoginEvents(filter LoginEventFilter) ([]LoginEvent, error) {
+	events := make([]LoginEvent, 0, filter.Limit)
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(loginEventsBucket)
+		if bucket == nil {
+			return fmt.Errorf("unable to find login events bucket")
+		}
+		cursor := bucket.Cursor()
+		first, next := cursor.First, cursor.Next
+		if filter.Order == OrderDESC {
+			first, next = cursor.Last, cursor.Prev
+		}
+		for k, v := first(); k != nil; k, v = next() {
+			var e LoginEvent
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			if filter.match(&e) {
+				events = append(events, e)
+				if len(events) >= filter.Limit {
+					break
+				}
+			}
+		}
+		return nil
+	})
+	return events, err
+}
+
+func (p *BoltProvider) cleanupLoginEvents(before int64) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(loginEventsBucket)
+		if bucket == nil {
+			return fmt.Errorf("unable to find login events bucket")
+		}
+		var keys [][]byte
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			var e LoginEvent
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			if e.Timestamp >= before {
+				break
+			}
+			keys = append(keys, k)
+		}
+		for _, k := range keys {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// updateWithObjectChange executes fn within an update transaction and, if fn
+// succeeds, adds the object change, if any, within the same transaction
+func (p *BoltProvider) updateWithObjectChange(change *ObjectChange, fn func(tx *bolt.Tx) error) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		if err := fn(tx); err != nil {
+			return err
+		}
+		if change == nil {
+			return nil
+		}
+		bucket := tx.Bucket(changesBucket)
+		if bucket == nil {
+			return fmt.Errorf("unable to find object changes bucket")
+		}
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		change.ID = int64(id)
+		buf, err := json.Marshal(change)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(binary.BigEndian.AppendUint64(nil, id), buf)
+	})
+}
+
+func (p *BoltProvider) getObjectChanges(sinceID int64, limit int) ([]ObjectChange, error) {
+	changes := make([]ObjectChange, 0, limit)
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(changesBucket)
+		if bucket == nil {
+			return fmt.Errorf("unable to find object changes bucket")
+		}
+		cursor := bucket.Cursor()
+		for k, v := cursor.Seek(binary.BigEndian.AppendUint64(nil, uint64(sinceID+1))); k != nil; k, v = cursor.Next() {
+			var c ObjectChange
+			if err := json.Unmarshal(v, &c); err != nil {
+				return err
+			}
+			changes = append(changes, c)
+			if len(changes) >= limit {
+				break
+			}
+		}
+		return nil
+	})
+	return changes, err
+}
+
+func (p *BoltProvider) cleanupObjectChanges(before int64) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(changesBucket)
+		if bucket == nil {
+			return fmt.Errorf("unable to find object changes bucket")
+		}
+		var keys [][]byte
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			var c ObjectChange
+			if err := json.Unmarshal(v, &c); err != nil {
+				return err
+			}
+			if c.Timestamp >= before {
+				break
+			}
+			keys = append(keys, k)
+		}
+		for _, k := range keys {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
 func (p *BoltProvider) setFirstDownloadTimestamp(username string) error {
 	return p.dbHandle.Update(func(tx *bolt.Tx) error {
 		bucket, err := p.getUsersBucket(tx)
@@ -3907,6 +4458,15 @@ func (p *BoltProvider) getRolesBucket(tx *bolt.Tx) (*bolt.Bucket, error) {
 	return bucket, err
 }
 
+func (p *BoltProvider) getUserTemplatesBucket(tx *bolt.Tx) (*bolt.Bucket, error) {
+	var err error
+	bucket := tx.Bucket(templatesBucket)
+	if bucket == nil {
+		err = fmt.Errorf("unable to find user templates bucket, bolt database structure not correcly defined")
+	}
+	return bucket, err
+}
+
 func (p *BoltProvider) getIPListsBucket(tx *bolt.Tx) (*bolt.Bucket, error) {
 	var err error
 	bucket := tx.Bucket(rolesBucket)
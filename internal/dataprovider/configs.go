@@ -21,6 +21,7 @@ import (
 	"image/png"
 	"net/url"
 	"slices"
+	"text/template"
 
 	"golang.org/x/crypto/ssh"
 
@@ -47,6 +48,100 @@ var (
 	}
 )
 
+// Supported values for host keys, KEXs, ciphers, MACs if the configuration file
+// values are overridden
+var (
+	sshSupportedAlgos             = ssh.SupportedAlgorithms()
+	sshInsecureAlgos              = ssh.InsecureAlgorithms()
+	overrideSupportedHostKeyAlgos = append(sshSupportedAlgos.HostKeys, sshInsecureAlgos.HostKeys...)
+	overrideSupportedPubKeyAlgos  = append(sshSupportedAlgos.PublicKeyAuths, sshInsecureAlgos.PublicKeyAuths...)
+	overrideSupportedKexAlgos     = append(append(sshSupportedAlgos.KeyExchanges, sshInsecureAlgos.KeyExchanges...),
+		"curve25519-sha256@libssh.org")
+	overrideSupportedCiphers = append(sshSupportedAlgos.Ciphers, sshInsecureAlgos.Ciphers...)
+	overrideSupportedMACs    = append(sshSupportedAlgos.MACs, sshInsecureAlgos.MACs...)
+)
+
+// maximum size for the MOTD template stored in the data provider
+const maxMOTDSize = 65536
+
+// Supported merge policies for the SFTPD configs
+const (
+	// SFTPDMergePolicyAppend appends the algorithms defined in the data provider
+	// to the ones defined in the configuration file
+	SFTPDMergePolicyAppend = iota
+	// SFTPDMergePolicyOverride replaces the algorithms defined in the configuration
+	// file with the ones defined in the data provider, empty lists are not overridden
+	SFTPDMergePolicyOverride
+)
+
+// SFTPDHostKey defines a private host key stored in the data provider
+type SFTPDHostKey struct {
+	Name       string      `json:"name"`
+	PrivateKey *kms.Secret `json:"private_key,omitempty"`
+	// Passphrase for encrypted private keys
+	Passphrase *kms.Secret `json:"passphrase,omitempty"`
+}
+
+func (k *SFTPDHostKey) validate() error {
+	if k.Name == "" {
+		return util.NewValidationError("sftpd: host key name is mandatory")
+	}
+	if k.PrivateKey == nil || k.PrivateKey.IsEmpty() {
+		return util.NewValidationError(fmt.Sprintf("sftpd: private key for host key %q is mandatory", k.Name))
+	}
+	if k.Passphrase == nil {
+		k.Passphrase = kms.NewEmptySecret()
+	}
+	if err := validateConfigSecret(k.PrivateKey, "sftpd", "host key"); err != nil {
+		return err
+	}
+	return validateConfigSecret(k.Passphrase, "sftpd", "host key passphrase")
+}
+
+// TryDecrypt tries to decrypt the private key and the passphrase
+func (k *SFTPDHostKey) TryDecrypt() error {
+	if k.PrivateKey == nil {
+		k.PrivateKey = kms.NewEmptySecret()
+	}
+	if k.Passphrase == nil {
+		k.Passphrase = kms.NewEmptySecret()
+	}
+	if err := k.PrivateKey.TryDecrypt(); err != nil {
+		return fmt.Errorf("unable to decrypt host key %q: %w", k.Name, err)
+	}
+	if err := k.Passphrase.TryDecrypt(); err != nil {
+		return fmt.Errorf("unable to decrypt passphrase for host key %q: %w", k.Name, err)
+	}
+	return nil
+}
+
+func (k *SFTPDHostKey) prepareForRendering() {
+	if k.PrivateKey != nil {
+		k.PrivateKey.Hide()
+	}
+	if k.Passphrase != nil {
+		k.Passphrase.Hide()
+		if k.Passphrase.IsEmpty() {
+			k.Passphrase = nil
+		}
+	}
+}
+
+func (k *SFTPDHostKey) getACopy() SFTPDHostKey {
+	var privateKey, passphrase *kms.Secret
+	if k.PrivateKey != nil {
+		privateKey = k.PrivateKey.Clone()
+	}
+	if k.Passphrase != nil {
+		passphrase = k.Passphrase.Clone()
+	}
+	return SFTPDHostKey{
+		Name:       k.Name,
+		PrivateKey: privateKey,
+		Passphrase: passphrase,
+	}
+}
+
 // SFTPDConfigs defines configurations for SFTPD
 type SFTPDConfigs struct {
 	HostKeyAlgos   []string `json:"host_key_algos,omitempty"`
@@ -54,9 +149,38 @@ type SFTPDConfigs struct {
 	KexAlgorithms  []string `json:"kex_algorithms,omitempty"`
 	Ciphers        []string `json:"ciphers,omitempty"`
 	MACs           []string `json:"macs,omitempty"`
+	// MergePolicy defines how the algorithms are merged with the ones
+	// defined in the configuration file
+	MergePolicy int `json:"merge_policy,omitempty"`
+	// HostKeys are private host keys shared among multiple instances,
+	// they are referenced in the SFTP service configuration using the
+	// "provider://" prefix followed by the key name
+	HostKeys []SFTPDHostKey `json:"host_keys,omitempty"`
+	// MOTD is the message of the day template, it takes precedence over
+	// the template file defined in the SFTP service configuration
+	MOTD string `json:"motd,omitempty"`
+}
+
+// GetHostKey returns the host key with the specified name, if any
+func (c *SFTPDConfigs) GetHostKey(name string) (SFTPDHostKey, bool) {
+	for idx := range c.HostKeys {
+		if c.HostKeys[idx].Name == name {
+			return c.HostKeys[idx].getACopy(), true
+		}
+	}
+	return SFTPDHostKey{}, false
 }
 
 func (c *SFTPDConfigs) isEmpty() bool {
+	if len(c.HostKeys) > 0 {
+		return false
+	}
+	if c.MOTD != "" {
+		return false
+	}
+	if c.MergePolicy != SFTPDMergePolicyAppend {
+		return false
+	}
 	if len(c.HostKeyAlgos) > 0 {
 		return false
 	}
@@ -75,38 +199,63 @@ func (c *SFTPDConfigs) isEmpty() bool {
 	return true
 }
 
-// GetSupportedHostKeyAlgos returns the supported legacy host key algos
-func (*SFTPDConfigs) GetSupportedHostKeyAlgos() []string {
+// IsOverride returns true if the algorithms defined in the configuration file are overridden
+func (c *SFTPDConfigs) IsOverride() bool {
+	return c.MergePolicy == SFTPDMergePolicyOverride
+}
+
+// GetSupportedHostKeyAlgos returns the supported legacy host key algos or
+// all the supported host key algos if the merge policy is override
+func (c *SFTPDConfigs) GetSupportedHostKeyAlgos() []string {
+	if c.IsOverride() {
+		return overrideSupportedHostKeyAlgos
+	}
 	return supportedHostKeyAlgos
 }
 
-// GetSupportedPublicKeyAlgos returns the supported legacy public key algos
-func (*SFTPDConfigs) GetSupportedPublicKeyAlgos() []string {
+// GetSupportedPublicKeyAlgos returns the supported legacy public key algos or
+// all the supported public key algos if the merge policy is override
+func (c *SFTPDConfigs) GetSupportedPublicKeyAlgos() []string {
+	if c.IsOverride() {
+		return overrideSupportedPubKeyAlgos
+	}
 	return supportedPublicKeyAlgos
 }
 
 // GetSupportedKEXAlgos returns the supported KEX algos
-func (*SFTPDConfigs) GetSupportedKEXAlgos() []string {
+func (c *SFTPDConfigs) GetSupportedKEXAlgos() []string {
+	if c.IsOverride() {
+		return overrideSupportedKexAlgos
+	}
 	return supportedKexAlgos
 }
 
 // GetSupportedCiphers returns the supported ciphers
-func (*SFTPDConfigs) GetSupportedCiphers() []string {
+func (c *SFTPDConfigs) GetSupportedCiphers() []string {
+	if c.IsOverride() {
+		return overrideSupportedCiphers
+	}
 	return supportedCiphers
 }
 
 // GetSupportedMACs returns the supported MACs algos
-func (*SFTPDConfigs) GetSupportedMACs() []string {
+func (c *SFTPDConfigs) GetSupportedMACs() []string {
+	if c.IsOverride() {
+		return overrideSupportedMACs
+	}
 	return supportedMACs
 }
 
 func (c *SFTPDConfigs) validate() error {
+	if c.MergePolicy != SFTPDMergePolicyAppend && c.MergePolicy != SFTPDMergePolicyOverride {
+		return util.NewValidationError(fmt.Sprintf("sftpd: invalid merge policy %d", c.MergePolicy))
+	}
 	var hostKeyAlgos []string
 	for _, algo := range c.HostKeyAlgos {
 		if algo == ssh.CertAlgoRSAv01 {
 			continue
 		}
-		if !slices.Contains(supportedHostKeyAlgos, algo) {
+		if !slices.Contains(c.GetSupportedHostKeyAlgos(), algo) {
 			return util.NewValidationError(fmt.Sprintf("unsupported host key algorithm %q", algo))
 		}
 		hostKeyAlgos = append(hostKeyAlgos, algo)
@@ -114,33 +263,63 @@ func (c *SFTPDConfigs) validate() error {
 	c.HostKeyAlgos = hostKeyAlgos
 	var kexAlgos []string
 	for _, algo := range c.KexAlgorithms {
-		if algo == "diffie-hellman-group18-sha512" || algo == ssh.KeyExchangeDHGEXSHA256 {
+		if algo == "diffie-hellman-group18-sha512" || (algo == ssh.KeyExchangeDHGEXSHA256 && !c.IsOverride()) {
 			continue
 		}
-		if !slices.Contains(supportedKexAlgos, algo) {
+		if !slices.Contains(c.GetSupportedKEXAlgos(), algo) {
 			return util.NewValidationError(fmt.Sprintf("unsupported KEX algorithm %q", algo))
 		}
 		kexAlgos = append(kexAlgos, algo)
 	}
 	c.KexAlgorithms = kexAlgos
 	for _, cipher := range c.Ciphers {
-		if !slices.Contains(supportedCiphers, cipher) {
+		if !slices.Contains(c.GetSupportedCiphers(), cipher) {
 			return util.NewValidationError(fmt.Sprintf("unsupported cipher %q", cipher))
 		}
 	}
 	for _, mac := range c.MACs {
-		if !slices.Contains(supportedMACs, mac) {
+		if !slices.Contains(c.GetSupportedMACs(), mac) {
 			return util.NewValidationError(fmt.Sprintf("unsupported MAC algorithm %q", mac))
 		}
 	}
 	for _, algo := range c.PublicKeyAlgos {
-		if !slices.Contains(supportedPublicKeyAlgos, algo) {
+		if !slices.Contains(c.GetSupportedPublicKeyAlgos(), algo) {
 			return util.NewValidationError(fmt.Sprintf("unsupported public key algorithm %q", algo))
 		}
 	}
+	hostKeyNames := make(map[string]bool)
+	for idx := range c.HostKeys {
+		k := &c.HostKeys[idx]
+		if err := k.validate(); err != nil {
+			return err
+		}
+		if hostKeyNames[k.Name] {
+			return util.NewValidationError(fmt.Sprintf("sftpd: duplicated host key %q", k.Name))
+		}
+		hostKeyNames[k.Name] = true
+	}
+	return c.validateMOTD()
+}
+
+func (c *SFTPDConfigs) validateMOTD() error {
+	if c.MOTD == "" {
+		return nil
+	}
+	if len(c.MOTD) > maxMOTDSize {
+		return util.NewValidationError(fmt.Sprintf("sftpd: the MOTD cannot be longer than %d bytes", maxMOTDSize))
+	}
+	if _, err := template.New("motd").Parse(c.MOTD); err != nil {
+		return util.NewValidationError(fmt.Sprintf("sftpd: invalid MOTD template: %v", err))
+	}
 	return nil
 }
 
+func (c *SFTPDConfigs) prepareForRendering() {
+	for idx := range c.HostKeys {
+		c.HostKeys[idx].prepareForRendering()
+	}
+}
+
 func (c *SFTPDConfigs) getACopy() *SFTPDConfigs {
 	hostKeys := make([]string, len(c.HostKeyAlgos))
 	copy(hostKeys, c.HostKeyAlgos)
@@ -152,6 +331,10 @@ func (c *SFTPDConfigs) getACopy() *SFTPDConfigs {
 	copy(ciphers, c.Ciphers)
 	macs := make([]string, len(c.MACs))
 	copy(macs, c.MACs)
+	var privateHostKeys []SFTPDHostKey
+	for idx := range c.HostKeys {
+		privateHostKeys = append(privateHostKeys, c.HostKeys[idx].getACopy())
+	}
 
 	return &SFTPDConfigs{
 		HostKeyAlgos:   hostKeys,
@@ -159,23 +342,30 @@ func (c *SFTPDConfigs) getACopy() *SFTPDConfigs {
 		KexAlgorithms:  kexs,
 		Ciphers:        ciphers,
 		MACs:           macs,
+		MergePolicy:    c.MergePolicy,
+		HostKeys:       privateHostKeys,
+		MOTD:           c.MOTD,
 	}
 }
 
 func validateSMTPSecret(secret *kms.Secret, name string) error {
+	return validateConfigSecret(secret, "smtp", name)
+}
+
+func validateConfigSecret(secret *kms.Secret, scope, name string) error {
 	if secret.IsRedacted() {
-		return util.NewValidationError(fmt.Sprintf("cannot save a redacted smtp %s", name))
+		return util.NewValidationError(fmt.Sprintf("cannot save a redacted %s %s", scope, name))
 	}
 	if secret.IsEncrypted() && !secret.IsValid() {
-		return util.NewValidationError(fmt.Sprintf("invalid encrypted smtp %s", name))
+		return util.NewValidationError(fmt.Sprintf("invalid encrypted %s %s", scope, name))
 	}
 	if !secret.IsEmpty() && !secret.IsValidInput() {
-		return util.NewValidationError(fmt.Sprintf("invalid smtp %s", name))
+		return util.NewValidationError(fmt.Sprintf("invalid %s %s", scope, name))
 	}
 	if secret.IsPlain() {
-		secret.SetAdditionalData("smtp")
+		secret.SetAdditionalData(scope)
 		if err := secret.Encrypt(); err != nil {
-			return util.NewValidationError(fmt.Sprintf("could not encrypt smtp %s: %v", name, err))
+			return util.NewValidationError(fmt.Sprintf("could not encrypt %s %s: %v", scope, name, err))
 		}
 	}
 	return nil
@@ -585,6 +775,9 @@ func (c *Configs) PrepareForRendering() {
 	if c.Branding != nil && c.Branding.isEmpty() {
 		c.Branding = nil
 	}
+	if c.SFTPD != nil {
+		c.SFTPD.prepareForRendering()
+	}
 	if c.SMTP != nil {
 		c.SMTP.prepareForRendering()
 	}
@@ -70,6 +70,7 @@ import (
 	"github.com/drakkan/sftpgo/v2/internal/httpclient"
 	"github.com/drakkan/sftpgo/v2/internal/kms"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/metric"
 	"github.com/drakkan/sftpgo/v2/internal/mfa"
 	"github.com/drakkan/sftpgo/v2/internal/plugin"
 	"github.com/drakkan/sftpgo/v2/internal/util"
@@ -114,6 +115,22 @@ const (
 	sqlPrefixValidChars       = "abcdefghijklmnopqrstuvwxyz_0123456789"
 	maxHookResponseSize       = 1048576 // 1MB
 	iso8601UTCFormat          = "2006-01-02T15:04:05Z"
+
+	// the following formats are only supported for verification, the hashes
+	// are converted to the configured algorithm after the first successful login
+	ssha512DigestPwdPrefix      = "{SSHA512}"
+	ssha512DigestLowerPwdPrefix = "{ssha512}"
+	cryptSHA512PwdPrefix        = "{CRYPT}$6$"
+	cryptSHA512LowerPwdPrefix   = "{crypt}$6$"
+	drupalPwdPrefix             = "$S$"
+)
+
+// Second factor authentication statuses reported to the post-login hook
+const (
+	SecondFactorStatusNotConfigured    = "not_configured"
+	SecondFactorStatusSkippedByNetwork = "skipped_by_network"
+	SecondFactorStatusRequired         = "required"
+	SecondFactorStatusSatisfied        = "satisfied"
 )
 
 // Supported algorithms for hashing passwords.
@@ -149,6 +166,8 @@ const (
 	DumpScopeRoles   = "roles"
 	DumpScopeIPLists = "ip_lists"
 	DumpScopeConfigs = "configs"
+	// DumpScopeUserTemplates defines the scope for the user templates
+	DumpScopeUserTemplates = "user_templates"
 )
 
 const (
@@ -164,7 +183,7 @@ var (
 	// ValidPerms defines all the valid permissions for a user
 	ValidPerms = []string{PermAny, PermListItems, PermDownload, PermUpload, PermOverwrite, PermCreateDirs, PermRename,
 		PermRenameFiles, PermRenameDirs, PermDelete, PermDeleteFiles, PermDeleteDirs, PermCopy, PermCreateSymlinks,
-		PermChmod, PermChown, PermChtimes}
+		PermChmod, PermChown, PermChtimes, PermDropbox}
 	// ValidLoginMethods defines all the valid login methods
 	ValidLoginMethods = []string{SSHLoginMethodPublicKey, LoginMethodPassword, SSHLoginMethodPassword,
 		SSHLoginMethodKeyboardInteractive, SSHLoginMethodKeyAndPassword, SSHLoginMethodKeyAndKeyboardInt,
@@ -183,6 +202,11 @@ var (
 	ErrNoInitRequired = errors.New("the data provider is up to date")
 	// ErrInvalidCredentials defines the error to return if the supplied credentials are invalid
 	ErrInvalidCredentials = errors.New("invalid credentials")
+	// ErrPublicKeyExpired defines the error to return if the supplied public key is expired
+	ErrPublicKeyExpired = errors.New("public key expired")
+	// ErrTooManySecondFactorAttempts defines the error to return if the one-time code
+	// verification is locked out after too many failed attempts
+	ErrTooManySecondFactorAttempts = errors.New("too many verification attempts")
 	// ErrLoginNotAllowedFromIP defines the error to return if login is denied from the current IP
 	ErrLoginNotAllowedFromIP = errors.New("login is not allowed from this IP")
 	// ErrDuplicatedKey occurs when there is a unique key constraint violation
@@ -198,11 +222,13 @@ var (
 	internalHashPwdPrefixes = []string{argonPwdPrefix, bcryptPwdPrefix}
 	hashPwdPrefixes         = []string{argonPwdPrefix, bcryptPwdPrefix, pbkdf2SHA1Prefix, pbkdf2SHA256Prefix,
 		pbkdf2SHA512Prefix, pbkdf2SHA256B64SaltPrefix, md5cryptPwdPrefix, md5cryptApr1PwdPrefix, md5DigestPwdPrefix,
-		sha256DigestPwdPrefix, sha512DigestPwdPrefix, sha256cryptPwdPrefix, sha512cryptPwdPrefix, yescryptPwdPrefix}
+		sha256DigestPwdPrefix, sha512DigestPwdPrefix, sha256cryptPwdPrefix, sha512cryptPwdPrefix, yescryptPwdPrefix,
+		ssha512DigestPwdPrefix, ssha512DigestLowerPwdPrefix, cryptSHA512PwdPrefix, cryptSHA512LowerPwdPrefix,
+		drupalPwdPrefix}
 	pbkdfPwdPrefixes        = []string{pbkdf2SHA1Prefix, pbkdf2SHA256Prefix, pbkdf2SHA512Prefix, pbkdf2SHA256B64SaltPrefix}
 	pbkdfPwdB64SaltPrefixes = []string{pbkdf2SHA256B64SaltPrefix}
 	unixPwdPrefixes         = []string{md5cryptPwdPrefix, md5cryptApr1PwdPrefix, sha256cryptPwdPrefix, sha512cryptPwdPrefix,
-		yescryptPwdPrefix}
+		yescryptPwdPrefix, cryptSHA512PwdPrefix, cryptSHA512LowerPwdPrefix}
 	digestPwdPrefixes            = []string{md5DigestPwdPrefix, sha256DigestPwdPrefix, sha512DigestPwdPrefix}
 	sharedProviders              = []string{PGSQLDataProviderName, MySQLDataProviderName, CockroachDataProviderName}
 	logSender                    = "dataprovider"
@@ -228,6 +254,11 @@ var (
 	sqlTableRoles                string
 	sqlTableIPLists              string
 	sqlTableConfigs              string
+	sqlTableLoginEvents          string
+	sqlTableTransferWindows      string
+	sqlTableUserTemplates        string
+	sqlTableObjectChanges        string
+	sqlTableObjectChangesLock    string
 	sqlTableSchemaVersion        string
 	argon2Params                 *argon2id.Params
 	lastLoginMinDelay            = 10 * time.Minute
@@ -237,6 +268,7 @@ var (
 	fnReloadRules                FnReloadRules
 	fnRemoveRule                 FnRemoveRule
 	fnHandleRuleForProviderEvent FnHandleRuleForProviderEvent
+	fnRefreshFilesystems         FnRefreshFilesystems
 )
 
 func initSQLTables() {
@@ -262,6 +294,11 @@ func initSQLTables() {
 	sqlTableRoles = "roles"
 	sqlTableIPLists = "ip_lists"
 	sqlTableConfigs = "configurations"
+	sqlTableLoginEvents = "login_events"
+	sqlTableTransferWindows = "transfer_windows"
+	sqlTableUserTemplates = "user_templates"
+	sqlTableObjectChanges = "object_changes"
+	sqlTableObjectChangesLock = "object_changes_lock"
 	sqlTableSchemaVersion = "schema_version"
 }
 
@@ -281,6 +318,22 @@ func SetEventRulesCallbacks(reload FnReloadRules, remove FnRemoveRule, handle Fn
 	fnHandleRuleForProviderEvent = handle
 }
 
+// FnRefreshFilesystems defines the callback to execute after the filesystem
+// configurations for a user may have changed
+type FnRefreshFilesystems func(username string)
+
+// SetRefreshFilesystemsCallback sets the callback to execute after a user,
+// or a folder or group associated to a user, is updated
+func SetRefreshFilesystemsCallback(refresh FnRefreshFilesystems) {
+	fnRefreshFilesystems = refresh
+}
+
+func refreshFilesystems(username string) {
+	if fnRefreshFilesystems != nil {
+		fnRefreshFilesystems(username)
+	}
+}
+
 type schemaVersion struct {
 	Version int
 }
@@ -433,6 +486,17 @@ type Config struct {
 	// you can combine the scopes, for example 3 means password and public key, 5 password and keyboard
 	// interactive and so on
 	ExternalAuthScope int `json:"external_auth_scope" mapstructure:"external_auth_scope"`
+	// ExternalAuthCache defines an optional cache for successful external authentication and
+	// pre-login hook results, repeated identical authentications within the configured TTL
+	// skip the hooks
+	ExternalAuthCache ExternalAuthCacheConfig `json:"external_auth_cache" mapstructure:"external_auth_cache"`
+	// UserCache defines an optional cache for the users fetched from the data provider
+	// while authenticating, the credentials are verified for each authentication attempt
+	UserCache UserCacheConfig `json:"user_cache" mapstructure:"user_cache"`
+	// ReadReplicas defines optional read-only replicas of the primary database
+	ReadReplicas ReadReplicasConfig `json:"read_replicas" mapstructure:"read_replicas"`
+	// AccountLockout defines the lockout for the protocol users after repeated login failures
+	AccountLockout AccountLockoutConfig `json:"account_lockout" mapstructure:"account_lockout"`
 	// Absolute path to an external program or an HTTP URL to invoke just before the user login.
 	// This program/URL allows to modify or create the user trying to login.
 	// It is useful if you have users with dynamic fields to update just before the login.
@@ -476,6 +540,8 @@ type Config struct {
 	PasswordHashing PasswordHashing `json:"password_hashing" mapstructure:"password_hashing"`
 	// PasswordValidation defines the password validation rules
 	PasswordValidation PasswordValidation `json:"password_validation" mapstructure:"password_validation"`
+	// PasswordPolicy defines the policy for the protocol users passwords
+	PasswordPolicy PasswordPolicy `json:"password_policy" mapstructure:"password_policy"`
 	// Verifying argon2 passwords has a high memory and computational cost,
 	// by enabling, in memory, password caching you reduce this cost.
 	PasswordCaching bool `json:"password_caching" mapstructure:"password_caching"`
@@ -516,6 +582,12 @@ type Config struct {
 	Node NodeConfig `json:"node" mapstructure:"node"`
 	// Path to the backup directory. This can be an absolute path or a path relative to the config dir
 	BackupsPath string `json:"backups_path" mapstructure:"backups_path"`
+	// Archives defines the logical backups of the data provider objects
+	Archives ArchiveConfig `json:"archives" mapstructure:"archives"`
+	// LoginEvents defines the configuration for the login history
+	LoginEvents LoginEventsConfig `json:"login_events" mapstructure:"login_events"`
+	// ChangeFeed defines the configuration for the feed of the users, groups and folders changes
+	ChangeFeed ChangeFeedConfig `json:"change_feed" mapstructure:"change_feed"`
 }
 
 // GetShared returns the provider share mode.
@@ -636,6 +708,12 @@ type TransferQuota struct {
 	AllowedULSize    int64
 	AllowedDLSize    int64
 	AllowedTotalSize int64
+	// transfer quota window budget and remaining budget, as bytes
+	WindowSize        int64
+	AllowedWindowSize int64
+	// maximum data transfer, as bytes, allowed for a single transfer
+	// if the window budget is enforced during the transfers
+	MaxWindowTransferSize int64
 }
 
 // HasSizeLimits returns true if any size limit is set
@@ -645,6 +723,9 @@ func (q *TransferQuota) HasSizeLimits() bool {
 
 // HasUploadSpace returns true if there is transfer upload space available
 func (q *TransferQuota) HasUploadSpace() bool {
+	if q.WindowSize > 0 && q.AllowedWindowSize <= 0 {
+		return false
+	}
 	if q.TotalSize <= 0 && q.ULSize <= 0 {
 		return true
 	}
@@ -656,6 +737,9 @@ func (q *TransferQuota) HasUploadSpace() bool {
 
 // HasDownloadSpace returns true if there is transfer download space available
 func (q *TransferQuota) HasDownloadSpace() bool {
+	if q.WindowSize > 0 && q.AllowedWindowSize <= 0 {
+		return false
+	}
 	if q.TotalSize <= 0 && q.DLSize <= 0 {
 		return true
 	}
@@ -703,18 +787,19 @@ func (d *DefenderEntry) MarshalJSON() ([]byte, error) {
 
 // BackupData defines the structure for the backup/restore files
 type BackupData struct {
-	Users        []User                  `json:"users"`
-	Groups       []Group                 `json:"groups"`
-	Folders      []vfs.BaseVirtualFolder `json:"folders"`
-	Admins       []Admin                 `json:"admins"`
-	APIKeys      []APIKey                `json:"api_keys"`
-	Shares       []Share                 `json:"shares"`
-	EventActions []BaseEventAction       `json:"event_actions"`
-	EventRules   []EventRule             `json:"event_rules"`
-	Roles        []Role                  `json:"roles"`
-	IPLists      []IPListEntry           `json:"ip_lists"`
-	Configs      *Configs                `json:"configs"`
-	Version      int                     `json:"version"`
+	Users         []User                  `json:"users"`
+	Groups        []Group                 `json:"groups"`
+	Folders       []vfs.BaseVirtualFolder `json:"folders"`
+	Admins        []Admin                 `json:"admins"`
+	APIKeys       []APIKey                `json:"api_keys"`
+	Shares        []Share                 `json:"shares"`
+	EventActions  []BaseEventAction       `json:"event_actions"`
+	EventRules    []EventRule             `json:"event_rules"`
+	Roles         []Role                  `json:"roles"`
+	IPLists       []IPListEntry           `json:"ip_lists"`
+	Configs       *Configs                `json:"configs"`
+	UserTemplates []UserTemplate          `json:"user_templates"`
+	Version       int                     `json:"version"`
 }
 
 // HasFolder returns true if the folder with the given name is included
@@ -760,35 +845,43 @@ type Provider interface {
 	updateQuota(username string, filesAdd int, sizeAdd int64, reset bool) error
 	updateTransferQuota(username string, uploadSize, downloadSize int64, reset bool) error
 	getUsedQuota(username string) (int, int64, int64, int64, error)
+	updateTransferWindowUsage(username string, start, end, size int64) error
+	getTransferWindowUsage(username string) (transferWindowUsage, error)
+	setTransferWindowNotified(username string, start int64, threshold int) (bool, error)
+	cleanupTransferWindowUsages(before int64) error
+	deleteTransferWindowUsage(username string) error
 	userExists(username, role string) (User, error)
-	addUser(user *User) error
-	updateUser(user *User) error
-	deleteUser(user User, softDelete bool) error
+	addUser(user *User, change *ObjectChange) error
+	updateUser(user *User, change *ObjectChange) error
+	deleteUser(user User, softDelete bool, change *ObjectChange) error
 	updateUserPassword(username, password string) error // used internally when converting passwords from other hash
-	getUsers(limit int, offset int, order, role string) ([]User, error)
+	getUsers(limit int, offset int, order, role, usernamePrefix string) ([]User, error)
 	dumpUsers() ([]User, error)
 	getRecentlyUpdatedUsers(after int64) ([]User, error)
 	getUsersForQuotaCheck(toFetch map[string]bool) ([]User, error)
 	updateLastLogin(username string) error
+	updatePublicKeyLastUse(username, key string) error
+	consumeUserLogin(username string, maxLogins int) error
 	updateAdminLastLogin(username string) error
 	setUpdatedAt(username string)
 	getAdminSignature(username string) (string, error)
 	getUserSignature(username string) (string, error)
 	getFolders(limit, offset int, order string, minimal bool) ([]vfs.BaseVirtualFolder, error)
 	getFolderByName(name string) (vfs.BaseVirtualFolder, error)
-	addFolder(folder *vfs.BaseVirtualFolder) error
-	updateFolder(folder *vfs.BaseVirtualFolder) error
-	deleteFolder(folder vfs.BaseVirtualFolder) error
+	addFolder(folder *vfs.BaseVirtualFolder, change *ObjectChange) error
+	updateFolder(folder *vfs.BaseVirtualFolder, change *ObjectChange) error
+	deleteFolder(folder vfs.BaseVirtualFolder, change *ObjectChange) error
 	updateFolderQuota(name string, filesAdd int, sizeAdd int64, reset bool) error
+	updateFolderQuotaScan(name string, scan vfs.FolderQuotaScan) error
 	getUsedFolderQuota(name string) (int, int64, error)
 	dumpFolders() ([]vfs.BaseVirtualFolder, error)
 	getGroups(limit, offset int, order string, minimal bool) ([]Group, error)
 	getGroupsWithNames(names []string) ([]Group, error)
 	getUsersInGroups(names []string) ([]string, error)
 	groupExists(name string) (Group, error)
-	addGroup(group *Group) error
-	updateGroup(group *Group) error
-	deleteGroup(group Group) error
+	addGroup(group *Group, change *ObjectChange) error
+	updateGroup(group *Group, change *ObjectChange) error
+	deleteGroup(group Group, change *ObjectChange) error
 	dumpGroups() ([]Group, error)
 	adminExists(username string) (Admin, error)
 	addAdmin(admin *Admin) error
@@ -819,6 +912,7 @@ type Provider interface {
 	addDefenderEvent(ip string, score int) error
 	setDefenderBanTime(ip string, banTime int64) error
 	cleanupDefender(from int64) error
+	importDefenderHosts(hosts []DefenderEntry, replace bool) error
 	addActiveTransfer(transfer ActiveTransfer) error
 	updateActiveTransferSizes(ulSize, dlSize, transferID int64, connectionID string) error
 	removeActiveTransfer(transferID int64, connectionID string) error
@@ -827,6 +921,7 @@ type Provider interface {
 	addSharedSession(session Session) error
 	deleteSharedSession(key string, sessionType SessionType) error
 	getSharedSession(key string, sessionType SessionType) (Session, error)
+	replaceSharedSession(session Session, previousData []byte) (bool, error)
 	cleanupSharedSessions(sessionType SessionType, before int64) error
 	getEventActions(limit, offset int, order string, minimal bool) ([]BaseEventAction, error)
 	dumpEventActions() ([]BaseEventAction, error)
@@ -843,8 +938,9 @@ type Provider interface {
 	deleteEventRule(rule EventRule, softDelete bool) error
 	getTaskByName(name string) (Task, error)
 	addTask(name string) error
-	updateTask(name string, version int64) error
-	updateTaskTimestamp(name string) error
+	updateTask(name string, version, updatedAt int64) error
+	updateTaskTimestamp(name string, updatedAt int64) error
+	deleteTask(name string) error
 	setFirstDownloadTimestamp(username string) error
 	setFirstUploadTimestamp(username string) error
 	addNode() error
@@ -858,6 +954,12 @@ type Provider interface {
 	deleteRole(role Role) error
 	getRoles(limit int, offset int, order string, minimal bool) ([]Role, error)
 	dumpRoles() ([]Role, error)
+	userTemplateExists(name string) (UserTemplate, error)
+	addUserTemplate(template *UserTemplate) error
+	updateUserTemplate(template *UserTemplate) error
+	deleteUserTemplate(template UserTemplate) error
+	getUserTemplates(limit int, offset int, order string) ([]UserTemplate, error)
+	dumpUserTemplates() ([]UserTemplate, error)
 	ipListEntryExists(ipOrNet string, listType IPListType) (IPListEntry, error)
 	addIPListEntry(entry *IPListEntry) error
 	updateIPListEntry(entry *IPListEntry) error
@@ -869,6 +971,11 @@ type Provider interface {
 	getListEntriesForIP(ip string, listType IPListType) ([]IPListEntry, error)
 	getConfigs() (Configs, error)
 	setConfigs(configs *Configs) error
+	addLoginEvents(events []LoginEvent) error
+	getLoginEvents(filter LoginEventFilter) ([]LoginEvent, error)
+	cleanupLoginEvents(before int64) error
+	getObjectChanges(sinceID int64, limit int) ([]ObjectChange, error)
+	cleanupObjectChanges(before int64) error
 	checkAvailability() error
 	close() error
 	reloadConfig() error
@@ -918,12 +1025,41 @@ func Initialize(cnf Config, basePath string, checkAdmins bool) error {
 	if err := validateHooks(); err != nil {
 		return err
 	}
+	if err := config.LoginEvents.validate(); err != nil {
+		return err
+	}
+	if err := config.ChangeFeed.validate(); err != nil {
+		return err
+	}
+	if err := config.ExternalAuthCache.validate(); err != nil {
+		return err
+	}
+	if err := config.UserCache.validate(); err != nil {
+		return err
+	}
+	if err := config.ReadReplicas.validate(); err != nil {
+		return err
+	}
+	if err := config.Archives.validate(); err != nil {
+		return err
+	}
+	if err := config.AccountLockout.validate(); err != nil {
+		return err
+	}
+	if err := config.PasswordPolicy.initialize(basePath); err != nil {
+		return err
+	}
+	cachedExternalAuths.clear()
+	cachedUserLookups.clear()
 	if err := createProvider(basePath); err != nil {
 		return err
 	}
 	if err := checkDatabase(checkAdmins); err != nil {
 		return err
 	}
+	if err := initializeReadReplicas(); err != nil {
+		return err
+	}
 	admins, err := provider.getAdmins(1, 0, OrderASC)
 	if err != nil {
 		return err
@@ -933,6 +1069,7 @@ func Initialize(cnf Config, basePath string, checkAdmins bool) error {
 		return err
 	}
 	delayedQuotaUpdater.start()
+	loginEvents.start()
 	if currentNode != nil {
 		config.BackupsPath = filepath.Join(config.BackupsPath, currentNode.Name)
 	}
@@ -1072,17 +1209,23 @@ func validateSQLTablesPrefix() error {
 		sqlTableRoles = config.SQLTablesPrefix + sqlTableRoles
 		sqlTableIPLists = config.SQLTablesPrefix + sqlTableIPLists
 		sqlTableConfigs = config.SQLTablesPrefix + sqlTableConfigs
+		sqlTableLoginEvents = config.SQLTablesPrefix + sqlTableLoginEvents
+		sqlTableTransferWindows = config.SQLTablesPrefix + sqlTableTransferWindows
+		sqlTableUserTemplates = config.SQLTablesPrefix + sqlTableUserTemplates
+		sqlTableObjectChanges = config.SQLTablesPrefix + sqlTableObjectChanges
+		sqlTableObjectChangesLock = config.SQLTablesPrefix + sqlTableObjectChangesLock
 		sqlTableSchemaVersion = config.SQLTablesPrefix + sqlTableSchemaVersion
 		providerLog(logger.LevelDebug, "sql table for users %q, folders %q users folders mapping %q admins %q "+
 			"api keys %q shares %q defender hosts %q defender events %q transfers %q  groups %q "+
 			"users groups mapping %q admins groups mapping %q groups folders mapping %q shared sessions %q "+
 			"schema version %q events actions %q events rules %q rules actions mapping %q tasks %q nodes %q roles %q"+
-			"ip lists %q configs %q",
+			"ip lists %q configs %q login events %q transfer windows %q user templates %q object changes %q object changes lock %q",
 			sqlTableUsers, sqlTableFolders, sqlTableUsersFoldersMapping, sqlTableAdmins, sqlTableAPIKeys,
 			sqlTableShares, sqlTableDefenderHosts, sqlTableDefenderEvents, sqlTableActiveTransfers, sqlTableGroups,
 			sqlTableUsersGroupsMapping, sqlTableAdminsGroupsMapping, sqlTableGroupsFoldersMapping, sqlTableSharedSessions,
 			sqlTableSchemaVersion, sqlTableEventsActions, sqlTableEventsRules, sqlTableRulesActionsMapping,
-			sqlTableTasks, sqlTableNodes, sqlTableRoles, sqlTableIPLists, sqlTableConfigs)
+			sqlTableTasks, sqlTableNodes, sqlTableRoles, sqlTableIPLists, sqlTableConfigs, sqlTableLoginEvents,
+			sqlTableTransferWindows, sqlTableUserTemplates, sqlTableObjectChanges, sqlTableObjectChangesLock)
 	}
 	return nil
 }
@@ -1156,6 +1299,9 @@ func CheckAdminAndPass(username, password, ip string) (Admin, error) {
 
 // CheckCachedUserCredentials checks the credentials for a cached user
 func CheckCachedUserCredentials(user *CachedUser, password, ip, loginMethod, protocol string, tlsCert *x509.Certificate) (*CachedUser, *User, error) {
+	if err := CheckAccountLockout(user.User.Username); err != nil {
+		return user, nil, err
+	}
 	if !user.User.skipExternalAuth() && isExternalAuthConfigured(loginMethod) {
 		u, _, err := CheckCompositeCredentials(user.User.Username, password, ip, loginMethod, protocol, tlsCert)
 		if err != nil {
@@ -1242,6 +1388,9 @@ func CheckCompositeCredentials(username, password, ip, loginMethod, protocol str
 // CheckUserBeforeTLSAuth checks if a user exits before trying mutual TLS
 func CheckUserBeforeTLSAuth(username, ip, protocol string, tlsCert *x509.Certificate) (User, error) {
 	username = config.convertName(username)
+	if err := CheckAccountLockout(username); err != nil {
+		return newAccountLockoutError(username)
+	}
 	if plugin.Handler.HasAuthScope(plugin.AuthScopeTLSCertificate) {
 		user, err := doPluginAuth(username, "", nil, ip, protocol, tlsCert, plugin.AuthScopeTLSCertificate)
 		if err != nil {
@@ -1278,6 +1427,9 @@ func CheckUserBeforeTLSAuth(username, ip, protocol string, tlsCert *x509.Certifi
 // given TLS certificate allow authentication without password
 func CheckUserAndTLSCert(username, ip, protocol string, tlsCert *x509.Certificate) (User, error) {
 	username = config.convertName(username)
+	if err := CheckAccountLockout(username); err != nil {
+		return newAccountLockoutError(username)
+	}
 	if plugin.Handler.HasAuthScope(plugin.AuthScopeTLSCertificate) {
 		user, err := doPluginAuth(username, "", nil, ip, protocol, tlsCert, plugin.AuthScopeTLSCertificate)
 		if err != nil {
@@ -1286,17 +1438,39 @@ func CheckUserAndTLSCert(username, ip, protocol string, tlsCert *x509.Certificat
 		return checkUserAndTLSCertificate(&user, protocol, tlsCert)
 	}
 	if config.ExternalAuthHook != "" && (config.ExternalAuthScope == 0 || config.ExternalAuthScope&8 != 0) {
+		if user, ok := cachedExternalAuths.get(username, LoginMethodTLSCertificate, getTLSCertRaw(tlsCert)); ok {
+			return checkUserAndTLSCertificate(&user, protocol, tlsCert)
+		}
 		user, err := doExternalAuth(username, "", nil, "", ip, protocol, tlsCert)
 		if err != nil {
 			return user, err
 		}
-		return checkUserAndTLSCertificate(&user, protocol, tlsCert)
+		user, err = checkUserAndTLSCertificate(&user, protocol, tlsCert)
+		if err == nil {
+			cachedExternalAuths.add(username, LoginMethodTLSCertificate, getTLSCertRaw(tlsCert), &user)
+		}
+		return user, err
 	}
 	if config.PreLoginHook != "" {
+		if user, ok := cachedExternalAuths.get(username, LoginMethodTLSCertificate, getTLSCertRaw(tlsCert)); ok {
+			return checkUserAndTLSCertificate(&user, protocol, tlsCert)
+		}
 		user, err := executePreLoginHook(username, LoginMethodTLSCertificate, ip, protocol, nil)
 		if err != nil {
 			return user, err
 		}
+		user, err = checkUserAndTLSCertificate(&user, protocol, tlsCert)
+		if err == nil {
+			cachedExternalAuths.add(username, LoginMethodTLSCertificate, getTLSCertRaw(tlsCert), &user)
+		}
+		return user, err
+	}
+	if config.UserCache.Enabled && tlsCert != nil {
+		user, err := getUserForAuth(username)
+		if err != nil {
+			providerLog(logger.LevelWarn, "error authenticating user %q: %v", username, err)
+			return user, err
+		}
 		return checkUserAndTLSCertificate(&user, protocol, tlsCert)
 	}
 	return provider.validateUserAndTLSCert(username, protocol, tlsCert)
@@ -1305,6 +1479,9 @@ func CheckUserAndTLSCert(username, ip, protocol string, tlsCert *x509.Certificat
 // CheckUserAndPass retrieves the SFTPGo user with the given username and password if a match is found or an error
 func CheckUserAndPass(username, password, ip, protocol string) (User, error) {
 	username = config.convertName(username)
+	if err := CheckAccountLockout(username); err != nil {
+		return newAccountLockoutError(username)
+	}
 	if plugin.Handler.HasAuthScope(plugin.AuthScopePassword) {
 		user, err := doPluginAuth(username, password, nil, ip, protocol, nil, plugin.AuthScopePassword)
 		if err != nil {
@@ -1313,17 +1490,39 @@ func CheckUserAndPass(username, password, ip, protocol string) (User, error) {
 		return checkUserAndPass(&user, password, ip, protocol)
 	}
 	if config.ExternalAuthHook != "" && (config.ExternalAuthScope == 0 || config.ExternalAuthScope&1 != 0) {
+		if user, ok := cachedExternalAuths.get(username, LoginMethodPassword, []byte(password)); ok {
+			return checkUserAndPass(&user, password, ip, protocol)
+		}
 		user, err := doExternalAuth(username, password, nil, "", ip, protocol, nil)
 		if err != nil {
 			return user, err
 		}
-		return checkUserAndPass(&user, password, ip, protocol)
+		user, err = checkUserAndPass(&user, password, ip, protocol)
+		if err == nil {
+			cachedExternalAuths.add(username, LoginMethodPassword, []byte(password), &user)
+		}
+		return user, err
 	}
 	if config.PreLoginHook != "" {
+		if user, ok := cachedExternalAuths.get(username, LoginMethodPassword, []byte(password)); ok {
+			return checkUserAndPass(&user, password, ip, protocol)
+		}
 		user, err := executePreLoginHook(username, LoginMethodPassword, ip, protocol, nil)
 		if err != nil {
 			return user, err
 		}
+		user, err = checkUserAndPass(&user, password, ip, protocol)
+		if err == nil {
+			cachedExternalAuths.add(username, LoginMethodPassword, []byte(password), &user)
+		}
+		return user, err
+	}
+	if config.UserCache.Enabled {
+		user, err := getUserForAuth(username)
+		if err != nil {
+			providerLog(logger.LevelWarn, "error authenticating user %q: %v", username, err)
+			return user, err
+		}
 		return checkUserAndPass(&user, password, ip, protocol)
 	}
 	return provider.validateUserAndPass(username, password, ip, protocol)
@@ -1332,6 +1531,10 @@ func CheckUserAndPass(username, password, ip, protocol string) (User, error) {
 // CheckUserAndPubKey retrieves the SFTP user with the given username and public key if a match is found or an error
 func CheckUserAndPubKey(username string, pubKey []byte, ip, protocol string, isSSHCert bool) (User, string, error) {
 	username = config.convertName(username)
+	if err := CheckAccountLockout(username); err != nil {
+		user, err := newAccountLockoutError(username)
+		return user, "", err
+	}
 	if plugin.Handler.HasAuthScope(plugin.AuthScopePublicKey) {
 		user, err := doPluginAuth(username, "", pubKey, ip, protocol, nil, plugin.AuthScopePublicKey)
 		if err != nil {
@@ -1340,17 +1543,39 @@ func CheckUserAndPubKey(username string, pubKey []byte, ip, protocol string, isS
 		return checkUserAndPubKey(&user, pubKey, isSSHCert)
 	}
 	if config.ExternalAuthHook != "" && (config.ExternalAuthScope == 0 || config.ExternalAuthScope&2 != 0) {
+		if user, ok := cachedExternalAuths.get(username, SSHLoginMethodPublicKey, pubKey); ok {
+			return checkUserAndPubKey(&user, pubKey, isSSHCert)
+		}
 		user, err := doExternalAuth(username, "", pubKey, "", ip, protocol, nil)
 		if err != nil {
 			return user, "", err
 		}
-		return checkUserAndPubKey(&user, pubKey, isSSHCert)
+		user, keyID, err := checkUserAndPubKey(&user, pubKey, isSSHCert)
+		if err == nil {
+			cachedExternalAuths.add(username, SSHLoginMethodPublicKey, pubKey, &user)
+		}
+		return user, keyID, err
 	}
 	if config.PreLoginHook != "" {
+		if user, ok := cachedExternalAuths.get(username, SSHLoginMethodPublicKey, pubKey); ok {
+			return checkUserAndPubKey(&user, pubKey, isSSHCert)
+		}
 		user, err := executePreLoginHook(username, SSHLoginMethodPublicKey, ip, protocol, nil)
 		if err != nil {
 			return user, "", err
 		}
+		user, keyID, err := checkUserAndPubKey(&user, pubKey, isSSHCert)
+		if err == nil {
+			cachedExternalAuths.add(username, SSHLoginMethodPublicKey, pubKey, &user)
+		}
+		return user, keyID, err
+	}
+	if config.UserCache.Enabled && len(pubKey) > 0 {
+		user, err := getUserForAuth(username)
+		if err != nil {
+			providerLog(logger.LevelWarn, "error authenticating user %q: %v", username, err)
+			return user, "", err
+		}
 		return checkUserAndPubKey(&user, pubKey, isSSHCert)
 	}
 	return provider.validateUserAndPubKey(username, pubKey, isSSHCert)
@@ -1364,6 +1589,9 @@ func CheckKeyboardInteractiveAuth(username, authHook string, client ssh.Keyboard
 	var user User
 	var err error
 	username = config.convertName(username)
+	if err := CheckAccountLockout(username); err != nil {
+		return newAccountLockoutError(username)
+	}
 	if plugin.Handler.HasAuthScope(plugin.AuthScopeKeyboardInteractive) {
 		user, err = doPluginAuth(username, "", nil, ip, protocol, nil, plugin.AuthScopeKeyboardInteractive)
 	} else if config.ExternalAuthHook != "" && (config.ExternalAuthScope == 0 || config.ExternalAuthScope&4 != 0) {
@@ -1371,7 +1599,7 @@ func CheckKeyboardInteractiveAuth(username, authHook string, client ssh.Keyboard
 	} else if config.PreLoginHook != "" {
 		user, err = executePreLoginHook(username, SSHLoginMethodKeyboardInteractive, ip, protocol, nil)
 	} else {
-		user, err = provider.userExists(username, "")
+		user, err = getUserForAuth(username)
 	}
 	if err != nil {
 		return user, err
@@ -1462,6 +1690,13 @@ func CleanupDefender(from int64) error {
 	return provider.cleanupDefender(from)
 }
 
+// ImportDefenderHosts adds the specified hosts in a single transaction.
+// Hosts with a ban time are banned, the score of the other ones is added
+// as a new event. If replace is true the existing hosts are removed
+func ImportDefenderHosts(hosts []DefenderEntry, replace bool) error {
+	return provider.importDefenderHosts(hosts, replace)
+}
+
 // UpdateShareLastUse updates the LastUseAt and UsedTokens for the given share
 func UpdateShareLastUse(share *Share, numTokens int) error {
 	return provider.updateShareLastUse(share.ShareID, numTokens)
@@ -1487,6 +1722,7 @@ func UpdateLastLogin(user *User) {
 		err := provider.updateLastLogin(user.Username)
 		if err == nil {
 			webDAVUsersCache.updateLastLogin(user.Username)
+			cachedUserLookups.updateLastLogin(user.Username)
 		}
 	}
 }
@@ -1547,6 +1783,35 @@ func UpdateVirtualFolderQuota(vfolder *vfs.BaseVirtualFolder, filesAdd int, size
 	return nil
 }
 
+// UpdateVirtualFolderQuotaScan stores the results of a completed quota scan for
+// the given virtual folder. The scanned values replace the tracked quota and the
+// difference between the tracked and the scanned values is stored as drift
+func UpdateVirtualFolderQuotaScan(vfolder *vfs.BaseVirtualFolder, numFiles int, size int64) error {
+	if config.TrackQuota == 0 {
+		return util.NewMethodDisabledError(trackQuotaDisabledError)
+	}
+	trackedFiles, trackedSize, err := GetUsedVirtualFolderQuota(vfolder.Name)
+	if err != nil {
+		return err
+	}
+	scan := vfs.FolderQuotaScan{
+		LastScan:     util.GetTimeAsMsSinceEpoch(time.Now()),
+		ScannedFiles: numFiles,
+		ScannedSize:  size,
+		DriftFiles:   trackedFiles - numFiles,
+		DriftSize:    trackedSize - size,
+	}
+	delayedQuotaUpdater.resetFolderQuota(vfolder.Name)
+	err = provider.updateFolderQuotaScan(vfolder.Name, scan)
+	if err == nil {
+		vfolder.UsedQuotaFiles = numFiles
+		vfolder.UsedQuotaSize = size
+		vfolder.LastQuotaUpdate = scan.LastScan
+		vfolder.QuotaScan = &scan
+	}
+	return err
+}
+
 // UpdateUserTransferQuota updates the transfer quota for the given SFTPGo user.
 // If reset is true uploadSize and downloadSize indicates the actual sizes instead of the difference.
 func UpdateUserTransferQuota(user *User, uploadSize, downloadSize int64, reset bool) error {
@@ -1759,6 +2024,7 @@ func DeleteRole(name string, executor, ipAddress, executorRole string) error {
 		executeAction(operationDelete, executor, ipAddress, actionObjectRole, role.Name, executorRole, &role)
 		for _, user := range role.Users {
 			provider.setUpdatedAt(user)
+			invalidateCachedUser(user)
 			u, err := provider.userExists(user, "")
 			if err == nil {
 				webDAVUsersCache.swap(&u, "")
@@ -1775,11 +2041,96 @@ func RoleExists(name string) (Role, error) {
 	return provider.roleExists(name)
 }
 
+// AddUserTemplate adds a new user template
+func AddUserTemplate(template *UserTemplate, executor, ipAddress, executorRole string) error {
+	template.Name = config.convertName(template.Name)
+	err := provider.addUserTemplate(template)
+	if err == nil {
+		executeAction(operationAdd, executor, ipAddress, actionObjectUserTemplate, template.Name, executorRole, template)
+	}
+	return err
+}
+
+// UpdateUserTemplate updates an existing user template
+func UpdateUserTemplate(template *UserTemplate, executor, ipAddress, executorRole string) error {
+	err := provider.updateUserTemplate(template)
+	if err == nil {
+		executeAction(operationUpdate, executor, ipAddress, actionObjectUserTemplate, template.Name, executorRole, template)
+	}
+	return err
+}
+
+// DeleteUserTemplate deletes an existing user template
+func DeleteUserTemplate(name string, executor, ipAddress, executorRole string) error {
+	name = config.convertName(name)
+	template, err := provider.userTemplateExists(name)
+	if err != nil {
+		return err
+	}
+	err = provider.deleteUserTemplate(template)
+	if err == nil {
+		executeAction(operationDelete, executor, ipAddress, actionObjectUserTemplate, template.Name, executorRole, &template)
+	}
+	return err
+}
+
+// UserTemplateExists returns the user template with the given name if it exists
+func UserTemplateExists(name string) (UserTemplate, error) {
+	name = config.convertName(name)
+	return provider.userTemplateExists(name)
+}
+
+// GetUserTemplates returns an array of user templates respecting limit and offset
+func GetUserTemplates(limit, offset int, order string) ([]UserTemplate, error) {
+	return provider.getUserTemplates(limit, offset, order)
+}
+
+// AddUserFromTemplate creates a user from the template with the given name by
+// replacing the placeholders with the given variables. The virtual folders that
+// do not exist are created from their template definitions. If dryRun is true
+// the expanded and validated user is returned without saving anything
+func AddUserFromTemplate(name string, vars *UserTemplateVariables, dryRun bool, executor, ipAddress, role string) (User, error) {
+	template, err := UserTemplateExists(name)
+	if err != nil {
+		return User{}, err
+	}
+	user, err := template.expand(vars)
+	if err != nil {
+		return user, err
+	}
+	if role != "" {
+		user.Role = role
+	}
+	folders, err := getTemplateFoldersToCreate(&user)
+	if err != nil {
+		return user, err
+	}
+	if dryRun {
+		err = ValidateUser(&user)
+		if err == nil {
+			setTemplateFoldersDefinitions(&user, folders)
+		}
+		return user, err
+	}
+	for idx := range folders {
+		folder := folders[idx]
+		if err := AddFolder(&folder, executor, ipAddress, role); err != nil {
+			return user, fmt.Errorf("unable to add folder %q: %w", folder.Name, err)
+		}
+	}
+	if err := AddUser(&user, executor, ipAddress, role); err != nil {
+		return user, err
+	}
+	return UserExists(user.Username, role)
+}
+
 // AddGroup adds a new group
 func AddGroup(group *Group, executor, ipAddress, role string) error {
 	group.Name = config.convertName(group.Name)
-	err := provider.addGroup(group)
+	change := newObjectChange(operationAdd, actionObjectGroup, group.Name, executor)
+	err := provider.addGroup(group, change)
 	if err == nil {
+		objectChangeSubscribers.notify(change)
 		executeAction(operationAdd, executor, ipAddress, actionObjectGroup, group.Name, role, group)
 	}
 	return err
@@ -1787,13 +2138,16 @@ func AddGroup(group *Group, executor, ipAddress, role string) error {
 
 // UpdateGroup updates an existing Group
 func UpdateGroup(group *Group, users []string, executor, ipAddress, role string) error {
-	err := provider.updateGroup(group)
+	change := newObjectChange(operationUpdate, actionObjectGroup, group.Name, executor)
+	err := provider.updateGroup(group, change)
 	if err == nil {
+		objectChangeSubscribers.notify(change)
 		for _, user := range users {
 			provider.setUpdatedAt(user)
 			u, err := provider.userExists(user, "")
 			if err == nil {
 				webDAVUsersCache.swap(&u, "")
+				refreshFilesystems(u.Username)
 			} else {
 				RemoveCachedWebDAVUser(user)
 			}
@@ -1814,8 +2168,10 @@ func DeleteGroup(name string, executor, ipAddress, role string) error {
 		errorString := fmt.Sprintf("the group %q is referenced, it cannot be removed", group.Name)
 		return util.NewValidationError(errorString)
 	}
-	err = provider.deleteGroup(group)
+	change := newObjectChange(operationDelete, actionObjectGroup, group.Name, executor)
+	err = provider.deleteGroup(group, change)
 	if err == nil {
+		objectChangeSubscribers.notify(change)
 		for _, user := range group.Users {
 			provider.setUpdatedAt(user)
 			u, err := provider.userExists(user, "")
@@ -2001,12 +2357,24 @@ func AddTask(name string) error {
 
 // UpdateTask updates the task with the specified name and version
 func UpdateTask(name string, version int64) error {
-	return provider.updateTask(name, version)
+	return provider.updateTask(name, version, util.GetTimeAsMsSinceEpoch(time.Now()))
+}
+
+// UpdateTaskWithTimestamp updates the task with the specified name and version
+// setting the specified timestamp, that can be in the future
+func UpdateTaskWithTimestamp(name string, version int64, updatedAt time.Time) error {
+	return provider.updateTask(name, version, util.GetTimeAsMsSinceEpoch(updatedAt))
 }
 
 // UpdateTaskTimestamp updates the timestamp for the task with the specified name
 func UpdateTaskTimestamp(name string) error {
-	return provider.updateTaskTimestamp(name)
+	return provider.updateTaskTimestamp(name, util.GetTimeAsMsSinceEpoch(time.Now()))
+}
+
+// SetTaskTimestamp sets the specified timestamp, that can be in the future,
+// for the task with the specified name
+func SetTaskTimestamp(name string, updatedAt time.Time) error {
+	return provider.updateTaskTimestamp(name, util.GetTimeAsMsSinceEpoch(updatedAt))
 }
 
 // GetNodes returns the other cluster nodes
@@ -2073,6 +2441,7 @@ func DeleteAdmin(username, executor, ipAddress, role string) error {
 	if err == nil {
 		executeAction(operationDelete, executor, ipAddress, actionObjectAdmin, admin.Username, role, &admin)
 		cachedAdminPasswords.Remove(username)
+		resetSecondFactorAttempts(username, true)
 	}
 	return err
 }
@@ -2131,8 +2500,10 @@ func GetUserVariants(username, role string) (User, User, error) {
 // AddUser adds a new SFTPGo user.
 func AddUser(user *User, executor, ipAddress, role string) error {
 	user.Username = config.convertName(user.Username)
-	err := provider.addUser(user)
+	change := newObjectChange(operationAdd, actionObjectUser, user.Username, executor)
+	err := provider.addUser(user, change)
 	if err == nil {
+		objectChangeSubscribers.notify(change)
 		executeAction(operationAdd, executor, ipAddress, actionObjectUser, user.Username, role, user)
 	}
 	return err
@@ -2156,9 +2527,12 @@ func UpdateUserPassword(username, plainPwd, executor, ipAddress, role string) er
 	user.Password = userCopy.Password
 	user.Filters.RequirePasswordChange = false
 	// the last password change is set when validating the user
-	if err := provider.updateUser(&user); err != nil {
+	change := newObjectChange(operationUpdate, actionObjectUser, user.Username, executor)
+	if err := provider.updateUser(&user, change); err != nil {
 		return err
 	}
+	objectChangeSubscribers.notify(change)
+	invalidateCachedUser(user.Username)
 	webDAVUsersCache.swap(&user, plainPwd)
 	executeAction(operationUpdate, executor, ipAddress, actionObjectUser, username, role, &user)
 	return nil
@@ -2169,9 +2543,16 @@ func UpdateUser(user *User, executor, ipAddress, role string) error {
 	if user.groupSettingsApplied {
 		return errors.New("cannot save a user with group settings applied")
 	}
-	err := provider.updateUser(user)
+	if storedUser, err := provider.userExists(user.Username, ""); err == nil {
+		user.mergePublicKeysMetadata(&storedUser)
+	}
+	change := newObjectChange(operationUpdate, actionObjectUser, user.Username, executor)
+	err := provider.updateUser(user, change)
 	if err == nil {
+		objectChangeSubscribers.notify(change)
+		invalidateCachedUser(user.Username)
 		webDAVUsersCache.swap(user, "")
+		refreshFilesystems(user.Username)
 		executeAction(operationUpdate, executor, ipAddress, actionObjectUser, user.Username, role, user)
 	}
 	return err
@@ -2184,11 +2565,19 @@ func DeleteUser(username, executor, ipAddress, role string) error {
 	if err != nil {
 		return err
 	}
-	err = provider.deleteUser(user, config.IsShared == 1)
+	change := newObjectChange(operationDelete, actionObjectUser, user.Username, executor)
+	err = provider.deleteUser(user, config.IsShared == 1, change)
 	if err == nil {
+		objectChangeSubscribers.notify(change)
+		invalidateCachedUser(user.Username)
 		RemoveCachedWebDAVUser(user.Username)
 		delayedQuotaUpdater.resetUserQuota(user.Username)
 		cachedUserPasswords.Remove(username)
+		resetSecondFactorAttempts(username, false)
+		if errWindow := provider.deleteTransferWindowUsage(user.Username); errWindow != nil {
+			providerLog(logger.LevelError, "unable to delete the transfer quota window for user %q: %v",
+				user.Username, errWindow)
+		}
 		executeAction(operationDelete, executor, ipAddress, actionObjectUser, user.Username, role, &user)
 	}
 	return err
@@ -2258,6 +2647,18 @@ func GetSharedSession(key string, sessionType SessionType) (Session, error) {
 	return provider.getSharedSession(key, sessionType)
 }
 
+// ReplaceSharedSession stores the session only if the stored data match
+// previousData, nil previousData means that the session must not exist.
+// It returns false if the session was concurrently modified
+func ReplaceSharedSession(session Session, previousData []byte) (bool, error) {
+	replaced, err := provider.replaceSharedSession(session, previousData)
+	if err != nil {
+		providerLog(logger.LevelError, "unable to replace shared session, key %q, type: %v, err: %v",
+			session.Key, session.Type, err)
+	}
+	return replaced, err
+}
+
 // CleanupSharedSessions removes the shared session with the specified type and
 // before the specified time
 func CleanupSharedSessions(sessionType SessionType, before time.Time) error {
@@ -2302,9 +2703,10 @@ func GetGroups(limit, offset int, order string, minimal bool) ([]Group, error) {
 	return provider.getGroups(limit, offset, order, minimal)
 }
 
-// GetUsers returns an array of users respecting limit and offset
-func GetUsers(limit, offset int, order, role string) ([]User, error) {
-	return provider.getUsers(limit, offset, order, role)
+// GetUsers returns an array of users respecting limit and offset.
+// A non-empty usernamePrefix restricts the results to the matching usernames
+func GetUsers(limit, offset int, order, role, usernamePrefix string) ([]User, error) {
+	return provider.getUsers(limit, offset, order, role, usernamePrefix)
 }
 
 // GetUsersForQuotaCheck returns the users with the fields required for a quota check
@@ -2315,8 +2717,10 @@ func GetUsersForQuotaCheck(toFetch map[string]bool) ([]User, error) {
 // AddFolder adds a new virtual folder.
 func AddFolder(folder *vfs.BaseVirtualFolder, executor, ipAddress, role string) error {
 	folder.Name = config.convertName(folder.Name)
-	err := provider.addFolder(folder)
+	change := newObjectChange(operationAdd, actionObjectFolder, folder.Name, executor)
+	err := provider.addFolder(folder, change)
 	if err == nil {
+		objectChangeSubscribers.notify(change)
 		executeAction(operationAdd, executor, ipAddress, actionObjectFolder, folder.Name, role, &wrappedFolder{Folder: *folder})
 	}
 	return err
@@ -2324,8 +2728,10 @@ func AddFolder(folder *vfs.BaseVirtualFolder, executor, ipAddress, role string)
 
 // UpdateFolder updates the specified virtual folder
 func UpdateFolder(folder *vfs.BaseVirtualFolder, users []string, groups []string, executor, ipAddress, role string) error {
-	err := provider.updateFolder(folder)
+	change := newObjectChange(operationUpdate, actionObjectFolder, folder.Name, executor)
+	err := provider.updateFolder(folder, change)
 	if err == nil {
+		objectChangeSubscribers.notify(change)
 		executeAction(operationUpdate, executor, ipAddress, actionObjectFolder, folder.Name, role, &wrappedFolder{Folder: *folder})
 		usersInGroups, errGrp := provider.getUsersInGroups(groups)
 		if errGrp == nil {
@@ -2336,9 +2742,11 @@ func UpdateFolder(folder *vfs.BaseVirtualFolder, users []string, groups []string
 		}
 		for _, user := range users {
 			provider.setUpdatedAt(user)
+			invalidateCachedUser(user)
 			u, err := provider.userExists(user, "")
 			if err == nil {
 				webDAVUsersCache.swap(&u, "")
+				refreshFilesystems(u.Username)
 				executeAction(operationUpdate, executor, ipAddress, actionObjectUser, u.Username, u.Role, &u)
 			} else {
 				RemoveCachedWebDAVUser(user)
@@ -2355,8 +2763,10 @@ func DeleteFolder(folderName, executor, ipAddress, role string) error {
 	if err != nil {
 		return err
 	}
-	err = provider.deleteFolder(folder)
+	change := newObjectChange(operationDelete, actionObjectFolder, folder.Name, executor)
+	err = provider.deleteFolder(folder, change)
 	if err == nil {
+		objectChangeSubscribers.notify(change)
 		executeAction(operationDelete, executor, ipAddress, actionObjectFolder, folder.Name, role, &wrappedFolder{Folder: folder})
 		users := folder.Users
 		usersInGroups, errGrp := provider.getUsersInGroups(folder.Groups)
@@ -2372,6 +2782,7 @@ func DeleteFolder(folderName, executor, ipAddress, role string) error {
 			if err == nil {
 				executeAction(operationUpdate, executor, ipAddress, actionObjectUser, u.Username, u.Role, &u)
 			}
+			invalidateCachedUser(user)
 			RemoveCachedWebDAVUser(user)
 		}
 		delayedQuotaUpdater.resetFolderQuota(folderName)
@@ -2390,6 +2801,69 @@ func GetFolders(limit, offset int, order string, minimal bool) ([]vfs.BaseVirtua
 	return provider.getFolders(limit, offset, order, minimal)
 }
 
+// GetFoldersWithRetention returns the folders with an automatic retention configured
+func GetFoldersWithRetention() ([]vfs.BaseVirtualFolder, error) {
+	folders, err := provider.dumpFolders()
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(folders, func(folder vfs.BaseVirtualFolder) bool {
+		return !folder.Retention.IsEnabled()
+	}), nil
+}
+
+// GetFoldersToReconcile returns the virtual folders whose quota must be scanned
+// again: folders modified after the last scan, folders with pending delayed
+// quota updates and folders not scanned within fullScanInterval, if not zero.
+// Folders with a path placeholder cannot be scanned and are never returned
+func GetFoldersToReconcile(fullScanInterval time.Duration) ([]vfs.BaseVirtualFolder, error) {
+	folders, err := provider.dumpFolders()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return slices.DeleteFunc(folders, func(folder vfs.BaseVirtualFolder) bool {
+		if folder.HasPathPlaceholder() {
+			return true
+		}
+		if folder.IsModifiedSinceQuotaScan() {
+			return false
+		}
+		if files, size := delayedQuotaUpdater.getFolderPendingQuota(folder.Name); files != 0 || size != 0 {
+			return false
+		}
+		if fullScanInterval > 0 {
+			return now.Sub(util.GetTimeFromMsecSinceEpoch(folder.QuotaScan.LastScan)) < fullScanInterval
+		}
+		return true
+	}), nil
+}
+
+// GetS3Filesystems returns the S3 filesystem configurations defined for users
+// and virtual folders
+func GetS3Filesystems() ([]vfs.Filesystem, error) {
+	users, err := provider.dumpUsers()
+	if err != nil {
+		return nil, err
+	}
+	folders, err := provider.dumpFolders()
+	if err != nil {
+		return nil, err
+	}
+	var result []vfs.Filesystem
+	for _, user := range users {
+		if user.FsConfig.Provider == sdk.S3FilesystemProvider {
+			result = append(result, user.FsConfig)
+		}
+	}
+	for _, folder := range folders {
+		if folder.FsConfig.Provider == sdk.S3FilesystemProvider {
+			result = append(result, folder.FsConfig)
+		}
+	}
+	return result, nil
+}
+
 func dumpUsers(data *BackupData, scopes []string) error {
 	if len(scopes) == 0 || slices.Contains(scopes, DumpScopeUsers) {
 		users, err := provider.dumpUsers()
@@ -2500,6 +2974,17 @@ func dumpIPLists(data *BackupData, scopes []string) error {
 	return nil
 }
 
+func dumpUserTemplates(data *BackupData, scopes []string) error {
+	if len(scopes) == 0 || slices.Contains(scopes, DumpScopeUserTemplates) {
+		templates, err := provider.dumpUserTemplates()
+		if err != nil {
+			return err
+		}
+		data.UserTemplates = templates
+	}
+	return nil
+}
+
 func dumpConfigs(data *BackupData, scopes []string) error {
 	if len(scopes) == 0 || slices.Contains(scopes, DumpScopeConfigs) {
 		configs, err := provider.getConfigs()
@@ -2550,6 +3035,9 @@ func DumpData(scopes []string) (BackupData, error) {
 	if err := dumpConfigs(&data, scopes); err != nil {
 		return data, err
 	}
+	if err := dumpUserTemplates(&data, scopes); err != nil {
+		return data, err
+	}
 
 	return data, nil
 }
@@ -2561,6 +3049,11 @@ func ParseDumpData(data []byte) (BackupData, error) {
 	if err != nil {
 		return dump, err
 	}
+	return upgradeDumpData(dump)
+}
+
+// upgradeDumpData applies the required upgrades to the data dumped from previous versions
+func upgradeDumpData(dump BackupData) (BackupData, error) {
 	if dump.Version < 17 {
 		providerLog(logger.LevelInfo, "updating placeholders for actions restored from dump version %d", dump.Version)
 		eventActions, err := updateEventActionPlaceholders(dump.EventActions)
@@ -2569,7 +3062,7 @@ func ParseDumpData(data []byte) (BackupData, error) {
 		}
 		dump.EventActions = eventActions
 	}
-	return dump, err
+	return dump, nil
 }
 
 // GetProviderConfig returns the current provider configuration
@@ -2597,6 +3090,8 @@ func GetProviderStatus() ProviderStatus {
 // Closing an uninitialized provider is not supported
 func Close() error {
 	stopScheduler()
+	loginEvents.stop()
+	closeReadReplicas()
 	return provider.close()
 }
 
@@ -2705,26 +3200,51 @@ func buildUserHomeDir(user *User) {
 func validateFolderQuotaLimits(folder vfs.VirtualFolder) error {
 	if folder.QuotaSize < -1 {
 		return util.NewI18nError(
-			util.NewValidationError(fmt.Sprintf("invalid quota_size: %v folder path %q", folder.QuotaSize, folder.MappedPath)),
+			util.NewValidationErrorWithCode(fmt.Sprintf("invalid quota_size: %v folder path %q", folder.QuotaSize, folder.MappedPath),
+				util.ErrorCodeQuotaInvalid),
 			util.I18nErrorFolderQuotaSizeInvalid,
 		)
 	}
 	if folder.QuotaFiles < -1 {
 		return util.NewI18nError(
-			util.NewValidationError(fmt.Sprintf("invalid quota_file: %v folder path %q", folder.QuotaFiles, folder.MappedPath)),
+			util.NewValidationErrorWithCode(fmt.Sprintf("invalid quota_file: %v folder path %q", folder.QuotaFiles, folder.MappedPath),
+				util.ErrorCodeQuotaInvalid),
 			util.I18nErrorFolderQuotaFileInvalid,
 		)
 	}
 	if (folder.QuotaSize == -1 && folder.QuotaFiles != -1) || (folder.QuotaFiles == -1 && folder.QuotaSize != -1) {
 		return util.NewI18nError(
-			util.NewValidationError(fmt.Sprintf("virtual folder quota_size and quota_files must be both -1 or >= 0, quota_size: %v quota_files: %v",
-				folder.QuotaFiles, folder.QuotaSize)),
+			util.NewValidationErrorWithCode(fmt.Sprintf("virtual folder quota_size and quota_files must be both -1 or >= 0, quota_size: %v quota_files: %v",
+				folder.QuotaFiles, folder.QuotaSize), util.ErrorCodeQuotaInvalid),
 			util.I18nErrorFolderQuotaInvalid,
 		)
 	}
 	return nil
 }
 
+// validateFolderPermissions returns the permissions for the specified mapping
+// without duplicates, nil means no restrictions
+func validateFolderPermissions(folder vfs.VirtualFolder) ([]string, error) {
+	for _, p := range folder.Permissions {
+		if !slices.Contains(ValidPerms, p) {
+			return nil, util.NewI18nError(
+				util.NewValidationError(fmt.Sprintf("invalid permission %q for virtual folder %q", p, folder.VirtualPath)),
+				util.I18nErrorGenericPermission,
+			)
+		}
+	}
+	if len(folder.Permissions) == 0 || slices.Contains(folder.Permissions, PermAny) {
+		return nil, nil
+	}
+	if err := validateDropboxPermissions(folder.Permissions); err != nil {
+		return nil, util.NewI18nError(
+			util.NewValidationError(fmt.Sprintf("invalid permissions for virtual folder %q: %v", folder.VirtualPath, err)),
+			util.I18nErrorGenericPermission,
+		)
+	}
+	return util.RemoveDuplicates(folder.Permissions, false), nil
+}
+
 func validateUserGroups(user *User) error {
 	if len(user.Groups) == 0 {
 		return nil
@@ -2774,6 +3294,10 @@ func validateAssociatedVirtualFolders(vfolders []vfs.VirtualFolder) ([]vfs.Virtu
 		if err := validateFolderQuotaLimits(v); err != nil {
 			return nil, err
 		}
+		permissions, err := validateFolderPermissions(v)
+		if err != nil {
+			return nil, err
+		}
 		if v.Name == "" {
 			return nil, util.NewI18nError(util.NewValidationError("folder name is mandatory"), util.I18nErrorFolderNameRequired)
 		}
@@ -2799,6 +3323,7 @@ func validateAssociatedVirtualFolders(vfolders []vfs.VirtualFolder) ([]vfs.Virtu
 			VirtualPath: cleanedVPath,
 			QuotaSize:   v.QuotaSize,
 			QuotaFiles:  v.QuotaFiles,
+			Permissions: permissions,
 		})
 		folderNames[v.Name] = true
 	}
@@ -2881,6 +3406,10 @@ func validateUserPermissions(permsToCheck map[string][]string) (map[string][]str
 		if slices.Contains(perms, PermAny) {
 			permissions[cleanedDir] = []string{PermAny}
 		} else {
+			if err := validateDropboxPermissions(perms); err != nil {
+				return permissions, util.NewValidationError(fmt.Sprintf("invalid permissions for the directory %q: %v",
+					dir, err))
+			}
 			permissions[cleanedDir] = util.RemoveDuplicates(perms, false)
 		}
 	}
@@ -2888,6 +3417,20 @@ func validateUserPermissions(permsToCheck map[string][]string) (map[string][]str
 	return permissions, nil
 }
 
+// validateDropboxPermissions checks that the dropbox permission is not
+// combined with the permissions that allow to read the existing files
+func validateDropboxPermissions(perms []string) error {
+	if !slices.Contains(perms, PermDropbox) {
+		return nil
+	}
+	for _, p := range []string{PermListItems, PermDownload, PermCopy} {
+		if slices.Contains(perms, p) {
+			return fmt.Errorf("the %q permission cannot be combined with %q", PermDropbox, p)
+		}
+	}
+	return nil
+}
+
 func validatePermissions(user *User) error {
 	if len(user.Permissions) == 0 {
 		return util.NewI18nError(util.NewValidationError("please grant some permissions to this user"), util.I18nErrorNoPermission)
@@ -2904,12 +3447,11 @@ func validatePermissions(user *User) error {
 }
 
 func validatePublicKeys(user *User) error {
-	if len(user.PublicKeys) == 0 {
-		user.PublicKeys = []string{}
-	}
-	var validatedKeys []string
-	for idx, key := range user.PublicKeys {
-		if key == "" {
+	var validatedKeys []UserPublicKey
+	seen := make(map[string]bool)
+	for idx, pk := range user.getPublicKeys() {
+		key := pk.Key
+		if key == "" || seen[key] {
 			continue
 		}
 		out, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key))
@@ -2939,10 +3481,17 @@ func validatePublicKeys(user *User) error {
 				}
 			}
 		}
+		if pk.ExpiresAt < 0 || pk.LastUsedAt < 0 {
+			return util.NewValidationError(fmt.Sprintf("invalid timestamps for public key at position %d", idx))
+		}
+		if len(pk.Comment) > 255 {
+			return util.NewValidationError(fmt.Sprintf("the comment for public key at position %d is too long", idx))
+		}
 
-		validatedKeys = append(validatedKeys, key)
+		seen[key] = true
+		validatedKeys = append(validatedKeys, pk)
 	}
-	user.PublicKeys = util.RemoveDuplicates(validatedKeys, false)
+	user.setPublicKeys(validatedKeys)
 	return nil
 }
 
@@ -3038,6 +3587,39 @@ func validateIPFilters(filters *sdk.BaseUserFilters) error {
 	return nil
 }
 
+func validateTwoFactorTrustedNetworks(networks []string) ([]string, error) {
+	networks = util.RemoveDuplicates(networks, true)
+	for _, network := range networks {
+		_, _, err := net.ParseCIDR(network)
+		if err != nil {
+			return nil, util.NewValidationError(fmt.Sprintf("could not parse two-factor trusted network %q: %v", network, err))
+		}
+	}
+	return networks, nil
+}
+
+func validateUploadSizeLimits(limits []UploadSizeLimit) ([]UploadSizeLimit, error) {
+	var result []UploadSizeLimit
+	for _, limit := range limits {
+		limit.Pattern = strings.TrimSpace(limit.Pattern)
+		if limit.Pattern == "" {
+			return nil, util.NewValidationError("upload size limit pattern is mandatory")
+		}
+		if strings.Contains(limit.Pattern, "/") {
+			limit.Pattern = util.CleanPath(limit.Pattern)
+		}
+		if _, err := path.Match(limit.Pattern, "abc"); err != nil {
+			return nil, util.NewValidationError(fmt.Sprintf("invalid upload size limit pattern %q: %v", limit.Pattern, err))
+		}
+		if limit.MaxSize <= 0 {
+			return nil, util.NewValidationError(fmt.Sprintf("invalid max size %d for upload size limit pattern %q",
+				limit.MaxSize, limit.Pattern))
+		}
+		result = append(result, limit)
+	}
+	return result, nil
+}
+
 func validateBandwidthLimit(bl sdk.BandwidthLimit) error {
 	if len(bl.Sources) == 0 {
 		return util.NewValidationError("no bandwidth limit source specified")
@@ -3077,11 +3659,11 @@ func updateFiltersValues(filters *sdk.BaseUserFilters) {
 
 func validateFilterProtocols(filters *sdk.BaseUserFilters) error {
 	if len(filters.DeniedProtocols) >= len(ValidProtocols) {
-		return util.NewValidationError("invalid denied_protocols")
+		return util.NewValidationErrorWithCode("invalid denied_protocols", util.ErrorCodeDeniedProtocolInvalid)
 	}
 	for _, p := range filters.DeniedProtocols {
 		if !slices.Contains(ValidProtocols, p) {
-			return util.NewValidationError(fmt.Sprintf("invalid denied protocol %q", p))
+			return util.NewValidationErrorWithCode(fmt.Sprintf("invalid denied protocol %q", p), util.ErrorCodeDeniedProtocolInvalid)
 		}
 	}
 
@@ -3267,37 +3849,54 @@ func validateEmails(user *User) error {
 	return nil
 }
 
-func validateBaseParams(user *User) error {
+func validateUsername(user *User) error {
 	if user.Username == "" {
 		return util.NewI18nError(util.NewValidationError("username is mandatory"), util.I18nErrorUsernameRequired)
 	}
 	if err := checkReservedUsernames(user.Username); err != nil {
 		return util.NewI18nError(err, util.I18nErrorReservedUsername)
 	}
-	if err := validateEmails(user); err != nil {
-		return err
-	}
 	if config.NamingRules&1 == 0 && !usernameRegex.MatchString(user.Username) {
 		return util.NewI18nError(
 			util.NewValidationError(fmt.Sprintf("username %q is not valid, the following characters are allowed: a-zA-Z0-9-_.~", user.Username)),
 			util.I18nErrorInvalidUser,
 		)
 	}
+	return nil
+}
+
+func validateUserHomeDir(user *User) error {
+	if user.HomeDir == "" {
+		return util.NewI18nError(util.NewValidationErrorWithCode("home_dir is mandatory", util.ErrorCodeHomeDirRequired),
+			util.I18nErrorHomeRequired)
+	}
+	if !filepath.IsAbs(user.HomeDir) {
+		return util.NewI18nError(
+			util.NewValidationErrorWithCode(fmt.Sprintf("home_dir must be an absolute path, actual value: %v", user.HomeDir),
+				util.ErrorCodeHomeDirInvalid),
+			util.I18nErrorHomeInvalid,
+		)
+	}
+	return nil
+}
+
+// validateBaseParams adds the validation errors to errs, other errors are returned
+func validateBaseParams(user *User, errs *validationErrors) error {
+	if err := errs.add("username", validateUsername(user)); err != nil {
+		return err
+	}
+	if err := errs.add("email", validateEmails(user)); err != nil {
+		return err
+	}
 	if user.hasRedactedSecret() {
 		return util.NewValidationError("cannot save a user with a redacted secret")
 	}
-	if user.HomeDir == "" {
-		return util.NewI18nError(util.NewValidationError("home_dir is mandatory"), util.I18nErrorHomeRequired)
-	}
 	// we can have users with no passwords and public keys, they can authenticate via SSH user certs or OIDC
 	/*if user.Password == "" && len(user.PublicKeys) == 0 {
 		return util.NewValidationError("please set a password or at least a public_key")
 	}*/
-	if !filepath.IsAbs(user.HomeDir) {
-		return util.NewI18nError(
-			util.NewValidationError(fmt.Sprintf("home_dir must be an absolute path, actual value: %v", user.HomeDir)),
-			util.I18nErrorHomeInvalid,
-		)
+	if err := errs.add("home_dir", validateUserHomeDir(user)); err != nil {
+		return err
 	}
 	if user.DownloadBandwidth < 0 {
 		user.DownloadBandwidth = 0
@@ -3313,11 +3912,7 @@ func validateBaseParams(user *User) error {
 	if user.Filters.IsAnonymous {
 		user.setAnonymousSettings()
 	}
-	err := user.FsConfig.Validate(user.GetEncryptionAdditionalData())
-	if err != nil {
-		return err
-	}
-	return nil
+	return errs.add("filesystem", user.FsConfig.Validate(user.GetEncryptionAdditionalData()))
 }
 
 func hashPlainPassword(plainPwd string) (string, error) {
@@ -3342,6 +3937,9 @@ func createUserPasswordHash(user *User) error {
 				return util.NewI18nError(util.NewValidationError(err.Error()), util.I18nErrorPasswordComplexity)
 			}
 		}
+		if err := config.PasswordPolicy.validate(user.Password); err != nil {
+			return err
+		}
 		hashedPwd, err := hashPlainPassword(user.Password)
 		if err != nil {
 			return err
@@ -3356,69 +3954,128 @@ func createUserPasswordHash(user *User) error {
 // FIXME: this should be defined as Folder struct method
 func ValidateFolder(folder *vfs.BaseVirtualFolder) error {
 	folder.FsConfig.SetEmptySecretsIfNil()
+	var errs validationErrors
 	if folder.Name == "" {
-		return util.NewI18nError(util.NewValidationError("folder name is mandatory"), util.I18nErrorNameRequired)
-	}
-	if config.NamingRules&1 == 0 && !usernameRegex.MatchString(folder.Name) {
-		return util.NewI18nError(
+		errs.add("name", util.NewI18nError(util.NewValidationError("folder name is mandatory"), util.I18nErrorNameRequired)) //nolint:errcheck
+	} else if config.NamingRules&1 == 0 && !usernameRegex.MatchString(folder.Name) {
+		errs.add("name", util.NewI18nError( //nolint:errcheck
 			util.NewValidationError(fmt.Sprintf("folder name %q is not valid, the following characters are allowed: a-zA-Z0-9-_.~", folder.Name)),
 			util.I18nErrorInvalidName,
-		)
+		))
 	}
 	if folder.FsConfig.Provider == sdk.LocalFilesystemProvider || folder.FsConfig.Provider == sdk.CryptedFilesystemProvider ||
 		folder.MappedPath != "" {
 		cleanedMPath := filepath.Clean(folder.MappedPath)
 		if !filepath.IsAbs(cleanedMPath) {
-			return util.NewI18nError(
+			errs.add("mapped_path", util.NewI18nError( //nolint:errcheck
 				util.NewValidationError(fmt.Sprintf("invalid folder mapped path %q", folder.MappedPath)),
 				util.I18nErrorInvalidHomeDir,
-			)
+			))
+		} else {
+			folder.MappedPath = cleanedMPath
 		}
-		folder.MappedPath = cleanedMPath
+	}
+	errs.add("retention", folder.ValidateRetention())      //nolint:errcheck
+	errs.add("extraction", folder.ValidateExtraction())    //nolint:errcheck
+	errs.add("transform", folder.ValidateTransform())      //nolint:errcheck
+	errs.add("setstat_mode", folder.ValidateSetstatMode()) //nolint:errcheck
+	if folder.MaxUploadFileSize < 0 {
+		errs.add("max_upload_file_size", util.NewValidationError( //nolint:errcheck
+			fmt.Sprintf("invalid max upload file size: %d", folder.MaxUploadFileSize)))
 	}
 	if folder.HasRedactedSecret() {
 		return errors.New("cannot save a folder with a redacted secret")
 	}
-	return folder.FsConfig.Validate(folder.GetEncryptionAdditionalData())
+	if err := errs.add("filesystem", folder.FsConfig.Validate(folder.GetEncryptionAdditionalData())); err != nil {
+		return err
+	}
+	return errs.err()
 }
 
 // ValidateUser returns an error if the user is not valid
 // FIXME: this should be defined as User struct method
 func ValidateUser(user *User) error {
 	user.OIDCCustomFields = nil
+	user.Lockout = nil
+	user.RemainingLogins = nil
+	user.EffectiveSources = nil
 	user.HasPassword = false
 	user.SetEmptySecretsIfNil()
 	buildUserHomeDir(user)
-	if err := validateBaseParams(user); err != nil {
+	// independent checks are accumulated, so all the problems are reported at once
+	var errs validationErrors
+	if err := validateBaseParams(user, &errs); err != nil {
 		return err
 	}
-	if err := validateUserGroups(user); err != nil {
+	if err := errs.add("groups", validateUserGroups(user)); err != nil {
 		return err
 	}
-	if err := validatePermissions(user); err != nil {
+	if err := errs.add("permissions", validatePermissions(user)); err != nil {
 		return err
 	}
 	if err := validateUserTOTPConfig(&user.Filters.TOTPConfig, user.Username); err != nil {
-		return util.NewI18nError(err, util.I18nError2FAInvalid)
+		if err := errs.add("filters.totp_config", util.NewI18nError(err, util.I18nError2FAInvalid)); err != nil {
+			return err
+		}
 	}
 	if err := validateUserRecoveryCodes(user); err != nil {
-		return util.NewI18nError(err, util.I18nErrorRecoveryCodesInvalid)
+		if err := errs.add("filters.recovery_codes", util.NewI18nError(err, util.I18nErrorRecoveryCodesInvalid)); err != nil {
+			return err
+		}
 	}
 	vfolders, err := validateAssociatedVirtualFolders(user.VirtualFolders)
-	if err != nil {
+	if err == nil {
+		user.VirtualFolders = vfolders
+	} else if err := errs.add("virtual_folders", err); err != nil {
 		return err
 	}
-	user.VirtualFolders = vfolders
 	if user.Status < 0 || user.Status > 1 {
-		return util.NewValidationError(fmt.Sprintf("invalid user status: %v", user.Status))
+		errs.add("status", util.NewValidationError(fmt.Sprintf("invalid user status: %v", user.Status))) //nolint:errcheck
 	}
-	if err := createUserPasswordHash(user); err != nil {
+	if err := errs.add("public_keys", validatePublicKeys(user)); err != nil {
+		return err
+	}
+	if err := errs.add("filters", validateBaseFilters(&user.Filters.BaseUserFilters)); err != nil {
+		return err
+	}
+	algos, err := validateAllowedKeyAlgos(user.Filters.AllowedKeyAlgos)
+	if err == nil {
+		user.Filters.AllowedKeyAlgos = algos
+	} else if err := errs.add("filters.allowed_key_algos", err); err != nil {
 		return err
 	}
-	if err := validatePublicKeys(user); err != nil {
+	networks, err := validateTwoFactorTrustedNetworks(user.Filters.TwoFactorTrustedNetworks)
+	if err == nil {
+		user.Filters.TwoFactorTrustedNetworks = networks
+	} else if err := errs.add("filters.two_factor_trusted_networks", err); err != nil {
+		return err
+	}
+	if err := errs.add("filters.transfer_quota_window", user.Filters.TransferQuotaWindow.validate()); err != nil {
+		return err
+	}
+	limits, err := validateUploadSizeLimits(user.Filters.UploadSizeLimits)
+	if err == nil {
+		user.Filters.UploadSizeLimits = limits
+	} else if err := errs.add("filters.upload_size_limits", err); err != nil {
+		return err
+	}
+	if err := errs.add("filters.create_modes", user.Filters.CreateModes.validate()); err != nil {
+		return err
+	}
+	if err := errs.add("filters.chown_policy", user.Filters.ChownPolicy.validate()); err != nil {
+		return err
+	}
+	if err := errs.add("filters.login_banner", user.Filters.LoginBanner.validate()); err != nil {
 		return err
 	}
-	if err := validateBaseFilters(&user.Filters.BaseUserFilters); err != nil {
+	if err := errs.add("filters.max_logins", user.validateLoginBudget()); err != nil {
+		return err
+	}
+	if errs.hasErrors() {
+		return errs.err()
+	}
+	// the password is hashed only if the other checks succeed
+	if err := createUserPasswordHash(user); err != nil {
 		return err
 	}
 	if !user.HasExternalAuth() {
@@ -3439,20 +4096,21 @@ func isPasswordOK(user *User, password string) (bool, error) {
 	updatePwd := true
 	var err error
 
+	passwordHashVerifications.Add(1)
 	switch {
 	case strings.HasPrefix(user.Password, bcryptPwdPrefix):
 		if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
 			return match, ErrInvalidCredentials
 		}
 		match = true
-		updatePwd = config.PasswordHashing.Algo != HashingAlgoBcrypt
+		updatePwd = isPasswordHashOutdated(user.Password)
 	case strings.HasPrefix(user.Password, argonPwdPrefix):
 		match, err = argon2id.ComparePasswordAndHash(password, user.Password)
 		if err != nil {
 			providerLog(logger.LevelError, "error comparing password with argon hash: %v", err)
 			return match, err
 		}
-		updatePwd = config.PasswordHashing.Algo != HashingAlgoArgon2ID
+		updatePwd = isPasswordHashOutdated(user.Password)
 	case util.IsStringPrefixInSlice(user.Password, unixPwdPrefixes):
 		match, err = compareUnixPasswordAndHash(user, password)
 		if err != nil {
@@ -3465,22 +4123,63 @@ func isPasswordOK(user *User, password string) (bool, error) {
 		}
 	case util.IsStringPrefixInSlice(user.Password, digestPwdPrefixes):
 		match = compareDigestPasswordAndHash(user, password)
+	case util.IsStringPrefixInSlice(user.Password, []string{ssha512DigestPwdPrefix, ssha512DigestLowerPwdPrefix}):
+		match = compareSSHA512PasswordAndHash(password, user.Password[len(ssha512DigestPwdPrefix):])
+	case strings.HasPrefix(user.Password, drupalPwdPrefix):
+		match, err = compareDrupalPasswordAndHash(password, user.Password)
+		if err != nil {
+			return match, err
+		}
 	}
 
 	if err == nil && match {
 		cachedUserPasswords.Add(user.Username, password, user.Password)
-		if updatePwd {
+		// the password for externally authenticated users is managed by the hook/plugin
+		if updatePwd && !user.HasExternalAuth() {
 			convertUserPassword(user.Username, password)
 		}
 	}
 	return match, err
 }
 
+// isPasswordHashOutdated returns true if the given hash was generated using a
+// different algorithm or weaker parameters than the configured ones
+func isPasswordHashOutdated(hashedPwd string) bool {
+	switch {
+	case strings.HasPrefix(hashedPwd, bcryptPwdPrefix):
+		if config.PasswordHashing.Algo != HashingAlgoBcrypt {
+			return true
+		}
+		cost, err := bcrypt.Cost(util.StringToBytes(hashedPwd))
+		if err != nil {
+			return false
+		}
+		configuredCost := config.PasswordHashing.BcryptOptions.Cost
+		if configuredCost < bcrypt.MinCost {
+			configuredCost = bcrypt.DefaultCost
+		}
+		return cost < configuredCost
+	case strings.HasPrefix(hashedPwd, argonPwdPrefix):
+		if config.PasswordHashing.Algo != HashingAlgoArgon2ID {
+			return true
+		}
+		params, _, _, err := argon2id.DecodeHash(hashedPwd)
+		if err != nil {
+			return false
+		}
+		return params.Memory < argon2Params.Memory || params.Iterations < argon2Params.Iterations ||
+			params.Parallelism < argon2Params.Parallelism
+	default:
+		return true
+	}
+}
+
 func convertUserPassword(username, plainPwd string) {
 	hashedPwd, err := hashPlainPassword(plainPwd)
 	if err == nil {
 		err = provider.updateUserPassword(username, hashedPwd)
 	}
+	invalidateCachedUser(username)
 	if err != nil {
 		providerLog(logger.LevelWarn, "unable to convert password for user %s: %v", username, err)
 	} else {
@@ -3526,14 +4225,19 @@ func checkUserAndPass(user *User, password, ip, protocol string) (User, error) {
 	if err != nil {
 		return *user, err
 	}
-	if protocol != protocolHTTP && user.MustChangePassword() {
-		return *user, errors.New("login not allowed, password change required")
+	if protocol != protocolHTTP {
+		if user.Filters.RequirePasswordChange {
+			return *user, errors.New("login not allowed, password change required")
+		}
+		if user.IsPasswordExpired() {
+			return *user, ErrPasswordExpired
+		}
 	}
 	if user.Filters.IsAnonymous {
 		user.setAnonymousSettings()
 		return *user, nil
 	}
-	password, err = checkUserPasscode(user, password, protocol)
+	password, err = checkUserPasscode(user, password, ip, protocol)
 	if err != nil {
 		return *user, ErrInvalidCredentials
 	}
@@ -3572,11 +4276,11 @@ func checkUserAndPass(user *User, password, ip, protocol string) (User, error) {
 	return *user, err
 }
 
-func checkUserPasscode(user *User, password, protocol string) (string, error) {
+func checkUserPasscode(user *User, password, ip, protocol string) (string, error) {
 	if user.Filters.TOTPConfig.Enabled {
 		switch protocol {
 		case protocolFTP:
-			if slices.Contains(user.Filters.TOTPConfig.Protocols, protocol) {
+			if slices.Contains(user.Filters.TOTPConfig.Protocols, protocol) && !user.IsSecondFactorSkipped(ip, protocol) {
 				// the TOTP passcode has six digits
 				pwdLen := len(password)
 				if pwdLen < 7 {
@@ -3592,11 +4296,14 @@ func checkUserPasscode(user *User, password, protocol string) (string, error) {
 				}
 				pwd := password[0:(pwdLen - 6)]
 				passcode := password[(pwdLen - 6):]
-				match, err := mfa.ValidateTOTPPasscode(user.Filters.TOTPConfig.ConfigName, passcode,
+				match, err := ValidateTOTPPasscode(user.Username, false, user.Filters.TOTPConfig.ConfigName, passcode,
 					user.Filters.TOTPConfig.Secret.GetPayload())
 				if !match || err != nil {
 					providerLog(logger.LevelWarn, "invalid passcode for user %q, protocol %v, err: %v",
 						user.Username, protocol, err)
+					if errors.Is(err, ErrTooManySecondFactorAttempts) {
+						return "", err
+					}
 					return "", util.NewValidationError("invalid passcode")
 				}
 				return pwd, nil
@@ -3615,20 +4322,29 @@ func checkUserAndPubKey(user *User, pubKey []byte, isSSHCert bool) (User, string
 	if err != nil {
 		return *user, "", err
 	}
+	if err := user.checkPublicKeyAlgo(pubKey); err != nil {
+		return *user, "", err
+	}
 	if isSSHCert {
 		return *user, "", nil
 	}
 	if len(user.PublicKeys) == 0 {
 		return *user, "", ErrInvalidCredentials
 	}
-	for idx, key := range user.PublicKeys {
-		storedKey, comment, _, _, err := ssh.ParseAuthorizedKey(util.StringToBytes(key))
+	for idx, key := range user.getPublicKeys() {
+		storedKey, comment, _, _, err := ssh.ParseAuthorizedKey(util.StringToBytes(key.Key))
 		if err != nil {
 			providerLog(logger.LevelError, "error parsing stored public key %d for user %s: %v", idx, user.Username, err)
 			return *user, "", err
 		}
 		if bytes.Equal(storedKey.Marshal(), pubKey) {
-			return *user, fmt.Sprintf("%s:%s", ssh.FingerprintSHA256(storedKey), comment), nil
+			fp := ssh.FingerprintSHA256(storedKey)
+			if key.IsExpired() {
+				providerLog(logger.LevelInfo, "public key %s for user %q expired at %s", fp, user.Username,
+					util.GetTimeFromMsecSinceEpoch(key.ExpiresAt).UTC().Format(time.RFC3339))
+				return *user, "", ErrPublicKeyExpired
+			}
+			return *user, fmt.Sprintf("%s:%s", fp, comment), nil
 		}
 	}
 	return *user, "", ErrInvalidCredentials
@@ -3653,23 +4369,92 @@ func compareDigestPasswordAndHash(user *User, password string) bool {
 	return false
 }
 
+// compareSSHA512PasswordAndHash checks a salted SHA-512 hash: the base64 encoded
+// SHA-512 digest of the password and the salt followed by the salt
+func compareSSHA512PasswordAndHash(password, encodedHash string) bool {
+	decoded, err := base64.StdEncoding.DecodeString(encodedHash)
+	if err != nil || len(decoded) <= sha512.Size {
+		return false
+	}
+	expected := decoded[:sha512.Size]
+	salt := decoded[sha512.Size:]
+	h := sha512.New()
+	h.Write([]byte(password))
+	h.Write(salt)
+	return subtle.ConstantTimeCompare(h.Sum(nil), expected) == 1
+}
+
+// compareDrupalPasswordAndHash checks a Drupal 7 password hash, a phpass variant using SHA-512
+func compareDrupalPasswordAndHash(password, hashedPassword string) (bool, error) {
+	const (
+		itoa64       = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+		hashLength   = 55
+		minHashCount = 7
+		maxHashCount = 30
+	)
+	if len(hashedPassword) != hashLength {
+		return false, errors.New("drupal: hash is not in the correct format")
+	}
+	countLog2 := strings.IndexByte(itoa64, hashedPassword[3])
+	if countLog2 < minHashCount || countLog2 > maxHashCount {
+		return false, fmt.Errorf("drupal: invalid hash count %d", countLog2)
+	}
+	setting := hashedPassword[:12]
+	salt := setting[4:]
+	digest := sha512.Sum512([]byte(salt + password))
+	for count := 1 << countLog2; count > 0; count-- {
+		digest = sha512.Sum512(append(digest[:], password...))
+	}
+	// this is the phpass base64 variant, it uses a different alphabet and bit order
+	var encoded strings.Builder
+	for i := 0; i < len(digest); {
+		value := int(digest[i])
+		i++
+		encoded.WriteByte(itoa64[value&0x3f])
+		if i < len(digest) {
+			value |= int(digest[i]) << 8
+		}
+		encoded.WriteByte(itoa64[(value>>6)&0x3f])
+		if i >= len(digest) {
+			break
+		}
+		i++
+		if i < len(digest) {
+			value |= int(digest[i]) << 16
+		}
+		encoded.WriteByte(itoa64[(value>>12)&0x3f])
+		if i >= len(digest) {
+			break
+		}
+		i++
+		encoded.WriteByte(itoa64[(value>>18)&0x3f])
+	}
+	computed := (setting + encoded.String())[:hashLength]
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(hashedPassword)) == 1, nil
+}
+
 func compareUnixPasswordAndHash(user *User, password string) (bool, error) {
-	if strings.HasPrefix(user.Password, yescryptPwdPrefix) {
-		return compareYescryptPassword(user.Password, password)
+	hashedPassword := user.Password
+	if util.IsStringPrefixInSlice(hashedPassword, []string{cryptSHA512PwdPrefix, cryptSHA512LowerPwdPrefix}) {
+		// LDAP style {CRYPT} scheme
+		hashedPassword = hashedPassword[len("{CRYPT}"):]
+	}
+	if strings.HasPrefix(hashedPassword, yescryptPwdPrefix) {
+		return compareYescryptPassword(hashedPassword, password)
 	}
 	var crypter crypt.Crypter
-	if strings.HasPrefix(user.Password, sha512cryptPwdPrefix) {
+	if strings.HasPrefix(hashedPassword, sha512cryptPwdPrefix) {
 		crypter = sha512_crypt.New()
-	} else if strings.HasPrefix(user.Password, sha256cryptPwdPrefix) {
+	} else if strings.HasPrefix(hashedPassword, sha256cryptPwdPrefix) {
 		crypter = sha256_crypt.New()
-	} else if strings.HasPrefix(user.Password, md5cryptPwdPrefix) {
+	} else if strings.HasPrefix(hashedPassword, md5cryptPwdPrefix) {
 		crypter = md5_crypt.New()
-	} else if strings.HasPrefix(user.Password, md5cryptApr1PwdPrefix) {
+	} else if strings.HasPrefix(hashedPassword, md5cryptApr1PwdPrefix) {
 		crypter = apr1_crypt.New()
 	} else {
 		return false, errors.New("unix crypt: invalid or unsupported hash format")
 	}
-	if err := crypter.Verify(user.Password, []byte(password)); err != nil {
+	if err := crypter.Verify(hashedPassword, []byte(password)); err != nil {
 		return false, err
 	}
 	return true, nil
@@ -3757,16 +4542,42 @@ func terminateInteractiveAuthProgram(cmd *exec.Cmd, isFinished bool) {
 	}
 }
 
+// isHookTimeout returns true if the hook execution failed because the configured
+// timeout expired. Timeouts are logged and counted
+func isHookTimeout(ctx context.Context, hook string, err error) bool {
+	if err == nil {
+		return false
+	}
+	if !errors.Is(ctx.Err(), context.DeadlineExceeded) && !util.IsTimeoutError(err) {
+		return false
+	}
+	providerLog(logger.LevelWarn, "%s hook timed out: %v", hook, err)
+	metric.AddHookTimeout(hook)
+	return true
+}
+
+// getAuthHookError converts a timeout for an authentication hook into an
+// invalid credentials error
+func getAuthHookError(ctx context.Context, hook string, err error) error {
+	if isHookTimeout(ctx, hook, err) {
+		return fmt.Errorf("%w: %s hook timed out", ErrInvalidCredentials, hook)
+	}
+	return err
+}
+
 func sendKeyboardAuthHTTPReq(url string, request *plugin.KeyboardAuthRequest) (*plugin.KeyboardAuthResponse, error) {
 	reqAsJSON, err := json.Marshal(request)
 	if err != nil {
 		providerLog(logger.LevelError, "error serializing keyboard interactive auth request: %v", err)
 		return nil, err
 	}
-	resp, err := httpclient.Post(url, "application/json", bytes.NewBuffer(reqAsJSON))
+	ctx, cancel := context.WithTimeout(context.Background(), httpclient.GetHookTimeout(command.HookKeyboardInteractive))
+	defer cancel()
+
+	resp, err := httpclient.PostWithContext(ctx, url, "application/json", bytes.NewBuffer(reqAsJSON))
 	if err != nil {
 		providerLog(logger.LevelError, "error getting keyboard interactive auth hook HTTP response: %v", err)
-		return nil, err
+		return nil, getAuthHookError(ctx, command.HookKeyboardInteractive, err)
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
@@ -3774,7 +4585,7 @@ func sendKeyboardAuthHTTPReq(url string, request *plugin.KeyboardAuthRequest) (*
 	}
 	var response plugin.KeyboardAuthResponse
 	err = render.DecodeJSON(resp.Body, &response)
-	return &response, err
+	return &response, getAuthHookError(ctx, command.HookKeyboardInteractive, err)
 }
 
 func doBuiltinKeyboardInteractiveAuth(user *User, client ssh.KeyboardInteractiveChallenge,
@@ -3797,19 +4608,26 @@ func doBuiltinKeyboardInteractiveAuth(user *User, client ssh.KeyboardInteractive
 			return 0, err
 		}
 	}
-	return checkKeyboardInteractiveSecondFactor(user, client, protocol)
+	return checkKeyboardInteractiveSecondFactor(user, client, ip, protocol)
 }
 
-func checkKeyboardInteractiveSecondFactor(user *User, client ssh.KeyboardInteractiveChallenge, protocol string) (int, error) {
+func checkKeyboardInteractiveSecondFactor(user *User, client ssh.KeyboardInteractiveChallenge, ip, protocol string) (int, error) {
 	if !user.Filters.TOTPConfig.Enabled || !slices.Contains(user.Filters.TOTPConfig.Protocols, protocolSSH) {
 		return 1, nil
 	}
+	if user.IsSecondFactorSkipped(ip, protocolSSH) {
+		return 1, nil
+	}
 	err := user.Filters.TOTPConfig.Secret.TryDecrypt()
 	if err != nil {
 		providerLog(logger.LevelError, "unable to decrypt TOTP secret for user %q, protocol %v, err: %v",
 			user.Username, protocol, err)
 		return 0, err
 	}
+	if err := CheckSecondFactorAttempts(user.Username, false); err != nil {
+		client("", "Too many verification attempts, please retry later", nil, nil) //nolint:errcheck
+		return 0, err
+	}
 	answers, err := client("", "", []string{"Authentication code: "}, []bool{false})
 	if err != nil {
 		return 0, err
@@ -3817,11 +4635,15 @@ func checkKeyboardInteractiveSecondFactor(user *User, client ssh.KeyboardInterac
 	if len(answers) != 1 {
 		return 0, fmt.Errorf("unexpected number of answers: %v", len(answers))
 	}
-	match, err := mfa.ValidateTOTPPasscode(user.Filters.TOTPConfig.ConfigName, answers[0],
+	match, err := ValidateTOTPPasscode(user.Username, false, user.Filters.TOTPConfig.ConfigName, answers[0],
 		user.Filters.TOTPConfig.Secret.GetPayload())
 	if !match || err != nil {
 		providerLog(logger.LevelWarn, "invalid passcode for user %q, protocol %v, err: %v",
 			user.Username, protocol, err)
+		if errors.Is(err, ErrTooManySecondFactorAttempts) {
+			client("", "Too many verification attempts, please retry later", nil, nil) //nolint:errcheck
+			return 0, err
+		}
 		return 0, util.NewValidationError("invalid passcode")
 	}
 	return 1, nil
@@ -3936,11 +4758,14 @@ func getKeyboardInteractiveAnswers(client ssh.KeyboardInteractiveChallenge, resp
 					user.Username, protocol, err)
 				return answers, fmt.Errorf("unable to decrypt TOTP secret: %w", err)
 			}
-			match, err := mfa.ValidateTOTPPasscode(user.Filters.TOTPConfig.ConfigName, answers[0],
+			match, err := ValidateTOTPPasscode(user.Username, false, user.Filters.TOTPConfig.ConfigName, answers[0],
 				user.Filters.TOTPConfig.Secret.GetPayload())
 			if !match || err != nil {
 				providerLog(logger.LevelInfo, "keyboard interactive auth error: unable to validate passcode for user %q, match? %v, err: %v",
 					user.Username, match, err)
+				if errors.Is(err, ErrTooManySecondFactorAttempts) {
+					return answers, err
+				}
 				return answers, errors.New("unable to validate TOTP passcode")
 			}
 		} else {
@@ -4000,6 +4825,11 @@ func executeKeyboardInteractiveProgram(user *User, authHook string, client ssh.K
 	if err != nil {
 		return authResult, err
 	}
+	// child processes could keep stdout open after the program is killed on timeout
+	stopCloseOnTimeout := context.AfterFunc(ctx, func() {
+		stdout.Close()
+	})
+	defer stopCloseOnTimeout()
 	var once sync.Once
 	scanner := bufio.NewScanner(stdout)
 	for scanner.Scan() {
@@ -4034,6 +4864,9 @@ func executeKeyboardInteractiveProgram(user *User, authHook string, client ssh.K
 			providerLog(logger.LevelWarn, "error waiting for %q process to exit: %v", authHook, err)
 		}
 	}()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return 0, getAuthHookError(ctx, command.HookKeyboardInteractive, ctx.Err())
+	}
 
 	return authResult, err
 }
@@ -4050,7 +4883,7 @@ func doKeyboardInteractiveAuth(user *User, authHook string, client ssh.KeyboardI
 		if plugin.Handler.HasAuthScope(plugin.AuthScopeKeyboardInteractive) {
 			authResult, err = executeKeyboardInteractivePlugin(user, client, ip, protocol)
 			if authResult == 1 && err == nil {
-				authResult, err = checkKeyboardInteractiveSecondFactor(user, client, protocol)
+				authResult, err = checkKeyboardInteractiveSecondFactor(user, client, ip, protocol)
 			}
 		} else if authHook != "" {
 			if strings.HasPrefix(authHook, "http") {
@@ -4109,29 +4942,35 @@ func getPasswordHookResponse(username, password, ip, protocol string) ([]byte, e
 		if err != nil {
 			return result, err
 		}
-		resp, err := httpclient.Post(config.CheckPasswordHook, "application/json", bytes.NewBuffer(reqAsJSON))
+		ctx, cancel := context.WithTimeout(context.Background(), httpclient.GetHookTimeout(command.HookCheckPassword))
+		defer cancel()
+
+		resp, err := httpclient.PostWithContext(ctx, config.CheckPasswordHook, "application/json", bytes.NewBuffer(reqAsJSON))
 		if err != nil {
 			providerLog(logger.LevelError, "error getting check password hook response: %v", err)
-			return result, err
+			return result, getAuthHookError(ctx, command.HookCheckPassword, err)
 		}
 		defer resp.Body.Close()
 		if resp.StatusCode != http.StatusOK {
 			return result, fmt.Errorf("wrong http status code from chek password hook: %v, expected 200", resp.StatusCode)
 		}
-		return io.ReadAll(io.LimitReader(resp.Body, maxHookResponseSize))
+		result, err = io.ReadAll(io.LimitReader(resp.Body, maxHookResponseSize))
+		return result, getAuthHookError(ctx, command.HookCheckPassword, err)
 	}
 	timeout, env, args := command.GetConfig(config.CheckPasswordHook, command.HookCheckPassword)
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
 	cmd := exec.CommandContext(ctx, config.CheckPasswordHook, args...)
+	cmd.WaitDelay = command.WaitDelay
 	cmd.Env = append(env,
 		fmt.Sprintf("SFTPGO_AUTHD_USERNAME=%s", username),
 		fmt.Sprintf("SFTPGO_AUTHD_PASSWORD=%s", password),
 		fmt.Sprintf("SFTPGO_AUTHD_IP=%s", ip),
 		fmt.Sprintf("SFTPGO_AUTHD_PROTOCOL=%s", protocol),
 	)
-	return cmd.Output()
+	out, err := cmd.Output()
+	return out, getAuthHookError(ctx, command.HookCheckPassword, err)
 }
 
 func executeCheckPasswordHook(username, password, ip, protocol string) (checkPasswordResponse, error) {
@@ -4167,10 +5006,13 @@ func getPreLoginHookResponse(loginMethod, ip, protocol string, userAsJSON []byte
 		q.Add("protocol", protocol)
 		url.RawQuery = q.Encode()
 
-		resp, err := httpclient.Post(url.String(), "application/json", bytes.NewBuffer(userAsJSON))
+		ctx, cancel := context.WithTimeout(context.Background(), httpclient.GetHookTimeout(command.HookPreLogin))
+		defer cancel()
+
+		resp, err := httpclient.PostWithContext(ctx, url.String(), "application/json", bytes.NewBuffer(userAsJSON))
 		if err != nil {
 			providerLog(logger.LevelWarn, "error getting pre-login hook response: %v", err)
-			return result, err
+			return result, getAuthHookError(ctx, command.HookPreLogin, err)
 		}
 		defer resp.Body.Close()
 		if resp.StatusCode == http.StatusNoContent {
@@ -4179,20 +5021,23 @@ func getPreLoginHookResponse(loginMethod, ip, protocol string, userAsJSON []byte
 		if resp.StatusCode != http.StatusOK {
 			return result, fmt.Errorf("wrong pre-login hook http status code: %v, expected 200", resp.StatusCode)
 		}
-		return io.ReadAll(io.LimitReader(resp.Body, maxHookResponseSize))
+		result, err = io.ReadAll(io.LimitReader(resp.Body, maxHookResponseSize))
+		return result, getAuthHookError(ctx, command.HookPreLogin, err)
 	}
 	timeout, env, args := command.GetConfig(config.PreLoginHook, command.HookPreLogin)
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
 	cmd := exec.CommandContext(ctx, config.PreLoginHook, args...)
+	cmd.WaitDelay = command.WaitDelay
 	cmd.Env = append(env,
 		fmt.Sprintf("SFTPGO_LOGIND_USER=%s", userAsJSON),
 		fmt.Sprintf("SFTPGO_LOGIND_METHOD=%s", loginMethod),
 		fmt.Sprintf("SFTPGO_LOGIND_IP=%s", ip),
 		fmt.Sprintf("SFTPGO_LOGIND_PROTOCOL=%s", protocol),
 	)
-	return cmd.Output()
+	out, err := cmd.Output()
+	return out, getAuthHookError(ctx, command.HookPreLogin, err)
 }
 
 func executePreLoginHook(username, loginMethod, ip, protocol string, oidcTokenFields *map[string]any) (User, error) {
@@ -4247,18 +5092,23 @@ func executePreLoginHook(username, loginMethod, ip, protocol string, oidcTokenFi
 	u.FirstDownload = userFirstDownload
 	u.FirstUpload = userFirstUpload
 	u.CreatedAt = userCreatedAt
+	var change *ObjectChange
 	if userID == 0 {
-		err = provider.addUser(&u)
+		change = newObjectChange(operationAdd, actionObjectUser, u.Username, ActionExecutorSystem)
+		err = provider.addUser(&u, change)
 	} else {
 		u.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
 		// preserve TOTP config and recovery codes
 		u.Filters.TOTPConfig = totpConfig
 		u.Filters.RecoveryCodes = recoveryCodes
-		err = provider.updateUser(&u)
+		change = newObjectChange(operationUpdate, actionObjectUser, u.Username, ActionExecutorSystem)
+		err = provider.updateUser(&u, change)
 	}
 	if err != nil {
 		return u, err
 	}
+	objectChangeSubscribers.notify(change)
+	invalidateCachedUser(username)
 	user, err := provider.userExists(username, "")
 	if err != nil {
 		return u, err
@@ -4272,6 +5122,12 @@ func executePreLoginHook(username, loginMethod, ip, protocol string, oidcTokenFi
 
 // ExecutePostLoginHook executes the post login hook if defined
 func ExecutePostLoginHook(user *User, loginMethod, ip, protocol string, err error) {
+	ExecutePostLoginHookWithSource(user, loginMethod, ip, protocol, ConnectionSource{}, err)
+}
+
+// ExecutePostLoginHookWithSource executes the post login hook, if defined,
+// including the transport peer and the provenance of the client address
+func ExecutePostLoginHookWithSource(user *User, loginMethod, ip, protocol string, source ConnectionSource, err error) {
 	if config.PostLoginHook == "" {
 		return
 	}
@@ -4292,6 +5148,7 @@ func ExecutePostLoginHook(user *User, loginMethod, ip, protocol string, err erro
 		if err == nil {
 			status = "1"
 		}
+		secondFactorStatus := user.GetSecondFactorStatus(ip, protocol, err)
 
 		user.PrepareForRendering()
 		userAsJSON, err := json.Marshal(user)
@@ -4311,15 +5168,24 @@ func ExecutePostLoginHook(user *User, loginMethod, ip, protocol string, err erro
 			q.Add("ip", ip)
 			q.Add("protocol", protocol)
 			q.Add("status", status)
+			q.Add("second_factor", secondFactorStatus)
+			if source.PeerIP != "" {
+				q.Add("peer_ip", source.PeerIP)
+			}
+			if source.Provenance != "" {
+				q.Add("ip_provenance", source.Provenance)
+			}
 			url.RawQuery = q.Encode()
 
 			startTime := time.Now()
 			respCode := 0
-			resp, err := httpclient.RetryablePost(url.String(), "application/json", bytes.NewBuffer(userAsJSON))
+			resp, err := httpclient.RetryablePostForHook(command.HookPostLogin, url.String(), "application/json",
+				bytes.NewBuffer(userAsJSON))
 			if err == nil {
 				respCode = resp.StatusCode
 				resp.Body.Close()
 			}
+			isHookTimeout(context.Background(), command.HookPostLogin, err)
 			providerLog(logger.LevelDebug, "post login hook executed for user %q, ip %v, protocol %v, response code: %v, elapsed: %v err: %v",
 				user.Username, ip, protocol, respCode, time.Since(startTime), err)
 			return
@@ -4329,14 +5195,19 @@ func ExecutePostLoginHook(user *User, loginMethod, ip, protocol string, err erro
 		defer cancel()
 
 		cmd := exec.CommandContext(ctx, config.PostLoginHook, args...)
+		cmd.WaitDelay = command.WaitDelay
 		cmd.Env = append(env,
 			fmt.Sprintf("SFTPGO_LOGIND_USER=%s", userAsJSON),
 			fmt.Sprintf("SFTPGO_LOGIND_IP=%s", ip),
 			fmt.Sprintf("SFTPGO_LOGIND_METHOD=%s", loginMethod),
 			fmt.Sprintf("SFTPGO_LOGIND_STATUS=%s", status),
+			fmt.Sprintf("SFTPGO_LOGIND_SECOND_FACTOR=%s", secondFactorStatus),
+			fmt.Sprintf("SFTPGO_LOGIND_PEER_IP=%s", source.PeerIP),
+			fmt.Sprintf("SFTPGO_LOGIND_IP_PROVENANCE=%s", source.Provenance),
 			fmt.Sprintf("SFTPGO_LOGIND_PROTOCOL=%s", protocol))
 		startTime := time.Now()
 		err = cmd.Run()
+		isHookTimeout(ctx, command.HookPostLogin, err)
 		providerLog(logger.LevelDebug, "post login hook executed for user %q, ip %v, protocol %v, elapsed %v err: %v",
 			user.Username, ip, protocol, time.Since(startTime), err)
 	}()
@@ -4371,10 +5242,14 @@ func getExternalAuthResponse(username, password, pkey, keyboardInteractive, ip,
 			providerLog(logger.LevelError, "error serializing external auth request: %v", err)
 			return result, err
 		}
-		resp, err := httpclient.Post(config.ExternalAuthHook, "application/json", bytes.NewBuffer(authRequestAsJSON))
+		ctx, cancel := context.WithTimeout(context.Background(), httpclient.GetHookTimeout(command.HookExternalAuth))
+		defer cancel()
+
+		resp, err := httpclient.PostWithContext(ctx, config.ExternalAuthHook, "application/json",
+			bytes.NewBuffer(authRequestAsJSON))
 		if err != nil {
 			providerLog(logger.LevelWarn, "error getting external auth hook HTTP response: %v", err)
-			return result, err
+			return result, getAuthHookError(ctx, command.HookExternalAuth, err)
 		}
 		defer resp.Body.Close()
 		providerLog(logger.LevelDebug, "external auth hook executed, response code: %v", resp.StatusCode)
@@ -4382,7 +5257,8 @@ func getExternalAuthResponse(username, password, pkey, keyboardInteractive, ip,
 			return result, fmt.Errorf("wrong external auth http status code: %v, expected 200", resp.StatusCode)
 		}
 
-		return io.ReadAll(io.LimitReader(resp.Body, maxHookResponseSize))
+		result, err = io.ReadAll(io.LimitReader(resp.Body, maxHookResponseSize))
+		return result, getAuthHookError(ctx, command.HookExternalAuth, err)
 	}
 	var userAsJSON []byte
 	var err error
@@ -4397,6 +5273,7 @@ func getExternalAuthResponse(username, password, pkey, keyboardInteractive, ip,
 	defer cancel()
 
 	cmd := exec.CommandContext(ctx, config.ExternalAuthHook, args...)
+	cmd.WaitDelay = command.WaitDelay
 	cmd.Env = append(env,
 		fmt.Sprintf("SFTPGO_AUTHD_USERNAME=%s", username),
 		fmt.Sprintf("SFTPGO_AUTHD_USER=%s", userAsJSON),
@@ -4407,7 +5284,8 @@ func getExternalAuthResponse(username, password, pkey, keyboardInteractive, ip,
 		fmt.Sprintf("SFTPGO_AUTHD_TLS_CERT=%s", strings.ReplaceAll(tlsCert, "\n", "\\n")),
 		fmt.Sprintf("SFTPGO_AUTHD_KEYBOARD_INTERACTIVE=%v", keyboardInteractive))
 
-	return cmd.Output()
+	out, err := cmd.Output()
+	return out, getAuthHookError(ctx, command.HookExternalAuth, err)
 }
 
 func updateUserFromExtAuthResponse(user *User, password, pkey string) {
@@ -4440,6 +5318,7 @@ func checkPasswordAfterEmptyExtAuthResponse(user *User, plainPwd, protocol strin
 		providerLog(logger.LevelError, "unable to update password for user %q after empty external response: %v",
 			user.Username, err)
 	}
+	invalidateCachedUser(user.Username)
 	user.Password = hashedPwd
 	cachedUserPasswords.Add(user.Username, plainPwd, user.Password)
 	if protocol != protocolWebDAV {
@@ -4525,10 +5404,12 @@ func doExternalAuth(username, password string, pubKey []byte, keyboardInteractiv
 		}
 		return user, err
 	}
-	err = provider.addUser(&user)
+	change := newObjectChange(operationAdd, actionObjectUser, user.Username, ActionExecutorSelf)
+	err = provider.addUser(&user, change)
 	if err != nil {
 		return user, err
 	}
+	objectChangeSubscribers.notify(change)
 	executeAction(operationAdd, ActionExecutorSelf, "", actionObjectUser, user.Username, "", &user)
 	return provider.userExists(user.Username, "")
 }
@@ -4599,18 +5480,23 @@ func doPluginAuth(username, password string, pubKey []byte, ip, protocol string,
 		}
 		return user, err
 	}
-	err = provider.addUser(&user)
+	change := newObjectChange(operationAdd, actionObjectUser, user.Username, ActionExecutorSelf)
+	err = provider.addUser(&user, change)
 	if err != nil {
 		return user, err
 	}
+	objectChangeSubscribers.notify(change)
 	executeAction(operationAdd, ActionExecutorSelf, "", actionObjectUser, user.Username, "", &user)
 	return provider.userExists(user.Username, "")
 }
 
 func updateUserAfterExternalAuth(user *User) (User, error) {
-	if err := provider.updateUser(user); err != nil {
+	change := newObjectChange(operationUpdate, actionObjectUser, user.Username, ActionExecutorSelf)
+	if err := provider.updateUser(user, change); err != nil {
 		return *user, err
 	}
+	objectChangeSubscribers.notify(change)
+	invalidateCachedUser(user.Username)
 	return provider.userExists(user.Username, "")
 }
 
@@ -111,11 +111,17 @@ const (
 	EventTriggerCertificate
 	EventTriggerOnDemand
 	EventTriggerIDPLogin
+	// Too many login failures for a username, see the "login_failures" configuration
+	EventTriggerLoginFailures
+	// An account was locked or unlocked, see the "account_lockout" data provider configuration,
+	// or the login budget for an account is exhausted, see the "max_logins" user filter
+	EventTriggerAccountLockout
 )
 
 var (
 	supportedEventTriggers = []int{EventTriggerFsEvent, EventTriggerProviderEvent, EventTriggerSchedule,
-		EventTriggerIPBlocked, EventTriggerCertificate, EventTriggerIDPLogin, EventTriggerOnDemand}
+		EventTriggerIPBlocked, EventTriggerCertificate, EventTriggerIDPLogin, EventTriggerOnDemand,
+		EventTriggerLoginFailures, EventTriggerAccountLockout}
 )
 
 func isEventTriggerValid(trigger int) bool {
@@ -136,6 +142,10 @@ func getTriggerTypeAsString(trigger int) string {
 		return util.I18nTriggerOnDemandEvent
 	case EventTriggerIDPLogin:
 		return util.I18nTriggerIDPLoginEvent
+	case EventTriggerLoginFailures:
+		return util.I18nTriggerLoginFailuresEvent
+	case EventTriggerAccountLockout:
+		return util.I18nTriggerAccountLockoutEvent
 	default:
 		return util.I18nTriggerScheduleEvent
 	}
@@ -197,7 +207,8 @@ func getFsActionTypeAsString(value int) string {
 var (
 	// SupportedFsEvents defines the supported filesystem events
 	SupportedFsEvents = []string{"upload", "pre-upload", "first-upload", "download", "pre-download",
-		"first-download", "delete", "pre-delete", "rename", "mkdir", "rmdir", "copy", "ssh_cmd"}
+		"first-download", "delete", "pre-delete", "rename", "pre-rename", "mkdir", "rmdir", "copy", "ssh_cmd",
+		"transfer-quota-warning", "transfer-quota-exhausted", "extract"}
 	// SupportedProviderEvents defines the supported provider events
 	SupportedProviderEvents = []string{operationAdd, operationUpdate, operationDelete}
 	// SupportedRuleConditionProtocols defines the supported protcols for rule conditions
@@ -208,8 +219,8 @@ var (
 		actionObjectAdmin, actionObjectAPIKey, actionObjectShare, actionObjectEventRule, actionObjectEventAction}
 	// SupportedHTTPActionMethods defines the supported methods for HTTP actions
 	SupportedHTTPActionMethods = []string{http.MethodPost, http.MethodGet, http.MethodPut, http.MethodDelete}
-	allowedSyncFsEvents        = []string{"upload", "pre-upload", "pre-download", "pre-delete"}
-	mandatorySyncFsEvents      = []string{"pre-upload", "pre-download", "pre-delete"}
+	allowedSyncFsEvents        = []string{"upload", "pre-upload", "pre-download", "pre-delete", "pre-rename"}
+	mandatorySyncFsEvents      = []string{"pre-upload", "pre-download", "pre-delete", "pre-rename"}
 )
 
 // enum mappings
@@ -1545,7 +1556,7 @@ func (c *EventConditions) validate(trigger int) error {
 		if err := c.validateSchedules(); err != nil {
 			return err
 		}
-	case EventTriggerIPBlocked, EventTriggerCertificate:
+	case EventTriggerIPBlocked, EventTriggerCertificate, EventTriggerLoginFailures, EventTriggerAccountLockout:
 		c.FsEvents = nil
 		c.ProviderEvents = nil
 		c.Options.Names = nil
@@ -1845,7 +1856,7 @@ func (r *EventRule) CheckActionsConsistency(providerObjectType string) error {
 					action.Name, getActionTypeAsString(action.Type))
 			}
 		}
-	case EventTriggerIPBlocked, EventTriggerCertificate:
+	case EventTriggerIPBlocked, EventTriggerCertificate, EventTriggerLoginFailures, EventTriggerAccountLockout:
 		if err := r.checkIPBlockedAndCertificateActions(); err != nil {
 			return err
 		}
@@ -0,0 +1,191 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/metric"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+var (
+	cachedExternalAuths = externalAuthCache{
+		entries: make(map[string]externalAuthCacheEntry),
+	}
+)
+
+func init() {
+	// the credentials are hashed using a random per-process key so that
+	// they cannot be recovered from the cache keys
+	cachedExternalAuths.hashKey = make([]byte, 32)
+	if _, err := rand.Read(cachedExternalAuths.hashKey); err != nil {
+		panic(err)
+	}
+}
+
+// ExternalAuthCacheConfig defines the cache for the external authentication
+// and pre-login hook results
+type ExternalAuthCacheConfig struct {
+	// Set to true to skip the hooks for repeated identical authentications.
+	// Only successful password, public key and TLS certificate authentications
+	// are cached, keyboard interactive authentications are never cached
+	Enabled bool `json:"enabled" mapstructure:"enabled"`
+	// Time to live for the cached results as seconds
+	TTL int `json:"ttl" mapstructure:"ttl"`
+	// Maximum number of cached results
+	MaxSize int `json:"max_size" mapstructure:"max_size"`
+}
+
+func (c *ExternalAuthCacheConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.TTL <= 0 {
+		return util.NewValidationError("external auth cache TTL must be greater than 0")
+	}
+	if c.MaxSize <= 0 {
+		return util.NewValidationError("external auth cache max size must be greater than 0")
+	}
+	return nil
+}
+
+type externalAuthCacheEntry struct {
+	// username returned from the hook, it could be different from the login one
+	username  string
+	expiresAt int64
+}
+
+type externalAuthCache struct {
+	sync.RWMutex
+	hashKey []byte
+	entries map[string]externalAuthCacheEntry
+}
+
+func (c *externalAuthCache) getKey(username, loginMethod string, credential []byte) string {
+	h := hmac.New(sha256.New, c.hashKey)
+	h.Write(credential)
+	return username + ":" + loginMethod + ":" + hex.EncodeToString(h.Sum(nil))
+}
+
+// get returns the user for a cached successful authentication
+func (c *externalAuthCache) get(username, loginMethod string, credential []byte) (User, bool) {
+	if !config.ExternalAuthCache.Enabled {
+		return User{}, false
+	}
+	key := c.getKey(username, loginMethod, credential)
+
+	c.RLock()
+	entry, ok := c.entries[key]
+	c.RUnlock()
+
+	if !ok || entry.expiresAt < util.GetTimeAsMsSinceEpoch(time.Now()) {
+		metric.AddExternalAuthCacheMiss()
+		return User{}, false
+	}
+	user, err := provider.userExists(entry.username, "")
+	if err != nil {
+		providerLog(logger.LevelDebug, "unable to get cached external auth user %q: %v", entry.username, err)
+		c.remove(key)
+		metric.AddExternalAuthCacheMiss()
+		return User{}, false
+	}
+	metric.AddExternalAuthCacheHit()
+	providerLog(logger.LevelDebug, "external auth cache hit for user %q, login method %q", username, loginMethod)
+	return user, true
+}
+
+func (c *externalAuthCache) add(username, loginMethod string, credential []byte, user *User) {
+	if !config.ExternalAuthCache.Enabled || user.Username == "" {
+		return
+	}
+	key := c.getKey(username, loginMethod, credential)
+	now := util.GetTimeAsMsSinceEpoch(time.Now())
+
+	c.Lock()
+	defer c.Unlock()
+
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= config.ExternalAuthCache.MaxSize {
+		c.evict(now)
+	}
+	c.entries[key] = externalAuthCacheEntry{
+		username:  user.Username,
+		expiresAt: now + int64(config.ExternalAuthCache.TTL)*1000,
+	}
+}
+
+// evict removes the expired entries or, if none is expired, the entry
+// closest to expiration. It must be called with the lock held
+func (c *externalAuthCache) evict(now int64) {
+	var oldestKey string
+	var oldestExpiration int64
+	for k, v := range c.entries {
+		if v.expiresAt < now {
+			delete(c.entries, k)
+			continue
+		}
+		if oldestKey == "" || v.expiresAt < oldestExpiration {
+			oldestKey = k
+			oldestExpiration = v.expiresAt
+		}
+	}
+	if len(c.entries) >= config.ExternalAuthCache.MaxSize && oldestKey != "" {
+		delete(c.entries, oldestKey)
+	}
+}
+
+func (c *externalAuthCache) remove(key string) {
+	c.Lock()
+	defer c.Unlock()
+
+	delete(c.entries, key)
+}
+
+func (c *externalAuthCache) cleanup() {
+	if !config.ExternalAuthCache.Enabled {
+		return
+	}
+	now := util.GetTimeAsMsSinceEpoch(time.Now())
+
+	c.Lock()
+	defer c.Unlock()
+
+	for k, v := range c.entries {
+		if v.expiresAt < now {
+			delete(c.entries, k)
+		}
+	}
+}
+
+func (c *externalAuthCache) clear() {
+	c.Lock()
+	defer c.Unlock()
+
+	c.entries = make(map[string]externalAuthCacheEntry)
+}
+
+func getTLSCertRaw(tlsCert *x509.Certificate) []byte {
+	if tlsCert == nil {
+		return nil
+	}
+	return tlsCert.Raw
+}
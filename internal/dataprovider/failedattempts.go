@@ -0,0 +1,213 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// failedAttempts defines the consecutive failed attempts and the lockout state
+type failedAttempts struct {
+	Failures    int   `json:"failures"`
+	LockedUntil int64 `json:"locked_until,omitempty"`
+	UpdatedAt   int64 `json:"updated_at"`
+}
+
+func (a *failedAttempts) isLocked(now int64) bool {
+	return a.LockedUntil > now
+}
+
+func (a *failedAttempts) getExpiration(ttl time.Duration) int64 {
+	expiresAt := a.UpdatedAt + ttl.Milliseconds()
+	if a.LockedUntil > expiresAt {
+		return a.LockedUntil
+	}
+	return expiresAt
+}
+
+const (
+	// maximum number of retries for the failed attempts updates concurrently
+	// modified by other instances
+	failedAttemptsMaxUpdateRetries = 20
+)
+
+// failedAttemptsUpdater receives the current failed attempts, ok is false if
+// there are no stored attempts, and returns the attempts to store and their
+// expiration. Nothing is stored if save is false. It can be called multiple
+// times if the stored attempts are concurrently modified
+type failedAttemptsUpdater func(attempts failedAttempts, ok bool) (updated failedAttempts, expiresAt int64, save bool)
+
+// failedAttemptsStore stores the failed attempts in memory or, in shared mode,
+// within the data provider so they are shared between multiple instances
+type failedAttemptsStore interface {
+	get(key string) (failedAttempts, bool)
+	// update atomically reads and updates the failed attempts for the
+	// specified key, concurrent failures cannot be lost
+	update(key string, fn failedAttemptsUpdater) error
+	remove(key string)
+	cleanup()
+}
+
+type memoryFailedAttemptsEntry struct {
+	attempts  failedAttempts
+	expiresAt int64
+}
+
+type memoryFailedAttemptsStore struct {
+	sync.Mutex
+	entries map[string]memoryFailedAttemptsEntry
+}
+
+func newMemoryFailedAttemptsStore() *memoryFailedAttemptsStore {
+	return &memoryFailedAttemptsStore{
+		entries: make(map[string]memoryFailedAttemptsEntry),
+	}
+}
+
+func (s *memoryFailedAttemptsStore) get(key string) (failedAttempts, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.getLocked(key)
+}
+
+func (s *memoryFailedAttemptsStore) getLocked(key string) (failedAttempts, bool) {
+	entry, ok := s.entries[key]
+	if !ok {
+		return failedAttempts{}, false
+	}
+	if entry.expiresAt < util.GetTimeAsMsSinceEpoch(time.Now()) {
+		delete(s.entries, key)
+		return failedAttempts{}, false
+	}
+	return entry.attempts, true
+}
+
+func (s *memoryFailedAttemptsStore) update(key string, fn failedAttemptsUpdater) error {
+	s.Lock()
+	defer s.Unlock()
+
+	attempts, ok := s.getLocked(key)
+	attempts, expiresAt, save := fn(attempts, ok)
+	if save {
+		s.entries[key] = memoryFailedAttemptsEntry{
+			attempts:  attempts,
+			expiresAt: expiresAt,
+		}
+	}
+	return nil
+}
+
+func (s *memoryFailedAttemptsStore) remove(key string) {
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.entries, key)
+}
+
+func (s *memoryFailedAttemptsStore) cleanup() {
+	s.Lock()
+	defer s.Unlock()
+
+	now := util.GetTimeAsMsSinceEpoch(time.Now())
+	for key, entry := range s.entries {
+		if entry.expiresAt < now {
+			delete(s.entries, key)
+		}
+	}
+}
+
+type dbFailedAttemptsStore struct {
+	sessionType SessionType
+}
+
+func (s *dbFailedAttemptsStore) get(key string) (failedAttempts, bool) {
+	session, err := GetSharedSession(key, s.sessionType)
+	if err != nil {
+		return failedAttempts{}, false
+	}
+	return s.decode(session)
+}
+
+func (s *dbFailedAttemptsStore) decode(session Session) (failedAttempts, bool) {
+	var attempts failedAttempts
+
+	if session.Timestamp < util.GetTimeAsMsSinceEpoch(time.Now()) {
+		return attempts, false
+	}
+	data, ok := session.Data.([]byte)
+	if !ok {
+		providerLog(logger.LevelError, "invalid failed attempts data type %T, session type: %v", session.Data, s.sessionType)
+		return attempts, false
+	}
+	if err := json.Unmarshal(data, &attempts); err != nil {
+		providerLog(logger.LevelError, "unable to decode failed attempts, session type %v: %v", s.sessionType, err)
+		return attempts, false
+	}
+	return attempts, true
+}
+
+// update uses an optimistic concurrency control: the stored attempts are
+// replaced only if they were not modified after reading them, otherwise the
+// update is retried with the new stored attempts
+func (s *dbFailedAttemptsStore) update(key string, fn failedAttemptsUpdater) error {
+	for range failedAttemptsMaxUpdateRetries {
+		var previousData []byte
+		var attempts failedAttempts
+		var ok bool
+
+		session, err := GetSharedSession(key, s.sessionType)
+		if err == nil {
+			previousData, _ = session.Data.([]byte)
+			attempts, ok = s.decode(session)
+		} else if !errors.Is(err, util.ErrNotFound) {
+			return err
+		}
+		attempts, expiresAt, save := fn(attempts, ok)
+		if !save {
+			return nil
+		}
+		replaced, err := ReplaceSharedSession(Session{
+			Key:       key,
+			Data:      attempts,
+			Type:      s.sessionType,
+			Timestamp: expiresAt,
+		}, previousData)
+		if err != nil {
+			return err
+		}
+		if replaced {
+			return nil
+		}
+	}
+	return fmt.Errorf("unable to update the failed attempts for key %q, too many concurrent updates", key)
+}
+
+func (s *dbFailedAttemptsStore) remove(key string) {
+	if _, ok := s.get(key); ok {
+		DeleteSharedSession(key, s.sessionType) //nolint:errcheck
+	}
+}
+
+func (s *dbFailedAttemptsStore) cleanup() {
+	CleanupSharedSessions(s.sessionType, time.Now()) //nolint:errcheck
+}
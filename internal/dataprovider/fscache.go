@@ -0,0 +1,109 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"sync"
+
+	"github.com/sftpgo/sdk"
+
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+// fsCache stores the filesystems for a user using the base virtual path as key
+type fsCache struct {
+	mu          sync.RWMutex
+	filesystems map[string]cachedFs
+}
+
+type cachedFs struct {
+	fs vfs.Fs
+	// copy of the configuration used to create the filesystem, taken before
+	// the secrets are decrypted
+	config vfs.Filesystem
+}
+
+func newFsCache() *fsCache {
+	return &fsCache{
+		filesystems: make(map[string]cachedFs),
+	}
+}
+
+func (c *fsCache) get(virtualPath string) (vfs.Fs, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	val, ok := c.filesystems[virtualPath]
+	return val.fs, ok
+}
+
+func (c *fsCache) getConfig(virtualPath string) (vfs.Filesystem, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	val, ok := c.filesystems[virtualPath]
+	return val.config, ok
+}
+
+func (c *fsCache) set(virtualPath string, fs vfs.Fs, config vfs.Filesystem) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.filesystems[virtualPath] = cachedFs{
+		fs:     fs,
+		config: config,
+	}
+}
+
+func (c *fsCache) close() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var err error
+	for _, val := range c.filesystems {
+		errClose := val.fs.Close()
+		if err == nil {
+			err = errClose
+		}
+	}
+	return err
+}
+
+func (c *fsCache) cancelOperations() {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, val := range c.filesystems {
+		vfs.CancelFsOperations(val.fs)
+	}
+}
+
+// isRefreshableFs returns true if the cloud storage filesystem created using
+// the current configuration must be replaced with one created using the
+// updated configuration. Only the filesystems pointing to the same bucket or
+// container are replaced, for example after a credentials rotation
+func isRefreshableFs(current, updated *vfs.Filesystem) bool {
+	switch current.Provider {
+	case sdk.S3FilesystemProvider, sdk.GCSFilesystemProvider, sdk.AzureBlobFilesystemProvider:
+		return current.IsSameResource(*updated) && !current.IsEqual(*updated)
+	default:
+		return false
+	}
+}
+
+func wrapFs(fs vfs.Fs, config *vfs.Filesystem) vfs.Fs {
+	vfs.SetMaxListEntries(fs, config.ListingMaxEntries)
+	return vfs.NewTracedFs(fs)
+}
@@ -33,6 +33,21 @@ type GroupUserSettings struct {
 	sdk.BaseGroupUserSettings
 	// Filesystem configuration details
 	FsConfig vfs.Filesystem `json:"filesystem"`
+	// Public key algorithms allowed for public key authentication,
+	// applied to users without their own restriction
+	AllowedKeyAlgos []string `json:"allowed_key_algos,omitempty"`
+	// Networks, as CIDRs, where the second factor authentication is not required,
+	// applied to users without their own trusted networks
+	TwoFactorTrustedNetworks []string `json:"two_factor_trusted_networks,omitempty"`
+	// Permissions for the files and directories created on local filesystems,
+	// applied to users without their own permissions
+	CreateModes CreateModes `json:"create_modes,omitempty"`
+	// Policy for the ownership change requests, applied to users without
+	// their own policy
+	ChownPolicy ChownPolicy `json:"chown_policy,omitempty"`
+	// How the permissions are merged with the ones already defined for the
+	// same path, if the group is a secondary group. See PermissionsMerge*
+	PermissionsMerge int `json:"permissions_merge,omitempty"`
 }
 
 // Group defines an SFTPGo group.
@@ -134,37 +149,44 @@ func (g *Group) hasRedactedSecret() bool {
 
 func (g *Group) validate() error {
 	g.SetEmptySecretsIfNil()
+	// independent checks are accumulated, so all the problems are reported at once
+	var errs validationErrors
 	if g.Name == "" {
-		return util.NewI18nError(util.NewValidationError("name is mandatory"), util.I18nErrorNameRequired)
-	}
-	if config.NamingRules&1 == 0 && !usernameRegex.MatchString(g.Name) {
-		return util.NewI18nError(
+		errs.add("name", util.NewI18nError(util.NewValidationError("name is mandatory"), util.I18nErrorNameRequired)) //nolint:errcheck
+	} else if config.NamingRules&1 == 0 && !usernameRegex.MatchString(g.Name) {
+		errs.add("name", util.NewI18nError( //nolint:errcheck
 			util.NewValidationError(fmt.Sprintf("name %q is not valid, the following characters are allowed: a-zA-Z0-9-_.~", g.Name)),
 			util.I18nErrorInvalidName,
-		)
+		))
 	}
 	if g.hasRedactedSecret() {
 		return util.NewValidationError("cannot save a group with a redacted secret")
 	}
 	vfolders, err := validateAssociatedVirtualFolders(g.VirtualFolders)
-	if err != nil {
+	if err == nil {
+		g.VirtualFolders = vfolders
+	} else if err := errs.add("virtual_folders", err); err != nil {
+		return err
+	}
+	if err := g.validateUserSettings(&errs); err != nil {
 		return err
 	}
-	g.VirtualFolders = vfolders
-	return g.validateUserSettings()
+	return errs.err()
 }
 
-func (g *Group) validateUserSettings() error {
+// validateUserSettings adds the validation errors to errs, other errors are returned
+func (g *Group) validateUserSettings(errs *validationErrors) error {
 	if g.UserSettings.HomeDir != "" {
 		g.UserSettings.HomeDir = filepath.Clean(g.UserSettings.HomeDir)
 		if !filepath.IsAbs(g.UserSettings.HomeDir) {
-			return util.NewI18nError(
-				util.NewValidationError(fmt.Sprintf("home_dir must be an absolute path, actual value: %v", g.UserSettings.HomeDir)),
+			errs.add("user_settings.home_dir", util.NewI18nError( //nolint:errcheck
+				util.NewValidationErrorWithCode(fmt.Sprintf("home_dir must be an absolute path, actual value: %v", g.UserSettings.HomeDir),
+					util.ErrorCodeHomeDirInvalid),
 				util.I18nErrorInvalidHomeDir,
-			)
+			))
 		}
 	}
-	if err := g.UserSettings.FsConfig.Validate(g.GetEncryptionAdditionalData()); err != nil {
+	if err := errs.add("user_settings.filesystem", g.UserSettings.FsConfig.Validate(g.GetEncryptionAdditionalData())); err != nil {
 		return err
 	}
 	if g.UserSettings.TotalDataTransfer > 0 {
@@ -174,13 +196,35 @@ func (g *Group) validateUserSettings() error {
 	}
 	if len(g.UserSettings.Permissions) > 0 {
 		permissions, err := validateUserPermissions(g.UserSettings.Permissions)
-		if err != nil {
-			return util.NewI18nError(err, util.I18nErrorGenericPermission)
+		if err == nil {
+			g.UserSettings.Permissions = permissions
+		} else if err := errs.add("user_settings.permissions", util.NewI18nError(err, util.I18nErrorGenericPermission)); err != nil {
+			return err
 		}
-		g.UserSettings.Permissions = permissions
 	}
 	g.UserSettings.Filters.TLSCerts = nil
-	if err := validateBaseFilters(&g.UserSettings.Filters); err != nil {
+	if err := errs.add("user_settings.filters", validateBaseFilters(&g.UserSettings.Filters)); err != nil {
+		return err
+	}
+	algos, err := validateAllowedKeyAlgos(g.UserSettings.AllowedKeyAlgos)
+	if err == nil {
+		g.UserSettings.AllowedKeyAlgos = algos
+	} else if err := errs.add("user_settings.allowed_key_algos", err); err != nil {
+		return err
+	}
+	networks, err := validateTwoFactorTrustedNetworks(g.UserSettings.TwoFactorTrustedNetworks)
+	if err == nil {
+		g.UserSettings.TwoFactorTrustedNetworks = networks
+	} else if err := errs.add("user_settings.two_factor_trusted_networks", err); err != nil {
+		return err
+	}
+	if err := errs.add("user_settings.create_modes", g.UserSettings.CreateModes.validate()); err != nil {
+		return err
+	}
+	if err := errs.add("user_settings.chown_policy", g.UserSettings.ChownPolicy.validate()); err != nil {
+		return err
+	}
+	if err := errs.add("user_settings.permissions_merge", validatePermissionsMerge(g.UserSettings.PermissionsMerge)); err != nil {
 		return err
 	}
 	if !g.HasExternalAuth() {
@@ -200,6 +244,10 @@ func (g *Group) getACopy() Group {
 		vfolder := g.VirtualFolders[idx].GetACopy()
 		virtualFolders = append(virtualFolders, vfolder)
 	}
+	allowedKeyAlgos := make([]string, len(g.UserSettings.AllowedKeyAlgos))
+	copy(allowedKeyAlgos, g.UserSettings.AllowedKeyAlgos)
+	trustedNetworks := make([]string, len(g.UserSettings.TwoFactorTrustedNetworks))
+	copy(trustedNetworks, g.UserSettings.TwoFactorTrustedNetworks)
 	permissions := make(map[string][]string)
 	for k, v := range g.UserSettings.Permissions {
 		perms := make([]string, len(v))
@@ -232,7 +280,12 @@ func (g *Group) getACopy() Group {
 				ExpiresIn:            g.UserSettings.ExpiresIn,
 				Filters:              copyBaseUserFilters(g.UserSettings.Filters),
 			},
-			FsConfig: g.UserSettings.FsConfig.GetACopy(),
+			FsConfig:                 g.UserSettings.FsConfig.GetACopy(),
+			AllowedKeyAlgos:          allowedKeyAlgos,
+			TwoFactorTrustedNetworks: trustedNetworks,
+			CreateModes:              g.UserSettings.CreateModes,
+			ChownPolicy:              g.UserSettings.ChownPolicy,
+			PermissionsMerge:         g.UserSettings.PermissionsMerge,
 		},
 		VirtualFolders: virtualFolders,
 	}
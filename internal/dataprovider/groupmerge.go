@@ -0,0 +1,128 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/sftpgo/sdk"
+
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// Supported merge modes for the permissions of secondary groups.
+// The permissions of the primary group are always merged using the union
+const (
+	// PermissionsMergeKeep keeps the permissions already defined for a path
+	PermissionsMergeKeep = iota
+	// PermissionsMergeUnion merges the permissions already defined for a path
+	// with the group ones
+	PermissionsMergeUnion
+)
+
+// EffectiveSettingSource defines where an effective user setting comes from
+type EffectiveSettingSource struct {
+	// Group name, empty if the setting is defined for the user
+	Group string `json:"group,omitempty"`
+	// Group type, 0 if the setting is defined for the user
+	GroupType int `json:"group_type,omitempty"`
+}
+
+// EffectiveSettingsSources defines the sources of the effective virtual folders
+// and permissions for a user with the group settings applied
+type EffectiveSettingsSources struct {
+	// Virtual path -> source of the mounted virtual folder
+	VirtualFolders map[string]EffectiveSettingSource `json:"virtual_folders"`
+	// Path -> sources of the merged permissions
+	Permissions map[string][]EffectiveSettingSource `json:"permissions"`
+}
+
+func newEffectiveSettingsSources(user *User) *EffectiveSettingsSources {
+	sources := &EffectiveSettingsSources{
+		VirtualFolders: make(map[string]EffectiveSettingSource),
+		Permissions:    make(map[string][]EffectiveSettingSource),
+	}
+	for _, folder := range user.VirtualFolders {
+		sources.VirtualFolders[folder.VirtualPath] = EffectiveSettingSource{}
+	}
+	for dir := range user.Permissions {
+		sources.Permissions[dir] = []EffectiveSettingSource{{}}
+	}
+	return sources
+}
+
+func (s *EffectiveSettingsSources) addVirtualFolder(virtualPath, groupName string, groupType int) {
+	if s == nil {
+		return
+	}
+	s.VirtualFolders[virtualPath] = EffectiveSettingSource{Group: groupName, GroupType: groupType}
+}
+
+func (s *EffectiveSettingsSources) addPermissions(dir, groupName string, groupType int, replace bool) {
+	if s == nil {
+		return
+	}
+	source := EffectiveSettingSource{Group: groupName, GroupType: groupType}
+	if replace {
+		s.Permissions[dir] = []EffectiveSettingSource{source}
+		return
+	}
+	s.Permissions[dir] = append(s.Permissions[dir], source)
+}
+
+func validatePermissionsMerge(mode int) error {
+	if mode != PermissionsMergeKeep && mode != PermissionsMergeUnion {
+		return util.NewValidationError(fmt.Sprintf("invalid permissions merge mode: %d", mode))
+	}
+	return nil
+}
+
+func shouldMergePermissions(group *Group, groupType int) bool {
+	return groupType == sdk.GroupTypePrimary || group.UserSettings.PermissionsMerge == PermissionsMergeUnion
+}
+
+// unionPermissions returns the union of the given permissions. If the result
+// combines the dropbox permission with permissions that reveal the directory
+// contents, dropbox is replaced by the permissions it implies
+func unionPermissions(perms, groupPerms []string) []string {
+	if slices.Contains(perms, PermAny) || slices.Contains(groupPerms, PermAny) {
+		return []string{PermAny}
+	}
+	result := make([]string, 0, len(perms)+len(groupPerms))
+	result = append(result, perms...)
+	result = append(result, groupPerms...)
+	if validateDropboxPermissions(result) != nil {
+		result = slices.DeleteFunc(result, func(p string) bool {
+			return p == PermDropbox
+		})
+		result = append(result, PermUpload, PermCreateDirs)
+	}
+	return util.RemoveDuplicates(result, false)
+}
+
+// GetUserWithEffectiveSettings returns the user with the given username with
+// the group settings applied and the sources of the effective virtual folders
+// and permissions
+func GetUserWithEffectiveSettings(username, role string) (User, error) {
+	username = config.convertName(username)
+	user, err := provider.userExists(username, role)
+	if err != nil {
+		return user, err
+	}
+	user.EffectiveSources = newEffectiveSettingsSources(&user)
+	err = user.LoadAndApplyGroupSettings()
+	return user, err
+}
@@ -0,0 +1,152 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/kms"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	// prefix for the tasks used as host keys generation locks
+	hostKeyTaskPrefix = "/sftpd/host_key/"
+	// generating a key takes a few seconds at most, after this timeout
+	// the lock held by another instance is considered stale
+	hostKeyLockTimeout        = 2 * time.Minute
+	hostKeyLockCheckInterval  = time.Second
+	hostKeyGenerationDeadline = hostKeyLockTimeout + 30*time.Second
+)
+
+var (
+	hostKeyGenerationMu sync.Mutex
+)
+
+func getSFTPDHostKey(name string) (SFTPDHostKey, bool, error) {
+	configs, err := provider.getConfigs()
+	if err != nil {
+		return SFTPDHostKey{}, false, err
+	}
+	configs.SetNilsToEmpty()
+	key, ok := configs.SFTPD.GetHostKey(name)
+	if !ok {
+		return key, false, nil
+	}
+	if err := key.TryDecrypt(); err != nil {
+		return key, true, err
+	}
+	return key, true, nil
+}
+
+// acquireHostKeyLock tries to acquire the lock to generate the host key with
+// the specified name. The lock is implemented using the tasks table so it is
+// shared among multiple instances. Providers without tasks support are not
+// shared and so the process level lock is enough
+func acquireHostKeyLock(name string) (bool, error) {
+	taskName := hostKeyTaskPrefix + name
+	task, err := provider.getTaskByName(taskName)
+	if err != nil {
+		if errors.Is(err, ErrNotImplemented) {
+			return true, nil
+		}
+		if errors.Is(err, util.ErrNotFound) {
+			// if another instance added the task in the meantime the insert fails
+			return provider.addTask(taskName) == nil, nil
+		}
+		return false, err
+	}
+	if util.GetTimeFromMsecSinceEpoch(task.UpdateAt).Add(hostKeyLockTimeout).After(time.Now()) {
+		return false, nil
+	}
+	// the version check ensures that only one instance can take over a stale lock
+	return provider.updateTask(taskName, task.Version, util.GetTimeAsMsSinceEpoch(time.Now())) == nil, nil
+}
+
+func releaseHostKeyLock(name string) {
+	err := provider.deleteTask(hostKeyTaskPrefix + name)
+	if err != nil && !errors.Is(err, ErrNotImplemented) {
+		// the lock will be considered stale after the timeout
+		providerLog(logger.LevelWarn, "unable to release the lock to generate host key %q: %v", name, err)
+	}
+}
+
+// GetOrCreateSFTPDHostKey returns the private host key with the specified name
+// stored in the data provider with the secrets decrypted. If the key does not
+// exist it is generated using the provided function. The generation is
+// coordinated so that multiple instances starting simultaneously use the
+// same key: only the lock holder generates the key, the other instances wait
+// and then read the stored key
+func GetOrCreateSFTPDHostKey(name string, generate func() ([]byte, error)) (SFTPDHostKey, error) {
+	hostKeyGenerationMu.Lock()
+	defer hostKeyGenerationMu.Unlock()
+
+	deadline := time.Now().Add(hostKeyGenerationDeadline)
+	for {
+		key, ok, err := getSFTPDHostKey(name)
+		if err != nil || ok {
+			return key, err
+		}
+		acquired, err := acquireHostKeyLock(name)
+		if err != nil {
+			return key, fmt.Errorf("unable to acquire the lock to generate host key %q: %w", name, err)
+		}
+		if acquired {
+			key, err = createSFTPDHostKey(name, generate)
+			releaseHostKeyLock(name)
+			return key, err
+		}
+		if time.Now().After(deadline) {
+			return key, fmt.Errorf("timeout waiting for host key %q generated by another instance", name)
+		}
+		providerLog(logger.LevelDebug, "host key %q is being generated by another instance, waiting", name)
+		time.Sleep(hostKeyLockCheckInterval)
+	}
+}
+
+func createSFTPDHostKey(name string, generate func() ([]byte, error)) (SFTPDHostKey, error) {
+	configs, err := provider.getConfigs()
+	if err != nil {
+		return SFTPDHostKey{}, err
+	}
+	configs.SetNilsToEmpty()
+	if _, ok := configs.SFTPD.GetHostKey(name); !ok {
+		providerLog(logger.LevelInfo, "generating host key %q", name)
+		privateKey, err := generate()
+		if err != nil {
+			return SFTPDHostKey{}, fmt.Errorf("unable to generate host key %q: %w", name, err)
+		}
+		configs.SFTPD.HostKeys = append(configs.SFTPD.HostKeys, SFTPDHostKey{
+			Name:       name,
+			PrivateKey: kms.NewPlainSecret(string(privateKey)),
+		})
+		if err := UpdateConfigs(&configs, ActionExecutorSystem, "", ""); err != nil {
+			return SFTPDHostKey{}, fmt.Errorf("unable to save host key %q: %w", name, err)
+		}
+	}
+	// read the stored key, so the same key is used by all the instances
+	key, ok, err := getSFTPDHostKey(name)
+	if err != nil {
+		return key, err
+	}
+	if !ok {
+		return key, fmt.Errorf("host key %q not found after saving", name)
+	}
+	return key, nil
+}
@@ -0,0 +1,75 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+var (
+	// ErrLoginBudgetExhausted is returned for any login after the user consumed its login budget
+	ErrLoginBudgetExhausted = errors.New("login budget exhausted, no logins remaining")
+)
+
+// HasLoginBudget returns true if the number of logins allowed for the user is limited
+func (u *User) HasLoginBudget() bool {
+	return u.Filters.MaxLogins > 0
+}
+
+// GetRemainingLogins returns the logins remaining in the user budget,
+// nil means unlimited
+func (u *User) GetRemainingLogins() *int {
+	if !u.HasLoginBudget() {
+		return nil
+	}
+	remaining := max(u.Filters.MaxLogins-u.UsedLogins, 0)
+	return &remaining
+}
+
+func (u *User) validateLoginBudget() error {
+	if u.Filters.MaxLogins < 0 {
+		return util.NewValidationError(fmt.Sprintf("invalid max logins: %d", u.Filters.MaxLogins))
+	}
+	// each WebDAV request is authenticated and there is no session to count or end
+	if u.HasLoginBudget() && !slices.Contains(u.Filters.DeniedProtocols, protocolWebDAV) {
+		return util.NewValidationError("WebDAV must be denied for users with a limited number of logins")
+	}
+	if u.Filters.DisableAfterFirstSession && (!slices.Contains(u.Filters.DeniedProtocols, protocolWebDAV) ||
+		!slices.Contains(u.Filters.DeniedProtocols, protocolHTTP)) {
+		return util.NewValidationError("HTTP and WebDAV must be denied for users disabled after their first session")
+	}
+	return nil
+}
+
+// ConsumeLogin decreases the login budget for the specified user, if limited.
+// The budget is checked and updated atomically within the data provider, so
+// concurrent logins cannot exceed it. ErrLoginBudgetExhausted is returned if
+// no logins remain
+func ConsumeLogin(user *User) error {
+	if !user.HasLoginBudget() {
+		return nil
+	}
+	err := provider.consumeUserLogin(user.Username, user.Filters.MaxLogins)
+	if errors.Is(err, ErrLoginBudgetExhausted) {
+		providerLog(logger.LevelInfo, "login budget exhausted for user %q, max logins: %d", user.Username,
+			user.Filters.MaxLogins)
+	}
+	return err
+}
@@ -0,0 +1,272 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// Supported login event statuses
+const (
+	LoginEventStatusAny = iota
+	LoginEventStatusOK
+	LoginEventStatusFailed
+)
+
+const (
+	loginEventsFlushInterval = 2 * time.Second
+	loginEventsMaxPending    = 10000
+	loginEventsBatchSize     = 100
+	loginEventsMaxLimit      = 1000
+)
+
+var loginEvents = newLoginEventsRecorder()
+
+// LoginEventsConfig defines the configuration for the login history
+type LoginEventsConfig struct {
+	// Set to true to record successful and failed logins in the data provider
+	Enabled bool `json:"enabled" mapstructure:"enabled"`
+	// Login events older than the configured number of hours are automatically removed.
+	// 0 means no automatic cleanup
+	RetentionHours int `json:"retention_hours" mapstructure:"retention_hours"`
+}
+
+func (c *LoginEventsConfig) validate() error {
+	if c.RetentionHours < 0 {
+		return util.NewValidationError("login events retention cannot be negative")
+	}
+	return nil
+}
+
+// LoginEvent defines a recorded login attempt
+type LoginEvent struct {
+	ID int64 `json:"id"`
+	// unix timestamp in milliseconds
+	Timestamp     int64  `json:"timestamp"`
+	Username      string `json:"username"`
+	IP            string `json:"ip"`
+	Protocol      string `json:"protocol"`
+	LoginMethod   string `json:"login_method"`
+	ClientVersion string `json:"client_version,omitempty"`
+	Status        int    `json:"status"`
+	Error         string `json:"error,omitempty"`
+}
+
+func (e *LoginEvent) truncateFields() {
+	e.Username = truncateString(e.Username, 255)
+	e.ClientVersion = truncateString(e.ClientVersion, 255)
+	e.Error = truncateString(e.Error, 1024)
+}
+
+func truncateString(s string, maxLen int) string {
+	if len(s) <= maxLen {
+		return s
+	}
+	return strings.ToValidUTF8(s[:maxLen], "")
+}
+
+// LoginEventFilter defines the supported filters for login events
+type LoginEventFilter struct {
+	Username string `json:"username,omitempty"`
+	IP       string `json:"ip,omitempty"`
+	// 0 any, 1 successful logins, 2 failed logins
+	Status int `json:"status,omitempty"`
+	// unix timestamps in milliseconds, 0 means no limit
+	StartTimestamp int64 `json:"start_timestamp,omitempty"`
+	EndTimestamp   int64 `json:"end_timestamp,omitempty"`
+	Limit          int   `json:"limit,omitempty"`
+	// ASC or DESC, sorting is by timestamp
+	Order string `json:"order,omitempty"`
+}
+
+func (f *LoginEventFilter) validate() error {
+	if f.Status < LoginEventStatusAny || f.Status > LoginEventStatusFailed {
+		return util.NewValidationError("invalid login event status")
+	}
+	if f.StartTimestamp > 0 && f.EndTimestamp > 0 && f.StartTimestamp > f.EndTimestamp {
+		return util.NewValidationError("the start timestamp must be before the end timestamp")
+	}
+	if f.Limit <= 0 || f.Limit > loginEventsMaxLimit {
+		f.Limit = loginEventsMaxLimit
+	}
+	if f.Order != OrderASC {
+		f.Order = OrderDESC
+	}
+	return nil
+}
+
+func (f *LoginEventFilter) match(e *LoginEvent) bool {
+	if f.Username != "" && f.Username != e.Username {
+		return false
+	}
+	if f.IP != "" && f.IP != e.IP {
+		return false
+	}
+	if f.Status != LoginEventStatusAny && f.Status != e.Status {
+		return false
+	}
+	if f.StartTimestamp > 0 && e.Timestamp < f.StartTimestamp {
+		return false
+	}
+	if f.EndTimestamp > 0 && e.Timestamp > f.EndTimestamp {
+		return false
+	}
+	return true
+}
+
+// loginEventsRecorder accumulates login events and stores them in batches,
+// so the authentication path is never blocked by the data provider
+type loginEventsRecorder struct {
+	sync.Mutex
+	pending []LoginEvent
+	dropped int64
+	running bool
+	done    chan bool
+}
+
+func newLoginEventsRecorder() *loginEventsRecorder {
+	return &loginEventsRecorder{}
+}
+
+func (r *loginEventsRecorder) start() {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.running || !config.LoginEvents.Enabled {
+		return
+	}
+	r.running = true
+	r.done = make(chan bool)
+	go r.loop(r.done)
+}
+
+func (r *loginEventsRecorder) stop() {
+	r.Lock()
+	if !r.running {
+		r.Unlock()
+		return
+	}
+	r.running = false
+	close(r.done)
+	r.Unlock()
+
+	r.flush()
+}
+
+func (r *loginEventsRecorder) loop(done chan bool) {
+	providerLog(logger.LevelDebug, "login events recorder started")
+	ticker := time.NewTicker(loginEventsFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			providerLog(logger.LevelDebug, "login events recorder stopped")
+			return
+		case <-ticker.C:
+			r.flush()
+		}
+	}
+}
+
+func (r *loginEventsRecorder) add(event LoginEvent) {
+	r.Lock()
+	defer r.Unlock()
+
+	if !r.running {
+		return
+	}
+	if len(r.pending) >= loginEventsMaxPending {
+		r.dropped++
+		return
+	}
+	r.pending = append(r.pending, event)
+}
+
+func (r *loginEventsRecorder) getPending() ([]LoginEvent, int64) {
+	r.Lock()
+	defer r.Unlock()
+
+	events := r.pending
+	dropped := r.dropped
+	r.pending = nil
+	r.dropped = 0
+	return events, dropped
+}
+
+func (r *loginEventsRecorder) flush() {
+	events, dropped := r.getPending()
+	if dropped > 0 {
+		providerLog(logger.LevelWarn, "%d login events dropped, too many pending events", dropped)
+	}
+	for len(events) > 0 {
+		batchSize := min(len(events), loginEventsBatchSize)
+		if err := provider.addLoginEvents(events[:batchSize]); err != nil {
+			providerLog(logger.LevelError, "unable to store %d login events: %v", batchSize, err)
+		}
+		events = events[batchSize:]
+	}
+}
+
+// AddLoginEvent records a login attempt, the event is stored asynchronously.
+// It does nothing if the login history is disabled
+func AddLoginEvent(username, ip, protocol, loginMethod, clientVersion string, err error) {
+	if !config.LoginEvents.Enabled {
+		return
+	}
+	event := LoginEvent{
+		Timestamp:     util.GetTimeAsMsSinceEpoch(time.Now()),
+		Username:      username,
+		IP:            ip,
+		Protocol:      protocol,
+		LoginMethod:   loginMethod,
+		ClientVersion: clientVersion,
+		Status:        LoginEventStatusOK,
+	}
+	if err != nil {
+		event.Status = LoginEventStatusFailed
+		event.Error = err.Error()
+	}
+	event.truncateFields()
+	loginEvents.add(event)
+}
+
+// GetLoginEvents returns the recorded login events matching the specified filter
+func GetLoginEvents(filter LoginEventFilter) ([]LoginEvent, error) {
+	if !config.LoginEvents.Enabled {
+		return nil, util.NewMethodDisabledError("login events recording is disabled")
+	}
+	if err := filter.validate(); err != nil {
+		return nil, err
+	}
+	return provider.getLoginEvents(filter)
+}
+
+func cleanupLoginEvents() {
+	if config.LoginEvents.RetentionHours <= 0 {
+		return
+	}
+	before := time.Now().Add(-time.Duration(config.LoginEvents.RetentionHours) * time.Hour)
+	if err := provider.cleanupLoginEvents(util.GetTimeAsMsSinceEpoch(before)); err != nil {
+		providerLog(logger.LevelError, "unable to cleanup login events: %v", err)
+		return
+	}
+	providerLog(logger.LevelDebug, "login events older than %s removed", before)
+}
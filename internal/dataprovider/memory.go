@@ -19,12 +19,14 @@ import (
 	"crypto/x509"
 	"errors"
 	"fmt"
+	"maps"
 	"net/netip"
 	"os"
 	"path/filepath"
 	"slices"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -78,12 +80,26 @@ type memoryProviderHandle struct {
 	roles map[string]Role
 	// slice with ordered roles
 	roleNames []string
+	// map for user templates, name is the key
+	userTemplates map[string]UserTemplate
+	// slice with ordered user templates
+	userTemplateNames []string
 	// map for IP List entry
 	ipListEntries map[string]IPListEntry
 	// slice with ordered IP list entries
 	ipListEntriesKeys []string
 	// configurations
 	configs Configs
+	// login events ordered by insertion time
+	loginEvents []LoginEvent
+	// last assigned login event ID
+	lastLoginEventID int64
+	// object changes ordered by ID
+	objectChanges []ObjectChange
+	// last assigned object change ID
+	lastObjectChangeID int64
+	// map for transfer quota windows usage, username is the key
+	transferWindows map[string]transferWindowUsage
 }
 
 // MemoryProvider defines the auth provider for a memory store
@@ -120,6 +136,8 @@ func initializeMemoryProvider(basePath string) error {
 			rulesNames:        []string{},
 			roles:             map[string]Role{},
 			roleNames:         []string{},
+			userTemplates:     map[string]UserTemplate{},
+			userTemplateNames: []string{},
 			ipListEntries:     map[string]IPListEntry{},
 			ipListEntriesKeys: []string{},
 			configs:           Configs{},
@@ -264,6 +282,43 @@ func (p *MemoryProvider) updateLastLogin(username string) error {
 	return nil
 }
 
+func (p *MemoryProvider) consumeUserLogin(username string, maxLogins int) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	user, err := p.userExistsInternal(username)
+	if err != nil {
+		return err
+	}
+	if user.UsedLogins >= maxLogins {
+		return ErrLoginBudgetExhausted
+	}
+	user.UsedLogins++
+	p.dbHandle.users[user.Username] = user
+	return nil
+}
+
+func (p *MemoryProvider) updatePublicKeyLastUse(username, key string) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	user, err := p.userExistsInternal(username)
+	if err != nil {
+		return err
+	}
+	keys := user.getPublicKeys()
+	if !setPublicKeyLastUse(keys, key) {
+		return util.NewRecordNotFoundError(fmt.Sprintf("public key not found for user %q", username))
+	}
+	user.setPublicKeys(keys)
+	p.dbHandle.users[user.Username] = user
+	return nil
+}
+
 func (p *MemoryProvider) updateAdminLastLogin(username string) error {
 	p.dbHandle.Lock()
 	defer p.dbHandle.Unlock()
@@ -304,6 +359,78 @@ func (p *MemoryProvider) updateTransferQuota(username string, uploadSize, downlo
 	return nil
 }
 
+func (p *MemoryProvider) updateTransferWindowUsage(username string, start, end, size int64) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	if p.dbHandle.transferWindows == nil {
+		p.dbHandle.transferWindows = make(map[string]transferWindowUsage)
+	}
+	usage, ok := p.dbHandle.transferWindows[username]
+	if !ok || usage.Start != start {
+		usage = transferWindowUsage{
+			Username: username,
+			Start:    start,
+			End:      end,
+		}
+	}
+	usage.Used += size
+	p.dbHandle.transferWindows[username] = usage
+	return nil
+}
+
+func (p *MemoryProvider) getTransferWindowUsage(username string) (transferWindowUsage, error) {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return transferWindowUsage{}, errMemoryProviderClosed
+	}
+	usage, ok := p.dbHandle.transferWindows[username]
+	if !ok {
+		return usage, util.NewRecordNotFoundError(fmt.Sprintf("transfer window for user %q does not exist", username))
+	}
+	return usage, nil
+}
+
+func (p *MemoryProvider) setTransferWindowNotified(username string, start int64, threshold int) (bool, error) {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return false, errMemoryProviderClosed
+	}
+	usage, ok := p.dbHandle.transferWindows[username]
+	if !ok || usage.Start != start || usage.Notified >= threshold {
+		return false, nil
+	}
+	usage.Notified = threshold
+	p.dbHandle.transferWindows[username] = usage
+	return true, nil
+}
+
+func (p *MemoryProvider) cleanupTransferWindowUsages(before int64) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	maps.DeleteFunc(p.dbHandle.transferWindows, func(_ string, usage transferWindowUsage) bool {
+		return usage.End <= before
+	})
+	return nil
+}
+
+func (p *MemoryProvider) deleteTransferWindowUsage(username string) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	delete(p.dbHandle.transferWindows, username)
+	return nil
+}
+
 func (p *MemoryProvider) updateQuota(username string, filesAdd int, sizeAdd int64, reset bool) error {
 	p.dbHandle.Lock()
 	defer p.dbHandle.Unlock()
@@ -343,7 +470,7 @@ func (p *MemoryProvider) getUsedQuota(username string) (int, int64, int64, int64
 	return user.UsedQuotaFiles, user.UsedQuotaSize, user.UsedUploadDataTransfer, user.UsedDownloadDataTransfer, err
 }
 
-func (p *MemoryProvider) addUser(user *User) error {
+func (p *MemoryProvider) addUser(user *User, change *ObjectChange) error {
 	err := ValidateUser(user)
 	if err != nil {
 		return err
@@ -371,6 +498,7 @@ func (p *MemoryProvider) addUser(user *User) error {
 	user.LastLogin = 0
 	user.FirstUpload = 0
 	user.FirstDownload = 0
+	user.UsedLogins = 0
 	user.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
 	user.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
 	if err := p.addUserToRole(user.Username, user.Role); err != nil {
@@ -407,10 +535,11 @@ func (p *MemoryProvider) addUser(user *User) error {
 	p.dbHandle.users[user.Username] = user.getACopy()
 	p.dbHandle.usernames = append(p.dbHandle.usernames, user.Username)
 	sort.Strings(p.dbHandle.usernames)
+	p.addObjectChange(change)
 	return nil
 }
 
-func (p *MemoryProvider) updateUser(user *User) error { //nolint:gocyclo
+func (p *MemoryProvider) updateUser(user *User, change *ObjectChange) error { //nolint:gocyclo
 	err := ValidateUser(user)
 	if err != nil {
 		return err
@@ -479,16 +608,18 @@ func (p *MemoryProvider) updateUser(user *User) error { //nolint:gocyclo
 	user.LastLogin = u.LastLogin
 	user.FirstDownload = u.FirstDownload
 	user.FirstUpload = u.FirstUpload
+	user.UsedLogins = u.UsedLogins
 	user.CreatedAt = u.CreatedAt
 	user.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
 	user.ID = u.ID
 	// pre-login and external auth hook will use the passed *user so save a copy
 	p.dbHandle.users[user.Username] = user.getACopy()
 	setLastUserUpdate()
+	p.addObjectChange(change)
 	return nil
 }
 
-func (p *MemoryProvider) deleteUser(user User, _ bool) error {
+func (p *MemoryProvider) deleteUser(user User, _ bool, change *ObjectChange) error {
 	p.dbHandle.Lock()
 	defer p.dbHandle.Unlock()
 	if p.dbHandle.isClosed {
@@ -514,6 +645,7 @@ func (p *MemoryProvider) deleteUser(user User, _ bool) error {
 	sort.Strings(p.dbHandle.usernames)
 	p.deleteAPIKeysWithUser(user.Username)
 	p.deleteSharesWithUser(user.Username)
+	p.addObjectChange(change)
 	return nil
 }
 
@@ -634,7 +766,7 @@ func (p *MemoryProvider) getUsersForQuotaCheck(toFetch map[string]bool) ([]User,
 	return users, nil
 }
 
-func (p *MemoryProvider) getUsers(limit int, offset int, order, role string) ([]User, error) {
+func (p *MemoryProvider) getUsers(limit int, offset int, order, role, usernamePrefix string) ([]User, error) {
 	users := make([]User, 0, limit)
 	var err error
 	p.dbHandle.Lock()
@@ -648,6 +780,9 @@ func (p *MemoryProvider) getUsers(limit int, offset int, order, role string) ([]
 	itNum := 0
 	if order == OrderASC {
 		for _, username := range p.dbHandle.usernames {
+			if !strings.HasPrefix(username, usernamePrefix) {
+				continue
+			}
 			itNum++
 			if itNum <= offset {
 				continue
@@ -666,11 +801,14 @@ func (p *MemoryProvider) getUsers(limit int, offset int, order, role string) ([]
 		}
 	} else {
 		for i := len(p.dbHandle.usernames) - 1; i >= 0; i-- {
+			username := p.dbHandle.usernames[i]
+			if !strings.HasPrefix(username, usernamePrefix) {
+				continue
+			}
 			itNum++
 			if itNum <= offset {
 				continue
 			}
-			username := p.dbHandle.usernames[i]
 			u := p.dbHandle.users[username]
 			user := u.getACopy()
 			if !user.hasRole(role) {
@@ -965,6 +1103,25 @@ func (p *MemoryProvider) updateFolderQuota(name string, filesAdd int, sizeAdd in
 	return nil
 }
 
+func (p *MemoryProvider) updateFolderQuotaScan(name string, scan vfs.FolderQuotaScan) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	folder, err := p.folderExistsInternal(name)
+	if err != nil {
+		providerLog(logger.LevelError, "unable to update quota scan for folder %q error: %v", name, err)
+		return err
+	}
+	folder.UsedQuotaSize = scan.ScannedSize
+	folder.UsedQuotaFiles = scan.ScannedFiles
+	folder.LastQuotaUpdate = scan.LastScan
+	folder.QuotaScan = &scan
+	p.dbHandle.vfolders[name] = folder
+	return nil
+}
+
 func (p *MemoryProvider) getGroups(limit, offset int, order string, _ bool) ([]Group, error) {
 	p.dbHandle.Lock()
 	defer p.dbHandle.Unlock()
@@ -1060,7 +1217,7 @@ func (p *MemoryProvider) groupExists(name string) (Group, error) {
 	return group, nil
 }
 
-func (p *MemoryProvider) addGroup(group *Group) error {
+func (p *MemoryProvider) addGroup(group *Group, change *ObjectChange) error {
 	if err := group.validate(); err != nil {
 		return err
 	}
@@ -1099,10 +1256,11 @@ func (p *MemoryProvider) addGroup(group *Group) error {
 	p.dbHandle.groups[group.Name] = group.getACopy()
 	p.dbHandle.groupnames = append(p.dbHandle.groupnames, group.Name)
 	sort.Strings(p.dbHandle.groupnames)
+	p.addObjectChange(change)
 	return nil
 }
 
-func (p *MemoryProvider) updateGroup(group *Group) error {
+func (p *MemoryProvider) updateGroup(group *Group, change *ObjectChange) error {
 	if err := group.validate(); err != nil {
 		return err
 	}
@@ -1139,10 +1297,11 @@ func (p *MemoryProvider) updateGroup(group *Group) error {
 	group.Users = g.Users
 	group.Admins = g.Admins
 	p.dbHandle.groups[group.Name] = group.getACopy()
+	p.addObjectChange(change)
 	return nil
 }
 
-func (p *MemoryProvider) deleteGroup(group Group) error {
+func (p *MemoryProvider) deleteGroup(group Group, change *ObjectChange) error {
 	p.dbHandle.Lock()
 	defer p.dbHandle.Unlock()
 	if p.dbHandle.isClosed {
@@ -1168,6 +1327,7 @@ func (p *MemoryProvider) deleteGroup(group Group) error {
 		p.dbHandle.groupnames = append(p.dbHandle.groupnames, name)
 	}
 	sort.Strings(p.dbHandle.groupnames)
+	p.addObjectChange(change)
 	return nil
 }
 
@@ -1532,7 +1692,7 @@ func (p *MemoryProvider) getFolderByName(name string) (vfs.BaseVirtualFolder, er
 	return folder.GetACopy(), nil
 }
 
-func (p *MemoryProvider) addFolder(folder *vfs.BaseVirtualFolder) error {
+func (p *MemoryProvider) addFolder(folder *vfs.BaseVirtualFolder, change *ObjectChange) error {
 	err := ValidateFolder(folder)
 	if err != nil {
 		return err
@@ -1557,10 +1717,11 @@ func (p *MemoryProvider) addFolder(folder *vfs.BaseVirtualFolder) error {
 	p.dbHandle.vfolders[folder.Name] = folder.GetACopy()
 	p.dbHandle.vfoldersNames = append(p.dbHandle.vfoldersNames, folder.Name)
 	sort.Strings(p.dbHandle.vfoldersNames)
+	p.addObjectChange(change)
 	return nil
 }
 
-func (p *MemoryProvider) updateFolder(folder *vfs.BaseVirtualFolder) error {
+func (p *MemoryProvider) updateFolder(folder *vfs.BaseVirtualFolder, change *ObjectChange) error {
 	err := ValidateFolder(folder)
 	if err != nil {
 		return err
@@ -1579,6 +1740,7 @@ func (p *MemoryProvider) updateFolder(folder *vfs.BaseVirtualFolder) error {
 	folder.LastQuotaUpdate = f.LastQuotaUpdate
 	folder.UsedQuotaFiles = f.UsedQuotaFiles
 	folder.UsedQuotaSize = f.UsedQuotaSize
+	folder.QuotaScan = f.QuotaScan
 	folder.Users = f.Users
 	folder.Groups = f.Groups
 	p.dbHandle.vfolders[folder.Name] = folder.GetACopy()
@@ -1598,10 +1760,11 @@ func (p *MemoryProvider) updateFolder(folder *vfs.BaseVirtualFolder) error {
 			p.dbHandle.users[user.Username] = user
 		}
 	}
+	p.addObjectChange(change)
 	return nil
 }
 
-func (p *MemoryProvider) deleteFolder(f vfs.BaseVirtualFolder) error {
+func (p *MemoryProvider) deleteFolder(f vfs.BaseVirtualFolder, change *ObjectChange) error {
 	p.dbHandle.Lock()
 	defer p.dbHandle.Unlock()
 	if p.dbHandle.isClosed {
@@ -1646,6 +1809,7 @@ func (p *MemoryProvider) deleteFolder(f vfs.BaseVirtualFolder) error {
 		p.dbHandle.vfoldersNames = append(p.dbHandle.vfoldersNames, name)
 	}
 	sort.Strings(p.dbHandle.vfoldersNames)
+	p.addObjectChange(change)
 	return nil
 }
 
@@ -2093,6 +2257,10 @@ func (p *MemoryProvider) cleanupDefender(_ int64) error {
 	return ErrNotImplemented
 }
 
+func (p *MemoryProvider) importDefenderHosts(_ []DefenderEntry, _ bool) error {
+	return ErrNotImplemented
+}
+
 func (p *MemoryProvider) addActiveTransfer(_ ActiveTransfer) error {
 	return ErrNotImplemented
 }
@@ -2125,6 +2293,10 @@ func (p *MemoryProvider) getSharedSession(_ string, _ SessionType) (Session, err
 	return Session{}, ErrNotImplemented
 }
 
+func (p *MemoryProvider) replaceSharedSession(_ Session, _ []byte) (bool, error) {
+	return false, ErrNotImplemented
+}
+
 func (p *MemoryProvider) cleanupSharedSessions(_ SessionType, _ int64) error {
 	return ErrNotImplemented
 }
@@ -2489,11 +2661,15 @@ func (*MemoryProvider) addTask(_ string) error {
 	return ErrNotImplemented
 }
 
-func (*MemoryProvider) updateTask(_ string, _ int64) error {
+func (*MemoryProvider) updateTask(_ string, _, _ int64) error {
+	return ErrNotImplemented
+}
+
+func (*MemoryProvider) updateTaskTimestamp(_ string, _ int64) error {
 	return ErrNotImplemented
 }
 
-func (*MemoryProvider) updateTaskTimestamp(_ string) error {
+func (*MemoryProvider) deleteTask(_ string) error {
 	return ErrNotImplemented
 }
 
@@ -2672,6 +2848,142 @@ func (p *MemoryProvider) dumpRoles() ([]Role, error) {
 	return roles, nil
 }
 
+func (p *MemoryProvider) userTemplateExistsInternal(name string) (UserTemplate, error) {
+	if val, ok := p.dbHandle.userTemplates[name]; ok {
+		return val.getACopy(), nil
+	}
+	return UserTemplate{}, util.NewRecordNotFoundError(fmt.Sprintf("user template %q does not exist", name))
+}
+
+func (p *MemoryProvider) userTemplateExists(name string) (UserTemplate, error) {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return UserTemplate{}, errMemoryProviderClosed
+	}
+	return p.userTemplateExistsInternal(name)
+}
+
+func (p *MemoryProvider) addUserTemplate(template *UserTemplate) error {
+	if err := template.validate(); err != nil {
+		return err
+	}
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+
+	_, err := p.userTemplateExistsInternal(template.Name)
+	if err == nil {
+		return util.NewI18nError(
+			fmt.Errorf("%w: user template %q already exists", ErrDuplicatedKey, template.Name),
+			util.I18nErrorDuplicatedName,
+		)
+	}
+	template.ID = p.getNextUserTemplateID()
+	template.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+	template.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+	p.dbHandle.userTemplates[template.Name] = template.getACopy()
+	p.dbHandle.userTemplateNames = append(p.dbHandle.userTemplateNames, template.Name)
+	sort.Strings(p.dbHandle.userTemplateNames)
+	return nil
+}
+
+func (p *MemoryProvider) updateUserTemplate(template *UserTemplate) error {
+	if err := template.validate(); err != nil {
+		return err
+	}
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	oldTemplate, err := p.userTemplateExistsInternal(template.Name)
+	if err != nil {
+		return err
+	}
+	template.ID = oldTemplate.ID
+	template.CreatedAt = oldTemplate.CreatedAt
+	template.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+	p.dbHandle.userTemplates[template.Name] = template.getACopy()
+	return nil
+}
+
+func (p *MemoryProvider) deleteUserTemplate(template UserTemplate) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	if _, err := p.userTemplateExistsInternal(template.Name); err != nil {
+		return err
+	}
+	delete(p.dbHandle.userTemplates, template.Name)
+	p.dbHandle.userTemplateNames = make([]string, 0, len(p.dbHandle.userTemplates))
+	for name := range p.dbHandle.userTemplates {
+		p.dbHandle.userTemplateNames = append(p.dbHandle.userTemplateNames, name)
+	}
+	sort.Strings(p.dbHandle.userTemplateNames)
+	return nil
+}
+
+func (p *MemoryProvider) getUserTemplates(limit int, offset int, order string) ([]UserTemplate, error) {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+
+	if p.dbHandle.isClosed {
+		return nil, errMemoryProviderClosed
+	}
+	if limit <= 0 {
+		return nil, nil
+	}
+	templates := make([]UserTemplate, 0, 10)
+	itNum := 0
+	if order == OrderASC {
+		for _, name := range p.dbHandle.userTemplateNames {
+			itNum++
+			if itNum <= offset {
+				continue
+			}
+			t := p.dbHandle.userTemplates[name]
+			templates = append(templates, t.getACopy())
+			if len(templates) >= limit {
+				break
+			}
+		}
+	} else {
+		for i := len(p.dbHandle.userTemplateNames) - 1; i >= 0; i-- {
+			itNum++
+			if itNum <= offset {
+				continue
+			}
+			name := p.dbHandle.userTemplateNames[i]
+			t := p.dbHandle.userTemplates[name]
+			templates = append(templates, t.getACopy())
+			if len(templates) >= limit {
+				break
+			}
+		}
+	}
+	return templates, nil
+}
+
+func (p *MemoryProvider) dumpUserTemplates() ([]UserTemplate, error) {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return nil, errMemoryProviderClosed
+	}
+
+	templates := make([]UserTemplate, 0, len(p.dbHandle.userTemplates))
+	for _, name := range p.dbHandle.userTemplateNames {
+		t := p.dbHandle.userTemplates[name]
+		templates = append(templates, t.getACopy())
+	}
+	return templates, nil
+}
+
 func (p *MemoryProvider) ipListEntryExists(ipOrNet string, listType IPListType) (IPListEntry, error) {
 	p.dbHandle.Lock()
 	defer p.dbHandle.Unlock()
@@ -2889,6 +3201,96 @@ func (p *MemoryProvider) setConfigs(configs *Configs) error {
 	return nil
 }
 
+func (p *MemoryProvider) addLoginEvents(events []LoginEvent) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	for _, e := range events {
+		p.dbHandle.lastLoginEventID++
+		e.ID = p.dbHandle.lastLoginEventID
+		p.dbHandle.loginEvents = append(p.dbHandle.loginEvents, e)
+	}
+	return nil
+}
+
+func (p *MemoryProvider) getLoginEvents(filter LoginEventFilter) ([]LoginEvent, error) {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return nil, errMemoryProviderClosed
+	}
+	events := make([]LoginEvent, 0, filter.Limit)
+	numEvents := len(p.dbHandle.loginEvents)
+	for idx := range numEvents {
+		e := p.dbHandle.loginEvents[idx]
+		if filter.Order == OrderDESC {
+			e = p.dbHandle.loginEvents[numEvents-1-idx]
+		}
+		if filter.match(&e) {
+			events = append(events, e)
+			if len(events) >= filter.Limit {
+				break
+			}
+		}
+	}
+	return events, nil
+}
+
+func (p *MemoryProvider) cleanupLoginEvents(before int64) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	p.dbHandle.loginEvents = slices.DeleteFunc(p.dbHandle.loginEvents, func(e LoginEvent) bool {
+		return e.Timestamp < before
+	})
+	return nil
+}
+
+// addObjectChange adds the given object change, if any, the lock must be held by the caller
+func (p *MemoryProvider) addObjectChange(change *ObjectChange) {
+	if change == nil {
+		return
+	}
+	p.dbHandle.lastObjectChangeID++
+	change.ID = p.dbHandle.lastObjectChangeID
+	p.dbHandle.objectChanges = append(p.dbHandle.objectChanges, *change)
+}
+
+func (p *MemoryProvider) getObjectChanges(sinceID int64, limit int) ([]ObjectChange, error) {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return nil, errMemoryProviderClosed
+	}
+	changes := make([]ObjectChange, 0, limit)
+	for _, c := range p.dbHandle.objectChanges {
+		if c.ID <= sinceID {
+			continue
+		}
+		changes = append(changes, c)
+		if len(changes) >= limit {
+			break
+		}
+	}
+	return changes, nil
+}
+
+func (p *MemoryProvider) cleanupObjectChanges(before int64) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	p.dbHandle.objectChanges = slices.DeleteFunc(p.dbHandle.objectChanges, func(c ObjectChange) bool {
+		return c.Timestamp < before
+	})
+	return nil
+}
+
 func (p *MemoryProvider) setFirstDownloadTimestamp(username string) error {
 	p.dbHandle.Lock()
 	defer p.dbHandle.Unlock()
@@ -2997,6 +3399,16 @@ func (p *MemoryProvider) getNextRoleID() int64 {
 	return nextID
 }
 
+func (p *MemoryProvider) getNextUserTemplateID() int64 {
+	nextID := int64(1)
+	for _, t := range p.dbHandle.userTemplates {
+		if t.ID >= nextID {
+			nextID = t.ID + 1
+		}
+	}
+	return nextID
+}
+
 func (p *MemoryProvider) clear() {
 	p.dbHandle.Lock()
 	defer p.dbHandle.Unlock()
@@ -3019,9 +3431,14 @@ func (p *MemoryProvider) clear() {
 	p.dbHandle.rulesNames = []string{}
 	p.dbHandle.roles = map[string]Role{}
 	p.dbHandle.roleNames = []string{}
+	p.dbHandle.userTemplates = map[string]UserTemplate{}
+	p.dbHandle.userTemplateNames = []string{}
 	p.dbHandle.ipListEntries = map[string]IPListEntry{}
 	p.dbHandle.ipListEntriesKeys = []string{}
 	p.dbHandle.configs = Configs{}
+	p.dbHandle.loginEvents = nil
+	p.dbHandle.objectChanges = nil
+	p.dbHandle.transferWindows = nil
 }
 
 func (p *MemoryProvider) reloadConfig() error {
@@ -3105,6 +3522,10 @@ func (p *MemoryProvider) restoreDump(dump *BackupData) error {
 		return err
 	}
 
+	if err := p.restoreUserTemplates(dump); err != nil {
+		return err
+	}
+
 	providerLog(logger.LevelDebug, "config loaded from file: %q", p.dbHandle.configFile)
 	return nil
 }
@@ -3280,6 +3701,29 @@ func (p *MemoryProvider) restoreRoles(dump *BackupData) error {
 	return nil
 }
 
+func (p *MemoryProvider) restoreUserTemplates(dump *BackupData) error {
+	for idx := range dump.UserTemplates {
+		template := dump.UserTemplates[idx]
+		template.Name = config.convertName(template.Name)
+		t, err := p.userTemplateExists(template.Name)
+		if err == nil {
+			template.ID = t.ID
+			err = UpdateUserTemplate(&template, ActionExecutorSystem, "", "")
+			if err != nil {
+				providerLog(logger.LevelError, "error updating user template %q: %v", template.Name, err)
+				return err
+			}
+		} else {
+			err = AddUserTemplate(&template, ActionExecutorSystem, "", "")
+			if err != nil {
+				providerLog(logger.LevelError, "error adding user template %q: %v", template.Name, err)
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 func (p *MemoryProvider) restoreGroups(dump *BackupData) error {
 	for idx := range dump.Groups {
 		group := dump.Groups[idx]
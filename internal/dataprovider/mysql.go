@@ -60,6 +60,11 @@ const (
 		"DROP TABLE IF EXISTS `{{roles}}` CASCADE;" +
 		"DROP TABLE IF EXISTS `{{ip_lists}}` CASCADE;" +
 		"DROP TABLE IF EXISTS `{{configs}}` CASCADE;" +
+		"DROP TABLE IF EXISTS `{{login_events}}` CASCADE;" +
+		"DROP TABLE IF EXISTS `{{transfer_windows}}` CASCADE;" +
+		"DROP TABLE IF EXISTS `{{user_templates}}` CASCADE;" +
+		"DROP TABLE IF EXISTS `{{object_changes}}` CASCADE;" +
+		"DROP TABLE IF EXISTS `{{object_changes_lock}}` CASCADE;" +
 		"DROP TABLE IF EXISTS `{{schema_version}}` CASCADE;"
 	mysqlInitialSQL = "CREATE TABLE `{{schema_version}}` (`id` integer AUTO_INCREMENT NOT NULL PRIMARY KEY, `version` integer NOT NULL);" +
 		"CREATE TABLE `{{admins}}` (`id` integer AUTO_INCREMENT NOT NULL PRIMARY KEY, `username` varchar(255) NOT NULL UNIQUE, " +
@@ -205,6 +210,53 @@ const (
 		"`data` longtext NOT NULL, `type` integer NOT NULL, `timestamp` bigint NOT NULL);" +
 		"CREATE INDEX `{{prefix}}shared_sessions_type_idx` ON `{{shared_sessions}}` (`type`);" +
 		"CREATE INDEX `{{prefix}}shared_sessions_timestamp_idx` ON `{{shared_sessions}}` (`timestamp`);"
+	mysqlV33SQL = "CREATE TABLE `{{login_events}}` (`id` bigint AUTO_INCREMENT NOT NULL PRIMARY KEY, " +
+		"`timestamp` bigint NOT NULL, `username` varchar(255) NOT NULL, `ip` varchar(50) NOT NULL, " +
+		"`protocol` varchar(30) NOT NULL, `login_method` varchar(64) NOT NULL, `client_version` varchar(255) NULL, " +
+		"`status` integer NOT NULL, `error` varchar(1024) NULL);" +
+		"CREATE INDEX `{{prefix}}login_events_timestamp_idx` ON `{{login_events}}` (`timestamp`);" +
+		"CREATE INDEX `{{prefix}}login_events_username_idx` ON `{{login_events}}` (`username`);" +
+		"CREATE INDEX `{{prefix}}login_events_ip_idx` ON `{{login_events}}` (`ip`);"
+	mysqlV33DownSQL = "DROP TABLE IF EXISTS `{{login_events}}` CASCADE;"
+	mysqlV34SQL     = "CREATE TABLE `{{transfer_windows}}` (`username` varchar(255) NOT NULL PRIMARY KEY, " +
+		"`window_start` bigint NOT NULL, `window_end` bigint NOT NULL, `used_size` bigint NOT NULL, " +
+		"`notified` integer NOT NULL);" +
+		"CREATE INDEX `{{prefix}}transfer_windows_window_end_idx` ON `{{transfer_windows}}` (`window_end`);"
+	mysqlV34DownSQL = "DROP TABLE IF EXISTS `{{transfer_windows}}` CASCADE;"
+	mysqlV35SQL     = "ALTER TABLE `{{folders}}` ADD COLUMN `retention` longtext NULL;"
+	mysqlV35DownSQL = "ALTER TABLE `{{folders}}` DROP COLUMN `retention`;"
+	mysqlV36SQL     = "ALTER TABLE `{{users_folders_mapping}}` ADD COLUMN `permissions` longtext NULL;" +
+		"ALTER TABLE `{{groups_folders_mapping}}` ADD COLUMN `permissions` longtext NULL;"
+	mysqlV36DownSQL = "ALTER TABLE `{{groups_folders_mapping}}` DROP COLUMN `permissions`;" +
+		"ALTER TABLE `{{users_folders_mapping}}` DROP COLUMN `permissions`;"
+	mysqlV37SQL     = "ALTER TABLE `{{folders}}` ADD COLUMN `quota_scan` longtext NULL;"
+	mysqlV37DownSQL = "ALTER TABLE `{{folders}}` DROP COLUMN `quota_scan`;"
+	mysqlV38SQL     = "ALTER TABLE `{{folders}}` ADD COLUMN `max_upload_file_size` bigint DEFAULT 0 NOT NULL;"
+	mysqlV38DownSQL = "ALTER TABLE `{{folders}}` DROP COLUMN `max_upload_file_size`;"
+	mysqlV39SQL     = "ALTER TABLE `{{folders}}` ADD COLUMN `extraction` longtext NULL;"
+	mysqlV39DownSQL = "ALTER TABLE `{{folders}}` DROP COLUMN `extraction`;"
+	mysqlV40SQL     = "ALTER TABLE `{{folders}}` ADD COLUMN `transform` longtext NULL;"
+	mysqlV40DownSQL = "ALTER TABLE `{{folders}}` DROP COLUMN `transform`;"
+	mysqlV41SQL     = "ALTER TABLE `{{folders}}` ADD COLUMN `setstat_mode` integer DEFAULT 0 NOT NULL;"
+	mysqlV41DownSQL = "ALTER TABLE `{{folders}}` DROP COLUMN `setstat_mode`;"
+	mysqlV42SQL     = "ALTER TABLE `{{users}}` ADD COLUMN `used_logins` integer DEFAULT 0 NOT NULL;"
+	mysqlV42DownSQL = "ALTER TABLE `{{users}}` DROP COLUMN `used_logins`;"
+	mysqlV43SQL     = "CREATE TABLE `{{user_templates}}` (`id` integer AUTO_INCREMENT NOT NULL PRIMARY KEY, " +
+		"`name` varchar(255) NOT NULL UNIQUE, `description` varchar(512) NULL, `user_settings` longtext NOT NULL, " +
+		"`created_at` bigint NOT NULL, `updated_at` bigint NOT NULL);"
+	mysqlV43DownSQL = "DROP TABLE IF EXISTS `{{user_templates}}` CASCADE;"
+	mysqlV44SQL     = "CREATE TABLE `{{object_changes}}` (`id` bigint AUTO_INCREMENT NOT NULL PRIMARY KEY, " +
+		"`timestamp` bigint NOT NULL, `object_type` varchar(50) NOT NULL, `object_name` varchar(255) NOT NULL, " +
+		"`action` varchar(20) NOT NULL, `actor` varchar(255) NOT NULL);" +
+		"CREATE INDEX `{{prefix}}object_changes_timestamp_idx` ON `{{object_changes}}` (`timestamp`);"
+	mysqlV44DownSQL = "DROP TABLE IF EXISTS `{{object_changes}}` CASCADE;"
+	mysqlV45SQL     = "ALTER TABLE `{{api_keys}}` ADD COLUMN `permissions` longtext NULL;" +
+		"ALTER TABLE `{{api_keys}}` ADD COLUMN `username_prefix` varchar(255) NULL;"
+	mysqlV45DownSQL = "ALTER TABLE `{{api_keys}}` DROP COLUMN `username_prefix`;" +
+		"ALTER TABLE `{{api_keys}}` DROP COLUMN `permissions`;"
+	mysqlV46SQL = "CREATE TABLE `{{object_changes_lock}}` (`id` integer NOT NULL PRIMARY KEY, `counter` bigint NOT NULL);" +
+		"INSERT INTO `{{object_changes_lock}}` (`id`, `counter`) VALUES (1, 0);"
+	mysqlV46DownSQL = "DROP TABLE IF EXISTS `{{object_changes_lock}}` CASCADE;"
 )
 
 // MySQLProvider defines the auth provider for MySQL/MariaDB database
@@ -352,6 +404,14 @@ func (p *MySQLProvider) updateLastLogin(username string) error {
 	return sqlCommonUpdateLastLogin(username, p.dbHandle)
 }
 
+func (p *MySQLProvider) consumeUserLogin(username string, maxLogins int) error {
+	return sqlCommonConsumeUserLogin(username, maxLogins, p.dbHandle)
+}
+
+func (p *MySQLProvider) updatePublicKeyLastUse(username, key string) error {
+	return sqlCommonUpdatePublicKeyLastUse(username, key, p.dbHandle)
+}
+
 func (p *MySQLProvider) updateAdminLastLogin(username string) error {
 	return sqlCommonUpdateAdminLastLogin(username, p.dbHandle)
 }
@@ -360,16 +420,16 @@ func (p *MySQLProvider) userExists(username, role string) (User, error) {
 	return sqlCommonGetUserByUsername(username, role, p.dbHandle)
 }
 
-func (p *MySQLProvider) addUser(user *User) error {
-	return p.normalizeError(sqlCommonAddUser(user, p.dbHandle), fieldUsername)
+func (p *MySQLProvider) addUser(user *User, change *ObjectChange) error {
+	return p.normalizeError(sqlCommonAddUser(user, change, p.dbHandle), fieldUsername)
 }
 
-func (p *MySQLProvider) updateUser(user *User) error {
-	return p.normalizeError(sqlCommonUpdateUser(user, p.dbHandle), -1)
+func (p *MySQLProvider) updateUser(user *User, change *ObjectChange) error {
+	return p.normalizeError(sqlCommonUpdateUser(user, change, p.dbHandle), -1)
 }
 
-func (p *MySQLProvider) deleteUser(user User, softDelete bool) error {
-	return sqlCommonDeleteUser(user, softDelete, p.dbHandle)
+func (p *MySQLProvider) deleteUser(user User, softDelete bool, change *ObjectChange) error {
+	return sqlCommonDeleteUser(user, softDelete, change, p.dbHandle)
 }
 
 func (p *MySQLProvider) updateUserPassword(username, password string) error {
@@ -384,8 +444,14 @@ func (p *MySQLProvider) getRecentlyUpdatedUsers(after int64) ([]User, error) {
 	return sqlCommonGetRecentlyUpdatedUsers(after, p.dbHandle)
 }
 
-func (p *MySQLProvider) getUsers(limit int, offset int, order, role string) ([]User, error) {
-	return sqlCommonGetUsers(limit, offset, order, role, p.dbHandle)
+func (p *MySQLProvider) getUsers(limit int, offset int, order, role, usernamePrefix string) ([]User, error) {
+	var users []User
+	err := executeOnReadReplica(replicaClassListings, p.dbHandle, func(dbHandle *sql.DB) error {
+		var err error
+		users, err = sqlCommonGetUsers(limit, offset, order, role, usernamePrefix, dbHandle)
+		return err
+	})
+	return users, err
 }
 
 func (p *MySQLProvider) getUsersForQuotaCheck(toFetch map[string]bool) ([]User, error) {
@@ -397,7 +463,13 @@ func (p *MySQLProvider) dumpFolders() ([]vfs.BaseVirtualFolder, error) {
 }
 
 func (p *MySQLProvider) getFolders(limit, offset int, order string, minimal bool) ([]vfs.BaseVirtualFolder, error) {
-	return sqlCommonGetFolders(limit, offset, order, minimal, p.dbHandle)
+	var folders []vfs.BaseVirtualFolder
+	err := executeOnReadReplica(replicaClassListings, p.dbHandle, func(dbHandle *sql.DB) error {
+		var err error
+		folders, err = sqlCommonGetFolders(limit, offset, order, minimal, dbHandle)
+		return err
+	})
+	return folders, err
 }
 
 func (p *MySQLProvider) getFolderByName(name string) (vfs.BaseVirtualFolder, error) {
@@ -406,28 +478,38 @@ func (p *MySQLProvider) getFolderByName(name string) (vfs.BaseVirtualFolder, err
 	return sqlCommonGetFolderByName(ctx, name, p.dbHandle)
 }
 
-func (p *MySQLProvider) addFolder(folder *vfs.BaseVirtualFolder) error {
-	return p.normalizeError(sqlCommonAddFolder(folder, p.dbHandle), fieldName)
+func (p *MySQLProvider) addFolder(folder *vfs.BaseVirtualFolder, change *ObjectChange) error {
+	return p.normalizeError(sqlCommonAddFolder(folder, change, p.dbHandle), fieldName)
 }
 
-func (p *MySQLProvider) updateFolder(folder *vfs.BaseVirtualFolder) error {
-	return sqlCommonUpdateFolder(folder, p.dbHandle)
+func (p *MySQLProvider) updateFolder(folder *vfs.BaseVirtualFolder, change *ObjectChange) error {
+	return sqlCommonUpdateFolder(folder, change, p.dbHandle)
 }
 
-func (p *MySQLProvider) deleteFolder(folder vfs.BaseVirtualFolder) error {
-	return sqlCommonDeleteFolder(folder, p.dbHandle)
+func (p *MySQLProvider) deleteFolder(folder vfs.BaseVirtualFolder, change *ObjectChange) error {
+	return sqlCommonDeleteFolder(folder, change, p.dbHandle)
 }
 
 func (p *MySQLProvider) updateFolderQuota(name string, filesAdd int, sizeAdd int64, reset bool) error {
 	return sqlCommonUpdateFolderQuota(name, filesAdd, sizeAdd, reset, p.dbHandle)
 }
 
+func (p *MySQLProvider) updateFolderQuotaScan(name string, scan vfs.FolderQuotaScan) error {
+	return sqlCommonUpdateFolderQuotaScan(name, scan, p.dbHandle)
+}
+
 func (p *MySQLProvider) getUsedFolderQuota(name string) (int, int64, error) {
 	return sqlCommonGetFolderUsedQuota(name, p.dbHandle)
 }
 
 func (p *MySQLProvider) getGroups(limit, offset int, order string, minimal bool) ([]Group, error) {
-	return sqlCommonGetGroups(limit, offset, order, minimal, p.dbHandle)
+	var groups []Group
+	err := executeOnReadReplica(replicaClassListings, p.dbHandle, func(dbHandle *sql.DB) error {
+		var err error
+		groups, err = sqlCommonGetGroups(limit, offset, order, minimal, dbHandle)
+		return err
+	})
+	return groups, err
 }
 
 func (p *MySQLProvider) getGroupsWithNames(names []string) ([]Group, error) {
@@ -442,16 +524,16 @@ func (p *MySQLProvider) groupExists(name string) (Group, error) {
 	return sqlCommonGetGroupByName(name, p.dbHandle)
 }
 
-func (p *MySQLProvider) addGroup(group *Group) error {
-	return p.normalizeError(sqlCommonAddGroup(group, p.dbHandle), fieldName)
+func (p *MySQLProvider) addGroup(group *Group, change *ObjectChange) error {
+	return p.normalizeError(sqlCommonAddGroup(group, change, p.dbHandle), fieldName)
 }
 
-func (p *MySQLProvider) updateGroup(group *Group) error {
-	return sqlCommonUpdateGroup(group, p.dbHandle)
+func (p *MySQLProvider) updateGroup(group *Group, change *ObjectChange) error {
+	return sqlCommonUpdateGroup(group, change, p.dbHandle)
 }
 
-func (p *MySQLProvider) deleteGroup(group Group) error {
-	return sqlCommonDeleteGroup(group, p.dbHandle)
+func (p *MySQLProvider) deleteGroup(group Group, change *ObjectChange) error {
+	return sqlCommonDeleteGroup(group, change, p.dbHandle)
 }
 
 func (p *MySQLProvider) dumpGroups() ([]Group, error) {
@@ -574,6 +656,10 @@ func (p *MySQLProvider) cleanupDefender(from int64) error {
 	return sqlCommonDefenderCleanup(from, p.dbHandle)
 }
 
+func (p *MySQLProvider) importDefenderHosts(hosts []DefenderEntry, replace bool) error {
+	return sqlCommonImportDefenderHosts(hosts, replace, p.dbHandle)
+}
+
 func (p *MySQLProvider) addActiveTransfer(transfer ActiveTransfer) error {
 	return sqlCommonAddActiveTransfer(transfer, p.dbHandle)
 }
@@ -606,6 +692,10 @@ func (p *MySQLProvider) getSharedSession(key string, sessionType SessionType) (S
 	return sqlCommonGetSession(key, sessionType, p.dbHandle)
 }
 
+func (p *MySQLProvider) replaceSharedSession(session Session, previousData []byte) (bool, error) {
+	return sqlCommonReplaceSession(session, previousData, p.dbHandle)
+}
+
 func (p *MySQLProvider) cleanupSharedSessions(sessionType SessionType, before int64) error {
 	return sqlCommonCleanupSessions(sessionType, before, p.dbHandle)
 }
@@ -670,12 +760,16 @@ func (p *MySQLProvider) addTask(name string) error {
 	return sqlCommonAddTask(name, p.dbHandle)
 }
 
-func (p *MySQLProvider) updateTask(name string, version int64) error {
-	return sqlCommonUpdateTask(name, version, p.dbHandle)
+func (p *MySQLProvider) updateTask(name string, version, updatedAt int64) error {
+	return sqlCommonUpdateTask(name, version, updatedAt, p.dbHandle)
+}
+
+func (p *MySQLProvider) updateTaskTimestamp(name string, updatedAt int64) error {
+	return sqlCommonUpdateTaskTimestamp(name, updatedAt, p.dbHandle)
 }
 
-func (p *MySQLProvider) updateTaskTimestamp(name string) error {
-	return sqlCommonUpdateTaskTimestamp(name, p.dbHandle)
+func (p *MySQLProvider) deleteTask(name string) error {
+	return sqlCommonDeleteTask(name, p.dbHandle)
 }
 
 func (p *MySQLProvider) addNode() error {
@@ -722,6 +816,30 @@ func (p *MySQLProvider) dumpRoles() ([]Role, error) {
 	return sqlCommonDumpRoles(p.dbHandle)
 }
 
+func (p *MySQLProvider) userTemplateExists(name string) (UserTemplate, error) {
+	return sqlCommonGetUserTemplateByName(name, p.dbHandle)
+}
+
+func (p *MySQLProvider) addUserTemplate(template *UserTemplate) error {
+	return p.normalizeError(sqlCommonAddUserTemplate(template, p.dbHandle), fieldName)
+}
+
+func (p *MySQLProvider) updateUserTemplate(template *UserTemplate) error {
+	return sqlCommonUpdateUserTemplate(template, p.dbHandle)
+}
+
+func (p *MySQLProvider) deleteUserTemplate(template UserTemplate) error {
+	return sqlCommonDeleteUserTemplate(template, p.dbHandle)
+}
+
+func (p *MySQLProvider) getUserTemplates(limit int, offset int, order string) ([]UserTemplate, error) {
+	return sqlCommonGetUserTemplates(limit, offset, order, p.dbHandle)
+}
+
+func (p *MySQLProvider) dumpUserTemplates() ([]UserTemplate, error) {
+	return sqlCommonDumpUserTemplates(p.dbHandle)
+}
+
 func (p *MySQLProvider) ipListEntryExists(ipOrNet string, listType IPListType) (IPListEntry, error) {
 	return sqlCommonGetIPListEntry(ipOrNet, listType, p.dbHandle)
 }
@@ -766,6 +884,46 @@ func (p *MySQLProvider) setConfigs(configs *Configs) error {
 	return sqlCommonSetConfigs(configs, p.dbHandle)
 }
 
+func (p *MySQLProvider) updateTransferWindowUsage(username string, start, end, size int64) error {
+	return sqlCommonUpdateTransferWindowUsage(username, start, end, size, p.dbHandle)
+}
+
+func (p *MySQLProvider) getTransferWindowUsage(username string) (transferWindowUsage, error) {
+	return sqlCommonGetTransferWindowUsage(username, p.dbHandle)
+}
+
+func (p *MySQLProvider) setTransferWindowNotified(username string, start int64, threshold int) (bool, error) {
+	return sqlCommonSetTransferWindowNotified(username, start, threshold, p.dbHandle)
+}
+
+func (p *MySQLProvider) cleanupTransferWindowUsages(before int64) error {
+	return sqlCommonCleanupTransferWindowUsages(before, p.dbHandle)
+}
+
+func (p *MySQLProvider) deleteTransferWindowUsage(username string) error {
+	return sqlCommonDeleteTransferWindowUsage(username, p.dbHandle)
+}
+
+func (p *MySQLProvider) addLoginEvents(events []LoginEvent) error {
+	return sqlCommonAddLoginEvents(events, p.dbHandle)
+}
+
+func (p *MySQLProvider) getLoginEvents(filter LoginEventFilter) ([]LoginEvent, error) {
+	return sqlCommonGetLoginEvents(filter, p.dbHandle)
+}
+
+func (p *MySQLProvider) cleanupLoginEvents(before int64) error {
+	return sqlCommonCleanupLoginEvents(before, p.dbHandle)
+}
+
+func (p *MySQLProvider) getObjectChanges(sinceID int64, limit int) ([]ObjectChange, error) {
+	return sqlCommonGetObjectChanges(sinceID, limit, p.dbHandle)
+}
+
+func (p *MySQLProvider) cleanupObjectChanges(before int64) error {
+	return sqlCommonCleanupObjectChanges(before, p.dbHandle)
+}
+
 func (p *MySQLProvider) setFirstDownloadTimestamp(username string) error {
 	return sqlCommonSetFirstDownloadTimestamp(username, p.dbHandle)
 }
@@ -819,6 +977,34 @@ func (p *MySQLProvider) migrateDatabase() error {
 		return updateMySQLDatabaseFromV30(p.dbHandle)
 	case version == 31:
 		return updateMySQLDatabaseFromV31(p.dbHandle)
+	case version == 32:
+		return updateMySQLDatabaseFromV32(p.dbHandle)
+	case version == 33:
+		return updateMySQLDatabaseFromV33(p.dbHandle)
+	case version == 34:
+		return updateMySQLDatabaseFromV34(p.dbHandle)
+	case version == 35:
+		return updateMySQLDatabaseFromV35(p.dbHandle)
+	case version == 36:
+		return updateMySQLDatabaseFromV36(p.dbHandle)
+	case version == 37:
+		return updateMySQLDatabaseFromV37(p.dbHandle)
+	case version == 38:
+		return updateMySQLDatabaseFromV38(p.dbHandle)
+	case version == 39:
+		return updateMySQLDatabaseFromV39(p.dbHandle)
+	case version == 40:
+		return updateMySQLDatabaseFromV40(p.dbHandle)
+	case version == 41:
+		return updateMySQLDatabaseFromV41(p.dbHandle)
+	case version == 42:
+		return updateMySQLDatabaseFromV42(p.dbHandle)
+	case version == 43:
+		return updateMySQLDatabaseFromV43(p.dbHandle)
+	case version == 44:
+		return updateMySQLDatabaseFromV44(p.dbHandle)
+	case version == 45:
+		return updateMySQLDatabaseFromV45(p.dbHandle)
 	default:
 		if version > sqlDatabaseVersion {
 			providerLog(logger.LevelError, "database schema version %d is newer than the supported one: %d", version,
@@ -847,6 +1033,34 @@ func (p *MySQLProvider) revertDatabase(targetVersion int) error {
 		return downgradeMySQLDatabaseFromV31(p.dbHandle)
 	case 32:
 		return downgradeMySQLDatabaseFromV32(p.dbHandle)
+	case 33:
+		return downgradeMySQLDatabaseFromV33(p.dbHandle)
+	case 34:
+		return downgradeMySQLDatabaseFromV34(p.dbHandle)
+	case 35:
+		return downgradeMySQLDatabaseFromV35(p.dbHandle)
+	case 36:
+		return downgradeMySQLDatabaseFromV36(p.dbHandle)
+	case 37:
+		return downgradeMySQLDatabaseFromV37(p.dbHandle)
+	case 38:
+		return downgradeMySQLDatabaseFromV38(p.dbHandle)
+	case 39:
+		return downgradeMySQLDatabaseFromV39(p.dbHandle)
+	case 40:
+		return downgradeMySQLDatabaseFromV40(p.dbHandle)
+	case 41:
+		return downgradeMySQLDatabaseFromV41(p.dbHandle)
+	case 42:
+		return downgradeMySQLDatabaseFromV42(p.dbHandle)
+	case 43:
+		return downgradeMySQLDatabaseFromV43(p.dbHandle)
+	case 44:
+		return downgradeMySQLDatabaseFromV44(p.dbHandle)
+	case 45:
+		return downgradeMySQLDatabaseFromV45(p.dbHandle)
+	case 46:
+		return downgradeMySQLDatabaseFromV46(p.dbHandle)
 	default:
 		return fmt.Errorf("database schema version not handled: %d", dbVersion.Version)
 	}
@@ -900,7 +1114,105 @@ func updateMySQLDatabaseFromV30(dbHandle *sql.DB) error {
 }
 
 func updateMySQLDatabaseFromV31(dbHandle *sql.DB) error {
-	return updateSQLDatabaseFrom31To32(dbHandle)
+	if err := updateSQLDatabaseFrom31To32(dbHandle); err != nil {
+		return err
+	}
+	return updateMySQLDatabaseFromV32(dbHandle)
+}
+
+func updateMySQLDatabaseFromV32(dbHandle *sql.DB) error {
+	if err := updateMySQLDatabaseFrom32To33(dbHandle); err != nil {
+		return err
+	}
+	return updateMySQLDatabaseFromV33(dbHandle)
+}
+
+func updateMySQLDatabaseFromV33(dbHandle *sql.DB) error {
+	if err := updateMySQLDatabaseFrom33To34(dbHandle); err != nil {
+		return err
+	}
+	return updateMySQLDatabaseFromV34(dbHandle)
+}
+
+func updateMySQLDatabaseFromV34(dbHandle *sql.DB) error {
+	if err := updateMySQLDatabaseFrom34To35(dbHandle); err != nil {
+		return err
+	}
+	return updateMySQLDatabaseFromV35(dbHandle)
+}
+
+func updateMySQLDatabaseFromV35(dbHandle *sql.DB) error {
+	if err := updateMySQLDatabaseFrom35To36(dbHandle); err != nil {
+		return err
+	}
+	return updateMySQLDatabaseFromV36(dbHandle)
+}
+
+func updateMySQLDatabaseFromV36(dbHandle *sql.DB) error {
+	if err := updateMySQLDatabaseFrom36To37(dbHandle); err != nil {
+		return err
+	}
+	return updateMySQLDatabaseFromV37(dbHandle)
+}
+
+func updateMySQLDatabaseFromV37(dbHandle *sql.DB) error {
+	if err := updateMySQLDatabaseFrom37To38(dbHandle); err != nil {
+		return err
+	}
+	return updateMySQLDatabaseFromV38(dbHandle)
+}
+
+func updateMySQLDatabaseFromV38(dbHandle *sql.DB) error {
+	if err := updateMySQLDatabaseFrom38To39(dbHandle); err != nil {
+		return err
+	}
+	return updateMySQLDatabaseFromV39(dbHandle)
+}
+
+func updateMySQLDatabaseFromV39(dbHandle *sql.DB) error {
+	if err := updateMySQLDatabaseFrom39To40(dbHandle); err != nil {
+		return err
+	}
+	return updateMySQLDatabaseFromV40(dbHandle)
+}
+
+func updateMySQLDatabaseFromV40(dbHandle *sql.DB) error {
+	if err := updateMySQLDatabaseFrom40To41(dbHandle); err != nil {
+		return err
+	}
+	return updateMySQLDatabaseFromV41(dbHandle)
+}
+
+func updateMySQLDatabaseFromV41(dbHandle *sql.DB) error {
+	if err := updateMySQLDatabaseFrom41To42(dbHandle); err != nil {
+		return err
+	}
+	return updateMySQLDatabaseFromV42(dbHandle)
+}
+
+func updateMySQLDatabaseFromV42(dbHandle *sql.DB) error {
+	if err := updateMySQLDatabaseFrom42To43(dbHandle); err != nil {
+		return err
+	}
+	return updateMySQLDatabaseFromV43(dbHandle)
+}
+
+func updateMySQLDatabaseFromV43(dbHandle *sql.DB) error {
+	if err := updateMySQLDatabaseFrom43To44(dbHandle); err != nil {
+		return err
+	}
+	return updateMySQLDatabaseFromV44(dbHandle)
+}
+
+func updateMySQLDatabaseFromV44(dbHandle *sql.DB) error {
+	if err := updateMySQLDatabaseFrom44To45(dbHandle); err != nil {
+		return err
+	}
+	return updateMySQLDatabaseFromV45(dbHandle)
+}
+
+func updateMySQLDatabaseFromV45(dbHandle *sql.DB) error {
+	return updateMySQLDatabaseFrom45To46(dbHandle)
 }
 
 func downgradeMySQLDatabaseFromV30(dbHandle *sql.DB) error {
@@ -921,6 +1233,104 @@ func downgradeMySQLDatabaseFromV32(dbHandle *sql.DB) error {
 	return downgradeMySQLDatabaseFromV31(dbHandle)
 }
 
+func downgradeMySQLDatabaseFromV33(dbHandle *sql.DB) error {
+	if err := downgradeMySQLDatabaseFrom33To32(dbHandle); err != nil {
+		return err
+	}
+	return downgradeMySQLDatabaseFromV32(dbHandle)
+}
+
+func downgradeMySQLDatabaseFromV34(dbHandle *sql.DB) error {
+	if err := downgradeMySQLDatabaseFrom34To33(dbHandle); err != nil {
+		return err
+	}
+	return downgradeMySQLDatabaseFromV33(dbHandle)
+}
+
+func downgradeMySQLDatabaseFromV35(dbHandle *sql.DB) error {
+	if err := downgradeMySQLDatabaseFrom35To34(dbHandle); err != nil {
+		return err
+	}
+	return downgradeMySQLDatabaseFromV34(dbHandle)
+}
+
+func downgradeMySQLDatabaseFromV36(dbHandle *sql.DB) error {
+	if err := downgradeMySQLDatabaseFrom36To35(dbHandle); err != nil {
+		return err
+	}
+	return downgradeMySQLDatabaseFromV35(dbHandle)
+}
+
+func downgradeMySQLDatabaseFromV37(dbHandle *sql.DB) error {
+	if err := downgradeMySQLDatabaseFrom37To36(dbHandle); err != nil {
+		return err
+	}
+	return downgradeMySQLDatabaseFromV36(dbHandle)
+}
+
+func downgradeMySQLDatabaseFromV38(dbHandle *sql.DB) error {
+	if err := downgradeMySQLDatabaseFrom38To37(dbHandle); err != nil {
+		return err
+	}
+	return downgradeMySQLDatabaseFromV37(dbHandle)
+}
+
+func downgradeMySQLDatabaseFromV39(dbHandle *sql.DB) error {
+	if err := downgradeMySQLDatabaseFrom39To38(dbHandle); err != nil {
+		return err
+	}
+	return downgradeMySQLDatabaseFromV38(dbHandle)
+}
+
+func downgradeMySQLDatabaseFromV40(dbHandle *sql.DB) error {
+	if err := downgradeMySQLDatabaseFrom40To39(dbHandle); err != nil {
+		return err
+	}
+	return downgradeMySQLDatabaseFromV39(dbHandle)
+}
+
+func downgradeMySQLDatabaseFromV41(dbHandle *sql.DB) error {
+	if err := downgradeMySQLDatabaseFrom41To40(dbHandle); err != nil {
+		return err
+	}
+	return downgradeMySQLDatabaseFromV40(dbHandle)
+}
+
+func downgradeMySQLDatabaseFromV42(dbHandle *sql.DB) error {
+	if err := downgradeMySQLDatabaseFrom42To41(dbHandle); err != nil {
+		return err
+	}
+	return downgradeMySQLDatabaseFromV41(dbHandle)
+}
+
+func downgradeMySQLDatabaseFromV43(dbHandle *sql.DB) error {
+	if err := downgradeMySQLDatabaseFrom43To42(dbHandle); err != nil {
+		return err
+	}
+	return downgradeMySQLDatabaseFromV42(dbHandle)
+}
+
+func downgradeMySQLDatabaseFromV44(dbHandle *sql.DB) error {
+	if err := downgradeMySQLDatabaseFrom44To43(dbHandle); err != nil {
+		return err
+	}
+	return downgradeMySQLDatabaseFromV43(dbHandle)
+}
+
+func downgradeMySQLDatabaseFromV45(dbHandle *sql.DB) error {
+	if err := downgradeMySQLDatabaseFrom45To44(dbHandle); err != nil {
+		return err
+	}
+	return downgradeMySQLDatabaseFromV44(dbHandle)
+}
+
+func downgradeMySQLDatabaseFromV46(dbHandle *sql.DB) error {
+	if err := downgradeMySQLDatabaseFrom46To45(dbHandle); err != nil {
+		return err
+	}
+	return downgradeMySQLDatabaseFromV45(dbHandle)
+}
+
 func updateMySQLDatabaseFrom29To30(dbHandle *sql.DB) error {
 	logger.InfoToConsole("updating database schema version: 29 -> 30")
 	providerLog(logger.LevelInfo, "updating database schema version: 29 -> 30")
@@ -954,3 +1364,232 @@ func downgradeMySQLDatabaseFrom31To30(dbHandle *sql.DB) error {
 	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
 	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 30, false)
 }
+
+func updateMySQLDatabaseFrom32To33(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 32 -> 33")
+	providerLog(logger.LevelInfo, "updating database schema version: 32 -> 33")
+
+	sql := strings.ReplaceAll(mysqlV33SQL, "{{login_events}}", sqlTableLoginEvents)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 33, true)
+}
+
+func downgradeMySQLDatabaseFrom33To32(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 33 -> 32")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 33 -> 32")
+
+	sql := strings.ReplaceAll(mysqlV33DownSQL, "{{login_events}}", sqlTableLoginEvents)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 32, false)
+}
+
+func updateMySQLDatabaseFrom33To34(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 33 -> 34")
+	providerLog(logger.LevelInfo, "updating database schema version: 33 -> 34")
+
+	sql := strings.ReplaceAll(mysqlV34SQL, "{{transfer_windows}}", sqlTableTransferWindows)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 34, true)
+}
+
+func downgradeMySQLDatabaseFrom34To33(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 34 -> 33")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 34 -> 33")
+
+	sql := strings.ReplaceAll(mysqlV34DownSQL, "{{transfer_windows}}", sqlTableTransferWindows)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 33, false)
+}
+
+func updateMySQLDatabaseFrom34To35(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 34 -> 35")
+	providerLog(logger.LevelInfo, "updating database schema version: 34 -> 35")
+
+	sql := strings.ReplaceAll(mysqlV35SQL, "{{folders}}", sqlTableFolders)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 35, true)
+}
+
+func downgradeMySQLDatabaseFrom35To34(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 35 -> 34")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 35 -> 34")
+
+	sql := strings.ReplaceAll(mysqlV35DownSQL, "{{folders}}", sqlTableFolders)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 34, false)
+}
+
+func updateMySQLDatabaseFrom35To36(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 35 -> 36")
+	providerLog(logger.LevelInfo, "updating database schema version: 35 -> 36")
+
+	sql := strings.ReplaceAll(mysqlV36SQL, "{{users_folders_mapping}}", sqlTableUsersFoldersMapping)
+	sql = strings.ReplaceAll(sql, "{{groups_folders_mapping}}", sqlTableGroupsFoldersMapping)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 36, true)
+}
+
+func downgradeMySQLDatabaseFrom36To35(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 36 -> 35")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 36 -> 35")
+
+	sql := strings.ReplaceAll(mysqlV36DownSQL, "{{users_folders_mapping}}", sqlTableUsersFoldersMapping)
+	sql = strings.ReplaceAll(sql, "{{groups_folders_mapping}}", sqlTableGroupsFoldersMapping)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 35, false)
+}
+
+func updateMySQLDatabaseFrom36To37(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 36 -> 37")
+	providerLog(logger.LevelInfo, "updating database schema version: 36 -> 37")
+
+	sql := strings.ReplaceAll(mysqlV37SQL, "{{folders}}", sqlTableFolders)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 37, true)
+}
+
+func downgradeMySQLDatabaseFrom37To36(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 37 -> 36")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 37 -> 36")
+
+	sql := strings.ReplaceAll(mysqlV37DownSQL, "{{folders}}", sqlTableFolders)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 36, false)
+}
+
+func updateMySQLDatabaseFrom37To38(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 37 -> 38")
+	providerLog(logger.LevelInfo, "updating database schema version: 37 -> 38")
+
+	sql := strings.ReplaceAll(mysqlV38SQL, "{{folders}}", sqlTableFolders)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 38, true)
+}
+
+func downgradeMySQLDatabaseFrom38To37(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 38 -> 37")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 38 -> 37")
+
+	sql := strings.ReplaceAll(mysqlV38DownSQL, "{{folders}}", sqlTableFolders)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 37, false)
+}
+
+func updateMySQLDatabaseFrom38To39(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 38 -> 39")
+	providerLog(logger.LevelInfo, "updating database schema version: 38 -> 39")
+
+	sql := strings.ReplaceAll(mysqlV39SQL, "{{folders}}", sqlTableFolders)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 39, true)
+}
+
+func downgradeMySQLDatabaseFrom39To38(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 39 -> 38")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 39 -> 38")
+
+	sql := strings.ReplaceAll(mysqlV39DownSQL, "{{folders}}", sqlTableFolders)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 38, false)
+}
+
+func updateMySQLDatabaseFrom39To40(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 39 -> 40")
+	providerLog(logger.LevelInfo, "updating database schema version: 39 -> 40")
+
+	sql := strings.ReplaceAll(mysqlV40SQL, "{{folders}}", sqlTableFolders)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 40, true)
+}
+
+func downgradeMySQLDatabaseFrom40To39(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 40 -> 39")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 40 -> 39")
+
+	sql := strings.ReplaceAll(mysqlV40DownSQL, "{{folders}}", sqlTableFolders)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 39, false)
+}
+
+func updateMySQLDatabaseFrom40To41(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 40 -> 41")
+	providerLog(logger.LevelInfo, "updating database schema version: 40 -> 41")
+
+	sql := strings.ReplaceAll(mysqlV41SQL, "{{folders}}", sqlTableFolders)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 41, true)
+}
+
+func downgradeMySQLDatabaseFrom41To40(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 41 -> 40")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 41 -> 40")
+
+	sql := strings.ReplaceAll(mysqlV41DownSQL, "{{folders}}", sqlTableFolders)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 40, false)
+}
+
+func updateMySQLDatabaseFrom41To42(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 41 -> 42")
+	providerLog(logger.LevelInfo, "updating database schema version: 41 -> 42")
+
+	sql := strings.ReplaceAll(mysqlV42SQL, "{{users}}", sqlTableUsers)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 42, true)
+}
+
+func downgradeMySQLDatabaseFrom42To41(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 42 -> 41")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 42 -> 41")
+
+	sql := strings.ReplaceAll(mysqlV42DownSQL, "{{users}}", sqlTableUsers)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 41, false)
+}
+
+func updateMySQLDatabaseFrom42To43(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 42 -> 43")
+	providerLog(logger.LevelInfo, "updating database schema version: 42 -> 43")
+
+	sql := strings.ReplaceAll(mysqlV43SQL, "{{user_templates}}", sqlTableUserTemplates)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 43, true)
+}
+
+func downgradeMySQLDatabaseFrom43To42(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 43 -> 42")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 43 -> 42")
+
+	sql := strings.ReplaceAll(mysqlV43DownSQL, "{{user_templates}}", sqlTableUserTemplates)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 42, false)
+}
+
+func updateMySQLDatabaseFrom43To44(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 43 -> 44")
+	providerLog(logger.LevelInfo, "updating database schema version: 43 -> 44")
+
+	sql := strings.ReplaceAll(mysqlV44SQL, "{{object_changes}}", sqlTableObjectChanges)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 44, true)
+}
+
+func downgradeMySQLDatabaseFrom44To43(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 44 -> 43")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 44 -> 43")
+
+	sql := strings.ReplaceAll(mysqlV44DownSQL, "{{object_changes}}", sqlTableObjectChanges)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 43, false)
+}
+
+func updateMySQLDatabaseFrom44To45(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 44 -> 45")
+	providerLog(logger.LevelInfo, "updating database schema version: 44 -> 45")
+
+	sql := strings.ReplaceAll(mysqlV45SQL, "{{api_keys}}", sqlTableAPIKeys)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 45, true)
+}
+
+func downgradeMySQLDatabaseFrom45To44(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 45 -> 44")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 45 -> 44")
+
+	sql := strings.ReplaceAll(mysqlV45DownSQL, "{{api_keys}}", sqlTableAPIKeys)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 44, false)
+}
+
+func updateMySQLDatabaseFrom45To46(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 45 -> 46")
+	providerLog(logger.LevelInfo, "updating database schema version: 45 -> 46")
+
+	sql := strings.ReplaceAll(mysqlV46SQL, "{{object_changes_lock}}", sqlTableObjectChangesLock)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 46, true)
+}
+
+func downgradeMySQLDatabaseFrom46To45(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 46 -> 45")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 46 -> 45")
+
+	sql := strings.ReplaceAll(mysqlV46DownSQL, "{{object_changes_lock}}", sqlTableObjectChangesLock)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 45, false)
+}
@@ -0,0 +1,142 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	objectChangesMaxLimit = 1000
+)
+
+var objectChangeSubscribers = &objectChangeNotifier{}
+
+// ChangeFeedConfig defines the configuration for the feed of the changes to users,
+// groups and virtual folders
+type ChangeFeedConfig struct {
+	// Set to true to record the users, groups and folders changes in the data provider
+	Enabled bool `json:"enabled" mapstructure:"enabled"`
+	// Changes older than the configured number of hours are automatically removed.
+	// 0 means no automatic cleanup
+	RetentionHours int `json:"retention_hours" mapstructure:"retention_hours"`
+}
+
+func (c *ChangeFeedConfig) validate() error {
+	if c.RetentionHours < 0 {
+		return util.NewValidationError("change feed retention cannot be negative")
+	}
+	return nil
+}
+
+// ObjectChange defines a recorded change to a provider object
+type ObjectChange struct {
+	// Sequence identifier, monotonically increasing
+	ID int64 `json:"id"`
+	// unix timestamp in milliseconds
+	Timestamp  int64  `json:"timestamp"`
+	ObjectType string `json:"object_type"`
+	ObjectName string `json:"object_name"`
+	// add, update or delete
+	Action string `json:"action"`
+	// the admin, the API key with machine scope, or the special executors for system
+	// and self changes, that made the change
+	Actor string `json:"actor"`
+}
+
+// newObjectChange returns the change to store together with a mutation,
+// nil if the change feed is disabled
+func newObjectChange(action, objectType, objectName, actor string) *ObjectChange {
+	if !config.ChangeFeed.Enabled {
+		return nil
+	}
+	return &ObjectChange{
+		Timestamp:  util.GetTimeAsMsSinceEpoch(time.Now()),
+		ObjectType: objectType,
+		ObjectName: truncateString(objectName, 255),
+		Action:     action,
+		Actor:      truncateString(actor, 255),
+	}
+}
+
+// FnObjectChange defines the callback to execute after a change is stored
+type FnObjectChange func(change ObjectChange)
+
+type objectChangeNotifier struct {
+	sync.RWMutex
+	subscribers []FnObjectChange
+}
+
+func (n *objectChangeNotifier) add(fn FnObjectChange) {
+	n.Lock()
+	defer n.Unlock()
+
+	n.subscribers = append(n.subscribers, fn)
+}
+
+func (n *objectChangeNotifier) notify(change *ObjectChange) {
+	if change == nil {
+		return
+	}
+	n.RLock()
+	subscribers := n.subscribers
+	n.RUnlock()
+
+	for _, fn := range subscribers {
+		fn(*change)
+	}
+}
+
+// AddObjectChangeSubscriber registers a callback executed after each stored change.
+// The callbacks are executed synchronously in the order they are added so they must
+// not block, they are never executed if the change feed is disabled
+func AddObjectChangeSubscriber(fn FnObjectChange) {
+	objectChangeSubscribers.add(fn)
+}
+
+// GetObjectChanges returns up to limit changes with a sequence identifier greater
+// than sinceID, ordered by sequence identifier.
+// The changes are committed in sequence order, so once a change is returned no
+// change with a lower sequence identifier can be added later and readers can
+// safely resume from the last returned identifier. Gaps are possible, for
+// example for rolled back transactions
+func GetObjectChanges(sinceID int64, limit int) ([]ObjectChange, error) {
+	if !config.ChangeFeed.Enabled {
+		return nil, util.NewMethodDisabledError("change feed is disabled")
+	}
+	if sinceID < 0 {
+		return nil, util.NewValidationError("the sequence identifier cannot be negative")
+	}
+	if limit <= 0 || limit > objectChangesMaxLimit {
+		limit = objectChangesMaxLimit
+	}
+	return provider.getObjectChanges(sinceID, limit)
+}
+
+func cleanupObjectChanges() {
+	if config.ChangeFeed.RetentionHours <= 0 {
+		return
+	}
+	before := time.Now().Add(-time.Duration(config.ChangeFeed.RetentionHours) * time.Hour)
+	if err := provider.cleanupObjectChanges(util.GetTimeAsMsSinceEpoch(before)); err != nil {
+		providerLog(logger.LevelError, "unable to cleanup object changes: %v", err)
+		return
+	}
+	providerLog(logger.LevelDebug, "object changes older than %s removed", before)
+}
@@ -0,0 +1,199 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	// minimum length for the SHA-1 prefixes in the breached passwords file,
+	// it matches the range length used by the Have I Been Pwned API
+	minBreachedPrefixLength = 5
+)
+
+var (
+	// ErrPasswordExpired is returned for password logins if the user password is expired
+	ErrPasswordExpired = errors.New("login not allowed, password expired")
+	breachedPasswords  atomic.Pointer[breachedPasswordsList]
+)
+
+// PasswordPolicy defines the policy for the protocol users passwords. The policy
+// is enforced when a password is set or changed, already stored passwords are
+// not checked
+type PasswordPolicy struct {
+	// Minimum number of characters. 0 means no minimum length
+	MinLength int `json:"min_length" mapstructure:"min_length"`
+	// Require at least an uppercase letter
+	RequireUppercase bool `json:"require_uppercase" mapstructure:"require_uppercase"`
+	// Require at least a lowercase letter
+	RequireLowercase bool `json:"require_lowercase" mapstructure:"require_lowercase"`
+	// Require at least a digit
+	RequireDigit bool `json:"require_digit" mapstructure:"require_digit"`
+	// Require at least a character that is not a letter or a digit
+	RequireSpecial bool `json:"require_special" mapstructure:"require_special"`
+	// Path to a file with hex encoded SHA-1 hashes, or hash prefixes, of
+	// breached passwords, one per line. Lines in the "HASH:count" format
+	// used by Have I Been Pwned are accepted. A password is rejected if
+	// its SHA-1 hash starts with any of the listed prefixes.
+	// Relative paths are resolved against the configuration directory
+	BreachedPasswordsFile string `json:"breached_passwords_file" mapstructure:"breached_passwords_file"`
+	// Number of days after which the passwords expire. 0 means no expiration.
+	// The password expiration set for users and groups takes precedence
+	MaxAge int `json:"max_age" mapstructure:"max_age"`
+}
+
+func (p *PasswordPolicy) initialize(configDir string) error {
+	if p.MinLength < 0 {
+		return fmt.Errorf("invalid password policy min length: %d", p.MinLength)
+	}
+	if p.MaxAge < 0 {
+		return fmt.Errorf("invalid password policy max age: %d", p.MaxAge)
+	}
+	if p.BreachedPasswordsFile == "" {
+		breachedPasswords.Store(nil)
+		return nil
+	}
+	filePath := getConfigPath(p.BreachedPasswordsFile, configDir)
+	list, err := loadBreachedPasswords(filePath)
+	if err != nil {
+		return err
+	}
+	breachedPasswords.Store(list)
+	providerLog(logger.LevelInfo, "breached passwords file %q loaded, entries: %d", filePath, len(list.prefixes))
+	return nil
+}
+
+// validate returns a validation error listing each rule violated by the
+// specified plain text password
+func (p *PasswordPolicy) validate(password string) error {
+	var issues []util.FieldError
+	addIssue := func(message, code string) {
+		issues = append(issues, util.FieldError{
+			Field:   "password",
+			Message: message,
+			Code:    code,
+		})
+	}
+
+	if p.MinLength > 0 && utf8.RuneCountInString(password) < p.MinLength {
+		addIssue(fmt.Sprintf("must be at least %d characters long", p.MinLength), util.ErrorCodePasswordTooShort)
+	}
+	var hasUpper, hasLower, hasDigit, hasSpecial bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case !unicode.IsLetter(r):
+			hasSpecial = true
+		}
+	}
+	if p.RequireUppercase && !hasUpper {
+		addIssue("must contain at least an uppercase letter", util.ErrorCodePasswordNoUppercase)
+	}
+	if p.RequireLowercase && !hasLower {
+		addIssue("must contain at least a lowercase letter", util.ErrorCodePasswordNoLowercase)
+	}
+	if p.RequireDigit && !hasDigit {
+		addIssue("must contain at least a digit", util.ErrorCodePasswordNoDigit)
+	}
+	if p.RequireSpecial && !hasSpecial {
+		addIssue("must contain at least a special character", util.ErrorCodePasswordNoSpecial)
+	}
+	if list := breachedPasswords.Load(); list != nil && list.contains(password) {
+		addIssue("is a known breached password", util.ErrorCodePasswordBreached)
+	}
+	if len(issues) == 0 {
+		return nil
+	}
+	err := util.NewValidationError("the password does not comply with the password policy")
+	err.Append(issues...)
+	return util.NewI18nError(err, util.I18nErrorPasswordComplexity)
+}
+
+// breachedPasswordsList defines the SHA-1 prefixes of the breached passwords
+type breachedPasswordsList struct {
+	prefixes map[string]struct{}
+	// the distinct prefix lengths, so a lookup requires a map access for each length
+	lengths []int
+}
+
+func (l *breachedPasswordsList) contains(password string) bool {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	for _, length := range l.lengths {
+		if _, ok := l.prefixes[hash[:length]]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func loadBreachedPasswords(filePath string) (*breachedPasswordsList, error) {
+	if filePath == "" || !util.IsFileInputValid(filePath) {
+		return nil, fmt.Errorf("invalid breached passwords file %q", filePath)
+	}
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open breached passwords file %q: %w", filePath, err)
+	}
+	defer f.Close()
+
+	list := &breachedPasswordsList{
+		prefixes: make(map[string]struct{}),
+	}
+	scanner := bufio.NewScanner(f)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		prefix, _, _ := strings.Cut(line, ":")
+		prefix = strings.ToUpper(strings.TrimSpace(prefix))
+		if len(prefix) < minBreachedPrefixLength || len(prefix) > sha1.Size*2 {
+			return nil, fmt.Errorf("invalid SHA-1 prefix length at line %d of file %q", lineNumber, filePath)
+		}
+		if _, err := hex.DecodeString(prefix + strings.Repeat("0", len(prefix)%2)); err != nil {
+			return nil, fmt.Errorf("invalid SHA-1 prefix at line %d of file %q", lineNumber, filePath)
+		}
+		list.prefixes[prefix] = struct{}{}
+		if !slices.Contains(list.lengths, len(prefix)) {
+			list.lengths = append(list.lengths, len(prefix))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read breached passwords file %q: %w", filePath, err)
+	}
+	return list, nil
+}
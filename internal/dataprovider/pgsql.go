@@ -62,6 +62,11 @@ DROP TABLE IF EXISTS "{{nodes}}" CASCADE;
 DROP TABLE IF EXISTS "{{roles}}" CASCADE;
 DROP TABLE IF EXISTS "{{ip_lists}}" CASCADE;
 DROP TABLE IF EXISTS "{{configs}}" CASCADE;
+DROP TABLE IF EXISTS "{{login_events}}" CASCADE;
+DROP TABLE IF EXISTS "{{transfer_windows}}" CASCADE;
+DROP TABLE IF EXISTS "{{user_templates}}" CASCADE;
+DROP TABLE IF EXISTS "{{object_changes}}" CASCADE;
+DROP TABLE IF EXISTS "{{object_changes_lock}}" CASCADE;
 DROP TABLE IF EXISTS "{{schema_version}}" CASCADE;
 `
 	pgsqlInitial = `CREATE TABLE "{{schema_version}}" ("id" integer NOT NULL PRIMARY KEY GENERATED ALWAYS AS IDENTITY, "version" integer NOT NULL);
@@ -222,6 +227,55 @@ CREATE TABLE "{{shared_sessions}}" ("key" varchar(128) NOT NULL PRIMARY KEY,
 "data" text NOT NULL, "type" integer NOT NULL, "timestamp" bigint NOT NULL);
 CREATE INDEX "{{prefix}}shared_sessions_type_idx" ON "{{shared_sessions}}" ("type");
 CREATE INDEX "{{prefix}}shared_sessions_timestamp_idx" ON "{{shared_sessions}}" ("timestamp");`
+	pgsqlV33SQL = `CREATE TABLE "{{login_events}}" ("id" bigint NOT NULL PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+"timestamp" bigint NOT NULL, "username" varchar(255) NOT NULL, "ip" varchar(50) NOT NULL,
+"protocol" varchar(30) NOT NULL, "login_method" varchar(64) NOT NULL, "client_version" varchar(255) NULL,
+"status" integer NOT NULL, "error" varchar(1024) NULL);
+CREATE INDEX "{{prefix}}login_events_timestamp_idx" ON "{{login_events}}" ("timestamp");
+CREATE INDEX "{{prefix}}login_events_username_idx" ON "{{login_events}}" ("username");
+CREATE INDEX "{{prefix}}login_events_ip_idx" ON "{{login_events}}" ("ip");
+`
+	pgsqlV33DownSQL = `DROP TABLE "{{login_events}}" CASCADE;`
+	pgsqlV34SQL     = `CREATE TABLE "{{transfer_windows}}" ("username" varchar(255) NOT NULL PRIMARY KEY,
+"window_start" bigint NOT NULL, "window_end" bigint NOT NULL, "used_size" bigint NOT NULL, "notified" integer NOT NULL);
+CREATE INDEX "{{prefix}}transfer_windows_window_end_idx" ON "{{transfer_windows}}" ("window_end");
+`
+	pgsqlV34DownSQL = `DROP TABLE "{{transfer_windows}}" CASCADE;`
+	pgsqlV35SQL     = `ALTER TABLE "{{folders}}" ADD COLUMN "retention" text NULL;`
+	pgsqlV35DownSQL = `ALTER TABLE "{{folders}}" DROP COLUMN "retention" CASCADE;`
+	pgsqlV36SQL     = `ALTER TABLE "{{users_folders_mapping}}" ADD COLUMN "permissions" text NULL;
+ALTER TABLE "{{groups_folders_mapping}}" ADD COLUMN "permissions" text NULL;`
+	pgsqlV36DownSQL = `ALTER TABLE "{{groups_folders_mapping}}" DROP COLUMN "permissions" CASCADE;
+ALTER TABLE "{{users_folders_mapping}}" DROP COLUMN "permissions" CASCADE;`
+	pgsqlV37SQL     = `ALTER TABLE "{{folders}}" ADD COLUMN "quota_scan" text NULL;`
+	pgsqlV37DownSQL = `ALTER TABLE "{{folders}}" DROP COLUMN "quota_scan" CASCADE;`
+	pgsqlV38SQL     = `ALTER TABLE "{{folders}}" ADD COLUMN "max_upload_file_size" bigint DEFAULT 0 NOT NULL;`
+	pgsqlV38DownSQL = `ALTER TABLE "{{folders}}" DROP COLUMN "max_upload_file_size" CASCADE;`
+	pgsqlV39SQL     = `ALTER TABLE "{{folders}}" ADD COLUMN "extraction" text NULL;`
+	pgsqlV39DownSQL = `ALTER TABLE "{{folders}}" DROP COLUMN "extraction" CASCADE;`
+	pgsqlV40SQL     = `ALTER TABLE "{{folders}}" ADD COLUMN "transform" text NULL;`
+	pgsqlV40DownSQL = `ALTER TABLE "{{folders}}" DROP COLUMN "transform" CASCADE;`
+	pgsqlV41SQL     = `ALTER TABLE "{{folders}}" ADD COLUMN "setstat_mode" integer DEFAULT 0 NOT NULL;`
+	pgsqlV41DownSQL = `ALTER TABLE "{{folders}}" DROP COLUMN "setstat_mode" CASCADE;`
+	pgsqlV42SQL     = `ALTER TABLE "{{users}}" ADD COLUMN "used_logins" integer DEFAULT 0 NOT NULL;`
+	pgsqlV42DownSQL = `ALTER TABLE "{{users}}" DROP COLUMN "used_logins" CASCADE;`
+	pgsqlV43SQL     = `CREATE TABLE "{{user_templates}}" ("id" integer NOT NULL PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+"name" varchar(255) NOT NULL UNIQUE, "description" varchar(512) NULL, "user_settings" text NOT NULL,
+"created_at" bigint NOT NULL, "updated_at" bigint NOT NULL);`
+	pgsqlV43DownSQL = `DROP TABLE "{{user_templates}}" CASCADE;`
+	pgsqlV44SQL     = `CREATE TABLE "{{object_changes}}" ("id" bigint NOT NULL PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+"timestamp" bigint NOT NULL, "object_type" varchar(50) NOT NULL, "object_name" varchar(255) NOT NULL,
+"action" varchar(20) NOT NULL, "actor" varchar(255) NOT NULL);
+CREATE INDEX "{{prefix}}object_changes_timestamp_idx" ON "{{object_changes}}" ("timestamp");
+`
+	pgsqlV44DownSQL = `DROP TABLE "{{object_changes}}" CASCADE;`
+	pgsqlV45SQL     = `ALTER TABLE "{{api_keys}}" ADD COLUMN "permissions" text NULL;
+ALTER TABLE "{{api_keys}}" ADD COLUMN "username_prefix" varchar(255) NULL;`
+	pgsqlV45DownSQL = `ALTER TABLE "{{api_keys}}" DROP COLUMN "username_prefix" CASCADE;
+ALTER TABLE "{{api_keys}}" DROP COLUMN "permissions" CASCADE;`
+	pgsqlV46SQL = `CREATE TABLE "{{object_changes_lock}}" ("id" integer NOT NULL PRIMARY KEY, "counter" bigint NOT NULL);
+INSERT INTO "{{object_changes_lock}}" ("id", "counter") VALUES (1, 0);`
+	pgsqlV46DownSQL = `DROP TABLE "{{object_changes_lock}}" CASCADE;`
 )
 
 var (
@@ -371,6 +425,14 @@ func (p *PGSQLProvider) updateLastLogin(username string) error {
 	return sqlCommonUpdateLastLogin(username, p.dbHandle)
 }
 
+func (p *PGSQLProvider) consumeUserLogin(username string, maxLogins int) error {
+	return sqlCommonConsumeUserLogin(username, maxLogins, p.dbHandle)
+}
+
+func (p *PGSQLProvider) updatePublicKeyLastUse(username, key string) error {
+	return sqlCommonUpdatePublicKeyLastUse(username, key, p.dbHandle)
+}
+
 func (p *PGSQLProvider) updateAdminLastLogin(username string) error {
 	return sqlCommonUpdateAdminLastLogin(username, p.dbHandle)
 }
@@ -379,16 +441,16 @@ func (p *PGSQLProvider) userExists(username, role string) (User, error) {
 	return sqlCommonGetUserByUsername(username, role, p.dbHandle)
 }
 
-func (p *PGSQLProvider) addUser(user *User) error {
-	return p.normalizeError(sqlCommonAddUser(user, p.dbHandle), fieldUsername)
+func (p *PGSQLProvider) addUser(user *User, change *ObjectChange) error {
+	return p.normalizeError(sqlCommonAddUser(user, change, p.dbHandle), fieldUsername)
 }
 
-func (p *PGSQLProvider) updateUser(user *User) error {
-	return p.normalizeError(sqlCommonUpdateUser(user, p.dbHandle), -1)
+func (p *PGSQLProvider) updateUser(user *User, change *ObjectChange) error {
+	return p.normalizeError(sqlCommonUpdateUser(user, change, p.dbHandle), -1)
 }
 
-func (p *PGSQLProvider) deleteUser(user User, softDelete bool) error {
-	return sqlCommonDeleteUser(user, softDelete, p.dbHandle)
+func (p *PGSQLProvider) deleteUser(user User, softDelete bool, change *ObjectChange) error {
+	return sqlCommonDeleteUser(user, softDelete, change, p.dbHandle)
 }
 
 func (p *PGSQLProvider) updateUserPassword(username, password string) error {
@@ -403,8 +465,14 @@ func (p *PGSQLProvider) getRecentlyUpdatedUsers(after int64) ([]User, error) {
 	return sqlCommonGetRecentlyUpdatedUsers(after, p.dbHandle)
 }
 
-func (p *PGSQLProvider) getUsers(limit int, offset int, order, role string) ([]User, error) {
-	return sqlCommonGetUsers(limit, offset, order, role, p.dbHandle)
+func (p *PGSQLProvider) getUsers(limit int, offset int, order, role, usernamePrefix string) ([]User, error) {
+	var users []User
+	err := executeOnReadReplica(replicaClassListings, p.dbHandle, func(dbHandle *sql.DB) error {
+		var err error
+		users, err = sqlCommonGetUsers(limit, offset, order, role, usernamePrefix, dbHandle)
+		return err
+	})
+	return users, err
 }
 
 func (p *PGSQLProvider) getUsersForQuotaCheck(toFetch map[string]bool) ([]User, error) {
@@ -416,7 +484,13 @@ func (p *PGSQLProvider) dumpFolders() ([]vfs.BaseVirtualFolder, error) {
 }
 
 func (p *PGSQLProvider) getFolders(limit, offset int, order string, minimal bool) ([]vfs.BaseVirtualFolder, error) {
-	return sqlCommonGetFolders(limit, offset, order, minimal, p.dbHandle)
+	var folders []vfs.BaseVirtualFolder
+	err := executeOnReadReplica(replicaClassListings, p.dbHandle, func(dbHandle *sql.DB) error {
+		var err error
+		folders, err = sqlCommonGetFolders(limit, offset, order, minimal, dbHandle)
+		return err
+	})
+	return folders, err
 }
 
 func (p *PGSQLProvider) getFolderByName(name string) (vfs.BaseVirtualFolder, error) {
@@ -425,28 +499,38 @@ func (p *PGSQLProvider) getFolderByName(name string) (vfs.BaseVirtualFolder, err
 	return sqlCommonGetFolderByName(ctx, name, p.dbHandle)
 }
 
-func (p *PGSQLProvider) addFolder(folder *vfs.BaseVirtualFolder) error {
-	return p.normalizeError(sqlCommonAddFolder(folder, p.dbHandle), fieldName)
+func (p *PGSQLProvider) addFolder(folder *vfs.BaseVirtualFolder, change *ObjectChange) error {
+	return p.normalizeError(sqlCommonAddFolder(folder, change, p.dbHandle), fieldName)
 }
 
-func (p *PGSQLProvider) updateFolder(folder *vfs.BaseVirtualFolder) error {
-	return sqlCommonUpdateFolder(folder, p.dbHandle)
+func (p *PGSQLProvider) updateFolder(folder *vfs.BaseVirtualFolder, change *ObjectChange) error {
+	return sqlCommonUpdateFolder(folder, change, p.dbHandle)
 }
 
-func (p *PGSQLProvider) deleteFolder(folder vfs.BaseVirtualFolder) error {
-	return sqlCommonDeleteFolder(folder, p.dbHandle)
+func (p *PGSQLProvider) deleteFolder(folder vfs.BaseVirtualFolder, change *ObjectChange) error {
+	return sqlCommonDeleteFolder(folder, change, p.dbHandle)
 }
 
 func (p *PGSQLProvider) updateFolderQuota(name string, filesAdd int, sizeAdd int64, reset bool) error {
 	return sqlCommonUpdateFolderQuota(name, filesAdd, sizeAdd, reset, p.dbHandle)
 }
 
+func (p *PGSQLProvider) updateFolderQuotaScan(name string, scan vfs.FolderQuotaScan) error {
+	return sqlCommonUpdateFolderQuotaScan(name, scan, p.dbHandle)
+}
+
 func (p *PGSQLProvider) getUsedFolderQuota(name string) (int, int64, error) {
 	return sqlCommonGetFolderUsedQuota(name, p.dbHandle)
 }
 
 func (p *PGSQLProvider) getGroups(limit, offset int, order string, minimal bool) ([]Group, error) {
-	return sqlCommonGetGroups(limit, offset, order, minimal, p.dbHandle)
+	var groups []Group
+	err := executeOnReadReplica(replicaClassListings, p.dbHandle, func(dbHandle *sql.DB) error {
+		var err error
+		groups, err = sqlCommonGetGroups(limit, offset, order, minimal, dbHandle)
+		return err
+	})
+	return groups, err
 }
 
 func (p *PGSQLProvider) getGroupsWithNames(names []string) ([]Group, error) {
@@ -461,16 +545,16 @@ func (p *PGSQLProvider) groupExists(name string) (Group, error) {
 	return sqlCommonGetGroupByName(name, p.dbHandle)
 }
 
-func (p *PGSQLProvider) addGroup(group *Group) error {
-	return p.normalizeError(sqlCommonAddGroup(group, p.dbHandle), fieldName)
+func (p *PGSQLProvider) addGroup(group *Group, change *ObjectChange) error {
+	return p.normalizeError(sqlCommonAddGroup(group, change, p.dbHandle), fieldName)
 }
 
-func (p *PGSQLProvider) updateGroup(group *Group) error {
-	return sqlCommonUpdateGroup(group, p.dbHandle)
+func (p *PGSQLProvider) updateGroup(group *Group, change *ObjectChange) error {
+	return sqlCommonUpdateGroup(group, change, p.dbHandle)
 }
 
-func (p *PGSQLProvider) deleteGroup(group Group) error {
-	return sqlCommonDeleteGroup(group, p.dbHandle)
+func (p *PGSQLProvider) deleteGroup(group Group, change *ObjectChange) error {
+	return sqlCommonDeleteGroup(group, change, p.dbHandle)
 }
 
 func (p *PGSQLProvider) dumpGroups() ([]Group, error) {
@@ -593,6 +677,10 @@ func (p *PGSQLProvider) cleanupDefender(from int64) error {
 	return sqlCommonDefenderCleanup(from, p.dbHandle)
 }
 
+func (p *PGSQLProvider) importDefenderHosts(hosts []DefenderEntry, replace bool) error {
+	return sqlCommonImportDefenderHosts(hosts, replace, p.dbHandle)
+}
+
 func (p *PGSQLProvider) addActiveTransfer(transfer ActiveTransfer) error {
 	return sqlCommonAddActiveTransfer(transfer, p.dbHandle)
 }
@@ -625,6 +713,10 @@ func (p *PGSQLProvider) getSharedSession(key string, sessionType SessionType) (S
 	return sqlCommonGetSession(key, sessionType, p.dbHandle)
 }
 
+func (p *PGSQLProvider) replaceSharedSession(session Session, previousData []byte) (bool, error) {
+	return sqlCommonReplaceSession(session, previousData, p.dbHandle)
+}
+
 func (p *PGSQLProvider) cleanupSharedSessions(sessionType SessionType, before int64) error {
 	return sqlCommonCleanupSessions(sessionType, before, p.dbHandle)
 }
@@ -689,12 +781,16 @@ func (p *PGSQLProvider) addTask(name string) error {
 	return sqlCommonAddTask(name, p.dbHandle)
 }
 
-func (p *PGSQLProvider) updateTask(name string, version int64) error {
-	return sqlCommonUpdateTask(name, version, p.dbHandle)
+func (p *PGSQLProvider) updateTask(name string, version, updatedAt int64) error {
+	return sqlCommonUpdateTask(name, version, updatedAt, p.dbHandle)
+}
+
+func (p *PGSQLProvider) updateTaskTimestamp(name string, updatedAt int64) error {
+	return sqlCommonUpdateTaskTimestamp(name, updatedAt, p.dbHandle)
 }
 
-func (p *PGSQLProvider) updateTaskTimestamp(name string) error {
-	return sqlCommonUpdateTaskTimestamp(name, p.dbHandle)
+func (p *PGSQLProvider) deleteTask(name string) error {
+	return sqlCommonDeleteTask(name, p.dbHandle)
 }
 
 func (p *PGSQLProvider) addNode() error {
@@ -741,6 +837,30 @@ func (p *PGSQLProvider) dumpRoles() ([]Role, error) {
 	return sqlCommonDumpRoles(p.dbHandle)
 }
 
+func (p *PGSQLProvider) userTemplateExists(name string) (UserTemplate, error) {
+	return sqlCommonGetUserTemplateByName(name, p.dbHandle)
+}
+
+func (p *PGSQLProvider) addUserTemplate(template *UserTemplate) error {
+	return p.normalizeError(sqlCommonAddUserTemplate(template, p.dbHandle), fieldName)
+}
+
+func (p *PGSQLProvider) updateUserTemplate(template *UserTemplate) error {
+	return sqlCommonUpdateUserTemplate(template, p.dbHandle)
+}
+
+func (p *PGSQLProvider) deleteUserTemplate(template UserTemplate) error {
+	return sqlCommonDeleteUserTemplate(template, p.dbHandle)
+}
+
+func (p *PGSQLProvider) getUserTemplates(limit int, offset int, order string) ([]UserTemplate, error) {
+	return sqlCommonGetUserTemplates(limit, offset, order, p.dbHandle)
+}
+
+func (p *PGSQLProvider) dumpUserTemplates() ([]UserTemplate, error) {
+	return sqlCommonDumpUserTemplates(p.dbHandle)
+}
+
 func (p *PGSQLProvider) ipListEntryExists(ipOrNet string, listType IPListType) (IPListEntry, error) {
 	return sqlCommonGetIPListEntry(ipOrNet, listType, p.dbHandle)
 }
@@ -785,6 +905,46 @@ func (p *PGSQLProvider) setConfigs(configs *Configs) error {
 	return sqlCommonSetConfigs(configs, p.dbHandle)
 }
 
+func (p *PGSQLProvider) updateTransferWindowUsage(username string, start, end, size int64) error {
+	return sqlCommonUpdateTransferWindowUsage(username, start, end, size, p.dbHandle)
+}
+
+func (p *PGSQLProvider) getTransferWindowUsage(username string) (transferWindowUsage, error) {
+	return sqlCommonGetTransferWindowUsage(username, p.dbHandle)
+}
+
+func (p *PGSQLProvider) setTransferWindowNotified(username string, start int64, threshold int) (bool, error) {
+	return sqlCommonSetTransferWindowNotified(username, start, threshold, p.dbHandle)
+}
+
+func (p *PGSQLProvider) cleanupTransferWindowUsages(before int64) error {
+	return sqlCommonCleanupTransferWindowUsages(before, p.dbHandle)
+}
+
+func (p *PGSQLProvider) deleteTransferWindowUsage(username string) error {
+	return sqlCommonDeleteTransferWindowUsage(username, p.dbHandle)
+}
+
+func (p *PGSQLProvider) addLoginEvents(events []LoginEvent) error {
+	return sqlCommonAddLoginEvents(events, p.dbHandle)
+}
+
+func (p *PGSQLProvider) getLoginEvents(filter LoginEventFilter) ([]LoginEvent, error) {
+	return sqlCommonGetLoginEvents(filter, p.dbHandle)
+}
+
+func (p *PGSQLProvider) cleanupLoginEvents(before int64) error {
+	return sqlCommonCleanupLoginEvents(before, p.dbHandle)
+}
+
+func (p *PGSQLProvider) getObjectChanges(sinceID int64, limit int) ([]ObjectChange, error) {
+	return sqlCommonGetObjectChanges(sinceID, limit, p.dbHandle)
+}
+
+func (p *PGSQLProvider) cleanupObjectChanges(before int64) error {
+	return sqlCommonCleanupObjectChanges(before, p.dbHandle)
+}
+
 func (p *PGSQLProvider) setFirstDownloadTimestamp(username string) error {
 	return sqlCommonSetFirstDownloadTimestamp(username, p.dbHandle)
 }
@@ -844,6 +1004,34 @@ func (p *PGSQLProvider) migrateDatabase() error { //nolint:dupl
 		return updatePGSQLDatabaseFromV30(p.dbHandle)
 	case version == 31:
 		return updatePGSQLDatabaseFromV31(p.dbHandle)
+	case version == 32:
+		return updatePGSQLDatabaseFromV32(p.dbHandle)
+	case version == 33:
+		return updatePGSQLDatabaseFromV33(p.dbHandle)
+	case version == 34:
+		return updatePGSQLDatabaseFromV34(p.dbHandle)
+	case version == 35:
+		return updatePGSQLDatabaseFromV35(p.dbHandle)
+	case version == 36:
+		return updatePGSQLDatabaseFromV36(p.dbHandle)
+	case version == 37:
+		return updatePGSQLDatabaseFromV37(p.dbHandle)
+	case version == 38:
+		return updatePGSQLDatabaseFromV38(p.dbHandle)
+	case version == 39:
+		return updatePGSQLDatabaseFromV39(p.dbHandle)
+	case version == 40:
+		return updatePGSQLDatabaseFromV40(p.dbHandle)
+	case version == 41:
+		return updatePGSQLDatabaseFromV41(p.dbHandle)
+	case version == 42:
+		return updatePGSQLDatabaseFromV42(p.dbHandle)
+	case version == 43:
+		return updatePGSQLDatabaseFromV43(p.dbHandle)
+	case version == 44:
+		return updatePGSQLDatabaseFromV44(p.dbHandle)
+	case version == 45:
+		return updatePGSQLDatabaseFromV45(p.dbHandle)
 	default:
 		if version > sqlDatabaseVersion {
 			providerLog(logger.LevelError, "database schema version %d is newer than the supported one: %d", version,
@@ -872,6 +1060,34 @@ func (p *PGSQLProvider) revertDatabase(targetVersion int) error {
 		return downgradePGSQLDatabaseFromV31(p.dbHandle)
 	case 32:
 		return downgradePGSQLDatabaseFromV32(p.dbHandle)
+	case 33:
+		return downgradePGSQLDatabaseFromV33(p.dbHandle)
+	case 34:
+		return downgradePGSQLDatabaseFromV34(p.dbHandle)
+	case 35:
+		return downgradePGSQLDatabaseFromV35(p.dbHandle)
+	case 36:
+		return downgradePGSQLDatabaseFromV36(p.dbHandle)
+	case 37:
+		return downgradePGSQLDatabaseFromV37(p.dbHandle)
+	case 38:
+		return downgradePGSQLDatabaseFromV38(p.dbHandle)
+	case 39:
+		return downgradePGSQLDatabaseFromV39(p.dbHandle)
+	case 40:
+		return downgradePGSQLDatabaseFromV40(p.dbHandle)
+	case 41:
+		return downgradePGSQLDatabaseFromV41(p.dbHandle)
+	case 42:
+		return downgradePGSQLDatabaseFromV42(p.dbHandle)
+	case 43:
+		return downgradePGSQLDatabaseFromV43(p.dbHandle)
+	case 44:
+		return downgradePGSQLDatabaseFromV44(p.dbHandle)
+	case 45:
+		return downgradePGSQLDatabaseFromV45(p.dbHandle)
+	case 46:
+		return downgradePGSQLDatabaseFromV46(p.dbHandle)
 	default:
 		return fmt.Errorf("database schema version not handled: %d", dbVersion.Version)
 	}
@@ -925,7 +1141,105 @@ func updatePGSQLDatabaseFromV30(dbHandle *sql.DB) error {
 }
 
 func updatePGSQLDatabaseFromV31(dbHandle *sql.DB) error {
-	return updateSQLDatabaseFrom31To32(dbHandle)
+	if err := updateSQLDatabaseFrom31To32(dbHandle); err != nil {
+		return err
+	}
+	return updatePGSQLDatabaseFromV32(dbHandle)
+}
+
+func updatePGSQLDatabaseFromV32(dbHandle *sql.DB) error {
+	if err := updatePGSQLDatabaseFrom32To33(dbHandle); err != nil {
+		return err
+	}
+	return updatePGSQLDatabaseFromV33(dbHandle)
+}
+
+func updatePGSQLDatabaseFromV33(dbHandle *sql.DB) error {
+	if err := updatePGSQLDatabaseFrom33To34(dbHandle); err != nil {
+		return err
+	}
+	return updatePGSQLDatabaseFromV34(dbHandle)
+}
+
+func updatePGSQLDatabaseFromV34(dbHandle *sql.DB) error {
+	if err := updatePGSQLDatabaseFrom34To35(dbHandle); err != nil {
+		return err
+	}
+	return updatePGSQLDatabaseFromV35(dbHandle)
+}
+
+func updatePGSQLDatabaseFromV35(dbHandle *sql.DB) error {
+	if err := updatePGSQLDatabaseFrom35To36(dbHandle); err != nil {
+		return err
+	}
+	return updatePGSQLDatabaseFromV36(dbHandle)
+}
+
+func updatePGSQLDatabaseFromV36(dbHandle *sql.DB) error {
+	if err := updatePGSQLDatabaseFrom36To37(dbHandle); err != nil {
+		return err
+	}
+	return updatePGSQLDatabaseFromV37(dbHandle)
+}
+
+func updatePGSQLDatabaseFromV37(dbHandle *sql.DB) error {
+	if err := updatePGSQLDatabaseFrom37To38(dbHandle); err != nil {
+		return err
+	}
+	return updatePGSQLDatabaseFromV38(dbHandle)
+}
+
+func updatePGSQLDatabaseFromV38(dbHandle *sql.DB) error {
+	if err := updatePGSQLDatabaseFrom38To39(dbHandle); err != nil {
+		return err
+	}
+	return updatePGSQLDatabaseFromV39(dbHandle)
+}
+
+func updatePGSQLDatabaseFromV39(dbHandle *sql.DB) error {
+	if err := updatePGSQLDatabaseFrom39To40(dbHandle); err != nil {
+		return err
+	}
+	return updatePGSQLDatabaseFromV40(dbHandle)
+}
+
+func updatePGSQLDatabaseFromV40(dbHandle *sql.DB) error {
+	if err := updatePGSQLDatabaseFrom40To41(dbHandle); err != nil {
+		return err
+	}
+	return updatePGSQLDatabaseFromV41(dbHandle)
+}
+
+func updatePGSQLDatabaseFromV41(dbHandle *sql.DB) error {
+	if err := updatePGSQLDatabaseFrom41To42(dbHandle); err != nil {
+		return err
+	}
+	return updatePGSQLDatabaseFromV42(dbHandle)
+}
+
+func updatePGSQLDatabaseFromV42(dbHandle *sql.DB) error {
+	if err := updatePGSQLDatabaseFrom42To43(dbHandle); err != nil {
+		return err
+	}
+	return updatePGSQLDatabaseFromV43(dbHandle)
+}
+
+func updatePGSQLDatabaseFromV43(dbHandle *sql.DB) error {
+	if err := updatePGSQLDatabaseFrom43To44(dbHandle); err != nil {
+		return err
+	}
+	return updatePGSQLDatabaseFromV44(dbHandle)
+}
+
+func updatePGSQLDatabaseFromV44(dbHandle *sql.DB) error {
+	if err := updatePGSQLDatabaseFrom44To45(dbHandle); err != nil {
+		return err
+	}
+	return updatePGSQLDatabaseFromV45(dbHandle)
+}
+
+func updatePGSQLDatabaseFromV45(dbHandle *sql.DB) error {
+	return updatePGSQLDatabaseFrom45To46(dbHandle)
 }
 
 func downgradePGSQLDatabaseFromV30(dbHandle *sql.DB) error {
@@ -946,6 +1260,104 @@ func downgradePGSQLDatabaseFromV32(dbHandle *sql.DB) error {
 	return downgradePGSQLDatabaseFromV31(dbHandle)
 }
 
+func downgradePGSQLDatabaseFromV33(dbHandle *sql.DB) error {
+	if err := downgradePGSQLDatabaseFrom33To32(dbHandle); err != nil {
+		return err
+	}
+	return downgradePGSQLDatabaseFromV32(dbHandle)
+}
+
+func downgradePGSQLDatabaseFromV34(dbHandle *sql.DB) error {
+	if err := downgradePGSQLDatabaseFrom34To33(dbHandle); err != nil {
+		return err
+	}
+	return downgradePGSQLDatabaseFromV33(dbHandle)
+}
+
+func downgradePGSQLDatabaseFromV35(dbHandle *sql.DB) error {
+	if err := downgradePGSQLDatabaseFrom35To34(dbHandle); err != nil {
+		return err
+	}
+	return downgradePGSQLDatabaseFromV34(dbHandle)
+}
+
+func downgradePGSQLDatabaseFromV36(dbHandle *sql.DB) error {
+	if err := downgradePGSQLDatabaseFrom36To35(dbHandle); err != nil {
+		return err
+	}
+	return downgradePGSQLDatabaseFromV35(dbHandle)
+}
+
+func downgradePGSQLDatabaseFromV37(dbHandle *sql.DB) error {
+	if err := downgradePGSQLDatabaseFrom37To36(dbHandle); err != nil {
+		return err
+	}
+	return downgradePGSQLDatabaseFromV36(dbHandle)
+}
+
+func downgradePGSQLDatabaseFromV38(dbHandle *sql.DB) error {
+	if err := downgradePGSQLDatabaseFrom38To37(dbHandle); err != nil {
+		return err
+	}
+	return downgradePGSQLDatabaseFromV37(dbHandle)
+}
+
+func downgradePGSQLDatabaseFromV39(dbHandle *sql.DB) error {
+	if err := downgradePGSQLDatabaseFrom39To38(dbHandle); err != nil {
+		return err
+	}
+	return downgradePGSQLDatabaseFromV38(dbHandle)
+}
+
+func downgradePGSQLDatabaseFromV40(dbHandle *sql.DB) error {
+	if err := downgradePGSQLDatabaseFrom40To39(dbHandle); err != nil {
+		return err
+	}
+	return downgradePGSQLDatabaseFromV39(dbHandle)
+}
+
+func downgradePGSQLDatabaseFromV41(dbHandle *sql.DB) error {
+	if err := downgradePGSQLDatabaseFrom41To40(dbHandle); err != nil {
+		return err
+	}
+	return downgradePGSQLDatabaseFromV40(dbHandle)
+}
+
+func downgradePGSQLDatabaseFromV42(dbHandle *sql.DB) error {
+	if err := downgradePGSQLDatabaseFrom42To41(dbHandle); err != nil {
+		return err
+	}
+	return downgradePGSQLDatabaseFromV41(dbHandle)
+}
+
+func downgradePGSQLDatabaseFromV43(dbHandle *sql.DB) error {
+	if err := downgradePGSQLDatabaseFrom43To42(dbHandle); err != nil {
+		return err
+	}
+	return downgradePGSQLDatabaseFromV42(dbHandle)
+}
+
+func downgradePGSQLDatabaseFromV44(dbHandle *sql.DB) error {
+	if err := downgradePGSQLDatabaseFrom44To43(dbHandle); err != nil {
+		return err
+	}
+	return downgradePGSQLDatabaseFromV43(dbHandle)
+}
+
+func downgradePGSQLDatabaseFromV45(dbHandle *sql.DB) error {
+	if err := downgradePGSQLDatabaseFrom45To44(dbHandle); err != nil {
+		return err
+	}
+	return downgradePGSQLDatabaseFromV44(dbHandle)
+}
+
+func downgradePGSQLDatabaseFromV46(dbHandle *sql.DB) error {
+	if err := downgradePGSQLDatabaseFrom46To45(dbHandle); err != nil {
+		return err
+	}
+	return downgradePGSQLDatabaseFromV45(dbHandle)
+}
+
 func updatePGSQLDatabaseFrom29To30(dbHandle *sql.DB) error {
 	logger.InfoToConsole("updating database schema version: 29 -> 30")
 	providerLog(logger.LevelInfo, "updating database schema version: 29 -> 30")
@@ -979,3 +1391,235 @@ func downgradePGSQLDatabaseFrom31To30(dbHandle *sql.DB) error {
 	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
 	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 30, false)
 }
+
+func updatePGSQLDatabaseFrom32To33(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 32 -> 33")
+	providerLog(logger.LevelInfo, "updating database schema version: 32 -> 33")
+
+	sql := strings.ReplaceAll(pgsqlV33SQL, "{{login_events}}", sqlTableLoginEvents)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	if config.Driver == CockroachDataProviderName {
+		sql = strings.ReplaceAll(sql, "GENERATED ALWAYS AS IDENTITY", "DEFAULT unordered_unique_rowid()")
+	}
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 33, true)
+}
+
+func downgradePGSQLDatabaseFrom33To32(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 33 -> 32")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 33 -> 32")
+
+	sql := strings.ReplaceAll(pgsqlV33DownSQL, "{{login_events}}", sqlTableLoginEvents)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 32, false)
+}
+
+func updatePGSQLDatabaseFrom33To34(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 33 -> 34")
+	providerLog(logger.LevelInfo, "updating database schema version: 33 -> 34")
+
+	sql := strings.ReplaceAll(pgsqlV34SQL, "{{transfer_windows}}", sqlTableTransferWindows)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 34, true)
+}
+
+func downgradePGSQLDatabaseFrom34To33(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 34 -> 33")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 34 -> 33")
+
+	sql := strings.ReplaceAll(pgsqlV34DownSQL, "{{transfer_windows}}", sqlTableTransferWindows)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 33, false)
+}
+
+func updatePGSQLDatabaseFrom34To35(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 34 -> 35")
+	providerLog(logger.LevelInfo, "updating database schema version: 34 -> 35")
+
+	sql := strings.ReplaceAll(pgsqlV35SQL, "{{folders}}", sqlTableFolders)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 35, true)
+}
+
+func downgradePGSQLDatabaseFrom35To34(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 35 -> 34")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 35 -> 34")
+
+	sql := strings.ReplaceAll(pgsqlV35DownSQL, "{{folders}}", sqlTableFolders)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 34, false)
+}
+
+func updatePGSQLDatabaseFrom35To36(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 35 -> 36")
+	providerLog(logger.LevelInfo, "updating database schema version: 35 -> 36")
+
+	sql := strings.ReplaceAll(pgsqlV36SQL, "{{users_folders_mapping}}", sqlTableUsersFoldersMapping)
+	sql = strings.ReplaceAll(sql, "{{groups_folders_mapping}}", sqlTableGroupsFoldersMapping)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 36, true)
+}
+
+func downgradePGSQLDatabaseFrom36To35(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 36 -> 35")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 36 -> 35")
+
+	sql := strings.ReplaceAll(pgsqlV36DownSQL, "{{users_folders_mapping}}", sqlTableUsersFoldersMapping)
+	sql = strings.ReplaceAll(sql, "{{groups_folders_mapping}}", sqlTableGroupsFoldersMapping)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 35, false)
+}
+
+func updatePGSQLDatabaseFrom36To37(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 36 -> 37")
+	providerLog(logger.LevelInfo, "updating database schema version: 36 -> 37")
+
+	sql := strings.ReplaceAll(pgsqlV37SQL, "{{folders}}", sqlTableFolders)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 37, true)
+}
+
+func downgradePGSQLDatabaseFrom37To36(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 37 -> 36")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 37 -> 36")
+
+	sql := strings.ReplaceAll(pgsqlV37DownSQL, "{{folders}}", sqlTableFolders)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 36, false)
+}
+
+func updatePGSQLDatabaseFrom37To38(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 37 -> 38")
+	providerLog(logger.LevelInfo, "updating database schema version: 37 -> 38")
+
+	sql := strings.ReplaceAll(pgsqlV38SQL, "{{folders}}", sqlTableFolders)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 38, true)
+}
+
+func downgradePGSQLDatabaseFrom38To37(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 38 -> 37")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 38 -> 37")
+
+	sql := strings.ReplaceAll(pgsqlV38DownSQL, "{{folders}}", sqlTableFolders)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 37, false)
+}
+
+func updatePGSQLDatabaseFrom38To39(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 38 -> 39")
+	providerLog(logger.LevelInfo, "updating database schema version: 38 -> 39")
+
+	sql := strings.ReplaceAll(pgsqlV39SQL, "{{folders}}", sqlTableFolders)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 39, true)
+}
+
+func downgradePGSQLDatabaseFrom39To38(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 39 -> 38")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 39 -> 38")
+
+	sql := strings.ReplaceAll(pgsqlV39DownSQL, "{{folders}}", sqlTableFolders)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 38, false)
+}
+
+func updatePGSQLDatabaseFrom39To40(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 39 -> 40")
+	providerLog(logger.LevelInfo, "updating database schema version: 39 -> 40")
+
+	sql := strings.ReplaceAll(pgsqlV40SQL, "{{folders}}", sqlTableFolders)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 40, true)
+}
+
+func downgradePGSQLDatabaseFrom40To39(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 40 -> 39")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 40 -> 39")
+
+	sql := strings.ReplaceAll(pgsqlV40DownSQL, "{{folders}}", sqlTableFolders)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 39, false)
+}
+
+func updatePGSQLDatabaseFrom40To41(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 40 -> 41")
+	providerLog(logger.LevelInfo, "updating database schema version: 40 -> 41")
+
+	sql := strings.ReplaceAll(pgsqlV41SQL, "{{folders}}", sqlTableFolders)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 41, true)
+}
+
+func downgradePGSQLDatabaseFrom41To40(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 41 -> 40")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 41 -> 40")
+
+	sql := strings.ReplaceAll(pgsqlV41DownSQL, "{{folders}}", sqlTableFolders)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 40, false)
+}
+
+func updatePGSQLDatabaseFrom41To42(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 41 -> 42")
+	providerLog(logger.LevelInfo, "updating database schema version: 41 -> 42")
+
+	sql := strings.ReplaceAll(pgsqlV42SQL, "{{users}}", sqlTableUsers)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 42, true)
+}
+
+func downgradePGSQLDatabaseFrom42To41(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 42 -> 41")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 42 -> 41")
+
+	sql := strings.ReplaceAll(pgsqlV42DownSQL, "{{users}}", sqlTableUsers)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 41, false)
+}
+
+func updatePGSQLDatabaseFrom42To43(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 42 -> 43")
+	providerLog(logger.LevelInfo, "updating database schema version: 42 -> 43")
+
+	sql := strings.ReplaceAll(pgsqlV43SQL, "{{user_templates}}", sqlTableUserTemplates)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 43, true)
+}
+
+func downgradePGSQLDatabaseFrom43To42(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 43 -> 42")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 43 -> 42")
+
+	sql := strings.ReplaceAll(pgsqlV43DownSQL, "{{user_templates}}", sqlTableUserTemplates)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 42, false)
+}
+
+func updatePGSQLDatabaseFrom43To44(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 43 -> 44")
+	providerLog(logger.LevelInfo, "updating database schema version: 43 -> 44")
+
+	sql := strings.ReplaceAll(pgsqlV44SQL, "{{object_changes}}", sqlTableObjectChanges)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 44, true)
+}
+
+func downgradePGSQLDatabaseFrom44To43(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 44 -> 43")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 44 -> 43")
+
+	sql := strings.ReplaceAll(pgsqlV44DownSQL, "{{object_changes}}", sqlTableObjectChanges)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 43, false)
+}
+
+func updatePGSQLDatabaseFrom44To45(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 44 -> 45")
+	providerLog(logger.LevelInfo, "updating database schema version: 44 -> 45")
+
+	sql := strings.ReplaceAll(pgsqlV45SQL, "{{api_keys}}", sqlTableAPIKeys)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 45, true)
+}
+
+func downgradePGSQLDatabaseFrom45To44(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 45 -> 44")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 45 -> 44")
+
+	sql := strings.ReplaceAll(pgsqlV45DownSQL, "{{api_keys}}", sqlTableAPIKeys)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 44, false)
+}
+
+func updatePGSQLDatabaseFrom45To46(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 45 -> 46")
+	providerLog(logger.LevelInfo, "updating database schema version: 45 -> 46")
+
+	sql := strings.ReplaceAll(pgsqlV46SQL, "{{object_changes_lock}}", sqlTableObjectChangesLock)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 46, true)
+}
+
+func downgradePGSQLDatabaseFrom46To45(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 46 -> 45")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 46 -> 45")
+
+	sql := strings.ReplaceAll(pgsqlV46DownSQL, "{{object_changes_lock}}", sqlTableObjectChangesLock)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 45, false)
+}
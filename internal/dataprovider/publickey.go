@@ -0,0 +1,160 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// supportedUserKeyAlgos defines the algorithms allowed inside the users allowed key algorithms filter
+var supportedUserKeyAlgos = []string{
+	ssh.KeyAlgoED25519, ssh.KeyAlgoSKED25519, ssh.KeyAlgoSKECDSA256,
+	ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521,
+	ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSA,
+	ssh.CertAlgoED25519v01, ssh.CertAlgoSKED25519v01, ssh.CertAlgoSKECDSA256v01,
+	ssh.CertAlgoECDSA256v01, ssh.CertAlgoECDSA384v01, ssh.CertAlgoECDSA521v01,
+	ssh.CertAlgoRSASHA256v01, ssh.CertAlgoRSASHA512v01, ssh.CertAlgoRSAv01,
+}
+
+func validateAllowedKeyAlgos(algos []string) ([]string, error) {
+	algos = util.RemoveDuplicates(algos, false)
+	for _, algo := range algos {
+		if !slices.Contains(supportedUserKeyAlgos, algo) {
+			return nil, util.NewValidationError(fmt.Sprintf("unsupported public key algorithm %q", algo))
+		}
+	}
+	return algos, nil
+}
+
+// isKeyAlgoAllowed returns true if the specified key type is allowed.
+// RSA keys have the same type regardless of the signature algorithm negotiated
+// in the SSH layer, so the SHA-2 variants allow RSA keys too
+func isKeyAlgoAllowed(keyType string, allowedAlgos []string) bool {
+	if len(allowedAlgos) == 0 || slices.Contains(allowedAlgos, keyType) {
+		return true
+	}
+	switch keyType {
+	case ssh.KeyAlgoRSA:
+		return slices.Contains(allowedAlgos, ssh.KeyAlgoRSASHA256) || slices.Contains(allowedAlgos, ssh.KeyAlgoRSASHA512)
+	case ssh.CertAlgoRSAv01:
+		return slices.Contains(allowedAlgos, ssh.CertAlgoRSASHA256v01) ||
+			slices.Contains(allowedAlgos, ssh.CertAlgoRSASHA512v01)
+	}
+	return false
+}
+
+// checkPublicKeyAlgo returns an error if the algorithm for the given public key is not allowed
+func (u *User) checkPublicKeyAlgo(pubKey []byte) error {
+	if len(u.Filters.AllowedKeyAlgos) == 0 {
+		return nil
+	}
+	key, err := ssh.ParsePublicKey(pubKey)
+	if err != nil {
+		return err
+	}
+	if !isKeyAlgoAllowed(key.Type(), u.Filters.AllowedKeyAlgos) {
+		return fmt.Errorf("public key algorithm %q is not allowed for user %q", key.Type(), u.Username)
+	}
+	return nil
+}
+
+// UserPublicKey defines a user public key and its metadata
+type UserPublicKey struct {
+	// Public key in authorized_keys format
+	Key string `json:"key"`
+	// Optional description
+	Comment string `json:"comment,omitempty"`
+	// Expiration date as unix timestamp in milliseconds, 0 means no expiration
+	ExpiresAt int64 `json:"expires_at,omitempty"`
+	// Last successful authentication as unix timestamp in milliseconds
+	LastUsedAt int64 `json:"last_used_at,omitempty"`
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+// Public keys stored as plain strings are supported too
+func (k *UserPublicKey) UnmarshalJSON(data []byte) error {
+	var key string
+	if err := json.Unmarshal(data, &key); err == nil {
+		*k = UserPublicKey{Key: key}
+		return nil
+	}
+	type publicKey UserPublicKey
+	var pk publicKey
+	if err := json.Unmarshal(data, &pk); err != nil {
+		return err
+	}
+	*k = UserPublicKey(pk)
+	return nil
+}
+
+// IsExpired returns true if the public key has an expiration date in the past
+func (k *UserPublicKey) IsExpired() bool {
+	return k.ExpiresAt > 0 && k.ExpiresAt < util.GetTimeAsMsSinceEpoch(time.Now())
+}
+
+func (k *UserPublicKey) matches(pubKey []byte) bool {
+	storedKey, _, _, _, err := ssh.ParseAuthorizedKey(util.StringToBytes(k.Key))
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(storedKey.Marshal(), pubKey)
+}
+
+func getPublicKeyFingerprint(key string) string {
+	out, _, _, _, err := ssh.ParseAuthorizedKey(util.StringToBytes(key))
+	if err != nil {
+		return ""
+	}
+	return ssh.FingerprintSHA256(out)
+}
+
+// setPublicKeyLastUse sets the last use for the specified key, it returns
+// false if the key is not found
+func setPublicKeyLastUse(keys []UserPublicKey, key string) bool {
+	for idx := range keys {
+		if keys[idx].Key == key {
+			keys[idx].LastUsedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+			return true
+		}
+	}
+	return false
+}
+
+// UpdatePublicKeyLastUse updates the last use time for the stored public key
+// matching the given one. To limit the writes to the data provider the update
+// is skipped if the key was recently used
+func UpdatePublicKeyLastUse(user *User, pubKey []byte) {
+	for _, k := range user.getPublicKeys() {
+		if !k.matches(pubKey) {
+			continue
+		}
+		if isLastActivityRecent(k.LastUsedAt, lastLoginMinDelay) {
+			return
+		}
+		if err := provider.updatePublicKeyLastUse(user.Username, k.Key); err != nil {
+			providerLog(logger.LevelWarn, "unable to update last use for public key of user %q: %v", user.Username, err)
+		}
+		return
+	}
+}
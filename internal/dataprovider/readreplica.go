@@ -0,0 +1,330 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/metric"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// operation classes that can be served by the read replicas
+const (
+	replicaClassAuth     = "auth"
+	replicaClassListings = "listings"
+)
+
+// routing targets for the read operations
+const (
+	replicaTargetPrimary  = "primary"
+	replicaTargetReplica  = "replica"
+	replicaTargetFailover = "failover"
+)
+
+const (
+	// lag reported for the replicas whose replication lag cannot be measured
+	replicaLagUnknown = -1
+
+	pgsqlReplicaLagQuery = `SELECT CASE WHEN NOT pg_is_in_recovery() OR pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn()
+THEN 0 ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0) END`
+)
+
+var (
+	sqlReadReplicas atomic.Pointer[readReplicas]
+)
+
+// ReadReplicasConfig defines the read-only replicas of the primary database.
+// The user lookups while authenticating and the users, groups and folders
+// listings are served by the healthy replicas, using round-robin, while all
+// the other operations use the primary database.
+// Read replicas are supported for the SQL data providers. The replication lag
+// cannot be measured for SQLite and CockroachDB: the user lookups while
+// authenticating always use the primary database for these providers, while
+// the listings use the replicas unless the listings max lag is negative
+type ReadReplicasConfig struct {
+	// Connection strings for the replicas, in the same format as the data provider
+	// connection string
+	ConnectionStrings []string `json:"connection_strings" mapstructure:"connection_strings"`
+	// Interval between the replicas health checks as seconds
+	HealthCheckInterval int `json:"health_check_interval" mapstructure:"health_check_interval"`
+	// Maximum replication lag, as seconds, accepted for the user lookups while
+	// authenticating. A negative value means that these lookups always use the
+	// primary database
+	AuthMaxLag int `json:"auth_max_lag" mapstructure:"auth_max_lag"`
+	// Maximum replication lag, as seconds, accepted for the users, groups and
+	// folders listings. A negative value means that the listings always use the
+	// primary database
+	ListingsMaxLag int `json:"listings_max_lag" mapstructure:"listings_max_lag"`
+}
+
+func (c *ReadReplicasConfig) validate() error {
+	if len(c.ConnectionStrings) == 0 {
+		return nil
+	}
+	if !slices.Contains([]string{SQLiteDataProviderName, PGSQLDataProviderName, MySQLDataProviderName,
+		CockroachDataProviderName}, config.Driver) {
+		return util.NewValidationError(fmt.Sprintf("read replicas are not supported for the %q data provider",
+			config.Driver))
+	}
+	if c.HealthCheckInterval <= 0 {
+		return util.NewValidationError("read replicas health check interval must be greater than 0")
+	}
+	return nil
+}
+
+// isLagSensitive returns true if the operations for the specified class
+// cannot use a replica whose replication lag is unknown. The user lookups
+// while authenticating must see, for example, password changes and disabled
+// users as soon as possible
+func isLagSensitive(class string) bool {
+	return class == replicaClassAuth
+}
+
+func (c *ReadReplicasConfig) getMaxLag(class string) int {
+	if class == replicaClassAuth {
+		return c.AuthMaxLag
+	}
+	return c.ListingsMaxLag
+}
+
+type readReplica struct {
+	// used in logs and metrics, the connection string could contain credentials
+	name     string
+	dbHandle *sql.DB
+	healthy  atomic.Bool
+	// replication lag as seconds, replicaLagUnknown if it cannot be measured
+	lag atomic.Int64
+}
+
+func (r *readReplica) setStatus(healthy bool, lag int64) {
+	if r.healthy.Swap(healthy) != healthy {
+		if healthy {
+			providerLog(logger.LevelInfo, "read replica %q is healthy, lag: %d", r.name, lag)
+		} else {
+			providerLog(logger.LevelWarn, "read replica %q is unhealthy", r.name)
+		}
+	}
+	r.lag.Store(lag)
+	metric.UpdateDataProviderReplicaStatus(r.name, healthy, lag)
+}
+
+type readReplicas struct {
+	driverName string
+	replicas   []*readReplica
+	next       atomic.Uint32
+}
+
+// pick returns the next healthy replica with a lag acceptable for the specified
+// operation class, nil if no replica can serve the operation
+func (r *readReplicas) pick(class string) *readReplica {
+	maxLag := config.ReadReplicas.getMaxLag(class)
+	if maxLag < 0 {
+		return nil
+	}
+	start := int(r.next.Add(1))
+	for idx := range r.replicas {
+		replica := r.replicas[(start+idx)%len(r.replicas)]
+		if !replica.healthy.Load() {
+			continue
+		}
+		lag := replica.lag.Load()
+		if lag == replicaLagUnknown {
+			if !isLagSensitive(class) {
+				return replica
+			}
+			continue
+		}
+		if lag <= int64(maxLag) {
+			return replica
+		}
+	}
+	return nil
+}
+
+func (r *readReplicas) checkHealth() {
+	for _, replica := range r.replicas {
+		lag, err := r.getLag(replica.dbHandle)
+		if err != nil {
+			providerLog(logger.LevelDebug, "health check failed for read replica %q: %v", replica.name, err)
+			replica.setStatus(false, 0)
+			continue
+		}
+		replica.setStatus(true, lag)
+	}
+}
+
+func (r *readReplicas) getLag(dbHandle *sql.DB) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	if err := dbHandle.PingContext(ctx); err != nil {
+		return 0, err
+	}
+	switch config.Driver {
+	case PGSQLDataProviderName:
+		var lag float64
+		if err := dbHandle.QueryRowContext(ctx, pgsqlReplicaLagQuery).Scan(&lag); err != nil {
+			return 0, err
+		}
+		return int64(math.Ceil(lag)), nil
+	case MySQLDataProviderName:
+		return getMySQLReplicaLag(ctx, dbHandle)
+	default:
+		// the replication lag cannot be measured for SQLite and CockroachDB
+		return replicaLagUnknown, nil
+	}
+}
+
+func (r *readReplicas) close() {
+	for _, replica := range r.replicas {
+		if err := replica.dbHandle.Close(); err != nil {
+			providerLog(logger.LevelWarn, "unable to close read replica %q: %v", replica.name, err)
+		}
+	}
+}
+
+func getMySQLReplicaLag(ctx context.Context, dbHandle *sql.DB) (int64, error) {
+	rows, err := dbHandle.QueryContext(ctx, "SHOW REPLICA STATUS")
+	if err != nil {
+		// MySQL < 8.0.22
+		rows, err = dbHandle.QueryContext(ctx, "SHOW SLAVE STATUS")
+		if err != nil {
+			return 0, err
+		}
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	if !rows.Next() {
+		// not configured as replica
+		return 0, rows.Err()
+	}
+	values := make([]sql.NullString, len(columns))
+	dest := make([]any, len(columns))
+	for idx := range values {
+		dest[idx] = &values[idx]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return 0, err
+	}
+	for idx, column := range columns {
+		if column == "Seconds_Behind_Source" || column == "Seconds_Behind_Master" {
+			if !values[idx].Valid {
+				return 0, errors.New("replication is not running")
+			}
+			return strconv.ParseInt(values[idx].String, 10, 64)
+		}
+	}
+	return 0, errors.New("unable to find the replication lag")
+}
+
+func getReplicasDriverName() string {
+	switch config.Driver {
+	case PGSQLDataProviderName, CockroachDataProviderName:
+		return "pgx"
+	case MySQLDataProviderName:
+		return "mysql"
+	default:
+		return "sqlite3"
+	}
+}
+
+func initializeReadReplicas() error {
+	closeReadReplicas()
+	if len(config.ReadReplicas.ConnectionStrings) == 0 {
+		return nil
+	}
+	r := &readReplicas{
+		driverName: getReplicasDriverName(),
+	}
+	for idx, connectionString := range config.ReadReplicas.ConnectionStrings {
+		name := fmt.Sprintf("replica_%d", idx)
+		dbHandle, err := sql.Open(r.driverName, connectionString)
+		if err != nil {
+			providerLog(logger.LevelError, "error creating database handler for read replica %q: %v", name, err)
+			r.close()
+			return err
+		}
+		dbHandle.SetMaxOpenConns(config.PoolSize)
+		if config.PoolSize > 0 {
+			dbHandle.SetMaxIdleConns(config.PoolSize)
+		} else {
+			dbHandle.SetMaxIdleConns(2)
+		}
+		dbHandle.SetConnMaxLifetime(240 * time.Second)
+		dbHandle.SetConnMaxIdleTime(120 * time.Second)
+		r.replicas = append(r.replicas, &readReplica{
+			name:     name,
+			dbHandle: dbHandle,
+		})
+	}
+	providerLog(logger.LevelDebug, "%d read replicas configured", len(r.replicas))
+	// an unavailable replica is not fatal, the primary is used until it becomes healthy
+	r.checkHealth()
+	sqlReadReplicas.Store(r)
+	return nil
+}
+
+func closeReadReplicas() {
+	if r := sqlReadReplicas.Swap(nil); r != nil {
+		r.close()
+	}
+}
+
+func checkReadReplicas() {
+	if r := sqlReadReplicas.Load(); r != nil {
+		r.checkHealth()
+	}
+}
+
+// executeOnReadReplica executes fn using a read replica, if any can serve the specified
+// operation class, or the primary database. If the replica fails, or the requested
+// object is not found, since it could have not been replicated yet, fn is executed
+// again using the primary database
+func executeOnReadReplica(class string, primary *sql.DB, fn func(dbHandle *sql.DB) error) error {
+	r := sqlReadReplicas.Load()
+	if r == nil {
+		return fn(primary)
+	}
+	replica := r.pick(class)
+	if replica == nil {
+		metric.AddDataProviderReadRouting(class, replicaTargetPrimary)
+		return fn(primary)
+	}
+	err := fn(replica.dbHandle)
+	if err == nil {
+		metric.AddDataProviderReadRouting(class, replicaTargetReplica)
+		return nil
+	}
+	if !errors.Is(err, util.ErrNotFound) {
+		providerLog(logger.LevelWarn, "read from replica %q failed, using the primary: %v", replica.name, err)
+		replica.setStatus(false, 0)
+	}
+	metric.AddDataProviderReadRouting(class, replicaTargetFailover)
+	return fn(primary)
+}
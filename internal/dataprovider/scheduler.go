@@ -68,6 +68,43 @@ func startScheduler() error {
 	if err != nil {
 		return fmt.Errorf("unable to schedule nodes cleanup: %w", err)
 	}
+	if config.LoginEvents.Enabled {
+		_, err = scheduler.AddFunc("@every 1h", cleanupLoginEvents)
+		if err != nil {
+			return fmt.Errorf("unable to schedule login events cleanup: %w", err)
+		}
+	}
+	if config.ChangeFeed.Enabled {
+		_, err = scheduler.AddFunc("@every 1h", cleanupObjectChanges)
+		if err != nil {
+			return fmt.Errorf("unable to schedule object changes cleanup: %w", err)
+		}
+	}
+	if config.UserCache.Enabled && config.ChangeFeed.Enabled && config.IsShared == 1 {
+		cachedUserLookups.lastChangeID.Store(0)
+		_, err = scheduler.AddFunc("@every 10s", cachedUserLookups.checkChanges)
+		if err != nil {
+			return fmt.Errorf("unable to schedule user cache changes check: %w", err)
+		}
+	}
+	if sqlReadReplicas.Load() != nil {
+		_, err = scheduler.AddFunc(fmt.Sprintf("@every %ds", config.ReadReplicas.HealthCheckInterval), checkReadReplicas)
+		if err != nil {
+			return fmt.Errorf("unable to schedule read replicas health check: %w", err)
+		}
+	}
+	if config.Archives.Schedule != "" {
+		_, err = scheduler.AddFunc(config.Archives.Schedule, executeScheduledArchive)
+		if err != nil {
+			return fmt.Errorf("unable to schedule archives: %w", err)
+		}
+	}
+	if config.TrackQuota != 0 {
+		_, err = scheduler.AddFunc("@every 10m", cleanupTransferQuotaWindows)
+		if err != nil {
+			return fmt.Errorf("unable to schedule transfer quota windows reset: %w", err)
+		}
+	}
 	scheduler.Start()
 	return nil
 }
@@ -103,6 +140,10 @@ func checkCacheUpdates() {
 	cachedUserPasswords.cleanup()
 	cachedAdminPasswords.cleanup()
 	cachedAPIKeys.cleanup()
+	cleanupSecondFactorAttempts()
+	cleanupAccountLockout()
+	cachedExternalAuths.cleanup()
+	cachedUserLookups.cleanup()
 }
 
 func checkUserCache() {
@@ -120,11 +161,12 @@ func checkUserCache() {
 	for idx := range users {
 		user := users[idx]
 		providerLog(logger.LevelDebug, "invalidate caches for user %q", user.Username)
+		invalidateCachedUser(user.Username)
 		if user.DeletedAt > 0 {
 			deletedAt := util.GetTimeFromMsecSinceEpoch(user.DeletedAt)
 			if deletedAt.Add(30 * time.Minute).Before(time.Now()) {
 				providerLog(logger.LevelDebug, "removing user %q deleted at %s", user.Username, deletedAt)
-				go provider.deleteUser(user, false) //nolint:errcheck
+				go provider.deleteUser(user, false, nil) //nolint:errcheck
 			}
 			webDAVUsersCache.remove(user.Username)
 			cachedUserPasswords.Remove(user.Username)
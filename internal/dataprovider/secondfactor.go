@@ -0,0 +1,137 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/metric"
+	"github.com/drakkan/sftpgo/v2/internal/mfa"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+var (
+	memorySecondFactorAttempts = newMemoryFailedAttemptsStore()
+)
+
+func getSecondFactorAttemptsStore() failedAttemptsStore {
+	if config.IsShared == 1 {
+		return &dbFailedAttemptsStore{sessionType: SessionTypeSecondFactorAttempts}
+	}
+	return memorySecondFactorAttempts
+}
+
+func getSecondFactorAttemptsKey(username string, isAdmin bool) string {
+	if isAdmin {
+		return "admin_" + username
+	}
+	return "user_" + username
+}
+
+// CheckSecondFactorAttempts returns ErrTooManySecondFactorAttempts if the
+// one-time code verification is locked out for the specified user or admin
+func CheckSecondFactorAttempts(username string, isAdmin bool) error {
+	lockout := mfa.GetLockoutConfig()
+	if !lockout.IsEnabled() {
+		return nil
+	}
+	attempts, ok := getSecondFactorAttemptsStore().get(getSecondFactorAttemptsKey(username, isAdmin))
+	if ok && attempts.isLocked(util.GetTimeAsMsSinceEpoch(time.Now())) {
+		providerLog(logger.LevelDebug, "second factor verification locked out for %q, admin? %t, locked until %s",
+			username, isAdmin, util.GetTimeFromMsecSinceEpoch(attempts.LockedUntil).UTC().Format(time.RFC3339))
+		return ErrTooManySecondFactorAttempts
+	}
+	return nil
+}
+
+// UpdateSecondFactorAttempts records the result of a one-time code verification,
+// TOTP passcodes and recovery codes share the same failed attempts counter.
+// A successful verification resets the counter. ErrTooManySecondFactorAttempts
+// is returned if the failed verification locks out further attempts
+func UpdateSecondFactorAttempts(username string, isAdmin, success bool) error {
+	lockout := mfa.GetLockoutConfig()
+	if !lockout.IsEnabled() {
+		return nil
+	}
+	store := getSecondFactorAttemptsStore()
+	key := getSecondFactorAttemptsKey(username, isAdmin)
+	if success {
+		store.remove(key)
+		return nil
+	}
+	var isLocked, lockedOut bool
+	err := store.update(key, func(attempts failedAttempts, _ bool) (failedAttempts, int64, bool) {
+		now := util.GetTimeAsMsSinceEpoch(time.Now())
+		isLocked = attempts.isLocked(now)
+		lockedOut = false
+		if isLocked {
+			return attempts, 0, false
+		}
+		attempts.Failures++
+		attempts.UpdatedAt = now
+		if attempts.Failures >= lockout.MaxAttempts {
+			attempts.Failures = 0
+			attempts.LockedUntil = now + lockout.GetCooldown().Milliseconds()
+			lockedOut = true
+		}
+		return attempts, attempts.getExpiration(lockout.GetCooldown()), true
+	})
+	if err != nil {
+		// the failure cannot be recorded, further attempts are not allowed
+		providerLog(logger.LevelError, "unable to update second factor attempts for %q, admin? %t: %v",
+			username, isAdmin, err)
+		return ErrTooManySecondFactorAttempts
+	}
+	if lockedOut {
+		providerLog(logger.LevelInfo, "second factor verification locked out for %q, admin? %t, max attempts: %d",
+			username, isAdmin, lockout.MaxAttempts)
+		metric.AddSecondFactorLockout()
+	}
+	if isLocked || lockedOut {
+		return ErrTooManySecondFactorAttempts
+	}
+	return nil
+}
+
+// ValidateTOTPPasscode validates a TOTP passcode for the specified user or admin
+// and updates the failed attempts counter. ErrTooManySecondFactorAttempts is
+// returned if the verification is locked out
+func ValidateTOTPPasscode(username string, isAdmin bool, configName, passcode, secret string) (bool, error) {
+	if err := CheckSecondFactorAttempts(username, isAdmin); err != nil {
+		return false, err
+	}
+	match, err := mfa.ValidateTOTPPasscode(configName, passcode, secret)
+	if errLockout := UpdateSecondFactorAttempts(username, isAdmin, match && err == nil); errLockout != nil {
+		return false, errLockout
+	}
+	return match, err
+}
+
+func resetSecondFactorAttempts(username string, isAdmin bool) {
+	lockout := mfa.GetLockoutConfig()
+	if !lockout.IsEnabled() {
+		return
+	}
+	getSecondFactorAttemptsStore().remove(getSecondFactorAttemptsKey(username, isAdmin))
+}
+
+func cleanupSecondFactorAttempts() {
+	lockout := mfa.GetLockoutConfig()
+	if !lockout.IsEnabled() {
+		return
+	}
+	getSecondFactorAttemptsStore().cleanup()
+}
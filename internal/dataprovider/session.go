@@ -30,6 +30,8 @@ const (
 	SessionTypeOAuth2Auth
 	SessionTypeInvalidToken
 	SessionTypeWebTask
+	SessionTypeSecondFactorAttempts
+	SessionTypeAccountLockout
 )
 
 // Session defines a shared session persisted in the data provider
@@ -44,7 +46,7 @@ func (s *Session) validate() error {
 	if s.Key == "" {
 		return errors.New("unable to save a session with an empty key")
 	}
-	if s.Type < SessionTypeOIDCAuth || s.Type > SessionTypeWebTask {
+	if s.Type < SessionTypeOIDCAuth || s.Type > SessionTypeAccountLockout {
 		return fmt.Errorf("invalid session type: %v", s.Type)
 	}
 	return nil
@@ -26,6 +26,7 @@ import (
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/cockroachdb/cockroach-go/v2/crdb"
 	"github.com/sftpgo/sdk"
@@ -36,7 +37,7 @@ import (
 )
 
 const (
-	sqlDatabaseVersion     = 32
+	sqlDatabaseVersion     = 46
 	defaultSQLQueryTimeout = 10 * time.Second
 	longSQLQueryTimeout    = 60 * time.Second
 )
@@ -83,6 +84,11 @@ func sqlReplaceAll(sql string) string {
 	sql = strings.ReplaceAll(sql, "{{roles}}", sqlTableRoles)
 	sql = strings.ReplaceAll(sql, "{{ip_lists}}", sqlTableIPLists)
 	sql = strings.ReplaceAll(sql, "{{configs}}", sqlTableConfigs)
+	sql = strings.ReplaceAll(sql, "{{login_events}}", sqlTableLoginEvents)
+	sql = strings.ReplaceAll(sql, "{{transfer_windows}}", sqlTableTransferWindows)
+	sql = strings.ReplaceAll(sql, "{{user_templates}}", sqlTableUserTemplates)
+	sql = strings.ReplaceAll(sql, "{{object_changes}}", sqlTableObjectChanges)
+	sql = strings.ReplaceAll(sql, "{{object_changes_lock}}", sqlTableObjectChangesLock)
 	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
 	return sql
 }
@@ -297,7 +303,7 @@ func sqlCommonAddAPIKey(apiKey *APIKey, dbHandle *sql.DB) error {
 	q := getAddAPIKeyQuery()
 	_, err = dbHandle.ExecContext(ctx, q, apiKey.KeyID, apiKey.Name, apiKey.Key, apiKey.Scope,
 		util.GetTimeAsMsSinceEpoch(time.Now()), util.GetTimeAsMsSinceEpoch(time.Now()), apiKey.LastUseAt,
-		apiKey.ExpiresAt, apiKey.Description, userID, adminID)
+		apiKey.ExpiresAt, apiKey.Description, userID, adminID, getAPIKeyPermissionsAsJSON(apiKey), apiKey.UsernamePrefix)
 	return err
 }
 
@@ -317,7 +323,8 @@ func sqlCommonUpdateAPIKey(apiKey *APIKey, dbHandle *sql.DB) error {
 
 	q := getUpdateAPIKeyQuery()
 	res, err := dbHandle.ExecContext(ctx, q, apiKey.Name, apiKey.Scope, apiKey.ExpiresAt, userID, adminID,
-		apiKey.Description, util.GetTimeAsMsSinceEpoch(time.Now()), apiKey.KeyID)
+		apiKey.Description, util.GetTimeAsMsSinceEpoch(time.Now()), getAPIKeyPermissionsAsJSON(apiKey),
+		apiKey.UsernamePrefix, apiKey.KeyID)
 	if err != nil {
 		return err
 	}
@@ -906,6 +913,110 @@ func sqlCommonDeleteRole(role Role, dbHandle *sql.DB) error {
 	return sqlCommonRequireRowAffected(res)
 }
 
+func sqlCommonGetUserTemplateByName(name string, dbHandle sqlQuerier) (UserTemplate, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getUserTemplateByNameQuery()
+	row := dbHandle.QueryRowContext(ctx, q, name)
+	return getUserTemplateFromDbRow(row)
+}
+
+func sqlCommonDumpUserTemplates(dbHandle sqlQuerier) ([]UserTemplate, error) {
+	templates := make([]UserTemplate, 0, 10)
+	ctx, cancel := context.WithTimeout(context.Background(), longSQLQueryTimeout)
+	defer cancel()
+
+	q := getDumpUserTemplatesQuery()
+
+	rows, err := dbHandle.QueryContext(ctx, q)
+	if err != nil {
+		return templates, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		template, err := getUserTemplateFromDbRow(rows)
+		if err != nil {
+			return templates, err
+		}
+		templates = append(templates, template)
+	}
+	return templates, rows.Err()
+}
+
+func sqlCommonGetUserTemplates(limit int, offset int, order string, dbHandle sqlQuerier) ([]UserTemplate, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getUserTemplatesQuery(order)
+
+	templates := make([]UserTemplate, 0, limit)
+	rows, err := dbHandle.QueryContext(ctx, q, limit, offset)
+	if err != nil {
+		return templates, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		template, err := getUserTemplateFromDbRow(rows)
+		if err != nil {
+			return templates, err
+		}
+		templates = append(templates, template)
+	}
+	return templates, rows.Err()
+}
+
+func sqlCommonAddUserTemplate(template *UserTemplate, dbHandle *sql.DB) error {
+	if err := template.validate(); err != nil {
+		return err
+	}
+	userSettings, err := json.Marshal(template.User)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getAddUserTemplateQuery()
+	_, err = dbHandle.ExecContext(ctx, q, template.Name, template.Description, string(userSettings),
+		util.GetTimeAsMsSinceEpoch(time.Now()), util.GetTimeAsMsSinceEpoch(time.Now()))
+	return err
+}
+
+func sqlCommonUpdateUserTemplate(template *UserTemplate, dbHandle *sql.DB) error {
+	if err := template.validate(); err != nil {
+		return err
+	}
+	userSettings, err := json.Marshal(template.User)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getUpdateUserTemplateQuery()
+	res, err := dbHandle.ExecContext(ctx, q, template.Description, string(userSettings),
+		util.GetTimeAsMsSinceEpoch(time.Now()), template.Name)
+	if err != nil {
+		return err
+	}
+	return sqlCommonRequireRowAffected(res)
+}
+
+func sqlCommonDeleteUserTemplate(template UserTemplate, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getDeleteUserTemplateQuery()
+	res, err := dbHandle.ExecContext(ctx, q, template.Name)
+	if err != nil {
+		return err
+	}
+	return sqlCommonRequireRowAffected(res)
+}
+
 func sqlCommonGetGroupByName(name string, dbHandle sqlQuerier) (Group, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
@@ -1092,7 +1203,7 @@ func sqlCommonGetGroups(limit int, offset int, order string, minimal bool, dbHan
 	return groups, nil
 }
 
-func sqlCommonAddGroup(group *Group, dbHandle *sql.DB) error {
+func sqlCommonAddGroup(group *Group, change *ObjectChange, dbHandle *sql.DB) error {
 	if err := group.validate(); err != nil {
 		return err
 	}
@@ -1110,11 +1221,14 @@ func sqlCommonAddGroup(group *Group, dbHandle *sql.DB) error {
 		if err != nil {
 			return err
 		}
-		return generateGroupVirtualFoldersMapping(ctx, group, tx)
+		if err := generateGroupVirtualFoldersMapping(ctx, group, tx); err != nil {
+			return err
+		}
+		return sqlCommonAddObjectChange(ctx, change, tx)
 	})
 }
 
-func sqlCommonUpdateGroup(group *Group, dbHandle *sql.DB) error {
+func sqlCommonUpdateGroup(group *Group, change *ObjectChange, dbHandle *sql.DB) error {
 	if err := group.validate(); err != nil {
 		return err
 	}
@@ -1132,20 +1246,28 @@ func sqlCommonUpdateGroup(group *Group, dbHandle *sql.DB) error {
 		if err != nil {
 			return err
 		}
-		return generateGroupVirtualFoldersMapping(ctx, group, tx)
+		if err := generateGroupVirtualFoldersMapping(ctx, group, tx); err != nil {
+			return err
+		}
+		return sqlCommonAddObjectChange(ctx, change, tx)
 	})
 }
 
-func sqlCommonDeleteGroup(group Group, dbHandle *sql.DB) error {
+func sqlCommonDeleteGroup(group Group, change *ObjectChange, dbHandle *sql.DB) error {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 
-	q := getDeleteGroupQuery()
-	res, err := dbHandle.ExecContext(ctx, q, group.Name)
-	if err != nil {
-		return err
-	}
-	return sqlCommonRequireRowAffected(res)
+	return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
+		q := getDeleteGroupQuery()
+		res, err := tx.ExecContext(ctx, q, group.Name)
+		if err != nil {
+			return err
+		}
+		if err := sqlCommonRequireRowAffected(res); err != nil {
+			return err
+		}
+		return sqlCommonAddObjectChange(ctx, change, tx)
+	})
 }
 
 func sqlCommonGetUserByUsername(username, role string, dbHandle sqlQuerier) (User, error) {
@@ -1169,8 +1291,19 @@ func sqlCommonGetUserByUsername(username, role string, dbHandle sqlQuerier) (Use
 	return getUserWithGroups(ctx, user, dbHandle)
 }
 
+// sqlCommonGetUserForAuth returns the user to authenticate, from a read replica if possible
+func sqlCommonGetUserForAuth(username string, dbHandle *sql.DB) (User, error) {
+	var user User
+	err := executeOnReadReplica(replicaClassAuth, dbHandle, func(handle *sql.DB) error {
+		var err error
+		user, err = sqlCommonGetUserByUsername(username, "", handle)
+		return err
+	})
+	return user, err
+}
+
 func sqlCommonValidateUserAndPass(username, password, ip, protocol string, dbHandle *sql.DB) (User, error) {
-	user, err := sqlCommonGetUserByUsername(username, "", dbHandle)
+	user, err := sqlCommonGetUserForAuth(username, dbHandle)
 	if err != nil {
 		providerLog(logger.LevelWarn, "error authenticating user %q: %v", username, err)
 		return user, err
@@ -1183,7 +1316,7 @@ func sqlCommonValidateUserAndTLSCertificate(username, protocol string, tlsCert *
 	if tlsCert == nil {
 		return user, errors.New("TLS certificate cannot be null or empty")
 	}
-	user, err := sqlCommonGetUserByUsername(username, "", dbHandle)
+	user, err := sqlCommonGetUserForAuth(username, dbHandle)
 	if err != nil {
 		providerLog(logger.LevelWarn, "error authenticating user %q: %v", username, err)
 		return user, err
@@ -1196,7 +1329,7 @@ func sqlCommonValidateUserAndPubKey(username string, pubKey []byte, isSSHCert bo
 	if len(pubKey) == 0 {
 		return user, "", errors.New("credentials cannot be null or empty")
 	}
-	user, err := sqlCommonGetUserByUsername(username, "", dbHandle)
+	user, err := sqlCommonGetUserForAuth(username, dbHandle)
 	if err != nil {
 		providerLog(logger.LevelWarn, "error authenticating user %q: %v", username, err)
 		return user, "", err
@@ -1234,6 +1367,76 @@ func sqlCommonUpdateTransferQuota(username string, uploadSize, downloadSize int6
 	return err
 }
 
+func sqlCommonUpdateTransferWindowUsage(username string, start, end, size int64, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	update := func() (bool, error) {
+		res, err := dbHandle.ExecContext(ctx, getUpdateTransferWindowQuery(), start, size, size, start, end, start,
+			username)
+		if err != nil {
+			return false, err
+		}
+		return sqlCommonRequireRowAffected(res) == nil, nil
+	}
+
+	updated, err := update()
+	if err != nil || updated {
+		return err
+	}
+	_, err = dbHandle.ExecContext(ctx, getAddTransferWindowQuery(), username, start, end, size)
+	if err != nil {
+		// the window could be added concurrently, for example by another node
+		if updated, errUpdate := update(); errUpdate == nil && updated {
+			return nil
+		}
+		providerLog(logger.LevelError, "error adding transfer window for user %q: %v", username, err)
+	}
+	return err
+}
+
+func sqlCommonGetTransferWindowUsage(username string, dbHandle *sql.DB) (transferWindowUsage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	usage := transferWindowUsage{
+		Username: username,
+	}
+	err := dbHandle.QueryRowContext(ctx, getTransferWindowQuery(), username).Scan(&usage.Start, &usage.End,
+		&usage.Used, &usage.Notified)
+	if errors.Is(err, sql.ErrNoRows) {
+		return usage, util.NewRecordNotFoundError(fmt.Sprintf("transfer window for user %q does not exist", username))
+	}
+	return usage, err
+}
+
+func sqlCommonSetTransferWindowNotified(username string, start int64, threshold int, dbHandle *sql.DB) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	res, err := dbHandle.ExecContext(ctx, getSetTransferWindowNotifiedQuery(), threshold, username, start, threshold)
+	if err != nil {
+		return false, err
+	}
+	return sqlCommonRequireRowAffected(res) == nil, nil
+}
+
+func sqlCommonCleanupTransferWindowUsages(before int64, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), longSQLQueryTimeout)
+	defer cancel()
+
+	_, err := dbHandle.ExecContext(ctx, getTransferWindowsCleanupQuery(), before)
+	return err
+}
+
+func sqlCommonDeleteTransferWindowUsage(username string, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	_, err := dbHandle.ExecContext(ctx, getDeleteTransferWindowQuery(), username)
+	return err
+}
+
 func sqlCommonUpdateQuota(username string, filesAdd int, sizeAdd int64, reset bool, dbHandle *sql.DB) error {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
@@ -1383,7 +1586,62 @@ func sqlCommonUpdateLastLogin(username string, dbHandle *sql.DB) error {
 	return err
 }
 
-func sqlCommonAddUser(user *User, dbHandle *sql.DB) error {
+func sqlCommonConsumeUserLogin(username string, maxLogins int, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	// the budget is checked and updated within the same statement, so concurrent
+	// logins cannot exceed it
+	q := getConsumeUserLoginQuery()
+	res, err := dbHandle.ExecContext(ctx, q, username, maxLogins)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error consuming a login for user %q: %v", username, err)
+		return err
+	}
+	if err := sqlCommonRequireRowAffected(res); err != nil {
+		return ErrLoginBudgetExhausted
+	}
+	providerLog(logger.LevelDebug, "login consumed for user %q", username)
+	return nil
+}
+
+func sqlCommonUpdatePublicKeyLastUse(username, key string, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	err := sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
+		var publicKeys []byte
+		q := getUserPublicKeysQuery()
+		if err := tx.QueryRowContext(ctx, q, username).Scan(&publicKeys); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return util.NewRecordNotFoundError(fmt.Sprintf("username %q does not exist", username))
+			}
+			return err
+		}
+		var keys []UserPublicKey
+		if err := json.Unmarshal(publicKeys, &keys); err != nil {
+			return err
+		}
+		if !setPublicKeyLastUse(keys, key) {
+			return util.NewRecordNotFoundError(fmt.Sprintf("public key not found for user %q", username))
+		}
+		publicKeys, err := json.Marshal(keys)
+		if err != nil {
+			return err
+		}
+		q = getUpdateUserPublicKeysQuery()
+		_, err = tx.ExecContext(ctx, q, publicKeys, username)
+		return err
+	})
+	if err == nil {
+		providerLog(logger.LevelDebug, "public key last use updated for user %q", username)
+	} else {
+		providerLog(logger.LevelWarn, "error updating public key last use for user %q: %v", username, err)
+	}
+	return err
+}
+
+func sqlCommonAddUser(user *User, change *ObjectChange, dbHandle *sql.DB) error {
 	err := ValidateUser(user)
 	if err != nil {
 		return err
@@ -1427,7 +1685,10 @@ func sqlCommonAddUser(user *User, dbHandle *sql.DB) error {
 		if err := generateUserVirtualFoldersMapping(ctx, user, tx); err != nil {
 			return err
 		}
-		return generateUserGroupMapping(ctx, user, tx)
+		if err := generateUserGroupMapping(ctx, user, tx); err != nil {
+			return err
+		}
+		return sqlCommonAddObjectChange(ctx, change, tx)
 	})
 }
 
@@ -1443,7 +1704,7 @@ func sqlCommonUpdateUserPassword(username, password string, dbHandle *sql.DB) er
 	return sqlCommonRequireRowAffected(res)
 }
 
-func sqlCommonUpdateUser(user *User, dbHandle *sql.DB) error {
+func sqlCommonUpdateUser(user *User, change *ObjectChange, dbHandle *sql.DB) error {
 	err := ValidateUser(user)
 	if err != nil {
 		return err
@@ -1484,17 +1745,22 @@ func sqlCommonUpdateUser(user *User, dbHandle *sql.DB) error {
 		if err := generateUserVirtualFoldersMapping(ctx, user, tx); err != nil {
 			return err
 		}
-		return generateUserGroupMapping(ctx, user, tx)
+		if err := generateUserGroupMapping(ctx, user, tx); err != nil {
+			return err
+		}
+		return sqlCommonAddObjectChange(ctx, change, tx)
 	})
 }
 
-func sqlCommonDeleteUser(user User, softDelete bool, dbHandle *sql.DB) error {
+func sqlCommonDeleteUser(user User, softDelete bool, change *ObjectChange, dbHandle *sql.DB) error {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 
 	q := getDeleteUserQuery(softDelete)
-	if softDelete {
-		return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
+	return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
+		var res sql.Result
+		var err error
+		if softDelete {
 			if err := sqlCommonClearUserFolderMapping(ctx, &user, tx); err != nil {
 				return err
 			}
@@ -1502,18 +1768,18 @@ func sqlCommonDeleteUser(user User, softDelete bool, dbHandle *sql.DB) error {
 				return err
 			}
 			ts := util.GetTimeAsMsSinceEpoch(time.Now())
-			res, err := tx.ExecContext(ctx, q, ts, ts, user.Username)
-			if err != nil {
-				return err
-			}
-			return sqlCommonRequireRowAffected(res)
-		})
-	}
-	res, err := dbHandle.ExecContext(ctx, q, user.Username)
-	if err != nil {
-		return err
-	}
-	return sqlCommonRequireRowAffected(res)
+			res, err = tx.ExecContext(ctx, q, ts, ts, user.Username)
+		} else {
+			res, err = tx.ExecContext(ctx, q, user.Username)
+		}
+		if err != nil {
+			return err
+		}
+		if err := sqlCommonRequireRowAffected(res); err != nil {
+			return err
+		}
+		return sqlCommonAddObjectChange(ctx, change, tx)
+	})
 }
 
 func sqlCommonDumpUsers(dbHandle sqlQuerier) ([]User, error) {
@@ -1790,18 +2056,20 @@ func sqlCommonGetActiveTransfers(from time.Time, dbHandle sqlQuerier) ([]ActiveT
 	return transfers, rows.Err()
 }
 
-func sqlCommonGetUsers(limit int, offset int, order, role string, dbHandle sqlQuerier) ([]User, error) {
+func sqlCommonGetUsers(limit int, offset int, order, role, usernamePrefix string, dbHandle sqlQuerier) ([]User, error) {
 	users := make([]User, 0, limit)
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 
-	q := getUsersQuery(order, role)
+	q := getUsersQuery(order, role, usernamePrefix)
 	var args []any
-	if role == "" {
-		args = append(args, limit, offset)
-	} else {
-		args = append(args, role, limit, offset)
+	if role != "" {
+		args = append(args, role)
+	}
+	if usernamePrefix != "" {
+		args = append(args, utf8.RuneCountInString(usernamePrefix), usernamePrefix)
 	}
+	args = append(args, limit, offset)
 	rows, err := dbHandle.QueryContext(ctx, q, args...)
 	if err != nil {
 		return users, err
@@ -1985,6 +2253,40 @@ func sqlCommonAddDefenderHostAndEvent(ip string, score int, dbHandle *sql.DB) er
 	})
 }
 
+func sqlCommonImportDefenderHosts(hosts []DefenderEntry, replace bool, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), longSQLQueryTimeout)
+	defer cancel()
+
+	return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
+		if replace {
+			if _, err := tx.ExecContext(ctx, getDeleteDefenderHostsQuery()); err != nil {
+				providerLog(logger.LevelError, "unable to delete defender hosts: %v", err)
+				return err
+			}
+		}
+		for idx := range hosts {
+			host := &hosts[idx]
+			if err := sqlCommonAddDefenderHost(ctx, host.IP, tx); err != nil {
+				return err
+			}
+			if host.Score > 0 {
+				if err := sqlCommonAddDefenderEvent(ctx, host.IP, host.Score, tx); err != nil {
+					return err
+				}
+			}
+			if !host.BanTime.IsZero() {
+				_, err := tx.ExecContext(ctx, getDefenderSetBanTimeQuery(), util.GetTimeAsMsSinceEpoch(host.BanTime), host.IP)
+				if err != nil {
+					providerLog(logger.LevelError, "error setting ban time for ip %q: %v", host.IP, err)
+					return err
+				}
+			}
+		}
+		providerLog(logger.LevelDebug, "defender hosts imported, hosts: %d, replace: %t", len(hosts), replace)
+		return nil
+	})
+}
+
 func sqlCommonDefenderCleanup(from int64, dbHandler *sql.DB) error {
 	if err := sqlCommonCleanupDefenderEvents(from, dbHandler); err != nil {
 		return err
@@ -2072,10 +2374,10 @@ func getShareFromDbRow(row sqlScanner) (Share, error) {
 func getAPIKeyFromDbRow(row sqlScanner) (APIKey, error) {
 	var apiKey APIKey
 	var userID, adminID sql.NullInt64
-	var description sql.NullString
+	var description, permissions, usernamePrefix sql.NullString
 
 	err := row.Scan(&apiKey.KeyID, &apiKey.Name, &apiKey.Key, &apiKey.Scope, &apiKey.CreatedAt, &apiKey.UpdatedAt,
-		&apiKey.LastUseAt, &apiKey.ExpiresAt, &description, &userID, &adminID)
+		&apiKey.LastUseAt, &apiKey.ExpiresAt, &description, &userID, &adminID, &permissions, &usernamePrefix)
 
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -2093,10 +2395,31 @@ func getAPIKeyFromDbRow(row sqlScanner) (APIKey, error) {
 	if description.Valid {
 		apiKey.Description = description.String
 	}
+	if usernamePrefix.Valid {
+		apiKey.UsernamePrefix = usernamePrefix.String
+	}
+	if permissions.Valid && permissions.String != "" {
+		var perms []string
+		if err := json.Unmarshal([]byte(permissions.String), &perms); err != nil {
+			return apiKey, err
+		}
+		apiKey.Permissions = perms
+	}
 
 	return apiKey, nil
 }
 
+func getAPIKeyPermissionsAsJSON(apiKey *APIKey) sql.NullString {
+	if len(apiKey.Permissions) == 0 {
+		return sql.NullString{}
+	}
+	data, err := json.Marshal(apiKey.Permissions)
+	if err != nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: string(data), Valid: true}
+}
+
 func getAdminFromDbRow(row sqlScanner) (Admin, error) {
 	var admin Admin
 	var email, additionalInfo, description, role sql.NullString
@@ -2226,6 +2549,29 @@ func getRoleFromDbRow(row sqlScanner) (Role, error) {
 	return role, nil
 }
 
+func getUserTemplateFromDbRow(row sqlScanner) (UserTemplate, error) {
+	var template UserTemplate
+	var description sql.NullString
+	var userSettings []byte
+
+	err := row.Scan(&template.ID, &template.Name, &description, &template.CreatedAt, &template.UpdatedAt, &userSettings)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return template, util.NewRecordNotFoundError(err.Error())
+		}
+		return template, err
+	}
+	if description.Valid {
+		template.Description = description.String
+	}
+	if err := json.Unmarshal(userSettings, &template.User); err != nil {
+		return template, err
+	}
+	template.SetEmptySecretsIfNil()
+
+	return template, nil
+}
+
 func getGroupFromDbRow(row sqlScanner) (Group, error) {
 	var group Group
 	var description sql.NullString
@@ -2262,7 +2608,7 @@ func getUserFromDbRow(row sqlScanner) (User, error) {
 		&user.UploadBandwidth, &user.DownloadBandwidth, &user.ExpirationDate, &user.LastLogin, &user.Status, &filters, &fsConfig,
 		&additionalInfo, &description, &email, &user.CreatedAt, &user.UpdatedAt, &user.UploadDataTransfer, &user.DownloadDataTransfer,
 		&user.TotalDataTransfer, &user.UsedUploadDataTransfer, &user.UsedDownloadDataTransfer, &user.DeletedAt, &user.FirstDownload,
-		&user.FirstUpload, &role, &user.LastPasswordChange)
+		&user.FirstUpload, &role, &user.LastPasswordChange, &user.UsedLogins)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return user, util.NewRecordNotFoundError(err.Error())
@@ -2282,10 +2628,10 @@ func getUserFromDbRow(row sqlScanner) (User, error) {
 	// we can have a empty string or an invalid json in null string
 	// so we do a relaxed test if the field is optional, for example we
 	// populate public keys only if unmarshal does not return an error
-	var pKeys []string
+	var pKeys []UserPublicKey
 	err = json.Unmarshal(publicKey, &pKeys)
 	if err == nil {
-		user.PublicKeys = pKeys
+		user.setPublicKeys(pKeys)
 	}
 	var userFilters UserFilters
 	err = json.Unmarshal(filters, &userFilters)
@@ -2317,10 +2663,11 @@ func sqlCommonGetFolder(ctx context.Context, name string, dbHandle sqlQuerier) (
 	var folder vfs.BaseVirtualFolder
 	q := getFolderByNameQuery()
 	row := dbHandle.QueryRowContext(ctx, q, name)
-	var mappedPath, description sql.NullString
+	var mappedPath, description, retention, quotaScan, extraction, transform sql.NullString
 	var fsConfig []byte
 	err := row.Scan(&folder.ID, &mappedPath, &folder.UsedQuotaSize, &folder.UsedQuotaFiles, &folder.LastQuotaUpdate,
-		&folder.Name, &description, &fsConfig)
+		&folder.Name, &description, &fsConfig, &retention, &quotaScan,
+		&folder.MaxUploadFileSize, &extraction, &transform, &folder.SetstatMode)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return folder, util.NewRecordNotFoundError(err.Error())
@@ -2333,6 +2680,10 @@ func sqlCommonGetFolder(ctx context.Context, name string, dbHandle sqlQuerier) (
 	if description.Valid {
 		folder.Description = description.String
 	}
+	setFolderRetention(&folder, retention)
+	setFolderQuotaScan(&folder, quotaScan)
+	setFolderExtraction(&folder, extraction)
+	setFolderTransform(&folder, transform)
 	var fs vfs.Filesystem
 	err = json.Unmarshal(fsConfig, &fs)
 	if err == nil {
@@ -2341,6 +2692,111 @@ func sqlCommonGetFolder(ctx context.Context, name string, dbHandle sqlQuerier) (
 	return folder, err
 }
 
+func getFolderRetentionAsJSON(folder *vfs.BaseVirtualFolder) sql.NullString {
+	if !folder.Retention.IsEnabled() {
+		return sql.NullString{}
+	}
+	data, err := json.Marshal(folder.Retention)
+	if err != nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: string(data), Valid: true}
+}
+
+func getFolderExtractionAsJSON(folder *vfs.BaseVirtualFolder) sql.NullString {
+	if !folder.Extraction.IsEnabled() {
+		return sql.NullString{}
+	}
+	data, err := json.Marshal(folder.Extraction)
+	if err != nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: string(data), Valid: true}
+}
+
+func getFolderTransformAsJSON(folder *vfs.BaseVirtualFolder) sql.NullString {
+	if !folder.Transform.IsEnabled() {
+		return sql.NullString{}
+	}
+	data, err := json.Marshal(folder.Transform)
+	if err != nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: string(data), Valid: true}
+}
+
+func getFolderPermissionsAsJSON(folder *vfs.VirtualFolder) sql.NullString {
+	if len(folder.Permissions) == 0 {
+		return sql.NullString{}
+	}
+	data, err := json.Marshal(folder.Permissions)
+	if err != nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: string(data), Valid: true}
+}
+
+func setFolderPermissions(folder *vfs.VirtualFolder, permissions sql.NullString) {
+	if !permissions.Valid || permissions.String == "" {
+		return
+	}
+	var perms []string
+	if err := json.Unmarshal([]byte(permissions.String), &perms); err == nil {
+		folder.Permissions = perms
+	}
+}
+
+func setFolderRetention(folder *vfs.BaseVirtualFolder, retention sql.NullString) {
+	if !retention.Valid || retention.String == "" {
+		return
+	}
+	var r vfs.FolderRetention
+	if err := json.Unmarshal([]byte(retention.String), &r); err == nil {
+		folder.Retention = r
+	}
+}
+
+func setFolderExtraction(folder *vfs.BaseVirtualFolder, extraction sql.NullString) {
+	if !extraction.Valid {
+		return
+	}
+	var e vfs.FolderExtraction
+	if err := json.Unmarshal([]byte(extraction.String), &e); err == nil {
+		folder.Extraction = e
+	}
+}
+
+func setFolderTransform(folder *vfs.BaseVirtualFolder, transform sql.NullString) {
+	if !transform.Valid {
+		return
+	}
+	var t vfs.FolderTransform
+	if err := json.Unmarshal([]byte(transform.String), &t); err == nil {
+		folder.Transform = t
+	}
+}
+
+func getFolderQuotaScanAsJSON(scan *vfs.FolderQuotaScan) sql.NullString {
+	if scan == nil {
+		return sql.NullString{}
+	}
+	data, err := json.Marshal(scan)
+	if err != nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: string(data), Valid: true}
+}
+
+func setFolderQuotaScan(folder *vfs.BaseVirtualFolder, quotaScan sql.NullString) {
+	if !quotaScan.Valid || quotaScan.String == "" {
+		return
+	}
+	var scan vfs.FolderQuotaScan
+	if err := json.Unmarshal([]byte(quotaScan.String), &scan); err == nil {
+		folder.QuotaScan = &scan
+	}
+}
+
 func sqlCommonGetFolderByName(ctx context.Context, name string, dbHandle sqlQuerier) (vfs.BaseVirtualFolder, error) {
 	folder, err := sqlCommonGetFolder(ctx, name, dbHandle)
 	if err != nil {
@@ -2363,7 +2819,7 @@ func sqlCommonGetFolderByName(ctx context.Context, name string, dbHandle sqlQuer
 	return folders[0], nil
 }
 
-func sqlCommonAddFolder(folder *vfs.BaseVirtualFolder, dbHandle sqlQuerier) error {
+func sqlCommonAddFolder(folder *vfs.BaseVirtualFolder, change *ObjectChange, dbHandle *sql.DB) error {
 	err := ValidateFolder(folder)
 	if err != nil {
 		return err
@@ -2375,13 +2831,20 @@ func sqlCommonAddFolder(folder *vfs.BaseVirtualFolder, dbHandle sqlQuerier) erro
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 
-	q := getAddFolderQuery()
-	_, err = dbHandle.ExecContext(ctx, q, folder.MappedPath, folder.UsedQuotaSize, folder.UsedQuotaFiles,
-		folder.LastQuotaUpdate, folder.Name, folder.Description, fsConfig)
-	return err
+	return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
+		q := getAddFolderQuery()
+		_, err := tx.ExecContext(ctx, q, folder.MappedPath, folder.UsedQuotaSize, folder.UsedQuotaFiles,
+			folder.LastQuotaUpdate, folder.Name, folder.Description, fsConfig, getFolderRetentionAsJSON(folder),
+			getFolderQuotaScanAsJSON(folder.QuotaScan), folder.MaxUploadFileSize, getFolderExtractionAsJSON(folder),
+			getFolderTransformAsJSON(folder), folder.SetstatMode)
+		if err != nil {
+			return err
+		}
+		return sqlCommonAddObjectChange(ctx, change, tx)
+	})
 }
 
-func sqlCommonUpdateFolder(folder *vfs.BaseVirtualFolder, dbHandle sqlQuerier) error {
+func sqlCommonUpdateFolder(folder *vfs.BaseVirtualFolder, change *ObjectChange, dbHandle *sql.DB) error {
 	err := ValidateFolder(folder)
 	if err != nil {
 		return err
@@ -2393,24 +2856,36 @@ func sqlCommonUpdateFolder(folder *vfs.BaseVirtualFolder, dbHandle sqlQuerier) e
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 
-	q := getUpdateFolderQuery()
-	res, err := dbHandle.ExecContext(ctx, q, folder.MappedPath, folder.Description, fsConfig, folder.Name)
-	if err != nil {
-		return err
-	}
-	return sqlCommonRequireRowAffected(res)
+	return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
+		q := getUpdateFolderQuery()
+		res, err := tx.ExecContext(ctx, q, folder.MappedPath, folder.Description, fsConfig,
+			getFolderRetentionAsJSON(folder), folder.MaxUploadFileSize, getFolderExtractionAsJSON(folder),
+			getFolderTransformAsJSON(folder), folder.SetstatMode, folder.Name)
+		if err != nil {
+			return err
+		}
+		if err := sqlCommonRequireRowAffected(res); err != nil {
+			return err
+		}
+		return sqlCommonAddObjectChange(ctx, change, tx)
+	})
 }
 
-func sqlCommonDeleteFolder(folder vfs.BaseVirtualFolder, dbHandle sqlQuerier) error {
+func sqlCommonDeleteFolder(folder vfs.BaseVirtualFolder, change *ObjectChange, dbHandle *sql.DB) error {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 
-	q := getDeleteFolderQuery()
-	res, err := dbHandle.ExecContext(ctx, q, folder.Name)
-	if err != nil {
-		return err
-	}
-	return sqlCommonRequireRowAffected(res)
+	return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
+		q := getDeleteFolderQuery()
+		res, err := tx.ExecContext(ctx, q, folder.Name)
+		if err != nil {
+			return err
+		}
+		if err := sqlCommonRequireRowAffected(res); err != nil {
+			return err
+		}
+		return sqlCommonAddObjectChange(ctx, change, tx)
+	})
 }
 
 func sqlCommonDumpFolders(dbHandle sqlQuerier) ([]vfs.BaseVirtualFolder, error) {
@@ -2426,10 +2901,11 @@ func sqlCommonDumpFolders(dbHandle sqlQuerier) ([]vfs.BaseVirtualFolder, error)
 	defer rows.Close()
 	for rows.Next() {
 		var folder vfs.BaseVirtualFolder
-		var mappedPath, description sql.NullString
+		var mappedPath, description, retention, quotaScan, extraction, transform sql.NullString
 		var fsConfig []byte
 		err = rows.Scan(&folder.ID, &mappedPath, &folder.UsedQuotaSize, &folder.UsedQuotaFiles,
-			&folder.LastQuotaUpdate, &folder.Name, &description, &fsConfig)
+			&folder.LastQuotaUpdate, &folder.Name, &description, &fsConfig, &retention, &quotaScan,
+			&folder.MaxUploadFileSize, &extraction, &transform, &folder.SetstatMode)
 		if err != nil {
 			return folders, err
 		}
@@ -2439,6 +2915,10 @@ func sqlCommonDumpFolders(dbHandle sqlQuerier) ([]vfs.BaseVirtualFolder, error)
 		if description.Valid {
 			folder.Description = description.String
 		}
+		setFolderRetention(&folder, retention)
+		setFolderQuotaScan(&folder, quotaScan)
+		setFolderExtraction(&folder, extraction)
+		setFolderTransform(&folder, transform)
 		var fs vfs.Filesystem
 		err = json.Unmarshal(fsConfig, &fs)
 		if err == nil {
@@ -2468,10 +2948,11 @@ func sqlCommonGetFolders(limit, offset int, order string, minimal bool, dbHandle
 				return folders, err
 			}
 		} else {
-			var mappedPath, description sql.NullString
+			var mappedPath, description, retention, quotaScan, extraction, transform sql.NullString
 			var fsConfig []byte
 			err = rows.Scan(&folder.ID, &mappedPath, &folder.UsedQuotaSize, &folder.UsedQuotaFiles,
-				&folder.LastQuotaUpdate, &folder.Name, &description, &fsConfig)
+				&folder.LastQuotaUpdate, &folder.Name, &description, &fsConfig, &retention, &quotaScan,
+				&folder.MaxUploadFileSize, &extraction, &transform, &folder.SetstatMode)
 			if err != nil {
 				return folders, err
 			}
@@ -2481,6 +2962,10 @@ func sqlCommonGetFolders(limit, offset int, order string, minimal bool, dbHandle
 			if description.Valid {
 				folder.Description = description.String
 			}
+			setFolderRetention(&folder, retention)
+			setFolderQuotaScan(&folder, quotaScan)
+			setFolderExtraction(&folder, extraction)
+			setFolderTransform(&folder, transform)
 			var fs vfs.Filesystem
 			err = json.Unmarshal(fsConfig, &fs)
 			if err == nil {
@@ -2525,7 +3010,8 @@ func sqlCommonClearUserGroupMapping(ctx context.Context, user *User, dbHandle sq
 
 func sqlCommonAddUserFolderMapping(ctx context.Context, user *User, folder *vfs.VirtualFolder, dbHandle sqlQuerier) error {
 	q := getAddUserFolderMappingQuery()
-	_, err := dbHandle.ExecContext(ctx, q, folder.VirtualPath, folder.QuotaSize, folder.QuotaFiles, folder.Name, user.Username)
+	_, err := dbHandle.ExecContext(ctx, q, folder.VirtualPath, folder.QuotaSize, folder.QuotaFiles,
+		getFolderPermissionsAsJSON(folder), folder.Name, user.Username)
 	return err
 }
 
@@ -2537,7 +3023,8 @@ func sqlCommonClearAdminGroupMapping(ctx context.Context, admin *Admin, dbHandle
 
 func sqlCommonAddGroupFolderMapping(ctx context.Context, group *Group, folder *vfs.VirtualFolder, dbHandle sqlQuerier) error {
 	q := getAddGroupFolderMappingQuery()
-	_, err := dbHandle.ExecContext(ctx, q, folder.VirtualPath, folder.QuotaSize, folder.QuotaFiles, folder.Name, group.Name)
+	_, err := dbHandle.ExecContext(ctx, q, folder.VirtualPath, folder.QuotaSize, folder.QuotaFiles,
+		getFolderPermissionsAsJSON(folder), folder.Name, group.Name)
 	return err
 }
 
@@ -2742,11 +3229,11 @@ func getUsersWithVirtualFolders(ctx context.Context, users []User, dbHandle sqlQ
 	for rows.Next() {
 		var folder vfs.VirtualFolder
 		var userID int64
-		var mappedPath, description sql.NullString
+		var mappedPath, description, permissions, extraction, transform sql.NullString
 		var fsConfig []byte
 		err = rows.Scan(&folder.ID, &folder.Name, &mappedPath, &folder.UsedQuotaSize, &folder.UsedQuotaFiles,
-			&folder.LastQuotaUpdate, &folder.VirtualPath, &folder.QuotaSize, &folder.QuotaFiles, &userID, &fsConfig,
-			&description)
+			&folder.LastQuotaUpdate, &folder.VirtualPath, &folder.QuotaSize, &folder.QuotaFiles, &permissions, &userID,
+			&fsConfig, &description, &folder.MaxUploadFileSize, &extraction, &transform, &folder.SetstatMode)
 		if err != nil {
 			return users, err
 		}
@@ -2756,6 +3243,9 @@ func getUsersWithVirtualFolders(ctx context.Context, users []User, dbHandle sqlQ
 		if description.Valid {
 			folder.Description = description.String
 		}
+		setFolderPermissions(&folder, permissions)
+		setFolderExtraction(&folder.BaseVirtualFolder, extraction)
+		setFolderTransform(&folder.BaseVirtualFolder, transform)
 		var fs vfs.Filesystem
 		err = json.Unmarshal(fsConfig, &fs)
 		if err == nil {
@@ -2893,11 +3383,11 @@ func getGroupsWithVirtualFolders(ctx context.Context, groups []Group, dbHandle s
 	for rows.Next() {
 		var groupID int64
 		var folder vfs.VirtualFolder
-		var mappedPath, description sql.NullString
+		var mappedPath, description, permissions, extraction, transform sql.NullString
 		var fsConfig []byte
 		err = rows.Scan(&folder.ID, &folder.Name, &mappedPath, &folder.UsedQuotaSize, &folder.UsedQuotaFiles,
-			&folder.LastQuotaUpdate, &folder.VirtualPath, &folder.QuotaSize, &folder.QuotaFiles, &groupID, &fsConfig,
-			&description)
+			&folder.LastQuotaUpdate, &folder.VirtualPath, &folder.QuotaSize, &folder.QuotaFiles, &permissions, &groupID,
+			&fsConfig, &description, &folder.MaxUploadFileSize, &extraction, &transform, &folder.SetstatMode)
 		if err != nil {
 			return groups, err
 		}
@@ -2907,6 +3397,9 @@ func getGroupsWithVirtualFolders(ctx context.Context, groups []Group, dbHandle s
 		if description.Valid {
 			folder.Description = description.String
 		}
+		setFolderPermissions(&folder, permissions)
+		setFolderExtraction(&folder.BaseVirtualFolder, extraction)
+		setFolderTransform(&folder.BaseVirtualFolder, transform)
 		var fs vfs.Filesystem
 		err = json.Unmarshal(fsConfig, &fs)
 		if err == nil {
@@ -3152,6 +3645,22 @@ func sqlCommonUpdateFolderQuota(name string, filesAdd int, sizeAdd int64, reset
 	return err
 }
 
+func sqlCommonUpdateFolderQuotaScan(name string, scan vfs.FolderQuotaScan, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getUpdateFolderQuotaScanQuery()
+	res, err := dbHandle.ExecContext(ctx, q, scan.ScannedSize, scan.ScannedFiles, scan.LastScan,
+		getFolderQuotaScanAsJSON(&scan), name)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error updating quota scan for folder %q: %v", name, err)
+		return err
+	}
+	providerLog(logger.LevelDebug, "quota scan updated for folder %q, files: %d, size: %d, drift files: %d, drift size: %d",
+		name, scan.ScannedFiles, scan.ScannedSize, scan.DriftFiles, scan.DriftSize)
+	return sqlCommonRequireRowAffected(res)
+}
+
 func sqlCommonGetFolderUsedQuota(mappedPath string, dbHandle *sql.DB) (int, int64, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
@@ -3283,6 +3792,38 @@ func sqlCommonGetSession(key string, sessionType SessionType, dbHandle sqlQuerie
 	return session, nil
 }
 
+// sqlCommonReplaceSession inserts the session if previousData is nil or
+// updates it only if the stored data are unchanged. Concurrent updates of the
+// same session are serialized by the database, the last ones will not match
+func sqlCommonReplaceSession(session Session, previousData []byte, dbHandle *sql.DB) (bool, error) {
+	if err := session.validate(); err != nil {
+		return false, err
+	}
+	data, err := json.Marshal(session.Data)
+	if err != nil {
+		return false, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	var res sql.Result
+	if previousData == nil {
+		q := getAddSessionIfMissingQuery()
+		res, err = dbHandle.ExecContext(ctx, q, session.Key, data, session.Type, session.Timestamp)
+	} else {
+		q := getReplaceSessionQuery()
+		res, err = dbHandle.ExecContext(ctx, q, data, session.Timestamp, session.Key, session.Type, previousData)
+	}
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
 func sqlCommonDeleteSession(key string, sessionType SessionType, dbHandle *sql.DB) error {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
@@ -3766,24 +4307,24 @@ func sqlCommonAddTask(name string, dbHandle *sql.DB) error {
 	return err
 }
 
-func sqlCommonUpdateTask(name string, version int64, dbHandle *sql.DB) error {
+func sqlCommonUpdateTask(name string, version, updatedAt int64, dbHandle *sql.DB) error {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 
 	q := getUpdateTaskQuery()
-	res, err := dbHandle.ExecContext(ctx, q, util.GetTimeAsMsSinceEpoch(time.Now()), name, version)
+	res, err := dbHandle.ExecContext(ctx, q, updatedAt, name, version)
 	if err != nil {
 		return err
 	}
 	return sqlCommonRequireRowAffected(res)
 }
 
-func sqlCommonUpdateTaskTimestamp(name string, dbHandle *sql.DB) error {
+func sqlCommonUpdateTaskTimestamp(name string, updatedAt int64, dbHandle *sql.DB) error {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 
 	q := getUpdateTaskTimestampQuery()
-	res, err := dbHandle.ExecContext(ctx, q, util.GetTimeAsMsSinceEpoch(time.Now()), name)
+	res, err := dbHandle.ExecContext(ctx, q, updatedAt, name)
 	if err != nil {
 		return err
 	}
@@ -3928,6 +4469,130 @@ func sqlCommonSetConfigs(configs *Configs, dbHandle *sql.DB) error {
 	return sqlCommonRequireRowAffected(res)
 }
 
+func sqlCommonAddLoginEvents(events []LoginEvent, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), longSQLQueryTimeout)
+	defer cancel()
+
+	return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
+		stmt, err := tx.PrepareContext(ctx, getAddLoginEventQuery())
+		if err != nil {
+			providerLog(logger.LevelError, "error preparing database query for login events: %v", err)
+			return err
+		}
+		defer stmt.Close()
+
+		for idx := range events {
+			e := &events[idx]
+			_, err := stmt.ExecContext(ctx, e.Timestamp, e.Username, e.IP, e.Protocol, e.LoginMethod,
+				e.ClientVersion, e.Status, e.Error)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func sqlCommonGetLoginEvents(filter LoginEventFilter, dbHandle sqlQuerier) ([]LoginEvent, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), longSQLQueryTimeout)
+	defer cancel()
+
+	q, args := getLoginEventsQuery(filter)
+	rows, err := dbHandle.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := make([]LoginEvent, 0, filter.Limit)
+	for rows.Next() {
+		var e LoginEvent
+		var clientVersion, errorString sql.NullString
+		err = rows.Scan(&e.ID, &e.Timestamp, &e.Username, &e.IP, &e.Protocol, &e.LoginMethod, &clientVersion,
+			&e.Status, &errorString)
+		if err != nil {
+			return events, err
+		}
+		if clientVersion.Valid {
+			e.ClientVersion = clientVersion.String
+		}
+		if errorString.Valid {
+			e.Error = errorString.String
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// sqlCommonAddObjectChange stores the change, if any, within the transaction of the
+// mutation and sets the assigned sequence identifier.
+// The sequence identifier is assigned at insert time while the row becomes visible
+// at commit time, so concurrent transactions could commit out of order and a reader
+// could skip a change. The lock row is updated before inserting the change, so the
+// identifiers are assigned and committed in the same order
+func sqlCommonAddObjectChange(ctx context.Context, change *ObjectChange, dbHandle sqlQuerier) error {
+	if change == nil {
+		return nil
+	}
+	if _, err := dbHandle.ExecContext(ctx, getLockObjectChangesQuery()); err != nil {
+		return err
+	}
+	q := getAddObjectChangeQuery()
+	if config.Driver == MySQLDataProviderName {
+		res, err := dbHandle.ExecContext(ctx, q, change.Timestamp, change.ObjectType, change.ObjectName,
+			change.Action, change.Actor)
+		if err != nil {
+			return err
+		}
+		change.ID, err = res.LastInsertId()
+		return err
+	}
+	row := dbHandle.QueryRowContext(ctx, q, change.Timestamp, change.ObjectType, change.ObjectName,
+		change.Action, change.Actor)
+	return row.Scan(&change.ID)
+}
+
+func sqlCommonGetObjectChanges(sinceID int64, limit int, dbHandle sqlQuerier) ([]ObjectChange, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getObjectChangesQuery()
+	rows, err := dbHandle.QueryContext(ctx, q, sinceID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := make([]ObjectChange, 0, limit)
+	for rows.Next() {
+		var c ObjectChange
+		err = rows.Scan(&c.ID, &c.Timestamp, &c.ObjectType, &c.ObjectName, &c.Action, &c.Actor)
+		if err != nil {
+			return changes, err
+		}
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
+
+func sqlCommonCleanupObjectChanges(before int64, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), longSQLQueryTimeout)
+	defer cancel()
+
+	q := getObjectChangesCleanupQuery()
+	_, err := dbHandle.ExecContext(ctx, q, before)
+	return err
+}
+
+func sqlCommonCleanupLoginEvents(before int64, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), longSQLQueryTimeout)
+	defer cancel()
+
+	q := getLoginEventsCleanupQuery()
+	_, err := dbHandle.ExecContext(ctx, q, before)
+	return err
+}
+
 func sqlCommonGetDatabaseVersion(dbHandle sqlQuerier, showInitWarn bool) (schemaVersion, error) {
 	var result schemaVersion
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
@@ -57,6 +57,11 @@ DROP TABLE IF EXISTS "{{tasks}}";
 DROP TABLE IF EXISTS "{{roles}}";
 DROP TABLE IF EXISTS "{{ip_lists}}";
 DROP TABLE IF EXISTS "{{configs}}";
+DROP TABLE IF EXISTS "{{login_events}}";
+DROP TABLE IF EXISTS "{{transfer_windows}}";
+DROP TABLE IF EXISTS "{{user_templates}}";
+DROP TABLE IF EXISTS "{{object_changes}}";
+DROP TABLE IF EXISTS "{{object_changes_lock}}";
 DROP TABLE IF EXISTS "{{schema_version}}";
 `
 	sqliteInitialSQL = `CREATE TABLE "{{schema_version}}" ("id" integer NOT NULL PRIMARY KEY, "version" integer NOT NULL);
@@ -194,6 +199,54 @@ CREATE TABLE "{{shared_sessions}}" ("key" varchar(128) NOT NULL PRIMARY KEY, "da
 CREATE INDEX "{{prefix}}shared_sessions_type_idx" ON "{{shared_sessions}}" ("type");
 CREATE INDEX "{{prefix}}shared_sessions_timestamp_idx" ON "{{shared_sessions}}" ("timestamp");
 `
+	sqliteV33SQL = `CREATE TABLE "{{login_events}}" ("id" integer NOT NULL PRIMARY KEY, "timestamp" bigint NOT NULL,
+"username" varchar(255) NOT NULL, "ip" varchar(50) NOT NULL, "protocol" varchar(30) NOT NULL,
+"login_method" varchar(64) NOT NULL, "client_version" varchar(255) NULL, "status" integer NOT NULL,
+"error" varchar(1024) NULL);
+CREATE INDEX "{{prefix}}login_events_timestamp_idx" ON "{{login_events}}" ("timestamp");
+CREATE INDEX "{{prefix}}login_events_username_idx" ON "{{login_events}}" ("username");
+CREATE INDEX "{{prefix}}login_events_ip_idx" ON "{{login_events}}" ("ip");
+`
+	sqliteV33DownSQL = `DROP TABLE "{{login_events}}";`
+	sqliteV34SQL     = `CREATE TABLE "{{transfer_windows}}" ("username" varchar(255) NOT NULL PRIMARY KEY,
+"window_start" bigint NOT NULL, "window_end" bigint NOT NULL, "used_size" bigint NOT NULL, "notified" integer NOT NULL);
+CREATE INDEX "{{prefix}}transfer_windows_window_end_idx" ON "{{transfer_windows}}" ("window_end");
+`
+	sqliteV34DownSQL = `DROP TABLE "{{transfer_windows}}";`
+	sqliteV35SQL     = `ALTER TABLE "{{folders}}" ADD COLUMN "retention" text NULL;`
+	sqliteV35DownSQL = `ALTER TABLE "{{folders}}" DROP COLUMN "retention";`
+	sqliteV36SQL     = `ALTER TABLE "{{users_folders_mapping}}" ADD COLUMN "permissions" text NULL;
+ALTER TABLE "{{groups_folders_mapping}}" ADD COLUMN "permissions" text NULL;`
+	sqliteV36DownSQL = `ALTER TABLE "{{groups_folders_mapping}}" DROP COLUMN "permissions";
+ALTER TABLE "{{users_folders_mapping}}" DROP COLUMN "permissions";`
+	sqliteV37SQL     = `ALTER TABLE "{{folders}}" ADD COLUMN "quota_scan" text NULL;`
+	sqliteV37DownSQL = `ALTER TABLE "{{folders}}" DROP COLUMN "quota_scan";`
+	sqliteV38SQL     = `ALTER TABLE "{{folders}}" ADD COLUMN "max_upload_file_size" bigint DEFAULT 0 NOT NULL;`
+	sqliteV38DownSQL = `ALTER TABLE "{{folders}}" DROP COLUMN "max_upload_file_size";`
+	sqliteV39SQL     = `ALTER TABLE "{{folders}}" ADD COLUMN "extraction" text NULL;`
+	sqliteV39DownSQL = `ALTER TABLE "{{folders}}" DROP COLUMN "extraction";`
+	sqliteV40SQL     = `ALTER TABLE "{{folders}}" ADD COLUMN "transform" text NULL;`
+	sqliteV40DownSQL = `ALTER TABLE "{{folders}}" DROP COLUMN "transform";`
+	sqliteV41SQL     = `ALTER TABLE "{{folders}}" ADD COLUMN "setstat_mode" integer DEFAULT 0 NOT NULL;`
+	sqliteV41DownSQL = `ALTER TABLE "{{folders}}" DROP COLUMN "setstat_mode";`
+	sqliteV42SQL     = `ALTER TABLE "{{users}}" ADD COLUMN "used_logins" integer DEFAULT 0 NOT NULL;`
+	sqliteV42DownSQL = `ALTER TABLE "{{users}}" DROP COLUMN "used_logins";`
+	sqliteV43SQL     = `CREATE TABLE "{{user_templates}}" ("id" integer NOT NULL PRIMARY KEY, "name" varchar(255) NOT NULL UNIQUE,
+"description" varchar(512) NULL, "user_settings" text NOT NULL, "created_at" bigint NOT NULL, "updated_at" bigint NOT NULL);`
+	sqliteV43DownSQL = `DROP TABLE "{{user_templates}}";`
+	sqliteV44SQL     = `CREATE TABLE "{{object_changes}}" ("id" integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+"timestamp" bigint NOT NULL, "object_type" varchar(50) NOT NULL, "object_name" varchar(255) NOT NULL,
+"action" varchar(20) NOT NULL, "actor" varchar(255) NOT NULL);
+CREATE INDEX "{{prefix}}object_changes_timestamp_idx" ON "{{object_changes}}" ("timestamp");
+`
+	sqliteV44DownSQL = `DROP TABLE "{{object_changes}}";`
+	sqliteV45SQL     = `ALTER TABLE "{{api_keys}}" ADD COLUMN "permissions" text NULL;
+ALTER TABLE "{{api_keys}}" ADD COLUMN "username_prefix" varchar(255) NULL;`
+	sqliteV45DownSQL = `ALTER TABLE "{{api_keys}}" DROP COLUMN "username_prefix";
+ALTER TABLE "{{api_keys}}" DROP COLUMN "permissions";`
+	sqliteV46SQL = `CREATE TABLE "{{object_changes_lock}}" ("id" integer NOT NULL PRIMARY KEY, "counter" bigint NOT NULL);
+INSERT INTO "{{object_changes_lock}}" ("id", "counter") VALUES (1, 0);`
+	sqliteV46DownSQL = `DROP TABLE "{{object_changes_lock}}";`
 )
 
 // SQLiteProvider defines the auth provider for SQLite database
@@ -276,6 +329,14 @@ func (p *SQLiteProvider) updateLastLogin(username string) error {
 	return sqlCommonUpdateLastLogin(username, p.dbHandle)
 }
 
+func (p *SQLiteProvider) consumeUserLogin(username string, maxLogins int) error {
+	return sqlCommonConsumeUserLogin(username, maxLogins, p.dbHandle)
+}
+
+func (p *SQLiteProvider) updatePublicKeyLastUse(username, key string) error {
+	return sqlCommonUpdatePublicKeyLastUse(username, key, p.dbHandle)
+}
+
 func (p *SQLiteProvider) updateAdminLastLogin(username string) error {
 	return sqlCommonUpdateAdminLastLogin(username, p.dbHandle)
 }
@@ -284,16 +345,16 @@ func (p *SQLiteProvider) userExists(username, role string) (User, error) {
 	return sqlCommonGetUserByUsername(username, role, p.dbHandle)
 }
 
-func (p *SQLiteProvider) addUser(user *User) error {
-	return p.normalizeError(sqlCommonAddUser(user, p.dbHandle), fieldUsername)
+func (p *SQLiteProvider) addUser(user *User, change *ObjectChange) error {
+	return p.normalizeError(sqlCommonAddUser(user, change, p.dbHandle), fieldUsername)
 }
 
-func (p *SQLiteProvider) updateUser(user *User) error {
-	return p.normalizeError(sqlCommonUpdateUser(user, p.dbHandle), -1)
+func (p *SQLiteProvider) updateUser(user *User, change *ObjectChange) error {
+	return p.normalizeError(sqlCommonUpdateUser(user, change, p.dbHandle), -1)
 }
 
-func (p *SQLiteProvider) deleteUser(user User, softDelete bool) error {
-	return sqlCommonDeleteUser(user, softDelete, p.dbHandle)
+func (p *SQLiteProvider) deleteUser(user User, softDelete bool, change *ObjectChange) error {
+	return sqlCommonDeleteUser(user, softDelete, change, p.dbHandle)
 }
 
 func (p *SQLiteProvider) updateUserPassword(username, password string) error {
@@ -308,8 +369,14 @@ func (p *SQLiteProvider) getRecentlyUpdatedUsers(after int64) ([]User, error) {
 	return sqlCommonGetRecentlyUpdatedUsers(after, p.dbHandle)
 }
 
-func (p *SQLiteProvider) getUsers(limit int, offset int, order, role string) ([]User, error) {
-	return sqlCommonGetUsers(limit, offset, order, role, p.dbHandle)
+func (p *SQLiteProvider) getUsers(limit int, offset int, order, role, usernamePrefix string) ([]User, error) {
+	var users []User
+	err := executeOnReadReplica(replicaClassListings, p.dbHandle, func(dbHandle *sql.DB) error {
+		var err error
+		users, err = sqlCommonGetUsers(limit, offset, order, role, usernamePrefix, dbHandle)
+		return err
+	})
+	return users, err
 }
 
 func (p *SQLiteProvider) getUsersForQuotaCheck(toFetch map[string]bool) ([]User, error) {
@@ -321,7 +388,13 @@ func (p *SQLiteProvider) dumpFolders() ([]vfs.BaseVirtualFolder, error) {
 }
 
 func (p *SQLiteProvider) getFolders(limit, offset int, order string, minimal bool) ([]vfs.BaseVirtualFolder, error) {
-	return sqlCommonGetFolders(limit, offset, order, minimal, p.dbHandle)
+	var folders []vfs.BaseVirtualFolder
+	err := executeOnReadReplica(replicaClassListings, p.dbHandle, func(dbHandle *sql.DB) error {
+		var err error
+		folders, err = sqlCommonGetFolders(limit, offset, order, minimal, dbHandle)
+		return err
+	})
+	return folders, err
 }
 
 func (p *SQLiteProvider) getFolderByName(name string) (vfs.BaseVirtualFolder, error) {
@@ -330,28 +403,38 @@ func (p *SQLiteProvider) getFolderByName(name string) (vfs.BaseVirtualFolder, er
 	return sqlCommonGetFolderByName(ctx, name, p.dbHandle)
 }
 
-func (p *SQLiteProvider) addFolder(folder *vfs.BaseVirtualFolder) error {
-	return p.normalizeError(sqlCommonAddFolder(folder, p.dbHandle), fieldName)
+func (p *SQLiteProvider) addFolder(folder *vfs.BaseVirtualFolder, change *ObjectChange) error {
+	return p.normalizeError(sqlCommonAddFolder(folder, change, p.dbHandle), fieldName)
 }
 
-func (p *SQLiteProvider) updateFolder(folder *vfs.BaseVirtualFolder) error {
-	return sqlCommonUpdateFolder(folder, p.dbHandle)
+func (p *SQLiteProvider) updateFolder(folder *vfs.BaseVirtualFolder, change *ObjectChange) error {
+	return sqlCommonUpdateFolder(folder, change, p.dbHandle)
 }
 
-func (p *SQLiteProvider) deleteFolder(folder vfs.BaseVirtualFolder) error {
-	return sqlCommonDeleteFolder(folder, p.dbHandle)
+func (p *SQLiteProvider) deleteFolder(folder vfs.BaseVirtualFolder, change *ObjectChange) error {
+	return sqlCommonDeleteFolder(folder, change, p.dbHandle)
 }
 
 func (p *SQLiteProvider) updateFolderQuota(name string, filesAdd int, sizeAdd int64, reset bool) error {
 	return sqlCommonUpdateFolderQuota(name, filesAdd, sizeAdd, reset, p.dbHandle)
 }
 
+func (p *SQLiteProvider) updateFolderQuotaScan(name string, scan vfs.FolderQuotaScan) error {
+	return sqlCommonUpdateFolderQuotaScan(name, scan, p.dbHandle)
+}
+
 func (p *SQLiteProvider) getUsedFolderQuota(name string) (int, int64, error) {
 	return sqlCommonGetFolderUsedQuota(name, p.dbHandle)
 }
 
 func (p *SQLiteProvider) getGroups(limit, offset int, order string, minimal bool) ([]Group, error) {
-	return sqlCommonGetGroups(limit, offset, order, minimal, p.dbHandle)
+	var groups []Group
+	err := executeOnReadReplica(replicaClassListings, p.dbHandle, func(dbHandle *sql.DB) error {
+		var err error
+		groups, err = sqlCommonGetGroups(limit, offset, order, minimal, dbHandle)
+		return err
+	})
+	return groups, err
 }
 
 func (p *SQLiteProvider) getGroupsWithNames(names []string) ([]Group, error) {
@@ -366,16 +449,16 @@ func (p *SQLiteProvider) groupExists(name string) (Group, error) {
 	return sqlCommonGetGroupByName(name, p.dbHandle)
 }
 
-func (p *SQLiteProvider) addGroup(group *Group) error {
-	return p.normalizeError(sqlCommonAddGroup(group, p.dbHandle), fieldName)
+func (p *SQLiteProvider) addGroup(group *Group, change *ObjectChange) error {
+	return p.normalizeError(sqlCommonAddGroup(group, change, p.dbHandle), fieldName)
 }
 
-func (p *SQLiteProvider) updateGroup(group *Group) error {
-	return sqlCommonUpdateGroup(group, p.dbHandle)
+func (p *SQLiteProvider) updateGroup(group *Group, change *ObjectChange) error {
+	return sqlCommonUpdateGroup(group, change, p.dbHandle)
 }
 
-func (p *SQLiteProvider) deleteGroup(group Group) error {
-	return sqlCommonDeleteGroup(group, p.dbHandle)
+func (p *SQLiteProvider) deleteGroup(group Group, change *ObjectChange) error {
+	return sqlCommonDeleteGroup(group, change, p.dbHandle)
 }
 
 func (p *SQLiteProvider) dumpGroups() ([]Group, error) {
@@ -498,6 +581,10 @@ func (p *SQLiteProvider) cleanupDefender(from int64) error {
 	return sqlCommonDefenderCleanup(from, p.dbHandle)
 }
 
+func (p *SQLiteProvider) importDefenderHosts(hosts []DefenderEntry, replace bool) error {
+	return sqlCommonImportDefenderHosts(hosts, replace, p.dbHandle)
+}
+
 func (p *SQLiteProvider) addActiveTransfer(transfer ActiveTransfer) error {
 	return sqlCommonAddActiveTransfer(transfer, p.dbHandle)
 }
@@ -530,6 +617,10 @@ func (p *SQLiteProvider) getSharedSession(key string, sessionType SessionType) (
 	return sqlCommonGetSession(key, sessionType, p.dbHandle)
 }
 
+func (p *SQLiteProvider) replaceSharedSession(session Session, previousData []byte) (bool, error) {
+	return sqlCommonReplaceSession(session, previousData, p.dbHandle)
+}
+
 func (p *SQLiteProvider) cleanupSharedSessions(sessionType SessionType, before int64) error {
 	return sqlCommonCleanupSessions(sessionType, before, p.dbHandle)
 }
@@ -594,12 +685,16 @@ func (p *SQLiteProvider) addTask(name string) error {
 	return sqlCommonAddTask(name, p.dbHandle)
 }
 
-func (p *SQLiteProvider) updateTask(name string, version int64) error {
-	return sqlCommonUpdateTask(name, version, p.dbHandle)
+func (p *SQLiteProvider) updateTask(name string, version, updatedAt int64) error {
+	return sqlCommonUpdateTask(name, version, updatedAt, p.dbHandle)
 }
 
-func (p *SQLiteProvider) updateTaskTimestamp(name string) error {
-	return sqlCommonUpdateTaskTimestamp(name, p.dbHandle)
+func (p *SQLiteProvider) updateTaskTimestamp(name string, updatedAt int64) error {
+	return sqlCommonUpdateTaskTimestamp(name, updatedAt, p.dbHandle)
+}
+
+func (p *SQLiteProvider) deleteTask(name string) error {
+	return sqlCommonDeleteTask(name, p.dbHandle)
 }
 
 func (*SQLiteProvider) addNode() error {
@@ -646,6 +741,30 @@ func (p *SQLiteProvider) dumpRoles() ([]Role, error) {
 	return sqlCommonDumpRoles(p.dbHandle)
 }
 
+func (p *SQLiteProvider) userTemplateExists(name string) (UserTemplate, error) {
+	return sqlCommonGetUserTemplateByName(name, p.dbHandle)
+}
+
+func (p *SQLiteProvider) addUserTemplate(template *UserTemplate) error {
+	return p.normalizeError(sqlCommonAddUserTemplate(template, p.dbHandle), fieldName)
+}
+
+func (p *SQLiteProvider) updateUserTemplate(template *UserTemplate) error {
+	return sqlCommonUpdateUserTemplate(template, p.dbHandle)
+}
+
+func (p *SQLiteProvider) deleteUserTemplate(template UserTemplate) error {
+	return sqlCommonDeleteUserTemplate(template, p.dbHandle)
+}
+
+func (p *SQLiteProvider) getUserTemplates(limit int, offset int, order string) ([]UserTemplate, error) {
+	return sqlCommonGetUserTemplates(limit, offset, order, p.dbHandle)
+}
+
+func (p *SQLiteProvider) dumpUserTemplates() ([]UserTemplate, error) {
+	return sqlCommonDumpUserTemplates(p.dbHandle)
+}
+
 func (p *SQLiteProvider) ipListEntryExists(ipOrNet string, listType IPListType) (IPListEntry, error) {
 	return sqlCommonGetIPListEntry(ipOrNet, listType, p.dbHandle)
 }
@@ -690,6 +809,46 @@ func (p *SQLiteProvider) setConfigs(configs *Configs) error {
 	return sqlCommonSetConfigs(configs, p.dbHandle)
 }
 
+func (p *SQLiteProvider) updateTransferWindowUsage(username string, start, end, size int64) error {
+	return sqlCommonUpdateTransferWindowUsage(username, start, end, size, p.dbHandle)
+}
+
+func (p *SQLiteProvider) getTransferWindowUsage(username string) (transferWindowUsage, error) {
+	return sqlCommonGetTransferWindowUsage(username, p.dbHandle)
+}
+
+func (p *SQLiteProvider) setTransferWindowNotified(username string, start int64, threshold int) (bool, error) {
+	return sqlCommonSetTransferWindowNotified(username, start, threshold, p.dbHandle)
+}
+
+func (p *SQLiteProvider) cleanupTransferWindowUsages(before int64) error {
+	return sqlCommonCleanupTransferWindowUsages(before, p.dbHandle)
+}
+
+func (p *SQLiteProvider) deleteTransferWindowUsage(username string) error {
+	return sqlCommonDeleteTransferWindowUsage(username, p.dbHandle)
+}
+
+func (p *SQLiteProvider) addLoginEvents(events []LoginEvent) error {
+	return sqlCommonAddLoginEvents(events, p.dbHandle)
+}
+
+func (p *SQLiteProvider) getLoginEvents(filter LoginEventFilter) ([]LoginEvent, error) {
+	return sqlCommonGetLoginEvents(filter, p.dbHandle)
+}
+
+func (p *SQLiteProvider) cleanupLoginEvents(before int64) error {
+	return sqlCommonCleanupLoginEvents(before, p.dbHandle)
+}
+
+func (p *SQLiteProvider) getObjectChanges(sinceID int64, limit int) ([]ObjectChange, error) {
+	return sqlCommonGetObjectChanges(sinceID, limit, p.dbHandle)
+}
+
+func (p *SQLiteProvider) cleanupObjectChanges(before int64) error {
+	return sqlCommonCleanupObjectChanges(before, p.dbHandle)
+}
+
 func (p *SQLiteProvider) setFirstDownloadTimestamp(username string) error {
 	return sqlCommonSetFirstDownloadTimestamp(username, p.dbHandle)
 }
@@ -742,6 +901,34 @@ func (p *SQLiteProvider) migrateDatabase() error { //nolint:dupl
 		return updateSQLiteDatabaseFromV30(p.dbHandle)
 	case version == 31:
 		return updateSQLiteDatabaseFromV31(p.dbHandle)
+	case version == 32:
+		return updateSQLiteDatabaseFromV32(p.dbHandle)
+	case version == 33:
+		return updateSQLiteDatabaseFromV33(p.dbHandle)
+	case version == 34:
+		return updateSQLiteDatabaseFromV34(p.dbHandle)
+	case version == 35:
+		return updateSQLiteDatabaseFromV35(p.dbHandle)
+	case version == 36:
+		return updateSQLiteDatabaseFromV36(p.dbHandle)
+	case version == 37:
+		return updateSQLiteDatabaseFromV37(p.dbHandle)
+	case version == 38:
+		return updateSQLiteDatabaseFromV38(p.dbHandle)
+	case version == 39:
+		return updateSQLiteDatabaseFromV39(p.dbHandle)
+	case version == 40:
+		return updateSQLiteDatabaseFromV40(p.dbHandle)
+	case version == 41:
+		return updateSQLiteDatabaseFromV41(p.dbHandle)
+	case version == 42:
+		return updateSQLiteDatabaseFromV42(p.dbHandle)
+	case version == 43:
+		return updateSQLiteDatabaseFromV43(p.dbHandle)
+	case version == 44:
+		return updateSQLiteDatabaseFromV44(p.dbHandle)
+	case version == 45:
+		return updateSQLiteDatabaseFromV45(p.dbHandle)
 	default:
 		if version > sqlDatabaseVersion {
 			providerLog(logger.LevelError, "database schema version %d is newer than the supported one: %d", version,
@@ -770,6 +957,34 @@ func (p *SQLiteProvider) revertDatabase(targetVersion int) error {
 		return downgradeSQLiteDatabaseFromV31(p.dbHandle)
 	case 32:
 		return downgradeSQLiteDatabaseFromV32(p.dbHandle)
+	case 33:
+		return downgradeSQLiteDatabaseFromV33(p.dbHandle)
+	case 34:
+		return downgradeSQLiteDatabaseFromV34(p.dbHandle)
+	case 35:
+		return downgradeSQLiteDatabaseFromV35(p.dbHandle)
+	case 36:
+		return downgradeSQLiteDatabaseFromV36(p.dbHandle)
+	case 37:
+		return downgradeSQLiteDatabaseFromV37(p.dbHandle)
+	case 38:
+		return downgradeSQLiteDatabaseFromV38(p.dbHandle)
+	case 39:
+		return downgradeSQLiteDatabaseFromV39(p.dbHandle)
+	case 40:
+		return downgradeSQLiteDatabaseFromV40(p.dbHandle)
+	case 41:
+		return downgradeSQLiteDatabaseFromV41(p.dbHandle)
+	case 42:
+		return downgradeSQLiteDatabaseFromV42(p.dbHandle)
+	case 43:
+		return downgradeSQLiteDatabaseFromV43(p.dbHandle)
+	case 44:
+		return downgradeSQLiteDatabaseFromV44(p.dbHandle)
+	case 45:
+		return downgradeSQLiteDatabaseFromV45(p.dbHandle)
+	case 46:
+		return downgradeSQLiteDatabaseFromV46(p.dbHandle)
 	default:
 		return fmt.Errorf("database schema version not handled: %d", dbVersion.Version)
 	}
@@ -830,7 +1045,105 @@ func updateSQLiteDatabaseFromV30(dbHandle *sql.DB) error {
 }
 
 func updateSQLiteDatabaseFromV31(dbHandle *sql.DB) error {
-	return updateSQLDatabaseFrom31To32(dbHandle)
+	if err := updateSQLDatabaseFrom31To32(dbHandle); err != nil {
+		return err
+	}
+	return updateSQLiteDatabaseFromV32(dbHandle)
+}
+
+func updateSQLiteDatabaseFromV32(dbHandle *sql.DB) error {
+	if err := updateSQLiteDatabaseFrom32To33(dbHandle); err != nil {
+		return err
+	}
+	return updateSQLiteDatabaseFromV33(dbHandle)
+}
+
+func updateSQLiteDatabaseFromV33(dbHandle *sql.DB) error {
+	if err := updateSQLiteDatabaseFrom33To34(dbHandle); err != nil {
+		return err
+	}
+	return updateSQLiteDatabaseFromV34(dbHandle)
+}
+
+func updateSQLiteDatabaseFromV34(dbHandle *sql.DB) error {
+	if err := updateSQLiteDatabaseFrom34To35(dbHandle); err != nil {
+		return err
+	}
+	return updateSQLiteDatabaseFromV35(dbHandle)
+}
+
+func updateSQLiteDatabaseFromV35(dbHandle *sql.DB) error {
+	if err := updateSQLiteDatabaseFrom35To36(dbHandle); err != nil {
+		return err
+	}
+	return updateSQLiteDatabaseFromV36(dbHandle)
+}
+
+func updateSQLiteDatabaseFromV36(dbHandle *sql.DB) error {
+	if err := updateSQLiteDatabaseFrom36To37(dbHandle); err != nil {
+		return err
+	}
+	return updateSQLiteDatabaseFromV37(dbHandle)
+}
+
+func updateSQLiteDatabaseFromV37(dbHandle *sql.DB) error {
+	if err := updateSQLiteDatabaseFrom37To38(dbHandle); err != nil {
+		return err
+	}
+	return updateSQLiteDatabaseFromV38(dbHandle)
+}
+
+func updateSQLiteDatabaseFromV38(dbHandle *sql.DB) error {
+	if err := updateSQLiteDatabaseFrom38To39(dbHandle); err != nil {
+		return err
+	}
+	return updateSQLiteDatabaseFromV39(dbHandle)
+}
+
+func updateSQLiteDatabaseFromV39(dbHandle *sql.DB) error {
+	if err := updateSQLiteDatabaseFrom39To40(dbHandle); err != nil {
+		return err
+	}
+	return updateSQLiteDatabaseFromV40(dbHandle)
+}
+
+func updateSQLiteDatabaseFromV40(dbHandle *sql.DB) error {
+	if err := updateSQLiteDatabaseFrom40To41(dbHandle); err != nil {
+		return err
+	}
+	return updateSQLiteDatabaseFromV41(dbHandle)
+}
+
+func updateSQLiteDatabaseFromV41(dbHandle *sql.DB) error {
+	if err := updateSQLiteDatabaseFrom41To42(dbHandle); err != nil {
+		return err
+	}
+	return updateSQLiteDatabaseFromV42(dbHandle)
+}
+
+func updateSQLiteDatabaseFromV42(dbHandle *sql.DB) error {
+	if err := updateSQLiteDatabaseFrom42To43(dbHandle); err != nil {
+		return err
+	}
+	return updateSQLiteDatabaseFromV43(dbHandle)
+}
+
+func updateSQLiteDatabaseFromV43(dbHandle *sql.DB) error {
+	if err := updateSQLiteDatabaseFrom43To44(dbHandle); err != nil {
+		return err
+	}
+	return updateSQLiteDatabaseFromV44(dbHandle)
+}
+
+func updateSQLiteDatabaseFromV44(dbHandle *sql.DB) error {
+	if err := updateSQLiteDatabaseFrom44To45(dbHandle); err != nil {
+		return err
+	}
+	return updateSQLiteDatabaseFromV45(dbHandle)
+}
+
+func updateSQLiteDatabaseFromV45(dbHandle *sql.DB) error {
+	return updateSQLiteDatabaseFrom45To46(dbHandle)
 }
 
 func downgradeSQLiteDatabaseFromV30(dbHandle *sql.DB) error {
@@ -851,6 +1164,104 @@ func downgradeSQLiteDatabaseFromV32(dbHandle *sql.DB) error {
 	return downgradeSQLiteDatabaseFromV31(dbHandle)
 }
 
+func downgradeSQLiteDatabaseFromV33(dbHandle *sql.DB) error {
+	if err := downgradeSQLiteDatabaseFrom33To32(dbHandle); err != nil {
+		return err
+	}
+	return downgradeSQLiteDatabaseFromV32(dbHandle)
+}
+
+func downgradeSQLiteDatabaseFromV34(dbHandle *sql.DB) error {
+	if err := downgradeSQLiteDatabaseFrom34To33(dbHandle); err != nil {
+		return err
+	}
+	return downgradeSQLiteDatabaseFromV33(dbHandle)
+}
+
+func downgradeSQLiteDatabaseFromV35(dbHandle *sql.DB) error {
+	if err := downgradeSQLiteDatabaseFrom35To34(dbHandle); err != nil {
+		return err
+	}
+	return downgradeSQLiteDatabaseFromV34(dbHandle)
+}
+
+func downgradeSQLiteDatabaseFromV36(dbHandle *sql.DB) error {
+	if err := downgradeSQLiteDatabaseFrom36To35(dbHandle); err != nil {
+		return err
+	}
+	return downgradeSQLiteDatabaseFromV35(dbHandle)
+}
+
+func downgradeSQLiteDatabaseFromV37(dbHandle *sql.DB) error {
+	if err := downgradeSQLiteDatabaseFrom37To36(dbHandle); err != nil {
+		return err
+	}
+	return downgradeSQLiteDatabaseFromV36(dbHandle)
+}
+
+func downgradeSQLiteDatabaseFromV38(dbHandle *sql.DB) error {
+	if err := downgradeSQLiteDatabaseFrom38To37(dbHandle); err != nil {
+		return err
+	}
+	return downgradeSQLiteDatabaseFromV37(dbHandle)
+}
+
+func downgradeSQLiteDatabaseFromV39(dbHandle *sql.DB) error {
+	if err := downgradeSQLiteDatabaseFrom39To38(dbHandle); err != nil {
+		return err
+	}
+	return downgradeSQLiteDatabaseFromV38(dbHandle)
+}
+
+func downgradeSQLiteDatabaseFromV40(dbHandle *sql.DB) error {
+	if err := downgradeSQLiteDatabaseFrom40To39(dbHandle); err != nil {
+		return err
+	}
+	return downgradeSQLiteDatabaseFromV39(dbHandle)
+}
+
+func downgradeSQLiteDatabaseFromV41(dbHandle *sql.DB) error {
+	if err := downgradeSQLiteDatabaseFrom41To40(dbHandle); err != nil {
+		return err
+	}
+	return downgradeSQLiteDatabaseFromV40(dbHandle)
+}
+
+func downgradeSQLiteDatabaseFromV42(dbHandle *sql.DB) error {
+	if err := downgradeSQLiteDatabaseFrom42To41(dbHandle); err != nil {
+		return err
+	}
+	return downgradeSQLiteDatabaseFromV41(dbHandle)
+}
+
+func downgradeSQLiteDatabaseFromV43(dbHandle *sql.DB) error {
+	if err := downgradeSQLiteDatabaseFrom43To42(dbHandle); err != nil {
+		return err
+	}
+	return downgradeSQLiteDatabaseFromV42(dbHandle)
+}
+
+func downgradeSQLiteDatabaseFromV44(dbHandle *sql.DB) error {
+	if err := downgradeSQLiteDatabaseFrom44To43(dbHandle); err != nil {
+		return err
+	}
+	return downgradeSQLiteDatabaseFromV43(dbHandle)
+}
+
+func downgradeSQLiteDatabaseFromV45(dbHandle *sql.DB) error {
+	if err := downgradeSQLiteDatabaseFrom45To44(dbHandle); err != nil {
+		return err
+	}
+	return downgradeSQLiteDatabaseFromV44(dbHandle)
+}
+
+func downgradeSQLiteDatabaseFromV46(dbHandle *sql.DB) error {
+	if err := downgradeSQLiteDatabaseFrom46To45(dbHandle); err != nil {
+		return err
+	}
+	return downgradeSQLiteDatabaseFromV45(dbHandle)
+}
+
 func updateSQLiteDatabaseFrom29To30(dbHandle *sql.DB) error {
 	logger.InfoToConsole("updating database schema version: 29 -> 30")
 	providerLog(logger.LevelInfo, "updating database schema version: 29 -> 30")
@@ -885,6 +1296,23 @@ func downgradeSQLiteDatabaseFrom31To30(dbHandle *sql.DB) error {
 	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 30, false)
 }
 
+func updateSQLiteDatabaseFrom32To33(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 32 -> 33")
+	providerLog(logger.LevelInfo, "updating database schema version: 32 -> 33")
+
+	sql := strings.ReplaceAll(sqliteV33SQL, "{{login_events}}", sqlTableLoginEvents)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 33, true)
+}
+
+func downgradeSQLiteDatabaseFrom33To32(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 33 -> 32")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 33 -> 32")
+
+	sql := strings.ReplaceAll(sqliteV33DownSQL, "{{login_events}}", sqlTableLoginEvents)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 32, false)
+}
+
 /*func setPragmaFK(dbHandle *sql.DB, value string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), longSQLQueryTimeout)
 	defer cancel()
@@ -894,3 +1322,215 @@ func downgradeSQLiteDatabaseFrom31To30(dbHandle *sql.DB) error {
 	_, err := dbHandle.ExecContext(ctx, sql)
 	return err
 }*/
+
+func updateSQLiteDatabaseFrom33To34(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 33 -> 34")
+	providerLog(logger.LevelInfo, "updating database schema version: 33 -> 34")
+
+	sql := strings.ReplaceAll(sqliteV34SQL, "{{transfer_windows}}", sqlTableTransferWindows)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 34, true)
+}
+
+func downgradeSQLiteDatabaseFrom34To33(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 34 -> 33")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 34 -> 33")
+
+	sql := strings.ReplaceAll(sqliteV34DownSQL, "{{transfer_windows}}", sqlTableTransferWindows)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 33, false)
+}
+
+func updateSQLiteDatabaseFrom34To35(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 34 -> 35")
+	providerLog(logger.LevelInfo, "updating database schema version: 34 -> 35")
+
+	sql := strings.ReplaceAll(sqliteV35SQL, "{{folders}}", sqlTableFolders)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 35, true)
+}
+
+func downgradeSQLiteDatabaseFrom35To34(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 35 -> 34")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 35 -> 34")
+
+	sql := strings.ReplaceAll(sqliteV35DownSQL, "{{folders}}", sqlTableFolders)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 34, false)
+}
+
+func updateSQLiteDatabaseFrom35To36(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 35 -> 36")
+	providerLog(logger.LevelInfo, "updating database schema version: 35 -> 36")
+
+	sql := strings.ReplaceAll(sqliteV36SQL, "{{users_folders_mapping}}", sqlTableUsersFoldersMapping)
+	sql = strings.ReplaceAll(sql, "{{groups_folders_mapping}}", sqlTableGroupsFoldersMapping)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 36, true)
+}
+
+func downgradeSQLiteDatabaseFrom36To35(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 36 -> 35")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 36 -> 35")
+
+	sql := strings.ReplaceAll(sqliteV36DownSQL, "{{users_folders_mapping}}", sqlTableUsersFoldersMapping)
+	sql = strings.ReplaceAll(sql, "{{groups_folders_mapping}}", sqlTableGroupsFoldersMapping)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 35, false)
+}
+
+func updateSQLiteDatabaseFrom36To37(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 36 -> 37")
+	providerLog(logger.LevelInfo, "updating database schema version: 36 -> 37")
+
+	sql := strings.ReplaceAll(sqliteV37SQL, "{{folders}}", sqlTableFolders)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 37, true)
+}
+
+func downgradeSQLiteDatabaseFrom37To36(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 37 -> 36")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 37 -> 36")
+
+	sql := strings.ReplaceAll(sqliteV37DownSQL, "{{folders}}", sqlTableFolders)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 36, false)
+}
+
+func updateSQLiteDatabaseFrom37To38(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 37 -> 38")
+	providerLog(logger.LevelInfo, "updating database schema version: 37 -> 38")
+
+	sql := strings.ReplaceAll(sqliteV38SQL, "{{folders}}", sqlTableFolders)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 38, true)
+}
+
+func downgradeSQLiteDatabaseFrom38To37(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 38 -> 37")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 38 -> 37")
+
+	sql := strings.ReplaceAll(sqliteV38DownSQL, "{{folders}}", sqlTableFolders)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 37, false)
+}
+
+func updateSQLiteDatabaseFrom38To39(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 38 -> 39")
+	providerLog(logger.LevelInfo, "updating database schema version: 38 -> 39")
+
+	sql := strings.ReplaceAll(sqliteV39SQL, "{{folders}}", sqlTableFolders)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 39, true)
+}
+
+func downgradeSQLiteDatabaseFrom39To38(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 39 -> 38")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 39 -> 38")
+
+	sql := strings.ReplaceAll(sqliteV39DownSQL, "{{folders}}", sqlTableFolders)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 38, false)
+}
+
+func updateSQLiteDatabaseFrom39To40(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 39 -> 40")
+	providerLog(logger.LevelInfo, "updating database schema version: 39 -> 40")
+
+	sql := strings.ReplaceAll(sqliteV40SQL, "{{folders}}", sqlTableFolders)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 40, true)
+}
+
+func downgradeSQLiteDatabaseFrom40To39(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 40 -> 39")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 40 -> 39")
+
+	sql := strings.ReplaceAll(sqliteV40DownSQL, "{{folders}}", sqlTableFolders)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 39, false)
+}
+
+func updateSQLiteDatabaseFrom40To41(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 40 -> 41")
+	providerLog(logger.LevelInfo, "updating database schema version: 40 -> 41")
+
+	sql := strings.ReplaceAll(sqliteV41SQL, "{{folders}}", sqlTableFolders)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 41, true)
+}
+
+func downgradeSQLiteDatabaseFrom41To40(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 41 -> 40")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 41 -> 40")
+
+	sql := strings.ReplaceAll(sqliteV41DownSQL, "{{folders}}", sqlTableFolders)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 40, false)
+}
+
+func updateSQLiteDatabaseFrom41To42(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 41 -> 42")
+	providerLog(logger.LevelInfo, "updating database schema version: 41 -> 42")
+
+	sql := strings.ReplaceAll(sqliteV42SQL, "{{users}}", sqlTableUsers)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 42, true)
+}
+
+func downgradeSQLiteDatabaseFrom42To41(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 42 -> 41")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 42 -> 41")
+
+	sql := strings.ReplaceAll(sqliteV42DownSQL, "{{users}}", sqlTableUsers)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 41, false)
+}
+
+func updateSQLiteDatabaseFrom42To43(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 42 -> 43")
+	providerLog(logger.LevelInfo, "updating database schema version: 42 -> 43")
+
+	sql := strings.ReplaceAll(sqliteV43SQL, "{{user_templates}}", sqlTableUserTemplates)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 43, true)
+}
+
+func downgradeSQLiteDatabaseFrom43To42(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 43 -> 42")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 43 -> 42")
+
+	sql := strings.ReplaceAll(sqliteV43DownSQL, "{{user_templates}}", sqlTableUserTemplates)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 42, false)
+}
+
+func updateSQLiteDatabaseFrom43To44(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 43 -> 44")
+	providerLog(logger.LevelInfo, "updating database schema version: 43 -> 44")
+
+	sql := strings.ReplaceAll(sqliteV44SQL, "{{object_changes}}", sqlTableObjectChanges)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 44, true)
+}
+
+func downgradeSQLiteDatabaseFrom44To43(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 44 -> 43")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 44 -> 43")
+
+	sql := strings.ReplaceAll(sqliteV44DownSQL, "{{object_changes}}", sqlTableObjectChanges)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 43, false)
+}
+
+func updateSQLiteDatabaseFrom44To45(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 44 -> 45")
+	providerLog(logger.LevelInfo, "updating database schema version: 44 -> 45")
+
+	sql := strings.ReplaceAll(sqliteV45SQL, "{{api_keys}}", sqlTableAPIKeys)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 45, true)
+}
+
+func downgradeSQLiteDatabaseFrom45To44(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 45 -> 44")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 45 -> 44")
+
+	sql := strings.ReplaceAll(sqliteV45DownSQL, "{{api_keys}}", sqlTableAPIKeys)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 44, false)
+}
+
+func updateSQLiteDatabaseFrom45To46(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 45 -> 46")
+	providerLog(logger.LevelInfo, "updating database schema version: 45 -> 46")
+
+	sql := strings.ReplaceAll(sqliteV46SQL, "{{object_changes_lock}}", sqlTableObjectChangesLock)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 46, true)
+}
+
+func downgradeSQLiteDatabaseFrom46To45(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 46 -> 45")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 46 -> 45")
+
+	sql := strings.ReplaceAll(sqliteV46DownSQL, "{{object_changes_lock}}", sqlTableObjectChangesLock)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 45, false)
+}
@@ -27,17 +27,20 @@ const (
 		"u.permissions,u.used_quota_size,u.used_quota_files,u.last_quota_update,u.upload_bandwidth,u.download_bandwidth," +
 		"u.expiration_date,u.last_login,u.status,u.filters,u.filesystem,u.additional_info,u.description,u.email,u.created_at," +
 		"u.updated_at,u.upload_data_transfer,u.download_data_transfer,u.total_data_transfer," +
-		"u.used_upload_data_transfer,u.used_download_data_transfer,u.deleted_at,u.first_download,u.first_upload,r.name,u.last_password_change"
-	selectFolderFields = "id,path,used_quota_size,used_quota_files,last_quota_update,name,description,filesystem"
+		"u.used_upload_data_transfer,u.used_download_data_transfer,u.deleted_at,u.first_download,u.first_upload,r.name,u.last_password_change," +
+		"u.used_logins"
+	selectFolderFields = "id,path,used_quota_size,used_quota_files,last_quota_update,name,description,filesystem,retention,quota_scan," +
+		"max_upload_file_size,extraction,transform,setstat_mode"
 	selectAdminFields  = "a.id,a.username,a.password,a.status,a.email,a.permissions,a.filters,a.additional_info,a.description,a.created_at,a.updated_at,a.last_login,r.name"
-	selectAPIKeyFields = "key_id,name,api_key,scope,created_at,updated_at,last_use_at,expires_at,description,user_id,admin_id"
+	selectAPIKeyFields = "key_id,name,api_key,scope,created_at,updated_at,last_use_at,expires_at,description,user_id,admin_id,permissions,username_prefix"
 	selectShareFields  = "s.share_id,s.name,s.description,s.scope,s.paths,u.username,s.created_at,s.updated_at,s.last_use_at," +
 		"s.expires_at,s.password,s.max_tokens,s.used_tokens,s.allow_from"
-	selectGroupFields       = "id,name,description,created_at,updated_at,user_settings"
-	selectEventActionFields = "id,name,description,type,options"
-	selectRoleFields        = "id,name,description,created_at,updated_at"
-	selectIPListEntryFields = "type,ipornet,mode,protocols,description,created_at,updated_at,deleted_at"
-	selectMinimalFields     = "id,name"
+	selectGroupFields        = "id,name,description,created_at,updated_at,user_settings"
+	selectEventActionFields  = "id,name,description,type,options"
+	selectRoleFields         = "id,name,description,created_at,updated_at"
+	selectUserTemplateFields = "id,name,description,created_at,updated_at,user_settings"
+	selectIPListEntryFields  = "type,ipornet,mode,protocols,description,created_at,updated_at,deleted_at"
+	selectMinimalFields      = "id,name"
 )
 
 func getSQLPlaceholders() []string {
@@ -86,6 +89,27 @@ func getAddSessionQuery() string {
 		sqlTableSharedSessions, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3])
 }
 
+func getAddSessionIfMissingQuery() string {
+	if config.Driver == MySQLDataProviderName {
+		return fmt.Sprintf("INSERT INTO %s (`key`,`data`,`type`,`timestamp`) VALUES (%s,%s,%s,%s) "+
+			"ON DUPLICATE KEY UPDATE `key`=`key`",
+			sqlTableSharedSessions, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3])
+	}
+	return fmt.Sprintf(`INSERT INTO %s (key,data,type,timestamp) VALUES (%s,%s,%s,%s) ON CONFLICT(key,type) DO NOTHING`,
+		sqlTableSharedSessions, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3])
+}
+
+func getReplaceSessionQuery() string {
+	if config.Driver == MySQLDataProviderName {
+		return fmt.Sprintf("UPDATE %s SET `data` = %s, `timestamp` = %s WHERE `key` = %s AND `type` = %s AND `data` = %s",
+			sqlTableSharedSessions, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3],
+			sqlPlaceholders[4])
+	}
+	return fmt.Sprintf(`UPDATE %s SET data = %s, timestamp = %s WHERE key = %s AND type = %s AND data = %s`,
+		sqlTableSharedSessions, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3],
+		sqlPlaceholders[4])
+}
+
 func getDeleteSessionQuery() string {
 	if config.Driver == MySQLDataProviderName {
 		return fmt.Sprintf("DELETE FROM %s WHERE `key` = %s AND `type` = %s",
@@ -171,6 +195,10 @@ func getDeleteDefenderHostQuery() string {
 	return fmt.Sprintf(`DELETE FROM %s WHERE ip = %s`, sqlTableDefenderHosts, sqlPlaceholders[0])
 }
 
+func getDeleteDefenderHostsQuery() string {
+	return fmt.Sprintf(`DELETE FROM %s`, sqlTableDefenderHosts)
+}
+
 func getDefenderHostsCleanupQuery() string {
 	return fmt.Sprintf(`DELETE FROM %s WHERE ban_time < %s AND NOT EXISTS (
 		SELECT id FROM %s WHERE %s.host_id = %s.id AND %s.date_time > %s)`,
@@ -284,6 +312,75 @@ func getUpdateConfigsQuery() string {
 	return fmt.Sprintf(`UPDATE %s SET configs = %s`, sqlTableConfigs, sqlPlaceholders[0])
 }
 
+func getAddLoginEventQuery() string {
+	return fmt.Sprintf(`INSERT INTO %s (timestamp,username,ip,protocol,login_method,client_version,status,error) `+
+		`VALUES (%s,%s,%s,%s,%s,%s,%s,%s)`, sqlTableLoginEvents, sqlPlaceholders[0], sqlPlaceholders[1],
+		sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5], sqlPlaceholders[6],
+		sqlPlaceholders[7])
+}
+
+func getLoginEventsQuery(filter LoginEventFilter) (string, []any) {
+	var sb strings.Builder
+	var args []any
+
+	addCondition := func(condition string, arg any) {
+		if len(args) == 0 {
+			sb.WriteString(" WHERE ")
+		} else {
+			sb.WriteString(" AND ")
+		}
+		sb.WriteString(fmt.Sprintf(condition, sqlPlaceholders[len(args)]))
+		args = append(args, arg)
+	}
+	if filter.Username != "" {
+		addCondition("username = %s", filter.Username)
+	}
+	if filter.IP != "" {
+		addCondition("ip = %s", filter.IP)
+	}
+	if filter.Status != LoginEventStatusAny {
+		addCondition("status = %s", filter.Status)
+	}
+	if filter.StartTimestamp > 0 {
+		addCondition("timestamp >= %s", filter.StartTimestamp)
+	}
+	if filter.EndTimestamp > 0 {
+		addCondition("timestamp <= %s", filter.EndTimestamp)
+	}
+	q := fmt.Sprintf(`SELECT id,timestamp,username,ip,protocol,login_method,client_version,status,error FROM %s%s `+
+		`ORDER BY timestamp %s, id %s LIMIT %s`, sqlTableLoginEvents, sb.String(), filter.Order, filter.Order,
+		sqlPlaceholders[len(args)])
+	args = append(args, filter.Limit)
+	return q, args
+}
+
+func getLockObjectChangesQuery() string {
+	return fmt.Sprintf(`UPDATE %s SET counter = counter + 1 WHERE id = 1`, sqlTableObjectChangesLock)
+}
+
+func getAddObjectChangeQuery() string {
+	q := fmt.Sprintf(`INSERT INTO %s (timestamp,object_type,object_name,action,actor) VALUES (%s,%s,%s,%s,%s)`,
+		sqlTableObjectChanges, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3],
+		sqlPlaceholders[4])
+	if config.Driver == MySQLDataProviderName {
+		return q
+	}
+	return q + " RETURNING id"
+}
+
+func getObjectChangesQuery() string {
+	return fmt.Sprintf(`SELECT id,timestamp,object_type,object_name,action,actor FROM %s WHERE id > %s ORDER BY id ASC LIMIT %s`,
+		sqlTableObjectChanges, sqlPlaceholders[0], sqlPlaceholders[1])
+}
+
+func getObjectChangesCleanupQuery() string {
+	return fmt.Sprintf(`DELETE FROM %s WHERE timestamp < %s`, sqlTableObjectChanges, sqlPlaceholders[0])
+}
+
+func getLoginEventsCleanupQuery() string {
+	return fmt.Sprintf(`DELETE FROM %s WHERE timestamp < %s`, sqlTableLoginEvents, sqlPlaceholders[0])
+}
+
 func getRoleByNameQuery() string {
 	return fmt.Sprintf(`SELECT %s FROM %s WHERE name = %s`, selectRoleFields, sqlTableRoles,
 		sqlPlaceholders[0])
@@ -353,6 +450,36 @@ func getDeleteRoleQuery() string {
 	return fmt.Sprintf(`DELETE FROM %s WHERE name = %s`, sqlTableRoles, sqlPlaceholders[0])
 }
 
+func getUserTemplateByNameQuery() string {
+	return fmt.Sprintf(`SELECT %s FROM %s WHERE name = %s`, selectUserTemplateFields, sqlTableUserTemplates,
+		sqlPlaceholders[0])
+}
+
+func getUserTemplatesQuery(order string) string {
+	return fmt.Sprintf(`SELECT %s FROM %s ORDER BY name %s LIMIT %s OFFSET %s`, selectUserTemplateFields,
+		sqlTableUserTemplates, order, sqlPlaceholders[0], sqlPlaceholders[1])
+}
+
+func getDumpUserTemplatesQuery() string {
+	return fmt.Sprintf(`SELECT %s FROM %s`, selectUserTemplateFields, sqlTableUserTemplates)
+}
+
+func getAddUserTemplateQuery() string {
+	return fmt.Sprintf(`INSERT INTO %s (name,description,user_settings,created_at,updated_at)
+		VALUES (%s,%s,%s,%s,%s)`, sqlTableUserTemplates, sqlPlaceholders[0], sqlPlaceholders[1],
+		sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4])
+}
+
+func getUpdateUserTemplateQuery() string {
+	return fmt.Sprintf(`UPDATE %s SET description=%s,user_settings=%s,updated_at=%s
+		WHERE name = %s`, sqlTableUserTemplates, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2],
+		sqlPlaceholders[3])
+}
+
+func getDeleteUserTemplateQuery() string {
+	return fmt.Sprintf(`DELETE FROM %s WHERE name = %s`, sqlTableUserTemplates, sqlPlaceholders[0])
+}
+
 func getGroupByNameQuery() string {
 	return fmt.Sprintf(`SELECT %s FROM %s WHERE name = %s`, selectGroupFields, getSQLQuotedName(sqlTableGroups),
 		sqlPlaceholders[0])
@@ -522,16 +649,17 @@ func getDumpAPIKeysQuery() string {
 }
 
 func getAddAPIKeyQuery() string {
-	return fmt.Sprintf(`INSERT INTO %s (key_id,name,api_key,scope,created_at,updated_at,last_use_at,expires_at,description,user_id,admin_id)
-		VALUES (%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s)`, sqlTableAPIKeys, sqlPlaceholders[0], sqlPlaceholders[1],
-		sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5], sqlPlaceholders[6],
-		sqlPlaceholders[7], sqlPlaceholders[8], sqlPlaceholders[9], sqlPlaceholders[10])
+	return fmt.Sprintf(`INSERT INTO %s (key_id,name,api_key,scope,created_at,updated_at,last_use_at,expires_at,description,user_id,admin_id,
+		permissions,username_prefix) VALUES (%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s)`, sqlTableAPIKeys, sqlPlaceholders[0],
+		sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5], sqlPlaceholders[6],
+		sqlPlaceholders[7], sqlPlaceholders[8], sqlPlaceholders[9], sqlPlaceholders[10], sqlPlaceholders[11], sqlPlaceholders[12])
 }
 
 func getUpdateAPIKeyQuery() string {
-	return fmt.Sprintf(`UPDATE %s SET name=%s,scope=%s,expires_at=%s,user_id=%s,admin_id=%s,description=%s,updated_at=%s
-		WHERE key_id = %s`, sqlTableAPIKeys, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2],
-		sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5], sqlPlaceholders[6], sqlPlaceholders[7])
+	return fmt.Sprintf(`UPDATE %s SET name=%s,scope=%s,expires_at=%s,user_id=%s,admin_id=%s,description=%s,updated_at=%s,
+		permissions=%s,username_prefix=%s WHERE key_id = %s`, sqlTableAPIKeys, sqlPlaceholders[0], sqlPlaceholders[1],
+		sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5], sqlPlaceholders[6], sqlPlaceholders[7],
+		sqlPlaceholders[8], sqlPlaceholders[9])
 }
 
 func getDeleteAPIKeyQuery() string {
@@ -590,15 +718,37 @@ func getUserByUsernameQuery(role string) string {
 		selectUserFields, sqlTableUsers, sqlTableRoles, sqlPlaceholders[0], sqlPlaceholders[1])
 }
 
-func getUsersQuery(order, role string) string {
-	if role == "" {
-		return fmt.Sprintf(`SELECT %s FROM %s u LEFT JOIN %s r on r.id = u.role_id WHERE
-			u.deleted_at = 0 ORDER BY u.username %s LIMIT %s OFFSET %s`,
-			selectUserFields, sqlTableUsers, sqlTableRoles, order, sqlPlaceholders[0], sqlPlaceholders[1])
+func getUsersQuery(order, role, usernamePrefix string) string {
+	var sb strings.Builder
+	idx := 0
+
+	sb.WriteString("SELECT ")
+	sb.WriteString(selectUserFields)
+	sb.WriteString(" FROM ")
+	sb.WriteString(sqlTableUsers)
+	sb.WriteString(" u LEFT JOIN ")
+	sb.WriteString(sqlTableRoles)
+	sb.WriteString(" r on r.id = u.role_id WHERE u.deleted_at = 0")
+	if role != "" {
+		sb.WriteString(" AND u.role_id is NOT NULL AND r.name = ")
+		sb.WriteString(sqlPlaceholders[idx])
+		idx++
 	}
-	return fmt.Sprintf(`SELECT %s FROM %s u LEFT JOIN %s r on r.id = u.role_id WHERE
-		u.deleted_at = 0 AND u.role_id is NOT NULL AND r.name = %s ORDER BY u.username %s LIMIT %s OFFSET %s`,
-		selectUserFields, sqlTableUsers, sqlTableRoles, sqlPlaceholders[0], order, sqlPlaceholders[1], sqlPlaceholders[2])
+	if usernamePrefix != "" {
+		// LIKE is not used, "_" is allowed within the usernames
+		sb.WriteString(" AND SUBSTR(u.username, 1, ")
+		sb.WriteString(sqlPlaceholders[idx])
+		sb.WriteString(") = ")
+		sb.WriteString(sqlPlaceholders[idx+1])
+		idx += 2
+	}
+	sb.WriteString(" ORDER BY u.username ")
+	sb.WriteString(order)
+	sb.WriteString(" LIMIT ")
+	sb.WriteString(sqlPlaceholders[idx])
+	sb.WriteString(" OFFSET ")
+	sb.WriteString(sqlPlaceholders[idx+1])
+	return sb.String()
 }
 
 func getUsersForQuotaCheckQuery(numArgs int) string {
@@ -643,6 +793,37 @@ func getUpdateTransferQuotaQuery(reset bool) string {
 		WHERE username = %s`, sqlTableUsers, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3])
 }
 
+// the window start is updated last, MySQL uses the updated values in the following assignments
+func getUpdateTransferWindowQuery() string {
+	return fmt.Sprintf(`UPDATE %s SET used_size = CASE WHEN window_start = %s THEN used_size + %s ELSE %s END,
+		notified = CASE WHEN window_start = %s THEN notified ELSE 0 END,window_end = %s,window_start = %s
+		WHERE username = %s`, sqlTableTransferWindows, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2],
+		sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5], sqlPlaceholders[6])
+}
+
+func getAddTransferWindowQuery() string {
+	return fmt.Sprintf(`INSERT INTO %s (username,window_start,window_end,used_size,notified) VALUES (%s,%s,%s,%s,0)`,
+		sqlTableTransferWindows, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3])
+}
+
+func getTransferWindowQuery() string {
+	return fmt.Sprintf(`SELECT window_start,window_end,used_size,notified FROM %s WHERE username = %s`,
+		sqlTableTransferWindows, sqlPlaceholders[0])
+}
+
+func getSetTransferWindowNotifiedQuery() string {
+	return fmt.Sprintf(`UPDATE %s SET notified = %s WHERE username = %s AND window_start = %s AND notified < %s`,
+		sqlTableTransferWindows, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3])
+}
+
+func getTransferWindowsCleanupQuery() string {
+	return fmt.Sprintf(`DELETE FROM %s WHERE window_end <= %s`, sqlTableTransferWindows, sqlPlaceholders[0])
+}
+
+func getDeleteTransferWindowQuery() string {
+	return fmt.Sprintf(`DELETE FROM %s WHERE username = %s`, sqlTableTransferWindows, sqlPlaceholders[0])
+}
+
 func getUpdateQuotaQuery(reset bool) string {
 	if reset {
 		return fmt.Sprintf(`UPDATE %s SET used_quota_size = %s,used_quota_files = %s,last_quota_update = %s
@@ -678,6 +859,20 @@ func getUpdateLastLoginQuery() string {
 	return fmt.Sprintf(`UPDATE %s SET last_login = %s WHERE username = %s`, sqlTableUsers, sqlPlaceholders[0], sqlPlaceholders[1])
 }
 
+func getConsumeUserLoginQuery() string {
+	return fmt.Sprintf(`UPDATE %s SET used_logins = used_logins + 1 WHERE username = %s AND used_logins < %s`,
+		sqlTableUsers, sqlPlaceholders[0], sqlPlaceholders[1])
+}
+
+func getUserPublicKeysQuery() string {
+	return fmt.Sprintf(`SELECT public_keys FROM %s WHERE username = %s`, sqlTableUsers, sqlPlaceholders[0])
+}
+
+func getUpdateUserPublicKeysQuery() string {
+	return fmt.Sprintf(`UPDATE %s SET public_keys = %s WHERE username = %s`, sqlTableUsers, sqlPlaceholders[0],
+		sqlPlaceholders[1])
+}
+
 func getUpdateAdminLastLoginQuery() string {
 	return fmt.Sprintf(`UPDATE %s SET last_login = %s WHERE username = %s`, sqlTableAdmins, sqlPlaceholders[0], sqlPlaceholders[1])
 }
@@ -747,14 +942,18 @@ func getFolderByNameQuery() string {
 }
 
 func getAddFolderQuery() string {
-	return fmt.Sprintf(`INSERT INTO %s (path,used_quota_size,used_quota_files,last_quota_update,name,description,filesystem)
-		VALUES (%s,%s,%s,%s,%s,%s,%s)`, sqlTableFolders, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2],
-		sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5], sqlPlaceholders[6])
+	return fmt.Sprintf(`INSERT INTO %s (path,used_quota_size,used_quota_files,last_quota_update,name,description,filesystem,retention,
+		quota_scan,max_upload_file_size,extraction,transform,setstat_mode) VALUES (%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s)`,
+		sqlTableFolders, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3],
+		sqlPlaceholders[4], sqlPlaceholders[5], sqlPlaceholders[6], sqlPlaceholders[7], sqlPlaceholders[8],
+		sqlPlaceholders[9], sqlPlaceholders[10], sqlPlaceholders[11], sqlPlaceholders[12])
 }
 
 func getUpdateFolderQuery() string {
-	return fmt.Sprintf(`UPDATE %s SET path=%s,description=%s,filesystem=%s WHERE name = %s`, sqlTableFolders, sqlPlaceholders[0],
-		sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3])
+	return fmt.Sprintf(`UPDATE %s SET path=%s,description=%s,filesystem=%s,retention=%s,max_upload_file_size=%s,
+		extraction=%s,transform=%s,setstat_mode=%s WHERE name = %s`, sqlTableFolders, sqlPlaceholders[0],
+		sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5],
+		sqlPlaceholders[6], sqlPlaceholders[7], sqlPlaceholders[8])
 }
 
 func getDeleteFolderQuery() string {
@@ -791,10 +990,10 @@ func getClearGroupFolderMappingQuery() string {
 }
 
 func getAddGroupFolderMappingQuery() string {
-	return fmt.Sprintf(`INSERT INTO %s (virtual_path,quota_size,quota_files,folder_id,group_id)
-		VALUES (%s,%s,%s,(SELECT id FROM %s WHERE name = %s),(SELECT id FROM %s WHERE name = %s))`,
-		sqlTableGroupsFoldersMapping, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlTableFolders,
-		sqlPlaceholders[3], getSQLQuotedName(sqlTableGroups), sqlPlaceholders[4])
+	return fmt.Sprintf(`INSERT INTO %s (virtual_path,quota_size,quota_files,permissions,folder_id,group_id)
+		VALUES (%s,%s,%s,%s,(SELECT id FROM %s WHERE name = %s),(SELECT id FROM %s WHERE name = %s))`,
+		sqlTableGroupsFoldersMapping, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3],
+		sqlTableFolders, sqlPlaceholders[4], getSQLQuotedName(sqlTableGroups), sqlPlaceholders[5])
 }
 
 func getClearUserFolderMappingQuery() string {
@@ -803,10 +1002,10 @@ func getClearUserFolderMappingQuery() string {
 }
 
 func getAddUserFolderMappingQuery() string {
-	return fmt.Sprintf(`INSERT INTO %s (virtual_path,quota_size,quota_files,folder_id,user_id)
-		VALUES (%s,%s,%s,(SELECT id FROM %s WHERE name = %s),(SELECT id FROM %s WHERE username = %s))`,
-		sqlTableUsersFoldersMapping, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlTableFolders,
-		sqlPlaceholders[3], sqlTableUsers, sqlPlaceholders[4])
+	return fmt.Sprintf(`INSERT INTO %s (virtual_path,quota_size,quota_files,permissions,folder_id,user_id)
+		VALUES (%s,%s,%s,%s,(SELECT id FROM %s WHERE name = %s),(SELECT id FROM %s WHERE username = %s))`,
+		sqlTableUsersFoldersMapping, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3],
+		sqlTableFolders, sqlPlaceholders[4], sqlTableUsers, sqlPlaceholders[5])
 }
 
 func getFoldersQuery(order string, minimal bool) string {
@@ -829,6 +1028,12 @@ func getUpdateFolderQuotaQuery(reset bool) string {
 		WHERE name = %s`, sqlTableFolders, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3])
 }
 
+func getUpdateFolderQuotaScanQuery() string {
+	return fmt.Sprintf(`UPDATE %s SET used_quota_size = %s,used_quota_files = %s,last_quota_update = %s,quota_scan = %s
+		WHERE name = %s`, sqlTableFolders, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3],
+		sqlPlaceholders[4])
+}
+
 func getQuotaFolderQuery() string {
 	return fmt.Sprintf(`SELECT used_quota_size,used_quota_files FROM %s WHERE name = %s`, sqlTableFolders,
 		sqlPlaceholders[0])
@@ -882,7 +1087,7 @@ func getRelatedFoldersForUsersQuery(users []User) string {
 		sb.WriteString(")")
 	}
 	return fmt.Sprintf(`SELECT f.id,f.name,f.path,f.used_quota_size,f.used_quota_files,f.last_quota_update,fm.virtual_path,
-		fm.quota_size,fm.quota_files,fm.user_id,f.filesystem,f.description FROM %s f INNER JOIN %s fm ON f.id = fm.folder_id WHERE
+		fm.quota_size,fm.quota_files,fm.permissions,fm.user_id,f.filesystem,f.description,f.max_upload_file_size,f.extraction,f.transform,f.setstat_mode FROM %s f INNER JOIN %s fm ON f.id = fm.folder_id WHERE
 		fm.user_id IN %s ORDER BY f.name`, sqlTableFolders, sqlTableUsersFoldersMapping, sb.String())
 }
 
@@ -969,7 +1174,7 @@ func getRelatedFoldersForGroupsQuery(groups []Group) string {
 		sb.WriteString(")")
 	}
 	return fmt.Sprintf(`SELECT f.id,f.name,f.path,f.used_quota_size,f.used_quota_files,f.last_quota_update,fm.virtual_path,
-		fm.quota_size,fm.quota_files,fm.group_id,f.filesystem,f.description FROM %s f INNER JOIN %s fm ON f.id = fm.folder_id WHERE
+		fm.quota_size,fm.quota_files,fm.permissions,fm.group_id,f.filesystem,f.description,f.max_upload_file_size,f.extraction,f.transform,f.setstat_mode FROM %s f INNER JOIN %s fm ON f.id = fm.folder_id WHERE
 		fm.group_id IN %s ORDER BY f.name`, sqlTableFolders, sqlTableGroupsFoldersMapping, sb.String())
 }
 
@@ -0,0 +1,263 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// Supported transfer quota window periods
+const (
+	TransferQuotaWindowDaily   = "daily"
+	TransferQuotaWindowWeekly  = "weekly"
+	TransferQuotaWindowMonthly = "monthly"
+)
+
+// TransferQuotaWindowThresholds defines the usage percentages, for a transfer
+// quota window, notified once per window
+var TransferQuotaWindowThresholds = []int{80, 100}
+
+var (
+	supportedTransferQuotaWindowPeriods = []string{TransferQuotaWindowDaily, TransferQuotaWindowWeekly,
+		TransferQuotaWindowMonthly}
+	// time zones are loaded from the system database, we cache them to avoid
+	// reading the database for each transfer
+	transferQuotaWindowLocations sync.Map
+)
+
+// TransferQuotaWindow defines a data transfer quota, for uploads and downloads,
+// reset periodically
+type TransferQuotaWindow struct {
+	// Reset period: daily, weekly or monthly. Weekly windows start on Monday.
+	// Empty means disabled
+	Period string `json:"period,omitempty"`
+	// Data transfer allowed in each window as MB
+	Size int64 `json:"size,omitempty"`
+	// Time zone, for example "Europe/Rome", used to compute the window boundaries.
+	// Empty means UTC
+	Timezone string `json:"timezone,omitempty"`
+	// By default the window budget is checked when a new transfer starts.
+	// If true the transfers in progress are aborted too once the budget,
+	// plus the grace size, is exhausted
+	EnforceDuringTransfer bool `json:"enforce_during_transfer,omitempty"`
+	// Data transfer, as MB, allowed beyond the window budget before the
+	// transfers in progress are aborted
+	GraceSize int64 `json:"grace_size,omitempty"`
+}
+
+// IsEnabled returns true if the transfer quota window is configured
+func (w *TransferQuotaWindow) IsEnabled() bool {
+	return w.Period != "" && w.Size > 0
+}
+
+func (w *TransferQuotaWindow) validate() error {
+	if w.Period == "" && w.Size == 0 {
+		w.Timezone = ""
+		w.EnforceDuringTransfer = false
+		w.GraceSize = 0
+		return nil
+	}
+	if !slices.Contains(supportedTransferQuotaWindowPeriods, w.Period) {
+		return util.NewValidationError(fmt.Sprintf("invalid transfer quota window period %q", w.Period))
+	}
+	if w.Size <= 0 {
+		return util.NewValidationError("the transfer quota window size must be greater than 0")
+	}
+	if w.GraceSize < 0 {
+		return util.NewValidationError(fmt.Sprintf("invalid transfer quota window grace size %d", w.GraceSize))
+	}
+	if w.Timezone != "" {
+		if _, err := getTransferQuotaWindowLocation(w.Timezone); err != nil {
+			return util.NewValidationError(fmt.Sprintf("invalid transfer quota window time zone %q: %v", w.Timezone, err))
+		}
+	}
+	if !w.EnforceDuringTransfer {
+		w.GraceSize = 0
+	}
+	return nil
+}
+
+// GetSize returns the window budget as bytes
+func (w *TransferQuotaWindow) GetSize() int64 {
+	return w.Size * 1048576
+}
+
+// GetGraceSize returns the grace size as bytes
+func (w *TransferQuotaWindow) GetGraceSize() int64 {
+	return w.GraceSize * 1048576
+}
+
+// getBounds returns the start and the end of the window including the specified time
+func (w *TransferQuotaWindow) getBounds(now time.Time) (time.Time, time.Time) {
+	loc, err := getTransferQuotaWindowLocation(w.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	t := now.In(loc)
+	switch w.Period {
+	case TransferQuotaWindowWeekly:
+		// time.Weekday starts on Sunday
+		offset := (int(t.Weekday()) + 6) % 7
+		start := time.Date(t.Year(), t.Month(), t.Day()-offset, 0, 0, 0, 0, loc)
+		return start, start.AddDate(0, 0, 7)
+	case TransferQuotaWindowMonthly:
+		start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc)
+		return start, start.AddDate(0, 1, 0)
+	default:
+		start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+		return start, start.AddDate(0, 0, 1)
+	}
+}
+
+func getTransferQuotaWindowLocation(name string) (*time.Location, error) {
+	if name == "" {
+		return time.UTC, nil
+	}
+	if loc, ok := transferQuotaWindowLocations.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	transferQuotaWindowLocations.Store(name, loc)
+	return loc, nil
+}
+
+// TransferQuotaWindowStatus defines the data transfer usage for the current
+// transfer quota window
+type TransferQuotaWindowStatus struct {
+	Period string `json:"period"`
+	// Window start as unix timestamp in milliseconds
+	Start int64 `json:"start"`
+	// Window end, excluded, as unix timestamp in milliseconds
+	End int64 `json:"end"`
+	// Window budget as bytes
+	Size int64 `json:"size"`
+	// Used data transfer as bytes
+	Used int64 `json:"used"`
+	// Remaining data transfer as bytes, it can be negative
+	// if the budget is exceeded
+	Remaining int64 `json:"remaining"`
+}
+
+// transferWindowUsage defines the data transfer for a user in a transfer quota window
+type transferWindowUsage struct {
+	Username string `json:"username"`
+	// Window start and end as unix timestamp in milliseconds
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+	// Used data transfer as bytes
+	Used int64 `json:"used"`
+	// Highest usage threshold, as percentage, already notified
+	Notified int `json:"notified"`
+}
+
+// GetTransferQuotaWindowStatus returns the data transfer usage for the current
+// transfer quota window of the specified user
+func GetTransferQuotaWindowStatus(user *User) (TransferQuotaWindowStatus, error) {
+	window := &user.Filters.TransferQuotaWindow
+	if !window.IsEnabled() {
+		return TransferQuotaWindowStatus{}, util.NewRecordNotFoundError(
+			fmt.Sprintf("no transfer quota window defined for user %q", user.Username))
+	}
+	if config.TrackQuota == 0 {
+		return TransferQuotaWindowStatus{}, util.NewMethodDisabledError(trackQuotaDisabledError)
+	}
+	start, end := window.getBounds(time.Now())
+	status := TransferQuotaWindowStatus{
+		Period:    window.Period,
+		Start:     util.GetTimeAsMsSinceEpoch(start),
+		End:       util.GetTimeAsMsSinceEpoch(end),
+		Size:      window.GetSize(),
+		Remaining: window.GetSize(),
+	}
+	usage, err := provider.getTransferWindowUsage(user.Username)
+	if err != nil {
+		if errors.Is(err, util.ErrNotFound) {
+			return status, nil
+		}
+		return status, err
+	}
+	// the usage for a previous window, not yet removed by the reset job, is ignored
+	if usage.Start == status.Start {
+		status.Used = usage.Used
+		status.Remaining = status.Size - usage.Used
+	}
+	return status, nil
+}
+
+// UpdateTransferQuotaWindow adds the specified data transfer, as bytes, to the
+// current transfer quota window of the specified user. It returns the usage
+// thresholds reached by this update, each threshold is returned once per window
+// also in multi-node setups, and the used data transfer as bytes
+func UpdateTransferQuotaWindow(user *User, size int64) ([]int, int64, error) {
+	window := &user.Filters.TransferQuotaWindow
+	if !window.IsEnabled() || size <= 0 {
+		return nil, 0, nil
+	}
+	if config.TrackQuota == 0 {
+		return nil, 0, util.NewMethodDisabledError(trackQuotaDisabledError)
+	}
+	start, end := window.getBounds(time.Now())
+	windowStart := util.GetTimeAsMsSinceEpoch(start)
+	err := provider.updateTransferWindowUsage(user.Username, windowStart, util.GetTimeAsMsSinceEpoch(end), size)
+	if err != nil {
+		providerLog(logger.LevelError, "unable to update the transfer quota window for user %q: %v", user.Username, err)
+		return nil, 0, err
+	}
+	usage, err := provider.getTransferWindowUsage(user.Username)
+	if err != nil {
+		return nil, 0, err
+	}
+	if usage.Start != windowStart {
+		return nil, 0, nil
+	}
+	var thresholds []int
+	for _, threshold := range TransferQuotaWindowThresholds {
+		if usage.Notified >= threshold || usage.Used*100 < window.GetSize()*int64(threshold) {
+			continue
+		}
+		// the first caller marking the threshold as notified wins
+		ok, err := provider.setTransferWindowNotified(user.Username, windowStart, threshold)
+		if err != nil {
+			providerLog(logger.LevelError, "unable to set the notified threshold %d for user %q: %v",
+				threshold, user.Username, err)
+			return thresholds, usage.Used, err
+		}
+		if ok {
+			thresholds = append(thresholds, threshold)
+		}
+	}
+	return thresholds, usage.Used, nil
+}
+
+// cleanupTransferQuotaWindows removes the usages for the ended windows, the
+// next update starts a new window
+func cleanupTransferQuotaWindows() {
+	now := util.GetTimeAsMsSinceEpoch(time.Now())
+	if err := provider.cleanupTransferWindowUsages(now); err != nil {
+		providerLog(logger.LevelError, "unable to reset the ended transfer quota windows: %v", err)
+		return
+	}
+	providerLog(logger.LevelDebug, "ended transfer quota windows reset")
+}
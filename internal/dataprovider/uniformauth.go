@@ -0,0 +1,83 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/alexedwards/argon2id"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+var (
+	dummyPassword             dummyPasswordHash
+	passwordHashVerifications atomic.Int64
+)
+
+// dummyPasswordHash is the hash of a random password generated using the
+// configured algorithm, it is regenerated if the algorithm changes
+type dummyPasswordHash struct {
+	mu   sync.Mutex
+	algo string
+	hash string
+}
+
+func (d *dummyPasswordHash) get() (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.hash != "" && d.algo == config.PasswordHashing.Algo {
+		return d.hash, nil
+	}
+	hash, err := hashPlainPassword(util.GenerateUniqueID())
+	if err != nil {
+		return "", err
+	}
+	d.algo = config.PasswordHashing.Algo
+	d.hash = hash
+	return hash, nil
+}
+
+// VerifyDummyPassword compares the given password with the hash of a random
+// password generated using the configured algorithm. It is used for
+// non-existent users so the authentication time does not reveal if a user exists.
+// As for the existing users, empty passwords are not verified
+func VerifyDummyPassword(password string) {
+	if strings.TrimSpace(password) == "" {
+		return
+	}
+	hash, err := dummyPassword.get()
+	if err != nil {
+		providerLog(logger.LevelError, "unable to generate the dummy password hash: %v", err)
+		return
+	}
+	passwordHashVerifications.Add(1)
+	if strings.HasPrefix(hash, bcryptPwdPrefix) {
+		bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) //nolint:errcheck
+		return
+	}
+	argon2id.ComparePasswordAndHash(password, hash) //nolint:errcheck
+}
+
+// GetPasswordHashVerifications returns the number of password hash verifications,
+// including the dummy ones, performed since the process started
+func GetPasswordHashVerifications() int64 {
+	return passwordHashVerifications.Load()
+}
@@ -22,6 +22,7 @@ import (
 	"net"
 	"os"
 	"path"
+	"path/filepath"
 	"slices"
 	"strconv"
 	"strings"
@@ -75,6 +76,12 @@ const (
 	PermChtimes = "chtimes"
 	// copying files or directories is allowed
 	PermCopy = "copy"
+	// upload only "dropbox" mode: uploading files and creating directories is
+	// allowed, listing and downloading are not. SFTP clients get empty directory
+	// listings and can stat the start directory and the paths uploaded or
+	// created within the same session. It cannot be combined with the list,
+	// download and copy permissions
+	PermDropbox = "dropbox"
 )
 
 // Available login methods
@@ -126,6 +133,40 @@ type UserFilters struct {
 	RequirePasswordChange bool `json:"require_password_change,omitempty"`
 	// AdditionalEmails defines additional email addresses
 	AdditionalEmails []string `json:"additional_emails,omitempty"`
+	// Public key algorithms allowed for public key authentication.
+	// Empty means all the supported algorithms
+	AllowedKeyAlgos []string `json:"allowed_key_algos,omitempty"`
+	// Networks, as CIDRs, where the second factor authentication is not required
+	TwoFactorTrustedNetworks []string `json:"two_factor_trusted_networks,omitempty"`
+	// Data transfer quota reset periodically, it is checked in addition
+	// to the total data transfer limits
+	TransferQuotaWindow TransferQuotaWindow `json:"transfer_quota_window,omitempty"`
+	// If enabled a SHA-256 checksum is computed for each completed upload
+	UploadChecksum bool `json:"upload_checksum,omitempty"`
+	// Maximum size for the uploaded files matching the specified patterns.
+	// The first matching limit takes precedence over the virtual folder and
+	// the user level limits
+	UploadSizeLimits []UploadSizeLimit `json:"upload_size_limits,omitempty"`
+	// Permissions for the files and directories created on local filesystems
+	CreateModes CreateModes `json:"create_modes,omitempty"`
+	// How to handle the requests for changing the owner and the group
+	ChownPolicy ChownPolicy `json:"chown_policy,omitempty"`
+	// SSH login banner for this user, it overrides the global one
+	LoginBanner LoginBanner `json:"login_banner,omitempty"`
+	// If enabled, logins are rejected if the client address cannot be trusted,
+	// for example if the connection was accepted with the proxy protocol enabled
+	// and the proxy header was not sent by a trusted proxy. Enforced for SSH
+	RequireTrustedSourceAddr bool `json:"require_trusted_source_addr,omitempty"`
+	// If enabled, the SFTP requests and the SSH commands are recorded to a
+	// per-session file. The recording must be configured in the SFTP service
+	SessionRecording bool `json:"session_recording,omitempty"`
+	// Maximum number of successful logins allowed for this user, the logins
+	// exceeding this limit are rejected. 0 means unlimited. WebDAV must be
+	// denied for users with a limited login budget
+	MaxLogins int `json:"max_logins,omitempty"`
+	// If enabled the user is disabled when its first SSH or FTP session ends.
+	// HTTP and WebDAV must be denied for these users
+	DisableAfterFirstSession bool `json:"disable_after_first_session,omitempty"`
 	// Time-based one time passwords configuration
 	TOTPConfig UserTOTPConfig `json:"totp_config,omitempty"`
 	// Recovery codes to use if the user loses access to their second factor auth device.
@@ -134,9 +175,189 @@ type UserFilters struct {
 	RecoveryCodes []RecoveryCode `json:"recovery_codes,omitempty"`
 }
 
+// UploadSizeLimit defines the maximum size for the uploaded files matching a
+// shell pattern. Patterns containing a "/" are matched against the virtual
+// path, the other ones against the file name
+type UploadSizeLimit struct {
+	Pattern string `json:"pattern"`
+	// Maximum file size as bytes
+	MaxSize int64 `json:"max_size"`
+}
+
+func (l *UploadSizeLimit) matches(virtualPath string) bool {
+	name := path.Base(virtualPath)
+	if strings.Contains(l.Pattern, "/") {
+		name = virtualPath
+	}
+	matched, err := path.Match(l.Pattern, name)
+	return err == nil && matched
+}
+
+// CreateModes defines the permissions for the files and directories created
+// on local filesystems as octal strings, for example "0640". Empty means the
+// permissions derived from the process umask. The permissions are ignored on
+// Windows
+type CreateModes struct {
+	File string `json:"file,omitempty"`
+	Dir  string `json:"dir,omitempty"`
+	// By default the permissions included in the SCP upload messages take
+	// precedence, if the user has the chmod permission. If true the configured
+	// permissions are always applied. SFTP clients cannot request permissions
+	// while creating files and directories
+	Force bool `json:"force,omitempty"`
+}
+
+// IsEnabled returns true if the permissions for new files or directories are configured
+func (m *CreateModes) IsEnabled() bool {
+	return m.File != "" || m.Dir != ""
+}
+
+// GetModes returns the configured permissions for new files and directories,
+// 0 means not configured
+func (m *CreateModes) GetModes() (os.FileMode, os.FileMode) {
+	fileMode, _ := parseCreateMode(m.File)
+	dirMode, _ := parseCreateMode(m.Dir)
+	return fileMode, dirMode
+}
+
+func (m *CreateModes) validate() error {
+	m.File = strings.TrimSpace(m.File)
+	m.Dir = strings.TrimSpace(m.Dir)
+	if !m.IsEnabled() {
+		m.Force = false
+		return nil
+	}
+	if _, err := parseCreateMode(m.File); err != nil {
+		return util.NewValidationError(fmt.Sprintf("invalid file create mode %q: %v", m.File, err))
+	}
+	if _, err := parseCreateMode(m.Dir); err != nil {
+		return util.NewValidationError(fmt.Sprintf("invalid directory create mode %q: %v", m.Dir, err))
+	}
+	return nil
+}
+
+func parseCreateMode(val string) (os.FileMode, error) {
+	if val == "" {
+		return 0, nil
+	}
+	mode, err := strconv.ParseUint(val, 8, 32)
+	if err != nil {
+		return 0, err
+	}
+	if mode == 0 || mode > uint64(os.ModePerm) {
+		return 0, errors.New("out of range")
+	}
+	return os.FileMode(mode), nil
+}
+
+// Supported policies for the ownership change requests
+const (
+	// ChownPolicyReject tries to apply the requested owner and group and
+	// returns an error if this is not possible, this is the default
+	ChownPolicyReject = "reject"
+	// ChownPolicyIgnore returns success without changing anything
+	ChownPolicyIgnore = "ignore"
+	// ChownPolicyMap applies the configured owner and group, if the process
+	// has the required privileges, whatever owner and group are requested
+	ChownPolicyMap = "map"
+)
+
+var supportedChownPolicies = []string{ChownPolicyReject, ChownPolicyIgnore, ChownPolicyMap}
+
+// ChownPolicy defines how to handle the requests for changing the owner and
+// the group of files and directories
+type ChownPolicy struct {
+	// Policy name, empty means reject
+	Mode string `json:"mode,omitempty"`
+	// Owner and group applied by the map policy, -1 means unchanged
+	UID int `json:"uid,omitempty"`
+	GID int `json:"gid,omitempty"`
+}
+
+// IsEnabled returns true if a policy is configured
+func (p *ChownPolicy) IsEnabled() bool {
+	return p.Mode != ""
+}
+
+func (p *ChownPolicy) validate() error {
+	if p.Mode != ChownPolicyMap {
+		p.UID = 0
+		p.GID = 0
+	}
+	if !p.IsEnabled() {
+		return nil
+	}
+	if !slices.Contains(supportedChownPolicies, p.Mode) {
+		return util.NewValidationError(fmt.Sprintf("invalid chown policy %q", p.Mode))
+	}
+	if p.Mode == ChownPolicyMap {
+		if p.UID < -1 || p.UID > math.MaxInt32 || p.GID < -1 || p.GID > math.MaxInt32 {
+			return util.NewValidationError(fmt.Sprintf("invalid uid/gid %d/%d for the chown map policy", p.UID, p.GID))
+		}
+		if p.UID == -1 && p.GID == -1 {
+			return util.NewValidationError("the chown map policy requires an uid or a gid")
+		}
+	}
+	return nil
+}
+
+// Provenances for the client address of a connection
+const (
+	// SourceAddrDirect means that the client address is the transport peer address
+	SourceAddrDirect = "direct"
+	// SourceAddrProxy means that the client address was read from the proxy
+	// header sent by a trusted proxy
+	SourceAddrProxy = "proxy"
+	// SourceAddrUntrusted means that the proxy protocol is enabled but the proxy
+	// header was not used, the transport peer could be a proxy and not the client
+	SourceAddrUntrusted = "untrusted"
+)
+
+// ConnectionSource defines the transport peer and the provenance of the
+// client address for a connection
+type ConnectionSource struct {
+	PeerIP     string
+	Provenance string
+}
+
+// maximum size for the inline login banner
+const maxLoginBannerSize = 8192
+
+// LoginBanner defines a per-user SSH login banner, either as inline text or
+// as path to a file. The "%r" and "%d" tokens are replaced with the client IP
+// address and the current date
+type LoginBanner struct {
+	Text string `json:"text,omitempty"`
+	// Absolute path to a file containing the banner
+	File string `json:"file,omitempty"`
+}
+
+// IsEnabled returns true if a login banner is configured
+func (b *LoginBanner) IsEnabled() bool {
+	return b.Text != "" || b.File != ""
+}
+
+func (b *LoginBanner) validate() error {
+	if b.Text != "" && b.File != "" {
+		return util.NewValidationError("the login banner can be either an inline text or a file, not both")
+	}
+	if len(b.Text) > maxLoginBannerSize {
+		return util.NewValidationError(fmt.Sprintf("the login banner cannot be longer than %d bytes", maxLoginBannerSize))
+	}
+	if b.File != "" {
+		b.File = filepath.Clean(b.File)
+		if !filepath.IsAbs(b.File) {
+			return util.NewValidationError(fmt.Sprintf("the login banner file %q must be an absolute path", b.File))
+		}
+	}
+	return nil
+}
+
 // User defines a SFTPGo user
 type User struct {
 	sdk.BaseUser
+	// Metadata for the public keys, such as expiration date and last use
+	PublicKeysMetadata []UserPublicKey `json:"public_keys_metadata,omitempty"`
 	// Additional restrictions
 	Filters UserFilters `json:"filters"`
 	// Mapping between virtual paths and virtual folders
@@ -145,8 +366,19 @@ type User struct {
 	FsConfig vfs.Filesystem `json:"filesystem"`
 	// groups associated with this user
 	Groups []sdk.GroupMapping `json:"groups,omitempty"`
+	// Lockout state after repeated login failures, set only when the user is
+	// returned by the admin REST API and never stored
+	Lockout *UserLockout `json:"lockout,omitempty"`
+	// Successful logins counted against the login budget, see the "max_logins" filter
+	UsedLogins int `json:"used_logins,omitempty"`
+	// Remaining logins if the login budget is limited, set only when the user is
+	// returned by the admin REST API and never stored
+	RemainingLogins *int `json:"remaining_logins,omitempty"`
+	// Sources of the effective virtual folders and permissions, set only when
+	// the effective user settings are returned by the admin REST API and never stored
+	EffectiveSources *EffectiveSettingsSources `json:"effective_sources,omitempty"`
 	// we store the filesystem here using the base path as key.
-	fsCache map[string]vfs.Fs `json:"-"`
+	fsCache *fsCache `json:"-"`
 	// true if group settings are already applied for this user
 	groupSettingsApplied bool `json:"-"`
 	// in multi node setups we mark the user as deleted to be able to update the webdav cache
@@ -494,14 +726,17 @@ func (u *User) CloseFs() error {
 		return nil
 	}
 
-	var err error
-	for _, fs := range u.fsCache {
-		errClose := fs.Close()
-		if err == nil {
-			err = errClose
-		}
+	return u.fsCache.close()
+}
+
+// CancelFsOperations cancels the long running operations, such as listings
+// and recursive deletes, for the underlying filesystems
+func (u *User) CancelFsOperations() {
+	if u.fsCache == nil {
+		return
 	}
-	return err
+
+	u.fsCache.cancelOperations()
 }
 
 // IsPasswordHashed returns true if the password is hashed
@@ -531,8 +766,45 @@ func (u *User) SetEmptySecrets() {
 }
 
 // GetPermissionsForPath returns the permissions for the given path.
-// The path must be a SFTPGo virtual path
+// The path must be a SFTPGo virtual path. If the path is inside a virtual
+// folder with restricted permissions, the user permissions are intersected
+// with the folder ones
 func (u *User) GetPermissionsForPath(p string) []string {
+	permissions := u.getUserPermissionsForPath(p)
+	folder, err := u.GetVirtualFolderForPath(p)
+	if err != nil || len(folder.Permissions) == 0 {
+		return permissions
+	}
+	return intersectPermissions(permissions, folder.Permissions)
+}
+
+// hasPermission returns true if the specified permission is granted by perms,
+// the dropbox permission implies the upload and create_dirs ones
+func hasPermission(perms []string, permission string) bool {
+	if slices.Contains(perms, PermAny) || slices.Contains(perms, permission) {
+		return true
+	}
+	return (permission == PermUpload || permission == PermCreateDirs) && slices.Contains(perms, PermDropbox)
+}
+
+// intersectPermissions returns the permissions included in both the specified lists
+func intersectPermissions(userPerms, folderPerms []string) []string {
+	if slices.Contains(userPerms, PermAny) {
+		return folderPerms
+	}
+	if slices.Contains(folderPerms, PermAny) {
+		return userPerms
+	}
+	permissions := make([]string, 0, len(userPerms))
+	for _, perm := range userPerms {
+		if slices.Contains(folderPerms, perm) {
+			permissions = append(permissions, perm)
+		}
+	}
+	return permissions
+}
+
+func (u *User) getUserPermissionsForPath(p string) []string {
 	permissions := []string{}
 	if perms, ok := u.Permissions["/"]; ok {
 		// if only root permissions are defined returns them unconditionally
@@ -606,13 +878,13 @@ func (u *User) GetFsConfigForPath(virtualPath string) vfs.Filesystem {
 // GetFilesystemForPath returns the filesystem for the given path
 func (u *User) GetFilesystemForPath(virtualPath, connectionID string) (vfs.Fs, error) {
 	if u.fsCache == nil {
-		u.fsCache = make(map[string]vfs.Fs)
+		u.fsCache = newFsCache()
 	}
 	// allow to override the `/` path with a virtual folder
 	if len(u.VirtualFolders) > 0 {
 		folder, err := u.GetVirtualFolderForPath(virtualPath)
 		if err == nil {
-			if fs, ok := u.fsCache[folder.VirtualPath]; ok {
+			if fs, ok := u.fsCache.get(folder.VirtualPath); ok {
 				return fs, nil
 			}
 			forbiddenSelfUsers := []string{u.Username}
@@ -623,25 +895,98 @@ func (u *User) GetFilesystemForPath(virtualPath, connectionID string) (vfs.Fs, e
 				}
 				forbiddenSelfUsers = append(forbiddenSelfUsers, forbiddens...)
 			}
+			config := folder.FsConfig.GetACopy()
 			fs, err := folder.GetFilesystem(connectionID, forbiddenSelfUsers)
 			if err == nil {
-				u.fsCache[folder.VirtualPath] = fs
+				u.setCreateModes(fs)
+				fs = wrapFs(fs, &config)
+				u.fsCache.set(folder.VirtualPath, fs, config)
 			}
 			return fs, err
 		}
 	}
 
-	if val, ok := u.fsCache["/"]; ok {
+	if val, ok := u.fsCache.get("/"); ok {
 		return val, nil
 	}
+	config := u.FsConfig.GetACopy()
 	fs, err := u.getRootFs(connectionID)
 	if err != nil {
 		return fs, err
 	}
-	u.fsCache["/"] = fs
+	u.setCreateModes(fs)
+	fs = wrapFs(fs, &config)
+	u.fsCache.set("/", fs, config)
 	return fs, err
 }
 
+func (u *User) setCreateModes(fs vfs.Fs) {
+	if u.Filters.CreateModes.IsEnabled() {
+		fileMode, dirMode := u.Filters.CreateModes.GetModes()
+		vfs.SetCreateModes(fs, fileMode, dirMode)
+	}
+}
+
+// RefreshFilesystems replaces the cloud storage filesystems whose
+// configuration changed in updated, the same user reloaded from the data
+// provider, so new operations use the updated credentials without waiting
+// for a new connection. The running transfers continue to use the previous
+// filesystems. It returns the virtual paths for the replaced filesystems
+func (u *User) RefreshFilesystems(updated *User, connectionID string) []string {
+	if u.fsCache == nil {
+		return nil
+	}
+	var virtualPaths []string
+
+	current, ok := u.fsCache.getConfig("/")
+	if !ok {
+		current = u.FsConfig.GetACopy()
+	}
+	if isRefreshableFs(&current, &updated.FsConfig) {
+		// the filesystems are created from copies, the updated user is shared
+		// between connections and its secrets must not be decrypted
+		config := updated.FsConfig.GetACopy()
+		user := *updated
+		user.FsConfig = updated.FsConfig.GetACopy()
+		fs, err := user.getRootFs(connectionID)
+		if err == nil {
+			u.fsCache.set("/", wrapFs(fs, &config), config)
+			virtualPaths = append(virtualPaths, "/")
+		} else {
+			logger.Warn(logSender, connectionID, "unable to refresh the root filesystem for user %q: %v",
+				u.Username, err)
+		}
+	}
+	for idx := range u.VirtualFolders {
+		folder := &u.VirtualFolders[idx]
+		updatedIdx := slices.IndexFunc(updated.VirtualFolders, func(f vfs.VirtualFolder) bool {
+			return f.VirtualPath == folder.VirtualPath && f.Name == folder.Name
+		})
+		if updatedIdx == -1 {
+			continue
+		}
+		updatedFolder := updated.VirtualFolders[updatedIdx]
+		current, ok := u.fsCache.getConfig(folder.VirtualPath)
+		if !ok {
+			current = folder.FsConfig.GetACopy()
+		}
+		if !isRefreshableFs(&current, &updatedFolder.FsConfig) {
+			continue
+		}
+		config := updatedFolder.FsConfig.GetACopy()
+		updatedFolder.FsConfig = updatedFolder.FsConfig.GetACopy()
+		fs, err := updatedFolder.GetFilesystem(connectionID, []string{u.Username})
+		if err != nil {
+			logger.Warn(logSender, connectionID, "unable to refresh the filesystem for folder %q, user %q: %v",
+				folder.Name, u.Username, err)
+			continue
+		}
+		u.fsCache.set(folder.VirtualPath, wrapFs(fs, &config), config)
+		virtualPaths = append(virtualPaths, folder.VirtualPath)
+	}
+	return virtualPaths
+}
+
 // GetVirtualFolderForPath returns the virtual folder containing the specified virtual path.
 // If the path is not inside a virtual folder an error is returned
 func (u *User) GetVirtualFolderForPath(virtualPath string) (vfs.VirtualFolder, error) {
@@ -661,6 +1006,24 @@ func (u *User) GetVirtualFolderForPath(virtualPath string) (vfs.VirtualFolder, e
 	return folder, errNoMatchingVirtualFolder
 }
 
+// GetMaxUploadFileSize returns the maximum allowed size for a file uploaded to
+// the specified virtual path, 0 means no limit. The first upload size limit
+// matching the path takes precedence, then the limit defined for the virtual
+// folder containing the path and finally the user level limit
+func (u *User) GetMaxUploadFileSize(virtualPath string) int64 {
+	for idx := range u.Filters.UploadSizeLimits {
+		if u.Filters.UploadSizeLimits[idx].matches(virtualPath) {
+			return u.Filters.UploadSizeLimits[idx].MaxSize
+		}
+	}
+	if folder, err := u.GetVirtualFolderForPath(path.Dir(virtualPath)); err == nil {
+		if folder.MaxUploadFileSize > 0 {
+			return folder.MaxUploadFileSize
+		}
+	}
+	return u.Filters.MaxUploadFileSize
+}
+
 // ScanQuota scans the user home dir and virtual folders, included in its quota,
 // and returns the number of files and their size
 func (u *User) ScanQuota() (int, int64, error) {
@@ -855,21 +1218,14 @@ func (u *User) HasPermissionsInside(virtualPath string) bool {
 
 // HasPerm returns true if the user has the given permission or any permission
 func (u *User) HasPerm(permission, path string) bool {
-	perms := u.GetPermissionsForPath(path)
-	if slices.Contains(perms, PermAny) {
-		return true
-	}
-	return slices.Contains(perms, permission)
+	return hasPermission(u.GetPermissionsForPath(path), permission)
 }
 
 // HasAnyPerm returns true if the user has at least one of the given permissions
 func (u *User) HasAnyPerm(permissions []string, path string) bool {
 	perms := u.GetPermissionsForPath(path)
-	if slices.Contains(perms, PermAny) {
-		return true
-	}
 	for _, permission := range permissions {
-		if slices.Contains(perms, permission) {
+		if hasPermission(perms, permission) {
 			return true
 		}
 	}
@@ -879,17 +1235,20 @@ func (u *User) HasAnyPerm(permissions []string, path string) bool {
 // HasPerms returns true if the user has all the given permissions
 func (u *User) HasPerms(permissions []string, path string) bool {
 	perms := u.GetPermissionsForPath(path)
-	if slices.Contains(perms, PermAny) {
-		return true
-	}
 	for _, permission := range permissions {
-		if !slices.Contains(perms, permission) {
+		if !hasPermission(perms, permission) {
 			return false
 		}
 	}
 	return true
 }
 
+// IsDropboxPath returns true if the user has the upload only dropbox
+// permission for the given path
+func (u *User) IsDropboxPath(path string) bool {
+	return slices.Contains(u.GetPermissionsForPath(path), PermDropbox)
+}
+
 // HasPermsDeleteAll returns true if the user can delete both files and directories
 // for the given path
 func (u *User) HasPermsDeleteAll(path string) bool {
@@ -1031,21 +1390,28 @@ func (u *User) getPatternsFilterForPath(virtualPath string) sdk.PatternsFilter {
 }
 
 func (u *User) isDirHidden(virtualPath string) bool {
+	_, ok := u.getHidingPatternsFilter(virtualPath)
+	return ok
+}
+
+// getHidingPatternsFilter returns the filter hiding the specified directory
+// or one of its parents, if any
+func (u *User) getHidingPatternsFilter(virtualPath string) (sdk.PatternsFilter, bool) {
 	if len(u.Filters.FilePatterns) == 0 {
-		return false
+		return sdk.PatternsFilter{}, false
 	}
 	for _, dirPath := range util.GetDirsForVirtualPath(virtualPath) {
 		if dirPath == "/" {
-			return false
+			return sdk.PatternsFilter{}, false
 		}
 		filter := u.getPatternsFilterForPath(dirPath)
 		if filter.DenyPolicy == sdk.DenyPolicyHide && filter.Path != dirPath {
 			if !filter.CheckAllowed(path.Base(dirPath)) {
-				return true
+				return filter, true
 			}
 		}
 	}
-	return false
+	return sdk.PatternsFilter{}, false
 }
 
 func (u *User) getMinPasswordEntropy() float64 {
@@ -1058,12 +1424,21 @@ func (u *User) getMinPasswordEntropy() float64 {
 // IsFileAllowed returns true if the specified file is allowed by the file restrictions filters.
 // The second parameter returned is the deny policy
 func (u *User) IsFileAllowed(virtualPath string) (bool, int) {
+	filter, allowed := u.GetPatternsFilterForFile(virtualPath)
+	return allowed, filter.DenyPolicy
+}
+
+// GetPatternsFilterForFile returns the patterns filter applying to the specified
+// file and true if the file is allowed. If a parent directory is hidden, the
+// filter hiding it is returned. The returned filter has an empty path if no
+// filter applies
+func (u *User) GetPatternsFilterForFile(virtualPath string) (sdk.PatternsFilter, bool) {
 	dirPath := path.Dir(virtualPath)
-	if u.isDirHidden(dirPath) {
-		return false, sdk.DenyPolicyHide
+	if filter, ok := u.getHidingPatternsFilter(dirPath); ok {
+		return filter, false
 	}
 	filter := u.getPatternsFilterForPath(dirPath)
-	return filter.CheckAllowed(path.Base(virtualPath)), filter.DenyPolicy
+	return filter, filter.CheckAllowed(path.Base(virtualPath))
 }
 
 // CanManageMFA returns true if the user can add a multi-factor authentication configuration
@@ -1197,12 +1572,25 @@ func (u *User) InactivityDays(when time.Time) int {
 	return int(float64(when.Sub(util.GetTimeFromMsecSinceEpoch(lastActivity))) / float64(24*time.Hour))
 }
 
+// GetPasswordExpiration returns the number of days after which the password
+// expires, 0 means no expiration. The user and group setting takes precedence
+// over the password policy
+func (u *User) GetPasswordExpiration() int {
+	if u.Filters.PasswordExpiration > 0 {
+		return u.Filters.PasswordExpiration
+	}
+	if u.Password == "" {
+		return 0
+	}
+	return config.PasswordPolicy.MaxAge
+}
+
 // PasswordExpiresIn returns the number of days before the password expires.
 // The returned value is negative if the password is expired.
-// The caller must ensure that a PasswordExpiration is set
+// The caller must ensure that a password expiration is set
 func (u *User) PasswordExpiresIn() int {
 	lastPwdChange := util.GetTimeFromMsecSinceEpoch(u.LastPasswordChange)
-	pwdExpiration := lastPwdChange.Add(time.Duration(u.Filters.PasswordExpiration) * 24 * time.Hour)
+	pwdExpiration := lastPwdChange.Add(time.Duration(u.GetPasswordExpiration()) * 24 * time.Hour)
 	res := int(math.Round(float64(time.Until(pwdExpiration)) / float64(24*time.Hour)))
 	if res == 0 && pwdExpiration.After(time.Now()) {
 		res = 1
@@ -1210,16 +1598,22 @@ func (u *User) PasswordExpiresIn() int {
 	return res
 }
 
+// IsPasswordExpired returns true if the password is expired
+func (u *User) IsPasswordExpired() bool {
+	expiration := u.GetPasswordExpiration()
+	if expiration == 0 {
+		return false
+	}
+	lastPwdChange := util.GetTimeFromMsecSinceEpoch(u.LastPasswordChange)
+	return lastPwdChange.Add(time.Duration(expiration) * 24 * time.Hour).Before(time.Now())
+}
+
 // MustChangePassword returns true if the user must change the password
 func (u *User) MustChangePassword() bool {
 	if u.Filters.RequirePasswordChange {
 		return true
 	}
-	if u.Filters.PasswordExpiration == 0 {
-		return false
-	}
-	lastPwdChange := util.GetTimeFromMsecSinceEpoch(u.LastPasswordChange)
-	return lastPwdChange.Add(time.Duration(u.Filters.PasswordExpiration) * 24 * time.Hour).Before(time.Now())
+	return u.IsPasswordExpired()
 }
 
 // MustSetSecondFactor returns true if the user must set a second factor authentication
@@ -1251,6 +1645,55 @@ func (u *User) MustSetSecondFactorForProtocol(protocol string) bool {
 	return false
 }
 
+// IsSecondFactorSkipped returns true if the second factor authentication is
+// not required because the specified IP is inside a trusted network
+func (u *User) IsSecondFactorSkipped(ip, protocol string) bool {
+	if len(u.Filters.TwoFactorTrustedNetworks) == 0 {
+		return false
+	}
+	if network, ok := u.getTwoFactorTrustedNetwork(ip); ok {
+		providerLog(logger.LevelInfo, "second factor authentication skipped for user %q, protocol %s, ip %s is inside the trusted network %s",
+			u.Username, protocol, ip, network)
+		return true
+	}
+	providerLog(logger.LevelDebug, "second factor authentication enforced for user %q, protocol %s, ip %s is not trusted",
+		u.Username, protocol, ip)
+	return false
+}
+
+func (u *User) getTwoFactorTrustedNetwork(ip string) (string, bool) {
+	remoteIP := net.ParseIP(ip)
+	if remoteIP == nil {
+		return "", false
+	}
+	for _, network := range u.Filters.TwoFactorTrustedNetworks {
+		_, ipNet, err := net.ParseCIDR(network)
+		if err != nil {
+			continue
+		}
+		if ipNet.Contains(remoteIP) {
+			return network, true
+		}
+	}
+	return "", false
+}
+
+// GetSecondFactorStatus returns the second factor authentication status for a
+// login from the specified IP and protocol
+func (u *User) GetSecondFactorStatus(ip, protocol string, loginErr error) string {
+	isConfigured := u.Filters.TOTPConfig.Enabled && slices.Contains(u.Filters.TOTPConfig.Protocols, protocol)
+	if !isConfigured && !slices.Contains(u.Filters.TwoFactorAuthProtocols, protocol) {
+		return SecondFactorStatusNotConfigured
+	}
+	if _, ok := u.getTwoFactorTrustedNetwork(ip); ok {
+		return SecondFactorStatusSkippedByNetwork
+	}
+	if loginErr != nil {
+		return SecondFactorStatusRequired
+	}
+	return SecondFactorStatusSatisfied
+}
+
 // GetSignature returns a signature for this user.
 // It will change after an update
 func (u *User) GetSignature() string {
@@ -1279,6 +1722,15 @@ func (u *User) GetBandwidthForIP(clientIP, connectionID string) (int64, int64) {
 	return u.UploadBandwidth, u.DownloadBandwidth
 }
 
+// IsSourceAddrProvenanceAllowed returns false if the user requires a trusted
+// client address and the given provenance is untrusted or unknown
+func (u *User) IsSourceAddrProvenanceAllowed(provenance string) bool {
+	if !u.Filters.RequireTrustedSourceAddr {
+		return true
+	}
+	return provenance == SourceAddrDirect || provenance == SourceAddrProxy
+}
+
 // IsLoginFromAddrAllowed returns true if the login is allowed from the specified remoteAddr.
 // If AllowedIP is defined only the specified IP/Mask can login.
 // If DeniedIP is defined the specified IP/Mask cannot login.
@@ -1319,9 +1771,70 @@ func (u *User) GetPermissionsAsJSON() ([]byte, error) {
 	return json.Marshal(u.Permissions)
 }
 
-// GetPublicKeysAsJSON returns the public keys as json byte array
+// GetPublicKeysAsJSON returns the public keys, including their metadata, as json byte array
 func (u *User) GetPublicKeysAsJSON() ([]byte, error) {
-	return json.Marshal(u.PublicKeys)
+	return json.Marshal(u.getPublicKeys())
+}
+
+// setPublicKeys sets the public keys and their metadata
+func (u *User) setPublicKeys(keys []UserPublicKey) {
+	u.PublicKeys = make([]string, 0, len(keys))
+	u.PublicKeysMetadata = make([]UserPublicKey, 0, len(keys))
+	for _, k := range keys {
+		u.PublicKeys = append(u.PublicKeys, k.Key)
+		u.PublicKeysMetadata = append(u.PublicKeysMetadata, k)
+	}
+}
+
+// getPublicKeys returns the user public keys with their metadata.
+// The public keys list is authoritative, metadata for keys not included
+// in this list are ignored
+func (u *User) getPublicKeys() []UserPublicKey {
+	metadata := make(map[string]UserPublicKey)
+	for _, k := range u.PublicKeysMetadata {
+		metadata[k.Key] = k
+	}
+	keys := make([]UserPublicKey, 0, len(u.PublicKeys))
+	for _, key := range u.PublicKeys {
+		if k, ok := metadata[key]; ok {
+			keys = append(keys, k)
+			delete(metadata, key)
+			continue
+		}
+		keys = append(keys, UserPublicKey{Key: key})
+	}
+	return keys
+}
+
+// mergePublicKeysMetadata preserves the last use from the stored user and
+// the metadata for the keys without explicit metadata
+func (u *User) mergePublicKeysMetadata(stored *User) {
+	storedKeys := make(map[string]UserPublicKey)
+	for _, k := range stored.getPublicKeys() {
+		if fp := getPublicKeyFingerprint(k.Key); fp != "" {
+			storedKeys[fp] = k
+		}
+	}
+	if len(storedKeys) == 0 {
+		return
+	}
+	withMetadata := make(map[string]bool)
+	for _, k := range u.PublicKeysMetadata {
+		withMetadata[k.Key] = true
+	}
+	keys := u.getPublicKeys()
+	for idx := range keys {
+		storedKey, ok := storedKeys[getPublicKeyFingerprint(keys[idx].Key)]
+		if !ok {
+			continue
+		}
+		keys[idx].LastUsedAt = storedKey.LastUsedAt
+		if !withMetadata[keys[idx].Key] {
+			keys[idx].Comment = storedKey.Comment
+			keys[idx].ExpiresAt = storedKey.ExpiresAt
+		}
+	}
+	u.setPublicKeys(keys)
 }
 
 // GetFiltersAsJSON returns the filters as json byte array
@@ -1623,6 +2136,18 @@ func (u *User) mergeWithPrimaryGroup(group *Group, replacer *strings.Replacer) {
 	if u.ExpirationDate == 0 && group.UserSettings.ExpiresIn > 0 {
 		u.ExpirationDate = u.CreatedAt + int64(group.UserSettings.ExpiresIn)*86400000
 	}
+	if len(u.Filters.AllowedKeyAlgos) == 0 {
+		u.Filters.AllowedKeyAlgos = group.UserSettings.AllowedKeyAlgos
+	}
+	if len(u.Filters.TwoFactorTrustedNetworks) == 0 {
+		u.Filters.TwoFactorTrustedNetworks = group.UserSettings.TwoFactorTrustedNetworks
+	}
+	if !u.Filters.CreateModes.IsEnabled() {
+		u.Filters.CreateModes = group.UserSettings.CreateModes
+	}
+	if !u.Filters.ChownPolicy.IsEnabled() {
+		u.Filters.ChownPolicy = group.UserSettings.ChownPolicy
+	}
 	u.mergePrimaryGroupFilters(&group.UserSettings.Filters, replacer)
 	u.mergeAdditiveProperties(group, sdk.GroupTypePrimary, replacer)
 }
@@ -1704,6 +2229,8 @@ func (u *User) mergeVirtualFolders(group *Group, groupType int, replacer *string
 				folder.MappedPath = u.replacePlaceholder(folder.MappedPath, replacer)
 				folder.FsConfig = u.replaceFsConfigPlaceholders(folder.FsConfig, replacer)
 				u.VirtualFolders = append(u.VirtualFolders, folder)
+				folderPaths[folder.VirtualPath] = true
+				u.EffectiveSources.addVirtualFolder(folder.VirtualPath, group.Name, groupType)
 			}
 		}
 	}
@@ -1713,17 +2240,24 @@ func (u *User) mergePermissions(group *Group, groupType int, replacer *strings.R
 	if u.Permissions == nil {
 		u.Permissions = make(map[string][]string)
 	}
+	merge := shouldMergePermissions(group, groupType)
 	for k, v := range group.UserSettings.Permissions {
 		if k == "/" {
+			// the root permissions are required for each user, so the primary
+			// group can only override them
 			if groupType == sdk.GroupTypePrimary {
 				u.Permissions[k] = v
-			} else {
-				continue
+				u.EffectiveSources.addPermissions(k, group.Name, groupType, true)
 			}
+			continue
 		}
 		k = u.replacePlaceholder(k, replacer)
-		if _, ok := u.Permissions[k]; !ok {
+		if perms, ok := u.Permissions[k]; !ok {
 			u.Permissions[k] = v
+			u.EffectiveSources.addPermissions(k, group.Name, groupType, true)
+		} else if merge {
+			u.Permissions[k] = unionPermissions(perms, v)
+			u.EffectiveSources.addPermissions(k, group.Name, groupType, false)
 		}
 	}
 }
@@ -1768,6 +2302,8 @@ func (u *User) getACopy() User {
 	u.SetEmptySecretsIfNil()
 	pubKeys := make([]string, len(u.PublicKeys))
 	copy(pubKeys, u.PublicKeys)
+	pubKeysMetadata := make([]UserPublicKey, len(u.PublicKeysMetadata))
+	copy(pubKeysMetadata, u.PublicKeysMetadata)
 	virtualFolders := make([]vfs.VirtualFolder, 0, len(u.VirtualFolders))
 	for idx := range u.VirtualFolders {
 		vfolder := u.VirtualFolders[idx].GetACopy()
@@ -1797,6 +2333,21 @@ func (u *User) getACopy() User {
 	copy(filters.TOTPConfig.Protocols, u.Filters.TOTPConfig.Protocols)
 	filters.AdditionalEmails = make([]string, len(u.Filters.AdditionalEmails))
 	copy(filters.AdditionalEmails, u.Filters.AdditionalEmails)
+	filters.AllowedKeyAlgos = make([]string, len(u.Filters.AllowedKeyAlgos))
+	copy(filters.AllowedKeyAlgos, u.Filters.AllowedKeyAlgos)
+	filters.TwoFactorTrustedNetworks = make([]string, len(u.Filters.TwoFactorTrustedNetworks))
+	copy(filters.TwoFactorTrustedNetworks, u.Filters.TwoFactorTrustedNetworks)
+	filters.TransferQuotaWindow = u.Filters.TransferQuotaWindow
+	filters.UploadChecksum = u.Filters.UploadChecksum
+	filters.UploadSizeLimits = make([]UploadSizeLimit, len(u.Filters.UploadSizeLimits))
+	copy(filters.UploadSizeLimits, u.Filters.UploadSizeLimits)
+	filters.CreateModes = u.Filters.CreateModes
+	filters.ChownPolicy = u.Filters.ChownPolicy
+	filters.LoginBanner = u.Filters.LoginBanner
+	filters.RequireTrustedSourceAddr = u.Filters.RequireTrustedSourceAddr
+	filters.SessionRecording = u.Filters.SessionRecording
+	filters.MaxLogins = u.Filters.MaxLogins
+	filters.DisableAfterFirstSession = u.Filters.DisableAfterFirstSession
 	filters.RecoveryCodes = make([]RecoveryCode, 0, len(u.Filters.RecoveryCodes))
 	for _, code := range u.Filters.RecoveryCodes {
 		if code.Secret == nil {
@@ -1845,10 +2396,12 @@ func (u *User) getACopy() User {
 			UpdatedAt:                u.UpdatedAt,
 			Role:                     u.Role,
 		},
+		PublicKeysMetadata:   pubKeysMetadata,
 		Filters:              filters,
 		VirtualFolders:       virtualFolders,
 		Groups:               groups,
 		FsConfig:             u.FsConfig.GetACopy(),
+		UsedLogins:           u.UsedLogins,
 		groupSettingsApplied: u.groupSettingsApplied,
 	}
 }
@@ -0,0 +1,231 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/metric"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+var (
+	cachedUserLookups = userLookupCache{
+		entries: make(map[string]userLookupCacheEntry),
+	}
+)
+
+// UserCacheConfig defines the cache for the users fetched from the data provider
+// while authenticating. Only the user object is cached, the credentials are
+// verified for each authentication attempt
+type UserCacheConfig struct {
+	// Set to true to enable the cache
+	Enabled bool `json:"enabled" mapstructure:"enabled"`
+	// Time to live for the cached users as seconds. The cached users are invalidated
+	// when updated from this instance, in multi-instance setups the updates made
+	// by other instances are detected after the TTL expiration or, if the change
+	// feed is enabled, within a few seconds
+	TTL int `json:"ttl" mapstructure:"ttl"`
+	// Maximum number of cached users
+	MaxSize int `json:"max_size" mapstructure:"max_size"`
+}
+
+func (c *UserCacheConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.TTL <= 0 {
+		return util.NewValidationError("user cache TTL must be greater than 0")
+	}
+	if c.MaxSize <= 0 {
+		return util.NewValidationError("user cache max size must be greater than 0")
+	}
+	return nil
+}
+
+type userLookupCacheEntry struct {
+	user      User
+	expiresAt int64
+}
+
+type userLookupCache struct {
+	sync.RWMutex
+	entries map[string]userLookupCacheEntry
+	// incremented each time an entry is invalidated, a user fetched before
+	// an invalidation is not cached since it could be stale
+	generation uint64
+	// last object change processed while checking the change feed
+	lastChangeID atomic.Int64
+}
+
+func (c *userLookupCache) getGeneration() uint64 {
+	c.RLock()
+	defer c.RUnlock()
+
+	return c.generation
+}
+
+func (c *userLookupCache) get(username string) (User, bool) {
+	c.RLock()
+	entry, ok := c.entries[username]
+	c.RUnlock()
+
+	if !ok || entry.expiresAt < util.GetTimeAsMsSinceEpoch(time.Now()) {
+		metric.AddUserCacheMiss()
+		return User{}, false
+	}
+	metric.AddUserCacheHit()
+	return entry.user.getACopy(), true
+}
+
+// add caches the given user if no invalidation happened after the specified generation
+func (c *userLookupCache) add(user *User, generation uint64) {
+	if user.Username == "" {
+		return
+	}
+	now := util.GetTimeAsMsSinceEpoch(time.Now())
+
+	c.Lock()
+	defer c.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+	if _, ok := c.entries[user.Username]; !ok && len(c.entries) >= config.UserCache.MaxSize {
+		c.evict(now)
+	}
+	c.entries[user.Username] = userLookupCacheEntry{
+		user:      user.getACopy(),
+		expiresAt: now + int64(config.UserCache.TTL)*1000,
+	}
+}
+
+// evict removes the expired entries or, if none is expired, the entry
+// closest to expiration. It must be called with the lock held
+func (c *userLookupCache) evict(now int64) {
+	var oldestKey string
+	var oldestExpiration int64
+	for k, v := range c.entries {
+		if v.expiresAt < now {
+			delete(c.entries, k)
+			continue
+		}
+		if oldestKey == "" || v.expiresAt < oldestExpiration {
+			oldestKey = k
+			oldestExpiration = v.expiresAt
+		}
+	}
+	if len(c.entries) >= config.UserCache.MaxSize && oldestKey != "" {
+		delete(c.entries, oldestKey)
+	}
+}
+
+func (c *userLookupCache) updateLastLogin(username string) {
+	c.Lock()
+	defer c.Unlock()
+
+	if entry, ok := c.entries[username]; ok {
+		entry.user.LastLogin = util.GetTimeAsMsSinceEpoch(time.Now())
+		c.entries[username] = entry
+	}
+}
+
+func (c *userLookupCache) remove(username string) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.generation++
+	delete(c.entries, username)
+}
+
+func (c *userLookupCache) cleanup() {
+	if !config.UserCache.Enabled {
+		return
+	}
+	now := util.GetTimeAsMsSinceEpoch(time.Now())
+
+	c.Lock()
+	defer c.Unlock()
+
+	for k, v := range c.entries {
+		if v.expiresAt < now {
+			delete(c.entries, k)
+		}
+	}
+}
+
+func (c *userLookupCache) clear() {
+	c.Lock()
+	defer c.Unlock()
+
+	c.generation++
+	c.entries = make(map[string]userLookupCacheEntry)
+}
+
+// checkChanges invalidates the users changed by other instances, it requires the change feed.
+// Resuming from the last seen identifier is safe, the changes are committed in sequence order
+func (c *userLookupCache) checkChanges() {
+	for {
+		lastID := c.lastChangeID.Load()
+		changes, err := provider.getObjectChanges(lastID, objectChangesMaxLimit)
+		if err != nil {
+			providerLog(logger.LevelError, "unable to get object changes for the user cache: %v", err)
+			return
+		}
+		for _, change := range changes {
+			switch change.ObjectType {
+			case actionObjectUser:
+				c.remove(change.ObjectName)
+			case actionObjectFolder:
+				// the folder definitions are embedded in the users
+				c.clear()
+			}
+			lastID = change.ID
+		}
+		c.lastChangeID.Store(lastID)
+		if len(changes) < objectChangesMaxLimit {
+			return
+		}
+	}
+}
+
+// getUserForAuth returns the user with the specified username from the cache, if
+// enabled, or from the data provider. It must be used only within the Check*
+// authentication functions, the other lookups always read the data provider
+func getUserForAuth(username string) (User, error) {
+	if !config.UserCache.Enabled {
+		return provider.userExists(username, "")
+	}
+	if user, ok := cachedUserLookups.get(username); ok {
+		return user, nil
+	}
+	generation := cachedUserLookups.getGeneration()
+	user, err := provider.userExists(username, "")
+	if err != nil {
+		return user, err
+	}
+	cachedUserLookups.add(&user, generation)
+	return user, nil
+}
+
+// invalidateCachedUser removes the specified user from the user cache
+func invalidateCachedUser(username string) {
+	if config.UserCache.Enabled {
+		cachedUserLookups.remove(username)
+	}
+}
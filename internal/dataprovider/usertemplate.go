@@ -0,0 +1,298 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/sftpgo/sdk"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+var (
+	userTemplatePlaceholderRegex = regexp.MustCompile(`%[a-zA-Z][a-zA-Z0-9_]+%`)
+	userTemplateVariableRegex    = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_]+$`)
+	reservedTemplateVariables    = []string{"username", "uid", "gid"}
+)
+
+// UserTemplate defines a stored user whose string fields may contain placeholders.
+// Users with the same structure are created from a template by replacing the
+// "%username%", "%uid%", "%gid%" and the custom "%variable%" placeholders
+type UserTemplate struct {
+	// Data provider unique identifier
+	ID int64 `json:"id"`
+	// Template name
+	Name string `json:"name"`
+	// optional description
+	Description string `json:"description,omitempty"`
+	// Creation time as unix timestamp in milliseconds
+	CreatedAt int64 `json:"created_at"`
+	// last update time as unix timestamp in milliseconds
+	UpdatedAt int64 `json:"updated_at"`
+	// The template user. Username, password and public keys are defined when
+	// a user is created from this template. The virtual folders that do not
+	// exist are created using their definitions within the template
+	User User `json:"user"`
+}
+
+// UserTemplateVariables defines the values used to create a user from a template
+type UserTemplateVariables struct {
+	Username   string   `json:"username"`
+	Password   string   `json:"password,omitempty"`
+	PublicKeys []string `json:"public_keys,omitempty"`
+	// UID and GID for the user, the template ones are used if not set
+	UID *int `json:"uid,omitempty"`
+	GID *int `json:"gid,omitempty"`
+	// Values for the custom placeholders, for example the "dept" key
+	// replaces the "%dept%" placeholder
+	Variables map[string]string `json:"variables,omitempty"`
+}
+
+func (v *UserTemplateVariables) validate() error {
+	if v.Username == "" {
+		return util.NewI18nError(util.NewValidationError("username is mandatory"), util.I18nErrorUsernameRequired)
+	}
+	for name := range v.Variables {
+		if !userTemplateVariableRegex.MatchString(name) {
+			return util.NewValidationError(fmt.Sprintf("invalid variable name %q", name))
+		}
+		if slices.Contains(reservedTemplateVariables, name) {
+			return util.NewValidationError(fmt.Sprintf("variable name %q is reserved", name))
+		}
+	}
+	return nil
+}
+
+// RenderAsJSON implements the renderer interface used within plugins
+func (t *UserTemplate) RenderAsJSON(reload bool) ([]byte, error) {
+	if reload {
+		template, err := provider.userTemplateExists(t.Name)
+		if err != nil {
+			providerLog(logger.LevelError, "unable to reload user template before rendering as json: %v", err)
+			return nil, err
+		}
+		template.PrepareForRendering()
+		return json.Marshal(template)
+	}
+	t.PrepareForRendering()
+	return json.Marshal(t)
+}
+
+// GetEncryptionAdditionalData returns the additional data to use for AEAD
+func (t *UserTemplate) GetEncryptionAdditionalData() string {
+	return fmt.Sprintf("user_template_%v", t.Name)
+}
+
+// PrepareForRendering prepares a user template for rendering.
+// It hides confidential data and sets to nil the empty secrets
+// so they are not serialized
+func (t *UserTemplate) PrepareForRendering() {
+	t.User.PrepareForRendering()
+}
+
+// SetEmptySecretsIfNil sets the secrets to empty if nil
+func (t *UserTemplate) SetEmptySecretsIfNil() {
+	t.User.SetEmptySecretsIfNil()
+}
+
+func (t *UserTemplate) validate() error {
+	if t.Name == "" {
+		return util.NewI18nError(util.NewValidationError("name is mandatory"), util.I18nErrorNameRequired)
+	}
+	if len(t.Name) > 255 {
+		return util.NewValidationError("name is too long, 255 is the maximum length allowed")
+	}
+	if config.NamingRules&1 == 0 && !usernameRegex.MatchString(t.Name) {
+		return util.NewI18nError(
+			util.NewValidationError(fmt.Sprintf("name %q is not valid, the following characters are allowed: a-zA-Z0-9-_.~", t.Name)),
+			util.I18nErrorInvalidName,
+		)
+	}
+	t.resetUserFields()
+	if t.User.hasRedactedSecret() {
+		return util.NewValidationError("cannot save a user template with a redacted secret")
+	}
+	if err := t.User.FsConfig.Validate(t.GetEncryptionAdditionalData()); err != nil {
+		return err
+	}
+	for idx := range t.User.VirtualFolders {
+		folder := &t.User.VirtualFolders[idx]
+		if folder.Name == "" {
+			return util.NewI18nError(util.NewValidationError("folder name is mandatory"), util.I18nErrorFolderNameRequired)
+		}
+		if err := folder.FsConfig.Validate(t.GetEncryptionAdditionalData()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resetUserFields clears the user fields that are not inherited by the users
+// created from this template
+func (t *UserTemplate) resetUserFields() {
+	t.User.ID = 0
+	t.User.Username = ""
+	t.User.Password = ""
+	t.User.HasPassword = false
+	t.User.PublicKeys = nil
+	t.User.PublicKeysMetadata = nil
+	t.User.UsedQuotaSize = 0
+	t.User.UsedQuotaFiles = 0
+	t.User.LastQuotaUpdate = 0
+	t.User.UsedUploadDataTransfer = 0
+	t.User.UsedDownloadDataTransfer = 0
+	t.User.LastLogin = 0
+	t.User.FirstDownload = 0
+	t.User.FirstUpload = 0
+	t.User.LastPasswordChange = 0
+	t.User.CreatedAt = 0
+	t.User.UpdatedAt = 0
+	t.User.UsedLogins = 0
+	t.User.OIDCCustomFields = nil
+	t.User.Lockout = nil
+	t.User.RemainingLogins = nil
+	t.User.EffectiveSources = nil
+	t.User.Filters.TOTPConfig = UserTOTPConfig{}
+	t.User.Filters.RecoveryCodes = nil
+	t.User.SetEmptySecretsIfNil()
+	for idx := range t.User.VirtualFolders {
+		folder := &t.User.VirtualFolders[idx]
+		folder.ID = 0
+		folder.UsedQuotaSize = 0
+		folder.UsedQuotaFiles = 0
+		folder.LastQuotaUpdate = 0
+		folder.Users = nil
+		folder.Groups = nil
+		folder.QuotaScan = nil
+	}
+}
+
+func (t *UserTemplate) getACopy() UserTemplate {
+	return UserTemplate{
+		ID:          t.ID,
+		Name:        t.Name,
+		Description: t.Description,
+		CreatedAt:   t.CreatedAt,
+		UpdatedAt:   t.UpdatedAt,
+		User:        t.User.getACopy(),
+	}
+}
+
+// expand returns the user obtained by replacing the placeholders with the given
+// variables. The replacement is done on the JSON representation so all the string
+// fields are expanded, the values are JSON escaped
+func (t *UserTemplate) expand(vars *UserTemplateVariables) (User, error) {
+	var user User
+	if err := vars.validate(); err != nil {
+		return user, err
+	}
+	templateUser := t.User
+	if vars.UID != nil {
+		templateUser.UID = *vars.UID
+	}
+	if vars.GID != nil {
+		templateUser.GID = *vars.GID
+	}
+	values := map[string]string{
+		"username": vars.Username,
+		"uid":      strconv.Itoa(templateUser.UID),
+		"gid":      strconv.Itoa(templateUser.GID),
+	}
+	for k, v := range vars.Variables {
+		values[k] = v
+	}
+	replacements := make([]string, 0, 2*len(values))
+	for k, v := range values {
+		escaped, err := json.Marshal(v)
+		if err != nil {
+			return user, err
+		}
+		replacements = append(replacements, "%"+k+"%", string(escaped[1:len(escaped)-1]))
+	}
+	data, err := json.Marshal(templateUser)
+	if err != nil {
+		return user, err
+	}
+	expanded := strings.NewReplacer(replacements...).Replace(string(data))
+	if unresolved := userTemplatePlaceholderRegex.FindAllString(expanded, -1); len(unresolved) > 0 {
+		return user, util.NewValidationError(fmt.Sprintf("unresolved placeholders: %s",
+			strings.Join(util.RemoveDuplicates(unresolved, false), ", ")))
+	}
+	if err := json.Unmarshal([]byte(expanded), &user); err != nil {
+		return user, err
+	}
+	user.Username = vars.Username
+	user.Password = vars.Password
+	user.PublicKeys = vars.PublicKeys
+	return user, nil
+}
+
+// getTemplateFoldersToCreate returns the virtual folders of the expanded user that do
+// not exist yet and that must be created using the template definitions
+func getTemplateFoldersToCreate(user *User) ([]vfs.BaseVirtualFolder, error) {
+	var folders []vfs.BaseVirtualFolder
+	for idx := range user.VirtualFolders {
+		folder := user.VirtualFolders[idx].BaseVirtualFolder.GetACopy()
+		folder.Name = config.convertName(folder.Name)
+		_, err := provider.getFolderByName(folder.Name)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, util.ErrNotFound) {
+			return nil, err
+		}
+		if folder.MappedPath == "" && folder.FsConfig.Provider == sdk.LocalFilesystemProvider {
+			return nil, util.NewValidationError(fmt.Sprintf("the folder %q does not exist and it is not defined in the template",
+				folder.Name))
+		}
+		folder.Users = nil
+		folder.Groups = nil
+		if err := ValidateFolder(&folder); err != nil {
+			return nil, err
+		}
+		folders = append(folders, folder)
+	}
+	return folders, nil
+}
+
+// setTemplateFoldersDefinitions sets the definitions of the virtual folders of a
+// validated user, the validation only keeps the folder names
+func setTemplateFoldersDefinitions(user *User, foldersToCreate []vfs.BaseVirtualFolder) {
+	for idx := range user.VirtualFolders {
+		folder := &user.VirtualFolders[idx]
+		found := false
+		for _, f := range foldersToCreate {
+			if f.Name == folder.Name {
+				folder.BaseVirtualFolder = f.GetACopy()
+				found = true
+				break
+			}
+		}
+		if !found {
+			if f, err := provider.getFolderByName(folder.Name); err == nil {
+				folder.BaseVirtualFolder = f
+			}
+		}
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"errors"
+
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// validationErrors accumulates the errors for independent validation checks,
+// so all the problems are reported at once instead of only the first one
+type validationErrors struct {
+	first  error
+	issues *util.ValidationError
+}
+
+// add records the validation error for the specified field. Errors that are
+// not validation errors are returned, the validation should stop in this case
+func (v *validationErrors) add(field string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var validationErr *util.ValidationError
+	if !errors.As(err, &validationErr) {
+		return err
+	}
+	if v.first == nil {
+		v.first = err
+		v.issues = util.NewValidationError("")
+	}
+	if fields := validationErr.GetFields(); len(fields) > 0 {
+		v.issues.Append(fields...)
+	} else {
+		v.issues.Append(util.FieldError{
+			Field:   field,
+			Message: validationErr.GetErrorString(),
+			Code:    validationErr.Code(),
+		})
+	}
+	return nil
+}
+
+func (v *validationErrors) hasErrors() bool {
+	return v.first != nil
+}
+
+// err returns the accumulated error. A single error is returned unchanged,
+// if there are multiple errors the localization message, if any, is taken
+// from the first one
+func (v *validationErrors) err() error {
+	if v.first == nil {
+		return nil
+	}
+	if len(v.issues.GetFields()) == 1 {
+		return v.first
+	}
+	var i18nErr *util.I18nError
+	if errors.As(v.first, &i18nErr) {
+		return i18nErr.WithError(v.issues)
+	}
+	return v.issues
+}
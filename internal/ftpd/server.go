@@ -174,8 +174,10 @@ func (s *Server) ClientConnected(cc ftpserver.ClientContext) (string, error) {
 // ClientDisconnected is called when the user disconnects, even if he never authenticated
 func (s *Server) ClientDisconnected(cc ftpserver.ClientContext) {
 	connID := fmt.Sprintf("%v_%v_%v", common.ProtocolFTP, s.ID, cc.ID())
+	ipAddr := util.GetIPFromRemoteAddress(cc.RemoteAddr().String())
+	common.HandleConnectionSessionEnd(connID, ipAddr, common.ProtocolFTP)
 	common.Connections.Remove(connID)
-	common.Connections.RemoveClientConnection(util.GetIPFromRemoteAddress(cc.RemoteAddr().String()))
+	common.Connections.RemoveClientConnection(ipAddr)
 }
 
 // AuthUser authenticates the user and selects an handling driver
@@ -367,7 +369,9 @@ func (s *Server) validateUser(user dataprovider.User, cc ftpserver.ClientContext
 			user.Username, loginMethod)
 		return nil, fmt.Errorf("login method %v is not allowed for user %q", loginMethod, user.Username)
 	}
-	if user.MustSetSecondFactorForProtocol(common.ProtocolFTP) {
+	remoteAddr := cc.RemoteAddr().String()
+	if user.MustSetSecondFactorForProtocol(common.ProtocolFTP) &&
+		!user.IsSecondFactorSkipped(util.GetIPFromRemoteAddress(remoteAddr), common.ProtocolFTP) {
 		logger.Info(logSender, connectionID, "cannot login user %q, second factor authentication is not set",
 			user.Username)
 		return nil, fmt.Errorf("second factor authentication is not set for user %q", user.Username)
@@ -380,7 +384,6 @@ func (s *Server) validateUser(user dataprovider.User, cc ftpserver.ClientContext
 			return nil, fmt.Errorf("too many open sessions: %v", activeSessions)
 		}
 	}
-	remoteAddr := cc.RemoteAddr().String()
 	if !user.IsLoginFromAddrAllowed(remoteAddr) {
 		logger.Info(logSender, connectionID, "cannot login user %q, remote address is not allowed: %v",
 			user.Username, remoteAddr)
@@ -392,6 +395,11 @@ func (s *Server) validateUser(user dataprovider.User, cc ftpserver.ClientContext
 		logger.Warn(logSender, connectionID, "unable to check fs root: %v close fs error: %v", err, errClose)
 		return nil, common.ErrInternalFailure
 	}
+	if err := common.ConsumeLogin(&user, util.GetIPFromRemoteAddress(remoteAddr), common.ProtocolFTP); err != nil {
+		errClose := user.CloseFs()
+		logger.Debug(logSender, connectionID, "login refused: %v, close fs error: %v", err, errClose)
+		return nil, err
+	}
 	connection := &Connection{
 		BaseConnection: common.NewBaseConnection(fmt.Sprintf("%v_%v", s.ID, cc.ID()), common.ProtocolFTP,
 			cc.LocalAddr().String(), remoteAddr, user),
@@ -414,26 +422,38 @@ func setStartDirectory(startDirectory string, cc ftpserver.ClientContext) {
 }
 
 func updateLoginMetrics(user *dataprovider.User, ip, loginMethod string, err error, c *Connection) {
+	// the connection is nil if the login fails before the connection is created
+	var connectionID, clientVersion string
+	if c != nil {
+		connectionID = c.ID
+		clientVersion = c.GetClientVersion()
+	}
 	metric.AddLoginAttempt(loginMethod)
 	if err == nil {
-		logger.LoginLog(user.Username, ip, loginMethod, common.ProtocolFTP, c.ID, c.GetClientVersion(),
-			c.clientContext.HasTLSForControl(), "")
+		logger.LoginLog(user.Username, ip, loginMethod, common.ProtocolFTP, connectionID, clientVersion,
+			c.clientContext.HasTLSForControl(), nil)
 		plugin.Handler.NotifyLogEvent(notifier.LogEventTypeLoginOK, common.ProtocolFTP, user.Username, ip, "", nil)
+		common.AddLoginResult(user.Username, ip, common.ProtocolFTP, nil)
+		common.UpdateAccountLockout(user.Username, ip, common.ProtocolFTP, nil)
 		common.DelayLogin(nil)
 	} else if err != common.ErrInternalFailure {
-		logger.ConnectionFailedLog(user.Username, ip, loginMethod, common.ProtocolFTP, err.Error())
-		event := common.HostEventLoginFailed
+		logger.ConnectionFailedLog(user.Username, ip, loginMethod, common.ProtocolFTP, connectionID, err.Error())
+		event := common.GetDefenderEventForLoginError(err)
 		logEv := notifier.LogEventTypeLoginFailed
 		if errors.Is(err, util.ErrNotFound) {
-			event = common.HostEventUserNotFound
 			logEv = notifier.LogEventTypeLoginNoUser
 		}
 		common.AddDefenderEvent(ip, common.ProtocolFTP, event)
+		common.AddLoginResult(user.Username, ip, common.ProtocolFTP, err)
+		common.UpdateAccountLockout(user.Username, ip, common.ProtocolFTP, err)
 		plugin.Handler.NotifyLogEvent(logEv, common.ProtocolFTP, user.Username, ip, "", err)
 		if loginMethod != dataprovider.LoginMethodTLSCertificate {
 			common.DelayLogin(err)
 		}
 	}
 	metric.AddLoginResult(loginMethod, err)
+	if err != common.ErrInternalFailure {
+		dataprovider.AddLoginEvent(user.Username, ip, common.ProtocolFTP, loginMethod, clientVersion, err)
+	}
 	dataprovider.ExecutePostLoginHook(user, loginMethod, ip, common.ProtocolFTP, err)
 }
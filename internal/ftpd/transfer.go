@@ -46,7 +46,7 @@ func newTransfer(baseTransfer *common.BaseTransfer, pipeWriter vfs.PipeWriter, p
 	}
 	return &transfer{
 		BaseTransfer:   baseTransfer,
-		writer:         writer,
+		writer:         baseTransfer.WrapUploadWriter(writer),
 		reader:         reader,
 		isFinished:     false,
 		expectedOffset: expectedOffset,
@@ -78,6 +78,7 @@ func (t *transfer) Write(p []byte) (n int, err error) {
 
 	n, err = t.writer.Write(p)
 	t.BytesReceived.Add(int64(n))
+	t.UpdateChecksum(p[:n], -1)
 
 	if err == nil {
 		err = t.CheckWrite()
@@ -122,10 +123,12 @@ func (t *transfer) Close() error {
 }
 
 func (t *transfer) closeIO() error {
+	t.CompleteUploadInspection()
 	var err error
 	if t.File != nil {
 		err = t.File.Close()
 	} else if t.writer != nil {
+		t.CancelUploadOnError()
 		err = t.writer.Close()
 		t.Lock()
 		// we set ErrTransfer here so quota is not updated, in this case the uploads are atomic
@@ -16,6 +16,7 @@
 package httpclient
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
@@ -23,11 +24,13 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/hashicorp/go-retryablehttp"
 
+	"github.com/drakkan/sftpgo/v2/internal/command"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/util"
 )
@@ -47,6 +50,19 @@ type Header struct {
 	URL   string `json:"url" mapstructure:"url"`
 }
 
+// Hook defines the HTTP client configuration for a specific hook
+type Hook struct {
+	// Hook is the hook name, the supported values are the same used
+	// for the commands configuration
+	Hook string `json:"hook" mapstructure:"hook"`
+	// Timeout specifies a time limit, in seconds, for a request to this hook.
+	// This value overrides the global timeout if set
+	Timeout float64 `json:"timeout" mapstructure:"timeout"`
+	// RetryMax defines the maximum number of attempts for asynchronous hooks.
+	// This value overrides the global retry max if set, 0 means no retries
+	RetryMax *int `json:"retry_max" mapstructure:"retry_max"`
+}
+
 // Config defines the configuration for HTTP clients.
 // HTTP clients are used for executing hooks such as the ones used for
 // custom actions, external authentication and pre-login user modifications
@@ -72,13 +88,21 @@ type Config struct {
 	// This should be used only for testing.
 	SkipTLSVerify bool `json:"skip_tls_verify" mapstructure:"skip_tls_verify"`
 	// Headers defines a list of http headers to add to each request
-	Headers         []Header `json:"headers" mapstructure:"headers"`
+	Headers []Header `json:"headers" mapstructure:"headers"`
+	// Hooks defines configuration overrides for specific hooks
+	Hooks           []Hook `json:"hooks" mapstructure:"hooks"`
 	customTransport *http.Transport
 }
 
 const logSender = "httpclient"
 
-var httpConfig Config
+var (
+	httpConfig     Config
+	supportedHooks = []string{command.HookFsActions, command.HookProviderActions, command.HookStartup,
+		command.HookPostConnect, command.HookPostDisconnect, command.HookDataRetention, command.HookCheckPassword,
+		command.HookPreLogin, command.HookPostLogin, command.HookExternalAuth, command.HookKeyboardInteractive,
+		command.HookUploadScan, command.HookPreDelete, command.HookPreRename}
+)
 
 // Initialize configures HTTP clients
 func (c *Config) Initialize(configDir string) error {
@@ -111,6 +135,17 @@ func (c *Config) Initialize(configDir string) error {
 		}
 	}
 	c.Headers = headers
+	for _, h := range c.Hooks {
+		if !slices.Contains(supportedHooks, h.Hook) {
+			return fmt.Errorf("invalid hook name %q, supported values: %+v", h.Hook, supportedHooks)
+		}
+		if h.Timeout < 0 {
+			return fmt.Errorf("invalid timeout %v for hook %q", h.Timeout, h.Hook)
+		}
+		if h.RetryMax != nil && *h.RetryMax < 0 {
+			return fmt.Errorf("invalid retry max %v for hook %q", *h.RetryMax, h.Hook)
+		}
+	}
 	httpConfig = *c
 	return nil
 }
@@ -181,6 +216,30 @@ func (c *Config) loadCertificates(configDir string) error {
 	return nil
 }
 
+// getHookParams returns the timeout and the retry max for the specified hook
+func getHookParams(hook string) (time.Duration, int) {
+	timeout := httpConfig.Timeout
+	retryMax := httpConfig.RetryMax
+	for _, h := range httpConfig.Hooks {
+		if h.Hook == hook {
+			if h.Timeout > 0 {
+				timeout = h.Timeout
+			}
+			if h.RetryMax != nil {
+				retryMax = *h.RetryMax
+			}
+			break
+		}
+	}
+	return time.Duration(timeout * float64(time.Second)), retryMax
+}
+
+// GetHookTimeout returns the request timeout for the specified hook
+func GetHookTimeout(hook string) time.Duration {
+	timeout, _ := getHookParams(hook)
+	return timeout
+}
+
 // GetHTTPClient returns a new HTTP client with the configured parameters
 func GetHTTPClient() *http.Client {
 	return &http.Client{
@@ -192,13 +251,17 @@ func GetHTTPClient() *http.Client {
 // GetRetraybleHTTPClient returns an HTTP client that retry a request on error.
 // It uses the configured retry parameters
 func GetRetraybleHTTPClient() *retryablehttp.Client {
+	return getRetryableHTTPClient(time.Duration(httpConfig.Timeout*float64(time.Second)), httpConfig.RetryMax)
+}
+
+func getRetryableHTTPClient(timeout time.Duration, retryMax int) *retryablehttp.Client {
 	client := retryablehttp.NewClient()
-	client.HTTPClient.Timeout = time.Duration(httpConfig.Timeout * float64(time.Second))
+	client.HTTPClient.Timeout = timeout
 	client.HTTPClient.Transport.(*http.Transport).TLSClientConfig = httpConfig.customTransport.TLSClientConfig
 	client.Logger = &logger.LeveledLogger{Sender: "RetryableHTTPClient"}
 	client.RetryWaitMin = time.Duration(httpConfig.RetryWaitMin) * time.Second
 	client.RetryWaitMax = time.Duration(httpConfig.RetryWaitMax) * time.Second
-	client.RetryMax = httpConfig.RetryMax
+	client.RetryMax = retryMax
 
 	return client
 }
@@ -216,6 +279,19 @@ func Get(url string) (*http.Response, error) {
 	return client.Do(req)
 }
 
+// Head issues a HEAD to the specified URL
+func Head(url string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodHead, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	addHeaders(req, url)
+	client := GetHTTPClient()
+	defer client.CloseIdleConnections()
+
+	return client.Do(req)
+}
+
 // Post issues a POST to the specified URL
 func Post(url string, contentType string, body io.Reader) (*http.Response, error) {
 	req, err := http.NewRequest(http.MethodPost, url, body)
@@ -230,6 +306,23 @@ func Post(url string, contentType string, body io.Reader) (*http.Response, error
 	return client.Do(req)
 }
 
+// PostWithContext issues a POST to the specified URL. The request is canceled
+// when the specified context is done, the client timeout is not applied
+func PostWithContext(ctx context.Context, url string, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	addHeaders(req, url)
+	client := &http.Client{
+		Transport: httpConfig.customTransport,
+	}
+	defer client.CloseIdleConnections()
+
+	return client.Do(req)
+}
+
 // RetryableGet issues a GET to the specified URL using the retryable client
 func RetryableGet(url string) (*http.Response, error) {
 	req, err := retryablehttp.NewRequest(http.MethodGet, url, nil)
@@ -257,6 +350,38 @@ func RetryablePost(url string, contentType string, body io.Reader) (*http.Respon
 	return client.Do(req)
 }
 
+// RetryableGetWithBodyForHook issues a GET, with the specified body, to the specified
+// URL using the retryable client and the timeout and retry parameters configured for
+// the specified hook. The timeout is applied to each attempt
+func RetryableGetWithBodyForHook(hook, url string, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := retryablehttp.NewRequest(http.MethodGet, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	addHeadersToRetryableReq(req, url)
+	client := getRetryableHTTPClient(getHookParams(hook))
+	defer client.HTTPClient.CloseIdleConnections()
+
+	return client.Do(req)
+}
+
+// RetryablePostForHook issues a POST to the specified URL using the retryable
+// client and the timeout and retry parameters configured for the specified hook.
+// The timeout is applied to each attempt
+func RetryablePostForHook(hook, url string, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := retryablehttp.NewRequest(http.MethodPost, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	addHeadersToRetryableReq(req, url)
+	client := getRetryableHTTPClient(getHookParams(hook))
+	defer client.HTTPClient.CloseIdleConnections()
+
+	return client.Do(req)
+}
+
 func addHeaders(req *http.Request, url string) {
 	for idx := range httpConfig.Headers {
 		h := &httpConfig.Headers[idx]
@@ -0,0 +1,131 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/render"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+func getArchives(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	archives, err := dataprovider.GetArchives()
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	if archives == nil {
+		archives = []dataprovider.ArchiveInfo{}
+	}
+	render.JSON(w, r, archives)
+}
+
+func createArchive(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	var scopes []string
+	if _, ok := r.URL.Query()["scopes"]; ok {
+		scopes = getCommaSeparatedQueryParam(r, "scopes")
+	}
+	info, err := dataprovider.CreateArchive(scopes)
+	if err != nil {
+		if errors.Is(err, dataprovider.ErrArchiveInProgress) {
+			sendAPIResponse(w, r, err, "", http.StatusConflict)
+			return
+		}
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.Status(r, http.StatusCreated)
+	render.JSON(w, r, info)
+}
+
+func restoreArchive(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = dataprovider.ArchiveRestoreModeMerge
+	}
+	if mode != dataprovider.ArchiveRestoreModeMerge && mode != dataprovider.ArchiveRestoreModeReplace {
+		sendAPIResponse(w, r, fmt.Errorf("invalid mode %q", mode), "", http.StatusBadRequest)
+		return
+	}
+	dryRun := r.URL.Query().Get("dry-run") == "1"
+	var scopes []string
+	if _, ok := r.URL.Query()["scopes"]; ok {
+		scopes = getCommaSeparatedQueryParam(r, "scopes")
+	}
+	name := getURLParam(r, "name")
+	archive, err := dataprovider.ReadArchive(name)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	replace := mode == dataprovider.ArchiveRestoreModeReplace
+	report, err := dataprovider.PrepareArchiveRestore(&archive, name, scopes, replace, dryRun, claims.Username)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	if !dryRun {
+		ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
+		if err := applyArchiveRestore(&archive, &report, claims.Username, ipAddr, claims.Role); err != nil {
+			sendAPIResponse(w, r, err, "", getRespStatus(err))
+			return
+		}
+	}
+	render.JSON(w, r, report)
+}
+
+func applyArchiveRestore(archive *dataprovider.Archive, report *dataprovider.ArchiveRestoreReport, executor,
+	ipAddress, role string,
+) error {
+	// in replace mode the updated users are disconnected, as for loaddata mode 2
+	mode := 0
+	if report.Mode == dataprovider.ArchiveRestoreModeReplace {
+		mode = 2
+	}
+	if err := restoreBackupData(&archive.Data, report.Archive, 0, mode, executor, ipAddress, role); err != nil {
+		return err
+	}
+	// delete the objects referencing others first
+	for idx := len(report.Scopes) - 1; idx >= 0; idx-- {
+		scope := report.Scopes[idx]
+		for _, name := range scope.Deleted {
+			err := dataprovider.DeleteArchiveObject(scope.Scope, name, executor, ipAddress, role)
+			logger.Debug(logSender, "", "deleting %s %q not included in archive %q, error: %v", scope.Scope, name,
+				report.Archive, err)
+			if err != nil {
+				return fmt.Errorf("unable to delete %s %q: %w", scope.Scope, name, err)
+			}
+			if scope.Scope == dataprovider.DumpScopeUsers {
+				disconnectUser(name, executor, role)
+			}
+		}
+	}
+	logger.Info(logSender, "", "archive %q restored, mode: %s", report.Archive, report.Mode)
+	return nil
+}
@@ -72,6 +72,85 @@ func deleteDefenderHostByID(w http.ResponseWriter, r *http.Request) {
 	sendAPIResponse(w, r, nil, "OK", http.StatusOK)
 }
 
+func exportDefender(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	// the safe list entries are IP list entries, they are only exported for
+	// the admins allowed to manage the IP lists
+	data, err := common.ExportDefender(claims.hasPerm(dataprovider.PermAdminAny))
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, data)
+}
+
+func importDefender(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	var replace bool
+	switch mode := r.URL.Query().Get("mode"); mode {
+	case "", "merge":
+	case "replace":
+		replace = true
+	default:
+		sendAPIResponse(w, r, fmt.Errorf("invalid import mode %q", mode), "", http.StatusBadRequest)
+		return
+	}
+	var data common.DefenderExport
+	if err := render.DecodeJSON(r.Body, &data); err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	// the safe list entries are IP list entries, managing them requires the
+	// same permissions as for the IP lists
+	hostsOnly := !claims.hasPerm(dataprovider.PermAdminAny)
+	if hostsOnly && len(data.SafeList) > 0 {
+		sendAPIResponse(w, r, nil, "You are not allowed to import the safe list", http.StatusForbidden)
+		return
+	}
+	err = common.ImportDefender(&data, &common.DefenderImportOptions{
+		Replace:   replace,
+		HostsOnly: hostsOnly,
+		Protocol:  common.ProtocolHTTP,
+		Executor:  claims.Username,
+		IPAddress: util.GetIPFromRemoteAddress(r.RemoteAddr),
+		Role:      claims.Role,
+	})
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "Data imported", http.StatusOK)
+}
+
+type defenderBansRequest struct {
+	Networks []string `json:"networks"`
+	BanTime  int      `json:"ban_time"`
+}
+
+func banDefenderNetworks(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	var req defenderBansRequest
+	if err := render.DecodeJSON(r.Body, &req); err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	if err := common.BanDefenderNetworks(req.Networks, req.BanTime, common.ProtocolHTTP); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "Hosts banned", http.StatusOK)
+}
+
 func getIPFromID(r *http.Request) (string, error) {
 	decoded, err := hex.DecodeString(getURLParam(r, "id"))
 	if err != nil {
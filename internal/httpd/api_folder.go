@@ -19,9 +19,12 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"strconv"
+	"time"
 
 	"github.com/go-chi/render"
 
+	"github.com/drakkan/sftpgo/v2/internal/common"
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
 	"github.com/drakkan/sftpgo/v2/internal/util"
 	"github.com/drakkan/sftpgo/v2/internal/vfs"
@@ -90,6 +93,10 @@ func updateFolder(w http.ResponseWriter, r *http.Request) {
 	updatedFolder.Name = folder.Name
 	updatedFolder.FsConfig.SetEmptySecretsIfNil()
 	updateEncryptedSecrets(&updatedFolder.FsConfig, &folder.FsConfig)
+	// we use the new PGP public keys if plain or empty, otherwise the old value
+	if updatedFolder.Transform.PGPPublicKeys != nil && updatedFolder.Transform.PGPPublicKeys.IsNotPlainAndNotEmpty() {
+		updatedFolder.Transform.PGPPublicKeys = folder.Transform.PGPPublicKeys
+	}
 
 	err = dataprovider.UpdateFolder(&updatedFolder, folder.Users, folder.Groups, claims.Username,
 		util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
@@ -143,3 +150,46 @@ func deleteFolder(w http.ResponseWriter, r *http.Request) {
 	}
 	sendAPIResponse(w, r, err, "Folder deleted", http.StatusOK)
 }
+
+func checkFolderBackend(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+
+	var folder vfs.BaseVirtualFolder
+	err := render.DecodeJSON(r.Body, &folder)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	// the plain secrets are encrypted as for a saved folder
+	if err := dataprovider.ValidateFolder(&folder); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	renderFolderBackendCheck(w, r, folder)
+}
+
+func checkSavedFolderBackend(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+
+	folder, err := dataprovider.GetFolderByName(getURLParam(r, "name"))
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	renderFolderBackendCheck(w, r, folder)
+}
+
+func renderFolderBackendCheck(w http.ResponseWriter, r *http.Request, folder vfs.BaseVirtualFolder) {
+	timeout := common.DefaultBackendCheckTimeout
+	if r.URL.Query().Has("timeout") {
+		seconds, err := strconv.Atoi(r.URL.Query().Get("timeout"))
+		if err != nil || seconds < 1 || time.Duration(seconds)*time.Second > common.MaxBackendCheckTimeout {
+			sendAPIResponse(w, r, err, fmt.Sprintf("Invalid timeout, it must be between 1 and %d seconds",
+				int(common.MaxBackendCheckTimeout/time.Second)), http.StatusBadRequest)
+			return
+		}
+		timeout = time.Duration(seconds) * time.Second
+	}
+	writeTest := r.URL.Query().Get("write-test") == "1"
+	render.JSON(w, r, common.CheckFolderBackend(folder, writeTest, timeout))
+}
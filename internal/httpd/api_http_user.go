@@ -332,7 +332,7 @@ func uploadUserFiles(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	t := newThrottledReader(r.Body, connection.User.UploadBandwidth, connection)
+	t := newThrottledReader(r.Body, r.ContentLength, connection.User.UploadBandwidth, connection)
 	r.Body = t
 	err = r.ParseMultipartForm(maxMultipartMem)
 	if err != nil {
@@ -483,6 +483,26 @@ func getUserProfile(w http.ResponseWriter, r *http.Request) {
 	render.JSON(w, r, resp)
 }
 
+func getUserTransferQuota(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	user, err := dataprovider.UserExists(claims.Username, "")
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	status, err := dataprovider.GetTransferQuotaWindowStatus(&user)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, status)
+}
+
 func updateUserProfile(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
 	claims, err := getTokenClaims(r)
@@ -189,48 +189,57 @@ func restoreBackup(content []byte, inputFile string, scanQuota, mode int, execut
 			util.I18nErrorBackupFile,
 		)
 	}
+	return restoreBackupData(&dump, inputFile, scanQuota, mode, executor, ipAddress, role)
+}
 
-	if err = RestoreConfigs(dump.Configs, mode, executor, ipAddress, role); err != nil {
+func restoreBackupData(dump *dataprovider.BackupData, inputFile string, scanQuota, mode int, executor, ipAddress,
+	role string,
+) error {
+	if err := RestoreConfigs(dump.Configs, mode, executor, ipAddress, role); err != nil {
 		return err
 	}
 
-	if err = RestoreIPListEntries(dump.IPLists, inputFile, mode, executor, ipAddress, role); err != nil {
+	if err := RestoreIPListEntries(dump.IPLists, inputFile, mode, executor, ipAddress, role); err != nil {
 		return err
 	}
 
-	if err = RestoreRoles(dump.Roles, inputFile, mode, executor, ipAddress, role); err != nil {
+	if err := RestoreRoles(dump.Roles, inputFile, mode, executor, ipAddress, role); err != nil {
 		return err
 	}
 
-	if err = RestoreFolders(dump.Folders, inputFile, mode, scanQuota, executor, ipAddress, role); err != nil {
+	if err := RestoreFolders(dump.Folders, inputFile, mode, scanQuota, executor, ipAddress, role); err != nil {
 		return err
 	}
 
-	if err = RestoreGroups(dump.Groups, inputFile, mode, executor, ipAddress, role); err != nil {
+	if err := RestoreGroups(dump.Groups, inputFile, mode, executor, ipAddress, role); err != nil {
 		return err
 	}
 
-	if err = RestoreUsers(dump.Users, inputFile, mode, scanQuota, executor, ipAddress, role); err != nil {
+	if err := RestoreUsers(dump.Users, inputFile, mode, scanQuota, executor, ipAddress, role); err != nil {
 		return err
 	}
 
-	if err = RestoreAdmins(dump.Admins, inputFile, mode, executor, ipAddress, role); err != nil {
+	if err := RestoreAdmins(dump.Admins, inputFile, mode, executor, ipAddress, role); err != nil {
 		return err
 	}
 
-	if err = RestoreAPIKeys(dump.APIKeys, inputFile, mode, executor, ipAddress, role); err != nil {
+	if err := RestoreAPIKeys(dump.APIKeys, inputFile, mode, executor, ipAddress, role); err != nil {
 		return err
 	}
 
-	if err = RestoreShares(dump.Shares, inputFile, mode, executor, ipAddress, role); err != nil {
+	if err := RestoreShares(dump.Shares, inputFile, mode, executor, ipAddress, role); err != nil {
 		return err
 	}
 
-	if err = RestoreEventActions(dump.EventActions, inputFile, mode, executor, ipAddress, role); err != nil {
+	if err := RestoreEventActions(dump.EventActions, inputFile, mode, executor, ipAddress, role); err != nil {
 		return err
 	}
 
-	if err = RestoreEventRules(dump.EventRules, inputFile, mode, executor, ipAddress, role, dump.Version); err != nil {
+	if err := RestoreEventRules(dump.EventRules, inputFile, mode, executor, ipAddress, role, dump.Version); err != nil {
+		return err
+	}
+
+	if err := RestoreUserTemplates(dump.UserTemplates, inputFile, mode, executor, ipAddress, role); err != nil {
 		return err
 	}
 	logger.Debug(logSender, "", "backup restored")
@@ -446,6 +455,16 @@ func RestoreConfigs(configs *dataprovider.Configs, mode int, executor, ipAddress
 			return nil
 		}
 	}
+	if c.SFTPD != nil && len(c.SFTPD.HostKeys) > 0 {
+		// don't remove the existing host keys restoring a backup without host keys,
+		// the SFTP service would generate new keys
+		if configs.SFTPD == nil {
+			configs.SFTPD = &dataprovider.SFTPDConfigs{}
+		}
+		if len(configs.SFTPD.HostKeys) == 0 {
+			configs.SFTPD.HostKeys = c.SFTPD.HostKeys
+		}
+	}
 	return dataprovider.UpdateConfigs(configs, executor, ipAddress, executorRole)
 }
 
@@ -501,6 +520,34 @@ func RestoreRoles(roles []dataprovider.Role, inputFile string, mode int, executo
 	return nil
 }
 
+// RestoreUserTemplates restores the specified user templates
+func RestoreUserTemplates(templates []dataprovider.UserTemplate, inputFile string, mode int, executor, ipAddress,
+	executorRole string,
+) error {
+	for idx := range templates {
+		template := templates[idx]
+		t, err := dataprovider.UserTemplateExists(template.Name)
+		if err == nil {
+			if mode == 1 {
+				logger.Debug(logSender, "", "loaddata mode 1, existing user template %q not updated", t.Name)
+				continue
+			}
+			template.ID = t.ID
+			err = dataprovider.UpdateUserTemplate(&template, executor, ipAddress, executorRole)
+			logger.Debug(logSender, "", "restoring existing user template: %q, dump file: %q, error: %v", template.Name,
+				inputFile, err)
+		} else {
+			err = dataprovider.AddUserTemplate(&template, executor, ipAddress, executorRole)
+			logger.Debug(logSender, "", "adding new user template: %q, dump file: %q, error: %v", template.Name,
+				inputFile, err)
+		}
+		if err != nil {
+			return fmt.Errorf("unable to restore user template %q: %w", template.Name, err)
+		}
+	}
+	return nil
+}
+
 // RestoreGroups restores the specified groups
 func RestoreGroups(groups []dataprovider.Group, inputFile string, mode int, executor, ipAddress, role string) error {
 	for idx := range groups {
@@ -560,3 +607,51 @@ func RestoreUsers(users []dataprovider.User, inputFile string, mode, scanQuota i
 	}
 	return nil
 }
+
+func getReEncryptions(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	render.JSON(w, r, common.ReEncryptions.Get())
+}
+
+func startUserReEncryption(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	err := common.ReEncryptions.StartForUser(getURLParam(r, "username"))
+	sendReEncryptionStartResponse(w, r, err)
+}
+
+func startFolderReEncryption(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	err := common.ReEncryptions.StartForFolder(getURLParam(r, "name"))
+	sendReEncryptionStartResponse(w, r, err)
+}
+
+func stopUserReEncryption(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	sendReEncryptionStopResponse(w, r, common.ReEncryptionTargetUser, getURLParam(r, "username"))
+}
+
+func stopFolderReEncryption(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	sendReEncryptionStopResponse(w, r, common.ReEncryptionTargetFolder, getURLParam(r, "name"))
+}
+
+func sendReEncryptionStartResponse(w http.ResponseWriter, r *http.Request, err error) {
+	if err != nil {
+		if errors.Is(err, common.ErrReEncryptionInProgress) {
+			sendAPIResponse(w, r, err, "", http.StatusConflict)
+			return
+		}
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "Re-encryption started", http.StatusAccepted)
+}
+
+func sendReEncryptionStopResponse(w http.ResponseWriter, r *http.Request, target, name string) {
+	if !common.ReEncryptions.Stop(target, name) {
+		sendAPIResponse(w, r, nil, fmt.Sprintf("No re-encryption in progress for %s %q", target, name),
+			http.StatusNotFound)
+		return
+	}
+	sendAPIResponse(w, r, nil, "Re-encryption stop requested", http.StatusOK)
+}
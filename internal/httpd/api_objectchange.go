@@ -0,0 +1,52 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/render"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+)
+
+func getObjectChanges(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	var sinceID int64
+	var limit int
+	var err error
+	if val := r.URL.Query().Get("since"); val != "" {
+		sinceID, err = strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			sendAPIResponse(w, r, errors.New("invalid since"), "", http.StatusBadRequest)
+			return
+		}
+	}
+	if val := r.URL.Query().Get("limit"); val != "" {
+		limit, err = strconv.Atoi(val)
+		if err != nil {
+			sendAPIResponse(w, r, errors.New("invalid limit"), "", http.StatusBadRequest)
+			return
+		}
+	}
+	changes, err := dataprovider.GetObjectChanges(sinceID, limit)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, changes)
+}
@@ -57,6 +57,26 @@ func getFoldersQuotaScans(w http.ResponseWriter, r *http.Request) {
 	render.JSON(w, r, common.QuotaScans.GetVFoldersQuotaScans())
 }
 
+func getUserTransferQuotaWindow(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	user, err := dataprovider.UserExists(getURLParam(r, "username"), claims.Role)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	status, err := dataprovider.GetTransferQuotaWindowStatus(&user)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, status)
+}
+
 func updateUserQuotaUsage(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
 	var usage quotaUsage
@@ -265,7 +285,7 @@ func doFolderQuotaScan(folder vfs.BaseVirtualFolder) error {
 		logger.Warn(logSender, "", "error scanning folder %q: %v", folder.Name, err)
 		return err
 	}
-	err = dataprovider.UpdateVirtualFolderQuota(&folder, numFiles, size, true)
+	err = dataprovider.UpdateVirtualFolderQuotaScan(&folder, numFiles, size)
 	logger.Debug(logSender, "", "virtual folder %q scanned, error: %v", folder.Name, err)
 	return err
 }
@@ -401,7 +401,7 @@ func (s *httpdServer) uploadFilesToShare(w http.ResponseWriter, r *http.Request)
 	}
 	defer common.Connections.Remove(connection.GetID())
 
-	t := newThrottledReader(r.Body, connection.User.UploadBandwidth, connection)
+	t := newThrottledReader(r.Body, r.ContentLength, connection.User.UploadBandwidth, connection)
 	r.Body = t
 	err = r.ParseMultipartForm(maxMultipartMem)
 	if err != nil {
@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"slices"
 	"strconv"
 	"time"
 
@@ -45,8 +46,16 @@ func getUsers(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	users, err := dataprovider.GetUsers(limit, offset, order, claims.Role)
+	var usernamePrefix string
+	if claims.MachineAPIKey {
+		usernamePrefix = claims.UsernamePrefix
+	}
+	users, err := dataprovider.GetUsers(limit, offset, order, claims.Role, usernamePrefix)
 	if err == nil {
+		// the prefix comparison may be case insensitive, depending on the database collation
+		users = slices.DeleteFunc(users, func(u dataprovider.User) bool {
+			return !claims.canManageUser(u.Username)
+		})
 		render.JSON(w, r, users)
 	} else {
 		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
@@ -73,6 +82,8 @@ func renderUser(w http.ResponseWriter, r *http.Request, username string, claims
 	if hideConfidentialData(claims, r) {
 		user.PrepareForRendering()
 	}
+	user.Lockout = dataprovider.GetAccountLockout(user.Username)
+	user.RemainingLogins = user.GetRemainingLogins()
 	if status != http.StatusOK {
 		ctx := context.WithValue(r.Context(), render.StatusCtxKey, status)
 		render.JSON(w, r.WithContext(ctx), user)
@@ -81,28 +92,74 @@ func renderUser(w http.ResponseWriter, r *http.Request, username string, claims
 	}
 }
 
-func addUser(w http.ResponseWriter, r *http.Request) {
+func getEffectiveUserSettings(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	username := getURLParam(r, "username")
+	user, err := dataprovider.GetUserWithEffectiveSettings(username, claims.Role)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	user.PrepareForRendering()
+	render.JSON(w, r, user)
+}
 
+func getUserDiagnostic(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
 	claims, err := getTokenClaims(r)
 	if err != nil || claims.Username == "" {
 		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
 		return
 	}
-	admin, err := dataprovider.AdminExists(claims.Username)
+	username := getURLParam(r, "username")
+	diagnostic, err := common.GetUserDiagnostic(username, claims.Role, r.URL.Query().Get("path"),
+		r.URL.Query().Get("operation"), r.URL.Query().Get("protocol"))
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
 	}
+	diagnostic.User.PrepareForRendering()
+	render.JSON(w, r, diagnostic)
+}
+
+func addUser(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
 	var user dataprovider.User
-	if admin.Filters.Preferences.DefaultUsersExpiration > 0 {
-		user.ExpirationDate = util.GetTimeAsMsSinceEpoch(time.Now().Add(24 * time.Hour * time.Duration(admin.Filters.Preferences.DefaultUsersExpiration)))
+	// API keys with machine scope are not related to any admin
+	if !claims.MachineAPIKey {
+		admin, err := dataprovider.AdminExists(claims.Username)
+		if err != nil {
+			sendAPIResponse(w, r, err, "", getRespStatus(err))
+			return
+		}
+		if admin.Filters.Preferences.DefaultUsersExpiration > 0 {
+			user.ExpirationDate = util.GetTimeAsMsSinceEpoch(time.Now().Add(24 * time.Hour * time.Duration(admin.Filters.Preferences.DefaultUsersExpiration)))
+		}
 	}
 	err = render.DecodeJSON(r.Body, &user)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
 		return
 	}
+	if !claims.canManageUser(user.Username) {
+		sendAPIResponse(w, r, nil, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	if claims.MachineAPIKey && user.Role != "" {
+		sendAPIResponse(w, r, nil, "API keys with machine scope cannot set the user role", http.StatusForbidden)
+		return
+	}
 	if claims.Role != "" {
 		user.Role = claims.Role
 	}
@@ -148,6 +205,25 @@ func disableUser2FA(w http.ResponseWriter, r *http.Request) {
 	sendAPIResponse(w, r, nil, "2FA disabled", http.StatusOK)
 }
 
+func unlockUser(w http.ResponseWriter, r *http.Request) {
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	username := getURLParam(r, "username")
+	user, err := dataprovider.UserExists(username, claims.Role)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	if !common.UnlockAccount(user.Username, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr)) {
+		sendAPIResponse(w, r, nil, "the account is not locked", http.StatusBadRequest)
+		return
+	}
+	sendAPIResponse(w, r, nil, "Account unlocked", http.StatusOK)
+}
+
 func updateUser(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
 	claims, err := getTokenClaims(r)
@@ -186,6 +262,10 @@ func updateUser(w http.ResponseWriter, r *http.Request) {
 	updatedUser.LastPasswordChange = user.LastPasswordChange
 	updatedUser.SetEmptySecretsIfNil()
 	updateEncryptedSecrets(&updatedUser.FsConfig, &user.FsConfig)
+	if claims.MachineAPIKey && updatedUser.Role != user.Role {
+		sendAPIResponse(w, r, nil, "API keys with machine scope cannot change the user role", http.StatusForbidden)
+		return
+	}
 	if claims.Role != "" {
 		updatedUser.Role = claims.Role
 	}
@@ -298,6 +378,7 @@ func updateEncryptedSecrets(fsConfig *vfs.Filesystem, currentFsConfig *vfs.Files
 		if fsConfig.CryptConfig.Passphrase.IsNotPlainAndNotEmpty() {
 			fsConfig.CryptConfig.Passphrase = currentFsConfig.CryptConfig.Passphrase
 		}
+		updateCryptFsRetiredPassphrases(fsConfig, currentFsConfig)
 	case sdk.SFTPFilesystemProvider:
 		updateSFTPFsEncryptedSecrets(fsConfig, currentFsConfig)
 	case sdk.HTTPFilesystemProvider:
@@ -305,6 +386,18 @@ func updateEncryptedSecrets(fsConfig *vfs.Filesystem, currentFsConfig *vfs.Files
 	}
 }
 
+// updateCryptFsRetiredPassphrases replaces the redacted retired passphrases
+// with the current ones at the same position. New retired passphrases must be
+// appended to the existing ones
+func updateCryptFsRetiredPassphrases(fsConfig *vfs.Filesystem, currentFsConfig *vfs.Filesystem) {
+	for idx, passphrase := range fsConfig.CryptConfig.RetiredPassphrases {
+		if passphrase != nil && passphrase.IsNotPlainAndNotEmpty() &&
+			idx < len(currentFsConfig.CryptConfig.RetiredPassphrases) {
+			fsConfig.CryptConfig.RetiredPassphrases[idx] = currentFsConfig.CryptConfig.RetiredPassphrases[idx]
+		}
+	}
+}
+
 func updateSFTPFsEncryptedSecrets(fsConfig *vfs.Filesystem, currentFsConfig *vfs.Filesystem) {
 	if fsConfig.SFTPConfig.Password.IsNotPlainAndNotEmpty() {
 		fsConfig.SFTPConfig.Password = currentFsConfig.SFTPConfig.Password
@@ -315,6 +408,9 @@ func updateSFTPFsEncryptedSecrets(fsConfig *vfs.Filesystem, currentFsConfig *vfs
 	if fsConfig.SFTPConfig.KeyPassphrase.IsNotPlainAndNotEmpty() {
 		fsConfig.SFTPConfig.KeyPassphrase = currentFsConfig.SFTPConfig.KeyPassphrase
 	}
+	if fsConfig.SFTPConfig.ProxyPassword.IsNotPlainAndNotEmpty() {
+		fsConfig.SFTPConfig.ProxyPassword = currentFsConfig.SFTPConfig.ProxyPassword
+	}
 }
 
 func updateHTTPFsEncryptedSecrets(fsConfig *vfs.Filesystem, currentFsConfig *vfs.Filesystem) {
@@ -324,4 +420,7 @@ func updateHTTPFsEncryptedSecrets(fsConfig *vfs.Filesystem, currentFsConfig *vfs
 	if fsConfig.HTTPConfig.APIKey.IsNotPlainAndNotEmpty() {
 		fsConfig.HTTPConfig.APIKey = currentFsConfig.HTTPConfig.APIKey
 	}
+	if fsConfig.HTTPConfig.ProxyPassword.IsNotPlainAndNotEmpty() {
+		fsConfig.HTTPConfig.ProxyPassword = currentFsConfig.HTTPConfig.ProxyPassword
+	}
 }
@@ -0,0 +1,182 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/go-chi/render"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+func getUserTemplates(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	limit, offset, order, err := getSearchFilters(w, r)
+	if err != nil {
+		return
+	}
+
+	templates, err := dataprovider.GetUserTemplates(limit, offset, order)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+		return
+	}
+	for idx := range templates {
+		templates[idx].PrepareForRendering()
+	}
+	render.JSON(w, r, templates)
+}
+
+func addUserTemplate(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+
+	var template dataprovider.UserTemplate
+	err = render.DecodeJSON(r.Body, &template)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	err = dataprovider.AddUserTemplate(&template, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+	} else {
+		w.Header().Add("Location", fmt.Sprintf("%s/%s", userTemplatesPath, url.PathEscape(template.Name)))
+		renderUserTemplate(w, r, template.Name, http.StatusCreated)
+	}
+}
+
+func updateUserTemplate(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+
+	name := getURLParam(r, "name")
+	template, err := dataprovider.UserTemplateExists(name)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+
+	var updatedTemplate dataprovider.UserTemplate
+	err = render.DecodeJSON(r.Body, &updatedTemplate)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+
+	updatedTemplate.ID = template.ID
+	updatedTemplate.Name = template.Name
+	updatedTemplate.SetEmptySecretsIfNil()
+	updateEncryptedSecrets(&updatedTemplate.User.FsConfig, &template.User.FsConfig)
+	for idx := range updatedTemplate.User.VirtualFolders {
+		folder := &updatedTemplate.User.VirtualFolders[idx]
+		for _, current := range template.User.VirtualFolders {
+			if current.Name == folder.Name {
+				updateEncryptedSecrets(&folder.FsConfig, &current.FsConfig)
+				break
+			}
+		}
+	}
+	err = dataprovider.UpdateUserTemplate(&updatedTemplate, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr),
+		claims.Role)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "User template updated", http.StatusOK)
+}
+
+func renderUserTemplate(w http.ResponseWriter, r *http.Request, name string, status int) {
+	template, err := dataprovider.UserTemplateExists(name)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	template.PrepareForRendering()
+	if status != http.StatusOK {
+		ctx := context.WithValue(r.Context(), render.StatusCtxKey, status)
+		render.JSON(w, r.WithContext(ctx), template)
+	} else {
+		render.JSON(w, r, template)
+	}
+}
+
+func getUserTemplateByName(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	name := getURLParam(r, "name")
+	renderUserTemplate(w, r, name, http.StatusOK)
+}
+
+func deleteUserTemplate(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	name := getURLParam(r, "name")
+	err = dataprovider.DeleteUserTemplate(name, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, err, "User template deleted", http.StatusOK)
+}
+
+func addUserFromTemplate(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+
+	var vars dataprovider.UserTemplateVariables
+	err = render.DecodeJSON(r.Body, &vars)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	name := getURLParam(r, "name")
+	user, err := dataprovider.AddUserFromTemplate(name, &vars, dryRun, claims.Username,
+		util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	user.PrepareForRendering()
+	if dryRun {
+		render.JSON(w, r, user)
+		return
+	}
+	w.Header().Add("Location", fmt.Sprintf("%s/%s", userPath, url.PathEscape(user.Username)))
+	ctx := context.WithValue(r.Context(), render.StatusCtxKey, http.StatusCreated)
+	render.JSON(w, r.WithContext(ctx), user)
+}
@@ -85,13 +85,39 @@ func sendAPIResponse(w http.ResponseWriter, r *http.Request, err error, message
 		errorString = err.Error()
 	}
 	resp := apiResponse{
-		Error:   errorString,
-		Message: message,
+		Error:       errorString,
+		ErrorCode:   getErrorCode(err),
+		FieldErrors: getFieldErrors(err),
+		Message:     message,
 	}
 	ctx := context.WithValue(r.Context(), render.StatusCtxKey, code)
 	render.JSON(w, r.WithContext(ctx), resp)
 }
 
+func getFieldErrors(err error) []util.FieldError {
+	var validationErr *util.ValidationError
+	if errors.As(err, &validationErr) {
+		return validationErr.GetFields()
+	}
+	return nil
+}
+
+func getErrorCode(err error) string {
+	if err == nil {
+		return ""
+	}
+	if code := util.ErrorCode(err); code != "" {
+		return code
+	}
+	if errors.Is(err, common.ErrQuotaExceeded) {
+		return util.ErrorCodeQuotaExceeded
+	}
+	if errors.Is(err, common.ErrReadQuotaExceeded) {
+		return util.ErrorCodeReadQuotaExceeded
+	}
+	return ""
+}
+
 func getRespStatus(err error) int {
 	if errors.Is(err, util.ErrValidation) {
 		return http.StatusBadRequest
@@ -105,7 +131,8 @@ func getRespStatus(err error) int {
 	if errors.Is(err, fs.ErrNotExist) {
 		return http.StatusBadRequest
 	}
-	if errors.Is(err, fs.ErrPermission) || errors.Is(err, dataprovider.ErrLoginNotAllowedFromIP) {
+	if errors.Is(err, fs.ErrPermission) || errors.Is(err, dataprovider.ErrLoginNotAllowedFromIP) ||
+		errors.Is(err, dataprovider.ErrLoginBudgetExhausted) {
 		return http.StatusForbidden
 	}
 	if errors.Is(err, plugin.ErrNoSearcher) || errors.Is(err, dataprovider.ErrNotImplemented) {
@@ -696,9 +723,8 @@ func parseRangeRequest(bytesRange string, size int64) (int64, int64, error) {
 }
 
 func handleDefenderEventLoginFailed(ipAddr string, err error) error {
-	event := common.HostEventLoginFailed
+	event := common.GetDefenderEventForLoginError(err)
 	if errors.Is(err, util.ErrNotFound) {
-		event = common.HostEventUserNotFound
 		err = dataprovider.ErrInvalidCredentials
 	}
 	common.AddDefenderEvent(ipAddr, common.ProtocolHTTP, event)
@@ -716,11 +742,17 @@ func updateLoginMetrics(user *dataprovider.User, loginMethod, ip string, err err
 		protocol = common.ProtocolHTTP
 	}
 	if err == nil {
-		logger.LoginLog(user.Username, ip, loginMethod, protocol, "", r.UserAgent(), r.TLS != nil, "")
+		logger.LoginLog(user.Username, ip, loginMethod, protocol, "", r.UserAgent(), r.TLS != nil, nil)
 		plugin.Handler.NotifyLogEvent(notifier.LogEventTypeLoginOK, protocol, user.Username, ip, "", nil)
+		if loginMethod != dataprovider.LoginMethodIDP {
+			common.UpdateAccountLockout(user.Username, ip, protocol, nil)
+		}
 		common.DelayLogin(nil)
 	} else if err != common.ErrInternalFailure && err != common.ErrNoCredentials {
-		logger.ConnectionFailedLog(user.Username, ip, loginMethod, protocol, err.Error())
+		logger.ConnectionFailedLog(user.Username, ip, loginMethod, protocol, "", err.Error())
+		if loginMethod != dataprovider.LoginMethodIDP {
+			common.UpdateAccountLockout(user.Username, ip, protocol, err)
+		}
 		err = handleDefenderEventLoginFailed(ip, err)
 		logEv := notifier.LogEventTypeLoginFailed
 		if errors.Is(err, util.ErrNotFound) {
@@ -729,6 +761,9 @@ func updateLoginMetrics(user *dataprovider.User, loginMethod, ip string, err err
 		plugin.Handler.NotifyLogEvent(logEv, protocol, user.Username, ip, "", err)
 	}
 	metric.AddLoginResult(loginMethod, err)
+	if err != common.ErrInternalFailure && err != common.ErrNoCredentials {
+		dataprovider.AddLoginEvent(user.Username, ip, protocol, loginMethod, r.UserAgent(), err)
+	}
 	dataprovider.ExecutePostLoginHook(user, loginMethod, ip, protocol, err)
 }
 
@@ -765,6 +800,18 @@ func checkHTTPClientUser(user *dataprovider.User, r *http.Request, connectionID
 	return nil
 }
 
+// consumeUserLogin consumes a login from the budget of the specified user, it
+// must be called after all the other login checks succeed
+func consumeUserLogin(user *dataprovider.User, ipAddr, protocol string) error {
+	if err := common.ConsumeLogin(user, ipAddr, protocol); err != nil {
+		if errors.Is(err, dataprovider.ErrLoginBudgetExhausted) {
+			return util.NewI18nError(err, util.I18nErrorLoginBudgetExhausted)
+		}
+		return util.NewI18nError(err, util.I18nError500Message)
+	}
+	return nil
+}
+
 func getActiveAdmin(username, ipAddr string) (dataprovider.Admin, error) {
 	admin, err := dataprovider.AdminExists(username)
 	if err != nil {
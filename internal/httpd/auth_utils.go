@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"net/http"
 	"slices"
+	"strings"
 	"time"
 
 	"github.com/go-chi/jwtauth/v5"
@@ -56,6 +57,8 @@ const (
 	claimPermissionsKey             = "permissions"
 	claimRole                       = "role"
 	claimAPIKey                     = "api_key"
+	claimMachineAPIKey              = "machine"
+	claimUsernamePrefix             = "username_prefix"
 	claimNodeID                     = "node_id"
 	claimMustChangePasswordKey      = "chpwd"
 	claimMustSetSecondFactorKey     = "2fa_required"
@@ -136,6 +139,8 @@ type jwtTokenClaims struct {
 	Signature                  string
 	Audience                   []string
 	APIKeyID                   string
+	MachineAPIKey              bool
+	UsernamePrefix             string
 	NodeID                     string
 	MustSetTwoFactorAuth       bool
 	MustChangePassword         bool
@@ -176,6 +181,12 @@ func (c *jwtTokenClaims) asMap() map[string]any {
 	if c.APIKeyID != "" {
 		claims[claimAPIKey] = c.APIKeyID
 	}
+	if c.MachineAPIKey {
+		claims[claimMachineAPIKey] = c.MachineAPIKey
+	}
+	if c.UsernamePrefix != "" {
+		claims[claimUsernamePrefix] = c.UsernamePrefix
+	}
 	if c.NodeID != "" {
 		claims[claimNodeID] = c.NodeID
 	}
@@ -252,6 +263,14 @@ func (c *jwtTokenClaims) Decode(token map[string]any) {
 		c.APIKeyID = c.decodeString(val)
 	}
 
+	if val, ok := token[claimMachineAPIKey]; ok {
+		c.MachineAPIKey = c.decodeBoolean(val)
+	}
+
+	if val, ok := token[claimUsernamePrefix]; ok {
+		c.UsernamePrefix = c.decodeString(val)
+	}
+
 	if val, ok := token[claimNodeID]; ok {
 		c.NodeID = c.decodeString(val)
 	}
@@ -291,6 +310,15 @@ func (c *jwtTokenClaims) hasPerm(perm string) bool {
 	return slices.Contains(c.Permissions, perm)
 }
 
+// canManageUser returns false if the token was generated for an API key with
+// machine scope restricted to usernames not matching the specified one
+func (c *jwtTokenClaims) canManageUser(username string) bool {
+	if !c.MachineAPIKey {
+		return true
+	}
+	return strings.HasPrefix(username, c.UsernamePrefix)
+}
+
 func (c *jwtTokenClaims) createToken(tokenAuth *jwtauth.JWTAuth, audience tokenAudience, ip string) (jwt.Token, string, error) {
 	claims := c.asMap()
 	now := time.Now().UTC()
@@ -629,6 +657,12 @@ func checkTokenSignature(r *http.Request, token jwt.Token) error {
 		for _, audience := range token.Audience() {
 			switch audience {
 			case tokenAudienceAPI, tokenAudienceWebAdmin:
+				if _, ok := token.Get(claimMachineAPIKey); ok {
+					// tokens for machine API keys are generated for each request
+					// and they are not related to any admin
+					err = validateMachineAPIKeyToken(token)
+					continue
+				}
 				err = validateSignatureForToken(token, dataprovider.GetAdminSignature)
 			case tokenAudienceAPIUser, tokenAudienceWebClient:
 				err = validateSignatureForToken(token, dataprovider.GetUserSignature)
@@ -641,6 +675,30 @@ func checkTokenSignature(r *http.Request, token jwt.Token) error {
 	return err
 }
 
+// validateMachineAPIKeyToken checks that a token with the machine claim refers to
+// an existing, not expired, API key with machine scope
+func validateMachineAPIKeyToken(token jwt.Token) error {
+	c := jwtTokenClaims{}
+	keyID := ""
+	if val, ok := token.Get(claimAPIKey); ok {
+		keyID = c.decodeString(val)
+	}
+	apiKey, err := dataprovider.APIKeyExists(keyID)
+	if err != nil {
+		logger.Debug(logSender, "", "unable to get the API key %q for a machine token: %v", keyID, err)
+		return errInvalidToken
+	}
+	if apiKey.Scope != dataprovider.APIKeyScopeMachine {
+		logger.Debug(logSender, "", "API key %q has not the machine scope", keyID)
+		return errInvalidToken
+	}
+	if apiKey.ExpiresAt > 0 && apiKey.ExpiresAt < util.GetTimeAsMsSinceEpoch(time.Now()) {
+		logger.Debug(logSender, "", "API key %q is expired", keyID)
+		return errInvalidToken
+	}
+	return nil
+}
+
 func validateSignatureForToken(token jwt.Token, getter func(string) (string, error)) error {
 	username := ""
 	if u, ok := token.Get(claimUsernameKey); ok {
@@ -41,7 +41,7 @@ func newHTTPDFile(baseTransfer *common.BaseTransfer, pipeWriter vfs.PipeWriter,
 	}
 	return &httpdFile{
 		BaseTransfer: baseTransfer,
-		writer:       writer,
+		writer:       baseTransfer.WrapUploadWriter(writer),
 		reader:       reader,
 		isFinished:   false,
 	}
@@ -84,6 +84,7 @@ func (f *httpdFile) Write(p []byte) (n int, err error) {
 
 	n, err = f.writer.Write(p)
 	f.BytesReceived.Add(int64(n))
+	f.UpdateChecksum(p[:n], -1)
 
 	if err == nil {
 		err = f.CheckWrite()
@@ -112,10 +113,12 @@ func (f *httpdFile) Close() error {
 }
 
 func (f *httpdFile) closeIO() error {
+	f.CompleteUploadInspection()
 	var err error
 	if f.File != nil {
 		err = f.File.Close()
 	} else if f.writer != nil {
+		f.CancelUploadOnError()
 		err = f.writer.Close()
 		f.Lock()
 		// we set ErrTransfer here so quota is not updated, in this case the uploads are atomic
@@ -153,7 +153,7 @@ func (c *Connection) getFileWriter(name string) (io.WriteCloser, error) {
 		return nil, err
 	}
 	filePath := p
-	if common.Config.IsAtomicUploadEnabled() && fs.IsAtomicUploadSupported() {
+	if common.Config.IsAtomicUploadEnabledFor(fs) {
 		filePath = fs.GetAtomicUploadPath(p)
 	}
 
@@ -180,7 +180,7 @@ func (c *Connection) getFileWriter(name string) (io.WriteCloser, error) {
 		return nil, c.GetPermissionDeniedError()
 	}
 
-	if common.Config.IsAtomicUploadEnabled() && fs.IsAtomicUploadSupported() {
+	if common.Config.IsAtomicUploadEnabled() && fs.IsAtomicUploadSupported() && !vfs.HasCloudAtomicUploads(fs) {
 		_, _, err = fs.Rename(p, filePath, 0)
 		if err != nil {
 			c.Log(logger.LevelError, "error renaming existing file for atomic upload, source: %q, dest: %q, err: %+v",
@@ -208,7 +208,7 @@ func (c *Connection) handleUploadFile(fs vfs.Fs, resolvedPath, filePath, request
 		return nil, c.GetPermissionDeniedError()
 	}
 
-	maxWriteSize, _ := c.GetMaxWriteSize(diskQuota, false, fileSize, fs.IsUploadResumeSupported())
+	maxWriteSize, _ := c.GetMaxWriteSize(diskQuota, false, fileSize, fs.IsUploadResumeSupported(), requestPath)
 
 	file, w, cancelFn, err := fs.Create(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, c.GetCreateChecks(requestPath, isNewFile, false))
 	if err != nil {
@@ -242,13 +242,14 @@ func (c *Connection) handleUploadFile(fs vfs.Fs, resolvedPath, filePath, request
 	return newHTTPDFile(baseTransfer, w, nil), nil
 }
 
-func newThrottledReader(r io.ReadCloser, limit int64, conn *Connection) *throttledReader {
+func newThrottledReader(r io.ReadCloser, size, limit int64, conn *Connection) *throttledReader {
 	t := &throttledReader{
-		id:    conn.GetTransferID(),
-		limit: limit,
-		r:     r,
-		start: time.Now(),
-		conn:  conn,
+		id:           conn.GetTransferID(),
+		limit:        limit,
+		expectedSize: size,
+		r:            r,
+		start:        time.Now(),
+		conn:         conn,
 	}
 	t.bytesRead.Store(0)
 	t.abortTransfer.Store(false)
@@ -260,6 +261,7 @@ type throttledReader struct {
 	bytesRead     atomic.Int64
 	id            int64
 	limit         int64
+	expectedSize  int64
 	r             io.ReadCloser
 	abortTransfer atomic.Bool
 	start         time.Time
@@ -321,6 +323,10 @@ func (t *throttledReader) HasSizeLimit() bool {
 	return false
 }
 
+func (t *throttledReader) GetExpectedSize() int64 {
+	return t.expectedSize
+}
+
 func (t *throttledReader) Truncate(_ string, _ int64) (int64, error) {
 	return 0, vfs.ErrVfsUnsupported
 }
@@ -59,7 +59,12 @@ const (
 	serverStatusPath                      = "/api/v2/status"
 	dumpDataPath                          = "/api/v2/dumpdata"
 	loadDataPath                          = "/api/v2/loaddata"
+	archivesPath                          = "/api/v2/archives"
+	reEncryptionPath                      = "/api/v2/reencryption"
 	defenderHosts                         = "/api/v2/defender/hosts"
+	defenderExport                        = "/api/v2/defender/export"
+	defenderImport                        = "/api/v2/defender/import"
+	defenderBans                          = "/api/v2/defender/bans"
 	adminPath                             = "/api/v2/admins"
 	adminPwdPath                          = "/api/v2/admin/changepwd"
 	adminProfilePath                      = "/api/v2/admin/profile"
@@ -82,6 +87,7 @@ const (
 	userTOTPSavePath                      = "/api/v2/user/totp/save"
 	user2FARecoveryCodesPath              = "/api/v2/user/2fa/recoverycodes"
 	userProfilePath                       = "/api/v2/user/profile"
+	userTransferQuotaPath                 = "/api/v2/user/transfer-quota"
 	userSharesPath                        = "/api/v2/user/shares"
 	retentionBasePath                     = "/api/v2/retention/users"
 	retentionChecksPath                   = "/api/v2/retention/users/checks"
@@ -92,7 +98,10 @@ const (
 	eventActionsPath                      = "/api/v2/eventactions"
 	eventRulesPath                        = "/api/v2/eventrules"
 	rolesPath                             = "/api/v2/roles"
+	userTemplatesPath                     = "/api/v2/usertemplates"
+	objectChangesPath                     = "/api/v2/changes"
 	ipListsPath                           = "/api/v2/iplists"
+	debugLogOverridesPath                 = "/api/v2/logs/debug-overrides"
 	healthzPath                           = "/healthz"
 	webRootPathDefault                    = "/"
 	webBasePathDefault                    = "/web"
@@ -846,14 +855,15 @@ func (r *rateLimiters) GetProtocolsAsString() string {
 
 // ServicesStatus keep the state of the running services
 type ServicesStatus struct {
-	SSH          sftpd.ServiceStatus         `json:"ssh"`
-	FTP          ftpd.ServiceStatus          `json:"ftp"`
-	WebDAV       webdavd.ServiceStatus       `json:"webdav"`
-	DataProvider dataprovider.ProviderStatus `json:"data_provider"`
-	Defender     defenderStatus              `json:"defender"`
-	MFA          mfa.ServiceStatus           `json:"mfa"`
-	AllowList    allowListStatus             `json:"allow_list"`
-	RateLimiters rateLimiters                `json:"rate_limiters"`
+	SSH             sftpd.ServiceStatus           `json:"ssh"`
+	FTP             ftpd.ServiceStatus            `json:"ftp"`
+	WebDAV          webdavd.ServiceStatus         `json:"webdav"`
+	DataProvider    dataprovider.ProviderStatus   `json:"data_provider"`
+	Defender        defenderStatus                `json:"defender"`
+	MFA             mfa.ServiceStatus             `json:"mfa"`
+	AllowList       allowListStatus               `json:"allow_list"`
+	RateLimiters    rateLimiters                  `json:"rate_limiters"`
+	MaintenanceJobs []common.MaintenanceJobStatus `json:"maintenance_jobs"`
 }
 
 // SetupConfig defines the configuration parameters for the initial web admin setup
@@ -938,8 +948,10 @@ type Conf struct {
 }
 
 type apiResponse struct {
-	Error   string `json:"error,omitempty"`
-	Message string `json:"message"`
+	Error       string            `json:"error,omitempty"`
+	ErrorCode   string            `json:"error_code,omitempty"`
+	FieldErrors []util.FieldError `json:"field_errors,omitempty"`
+	Message     string            `json:"message"`
 }
 
 // ShouldBind returns true if there is at least a valid binding
@@ -1211,6 +1223,7 @@ func getServicesStatus() *ServicesStatus {
 			IsActive:  rtlEnabled,
 			Protocols: rtlProtocols,
 		},
+		MaintenanceJobs: common.GetMaintenanceJobsStatus(),
 	}
 	return status
 }
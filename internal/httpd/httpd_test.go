@@ -17,6 +17,8 @@ package httpd_test
 import (
 	"bytes"
 	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -40,9 +42,13 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
 	"github.com/go-chi/render"
 	_ "github.com/go-sql-driver/mysql"
 	_ "github.com/jackc/pgx/v5/stdlib"
@@ -102,7 +108,11 @@ const (
 	quotasBasePath                 = "/api/v2/quotas"
 	quotaScanPath                  = "/api/v2/quotas/users/scans"
 	quotaScanVFolderPath           = "/api/v2/quotas/folders/scans"
+	reEncryptionPath               = "/api/v2/reencryption"
 	defenderHosts                  = "/api/v2/defender/hosts"
+	defenderExport                 = "/api/v2/defender/export"
+	defenderImport                 = "/api/v2/defender/import"
+	defenderBans                   = "/api/v2/defender/bans"
 	versionPath                    = "/api/v2/version"
 	logoutPath                     = "/api/v2/logout"
 	userPwdPath                    = "/api/v2/user/changepwd"
@@ -125,6 +135,7 @@ const (
 	userTOTPSavePath               = "/api/v2/user/totp/save"
 	user2FARecoveryCodesPath       = "/api/v2/user/2fa/recoverycodes"
 	userProfilePath                = "/api/v2/user/profile"
+	userTransferQuotaPath          = "/api/v2/user/transfer-quota"
 	userSharesPath                 = "/api/v2/user/shares"
 	retentionBasePath              = "/api/v2/retention/users"
 	fsEventsPath                   = "/api/v2/events/fs"
@@ -135,6 +146,7 @@ const (
 	eventRulesPath                 = "/api/v2/eventrules"
 	rolesPath                      = "/api/v2/roles"
 	ipListsPath                    = "/api/v2/iplists"
+	debugLogOverridesPath          = "/api/v2/logs/debug-overrides"
 	healthzPath                    = "/healthz"
 	webBasePath                    = "/web"
 	webBasePathAdmin               = "/web/admin"
@@ -791,6 +803,509 @@ func TestBasicRoleHandling(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestUserTemplates(t *testing.T) {
+	ut := dataprovider.UserTemplate{
+		Name:        "test_template",
+		Description: "template desc",
+	}
+	ut.User = getTestUser()
+	ut.User.HomeDir = filepath.Join(homeBasePath, "%dept%", "%username%")
+	ut.User.Description = "user %username% uid %uid%"
+	ut.User.FsConfig.Provider = sdk.S3FilesystemProvider
+	ut.User.FsConfig.S3Config.Bucket = "test-bucket"
+	ut.User.FsConfig.S3Config.Region = "us-east-1"
+	ut.User.FsConfig.S3Config.AccessKey = "access-key"
+	ut.User.FsConfig.S3Config.AccessSecret = kms.NewPlainSecret("access-secret")
+	ut.User.FsConfig.S3Config.KeyPrefix = "%dept%/%username%/"
+	ut.User.VirtualFolders = []vfs.VirtualFolder{
+		{
+			BaseVirtualFolder: vfs.BaseVirtualFolder{
+				Name:       "%username%_data",
+				MappedPath: filepath.Join(os.TempDir(), "%username%_data"),
+			},
+			VirtualPath: "/data",
+		},
+	}
+	template, resp, err := httpdtest.AddUserTemplate(ut, http.StatusCreated)
+	assert.NoError(t, err, string(resp))
+	assert.Empty(t, template.User.Username)
+	assert.Empty(t, template.User.Password)
+	assert.Equal(t, sdkkms.SecretStatusSecretBox, template.User.FsConfig.S3Config.AccessSecret.GetStatus())
+	assert.Empty(t, template.User.FsConfig.S3Config.AccessSecret.GetKey())
+	_, _, err = httpdtest.AddUserTemplate(ut, http.StatusConflict)
+	assert.NoError(t, err)
+
+	templates, _, err := httpdtest.GetUserTemplates(0, 0, http.StatusOK)
+	assert.NoError(t, err)
+	found := false
+	for _, tmpl := range templates {
+		if tmpl.Name == template.Name {
+			found = true
+		}
+	}
+	assert.True(t, found)
+
+	vars := dataprovider.UserTemplateVariables{
+		Username: "template_user",
+		Password: defaultPassword,
+	}
+	_, resp, err = httpdtest.AddUserFromTemplate(template.Name, vars, true, http.StatusBadRequest)
+	assert.NoError(t, err)
+	assert.Contains(t, string(resp), "unresolved placeholders: %dept%")
+	vars.Variables = map[string]string{"username": "other"}
+	_, resp, err = httpdtest.AddUserFromTemplate(template.Name, vars, true, http.StatusBadRequest)
+	assert.NoError(t, err)
+	assert.Contains(t, string(resp), "is reserved")
+	uid := 1000
+	vars.UID = &uid
+	vars.Variables = map[string]string{"dept": "sales"}
+	_, _, err = httpdtest.AddUserFromTemplate(template.Name+"_", vars, true, http.StatusNotFound)
+	assert.NoError(t, err)
+
+	user, resp, err := httpdtest.AddUserFromTemplate(template.Name, vars, true, http.StatusOK)
+	assert.NoError(t, err, string(resp))
+	assert.Equal(t, vars.Username, user.Username)
+	assert.Equal(t, filepath.Join(homeBasePath, "sales", vars.Username), user.HomeDir)
+	assert.Equal(t, "user template_user uid 1000", user.Description)
+	assert.Equal(t, 1000, user.UID)
+	assert.Equal(t, "sales/template_user/", user.FsConfig.S3Config.KeyPrefix)
+	if assert.Len(t, user.VirtualFolders, 1) {
+		assert.Equal(t, "template_user_data", user.VirtualFolders[0].Name)
+		assert.Equal(t, filepath.Join(os.TempDir(), "template_user_data"), user.VirtualFolders[0].MappedPath)
+	}
+	// a dry run does not save anything
+	_, _, err = httpdtest.GetUserByUsername(vars.Username, http.StatusNotFound)
+	assert.NoError(t, err)
+	_, _, err = httpdtest.GetFolderByName("template_user_data", http.StatusNotFound)
+	assert.NoError(t, err)
+
+	user, resp, err = httpdtest.AddUserFromTemplate(template.Name, vars, false, http.StatusCreated)
+	assert.NoError(t, err, string(resp))
+	assert.Equal(t, filepath.Join(homeBasePath, "sales", vars.Username), user.HomeDir)
+	assert.Equal(t, "sales/template_user/", user.FsConfig.S3Config.KeyPrefix)
+	folder, _, err := httpdtest.GetFolderByName("template_user_data", http.StatusOK)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(os.TempDir(), "template_user_data"), folder.MappedPath)
+	user, _, err = httpdtest.GetUserByUsername(vars.Username, http.StatusOK)
+	assert.NoError(t, err)
+	assert.Len(t, user.VirtualFolders, 1)
+	// the user already exists
+	_, _, err = httpdtest.AddUserFromTemplate(template.Name, vars, false, http.StatusConflict)
+	assert.NoError(t, err)
+
+	template.Description = "updated desc"
+	template.User.FsConfig.S3Config.KeyPrefix = "%dept%/"
+	_, _, err = httpdtest.UpdateUserTemplate(template, http.StatusOK)
+	assert.NoError(t, err)
+	templateGet, _, err := httpdtest.GetUserTemplateByName(template.Name, http.StatusOK)
+	assert.NoError(t, err)
+	assert.Equal(t, template.Description, templateGet.Description)
+	assert.Equal(t, "%dept%/", templateGet.User.FsConfig.S3Config.KeyPrefix)
+	// the hidden secret is preserved
+	templateInDB, err := dataprovider.UserTemplateExists(template.Name)
+	assert.NoError(t, err)
+	assert.Equal(t, sdkkms.SecretStatusSecretBox, templateInDB.User.FsConfig.S3Config.AccessSecret.GetStatus())
+	assert.NotEmpty(t, templateInDB.User.FsConfig.S3Config.AccessSecret.GetKey())
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveFolder(folder, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUserTemplate(template, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUserTemplate(template, http.StatusNotFound)
+	assert.NoError(t, err)
+	err = os.RemoveAll(filepath.Join(homeBasePath, "sales"))
+	assert.NoError(t, err)
+}
+
+func TestObjectChangesDisabled(t *testing.T) {
+	_, _, err := httpdtest.GetObjectChanges(0, 0, http.StatusForbidden)
+	assert.NoError(t, err)
+	_, err = dataprovider.GetObjectChanges(0, 10)
+	assert.ErrorIs(t, err, util.ErrMethodDisabled)
+}
+
+func TestArchives(t *testing.T) {
+	mappedPath := filepath.Join(os.TempDir(), "archives")
+	folder, _, err := httpdtest.AddFolder(vfs.BaseVirtualFolder{
+		Name:       "archives_folder",
+		MappedPath: mappedPath,
+	}, http.StatusCreated)
+	assert.NoError(t, err)
+
+	err = dataprovider.Close()
+	assert.NoError(t, err)
+	err = config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	providerConf := config.GetProviderConf()
+	providerConf.BackupsPath = backupsPath
+	providerConf.Archives.Folder = folder.Name
+	providerConf.Archives.Passphrase = "archive passphrase"
+	providerConf.Archives.Schedule = "invalid schedule"
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.Error(t, err)
+	providerConf.Archives.Schedule = "0 2 * * *"
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.NoError(t, err)
+
+	group, _, err := httpdtest.AddGroup(getTestGroup(), http.StatusCreated)
+	assert.NoError(t, err)
+	u := getTestUser()
+	u.Description = "archived user"
+	u.Groups = []sdk.GroupMapping{
+		{
+			Name: group.Name,
+			Type: sdk.GroupTypePrimary,
+		},
+	}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+
+	_, _, err = httpdtest.CreateArchive(http.StatusBadRequest, "invalid scope")
+	assert.NoError(t, err)
+	archive, _, err := httpdtest.CreateArchive(http.StatusCreated, dataprovider.DumpScopeUsers,
+		dataprovider.DumpScopeGroups)
+	assert.NoError(t, err)
+	assert.True(t, archive.Encrypted)
+	assert.True(t, strings.HasSuffix(archive.Name, ".json.enc"))
+	assert.FileExists(t, filepath.Join(mappedPath, archive.Name))
+	archives, _, err := httpdtest.GetArchives(http.StatusOK)
+	assert.NoError(t, err)
+	if assert.Len(t, archives, 1) {
+		assert.Equal(t, archive.Name, archives[0].Name)
+		assert.Equal(t, archive.Size, archives[0].Size)
+		assert.True(t, archives[0].Encrypted)
+	}
+	// the secrets are archived in their encrypted form
+	content, err := os.ReadFile(filepath.Join(mappedPath, archive.Name))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(content), user.Username)
+	assert.True(t, bytes.HasPrefix(content, []byte("SFTPGOAE")))
+	// the key derivation parameters are read from the header and must be within the limits
+	tampered := bytes.Clone(content)
+	copy(tampered[13:17], []byte{0xff, 0xff, 0xff, 0xff})
+	tamperedName := "sftpgo-archive-20250101T000000.000Z.json.enc"
+	err = os.WriteFile(filepath.Join(mappedPath, tamperedName), tampered, 0666)
+	assert.NoError(t, err)
+	_, _, err = httpdtest.RestoreArchive(tamperedName, "", true, http.StatusBadRequest)
+	assert.NoError(t, err)
+	tampered = bytes.Clone(content)
+	tampered[len(tampered)-1] ^= 0xff
+	err = os.WriteFile(filepath.Join(mappedPath, tamperedName), tampered, 0666)
+	assert.NoError(t, err)
+	_, _, err = httpdtest.RestoreArchive(tamperedName, "", true, http.StatusBadRequest)
+	assert.NoError(t, err)
+	err = os.Remove(filepath.Join(mappedPath, tamperedName))
+	assert.NoError(t, err)
+
+	u2 := getTestUser()
+	u2.Username = defaultUsername + "_2"
+	user2, _, err := httpdtest.AddUser(u2, http.StatusCreated)
+	assert.NoError(t, err)
+	user.Description = "updated user"
+	_, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+
+	report, _, err := httpdtest.RestoreArchive(archive.Name, "", true, http.StatusOK)
+	assert.NoError(t, err)
+	assert.True(t, report.DryRun)
+	assert.Equal(t, dataprovider.ArchiveRestoreModeMerge, report.Mode)
+	for _, scope := range report.Scopes {
+		assert.Empty(t, scope.Errors)
+		assert.Empty(t, scope.Deleted)
+		switch scope.Scope {
+		case dataprovider.DumpScopeUsers:
+			assert.Contains(t, scope.Conflicts, user.Username)
+			assert.NotContains(t, scope.Conflicts, user2.Username)
+		case dataprovider.DumpScopeGroups:
+			assert.Contains(t, scope.Conflicts, group.Name)
+		}
+	}
+	report, _, err = httpdtest.RestoreArchive(archive.Name, dataprovider.ArchiveRestoreModeReplace, true,
+		http.StatusOK, dataprovider.DumpScopeUsers)
+	assert.NoError(t, err)
+	if assert.Len(t, report.Scopes, 1) {
+		assert.Equal(t, dataprovider.DumpScopeUsers, report.Scopes[0].Scope)
+		assert.Contains(t, report.Scopes[0].Deleted, user2.Username)
+	}
+	// dry-run does not change anything
+	user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+	assert.NoError(t, err)
+	assert.Equal(t, "updated user", user.Description)
+	_, _, err = httpdtest.GetUserByUsername(user2.Username, http.StatusOK)
+	assert.NoError(t, err)
+
+	report, _, err = httpdtest.RestoreArchive(archive.Name, dataprovider.ArchiveRestoreModeReplace, false,
+		http.StatusOK, dataprovider.DumpScopeUsers)
+	assert.NoError(t, err)
+	assert.False(t, report.DryRun)
+	user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+	assert.NoError(t, err)
+	assert.Equal(t, "archived user", user.Description)
+	_, _, err = httpdtest.GetUserByUsername(user2.Username, http.StatusNotFound)
+	assert.NoError(t, err)
+
+	_, _, err = httpdtest.RestoreArchive(archive.Name, "invalid", false, http.StatusBadRequest)
+	assert.NoError(t, err)
+	_, _, err = httpdtest.RestoreArchive(archive.Name, "", false, http.StatusBadRequest, "invalid scope")
+	assert.NoError(t, err)
+	_, _, err = httpdtest.RestoreArchive("invalid name", "", false, http.StatusBadRequest)
+	assert.NoError(t, err)
+	_, _, err = httpdtest.RestoreArchive("sftpgo-archive-20260101T000000.000Z.json", "", false, http.StatusNotFound)
+	assert.NoError(t, err)
+	// an encrypted archive cannot be restored without the passphrase
+	err = dataprovider.Close()
+	assert.NoError(t, err)
+	providerConf.Archives.Passphrase = ""
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.NoError(t, err)
+	_, _, err = httpdtest.RestoreArchive(archive.Name, "", true, http.StatusBadRequest)
+	assert.NoError(t, err)
+	archive, _, err = httpdtest.CreateArchive(http.StatusCreated)
+	assert.NoError(t, err)
+	assert.False(t, archive.Encrypted)
+	_, _, err = httpdtest.RestoreArchive(archive.Name, "", false, http.StatusOK)
+	assert.NoError(t, err)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveGroup(group, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveFolder(folder, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(mappedPath)
+	assert.NoError(t, err)
+
+	err = dataprovider.Close()
+	assert.NoError(t, err)
+	err = config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	providerConf = config.GetProviderConf()
+	providerConf.BackupsPath = backupsPath
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.NoError(t, err)
+}
+
+func TestMachineAPIKey(t *testing.T) {
+	apiKey := dataprovider.APIKey{
+		Name:  "machine key",
+		Scope: dataprovider.APIKeyScopeMachine,
+	}
+	_, _, err := httpdtest.AddAPIKey(apiKey, http.StatusBadRequest)
+	assert.NoError(t, err)
+	apiKey.Permissions = []string{"users:list"}
+	_, _, err = httpdtest.AddAPIKey(apiKey, http.StatusBadRequest)
+	assert.NoError(t, err)
+
+	err = dataprovider.Close()
+	assert.NoError(t, err)
+	err = config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	providerConf := config.GetProviderConf()
+	providerConf.BackupsPath = backupsPath
+	providerConf.ChangeFeed.Enabled = true
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.NoError(t, err)
+
+	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
+	assert.NoError(t, err)
+	apiKey.Permissions = []string{dataprovider.APIKeyPermUsersCreate, dataprovider.APIKeyPermUsersRead,
+		dataprovider.APIKeyPermUsersUpdate, dataprovider.APIKeyPermFoldersRead}
+	apiKey.UsernamePrefix = "prov_"
+	// groups and folders are shared between users, they cannot be modified
+	// using API keys restricted to a username prefix
+	for _, perm := range []string{dataprovider.APIKeyPermGroupsUpdate, dataprovider.APIKeyPermFoldersCreate} {
+		invalidKey := apiKey
+		invalidKey.Permissions = append(slices.Clone(apiKey.Permissions), perm)
+		_, _, err = httpdtest.AddAPIKey(invalidKey, http.StatusBadRequest)
+		assert.NoError(t, err)
+	}
+	apiKey, _, err = httpdtest.AddAPIKey(apiKey, http.StatusCreated)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), apiKey.LastUseAt)
+	key := apiKey.Key
+
+	u := getTestUser()
+	u.Username = "prov_user"
+	u.Password = defaultPassword
+	userAsJSON := getUserAsJSON(t, u)
+	req, err := http.NewRequest(http.MethodPost, userPath, bytes.NewBuffer(userAsJSON))
+	assert.NoError(t, err)
+	setAPIKeyForReq(req, key, "")
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, rr)
+	// the username prefix is enforced
+	u.Username = "other_user"
+	userAsJSON = getUserAsJSON(t, u)
+	req, err = http.NewRequest(http.MethodPost, userPath, bytes.NewBuffer(userAsJSON))
+	assert.NoError(t, err)
+	setAPIKeyForReq(req, key, "")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
+	_, _, err = httpdtest.GetUserByUsername(u.Username, http.StatusNotFound)
+	assert.NoError(t, err)
+
+	req, err = http.NewRequest(http.MethodGet, userPath, nil)
+	assert.NoError(t, err)
+	setAPIKeyForReq(req, key, "")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	var users []dataprovider.User
+	err = json.Unmarshal(rr.Body.Bytes(), &users)
+	assert.NoError(t, err)
+	if assert.Len(t, users, 1) {
+		assert.Equal(t, "prov_user", users[0].Username)
+		assert.Empty(t, users[0].Password)
+	}
+	// the prefix is applied before the pagination
+	req, err = http.NewRequest(http.MethodGet, userPath+"?limit=1&order=DESC", nil)
+	assert.NoError(t, err)
+	setAPIKeyForReq(req, key, "")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	users = nil
+	err = json.Unmarshal(rr.Body.Bytes(), &users)
+	assert.NoError(t, err)
+	if assert.Len(t, users, 1) {
+		assert.Equal(t, "prov_user", users[0].Username)
+	}
+	req, err = http.NewRequest(http.MethodGet, path.Join(userPath, user.Username), nil)
+	assert.NoError(t, err)
+	setAPIKeyForReq(req, key, "")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
+	u.Username = "prov_user"
+	u.Description = "updated by machine key"
+	userAsJSON = getUserAsJSON(t, u)
+	req, err = http.NewRequest(http.MethodPut, path.Join(userPath, u.Username), bytes.NewBuffer(userAsJSON))
+	assert.NoError(t, err)
+	setAPIKeyForReq(req, key, "")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	// the role cannot be set or changed
+	u.Role = "machine_role"
+	userAsJSON = getUserAsJSON(t, u)
+	req, err = http.NewRequest(http.MethodPut, path.Join(userPath, u.Username), bytes.NewBuffer(userAsJSON))
+	assert.NoError(t, err)
+	setAPIKeyForReq(req, key, "")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
+	u.Username = "prov_user_role"
+	userAsJSON = getUserAsJSON(t, u)
+	req, err = http.NewRequest(http.MethodPost, userPath, bytes.NewBuffer(userAsJSON))
+	assert.NoError(t, err)
+	setAPIKeyForReq(req, key, "")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
+	_, _, err = httpdtest.GetUserByUsername(u.Username, http.StatusNotFound)
+	assert.NoError(t, err)
+	u.Username = "prov_user"
+	u.Role = ""
+	// the permissions are enforced
+	req, err = http.NewRequest(http.MethodDelete, path.Join(userPath, u.Username), nil)
+	assert.NoError(t, err)
+	setAPIKeyForReq(req, key, "")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
+	req, err = http.NewRequest(http.MethodGet, folderPath, nil)
+	assert.NoError(t, err)
+	setAPIKeyForReq(req, key, "")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	req, err = http.NewRequest(http.MethodPost, folderPath, bytes.NewBuffer([]byte(`{"name":"prov_folder"}`)))
+	assert.NoError(t, err)
+	setAPIKeyForReq(req, key, "")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
+	for _, p := range []string{groupPath, adminPath, serverStatusPath, path.Join(userPath, u.Username, "2fa", "disable")} {
+		method := http.MethodGet
+		if strings.HasSuffix(p, "disable") {
+			method = http.MethodPut
+		}
+		req, err = http.NewRequest(method, p, nil)
+		assert.NoError(t, err)
+		setAPIKeyForReq(req, key, "")
+		rr = executeRequest(req)
+		checkResponseCode(t, http.StatusForbidden, rr)
+	}
+	req, err = http.NewRequest(http.MethodGet, apiKeysPath, nil)
+	assert.NoError(t, err)
+	setAPIKeyForReq(req, key, "")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
+	// the API key is recorded as actor
+	var actions []string
+	var sinceID int64
+	for {
+		changes, err := dataprovider.GetObjectChanges(sinceID, 100)
+		assert.NoError(t, err)
+		if len(changes) == 0 {
+			break
+		}
+		for _, change := range changes {
+			sinceID = change.ID
+			if change.Actor == "api_key:"+apiKey.KeyID {
+				assert.Equal(t, "prov_user", change.ObjectName)
+				actions = append(actions, change.Action)
+			}
+		}
+	}
+	assert.Len(t, actions, 2)
+
+	apiKey, _, err = httpdtest.GetAPIKeyByID(apiKey.KeyID, http.StatusOK)
+	assert.NoError(t, err)
+	assert.Greater(t, apiKey.LastUseAt, int64(0))
+	// the permissions can be updated
+	apiKey.Permissions = append(apiKey.Permissions, dataprovider.APIKeyPermUsersDelete)
+	apiKey.UsernamePrefix = ""
+	_, _, err = httpdtest.UpdateAPIKey(apiKey, http.StatusOK)
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodGet, path.Join(userPath, user.Username), nil)
+	assert.NoError(t, err)
+	setAPIKeyForReq(req, key, "")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	req, err = http.NewRequest(http.MethodDelete, path.Join(userPath, "prov_user"), nil)
+	assert.NoError(t, err)
+	setAPIKeyForReq(req, key, "")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	// expired keys cannot be used
+	apiKey.ExpiresAt = util.GetTimeAsMsSinceEpoch(time.Now().Add(-1 * time.Minute))
+	_, _, err = httpdtest.UpdateAPIKey(apiKey, http.StatusOK)
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodGet, userPath, nil)
+	assert.NoError(t, err)
+	setAPIKeyForReq(req, key, "")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusUnauthorized, rr)
+	// revoked keys cannot be used
+	_, err = httpdtest.RemoveAPIKey(apiKey, http.StatusOK)
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodGet, userPath, nil)
+	assert.NoError(t, err)
+	setAPIKeyForReq(req, key, "")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	err = os.RemoveAll(filepath.Join(homeBasePath, "prov_user"))
+	assert.NoError(t, err)
+
+	err = dataprovider.Close()
+	assert.NoError(t, err)
+	err = config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	providerConf = config.GetProviderConf()
+	providerConf.BackupsPath = backupsPath
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.NoError(t, err)
+}
+
 func TestRoleRelations(t *testing.T) {
 	r := getTestRole()
 	role, resp, err := httpdtest.AddRole(r, http.StatusCreated)
@@ -1301,6 +1816,7 @@ func TestGroupValidation(t *testing.T) {
 	_, resp, err = httpdtest.AddGroup(group, http.StatusBadRequest)
 	assert.NoError(t, err)
 	assert.Contains(t, string(resp), "home_dir must be an absolute path")
+	assert.Contains(t, string(resp), `"error_code":"home_dir_invalid"`)
 	group.UserSettings.HomeDir = ""
 	group.UserSettings.Filters.WebClient = []string{"invalid permission"}
 	_, resp, err = httpdtest.AddGroup(group, http.StatusBadRequest)
@@ -1492,93 +2008,338 @@ func TestGroupSettingsOverride(t *testing.T) {
 	group2.UserSettings.Filters.WebClient = []string{sdk.WebClientInfoChangeDisabled, sdk.WebClientMFADisabled}
 	_, _, err = httpdtest.UpdateGroup(group2, http.StatusOK)
 	assert.NoError(t, err)
-	user, err = dataprovider.CheckUserAndPass(defaultUsername, defaultPassword, "", common.ProtocolHTTP)
+	user, err = dataprovider.CheckUserAndPass(defaultUsername, defaultPassword, "", common.ProtocolHTTP)
+	assert.NoError(t, err)
+	assert.Len(t, user.VirtualFolders, 4)
+	assert.Equal(t, sdk.LocalFilesystemProvider, user.FsConfig.Provider)
+	assert.Equal(t, int64(0), user.DownloadBandwidth)
+	assert.Equal(t, int64(0), user.UploadBandwidth)
+	assert.Equal(t, 0, user.Filters.PasswordStrength)
+	assert.Equal(t, []string{dataprovider.PermAny}, user.GetPermissionsForPath("/"))
+	assert.Equal(t, []string{dataprovider.PermListItems}, user.GetPermissionsForPath("/"+defaultUsername))
+	assert.Len(t, user.Filters.WebClient, 2)
+
+	group1.UserSettings.FsConfig = vfs.Filesystem{
+		Provider: sdk.SFTPFilesystemProvider,
+		SFTPConfig: vfs.SFTPFsConfig{
+			BaseSFTPFsConfig: sdk.BaseSFTPFsConfig{
+				Endpoint: sftpServerAddr,
+				Username: altAdminUsername,
+				Prefix:   "/dirs/%role%/%username%",
+			},
+			Password: kms.NewPlainSecret(defaultPassword),
+		},
+	}
+	group1.UserSettings.MaxSessions = 2
+	group1.UserSettings.QuotaFiles = 1000
+	group1.UserSettings.UploadBandwidth = 512
+	group1.UserSettings.DownloadBandwidth = 1024
+	group1.UserSettings.TotalDataTransfer = 2048
+	group1.UserSettings.ExpiresIn = 15
+	group1.UserSettings.Filters.MaxUploadFileSize = 1024 * 1024
+	group1.UserSettings.Filters.StartDirectory = "/startdir/%username%"
+	group1.UserSettings.Filters.PasswordStrength = 70
+	group1.UserSettings.Filters.WebClient = []string{sdk.WebClientInfoChangeDisabled}
+	group1.UserSettings.Permissions = map[string][]string{
+		"/":                  {dataprovider.PermListItems, dataprovider.PermUpload},
+		"/sub/%username%":    {dataprovider.PermRename},
+		"/%role%/%username%": {dataprovider.PermDelete},
+	}
+	group1.UserSettings.Filters.FilePatterns = []sdk.PatternsFilter{
+		{
+			Path:            "/sub2/%role%/%username%test",
+			AllowedPatterns: []string{},
+			DeniedPatterns:  []string{"*.jpg", "*.zip"},
+		},
+	}
+	_, _, err = httpdtest.UpdateGroup(group1, http.StatusOK)
+	assert.NoError(t, err)
+	user, err = dataprovider.CheckUserAndPass(defaultUsername, defaultPassword, "", common.ProtocolHTTP)
+	assert.NoError(t, err)
+	assert.Len(t, user.VirtualFolders, 4)
+	assert.Equal(t, user.CreatedAt+int64(group1.UserSettings.ExpiresIn)*86400000, user.ExpirationDate)
+	assert.Equal(t, group1.UserSettings.Filters.PasswordStrength, user.Filters.PasswordStrength)
+	assert.Equal(t, sdk.SFTPFilesystemProvider, user.FsConfig.Provider)
+	assert.Equal(t, altAdminUsername, user.FsConfig.SFTPConfig.Username)
+	assert.Equal(t, "/dirs/"+role.Name+"/"+defaultUsername, user.FsConfig.SFTPConfig.Prefix)
+	assert.Equal(t, []string{dataprovider.PermListItems, dataprovider.PermUpload}, user.GetPermissionsForPath("/"))
+	assert.Equal(t, []string{dataprovider.PermDelete}, user.GetPermissionsForPath(path.Join("/", role.Name, defaultUsername)))
+	assert.Equal(t, []string{dataprovider.PermRename}, user.GetPermissionsForPath(path.Join("/sub", defaultUsername)))
+	assert.Equal(t, group1.UserSettings.MaxSessions, user.MaxSessions)
+	assert.Equal(t, group1.UserSettings.QuotaFiles, user.QuotaFiles)
+	assert.Equal(t, group1.UserSettings.UploadBandwidth, user.UploadBandwidth)
+	assert.Equal(t, group1.UserSettings.TotalDataTransfer, user.TotalDataTransfer)
+	assert.Equal(t, group1.UserSettings.Filters.MaxUploadFileSize, user.Filters.MaxUploadFileSize)
+	assert.Equal(t, "/startdir/"+defaultUsername, user.Filters.StartDirectory)
+	if assert.Len(t, user.Filters.FilePatterns, 1) {
+		assert.Equal(t, "/sub2/"+role.Name+"/"+defaultUsername+"test", user.Filters.FilePatterns[0].Path) //nolint:goconst
+	}
+	if assert.Len(t, user.Filters.WebClient, 2) {
+		assert.Contains(t, user.Filters.WebClient, sdk.WebClientInfoChangeDisabled)
+		assert.Contains(t, user.Filters.WebClient, sdk.WebClientMFADisabled)
+	}
+
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveGroup(group1, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveGroup(group2, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveFolder(vfs.BaseVirtualFolder{Name: folderName1}, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveFolder(vfs.BaseVirtualFolder{Name: folderName2}, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveFolder(vfs.BaseVirtualFolder{Name: folderName3}, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveRole(role, http.StatusOK)
+	assert.NoError(t, err)
+}
+
+func TestGroupSettingsMerge(t *testing.T) {
+	mappedPath1 := filepath.Join(os.TempDir(), util.GenerateUniqueID())
+	folderName1 := filepath.Base(mappedPath1)
+	mappedPath2 := filepath.Join(os.TempDir(), util.GenerateUniqueID())
+	folderName2 := filepath.Base(mappedPath2)
+	mappedPath3 := filepath.Join(os.TempDir(), util.GenerateUniqueID())
+	folderName3 := filepath.Base(mappedPath3)
+	_, _, err := httpdtest.AddFolder(vfs.BaseVirtualFolder{Name: folderName1, MappedPath: mappedPath1}, http.StatusCreated)
+	assert.NoError(t, err)
+	_, _, err = httpdtest.AddFolder(vfs.BaseVirtualFolder{Name: folderName2, MappedPath: mappedPath2}, http.StatusCreated)
+	assert.NoError(t, err)
+	_, _, err = httpdtest.AddFolder(vfs.BaseVirtualFolder{Name: folderName3, MappedPath: mappedPath3}, http.StatusCreated)
+	assert.NoError(t, err)
+
+	g1 := getTestGroup()
+	g1.Name += "_1"
+	g1.VirtualFolders = []vfs.VirtualFolder{
+		{
+			BaseVirtualFolder: vfs.BaseVirtualFolder{Name: folderName2},
+			VirtualPath:       "/shared",
+		},
+		{
+			BaseVirtualFolder: vfs.BaseVirtualFolder{Name: folderName3},
+			VirtualPath:       "/primary",
+		},
+	}
+	g1.UserSettings.Permissions = map[string][]string{
+		"/dir1": {dataprovider.PermUpload},
+		"/dir2": {dataprovider.PermDownload},
+	}
+	g2 := getTestGroup()
+	g2.Name += "_2"
+	g2.VirtualFolders = []vfs.VirtualFolder{
+		{
+			BaseVirtualFolder: vfs.BaseVirtualFolder{Name: folderName2},
+			VirtualPath:       "/primary",
+		},
+		{
+			BaseVirtualFolder: vfs.BaseVirtualFolder{Name: folderName1},
+			VirtualPath:       "/secondary",
+		},
+	}
+	g2.UserSettings.Permissions = map[string][]string{
+		"/dir1": {dataprovider.PermDropbox},
+		"/dir2": {dataprovider.PermListItems},
+		"/dir3": {dataprovider.PermDropbox},
+	}
+	g2.UserSettings.PermissionsMerge = 2
+	_, resp, err := httpdtest.AddGroup(g2, http.StatusBadRequest)
+	assert.NoError(t, err)
+	assert.Contains(t, string(resp), "invalid permissions merge mode")
+	g2.UserSettings.PermissionsMerge = dataprovider.PermissionsMergeKeep
+
+	group1, resp, err := httpdtest.AddGroup(g1, http.StatusCreated)
+	assert.NoError(t, err, string(resp))
+	group2, resp, err := httpdtest.AddGroup(g2, http.StatusCreated)
+	assert.NoError(t, err, string(resp))
+	u := getTestUser()
+	u.VirtualFolders = []vfs.VirtualFolder{
+		{
+			BaseVirtualFolder: vfs.BaseVirtualFolder{Name: folderName1},
+			VirtualPath:       "/shared",
+		},
+	}
+	u.Permissions["/dir1"] = []string{dataprovider.PermListItems}
+	u.Groups = []sdk.GroupMapping{
+		{
+			Name: group1.Name,
+			Type: sdk.GroupTypePrimary,
+		},
+		{
+			Name: group2.Name,
+			Type: sdk.GroupTypeSecondary,
+		},
+	}
+	user, resp, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err, string(resp))
+	assert.Nil(t, user.EffectiveSources)
+
+	userSource := dataprovider.EffectiveSettingSource{}
+	primarySource := dataprovider.EffectiveSettingSource{Group: group1.Name, GroupType: sdk.GroupTypePrimary}
+	secondarySource := dataprovider.EffectiveSettingSource{Group: group2.Name, GroupType: sdk.GroupTypeSecondary}
+
+	effectiveUser, _, err := httpdtest.GetEffectiveUserSettings(user.Username, http.StatusOK)
+	assert.NoError(t, err)
+	if assert.Len(t, effectiveUser.VirtualFolders, 3) {
+		for _, f := range effectiveUser.VirtualFolders {
+			switch f.VirtualPath {
+			case "/shared", "/secondary":
+				assert.Equal(t, folderName1, f.Name)
+			case "/primary":
+				assert.Equal(t, folderName3, f.Name)
+			default:
+				t.Errorf("unexpected virtual path %q", f.VirtualPath)
+			}
+		}
+	}
+	assert.Equal(t, []string{dataprovider.PermListItems, dataprovider.PermUpload}, effectiveUser.Permissions["/dir1"])
+	assert.Equal(t, []string{dataprovider.PermDownload}, effectiveUser.Permissions["/dir2"])
+	assert.Equal(t, []string{dataprovider.PermDropbox}, effectiveUser.Permissions["/dir3"])
+	if assert.NotNil(t, effectiveUser.EffectiveSources) {
+		sources := effectiveUser.EffectiveSources
+		assert.Equal(t, userSource, sources.VirtualFolders["/shared"])
+		assert.Equal(t, primarySource, sources.VirtualFolders["/primary"])
+		assert.Equal(t, secondarySource, sources.VirtualFolders["/secondary"])
+		assert.Equal(t, []dataprovider.EffectiveSettingSource{userSource}, sources.Permissions["/"])
+		assert.Equal(t, []dataprovider.EffectiveSettingSource{userSource, primarySource}, sources.Permissions["/dir1"])
+		assert.Equal(t, []dataprovider.EffectiveSettingSource{primarySource}, sources.Permissions["/dir2"])
+		assert.Equal(t, []dataprovider.EffectiveSettingSource{secondarySource}, sources.Permissions["/dir3"])
+	}
+	// the same settings must be applied on login
+	user, err = dataprovider.CheckUserAndPass(defaultUsername, defaultPassword, "", common.ProtocolHTTP)
+	assert.NoError(t, err)
+	assert.Len(t, user.VirtualFolders, 3)
+	assert.Equal(t, effectiveUser.Permissions, user.Permissions)
+	assert.Nil(t, user.EffectiveSources)
+
+	group2.UserSettings.PermissionsMerge = dataprovider.PermissionsMergeUnion
+	group2, _, err = httpdtest.UpdateGroup(group2, http.StatusOK)
+	assert.NoError(t, err)
+	assert.Equal(t, dataprovider.PermissionsMergeUnion, group2.UserSettings.PermissionsMerge)
+	effectiveUser, _, err = httpdtest.GetEffectiveUserSettings(user.Username, http.StatusOK)
+	assert.NoError(t, err)
+	// dropbox cannot be combined with list so it is replaced by the permissions it implies
+	assert.Equal(t, []string{dataprovider.PermListItems, dataprovider.PermUpload, dataprovider.PermCreateDirs},
+		effectiveUser.Permissions["/dir1"])
+	assert.Equal(t, []string{dataprovider.PermDownload, dataprovider.PermListItems}, effectiveUser.Permissions["/dir2"])
+	if assert.NotNil(t, effectiveUser.EffectiveSources) {
+		sources := effectiveUser.EffectiveSources
+		assert.Equal(t, []dataprovider.EffectiveSettingSource{userSource, primarySource, secondarySource},
+			sources.Permissions["/dir1"])
+		assert.Equal(t, []dataprovider.EffectiveSettingSource{primarySource, secondarySource}, sources.Permissions["/dir2"])
+	}
+	// the stored user is unchanged
+	user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+	assert.NoError(t, err)
+	assert.Len(t, user.VirtualFolders, 1)
+	assert.Len(t, user.Permissions, 2)
+	assert.Nil(t, user.EffectiveSources)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	_, _, err = httpdtest.GetEffectiveUserSettings(user.Username, http.StatusNotFound)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveGroup(group1, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveGroup(group2, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveFolder(vfs.BaseVirtualFolder{Name: folderName1}, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveFolder(vfs.BaseVirtualFolder{Name: folderName2}, http.StatusOK)
 	assert.NoError(t, err)
-	assert.Len(t, user.VirtualFolders, 4)
-	assert.Equal(t, sdk.LocalFilesystemProvider, user.FsConfig.Provider)
-	assert.Equal(t, int64(0), user.DownloadBandwidth)
-	assert.Equal(t, int64(0), user.UploadBandwidth)
-	assert.Equal(t, 0, user.Filters.PasswordStrength)
-	assert.Equal(t, []string{dataprovider.PermAny}, user.GetPermissionsForPath("/"))
-	assert.Equal(t, []string{dataprovider.PermListItems}, user.GetPermissionsForPath("/"+defaultUsername))
-	assert.Len(t, user.Filters.WebClient, 2)
+	_, err = httpdtest.RemoveFolder(vfs.BaseVirtualFolder{Name: folderName3}, http.StatusOK)
+	assert.NoError(t, err)
+}
 
-	group1.UserSettings.FsConfig = vfs.Filesystem{
-		Provider: sdk.SFTPFilesystemProvider,
-		SFTPConfig: vfs.SFTPFsConfig{
-			BaseSFTPFsConfig: sdk.BaseSFTPFsConfig{
-				Endpoint: sftpServerAddr,
-				Username: altAdminUsername,
-				Prefix:   "/dirs/%role%/%username%",
-			},
-			Password: kms.NewPlainSecret(defaultPassword),
+func TestUserDiagnostics(t *testing.T) {
+	mappedPath1 := filepath.Join(os.TempDir(), util.GenerateUniqueID())
+	folderName1 := filepath.Base(mappedPath1)
+	mappedPath2 := filepath.Join(os.TempDir(), util.GenerateUniqueID())
+	folderName2 := filepath.Base(mappedPath2)
+	_, _, err := httpdtest.AddFolder(vfs.BaseVirtualFolder{Name: folderName1, MappedPath: mappedPath1}, http.StatusCreated)
+	assert.NoError(t, err)
+	_, _, err = httpdtest.AddFolder(vfs.BaseVirtualFolder{Name: folderName2, MappedPath: mappedPath2}, http.StatusCreated)
+	assert.NoError(t, err)
+	g := getTestGroup()
+	g.UserSettings.Filters.FilePatterns = []sdk.PatternsFilter{
+		{
+			Path:           "/vdir/nested",
+			DeniedPatterns: []string{"*.zip"},
 		},
 	}
-	group1.UserSettings.MaxSessions = 2
-	group1.UserSettings.QuotaFiles = 1000
-	group1.UserSettings.UploadBandwidth = 512
-	group1.UserSettings.DownloadBandwidth = 1024
-	group1.UserSettings.TotalDataTransfer = 2048
-	group1.UserSettings.ExpiresIn = 15
-	group1.UserSettings.Filters.MaxUploadFileSize = 1024 * 1024
-	group1.UserSettings.Filters.StartDirectory = "/startdir/%username%"
-	group1.UserSettings.Filters.PasswordStrength = 70
-	group1.UserSettings.Filters.WebClient = []string{sdk.WebClientInfoChangeDisabled}
-	group1.UserSettings.Permissions = map[string][]string{
-		"/":                  {dataprovider.PermListItems, dataprovider.PermUpload},
-		"/sub/%username%":    {dataprovider.PermRename},
-		"/%role%/%username%": {dataprovider.PermDelete},
+	g.VirtualFolders = []vfs.VirtualFolder{
+		{
+			BaseVirtualFolder: vfs.BaseVirtualFolder{Name: folderName2},
+			VirtualPath:       "/vdir/nested",
+		},
 	}
-	group1.UserSettings.Filters.FilePatterns = []sdk.PatternsFilter{
+	group, resp, err := httpdtest.AddGroup(g, http.StatusCreated)
+	assert.NoError(t, err, string(resp))
+	u := getTestUser()
+	u.VirtualFolders = []vfs.VirtualFolder{
 		{
-			Path:            "/sub2/%role%/%username%test",
-			AllowedPatterns: []string{},
-			DeniedPatterns:  []string{"*.jpg", "*.zip"},
+			BaseVirtualFolder: vfs.BaseVirtualFolder{Name: folderName1},
+			VirtualPath:       "/vdir",
 		},
 	}
-	_, _, err = httpdtest.UpdateGroup(group1, http.StatusOK)
+	u.Permissions["/vdir"] = []string{dataprovider.PermListItems}
+	u.Permissions["/vdir/nested"] = []string{dataprovider.PermAny}
+	u.Groups = []sdk.GroupMapping{
+		{
+			Name: group.Name,
+			Type: sdk.GroupTypeSecondary,
+		},
+	}
+	user, resp, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err, string(resp))
+
+	diagnostic, _, err := httpdtest.GetUserDiagnostic(user.Username, "", "", "", http.StatusOK)
 	assert.NoError(t, err)
-	user, err = dataprovider.CheckUserAndPass(defaultUsername, defaultPassword, "", common.ProtocolHTTP)
+	assert.True(t, diagnostic.Allowed)
+	assert.Empty(t, diagnostic.User.Password)
+	assert.Len(t, diagnostic.User.VirtualFolders, 2)
+	assert.Len(t, diagnostic.User.Filters.FilePatterns, 1)
+	diagnostic, _, err = httpdtest.GetUserDiagnostic(user.Username, "/vdir/file.txt", common.DiagnosticOperationUpload,
+		common.ProtocolSSH, http.StatusOK)
 	assert.NoError(t, err)
-	assert.Len(t, user.VirtualFolders, 4)
-	assert.Equal(t, user.CreatedAt+int64(group1.UserSettings.ExpiresIn)*86400000, user.ExpirationDate)
-	assert.Equal(t, group1.UserSettings.Filters.PasswordStrength, user.Filters.PasswordStrength)
-	assert.Equal(t, sdk.SFTPFilesystemProvider, user.FsConfig.Provider)
-	assert.Equal(t, altAdminUsername, user.FsConfig.SFTPConfig.Username)
-	assert.Equal(t, "/dirs/"+role.Name+"/"+defaultUsername, user.FsConfig.SFTPConfig.Prefix)
-	assert.Equal(t, []string{dataprovider.PermListItems, dataprovider.PermUpload}, user.GetPermissionsForPath("/"))
-	assert.Equal(t, []string{dataprovider.PermDelete}, user.GetPermissionsForPath(path.Join("/", role.Name, defaultUsername)))
-	assert.Equal(t, []string{dataprovider.PermRename}, user.GetPermissionsForPath(path.Join("/sub", defaultUsername)))
-	assert.Equal(t, group1.UserSettings.MaxSessions, user.MaxSessions)
-	assert.Equal(t, group1.UserSettings.QuotaFiles, user.QuotaFiles)
-	assert.Equal(t, group1.UserSettings.UploadBandwidth, user.UploadBandwidth)
-	assert.Equal(t, group1.UserSettings.TotalDataTransfer, user.TotalDataTransfer)
-	assert.Equal(t, group1.UserSettings.Filters.MaxUploadFileSize, user.Filters.MaxUploadFileSize)
-	assert.Equal(t, "/startdir/"+defaultUsername, user.Filters.StartDirectory)
-	if assert.Len(t, user.Filters.FilePatterns, 1) {
-		assert.Equal(t, "/sub2/"+role.Name+"/"+defaultUsername+"test", user.Filters.FilePatterns[0].Path) //nolint:goconst
+	assert.False(t, diagnostic.Allowed)
+	assert.Equal(t, common.DiagnosticRulePermissions, diagnostic.DeniedBy)
+	if assert.NotNil(t, diagnostic.VirtualFolder) {
+		assert.Equal(t, folderName1, diagnostic.VirtualFolder.Name)
 	}
-	if assert.Len(t, user.Filters.WebClient, 2) {
-		assert.Contains(t, user.Filters.WebClient, sdk.WebClientInfoChangeDisabled)
-		assert.Contains(t, user.Filters.WebClient, sdk.WebClientMFADisabled)
+	diagnostic, _, err = httpdtest.GetUserDiagnostic(user.Username, "/vdir/nested/file.txt", common.DiagnosticOperationUpload,
+		common.ProtocolSSH, http.StatusOK)
+	assert.NoError(t, err)
+	assert.True(t, diagnostic.Allowed)
+	if assert.NotNil(t, diagnostic.VirtualFolder) {
+		assert.Equal(t, folderName2, diagnostic.VirtualFolder.Name)
 	}
-
-	err = os.RemoveAll(user.GetHomeDir())
+	assert.NotNil(t, diagnostic.Quota)
+	diagnostic, _, err = httpdtest.GetUserDiagnostic(user.Username, "/vdir/nested/file.zip", common.DiagnosticOperationUpload,
+		"", http.StatusOK)
+	assert.NoError(t, err)
+	assert.False(t, diagnostic.Allowed)
+	assert.Equal(t, common.DiagnosticRulePatternsFilter, diagnostic.DeniedBy)
+	if assert.NotNil(t, diagnostic.PatternsFilter) {
+		assert.Equal(t, "/vdir/nested", diagnostic.PatternsFilter.Filter.Path)
+	}
+	_, _, err = httpdtest.GetUserDiagnostic(user.Username, "/file.txt", "invalid", "", http.StatusBadRequest)
+	assert.NoError(t, err)
+	_, _, err = httpdtest.GetUserDiagnostic(user.Username, "", "", "invalid", http.StatusBadRequest)
 	assert.NoError(t, err)
+
 	_, err = httpdtest.RemoveUser(user, http.StatusOK)
 	assert.NoError(t, err)
-	_, err = httpdtest.RemoveGroup(group1, http.StatusOK)
+	_, _, err = httpdtest.GetUserDiagnostic(user.Username, "", "", "", http.StatusNotFound)
 	assert.NoError(t, err)
-	_, err = httpdtest.RemoveGroup(group2, http.StatusOK)
+	_, err = httpdtest.RemoveGroup(group, http.StatusOK)
 	assert.NoError(t, err)
 	_, err = httpdtest.RemoveFolder(vfs.BaseVirtualFolder{Name: folderName1}, http.StatusOK)
 	assert.NoError(t, err)
 	_, err = httpdtest.RemoveFolder(vfs.BaseVirtualFolder{Name: folderName2}, http.StatusOK)
 	assert.NoError(t, err)
-	_, err = httpdtest.RemoveFolder(vfs.BaseVirtualFolder{Name: folderName3}, http.StatusOK)
-	assert.NoError(t, err)
-	_, err = httpdtest.RemoveRole(role, http.StatusOK)
-	assert.NoError(t, err)
 }
 
 func TestConfigs(t *testing.T) {
@@ -1607,6 +2368,22 @@ func TestConfigs(t *testing.T) {
 	}
 	err = dataprovider.UpdateConfigs(&configs, "", "", "")
 	assert.ErrorIs(t, err, util.ErrValidation)
+	configs.SFTPD = &dataprovider.SFTPDConfigs{
+		MOTD: "Hello {{.Username",
+	}
+	err = dataprovider.UpdateConfigs(&configs, "", "", "")
+	if assert.ErrorIs(t, err, util.ErrValidation) {
+		assert.Contains(t, err.Error(), "invalid MOTD template")
+	}
+	configs.SFTPD.MOTD = strings.Repeat("a", 65537)
+	err = dataprovider.UpdateConfigs(&configs, "", "", "")
+	assert.ErrorIs(t, err, util.ErrValidation)
+	configs.SFTPD.MOTD = "Hello {{.Username}}"
+	err = dataprovider.UpdateConfigs(&configs, "", "", "")
+	assert.NoError(t, err)
+	configs, err = dataprovider.GetConfigs()
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello {{.Username}}", configs.SFTPD.MOTD)
 	configs = dataprovider.Configs{
 		SFTPD: &dataprovider.SFTPDConfigs{},
 		SMTP: &dataprovider.SMTPConfigs{
@@ -4077,6 +4854,120 @@ func TestLoginUserAPITOTP(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestSecondFactorLockout(t *testing.T) {
+	lockout := mfa.GetLockoutConfig()
+	require.True(t, lockout.IsEnabled())
+
+	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
+	assert.NoError(t, err)
+	configName, key, _, err := mfa.GenerateTOTPSecret(mfa.GetAvailableTOTPConfigNames()[0], user.Username)
+	assert.NoError(t, err)
+	user.Password = defaultPassword
+	user.Filters.TOTPConfig = dataprovider.UserTOTPConfig{
+		Enabled:    true,
+		ConfigName: configName,
+		Secret:     kms.NewPlainSecret(key.Secret()),
+		Protocols:  []string{common.ProtocolHTTP},
+	}
+	err = dataprovider.UpdateUser(&user, "", "", "")
+	assert.NoError(t, err)
+
+	getToken := func(passcode string) int {
+		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%v%v", httpBaseURL, userTokenPath), nil)
+		assert.NoError(t, err)
+		req.Header.Set("X-SFTPGO-OTP", passcode)
+		req.SetBasicAuth(defaultUsername, defaultPassword)
+		resp, err := httpclient.GetHTTPClient().Do(req)
+		if !assert.NoError(t, err) {
+			return 0
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusTooManyRequests {
+			body, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err)
+			assert.Contains(t, string(body), dataprovider.ErrTooManySecondFactorAttempts.Error())
+		}
+		return resp.StatusCode
+	}
+	// a successful verification resets the failed attempts
+	for i := 0; i < lockout.MaxAttempts-1; i++ {
+		assert.Equal(t, http.StatusUnauthorized, getToken("000000"))
+	}
+	passcode, err := generateTOTPPasscode(key.Secret())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, getToken(passcode))
+	for i := 0; i < lockout.MaxAttempts-1; i++ {
+		assert.Equal(t, http.StatusUnauthorized, getToken("000000"))
+	}
+	assert.Equal(t, http.StatusTooManyRequests, getToken("000000"))
+	// a valid passcode is refused while the verification is locked out
+	assert.Equal(t, http.StatusTooManyRequests, getToken(passcode))
+	assert.ErrorIs(t, dataprovider.CheckSecondFactorAttempts(user.Username, false),
+		dataprovider.ErrTooManySecondFactorAttempts)
+	// admins with the same username are tracked separately
+	assert.NoError(t, dataprovider.CheckSecondFactorAttempts(user.Username, true))
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	// removing the user resets the failed attempts
+	assert.NoError(t, dataprovider.CheckSecondFactorAttempts(user.Username, false))
+}
+
+func TestSecondFactorLockoutConcurrency(t *testing.T) {
+	lockout := mfa.GetLockoutConfig()
+	require.True(t, lockout.IsEnabled())
+
+	checkConcurrentFailures := func(username string) {
+		var allowed atomic.Int32
+		var wg sync.WaitGroup
+		start := make(chan struct{})
+		for range 50 * lockout.MaxAttempts {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				<-start
+				if dataprovider.UpdateSecondFactorAttempts(username, false, false) == nil {
+					allowed.Add(1)
+				}
+			}()
+		}
+		close(start)
+		wg.Wait()
+		// no failure is lost, only the ones before the lockout are allowed
+		assert.Equal(t, int32(lockout.MaxAttempts-1), allowed.Load())
+		assert.ErrorIs(t, dataprovider.CheckSecondFactorAttempts(username, false),
+			dataprovider.ErrTooManySecondFactorAttempts)
+		assert.NoError(t, dataprovider.UpdateSecondFactorAttempts(username, false, true))
+		assert.NoError(t, dataprovider.CheckSecondFactorAttempts(username, false))
+	}
+
+	checkConcurrentFailures("concurrent_2fa_user")
+
+	providerConf := dataprovider.GetProviderConfig()
+	providerConf.IsShared = 1
+	if providerConf.GetShared() == 0 {
+		t.Skip("the shared mode is not supported with the current database provider")
+	}
+	err := dataprovider.Close()
+	assert.NoError(t, err)
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	require.NoError(t, err)
+
+	checkConcurrentFailures("concurrent_2fa_shared_user")
+
+	err = dataprovider.Close()
+	assert.NoError(t, err)
+	err = config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	providerConf = config.GetProviderConf()
+	providerConf.BackupsPath = backupsPath
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.NoError(t, err)
+}
+
 func TestLoginAdminAPITOTP(t *testing.T) {
 	admin := getTestAdmin()
 	admin.Username = altAdminUsername
@@ -4441,6 +5332,111 @@ func TestPasswordValidations(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestPasswordPolicy(t *testing.T) {
+	if config.GetProviderConf().Driver == dataprovider.MemoryDataProviderName {
+		t.Skip("this test is not supported with the memory provider")
+	}
+	breachedPwd := "Breached_pwd1"
+	breachedHash := sha1.Sum([]byte(breachedPwd))
+	breachedFile := filepath.Join(os.TempDir(), "breached_passwords.txt")
+	err := os.WriteFile(breachedFile, []byte("# test list\n"+
+		strings.ToUpper(hex.EncodeToString(breachedHash[:]))+":123\n"), 0600)
+	assert.NoError(t, err)
+
+	err = dataprovider.Close()
+	assert.NoError(t, err)
+	err = config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	providerConf := config.GetProviderConf()
+	providerConf.PasswordPolicy.MinLength = 10
+	providerConf.PasswordPolicy.RequireUppercase = true
+	providerConf.PasswordPolicy.RequireLowercase = true
+	providerConf.PasswordPolicy.RequireDigit = true
+	providerConf.PasswordPolicy.RequireSpecial = true
+	providerConf.PasswordPolicy.BreachedPasswordsFile = "missing_file.txt"
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.Error(t, err)
+	providerConf.PasswordPolicy.BreachedPasswordsFile = breachedFile
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.NoError(t, err)
+
+	getFieldErrorCodes := func(body []byte) []string {
+		var resp map[string]any
+		err := json.Unmarshal(body, &resp)
+		require.NoError(t, err)
+		fieldErrors, ok := resp["field_errors"].([]any)
+		require.True(t, ok, string(body))
+		var codes []string
+		for _, fe := range fieldErrors {
+			assert.Equal(t, "password", fe.(map[string]any)["field"])
+			codes = append(codes, fe.(map[string]any)["code"].(string))
+		}
+		return codes
+	}
+
+	u := getTestUser()
+	u.Password = "pwd"
+	_, body, err := httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{util.ErrorCodePasswordTooShort, util.ErrorCodePasswordNoUppercase,
+		util.ErrorCodePasswordNoDigit, util.ErrorCodePasswordNoSpecial}, getFieldErrorCodes(body))
+	u.Password = breachedPwd
+	_, body, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{util.ErrorCodePasswordBreached}, getFieldErrorCodes(body))
+	u.Password = "Secure_pwd1"
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	// the policy is enforced for the password changes made by the users too
+	token, err := getJWTAPIUserTokenFromTestServer(defaultUsername, u.Password)
+	assert.NoError(t, err)
+	pwd := make(map[string]string)
+	pwd["current_password"] = u.Password
+	pwd["new_password"] = "SECURE_PWD"
+	asJSON, err := json.Marshal(pwd)
+	assert.NoError(t, err)
+	req, err := http.NewRequest(http.MethodPut, userPwdPath, bytes.NewBuffer(asJSON))
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	assert.Equal(t, []string{util.ErrorCodePasswordNoLowercase, util.ErrorCodePasswordNoDigit},
+		getFieldErrorCodes(rr.Body.Bytes()))
+	pwd["new_password"] = "Secure_pwd2"
+	asJSON, err = json.Marshal(pwd)
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodPut, userPwdPath, bytes.NewBuffer(asJSON))
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+
+	err = os.WriteFile(breachedFile, []byte("ABC\n"), 0600)
+	assert.NoError(t, err)
+	err = dataprovider.Close()
+	assert.NoError(t, err)
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.Error(t, err)
+	err = os.WriteFile(breachedFile, []byte("ABCDZ\n"), 0600)
+	assert.NoError(t, err)
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.Error(t, err)
+	err = os.Remove(breachedFile)
+	assert.NoError(t, err)
+
+	err = config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	providerConf = config.GetProviderConf()
+	providerConf.BackupsPath = backupsPath
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.NoError(t, err)
+}
+
 func TestAdminPasswordHashing(t *testing.T) {
 	if config.GetProviderConf().Driver == dataprovider.MemoryDataProviderName {
 		t.Skip("this test is not supported with the memory provider")
@@ -4694,18 +5690,69 @@ func TestAddUserNoUsername(t *testing.T) {
 	assert.NoError(t, err)
 }
 
-func TestAddUserNoHomeDir(t *testing.T) {
-	u := getTestUser()
-	u.HomeDir = ""
-	_, _, err := httpdtest.AddUser(u, http.StatusBadRequest)
+func TestAddUserNoHomeDir(t *testing.T) {
+	u := getTestUser()
+	u.HomeDir = ""
+	_, _, err := httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+}
+
+func TestAddUserInvalidHomeDir(t *testing.T) {
+	u := getTestUser()
+	u.HomeDir = "relative_path" //nolint:goconst
+	_, body, err := httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+	assert.Contains(t, string(body), `"error_code":"home_dir_invalid"`)
+	u.HomeDir = ""
+	_, body, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+	assert.Contains(t, string(body), `"error_code":"home_dir_required"`)
+}
+
+func TestAddUserMultipleValidationErrors(t *testing.T) {
+	u := getTestUser()
+	u.HomeDir = "relative_path"
+	u.Email = "invalid_email"
+	u.Status = 3
+	u.Filters.DeniedProtocols = []string{"invalid protocol"}
+	_, body, err := httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+	var resp map[string]any
+	err = json.Unmarshal(body, &resp)
+	require.NoError(t, err)
+	fieldErrors, ok := resp["field_errors"].([]any)
+	require.True(t, ok, string(body))
+	require.Len(t, fieldErrors, 4)
+	var fields []string
+	for _, fe := range fieldErrors {
+		fields = append(fields, fe.(map[string]any)["field"].(string))
+	}
+	assert.Equal(t, []string{"email", "home_dir", "status", "filters"}, fields)
+	assert.Equal(t, "home_dir_invalid", fieldErrors[1].(map[string]any)["code"])
+	assert.Contains(t, resp["error"], "home_dir: home_dir must be an absolute path")
+	assert.Contains(t, resp["error"], "status: invalid user status")
+	// a single issue is reported as before
+	u = getTestUser()
+	u.Status = 3
+	_, body, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(body), "field_errors")
+
+	folder := vfs.BaseVirtualFolder{
+		MappedPath: "relative",
+	}
+	_, body, err = httpdtest.AddFolder(folder, http.StatusBadRequest)
 	assert.NoError(t, err)
-}
+	assert.Contains(t, string(body), `"field":"name"`)
+	assert.Contains(t, string(body), `"field":"mapped_path"`)
 
-func TestAddUserInvalidHomeDir(t *testing.T) {
-	u := getTestUser()
-	u.HomeDir = "relative_path" //nolint:goconst
-	_, _, err := httpdtest.AddUser(u, http.StatusBadRequest)
+	group := getTestGroup()
+	group.Name = "invalid name"
+	group.UserSettings.HomeDir = "relative"
+	_, body, err = httpdtest.AddGroup(group, http.StatusBadRequest)
 	assert.NoError(t, err)
+	assert.Contains(t, string(body), `"field":"name"`)
+	assert.Contains(t, string(body), `"field":"user_settings.home_dir"`)
 }
 
 func TestAddUserNoPerms(t *testing.T) {
@@ -4760,8 +5807,9 @@ func TestAddUserInvalidFilters(t *testing.T) {
 	assert.NoError(t, err)
 	u.Filters.DeniedLoginMethods = []string{dataprovider.LoginMethodTLSCertificateAndPwd}
 	u.Filters.DeniedProtocols = dataprovider.ValidProtocols
-	_, _, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	_, body, err := httpdtest.AddUser(u, http.StatusBadRequest)
 	assert.NoError(t, err)
+	assert.Contains(t, string(body), `"error_code":"denied_protocol_invalid"`)
 	u.Filters.DeniedProtocols = []string{common.ProtocolFTP}
 	u.Filters.FilePatterns = []sdk.PatternsFilter{
 		{
@@ -4857,6 +5905,22 @@ func TestAddUserInvalidFilters(t *testing.T) {
 	u.Filters.WebClient = []string{"not a valid web client options"}
 	_, _, err = httpdtest.AddUser(u, http.StatusBadRequest)
 	assert.NoError(t, err)
+	u.Filters.WebClient = nil
+	u.Filters.UploadSizeLimits = []dataprovider.UploadSizeLimit{
+		{
+			Pattern: " ",
+			MaxSize: 100,
+		},
+	}
+	_, _, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+	u.Filters.UploadSizeLimits[0].Pattern = "[-]"
+	_, _, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+	u.Filters.UploadSizeLimits[0].Pattern = "*.iso"
+	u.Filters.UploadSizeLimits[0].MaxSize = 0
+	_, _, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
 }
 
 func TestAddUserInvalidFsConfig(t *testing.T) {
@@ -4911,6 +5975,79 @@ func TestAddUserInvalidFsConfig(t *testing.T) {
 		assert.Contains(t, string(resp), "invalid download concurrency")
 	}
 	u.FsConfig.S3Config.DownloadConcurrency = 0
+	u.FsConfig.S3Config.ContentTypes = []vfs.ContentTypeOverride{
+		{
+			Pattern:     "[a-",
+			ContentType: "text/plain",
+		},
+	}
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "invalid pattern")
+	}
+	u.FsConfig.S3Config.ContentTypes[0].Pattern = "*.log"
+	u.FsConfig.S3Config.ContentTypes[0].ContentType = "text"
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "invalid content type")
+	}
+	u.FsConfig.S3Config.ContentTypes = nil
+	u.FsConfig.S3Config.UploadRules = []vfs.S3UploadRule{
+		{
+			Pattern:      "*.zip",
+			StorageClass: "invalid",
+		},
+	}
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "invalid storage class")
+	}
+	u.FsConfig.S3Config.UploadRules[0].StorageClass = ""
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "a storage class or at least a tag is required")
+	}
+	u.FsConfig.S3Config.UploadRules[0].Tags = map[string]string{
+		"aws:key": "value",
+	}
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "is reserved")
+	}
+	u.FsConfig.S3Config.UploadRules[0].Tags = map[string]string{
+		"key": strings.Repeat("a", 257),
+	}
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "the max length is")
+	}
+	u.FsConfig.S3Config.UploadRules[0].Tags = make(map[string]string)
+	for i := 0; i < 11; i++ {
+		u.FsConfig.S3Config.UploadRules[0].Tags[fmt.Sprintf("key%d", i)] = "value"
+	}
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "too many tags")
+	}
+	u.FsConfig.S3Config.UploadRules = nil
+	u.FsConfig.S3Config.RequestPayer = "bucketowner"
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "invalid request payer")
+	}
+	u.FsConfig.S3Config.RequestPayer = ""
+	u.FsConfig.S3Config.ExternalID = "ext-id"
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "require a role_arn")
+	}
+	u.FsConfig.S3Config.ExternalID = ""
+	u.FsConfig.ListingMaxEntries = -2
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "invalid listing max entries")
+	}
+	u.FsConfig.ListingMaxEntries = 0
 	u.FsConfig.S3Config.Endpoint = ""
 	u.FsConfig.S3Config.Region = ""
 	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
@@ -4939,6 +6076,46 @@ func TestAddUserInvalidFsConfig(t *testing.T) {
 	u.FsConfig.GCSConfig.Credentials = kms.NewSecret(sdkkms.SecretStatusSecretBox, "invalid", "", "")
 	_, _, err = httpdtest.AddUser(u, http.StatusBadRequest)
 	assert.NoError(t, err)
+	u.FsConfig.GCSConfig.Credentials = kms.NewPlainSecret("fake credentials")
+	u.FsConfig.GCSConfig.KMSKeyName = "projects/p/locations/us/keyRings/r"
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "invalid KMS key name")
+	}
+	u.FsConfig.GCSConfig.KMSKeyName = "projects/p/locations/us/keyRings/r/cryptoKeys/k/cryptoKeyVersions/1"
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "invalid KMS key name")
+	}
+	u.FsConfig.GCSConfig.KMSKeyName = ""
+	u.FsConfig.GCSConfig.ObjectMetadata = map[string]string{
+		"cost center": "value",
+	}
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "invalid key")
+	}
+	u.FsConfig.GCSConfig.ObjectMetadata = map[string]string{
+		"SFTPGo_Last_Modified": "1",
+	}
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "is reserved")
+	}
+	u.FsConfig.GCSConfig.ObjectMetadata = map[string]string{
+		"key": "value\n",
+	}
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "contains invalid characters")
+	}
+	u.FsConfig.GCSConfig.ObjectMetadata = map[string]string{
+		"key": strings.Repeat("a", 8192),
+	}
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "cannot exceed 8 KB")
+	}
 
 	u = getTestUser()
 	u.FsConfig.Provider = sdk.AzureBlobFilesystemProvider
@@ -4966,6 +6143,34 @@ func TestAddUserInvalidFsConfig(t *testing.T) {
 	u.FsConfig.AzBlobConfig.UploadPartSize = 101
 	_, _, err = httpdtest.AddUser(u, http.StatusBadRequest)
 	assert.NoError(t, err)
+	u.FsConfig.AzBlobConfig.UploadPartSize = 0
+	u.FsConfig.AzBlobConfig.Container = "container"
+	u.FsConfig.AzBlobConfig.AccessTiers = []vfs.AzBlobAccessTierOverride{
+		{
+			Pattern:    "*.zip",
+			AccessTier: "Premium",
+		},
+	}
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "invalid access tier")
+	}
+	u.FsConfig.AzBlobConfig.AccessTiers[0].AccessTier = ""
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "invalid access tier")
+	}
+	u.FsConfig.AzBlobConfig.AccessTiers[0].AccessTier = "Cool"
+	u.FsConfig.AzBlobConfig.AccessTiers[0].Pattern = "/"
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "pattern cannot be empty")
+	}
+	u.FsConfig.AzBlobConfig.AccessTiers[0].Pattern = "[a-"
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "invalid pattern")
+	}
 
 	u = getTestUser()
 	u.FsConfig.Provider = sdk.CryptedFilesystemProvider
@@ -4998,6 +6203,42 @@ func TestAddUserInvalidFsConfig(t *testing.T) {
 	if assert.NoError(t, err) {
 		assert.Contains(t, string(resp), "invalid buffer_size")
 	}
+	u.FsConfig.SFTPConfig.BufferSize = 0
+	u.FsConfig.SFTPConfig.PoolSize = 101
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "invalid pool_size")
+	}
+	u.FsConfig.SFTPConfig.PoolSize = 0
+	u.FsConfig.SFTPConfig.KnownHosts = []string{"127.1.1.1 ssh-rsa invalid"}
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "invalid known_hosts entry")
+	}
+	u.FsConfig.SFTPConfig.KnownHosts = []string{"127.1.1.1 " + testPubKey}
+	u.FsConfig.SFTPConfig.KnownHostsFile = "relative/known_hosts"
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "invalid known_hosts_file")
+	}
+	u.FsConfig.SFTPConfig.KnownHostsFile = ""
+	u.FsConfig.SFTPConfig.ProxyURL = "ftp://127.0.0.1:1080"
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "unsupported proxy scheme")
+	}
+	u.FsConfig.SFTPConfig.ProxyURL = ""
+	u.FsConfig.SFTPConfig.ProxyPassword = kms.NewPlainSecret("proxy password")
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "proxy credentials require a proxy URL")
+	}
+	u.FsConfig.SFTPConfig.ProxyPassword = kms.NewEmptySecret()
+	u.FsConfig.SFTPConfig.OutboundBindAddress = "localhost"
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "invalid outbound bind address")
+	}
 
 	u = getTestUser()
 	u.FsConfig.Provider = sdk.HTTPFilesystemProvider
@@ -5034,6 +6275,18 @@ func TestAddUserInvalidFsConfig(t *testing.T) {
 	if assert.NoError(t, err) {
 		assert.Contains(t, string(resp), "invalid unix domain socket path")
 	}
+	u.FsConfig.HTTPConfig.Endpoint = "http://127.0.0.1:9999/api/v1"
+	u.FsConfig.HTTPConfig.RetryMaxAttempts = 11
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "invalid retry_max_attempts")
+	}
+	u.FsConfig.HTTPConfig.RetryMaxAttempts = 3
+	u.FsConfig.HTTPConfig.CircuitBreakerCooldown = 3601
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "invalid circuit_breaker_cooldown")
+	}
 }
 
 func TestUserRedactedPassword(t *testing.T) {
@@ -5301,6 +6554,19 @@ func TestAddUserInvalidVirtualFolders(t *testing.T) {
 	// folder name is mandatory
 	_, _, err = httpdtest.AddUser(u, http.StatusBadRequest)
 	assert.NoError(t, err)
+	u.VirtualFolders = nil
+	u.VirtualFolders = append(u.VirtualFolders, vfs.VirtualFolder{
+		BaseVirtualFolder: vfs.BaseVirtualFolder{
+			MappedPath: filepath.Join(os.TempDir(), "mapped_dir"),
+			Name:       folderName,
+		},
+		VirtualPath: "/vdir1",
+		Permissions: []string{dataprovider.PermListItems, "invalid"},
+	})
+	_, resp, err := httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "invalid permission")
+	}
 }
 
 func TestUserPublicKey(t *testing.T) {
@@ -5796,6 +7062,24 @@ func TestUserS3Config(t *testing.T) {
 	user.FsConfig.S3Config.ForcePathStyle = true
 	user.FsConfig.S3Config.SkipTLSVerify = true
 	user.FsConfig.S3Config.DownloadPartSize = 6
+	user.FsConfig.S3Config.ContentTypes = []vfs.ContentTypeOverride{
+		{
+			Pattern:     "*.log",
+			ContentType: "text/plain; charset=utf-8",
+		},
+	}
+	user.FsConfig.S3Config.UploadRules = []vfs.S3UploadRule{
+		{
+			Pattern:      "*.zip",
+			StorageClass: "STANDARD_IA",
+		},
+		{
+			Pattern: "/raw/",
+			Tags: map[string]string{
+				"lifecycle": "raw",
+			},
+		},
+	}
 	folderName := "vfolderName"
 	user.VirtualFolders = append(user.VirtualFolders, vfs.VirtualFolder{
 		BaseVirtualFolder: vfs.BaseVirtualFolder{
@@ -5828,6 +7112,11 @@ func TestUserS3Config(t *testing.T) {
 	assert.Equal(t, 60, user.FsConfig.S3Config.DownloadPartMaxTime)
 	assert.Equal(t, 40, user.FsConfig.S3Config.UploadPartMaxTime)
 	assert.True(t, user.FsConfig.S3Config.SkipTLSVerify)
+	assert.Len(t, user.FsConfig.S3Config.ContentTypes, 1)
+	if assert.Len(t, user.FsConfig.S3Config.UploadRules, 2) {
+		assert.Equal(t, "raw/", user.FsConfig.S3Config.UploadRules[1].Pattern)
+		assert.Equal(t, "raw", user.FsConfig.S3Config.UploadRules[1].Tags["lifecycle"])
+	}
 	if assert.Len(t, user.VirtualFolders, 1) {
 		folder := user.VirtualFolders[0]
 		assert.Equal(t, sdkkms.SecretStatusSecretBox, folder.FsConfig.CryptConfig.Passphrase.GetStatus())
@@ -5980,8 +7269,24 @@ func TestUserAzureBlobConfig(t *testing.T) {
 	user.FsConfig.AzBlobConfig.Endpoint = "http://127.0.0.1:9000"
 	user.FsConfig.AzBlobConfig.UploadPartSize = 8
 	user.FsConfig.AzBlobConfig.DownloadPartSize = 6
+	user.FsConfig.AzBlobConfig.AccessTier = "Hot"
+	user.FsConfig.AzBlobConfig.AccessTiers = []vfs.AzBlobAccessTierOverride{
+		{
+			Pattern:    "*.zip",
+			AccessTier: "Cool",
+		},
+		{
+			Pattern:    "/archive/",
+			AccessTier: "Archive",
+		},
+	}
 	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
 	assert.NoError(t, err)
+	if assert.Len(t, user.FsConfig.AzBlobConfig.AccessTiers, 2) {
+		assert.Equal(t, "archive/", user.FsConfig.AzBlobConfig.AccessTiers[1].Pattern)
+		assert.Equal(t, "Archive", user.FsConfig.AzBlobConfig.AccessTiers[1].AccessTier)
+	}
+	user.FsConfig.AzBlobConfig.AccessTiers = nil
 	initialPayload := user.FsConfig.AzBlobConfig.AccountKey.GetPayload()
 	assert.Equal(t, sdkkms.SecretStatusSecretBox, user.FsConfig.AzBlobConfig.AccountKey.GetStatus())
 	assert.NotEmpty(t, initialPayload)
@@ -6245,6 +7550,10 @@ func TestUserHiddenFields(t *testing.T) {
 	u2.FsConfig.GCSConfig.ACL = "bucketOwnerRead"
 	u2.FsConfig.GCSConfig.UploadPartSize = 5
 	u2.FsConfig.GCSConfig.UploadPartMaxTime = 20
+	u2.FsConfig.GCSConfig.KMSKeyName = "projects/p/locations/us/keyRings/r/cryptoKeys/k"
+	u2.FsConfig.GCSConfig.ObjectMetadata = map[string]string{
+		"cost-center": "sftp",
+	}
 	user2, _, err := httpdtest.AddUser(u2, http.StatusCreated)
 	assert.NoError(t, err)
 
@@ -6790,8 +8099,11 @@ func TestGetVersion(t *testing.T) {
 }
 
 func TestGetStatus(t *testing.T) {
-	_, _, err := httpdtest.GetStatus(http.StatusOK)
+	status, _, err := httpdtest.GetStatus(http.StatusOK)
 	assert.NoError(t, err)
+	// no maintenance job is enabled in the test configuration
+	assert.NotNil(t, status.MaintenanceJobs)
+	assert.Len(t, status.MaintenanceJobs, 0)
 	_, _, err = httpdtest.GetStatus(http.StatusBadRequest)
 	assert.Error(t, err, "get provider status request must succeed, we requested to check a wrong status code")
 }
@@ -7925,11 +9237,67 @@ func TestFolders(t *testing.T) {
 	_, _, err = httpdtest.UpdateFolder(folder1, http.StatusBadRequest)
 	assert.NoError(t, err)
 	folder1.MappedPath = filepath.Join(os.TempDir(), "updated")
+	folder1.MaxUploadFileSize = -1
+	_, _, err = httpdtest.UpdateFolder(folder1, http.StatusBadRequest)
+	assert.NoError(t, err)
+	folder1.MaxUploadFileSize = 1048576
+	// the record setstat mode is not supported for local folders
+	folder1.SetstatMode = vfs.FolderSetstatModeRecord
+	_, _, err = httpdtest.UpdateFolder(folder1, http.StatusBadRequest)
+	assert.NoError(t, err)
+	folder1.SetstatMode = vfs.FolderSetstatModeDefault
+	folder1.Extraction = vfs.FolderExtraction{
+		Patterns: []string{"/sub/*.zip"},
+	}
+	_, _, err = httpdtest.UpdateFolder(folder1, http.StatusBadRequest)
+	assert.NoError(t, err)
+	folder1.Extraction.Patterns = []string{"*.zip"}
+	folder1.Extraction.MaxRatio = -1
+	_, _, err = httpdtest.UpdateFolder(folder1, http.StatusBadRequest)
+	assert.NoError(t, err)
+	folder1.Extraction = vfs.FolderExtraction{
+		Patterns:      []string{"*.zip", "*.tar.gz"},
+		TargetDir:     "/extracted",
+		MaxEntries:    100,
+		DeleteArchive: true,
+	}
 	folder1.Description = "updated folder description"
 	f, resp, err = httpdtest.UpdateFolder(folder1, http.StatusOK)
 	assert.NoError(t, err, string(resp))
 	assert.Equal(t, folder1.MappedPath, f.MappedPath)
 	assert.Equal(t, folder1.Description, f.Description)
+	assert.Equal(t, int64(1048576), f.MaxUploadFileSize)
+	assert.Equal(t, folder1.Extraction, f.Extraction)
+	folder1.Transform = vfs.FolderTransform{
+		PGPPublicKeys: kms.NewPlainSecret("invalid key"),
+	}
+	_, _, err = httpdtest.UpdateFolder(folder1, http.StatusBadRequest)
+	assert.NoError(t, err)
+	// archives extraction and PGP encryption cannot be combined
+	publicKey := getTestPGPPublicKey(t)
+	folder1.Transform.PGPPublicKeys = kms.NewPlainSecret(publicKey)
+	_, _, err = httpdtest.UpdateFolder(folder1, http.StatusBadRequest)
+	assert.NoError(t, err)
+	folder2.Transform = vfs.FolderTransform{
+		PGPPublicKeys: kms.NewPlainSecret(publicKey),
+		PGPKeepName:   true,
+	}
+	f, resp, err = httpdtest.UpdateFolder(folder2, http.StatusOK)
+	assert.NoError(t, err, string(resp))
+	assert.Equal(t, sdkkms.SecretStatusSecretBox, f.Transform.PGPPublicKeys.GetStatus())
+	assert.NotEmpty(t, f.Transform.PGPPublicKeys.GetPayload())
+	assert.Empty(t, f.Transform.PGPPublicKeys.GetAdditionalData())
+	assert.Empty(t, f.Transform.PGPPublicKeys.GetKey())
+	assert.True(t, f.Transform.PGPKeepName)
+	// the existing keys are preserved if not provided in plain text
+	f.Description = "pgp folder"
+	f, resp, err = httpdtest.UpdateFolder(f, http.StatusOK)
+	assert.NoError(t, err, string(resp))
+	assert.True(t, f.Transform.IsEnabled())
+	folder2.Transform = vfs.FolderTransform{}
+	f, resp, err = httpdtest.UpdateFolder(folder2, http.StatusOK)
+	assert.NoError(t, err, string(resp))
+	assert.False(t, f.Transform.IsEnabled())
 
 	_, err = httpdtest.RemoveFolder(folder1, http.StatusOK)
 	assert.NoError(t, err)
@@ -7937,6 +9305,72 @@ func TestFolders(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func getTestPGPPublicKey(t *testing.T) string {
+	entity, err := openpgp.NewEntity("sftpgo", "", "sftpgo@example.com", &packet.Config{
+		Algorithm: packet.PubKeyAlgoEdDSA,
+	})
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, openpgp.PublicKeyType, nil)
+	require.NoError(t, err)
+	require.NoError(t, entity.Serialize(w))
+	require.NoError(t, w.Close())
+	return buf.String()
+}
+
+func TestFolderBackendCheck(t *testing.T) {
+	mappedPath := filepath.Join(os.TempDir(), util.GenerateUniqueID())
+	folder := vfs.BaseVirtualFolder{
+		Name:       filepath.Base(mappedPath),
+		MappedPath: mappedPath,
+		FsConfig: vfs.Filesystem{
+			Provider: sdk.CryptedFilesystemProvider,
+			CryptConfig: vfs.CryptFsConfig{
+				Passphrase: kms.NewPlainSecret("passphrase"),
+			},
+		},
+	}
+	// the mapped path does not exist
+	result, _, err := httpdtest.CheckFolderBackend(folder, false, 0, http.StatusOK)
+	assert.NoError(t, err)
+	assert.False(t, result.Success)
+	assert.Equal(t, sdk.CryptedFilesystemProvider, result.Provider)
+	assert.NotEmpty(t, result.Error)
+	err = os.MkdirAll(mappedPath, os.ModePerm)
+	assert.NoError(t, err)
+	result, _, err = httpdtest.CheckFolderBackend(folder, true, 10, http.StatusOK)
+	assert.NoError(t, err)
+	assert.True(t, result.Success, result.Error)
+	assert.Len(t, result.Steps, 4)
+	// the unsaved folder must not be saved
+	_, _, err = httpdtest.GetFolderByName(folder.Name, http.StatusNotFound)
+	assert.NoError(t, err)
+	_, _, err = httpdtest.CheckFolderBackend(folder, false, -1, http.StatusBadRequest)
+	assert.NoError(t, err)
+	_, _, err = httpdtest.CheckFolderBackend(folder, false, 3600, http.StatusBadRequest)
+	assert.NoError(t, err)
+	_, _, err = httpdtest.CheckFolderBackend(vfs.BaseVirtualFolder{MappedPath: mappedPath}, false, 0, http.StatusBadRequest)
+	assert.NoError(t, err)
+
+	_, _, err = httpdtest.CheckSavedFolderBackend(folder.Name, false, 0, http.StatusNotFound)
+	assert.NoError(t, err)
+	folder, _, err = httpdtest.AddFolder(folder, http.StatusCreated)
+	assert.NoError(t, err)
+	result, _, err = httpdtest.CheckSavedFolderBackend(folder.Name, true, 0, http.StatusOK)
+	assert.NoError(t, err)
+	assert.True(t, result.Success, result.Error)
+	assert.Len(t, result.Steps, 4)
+	// the probe file must be removed
+	entries, err := os.ReadDir(mappedPath)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 0)
+
+	_, err = httpdtest.RemoveFolder(folder, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(mappedPath)
+	assert.NoError(t, err)
+}
+
 func TestFolderRelations(t *testing.T) {
 	mappedPath := filepath.Join(os.TempDir(), "mapped_path")
 	name := filepath.Base(mappedPath)
@@ -8173,25 +9607,189 @@ func TestDefenderAPI(t *testing.T) {
 		assert.NoError(t, err)
 		assert.Len(t, hosts, 1)
 
-		_, err = httpdtest.RemoveDefenderHostByIP(ip, http.StatusOK)
-		assert.NoError(t, err)
+		_, err = httpdtest.RemoveDefenderHostByIP(ip, http.StatusOK)
+		assert.NoError(t, err)
+
+		host, _, err = httpdtest.GetDefenderHostByIP(ip, http.StatusNotFound)
+		assert.NoError(t, err)
+		_, err = httpdtest.RemoveDefenderHostByIP(ip, http.StatusNotFound)
+		assert.NoError(t, err)
+
+		host, _, err = httpdtest.GetDefenderHostByIP("invalid_ip", http.StatusBadRequest)
+		assert.NoError(t, err)
+		_, err = httpdtest.RemoveDefenderHostByIP("invalid_ip", http.StatusBadRequest)
+		assert.NoError(t, err)
+		if driver == common.DefenderDriverProvider {
+			err = dataprovider.CleanupDefender(util.GetTimeAsMsSinceEpoch(time.Now().Add(1 * time.Hour)))
+			assert.NoError(t, err)
+		}
+	}
+
+	err := common.Initialize(oldConfig, 0)
+	require.NoError(t, err)
+}
+
+func TestDefenderImportExportAPI(t *testing.T) {
+	oldConfig := config.GetCommonConfig()
+
+	token, err := getJWTAPITokenFromTestServer(defaultTokenAuthUser, defaultTokenAuthPass)
+	assert.NoError(t, err)
+	// the defender is disabled
+	req, err := http.NewRequest(http.MethodGet, defenderExport, nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
+
+	cfg := config.GetCommonConfig()
+	cfg.DefenderConfig.Enabled = true
+	cfg.DefenderConfig.Driver = common.DefenderDriverMemory
+	err = common.Initialize(cfg, 0)
+	require.NoError(t, err)
+
+	ip := "172.18.1.1"
+	common.AddDefenderEvent(ip, common.ProtocolHTTP, common.HostEventUserNotFound)
+	req, err = http.NewRequest(http.MethodGet, defenderExport, nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	var data common.DefenderExport
+	err = json.Unmarshal(rr.Body.Bytes(), &data)
+	require.NoError(t, err)
+	require.Len(t, data.Hosts, 1)
+	assert.Equal(t, ip, data.Hosts[0].IP)
+	assert.Len(t, data.SafeList, 0)
+
+	data.Hosts = append(data.Hosts, dataprovider.DefenderEntry{
+		IP:      "172.18.1.2",
+		BanTime: time.Now().Add(10 * time.Minute),
+	})
+	data.SafeList = append(data.SafeList, dataprovider.IPListEntry{
+		IPOrNet: "172.18.2.0/24",
+	})
+	asJSON, err := json.Marshal(data)
+	require.NoError(t, err)
+	req, err = http.NewRequest(http.MethodPost, defenderImport+"?mode=replace", bytes.NewBuffer(asJSON))
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	hosts, _, err := httpdtest.GetDefenderHosts(http.StatusOK)
+	assert.NoError(t, err)
+	assert.Len(t, hosts, 2)
+	assert.True(t, common.IsBanned("172.18.1.2", common.ProtocolSSH))
+	_, _, err = httpdtest.GetIPListEntry("172.18.2.0/24", dataprovider.IPListTypeDefender, http.StatusOK)
+	assert.NoError(t, err)
+
+	req, err = http.NewRequest(http.MethodPost, defenderImport+"?mode=invalid", bytes.NewBuffer(asJSON))
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	req, err = http.NewRequest(http.MethodPost, defenderImport, bytes.NewBuffer([]byte("{")))
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	req, err = http.NewRequest(http.MethodPost, defenderImport, bytes.NewBuffer([]byte(`{"hosts":[{"ip":"invalid","score":1}]}`)))
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	// an admin without the permission to manage the IP lists can only import the hosts
+	admin := getTestAdmin()
+	admin.Username = altAdminUsername
+	admin.Password = altAdminPassword
+	admin.Permissions = []string{dataprovider.PermAdminViewDefender, dataprovider.PermAdminManageDefender}
+	admin, _, err = httpdtest.AddAdmin(admin, http.StatusCreated)
+	require.NoError(t, err)
+	altToken, err := getJWTAPITokenFromTestServer(altAdminUsername, altAdminPassword)
+	require.NoError(t, err)
+	data.SafeList = []dataprovider.IPListEntry{{IPOrNet: "10.0.0.0/8"}}
+	asJSON, err = json.Marshal(data)
+	require.NoError(t, err)
+	req, err = http.NewRequest(http.MethodPost, defenderImport, bytes.NewBuffer(asJSON))
+	assert.NoError(t, err)
+	setBearerForReq(req, altToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
+	_, _, err = httpdtest.GetIPListEntry("10.0.0.0/8", dataprovider.IPListTypeDefender, http.StatusNotFound)
+	assert.NoError(t, err)
+	// in replace mode the existing safe list is preserved
+	data.SafeList = nil
+	asJSON, err = json.Marshal(data)
+	require.NoError(t, err)
+	req, err = http.NewRequest(http.MethodPost, defenderImport+"?mode=replace", bytes.NewBuffer(asJSON))
+	assert.NoError(t, err)
+	setBearerForReq(req, altToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	_, _, err = httpdtest.GetIPListEntry("172.18.2.0/24", dataprovider.IPListTypeDefender, http.StatusOK)
+	assert.NoError(t, err)
+	// hosts in the current safe list are not imported
+	req, err = http.NewRequest(http.MethodPost, defenderImport,
+		bytes.NewBuffer([]byte(`{"hosts":[{"ip":"172.18.2.5","score":1}]}`)))
+	assert.NoError(t, err)
+	setBearerForReq(req, altToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	_, _, err = httpdtest.GetDefenderHostByIP("172.18.2.5", http.StatusNotFound)
+	assert.NoError(t, err)
+	// the safe list is only exported for admins allowed to manage the IP lists
+	req, err = http.NewRequest(http.MethodGet, defenderExport, nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	data = common.DefenderExport{}
+	err = json.Unmarshal(rr.Body.Bytes(), &data)
+	require.NoError(t, err)
+	assert.Len(t, data.SafeList, 1)
+	admin.Permissions = []string{dataprovider.PermAdminViewDefender}
+	admin, _, err = httpdtest.UpdateAdmin(admin, http.StatusOK)
+	require.NoError(t, err)
+	altToken, err = getJWTAPITokenFromTestServer(altAdminUsername, altAdminPassword)
+	require.NoError(t, err)
+	req, err = http.NewRequest(http.MethodGet, defenderExport, nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, altToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.NotContains(t, rr.Body.String(), "safe_list")
+	data = common.DefenderExport{}
+	err = json.Unmarshal(rr.Body.Bytes(), &data)
+	require.NoError(t, err)
+	assert.Len(t, data.Hosts, 2)
+	_, err = httpdtest.RemoveAdmin(admin, http.StatusOK)
+	assert.NoError(t, err)
 
-		host, _, err = httpdtest.GetDefenderHostByIP(ip, http.StatusNotFound)
-		assert.NoError(t, err)
-		_, err = httpdtest.RemoveDefenderHostByIP(ip, http.StatusNotFound)
-		assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodPost, defenderBans,
+		bytes.NewBuffer([]byte(`{"networks":["172.18.3.0/30"],"ban_time":10}`)))
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.True(t, common.IsBanned("172.18.3.3", common.ProtocolSSH))
+	req, err = http.NewRequest(http.MethodPost, defenderBans,
+		bytes.NewBuffer([]byte(`{"networks":["172.18.3.0/30","172.18.3.1"],"ban_time":10}`)))
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	req, err = http.NewRequest(http.MethodPost, defenderBans, bytes.NewBuffer([]byte("[")))
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
 
-		host, _, err = httpdtest.GetDefenderHostByIP("invalid_ip", http.StatusBadRequest)
-		assert.NoError(t, err)
-		_, err = httpdtest.RemoveDefenderHostByIP("invalid_ip", http.StatusBadRequest)
-		assert.NoError(t, err)
-		if driver == common.DefenderDriverProvider {
-			err = dataprovider.CleanupDefender(util.GetTimeAsMsSinceEpoch(time.Now().Add(1 * time.Hour)))
-			assert.NoError(t, err)
-		}
-	}
+	_, err = httpdtest.RemoveIPListEntry(dataprovider.IPListEntry{
+		IPOrNet: "172.18.2.0/24",
+		Type:    dataprovider.IPListTypeDefender,
+	}, http.StatusOK)
+	assert.NoError(t, err)
 
-	err := common.Initialize(oldConfig, 0)
+	err = common.Initialize(oldConfig, 0)
 	require.NoError(t, err)
 }
 
@@ -8229,6 +9827,64 @@ func TestDefenderAPIErrors(t *testing.T) {
 	}
 }
 
+func TestDebugLogOverrides(t *testing.T) {
+	token, err := getJWTAPITokenFromTestServer(defaultTokenAuthUser, defaultTokenAuthPass)
+	assert.NoError(t, err)
+
+	overrides := []common.DebugLogOverride{
+		{
+			Username: "user1",
+		},
+		{
+			IP:        "172.16.1.2",
+			ExpiresAt: util.GetTimeAsMsSinceEpoch(time.Now().Add(1 * time.Hour)),
+		},
+	}
+	asJSON, err := json.Marshal(overrides)
+	assert.NoError(t, err)
+	req, err := http.NewRequest(http.MethodPut, debugLogOverridesPath, bytes.NewBuffer(asJSON))
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+
+	req, err = http.NewRequest(http.MethodGet, debugLogOverridesPath, nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	var result []common.DebugLogOverride
+	err = json.Unmarshal(rr.Body.Bytes(), &result)
+	assert.NoError(t, err)
+	assert.Equal(t, overrides, result)
+	assert.True(t, common.IsDebugLogForced("user1", "10.1.1.1"))
+	assert.True(t, common.IsDebugLogForced("user2", "172.16.1.2"))
+	assert.False(t, common.IsDebugLogForced("user2", "10.1.1.1"))
+
+	overrides = []common.DebugLogOverride{{}}
+	asJSON, err = json.Marshal(overrides)
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodPut, debugLogOverridesPath, bytes.NewBuffer(asJSON))
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+
+	req, err = http.NewRequest(http.MethodPut, debugLogOverridesPath, bytes.NewBuffer([]byte("invalid json")))
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+
+	req, err = http.NewRequest(http.MethodPut, debugLogOverridesPath, bytes.NewBuffer([]byte("[]")))
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Len(t, common.GetDebugLogOverrides(), 0)
+	assert.False(t, common.IsDebugLogForced("user1", "10.1.1.1"))
+}
+
 func TestRestoreShares(t *testing.T) {
 	// shares should be restored preserving the UsedTokens, CreatedAt, LastUseAt, UpdatedAt,
 	// and ExpiresAt, so an expired share can be restored while we cannot create an already
@@ -11528,6 +13184,54 @@ func TestWebUserTOTP(t *testing.T) {
 	checkResponseCode(t, http.StatusNotFound, rr)
 }
 
+func TestWebAPIUserTransferQuotaMock(t *testing.T) {
+	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
+	assert.NoError(t, err)
+	token, err := getJWTAPIUserTokenFromTestServer(defaultUsername, defaultPassword)
+	assert.NoError(t, err)
+	// no transfer quota window defined
+	req, err := http.NewRequest(http.MethodGet, userTransferQuotaPath, nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr)
+
+	user.Filters.TransferQuotaWindow = dataprovider.TransferQuotaWindow{
+		Period: dataprovider.TransferQuotaWindowWeekly,
+		Size:   100,
+	}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodGet, userTransferQuotaPath, nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	var status dataprovider.TransferQuotaWindowStatus
+	err = json.Unmarshal(rr.Body.Bytes(), &status)
+	assert.NoError(t, err)
+	assert.Equal(t, dataprovider.TransferQuotaWindowWeekly, status.Period)
+	assert.Equal(t, int64(100*1048576), status.Size)
+	assert.Equal(t, status.Size, status.Remaining)
+	start := util.GetTimeFromMsecSinceEpoch(status.Start)
+	end := util.GetTimeFromMsecSinceEpoch(status.End)
+	assert.Equal(t, time.Monday, start.UTC().Weekday())
+	assert.Equal(t, 7*24*time.Hour, end.Sub(start))
+	// the grace size is ignored if the budget is not enforced during transfers
+	user.Filters.TransferQuotaWindow.GraceSize = 10
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), user.Filters.TransferQuotaWindow.GraceSize)
+	user.Filters.TransferQuotaWindow.Size = 0
+	_, _, err = httpdtest.UpdateUser(user, http.StatusBadRequest, "")
+	assert.NoError(t, err)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestWebAPIChangeUserProfileMock(t *testing.T) {
 	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
 	assert.NoError(t, err)
@@ -12857,6 +14561,143 @@ func TestStartQuotaScanNonExistentFolderMock(t *testing.T) {
 	checkResponseCode(t, http.StatusNotFound, rr)
 }
 
+func TestReEncryptionMock(t *testing.T) {
+	token, err := getJWTAPITokenFromTestServer(defaultTokenAuthUser, defaultTokenAuthPass)
+	assert.NoError(t, err)
+	mappedPath := filepath.Join(os.TempDir(), "cryptfolder")
+	folder := vfs.BaseVirtualFolder{
+		Name:       filepath.Base(mappedPath),
+		MappedPath: mappedPath,
+		FsConfig: vfs.Filesystem{
+			Provider: sdk.CryptedFilesystemProvider,
+			CryptConfig: vfs.CryptFsConfig{
+				Passphrase: kms.NewPlainSecret("old passphrase"),
+			},
+		},
+	}
+	folder, _, err = httpdtest.AddFolder(folder, http.StatusCreated)
+	assert.NoError(t, err)
+	// no retired passphrases
+	req, _ := http.NewRequest(http.MethodPost, path.Join(reEncryptionPath, "folders", folder.Name), nil)
+	setBearerForReq(req, token)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+
+	err = os.MkdirAll(mappedPath, os.ModePerm)
+	assert.NoError(t, err)
+	oldFs, err := vfs.NewCryptFs("", mappedPath, "", vfs.CryptFsConfig{
+		Passphrase: kms.NewPlainSecret("old passphrase"),
+	})
+	assert.NoError(t, err)
+	content := []byte("encrypted content")
+	_, w, _, err := oldFs.Create(filepath.Join(mappedPath, "file.txt"), 0, 0)
+	assert.NoError(t, err)
+	_, err = w.Write(content)
+	assert.NoError(t, err)
+	err = w.Close()
+	assert.NoError(t, err)
+
+	folder.FsConfig.CryptConfig.Passphrase = kms.NewPlainSecret("new passphrase")
+	folder.FsConfig.CryptConfig.RetiredPassphrases = []*kms.Secret{kms.NewPlainSecret("old passphrase")}
+	folder, _, err = httpdtest.UpdateFolder(folder, http.StatusOK)
+	assert.NoError(t, err)
+	assert.Len(t, folder.FsConfig.CryptConfig.RetiredPassphrases, 1)
+	// the redacted secrets must be preserved
+	folder.FsConfig.CryptConfig.Passphrase.SetStatus(sdkkms.SecretStatusRedacted)
+	folder.FsConfig.CryptConfig.RetiredPassphrases[0].SetStatus(sdkkms.SecretStatusRedacted)
+	folderAsJSON, err := json.Marshal(folder)
+	assert.NoError(t, err)
+	req, _ = http.NewRequest(http.MethodPut, path.Join(folderPath, folder.Name), bytes.NewBuffer(folderAsJSON))
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	folderGet, err := dataprovider.GetFolderByName(folder.Name)
+	assert.NoError(t, err)
+	if assert.Len(t, folderGet.FsConfig.CryptConfig.RetiredPassphrases, 1) {
+		retired := folderGet.FsConfig.CryptConfig.RetiredPassphrases[0]
+		assert.Equal(t, sdkkms.SecretStatusSecretBox, retired.GetStatus())
+		assert.NoError(t, retired.TryDecrypt())
+		assert.Equal(t, "old passphrase", retired.GetPayload())
+	}
+
+	req, _ = http.NewRequest(http.MethodDelete, path.Join(reEncryptionPath, "folders", folder.Name), nil)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr)
+	req, _ = http.NewRequest(http.MethodPost, path.Join(reEncryptionPath, "folders", folder.Name), nil)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusAccepted, rr)
+	assert.Eventually(t, func() bool {
+		for _, status := range common.ReEncryptions.Get() {
+			if status.Target == common.ReEncryptionTargetFolder && status.Name == folder.Name {
+				return status.Status == common.ReEncryptionStatusCompleted
+			}
+		}
+		return false
+	}, 2*time.Second, 50*time.Millisecond)
+
+	var statuses []common.ReEncryptionStatus
+	req, _ = http.NewRequest(http.MethodGet, reEncryptionPath, nil)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	err = render.DecodeJSON(rr.Body, &statuses)
+	assert.NoError(t, err)
+	found := false
+	for _, status := range statuses {
+		if status.Target == common.ReEncryptionTargetFolder && status.Name == folder.Name {
+			found = true
+			assert.Equal(t, 1, status.ProcessedFiles)
+			assert.Equal(t, 1, status.ReEncryptedFiles)
+			assert.Equal(t, int64(len(content)), status.ReEncryptedSize)
+			assert.Equal(t, 0, status.FailedFiles)
+		}
+	}
+	assert.True(t, found)
+	// the file can now be read using the new passphrase only
+	newFs, err := vfs.NewCryptFs("", mappedPath, "", vfs.CryptFsConfig{
+		Passphrase: kms.NewPlainSecret("new passphrase"),
+	})
+	assert.NoError(t, err)
+	_, r, _, err := newFs.Open(filepath.Join(mappedPath, "file.txt"), 0)
+	if assert.NoError(t, err) {
+		data, err := io.ReadAll(r)
+		assert.NoError(t, err)
+		assert.Equal(t, content, data)
+		assert.NoError(t, r.Close())
+	}
+	// a local folder cannot be re-encrypted
+	localFolder, _, err := httpdtest.AddFolder(vfs.BaseVirtualFolder{
+		Name:       "localfolder",
+		MappedPath: filepath.Join(os.TempDir(), "localfolder"),
+	}, http.StatusCreated)
+	assert.NoError(t, err)
+	req, _ = http.NewRequest(http.MethodPost, path.Join(reEncryptionPath, "folders", localFolder.Name), nil)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	req, _ = http.NewRequest(http.MethodPost, path.Join(reEncryptionPath, "folders", "missingfolder"), nil)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr)
+	req, _ = http.NewRequest(http.MethodPost, path.Join(reEncryptionPath, "users", "missinguser"), nil)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr)
+	req, _ = http.NewRequest(http.MethodDelete, path.Join(reEncryptionPath, "users", "missinguser"), nil)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr)
+
+	_, err = httpdtest.RemoveFolder(folder, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveFolder(localFolder, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(mappedPath)
+	assert.NoError(t, err)
+}
+
 func TestGetFoldersMock(t *testing.T) {
 	token, err := getJWTAPITokenFromTestServer(defaultTokenAuthUser, defaultTokenAuthPass)
 	assert.NoError(t, err)
@@ -13163,6 +15004,41 @@ func TestWebAPILoginMock(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestUserLoginBudgetMock(t *testing.T) {
+	u := getTestUser()
+	u.Filters.MaxLogins = 2
+	u.Filters.DeniedProtocols = []string{common.ProtocolWebDAV}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	_, err = getJWTAPIUserTokenFromTestServer(defaultUsername, defaultPassword)
+	assert.NoError(t, err)
+	_, err = getJWTWebClientTokenFromTestServer(defaultUsername, defaultPassword)
+	assert.NoError(t, err)
+
+	req, _ := http.NewRequest(http.MethodGet, userTokenPath, nil)
+	req.SetBasicAuth(defaultUsername, defaultPassword)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
+	assert.Contains(t, rr.Body.String(), dataprovider.ErrLoginBudgetExhausted.Error())
+	_, err = getJWTWebClientTokenFromTestServer(defaultUsername, defaultPassword)
+	assert.Error(t, err)
+
+	user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, user.UsedLogins)
+	if assert.NotNil(t, user.RemainingLogins) {
+		assert.Equal(t, 0, *user.RemainingLogins)
+	}
+	user.Filters.MaxLogins = -1
+	_, _, err = httpdtest.UpdateUser(user, http.StatusBadRequest, "")
+	assert.NoError(t, err)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestWebClientLoginMock(t *testing.T) {
 	_, err := getJWTWebClientTokenFromTestServer(defaultUsername, defaultPassword)
 	assert.Error(t, err)
@@ -13826,6 +15702,7 @@ func TestWebConfigsMock(t *testing.T) {
 	form.Set("sftp_pub_key_algos", ssh.InsecureKeyAlgoDSA) //nolint:staticcheck
 	form.Set("sftp_kex_algos", "diffie-hellman-group18-sha512")
 	form.Add("sftp_kex_algos", ssh.KeyExchangeDH16SHA512)
+	form.Set("sftp_motd", " Maintenance this weekend, {{.Username}}\n")
 	b, contentType, err = getMultipartFormData(form, "", "")
 	assert.NoError(t, err)
 	req, err = http.NewRequest(http.MethodPost, webConfigsPath, &b)
@@ -13844,6 +15721,7 @@ func TestWebConfigsMock(t *testing.T) {
 	assert.Contains(t, configs.SFTPD.PublicKeyAlgos, ssh.InsecureKeyAlgoDSA) //nolint:staticcheck
 	assert.Len(t, configs.SFTPD.KexAlgorithms, 1)
 	assert.Contains(t, configs.SFTPD.KexAlgorithms, ssh.KeyExchangeDH16SHA512)
+	assert.Equal(t, "Maintenance this weekend, {{.Username}}", configs.SFTPD.MOTD)
 	// invalid form action
 	form.Set("form_action", "")
 	b, contentType, err = getMultipartFormData(form, "", "")
@@ -21686,6 +23564,8 @@ func TestWebUserAddMock(t *testing.T) {
 	form.Set("virtual_folders[0][vfolder_name]", folderName)
 	form.Set("virtual_folders[0][vfolder_quota_files]", "2")
 	form.Set("virtual_folders[0][vfolder_quota_size]", "1024")
+	form.Set("virtual_folders[0][vfolder_permissions][]", "list")
+	form.Add("virtual_folders[0][vfolder_permissions][]", "download")
 	form.Set("directory_patterns[0][pattern_path]", "/dir2")
 	form.Set("directory_patterns[0][patterns]", "*.jpg,*.png")
 	form.Set("directory_patterns[0][pattern_type]", "allowed")
@@ -22019,6 +23899,7 @@ func TestWebUserAddMock(t *testing.T) {
 		assert.Equal(t, v.MappedPath, mappedDir)
 		assert.Equal(t, v.QuotaFiles, 2)
 		assert.Equal(t, v.QuotaSize, int64(1024))
+		assert.Equal(t, []string{dataprovider.PermListItems, dataprovider.PermDownload}, v.Permissions)
 	}
 	assert.Len(t, newUser.Filters.FilePatterns, 3)
 	for _, filter := range newUser.Filters.FilePatterns {
@@ -23244,6 +25125,7 @@ func TestWebUserGCSMock(t *testing.T) {
 	user.FsConfig.GCSConfig.ACL = "publicReadWrite"
 	user.FsConfig.GCSConfig.UploadPartSize = 16
 	user.FsConfig.GCSConfig.UploadPartMaxTime = 32
+	user.FsConfig.GCSConfig.KMSKeyName = "projects/p/locations/us/keyRings/r/cryptoKeys/k"
 	form := make(url.Values)
 	form.Set(csrfFormToken, csrfToken)
 	form.Set("username", user.Username)
@@ -23272,6 +25154,7 @@ func TestWebUserGCSMock(t *testing.T) {
 	form.Set("gcs_key_prefix", user.FsConfig.GCSConfig.KeyPrefix)
 	form.Set("gcs_upload_part_size", strconv.FormatInt(user.FsConfig.GCSConfig.UploadPartSize, 10))
 	form.Set("gcs_upload_part_max_time", strconv.FormatInt(int64(user.FsConfig.GCSConfig.UploadPartMaxTime), 10))
+	form.Set("gcs_kms_key_name", user.FsConfig.GCSConfig.KMSKeyName)
 	form.Set("directory_patterns[0][pattern_path]", "/dir1")
 	form.Set("directory_patterns[0][patterns]", "*.jpg,*.png")
 	form.Set("directory_patterns[0][pattern_type]", "allowed")
@@ -23316,6 +25199,7 @@ func TestWebUserGCSMock(t *testing.T) {
 	assert.Equal(t, user.FsConfig.GCSConfig.KeyPrefix, updateUser.FsConfig.GCSConfig.KeyPrefix)
 	assert.Equal(t, user.FsConfig.GCSConfig.UploadPartSize, updateUser.FsConfig.GCSConfig.UploadPartSize)
 	assert.Equal(t, user.FsConfig.GCSConfig.UploadPartMaxTime, updateUser.FsConfig.GCSConfig.UploadPartMaxTime)
+	assert.Equal(t, user.FsConfig.GCSConfig.KMSKeyName, updateUser.FsConfig.GCSConfig.KMSKeyName)
 	if assert.Len(t, updateUser.Filters.FilePatterns, 1) {
 		assert.Equal(t, "/dir1", updateUser.Filters.FilePatterns[0].Path)
 		assert.Len(t, updateUser.Filters.FilePatterns[0].AllowedPatterns, 2)
@@ -23399,6 +25283,14 @@ func TestWebUserHTTPFsMock(t *testing.T) {
 	form.Set("http_password", user.FsConfig.HTTPConfig.Password.GetPayload())
 	form.Set("http_api_key", user.FsConfig.HTTPConfig.APIKey.GetPayload())
 	form.Set("http_skip_tls_verify", "checked")
+	form.Set("http_proxy_url", "socks5h://127.0.0.1:1080")
+	form.Set("http_proxy_username", "proxyuser")
+	form.Set("http_proxy_password", "proxypwd")
+	form.Set("http_retry_max_attempts", "3")
+	form.Set("http_retry_base_delay", "500")
+	form.Set("http_retry_idempotent_writes", "checked")
+	form.Set("http_circuit_breaker_threshold", "5")
+	form.Set("http_circuit_breaker_cooldown", "60")
 	form.Set("directory_patterns[0][pattern_path]", "/dir1")
 	form.Set("directory_patterns[0][patterns]", "*.jpg,*.png")
 	form.Set("directory_patterns[0][pattern_type]", "allowed")
@@ -23439,10 +25331,20 @@ func TestWebUserHTTPFsMock(t *testing.T) {
 	assert.Empty(t, updateUser.FsConfig.HTTPConfig.APIKey.GetKey())
 	assert.Empty(t, updateUser.FsConfig.HTTPConfig.APIKey.GetAdditionalData())
 	assert.Equal(t, 1, updateUser.FsConfig.HTTPConfig.EqualityCheckMode)
+	assert.Equal(t, "socks5h://127.0.0.1:1080", updateUser.FsConfig.HTTPConfig.ProxyURL)
+	assert.Equal(t, "proxyuser", updateUser.FsConfig.HTTPConfig.ProxyUsername)
+	assert.Equal(t, sdkkms.SecretStatusSecretBox, updateUser.FsConfig.HTTPConfig.ProxyPassword.GetStatus())
+	assert.NotEmpty(t, updateUser.FsConfig.HTTPConfig.ProxyPassword.GetPayload())
+	assert.Equal(t, 3, updateUser.FsConfig.HTTPConfig.RetryMaxAttempts)
+	assert.Equal(t, 500, updateUser.FsConfig.HTTPConfig.RetryBaseDelay)
+	assert.True(t, updateUser.FsConfig.HTTPConfig.RetryIdempotentWrites)
+	assert.Equal(t, 5, updateUser.FsConfig.HTTPConfig.CircuitBreakerThreshold)
+	assert.Equal(t, 60, updateUser.FsConfig.HTTPConfig.CircuitBreakerCooldown)
 	// now check that a redacted password is not saved
 	form.Set("http_equality_check_mode", "")
 	form.Set("http_password", " "+redactedSecret+" ")
 	form.Set("http_api_key", redactedSecret)
+	form.Set("http_proxy_password", redactedSecret)
 	b, contentType, _ = getMultipartFormData(form, "", "")
 	req, _ = http.NewRequest(http.MethodPost, path.Join(webUserPath, user.Username), &b)
 	setJWTCookieForReq(req, webToken)
@@ -23465,6 +25367,9 @@ func TestWebUserHTTPFsMock(t *testing.T) {
 	assert.Empty(t, lastUpdatedUser.FsConfig.HTTPConfig.APIKey.GetKey())
 	assert.Empty(t, lastUpdatedUser.FsConfig.HTTPConfig.APIKey.GetAdditionalData())
 	assert.Equal(t, 0, lastUpdatedUser.FsConfig.HTTPConfig.EqualityCheckMode)
+	assert.Equal(t, sdkkms.SecretStatusSecretBox, lastUpdatedUser.FsConfig.HTTPConfig.ProxyPassword.GetStatus())
+	assert.Equal(t, updateUser.FsConfig.HTTPConfig.ProxyPassword.GetPayload(),
+		lastUpdatedUser.FsConfig.HTTPConfig.ProxyPassword.GetPayload())
 
 	req, err = http.NewRequest(http.MethodDelete, path.Join(userPath, user.Username), nil)
 	assert.NoError(t, err)
@@ -23853,6 +25758,10 @@ func TestWebUserSFTPFsMock(t *testing.T) {
 	form.Set("sftp_disable_concurrent_reads", "true")
 	form.Set("sftp_equality_check_mode", "true")
 	form.Set("sftp_buffer_size", strconv.FormatInt(user.FsConfig.SFTPConfig.BufferSize, 10))
+	form.Set("sftp_pool_size", "4")
+	form.Set("sftp_known_hosts", fmt.Sprintf("127.0.0.1 %s\n\n[127.0.0.1]:2022 %s\n", testPubKey, testPubKey1))
+	form.Set("sftp_known_hosts_file", filepath.Join(os.TempDir(), "known_hosts"))
+	form.Set("sftp_proxy_url", vfs.ProxyURLDirect)
 	b, contentType, _ = getMultipartFormData(form, "", "")
 	req, _ = http.NewRequest(http.MethodPost, path.Join(webUserPath, user.Username), &b)
 	setJWTCookieForReq(req, webToken)
@@ -23886,6 +25795,11 @@ func TestWebUserSFTPFsMock(t *testing.T) {
 	assert.True(t, updateUser.FsConfig.SFTPConfig.DisableCouncurrentReads)
 	assert.Len(t, updateUser.FsConfig.SFTPConfig.Fingerprints, 1)
 	assert.Equal(t, user.FsConfig.SFTPConfig.BufferSize, updateUser.FsConfig.SFTPConfig.BufferSize)
+	assert.Equal(t, 4, updateUser.FsConfig.SFTPConfig.PoolSize)
+	assert.Equal(t, []string{"127.0.0.1 " + testPubKey, "[127.0.0.1]:2022 " + testPubKey1},
+		updateUser.FsConfig.SFTPConfig.KnownHosts)
+	assert.Equal(t, filepath.Join(os.TempDir(), "known_hosts"), updateUser.FsConfig.SFTPConfig.KnownHostsFile)
+	assert.Equal(t, vfs.ProxyURLDirect, updateUser.FsConfig.SFTPConfig.ProxyURL)
 	assert.Contains(t, updateUser.FsConfig.SFTPConfig.Fingerprints, sftpPkeyFingerprint)
 	assert.Equal(t, 1, updateUser.FsConfig.SFTPConfig.EqualityCheckMode)
 	// now check that a redacted credentials are not saved
@@ -25645,6 +27559,11 @@ func TestS3WebFolderMock(t *testing.T) {
 	form.Set("s3_download_concurrency", strconv.Itoa(S3DownloadConcurrency))
 	form.Set("s3_upload_part_max_time", strconv.Itoa(S3MaxPartUploadTime))
 	form.Set("s3_upload_concurrency", "a")
+	form.Set("s3_request_payer", "checked")
+	form.Set("s3_use_stored_checksums", "checked")
+	form.Set("s3_external_id", "ext-id")
+	form.Set("s3_role_session_name", "sftpgo")
+	form.Set("fs_listing_max_entries", "1000")
 	form.Set(csrfFormToken, csrfToken)
 	b, contentType, err := getMultipartFormData(form, "", "")
 	assert.NoError(t, err)
@@ -25693,6 +27612,11 @@ func TestS3WebFolderMock(t *testing.T) {
 	assert.Equal(t, int64(S3DownloadPartSize), folder.FsConfig.S3Config.DownloadPartSize)
 	assert.False(t, folder.FsConfig.S3Config.ForcePathStyle)
 	assert.False(t, folder.FsConfig.S3Config.SkipTLSVerify)
+	assert.Equal(t, "requester", folder.FsConfig.S3Config.RequestPayer)
+	assert.True(t, folder.FsConfig.S3Config.UseStoredChecksums)
+	assert.Equal(t, "ext-id", folder.FsConfig.S3Config.ExternalID)
+	assert.Equal(t, "sftpgo", folder.FsConfig.S3Config.RoleSessionName)
+	assert.Equal(t, 1000, folder.FsConfig.ListingMaxEntries)
 	// update
 	S3UploadConcurrency = 10
 	form.Set("s3_upload_concurrency", "b")
@@ -384,6 +384,23 @@ func TestGetRespStatus(t *testing.T) {
 	assert.Equal(t, http.StatusNotImplemented, respStatus)
 }
 
+func TestGetErrorCode(t *testing.T) {
+	assert.Empty(t, getErrorCode(nil))
+	assert.Empty(t, getErrorCode(util.NewValidationError("invalid")))
+	err := util.NewValidationErrorWithCode("invalid", util.ErrorCodeHomeDirInvalid)
+	assert.True(t, errors.Is(err, util.ErrValidation))
+	assert.Equal(t, util.ErrorCodeHomeDirInvalid, getErrorCode(err))
+	// the code is found in wrapped errors
+	assert.Equal(t, util.ErrorCodeHomeDirInvalid, getErrorCode(util.NewI18nError(err, util.I18nErrorHomeInvalid)))
+	assert.Equal(t, util.ErrorCodeHomeDirInvalid, getErrorCode(fmt.Errorf("wrapped: %w", err)))
+	assert.Equal(t, "not_found_code", getErrorCode(util.NewRecordNotFoundErrorWithCode("", "not_found_code")))
+	assert.Equal(t, "disabled_code", getErrorCode(util.NewMethodDisabledErrorWithCode("", "disabled_code")))
+	assert.Equal(t, "generic_code", getErrorCode(util.NewGenericErrorWithCode("", "generic_code")))
+	assert.Equal(t, util.ErrorCodeQuotaExceeded, getErrorCode(common.ErrQuotaExceeded))
+	assert.Equal(t, util.ErrorCodeReadQuotaExceeded, getErrorCode(common.ErrReadQuotaExceeded))
+	assert.Empty(t, getErrorCode(os.ErrNotExist))
+}
+
 func TestMappedStatusCode(t *testing.T) {
 	err := os.ErrPermission
 	code := getMappedStatusCode(err)
@@ -1745,7 +1762,7 @@ func TestCreateTokenError(t *testing.T) {
 	err = dataprovider.AddAdmin(&admin, "", "", "")
 	assert.NoError(t, err)
 
-	err = authenticateAdminWithAPIKey(admin.Username, "", server.tokenAuth, req)
+	err = authenticateAdminWithAPIKey(admin.Username, &dataprovider.APIKey{}, server.tokenAuth, req)
 	assert.Error(t, err)
 
 	err = dataprovider.DeleteAdmin(admin.Username, "", "", "")
@@ -4311,3 +4328,81 @@ func isSharedProviderSupported() bool {
 		return false
 	}
 }
+
+func TestMachineAPIKeyPrefixPerms(t *testing.T) {
+	claims := &jwtTokenClaims{
+		Permissions: []string{dataprovider.APIKeyPermUsersCreate, dataprovider.APIKeyPermFoldersCreate,
+			dataprovider.APIKeyPermFoldersRead, dataprovider.APIKeyPermGroupsUpdate},
+		APIKeyID:       "key",
+		MachineAPIKey:  true,
+		UsernamePrefix: "prov_",
+	}
+	req, err := http.NewRequest(http.MethodPost, folderPath, nil)
+	require.NoError(t, err)
+	// keys stored before the validation of the permissions cannot modify
+	// groups and folders if restricted to a username prefix
+	err = checkMachineAPIKeyPerms(req, claims, []string{dataprovider.PermAdminManageFolders})
+	assert.ErrorContains(t, err, "cannot modify folders")
+	req, err = http.NewRequest(http.MethodPut, groupPath+"/group", nil)
+	require.NoError(t, err)
+	err = checkMachineAPIKeyPerms(req, claims, []string{dataprovider.PermAdminManageGroups})
+	assert.ErrorContains(t, err, "cannot modify groups")
+	req, err = http.NewRequest(http.MethodGet, folderPath, nil)
+	require.NoError(t, err)
+	assert.NoError(t, checkMachineAPIKeyPerms(req, claims, []string{dataprovider.PermAdminManageFolders}))
+	req, err = http.NewRequest(http.MethodPost, userPath, nil)
+	require.NoError(t, err)
+	assert.NoError(t, checkMachineAPIKeyPerms(req, claims, []string{dataprovider.PermAdminAddUsers}))
+	claims.UsernamePrefix = ""
+	req, err = http.NewRequest(http.MethodPost, folderPath, nil)
+	require.NoError(t, err)
+	assert.NoError(t, checkMachineAPIKeyPerms(req, claims, []string{dataprovider.PermAdminManageFolders}))
+}
+
+func TestMachineAPIKeyTokenSignature(t *testing.T) {
+	tokenValidationMode = tokenValidationModeUserSignature
+	defer func() {
+		tokenValidationMode = tokenValidationModeDefault
+	}()
+
+	tokenAuth := jwtauth.New(jwa.HS256.String(), util.GenerateRandomBytes(32), nil)
+	apiKey := dataprovider.APIKey{
+		Name:        "machine key signature",
+		Scope:       dataprovider.APIKeyScopeMachine,
+		Permissions: []string{dataprovider.APIKeyPermUsersRead},
+	}
+	err := dataprovider.AddAPIKey(&apiKey, "", "", "")
+	require.NoError(t, err)
+
+	c := jwtTokenClaims{
+		Username:      apiKey.GetActor(),
+		Permissions:   apiKey.Permissions,
+		APIKeyID:      apiKey.KeyID,
+		MachineAPIKey: true,
+	}
+	token, _, err := c.createToken(tokenAuth, tokenAudienceAPI, "127.0.0.1")
+	require.NoError(t, err)
+	req, err := http.NewRequest(http.MethodGet, userPath, nil)
+	require.NoError(t, err)
+	assert.NoError(t, checkTokenSignature(req, token))
+	// the machine claim is not trusted without a valid API key
+	c.APIKeyID = "missing"
+	token, _, err = c.createToken(tokenAuth, tokenAudienceAPI, "127.0.0.1")
+	require.NoError(t, err)
+	assert.ErrorIs(t, checkTokenSignature(req, token), errInvalidToken)
+	c.APIKeyID = ""
+	token, _, err = c.createToken(tokenAuth, tokenAudienceAPI, "127.0.0.1")
+	require.NoError(t, err)
+	assert.ErrorIs(t, checkTokenSignature(req, token), errInvalidToken)
+
+	apiKey.ExpiresAt = util.GetTimeAsMsSinceEpoch(time.Now().Add(-1 * time.Hour))
+	err = dataprovider.UpdateAPIKey(&apiKey, "", "", "")
+	require.NoError(t, err)
+	c.APIKeyID = apiKey.KeyID
+	token, _, err = c.createToken(tokenAuth, tokenAudienceAPI, "127.0.0.1")
+	require.NoError(t, err)
+	assert.ErrorIs(t, checkTokenSignature(req, token), errInvalidToken)
+
+	err = dataprovider.DeleteAPIKey(apiKey.KeyID, "", "", "")
+	assert.NoError(t, err)
+}
@@ -312,6 +312,16 @@ func (s *httpdServer) checkPerms(perms ...string) func(next http.Handler) http.H
 			tokenClaims := jwtTokenClaims{}
 			tokenClaims.Decode(claims)
 
+			if tokenClaims.MachineAPIKey {
+				if err := checkMachineAPIKeyPerms(r, &tokenClaims, perms); err != nil {
+					logger.Debug(logSender, "", "%v", err)
+					sendAPIResponse(w, r, nil, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
 			for _, perm := range perms {
 				if !tokenClaims.hasPerm(perm) {
 					if isWebRequest(r) {
@@ -421,7 +431,7 @@ func checkAPIKeyAuth(tokenAuth *jwtauth.JWTAuth, scope dataprovider.APIKeyScope)
 				sendAPIResponse(w, r, errors.New("the provided api key is not valid"), "", http.StatusBadRequest)
 				return
 			}
-			if k.Scope != scope {
+			if !isAPIKeyScopeAllowed(k.Scope, scope) {
 				handleDefenderEventLoginFailed(util.GetIPFromRemoteAddress(r.RemoteAddr), dataprovider.ErrInvalidCredentials) //nolint:errcheck
 				logger.Debug(logSender, "", "unable to authenticate api key %q: invalid scope: got %d, wanted: %d",
 					apiKey, k.Scope, scope)
@@ -438,7 +448,7 @@ func checkAPIKeyAuth(tokenAuth *jwtauth.JWTAuth, scope dataprovider.APIKeyScope)
 				if k.Admin != "" {
 					apiUser = k.Admin
 				}
-				if err := authenticateAdminWithAPIKey(apiUser, keyID, tokenAuth, r); err != nil {
+				if err := authenticateAdminWithAPIKey(apiUser, &k, tokenAuth, r); err != nil {
 					handleDefenderEventLoginFailed(util.GetIPFromRemoteAddress(r.RemoteAddr), err) //nolint:errcheck
 					logger.Debug(logSender, "", "unable to authenticate admin %q associated with api key %q: %v",
 						apiUser, apiKey, err)
@@ -490,7 +500,71 @@ func forbidAPIKeyAuthentication(next http.Handler) http.Handler {
 	})
 }
 
-func authenticateAdminWithAPIKey(username, keyID string, tokenAuth *jwtauth.JWTAuth, r *http.Request) error {
+// getMachineAPIKeyPermission returns the permission an API key with machine scope
+// requires to execute a request protected by the specified admin permission.
+// An empty string means that the request is not allowed for machine API keys
+func getMachineAPIKeyPermission(r *http.Request, perm string) string {
+	var resource, basePath string
+	switch perm {
+	case dataprovider.PermAdminAddUsers, dataprovider.PermAdminChangeUsers, dataprovider.PermAdminDeleteUsers,
+		dataprovider.PermAdminViewUsers:
+		resource, basePath = "users", userPath
+	case dataprovider.PermAdminManageFolders:
+		resource, basePath = "folders", folderPath
+	case dataprovider.PermAdminManageGroups:
+		resource, basePath = "groups", groupPath
+	default:
+		return ""
+	}
+	if r.URL.Path != basePath && !strings.HasPrefix(r.URL.Path, basePath+"/") {
+		return ""
+	}
+	switch r.Method {
+	case http.MethodGet:
+		return resource + ":read"
+	case http.MethodPost:
+		return resource + ":create"
+	case http.MethodPut:
+		return resource + ":update"
+	case http.MethodDelete:
+		return resource + ":delete"
+	default:
+		return ""
+	}
+}
+
+func checkMachineAPIKeyPerms(r *http.Request, claims *jwtTokenClaims, perms []string) error {
+	for _, perm := range perms {
+		machinePerm := getMachineAPIKeyPermission(r, perm)
+		if machinePerm == "" || !slices.Contains(claims.Permissions, machinePerm) {
+			return fmt.Errorf("API key %q is not allowed to execute %s %q", claims.APIKeyID, r.Method, r.URL.Path)
+		}
+		// groups and folders are shared between users, API keys restricted to
+		// a username prefix can only read them
+		if resource, _, _ := strings.Cut(machinePerm, ":"); claims.UsernamePrefix != "" && r.Method != http.MethodGet &&
+			resource != "users" {
+			return fmt.Errorf("API key %q is restricted to a username prefix and cannot modify %s",
+				claims.APIKeyID, resource)
+		}
+	}
+	if username := getURLParam(r, "username"); username != "" && !claims.canManageUser(username) {
+		return fmt.Errorf("API key %q is not allowed to manage user %q", claims.APIKeyID, username)
+	}
+	return nil
+}
+
+// API keys with machine scope can be used for the admin REST API
+func isAPIKeyScopeAllowed(keyScope, scope dataprovider.APIKeyScope) bool {
+	if keyScope == scope {
+		return true
+	}
+	return keyScope == dataprovider.APIKeyScopeMachine && scope == dataprovider.APIKeyScopeAdmin
+}
+
+func authenticateAdminWithAPIKey(username string, k *dataprovider.APIKey, tokenAuth *jwtauth.JWTAuth, r *http.Request) error {
+	if k.Scope == dataprovider.APIKeyScopeMachine {
+		return authenticateMachineWithAPIKey(k, tokenAuth, r)
+	}
 	if username == "" {
 		return errors.New("the provided key is not associated with any admin and no username was provided")
 	}
@@ -510,7 +584,7 @@ func authenticateAdminWithAPIKey(username, keyID string, tokenAuth *jwtauth.JWTA
 		Permissions: admin.Permissions,
 		Signature:   admin.GetSignature(),
 		Role:        admin.Role,
-		APIKeyID:    keyID,
+		APIKeyID:    k.KeyID,
 	}
 
 	resp, err := c.createTokenResponse(tokenAuth, tokenAudienceAPI, ipAddr)
@@ -523,6 +597,23 @@ func authenticateAdminWithAPIKey(username, keyID string, tokenAuth *jwtauth.JWTA
 	return nil
 }
 
+func authenticateMachineWithAPIKey(k *dataprovider.APIKey, tokenAuth *jwtauth.JWTAuth, r *http.Request) error {
+	c := jwtTokenClaims{
+		Username:       k.GetActor(),
+		Permissions:    k.Permissions,
+		APIKeyID:       k.KeyID,
+		MachineAPIKey:  true,
+		UsernamePrefix: k.UsernamePrefix,
+	}
+
+	resp, err := c.createTokenResponse(tokenAuth, tokenAudienceAPI, util.GetIPFromRemoteAddress(r.RemoteAddr))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Authorization", fmt.Sprintf("Bearer %v", resp["access_token"]))
+	return nil
+}
+
 func authenticateUserWithAPIKey(username, keyID string, tokenAuth *jwtauth.JWTAuth, r *http.Request) error {
 	ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
 	protocol := common.ProtocolHTTP
@@ -561,6 +652,10 @@ func authenticateUserWithAPIKey(username, keyID string, tokenAuth *jwtauth.JWTAu
 		updateLoginMetrics(&user, dataprovider.LoginMethodPassword, ipAddr, common.ErrInternalFailure, r)
 		return common.ErrInternalFailure
 	}
+	if err := consumeUserLogin(&user, ipAddr, protocol); err != nil {
+		updateLoginMetrics(&user, dataprovider.LoginMethodPassword, ipAddr, err, r)
+		return err
+	}
 	c := jwtTokenClaims{
 		Username:    user.Username,
 		Permissions: user.Filters.WebClient,
@@ -471,6 +471,10 @@ func (t *oidcToken) getUser(r *http.Request) error {
 		updateLoginMetrics(user, dataprovider.LoginMethodIDP, ipAddr, common.ErrInternalFailure, r)
 		return err
 	}
+	if err := consumeUserLogin(user, ipAddr, common.ProtocolOIDC); err != nil {
+		updateLoginMetrics(user, dataprovider.LoginMethodIDP, ipAddr, err, r)
+		return err
+	}
 	updateLoginMetrics(user, dataprovider.LoginMethodIDP, ipAddr, nil, r)
 	dataprovider.UpdateLastLogin(user)
 	t.Permissions = user.Filters.WebClient
@@ -44,7 +44,6 @@ import (
 	"github.com/drakkan/sftpgo/v2/internal/common"
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
-	"github.com/drakkan/sftpgo/v2/internal/mfa"
 	"github.com/drakkan/sftpgo/v2/internal/smtp"
 	"github.com/drakkan/sftpgo/v2/internal/util"
 	"github.com/drakkan/sftpgo/v2/internal/version"
@@ -364,6 +363,11 @@ func (s *httpdServer) handleWebClientTwoFactorRecoveryPost(w http.ResponseWriter
 			util.NewValidationError("two factory authentication is not enabled"), util.I18n2FADisabled))
 		return
 	}
+	if err := dataprovider.CheckSecondFactorAttempts(user.Username, false); err != nil {
+		handleDefenderEventLoginFailed(ipAddr, err) //nolint:errcheck
+		s.renderClientTwoFactorRecoveryPage(w, r, util.NewI18nError(err, util.I18nError2FATooManyAttempts))
+		return
+	}
 	for idx, code := range user.Filters.RecoveryCodes {
 		if err := code.Secret.Decrypt(); err != nil {
 			s.renderClientInternalServerErrorPage(w, r, fmt.Errorf("unable to decrypt recovery code: %w", err))
@@ -371,10 +375,9 @@ func (s *httpdServer) handleWebClientTwoFactorRecoveryPost(w http.ResponseWriter
 		}
 		if code.Secret.GetPayload() == recoveryCode {
 			if code.Used {
-				s.renderClientTwoFactorRecoveryPage(w, r,
-					util.NewI18nError(dataprovider.ErrInvalidCredentials, util.I18nErrorInvalidCredentials))
-				return
+				break
 			}
+			dataprovider.UpdateSecondFactorAttempts(user.Username, false, true) //nolint:errcheck
 			user.Filters.RecoveryCodes[idx].Used = true
 			err = dataprovider.UpdateUser(&user, dataprovider.ActionExecutorSelf, ipAddr, user.Role)
 			if err != nil {
@@ -388,6 +391,11 @@ func (s *httpdServer) handleWebClientTwoFactorRecoveryPost(w http.ResponseWriter
 			return
 		}
 	}
+	if err := dataprovider.UpdateSecondFactorAttempts(user.Username, false, false); err != nil {
+		handleDefenderEventLoginFailed(ipAddr, err) //nolint:errcheck
+		s.renderClientTwoFactorRecoveryPage(w, r, util.NewI18nError(err, util.I18nError2FATooManyAttempts))
+		return
+	}
 	handleDefenderEventLoginFailed(ipAddr, dataprovider.ErrInvalidCredentials) //nolint:errcheck
 	s.renderClientTwoFactorRecoveryPage(w, r,
 		util.NewI18nError(dataprovider.ErrInvalidCredentials, util.I18nErrorInvalidCredentials))
@@ -438,9 +446,14 @@ func (s *httpdServer) handleWebClientTwoFactorPost(w http.ResponseWriter, r *htt
 		s.renderClientInternalServerErrorPage(w, r, err)
 		return
 	}
-	match, err := mfa.ValidateTOTPPasscode(user.Filters.TOTPConfig.ConfigName, passcode,
+	match, err := dataprovider.ValidateTOTPPasscode(user.Username, false, user.Filters.TOTPConfig.ConfigName, passcode,
 		user.Filters.TOTPConfig.Secret.GetPayload())
 	if !match || err != nil {
+		if errors.Is(err, dataprovider.ErrTooManySecondFactorAttempts) {
+			updateLoginMetrics(&user, dataprovider.LoginMethodPassword, ipAddr, err, r)
+			s.renderClientTwoFactorPage(w, r, util.NewI18nError(err, util.I18nError2FATooManyAttempts))
+			return
+		}
 		updateLoginMetrics(&user, dataprovider.LoginMethodPassword, ipAddr, dataprovider.ErrInvalidCredentials, r)
 		s.renderClientTwoFactorPage(w, r,
 			util.NewI18nError(dataprovider.ErrInvalidCredentials, util.I18nErrorInvalidCredentials))
@@ -485,6 +498,11 @@ func (s *httpdServer) handleWebAdminTwoFactorRecoveryPost(w http.ResponseWriter,
 		s.renderTwoFactorRecoveryPage(w, r, util.NewI18nError(util.NewValidationError("two factory authentication is not enabled"), util.I18n2FADisabled))
 		return
 	}
+	if err := dataprovider.CheckSecondFactorAttempts(admin.Username, true); err != nil {
+		handleDefenderEventLoginFailed(ipAddr, err) //nolint:errcheck
+		s.renderTwoFactorRecoveryPage(w, r, util.NewI18nError(err, util.I18nError2FATooManyAttempts))
+		return
+	}
 	for idx, code := range admin.Filters.RecoveryCodes {
 		if err := code.Secret.Decrypt(); err != nil {
 			s.renderInternalServerErrorPage(w, r, fmt.Errorf("unable to decrypt recovery code: %w", err))
@@ -492,10 +510,9 @@ func (s *httpdServer) handleWebAdminTwoFactorRecoveryPost(w http.ResponseWriter,
 		}
 		if code.Secret.GetPayload() == recoveryCode {
 			if code.Used {
-				s.renderTwoFactorRecoveryPage(w, r,
-					util.NewI18nError(dataprovider.ErrInvalidCredentials, util.I18nErrorInvalidCredentials))
-				return
+				break
 			}
+			dataprovider.UpdateSecondFactorAttempts(admin.Username, true, true) //nolint:errcheck
 			admin.Filters.RecoveryCodes[idx].Used = true
 			err = dataprovider.UpdateAdmin(&admin, dataprovider.ActionExecutorSelf, ipAddr, admin.Role)
 			if err != nil {
@@ -507,6 +524,11 @@ func (s *httpdServer) handleWebAdminTwoFactorRecoveryPost(w http.ResponseWriter,
 			return
 		}
 	}
+	if err := dataprovider.UpdateSecondFactorAttempts(admin.Username, true, false); err != nil {
+		handleDefenderEventLoginFailed(ipAddr, err) //nolint:errcheck
+		s.renderTwoFactorRecoveryPage(w, r, util.NewI18nError(err, util.I18nError2FATooManyAttempts))
+		return
+	}
 	handleDefenderEventLoginFailed(ipAddr, dataprovider.ErrInvalidCredentials) //nolint:errcheck
 	s.renderTwoFactorRecoveryPage(w, r, util.NewI18nError(dataprovider.ErrInvalidCredentials, util.I18nErrorInvalidCredentials))
 }
@@ -551,9 +573,14 @@ func (s *httpdServer) handleWebAdminTwoFactorPost(w http.ResponseWriter, r *http
 		s.renderInternalServerErrorPage(w, r, err)
 		return
 	}
-	match, err := mfa.ValidateTOTPPasscode(admin.Filters.TOTPConfig.ConfigName, passcode,
+	match, err := dataprovider.ValidateTOTPPasscode(admin.Username, true, admin.Filters.TOTPConfig.ConfigName, passcode,
 		admin.Filters.TOTPConfig.Secret.GetPayload())
 	if !match || err != nil {
+		if errors.Is(err, dataprovider.ErrTooManySecondFactorAttempts) {
+			handleDefenderEventLoginFailed(ipAddr, err) //nolint:errcheck
+			s.renderTwoFactorPage(w, r, util.NewI18nError(err, util.I18nError2FATooManyAttempts))
+			return
+		}
 		handleDefenderEventLoginFailed(ipAddr, dataprovider.ErrInvalidCredentials) //nolint:errcheck
 		s.renderTwoFactorPage(w, r, util.NewI18nError(dataprovider.ErrInvalidCredentials, util.I18nErrorInvalidCredentials))
 		return
@@ -752,6 +779,13 @@ func (s *httpdServer) loginUser(
 		user.CanManageMFA() && !isSecondFactorAuth {
 		audience = tokenAudienceWebClientPartial
 	}
+	if audience == tokenAudienceWebClient {
+		if err := consumeUserLogin(user, ipAddr, common.ProtocolHTTP); err != nil {
+			updateLoginMetrics(user, dataprovider.LoginMethodPassword, ipAddr, err, r)
+			errorFunc(w, r, util.NewI18nError(err, util.I18nError500Message))
+			return
+		}
+	}
 
 	err := c.createAndSetCookie(w, r, s.tokenAuth, audience, ipAddr)
 	if err != nil {
@@ -884,10 +918,15 @@ func (s *httpdServer) getUserToken(w http.ResponseWriter, r *http.Request) {
 			sendAPIResponse(w, r, fmt.Errorf("unable to decrypt TOTP secret: %w", err), http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			return
 		}
-		match, err := mfa.ValidateTOTPPasscode(user.Filters.TOTPConfig.ConfigName, passcode,
+		match, err := dataprovider.ValidateTOTPPasscode(user.Username, false, user.Filters.TOTPConfig.ConfigName, passcode,
 			user.Filters.TOTPConfig.Secret.GetPayload())
 		if !match || err != nil {
 			logger.Debug(logSender, "invalid passcode for user %q, match? %v, err: %v", user.Username, match, err)
+			if errors.Is(err, dataprovider.ErrTooManySecondFactorAttempts) {
+				updateLoginMetrics(&user, dataprovider.LoginMethodPassword, ipAddr, err, r)
+				sendAPIResponse(w, r, err, "", http.StatusTooManyRequests)
+				return
+			}
 			w.Header().Set(common.HTTPAuthenticationHeader, basicRealm)
 			updateLoginMetrics(&user, dataprovider.LoginMethodPassword, ipAddr, dataprovider.ErrInvalidCredentials, r)
 			sendAPIResponse(w, r, dataprovider.ErrInvalidCredentials, http.StatusText(http.StatusUnauthorized),
@@ -909,6 +948,11 @@ func (s *httpdServer) getUserToken(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *httpdServer) generateAndSendUserToken(w http.ResponseWriter, r *http.Request, ipAddr string, user dataprovider.User) {
+	if err := consumeUserLogin(&user, ipAddr, common.ProtocolHTTP); err != nil {
+		updateLoginMetrics(&user, dataprovider.LoginMethodPassword, ipAddr, err, r)
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
 	c := jwtTokenClaims{
 		Username:                   user.Username,
 		Permissions:                user.Filters.WebClient,
@@ -962,10 +1006,15 @@ func (s *httpdServer) getToken(w http.ResponseWriter, r *http.Request) {
 				http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			return
 		}
-		match, err := mfa.ValidateTOTPPasscode(admin.Filters.TOTPConfig.ConfigName, passcode,
+		match, err := dataprovider.ValidateTOTPPasscode(admin.Username, true, admin.Filters.TOTPConfig.ConfigName, passcode,
 			admin.Filters.TOTPConfig.Secret.GetPayload())
 		if !match || err != nil {
 			logger.Debug(logSender, "invalid passcode for admin %q, match? %v, err: %v", admin.Username, match, err)
+			if errors.Is(err, dataprovider.ErrTooManySecondFactorAttempts) {
+				handleDefenderEventLoginFailed(ipAddr, err) //nolint:errcheck
+				sendAPIResponse(w, r, err, "", http.StatusTooManyRequests)
+				return
+			}
 			w.Header().Set(common.HTTPAuthenticationHeader, basicRealm)
 			err = handleDefenderEventLoginFailed(ipAddr, dataprovider.ErrInvalidCredentials)
 			sendAPIResponse(w, r, err, http.StatusText(http.StatusUnauthorized),
@@ -1395,15 +1444,22 @@ func (s *httpdServer) setupRESTAPIRoutes() {
 				router.With(s.checkPerms(dataprovider.PermAdminQuotaScans)).Post(quotasBasePath+"/users/{username}/scan", startUserQuotaScan)
 				router.With(s.checkPerms(dataprovider.PermAdminQuotaScans)).Get(quotasBasePath+"/folders/scans", getFoldersQuotaScans)
 				router.With(s.checkPerms(dataprovider.PermAdminQuotaScans)).Post(quotasBasePath+"/folders/{name}/scan", startFolderQuotaScan)
+				router.With(s.checkPerms(dataprovider.PermAdminViewUsers)).
+					Get(quotasBasePath+"/users/{username}/transfer-window", getUserTransferQuotaWindow)
 				router.With(s.checkPerms(dataprovider.PermAdminViewUsers)).Get(userPath, getUsers)
 				router.With(s.checkPerms(dataprovider.PermAdminAddUsers)).Post(userPath, addUser)
 				router.With(s.checkPerms(dataprovider.PermAdminViewUsers)).Get(userPath+"/{username}", getUserByUsername) //nolint:goconst
+				router.With(s.checkPerms(dataprovider.PermAdminViewUsers)).Get(userPath+"/{username}/effective", getEffectiveUserSettings)
+				router.With(s.checkPerms(dataprovider.PermAdminViewUsers)).Get(userPath+"/{username}/diagnostics", getUserDiagnostic)
 				router.With(s.checkPerms(dataprovider.PermAdminChangeUsers)).Put(userPath+"/{username}", updateUser)
 				router.With(s.checkPerms(dataprovider.PermAdminDeleteUsers)).Delete(userPath+"/{username}", deleteUser)
 				router.With(s.checkPerms(dataprovider.PermAdminDisableMFA)).Put(userPath+"/{username}/2fa/disable", disableUser2FA) //nolint:goconst
+				router.With(s.checkPerms(dataprovider.PermAdminChangeUsers)).Put(userPath+"/{username}/unlock", unlockUser)
 				router.With(s.checkPerms(dataprovider.PermAdminManageFolders)).Get(folderPath, getFolders)
 				router.With(s.checkPerms(dataprovider.PermAdminManageFolders)).Get(folderPath+"/{name}", getFolderByName) //nolint:goconst
 				router.With(s.checkPerms(dataprovider.PermAdminManageFolders)).Post(folderPath, addFolder)
+				router.With(s.checkPerms(dataprovider.PermAdminManageFolders)).Post(folderPath+"/check", checkFolderBackend)
+				router.With(s.checkPerms(dataprovider.PermAdminManageFolders)).Post(folderPath+"/{name}/check", checkSavedFolderBackend)
 				router.With(s.checkPerms(dataprovider.PermAdminManageFolders)).Put(folderPath+"/{name}", updateFolder)
 				router.With(s.checkPerms(dataprovider.PermAdminManageFolders)).Delete(folderPath+"/{name}", deleteFolder)
 				router.With(s.checkPerms(dataprovider.PermAdminManageGroups)).Get(groupPath, getGroups)
@@ -1414,6 +1470,18 @@ func (s *httpdServer) setupRESTAPIRoutes() {
 				router.With(s.checkPerms(dataprovider.PermAdminAny)).Get(dumpDataPath, dumpData)
 				router.With(s.checkPerms(dataprovider.PermAdminAny)).Get(loadDataPath, loadData)
 				router.With(s.checkPerms(dataprovider.PermAdminAny)).Post(loadDataPath, loadDataFromRequest)
+				router.With(s.checkPerms(dataprovider.PermAdminAny)).Get(archivesPath, getArchives)
+				router.With(s.checkPerms(dataprovider.PermAdminAny)).Post(archivesPath, createArchive)
+				router.With(s.checkPerms(dataprovider.PermAdminAny)).Post(archivesPath+"/{name}/restore", restoreArchive)
+				router.With(s.checkPerms(dataprovider.PermAdminAny)).Get(reEncryptionPath, getReEncryptions)
+				router.With(s.checkPerms(dataprovider.PermAdminAny)).Post(reEncryptionPath+"/users/{username}",
+					startUserReEncryption)
+				router.With(s.checkPerms(dataprovider.PermAdminAny)).Delete(reEncryptionPath+"/users/{username}",
+					stopUserReEncryption)
+				router.With(s.checkPerms(dataprovider.PermAdminAny)).Post(reEncryptionPath+"/folders/{name}",
+					startFolderReEncryption)
+				router.With(s.checkPerms(dataprovider.PermAdminAny)).Delete(reEncryptionPath+"/folders/{name}",
+					stopFolderReEncryption)
 				router.With(s.checkPerms(dataprovider.PermAdminChangeUsers)).Put(quotasBasePath+"/users/{username}/usage",
 					updateUserQuotaUsage)
 				router.With(s.checkPerms(dataprovider.PermAdminChangeUsers)).Put(quotasBasePath+"/users/{username}/transfer-usage",
@@ -1423,6 +1491,9 @@ func (s *httpdServer) setupRESTAPIRoutes() {
 				router.With(s.checkPerms(dataprovider.PermAdminViewDefender)).Get(defenderHosts, getDefenderHosts)
 				router.With(s.checkPerms(dataprovider.PermAdminViewDefender)).Get(defenderHosts+"/{id}", getDefenderHostByID)
 				router.With(s.checkPerms(dataprovider.PermAdminManageDefender)).Delete(defenderHosts+"/{id}", deleteDefenderHostByID)
+				router.With(s.checkPerms(dataprovider.PermAdminViewDefender)).Get(defenderExport, exportDefender)
+				router.With(s.checkPerms(dataprovider.PermAdminManageDefender)).Post(defenderImport, importDefender)
+				router.With(s.checkPerms(dataprovider.PermAdminManageDefender)).Post(defenderBans, banDefenderNetworks)
 				router.With(s.checkPerms(dataprovider.PermAdminAny)).Get(adminPath, getAdmins)
 				router.With(s.checkPerms(dataprovider.PermAdminAny)).Post(adminPath, addAdmin)
 				router.With(s.checkPerms(dataprovider.PermAdminAny)).Get(adminPath+"/{username}", getAdminByUsername)
@@ -1454,11 +1525,21 @@ func (s *httpdServer) setupRESTAPIRoutes() {
 				router.With(s.checkPerms(dataprovider.PermAdminAny)).Get(rolesPath+"/{name}", getRoleByName)
 				router.With(s.checkPerms(dataprovider.PermAdminAny)).Put(rolesPath+"/{name}", updateRole)
 				router.With(s.checkPerms(dataprovider.PermAdminAny)).Delete(rolesPath+"/{name}", deleteRole)
+				router.With(s.checkPerms(dataprovider.PermAdminAny)).Get(userTemplatesPath, getUserTemplates)
+				router.With(s.checkPerms(dataprovider.PermAdminAny)).Post(userTemplatesPath, addUserTemplate)
+				router.With(s.checkPerms(dataprovider.PermAdminAny)).Get(userTemplatesPath+"/{name}", getUserTemplateByName)
+				router.With(s.checkPerms(dataprovider.PermAdminAny)).Put(userTemplatesPath+"/{name}", updateUserTemplate)
+				router.With(s.checkPerms(dataprovider.PermAdminAny)).Delete(userTemplatesPath+"/{name}", deleteUserTemplate)
+				router.With(s.checkPerms(dataprovider.PermAdminAddUsers)).Post(userTemplatesPath+"/{name}/users",
+					addUserFromTemplate)
+				router.With(s.checkPerms(dataprovider.PermAdminAny), compressor.Handler).Get(objectChangesPath, getObjectChanges)
 				router.With(s.checkPerms(dataprovider.PermAdminAny), compressor.Handler).Get(ipListsPath+"/{type}", getIPListEntries) //nolint:goconst
 				router.With(s.checkPerms(dataprovider.PermAdminAny)).Post(ipListsPath+"/{type}", addIPListEntry)
 				router.With(s.checkPerms(dataprovider.PermAdminAny)).Get(ipListsPath+"/{type}/{ipornet}", getIPListEntry) //nolint:goconst
 				router.With(s.checkPerms(dataprovider.PermAdminAny)).Put(ipListsPath+"/{type}/{ipornet}", updateIPListEntry)
 				router.With(s.checkPerms(dataprovider.PermAdminAny)).Delete(ipListsPath+"/{type}/{ipornet}", deleteIPListEntry)
+				router.With(s.checkPerms(dataprovider.PermAdminAny)).Get(debugLogOverridesPath, getDebugLogOverrides)
+				router.With(s.checkPerms(dataprovider.PermAdminAny)).Put(debugLogOverridesPath, updateDebugLogOverrides)
 			})
 		})
 
@@ -1487,6 +1568,7 @@ func (s *httpdServer) setupRESTAPIRoutes() {
 				Put(userPwdPath, changeUserPassword)
 			router.With(forbidAPIKeyAuthentication).Get(userProfilePath, getUserProfile)
 			router.With(forbidAPIKeyAuthentication, s.checkAuthRequirements).Put(userProfilePath, updateUserProfile)
+			router.With(s.checkAuthRequirements).Get(userTransferQuotaPath, getUserTransferQuota)
 			// user TOTP APIs
 			router.With(forbidAPIKeyAuthentication, s.checkHTTPUserPerm(sdk.WebClientMFADisabled)).
 				Get(userTOTPConfigsPath, getTOTPConfigs)
@@ -43,6 +43,7 @@ import (
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/mfa"
 	"github.com/drakkan/sftpgo/v2/internal/plugin"
+	"github.com/drakkan/sftpgo/v2/internal/sftpd"
 	"github.com/drakkan/sftpgo/v2/internal/smtp"
 	"github.com/drakkan/sftpgo/v2/internal/util"
 	"github.com/drakkan/sftpgo/v2/internal/vfs"
@@ -1253,6 +1254,7 @@ func getVirtualFoldersFromPostFields(r *http.Request) []vfs.VirtualFolder {
 				VirtualPath: p,
 				QuotaFiles:  -1,
 				QuotaSize:   -1,
+				Permissions: r.Form["vfolder_permissions"+strconv.Itoa(idx)],
 			}
 			if len(folderQuotaSizes) > idx {
 				quotaSize, err := util.ParseBytes(folderQuotaSizes[idx])
@@ -1535,6 +1537,19 @@ func getSecretFromFormField(r *http.Request, field string) *kms.Secret {
 	return secret
 }
 
+// updateContentTypeOverrides preserves the content type overrides, the GCS
+// object metadata, the Azure access tier overrides and the retired crypt
+// passphrases, they cannot be edited using the web admin
+func updateFsConfigNonFormFields(fsConfig *vfs.Filesystem, currentFsConfig *vfs.Filesystem) {
+	fsConfig.S3Config.ContentTypes = currentFsConfig.S3Config.ContentTypes
+	fsConfig.S3Config.UploadRules = currentFsConfig.S3Config.UploadRules
+	fsConfig.GCSConfig.ContentTypes = currentFsConfig.GCSConfig.ContentTypes
+	fsConfig.GCSConfig.ObjectMetadata = currentFsConfig.GCSConfig.ObjectMetadata
+	fsConfig.AzBlobConfig.ContentTypes = currentFsConfig.AzBlobConfig.ContentTypes
+	fsConfig.AzBlobConfig.AccessTiers = currentFsConfig.AzBlobConfig.AccessTiers
+	fsConfig.CryptConfig.RetiredPassphrases = currentFsConfig.CryptConfig.RetiredPassphrases
+}
+
 func getS3Config(r *http.Request) (vfs.S3FsConfig, error) {
 	var err error
 	config := vfs.S3FsConfig{}
@@ -1542,6 +1557,8 @@ func getS3Config(r *http.Request) (vfs.S3FsConfig, error) {
 	config.Region = strings.TrimSpace(r.Form.Get("s3_region"))
 	config.AccessKey = strings.TrimSpace(r.Form.Get("s3_access_key"))
 	config.RoleARN = strings.TrimSpace(r.Form.Get("s3_role_arn"))
+	config.ExternalID = strings.TrimSpace(r.Form.Get("s3_external_id"))
+	config.RoleSessionName = strings.TrimSpace(r.Form.Get("s3_role_session_name"))
 	config.AccessSecret = getSecretFromFormField(r, "s3_access_secret")
 	config.SSECustomerKey = getSecretFromFormField(r, "s3_sse_customer_key")
 	config.Endpoint = strings.TrimSpace(r.Form.Get("s3_endpoint"))
@@ -1566,6 +1583,11 @@ func getS3Config(r *http.Request) (vfs.S3FsConfig, error) {
 	}
 	config.ForcePathStyle = r.Form.Get("s3_force_path_style") != ""
 	config.SkipTLSVerify = r.Form.Get("s3_skip_tls_verify") != ""
+	if r.Form.Get("s3_request_payer") != "" {
+		config.RequestPayer = "requester"
+	}
+	config.UseStoredChecksums = r.Form.Get("s3_use_stored_checksums") != ""
+	config.OutboundBindAddress = strings.TrimSpace(r.Form.Get("s3_outbound_bind_address"))
 	config.DownloadPartMaxTime, err = strconv.Atoi(r.Form.Get("s3_download_part_max_time"))
 	if err != nil {
 		return config, fmt.Errorf("invalid s3 download part max time: %w", err)
@@ -1584,6 +1606,8 @@ func getGCSConfig(r *http.Request) (vfs.GCSFsConfig, error) {
 	config.Bucket = strings.TrimSpace(r.Form.Get("gcs_bucket"))
 	config.StorageClass = strings.TrimSpace(r.Form.Get("gcs_storage_class"))
 	config.ACL = strings.TrimSpace(r.Form.Get("gcs_acl"))
+	config.KMSKeyName = strings.TrimSpace(r.Form.Get("gcs_kms_key_name"))
+	config.OutboundBindAddress = strings.TrimSpace(r.Form.Get("gcs_outbound_bind_address"))
 	config.KeyPrefix = strings.TrimSpace(strings.TrimPrefix(r.Form.Get("gcs_key_prefix"), "/"))
 	uploadPartSize, err := strconv.ParseInt(r.Form.Get("gcs_upload_part_size"), 10, 64)
 	if err == nil {
@@ -1629,9 +1653,18 @@ func getSFTPConfig(r *http.Request) (vfs.SFTPFsConfig, error) {
 	config.KeyPassphrase = getSecretFromFormField(r, "sftp_key_passphrase")
 	fingerprintsFormValue := r.Form.Get("sftp_fingerprints")
 	config.Fingerprints = getSliceFromDelimitedValues(fingerprintsFormValue, "\n")
+	config.KnownHosts = getSliceFromDelimitedValues(r.Form.Get("sftp_known_hosts"), "\n")
+	config.KnownHostsFile = strings.TrimSpace(r.Form.Get("sftp_known_hosts_file"))
 	config.Prefix = strings.TrimSpace(r.Form.Get("sftp_prefix"))
 	config.DisableCouncurrentReads = r.Form.Get("sftp_disable_concurrent_reads") != ""
 	config.BufferSize, err = strconv.ParseInt(r.Form.Get("sftp_buffer_size"), 10, 64)
+	if poolSize, errPool := strconv.Atoi(r.Form.Get("sftp_pool_size")); errPool == nil {
+		config.PoolSize = poolSize
+	}
+	config.ProxyURL = strings.TrimSpace(r.Form.Get("sftp_proxy_url"))
+	config.ProxyUsername = strings.TrimSpace(r.Form.Get("sftp_proxy_username"))
+	config.ProxyPassword = getSecretFromFormField(r, "sftp_proxy_password")
+	config.OutboundBindAddress = strings.TrimSpace(r.Form.Get("sftp_outbound_bind_address"))
 	if r.Form.Get("sftp_equality_check_mode") != "" {
 		config.EqualityCheckMode = 1
 	} else {
@@ -1650,6 +1683,23 @@ func getHTTPFsConfig(r *http.Request) vfs.HTTPFsConfig {
 	config.SkipTLSVerify = r.Form.Get("http_skip_tls_verify") != ""
 	config.Password = getSecretFromFormField(r, "http_password")
 	config.APIKey = getSecretFromFormField(r, "http_api_key")
+	config.ProxyURL = strings.TrimSpace(r.Form.Get("http_proxy_url"))
+	config.ProxyUsername = strings.TrimSpace(r.Form.Get("http_proxy_username"))
+	config.ProxyPassword = getSecretFromFormField(r, "http_proxy_password")
+	config.OutboundBindAddress = strings.TrimSpace(r.Form.Get("http_outbound_bind_address"))
+	if val, err := strconv.Atoi(r.Form.Get("http_retry_max_attempts")); err == nil {
+		config.RetryMaxAttempts = val
+	}
+	if val, err := strconv.Atoi(r.Form.Get("http_retry_base_delay")); err == nil {
+		config.RetryBaseDelay = val
+	}
+	config.RetryIdempotentWrites = r.Form.Get("http_retry_idempotent_writes") != ""
+	if val, err := strconv.Atoi(r.Form.Get("http_circuit_breaker_threshold")); err == nil {
+		config.CircuitBreakerThreshold = val
+	}
+	if val, err := strconv.Atoi(r.Form.Get("http_circuit_breaker_cooldown")); err == nil {
+		config.CircuitBreakerCooldown = val
+	}
 	if r.Form.Get("http_equality_check_mode") != "" {
 		config.EqualityCheckMode = 1
 	} else {
@@ -1666,6 +1716,7 @@ func getAzureConfig(r *http.Request) (vfs.AzBlobFsConfig, error) {
 	config.AccountKey = getSecretFromFormField(r, "az_account_key")
 	config.SASURL = getSecretFromFormField(r, "az_sas_url")
 	config.Endpoint = strings.TrimSpace(r.Form.Get("az_endpoint"))
+	config.OutboundBindAddress = strings.TrimSpace(r.Form.Get("az_outbound_bind_address"))
 	config.KeyPrefix = strings.TrimSpace(strings.TrimPrefix(r.Form.Get("az_key_prefix"), "/"))
 	config.AccessTier = strings.TrimSpace(r.Form.Get("az_access_tier"))
 	config.UseEmulator = r.Form.Get("az_use_emulator") != ""
@@ -1737,6 +1788,10 @@ func getFsConfigFromPostFields(r *http.Request) (vfs.Filesystem, error) {
 	case sdk.HTTPFilesystemProvider:
 		fs.HTTPConfig = getHTTPFsConfig(r)
 	}
+	maxEntries, err := strconv.Atoi(r.Form.Get("fs_listing_max_entries"))
+	if err == nil {
+		fs.ListingMaxEntries = maxEntries
+	}
 	return fs, nil
 }
 
@@ -2004,6 +2059,7 @@ func updateRepeaterFormFields(r *http.Request) {
 			r.Form.Add("vfolder_name", strings.TrimSpace(r.Form.Get(base+"[vfolder_name]")))
 			r.Form.Add("vfolder_quota_files", strings.TrimSpace(r.Form.Get(base+"[vfolder_quota_files]")))
 			r.Form.Add("vfolder_quota_size", strings.TrimSpace(r.Form.Get(base+"[vfolder_quota_size]")))
+			r.Form["vfolder_permissions"+strconv.Itoa(len(r.Form["vfolder_path"])-1)] = r.Form[base+"[vfolder_permissions][]"]
 			continue
 		}
 		if hasPrefixAndSuffix(k, "directory_permissions[", "][sub_perm_path]") {
@@ -2747,12 +2803,18 @@ func getIPListEntryFromPostFields(r *http.Request, listType dataprovider.IPListT
 }
 
 func getSFTPConfigsFromPostFields(r *http.Request) *dataprovider.SFTPDConfigs {
+	mergePolicy, err := strconv.Atoi(r.Form.Get("sftp_merge_policy"))
+	if err != nil {
+		mergePolicy = dataprovider.SFTPDMergePolicyAppend
+	}
 	return &dataprovider.SFTPDConfigs{
 		HostKeyAlgos:   r.Form["sftp_host_key_algos"],
 		PublicKeyAlgos: r.Form["sftp_pub_key_algos"],
 		KexAlgorithms:  r.Form["sftp_kex_algos"],
 		Ciphers:        r.Form["sftp_ciphers"],
 		MACs:           r.Form["sftp_macs"],
+		MergePolicy:    mergePolicy,
+		MOTD:           strings.TrimSpace(r.Form.Get("sftp_motd")),
 	}
 }
 
@@ -3219,7 +3281,7 @@ func getAllUsers(w http.ResponseWriter, r *http.Request) {
 	}
 
 	dataGetter := func(limit, offset int) ([]byte, int, error) {
-		results, err := dataprovider.GetUsers(limit, offset, dataprovider.OrderASC, claims.Role)
+		results, err := dataprovider.GetUsers(limit, offset, dataprovider.OrderASC, claims.Role, "")
 		if err != nil {
 			return nil, 0, err
 		}
@@ -3510,12 +3572,23 @@ func (s *httpdServer) handleWebUpdateUserPost(w http.ResponseWriter, r *http.Req
 	updatedUser.Username = user.Username
 	updatedUser.Filters.RecoveryCodes = user.Filters.RecoveryCodes
 	updatedUser.Filters.TOTPConfig = user.Filters.TOTPConfig
+	updatedUser.Filters.TransferQuotaWindow = user.Filters.TransferQuotaWindow
+	updatedUser.Filters.UploadChecksum = user.Filters.UploadChecksum
+	updatedUser.Filters.UploadSizeLimits = user.Filters.UploadSizeLimits
+	updatedUser.Filters.CreateModes = user.Filters.CreateModes
+	updatedUser.Filters.ChownPolicy = user.Filters.ChownPolicy
+	updatedUser.Filters.LoginBanner = user.Filters.LoginBanner
+	updatedUser.Filters.RequireTrustedSourceAddr = user.Filters.RequireTrustedSourceAddr
+	updatedUser.Filters.SessionRecording = user.Filters.SessionRecording
+	updatedUser.Filters.MaxLogins = user.Filters.MaxLogins
+	updatedUser.Filters.DisableAfterFirstSession = user.Filters.DisableAfterFirstSession
 	updatedUser.LastPasswordChange = user.LastPasswordChange
 	updatedUser.SetEmptySecretsIfNil()
 	if updatedUser.Password == redactedSecret {
 		updatedUser.Password = user.Password
 	}
 	updateEncryptedSecrets(&updatedUser.FsConfig, &user.FsConfig)
+	updateFsConfigNonFormFields(&updatedUser.FsConfig, &user.FsConfig)
 
 	updatedUser = getUserFromTemplate(updatedUser, userTemplateFields{
 		Username:   updatedUser.Username,
@@ -3655,9 +3728,15 @@ func (s *httpdServer) handleWebUpdateFolderPost(w http.ResponseWriter, r *http.R
 	}
 	updatedFolder.ID = folder.ID
 	updatedFolder.Name = folder.Name
+	updatedFolder.Retention = folder.Retention
+	updatedFolder.MaxUploadFileSize = folder.MaxUploadFileSize
+	updatedFolder.Extraction = folder.Extraction
+	updatedFolder.Transform = folder.Transform
+	updatedFolder.SetstatMode = folder.SetstatMode
 	updatedFolder.FsConfig = fsConfig
 	updatedFolder.FsConfig.SetEmptySecretsIfNil()
 	updateEncryptedSecrets(&updatedFolder.FsConfig, &folder.FsConfig)
+	updateFsConfigNonFormFields(&updatedFolder.FsConfig, &folder.FsConfig)
 
 	updatedFolder = getFolderFromTemplate(updatedFolder, updatedFolder.Name)
 
@@ -3816,9 +3895,13 @@ func (s *httpdServer) handleWebUpdateGroupPost(w http.ResponseWriter, r *http.Re
 	}
 	updatedGroup.ID = group.ID
 	updatedGroup.Name = group.Name
+	updatedGroup.UserSettings.CreateModes = group.UserSettings.CreateModes
+	updatedGroup.UserSettings.ChownPolicy = group.UserSettings.ChownPolicy
+	updatedGroup.UserSettings.PermissionsMerge = group.UserSettings.PermissionsMerge
 	updatedGroup.SetEmptySecretsIfNil()
 
 	updateEncryptedSecrets(&updatedGroup.UserSettings.FsConfig, &group.UserSettings.FsConfig)
+	updateFsConfigNonFormFields(&updatedGroup.UserSettings.FsConfig, &group.UserSettings.FsConfig)
 
 	err = dataprovider.UpdateGroup(&updatedGroup, group.Users, claims.Username, ipAddr, claims.Role)
 	if err != nil {
@@ -4338,6 +4421,10 @@ func (s *httpdServer) handleWebConfigsPost(w http.ResponseWriter, r *http.Reques
 	case "sftp_submit":
 		configSection = 1
 		sftpConfigs := getSFTPConfigsFromPostFields(r)
+		if configs.SFTPD != nil {
+			// host keys are managed by the SFTP service
+			sftpConfigs.HostKeys = configs.SFTPD.HostKeys
+		}
 		configs.SFTPD = sftpConfigs
 	case "acme_submit":
 		configSection = 2
@@ -4378,6 +4465,8 @@ func (s *httpdServer) handleWebConfigsPost(w http.ResponseWriter, r *http.Reques
 
 func postConfigsUpdate(section int, configs dataprovider.Configs) {
 	switch section {
+	case 1:
+		sftpd.ApplyProviderConfigs()
 	case 3:
 		err := configs.SMTP.TryDecrypt()
 		if err == nil {
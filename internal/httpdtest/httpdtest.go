@@ -22,6 +22,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"net/http"
 	"net/url"
 	"path"
@@ -54,6 +55,7 @@ const (
 	serverStatusPath      = "/api/v2/status"
 	dumpDataPath          = "/api/v2/dumpdata"
 	loadDataPath          = "/api/v2/loaddata"
+	archivesPath          = "/api/v2/archives"
 	defenderHosts         = "/api/v2/defender/hosts"
 	adminPath             = "/api/v2/admins"
 	adminPwdPath          = "/api/v2/admin/changepwd"
@@ -63,6 +65,8 @@ const (
 	eventActionsPath      = "/api/v2/eventactions"
 	eventRulesPath        = "/api/v2/eventrules"
 	rolesPath             = "/api/v2/roles"
+	userTemplatesPath     = "/api/v2/usertemplates"
+	objectChangesPath     = "/api/v2/changes"
 	ipListsPath           = "/api/v2/iplists"
 )
 
@@ -218,6 +222,20 @@ func RemoveUser(user dataprovider.User, expectedStatusCode int) ([]byte, error)
 	return body, checkResponse(resp.StatusCode, expectedStatusCode)
 }
 
+// UnlockUser clears the lockout state for the user with the specified username and checks the
+// received HTTP Status code against expectedStatusCode.
+func UnlockUser(username string, expectedStatusCode int) ([]byte, error) {
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodPut, buildURLRelativeToBase(userPath, url.PathEscape(username), "unlock"),
+		nil, "", getDefaultToken())
+	if err != nil {
+		return body, err
+	}
+	defer resp.Body.Close()
+	body, _ = getResponseBody(resp)
+	return body, checkResponse(resp.StatusCode, expectedStatusCode)
+}
+
 // GetUserByUsername gets a user by username and checks the received HTTP Status code against expectedStatusCode.
 func GetUserByUsername(username string, expectedStatusCode int) (dataprovider.User, []byte, error) {
 	var user dataprovider.User
@@ -237,6 +255,61 @@ func GetUserByUsername(username string, expectedStatusCode int) (dataprovider.Us
 	return user, body, err
 }
 
+// GetEffectiveUserSettings gets a user with the group settings applied and checks the
+// received HTTP Status code against expectedStatusCode.
+func GetEffectiveUserSettings(username string, expectedStatusCode int) (dataprovider.User, []byte, error) {
+	var user dataprovider.User
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodGet, buildURLRelativeToBase(userPath, url.PathEscape(username), "effective"),
+		nil, "", getDefaultToken())
+	if err != nil {
+		return user, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &user)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return user, body, err
+}
+
+// GetUserDiagnostic gets the diagnostic for a user and, optionally, for an operation on a
+// virtual path and checks the received HTTP Status code against expectedStatusCode.
+func GetUserDiagnostic(username, virtualPath, operation, protocol string, expectedStatusCode int,
+) (common.UserDiagnostic, []byte, error) {
+	var diagnostic common.UserDiagnostic
+	var body []byte
+	u, err := url.Parse(buildURLRelativeToBase(userPath, url.PathEscape(username), "diagnostics"))
+	if err != nil {
+		return diagnostic, body, err
+	}
+	q := u.Query()
+	if virtualPath != "" {
+		q.Add("path", virtualPath)
+	}
+	if operation != "" {
+		q.Add("operation", operation)
+	}
+	if protocol != "" {
+		q.Add("protocol", protocol)
+	}
+	u.RawQuery = q.Encode()
+	resp, err := sendHTTPRequest(http.MethodGet, u.String(), nil, "", getDefaultToken())
+	if err != nil {
+		return diagnostic, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &diagnostic)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return diagnostic, body, err
+}
+
 // GetUsers returns a list of users and checks the received HTTP Status code against expectedStatusCode.
 // The number of results can be limited specifying a limit.
 // Some results can be skipped specifying an offset.
@@ -371,6 +444,168 @@ func GetGroups(limit, offset int64, expectedStatusCode int) ([]dataprovider.Grou
 	return groups, body, err
 }
 
+// AddUserTemplate adds a new user template and checks the received HTTP Status code against expectedStatusCode.
+func AddUserTemplate(template dataprovider.UserTemplate, expectedStatusCode int) (dataprovider.UserTemplate, []byte, error) {
+	var newTemplate dataprovider.UserTemplate
+	var body []byte
+	asJSON, _ := json.Marshal(template)
+	resp, err := sendHTTPRequest(http.MethodPost, buildURLRelativeToBase(userTemplatesPath), bytes.NewBuffer(asJSON),
+		"application/json", getDefaultToken())
+	if err != nil {
+		return newTemplate, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if expectedStatusCode != http.StatusCreated {
+		body, _ = getResponseBody(resp)
+		return newTemplate, body, err
+	}
+	if err == nil {
+		err = render.DecodeJSON(resp.Body, &newTemplate)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	if err == nil {
+		err = checkUserTemplate(template, newTemplate)
+	}
+	return newTemplate, body, err
+}
+
+// UpdateUserTemplate updates an existing user template and checks the received HTTP Status code against expectedStatusCode
+func UpdateUserTemplate(template dataprovider.UserTemplate, expectedStatusCode int) (dataprovider.UserTemplate, []byte, error) {
+	var newTemplate dataprovider.UserTemplate
+	var body []byte
+
+	asJSON, _ := json.Marshal(template)
+	resp, err := sendHTTPRequest(http.MethodPut, buildURLRelativeToBase(userTemplatesPath, url.PathEscape(template.Name)),
+		bytes.NewBuffer(asJSON), "application/json", getDefaultToken())
+	if err != nil {
+		return newTemplate, body, err
+	}
+	defer resp.Body.Close()
+	body, _ = getResponseBody(resp)
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if expectedStatusCode != http.StatusOK {
+		return newTemplate, body, err
+	}
+	if err == nil {
+		newTemplate, body, err = GetUserTemplateByName(template.Name, expectedStatusCode)
+	}
+	if err == nil {
+		err = checkUserTemplate(template, newTemplate)
+	}
+	return newTemplate, body, err
+}
+
+// RemoveUserTemplate removes an existing user template and checks the received HTTP Status code against expectedStatusCode.
+func RemoveUserTemplate(template dataprovider.UserTemplate, expectedStatusCode int) ([]byte, error) {
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodDelete, buildURLRelativeToBase(userTemplatesPath, url.PathEscape(template.Name)),
+		nil, "", getDefaultToken())
+	if err != nil {
+		return body, err
+	}
+	defer resp.Body.Close()
+	body, _ = getResponseBody(resp)
+	return body, checkResponse(resp.StatusCode, expectedStatusCode)
+}
+
+// GetUserTemplateByName gets a user template by name and checks the received HTTP Status code against expectedStatusCode.
+func GetUserTemplateByName(name string, expectedStatusCode int) (dataprovider.UserTemplate, []byte, error) {
+	var template dataprovider.UserTemplate
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodGet, buildURLRelativeToBase(userTemplatesPath, url.PathEscape(name)),
+		nil, "", getDefaultToken())
+	if err != nil {
+		return template, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &template)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return template, body, err
+}
+
+// GetUserTemplates returns a list of user templates and checks the received HTTP Status code against expectedStatusCode.
+// The number of results can be limited specifying a limit.
+// Some results can be skipped specifying an offset.
+func GetUserTemplates(limit, offset int64, expectedStatusCode int) ([]dataprovider.UserTemplate, []byte, error) {
+	var templates []dataprovider.UserTemplate
+	var body []byte
+	url, err := addLimitAndOffsetQueryParams(buildURLRelativeToBase(userTemplatesPath), limit, offset)
+	if err != nil {
+		return templates, body, err
+	}
+	resp, err := sendHTTPRequest(http.MethodGet, url.String(), nil, "", getDefaultToken())
+	if err != nil {
+		return templates, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &templates)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return templates, body, err
+}
+
+// AddUserFromTemplate creates a user from the template with the given name and checks the received HTTP Status
+// code against expectedStatusCode. If dryRun is true the expanded user is returned without saving it
+func AddUserFromTemplate(name string, vars dataprovider.UserTemplateVariables, dryRun bool, expectedStatusCode int,
+) (dataprovider.User, []byte, error) {
+	var user dataprovider.User
+	var body []byte
+	asJSON, _ := json.Marshal(vars)
+	reqURL := buildURLRelativeToBase(userTemplatesPath, url.PathEscape(name), "users")
+	if dryRun {
+		reqURL += "?dry_run=true"
+	}
+	resp, err := sendHTTPRequest(http.MethodPost, reqURL, bytes.NewBuffer(asJSON), "application/json", getDefaultToken())
+	if err != nil {
+		return user, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && (expectedStatusCode == http.StatusOK || expectedStatusCode == http.StatusCreated) {
+		err = render.DecodeJSON(resp.Body, &user)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return user, body, err
+}
+
+// GetObjectChanges returns the provider object changes with a sequence identifier greater than sinceID
+// and checks the received HTTP Status code against expectedStatusCode.
+func GetObjectChanges(sinceID, limit int64, expectedStatusCode int) ([]dataprovider.ObjectChange, []byte, error) {
+	var changes []dataprovider.ObjectChange
+	var body []byte
+	url, err := addLimitAndOffsetQueryParams(buildURLRelativeToBase(objectChangesPath), limit, 0)
+	if err != nil {
+		return changes, body, err
+	}
+	if sinceID != 0 {
+		q := url.Query()
+		q.Add("since", strconv.FormatInt(sinceID, 10))
+		url.RawQuery = q.Encode()
+	}
+	resp, err := sendHTTPRequest(http.MethodGet, url.String(), nil, "", getDefaultToken())
+	if err != nil {
+		return changes, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &changes)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return changes, body, err
+}
+
 // AddRole adds a new role and checks the received HTTP Status code against expectedStatusCode.
 func AddRole(role dataprovider.Role, expectedStatusCode int) (dataprovider.Role, []byte, error) {
 	var newRole dataprovider.Role
@@ -1104,6 +1339,26 @@ func GetQuotaScans(expectedStatusCode int) ([]common.ActiveQuotaScan, []byte, er
 	return quotaScans, body, err
 }
 
+// GetTransferQuotaWindow gets the transfer quota window usage for the given user and checks the
+// received HTTP Status code against expectedStatusCode.
+func GetTransferQuotaWindow(user dataprovider.User, expectedStatusCode int) (dataprovider.TransferQuotaWindowStatus, []byte, error) {
+	var status dataprovider.TransferQuotaWindowStatus
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodGet, buildURLRelativeToBase(quotasBasePath, "users", user.Username, "transfer-window"),
+		nil, "", getDefaultToken())
+	if err != nil {
+		return status, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &status)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return status, body, err
+}
+
 // StartQuotaScan starts a new quota scan for the given user and checks the received HTTP Status code against expectedStatusCode.
 func StartQuotaScan(user dataprovider.User, expectedStatusCode int) ([]byte, error) {
 	var body []byte
@@ -1219,6 +1474,53 @@ func CloseConnection(connectionID string, expectedStatusCode int) ([]byte, error
 	return body, err
 }
 
+// CheckFolderBackend checks the backend for an unsaved folder and checks the received
+// HTTP Status code against expectedStatusCode. A timeout of 0 means the default one
+func CheckFolderBackend(folder vfs.BaseVirtualFolder, writeTest bool, timeout int, expectedStatusCode int,
+) (common.BackendCheckResult, []byte, error) {
+	folderAsJSON, _ := json.Marshal(folder)
+	return checkFolderBackend(buildURLRelativeToBase(folderPath, "check"), bytes.NewBuffer(folderAsJSON),
+		writeTest, timeout, expectedStatusCode)
+}
+
+// CheckSavedFolderBackend checks the backend for the folder with the specified name and checks
+// the received HTTP Status code against expectedStatusCode. A timeout of 0 means the default one
+func CheckSavedFolderBackend(name string, writeTest bool, timeout int, expectedStatusCode int,
+) (common.BackendCheckResult, []byte, error) {
+	return checkFolderBackend(buildURLRelativeToBase(folderPath, url.PathEscape(name), "check"), nil,
+		writeTest, timeout, expectedStatusCode)
+}
+
+func checkFolderBackend(checkURL string, body io.Reader, writeTest bool, timeout int, expectedStatusCode int,
+) (common.BackendCheckResult, []byte, error) {
+	var result common.BackendCheckResult
+	var respBody []byte
+	u, err := url.Parse(checkURL)
+	if err != nil {
+		return result, respBody, err
+	}
+	q := u.Query()
+	if writeTest {
+		q.Add("write-test", "1")
+	}
+	if timeout != 0 {
+		q.Add("timeout", strconv.Itoa(timeout))
+	}
+	u.RawQuery = q.Encode()
+	resp, err := sendHTTPRequest(http.MethodPost, u.String(), body, "application/json", getDefaultToken())
+	if err != nil {
+		return result, respBody, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &result)
+	} else {
+		respBody, _ = getResponseBody(resp)
+	}
+	return result, respBody, err
+}
+
 // AddFolder adds a new folder and checks the received HTTP Status code against expectedStatusCode
 func AddFolder(folder vfs.BaseVirtualFolder, expectedStatusCode int) (vfs.BaseVirtualFolder, []byte, error) {
 	var newFolder vfs.BaseVirtualFolder
@@ -1567,6 +1869,85 @@ func LoaddataFromPostBody(data []byte, scanQuota, mode string, expectedStatusCod
 	return response, body, err
 }
 
+// GetArchives returns the saved archives
+func GetArchives(expectedStatusCode int) ([]dataprovider.ArchiveInfo, []byte, error) {
+	var archives []dataprovider.ArchiveInfo
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodGet, buildURLRelativeToBase(archivesPath), nil, "", getDefaultToken())
+	if err != nil {
+		return archives, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &archives)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return archives, body, err
+}
+
+// CreateArchive saves an archive with the objects in the specified scopes
+func CreateArchive(expectedStatusCode int, scopes ...string) (dataprovider.ArchiveInfo, []byte, error) {
+	var archive dataprovider.ArchiveInfo
+	var body []byte
+	url, err := url.Parse(buildURLRelativeToBase(archivesPath))
+	if err != nil {
+		return archive, body, err
+	}
+	if len(scopes) > 0 {
+		q := url.Query()
+		q.Add("scopes", strings.Join(scopes, ","))
+		url.RawQuery = q.Encode()
+	}
+	resp, err := sendHTTPRequest(http.MethodPost, url.String(), nil, "", getDefaultToken())
+	if err != nil {
+		return archive, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusCreated {
+		err = render.DecodeJSON(resp.Body, &archive)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return archive, body, err
+}
+
+// RestoreArchive restores the archive with the specified name, or validates it if dryRun is true
+func RestoreArchive(name, mode string, dryRun bool, expectedStatusCode int, scopes ...string,
+) (dataprovider.ArchiveRestoreReport, []byte, error) {
+	var report dataprovider.ArchiveRestoreReport
+	var body []byte
+	url, err := url.Parse(buildURLRelativeToBase(archivesPath, url.PathEscape(name), "restore"))
+	if err != nil {
+		return report, body, err
+	}
+	q := url.Query()
+	if mode != "" {
+		q.Add("mode", mode)
+	}
+	if dryRun {
+		q.Add("dry-run", "1")
+	}
+	if len(scopes) > 0 {
+		q.Add("scopes", strings.Join(scopes, ","))
+	}
+	url.RawQuery = q.Encode()
+	resp, err := sendHTTPRequest(http.MethodPost, url.String(), nil, "", getDefaultToken())
+	if err != nil {
+		return report, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &report)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return report, body, err
+}
+
 func checkResponse(actual int, expected int) error {
 	if expected != actual {
 		return fmt.Errorf("wrong status code: got %v want %v", actual, expected)
@@ -1839,6 +2220,37 @@ func checkRole(expected, actual dataprovider.Role) error {
 	return nil
 }
 
+func checkUserTemplate(expected, actual dataprovider.UserTemplate) error {
+	if expected.ID <= 0 {
+		if actual.ID <= 0 {
+			return errors.New("actual user template ID must be > 0")
+		}
+	} else {
+		if actual.ID != expected.ID {
+			return errors.New("user template ID mismatch")
+		}
+	}
+	if dataprovider.ConvertName(expected.Name) != actual.Name {
+		return errors.New("name mismatch")
+	}
+	if expected.Description != actual.Description {
+		return errors.New("description mismatch")
+	}
+	if expected.User.HomeDir != actual.User.HomeDir {
+		return errors.New("home dir mismatch")
+	}
+	if len(expected.User.VirtualFolders) != len(actual.User.VirtualFolders) {
+		return errors.New("virtual folders mismatch")
+	}
+	if actual.CreatedAt == 0 {
+		return errors.New("created_at unset")
+	}
+	if actual.UpdatedAt == 0 {
+		return errors.New("updated_at unset")
+	}
+	return nil
+}
+
 func checkGroup(expected, actual dataprovider.Group) error {
 	if expected.ID <= 0 {
 		if actual.ID <= 0 {
@@ -1871,6 +2283,21 @@ func checkGroup(expected, actual dataprovider.Group) error {
 	if err := compareUserFilters(expected.UserSettings.Filters, actual.UserSettings.Filters); err != nil {
 		return err
 	}
+	if !slices.Equal(expected.UserSettings.AllowedKeyAlgos, actual.UserSettings.AllowedKeyAlgos) {
+		return errors.New("allowed key algorithms mismatch")
+	}
+	if !slices.Equal(expected.UserSettings.TwoFactorTrustedNetworks, actual.UserSettings.TwoFactorTrustedNetworks) {
+		return errors.New("two factor trusted networks mismatch")
+	}
+	if expected.UserSettings.CreateModes != actual.UserSettings.CreateModes {
+		return errors.New("create modes mismatch")
+	}
+	if expected.UserSettings.ChownPolicy != actual.UserSettings.ChownPolicy {
+		return errors.New("chown policy mismatch")
+	}
+	if expected.UserSettings.PermissionsMerge != actual.UserSettings.PermissionsMerge {
+		return errors.New("permissions merge mismatch")
+	}
 	return compareFsConfig(&expected.UserSettings.FsConfig, &actual.UserSettings.FsConfig)
 }
 
@@ -1893,9 +2320,57 @@ func checkFolder(expected *vfs.BaseVirtualFolder, actual *vfs.BaseVirtualFolder)
 	if expected.Description != actual.Description {
 		return errors.New("description mismatch")
 	}
+	if expected.Retention.IsEnabled() && expected.Retention != actual.Retention {
+		return errors.New("retention mismatch")
+	}
+	if expected.MaxUploadFileSize != actual.MaxUploadFileSize {
+		return errors.New("max upload file size mismatch")
+	}
+	if expected.SetstatMode != actual.SetstatMode {
+		return errors.New("setstat mode mismatch")
+	}
+	if err := checkFolderExtraction(&expected.Extraction, &actual.Extraction); err != nil {
+		return err
+	}
+	if err := checkFolderTransform(&expected.Transform, &actual.Transform); err != nil {
+		return err
+	}
 	return compareFsConfig(&expected.FsConfig, &actual.FsConfig)
 }
 
+func checkFolderTransform(expected, actual *vfs.FolderTransform) error {
+	if !expected.IsEnabled() {
+		return nil
+	}
+	if err := checkEncryptedSecret(expected.PGPPublicKeys, actual.PGPPublicKeys); err != nil {
+		return fmt.Errorf("PGP public keys mismatch: %w", err)
+	}
+	if expected.PGPKeepName != actual.PGPKeepName {
+		return errors.New("PGP keep name mismatch")
+	}
+	return nil
+}
+
+func checkFolderExtraction(expected, actual *vfs.FolderExtraction) error {
+	if !expected.IsEnabled() {
+		return nil
+	}
+	if !slices.Equal(expected.Patterns, actual.Patterns) {
+		return errors.New("extraction patterns mismatch")
+	}
+	if expected.TargetDir != actual.TargetDir {
+		return errors.New("extraction target dir mismatch")
+	}
+	if expected.MaxEntries != actual.MaxEntries || expected.MaxSize != actual.MaxSize ||
+		expected.MaxRatio != actual.MaxRatio {
+		return errors.New("extraction limits mismatch")
+	}
+	if expected.DeleteArchive != actual.DeleteArchive {
+		return errors.New("extraction delete archive mismatch")
+	}
+	return nil
+}
+
 func checkAPIKey(expected, actual *dataprovider.APIKey) error {
 	if actual.Key != "" {
 		return errors.New("key must not be visible")
@@ -1927,6 +2402,17 @@ func checkAPIKey(expected, actual *dataprovider.APIKey) error {
 	if expected.Admin != actual.Admin {
 		return errors.New("admin mismatch")
 	}
+	if len(expected.Permissions) != len(actual.Permissions) {
+		return errors.New("permissions mismatch")
+	}
+	for _, perm := range expected.Permissions {
+		if !slices.Contains(actual.Permissions, perm) {
+			return errors.New("permissions content mismatch")
+		}
+	}
+	if expected.UsernamePrefix != actual.UsernamePrefix {
+		return errors.New("username prefix mismatch")
+	}
 
 	return nil
 }
@@ -2040,9 +2526,45 @@ func checkUser(expected *dataprovider.User, actual *dataprovider.User) error {
 	if !slices.Equal(expected.Filters.AdditionalEmails, actual.Filters.AdditionalEmails) {
 		return errors.New("additional emails mismatch")
 	}
+	if !slices.Equal(expected.Filters.AllowedKeyAlgos, actual.Filters.AllowedKeyAlgos) {
+		return errors.New("allowed key algorithms mismatch")
+	}
+	if !slices.Equal(expected.Filters.TwoFactorTrustedNetworks, actual.Filters.TwoFactorTrustedNetworks) {
+		return errors.New("two factor trusted networks mismatch")
+	}
+	if err := compareTransferQuotaWindow(&expected.Filters.TransferQuotaWindow, &actual.Filters.TransferQuotaWindow); err != nil {
+		return err
+	}
 	if expected.Filters.RequirePasswordChange != actual.Filters.RequirePasswordChange {
 		return errors.New("require_password_change mismatch")
 	}
+	if expected.Filters.UploadChecksum != actual.Filters.UploadChecksum {
+		return errors.New("upload_checksum mismatch")
+	}
+	if !slices.Equal(expected.Filters.UploadSizeLimits, actual.Filters.UploadSizeLimits) {
+		return errors.New("upload size limits mismatch")
+	}
+	if expected.Filters.CreateModes != actual.Filters.CreateModes {
+		return errors.New("create modes mismatch")
+	}
+	if expected.Filters.ChownPolicy != actual.Filters.ChownPolicy {
+		return errors.New("chown policy mismatch")
+	}
+	if expected.Filters.LoginBanner != actual.Filters.LoginBanner {
+		return errors.New("login banner mismatch")
+	}
+	if expected.Filters.RequireTrustedSourceAddr != actual.Filters.RequireTrustedSourceAddr {
+		return errors.New("require_trusted_source_addr mismatch")
+	}
+	if expected.Filters.SessionRecording != actual.Filters.SessionRecording {
+		return errors.New("session_recording mismatch")
+	}
+	if expected.Filters.MaxLogins != actual.Filters.MaxLogins {
+		return errors.New("max_logins mismatch")
+	}
+	if expected.Filters.DisableAfterFirstSession != actual.Filters.DisableAfterFirstSession {
+		return errors.New("disable_after_first_session mismatch")
+	}
 	if err := compareUserPermissions(expected.Permissions, actual.Permissions); err != nil {
 		return err
 	}
@@ -2138,6 +2660,9 @@ func compareVirtualFolders(expected []vfs.VirtualFolder, actual []vfs.VirtualFol
 				if (v.QuotaFiles) != (v1.QuotaFiles) {
 					return errors.New("vfolder quota files mismatch")
 				}
+				if err := compareVirtualFolderPermissions(v1.Permissions, v.Permissions); err != nil {
+					return err
+				}
 				found = true
 				break
 			}
@@ -2149,6 +2674,26 @@ func compareVirtualFolders(expected []vfs.VirtualFolder, actual []vfs.VirtualFol
 	return nil
 }
 
+func compareVirtualFolderPermissions(expected, actual []string) error {
+	if len(expected) == 0 || slices.Contains(expected, dataprovider.PermAny) {
+		if len(actual) > 0 {
+			return errors.New("vfolder permissions mismatch")
+		}
+		return nil
+	}
+	for _, perm := range expected {
+		if !slices.Contains(actual, perm) {
+			return errors.New("vfolder permissions mismatch")
+		}
+	}
+	for _, perm := range actual {
+		if !slices.Contains(expected, perm) {
+			return errors.New("vfolder permissions mismatch")
+		}
+	}
+	return nil
+}
+
 func compareFsConfig(expected *vfs.Filesystem, actual *vfs.Filesystem) error {
 	if expected.Provider != actual.Provider {
 		return errors.New("fs provider mismatch")
@@ -2159,6 +2704,9 @@ func compareFsConfig(expected *vfs.Filesystem, actual *vfs.Filesystem) error {
 	if expected.OSConfig.WriteBufferSize != actual.OSConfig.WriteBufferSize {
 		return fmt.Errorf("write buffer size mismatch")
 	}
+	if expected.ListingMaxEntries != actual.ListingMaxEntries {
+		return errors.New("listing max entries mismatch")
+	}
 	if err := compareS3Config(expected, actual); err != nil {
 		return err
 	}
@@ -2171,6 +2719,14 @@ func compareFsConfig(expected *vfs.Filesystem, actual *vfs.Filesystem) error {
 	if err := checkEncryptedSecret(expected.CryptConfig.Passphrase, actual.CryptConfig.Passphrase); err != nil {
 		return err
 	}
+	if len(expected.CryptConfig.RetiredPassphrases) != len(actual.CryptConfig.RetiredPassphrases) {
+		return errors.New("crypt retired passphrases mismatch")
+	}
+	for idx, passphrase := range expected.CryptConfig.RetiredPassphrases {
+		if err := checkEncryptedSecret(passphrase, actual.CryptConfig.RetiredPassphrases[idx]); err != nil {
+			return fmt.Errorf("crypt retired passphrase %d mismatch: %w", idx, err)
+		}
+	}
 	if expected.CryptConfig.ReadBufferSize != actual.CryptConfig.ReadBufferSize {
 		return fmt.Errorf("crypt read buffer size mismatch")
 	}
@@ -2239,6 +2795,27 @@ func compareS3Config(expected *vfs.Filesystem, actual *vfs.Filesystem) error { /
 		expected.S3Config.KeyPrefix+"/" != actual.S3Config.KeyPrefix {
 		return errors.New("fs S3 key prefix mismatch")
 	}
+	if !slices.Equal(expected.S3Config.ContentTypes, actual.S3Config.ContentTypes) {
+		return errors.New("fs S3 content types mismatch")
+	}
+	if len(expected.S3Config.UploadRules) != len(actual.S3Config.UploadRules) {
+		return errors.New("fs S3 upload rules mismatch")
+	}
+	if expected.S3Config.RequestPayer != actual.S3Config.RequestPayer {
+		return errors.New("fs S3 request payer mismatch")
+	}
+	if expected.S3Config.UseStoredChecksums != actual.S3Config.UseStoredChecksums {
+		return errors.New("fs S3 use stored checksums mismatch")
+	}
+	if expected.S3Config.ExternalID != actual.S3Config.ExternalID {
+		return errors.New("fs S3 external ID mismatch")
+	}
+	if expected.S3Config.RoleSessionName != actual.S3Config.RoleSessionName {
+		return errors.New("fs S3 role session name mismatch")
+	}
+	if expected.S3Config.OutboundBindAddress != actual.S3Config.OutboundBindAddress {
+		return errors.New("fs S3 outbound bind address mismatch")
+	}
 	return nil
 }
 
@@ -2265,6 +2842,18 @@ func compareGCSConfig(expected *vfs.Filesystem, actual *vfs.Filesystem) error {
 	if expected.GCSConfig.UploadPartMaxTime != actual.GCSConfig.UploadPartMaxTime {
 		return errors.New("GCS upload part max time mismatch")
 	}
+	if expected.GCSConfig.KMSKeyName != actual.GCSConfig.KMSKeyName {
+		return errors.New("GCS KMS key name mismatch")
+	}
+	if !maps.Equal(expected.GCSConfig.ObjectMetadata, actual.GCSConfig.ObjectMetadata) {
+		return errors.New("GCS object metadata mismatch")
+	}
+	if expected.GCSConfig.OutboundBindAddress != actual.GCSConfig.OutboundBindAddress {
+		return errors.New("GCS outbound bind address mismatch")
+	}
+	if !slices.Equal(expected.GCSConfig.ContentTypes, actual.GCSConfig.ContentTypes) {
+		return errors.New("GCS content types mismatch")
+	}
 	return nil
 }
 
@@ -2287,6 +2876,33 @@ func compareHTTPFsConfig(expected *vfs.Filesystem, actual *vfs.Filesystem) error
 	if err := checkEncryptedSecret(expected.HTTPConfig.APIKey, actual.HTTPConfig.APIKey); err != nil {
 		return fmt.Errorf("HTTPFs API key mismatch: %v", err)
 	}
+	if expected.HTTPConfig.ProxyURL != actual.HTTPConfig.ProxyURL {
+		return errors.New("HTTPFs proxy_url mismatch")
+	}
+	if expected.HTTPConfig.ProxyUsername != actual.HTTPConfig.ProxyUsername {
+		return errors.New("HTTPFs proxy_username mismatch")
+	}
+	if err := checkEncryptedSecret(expected.HTTPConfig.ProxyPassword, actual.HTTPConfig.ProxyPassword); err != nil {
+		return fmt.Errorf("HTTPFs proxy password mismatch: %v", err)
+	}
+	if expected.HTTPConfig.OutboundBindAddress != actual.HTTPConfig.OutboundBindAddress {
+		return errors.New("HTTPFs outbound_bind_address mismatch")
+	}
+	if expected.HTTPConfig.RetryMaxAttempts != actual.HTTPConfig.RetryMaxAttempts {
+		return errors.New("HTTPFs retry_max_attempts mismatch")
+	}
+	if expected.HTTPConfig.RetryBaseDelay != actual.HTTPConfig.RetryBaseDelay {
+		return errors.New("HTTPFs retry_base_delay mismatch")
+	}
+	if expected.HTTPConfig.RetryIdempotentWrites != actual.HTTPConfig.RetryIdempotentWrites {
+		return errors.New("HTTPFs retry_idempotent_writes mismatch")
+	}
+	if expected.HTTPConfig.CircuitBreakerThreshold != actual.HTTPConfig.CircuitBreakerThreshold {
+		return errors.New("HTTPFs circuit_breaker_threshold mismatch")
+	}
+	if expected.HTTPConfig.CircuitBreakerCooldown != actual.HTTPConfig.CircuitBreakerCooldown {
+		return errors.New("HTTPFs circuit_breaker_cooldown mismatch")
+	}
 	return nil
 }
 
@@ -2303,6 +2919,15 @@ func compareSFTPFsConfig(expected *vfs.Filesystem, actual *vfs.Filesystem) error
 	if expected.SFTPConfig.BufferSize != actual.SFTPConfig.BufferSize {
 		return errors.New("SFTPFs buffer_size mismatch")
 	}
+	if expected.SFTPConfig.PoolSize != actual.SFTPConfig.PoolSize {
+		return errors.New("SFTPFs pool_size mismatch")
+	}
+	if !slices.Equal(expected.SFTPConfig.KnownHosts, actual.SFTPConfig.KnownHosts) {
+		return errors.New("SFTPFs known_hosts mismatch")
+	}
+	if expected.SFTPConfig.KnownHostsFile != actual.SFTPConfig.KnownHostsFile {
+		return errors.New("SFTPFs known_hosts_file mismatch")
+	}
 	if expected.SFTPConfig.EqualityCheckMode != actual.SFTPConfig.EqualityCheckMode {
 		return errors.New("SFTPFs equality_check_mode mismatch")
 	}
@@ -2315,6 +2940,18 @@ func compareSFTPFsConfig(expected *vfs.Filesystem, actual *vfs.Filesystem) error
 	if err := checkEncryptedSecret(expected.SFTPConfig.KeyPassphrase, actual.SFTPConfig.KeyPassphrase); err != nil {
 		return fmt.Errorf("SFTPFs private key passphrase mismatch: %v", err)
 	}
+	if expected.SFTPConfig.ProxyURL != actual.SFTPConfig.ProxyURL {
+		return errors.New("SFTPFs proxy_url mismatch")
+	}
+	if expected.SFTPConfig.ProxyUsername != actual.SFTPConfig.ProxyUsername {
+		return errors.New("SFTPFs proxy_username mismatch")
+	}
+	if err := checkEncryptedSecret(expected.SFTPConfig.ProxyPassword, actual.SFTPConfig.ProxyPassword); err != nil {
+		return fmt.Errorf("SFTPFs proxy password mismatch: %v", err)
+	}
+	if expected.SFTPConfig.OutboundBindAddress != actual.SFTPConfig.OutboundBindAddress {
+		return errors.New("SFTPFs outbound_bind_address mismatch")
+	}
 	if expected.SFTPConfig.Prefix != actual.SFTPConfig.Prefix {
 		if expected.SFTPConfig.Prefix != "" && actual.SFTPConfig.Prefix != "/" {
 			return errors.New("SFTPFs prefix mismatch")
@@ -2369,6 +3006,15 @@ func compareAzBlobConfig(expected *vfs.Filesystem, actual *vfs.Filesystem) error
 	if expected.AzBlobConfig.AccessTier != actual.AzBlobConfig.AccessTier {
 		return errors.New("azure Blob access tier mismatch")
 	}
+	if expected.AzBlobConfig.OutboundBindAddress != actual.AzBlobConfig.OutboundBindAddress {
+		return errors.New("azure Blob outbound bind address mismatch")
+	}
+	if !slices.Equal(expected.AzBlobConfig.ContentTypes, actual.AzBlobConfig.ContentTypes) {
+		return errors.New("azure Blob content types mismatch")
+	}
+	if len(expected.AzBlobConfig.AccessTiers) != len(actual.AzBlobConfig.AccessTiers) {
+		return errors.New("azure Blob access tiers mismatch")
+	}
 	return nil
 }
 
@@ -2993,3 +3639,19 @@ func addUpdateUserQueryParams(rawurl, disconnect string) (*url.URL, error) {
 	url.RawQuery = q.Encode()
 	return url, err
 }
+
+func compareTransferQuotaWindow(expected, actual *dataprovider.TransferQuotaWindow) error {
+	if expected.Period != actual.Period {
+		return errors.New("transfer quota window period mismatch")
+	}
+	if expected.Size != actual.Size {
+		return errors.New("transfer quota window size mismatch")
+	}
+	if expected.Timezone != actual.Timezone {
+		return errors.New("transfer quota window time zone mismatch")
+	}
+	if expected.EnforceDuringTransfer != actual.EnforceDuringTransfer {
+		return errors.New("transfer quota window enforce during transfer mismatch")
+	}
+	return nil
+}
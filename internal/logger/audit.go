@@ -0,0 +1,236 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package logger
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+	lumberjack "gopkg.in/natefinch/lumberjack.v2"
+)
+
+const (
+	auditSender            = "audit"
+	defaultAuditBufferSize = 1024
+	auditRetryInterval     = 500 * time.Millisecond
+)
+
+var (
+	auditLog     *auditLogger
+	auditLogMu   sync.RWMutex
+	auditDropped atomic.Int64
+)
+
+// AuditRecord defines an audit log record for a completed file operation
+type AuditRecord struct {
+	Timestamp         time.Time `json:"timestamp"`
+	Username          string    `json:"username"`
+	ConnectionID      string    `json:"connection_id"`
+	Protocol          string    `json:"protocol"`
+	IP                string    `json:"ip"`
+	Operation         string    `json:"operation"`
+	VirtualPath       string    `json:"virtual_path"`
+	VirtualTargetPath string    `json:"virtual_target_path,omitempty"`
+	Size              int64     `json:"size"`
+	Elapsed           int64     `json:"elapsed_ms"`
+	// SHA-256 checksum for completed uploads, if enabled
+	SHA256 string `json:"sha256,omitempty"`
+	// Status is 1 for successful operations, 0 otherwise
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// AuditLoggerConfig defines the configuration for the audit logger
+type AuditLoggerConfig struct {
+	// Absolute path to the audit log file
+	FilePath string
+	// Maximum size in megabytes of the audit log file before it gets rotated
+	MaxSize int
+	// Maximum number of old audit log files to retain
+	MaxBackups int
+	// Maximum number of days to retain old audit log files
+	MaxAge int
+	// Compress determines if the rotated audit log files should be compressed
+	Compress bool
+	// UTCTime uses UTC for the timestamps in the rotated file names
+	UTCTime bool
+	// Number of records that can be queued before the sink is written
+	BufferSize int
+	// DropOnFailure drops the records if the queue is full or the sink fails,
+	// otherwise the operations wait for the sink to accept the record
+	DropOnFailure bool
+	// OnDrop, if set, is called each time a record is dropped
+	OnDrop func()
+}
+
+type auditLogger struct {
+	writer        io.WriteCloser
+	records       chan *AuditRecord
+	done          chan struct{}
+	dropOnFailure bool
+	onDrop        func()
+}
+
+// InitAuditLogger configures the audit logger. Any previously configured
+// audit logger is closed after writing the queued records
+func InitAuditLogger(config AuditLoggerConfig) error {
+	if !filepath.IsAbs(config.FilePath) {
+		return fmt.Errorf("invalid audit log file path %q: it must be an absolute path", config.FilePath)
+	}
+	logDir := filepath.Dir(config.FilePath)
+	if err := os.MkdirAll(logDir, os.ModePerm); err != nil {
+		return fmt.Errorf("unable to create audit log dir %q: %w", logDir, err)
+	}
+	bufferSize := config.BufferSize
+	if bufferSize <= 0 {
+		bufferSize = defaultAuditBufferSize
+	}
+	l := &auditLogger{
+		writer: &lumberjack.Logger{
+			Filename:   config.FilePath,
+			MaxSize:    config.MaxSize,
+			MaxBackups: config.MaxBackups,
+			MaxAge:     config.MaxAge,
+			Compress:   config.Compress,
+			LocalTime:  !config.UTCTime,
+		},
+		records:       make(chan *AuditRecord, bufferSize),
+		done:          make(chan struct{}),
+		dropOnFailure: config.DropOnFailure,
+		onDrop:        config.OnDrop,
+	}
+	go l.run()
+
+	auditLogMu.Lock()
+	old := auditLog
+	auditLog = l
+	auditLogMu.Unlock()
+
+	if old != nil {
+		old.close()
+	}
+	return nil
+}
+
+// CloseAuditLogger disables the audit logger after writing the queued records
+func CloseAuditLogger() {
+	auditLogMu.Lock()
+	old := auditLog
+	auditLog = nil
+	auditLogMu.Unlock()
+
+	if old != nil {
+		old.close()
+	}
+}
+
+// RotateAuditLogFile closes the existing audit log file and immediately create a new one
+func RotateAuditLogFile() error {
+	auditLogMu.RLock()
+	defer auditLogMu.RUnlock()
+
+	if auditLog == nil {
+		return errors.New("audit log is disabled")
+	}
+	if r, ok := auditLog.writer.(*lumberjack.Logger); ok {
+		return r.Rotate()
+	}
+	return errors.New("the audit log sink does not support rotation")
+}
+
+// IsAuditLogEnabled returns true if the audit logger is configured
+func IsAuditLogEnabled() bool {
+	auditLogMu.RLock()
+	defer auditLogMu.RUnlock()
+
+	return auditLog != nil
+}
+
+// GetAuditDroppedRecords returns the number of dropped audit records
+func GetAuditDroppedRecords() int64 {
+	return auditDropped.Load()
+}
+
+// AuditLog queues the specified record for the audit log.
+// It is a no-op if the audit logger is disabled
+func AuditLog(record *AuditRecord) {
+	auditLogMu.RLock()
+	defer auditLogMu.RUnlock()
+
+	if auditLog == nil {
+		return
+	}
+	if record.Timestamp.IsZero() {
+		record.Timestamp = zerolog.TimestampFunc()
+	}
+	if !auditLog.dropOnFailure {
+		auditLog.records <- record
+		return
+	}
+	select {
+	case auditLog.records <- record:
+	default:
+		auditLog.drop()
+	}
+}
+
+func (l *auditLogger) run() {
+	defer close(l.done)
+
+	for record := range l.records {
+		data, err := json.Marshal(record)
+		if err != nil {
+			Warn(auditSender, record.ConnectionID, "unable to marshal audit record: %v", err)
+			l.drop()
+			continue
+		}
+		data = append(data, '\n')
+		for {
+			_, err = l.writer.Write(data)
+			if err == nil {
+				break
+			}
+			Error(auditSender, record.ConnectionID, "unable to write audit record: %v", err)
+			if l.dropOnFailure {
+				l.drop()
+				break
+			}
+			time.Sleep(auditRetryInterval)
+		}
+	}
+	l.writer.Close() //nolint:errcheck
+}
+
+func (l *auditLogger) drop() {
+	auditDropped.Add(1)
+	if l.onDrop != nil {
+		l.onDrop()
+	}
+}
+
+// close must be called after removing the logger from auditLog
+// so no new records can be added
+func (l *auditLogger) close() {
+	close(l.records)
+	<-l.done
+}
@@ -0,0 +1,258 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package logger
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+	lumberjack "gopkg.in/natefinch/lumberjack.v2"
+)
+
+const (
+	eventStreamSender            = "event_stream"
+	defaultEventStreamBufferSize = 1024
+)
+
+// EventStreamSchemaVersion is the schema version included in each event, it
+// is incremented for incompatible schema changes
+const EventStreamSchemaVersion = 1
+
+// Supported event stream events
+const (
+	StreamEventConnectionAccepted = "connection_accepted"
+	StreamEventConnectionDenied   = "connection_denied"
+	StreamEventAuthAttempt        = "auth_attempt"
+	StreamEventSessionStart       = "session_start"
+	StreamEventSessionEnd         = "session_end"
+)
+
+var (
+	eventStream        *eventStreamLogger
+	eventStreamMu      sync.RWMutex
+	eventStreamDropped atomic.Int64
+)
+
+// StreamEvent defines an event for the event stream. Common fields are always
+// set, the other ones depend on the event type
+type StreamEvent struct {
+	SchemaVersion int       `json:"schema_version"`
+	Timestamp     time.Time `json:"timestamp"`
+	Event         string    `json:"event"`
+	Protocol      string    `json:"protocol"`
+	IP            string    `json:"ip"`
+	LocalAddress  string    `json:"local_address,omitempty"`
+	ConnectionID  string    `json:"connection_id,omitempty"`
+	Username      string    `json:"username,omitempty"`
+	// reason for denied connections
+	Reason string `json:"reason,omitempty"`
+	// the following fields are set for authentication attempts
+	LoginMethod string `json:"login_method,omitempty"`
+	Status      *int   `json:"status,omitempty"`
+	ErrorClass  string `json:"error_class,omitempty"`
+	Error       string `json:"error,omitempty"`
+	// the following fields are set for sessions
+	ClientVersion string `json:"client_version,omitempty"`
+	Duration      *int64 `json:"duration_ms,omitempty"`
+	Channels      *int64 `json:"channels,omitempty"`
+	BytesReceived *int64 `json:"bytes_received,omitempty"`
+	BytesSent     *int64 `json:"bytes_sent,omitempty"`
+}
+
+// EventStreamConfig defines the configuration for the event stream
+type EventStreamConfig struct {
+	// Absolute path to the event stream file. A named pipe is supported, it
+	// is not rotated
+	FilePath string
+	// Maximum size in megabytes of the event stream file before it gets rotated
+	MaxSize int
+	// Maximum number of old event stream files to retain
+	MaxBackups int
+	// Maximum number of days to retain old event stream files
+	MaxAge int
+	// Compress determines if the rotated event stream files should be compressed
+	Compress bool
+	// UTCTime uses UTC for the timestamps in the rotated file names
+	UTCTime bool
+	// Number of events that can be queued, events are dropped if the queue is full
+	BufferSize int
+	// OnDrop, if set, is called each time an event is dropped
+	OnDrop func()
+}
+
+type eventStreamLogger struct {
+	writer io.WriteCloser
+	events chan *StreamEvent
+	done   chan struct{}
+	onDrop func()
+}
+
+// InitEventStream configures the event stream. Any previously configured
+// event stream is closed after writing the queued events
+func InitEventStream(config EventStreamConfig) error {
+	if !filepath.IsAbs(config.FilePath) {
+		return fmt.Errorf("invalid event stream file path %q: it must be an absolute path", config.FilePath)
+	}
+	writer, err := getEventStreamWriter(config)
+	if err != nil {
+		return err
+	}
+	bufferSize := config.BufferSize
+	if bufferSize <= 0 {
+		bufferSize = defaultEventStreamBufferSize
+	}
+	l := &eventStreamLogger{
+		writer: writer,
+		events: make(chan *StreamEvent, bufferSize),
+		done:   make(chan struct{}),
+		onDrop: config.OnDrop,
+	}
+	go l.run()
+
+	eventStreamMu.Lock()
+	old := eventStream
+	eventStream = l
+	eventStreamMu.Unlock()
+
+	if old != nil {
+		old.close()
+	}
+	return nil
+}
+
+func getEventStreamWriter(config EventStreamConfig) (io.WriteCloser, error) {
+	if info, err := os.Stat(config.FilePath); err == nil && info.Mode()&os.ModeNamedPipe != 0 {
+		// opening a named pipe for writing blocks until a reader is available,
+		// so it is opened in read/write mode
+		f, err := os.OpenFile(config.FilePath, os.O_RDWR, 0)
+		if err != nil {
+			return nil, fmt.Errorf("unable to open event stream pipe %q: %w", config.FilePath, err)
+		}
+		return f, nil
+	}
+	logDir := filepath.Dir(config.FilePath)
+	if err := os.MkdirAll(logDir, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("unable to create event stream dir %q: %w", logDir, err)
+	}
+	return &lumberjack.Logger{
+		Filename:   config.FilePath,
+		MaxSize:    config.MaxSize,
+		MaxBackups: config.MaxBackups,
+		MaxAge:     config.MaxAge,
+		Compress:   config.Compress,
+		LocalTime:  !config.UTCTime,
+	}, nil
+}
+
+// CloseEventStream disables the event stream after writing the queued events
+func CloseEventStream() {
+	eventStreamMu.Lock()
+	old := eventStream
+	eventStream = nil
+	eventStreamMu.Unlock()
+
+	if old != nil {
+		old.close()
+	}
+}
+
+// RotateEventStreamFile closes the existing event stream file and immediately create a new one
+func RotateEventStreamFile() error {
+	eventStreamMu.RLock()
+	defer eventStreamMu.RUnlock()
+
+	if eventStream == nil {
+		return errors.New("event stream is disabled")
+	}
+	if r, ok := eventStream.writer.(*lumberjack.Logger); ok {
+		return r.Rotate()
+	}
+	return errors.New("the event stream sink does not support rotation")
+}
+
+// IsEventStreamEnabled returns true if the event stream is configured
+func IsEventStreamEnabled() bool {
+	eventStreamMu.RLock()
+	defer eventStreamMu.RUnlock()
+
+	return eventStream != nil
+}
+
+// GetEventStreamDroppedEvents returns the number of dropped events
+func GetEventStreamDroppedEvents() int64 {
+	return eventStreamDropped.Load()
+}
+
+// EmitStreamEvent queues the specified event for the event stream.
+// It never blocks, the event is dropped if the queue is full.
+// It is a no-op if the event stream is disabled
+func EmitStreamEvent(event *StreamEvent) {
+	eventStreamMu.RLock()
+	defer eventStreamMu.RUnlock()
+
+	if eventStream == nil {
+		return
+	}
+	event.SchemaVersion = EventStreamSchemaVersion
+	if event.Timestamp.IsZero() {
+		event.Timestamp = zerolog.TimestampFunc()
+	}
+	select {
+	case eventStream.events <- event:
+	default:
+		eventStream.drop()
+	}
+}
+
+func (l *eventStreamLogger) run() {
+	defer close(l.done)
+
+	for event := range l.events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			Warn(eventStreamSender, event.ConnectionID, "unable to marshal event: %v", err)
+			l.drop()
+			continue
+		}
+		data = append(data, '\n')
+		if _, err = l.writer.Write(data); err != nil {
+			Error(eventStreamSender, event.ConnectionID, "unable to write event: %v", err)
+			l.drop()
+		}
+	}
+	l.writer.Close() //nolint:errcheck
+}
+
+func (l *eventStreamLogger) drop() {
+	eventStreamDropped.Add(1)
+	if l.onDrop != nil {
+		l.onDrop()
+	}
+}
+
+// close must be called after removing the logger from eventStream
+// so no new events can be added
+func (l *eventStreamLogger) close() {
+	close(l.events)
+	<-l.done
+}
@@ -25,6 +25,7 @@ package logger
 import (
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
@@ -54,6 +55,8 @@ var (
 	logger        zerolog.Logger
 	consoleLogger zerolog.Logger
 	rollingLogger *lumberjack.Logger
+	// logWriter is the output for the main logger, syslog excluded
+	logWriter io.Writer
 )
 
 func init() {
@@ -86,22 +89,23 @@ func InitLogger(logFilePath string, logMaxSize int, logMaxBackups int, logMaxAge
 			Compress:   logCompress,
 			LocalTime:  !logUTCTime,
 		}
-		logger = zerolog.New(rollingLogger)
+		logWriter = rollingLogger
 		EnableConsoleLogger(level)
 	} else {
-		logger = zerolog.New(&logSyncWrapper{
+		logWriter = &logSyncWrapper{
 			output: os.Stdout,
-		})
+		}
 		consoleLogger = zerolog.Nop()
 	}
-	logger = logger.Level(level)
+	logger = zerolog.New(logWriter).Level(level)
 }
 
 // InitStdErrLogger configures the logger to write to stderr
 func InitStdErrLogger(level zerolog.Level) {
-	logger = zerolog.New(&logSyncWrapper{
+	logWriter = &logSyncWrapper{
 		output: os.Stderr,
-	}).Level(level)
+	}
+	logger = zerolog.New(logWriter).Level(level)
 	consoleLogger = zerolog.Nop()
 }
 
@@ -110,6 +114,7 @@ func InitStdErrLogger(level zerolog.Level) {
 func DisableLogger() {
 	logger = zerolog.Nop()
 	rollingLogger = nil
+	logWriter = nil
 }
 
 // EnableConsoleLogger enables the console logger
@@ -140,23 +145,77 @@ func SetLogTime(utc bool) {
 	}
 }
 
-// Log logs at the specified level for the specified sender
-func Log(level LogLevel, sender string, connectionID string, format string, v ...any) {
-	var ev *zerolog.Event
+// SSHConnectionFields defines the SSH connection details added
+// as structured fields to the log events. Empty fields are omitted
+type SSHConnectionFields struct {
+	ClientVersion string
+	KeyExchange   string
+	Cipher        string
+	MAC           string
+	HostKeyAlgo   string
+	LoginMethod   string
+}
+
+func (f *SSHConnectionFields) addTo(ev *zerolog.Event) {
+	if f == nil {
+		return
+	}
+	if f.ClientVersion != "" {
+		ev.Str("client_version", f.ClientVersion)
+	}
+	if f.KeyExchange != "" {
+		ev.Str("kex", f.KeyExchange)
+	}
+	if f.Cipher != "" {
+		ev.Str("cipher", f.Cipher)
+	}
+	if f.MAC != "" {
+		ev.Str("mac", f.MAC)
+	}
+	if f.HostKeyAlgo != "" {
+		ev.Str("host_key_algo", f.HostKeyAlgo)
+	}
+	if f.LoginMethod != "" {
+		ev.Str("login_method", f.LoginMethod)
+	}
+}
+
+func getEventForLevel(level LogLevel, forceDebug bool) *zerolog.Event {
+	l := &logger
+	if forceDebug && logger.GetLevel() > zerolog.DebugLevel {
+		debugLogger := logger.Level(zerolog.DebugLevel)
+		l = &debugLogger
+	}
 	switch level {
 	case LevelDebug:
-		ev = logger.Debug()
+		return l.Debug()
 	case LevelInfo:
-		ev = logger.Info()
+		return l.Info()
 	case LevelWarn:
-		ev = logger.Warn()
+		return l.Warn()
 	default:
-		ev = logger.Error()
+		return l.Error()
 	}
+}
+
+// Log logs at the specified level for the specified sender
+func Log(level LogLevel, sender string, connectionID string, format string, v ...any) {
+	ConnectionLog(level, sender, connectionID, false, nil, format, v...)
+}
+
+// ConnectionLog logs at the specified level for the specified connection.
+// If forceDebug is true the debug messages are logged regardless of the
+// configured log level. The SSH connection details, if any, are added
+// as structured fields
+func ConnectionLog(level LogLevel, sender, connectionID string, forceDebug bool, fields *SSHConnectionFields,
+	format string, v ...any,
+) {
+	ev := getEventForLevel(level, forceDebug)
 	ev.Timestamp().Str("sender", sender)
 	if connectionID != "" {
 		ev.Str("connection_id", connectionID)
 	}
+	fields.addTo(ev)
 	ev.Msg(fmt.Sprintf(format, v...))
 }
 
@@ -255,20 +314,26 @@ func CommandLog(command, path, target, user, fileMode, connectionID, protocol st
 // A connection can fail for an authentication error or other errors such as
 // a client abort or a time out if the login does not happen in two minutes.
 // These logs are useful for better integration with Fail2ban and similar tools.
-func ConnectionFailedLog(user, ip, loginType, protocol, errorString string) {
-	logger.Debug().
-		Timestamp().
+func ConnectionFailedLog(user, ip, loginType, protocol, connectionID, errorString string) {
+	ev := logger.Debug()
+	ev.Timestamp().
 		Str("sender", "connection_failed").
 		Str("client_ip", ip).
 		Str("username", user).
 		Str("login_type", loginType).
-		Str("protocol", protocol).
-		Str("error", errorString).
+		Str("protocol", protocol)
+	if connectionID != "" {
+		ev.Str("connection_id", connectionID)
+	}
+	ev.Str("error", errorString).
 		Send()
 }
 
 // LoginLog logs successful logins.
-func LoginLog(user, ip, loginMethod, protocol, connectionID, clientVersion string, encrypted bool, info string) {
+// The SSH connection fields, if any, are added as structured fields
+func LoginLog(user, ip, loginMethod, protocol, connectionID, clientVersion string, encrypted bool,
+	sshFields *SSHConnectionFields,
+) {
 	ev := logger.Info()
 	ev.Timestamp().
 		Str("sender", "login").
@@ -281,9 +346,7 @@ func LoginLog(user, ip, loginMethod, protocol, connectionID, clientVersion strin
 	}
 	ev.Str("client", clientVersion).
 		Bool("encrypted", encrypted)
-	if info != "" {
-		ev.Str("info", info)
-	}
+	sshFields.addTo(ev)
 	ev.Send()
 }
 
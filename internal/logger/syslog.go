@@ -0,0 +1,253 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package logger
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/drakkan/sftpgo/v2/internal/metric"
+)
+
+const (
+	syslogAppName         = "sftpgo"
+	syslogTimeFormat      = "2006-01-02T15:04:05.000000Z07:00"
+	syslogBufferSize      = 1024
+	syslogDialTimeout     = 5 * time.Second
+	syslogWriteTimeout    = 5 * time.Second
+	syslogRedialInterval  = 10 * time.Second
+	defaultSyslogFacility = "daemon"
+)
+
+var (
+	syslogOutput  *syslogWriter
+	syslogDropped atomic.Int64
+	errNoSyslog   = errors.New("not connected to the syslog server")
+)
+
+var syslogFacilities = map[string]int{
+	"kern":     0,
+	"user":     1,
+	"mail":     2,
+	"daemon":   3,
+	"auth":     4,
+	"syslog":   5,
+	"lpr":      6,
+	"news":     7,
+	"uucp":     8,
+	"cron":     9,
+	"authpriv": 10,
+	"ftp":      11,
+	"local0":   16,
+	"local1":   17,
+	"local2":   18,
+	"local3":   19,
+	"local4":   20,
+	"local5":   21,
+	"local6":   22,
+	"local7":   23,
+}
+
+// SyslogConfig defines the configuration for the syslog output
+type SyslogConfig struct {
+	// Address of the syslog server, for example udp://127.0.0.1:514,
+	// tcp://127.0.0.1:514 or unixgram:///dev/log
+	Address string
+	// Syslog facility, for example daemon, auth or local0. Default: daemon
+	Facility string
+	// If true the logs are written to syslog only, otherwise they are
+	// written to the configured log file or standard output too
+	Only bool
+}
+
+// syslogWriter sends the log messages, as RFC 5424 messages with the JSON
+// log record as message, to a syslog server. Messages are queued in a bounded
+// buffer and dropped if the buffer is full or the server is not reachable,
+// so logging never blocks
+type syslogWriter struct {
+	network  string
+	address  string
+	facility int
+	hostname string
+	pid      string
+	messages chan []byte
+	// the following fields are accessed by the sender goroutine only
+	conn          net.Conn
+	lastDialError time.Time
+}
+
+func newSyslogWriter(config SyslogConfig) (*syslogWriter, error) {
+	network, address, ok := strings.Cut(config.Address, "://")
+	if !ok || address == "" {
+		return nil, fmt.Errorf("invalid syslog address %q", config.Address)
+	}
+	switch network {
+	case "udp", "tcp", "unix", "unixgram":
+	default:
+		return nil, fmt.Errorf("unsupported syslog network %q", network)
+	}
+	facilityName := config.Facility
+	if facilityName == "" {
+		facilityName = defaultSyslogFacility
+	}
+	facility, ok := syslogFacilities[facilityName]
+	if !ok {
+		return nil, fmt.Errorf("unsupported syslog facility %q", config.Facility)
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	w := &syslogWriter{
+		network:  network,
+		address:  address,
+		facility: facility,
+		hostname: hostname,
+		pid:      strconv.Itoa(os.Getpid()),
+		messages: make(chan []byte, syslogBufferSize),
+	}
+	go w.run()
+	return w, nil
+}
+
+// Write implements io.Writer
+func (w *syslogWriter) Write(p []byte) (int, error) {
+	return w.WriteLevel(zerolog.NoLevel, p)
+}
+
+// WriteLevel implements zerolog.LevelWriter
+func (w *syslogWriter) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	msg := w.format(level, p)
+	select {
+	case w.messages <- msg:
+	default:
+		w.drop()
+	}
+	return len(p), nil
+}
+
+func (w *syslogWriter) format(level zerolog.Level, p []byte) []byte {
+	var b bytes.Buffer
+
+	b.Grow(len(p) + 128)
+	b.WriteByte('<')
+	b.WriteString(strconv.Itoa(w.facility*8 + getSyslogSeverity(level)))
+	b.WriteString(">1 ")
+	b.WriteString(zerolog.TimestampFunc().Format(syslogTimeFormat))
+	b.WriteByte(' ')
+	b.WriteString(w.hostname)
+	b.WriteByte(' ')
+	b.WriteString(syslogAppName)
+	b.WriteByte(' ')
+	b.WriteString(w.pid)
+	// no MSGID and no structured data, the structured fields are in the JSON message
+	b.WriteString(" - - ")
+	b.Write(bytes.TrimRight(p, "\n"))
+	return b.Bytes()
+}
+
+func (w *syslogWriter) run() {
+	for msg := range w.messages {
+		if err := w.send(msg); err != nil {
+			w.drop()
+		}
+	}
+}
+
+func (w *syslogWriter) drop() {
+	syslogDropped.Add(1)
+	metric.AddSyslogMessageDropped()
+}
+
+func (w *syslogWriter) send(msg []byte) error {
+	if w.conn == nil {
+		if !w.lastDialError.IsZero() && time.Since(w.lastDialError) < syslogRedialInterval {
+			return errNoSyslog
+		}
+		conn, err := net.DialTimeout(w.network, w.address, syslogDialTimeout)
+		if err != nil {
+			w.lastDialError = time.Now()
+			return err
+		}
+		w.conn = conn
+		w.lastDialError = time.Time{}
+	}
+	switch w.network {
+	case "tcp":
+		// octet counting framing as defined in RFC 6587
+		msg = append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+	case "unix":
+		msg = append(msg, '\n')
+	}
+	w.conn.SetWriteDeadline(time.Now().Add(syslogWriteTimeout)) //nolint:errcheck
+	if _, err := w.conn.Write(msg); err != nil {
+		w.conn.Close() //nolint:errcheck
+		w.conn = nil
+		return err
+	}
+	return nil
+}
+
+func getSyslogSeverity(level zerolog.Level) int {
+	switch level {
+	case zerolog.DebugLevel, zerolog.TraceLevel:
+		return 7
+	case zerolog.WarnLevel:
+		return 4
+	case zerolog.ErrorLevel:
+		return 3
+	case zerolog.FatalLevel:
+		return 2
+	case zerolog.PanicLevel:
+		return 1
+	default:
+		return 6
+	}
+}
+
+// EnableSyslogOutput sends the logs to the configured syslog server, in addition
+// or instead of the log file or the standard output. It must be called once,
+// after the logger is initialized
+func EnableSyslogOutput(config SyslogConfig) error {
+	if syslogOutput != nil {
+		return errors.New("syslog output already enabled")
+	}
+	w, err := newSyslogWriter(config)
+	if err != nil {
+		return err
+	}
+	level := logger.GetLevel()
+	if config.Only || logWriter == nil {
+		logger = zerolog.New(w).Level(level)
+	} else {
+		logger = zerolog.New(zerolog.MultiLevelWriter(logWriter, w)).Level(level)
+	}
+	syslogOutput = w
+	return nil
+}
+
+// GetSyslogDroppedMessages returns the number of log messages not sent to syslog
+func GetSyslogDroppedMessages() int64 {
+	return syslogDropped.Load()
+}
@@ -18,6 +18,9 @@
 package metric
 
 import (
+	"sync/atomic"
+	"time"
+
 	"github.com/go-chi/chi/v5"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
@@ -36,6 +39,13 @@ const (
 	loginMethodIDP                  = "IDP"
 )
 
+var (
+	// labels for the SFTP operations, indexed by operation
+	sftpOperationLabels = []string{"open", "read", "write", "close", "stat", "readdir", "rename", "remove"}
+	// labels for the filesystem providers, indexed by provider
+	fsProviderLabels = []string{"osfs", "s3fs", "gcsfs", "azblobfs", "cryptfs", "sftpfs", "httpfs"}
+)
+
 func init() {
 	version.AddFeature("+metrics")
 }
@@ -47,12 +57,101 @@ var (
 		Help: "Availability for the configured data provider, 1 means OK, 0 KO",
 	})
 
+	// dataproviderReadRouting is the metric that reports the total number of read
+	// operations by operation class and target: primary, replica or failover to the primary
+	dataproviderReadRouting = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sftpgo_dataprovider_read_routing_total",
+		Help: "The total number of read operations routed to the primary database or to a read replica",
+	}, []string{"class", "target"})
+
+	// dataproviderReplicaHealth is the metric that reports the health of the read replicas
+	dataproviderReplicaHealth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sftpgo_dataprovider_replica_health",
+		Help: "Health for the read replicas, 1 means OK, 0 KO",
+	}, []string{"replica"})
+
+	// dataproviderReplicaLag is the metric that reports the replication lag of the read replicas
+	dataproviderReplicaLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sftpgo_dataprovider_replica_lag_seconds",
+		Help: "Replication lag for the read replicas as seconds, -1 means unknown",
+	}, []string{"replica"})
+
+	// maintenanceJobRuns is the metric that reports the total number of executions for the maintenance jobs
+	maintenanceJobRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sftpgo_maintenance_job_runs_total",
+		Help: "The total number of executions for the maintenance jobs",
+	}, []string{"job"})
+
+	// maintenanceJobFailures is the metric that reports the total number of failed executions
+	// for the maintenance jobs
+	maintenanceJobFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sftpgo_maintenance_job_failures_total",
+		Help: "The total number of failed executions for the maintenance jobs",
+	}, []string{"job"})
+
+	// maintenanceJobSkipped is the metric that reports the total number of skipped executions
+	// for the maintenance jobs, for example because the job is running on another instance
+	maintenanceJobSkipped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sftpgo_maintenance_job_skipped_total",
+		Help: "The total number of skipped executions for the maintenance jobs",
+	}, []string{"job"})
+
+	// maintenanceJobDuration is the metric that reports the execution time for the maintenance jobs
+	maintenanceJobDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sftpgo_maintenance_job_duration_seconds",
+		Help:    "Execution time for the maintenance jobs as seconds",
+		Buckets: []float64{0.1, 0.5, 1, 5, 15, 60, 300, 900, 3600},
+	}, []string{"job"})
+
 	// activeConnections is the metric that reports the total number of active connections
 	activeConnections = promauto.NewGauge(prometheus.GaugeOpts{
 		Name: "sftpgo_active_connections",
 		Help: "Total number of logged in users",
 	})
 
+	// activeSSHConnections is the metric that reports the total number of active SSH connections
+	activeSSHConnections = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "sftpgo_active_ssh_connections",
+		Help: "Total number of active SSH connections",
+	})
+
+	// activeSessions is the metric that reports the number of active sessions per protocol
+	activeSessions = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sftpgo_active_sessions",
+		Help: "Number of active sessions per protocol",
+	}, []string{"protocol"})
+
+	// activeTransfers is the metric that reports the number of active transfers
+	// partitioned by type, upload or download
+	activeTransfers = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sftpgo_active_transfers",
+		Help: "Number of active transfers by type",
+	}, []string{"type"})
+
+	// activeUploads and activeDownloads are the pre-resolved gauges for activeTransfers
+	activeUploads   = activeTransfers.WithLabelValues("upload")
+	activeDownloads = activeTransfers.WithLabelValues("download")
+
+	// clientConnections is the metric that reports the number of client connections,
+	// authenticated or not
+	clientConnections = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "sftpgo_client_connections",
+		Help: "Total number of client connections, including the ones waiting for authentication",
+	})
+
+	// defenderBannedHosts is the metric that reports the number of hosts banned by the defender
+	defenderBannedHosts = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "sftpgo_defender_banned_hosts",
+		Help: "Number of hosts currently banned by the defender",
+	})
+
+	// loginFailuresElevatedAccounts is the metric that reports the number of accounts
+	// currently in the elevated state because of too many login failures
+	loginFailuresElevatedAccounts = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "sftpgo_login_failures_elevated_accounts",
+		Help: "Number of accounts currently in the elevated state because of too many login failures",
+	})
+
 	// totalUploads is the metric that reports the total number of successful uploads
 	totalUploads = promauto.NewCounter(prometheus.CounterOpts{
 		Name: "sftpgo_uploads_total",
@@ -114,6 +213,164 @@ var (
 		Help: "The total number of clients disconnected for inactivity before trying to login",
 	})
 
+	// totalSecondFactorLockouts is the metric that reports the total number of second
+	// factor verifications locked out after too many failed attempts
+	totalSecondFactorLockouts = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sftpgo_second_factor_lockouts_total",
+		Help: "The total number of second factor verifications locked out after too many failed attempts",
+	})
+
+	// totalExternalAuthCacheHits is the metric that reports the total number of
+	// authentications served from the external auth cache
+	totalExternalAuthCacheHits = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sftpgo_external_auth_cache_hits_total",
+		Help: "The total number of authentications served from the external auth cache",
+	})
+
+	// totalExternalAuthCacheMisses is the metric that reports the total number of
+	// authentications not found in the external auth cache
+	totalExternalAuthCacheMisses = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sftpgo_external_auth_cache_misses_total",
+		Help: "The total number of authentications not found in the external auth cache",
+	})
+
+	// totalUserCacheHits is the metric that reports the total number of
+	// user lookups served from the user cache
+	totalUserCacheHits = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sftpgo_user_cache_hits_total",
+		Help: "The total number of user lookups served from the user cache",
+	})
+
+	// totalUserCacheMisses is the metric that reports the total number of
+	// user lookups not found in the user cache
+	totalUserCacheMisses = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sftpgo_user_cache_misses_total",
+		Help: "The total number of user lookups not found in the user cache",
+	})
+
+	// totalHookTimeouts is the metric that reports the total number of hook
+	// executions that exceeded the configured timeout, partitioned by hook
+	totalHookTimeouts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sftpgo_hook_timeouts_total",
+		Help: "The total number of hook executions that timed out",
+	}, []string{"hook"})
+
+	// uploadScanDuration is the metric that reports the time spent to execute
+	// the upload scan hook, partitioned by result: accepted, rejected or error
+	uploadScanDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sftpgo_upload_scan_duration_seconds",
+		Help:    "The time spent to execute the upload scan hook, added to the upload close latency",
+		Buckets: []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"result"})
+
+	// sftpOperationsLatency is the metric that reports the latency for SFTP operations,
+	// partitioned by operation and filesystem provider. The buckets cover local
+	// filesystem operations completed in microseconds up to slow cloud storage requests
+	sftpOperationsLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "sftpgo_sftp_operation_duration_seconds",
+		Help: "The time spent to handle SFTP operations, from request receipt to response",
+		Buckets: []float64{0.00005, 0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05,
+			0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+	}, []string{"operation", "fs_provider"})
+
+	// observers for the SFTP operations latency with pre-resolved labels,
+	// indexed by operation and filesystem provider
+	sftpOperationsObservers = newSFTPOperationsObservers()
+	// operationsLatencyEnabled allows to disable the SFTP operations latency metric
+	operationsLatencyEnabled atomic.Bool
+
+	// sftpfsPoolConnections is the metric that reports the number of connections
+	// owned by the SFTP filesystem pools, partitioned by state: idle or in use
+	sftpfsPoolConnections = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sftpgo_sftpfs_pool_connections",
+		Help: "Number of connections owned by the SFTP filesystem pools by state",
+	}, []string{"state"})
+
+	// pre-resolved gauges for sftpfsPoolConnections
+	sftpfsPoolIdleConnections  = sftpfsPoolConnections.WithLabelValues("idle")
+	sftpfsPoolInUseConnections = sftpfsPoolConnections.WithLabelValues("in_use")
+
+	// sftpfsPoolWaiters is the metric that reports the number of requests waiting
+	// for a connection from an exhausted SFTP filesystem pool
+	sftpfsPoolWaiters = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "sftpgo_sftpfs_pool_waiters",
+		Help: "Number of requests waiting for a connection from the SFTP filesystem pools",
+	})
+
+	// sftpfsPoolCheckoutDuration is the metric that reports the time spent to check
+	// out a connection from an SFTP filesystem pool, partitioned by result: ok or error.
+	// It includes the wait for a free connection and the connection setup, if required
+	sftpfsPoolCheckoutDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sftpgo_sftpfs_pool_checkout_duration_seconds",
+		Help:    "The time spent to check out a connection from the SFTP filesystem pools",
+		Buckets: []float64{0.0001, 0.001, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+	}, []string{"result"})
+
+	// bufferPoolBytes is the metric that reports the size of the buffers owned by
+	// the shared transfer buffers pool, partitioned by state: idle or in use
+	bufferPoolBytes = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sftpgo_buffer_pool_bytes",
+		Help: "Size of the buffers owned by the transfer buffers pool by state",
+	}, []string{"state"})
+
+	// pre-resolved gauges for bufferPoolBytes
+	bufferPoolIdleBytes  = bufferPoolBytes.WithLabelValues("idle")
+	bufferPoolInUseBytes = bufferPoolBytes.WithLabelValues("in_use")
+
+	// bufferPoolMaxBytes is the metric that reports the maximum size for the
+	// transfer buffers pool, 0 means the pool is disabled
+	bufferPoolMaxBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "sftpgo_buffer_pool_max_bytes",
+		Help: "Maximum size of the transfer buffers pool",
+	})
+
+	// bufferPoolWaiters is the metric that reports the number of transfers waiting
+	// for a buffer from the exhausted transfer buffers pool
+	bufferPoolWaiters = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "sftpgo_buffer_pool_waiters",
+		Help: "Number of transfers waiting for a buffer from the transfer buffers pool",
+	})
+
+	// bufferPoolWaits is the metric that reports the total number of waits for a
+	// buffer from the exhausted transfer buffers pool, partitioned by result: ok
+	// or timeout. After a timeout the buffer is allocated outside the pool
+	bufferPoolWaits = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sftpgo_buffer_pool_waits_total",
+		Help: "The total number of waits for a buffer from the transfer buffers pool",
+	}, []string{"result"})
+
+	// sftpdAuxiliaryFilesHealth is the metric that reports the health of the
+	// auxiliary files and hooks used by the SFTP service, 1 healthy, 0 unhealthy
+	sftpdAuxiliaryFilesHealth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sftpgo_sftpd_auxiliary_file_healthy",
+		Help: "Health of the auxiliary files and hooks used by the SFTP service, 1 healthy, 0 unhealthy",
+	}, []string{"kind", "path"})
+
+	// totalSSHClientVersionMatches is the metric that reports the total number of SSH
+	// connections matching a client version rule, partitioned by rule action
+	totalSSHClientVersionMatches = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sftpgo_ssh_client_version_matches_total",
+		Help: "The total number of SSH connections matching a client version rule",
+	}, []string{"action"})
+
+	// totalAuditRecordsDropped is the metric that reports the total number of dropped audit records
+	totalAuditRecordsDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sftpgo_audit_records_dropped_total",
+		Help: "The total number of audit log records dropped",
+	})
+
+	// totalEventStreamDropped is the metric that reports the total number of dropped event stream events
+	totalEventStreamDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sftpgo_event_stream_dropped_total",
+		Help: "The total number of event stream events dropped",
+	})
+
+	// totalSyslogDropped is the metric that reports the total number of log messages not sent to syslog
+	totalSyslogDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sftpgo_syslog_dropped_total",
+		Help: "The total number of log messages not sent to syslog",
+	})
+
 	// totalLoginOK is the metric that reports the total number of successful logins
 	totalLoginOK = promauto.NewCounter(prometheus.CounterOpts{
 		Name: "sftpgo_login_ok_total",
@@ -350,6 +607,13 @@ var (
 		Help: "The total S3 download size as bytes, partial downloads are included",
 	})
 
+	// totalS3AbortedMultipartUploads is the metric that reports the total number of incomplete
+	// S3 multipart uploads aborted by the periodic cleanup
+	totalS3AbortedMultipartUploads = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sftpgo_s3_aborted_multipart_uploads_total",
+		Help: "The total number of incomplete S3 multipart uploads aborted by the periodic cleanup",
+	})
+
 	// totalS3ListObjects is the metric that reports the total successful S3 list objects requests
 	totalS3ListObjects = promauto.NewCounter(prometheus.CounterOpts{
 		Name: "sftpgo_s3_list_objects",
@@ -637,6 +901,31 @@ var (
 		Name: "sftpgo_httpfs_download_size",
 		Help: "The total HTTPFs download size as bytes, partial downloads are included",
 	})
+
+	// totalHTTPFsRequestRetries is the metric that reports the total number of retried HTTPFs requests
+	totalHTTPFsRequestRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sftpgo_httpfs_request_retries_total",
+		Help: "The total number of retried HTTPFs requests",
+	}, []string{"operation"})
+
+	// httpfsCircuitBreakerTransitions is the metric that reports the HTTPFs circuit breakers state changes
+	httpfsCircuitBreakerTransitions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sftpgo_httpfs_circuit_breaker_transitions_total",
+		Help: "The total number of HTTPFs circuit breaker state changes by new state",
+	}, []string{"state"})
+
+	// httpfsOpenCircuitBreakers is the metric that reports the number of HTTPFs circuit breakers not closed
+	httpfsOpenCircuitBreakers = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "sftpgo_httpfs_circuit_breakers_open",
+		Help: "The number of open or half-open HTTPFs circuit breakers",
+	})
+
+	// totalHTTPFsCircuitBreakerRejections is the metric that reports the total number of
+	// HTTPFs requests rejected by an open circuit breaker
+	totalHTTPFsCircuitBreakerRejections = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sftpgo_httpfs_circuit_breaker_rejections_total",
+		Help: "The total number of HTTPFs requests rejected by an open circuit breaker",
+	})
 )
 
 // AddMetricsEndpoint publishes metrics to the specified endpoint
@@ -693,6 +982,12 @@ func S3TransferCompleted(bytes int64, transferKind int, err error) {
 	}
 }
 
+// S3MultipartUploadsAborted updates metrics after the incomplete S3 multipart
+// uploads cleanup
+func S3MultipartUploadsAborted(count int) {
+	totalS3AbortedMultipartUploads.Add(float64(count))
+}
+
 // S3ListObjectsCompleted updates metrics after an S3 list objects request terminates
 func S3ListObjectsCompleted(err error) {
 	if err == nil {
@@ -786,6 +1081,53 @@ func GCSHeadObjectCompleted(err error) {
 	}
 }
 
+// SFTPFsPoolConnectionsUpdated updates the number of idle and in use
+// connections owned by the SFTP filesystem pools
+func SFTPFsPoolConnectionsUpdated(idleDelta, inUseDelta int) {
+	sftpfsPoolIdleConnections.Add(float64(idleDelta))
+	sftpfsPoolInUseConnections.Add(float64(inUseDelta))
+}
+
+// SFTPFsPoolWaitersUpdated updates the number of requests waiting for a
+// pooled SFTP connection
+func SFTPFsPoolWaitersUpdated(delta int) {
+	sftpfsPoolWaiters.Add(float64(delta))
+}
+
+// SFTPFsPoolCheckoutCompleted observes the time spent to check out a pooled
+// SFTP connection
+func SFTPFsPoolCheckoutCompleted(elapsed time.Duration, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	sftpfsPoolCheckoutDuration.WithLabelValues(result).Observe(elapsed.Seconds())
+}
+
+// UpdateBufferPoolSize updates the maximum size and the idle and in use
+// bytes for the transfer buffers pool
+func UpdateBufferPoolSize(maxSize, idle, inUse int64) {
+	bufferPoolMaxBytes.Set(float64(maxSize))
+	bufferPoolIdleBytes.Set(float64(idle))
+	bufferPoolInUseBytes.Set(float64(inUse))
+}
+
+// BufferPoolWaitersUpdated updates the number of transfers waiting for a
+// buffer from the transfer buffers pool
+func BufferPoolWaitersUpdated(delta int) {
+	bufferPoolWaiters.Add(float64(delta))
+}
+
+// BufferPoolWaitCompleted updates the waits for a buffer from the transfer
+// buffers pool
+func BufferPoolWaitCompleted(timedOut bool) {
+	if timedOut {
+		bufferPoolWaits.WithLabelValues("timeout").Inc()
+		return
+	}
+	bufferPoolWaits.WithLabelValues("ok").Inc()
+}
+
 // AZTransferCompleted updates metrics after a Azure upload or a download
 func AZTransferCompleted(bytes int64, transferKind int, err error) {
 	if transferKind == 0 {
@@ -889,6 +1231,29 @@ func HTTPFsTransferCompleted(bytes int64, transferKind int, err error) {
 	}
 }
 
+// HTTPFsRequestRetried updates the metric for the retried HTTPFs requests
+func HTTPFsRequestRetried(operation string) {
+	totalHTTPFsRequestRetries.WithLabelValues(operation).Inc()
+}
+
+// HTTPFsCircuitBreakerStateChanged updates the metrics after an HTTPFs
+// circuit breaker state change
+func HTTPFsCircuitBreakerStateChanged(from, to string) {
+	httpfsCircuitBreakerTransitions.WithLabelValues(to).Inc()
+	if from == "closed" {
+		httpfsOpenCircuitBreakers.Inc()
+	}
+	if to == "closed" {
+		httpfsOpenCircuitBreakers.Dec()
+	}
+}
+
+// HTTPFsCircuitBreakerRejected updates the metric for the HTTPFs requests
+// rejected by an open circuit breaker
+func HTTPFsCircuitBreakerRejected() {
+	totalHTTPFsCircuitBreakerRejections.Inc()
+}
+
 // SSHCommandCompleted update metrics after an SSH command terminates
 func SSHCommandCompleted(err error) {
 	if err == nil {
@@ -907,6 +1272,37 @@ func UpdateDataProviderAvailability(err error) {
 	}
 }
 
+// AddDataProviderReadRouting increments the metric for read operations
+// routed to the specified target
+func AddDataProviderReadRouting(class, target string) {
+	dataproviderReadRouting.WithLabelValues(class, target).Inc()
+}
+
+// UpdateDataProviderReplicaStatus updates the metrics for the health and
+// the replication lag of a read replica
+func UpdateDataProviderReplicaStatus(replica string, healthy bool, lag int64) {
+	if healthy {
+		dataproviderReplicaHealth.WithLabelValues(replica).Set(1)
+	} else {
+		dataproviderReplicaHealth.WithLabelValues(replica).Set(0)
+	}
+	dataproviderReplicaLag.WithLabelValues(replica).Set(float64(lag))
+}
+
+// AddMaintenanceJobRun updates the metrics for a completed maintenance job execution
+func AddMaintenanceJobRun(job string, duration time.Duration, err error) {
+	maintenanceJobRuns.WithLabelValues(job).Inc()
+	maintenanceJobDuration.WithLabelValues(job).Observe(duration.Seconds())
+	if err != nil {
+		maintenanceJobFailures.WithLabelValues(job).Inc()
+	}
+}
+
+// AddMaintenanceJobSkipped increments the metric for skipped maintenance job executions
+func AddMaintenanceJobSkipped(job string) {
+	maintenanceJobSkipped.WithLabelValues(job).Inc()
+}
+
 // AddLoginAttempt increments the metrics for login attempts
 func AddLoginAttempt(authMethod string) {
 	totalLoginAttempts.Inc()
@@ -989,6 +1385,82 @@ func AddNoAuthTried() {
 	totalNoAuthTried.Inc()
 }
 
+// AddSecondFactorLockout increments the metric for second factor
+// verifications locked out after too many failed attempts
+func AddSecondFactorLockout() {
+	totalSecondFactorLockouts.Inc()
+}
+
+// AddExternalAuthCacheHit increments the metric for authentications
+// served from the external auth cache
+func AddExternalAuthCacheHit() {
+	totalExternalAuthCacheHits.Inc()
+}
+
+// AddExternalAuthCacheMiss increments the metric for authentications
+// not found in the external auth cache
+func AddExternalAuthCacheMiss() {
+	totalExternalAuthCacheMisses.Inc()
+}
+
+// AddUserCacheHit increments the metric for user lookups
+// served from the user cache
+func AddUserCacheHit() {
+	totalUserCacheHits.Inc()
+}
+
+// AddUserCacheMiss increments the metric for user lookups
+// not found in the user cache
+func AddUserCacheMiss() {
+	totalUserCacheMisses.Inc()
+}
+
+// AddHookTimeout increments the metric for hook executions that timed out
+func AddHookTimeout(hook string) {
+	totalHookTimeouts.WithLabelValues(hook).Inc()
+}
+
+// UpdateSFTPDAuxiliaryFileHealth sets the health of an auxiliary file or hook
+// used by the SFTP service
+func UpdateSFTPDAuxiliaryFileHealth(kind, path string, healthy bool) {
+	value := 0.0
+	if healthy {
+		value = 1
+	}
+	sftpdAuxiliaryFilesHealth.WithLabelValues(kind, path).Set(value)
+}
+
+// ResetSFTPDAuxiliaryFilesHealth removes the health metric for all the
+// auxiliary files and hooks
+func ResetSFTPDAuxiliaryFilesHealth() {
+	sftpdAuxiliaryFilesHealth.Reset()
+}
+
+// AddSSHClientVersionMatch increments the SSH connections matching a client version rule
+func AddSSHClientVersionMatch(action string) {
+	totalSSHClientVersionMatches.WithLabelValues(action).Inc()
+}
+
+// UploadScanCompleted observes the time spent to execute the upload scan hook
+func UploadScanCompleted(elapsed time.Duration, result string) {
+	uploadScanDuration.WithLabelValues(result).Observe(elapsed.Seconds())
+}
+
+// AddAuditRecordDropped increments the metric for dropped audit records
+func AddAuditRecordDropped() {
+	totalAuditRecordsDropped.Inc()
+}
+
+// AddEventStreamEventDropped increments the metric for dropped event stream events
+func AddEventStreamEventDropped() {
+	totalEventStreamDropped.Inc()
+}
+
+// AddSyslogMessageDropped increments the metric for log messages not sent to syslog
+func AddSyslogMessageDropped() {
+	totalSyslogDropped.Inc()
+}
+
 // HTTPRequestServed increments the metrics for HTTP requests
 func HTTPRequestServed(status int) {
 	totalHTTPRequests.Inc()
@@ -1005,3 +1477,90 @@ func HTTPRequestServed(status int) {
 func UpdateActiveConnectionsSize(size int) {
 	activeConnections.Set(float64(size))
 }
+
+// UpdateActiveSSHConnectionsSize sets the metric for active SSH connections
+func UpdateActiveSSHConnectionsSize(size int) {
+	activeSSHConnections.Set(float64(size))
+}
+
+// AddActiveSession increments the active sessions for the specified protocol
+func AddActiveSession(protocol string) {
+	activeSessions.WithLabelValues(protocol).Inc()
+}
+
+// RemoveActiveSession decrements the active sessions for the specified protocol
+func RemoveActiveSession(protocol string) {
+	activeSessions.WithLabelValues(protocol).Dec()
+}
+
+// AddActiveTransfer increments the active transfers for the specified kind
+func AddActiveTransfer(transferKind int) {
+	if transferKind == 0 {
+		activeUploads.Inc()
+	} else {
+		activeDownloads.Inc()
+	}
+}
+
+// RemoveActiveTransfer decrements the active transfers for the specified kind
+func RemoveActiveTransfer(transferKind int) {
+	if transferKind == 0 {
+		activeUploads.Dec()
+	} else {
+		activeDownloads.Dec()
+	}
+}
+
+// AddClientConnection increments the metric for client connections
+func AddClientConnection() {
+	clientConnections.Inc()
+}
+
+// RemoveClientConnection decrements the metric for client connections
+func RemoveClientConnection() {
+	clientConnections.Dec()
+}
+
+// UpdateDefenderBannedHosts sets the metric for the hosts banned by the defender
+func UpdateDefenderBannedHosts(size int) {
+	defenderBannedHosts.Set(float64(size))
+}
+
+// UpdateLoginFailuresElevatedAccounts sets the metric for the accounts in the
+// elevated state because of too many login failures
+func UpdateLoginFailuresElevatedAccounts(size int) {
+	loginFailuresElevatedAccounts.Set(float64(size))
+}
+
+func newSFTPOperationsObservers() [][]prometheus.Observer {
+	observers := make([][]prometheus.Observer, len(sftpOperationLabels))
+	for op, opLabel := range sftpOperationLabels {
+		observers[op] = make([]prometheus.Observer, len(fsProviderLabels))
+		for provider, providerLabel := range fsProviderLabels {
+			observers[op][provider] = sftpOperationsLatency.WithLabelValues(opLabel, providerLabel)
+		}
+	}
+	return observers
+}
+
+// EnableSFTPOperationsLatency enables or disables the SFTP operations latency metric
+func EnableSFTPOperationsLatency(enabled bool) {
+	operationsLatencyEnabled.Store(enabled)
+}
+
+// IsSFTPOperationsLatencyEnabled returns true if the SFTP operations latency metric is enabled
+func IsSFTPOperationsLatencyEnabled() bool {
+	return operationsLatencyEnabled.Load()
+}
+
+// SFTPOperationCompleted observes the latency for the specified SFTP operation
+// and filesystem provider, start is the time the request was received
+func SFTPOperationCompleted(operation, fsProvider int, start time.Time) {
+	if !operationsLatencyEnabled.Load() {
+		return
+	}
+	if operation < 0 || operation >= len(sftpOperationsObservers) || fsProvider < 0 || fsProvider >= len(fsProviderLabels) {
+		return
+	}
+	sftpOperationsObservers[operation][fsProvider].Observe(time.Since(start).Seconds())
+}
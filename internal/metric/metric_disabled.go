@@ -17,6 +17,8 @@
 package metric
 
 import (
+	"time"
+
 	"github.com/go-chi/chi/v5"
 
 	"github.com/drakkan/sftpgo/v2/internal/version"
@@ -35,6 +37,10 @@ func TransferCompleted(_, _ int64, _ int, _ error, _ bool) {}
 // S3TransferCompleted updates metrics after an S3 upload or a download
 func S3TransferCompleted(_ int64, _ int, _ error) {}
 
+// S3MultipartUploadsAborted updates metrics after the incomplete S3 multipart
+// uploads cleanup
+func S3MultipartUploadsAborted(_ int) {}
+
 // S3ListObjectsCompleted updates metrics after an S3 list objects request terminates
 func S3ListObjectsCompleted(_ error) {}
 
@@ -62,15 +68,64 @@ func GCSDeleteObjectCompleted(_ error) {}
 // GCSHeadBucketCompleted updates metrics after a GCS head bucket request terminates
 func GCSHeadBucketCompleted(_ error) {}
 
+// SFTPFsPoolConnectionsUpdated updates the number of idle and in use
+// connections owned by the SFTP filesystem pools
+func SFTPFsPoolConnectionsUpdated(_, _ int) {}
+
+// SFTPFsPoolWaitersUpdated updates the number of requests waiting for a
+// pooled SFTP connection
+func SFTPFsPoolWaitersUpdated(_ int) {}
+
+// SFTPFsPoolCheckoutCompleted observes the time spent to check out a pooled
+// SFTP connection
+func SFTPFsPoolCheckoutCompleted(_ time.Duration, _ error) {}
+
+// UpdateBufferPoolSize updates the maximum size and the idle and in use
+// bytes for the transfer buffers pool
+func UpdateBufferPoolSize(_, _, _ int64) {}
+
+// BufferPoolWaitersUpdated updates the number of transfers waiting for a
+// buffer from the transfer buffers pool
+func BufferPoolWaitersUpdated(_ int) {}
+
+// BufferPoolWaitCompleted updates the waits for a buffer from the transfer
+// buffers pool
+func BufferPoolWaitCompleted(_ bool) {}
+
 // HTTPFsTransferCompleted updates metrics after an HTTPFs upload or a download
 func HTTPFsTransferCompleted(_ int64, _ int, _ error) {}
 
+// HTTPFsRequestRetried updates the metric for the retried HTTPFs requests
+func HTTPFsRequestRetried(_ string) {}
+
+// HTTPFsCircuitBreakerStateChanged updates the metrics after an HTTPFs
+// circuit breaker state change
+func HTTPFsCircuitBreakerStateChanged(_, _ string) {}
+
+// HTTPFsCircuitBreakerRejected updates the metric for the HTTPFs requests
+// rejected by an open circuit breaker
+func HTTPFsCircuitBreakerRejected() {}
+
 // SSHCommandCompleted update metrics after an SSH command terminates
 func SSHCommandCompleted(_ error) {}
 
 // UpdateDataProviderAvailability updates the metric for the data provider availability
 func UpdateDataProviderAvailability(_ error) {}
 
+// AddDataProviderReadRouting increments the metric for read operations
+// routed to the specified target
+func AddDataProviderReadRouting(_, _ string) {}
+
+// UpdateDataProviderReplicaStatus updates the metrics for the health and
+// the replication lag of a read replica
+func UpdateDataProviderReplicaStatus(_ string, _ bool, _ int64) {}
+
+// AddMaintenanceJobRun updates the metrics for a completed maintenance job execution
+func AddMaintenanceJobRun(_ string, _ time.Duration, _ error) {}
+
+// AddMaintenanceJobSkipped increments the metric for skipped maintenance job executions
+func AddMaintenanceJobSkipped(_ string) {}
+
 // AddLoginAttempt increments the metrics for login attempts
 func AddLoginAttempt(_ string) {}
 
@@ -81,8 +136,94 @@ func AddLoginResult(_ string, _ error) {}
 // for inactivity before trying to login
 func AddNoAuthTried() {}
 
+// AddSecondFactorLockout increments the metric for second factor
+// verifications locked out after too many failed attempts
+func AddSecondFactorLockout() {}
+
+// AddExternalAuthCacheHit increments the metric for authentications
+// served from the external auth cache
+func AddExternalAuthCacheHit() {}
+
+// AddExternalAuthCacheMiss increments the metric for authentications
+// not found in the external auth cache
+func AddExternalAuthCacheMiss() {}
+
+// AddUserCacheHit increments the metric for user lookups
+// served from the user cache
+func AddUserCacheHit() {}
+
+// AddUserCacheMiss increments the metric for user lookups
+// not found in the user cache
+func AddUserCacheMiss() {}
+
+// AddHookTimeout increments the metric for hook executions that timed out
+func AddHookTimeout(_ string) {}
+
+// UpdateSFTPDAuxiliaryFileHealth sets the health of an auxiliary file or hook
+// used by the SFTP service
+func UpdateSFTPDAuxiliaryFileHealth(_, _ string, _ bool) {}
+
+// ResetSFTPDAuxiliaryFilesHealth removes the health metric for all the
+// auxiliary files and hooks
+func ResetSFTPDAuxiliaryFilesHealth() {}
+
+// AddSSHClientVersionMatch increments the SSH connections matching a client version rule
+func AddSSHClientVersionMatch(_ string) {}
+
+// UploadScanCompleted observes the time spent to execute the upload scan hook
+func UploadScanCompleted(_ time.Duration, _ string) {}
+
+// AddAuditRecordDropped increments the metric for dropped audit records
+func AddAuditRecordDropped() {}
+
+// AddEventStreamEventDropped increments the metric for dropped event stream events
+func AddEventStreamEventDropped() {}
+
+// AddSyslogMessageDropped increments the metric for log messages not sent to syslog
+func AddSyslogMessageDropped() {}
+
 // HTTPRequestServed increments the metrics for HTTP requests
 func HTTPRequestServed(_ int) {}
 
 // UpdateActiveConnectionsSize sets the metric for active connections
 func UpdateActiveConnectionsSize(_ int) {}
+
+// UpdateActiveSSHConnectionsSize sets the metric for active SSH connections
+func UpdateActiveSSHConnectionsSize(_ int) {}
+
+// AddActiveSession increments the active sessions for the specified protocol
+func AddActiveSession(_ string) {}
+
+// RemoveActiveSession decrements the active sessions for the specified protocol
+func RemoveActiveSession(_ string) {}
+
+// AddActiveTransfer increments the active transfers for the specified kind
+func AddActiveTransfer(_ int) {}
+
+// RemoveActiveTransfer decrements the active transfers for the specified kind
+func RemoveActiveTransfer(_ int) {}
+
+// AddClientConnection increments the metric for client connections
+func AddClientConnection() {}
+
+// RemoveClientConnection decrements the metric for client connections
+func RemoveClientConnection() {}
+
+// UpdateDefenderBannedHosts sets the metric for the hosts banned by the defender
+func UpdateDefenderBannedHosts(_ int) {}
+
+// UpdateLoginFailuresElevatedAccounts sets the metric for the accounts in the
+// elevated state because of too many login failures
+func UpdateLoginFailuresElevatedAccounts(_ int) {}
+
+// EnableSFTPOperationsLatency enables or disables the SFTP operations latency metric
+func EnableSFTPOperationsLatency(_ bool) {}
+
+// IsSFTPOperationsLatencyEnabled returns true if the SFTP operations latency metric is enabled
+func IsSFTPOperationsLatencyEnabled() bool {
+	return false
+}
+
+// SFTPOperationCompleted observes the latency for the specified SFTP operation
+// and filesystem provider, start is the time the request was received
+func SFTPOperationCompleted(_, _ int, _ time.Time) {}
@@ -27,6 +27,7 @@ import (
 var (
 	totpConfigs   []*TOTPConfig
 	serviceStatus ServiceStatus
+	lockoutConfig LockoutConfig
 )
 
 // ServiceStatus defines the service status
@@ -40,10 +41,45 @@ func GetStatus() ServiceStatus {
 	return serviceStatus
 }
 
+// LockoutConfig defines the lockout policy for failed one-time code verifications
+type LockoutConfig struct {
+	// Consecutive failed verifications, for TOTP passcodes or recovery codes, after
+	// which the verification is locked out. 0 means disabled
+	MaxAttempts int `json:"max_attempts" mapstructure:"max_attempts"`
+	// Lockout duration as minutes. The failed attempts counter is also reset if no
+	// verification fails for this time
+	Cooldown int `json:"cooldown" mapstructure:"cooldown"`
+	// If enabled, a defender event is generated for verifications refused
+	// because of the lockout
+	GenerateDefenderEvents bool `json:"generate_defender_events" mapstructure:"generate_defender_events"`
+}
+
+// IsEnabled returns true if the lockout is enabled
+func (c *LockoutConfig) IsEnabled() bool {
+	return c.MaxAttempts > 0
+}
+
+// GetCooldown returns the lockout duration
+func (c *LockoutConfig) GetCooldown() time.Duration {
+	return time.Duration(c.Cooldown) * time.Minute
+}
+
+func (c *LockoutConfig) validate() error {
+	if c.MaxAttempts < 0 {
+		return fmt.Errorf("invalid max attempts: %d", c.MaxAttempts)
+	}
+	if c.IsEnabled() && c.Cooldown < 1 {
+		return fmt.Errorf("invalid cooldown: %d", c.Cooldown)
+	}
+	return nil
+}
+
 // Config defines configuration parameters for Multi-Factor authentication modules
 type Config struct {
 	// Time-based one time passwords configurations
 	TOTP []TOTPConfig `json:"totp" mapstructure:"totp"`
+	// Lockout policy for failed verifications
+	Lockout LockoutConfig `json:"lockout" mapstructure:"lockout"`
 }
 
 // Initialize configures the MFA support
@@ -51,6 +87,10 @@ func (c *Config) Initialize() error {
 	totpConfigs = nil
 	serviceStatus.IsActive = false
 	serviceStatus.TOTPConfigs = nil
+	if err := c.Lockout.validate(); err != nil {
+		return fmt.Errorf("invalid lockout config: %w", err)
+	}
+	lockoutConfig = c.Lockout
 	totp := make(map[string]bool)
 	for _, totpConfig := range c.TOTP {
 		totpConfig := totpConfig //pin
@@ -71,6 +111,11 @@ func (c *Config) Initialize() error {
 	return nil
 }
 
+// GetLockoutConfig returns the lockout policy for failed verifications
+func GetLockoutConfig() LockoutConfig {
+	return lockoutConfig
+}
+
 // GetAvailableTOTPConfigs returns the available TOTP configs
 func GetAvailableTOTPConfigs() []*TOTPConfig {
 	return totpConfigs
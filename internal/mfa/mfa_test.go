@@ -109,6 +109,39 @@ func TestMFAConfig(t *testing.T) {
 	stopCleanupTicker()
 }
 
+func TestLockoutConfig(t *testing.T) {
+	config := Config{
+		TOTP: []TOTPConfig{
+			{
+				Name:   "config1",
+				Issuer: "SFTPGo",
+				Algo:   TOTPAlgoSHA1,
+			},
+		},
+		Lockout: LockoutConfig{
+			MaxAttempts: -1,
+		},
+	}
+	err := config.Initialize()
+	assert.Error(t, err)
+	config.Lockout.MaxAttempts = 3
+	err = config.Initialize()
+	assert.Error(t, err)
+	config.Lockout.Cooldown = 10
+	err = config.Initialize()
+	assert.NoError(t, err)
+	lockout := GetLockoutConfig()
+	assert.True(t, lockout.IsEnabled())
+	assert.Equal(t, 10*time.Minute, lockout.GetCooldown())
+	config.Lockout = LockoutConfig{}
+	err = config.Initialize()
+	assert.NoError(t, err)
+	lockout = GetLockoutConfig()
+	assert.False(t, lockout.IsEnabled())
+
+	stopCleanupTicker()
+}
+
 func TestGenerateQRCodeFromURL(t *testing.T) {
 	_, err := GenerateQRCodeFromURL("http://foo\x7f.cloud", 200, 200)
 	assert.Error(t, err)
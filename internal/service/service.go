@@ -56,6 +56,9 @@ type Service struct {
 	LogCompress       bool
 	LogLevel          string
 	LogUTCTime        bool
+	LogSyslogAddress  string
+	LogSyslogFacility string
+	LogSyslogOnly     bool
 	LoadDataClean     bool
 	LoadDataFrom      string
 	LoadDataMode      int
@@ -86,15 +89,25 @@ func (s *Service) initLogger() {
 			logger.DisableLogger()
 		}
 	}
+	if s.LogSyslogAddress != "" {
+		err := logger.EnableSyslogOutput(logger.SyslogConfig{
+			Address:  s.LogSyslogAddress,
+			Facility: s.LogSyslogFacility,
+			Only:     s.LogSyslogOnly,
+		})
+		if err != nil {
+			logger.ErrorToConsole("unable to enable the syslog output: %v", err)
+		}
+	}
 }
 
 // Start initializes and starts the service
 func (s *Service) Start(disableAWSInstallationCode bool) error {
 	s.initLogger()
 	logger.Info(logSender, "", "starting SFTPGo %s, config dir: %s, config file: %s, log max size: %d log max backups: %d "+
-		"log max age: %d log level: %s, log compress: %t, log utc time: %t, load data from: %q, grace time: %d secs",
-		version.GetAsString(), s.ConfigDir, s.ConfigFile, s.LogMaxSize, s.LogMaxBackups, s.LogMaxAge, s.LogLevel,
-		s.LogCompress, s.LogUTCTime, s.LoadDataFrom, graceTime)
+		"log max age: %d log level: %s, log compress: %t, log utc time: %t, log syslog address: %q, load data from: %q, "+
+		"grace time: %d secs", version.GetAsString(), s.ConfigDir, s.ConfigFile, s.LogMaxSize, s.LogMaxBackups, s.LogMaxAge,
+		s.LogLevel, s.LogCompress, s.LogUTCTime, s.LogSyslogAddress, s.LoadDataFrom, graceTime)
 	// in portable mode we don't read configuration from file
 	if s.PortableMode != 1 {
 		err := config.LoadConfig(s.ConfigDir, s.ConfigFile)
@@ -398,6 +411,10 @@ func (s *Service) restoreDump(dump *dataprovider.BackupData) error {
 	if err != nil {
 		return fmt.Errorf("unable to restore event rules from file %q: %v", s.LoadDataFrom, err)
 	}
+	err = httpd.RestoreUserTemplates(dump.UserTemplates, s.LoadDataFrom, s.LoadDataMode, dataprovider.ActionExecutorSystem, "", "")
+	if err != nil {
+		return fmt.Errorf("unable to restore user templates from file %q: %v", s.LoadDataFrom, err)
+	}
 	return nil
 }
 
@@ -209,7 +209,8 @@ func configurePortableSFTPService(port int, enabledSSHCommands []string) {
 		// dynamic ports starts from 49152
 		sftpdConf.Bindings[0].Port = 49152 + rand.Intn(15000)
 	} else {
-		sftpdConf.Bindings[0].Port = 0
+		// port 0 means a port chosen by the OS, a negative port disables the binding
+		sftpdConf.Bindings[0].Port = -1
 	}
 	if slices.Contains(enabledSSHCommands, "*") {
 		sftpdConf.EnabledSSHCommands = sftpd.GetSupportedSSHCommands()
@@ -33,6 +33,7 @@ import (
 	"github.com/drakkan/sftpgo/v2/internal/plugin"
 	"github.com/drakkan/sftpgo/v2/internal/sftpd"
 	"github.com/drakkan/sftpgo/v2/internal/telemetry"
+	"github.com/drakkan/sftpgo/v2/internal/tracing"
 	"github.com/drakkan/sftpgo/v2/internal/webdavd"
 )
 
@@ -138,6 +139,8 @@ loop:
 			s.Service.Stop()
 			plugin.Handler.Cleanup()
 			common.WaitForTransfers(graceTime)
+			logger.CloseAuditLogger()
+			tracing.Shutdown()
 			break loop
 		case svc.ParamChange:
 			logger.Debug(logSender, "", "Received reload request")
@@ -167,7 +170,7 @@ loop:
 			}
 			err = sftpd.Reload()
 			if err != nil {
-				logger.Warn(logSender, "", "error reloading sftpd revoked certificates: %v", err)
+				logger.Warn(logSender, "", "error reloading sftpd certificates: %v", err)
 			}
 		case rotateLogCmd:
 			logger.Debug(logSender, "", "Received log file rotation request")
@@ -175,6 +178,16 @@ loop:
 			if err != nil {
 				logger.Warn(logSender, "", "error rotating log file: %v", err)
 			}
+			if logger.IsAuditLogEnabled() {
+				if err := logger.RotateAuditLogFile(); err != nil {
+					logger.Warn(logSender, "", "error rotating audit log file: %v", err)
+				}
+			}
+			if logger.IsEventStreamEnabled() {
+				if err := logger.RotateEventStreamFile(); err != nil {
+					logger.Warn(logSender, "", "error rotating event stream file: %v", err)
+				}
+			}
 		default:
 			continue loop
 		}
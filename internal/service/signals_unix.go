@@ -29,6 +29,7 @@ import (
 	"github.com/drakkan/sftpgo/v2/internal/plugin"
 	"github.com/drakkan/sftpgo/v2/internal/sftpd"
 	"github.com/drakkan/sftpgo/v2/internal/telemetry"
+	"github.com/drakkan/sftpgo/v2/internal/tracing"
 	"github.com/drakkan/sftpgo/v2/internal/webdavd"
 )
 
@@ -77,7 +78,7 @@ func handleSIGHUP() {
 	}
 	err = sftpd.Reload()
 	if err != nil {
-		logger.Warn(logSender, "", "error reloading sftpd revoked certificates: %v", err)
+		logger.Warn(logSender, "", "error reloading sftpd certificates: %v", err)
 	}
 }
 
@@ -87,11 +88,23 @@ func handleSIGUSR1() {
 	if err != nil {
 		logger.Warn(logSender, "", "error rotating log file: %v", err)
 	}
+	if logger.IsAuditLogEnabled() {
+		if err := logger.RotateAuditLogFile(); err != nil {
+			logger.Warn(logSender, "", "error rotating audit log file: %v", err)
+		}
+	}
+	if logger.IsEventStreamEnabled() {
+		if err := logger.RotateEventStreamFile(); err != nil {
+			logger.Warn(logSender, "", "error rotating event stream file: %v", err)
+		}
+	}
 }
 
 func handleInterrupt() {
 	logger.Debug(logSender, "", "Received interrupt request")
 	plugin.Handler.Cleanup()
 	common.WaitForTransfers(graceTime)
+	logger.CloseAuditLogger()
+	tracing.Shutdown()
 	os.Exit(0)
 }
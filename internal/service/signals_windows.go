@@ -21,6 +21,7 @@ import (
 	"github.com/drakkan/sftpgo/v2/internal/common"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/plugin"
+	"github.com/drakkan/sftpgo/v2/internal/tracing"
 )
 
 func registerSignals() {
@@ -31,6 +32,8 @@ func registerSignals() {
 			logger.Debug(logSender, "", "Received interrupt request")
 			plugin.Handler.Cleanup()
 			common.WaitForTransfers(graceTime)
+			logger.CloseAuditLogger()
+			tracing.Shutdown()
 			os.Exit(0)
 		}
 	}()
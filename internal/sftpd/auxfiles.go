@@ -0,0 +1,289 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package sftpd
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/httpclient"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/metric"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// Supported auxiliary file kinds
+const (
+	AuxiliaryFileLoginBanner             = "login_banner_file"
+	AuxiliaryFileKeyboardInteractiveHook = "keyboard_interactive_auth_hook"
+	AuxiliaryFileRevokedUserCerts        = "revoked_user_certs_file"
+	AuxiliaryFileTrustedUserCAKey        = "trusted_user_ca_key"
+)
+
+const auxiliaryFileUnhealthyEvent = "SFTP auxiliary file unhealthy"
+
+var (
+	auxFilesCheckerMu sync.RWMutex
+	// checker for the active SFTP service
+	auxFilesChecker *auxiliaryFilesChecker
+)
+
+// AuxiliaryFileStatus defines the health status of an auxiliary file or hook
+// used by the SFTP service
+type AuxiliaryFileStatus struct {
+	Kind      string `json:"kind"`
+	Path      string `json:"path"`
+	IsHealthy bool   `json:"is_healthy"`
+	Error     string `json:"error,omitempty"`
+	// last check as unix timestamp in milliseconds
+	LastCheck int64 `json:"last_check"`
+}
+
+// GetLastCheckAsString returns the last check time formatted as RFC 3339 string
+func (s *AuxiliaryFileStatus) GetLastCheckAsString() string {
+	return getMsTimeAsString(s.LastCheck)
+}
+
+type auxiliaryFile struct {
+	kind  string
+	path  string
+	check func(string) error
+}
+
+// auxiliaryFilesChecker periodically checks that the configured auxiliary
+// files and hooks are usable. Each state transition is logged and an unhealthy
+// file fires a certificate event, as for the expiring host certificates
+type auxiliaryFilesChecker struct {
+	sync.RWMutex
+	files     []auxiliaryFile
+	statuses  []AuxiliaryFileStatus
+	scheduler *cron.Cron
+}
+
+func setAuxiliaryFilesChecker(checker *auxiliaryFilesChecker) {
+	auxFilesCheckerMu.Lock()
+	oldChecker := auxFilesChecker
+	auxFilesChecker = checker
+	auxFilesCheckerMu.Unlock()
+
+	if oldChecker != nil && oldChecker != checker {
+		oldChecker.stop()
+	}
+	metric.ResetSFTPDAuxiliaryFilesHealth()
+}
+
+func getAuxiliaryFilesStatus() []AuxiliaryFileStatus {
+	auxFilesCheckerMu.RLock()
+	checker := auxFilesChecker
+	auxFilesCheckerMu.RUnlock()
+
+	if checker == nil {
+		return nil
+	}
+	return checker.getStatuses()
+}
+
+// getAuxiliaryFiles returns the auxiliary files and hooks to check, relative
+// paths are resolved against the configuration directory
+func (c *Configuration) getAuxiliaryFiles(configDir string) []auxiliaryFile {
+	var files []auxiliaryFile
+	resolvePath := func(name string) string {
+		if !filepath.IsAbs(name) {
+			return filepath.Join(configDir, name)
+		}
+		return name
+	}
+	if c.LoginBannerFile != "" {
+		files = append(files, auxiliaryFile{
+			kind:  AuxiliaryFileLoginBanner,
+			path:  resolvePath(c.LoginBannerFile),
+			check: checkReadableFile,
+		})
+	}
+	if c.KeyboardInteractiveHook != "" {
+		files = append(files, auxiliaryFile{
+			kind:  AuxiliaryFileKeyboardInteractiveHook,
+			path:  c.KeyboardInteractiveHook,
+			check: checkKeyboardInteractiveHook,
+		})
+	}
+	if c.RevokedUserCertsFile != "" {
+		files = append(files, auxiliaryFile{
+			kind:  AuxiliaryFileRevokedUserCerts,
+			path:  resolvePath(c.RevokedUserCertsFile),
+			check: checkReadableFile,
+		})
+	}
+	for _, keyPath := range c.TrustedUserCAKeys {
+		if strings.TrimSpace(keyPath) == "" {
+			continue
+		}
+		files = append(files, auxiliaryFile{
+			kind:  AuxiliaryFileTrustedUserCAKey,
+			path:  resolvePath(keyPath),
+			check: checkReadableFile,
+		})
+	}
+	return files
+}
+
+// initializeAuxiliaryFilesChecker checks the auxiliary files and schedules the
+// periodic checks, if enabled
+func (c *Configuration) initializeAuxiliaryFilesChecker(configDir string) error {
+	files := c.getAuxiliaryFiles(configDir)
+	if c.AuxiliaryFilesCheckInterval <= 0 || len(files) == 0 {
+		setAuxiliaryFilesChecker(nil)
+		return nil
+	}
+	checker := &auxiliaryFilesChecker{
+		files: files,
+	}
+	setAuxiliaryFilesChecker(checker)
+	return checker.start(time.Duration(c.AuxiliaryFilesCheckInterval) * time.Second)
+}
+
+func (c *auxiliaryFilesChecker) start(interval time.Duration) error {
+	c.check()
+
+	scheduler := cron.New(cron.WithLocation(time.UTC), cron.WithLogger(cron.DiscardLogger))
+	if _, err := scheduler.AddFunc(fmt.Sprintf("@every %s", interval), c.check); err != nil {
+		return fmt.Errorf("unable to schedule the auxiliary files check: %w", err)
+	}
+	scheduler.Start()
+
+	c.Lock()
+	c.scheduler = scheduler
+	c.Unlock()
+	return nil
+}
+
+func (c *auxiliaryFilesChecker) stop() {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.scheduler != nil {
+		c.scheduler.Stop()
+		c.scheduler = nil
+	}
+}
+
+func (c *auxiliaryFilesChecker) getStatuses() []AuxiliaryFileStatus {
+	c.RLock()
+	defer c.RUnlock()
+
+	result := make([]AuxiliaryFileStatus, len(c.statuses))
+	copy(result, c.statuses)
+	return result
+}
+
+func (c *auxiliaryFilesChecker) check() {
+	c.RLock()
+	oldStatuses := c.statuses
+	c.RUnlock()
+
+	now := time.Now()
+	statuses := make([]AuxiliaryFileStatus, 0, len(c.files))
+	for idx, f := range c.files {
+		status := AuxiliaryFileStatus{
+			Kind:      f.kind,
+			Path:      f.path,
+			IsHealthy: true,
+			LastCheck: util.GetTimeAsMsSinceEpoch(now),
+		}
+		err := f.check(f.path)
+		if err != nil {
+			status.IsHealthy = false
+			status.Error = err.Error()
+		}
+		metric.UpdateSFTPDAuxiliaryFileHealth(f.kind, f.path, status.IsHealthy)
+		// the first check notifies unhealthy files only
+		wasHealthy := true
+		if idx < len(oldStatuses) {
+			wasHealthy = oldStatuses[idx].IsHealthy
+		}
+		switch {
+		case wasHealthy && !status.IsHealthy:
+			logger.Warn(logSender, "", "%s %q is unhealthy: %v", f.kind, f.path, err)
+			params := common.EventParams{
+				Name:      f.path,
+				Event:     auxiliaryFileUnhealthyEvent,
+				Status:    2,
+				Timestamp: now,
+			}
+			params.AddError(fmt.Errorf("%s %q is unhealthy: %w", f.kind, f.path, err))
+			common.HandleCertificateEvent(params)
+		case !wasHealthy && status.IsHealthy:
+			logger.Info(logSender, "", "%s %q is healthy again", f.kind, f.path)
+		}
+		statuses = append(statuses, status)
+	}
+
+	c.Lock()
+	c.statuses = statuses
+	c.Unlock()
+}
+
+// checkReadableFile returns an error if the specified path is not a regular
+// file that can be opened for reading
+func checkReadableFile(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%q is not a regular file", name)
+	}
+	return nil
+}
+
+// checkKeyboardInteractiveHook checks that the hook program exists and is
+// executable. For HTTP hooks a HEAD request is sent, server errors make the
+// hook unhealthy
+func checkKeyboardInteractiveHook(hook string) error {
+	if strings.HasPrefix(hook, "http") {
+		resp, err := httpclient.Head(hook)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode >= http.StatusInternalServerError {
+			return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+		}
+		return nil
+	}
+	info, err := os.Stat(hook)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%q is not a regular file", hook)
+	}
+	return checkExecutable(info)
+}
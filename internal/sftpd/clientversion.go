@@ -0,0 +1,192 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package sftpd
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"regexp"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/metric"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// Supported actions for the client version rules
+const (
+	ClientVersionActionDeny     = "deny"
+	ClientVersionActionThrottle = "throttle"
+	ClientVersionActionLog      = "log"
+)
+
+const clientVersionRegexpPrefix = "regexp:"
+
+// ClientVersionRule defines an action for the SSH clients whose identification
+// string, for example "SSH-2.0-Go", matches one of the configured patterns.
+// The rules are checked in order and the first matching rule is applied, the
+// check happens after the key exchange and before any authentication attempt
+type ClientVersionRule struct {
+	// Patterns to match against the client version. Shell patterns are supported,
+	// for example "SSH-2.0-Go*", use the "regexp:" prefix for regular expressions,
+	// for example "regexp:^SSH-2\.0-libssh_0\.[0-8]\."
+	Patterns []string `json:"patterns" mapstructure:"patterns"`
+	// Action to apply to the matching clients:
+	// - "deny", the connection is closed. The optional message is sent to the client
+	// - "throttle", the connection is delayed, or closed, using the configured rate limits
+	// - "log", the connection is logged and accepted
+	Action string `json:"action" mapstructure:"action"`
+	// Message sent, as authentication banner, to the denied clients
+	Message string `json:"message" mapstructure:"message"`
+	// Average, Period and Burst define the per-source rate limit for the
+	// throttle action, see the rate limiters configuration for details
+	Average int64 `json:"average" mapstructure:"average"`
+	Period  int64 `json:"period" mapstructure:"period"`
+	Burst   int   `json:"burst" mapstructure:"burst"`
+	// If enabled and the defender is enabled, a "limit exceeded" defender
+	// event is generated for each matching connection
+	GenerateDefenderEvents bool `json:"generate_defender_events" mapstructure:"generate_defender_events"`
+	globs                  []string
+	regexps                []*regexp.Regexp
+	limiter                *common.SourceRateLimiter
+}
+
+func (r *ClientVersionRule) initialize() error {
+	r.globs = nil
+	r.regexps = nil
+	r.limiter = nil
+	if len(r.Patterns) == 0 {
+		return fmt.Errorf("client version rule without patterns")
+	}
+	for _, pattern := range r.Patterns {
+		if expr, ok := strings.CutPrefix(pattern, clientVersionRegexpPrefix); ok {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return fmt.Errorf("invalid client version regular expression %q: %w", expr, err)
+			}
+			r.regexps = append(r.regexps, re)
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid client version pattern %q: %w", pattern, err)
+		}
+		r.globs = append(r.globs, pattern)
+	}
+	switch r.Action {
+	case ClientVersionActionDeny, ClientVersionActionLog:
+	case ClientVersionActionThrottle:
+		limiter, err := common.NewSourceRateLimiter(common.RateLimiterConfig{
+			Average: r.Average,
+			Period:  r.Period,
+			Burst:   r.Burst,
+		})
+		if err != nil {
+			return fmt.Errorf("invalid client version throttle for patterns %v: %w", r.Patterns, err)
+		}
+		r.limiter = limiter
+	default:
+		return fmt.Errorf("invalid client version action %q", r.Action)
+	}
+	return nil
+}
+
+func (r *ClientVersionRule) matches(clientVersion string) bool {
+	for _, pattern := range r.globs {
+		if matched, _ := path.Match(pattern, clientVersion); matched {
+			return true
+		}
+	}
+	for _, re := range r.regexps {
+		if re.MatchString(clientVersion) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Configuration) initializeClientVersionRules() error {
+	for idx := range c.ClientVersionRules {
+		rule := &c.ClientVersionRules[idx]
+		if err := rule.initialize(); err != nil {
+			return err
+		}
+		logger.Debug(logSender, "", "client version rule configured, patterns: %v, action: %q",
+			rule.Patterns, rule.Action)
+	}
+	return nil
+}
+
+func (c *Configuration) getClientVersionRule(clientVersion string) *ClientVersionRule {
+	for idx := range c.ClientVersionRules {
+		if c.ClientVersionRules[idx].matches(clientVersion) {
+			return &c.ClientVersionRules[idx]
+		}
+	}
+	return nil
+}
+
+// getServerConfigForConn returns the server configuration to use for the
+// specified connection. If client version rules are defined, a copy of the
+// given configuration that checks the client version is returned
+func (c *Configuration) getServerConfigForConn(config *ssh.ServerConfig, conn net.Conn,
+	ipAddr string,
+) *ssh.ServerConfig {
+	if len(c.ClientVersionRules) == 0 {
+		return config
+	}
+	connConfig := *config
+	connConfig.PreAuthConnCallback = func(preAuthConn ssh.ServerPreAuthConn) {
+		c.checkClientVersion(preAuthConn, conn, ipAddr)
+	}
+	return &connConfig
+}
+
+// checkClientVersion applies the first rule matching the client version, the
+// connection is closed if the client is denied
+func (c *Configuration) checkClientVersion(preAuthConn ssh.ServerPreAuthConn, conn net.Conn, ipAddr string) {
+	clientVersion := util.BytesToString(preAuthConn.ClientVersion())
+	rule := c.getClientVersionRule(clientVersion)
+	if rule == nil {
+		return
+	}
+	metric.AddSSHClientVersionMatch(rule.Action)
+	if rule.GenerateDefenderEvents {
+		common.AddDefenderEvent(ipAddr, common.ProtocolSSH, common.HostEventLimitExceeded)
+	}
+	switch rule.Action {
+	case ClientVersionActionDeny:
+		logger.Info(logSender, "", "connection from ip %q denied, client version %q matches a deny rule",
+			ipAddr, clientVersion)
+		if rule.Message != "" {
+			if err := preAuthConn.SendAuthBanner(rule.Message); err != nil {
+				logger.Debug(logSender, "", "unable to send the deny message to ip %q: %v", ipAddr, err)
+			}
+		}
+		conn.Close()
+	case ClientVersionActionThrottle:
+		if _, err := rule.limiter.Wait(ipAddr, common.ProtocolSSH); err != nil {
+			logger.Info(logSender, "", "connection from ip %q denied, client version %q throttled: %v",
+				ipAddr, clientVersion, err)
+			conn.Close()
+		}
+	default:
+		logger.Info(logSender, "", "connection from ip %q, client version %q matches a log rule",
+			ipAddr, clientVersion)
+	}
+}
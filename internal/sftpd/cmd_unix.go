@@ -17,6 +17,7 @@
 package sftpd
 
 import (
+	"fmt"
 	"os"
 	"os/exec"
 	"syscall"
@@ -35,3 +36,12 @@ func wrapCmd(cmd *exec.Cmd, uid, gid int) *exec.Cmd {
 	}
 	return cmd
 }
+
+// checkExecutable returns an error if the file described by info cannot be
+// executed by anyone
+func checkExecutable(info os.FileInfo) error {
+	if info.Mode().Perm()&0111 == 0 {
+		return fmt.Errorf("%q is not executable", info.Name())
+	}
+	return nil
+}
@@ -15,9 +15,15 @@
 package sftpd
 
 import (
+	"os"
 	"os/exec"
 )
 
 func wrapCmd(cmd *exec.Cmd, _, _ int) *exec.Cmd {
 	return cmd
 }
+
+// checkExecutable is a no-op on Windows, the executable bits are not available
+func checkExecutable(_ os.FileInfo) error {
+	return nil
+}
@@ -0,0 +1,97 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package sftpd
+
+import (
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+// maximum number of paths tracked for a dropbox session, the paths uploaded
+// after reaching this limit cannot be stat
+const maxDropboxPaths = 10000
+
+// dropboxPaths tracks the files uploaded and the directories created, within
+// an SFTP session, inside the paths with the dropbox permission
+type dropboxPaths struct {
+	sync.RWMutex
+	paths map[string]struct{}
+}
+
+func (p *dropboxPaths) add(virtualPath string) {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.paths == nil {
+		p.paths = make(map[string]struct{})
+	}
+	if len(p.paths) < maxDropboxPaths {
+		p.paths[virtualPath] = struct{}{}
+	}
+}
+
+func (p *dropboxPaths) has(virtualPath string) bool {
+	p.RLock()
+	defer p.RUnlock()
+
+	_, ok := p.paths[virtualPath]
+	return ok
+}
+
+// addDropboxPath tracks the specified path, uploaded or created within this
+// session, if its parent directory has the dropbox permission
+func (c *Connection) addDropboxPath(virtualPath string) {
+	virtualPath = util.CleanPath(virtualPath)
+	if c.User.IsDropboxPath(path.Dir(virtualPath)) {
+		c.dropbox.add(virtualPath)
+	}
+}
+
+// getDropboxEmptyListing returns the listing for a directory with the dropbox
+// permission, only the "." and ".." entries are included
+func (c *Connection) getDropboxEmptyListing(virtualPath string) listerAt {
+	modTime := time.Unix(0, 0)
+	entries := []os.FileInfo{vfs.NewFileInfo(".", true, 0, modTime, false)}
+	if util.CleanPath(virtualPath) != "/" {
+		entries = append(entries, vfs.NewFileInfo("..", true, 0, modTime, false))
+	}
+	return listerAt(entries)
+}
+
+// getDropboxStat returns the sanitized stat result for a path inside a
+// directory with the dropbox permission. Only the start directory and the paths
+// uploaded or created within this session can be stat, any other path does not
+// exist, so clients checking the upload target before writing it don't fail
+func (c *Connection) getDropboxStat(virtualPath string, mode int) (os.FileInfo, error) {
+	virtualPath = util.CleanPath(virtualPath)
+	if virtualPath == util.CleanPath(c.User.Filters.StartDirectory) {
+		return vfs.NewFileInfo(path.Base(virtualPath), true, 0, time.Unix(0, 0), false), nil
+	}
+	if !c.dropbox.has(virtualPath) {
+		return nil, sftp.ErrSSHFxNoSuchFile
+	}
+	info, err := c.DoStat(virtualPath, mode, true)
+	if err != nil {
+		return nil, err
+	}
+	return vfs.NewFileInfo(info.Name(), info.IsDir(), info.Size(), info.ModTime(), false), nil
+}
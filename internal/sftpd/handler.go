@@ -15,18 +15,27 @@
 package sftpd
 
 import (
+	"bytes"
+	"errors"
 	"io"
 	"net"
 	"os"
 	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pkg/sftp"
 	"github.com/sftpgo/sdk"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/drakkan/sftpgo/v2/internal/common"
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/metric"
+	"github.com/drakkan/sftpgo/v2/internal/tracing"
 	"github.com/drakkan/sftpgo/v2/internal/util"
 	"github.com/drakkan/sftpgo/v2/internal/vfs"
 )
@@ -41,6 +50,27 @@ type Connection struct {
 	LocalAddr  net.Addr
 	channel    io.ReadWriteCloser
 	command    string
+	// SSH connection details added to the log events, if any
+	logFields *logger.SSHConnectionFields
+	// message of the day configuration, nil if not available
+	motd        *MOTDConfig
+	motdOnce    sync.Once
+	motdContent []byte
+	// maximum number of open handles, 0 means unlimited
+	maxHandles  int
+	openHandles atomic.Int32
+	// session recording configuration and recorder, the recorder is nil
+	// if the session is not recorded
+	recordingConfig *SessionRecordingConfig
+	recorder        *sessionRecorder
+	// paths uploaded or created within the dropbox directories
+	dropbox dropboxPaths
+}
+
+// Log outputs a log entry to the configured logger adding the
+// SSH connection details as structured fields
+func (c *Connection) Log(level logger.LogLevel, format string, v ...any) {
+	logger.ConnectionLog(level, c.GetProtocol(), c.GetID(), c.IsDebugLogForced(), c.logFields, format, v...)
 }
 
 // GetClientVersion returns the connected client's version
@@ -70,9 +100,32 @@ func (c *Connection) GetCommand() string {
 }
 
 // Fileread creates a reader for a file on the system and returns the reader back.
-func (c *Connection) Fileread(request *sftp.Request) (io.ReaderAt, error) {
+func (c *Connection) Fileread(request *sftp.Request) (reader io.ReaderAt, err error) {
+	span := c.startRequestSpan(request)
+	defer func() { c.endRequest(span, request, err) }()
+	defer c.operationCompleted(metric.SFTPOperationOpen, request.Filepath, operationStart())
 	c.UpdateLastActivity()
 
+	releaseHandle, err := c.reserveHandle()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			releaseHandle()
+		}
+	}()
+
+	if c.motd.isVirtualFile(request.Filepath) {
+		return &handleReaderAt{ReaderAt: bytes.NewReader(c.getMOTD()), release: releaseHandle}, nil
+	}
+	if c.IsGeneratedFile(request.Filepath) {
+		r, err := c.OpenGeneratedFile(request.Filepath)
+		if err != nil {
+			return nil, err
+		}
+		return &handleReaderAt{ReaderAt: r, release: releaseHandle}, nil
+	}
 	if !c.User.HasPerm(dataprovider.PermDownload, path.Dir(request.Filepath)) {
 		return nil, sftp.ErrSSHFxPermissionDenied
 	}
@@ -110,6 +163,9 @@ func (c *Connection) Fileread(request *sftp.Request) (io.ReaderAt, error) {
 	baseTransfer := common.NewBaseTransfer(file, c.BaseConnection, cancelFn, p, p, request.Filepath, common.TransferDownload,
 		0, 0, 0, 0, false, fs, transferQuota)
 	t := newTransfer(baseTransfer, nil, r, nil)
+	t.fsProvider = c.getFsProvider(request.Filepath)
+	c.setTransferRecording(t)
+	t.releaseHandle = releaseHandle
 
 	return t, nil
 }
@@ -124,9 +180,27 @@ func (c *Connection) Filewrite(request *sftp.Request) (io.WriterAt, error) {
 	return c.handleFilewrite(request)
 }
 
-func (c *Connection) handleFilewrite(request *sftp.Request) (sftp.WriterAtReaderAt, error) { //nolint:gocyclo
+func (c *Connection) handleFilewrite(request *sftp.Request) (writer sftp.WriterAtReaderAt, err error) { //nolint:gocyclo
+	span := c.startRequestSpan(request)
+	defer func() { c.endRequest(span, request, err) }()
+	defer c.operationCompleted(metric.SFTPOperationOpen, request.Filepath, operationStart())
 	c.UpdateLastActivity()
 
+	if c.isVirtualFile(request.Filepath) {
+		return nil, sftp.ErrSSHFxPermissionDenied
+	}
+	releaseHandle, err := c.reserveHandle()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			releaseHandle()
+		} else if t, ok := writer.(*transfer); ok {
+			t.releaseHandle = releaseHandle
+			c.addDropboxPath(request.Filepath)
+		}
+	}()
 	if err := common.Connections.IsNewTransferAllowed(c.User.Username); err != nil {
 		c.Log(logger.LevelInfo, "denying file write due to transfer count limits")
 		return nil, c.GetPermissionDeniedError()
@@ -143,7 +217,7 @@ func (c *Connection) handleFilewrite(request *sftp.Request) (sftp.WriterAtReader
 	}
 
 	filePath := p
-	if common.Config.IsAtomicUploadEnabled() && fs.IsAtomicUploadSupported() {
+	if common.Config.IsAtomicUploadEnabledFor(fs) {
 		filePath = fs.GetAtomicUploadPath(p)
 	}
 
@@ -187,13 +261,20 @@ func (c *Connection) handleFilewrite(request *sftp.Request) (sftp.WriterAtReader
 
 // Filecmd hander for basic SFTP system calls related to files, but not anything to do with reading
 // or writing to those files.
-func (c *Connection) Filecmd(request *sftp.Request) error {
+func (c *Connection) Filecmd(request *sftp.Request) (err error) {
+	span := c.startRequestSpan(request)
+	defer func() { c.endRequest(span, request, err) }()
 	c.UpdateLastActivity()
 
+	if c.isVirtualFile(request.Filepath) || c.isVirtualFile(request.Target) {
+		return sftp.ErrSSHFxPermissionDenied
+	}
+
 	switch request.Method {
 	case "Setstat":
 		return c.handleSFTPSetstat(request)
 	case "Rename":
+		defer c.operationCompleted(metric.SFTPOperationRename, request.Filepath, operationStart())
 		if err := c.Rename(request.Filepath, request.Target); err != nil {
 			return err
 		}
@@ -204,11 +285,13 @@ func (c *Connection) Filecmd(request *sftp.Request) error {
 		if err != nil {
 			return err
 		}
+		c.addDropboxPath(request.Filepath)
 	case "Symlink":
 		if err := c.CreateSymlink(request.Filepath, request.Target); err != nil {
 			return err
 		}
 	case "Remove":
+		defer c.operationCompleted(metric.SFTPOperationRemove, request.Filepath, operationStart())
 		return c.handleSFTPRemove(request)
 	default:
 		return sftp.ErrSSHFxOpUnsupported
@@ -219,39 +302,50 @@ func (c *Connection) Filecmd(request *sftp.Request) error {
 
 // Filelist is the handler for SFTP filesystem list calls. This will handle calls to list the contents of
 // a directory as well as perform file/folder stat calls.
-func (c *Connection) Filelist(request *sftp.Request) (sftp.ListerAt, error) {
+func (c *Connection) Filelist(request *sftp.Request) (lister sftp.ListerAt, err error) {
+	span := c.startRequestSpan(request)
+	defer func() { c.endRequest(span, request, err) }()
 	c.UpdateLastActivity()
 
 	switch request.Method {
 	case "List":
+		defer c.operationCompleted(metric.SFTPOperationReadDir, request.Filepath, operationStart())
+		if c.User.IsDropboxPath(request.Filepath) {
+			return c.getDropboxEmptyListing(request.Filepath), nil
+		}
+		releaseHandle, err := c.reserveHandle()
+		if err != nil {
+			return nil, err
+		}
 		lister, err := c.ListDir(request.Filepath)
 		if err != nil {
+			releaseHandle()
 			return nil, err
 		}
+		if c.motd != nil && c.motd.VirtualFile != "" && path.Dir(c.motd.VirtualFile) == util.CleanPath(request.Filepath) {
+			lister.Prepend(c.getMOTDFileInfo())
+		}
+		for _, info := range c.GetGeneratedFilesInfo(request.Filepath) {
+			lister.Prepend(info)
+		}
 		modTime := time.Unix(0, 0)
 		if request.Filepath != "/" {
 			lister.Prepend(vfs.NewFileInfo("..", true, 0, modTime, false))
 		}
 		lister.Prepend(vfs.NewFileInfo(".", true, 0, modTime, false))
-		return lister, nil
+		return &handleDirLister{DirListerAt: lister, release: releaseHandle}, nil
 	case "Stat":
-		if !c.User.HasPerm(dataprovider.PermListItems, path.Dir(request.Filepath)) {
-			return nil, sftp.ErrSSHFxPermissionDenied
-		}
-
-		s, err := c.DoStat(request.Filepath, 0, true)
-		if err != nil {
-			return nil, err
-		}
-
-		return listerAt([]os.FileInfo{s}), nil
+		defer c.operationCompleted(metric.SFTPOperationStat, request.Filepath, operationStart())
+		return c.handleSFTPStat(request.Filepath, 0)
 	default:
 		return nil, sftp.ErrSSHFxOpUnsupported
 	}
 }
 
 // Readlink implements the ReadlinkFileLister interface
-func (c *Connection) Readlink(filePath string) (string, error) {
+func (c *Connection) Readlink(filePath string) (target string, err error) {
+	defer func() { c.recordPath("readlink", filePath, target, err) }()
+
 	if err := c.canReadLink(filePath); err != nil {
 		return "", err
 	}
@@ -274,24 +368,23 @@ func (c *Connection) Readlink(filePath string) (string, error) {
 }
 
 // Lstat implements LstatFileLister interface
-func (c *Connection) Lstat(request *sftp.Request) (sftp.ListerAt, error) {
+func (c *Connection) Lstat(request *sftp.Request) (lister sftp.ListerAt, err error) {
+	span := c.startRequestSpan(request)
+	defer func() { c.endRequest(span, request, err) }()
+	defer c.operationCompleted(metric.SFTPOperationStat, request.Filepath, operationStart())
 	c.UpdateLastActivity()
 
-	if !c.User.HasPerm(dataprovider.PermListItems, path.Dir(request.Filepath)) {
-		return nil, sftp.ErrSSHFxPermissionDenied
-	}
-
-	s, err := c.DoStat(request.Filepath, 1, true)
-	if err != nil {
-		return nil, err
-	}
-
-	return listerAt([]os.FileInfo{s}), nil
+	return c.handleSFTPStat(request.Filepath, 1)
 }
 
 // RealPath implements the RealPathFileLister interface
-func (c *Connection) RealPath(p string) (string, error) {
-	if !c.User.HasPerm(dataprovider.PermListItems, path.Dir(p)) {
+func (c *Connection) RealPath(p string) (resolvedPath string, err error) {
+	requestPath := p
+	defer func() { c.recordPath("realpath", requestPath, resolvedPath, err) }()
+
+	// the dropbox users can resolve paths, for example the start directory
+	// on connect, but they cannot list the directory contents
+	if !c.User.HasPerm(dataprovider.PermListItems, path.Dir(p)) && !c.User.IsDropboxPath(path.Dir(p)) {
 		return "", sftp.ErrSSHFxPermissionDenied
 	}
 
@@ -315,7 +408,8 @@ func (c *Connection) RealPath(p string) (string, error) {
 }
 
 // StatVFS implements StatVFSFileCmder interface
-func (c *Connection) StatVFS(r *sftp.Request) (*sftp.StatVFS, error) {
+func (c *Connection) StatVFS(r *sftp.Request) (statvfs *sftp.StatVFS, err error) {
+	defer func() { c.recordPath("statvfs", r.Filepath, "", err) }()
 	c.UpdateLastActivity()
 
 	// we are assuming that r.Filepath is a dir, this could be wrong but should
@@ -328,22 +422,17 @@ func (c *Connection) StatVFS(r *sftp.Request) (*sftp.StatVFS, error) {
 	if err != nil {
 		return nil, err
 	}
-
-	if !quotaResult.HasSpace {
-		return c.getStatVFSFromQuotaResult(fs, p, quotaResult)
-	}
-
-	if quotaResult.QuotaSize == 0 && quotaResult.QuotaFiles == 0 {
-		// no quota restrictions
-		statvfs, err := fs.GetAvailableDiskSize(p)
-		if err == vfs.ErrStorageSizeUnavailable {
-			return c.getStatVFSFromQuotaResult(fs, p, quotaResult)
-		}
-		return statvfs, err
+	statvfs, err = vfs.GetStatVFS(fs, p, quotaResult)
+	if err != nil {
+		return nil, c.GetFsError(fs, err)
 	}
+	return statvfs, nil
+}
 
-	// there is free space but some limits are configured
-	return c.getStatVFSFromQuotaResult(fs, p, quotaResult)
+// isVirtualFile returns true if the specified path is the MOTD or a generated file,
+// virtual files cannot be modified
+func (c *Connection) isVirtualFile(name string) bool {
+	return c.motd.isVirtualFile(name) || c.IsGeneratedFile(name)
 }
 
 func (c *Connection) canReadLink(name string) error {
@@ -357,6 +446,37 @@ func (c *Connection) canReadLink(name string) error {
 	return nil
 }
 
+// handleSFTPStat handles the stat requests, mode 1 means lstat
+func (c *Connection) handleSFTPStat(virtualPath string, mode int) (sftp.ListerAt, error) {
+	if c.User.IsDropboxPath(path.Dir(virtualPath)) {
+		s, err := c.getDropboxStat(virtualPath, mode)
+		if err != nil {
+			return nil, err
+		}
+		return listerAt([]os.FileInfo{s}), nil
+	}
+	if !c.User.HasPerm(dataprovider.PermListItems, path.Dir(virtualPath)) {
+		return nil, sftp.ErrSSHFxPermissionDenied
+	}
+	if c.motd.isVirtualFile(virtualPath) {
+		return listerAt([]os.FileInfo{c.getMOTDFileInfo()}), nil
+	}
+	if c.IsGeneratedFile(virtualPath) {
+		s, err := c.GetGeneratedFileInfo(virtualPath)
+		if err != nil {
+			return nil, err
+		}
+		return listerAt([]os.FileInfo{s}), nil
+	}
+
+	s, err := c.DoStat(virtualPath, mode, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return listerAt([]os.FileInfo{s}), nil
+}
+
 func (c *Connection) handleSFTPSetstat(request *sftp.Request) error {
 	attrs := common.StatAttributes{
 		Flags: 0,
@@ -426,11 +546,13 @@ func (c *Connection) handleSFTPUploadToNewFile(fs vfs.Fs, pflags sftp.FileOpenFl
 	vfs.SetPathPermissions(fs, filePath, c.User.GetUID(), c.User.GetGID())
 
 	// we can get an error only for resume
-	maxWriteSize, _ := c.GetMaxWriteSize(diskQuota, false, 0, fs.IsUploadResumeSupported())
+	maxWriteSize, _ := c.GetMaxWriteSize(diskQuota, false, 0, fs.IsUploadResumeSupported(), requestPath)
 
 	baseTransfer := common.NewBaseTransfer(file, c.BaseConnection, cancelFn, resolvedPath, filePath, requestPath,
 		common.TransferUpload, 0, 0, maxWriteSize, 0, true, fs, transferQuota)
 	t := newTransfer(baseTransfer, w, nil, errForRead)
+	t.fsProvider = c.getFsProvider(requestPath)
+	c.setTransferRecording(t)
 
 	return t, nil
 }
@@ -453,7 +575,7 @@ func (c *Connection) handleSFTPUploadToExistingFile(fs vfs.Fs, pflags sftp.FileO
 	// if there is a size limit the remaining size cannot be 0 here, since quotaResult.HasSpace
 	// will return false in this case and we deny the upload before.
 	// For Cloud FS GetMaxWriteSize will return unsupported operation
-	maxWriteSize, err := c.GetMaxWriteSize(diskQuota, isResume, fileSize, vfs.IsUploadResumeSupported(fs, fileSize))
+	maxWriteSize, err := c.GetMaxWriteSize(diskQuota, isResume, fileSize, vfs.IsUploadResumeSupported(fs, fileSize), requestPath)
 	if err != nil {
 		c.Log(logger.LevelDebug, "unable to get max write size for file %q is resume? %t: %v",
 			requestPath, isResume, err)
@@ -465,7 +587,7 @@ func (c *Connection) handleSFTPUploadToExistingFile(fs vfs.Fs, pflags sftp.FileO
 		return nil, c.GetPermissionDeniedError()
 	}
 
-	if common.Config.IsAtomicUploadEnabled() && fs.IsAtomicUploadSupported() {
+	if common.Config.IsAtomicUploadEnabled() && fs.IsAtomicUploadSupported() && !vfs.HasCloudAtomicUploads(fs) {
 		_, _, err = fs.Rename(resolvedPath, filePath, 0)
 		if err != nil {
 			c.Log(logger.LevelError, "error renaming existing file for atomic upload, source: %q, dest: %q, err: %+v",
@@ -506,6 +628,8 @@ func (c *Connection) handleSFTPUploadToExistingFile(fs vfs.Fs, pflags sftp.FileO
 	baseTransfer := common.NewBaseTransfer(file, c.BaseConnection, cancelFn, resolvedPath, filePath, requestPath,
 		common.TransferUpload, minWriteOffset, initialSize, maxWriteSize, truncatedSize, false, fs, transferQuota)
 	t := newTransfer(baseTransfer, w, nil, errForRead)
+	t.fsProvider = c.getFsProvider(requestPath)
+	c.setTransferRecording(t)
 
 	return t, nil
 }
@@ -519,59 +643,81 @@ func (c *Connection) Disconnect() error {
 	return c.channel.Close()
 }
 
-func (c *Connection) getStatVFSFromQuotaResult(fs vfs.Fs, name string, quotaResult vfs.QuotaCheckResult) (*sftp.StatVFS, error) {
-	s, err := fs.GetAvailableDiskSize(name)
+func (c *Connection) updateQuotaAfterTruncate(requestPath string, fileSize int64) {
+	vfolder, err := c.User.GetVirtualFolderForPath(path.Dir(requestPath))
 	if err == nil {
-		if quotaResult.QuotaSize == 0 || quotaResult.QuotaSize > int64(s.TotalSpace()) {
-			quotaResult.QuotaSize = int64(s.TotalSpace())
+		dataprovider.UpdateUserFolderQuota(&vfolder, &c.User, 0, -fileSize, false)
+		return
+	}
+	dataprovider.UpdateUserQuota(&c.User, 0, -fileSize, false) //nolint:errcheck
+}
+
+// getFsProvider returns the filesystem provider for the specified virtual path.
+// It does not allocate, so it can be used for each SFTP request
+func (c *Connection) getFsProvider(virtualPath string) int {
+	provider := c.User.FsConfig.Provider
+	matchLen := 0
+	for idx := range c.User.VirtualFolders {
+		v := &c.User.VirtualFolders[idx]
+		if len(v.VirtualPath) <= matchLen || !strings.HasPrefix(virtualPath, v.VirtualPath) {
+			continue
 		}
-		if quotaResult.QuotaFiles == 0 || quotaResult.QuotaFiles > int(s.Files) {
-			quotaResult.QuotaFiles = int(s.Files)
+		if len(virtualPath) == len(v.VirtualPath) || virtualPath[len(v.VirtualPath)] == '/' {
+			provider = v.FsConfig.Provider
+			matchLen = len(v.VirtualPath)
 		}
-	} else if err != vfs.ErrStorageSizeUnavailable {
-		return nil, err
-	}
-	// if we are unable to get quota size or quota files we add some arbitrary values
-	if quotaResult.QuotaSize == 0 {
-		quotaResult.QuotaSize = quotaResult.UsedSize + 8*1024*1024*1024*1024 // 8TB
 	}
-	if quotaResult.QuotaFiles == 0 {
-		quotaResult.QuotaFiles = quotaResult.UsedFiles + 1000000 // 1 million
+	return int(provider)
+}
+
+// operationCompleted updates the latency metric for an SFTP operation,
+// a zero start time means the metric is disabled
+func (c *Connection) operationCompleted(operation int, virtualPath string, start time.Time) {
+	if start.IsZero() {
+		return
 	}
+	metric.SFTPOperationCompleted(operation, c.getFsProvider(virtualPath), start)
+}
 
-	bsize := uint64(4096)
-	for bsize > uint64(quotaResult.QuotaSize) {
-		bsize /= 4
+// startRequestSpan starts a tracing span for an SFTP request, as child of
+// the connection span. It returns a no-op span if tracing is disabled
+func (c *Connection) startRequestSpan(request *sftp.Request) trace.Span {
+	if !tracing.IsEnabled() {
+		return tracing.NoopSpan()
+	}
+	attrs := []attribute.KeyValue{
+		attribute.String("sftp.method", request.Method),
+		attribute.String("sftp.path", request.Filepath),
 	}
-	blocks := uint64(quotaResult.QuotaSize) / bsize
-	bfree := uint64(quotaResult.QuotaSize-quotaResult.UsedSize) / bsize
-	files := uint64(quotaResult.QuotaFiles)
-	ffree := uint64(quotaResult.QuotaFiles - quotaResult.UsedFiles)
-	if !quotaResult.HasSpace {
-		bfree = 0
-		ffree = 0
+	if request.Target != "" {
+		attrs = append(attrs, attribute.String("sftp.target", request.Target))
 	}
+	return tracing.StartConnectionChildSpan(c.GetID(), "sftp."+request.Method, attrs...)
+}
 
-	return &sftp.StatVFS{
-		Bsize:   bsize,
-		Frsize:  bsize,
-		Blocks:  blocks,
-		Bfree:   bfree,
-		Bavail:  bfree,
-		Files:   files,
-		Ffree:   ffree,
-		Favail:  ffree,
-		Namemax: 255,
-	}, nil
+// endRequest ends the tracing span for an SFTP request and
+// records the request if the session is recorded
+func (c *Connection) endRequest(span trace.Span, request *sftp.Request, err error) {
+	endRequestSpan(span, err)
+	c.recordRequest(request, err)
 }
 
-func (c *Connection) updateQuotaAfterTruncate(requestPath string, fileSize int64) {
-	vfolder, err := c.User.GetVirtualFolderForPath(path.Dir(requestPath))
-	if err == nil {
-		dataprovider.UpdateUserFolderQuota(&vfolder, &c.User, 0, -fileSize, false)
-		return
+// endRequestSpan ends a span started by startRequestSpan,
+// ErrSSHFxOk is not an error
+func endRequestSpan(span trace.Span, err error) {
+	if errors.Is(err, sftp.ErrSSHFxOk) {
+		err = nil
 	}
-	dataprovider.UpdateUserQuota(&c.User, 0, -fileSize, false) //nolint:errcheck
+	tracing.EndSpan(span, err)
+}
+
+// operationStart returns the start time for an SFTP operation or
+// the zero time if the operations latency metric is disabled
+func operationStart() time.Time {
+	if !metric.IsSFTPOperationsLatencyEnabled() {
+		return time.Time{}
+	}
+	return time.Now()
 }
 
 func getOSOpenFlags(requestFlags sftp.FileOpenFlags) (flags int) {
@@ -0,0 +1,364 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package sftpd
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	hostCertificateExpirationEvent = "Host certificate expiration"
+	// interval to check for algorithms changes in the data provider
+	providerConfigsCheckInterval = "@every 1m"
+)
+
+var (
+	hostKeysMgrMu sync.RWMutex
+	// manager for the active SFTP service, used to reload the host keys
+	hostKeysMgr *hostKeysManager
+)
+
+func setHostKeysManager(m *hostKeysManager) {
+	hostKeysMgrMu.Lock()
+	oldMgr := hostKeysMgr
+	hostKeysMgr = m
+	hostKeysMgrMu.Unlock()
+
+	if oldMgr != nil && oldMgr != m {
+		oldMgr.stop()
+	}
+}
+
+func reloadHostKeys() error {
+	hostKeysMgrMu.RLock()
+	m := hostKeysMgr
+	hostKeysMgrMu.RUnlock()
+
+	if m == nil {
+		return nil
+	}
+	return m.reload()
+}
+
+func checkProviderConfigs() {
+	hostKeysMgrMu.RLock()
+	m := hostKeysMgr
+	hostKeysMgrMu.RUnlock()
+
+	if m != nil {
+		m.checkProviderConfigs()
+	}
+}
+
+func newHostKeyStatus(path string, pubKey ssh.PublicKey, algos []string) HostKey {
+	return HostKey{
+		Path:           path,
+		Fingerprint:    ssh.FingerprintSHA256(pubKey),
+		FingerprintMD5: ssh.FingerprintLegacyMD5(pubKey),
+		Type:           pubKey.Type(),
+		Bits:           getPublicKeySize(pubKey),
+		Algorithms:     algos,
+	}
+}
+
+// getPublicKeySize returns the key size in bits, for certificates
+// the size of the certified key is returned
+func getPublicKeySize(pubKey ssh.PublicKey) int {
+	if cert, ok := pubKey.(*ssh.Certificate); ok {
+		pubKey = cert.Key
+	}
+	cryptoKey, ok := pubKey.(ssh.CryptoPublicKey)
+	if !ok {
+		return 0
+	}
+	switch k := cryptoKey.CryptoPublicKey().(type) {
+	case *rsa.PublicKey:
+		return k.N.BitLen()
+	case *ecdsa.PublicKey:
+		return k.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return 256
+	default:
+		return 0
+	}
+}
+
+// getCertificateValidBefore returns the certificate expiration as unix timestamp
+// in milliseconds, 0 means no expiration
+func getCertificateValidBefore(cert *ssh.Certificate) int64 {
+	if cert.ValidBefore == ssh.CertTimeInfinity || cert.ValidBefore > math.MaxInt64/1000 {
+		return 0
+	}
+	return int64(cert.ValidBefore) * 1000
+}
+
+func (c *Configuration) isHostCertificateExpiring(expiresAt time.Time) bool {
+	if c.HostCertificatesExpirationWarning <= 0 {
+		return false
+	}
+	return expiresAt.Before(time.Now().Add(time.Duration(c.HostCertificatesExpirationWarning) * 24 * time.Hour))
+}
+
+// hostKeysManager holds the server configuration with the loaded host keys and
+// certificates, it allows to reload them and monitors the certificates expiration
+type hostKeysManager struct {
+	sync.RWMutex
+	// serializes the loads and the algorithms updates
+	loadMu sync.Mutex
+	// the configuration is never modified, the host keys are loaded using
+	// a copy with the effective algorithms
+	configuration *Configuration
+	configDir     string
+	// algorithms defined in the configuration file, the ones defined in
+	// the data provider are merged with them
+	fileAlgorithms sshAlgorithms
+	// effective algorithms and last applied provider configs, protected by loadMu
+	algorithms               sshAlgorithms
+	providerConfigsUpdatedAt int64
+	// server configuration without host keys, it is replaced when the
+	// algorithms defined in the data provider change. Protected by loadMu
+	baseConfig *ssh.ServerConfig
+	// server configuration with the loaded host keys and certificates
+	serverConfig *ssh.ServerConfig
+	// certificates already notified as expiring or expired
+	notifiedCerts map[string]bool
+	scheduler     *cron.Cron
+}
+
+func newHostKeysManager(c *Configuration, configDir string, baseConfig *ssh.ServerConfig) *hostKeysManager {
+	return &hostKeysManager{
+		configuration:            c,
+		configDir:                configDir,
+		algorithms:               c.getAlgorithms(),
+		providerConfigsUpdatedAt: c.providerConfigsUpdatedAt,
+		baseConfig:               baseConfig,
+		notifiedCerts:            make(map[string]bool),
+	}
+}
+
+// start loads the host keys and schedules the certificates expiration check
+func (m *hostKeysManager) start() error {
+	if err := m.load(); err != nil {
+		return err
+	}
+	m.checkExpirations()
+
+	scheduler := cron.New(cron.WithLocation(time.UTC), cron.WithLogger(cron.DiscardLogger))
+	if _, err := scheduler.AddFunc("@every 1h", m.checkExpirations); err != nil {
+		return fmt.Errorf("unable to schedule host certificates expiration check: %w", err)
+	}
+	if _, err := scheduler.AddFunc(providerConfigsCheckInterval, m.checkProviderConfigs); err != nil {
+		return fmt.Errorf("unable to schedule provider configs check: %w", err)
+	}
+	scheduler.Start()
+
+	m.Lock()
+	m.scheduler = scheduler
+	m.Unlock()
+	return nil
+}
+
+func (m *hostKeysManager) stop() {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.scheduler != nil {
+		m.scheduler.Stop()
+		m.scheduler = nil
+	}
+}
+
+// load loads the host keys and certificates in a copy of the base server configuration.
+// On error the current server configuration and host keys are preserved
+func (m *hostKeysManager) load() error {
+	m.loadMu.Lock()
+	defer m.loadMu.Unlock()
+
+	conf := *m.configuration
+	conf.setAlgorithms(m.algorithms)
+	serverConfig, hostKeys, err := m.loadHostKeys(&conf, m.baseConfig)
+	if err != nil {
+		return err
+	}
+	m.setServerConfig(serverConfig)
+	updateServiceStatus(func(s *ServiceStatus) {
+		s.HostKeys = hostKeys
+	})
+	setHostKeysFingerprints(hostKeys)
+	return nil
+}
+
+// loadHostKeys loads the host keys and certificates, as defined in the specified
+// configuration, in a copy of the specified base server configuration.
+// Nothing is published
+func (m *hostKeysManager) loadHostKeys(conf *Configuration, baseConfig *ssh.ServerConfig,
+) (*ssh.ServerConfig, []HostKey, error) {
+	serverConfig := *baseConfig
+	hostKeys, err := conf.checkAndLoadHostKeys(m.configDir, &serverConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &serverConfig, hostKeys, nil
+}
+
+// reload reloads the host keys and certificates and clears the expiration warnings
+func (m *hostKeysManager) reload() error {
+	if err := m.load(); err != nil {
+		logger.Warn(logSender, "", "unable to reload host keys: %v", err)
+		return err
+	}
+	logger.Info(logSender, "", "host keys and certificates reloaded")
+
+	m.Lock()
+	m.notifiedCerts = make(map[string]bool)
+	m.Unlock()
+
+	m.checkExpirations()
+	return nil
+}
+
+// checkProviderConfigs applies the algorithms defined in the data provider to
+// new connections if the provider configs changed since the last check.
+// Invalid algorithms are refused and the current configuration is preserved
+func (m *hostKeysManager) checkProviderConfigs() {
+	configs, err := dataprovider.GetConfigs()
+	if err != nil {
+		logger.Warn(logSender, "", "unable to check provider configs: %v", err)
+		return
+	}
+	configs.SetNilsToEmpty()
+
+	m.loadMu.Lock()
+	defer m.loadMu.Unlock()
+
+	if configs.UpdatedAt == m.providerConfigsUpdatedAt {
+		return
+	}
+	m.providerConfigsUpdatedAt = configs.UpdatedAt
+	if err := m.applyProviderConfigs(configs.SFTPD); err != nil {
+		logger.Warn(logSender, "", "unable to apply the algorithms defined in the data provider, the current ones are preserved: %v", err)
+	}
+}
+
+// applyProviderConfigs builds the server configuration for the algorithms defined
+// in the data provider and publishes it, together with the service status, only
+// if the host keys can be loaded
+func (m *hostKeysManager) applyProviderConfigs(configs *dataprovider.SFTPDConfigs) error {
+	newConfiguration := *m.configuration
+	newConfiguration.setAlgorithms(m.fileAlgorithms)
+	newConfiguration.applyProviderConfigs(configs)
+	baseConfig := *m.baseConfig
+	if err := newConfiguration.configureSecurityOptions(&baseConfig); err != nil {
+		return err
+	}
+	algos := newConfiguration.getAlgorithms()
+	if algos.equal(m.algorithms) {
+		return nil
+	}
+	// the algorithms for the host keys depend on the enabled host key algorithms
+	serverConfig, hostKeys, err := m.loadHostKeys(&newConfiguration, &baseConfig)
+	if err != nil {
+		return err
+	}
+	m.algorithms = algos
+	m.baseConfig = &baseConfig
+	m.setServerConfig(serverConfig)
+	var generation int
+	updateServiceStatus(func(s *ServiceStatus) {
+		s.HostKeys = hostKeys
+		newConfiguration.setSecurityOptionsStatus(s)
+		generation = s.ConfigsGeneration
+	})
+	setHostKeysFingerprints(hostKeys)
+	logger.Info(logSender, "", "algorithms defined in the data provider applied to new connections, generation: %d",
+		generation)
+	return nil
+}
+
+func (m *hostKeysManager) setServerConfig(serverConfig *ssh.ServerConfig) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.serverConfig = serverConfig
+}
+
+func (m *hostKeysManager) getServerConfig() *ssh.ServerConfig {
+	m.RLock()
+	defer m.RUnlock()
+
+	return m.serverConfig
+}
+
+// checkExpirations fires a certificate event for expiring or expired host certificates.
+// Each certificate is notified once until the host keys are reloaded. Expired certificates
+// are removed from the ones offered to clients
+func (m *hostKeysManager) checkExpirations() {
+	c := m.configuration
+	now := time.Now()
+	hasExpired := false
+	for _, k := range getHostKeysStatus() {
+		if k.ValidBefore == 0 {
+			continue
+		}
+		expiresAt := util.GetTimeFromMsecSinceEpoch(k.ValidBefore)
+		var err error
+		if expiresAt.Before(now) {
+			hasExpired = true
+			err = fmt.Errorf("host certificate %q expired on %s", k.Path, expiresAt.UTC().Format(time.RFC3339))
+		} else if c.isHostCertificateExpiring(expiresAt) {
+			err = fmt.Errorf("host certificate %q expires on %s", k.Path, expiresAt.UTC().Format(time.RFC3339))
+		} else {
+			continue
+		}
+		logger.Warn(logSender, "", "%v", err)
+
+		m.Lock()
+		isNotified := m.notifiedCerts[k.Path]
+		m.notifiedCerts[k.Path] = true
+		m.Unlock()
+
+		if isNotified {
+			continue
+		}
+		params := common.EventParams{
+			Name:      k.Path,
+			Event:     hostCertificateExpirationEvent,
+			Status:    2,
+			Timestamp: now,
+		}
+		params.AddError(err)
+		common.HandleCertificateEvent(params)
+	}
+	if hasExpired {
+		// expired certificates are skipped while loading
+		if err := m.load(); err != nil {
+			logger.Warn(logSender, "", "unable to remove expired host certificates: %v", err)
+		}
+	}
+}
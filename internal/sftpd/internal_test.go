@@ -16,19 +16,29 @@ package sftpd
 
 import (
 	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/binary"
+	"encoding/pem"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"runtime"
 	"slices"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/eikenb/pipeat"
+	"github.com/pires/go-proxyproto"
 	"github.com/pkg/sftp"
 	"github.com/rs/xid"
 	"github.com/sftpgo/sdk"
@@ -1247,6 +1257,10 @@ func TestSCPParseUploadMessage(t *testing.T) {
 
 	_, _, err = scpCommand.parseUploadMessage(fs, "D0755 0 ")
 	assert.Error(t, err, "parsing upload message with invalid name must fail")
+
+	assert.Equal(t, os.FileMode(0755), getUploadMessageMode("D0755 0 testdir"))
+	assert.Equal(t, os.FileMode(0640), getUploadMessageMode("C0640 6 testfile"))
+	assert.Equal(t, os.FileMode(0), getUploadMessageMode("Cinvalid 6 testfile"))
 }
 
 func TestSCPProtocolMessages(t *testing.T) {
@@ -1882,11 +1896,131 @@ func TestConfigsFromProvider(t *testing.T) {
 	assert.Equal(t, expectedCiphers, c.Ciphers)
 	assert.Equal(t, expectedMACs, c.MACs)
 	assert.Equal(t, expectedPublicKeyAlgos, c.PublicKeyAlgorithms)
+	// override the configured algorithms
+	c = Configuration{
+		HostKeyAlgorithms: []string{ssh.KeyAlgoED25519, ssh.KeyAlgoRSASHA256},
+		KexAlgorithms:     []string{ssh.KeyExchangeCurve25519},
+		Ciphers:           []string{ssh.CipherAES128GCM},
+		MACs:              []string{ssh.HMACSHA256ETM},
+	}
+	configs.SFTPD = &dataprovider.SFTPDConfigs{
+		HostKeyAlgos:  []string{ssh.KeyAlgoED25519},
+		KexAlgorithms: []string{ssh.KeyExchangeDHGEXSHA256, ssh.InsecureKeyExchangeDHGEXSHA1},
+		Ciphers:       []string{ssh.CipherChaCha20Poly1305},
+		MergePolicy:   dataprovider.SFTPDMergePolicyOverride,
+	}
+	err = dataprovider.UpdateConfigs(&configs, "", "", "")
+	assert.NoError(t, err)
+	err = c.loadFromProvider()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{ssh.KeyAlgoED25519}, c.HostKeyAlgorithms)
+	assert.Equal(t, []string{ssh.KeyExchangeDHGEXSHA256, ssh.InsecureKeyExchangeDHGEXSHA1}, c.KexAlgorithms)
+	assert.Equal(t, []string{ssh.CipherChaCha20Poly1305}, c.Ciphers)
+	// empty lists are not overridden
+	assert.Equal(t, []string{ssh.HMACSHA256ETM}, c.MACs)
+	assert.Len(t, c.PublicKeyAlgorithms, 0)
+	savedStatus := swapServiceStatus(GetStatus())
+	t.Cleanup(func() {
+		swapServiceStatus(savedStatus)
+	})
+	serverConfig := &ssh.ServerConfig{}
+	err = c.configureSecurityOptions(serverConfig)
+	assert.NoError(t, err)
+	updateServiceStatus(c.setSecurityOptionsStatus)
+	assert.Equal(t, []string{ssh.KeyAlgoED25519}, GetStatus().HostKeyAlgorithms)
+	assert.Equal(t, c.KexAlgorithms, serverConfig.KeyExchanges)
+	// algorithms not supported by the SSH library are rejected
+	configs.SFTPD.MACs = []string{"unsupported-mac"}
+	err = dataprovider.UpdateConfigs(&configs, "", "", "")
+	assert.Error(t, err)
+	configs.SFTPD.MACs = nil
+	configs.SFTPD.MergePolicy = 3
+	err = dataprovider.UpdateConfigs(&configs, "", "", "")
+	assert.Error(t, err)
 
 	err = dataprovider.UpdateConfigs(nil, "", "", "")
 	assert.NoError(t, err)
 }
 
+func TestApplyProviderConfigs(t *testing.T) {
+	err := dataprovider.UpdateConfigs(nil, "", "", "")
+	require.NoError(t, err)
+	savedStatus := swapServiceStatus(ServiceStatus{})
+	t.Cleanup(func() {
+		swapServiceStatus(savedStatus)
+	})
+
+	keyPath := filepath.Join(os.TempDir(), "host_ed25519_algos")
+	err = util.GenerateEd25519Keys(keyPath)
+	require.NoError(t, err)
+	defer os.Remove(keyPath)
+
+	c := Configuration{
+		HostKeys: []string{keyPath},
+		Ciphers:  []string{ssh.CipherAES128GCM},
+	}
+	fileAlgorithms := c.getAlgorithms()
+	err = c.loadFromProvider()
+	require.NoError(t, err)
+	serverConfig := &ssh.ServerConfig{}
+	err = c.configureSecurityOptions(serverConfig)
+	require.NoError(t, err)
+	updateServiceStatus(c.setSecurityOptionsStatus)
+	m := newHostKeysManager(&c, configDir, serverConfig)
+	m.fileAlgorithms = fileAlgorithms
+	err = m.load()
+	require.NoError(t, err)
+	assert.Equal(t, 1, GetStatus().ConfigsGeneration)
+	assert.Greater(t, GetStatus().ConfigsAppliedAt, int64(0))
+	// the provider configs are unchanged
+	m.checkProviderConfigs()
+	assert.Equal(t, 1, GetStatus().ConfigsGeneration)
+	// the new algorithms are merged with the configured ones
+	configs := dataprovider.Configs{
+		SFTPD: &dataprovider.SFTPDConfigs{
+			Ciphers: []string{ssh.InsecureCipherAES128CBC},
+		},
+	}
+	err = dataprovider.UpdateConfigs(&configs, "", "", "")
+	require.NoError(t, err)
+	m.checkProviderConfigs()
+	assert.Equal(t, 2, GetStatus().ConfigsGeneration)
+	expectedCiphers := []string{ssh.CipherAES128GCM, ssh.InsecureCipherAES128CBC}
+	assert.Equal(t, expectedCiphers, m.algorithms.ciphers)
+	// the server configuration is never modified
+	assert.Equal(t, []string{ssh.CipherAES128GCM}, c.Ciphers)
+	assert.Equal(t, expectedCiphers, GetStatus().Ciphers)
+	assert.Equal(t, expectedCiphers, m.getServerConfig().Ciphers)
+	require.Len(t, GetStatus().HostKeys, 1)
+	// the configs are updated but the algorithms are the same
+	err = dataprovider.UpdateConfigs(&configs, "", "", "")
+	require.NoError(t, err)
+	m.checkProviderConfigs()
+	assert.Equal(t, 2, GetStatus().ConfigsGeneration)
+	// no algorithm can be used with the configured host key, the update is refused
+	configs.SFTPD = &dataprovider.SFTPDConfigs{
+		HostKeyAlgos: []string{ssh.KeyAlgoRSASHA256},
+		Ciphers:      []string{ssh.CipherChaCha20Poly1305},
+		MergePolicy:  dataprovider.SFTPDMergePolicyOverride,
+	}
+	err = dataprovider.UpdateConfigs(&configs, "", "", "")
+	require.NoError(t, err)
+	m.checkProviderConfigs()
+	assert.Equal(t, 2, GetStatus().ConfigsGeneration)
+	assert.Equal(t, expectedCiphers, m.algorithms.ciphers)
+	assert.Equal(t, expectedCiphers, m.getServerConfig().Ciphers)
+	assert.Equal(t, preferredHostKeyAlgos, m.algorithms.hostKeys)
+	assert.Equal(t, expectedCiphers, GetStatus().Ciphers)
+	require.Len(t, GetStatus().HostKeys, 1)
+	assert.Equal(t, keyPath, GetStatus().HostKeys[0].Path)
+	// back to the configured algorithms
+	err = dataprovider.UpdateConfigs(nil, "", "", "")
+	require.NoError(t, err)
+	m.checkProviderConfigs()
+	assert.Equal(t, 3, GetStatus().ConfigsGeneration)
+	assert.Equal(t, []string{ssh.CipherAES128GCM}, m.getServerConfig().Ciphers)
+}
+
 func TestSupportedSecurityOptions(t *testing.T) {
 	c := Configuration{
 		KexAlgorithms: supportedKexAlgos,
@@ -1926,13 +2060,13 @@ func TestLoadHostKeys(t *testing.T) {
 	serverConfig := &ssh.ServerConfig{}
 	c := Configuration{}
 	c.HostKeys = []string{".", "missing file"}
-	err := c.checkAndLoadHostKeys(configDir, serverConfig)
+	_, err := c.checkAndLoadHostKeys(configDir, serverConfig)
 	assert.Error(t, err)
 	testfile := filepath.Join(os.TempDir(), "invalidkey")
 	err = os.WriteFile(testfile, []byte("some bytes"), os.ModePerm)
 	assert.NoError(t, err)
 	c.HostKeys = []string{testfile}
-	err = c.checkAndLoadHostKeys(configDir, serverConfig)
+	_, err = c.checkAndLoadHostKeys(configDir, serverConfig)
 	assert.Error(t, err)
 	err = os.Remove(testfile)
 	assert.NoError(t, err)
@@ -1944,14 +2078,14 @@ func TestLoadHostKeys(t *testing.T) {
 	ed25519KeyName := filepath.Join(keysDir, defaultPrivateEd25519KeyName)
 	nonDefaultKeyName := filepath.Join(keysDir, "akey")
 	c.HostKeys = []string{nonDefaultKeyName, rsaKeyName, ecdsaKeyName, ed25519KeyName}
-	err = c.checkAndLoadHostKeys(configDir, serverConfig)
+	_, err = c.checkAndLoadHostKeys(configDir, serverConfig)
 	assert.Error(t, err)
 	c.HostKeyAlgorithms = []string{ssh.KeyAlgoRSASHA256}
 	c.HostKeys = []string{ecdsaKeyName}
-	err = c.checkAndLoadHostKeys(configDir, serverConfig)
+	_, err = c.checkAndLoadHostKeys(configDir, serverConfig)
 	assert.Error(t, err)
 	c.HostKeyAlgorithms = preferredHostKeyAlgos
-	err = c.checkAndLoadHostKeys(configDir, serverConfig)
+	_, err = c.checkAndLoadHostKeys(configDir, serverConfig)
 	assert.NoError(t, err)
 	assert.FileExists(t, rsaKeyName)
 	assert.FileExists(t, ecdsaKeyName)
@@ -1967,16 +2101,16 @@ func TestLoadHostKeys(t *testing.T) {
 		err = os.Chmod(keysDir, 0551)
 		assert.NoError(t, err)
 		c.HostKeys = nil
-		err = c.checkAndLoadHostKeys(keysDir, serverConfig)
+		_, err = c.checkAndLoadHostKeys(keysDir, serverConfig)
 		assert.Error(t, err)
 		c.HostKeys = []string{rsaKeyName, ecdsaKeyName}
-		err = c.checkAndLoadHostKeys(configDir, serverConfig)
+		_, err = c.checkAndLoadHostKeys(configDir, serverConfig)
 		assert.Error(t, err)
 		c.HostKeys = []string{ecdsaKeyName, rsaKeyName}
-		err = c.checkAndLoadHostKeys(configDir, serverConfig)
+		_, err = c.checkAndLoadHostKeys(configDir, serverConfig)
 		assert.Error(t, err)
 		c.HostKeys = []string{ed25519KeyName}
-		err = c.checkAndLoadHostKeys(configDir, serverConfig)
+		_, err = c.checkAndLoadHostKeys(configDir, serverConfig)
 		assert.Error(t, err)
 		err = os.Chmod(keysDir, 0755)
 		assert.NoError(t, err)
@@ -1985,6 +2119,277 @@ func TestLoadHostKeys(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestHostCertificatesExpiration(t *testing.T) {
+	_, hostPrivKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	hostSigner, err := ssh.NewSignerFromKey(hostPrivKey)
+	require.NoError(t, err)
+	pemBlock, err := ssh.MarshalPrivateKey(hostPrivKey, "")
+	require.NoError(t, err)
+	_, caPrivKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	caSigner, err := ssh.NewSignerFromKey(caPrivKey)
+	require.NoError(t, err)
+
+	writeHostCert := func(certPath string, validBefore time.Time) {
+		cert := &ssh.Certificate{
+			Key:         hostSigner.PublicKey(),
+			CertType:    ssh.HostCert,
+			ValidAfter:  uint64(time.Now().Add(-24 * time.Hour).Unix()),
+			ValidBefore: uint64(validBefore.Unix()),
+		}
+		err := cert.SignCert(rand.Reader, caSigner)
+		require.NoError(t, err)
+		err = os.WriteFile(certPath, ssh.MarshalAuthorizedKey(cert), 0600)
+		require.NoError(t, err)
+	}
+
+	keyPath := filepath.Join(os.TempDir(), "host_ed25519")
+	err = os.WriteFile(keyPath, pem.EncodeToMemory(pemBlock), 0600)
+	require.NoError(t, err)
+	certPath := filepath.Join(os.TempDir(), "host_ed25519-cert.pub")
+	validBefore := time.Now().Add(5 * 24 * time.Hour)
+	writeHostCert(certPath, validBefore)
+
+	savedStatus := swapServiceStatus(ServiceStatus{})
+	t.Cleanup(func() {
+		swapServiceStatus(savedStatus)
+	})
+
+	c := Configuration{
+		HostKeys:                          []string{keyPath},
+		HostCertificates:                  []string{certPath},
+		HostKeyAlgorithms:                 preferredHostKeyAlgos,
+		HostCertificatesExpirationWarning: 10,
+	}
+	m := newHostKeysManager(&c, configDir, &ssh.ServerConfig{})
+	assert.Nil(t, m.getServerConfig())
+	err = m.start()
+	require.NoError(t, err)
+	defer m.stop()
+
+	assert.NotNil(t, m.getServerConfig())
+	require.Len(t, GetStatus().HostKeys, 2)
+	assert.Equal(t, int64(0), GetStatus().HostKeys[0].ValidBefore)
+	assert.Equal(t, certPath, GetStatus().HostKeys[1].Path)
+	assert.Equal(t, validBefore.Unix()*1000, GetStatus().HostKeys[1].ValidBefore)
+	assert.Equal(t, ssh.CertAlgoED25519v01, GetStatus().HostKeys[1].Type)
+	assert.Equal(t, 256, GetStatus().HostKeys[1].Bits)
+	assert.True(t, m.notifiedCerts[certPath])
+	// the certificate expires after the warning threshold
+	c.HostCertificatesExpirationWarning = 3
+	err = m.reload()
+	assert.NoError(t, err)
+	require.Len(t, GetStatus().HostKeys, 2)
+	assert.Len(t, m.notifiedCerts, 0)
+	// an expired certificate is removed from the offered host keys
+	validBefore = time.Now().Add(-time.Hour)
+	writeHostCert(certPath, validBefore)
+	updateServiceStatus(func(s *ServiceStatus) {
+		s.HostKeys = slices.Clone(s.HostKeys)
+		s.HostKeys[1].ValidBefore = validBefore.Unix() * 1000
+	})
+	m.checkExpirations()
+	assert.True(t, m.notifiedCerts[certPath])
+	require.Len(t, GetStatus().HostKeys, 1)
+	assert.Equal(t, keyPath, GetStatus().HostKeys[0].Path)
+	// reload errors must preserve the loaded host keys
+	c.HostKeys = []string{"missing file"}
+	err = m.reload()
+	assert.Error(t, err)
+	require.Len(t, GetStatus().HostKeys, 1)
+	assert.Equal(t, keyPath, GetStatus().HostKeys[0].Path)
+
+	assert.Equal(t, int64(0), getCertificateValidBefore(&ssh.Certificate{ValidBefore: ssh.CertTimeInfinity}))
+	assert.True(t, c.isHostCertificateExpiring(time.Now().Add(24*time.Hour)))
+	c.HostCertificatesExpirationWarning = 0
+	assert.False(t, c.isHostCertificateExpiring(time.Now().Add(24*time.Hour)))
+
+	err = os.Remove(keyPath)
+	assert.NoError(t, err)
+	err = os.Remove(certPath)
+	assert.NoError(t, err)
+}
+
+func TestHostKeysFromProvider(t *testing.T) {
+	t.Cleanup(func() {
+		err := dataprovider.UpdateConfigs(nil, "", "", "")
+		assert.NoError(t, err)
+	})
+
+	_, privKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	pemBlock, err := ssh.MarshalPrivateKeyWithPassphrase(privKey, "", []byte("passphrase"))
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(privKey)
+	require.NoError(t, err)
+	configs := dataprovider.Configs{
+		SFTPD: &dataprovider.SFTPDConfigs{
+			HostKeys: []dataprovider.SFTPDHostKey{
+				{
+					Name:       "encrypted",
+					PrivateKey: kms.NewPlainSecret(string(pem.EncodeToMemory(pemBlock))),
+					Passphrase: kms.NewPlainSecret("passphrase"),
+				},
+			},
+		},
+	}
+	err = dataprovider.UpdateConfigs(&configs, "", "", "")
+	require.NoError(t, err)
+
+	c := Configuration{
+		HostKeys:          []string{"provider://default_rsa", "provider://encrypted", "provider://test_ed25519"},
+		HostKeyAlgorithms: preferredHostKeyAlgos,
+	}
+	hostKeys, err := c.checkAndLoadHostKeys(configDir, &ssh.ServerConfig{})
+	require.NoError(t, err)
+	require.Len(t, hostKeys, 3)
+	fingerprints := make(map[string]string)
+	for _, k := range hostKeys {
+		fingerprints[k.Path] = k.Fingerprint
+		assert.NotEmpty(t, k.Algorithms)
+	}
+	assert.Equal(t, ssh.FingerprintSHA256(signer.PublicKey()), fingerprints["provider://encrypted"])
+
+	configs, err = dataprovider.GetConfigs()
+	require.NoError(t, err)
+	require.Len(t, configs.SFTPD.HostKeys, 3)
+	for _, k := range configs.SFTPD.HostKeys {
+		assert.True(t, k.PrivateKey.IsEncrypted())
+	}
+	configs.PrepareForRendering()
+	for _, k := range configs.SFTPD.HostKeys {
+		assert.Empty(t, k.PrivateKey.GetKey())
+	}
+	// the generated keys are loaded again and not regenerated
+	hostKeys, err = c.checkAndLoadHostKeys(configDir, &ssh.ServerConfig{})
+	require.NoError(t, err)
+	require.Len(t, hostKeys, 3)
+	for _, k := range hostKeys {
+		assert.Equal(t, fingerprints[k.Path], k.Fingerprint)
+	}
+	// the type for missing keys must be detectable from the name
+	c.HostKeys = []string{"provider://custom"}
+	_, err = c.checkAndLoadHostKeys(configDir, &ssh.ServerConfig{})
+	assert.ErrorContains(t, err, "cannot be detected")
+	c.HostKeys = []string{"provider://"}
+	_, err = c.checkAndLoadHostKeys(configDir, &ssh.ServerConfig{})
+	assert.Error(t, err)
+
+	assert.Equal(t, "rsa", getProviderHostKeyType("default_rsa"))
+	assert.Equal(t, "ecdsa", getProviderHostKeyType("default_ecdsa"))
+	assert.Equal(t, "ed25519", getProviderHostKeyType("ed25519"))
+	assert.Empty(t, getProviderHostKeyType("default"))
+}
+
+func TestHostKeyPassphrases(t *testing.T) {
+	savedStatus := swapServiceStatus(ServiceStatus{})
+	t.Cleanup(func() {
+		swapServiceStatus(savedStatus)
+	})
+
+	_, privKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	pemBlock, err := ssh.MarshalPrivateKeyWithPassphrase(privKey, "", []byte("secret passphrase"))
+	require.NoError(t, err)
+	keyPath := filepath.Join(os.TempDir(), "encrypted_ed25519")
+	err = os.WriteFile(keyPath, pem.EncodeToMemory(pemBlock), 0600)
+	require.NoError(t, err)
+	passphrasePath := filepath.Join(os.TempDir(), "host_key_passphrase")
+	err = os.WriteFile(passphrasePath, []byte("secret passphrase\n"), 0600)
+	require.NoError(t, err)
+
+	c := Configuration{
+		HostKeys:          []string{keyPath},
+		HostKeyAlgorithms: preferredHostKeyAlgos,
+	}
+	_, err = c.checkAndLoadHostKeys(configDir, &ssh.ServerConfig{})
+	assert.ErrorContains(t, err, "a passphrase is required")
+	c.HostKeyPassphrases = []string{"wrong passphrase"}
+	_, err = c.checkAndLoadHostKeys(configDir, &ssh.ServerConfig{})
+	assert.ErrorContains(t, err, fmt.Sprintf("invalid passphrase for host key %q", keyPath))
+	c.HostKeyPassphrases = []string{"env://SFTPGO_TEST_HOST_KEY_PASSPHRASE"}
+	_, err = c.checkAndLoadHostKeys(configDir, &ssh.ServerConfig{})
+	assert.ErrorContains(t, err, "is not set")
+	t.Setenv("SFTPGO_TEST_HOST_KEY_PASSPHRASE", "secret passphrase")
+	hostKeys, err := c.checkAndLoadHostKeys(configDir, &ssh.ServerConfig{})
+	assert.NoError(t, err)
+	require.Len(t, hostKeys, 1)
+	signer, err := ssh.NewSignerFromKey(privKey)
+	require.NoError(t, err)
+	assert.Equal(t, ssh.FingerprintSHA256(signer.PublicKey()), hostKeys[0].Fingerprint)
+	c.HostKeyPassphrases = []string{"file://" + passphrasePath}
+	_, err = c.checkAndLoadHostKeys(configDir, &ssh.ServerConfig{})
+	assert.NoError(t, err)
+	c.HostKeyPassphrases = []string{"file://missing"}
+	_, err = c.checkAndLoadHostKeys(configDir, &ssh.ServerConfig{})
+	assert.Error(t, err)
+	// generated host keys are encrypted if a passphrase is configured
+	generatedPath := filepath.Join(os.TempDir(), defaultPrivateECDSAKeyName)
+	c.HostKeys = []string{keyPath, generatedPath}
+	c.HostKeyPassphrases = []string{"secret passphrase", "generated passphrase"}
+	hostKeys, err = c.checkAndLoadHostKeys(configDir, &ssh.ServerConfig{})
+	assert.NoError(t, err)
+	assert.Len(t, hostKeys, 2)
+	generatedBytes, err := os.ReadFile(generatedPath)
+	assert.NoError(t, err)
+	_, err = ssh.ParsePrivateKey(generatedBytes)
+	var errMissing *ssh.PassphraseMissingError
+	assert.ErrorAs(t, err, &errMissing)
+	_, err = ssh.ParsePrivateKeyWithPassphrase(generatedBytes, []byte("generated passphrase"))
+	assert.NoError(t, err)
+
+	for _, f := range []string{keyPath, passphrasePath, generatedPath, generatedPath + ".pub"} {
+		err = os.Remove(f)
+		assert.NoError(t, err)
+	}
+}
+
+func TestHostKeysGenerationParameters(t *testing.T) {
+	rsaKeyPath := filepath.Join(os.TempDir(), defaultPrivateRSAKeyName)
+	ecdsaKeyPath := filepath.Join(os.TempDir(), defaultPrivateECDSAKeyName)
+	c := Configuration{
+		HostKeys:          []string{rsaKeyPath, ecdsaKeyPath},
+		HostKeyAlgorithms: preferredHostKeyAlgos,
+		HostKeyRSABits:    1024,
+	}
+	_, err := c.checkAndLoadHostKeys(configDir, &ssh.ServerConfig{})
+	assert.ErrorContains(t, err, "unsupported RSA key size")
+	c.HostKeyRSABits = 2048
+	c.HostKeyECDSACurve = "P-224"
+	_, err = c.checkAndLoadHostKeys(configDir, &ssh.ServerConfig{})
+	assert.ErrorContains(t, err, "unsupported ECDSA curve")
+	assert.NoFileExists(t, rsaKeyPath)
+	c.HostKeyECDSACurve = "P-384"
+	hostKeys, err := c.checkAndLoadHostKeys(configDir, &ssh.ServerConfig{})
+	require.NoError(t, err)
+	require.Len(t, hostKeys, 2)
+
+	privateBytes, err := os.ReadFile(rsaKeyPath)
+	assert.NoError(t, err)
+	rsaKey, err := ssh.ParseRawPrivateKey(privateBytes)
+	require.NoError(t, err)
+	assert.Equal(t, 2048, rsaKey.(*rsa.PrivateKey).N.BitLen())
+	privateBytes, err = os.ReadFile(ecdsaKeyPath)
+	assert.NoError(t, err)
+	ecdsaKey, err := ssh.ParsePrivateKey(privateBytes)
+	require.NoError(t, err)
+	assert.Equal(t, ssh.KeyAlgoECDSA384, ecdsaKey.PublicKey().Type())
+	// the key details are returned for the service status
+	assert.Equal(t, ssh.KeyAlgoRSA, hostKeys[0].Type)
+	assert.Equal(t, 2048, hostKeys[0].Bits)
+	assert.Equal(t, ssh.KeyAlgoECDSA384, hostKeys[1].Type)
+	assert.Equal(t, 384, hostKeys[1].Bits)
+	assert.Equal(t, ssh.FingerprintSHA256(ecdsaKey.PublicKey()), hostKeys[1].Fingerprint)
+	assert.Equal(t, ssh.FingerprintLegacyMD5(ecdsaKey.PublicKey()), hostKeys[1].FingerprintMD5)
+	assert.Len(t, strings.Split(hostKeys[1].FingerprintMD5, ":"), 16)
+
+	for _, f := range []string{rsaKeyPath, rsaKeyPath + ".pub", ecdsaKeyPath, ecdsaKeyPath + ".pub"} {
+		err = os.Remove(f)
+		assert.NoError(t, err)
+	}
+}
+
 func TestCertCheckerInitErrors(t *testing.T) {
 	c := Configuration{}
 	c.TrustedUserCAKeys = []string{".", "missing file"}
@@ -2064,17 +2469,233 @@ func TestListernerAcceptErrors(t *testing.T) {
 	errFake := errors.New("a fake error")
 	listener := newFakeListener(errFake)
 	c := Configuration{}
-	err := c.serve(listener, nil)
+	err := c.serve(listener, nil, nil)
 	require.EqualError(t, err, errFake.Error())
 	err = listener.Close()
 	require.NoError(t, err)
 
 	errNetFake := &fakeNetError{error: errFake}
 	listener = newFakeListener(errNetFake)
-	err = c.serve(listener, nil)
+	status := newListenerStatus(Binding{Address: "127.0.0.1", Port: 2022})
+	err = c.serve(listener, nil, status)
 	require.EqualError(t, err, errFake.Error())
 	err = listener.Close()
 	require.NoError(t, err)
+
+	listenerInfo := status.get()
+	assert.Equal(t, "127.0.0.1:2022", listenerInfo.Address)
+	assert.NotEmpty(t, listenerInfo.ListenAddress)
+	assert.False(t, listenerInfo.IsActive)
+	assert.Greater(t, listenerInfo.StartedAt, int64(0))
+	assert.Equal(t, int64(10), listenerInfo.AcceptErrors)
+	assert.Equal(t, errFake.Error(), listenerInfo.LastError)
+	assert.Greater(t, listenerInfo.LastErrorAt, int64(0))
+	assert.NotEmpty(t, listenerInfo.GetLastErrorAtAsString())
+
+	status.addConnections(2)
+	status.addConnections(-1)
+	setListeners([]*listenerStatus{status})
+	defer setListeners(nil)
+	serviceStatus := GetStatus()
+	require.Len(t, serviceStatus.Listeners, 1)
+	assert.Equal(t, int32(1), serviceStatus.Listeners[0].Connections)
+}
+
+func TestEphemeralPortBinding(t *testing.T) {
+	b := Binding{
+		Address: "127.0.0.1",
+	}
+	assert.True(t, b.IsValid())
+	b.Port = -1
+	assert.False(t, b.IsValid())
+
+	b.Port = 0
+	savedProxyProtocol := common.Config.ProxyProtocol
+	savedStatus := swapServiceStatus(ServiceStatus{
+		Bindings: []Binding{b},
+	})
+	t.Cleanup(func() {
+		swapServiceStatus(savedStatus)
+		common.Config.ProxyProtocol = savedProxyProtocol
+		setListeners(nil)
+	})
+	status := newListenerStatus(b)
+	setListeners([]*listenerStatus{status})
+	assert.Len(t, GetListenerAddresses(), 0)
+
+	listener, err := net.Listen("tcp", b.GetAddress())
+	require.NoError(t, err)
+	port := listener.Addr().(*net.TCPAddr).Port
+	assert.Greater(t, port, 0)
+	// the port is reported also if the listener is wrapped
+	common.Config.ProxyProtocol = 1
+	proxyListener, err := common.Config.GetProxyListener(listener)
+	require.NoError(t, err)
+
+	c := Configuration{}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- c.serve(proxyListener, nil, status)
+	}()
+	assert.Eventually(t, func() bool {
+		return len(GetListenerAddresses()) == 1
+	}, 1*time.Second, 50*time.Millisecond)
+	assert.Equal(t, []string{listener.Addr().String()}, GetListenerAddresses())
+	currentStatus := GetStatus()
+	require.Len(t, currentStatus.Bindings, 1)
+	assert.Equal(t, port, currentStatus.Bindings[0].Port)
+	require.Len(t, currentStatus.Listeners, 1)
+	assert.Equal(t, port, currentStatus.Listeners[0].Port)
+	assert.True(t, currentStatus.Listeners[0].IsActive)
+	// the configured binding is not modified
+	serviceStatusMu.RLock()
+	assert.Equal(t, 0, serviceStatus.Bindings[0].Port)
+	serviceStatusMu.RUnlock()
+
+	err = proxyListener.Close()
+	assert.NoError(t, err)
+	assert.Error(t, <-errCh)
+	assert.False(t, status.get().IsActive)
+}
+
+func TestBindingProxyProtocol(t *testing.T) {
+	savedProxyProtocol := common.Config.ProxyProtocol
+	t.Cleanup(func() {
+		common.Config.ProxyProtocol = savedProxyProtocol
+	})
+
+	common.Config.ProxyProtocol = 1
+	b := Binding{
+		Port:             2022,
+		ApplyProxyConfig: true,
+	}
+	assert.Equal(t, 1, b.GetProxyProtocol())
+	b.ProxyProtocol = 2
+	assert.Equal(t, 2, b.GetProxyProtocol())
+	assert.Equal(t, 2, newListenerStatus(b).get().ProxyProtocol)
+	common.Config.ProxyProtocol = 0
+	assert.True(t, b.HasProxy())
+	b.ApplyProxyConfig = false
+	assert.False(t, b.HasProxy())
+	assert.Equal(t, 0, newListenerStatus(b).get().ProxyProtocol)
+
+	// connections without the proxy header are dropped before the SSH handshake
+	// if the header is required
+	server, client := net.Pipe()
+	go func() {
+		_, _ = client.Write([]byte("SSH-2.0-client\r\n"))
+	}()
+	c := Configuration{}
+	done := make(chan struct{})
+	go func() {
+		c.AcceptInboundConnection(proxyproto.NewConn(server, proxyproto.WithPolicy(proxyproto.REQUIRE)),
+			&ssh.ServerConfig{})
+		close(done)
+	}()
+	data, err := io.ReadAll(client)
+	assert.NoError(t, err)
+	assert.Empty(t, data)
+	<-done
+	client.Close()
+}
+
+func TestBindingNetwork(t *testing.T) {
+	b := Binding{
+		Address: "::1",
+		Port:    2022,
+	}
+	assert.Equal(t, "[::1]:2022", b.GetAddress())
+	assert.Equal(t, "tcp", b.GetNetwork())
+	assert.NoError(t, b.validateNetwork())
+	b.Network = "tcp6"
+	assert.NoError(t, b.validateNetwork())
+	b.Network = "tcp4"
+	assert.Error(t, b.validateNetwork())
+	b.Address = "127.0.0.1"
+	assert.Equal(t, "127.0.0.1:2022", b.GetAddress())
+	assert.NoError(t, b.validateNetwork())
+	b.Network = "tcp6"
+	assert.Error(t, b.validateNetwork())
+	b.Address = "localhost"
+	assert.NoError(t, b.validateNetwork())
+	b.Address = ""
+	assert.NoError(t, b.validateNetwork())
+	b.Network = "udp"
+	assert.Error(t, b.validateNetwork())
+
+	assert.Equal(t, "tcp", getListenerNetwork("tcp", net.IPv6unspecified))
+	assert.Equal(t, "tcp4", getListenerNetwork("tcp", net.IPv4zero))
+	assert.Equal(t, "tcp6", getListenerNetwork("tcp", net.IPv6loopback))
+	assert.Equal(t, "tcp6", getListenerNetwork("tcp6", net.IPv6unspecified))
+	assert.Equal(t, "tcp", getListenerNetwork("tcp", nil))
+
+	assert.Equal(t, "1.2.3.4", util.GetIPFromRemoteAddress("[::ffff:1.2.3.4]:2022"))
+	assert.Equal(t, "1.2.3.4", util.GetIPFromRemoteAddress("::ffff:1.2.3.4"))
+	assert.Equal(t, "2001:db8::1", util.GetIPFromRemoteAddress("[2001:DB8:0::1]:2022"))
+	assert.Equal(t, "invalid", util.GetIPFromRemoteAddress("invalid"))
+
+	b = Binding{
+		Address: "127.0.0.1",
+		Network: "tcp4",
+	}
+	status := newListenerStatus(b)
+	listener, err := b.getListenConfig().Listen(context.Background(), b.GetNetwork(), b.GetAddress())
+	require.NoError(t, err)
+	status.setStarted(listener.Addr())
+	assert.Equal(t, "tcp4", status.get().Network)
+	assert.NoError(t, listener.Close())
+}
+
+func TestBindingSocketOptions(t *testing.T) {
+	b := Binding{
+		Address: "127.0.0.1",
+	}
+	assert.NoError(t, b.validateSocketOptions())
+	for _, invalid := range []Binding{
+		{TCPKeepAlive: 3},
+		{TCPKeepAliveInterval: -1},
+		{TCPKeepAliveCount: -1},
+		{TCPNoDelay: -1},
+		{ReceiveBufferSize: -1},
+		{SendBufferSize: -1},
+	} {
+		assert.Error(t, invalid.validateSocketOptions(), "%+v", invalid)
+	}
+	assert.Nil(t, b.getListenConfig().Control)
+	b.TCPKeepAlive = 1
+	b.TCPKeepAliveInterval = 30
+	b.TCPKeepAliveCount = 3
+	b.TCPNoDelay = 2
+	b.ReceiveBufferSize = 131072
+	b.SendBufferSize = 131072
+	assert.NoError(t, b.validateSocketOptions())
+	listener, err := b.getListenConfig().Listen(context.Background(), "tcp", b.GetAddress())
+	require.NoError(t, err)
+	listener = &socketOptionsListener{
+		Listener: listener,
+		binding:  b,
+	}
+	for _, keepAlive := range []int{1, 2} {
+		b.TCPKeepAlive = keepAlive
+		listener.(*socketOptionsListener).binding = b
+		client, err := net.Dial("tcp", listener.Addr().String())
+		require.NoError(t, err)
+		conn, err := listener.Accept()
+		require.NoError(t, err)
+		_, ok := conn.(*net.TCPConn)
+		assert.True(t, ok)
+		conn.Close()
+		client.Close()
+	}
+	err = listener.Close()
+	assert.NoError(t, err)
+	_, err = listener.Accept()
+	assert.Error(t, err)
+
+	b.logUnsupportedSocketOption("test option", errors.New("unsupported"))
+	b.logUnsupportedSocketOption("test option", errors.New("unsupported"))
+	_, ok := unsupportedSocketOptions.Load(b.GetAddress() + "|test option")
+	assert.True(t, ok)
 }
 
 type fakeNetError struct {
@@ -2148,6 +2769,84 @@ func TestLoadRevokedUserCertsFile(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestReloadRevokedUserCertsError(t *testing.T) {
+	_, hostPrivKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	pemBlock, err := ssh.MarshalPrivateKey(hostPrivKey, "")
+	require.NoError(t, err)
+	keyPath := filepath.Join(os.TempDir(), "reload_host_ed25519")
+	err = os.WriteFile(keyPath, pem.EncodeToMemory(pemBlock), 0600)
+	require.NoError(t, err)
+	revokedCertsPath := filepath.Join(os.TempDir(), "reload_revoked_certs")
+	err = os.WriteFile(revokedCertsPath, []byte(`no json`), 0644)
+	require.NoError(t, err)
+
+	savedStatus := swapServiceStatus(ServiceStatus{})
+	savedRevokedCertsPath := revokedCertManager.filePath
+	revokedCertManager.filePath = revokedCertsPath
+	c := Configuration{
+		HostKeys:          []string{keyPath},
+		HostKeyAlgorithms: preferredHostKeyAlgos,
+	}
+	m := newHostKeysManager(&c, configDir, &ssh.ServerConfig{})
+	err = m.start()
+	require.NoError(t, err)
+	// replace the manager without stopping the one of the running service
+	hostKeysMgrMu.Lock()
+	savedMgr := hostKeysMgr
+	hostKeysMgr = m
+	hostKeysMgrMu.Unlock()
+	t.Cleanup(func() {
+		hostKeysMgrMu.Lock()
+		hostKeysMgr = savedMgr
+		hostKeysMgrMu.Unlock()
+		m.stop()
+		revokedCertManager.filePath = savedRevokedCertsPath
+		swapServiceStatus(savedStatus)
+	})
+	require.Len(t, GetStatus().HostKeys, 1)
+	// the host keys must be reloaded even if the revoked certificates cannot be loaded
+	_, hostPrivKey, err = ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	hostSigner, err := ssh.NewSignerFromKey(hostPrivKey)
+	require.NoError(t, err)
+	pemBlock, err = ssh.MarshalPrivateKey(hostPrivKey, "")
+	require.NoError(t, err)
+	err = os.WriteFile(keyPath, pem.EncodeToMemory(pemBlock), 0600)
+	require.NoError(t, err)
+	err = Reload()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unable to parse revoked user certificate file")
+	}
+	require.Len(t, GetStatus().HostKeys, 1)
+	assert.Equal(t, ssh.FingerprintSHA256(hostSigner.PublicKey()), GetStatus().HostKeys[0].Fingerprint)
+	// both errors are returned
+	c.HostKeys = []string{"missing file"}
+	err = Reload()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unable to parse revoked user certificate file")
+		assert.Contains(t, err.Error(), "missing file")
+	}
+	assert.Equal(t, ssh.FingerprintSHA256(hostSigner.PublicKey()), GetStatus().HostKeys[0].Fingerprint)
+	// the revoked certificates are loaded even if the host keys cannot be reloaded
+	err = os.WriteFile(revokedCertsPath, []byte(`["SHA256:fp"]`), 0644)
+	require.NoError(t, err)
+	err = Reload()
+	if assert.Error(t, err) {
+		assert.NotContains(t, err.Error(), "revoked user certificate")
+	}
+	assert.True(t, revokedCertManager.isRevoked("SHA256:fp"))
+	// restore the revoked certificates loaded for the running service
+	revokedCertManager.filePath = savedRevokedCertsPath
+	err = revokedCertManager.load()
+	assert.NoError(t, err)
+
+	err = os.Remove(keyPath)
+	assert.NoError(t, err)
+	err = os.Remove(revokedCertsPath)
+	assert.NoError(t, err)
+}
+
 func TestMaxUserSessions(t *testing.T) {
 	connection := &Connection{
 		BaseConnection: common.NewBaseConnection(xid.New().String(), common.ProtocolSFTP, "", "", dataprovider.User{
@@ -2287,3 +2986,1120 @@ func TestRsyncArguments(t *testing.T) {
 	args = []string{"--server", "-vlogDtpre.iLsfxCIvu", "--unsupported-option", ".", "/"}
 	assert.False(t, canAcceptRsyncArgs(args))
 }
+
+func TestOperationsLatencyFsProvider(t *testing.T) {
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			HomeDir: os.TempDir(),
+		},
+	}
+	user.FsConfig.Provider = sdk.S3FilesystemProvider
+	user.VirtualFolders = append(user.VirtualFolders, vfs.VirtualFolder{
+		BaseVirtualFolder: vfs.BaseVirtualFolder{
+			FsConfig: vfs.Filesystem{
+				Provider: sdk.LocalFilesystemProvider,
+			},
+		},
+		VirtualPath: "/vdir",
+	})
+	user.VirtualFolders = append(user.VirtualFolders, vfs.VirtualFolder{
+		BaseVirtualFolder: vfs.BaseVirtualFolder{
+			FsConfig: vfs.Filesystem{
+				Provider: sdk.SFTPFilesystemProvider,
+			},
+		},
+		VirtualPath: "/vdir/sub",
+	})
+	conn := &Connection{
+		BaseConnection: common.NewBaseConnection("", common.ProtocolSFTP, "", "", user),
+	}
+	assert.Equal(t, int(sdk.S3FilesystemProvider), conn.getFsProvider("/"))
+	assert.Equal(t, int(sdk.S3FilesystemProvider), conn.getFsProvider("/vdir1/file"))
+	assert.Equal(t, int(sdk.LocalFilesystemProvider), conn.getFsProvider("/vdir"))
+	assert.Equal(t, int(sdk.LocalFilesystemProvider), conn.getFsProvider("/vdir/file"))
+	assert.Equal(t, int(sdk.SFTPFilesystemProvider), conn.getFsProvider("/vdir/sub"))
+	assert.Equal(t, int(sdk.SFTPFilesystemProvider), conn.getFsProvider("/vdir/sub/file"))
+	assert.Equal(t, int(sdk.LocalFilesystemProvider), conn.getFsProvider("/vdir/sub1"))
+	// the metric is not updated for transfers without a filesystem provider
+	tr := &transfer{fsProvider: -1}
+	assert.True(t, tr.operationStart().IsZero())
+}
+
+func TestSessionRecorder(t *testing.T) {
+	c := SessionRecordingConfig{
+		MaxSize: -1,
+	}
+	assert.Error(t, c.initialize(configDir))
+	c.MaxSize = 0
+	assert.NoError(t, c.initialize(configDir))
+	assert.Empty(t, c.pathTemplate)
+	_, err := newSessionRecorder(&c, "user", "id")
+	assert.ErrorIs(t, err, errRecordingNotConfigured)
+	_, err = newSessionRecorder(nil, "user", "id")
+	assert.ErrorIs(t, err, errRecordingNotConfigured)
+	c.PathTemplate = filepath.Join("recordings", "%username%", "%date%", "%connection_id%.jsonl")
+	assert.NoError(t, c.initialize(configDir))
+	assert.Equal(t, filepath.Join(configDir, c.PathTemplate), c.pathTemplate)
+	now := time.Date(2026, 10, 17, 10, 0, 0, 0, time.UTC)
+	assert.Equal(t, filepath.Join(configDir, "recordings", "user", "20261017", "SFTP_id.jsonl"),
+		c.getPath("user", "SFTP_id", now))
+	assert.Equal(t, filepath.Join(configDir, "recordings", "__", "20261017", "a_b.jsonl"),
+		c.getPath("..", "a/b", now))
+
+	recordingsDir := filepath.Join(os.TempDir(), "recordings")
+	c.PathTemplate = filepath.Join(recordingsDir, "%username%", "%connection_id%.jsonl")
+	c.MaxSize = 1
+	assert.NoError(t, c.initialize(configDir))
+	conn := &Connection{
+		BaseConnection: common.NewBaseConnection("id", common.ProtocolSFTP, "", "", dataprovider.User{
+			BaseUser: sdk.BaseUser{
+				Username: "recording_user",
+			},
+			Filters: dataprovider.UserFilters{
+				SessionRecording: true,
+			},
+		}),
+		channel:         &MockChannel{},
+		recordingConfig: &c,
+	}
+	assert.NoError(t, conn.startRecording())
+	if assert.NotNil(t, conn.recorder) {
+		assert.Equal(t, filepath.Join(recordingsDir, "recording_user", "SFTP_id.jsonl"), conn.GetRecordingPath())
+		conn.recordPath("realpath", "/", "/", nil)
+		conn.recordPath("statvfs", "/", "", os.ErrPermission)
+		conn.recorder.maxSize = conn.recorder.size + 10
+		err = conn.recorder.write(&sessionRecord{Op: "stat"})
+		assert.ErrorIs(t, err, errRecordingSizeExceeded)
+		// after a failure the records are discarded
+		assert.NoError(t, conn.recorder.write(&sessionRecord{Op: "stat"}))
+		conn.stopRecording()
+		assert.NoError(t, conn.recorder.close())
+		content, err := os.ReadFile(conn.GetRecordingPath())
+		assert.NoError(t, err)
+		lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+		if assert.Len(t, lines, 3) {
+			assert.Contains(t, lines[0], `"op":"start"`)
+			assert.Contains(t, lines[1], `"op":"realpath"`)
+			assert.Contains(t, lines[2], `"result":"error"`)
+		}
+		// the recorder is closed
+		conn.recorder.failed = false
+		assert.ErrorIs(t, conn.recorder.write(&sessionRecord{Op: "stat"}), errRecordingClosed)
+	}
+	// fail closed
+	c.FailOpen = false
+	c.PathTemplate = filepath.Join(recordingsDir, "%username%", "sub", "%connection_id%.jsonl")
+	assert.NoError(t, c.initialize(configDir))
+	err = os.WriteFile(filepath.Join(recordingsDir, "recording_user", "sub"), []byte("file"), 0600)
+	assert.NoError(t, err)
+	conn.recorder = nil
+	assert.Error(t, conn.startRecording())
+	assert.Nil(t, conn.recorder)
+	c.FailOpen = true
+	assert.NoError(t, conn.startRecording())
+	assert.Nil(t, conn.recorder)
+	// the recording is not enabled for the user
+	conn.User.Filters.SessionRecording = false
+	c.FailOpen = false
+	assert.NoError(t, conn.startRecording())
+	assert.Nil(t, conn.recorder)
+	conn.recordRequest(sftp.NewRequest("Stat", "/"), nil)
+	conn.recordCommand(nil)
+	conn.stopRecording()
+
+	err = os.RemoveAll(recordingsDir)
+	assert.NoError(t, err)
+}
+
+func TestMOTD(t *testing.T) {
+	err := dataprovider.UpdateConfigs(nil, "", "", "")
+	assert.NoError(t, err)
+	m := MOTDConfig{
+		MaxSize: -1,
+	}
+	assert.Error(t, m.initialize(configDir))
+	m.MaxSize = maxMOTDMaxSize + 1
+	assert.Error(t, m.initialize(configDir))
+	m.MaxSize = 0
+	m.VirtualFile = "/"
+	assert.Error(t, m.initialize(configDir))
+	m.VirtualFile = "motd"
+	m.TemplateFile = "motd_template"
+	require.NoError(t, m.initialize(configDir))
+	assert.Equal(t, defaultMOTDMaxSize, m.MaxSize)
+	assert.Equal(t, "/motd", m.VirtualFile)
+	assert.Equal(t, filepath.Join(configDir, "motd_template"), m.templateFilePath)
+	assert.True(t, m.isVirtualFile("/motd"))
+	assert.True(t, m.isVirtualFile("motd"))
+	assert.False(t, m.isVirtualFile("/motd1"))
+	assert.False(t, (*MOTDConfig)(nil).isVirtualFile("/motd"))
+
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username:       "motd_user",
+			HomeDir:        filepath.Join(os.TempDir(), "motd_user"),
+			QuotaSize:      1048576,
+			ExpirationDate: util.GetTimeAsMsSinceEpoch(time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)),
+		},
+	}
+	user.Permissions = map[string][]string{
+		"/": {dataprovider.PermAny},
+	}
+	err = os.MkdirAll(user.GetHomeDir(), os.ModePerm)
+	require.NoError(t, err)
+	defer os.RemoveAll(user.GetHomeDir())
+	// missing template file
+	assert.Nil(t, m.render(&user))
+	templatePath := filepath.Join(os.TempDir(), "motd_template")
+	m.templateFilePath = templatePath
+	err = os.WriteFile(templatePath, []byte("{{.Username}} {{.QuotaUsed}}/{{.QuotaTotal}} expires {{.ExpirationDate}}"), 0644)
+	require.NoError(t, err)
+	defer os.Remove(templatePath)
+	assert.Equal(t, "motd_user 0 B/1.0 MiB expires 2030-01-02T03:04:05Z\n", string(m.render(&user)))
+	// the template stored in the data provider takes precedence
+	configs := dataprovider.Configs{
+		SFTPD: &dataprovider.SFTPDConfigs{
+			MOTD: "Maintenance this weekend {{.Username}}",
+		},
+	}
+	err = dataprovider.UpdateConfigs(&configs, "", "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "Maintenance this weekend motd_user\n", string(m.render(&user)))
+	err = dataprovider.UpdateConfigs(nil, "", "", "")
+	assert.NoError(t, err)
+	// rendering errors are not fatal
+	err = os.WriteFile(templatePath, []byte("{{.Username"), 0644)
+	require.NoError(t, err)
+	assert.Nil(t, m.render(&user))
+	err = os.WriteFile(templatePath, []byte("{{.Unknown}}"), 0644)
+	require.NoError(t, err)
+	assert.Nil(t, m.render(&user))
+	// the message is truncated
+	m.MaxSize = 10
+	err = os.WriteFile(templatePath, []byte(strings.Repeat("a", 20)), 0644)
+	require.NoError(t, err)
+	assert.Equal(t, strings.Repeat("a", 10)+"\n", string(m.render(&user)))
+	m.MaxSize = defaultMOTDMaxSize
+	err = os.WriteFile(templatePath, []byte("hello {{.Username}}\n"), 0644)
+	require.NoError(t, err)
+
+	mockSSHChannel := MockChannel{
+		Buffer:       bytes.NewBuffer(nil),
+		StdErrBuffer: bytes.NewBuffer(nil),
+	}
+	connection := &Connection{
+		BaseConnection: common.NewBaseConnection(xid.New().String(), common.ProtocolSFTP, "", "", user),
+		channel:        &mockSSHChannel,
+		motd:           &m,
+	}
+	expected := "hello motd_user\n"
+	r, err := connection.Fileread(sftp.NewRequest("Get", "/motd"))
+	require.NoError(t, err)
+	content, err := io.ReadAll(io.NewSectionReader(r.(io.ReaderAt), 0, 100))
+	require.NoError(t, err)
+	assert.Equal(t, expected, string(content))
+	lister, err := connection.Filelist(sftp.NewRequest("Stat", "/motd"))
+	require.NoError(t, err)
+	infos := make([]os.FileInfo, 10)
+	n, _ := lister.ListAt(infos, 0)
+	if assert.Equal(t, 1, n) {
+		assert.Equal(t, "motd", infos[0].Name())
+		assert.Equal(t, int64(len(expected)), infos[0].Size())
+		assert.Equal(t, os.FileMode(0444), infos[0].Mode())
+	}
+	lister, err = connection.Lstat(sftp.NewRequest("Lstat", "/motd"))
+	require.NoError(t, err)
+	n, _ = lister.ListAt(infos, 0)
+	assert.Equal(t, 1, n)
+	lister, err = connection.Filelist(sftp.NewRequest("List", "/"))
+	require.NoError(t, err)
+	n, _ = lister.ListAt(infos, 0)
+	var names []string
+	for _, info := range infos[:n] {
+		names = append(names, info.Name())
+	}
+	assert.Contains(t, names, "motd")
+	// the virtual file is read-only
+	_, err = connection.Filewrite(sftp.NewRequest("Put", "/motd"))
+	assert.ErrorIs(t, err, sftp.ErrSSHFxPermissionDenied)
+	err = connection.Filecmd(sftp.NewRequest("Remove", "/motd"))
+	assert.ErrorIs(t, err, sftp.ErrSSHFxPermissionDenied)
+	renameRequest := sftp.NewRequest("Rename", "/file")
+	renameRequest.Target = "/motd"
+	err = connection.Filecmd(renameRequest)
+	assert.ErrorIs(t, err, sftp.ErrSSHFxPermissionDenied)
+
+	connection = &Connection{
+		BaseConnection: common.NewBaseConnection(xid.New().String(), common.ProtocolSSH, "", "", user),
+		channel:        &mockSSHChannel,
+		motd:           &m,
+	}
+	cmd := sshCommand{
+		command:    "pwd",
+		connection: connection,
+	}
+	err = cmd.handle()
+	assert.NoError(t, err)
+	assert.Equal(t, "/\n", mockSSHChannel.Buffer.String())
+	assert.Equal(t, expected, mockSSHChannel.StdErrBuffer.String())
+	mockSSHChannel.Buffer.Reset()
+	connection.handleShell(&mockSSHChannel)
+	assert.Equal(t, expected, mockSSHChannel.Buffer.String())
+	// no message configured
+	mockSSHChannel.Buffer.Reset()
+	mockSSHChannel.StdErrBuffer.Reset()
+	connection = &Connection{
+		BaseConnection: common.NewBaseConnection(xid.New().String(), common.ProtocolSSH, "", "", user),
+		channel:        &mockSSHChannel,
+	}
+	cmd.connection = connection
+	err = cmd.handle()
+	assert.NoError(t, err)
+	assert.Equal(t, "/\n", mockSSHChannel.Buffer.String())
+	assert.Empty(t, mockSSHChannel.StdErrBuffer.String())
+}
+
+func TestGeneratedFiles(t *testing.T) {
+	c := Configuration{
+		MOTD: MOTDConfig{
+			VirtualFile: "/motd",
+		},
+		GeneratedFiles: []common.GeneratedFile{
+			{Path: "/motd", Generator: common.GeneratorQuotaReport},
+		},
+	}
+	assert.Error(t, c.initializeGeneratedFiles())
+	c.GeneratedFiles[0].Generator = "unknown"
+	assert.Error(t, c.initializeGeneratedFiles())
+	c.GeneratedFiles = []common.GeneratedFile{
+		{Path: "quota.json", Generator: common.GeneratorQuotaReport},
+	}
+	require.NoError(t, c.initializeGeneratedFiles())
+	assert.Equal(t, "/quota.json", c.GeneratedFiles[0].Path)
+
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: "generated_files_user",
+			HomeDir:  filepath.Join(os.TempDir(), "generated_files_user"),
+		},
+	}
+	user.Permissions = map[string][]string{
+		"/": {dataprovider.PermAny},
+	}
+	err := os.MkdirAll(user.GetHomeDir(), os.ModePerm)
+	require.NoError(t, err)
+	defer os.RemoveAll(user.GetHomeDir())
+
+	connection := &Connection{
+		BaseConnection: common.NewBaseConnection(xid.New().String(), common.ProtocolSFTP, "", "", user),
+	}
+	connection.SetGeneratedFiles(c.GeneratedFiles)
+	r, err := connection.Fileread(sftp.NewRequest("Get", "/quota.json"))
+	require.NoError(t, err)
+	content, err := io.ReadAll(io.NewSectionReader(r, 0, 65536))
+	require.NoError(t, err)
+	assert.Contains(t, string(content), `"username": "generated_files_user"`)
+	assert.NoError(t, r.(io.Closer).Close())
+	lister, err := connection.Filelist(sftp.NewRequest("Stat", "/quota.json"))
+	require.NoError(t, err)
+	infos := make([]os.FileInfo, 10)
+	n, _ := lister.ListAt(infos, 0)
+	if assert.Equal(t, 1, n) {
+		assert.Equal(t, "quota.json", infos[0].Name())
+		assert.Equal(t, os.FileMode(0444), infos[0].Mode())
+	}
+	lister, err = connection.Lstat(sftp.NewRequest("Lstat", "/quota.json"))
+	require.NoError(t, err)
+	n, _ = lister.ListAt(infos, 0)
+	assert.Equal(t, 1, n)
+	lister, err = connection.Filelist(sftp.NewRequest("List", "/"))
+	require.NoError(t, err)
+	n, _ = lister.ListAt(infos, 0)
+	var names []string
+	for _, info := range infos[:n] {
+		names = append(names, info.Name())
+	}
+	assert.Contains(t, names, "quota.json")
+	// generated files are read-only
+	_, err = connection.Filewrite(sftp.NewRequest("Put", "/quota.json"))
+	assert.ErrorIs(t, err, sftp.ErrSSHFxPermissionDenied)
+	err = connection.Filecmd(sftp.NewRequest("Remove", "/quota.json"))
+	assert.ErrorIs(t, err, sftp.ErrSSHFxPermissionDenied)
+	renameRequest := sftp.NewRequest("Rename", "/quota.json")
+	renameRequest.Target = "/file"
+	err = connection.Filecmd(renameRequest)
+	assert.ErrorIs(t, err, sftp.ErrSSHFxPermissionDenied)
+	// permissions are checked
+	connection.User.Permissions["/"] = []string{dataprovider.PermUpload}
+	_, err = connection.Fileread(sftp.NewRequest("Get", "/quota.json"))
+	assert.ErrorIs(t, err, sftp.ErrSSHFxPermissionDenied)
+	_, err = connection.Filelist(sftp.NewRequest("Stat", "/quota.json"))
+	assert.ErrorIs(t, err, sftp.ErrSSHFxPermissionDenied)
+}
+
+func TestLimitsConfig(t *testing.T) {
+	l := LimitsConfig{}
+	require.NoError(t, l.initialize())
+	assert.Equal(t, maxSFTPPacketLength, l.MaxPacketLength)
+	assert.Equal(t, maxSFTPDataLength, l.MaxReadLength)
+	assert.Equal(t, maxSFTPDataLength, l.MaxWriteLength)
+	assert.Equal(t, defaultMaxOpenHandles, l.MaxOpenHandles)
+	l.MaxPacketLength = maxSFTPPacketLength + 1
+	assert.Error(t, l.initialize())
+	l.MaxPacketLength = 65536
+	assert.Error(t, l.initialize())
+	l.MaxReadLength = 65536 - 1024
+	assert.Error(t, l.initialize())
+	l.MaxWriteLength = 65536 - 1024
+	assert.NoError(t, l.initialize())
+	l.MaxWriteLength = 1024
+	assert.Error(t, l.initialize())
+	l.MaxWriteLength = 32768
+	l.MaxOpenHandles = -2
+	assert.Error(t, l.initialize())
+	l.MaxOpenHandles = -1
+	assert.NoError(t, l.initialize())
+	assert.Equal(t, 0, l.getMaxOpenHandles())
+}
+
+func getLimitsTestUser() dataprovider.User {
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: "limits_user",
+			HomeDir:  filepath.Join(os.TempDir(), "limits_user"),
+		},
+	}
+	user.Permissions = map[string][]string{
+		"/": {dataprovider.PermAny},
+	}
+	return user
+}
+
+// startLimitsTestServer starts a request server, for the specified user, and
+// returns the client side of the pipe connected to it. The returned channel
+// is closed when the server exits
+func startLimitsTestServer(user dataprovider.User, limits *LimitsConfig) (net.Conn, *Connection, chan struct{}) {
+	clientConn, serverConn := net.Pipe()
+	connection := &Connection{
+		BaseConnection: common.NewBaseConnection(xid.New().String(), common.ProtocolSFTP, "", "", user),
+		ClientVersion:  "SSH-2.0-limits_test",
+		channel:        serverConn,
+		maxHandles:     limits.getMaxOpenHandles(),
+	}
+	c := Configuration{}
+	server := sftp.NewRequestServer(newLimitsChannel(newDisconnectChannel(serverConn, connection), limits),
+		c.createHandlers(connection),
+		sftp.WithRSMaxTxPacket(uint32(limits.MaxReadLength)))
+	done := make(chan struct{})
+	go func() {
+		server.Serve() //nolint:errcheck
+		server.Close()
+		close(done)
+	}()
+	return clientConn, connection, done
+}
+
+func sendTestSFTPPacket(t *testing.T, w io.Writer, packetType byte, payload []byte) {
+	b := make([]byte, 4, 5+len(payload))
+	b = append(b, packetType)
+	b = append(b, payload...)
+	binary.BigEndian.PutUint32(b, uint32(len(b)-4))
+	_, err := w.Write(b)
+	require.NoError(t, err)
+}
+
+func recvTestSFTPPacket(t *testing.T, r io.Reader) (byte, []byte) {
+	header := make([]byte, 4)
+	_, err := io.ReadFull(r, header)
+	require.NoError(t, err)
+	b := make([]byte, binary.BigEndian.Uint32(header))
+	_, err = io.ReadFull(r, b)
+	require.NoError(t, err)
+	return b[0], b[1:]
+}
+
+func appendTestSFTPString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
+
+func TestLimitsExtension(t *testing.T) {
+	user := getLimitsTestUser()
+	err := os.MkdirAll(user.GetHomeDir(), os.ModePerm)
+	require.NoError(t, err)
+	defer os.RemoveAll(user.GetHomeDir())
+
+	limits := LimitsConfig{}
+	require.NoError(t, limits.initialize())
+	conn, _, _ := startLimitsTestServer(user, &limits)
+	defer conn.Close()
+
+	sendTestSFTPPacket(t, conn, 1, binary.BigEndian.AppendUint32(nil, 3))
+	packetType, payload := recvTestSFTPPacket(t, conn)
+	require.Equal(t, byte(sshFxpVersion), packetType)
+	assert.Contains(t, string(payload), limitsExtensionName)
+	assert.Contains(t, string(payload), "statvfs@openssh.com")
+
+	request := binary.BigEndian.AppendUint32(nil, 1)
+	request = appendTestSFTPString(request, limitsExtensionName)
+	sendTestSFTPPacket(t, conn, sshFxpExtended, request)
+	packetType, payload = recvTestSFTPPacket(t, conn)
+	require.Equal(t, byte(sshFxpExtendedReply), packetType)
+	require.Len(t, payload, 4+4*8)
+	assert.Equal(t, uint32(1), binary.BigEndian.Uint32(payload))
+	assert.Equal(t, uint64(limits.MaxPacketLength), binary.BigEndian.Uint64(payload[4:]))
+	assert.Equal(t, uint64(limits.MaxReadLength), binary.BigEndian.Uint64(payload[12:]))
+	assert.Equal(t, uint64(limits.MaxWriteLength), binary.BigEndian.Uint64(payload[20:]))
+	assert.Equal(t, uint64(limits.MaxOpenHandles), binary.BigEndian.Uint64(payload[28:]))
+	// other extended requests are still handled by the request server
+	request = binary.BigEndian.AppendUint32(nil, 2)
+	request = appendTestSFTPString(request, "statvfs@openssh.com")
+	request = appendTestSFTPString(request, "/")
+	sendTestSFTPPacket(t, conn, sshFxpExtended, request)
+	packetType, payload = recvTestSFTPPacket(t, conn)
+	assert.Equal(t, byte(sshFxpExtendedReply), packetType)
+	assert.Equal(t, uint32(2), binary.BigEndian.Uint32(payload))
+	// write a file using the negotiated write length
+	request = binary.BigEndian.AppendUint32(nil, 3)
+	request = appendTestSFTPString(request, "/file")
+	request = binary.BigEndian.AppendUint32(request, 0x02|0x08|0x10) // write, create, truncate
+	request = binary.BigEndian.AppendUint32(request, 0)
+	sendTestSFTPPacket(t, conn, 3, request)
+	packetType, payload = recvTestSFTPPacket(t, conn)
+	require.Equal(t, byte(102), packetType, "expected handle packet")
+	handleLen := binary.BigEndian.Uint32(payload[4:])
+	handle := string(payload[8 : 8+handleLen])
+	data := bytes.Repeat([]byte("a"), limits.MaxWriteLength)
+	request = binary.BigEndian.AppendUint32(nil, 4)
+	request = appendTestSFTPString(request, handle)
+	request = binary.BigEndian.AppendUint64(request, 0)
+	request = appendTestSFTPString(request, string(data))
+	sendTestSFTPPacket(t, conn, 6, request)
+	packetType, payload = recvTestSFTPPacket(t, conn)
+	require.Equal(t, byte(101), packetType, "expected status packet")
+	assert.Equal(t, uint32(0), binary.BigEndian.Uint32(payload[4:]))
+	request = binary.BigEndian.AppendUint32(nil, 5)
+	request = appendTestSFTPString(request, handle)
+	sendTestSFTPPacket(t, conn, 4, request)
+	packetType, payload = recvTestSFTPPacket(t, conn)
+	require.Equal(t, byte(101), packetType, "expected status packet")
+	assert.Equal(t, uint32(0), binary.BigEndian.Uint32(payload[4:]))
+	info, err := os.Stat(filepath.Join(user.GetHomeDir(), "file"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(limits.MaxWriteLength), info.Size())
+	}
+	// packets longer than the configured limit are rejected
+	limits.MaxPacketLength = 65536
+	packet := binary.BigEndian.AppendUint32(nil, 65537)
+	packet = append(packet, 6)
+	_, err = conn.Write(packet)
+	assert.NoError(t, err)
+	_, err = io.ReadFull(conn, make([]byte, 4))
+	assert.Error(t, err)
+}
+
+func TestMaxOpenHandles(t *testing.T) {
+	user := getLimitsTestUser()
+	err := os.MkdirAll(user.GetHomeDir(), os.ModePerm)
+	require.NoError(t, err)
+	defer os.RemoveAll(user.GetHomeDir())
+
+	limits := LimitsConfig{
+		MaxOpenHandles: 2,
+	}
+	require.NoError(t, limits.initialize())
+	conn, connection, done := startLimitsTestServer(user, &limits)
+	client, err := sftp.NewClientPipe(conn, conn)
+	require.NoError(t, err)
+	defer client.Close()
+	err = common.Connections.Add(connection)
+	require.NoError(t, err)
+	defer common.Connections.Remove(connection.GetID())
+
+	_, ok := client.HasExtension(limitsExtensionName)
+	assert.True(t, ok)
+	f1, err := client.Create("file1")
+	require.NoError(t, err)
+	f2, err := client.Create("file2")
+	require.NoError(t, err)
+	_, err = client.Create("file3")
+	assert.Error(t, err)
+	assert.Equal(t, 2, connection.GetOpenHandles())
+	stats := common.Connections.GetStats("")
+	idx := slices.IndexFunc(stats, func(s common.ConnectionStatus) bool { return s.ConnectionID == connection.GetID() })
+	if assert.GreaterOrEqual(t, idx, 0) {
+		assert.Equal(t, 2, stats[idx].OpenHandles)
+	}
+	_, err = client.Open("file1")
+	assert.Error(t, err)
+	_, err = client.ReadDir("/")
+	assert.Error(t, err)
+	err = f1.Close()
+	assert.NoError(t, err)
+	// failed opens don't leak handles
+	_, err = client.Open("missing")
+	assert.Error(t, err)
+	f1, err = client.Open("file1")
+	require.NoError(t, err)
+	err = f1.Close()
+	assert.NoError(t, err)
+	_, err = client.ReadDir("/")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, connection.GetOpenHandles())
+	// the handles left open are released when the client disconnects
+	_, err = client.Open("file1")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, connection.GetOpenHandles())
+	err = client.Close()
+	assert.NoError(t, err)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the request server did not exit")
+	}
+	assert.Equal(t, 0, connection.GetOpenHandles())
+	err = f2.Close()
+	assert.Error(t, err)
+}
+
+// BenchmarkWriteSizes compares the upload throughput using the default SFTP
+// packet size and the write length advertised by the limits extension
+func BenchmarkWriteSizes(b *testing.B) {
+	user := getLimitsTestUser()
+	err := os.MkdirAll(user.GetHomeDir(), os.ModePerm)
+	require.NoError(b, err)
+	defer os.RemoveAll(user.GetHomeDir())
+
+	limits := LimitsConfig{}
+	require.NoError(b, limits.initialize())
+	data := bytes.Repeat([]byte("a"), 16*1024*1024)
+
+	for _, size := range []int{32768, limits.MaxWriteLength} {
+		b.Run(fmt.Sprintf("write_size_%d", size), func(b *testing.B) {
+			conn, _, _ := startLimitsTestServer(user, &limits)
+			client, err := sftp.NewClientPipe(conn, conn, sftp.MaxPacketUnchecked(size),
+				sftp.UseConcurrentWrites(true))
+			require.NoError(b, err)
+			defer client.Close()
+
+			b.SetBytes(int64(len(data)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				f, err := client.Create("benchfile")
+				require.NoError(b, err)
+				_, err = f.ReadFrom(bytes.NewReader(data))
+				require.NoError(b, err)
+				require.NoError(b, f.Close())
+			}
+		})
+	}
+}
+
+func BenchmarkCopyFromReaderToWriter(b *testing.B) {
+	user := getLimitsTestUser()
+	err := os.MkdirAll(user.GetHomeDir(), os.ModePerm)
+	require.NoError(b, err)
+	defer os.RemoveAll(user.GetHomeDir())
+
+	srcPath := filepath.Join(user.GetHomeDir(), "src")
+	dstPath := filepath.Join(user.GetHomeDir(), "dst")
+	data := bytes.Repeat([]byte("a"), 16*1024*1024)
+	err = os.WriteFile(srcPath, data, 0666)
+	require.NoError(b, err)
+	fs := vfs.NewOsFs("", user.GetHomeDir(), "", nil)
+	connection := common.NewBaseConnection(xid.New().String(), common.ProtocolSSH, "", "", user)
+
+	for _, maxSize := range []int64{0, 256} {
+		b.Run(fmt.Sprintf("buffer_pool_%d", maxSize), func(b *testing.B) {
+			err := vfs.SetBufferPoolConfig(vfs.BufferPoolConfig{MaxSize: maxSize})
+			require.NoError(b, err)
+			defer vfs.SetBufferPoolConfig(vfs.BufferPoolConfig{}) //nolint:errcheck
+
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				src, err := os.Open(srcPath)
+				require.NoError(b, err)
+				dst, err := os.Create(dstPath)
+				require.NoError(b, err)
+				baseTransfer := common.NewBaseTransfer(dst, connection, nil, dstPath, dstPath, "/dst",
+					common.TransferUpload, 0, 0, 0, 0, true, fs, dataprovider.TransferQuota{})
+				transfer := newTransfer(baseTransfer, nil, nil, nil)
+				_, err = transfer.copyFromReaderToWriter(dst, src)
+				require.NoError(b, err)
+				require.NoError(b, src.Close())
+				require.NoError(b, transfer.Close())
+			}
+		})
+	}
+}
+
+func TestCopyToWriterThrottled(t *testing.T) {
+	user := getLimitsTestUser()
+	user.DownloadBandwidth = 1024
+	err := os.MkdirAll(user.GetHomeDir(), os.ModePerm)
+	require.NoError(t, err)
+	defer os.RemoveAll(user.GetHomeDir())
+
+	srcPath := filepath.Join(user.GetHomeDir(), "src")
+	data := bytes.Repeat([]byte("a"), 1024*1024)
+	err = os.WriteFile(srcPath, data, 0666)
+	require.NoError(t, err)
+	fs := vfs.NewOsFs("", user.GetHomeDir(), "", nil)
+	connection := common.NewBaseConnection(xid.New().String(), common.ProtocolSCP, "", "", user)
+	src, err := os.Open(srcPath)
+	require.NoError(t, err)
+	baseTransfer := common.NewBaseTransfer(src, connection, nil, srcPath, srcPath, "/src",
+		common.TransferDownload, 0, 0, 0, 0, false, fs, dataprovider.TransferQuota{})
+	transfer := newTransfer(baseTransfer, nil, nil, nil)
+	assert.Equal(t, 1024*1024/10, transfer.getBufferSize())
+
+	var dst bytes.Buffer
+	start := time.Now()
+	n, err := transfer.copyToWriter(&dst)
+	elapsed := time.Since(start)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(data)), n)
+	assert.Equal(t, int64(len(data)), transfer.BytesSent.Load())
+	assert.Equal(t, data, dst.Bytes())
+	// 1MB at 1024 KB/s
+	assert.GreaterOrEqual(t, elapsed, 950*time.Millisecond)
+	assert.Less(t, elapsed, 1500*time.Millisecond)
+	assert.NoError(t, transfer.Close())
+
+	user.DownloadBandwidth = 1
+	connection = common.NewBaseConnection(xid.New().String(), common.ProtocolSCP, "", "", user)
+	baseTransfer = common.NewBaseTransfer(nil, connection, nil, srcPath, srcPath, "/src",
+		common.TransferDownload, 0, 0, 0, 0, false, fs, dataprovider.TransferQuota{})
+	transfer = newTransfer(baseTransfer, nil, nil, nil)
+	assert.Equal(t, minTransferBufferSize, transfer.getBufferSize())
+	assert.NoError(t, transfer.Close())
+	user.DownloadBandwidth = 0
+	connection = common.NewBaseConnection(xid.New().String(), common.ProtocolSCP, "", "", user)
+	baseTransfer = common.NewBaseTransfer(nil, connection, nil, srcPath, srcPath, "/src",
+		common.TransferDownload, 0, 0, 0, 0, false, fs, dataprovider.TransferQuota{})
+	transfer = newTransfer(baseTransfer, nil, nil, errors.New("read error"))
+	assert.Equal(t, transferBufferSize, transfer.getBufferSize())
+	_, err = transfer.copyToWriter(&dst)
+	assert.Error(t, err)
+	assert.Error(t, transfer.Close())
+	assert.Equal(t, int32(0), common.Connections.GetTotalTransfers())
+}
+
+func BenchmarkLocalDownload(b *testing.B) {
+	user := getLimitsTestUser()
+	err := os.MkdirAll(user.GetHomeDir(), os.ModePerm)
+	require.NoError(b, err)
+	defer os.RemoveAll(user.GetHomeDir())
+
+	srcPath := filepath.Join(user.GetHomeDir(), "src")
+	data := bytes.Repeat([]byte("a"), 64*1024*1024)
+	err = os.WriteFile(srcPath, data, 0666)
+	require.NoError(b, err)
+	fs := vfs.NewOsFs("", user.GetHomeDir(), "", nil)
+	connection := common.NewBaseConnection(xid.New().String(), common.ProtocolSCP, "", "", user)
+
+	newDownload := func() *transfer {
+		src, err := os.Open(srcPath)
+		require.NoError(b, err)
+		baseTransfer := common.NewBaseTransfer(src, connection, nil, srcPath, srcPath, "/src",
+			common.TransferDownload, 0, 0, 0, 0, false, fs, dataprovider.TransferQuota{})
+		return newTransfer(baseTransfer, nil, nil, nil)
+	}
+
+	b.Run("buffer_32KB", func(b *testing.B) {
+		b.SetBytes(int64(len(data)))
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			transfer := newDownload()
+			// the copy loop used for SCP downloads before copyToWriter
+			buf := make([]byte, 32768)
+			var readed int64
+			for {
+				n, err := transfer.ReadAt(buf, readed)
+				if n > 0 {
+					_, err = io.Discard.Write(buf[:n])
+				}
+				readed += int64(n)
+				if err != nil {
+					break
+				}
+			}
+			require.Equal(b, int64(len(data)), readed)
+			require.NoError(b, transfer.Close())
+		}
+	})
+	b.Run("copy_to_writer", func(b *testing.B) {
+		b.SetBytes(int64(len(data)))
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			transfer := newDownload()
+			n, err := transfer.copyToWriter(io.Discard)
+			require.NoError(b, err)
+			require.Equal(b, int64(len(data)), n)
+			require.NoError(b, transfer.Close())
+		}
+	})
+}
+
+func TestClientDisconnected(t *testing.T) {
+	user := getLimitsTestUser()
+	err := os.MkdirAll(user.GetHomeDir(), os.ModePerm)
+	require.NoError(t, err)
+	defer os.RemoveAll(user.GetHomeDir())
+
+	limits := LimitsConfig{}
+	require.NoError(t, limits.initialize())
+	conn, connection, done := startLimitsTestServer(user, &limits)
+	client, err := sftp.NewClientPipe(conn, conn)
+	require.NoError(t, err)
+	_, err = client.ReadDir("/")
+	assert.NoError(t, err)
+	osFs := vfs.NewOsFs("", user.GetHomeDir(), "", nil)
+	err = connection.GetFsError(osFs, context.Canceled)
+	assert.NotErrorIs(t, err, common.ErrAbortedByClient)
+
+	err = client.Close()
+	assert.NoError(t, err)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "request server not closed")
+	}
+	// the operations canceled after the disconnection are reported as aborted
+	err = connection.GetFsError(osFs, context.Canceled)
+	assert.ErrorIs(t, err, common.ErrAbortedByClient)
+}
+
+func TestClientVersionRules(t *testing.T) {
+	for _, rule := range []ClientVersionRule{
+		{Action: ClientVersionActionDeny},
+		{Patterns: []string{"SSH-2.0-[a"}, Action: ClientVersionActionDeny},
+		{Patterns: []string{"regexp:SSH-2.0-(a"}, Action: ClientVersionActionDeny},
+		{Patterns: []string{"SSH-2.0-Go"}, Action: "unknown"},
+		{Patterns: []string{"SSH-2.0-Go"}, Action: ClientVersionActionThrottle},
+	} {
+		c := Configuration{
+			ClientVersionRules: []ClientVersionRule{rule},
+		}
+		assert.Error(t, c.initializeClientVersionRules(), "%+v", rule)
+	}
+	c := Configuration{
+		ClientVersionRules: []ClientVersionRule{
+			{
+				Patterns: []string{"SSH-2.0-BadClient*", `regexp:^SSH-2\.0-libssh_0\.[0-8]\.`},
+				Action:   ClientVersionActionDeny,
+				Message:  "client not allowed",
+			},
+			{
+				Patterns: []string{"SSH-2.0-Slow*"},
+				Action:   ClientVersionActionThrottle,
+				Average:  1,
+			},
+			{
+				Patterns: []string{"SSH-2.0-*"},
+				Action:   ClientVersionActionLog,
+			},
+		},
+	}
+	require.NoError(t, c.initializeClientVersionRules())
+	assert.Equal(t, ClientVersionActionDeny, c.getClientVersionRule("SSH-2.0-BadClient_1.0").Action)
+	assert.Equal(t, ClientVersionActionDeny, c.getClientVersionRule("SSH-2.0-libssh_0.8.9").Action)
+	assert.Equal(t, ClientVersionActionThrottle, c.getClientVersionRule("SSH-2.0-SlowClient").Action)
+	assert.Equal(t, ClientVersionActionLog, c.getClientVersionRule("SSH-2.0-libssh_0.9.6").Action)
+	assert.Nil(t, c.getClientVersionRule("SSH-1.99-OpenSSH"))
+	assert.NotNil(t, c.ClientVersionRules[1].limiter)
+
+	serverConfig := &ssh.ServerConfig{
+		PasswordCallback: func(_ ssh.ConnMetadata, _ []byte) (*ssh.Permissions, error) {
+			return nil, errors.New("unable to authenticate")
+		},
+	}
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	require.NoError(t, err)
+	serverConfig.AddHostKey(signer)
+	assert.NotNil(t, c.getServerConfigForConn(serverConfig, nil, "").PreAuthConnCallback)
+	assert.Nil(t, serverConfig.PreAuthConnCallback)
+	empty := Configuration{}
+	assert.Equal(t, serverConfig, empty.getServerConfigForConn(serverConfig, nil, ""))
+
+	// net.Pipe is unbuffered and the server could block sending the banner
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	for _, test := range []struct {
+		clientVersion string
+		denied        bool
+	}{
+		{clientVersion: "SSH-2.0-BadClient_1.0", denied: true},
+		{clientVersion: "SSH-2.0-SlowClient", denied: false},
+		{clientVersion: "SSH-2.0-SlowClient", denied: true},
+		{clientVersion: "SSH-2.0-GoodClient", denied: false},
+	} {
+		client, err := net.Dial("tcp", listener.Addr().String())
+		require.NoError(t, err)
+		server, err := listener.Accept()
+		require.NoError(t, err)
+		done := make(chan struct{})
+		go func() {
+			c.AcceptInboundConnection(server, serverConfig)
+			close(done)
+		}()
+		_, _, _, err = ssh.NewClientConn(client, listener.Addr().String(), &ssh.ClientConfig{
+			User:            "user",
+			Auth:            []ssh.AuthMethod{ssh.Password("password")},
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(), //nolint:gosec
+			ClientVersion:   test.clientVersion,
+		})
+		if assert.Error(t, err) {
+			if test.denied {
+				assert.NotContains(t, err.Error(), "unable to authenticate", test.clientVersion)
+			} else {
+				assert.Contains(t, err.Error(), "unable to authenticate", test.clientVersion)
+			}
+		}
+		client.Close()
+		<-done
+	}
+}
+
+func TestAuxiliaryFilesChecker(t *testing.T) {
+	bannerFile := filepath.Join(os.TempDir(), "banner_test.txt")
+	err := os.WriteFile(bannerFile, []byte("banner"), 0644)
+	require.NoError(t, err)
+	hookFile := filepath.Join(os.TempDir(), "kihook_test.sh")
+	err = os.WriteFile(hookFile, []byte("#!/bin/sh\n"), 0755)
+	require.NoError(t, err)
+
+	statusCode := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodHead, r.Method)
+		w.WriteHeader(statusCode)
+	}))
+	defer server.Close()
+
+	c := Configuration{
+		LoginBannerFile:         bannerFile,
+		KeyboardInteractiveHook: hookFile,
+		TrustedUserCAKeys:       []string{"", "missing_ca_key.pub"},
+	}
+	files := c.getAuxiliaryFiles(configDir)
+	require.Len(t, files, 3)
+	assert.Equal(t, AuxiliaryFileLoginBanner, files[0].kind)
+	assert.Equal(t, AuxiliaryFileKeyboardInteractiveHook, files[1].kind)
+	assert.Equal(t, AuxiliaryFileTrustedUserCAKey, files[2].kind)
+	assert.Equal(t, filepath.Join(configDir, "missing_ca_key.pub"), files[2].path)
+	// the checker is disabled
+	err = c.initializeAuxiliaryFilesChecker(configDir)
+	assert.NoError(t, err)
+	assert.Len(t, getAuxiliaryFilesStatus(), 0)
+
+	c.AuxiliaryFilesCheckInterval = 3600
+	err = c.initializeAuxiliaryFilesChecker(configDir)
+	assert.NoError(t, err)
+	statuses := getAuxiliaryFilesStatus()
+	require.Len(t, statuses, 3)
+	assert.True(t, statuses[0].IsHealthy)
+	assert.Empty(t, statuses[0].Error)
+	assert.NotEmpty(t, statuses[0].GetLastCheckAsString())
+	if runtime.GOOS != osWindows {
+		assert.True(t, statuses[1].IsHealthy)
+	}
+	assert.False(t, statuses[2].IsHealthy)
+	assert.NotEmpty(t, statuses[2].Error)
+
+	auxFilesCheckerMu.RLock()
+	checker := auxFilesChecker
+	auxFilesCheckerMu.RUnlock()
+	require.NotNil(t, checker)
+
+	err = os.Remove(bannerFile)
+	assert.NoError(t, err)
+	if runtime.GOOS != osWindows {
+		err = os.Chmod(hookFile, 0644)
+		assert.NoError(t, err)
+	}
+	checker.check()
+	statuses = getAuxiliaryFilesStatus()
+	require.Len(t, statuses, 3)
+	assert.False(t, statuses[0].IsHealthy)
+	if runtime.GOOS != osWindows {
+		assert.False(t, statuses[1].IsHealthy)
+		assert.Contains(t, statuses[1].Error, "not executable")
+	}
+	// recovery
+	err = os.WriteFile(bannerFile, []byte("banner"), 0644)
+	assert.NoError(t, err)
+	checker.check()
+	statuses = getAuxiliaryFilesStatus()
+	assert.True(t, statuses[0].IsHealthy)
+	assert.Empty(t, statuses[0].Error)
+
+	assert.NoError(t, checkKeyboardInteractiveHook(server.URL))
+	statusCode = http.StatusNotFound
+	assert.NoError(t, checkKeyboardInteractiveHook(server.URL))
+	statusCode = http.StatusInternalServerError
+	assert.Error(t, checkKeyboardInteractiveHook(server.URL))
+	assert.Error(t, checkKeyboardInteractiveHook("http://127.0.0.1:1/hook"))
+	assert.Error(t, checkKeyboardInteractiveHook(os.TempDir()))
+	assert.Error(t, checkReadableFile(os.TempDir()))
+
+	setAuxiliaryFilesChecker(nil)
+	assert.Len(t, getAuxiliaryFilesStatus(), 0)
+	assert.Nil(t, checker.scheduler)
+
+	err = os.Remove(bannerFile)
+	assert.NoError(t, err)
+	err = os.Remove(hookFile)
+	assert.NoError(t, err)
+}
+
+func TestLoginBannerReload(t *testing.T) {
+	bannerFile := filepath.Join(os.TempDir(), "login_banner_test")
+	err := os.WriteFile(bannerFile, []byte("banner for %r on %d"), 0644)
+	require.NoError(t, err)
+
+	b := newLoginBanner(bannerFile)
+	today := time.Now().Format(time.DateOnly)
+	assert.Equal(t, "banner for 192.168.1.1 on "+today, b.get("", "192.168.1.1"))
+	err = os.WriteFile(bannerFile, []byte("updated banner"), 0644)
+	require.NoError(t, err)
+	// make sure the modification time changes on filesystems with low resolution
+	err = os.Chtimes(bannerFile, time.Now().Add(time.Minute), time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, "updated banner", b.get("", "192.168.1.1"))
+	// the user does not exist, the global banner is used
+	assert.Equal(t, "updated banner", b.get("missing_user", "192.168.1.1"))
+	b.users["banner_user"] = cachedUserBanner{
+		banner:    dataprovider.LoginBanner{File: filepath.Join(os.TempDir(), "missing_banner")},
+		expiresAt: time.Now().Add(time.Minute),
+	}
+	assert.Equal(t, "updated banner", b.get("banner_user", "192.168.1.1"))
+	b.users["banner_user"] = cachedUserBanner{
+		banner:    dataprovider.LoginBanner{File: bannerFile},
+		expiresAt: time.Now().Add(time.Minute),
+	}
+	assert.Equal(t, "updated banner", b.get("banner_user", "192.168.1.1"))
+	b.users["banner_user"] = cachedUserBanner{
+		banner:    dataprovider.LoginBanner{Text: "user banner %r"},
+		expiresAt: time.Now().Add(time.Minute),
+	}
+	assert.Equal(t, "user banner 10.0.0.1", b.get("banner_user", "10.0.0.1"))
+
+	err = os.Remove(bannerFile)
+	assert.NoError(t, err)
+	assert.Empty(t, b.get("", "192.168.1.1"))
+	assert.Empty(t, newLoginBanner("").get("", "192.168.1.1"))
+	assert.Equal(t, "no tokens", expandBannerTokens("no tokens", "192.168.1.1"))
+}
+
+type mockConnMetadata struct {
+	username string
+}
+
+func (m *mockConnMetadata) User() string          { return m.username }
+func (m *mockConnMetadata) SessionID() []byte     { return []byte("session") }
+func (m *mockConnMetadata) ClientVersion() []byte { return []byte("SSH-2.0-test") }
+func (m *mockConnMetadata) ServerVersion() []byte { return []byte("SSH-2.0-SFTPGo") }
+func (m *mockConnMetadata) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 12345}
+}
+func (m *mockConnMetadata) LocalAddr() net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 2022}
+}
+
+func TestUniformAuthFailures(t *testing.T) {
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: "uniform_auth_user",
+			Password: "uniform_password",
+			HomeDir:  filepath.Join(os.TempDir(), "uniform_auth_user"),
+			Status:   1,
+			Permissions: map[string][]string{
+				"/": {dataprovider.PermAny},
+			},
+		},
+	}
+	err := dataprovider.AddUser(&user, "", "", "")
+	require.NoError(t, err)
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	pubKey, err := ssh.NewPublicKey(pub)
+	require.NoError(t, err)
+	savedStatus := swapServiceStatus(GetStatus())
+	defer swapServiceStatus(savedStatus)
+
+	existing := &mockConnMetadata{username: user.Username}
+	missing := &mockConnMetadata{username: "missing_uniform_auth_user"}
+	prompts := 0
+	client := func(_, _ string, questions []string, _ []bool) ([]string, error) {
+		prompts++
+		answers := make([]string, 0, len(questions))
+		for range questions {
+			answers = append(answers, "wrong password")
+		}
+		return answers, nil
+	}
+	c := Configuration{
+		UniformAuthFailures:    true,
+		PasswordAuthentication: true,
+	}
+	serverConfig := c.getServerConfig()
+	// the wrong password and the missing user flows must perform the same
+	// number of password hash verifications and keyboard interactive prompts
+	for _, conn := range []*mockConnMetadata{existing, missing} {
+		verifications := dataprovider.GetPasswordHashVerifications()
+		_, err = serverConfig.PasswordCallback(conn, []byte("wrong password"))
+		assert.Error(t, err)
+		assert.Equal(t, verifications+1, dataprovider.GetPasswordHashVerifications(), conn.username)
+		prompts = 0
+		verifications = dataprovider.GetPasswordHashVerifications()
+		_, err = c.validateKeyboardInteractiveCredentials(conn, client, dataprovider.SSHLoginMethodKeyboardInteractive, false)
+		assert.Error(t, err)
+		assert.Equal(t, 1, prompts, conn.username)
+		assert.Equal(t, verifications+1, dataprovider.GetPasswordHashVerifications(), conn.username)
+	}
+	// the same error is returned to the SSH layer, the distinction is preserved
+	// for the defender events
+	_, errWrongPwd := c.validatePasswordCredentials(existing, []byte("wrong password"), dataprovider.LoginMethodPassword)
+	_, errMissingPwd := c.validatePasswordCredentials(missing, []byte("wrong password"), dataprovider.LoginMethodPassword)
+	assert.Equal(t, errWrongPwd.Error(), errMissingPwd.Error())
+	assert.NotErrorIs(t, errWrongPwd, util.ErrNotFound)
+	assert.ErrorIs(t, errMissingPwd, util.ErrNotFound)
+	_, errWrongKey := serverConfig.PublicKeyCallback(existing, pubKey)
+	_, errMissingKey := serverConfig.PublicKeyCallback(missing, pubKey)
+	assert.Equal(t, errWrongKey.Error(), errMissingKey.Error())
+	assert.Equal(t, errWrongPwd.Error(), errWrongKey.Error())
+	assert.ErrorIs(t, errMissingKey, util.ErrNotFound)
+	_, errWrongKI := c.validateKeyboardInteractiveCredentials(existing, client, dataprovider.SSHLoginMethodKeyboardInteractive, false)
+	_, errMissingKI := c.validateKeyboardInteractiveCredentials(missing, client, dataprovider.SSHLoginMethodKeyboardInteractive, false)
+	assert.Equal(t, errWrongKI.Error(), errMissingKI.Error())
+	assert.ErrorIs(t, errMissingKI, util.ErrNotFound)
+	// uniform auth failures disabled
+	c.UniformAuthFailures = false
+	verifications := dataprovider.GetPasswordHashVerifications()
+	_, errMissingPwd = c.validatePasswordCredentials(missing, []byte("wrong password"), dataprovider.LoginMethodPassword)
+	assert.Equal(t, verifications, dataprovider.GetPasswordHashVerifications())
+	assert.NotEqual(t, errWrongPwd.Error(), errMissingPwd.Error())
+	prompts = 0
+	_, err = c.validateKeyboardInteractiveCredentials(missing, client, dataprovider.SSHLoginMethodKeyboardInteractive, false)
+	assert.Error(t, err)
+	assert.Equal(t, 0, prompts)
+
+	err = dataprovider.DeleteUser(user.Username, "", "", "")
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func swapServiceStatus(status ServiceStatus) ServiceStatus {
+	var oldStatus ServiceStatus
+	updateServiceStatus(func(s *ServiceStatus) {
+		oldStatus = *s
+		*s = status
+	})
+	return oldStatus
+}
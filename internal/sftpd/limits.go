@@ -0,0 +1,315 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package sftpd
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/pkg/sftp"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+)
+
+const (
+	limitsExtensionName = "limits@openssh.com"
+	// maximum packet length accepted by the SFTP request server
+	maxSFTPPacketLength = 256 * 1024
+	// reads and writes must leave room for the packet headers
+	maxSFTPDataLength     = maxSFTPPacketLength - 1024
+	minSFTPDataLength     = 32768
+	defaultMaxOpenHandles = 256
+)
+
+const (
+	sshFxpVersion       = 2
+	sshFxpExtended      = 200
+	sshFxpExtendedReply = 201
+)
+
+// LimitsConfig defines the SFTP protocol limits. They are advertised to the
+// clients using the "limits@openssh.com" extension, so clients supporting it,
+// such as recent OpenSSH versions, can use bigger reads and writes
+type LimitsConfig struct {
+	// MaxPacketLength defines the maximum length, in bytes, for the packets
+	// sent by the clients, longer packets are rejected. 0 means 262144
+	MaxPacketLength int `json:"max_packet_length" mapstructure:"max_packet_length"`
+	// MaxReadLength defines the maximum length, in bytes, for a read request.
+	// Longer reads are truncated. 0 means 261120
+	MaxReadLength int `json:"max_read_length" mapstructure:"max_read_length"`
+	// MaxWriteLength defines the maximum length, in bytes, for a write request
+	// advertised to the clients. It must fit within the maximum packet length.
+	// 0 means 261120
+	MaxWriteLength int `json:"max_write_length" mapstructure:"max_write_length"`
+	// MaxOpenHandles defines the maximum number of files and directories that
+	// can be open at the same time within an SFTP session. 0 means 256,
+	// -1 means unlimited
+	MaxOpenHandles int `json:"max_open_handles" mapstructure:"max_open_handles"`
+}
+
+func (l *LimitsConfig) initialize() error {
+	if l.MaxPacketLength == 0 {
+		l.MaxPacketLength = maxSFTPPacketLength
+	}
+	if l.MaxReadLength == 0 {
+		l.MaxReadLength = maxSFTPDataLength
+	}
+	if l.MaxWriteLength == 0 {
+		l.MaxWriteLength = maxSFTPDataLength
+	}
+	if l.MaxOpenHandles == 0 {
+		l.MaxOpenHandles = defaultMaxOpenHandles
+	}
+	if l.MaxPacketLength < minSFTPDataLength+1024 || l.MaxPacketLength > maxSFTPPacketLength {
+		return fmt.Errorf("invalid SFTP max packet length %d, it must be between %d and %d",
+			l.MaxPacketLength, minSFTPDataLength+1024, maxSFTPPacketLength)
+	}
+	maxDataLength := l.MaxPacketLength - 1024
+	if l.MaxReadLength < minSFTPDataLength || l.MaxReadLength > maxDataLength {
+		return fmt.Errorf("invalid SFTP max read length %d, it must be between %d and %d",
+			l.MaxReadLength, minSFTPDataLength, maxDataLength)
+	}
+	if l.MaxWriteLength < minSFTPDataLength || l.MaxWriteLength > maxDataLength {
+		return fmt.Errorf("invalid SFTP max write length %d, it must be between %d and %d",
+			l.MaxWriteLength, minSFTPDataLength, maxDataLength)
+	}
+	if l.MaxOpenHandles < -1 {
+		return fmt.Errorf("invalid SFTP max open handles %d", l.MaxOpenHandles)
+	}
+	logger.Debug(logSender, "", "SFTP limits configured, max packet length: %d, max read length: %d, "+
+		"max write length: %d, max open handles: %d", l.MaxPacketLength, l.MaxReadLength, l.MaxWriteLength,
+		l.MaxOpenHandles)
+	return nil
+}
+
+// getMaxOpenHandles returns the open handles limit to enforce, 0 means unlimited
+func (l *LimitsConfig) getMaxOpenHandles() int {
+	if l.MaxOpenHandles < 0 {
+		return 0
+	}
+	return l.MaxOpenHandles
+}
+
+func (l *LimitsConfig) getReply(id uint32) []byte {
+	b := make([]byte, 4, 4+1+4+4*8)
+	b = append(b, sshFxpExtendedReply)
+	b = binary.BigEndian.AppendUint32(b, id)
+	b = binary.BigEndian.AppendUint64(b, uint64(l.MaxPacketLength))
+	b = binary.BigEndian.AppendUint64(b, uint64(l.MaxReadLength))
+	b = binary.BigEndian.AppendUint64(b, uint64(l.MaxWriteLength))
+	b = binary.BigEndian.AppendUint64(b, uint64(l.getMaxOpenHandles()))
+	binary.BigEndian.PutUint32(b, uint32(len(b)-4))
+	return b
+}
+
+// limitsChannel wraps the channel used by the SFTP request server to handle
+// the "limits@openssh.com" extension, it is not supported by the request server.
+// The extension is added to the server version packet and the limits requests
+// are answered directly without forwarding them to the request server
+type limitsChannel struct {
+	io.ReadWriteCloser
+	limits *LimitsConfig
+	// bytes, already read from the channel, to return before reading again
+	pending []byte
+	// remaining bytes for the packet being read
+	readRemaining int
+	// the request server writes the packet header and the payload separately,
+	// this mutex is held until a packet is fully written so we can send our
+	// replies between packets
+	writeMu sync.Mutex
+	// remaining bytes for the packet being written
+	writeRemaining int
+	versionSent    bool
+}
+
+func newLimitsChannel(channel io.ReadWriteCloser, limits *LimitsConfig) *limitsChannel {
+	return &limitsChannel{
+		ReadWriteCloser: channel,
+		limits:          limits,
+	}
+}
+
+func (c *limitsChannel) Read(p []byte) (int, error) {
+	for {
+		if len(c.pending) > 0 {
+			n := copy(p, c.pending)
+			c.pending = c.pending[n:]
+			return n, nil
+		}
+		if c.readRemaining > 0 {
+			if len(p) > c.readRemaining {
+				p = p[:c.readRemaining]
+			}
+			n, err := c.ReadWriteCloser.Read(p)
+			c.readRemaining -= n
+			return n, err
+		}
+		if err := c.readPacketHeader(); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// readPacketHeader reads the header of the next packet, extended packets are
+// fully read and the limits requests are handled here
+func (c *limitsChannel) readPacketHeader() error {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(c.ReadWriteCloser, header); err != nil {
+		return err
+	}
+	length := int(binary.BigEndian.Uint32(header))
+	if length == 0 {
+		return errors.New("invalid SFTP packet, zero length")
+	}
+	if length > c.limits.MaxPacketLength {
+		return fmt.Errorf("SFTP packet too long: %d bytes, max allowed: %d", length, c.limits.MaxPacketLength)
+	}
+	if header[4] != sshFxpExtended || length < 9 {
+		c.pending = header
+		c.readRemaining = length - 1
+		return nil
+	}
+	packet := make([]byte, 4+length)
+	copy(packet, header)
+	if _, err := io.ReadFull(c.ReadWriteCloser, packet[5:]); err != nil {
+		return err
+	}
+	id := binary.BigEndian.Uint32(packet[5:])
+	if name, ok := getExtendedRequestName(packet[9:]); ok && name == limitsExtensionName {
+		return c.writePacket(c.limits.getReply(id))
+	}
+	c.pending = packet
+	return nil
+}
+
+func getExtendedRequestName(b []byte) (string, bool) {
+	if len(b) < 4 {
+		return "", false
+	}
+	l := int(binary.BigEndian.Uint32(b))
+	if len(b) < 4+l {
+		return "", false
+	}
+	return string(b[4 : 4+l]), true
+}
+
+func (c *limitsChannel) writePacket(packet []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	_, err := c.ReadWriteCloser.Write(packet)
+	return err
+}
+
+func (c *limitsChannel) Write(p []byte) (int, error) {
+	if c.writeRemaining == 0 {
+		if len(p) < 5 {
+			return 0, fmt.Errorf("unexpected SFTP packet header length: %d", len(p))
+		}
+		c.writeMu.Lock()
+		c.writeRemaining = 4 + int(binary.BigEndian.Uint32(p))
+		if !c.versionSent && p[4] == sshFxpVersion {
+			c.versionSent = true
+			return c.writeVersion(p)
+		}
+	}
+	if len(p) > c.writeRemaining {
+		c.writeMu.Unlock()
+		return 0, fmt.Errorf("unexpected SFTP packet write, size %d, remaining: %d", len(p), c.writeRemaining)
+	}
+	n, err := c.ReadWriteCloser.Write(p)
+	c.writeRemaining -= n
+	if c.writeRemaining == 0 || err != nil {
+		c.writeRemaining = 0
+		c.writeMu.Unlock()
+	}
+	return n, err
+}
+
+// writeVersion adds the limits extension to the version packet. The version
+// packet is written with a single write, the write lock is already held
+func (c *limitsChannel) writeVersion(p []byte) (int, error) {
+	defer c.writeMu.Unlock()
+
+	c.writeRemaining = 0
+	if len(p) != 4+int(binary.BigEndian.Uint32(p)) {
+		return 0, errors.New("unexpected SFTP version packet")
+	}
+	packet := make([]byte, len(p), len(p)+8+len(limitsExtensionName)+1)
+	copy(packet, p)
+	packet = binary.BigEndian.AppendUint32(packet, uint32(len(limitsExtensionName)))
+	packet = append(packet, limitsExtensionName...)
+	packet = binary.BigEndian.AppendUint32(packet, 1)
+	packet = append(packet, '1')
+	binary.BigEndian.PutUint32(packet, uint32(len(packet)-4))
+	if _, err := c.ReadWriteCloser.Write(packet); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// handleReaderAt releases the open handle when the reader is closed
+type handleReaderAt struct {
+	io.ReaderAt
+	release func()
+}
+
+func (r *handleReaderAt) Close() error {
+	defer r.release()
+
+	if c, ok := r.ReaderAt.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// handleDirLister releases the open handle when the lister is closed
+type handleDirLister struct {
+	*common.DirListerAt
+	release func()
+}
+
+func (l *handleDirLister) Close() error {
+	defer l.release()
+
+	return l.DirListerAt.Close()
+}
+
+// GetOpenHandles returns the number of open files and directories
+func (c *Connection) GetOpenHandles() int {
+	return int(c.openHandles.Load())
+}
+
+// reserveHandle reserves a slot for a new open handle. It returns the function
+// to release the slot, it can be safely called more than once
+func (c *Connection) reserveHandle() (func(), error) {
+	numHandles := c.openHandles.Add(1)
+	if c.maxHandles > 0 && int(numHandles) > c.maxHandles {
+		c.openHandles.Add(-1)
+		c.Log(logger.LevelWarn, "denying open, the maximum number of open handles (%d) has been reached, client: %q",
+			c.maxHandles, c.ClientVersion)
+		return nil, sftp.ErrSSHFxFailure
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			c.openHandles.Add(-1)
+		})
+	}, nil
+}
@@ -0,0 +1,199 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package sftpd
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+var (
+	listenersMu sync.RWMutex
+	// runtime status for the listeners of the configured bindings
+	listeners []*listenerStatus
+)
+
+// ListenerStatus defines the runtime status for the listener of a binding
+type ListenerStatus struct {
+	// Address is the configured binding address
+	Address string `json:"address"`
+	// ListenAddress is the resolved address the listener is bound to
+	ListenAddress string `json:"listen_address,omitempty"`
+	// Network is the address family the listener is bound to: "tcp4", "tcp6"
+	// or "tcp" for listeners accepting both IPv4 and IPv6 connections
+	Network string `json:"network,omitempty"`
+	// Port is the port the listener is bound to, if the binding port is 0
+	// this is the port chosen by the OS
+	Port int `json:"port,omitempty"`
+	// IsActive is true if the listener is accepting connections
+	IsActive bool `json:"is_active"`
+	// StartedAt is the listener start time as unix timestamp in milliseconds
+	StartedAt int64 `json:"started_at,omitempty"`
+	// ProxyProtocol is the effective proxy protocol policy for the binding:
+	// 0 disabled, 1 optional, 2 required
+	ProxyProtocol int `json:"proxy_protocol"`
+	// AcceptErrors is the number of failed accept attempts
+	AcceptErrors int64 `json:"accept_errors"`
+	// Connections is the number of connections currently handled
+	Connections int32 `json:"connections"`
+	// LastError is the last fatal error for the listener
+	LastError string `json:"last_error,omitempty"`
+	// LastErrorAt is the time of the last fatal error as unix timestamp in milliseconds
+	LastErrorAt int64 `json:"last_error_at,omitempty"`
+}
+
+// GetStartedAtAsString returns the listener start time formatted as RFC 3339 string
+func (s *ListenerStatus) GetStartedAtAsString() string {
+	return getMsTimeAsString(s.StartedAt)
+}
+
+// GetLastErrorAtAsString returns the time of the last fatal error formatted as RFC 3339 string
+func (s *ListenerStatus) GetLastErrorAtAsString() string {
+	return getMsTimeAsString(s.LastErrorAt)
+}
+
+func getMsTimeAsString(t int64) string {
+	if t == 0 {
+		return ""
+	}
+	return util.GetTimeFromMsecSinceEpoch(t).UTC().Format(time.RFC3339)
+}
+
+// listenerStatus tracks the status for a listener, a nil listenerStatus
+// can be safely used and does nothing
+type listenerStatus struct {
+	mu     sync.RWMutex
+	status ListenerStatus
+	// configured network for the binding
+	network string
+}
+
+// getListenerNetwork returns the address family for a listener bound to the
+// given IP using the configured network. Only listeners for the "tcp" network
+// bound to the unspecified IPv6 address accept both IPv4 and IPv6 connections
+func getListenerNetwork(network string, ip net.IP) string {
+	if network != "tcp" || ip == nil {
+		return network
+	}
+	if ip.To4() != nil {
+		return "tcp4"
+	}
+	if ip.IsUnspecified() {
+		return network
+	}
+	return "tcp6"
+}
+
+func newListenerStatus(binding Binding) *listenerStatus {
+	return &listenerStatus{
+		status: ListenerStatus{
+			Address:       binding.GetAddress(),
+			ProxyProtocol: binding.GetProxyProtocol(),
+		},
+		network: binding.GetNetwork(),
+	}
+}
+
+func (l *listenerStatus) setStarted(addr net.Addr) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.status.ListenAddress = addr.String()
+	l.status.Network = l.network
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		l.status.Port = tcpAddr.Port
+		l.status.Network = getListenerNetwork(l.network, tcpAddr.IP)
+	}
+	l.status.IsActive = true
+	l.status.StartedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+}
+
+func (l *listenerStatus) setError(err error) {
+	if l == nil || err == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.status.IsActive = false
+	l.status.LastError = err.Error()
+	l.status.LastErrorAt = util.GetTimeAsMsSinceEpoch(time.Now())
+}
+
+func (l *listenerStatus) addAcceptError() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.status.AcceptErrors++
+}
+
+func (l *listenerStatus) addConnections(delta int32) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.status.Connections += delta
+}
+
+func (l *listenerStatus) get() ListenerStatus {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.status
+}
+
+func setListeners(status []*listenerStatus) {
+	listenersMu.Lock()
+	defer listenersMu.Unlock()
+
+	listeners = status
+}
+
+func getListenersStatus() []ListenerStatus {
+	listenersMu.RLock()
+	defer listenersMu.RUnlock()
+
+	if len(listeners) == 0 {
+		return nil
+	}
+	result := make([]ListenerStatus, 0, len(listeners))
+	for _, l := range listeners {
+		result = append(result, l.get())
+	}
+	return result
+}
+
+// GetListenerAddresses returns the addresses the started listeners are bound to.
+// For bindings with port 0 the returned addresses include the port chosen by the OS
+func GetListenerAddresses() []string {
+	var addresses []string
+	for _, l := range getListenersStatus() {
+		if l.ListenAddress != "" {
+			addresses = append(addresses, l.ListenAddress)
+		}
+	}
+	return addresses
+}
@@ -0,0 +1,161 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package sftpd
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	// the per-user banners are cached for this time, the lookup happens before
+	// authentication and so it must be cheap
+	userBannerCacheTTL = 1 * time.Minute
+	// the user banners cache is cleared if it grows over this limit
+	userBannerCacheMaxEntries = 10000
+)
+
+// loginBanner returns the SSH login banner. The banner files are re-read if
+// their modification time or size change, so edits are applied without a
+// restart. The user's banner, if any, overrides the global one
+type loginBanner struct {
+	globalFile string
+	filesMu    sync.Mutex
+	files      map[string]cachedBannerFile
+	usersMu    sync.Mutex
+	users      map[string]cachedUserBanner
+}
+
+type cachedBannerFile struct {
+	modTime time.Time
+	size    int64
+	content string
+}
+
+type cachedUserBanner struct {
+	banner    dataprovider.LoginBanner
+	expiresAt time.Time
+}
+
+func newLoginBanner(globalFile string) *loginBanner {
+	return &loginBanner{
+		globalFile: globalFile,
+		files:      make(map[string]cachedBannerFile),
+		users:      make(map[string]cachedUserBanner),
+	}
+}
+
+func (b *loginBanner) callback(conn ssh.ConnMetadata) string {
+	ipAddr := util.GetIPFromRemoteAddress(conn.RemoteAddr().String())
+	return b.get(conn.User(), ipAddr)
+}
+
+func (b *loginBanner) get(username, ipAddr string) string {
+	userBanner := b.getUserBanner(username)
+	if userBanner.Text != "" {
+		return expandBannerTokens(userBanner.Text, ipAddr)
+	}
+	if userBanner.File != "" {
+		content, err := b.readFile(userBanner.File)
+		if err == nil {
+			return expandBannerTokens(content, ipAddr)
+		}
+		logger.Warn(logSender, "", "unable to read login banner file %q for user %q, using the global banner: %v",
+			userBanner.File, username, err)
+	}
+	if b.globalFile == "" {
+		return ""
+	}
+	content, err := b.readFile(b.globalFile)
+	if err != nil {
+		logger.Warn(logSender, "", "unable to read login banner file: %v", err)
+		return ""
+	}
+	return expandBannerTokens(content, ipAddr)
+}
+
+func (b *loginBanner) getUserBanner(username string) dataprovider.LoginBanner {
+	if username == "" {
+		return dataprovider.LoginBanner{}
+	}
+	b.usersMu.Lock()
+	cached, ok := b.users[username]
+	b.usersMu.Unlock()
+
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached.banner
+	}
+	// lookup failures, for example for non-existent users, are cached too and
+	// fall back to the global banner
+	var banner dataprovider.LoginBanner
+	user, err := dataprovider.UserExists(username, "")
+	if err == nil {
+		banner = user.Filters.LoginBanner
+	}
+
+	b.usersMu.Lock()
+	defer b.usersMu.Unlock()
+
+	if len(b.users) >= userBannerCacheMaxEntries {
+		clear(b.users)
+	}
+	b.users[username] = cachedUserBanner{
+		banner:    banner,
+		expiresAt: time.Now().Add(userBannerCacheTTL),
+	}
+	return banner
+}
+
+func (b *loginBanner) readFile(name string) (string, error) {
+	info, err := os.Stat(name)
+	if err != nil {
+		return "", err
+	}
+	b.filesMu.Lock()
+	defer b.filesMu.Unlock()
+
+	cached, ok := b.files[name]
+	if ok && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+		return cached.content, nil
+	}
+	content, err := os.ReadFile(name)
+	if err != nil {
+		return "", err
+	}
+	b.files[name] = cachedBannerFile{
+		modTime: info.ModTime(),
+		size:    info.Size(),
+		content: util.BytesToString(content),
+	}
+	return util.BytesToString(content), nil
+}
+
+// expandBannerTokens replaces "%r" with the client IP address and "%d" with
+// the current date
+func expandBannerTokens(banner, ipAddr string) string {
+	if !strings.Contains(banner, "%") {
+		return banner
+	}
+	replacer := strings.NewReplacer("%r", ipAddr, "%d", time.Now().Format(time.DateOnly))
+	return replacer.Replace(banner)
+}
@@ -0,0 +1,216 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package sftpd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"text/template"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+const (
+	defaultMOTDMaxSize = 4096
+	maxMOTDMaxSize     = 65536
+)
+
+// MOTDConfig defines the message of the day shown to the users after login.
+// The message is a text/template, the following placeholders are supported:
+// {{.Username}}, {{.QuotaUsed}}, {{.QuotaTotal}}, {{.ExpirationDate}}
+type MOTDConfig struct {
+	// TemplateFile defines the path to the message template, it can be absolute or
+	// relative to the configuration directory. The file is read for each login, so
+	// it can be updated without restarting the service. A template stored in the
+	// data provider takes precedence
+	TemplateFile string `json:"template_file" mapstructure:"template_file"`
+	// VirtualFile defines the path for a read-only virtual file exposing the message
+	// to SFTP clients, for example "/.motd". Leave empty to disable
+	VirtualFile string `json:"virtual_file" mapstructure:"virtual_file"`
+	// MaxSize defines the maximum size, in bytes, of the rendered message.
+	// Longer messages are truncated. 0 means 4096
+	MaxSize int `json:"max_size" mapstructure:"max_size"`
+	// resolved template file path
+	templateFilePath string
+}
+
+type motdData struct {
+	Username       string
+	QuotaUsed      string
+	QuotaTotal     string
+	ExpirationDate string
+}
+
+func (m *MOTDConfig) initialize(configDir string) error {
+	if m.MaxSize < 0 || m.MaxSize > maxMOTDMaxSize {
+		return fmt.Errorf("invalid MOTD max size %d, it must be between 0 and %d", m.MaxSize, maxMOTDMaxSize)
+	}
+	if m.MaxSize == 0 {
+		m.MaxSize = defaultMOTDMaxSize
+	}
+	if m.VirtualFile != "" {
+		m.VirtualFile = util.CleanPath(m.VirtualFile)
+		if m.VirtualFile == "/" {
+			return errors.New("invalid MOTD virtual file, the root directory is not allowed")
+		}
+	}
+	m.templateFilePath = ""
+	if m.TemplateFile != "" {
+		m.templateFilePath = m.TemplateFile
+		if !filepath.IsAbs(m.templateFilePath) {
+			m.templateFilePath = filepath.Join(configDir, m.templateFilePath)
+		}
+	}
+	logger.Debug(logSender, "", "MOTD configured, template file: %q, virtual file: %q, max size: %d",
+		m.templateFilePath, m.VirtualFile, m.MaxSize)
+	return nil
+}
+
+// isVirtualFile returns true if the specified virtual path is the MOTD virtual file
+func (m *MOTDConfig) isVirtualFile(virtualPath string) bool {
+	if m == nil || m.VirtualFile == "" {
+		return false
+	}
+	return util.CleanPath(virtualPath) == m.VirtualFile
+}
+
+// getTemplate returns the message template, the one stored in the data provider,
+// if any, takes precedence over the template file
+func (m *MOTDConfig) getTemplate() (string, error) {
+	configs, err := dataprovider.GetConfigs()
+	if err != nil {
+		return "", fmt.Errorf("unable to get provider configs: %w", err)
+	}
+	if configs.SFTPD != nil && configs.SFTPD.MOTD != "" {
+		return configs.SFTPD.MOTD, nil
+	}
+	if m.templateFilePath == "" {
+		return "", nil
+	}
+	f, err := os.Open(m.templateFilePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	// the template can be bigger than the rendered message, but not too much
+	content, err := io.ReadAll(io.LimitReader(f, maxMOTDMaxSize))
+	if err != nil {
+		return "", err
+	}
+	return util.BytesToString(content), nil
+}
+
+// render returns the message of the day for the specified user.
+// Errors are logged and an empty message is returned, they must not break the login
+func (m *MOTDConfig) render(user *dataprovider.User) []byte {
+	if m == nil {
+		return nil
+	}
+	text, err := m.getTemplate()
+	if err != nil {
+		logger.Warn(logSender, "", "unable to get the MOTD template for user %q: %v", user.Username, err)
+		return nil
+	}
+	if text == "" {
+		return nil
+	}
+	tmpl, err := template.New("motd").Parse(text)
+	if err != nil {
+		logger.Warn(logSender, "", "unable to parse the MOTD template: %v", err)
+		return nil
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, getMOTDData(user)); err != nil {
+		logger.Warn(logSender, "", "unable to render the MOTD template for user %q: %v", user.Username, err)
+		return nil
+	}
+	content := buf.Bytes()
+	if len(content) > m.MaxSize {
+		content = bytes.ToValidUTF8(content[:m.MaxSize], nil)
+	}
+	if len(content) > 0 && content[len(content)-1] != '\n' {
+		content = append(content, '\n')
+	}
+	return content
+}
+
+func getMOTDData(user *dataprovider.User) motdData {
+	usedSize := user.UsedQuotaSize
+	if _, size, _, _, err := dataprovider.GetUsedQuota(user.Username); err == nil {
+		usedSize = size
+	}
+	data := motdData{
+		Username:       user.Username,
+		QuotaUsed:      util.ByteCountIEC(usedSize),
+		QuotaTotal:     "unlimited",
+		ExpirationDate: "never",
+	}
+	if user.QuotaSize > 0 {
+		data.QuotaTotal = util.ByteCountIEC(user.QuotaSize)
+	}
+	if user.ExpirationDate > 0 {
+		data.ExpirationDate = util.GetTimeFromMsecSinceEpoch(user.ExpirationDate).UTC().Format(time.RFC3339)
+	}
+	return data
+}
+
+// getMOTD returns the rendered message of the day for this connection.
+// The message is rendered once, so the virtual file has a stable size
+func (c *Connection) getMOTD() []byte {
+	c.motdOnce.Do(func() {
+		c.motdContent = c.motd.render(&c.User)
+	})
+	return c.motdContent
+}
+
+func (c *Connection) getMOTDFileInfo() os.FileInfo {
+	info := vfs.NewFileInfo(path.Base(c.motd.VirtualFile), false, int64(len(c.getMOTD())), c.GetConnectionTime(), false)
+	info.SetMode(0444)
+	return info
+}
+
+// sendMOTD writes the message of the day, if any, to the specified writer
+func (c *Connection) sendMOTD(w io.Writer) {
+	if content := c.getMOTD(); len(content) > 0 {
+		_, err := w.Write(content)
+		c.Log(logger.LevelDebug, "MOTD sent, size: %d, err: %v", len(content), err)
+	}
+}
+
+// handleShell sends the message of the day to clients requesting an
+// interactive shell, the session is then closed since shells are not supported
+func (c *Connection) handleShell(channel ssh.Channel) {
+	defer func() {
+		err := c.CloseFS()
+		c.Log(logger.LevelDebug, "shell session closed, close fs err: %v", err)
+	}()
+
+	c.sendMOTD(channel)
+	exitStatus := sshSubsystemExitStatus{Status: uint32(0)}
+	_, err := channel.SendRequest("exit-status", false, ssh.Marshal(&exitStatus))
+	c.Log(logger.LevelDebug, "exit status sent for shell session, error: %v", err)
+	channel.Close()
+}
@@ -0,0 +1,391 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package sftpd
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+)
+
+const (
+	recordingResultOK    = "ok"
+	recordingResultError = "error"
+)
+
+var (
+	errRecordingNotConfigured = errors.New("session recording is not configured")
+	errRecordingSizeExceeded  = errors.New("session recording max size exceeded")
+	errRecordingClosed        = errors.New("session recording closed")
+)
+
+// SessionRecordingConfig defines the recording of the SFTP requests and of
+// the SSH commands for the users with the session recording filter enabled.
+// Each session is recorded to its own JSON Lines file
+type SessionRecordingConfig struct {
+	// PathTemplate defines the path for the recording files. The following
+	// placeholders are supported: "%username%", "%connection_id%" and "%date%",
+	// the date is formatted as YYYYMMDD. Relative paths are resolved against
+	// the configuration directory. Empty means disabled
+	PathTemplate string `json:"path_template" mapstructure:"path_template"`
+	// MaxSize defines the maximum size, in megabytes, for a recording file.
+	// Once the limit is reached the further records are discarded.
+	// 0 means unlimited
+	MaxSize int `json:"max_size" mapstructure:"max_size"`
+	// FailOpen defines how recording failures are handled. If true the session
+	// continues without recording, otherwise the session is closed
+	FailOpen     bool `json:"fail_open" mapstructure:"fail_open"`
+	pathTemplate string
+}
+
+func (c *SessionRecordingConfig) initialize(configDir string) error {
+	if c.MaxSize < 0 {
+		return fmt.Errorf("invalid session recording max size %d", c.MaxSize)
+	}
+	c.pathTemplate = ""
+	if c.PathTemplate != "" {
+		c.pathTemplate = c.PathTemplate
+		if !filepath.IsAbs(c.pathTemplate) {
+			c.pathTemplate = filepath.Join(configDir, c.pathTemplate)
+		}
+	}
+	logger.Debug(logSender, "", "session recording configured, path template: %q, max size: %d, fail open: %t",
+		c.pathTemplate, c.MaxSize, c.FailOpen)
+	return nil
+}
+
+func (c *SessionRecordingConfig) getPath(username, connectionID string, now time.Time) string {
+	replacer := strings.NewReplacer(
+		"%username%", sanitizeRecordingPathElement(username),
+		"%connection_id%", sanitizeRecordingPathElement(connectionID),
+		"%date%", now.UTC().Format("20060102"),
+	)
+	return filepath.Clean(replacer.Replace(c.pathTemplate))
+}
+
+// sanitizeRecordingPathElement makes sure that the placeholder values cannot
+// change the directory for the recording files
+func sanitizeRecordingPathElement(name string) string {
+	name = strings.NewReplacer("/", "_", "\\", "_").Replace(name)
+	if name == "." || name == ".." {
+		return strings.Repeat("_", len(name))
+	}
+	return name
+}
+
+// sessionRecord defines a recorded operation
+type sessionRecord struct {
+	Time          string   `json:"time"`
+	ConnectionID  string   `json:"connection_id"`
+	Protocol      string   `json:"protocol"`
+	Op            string   `json:"op"`
+	Username      string   `json:"username,omitempty"`
+	RemoteAddress string   `json:"remote_address,omitempty"`
+	ClientVersion string   `json:"client_version,omitempty"`
+	Command       string   `json:"command,omitempty"`
+	Path          string   `json:"path,omitempty"`
+	Target        string   `json:"target,omitempty"`
+	Flags         []string `json:"flags,omitempty"`
+	Mode          string   `json:"mode,omitempty"`
+	UID           *int     `json:"uid,omitempty"`
+	GID           *int     `json:"gid,omitempty"`
+	Atime         int64    `json:"atime,omitempty"`
+	Mtime         int64    `json:"mtime,omitempty"`
+	Size          *int64   `json:"size,omitempty"`
+	Result        string   `json:"result,omitempty"`
+	Error         string   `json:"error,omitempty"`
+}
+
+func (r *sessionRecord) setResult(err error) {
+	if err == nil || errors.Is(err, sftp.ErrSSHFxOk) {
+		r.Result = recordingResultOK
+		return
+	}
+	r.Result = recordingResultError
+	r.Error = err.Error()
+}
+
+func (r *sessionRecord) setRequestDetails(request *sftp.Request) {
+	switch request.Method {
+	case "Get", "Put", "Open":
+		r.Flags = getRecordingOpenFlags(request.Pflags())
+	case "Setstat":
+		if request.Attributes() == nil {
+			return
+		}
+		attrs := request.Attributes()
+		if request.AttrFlags().Permissions {
+			r.Mode = fmt.Sprintf("%#o", attrs.FileMode().Perm())
+		}
+		if request.AttrFlags().UidGid {
+			uid, gid := int(attrs.UID), int(attrs.GID)
+			r.UID = &uid
+			r.GID = &gid
+		}
+		if request.AttrFlags().Acmodtime {
+			r.Atime = int64(attrs.Atime)
+			r.Mtime = int64(attrs.Mtime)
+		}
+		if request.AttrFlags().Size {
+			size := int64(attrs.Size)
+			r.Size = &size
+		}
+	}
+}
+
+func getRecordingOpenFlags(pflags sftp.FileOpenFlags) []string {
+	var flags []string
+	if pflags.Read {
+		flags = append(flags, "read")
+	}
+	if pflags.Write {
+		flags = append(flags, "write")
+	}
+	if pflags.Append {
+		flags = append(flags, "append")
+	}
+	if pflags.Creat {
+		flags = append(flags, "create")
+	}
+	if pflags.Trunc {
+		flags = append(flags, "truncate")
+	}
+	if pflags.Excl {
+		flags = append(flags, "exclusive")
+	}
+	return flags
+}
+
+// sessionRecorder appends the records for a session to its recording file
+type sessionRecorder struct {
+	sync.Mutex
+	file     *os.File
+	writer   *bufio.Writer
+	path     string
+	maxSize  int64
+	size     int64
+	failOpen bool
+	// set after the first failure, the further records are discarded
+	failed bool
+}
+
+func newSessionRecorder(config *SessionRecordingConfig, username, connectionID string) (*sessionRecorder, error) {
+	if config == nil || config.pathTemplate == "" {
+		return nil, errRecordingNotConfigured
+	}
+	recordingPath := config.getPath(username, connectionID, time.Now())
+	if err := os.MkdirAll(filepath.Dir(recordingPath), 0700); err != nil {
+		return nil, fmt.Errorf("unable to create the directory for the session recording %q: %w", recordingPath, err)
+	}
+	f, err := os.OpenFile(recordingPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open the session recording %q: %w", recordingPath, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("unable to stat the session recording %q: %w", recordingPath, err)
+	}
+	return &sessionRecorder{
+		file:     f,
+		writer:   bufio.NewWriter(f),
+		path:     recordingPath,
+		maxSize:  int64(config.MaxSize) * 1048576,
+		size:     info.Size(),
+		failOpen: config.FailOpen,
+	}, nil
+}
+
+// write appends the specified record. It returns an error only for the first
+// failure, the further records are silently discarded
+func (r *sessionRecorder) write(record *sessionRecord) error {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.failed {
+		return nil
+	}
+	if r.file == nil {
+		r.failed = true
+		return errRecordingClosed
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		r.failed = true
+		return err
+	}
+	data = append(data, '\n')
+	if r.maxSize > 0 && r.size+int64(len(data)) > r.maxSize {
+		r.failed = true
+		return errRecordingSizeExceeded
+	}
+	n, err := r.writer.Write(data)
+	r.size += int64(n)
+	if err != nil {
+		r.failed = true
+		return err
+	}
+	return nil
+}
+
+// close flushes the pending records and closes the recording file
+func (r *sessionRecorder) close() error {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+	err := r.writer.Flush()
+	if errSync := r.file.Sync(); err == nil {
+		err = errSync
+	}
+	if errClose := r.file.Close(); err == nil {
+		err = errClose
+	}
+	r.file = nil
+	return err
+}
+
+// startRecording starts the session recording if it is enabled for the
+// connected user. An error is returned if the recording cannot be started
+// and the session must be closed
+func (c *Connection) startRecording() error {
+	if !c.User.Filters.SessionRecording {
+		return nil
+	}
+	recorder, err := newSessionRecorder(c.recordingConfig, c.User.Username, c.GetID())
+	if err != nil {
+		if c.recordingConfig != nil && c.recordingConfig.FailOpen {
+			c.Log(logger.LevelError, "unable to start the session recording, continuing without recording: %v", err)
+			return nil
+		}
+		c.Log(logger.LevelError, "unable to start the session recording, closing the session: %v", err)
+		return err
+	}
+	c.recorder = recorder
+	c.SetRecordingPath(recorder.path)
+	c.Log(logger.LevelInfo, "session recording started, path %q", recorder.path)
+	c.record(&sessionRecord{
+		Op:            "start",
+		Username:      c.User.Username,
+		RemoteAddress: c.GetRemoteAddress(),
+		ClientVersion: c.GetClientVersion(),
+	})
+	return nil
+}
+
+// stopRecording flushes and closes the session recording, if any
+func (c *Connection) stopRecording() {
+	if c.recorder == nil {
+		return
+	}
+	c.record(&sessionRecord{Op: "end"})
+	if err := c.recorder.close(); err != nil {
+		c.Log(logger.LevelError, "unable to close the session recording %q: %v", c.recorder.path, err)
+		return
+	}
+	c.Log(logger.LevelDebug, "session recording %q closed", c.recorder.path)
+}
+
+func (c *Connection) record(record *sessionRecord) {
+	record.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	record.ConnectionID = c.GetID()
+	record.Protocol = c.GetProtocol()
+	err := c.recorder.write(record)
+	if err == nil {
+		return
+	}
+	if c.recorder.failOpen {
+		c.Log(logger.LevelError, "unable to write to the session recording %q, the further operations are not recorded: %v",
+			c.recorder.path, err)
+		return
+	}
+	c.Log(logger.LevelError, "unable to write to the session recording %q, closing the session: %v",
+		c.recorder.path, err)
+	c.Disconnect() //nolint:errcheck
+}
+
+func (c *Connection) recordRequest(request *sftp.Request, err error) {
+	if c.recorder == nil {
+		return
+	}
+	record := &sessionRecord{
+		Op:     strings.ToLower(request.Method),
+		Path:   request.Filepath,
+		Target: request.Target,
+	}
+	record.setRequestDetails(request)
+	record.setResult(err)
+	c.record(record)
+}
+
+func (c *Connection) recordPath(op, virtualPath, target string, err error) {
+	if c.recorder == nil {
+		return
+	}
+	record := &sessionRecord{
+		Op:     op,
+		Path:   virtualPath,
+		Target: target,
+	}
+	record.setResult(err)
+	c.record(record)
+}
+
+func (c *Connection) recordCommand(err error) {
+	if c.recorder == nil {
+		return
+	}
+	record := &sessionRecord{
+		Op:      "exec",
+		Command: c.command,
+	}
+	record.setResult(err)
+	c.record(record)
+}
+
+// setTransferRecording configures the transfer to record its result
+// when closed, if the session is recorded
+func (c *Connection) setTransferRecording(t *transfer) {
+	if c.recorder != nil {
+		t.recordingConn = c
+	}
+}
+
+func (c *Connection) recordTransferClose(t *transfer, err error) {
+	size := t.GetDownloadedSize()
+	if t.GetType() == common.TransferUpload {
+		size = t.GetUploadedSize()
+	}
+	record := &sessionRecord{
+		Op:   "close",
+		Path: t.GetVirtualPath(),
+		Size: &size,
+	}
+	record.setResult(err)
+	c.record(record)
+}
@@ -41,6 +41,8 @@ var (
 
 type scpCommand struct {
 	sshCommand
+	// permissions from the last upload protocol message
+	uploadMode os.FileMode
 }
 
 func (c *scpCommand) handle() (err error) {
@@ -56,6 +58,13 @@ func (c *scpCommand) handle() (err error) {
 	}
 	defer common.Connections.Remove(c.connection.GetID())
 
+	if err := c.connection.startRecording(); err != nil {
+		c.connection.Disconnect() //nolint:errcheck
+		return err
+	}
+	defer c.connection.stopRecording()
+	defer func() { c.connection.recordCommand(err) }()
+
 	destPath := c.getDestPath()
 	c.connection.Log(logger.LevelDebug, "handle scp command, args: %v user: %s, dest path: %q",
 		c.args, c.connection.User.Username, destPath)
@@ -120,6 +129,7 @@ func (c *scpCommand) handleRecursiveUpload() error {
 			if err != nil {
 				return err
 			}
+			c.uploadMode = getUploadMessageMode(command)
 			if strings.HasPrefix(command, "D") {
 				numDirs++
 				destPath = path.Join(destPath, name)
@@ -172,6 +182,7 @@ func (c *scpCommand) handleCreateDir(fs vfs.Fs, dirPath string) error {
 	if err != nil {
 		return err
 	}
+	c.applyUploadMode(fs, p, dirPath, true)
 	c.connection.Log(logger.LevelDebug, "created dir %q", dirPath)
 	return nil
 }
@@ -188,7 +199,9 @@ func (c *scpCommand) getUploadFileData(sizeToRead int64, transfer *transfer) err
 	if sizeToRead > 0 {
 		// we could replace this method with io.CopyN implementing "Write" method in transfer struct
 		remaining := sizeToRead
-		buf := make([]byte, int64(math.Min(32768, float64(sizeToRead))))
+		buf, releaseBuf := vfs.GetTransferBuffer(int(math.Min(32768, float64(sizeToRead))))
+		defer releaseBuf()
+
 		for {
 			n, err := c.connection.channel.Read(buf)
 			if err != nil {
@@ -208,7 +221,7 @@ func (c *scpCommand) getUploadFileData(sizeToRead int64, transfer *transfer) err
 				break
 			}
 			if remaining < int64(len(buf)) {
-				buf = make([]byte, remaining)
+				buf = buf[:remaining]
 			}
 		}
 	}
@@ -249,7 +262,7 @@ func (c *scpCommand) handleUploadFile(fs vfs.Fs, resolvedPath, filePath string,
 		return err
 	}
 
-	maxWriteSize, _ := c.connection.GetMaxWriteSize(diskQuota, false, fileSize, fs.IsUploadResumeSupported())
+	maxWriteSize, _ := c.connection.GetMaxWriteSize(diskQuota, false, fileSize, fs.IsUploadResumeSupported(), requestPath)
 
 	file, w, cancelFn, err := fs.Create(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, c.connection.GetCreateChecks(requestPath, isNewFile, false))
 	if err != nil {
@@ -258,6 +271,9 @@ func (c *scpCommand) handleUploadFile(fs vfs.Fs, resolvedPath, filePath string,
 		return err
 	}
 
+	if isNewFile {
+		c.applyUploadMode(fs, filePath, requestPath, false)
+	}
 	initialSize := int64(0)
 	truncatedSize := int64(0) // bytes truncated and not included in quota
 	if !isNewFile {
@@ -281,7 +297,13 @@ func (c *scpCommand) handleUploadFile(fs vfs.Fs, resolvedPath, filePath string,
 
 	baseTransfer := common.NewBaseTransfer(file, c.connection.BaseConnection, cancelFn, resolvedPath, filePath, requestPath,
 		common.TransferUpload, 0, initialSize, maxWriteSize, truncatedSize, isNewFile, fs, transferQuota)
+	baseTransfer.SetExpectedSize(sizeToRead)
 	t := newTransfer(baseTransfer, w, nil, nil)
+	if err := baseTransfer.CheckDeclaredSize(sizeToRead); err != nil {
+		t.Close()
+		c.sendErrorMessage(fs, err)
+		return err
+	}
 
 	return c.getUploadFileData(sizeToRead, t)
 }
@@ -303,7 +325,7 @@ func (c *scpCommand) handleUpload(uploadFilePath string, sizeToRead int64) error
 	}
 
 	filePath := p
-	if common.Config.IsAtomicUploadEnabled() && fs.IsAtomicUploadSupported() {
+	if common.Config.IsAtomicUploadEnabledFor(fs) {
 		filePath = fs.GetAtomicUploadPath(p)
 	}
 	stat, statErr := fs.Lstat(p)
@@ -335,7 +357,7 @@ func (c *scpCommand) handleUpload(uploadFilePath string, sizeToRead int64) error
 		return common.ErrPermissionDenied
 	}
 
-	if common.Config.IsAtomicUploadEnabled() && fs.IsAtomicUploadSupported() {
+	if common.Config.IsAtomicUploadEnabled() && fs.IsAtomicUploadSupported() && !vfs.HasCloudAtomicUploads(fs) {
 		_, _, err = fs.Rename(p, filePath, 0)
 		if err != nil {
 			c.connection.Log(logger.LevelError, "error renaming existing file for atomic upload, source: %q, dest: %q, err: %v",
@@ -463,11 +485,10 @@ func (c *scpCommand) sendDownloadFileData(fs vfs.Fs, filePath string, stat os.Fi
 		}
 	}
 	if vfs.IsCryptOsFs(fs) {
-		stat = fs.(*vfs.CryptFs).ConvertFileInfo(stat)
+		stat = vfs.UnwrapFs(fs).(*vfs.CryptFs).ConvertFileInfo(stat)
 	}
 
 	fileSize := stat.Size()
-	readed := int64(0)
 	fileMode := fmt.Sprintf("C%v %v %v\n", getFileModeAsString(stat.Mode(), stat.IsDir()), fileSize, filepath.Base(filePath))
 	err = c.sendProtocolMessage(fileMode)
 	if err != nil {
@@ -478,22 +499,8 @@ func (c *scpCommand) sendDownloadFileData(fs vfs.Fs, filePath string, stat os.Fi
 		return err
 	}
 
-	// we could replace this method with io.CopyN implementing "Read" method in transfer struct
-	buf := make([]byte, 32768)
-	var n int
-	for {
-		n, err = transfer.ReadAt(buf, readed)
-		if err == nil || err == io.EOF {
-			if n > 0 {
-				_, err = c.connection.channel.Write(buf[:n])
-			}
-		}
-		readed += int64(n)
-		if err != nil {
-			break
-		}
-	}
-	if err != io.EOF {
+	_, err = transfer.copyToWriter(c.connection.channel)
+	if err != nil {
 		c.sendErrorMessage(fs, err)
 		return err
 	}
@@ -573,6 +580,7 @@ func (c *scpCommand) handleDownload(filePath string) error {
 
 	baseTransfer := common.NewBaseTransfer(file, c.connection.BaseConnection, cancelFn, p, p, filePath,
 		common.TransferDownload, 0, 0, 0, 0, false, fs, transferQuota)
+	baseTransfer.SetExpectedSize(stat.Size())
 	t := newTransfer(baseTransfer, nil, r, nil)
 
 	err = c.sendDownloadFileData(fs, p, stat, t)
@@ -725,6 +733,38 @@ func (c *scpCommand) createDir(fs vfs.Fs, dirPath string) error {
 	return err
 }
 
+// applyUploadMode applies the permissions from the upload protocol message to
+// a new file or directory if the related create mode is configured for the
+// user and not forced. Without a configured create mode the permissions from
+// the protocol message are ignored
+func (c *scpCommand) applyUploadMode(fs vfs.Fs, fsPath, virtualPath string, isDir bool) {
+	modes := &c.connection.User.Filters.CreateModes
+	configuredMode := modes.File
+	if isDir {
+		configuredMode = modes.Dir
+	}
+	if configuredMode == "" || modes.Force || c.uploadMode == 0 || !vfs.IsLocalOrCryptoFs(fs) {
+		return
+	}
+	if !c.connection.User.HasPerm(dataprovider.PermChmod, path.Dir(virtualPath)) {
+		c.connection.Log(logger.LevelDebug, "ignoring mode %v requested for %q, chmod not allowed", c.uploadMode, virtualPath)
+		return
+	}
+	err := fs.Chmod(fsPath, c.uploadMode)
+	c.connection.Log(logger.LevelDebug, "applied mode %v requested for %q, err: %v", c.uploadMode, virtualPath, err)
+}
+
+// getUploadMessageMode returns the permissions from a valid upload protocol
+// message, 0 if they cannot be parsed
+func getUploadMessageMode(command string) os.FileMode {
+	modeString, _, _ := strings.Cut(command[1:], " ")
+	mode, err := strconv.ParseUint(modeString, 8, 32)
+	if err != nil {
+		return 0
+	}
+	return os.FileMode(mode) & os.ModePerm
+}
+
 // parse protocol messages such as:
 // D0755 0 testdir
 // or:
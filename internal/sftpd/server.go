@@ -16,8 +16,12 @@ package sftpd
 
 import (
 	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/x509"
 	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
 	"errors"
 	"fmt"
 	"io"
@@ -27,8 +31,10 @@ import (
 	"path/filepath"
 	"runtime/debug"
 	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pkg/sftp"
@@ -40,6 +46,7 @@ import (
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/metric"
 	"github.com/drakkan/sftpgo/v2/internal/plugin"
+	"github.com/drakkan/sftpgo/v2/internal/tracing"
 	"github.com/drakkan/sftpgo/v2/internal/util"
 	"github.com/drakkan/sftpgo/v2/internal/version"
 	"github.com/drakkan/sftpgo/v2/internal/vfs"
@@ -49,6 +56,7 @@ const (
 	defaultPrivateRSAKeyName          = "id_rsa"
 	defaultPrivateECDSAKeyName        = "id_ecdsa"
 	defaultPrivateEd25519KeyName      = "id_ed25519"
+	providerHostKeyPrefix             = "provider://"
 	sourceAddressCriticalOption       = "source-address"
 	keyExchangeCurve25519SHA256LibSSH = "curve25519-sha256@libssh.org"
 )
@@ -85,25 +93,97 @@ var (
 type Binding struct {
 	// The address to listen on. A blank value means listen on all available network interfaces.
 	Address string `json:"address" mapstructure:"address"`
-	// The port used for serving requests
+	// The port used for serving requests. 0 means a port chosen by the OS,
+	// the port actually used is reported in the service status.
+	// A negative port disables the binding
 	Port int `json:"port" mapstructure:"port"`
+	// Network defines the address family for the listener:
+	// - "tcp" means IPv4 and IPv6, dual-stack if supported by the OS. This is the default
+	// - "tcp4" means IPv4 only
+	// - "tcp6" means IPv6 only, mapped IPv4 connections are not accepted
+	Network string `json:"network" mapstructure:"network"`
 	// Apply the proxy configuration, if any, for this binding
 	ApplyProxyConfig bool `json:"apply_proxy_config" mapstructure:"apply_proxy_config"`
+	// Proxy protocol policy for this binding, it is used only if apply_proxy_config is true.
+	// - 0 means the global proxy_protocol setting is used
+	// - 1 means proxy protocol enabled, requests without proxy header will be accepted
+	// - 2 means proxy protocol required, requests without proxy header will be rejected
+	// The global proxy_allowed and proxy_skipped lists apply to this binding too
+	ProxyProtocol int `json:"proxy_protocol" mapstructure:"proxy_protocol"`
+	// TCP keepalive for the accepted connections.
+	// - 0 means the default behavior, keepalive enabled with the platform defaults
+	// - 1 means keepalive enabled using the configured interval and count
+	// - 2 means keepalive disabled
+	TCPKeepAlive int `json:"tcp_keepalive" mapstructure:"tcp_keepalive"`
+	// Idle time, in seconds, before sending the first keepalive probe and interval
+	// between the following probes. 0 means the platform default
+	TCPKeepAliveInterval int `json:"tcp_keepalive_interval" mapstructure:"tcp_keepalive_interval"`
+	// Number of unacknowledged keepalive probes before dropping the connection.
+	// 0 means the platform default
+	TCPKeepAliveCount int `json:"tcp_keepalive_count" mapstructure:"tcp_keepalive_count"`
+	// TCP_NODELAY for the accepted connections: 0 means the default, enabled,
+	// 1 means enabled, 2 means disabled
+	TCPNoDelay int `json:"tcp_nodelay" mapstructure:"tcp_nodelay"`
+	// Socket receive buffer size (SO_RCVBUF), in bytes. 0 means the platform default
+	ReceiveBufferSize int `json:"receive_buffer_size" mapstructure:"receive_buffer_size"`
+	// Socket send buffer size (SO_SNDBUF), in bytes. 0 means the platform default
+	SendBufferSize int `json:"send_buffer_size" mapstructure:"send_buffer_size"`
 }
 
 // GetAddress returns the binding address
 func (b *Binding) GetAddress() string {
-	return fmt.Sprintf("%s:%d", b.Address, b.Port)
+	return net.JoinHostPort(b.Address, strconv.Itoa(b.Port))
 }
 
-// IsValid returns true if the binding port is > 0
+// GetNetwork returns the network to listen on: "tcp", "tcp4" or "tcp6"
+func (b *Binding) GetNetwork() string {
+	if b.Network == "" {
+		return "tcp"
+	}
+	return b.Network
+}
+
+func (b *Binding) validateNetwork() error {
+	network := b.GetNetwork()
+	if !slices.Contains([]string{"tcp", "tcp4", "tcp6"}, network) {
+		return fmt.Errorf("invalid network %q for binding %q, supported values: tcp, tcp4, tcp6",
+			b.Network, b.GetAddress())
+	}
+	if network == "tcp" || b.Address == "" {
+		return nil
+	}
+	ip := net.ParseIP(b.Address)
+	if ip == nil {
+		// host names are resolved by net.Listen for the configured network
+		return nil
+	}
+	if (ip.To4() != nil) != (network == "tcp4") {
+		return fmt.Errorf("address %q does not match the network %q for binding %q", b.Address, network,
+			b.GetAddress())
+	}
+	return nil
+}
+
+// IsValid returns true if the binding port is >= 0
 func (b *Binding) IsValid() bool {
-	return b.Port > 0
+	return b.Port >= 0
 }
 
 // HasProxy returns true if the proxy protocol is active for this binding
 func (b *Binding) HasProxy() bool {
-	return b.ApplyProxyConfig && common.Config.ProxyProtocol > 0
+	return b.GetProxyProtocol() > 0
+}
+
+// GetProxyProtocol returns the effective proxy protocol policy for this binding:
+// 0 disabled, 1 optional, 2 required
+func (b *Binding) GetProxyProtocol() int {
+	if !b.ApplyProxyConfig {
+		return 0
+	}
+	if b.ProxyProtocol > 0 {
+		return b.ProxyProtocol
+	}
+	return common.Config.ProxyProtocol
 }
 
 // Configuration for the SFTP server
@@ -118,11 +198,31 @@ type Configuration struct {
 	// Each host key can be defined as a path relative to the configuration directory or an absolute one.
 	// If empty or missing, the daemon will search or try to generate "id_rsa" and "id_ecdsa" host keys
 	// inside the configuration directory.
+	// Host keys stored in the data provider, shared among multiple instances, can be referenced using
+	// the "provider://" prefix followed by the key name, for example "provider://default_rsa".
+	// Missing keys are generated if the name ends with "rsa", "ecdsa" or "ed25519".
 	HostKeys []string `json:"host_keys" mapstructure:"host_keys"`
+	// HostKeyPassphrases defines the passphrases for encrypted private host keys loaded from files.
+	// The passphrase at a given position applies to the host key at the same position, empty values
+	// mean no passphrase. A passphrase can be read from an environment variable using the "env://"
+	// prefix, for example "env://SFTPGO_HOST_KEY_PASSPHRASE", or from a file using the "file://" prefix.
+	// If no host keys are defined, the passphrases apply to the default host keys in the order
+	// "id_rsa", "id_ecdsa", "id_ed25519". Generated host keys are encrypted if a passphrase is defined.
+	HostKeyPassphrases []string `json:"host_key_passphrases" mapstructure:"host_key_passphrases"`
+	// HostKeyRSABits defines the size, in bits, of the auto-generated RSA host keys.
+	// Supported values: 2048, 3072, 4096. 0 means the default size, 3072 bits
+	HostKeyRSABits int `json:"host_key_rsa_bits" mapstructure:"host_key_rsa_bits"`
+	// HostKeyECDSACurve defines the curve for the auto-generated ECDSA host keys.
+	// Supported values: "P-256", "P-384", "P-521". Empty means "P-256"
+	HostKeyECDSACurve string `json:"host_key_ecdsa_curve" mapstructure:"host_key_ecdsa_curve"`
 	// HostCertificates defines public host certificates.
 	// Each certificate can be defined as a path relative to the configuration directory or an absolute one.
 	// Certificate's public key must match a private host key otherwise it will be silently ignored.
 	HostCertificates []string `json:"host_certificates" mapstructure:"host_certificates"`
+	// HostCertificatesExpirationWarning defines the number of days before the expiration of a
+	// host certificate to log a warning and to fire a certificate event. Expired certificates
+	// are never offered to clients. 0 means no warning
+	HostCertificatesExpirationWarning int `json:"host_certificates_expiration_warning" mapstructure:"host_certificates_expiration_warning"`
 	// HostKeyAlgorithms lists the public key algorithms that the server will accept for host
 	// key authentication.
 	HostKeyAlgorithms []string `json:"host_key_algorithms" mapstructure:"host_key_algorithms"`
@@ -150,8 +250,24 @@ type Configuration struct {
 	// ["SHA256:bsBRHC/xgiqBJdSuvSTNpJNLTISP/G356jNMCRYC5Es","SHA256:119+8cL/HH+NLMawRsJx6CzPF1I3xC+jpM60bQHXGE8"]
 	RevokedUserCertsFile string `json:"revoked_user_certs_file" mapstructure:"revoked_user_certs_file"`
 	// LoginBannerFile the contents of the specified file, if any, are sent to
-	// the remote user before authentication is allowed.
+	// the remote user before authentication is allowed. The file is re-read when
+	// it changes, "%r" and "%d" are replaced with the client IP and the current date.
+	// Users can have their own banner that overrides this one
 	LoginBannerFile string `json:"login_banner_file" mapstructure:"login_banner_file"`
+	// MOTD defines the message of the day sent, after login, to the clients
+	// requesting a shell or running the "cd" and "pwd" commands. The message
+	// can also be exposed to SFTP clients as a read-only virtual file
+	MOTD MOTDConfig `json:"motd" mapstructure:"motd"`
+	// GeneratedFiles defines read-only virtual files whose content is produced
+	// on the fly when SFTP clients open them, for example a manifest of the
+	// user's files or a quota report
+	GeneratedFiles []common.GeneratedFile `json:"generated_files" mapstructure:"generated_files"`
+	// Limits defines the SFTP protocol limits advertised to the clients
+	// supporting the "limits@openssh.com" extension
+	Limits LimitsConfig `json:"limits" mapstructure:"limits"`
+	// ClientVersionRules allows to deny, throttle or log the clients based on
+	// their SSH identification string. The first matching rule is applied
+	ClientVersionRules []ClientVersionRule `json:"client_version_rules" mapstructure:"client_version_rules"`
 	// List of enabled SSH commands.
 	// We support the following SSH commands:
 	// - "scp". SCP is an experimental feature, we have our own SCP implementation since
@@ -182,17 +298,48 @@ type Configuration struct {
 	KeyboardInteractiveHook string `json:"keyboard_interactive_auth_hook" mapstructure:"keyboard_interactive_auth_hook"`
 	// PasswordAuthentication specifies whether password authentication is allowed.
 	PasswordAuthentication bool `json:"password_authentication" mapstructure:"password_authentication"`
-	certChecker            *ssh.CertChecker
-	parsedUserCAKeys       []ssh.PublicKey
+	// UniformAuthFailures hides whether a user exists. For non-existent users a
+	// dummy password hash verification is performed, the builtin keyboard
+	// interactive prompt is sent and the same error is returned to the SSH layer.
+	// The defender events and the logs still distinguish non-existent users
+	UniformAuthFailures bool `json:"uniform_auth_failures" mapstructure:"uniform_auth_failures"`
+	// OperationsLatencyMetrics enables the metric for the SFTP operations latency.
+	// Disable it to avoid the, small, instrumentation overhead for each SFTP request
+	OperationsLatencyMetrics bool `json:"operations_latency_metrics" mapstructure:"operations_latency_metrics"`
+	// SessionRecording defines the recording of the SFTP requests and the
+	// SSH commands for the users with the session recording filter enabled
+	SessionRecording SessionRecordingConfig `json:"session_recording" mapstructure:"session_recording"`
+	// AuxiliaryFilesCheckInterval defines, in seconds, how often the login banner,
+	// the keyboard interactive hook, the revoked certificates and the trusted CA
+	// key files are checked. 0 means disabled
+	AuxiliaryFilesCheckInterval int `json:"auxiliary_files_check_interval" mapstructure:"auxiliary_files_check_interval"`
+	certChecker                 *ssh.CertChecker
+	parsedUserCAKeys            []ssh.PublicKey
+	// last update time of the provider configs merged with the configured algorithms
+	providerConfigsUpdatedAt int64
+}
+
+// sshAlgorithms groups the configurable SSH algorithms
+type sshAlgorithms struct {
+	hostKeys   []string
+	publicKeys []string
+	kex        []string
+	ciphers    []string
+	macs       []string
 }
 
 type authenticationError struct {
 	err         error
 	loginMethod string
 	username    string
+	// if true the wrapped error is hidden, it is still available using Unwrap
+	uniform bool
 }
 
 func (e *authenticationError) Error() string {
+	if e.uniform {
+		return "Authentication error: invalid credentials"
+	}
 	return fmt.Sprintf("Authentication error: %v", e.err)
 }
 
@@ -219,6 +366,12 @@ func newAuthenticationError(err error, loginMethod, username string) *authentica
 	return &authenticationError{err: err, loginMethod: loginMethod, username: username}
 }
 
+func (c *Configuration) newAuthenticationError(err error, loginMethod, username string) *authenticationError {
+	authErr := newAuthenticationError(err, loginMethod, username)
+	authErr.uniform = c.UniformAuthFailures
+	return authErr
+}
+
 // ShouldBind returns true if there is at least a valid binding
 func (c *Configuration) ShouldBind() bool {
 	for _, binding := range c.Bindings {
@@ -241,7 +394,7 @@ func (c *Configuration) getServerConfig() *ssh.ServerConfig {
 				return sp, err
 			}
 			if err != nil {
-				return nil, newAuthenticationError(fmt.Errorf("could not validate public key credentials: %w", err),
+				return nil, c.newAuthenticationError(fmt.Errorf("could not validate public key credentials: %w", err),
 					dataprovider.SSHLoginMethodPublicKey, conn.User())
 			}
 
@@ -254,24 +407,77 @@ func (c *Configuration) getServerConfig() *ssh.ServerConfig {
 		serverConfig.PasswordCallback = func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
 			return c.validatePasswordCredentials(conn, password, dataprovider.LoginMethodPassword)
 		}
-		serviceStatus.Authentications = append(serviceStatus.Authentications, dataprovider.LoginMethodPassword)
+		c.addSupportedAuthentication(dataprovider.LoginMethodPassword)
 	}
-	serviceStatus.Authentications = append(serviceStatus.Authentications, dataprovider.SSHLoginMethodPublicKey)
+	c.addSupportedAuthentication(dataprovider.SSHLoginMethodPublicKey)
 
 	return serverConfig
 }
 
+func (c *Configuration) addSupportedAuthentication(method string) {
+	updateServiceStatus(func(s *ServiceStatus) {
+		s.Authentications = append(slices.Clone(s.Authentications), method)
+	})
+}
+
 func (c *Configuration) updateSupportedAuthentications() {
-	serviceStatus.Authentications = util.RemoveDuplicates(serviceStatus.Authentications, false)
+	updateServiceStatus(func(s *ServiceStatus) {
+		authentications := util.RemoveDuplicates(slices.Clone(s.Authentications), false)
 
-	if slices.Contains(serviceStatus.Authentications, dataprovider.LoginMethodPassword) &&
-		slices.Contains(serviceStatus.Authentications, dataprovider.SSHLoginMethodPublicKey) {
-		serviceStatus.Authentications = append(serviceStatus.Authentications, dataprovider.SSHLoginMethodKeyAndPassword)
+		if slices.Contains(authentications, dataprovider.LoginMethodPassword) &&
+			slices.Contains(authentications, dataprovider.SSHLoginMethodPublicKey) {
+			authentications = append(authentications, dataprovider.SSHLoginMethodKeyAndPassword)
+		}
+
+		if slices.Contains(authentications, dataprovider.SSHLoginMethodKeyboardInteractive) &&
+			slices.Contains(authentications, dataprovider.SSHLoginMethodPublicKey) {
+			authentications = append(authentications, dataprovider.SSHLoginMethodKeyAndKeyboardInt)
+		}
+		s.Authentications = authentications
+	})
+}
+
+func (c *Configuration) getAlgorithms() sshAlgorithms {
+	return sshAlgorithms{
+		hostKeys:   slices.Clone(c.HostKeyAlgorithms),
+		publicKeys: slices.Clone(c.PublicKeyAlgorithms),
+		kex:        slices.Clone(c.KexAlgorithms),
+		ciphers:    slices.Clone(c.Ciphers),
+		macs:       slices.Clone(c.MACs),
 	}
+}
 
-	if slices.Contains(serviceStatus.Authentications, dataprovider.SSHLoginMethodKeyboardInteractive) &&
-		slices.Contains(serviceStatus.Authentications, dataprovider.SSHLoginMethodPublicKey) {
-		serviceStatus.Authentications = append(serviceStatus.Authentications, dataprovider.SSHLoginMethodKeyAndKeyboardInt)
+func (a sshAlgorithms) equal(other sshAlgorithms) bool {
+	return slices.Equal(a.hostKeys, other.hostKeys) && slices.Equal(a.publicKeys, other.publicKeys) &&
+		slices.Equal(a.kex, other.kex) && slices.Equal(a.ciphers, other.ciphers) && slices.Equal(a.macs, other.macs)
+}
+
+func (c *Configuration) setAlgorithms(algos sshAlgorithms) {
+	c.HostKeyAlgorithms = slices.Clone(algos.hostKeys)
+	c.PublicKeyAlgorithms = slices.Clone(algos.publicKeys)
+	c.KexAlgorithms = slices.Clone(algos.kex)
+	c.Ciphers = slices.Clone(algos.ciphers)
+	c.MACs = slices.Clone(algos.macs)
+}
+
+// overrideAlgorithms replaces the configured algorithms with the non-empty
+// lists defined in the data provider
+func (c *Configuration) overrideAlgorithms(configs *dataprovider.SFTPDConfigs) {
+	logger.Info(logSender, "", "the algorithms defined in the data provider override the configured ones")
+	if len(configs.HostKeyAlgos) > 0 {
+		c.HostKeyAlgorithms = slices.Clone(configs.HostKeyAlgos)
+	}
+	if len(configs.PublicKeyAlgos) > 0 {
+		c.PublicKeyAlgorithms = slices.Clone(configs.PublicKeyAlgos)
+	}
+	if len(configs.KexAlgorithms) > 0 {
+		c.KexAlgorithms = slices.Clone(configs.KexAlgorithms)
+	}
+	if len(configs.Ciphers) > 0 {
+		c.Ciphers = slices.Clone(configs.Ciphers)
+	}
+	if len(configs.MACs) > 0 {
+		c.MACs = slices.Clone(configs.MACs)
 	}
 }
 
@@ -281,45 +487,62 @@ func (c *Configuration) loadFromProvider() error {
 		return fmt.Errorf("unable to load config from provider: %w", err)
 	}
 	configs.SetNilsToEmpty()
-	if len(configs.SFTPD.HostKeyAlgos) > 0 {
+	c.providerConfigsUpdatedAt = configs.UpdatedAt
+	c.applyProviderConfigs(configs.SFTPD)
+	return nil
+}
+
+// applyProviderConfigs merges the algorithms defined in the data provider
+// with the configured ones according to the merge policy
+func (c *Configuration) applyProviderConfigs(configs *dataprovider.SFTPDConfigs) {
+	if configs.IsOverride() {
+		c.overrideAlgorithms(configs)
+		return
+	}
+	if len(configs.HostKeyAlgos) > 0 {
 		if len(c.HostKeyAlgorithms) == 0 {
 			c.HostKeyAlgorithms = preferredHostKeyAlgos
 		}
-		c.HostKeyAlgorithms = append(c.HostKeyAlgorithms, configs.SFTPD.HostKeyAlgos...)
+		c.HostKeyAlgorithms = append(c.HostKeyAlgorithms, configs.HostKeyAlgos...)
 	}
-	if len(configs.SFTPD.PublicKeyAlgos) > 0 {
+	if len(configs.PublicKeyAlgos) > 0 {
 		if len(c.PublicKeyAlgorithms) == 0 {
 			c.PublicKeyAlgorithms = preferredPublicKeyAlgos
 		}
-		c.PublicKeyAlgorithms = append(c.PublicKeyAlgorithms, configs.SFTPD.PublicKeyAlgos...)
+		c.PublicKeyAlgorithms = append(c.PublicKeyAlgorithms, configs.PublicKeyAlgos...)
 	}
-	if len(configs.SFTPD.KexAlgorithms) > 0 {
+	if len(configs.KexAlgorithms) > 0 {
 		if len(c.KexAlgorithms) == 0 {
 			c.KexAlgorithms = preferredKexAlgos
 		}
-		c.KexAlgorithms = append(c.KexAlgorithms, configs.SFTPD.KexAlgorithms...)
+		c.KexAlgorithms = append(c.KexAlgorithms, configs.KexAlgorithms...)
 	}
-	if len(configs.SFTPD.Ciphers) > 0 {
+	if len(configs.Ciphers) > 0 {
 		if len(c.Ciphers) == 0 {
 			c.Ciphers = preferredCiphers
 		}
-		c.Ciphers = append(c.Ciphers, configs.SFTPD.Ciphers...)
+		c.Ciphers = append(c.Ciphers, configs.Ciphers...)
 	}
-	if len(configs.SFTPD.MACs) > 0 {
+	if len(configs.MACs) > 0 {
 		if len(c.MACs) == 0 {
 			c.MACs = preferredMACs
 		}
-		c.MACs = append(c.MACs, configs.SFTPD.MACs...)
+		c.MACs = append(c.MACs, configs.MACs...)
 	}
-	return nil
 }
 
 // Initialize the SFTP server and add a persistent listener to handle inbound SFTP connections.
 func (c *Configuration) Initialize(configDir string) error {
+	// the algorithms defined in the configuration file are merged with the ones
+	// defined in the data provider each time the provider configs change
+	fileAlgorithms := c.getAlgorithms()
 	if err := c.loadFromProvider(); err != nil {
 		return fmt.Errorf("unable to load configs from provider: %w", err)
 	}
-	serviceStatus = ServiceStatus{}
+	updateServiceStatus(func(s *ServiceStatus) {
+		*s = ServiceStatus{}
+	})
+	setListeners(nil)
 	serverConfig := c.getServerConfig()
 
 	if !c.ShouldBind() {
@@ -331,68 +554,125 @@ func (c *Configuration) Initialize(configDir string) error {
 		ssh.GetDHKexServerMinBits())
 	sftp.SetSFTPExtensions(sftpExtensions...) //nolint:errcheck // we configure valid SFTP Extensions so we cannot get an error
 	sftp.MaxFilelist = 250
+	metric.EnableSFTPOperationsLatency(c.OperationsLatencyMetrics)
 
 	if err := c.configureSecurityOptions(serverConfig); err != nil {
 		return err
 	}
-	if err := c.checkAndLoadHostKeys(configDir, serverConfig); err != nil {
-		serviceStatus.HostKeys = nil
-		return err
-	}
+	updateServiceStatus(c.setSecurityOptionsStatus)
 	if err := c.initializeCertChecker(configDir); err != nil {
 		return err
 	}
 	c.configureKeyboardInteractiveAuth(serverConfig)
 	c.configureLoginBanner(serverConfig, configDir)
+	if err := c.MOTD.initialize(configDir); err != nil {
+		return err
+	}
+	if err := c.initializeGeneratedFiles(); err != nil {
+		return err
+	}
+	if err := c.SessionRecording.initialize(configDir); err != nil {
+		return err
+	}
+	if err := c.Limits.initialize(); err != nil {
+		return err
+	}
+	if err := c.initializeClientVersionRules(); err != nil {
+		return err
+	}
+	if err := c.initializeAuxiliaryFilesChecker(configDir); err != nil {
+		return err
+	}
+	for idx := range c.Bindings {
+		if err := c.Bindings[idx].validateNetwork(); err != nil {
+			return err
+		}
+		if err := c.Bindings[idx].validateSocketOptions(); err != nil {
+			return err
+		}
+	}
+	// the default host keys, if generated, are added to the configuration
+	// before it is copied to load the host keys
+	if err := c.checkHostKeyAutoGeneration(configDir); err != nil {
+		return err
+	}
+	// host keys must be loaded last, the server config without host keys is
+	// used as base to reload them
+	hostKeys := newHostKeysManager(c, configDir, serverConfig)
+	hostKeys.fileAlgorithms = fileAlgorithms
+	if err := hostKeys.start(); err != nil {
+		updateServiceStatus(func(s *ServiceStatus) {
+			s.HostKeys = nil
+		})
+		return err
+	}
+	setHostKeysManager(hostKeys)
 	c.checkSSHCommands()
 
 	exitChannel := make(chan error, 1)
-	serviceStatus.Bindings = nil
+	var bindings []Binding
+	var listenersStatus []*listenerStatus
 
 	for _, binding := range c.Bindings {
 		if !binding.IsValid() {
 			continue
 		}
-		serviceStatus.Bindings = append(serviceStatus.Bindings, binding)
+		bindings = append(bindings, binding)
+		status := newListenerStatus(binding)
+		listenersStatus = append(listenersStatus, status)
 
-		go func(binding Binding) {
+		go func(binding Binding, status *listenerStatus) {
 			addr := binding.GetAddress()
-			util.CheckTCP4Port(binding.Port)
-			listener, err := net.Listen("tcp", addr)
+			if binding.Port > 0 && binding.GetNetwork() != "tcp6" {
+				util.CheckTCP4Port(binding.Port)
+			}
+			listener, err := binding.getListenConfig().Listen(context.Background(), binding.GetNetwork(), addr)
 			if err != nil {
 				logger.Warn(logSender, "", "error starting listener on address %v: %v", addr, err)
+				status.setError(err)
 				exitChannel <- err
 				return
 			}
+			listener = &socketOptionsListener{
+				Listener: listener,
+				binding:  binding,
+			}
 
-			if binding.ApplyProxyConfig && common.Config.ProxyProtocol > 0 {
-				proxyListener, err := common.Config.GetProxyListener(listener)
+			if binding.HasProxy() {
+				proxyListener, err := common.Config.GetProxyListenerWithPolicy(listener, binding.GetProxyProtocol())
 				if err != nil {
 					logger.Warn(logSender, "", "error enabling proxy listener: %v", err)
+					status.setError(err)
 					exitChannel <- err
 					return
 				}
 				listener = proxyListener
 			}
 
-			exitChannel <- c.serve(listener, serverConfig)
-		}(binding)
+			exitChannel <- c.serve(listener, hostKeys, status)
+		}(binding, status)
 	}
+	setListeners(listenersStatus)
 
-	serviceStatus.IsActive = true
-	serviceStatus.SSHCommands = c.EnabledSSHCommands
+	updateServiceStatus(func(s *ServiceStatus) {
+		s.Bindings = bindings
+		s.IsActive = true
+		s.SSHCommands = c.EnabledSSHCommands
+	})
 	c.updateSupportedAuthentications()
 
 	return <-exitChannel
 }
 
-func (c *Configuration) serve(listener net.Listener, serverConfig *ssh.ServerConfig) error {
+func (c *Configuration) serve(listener net.Listener, hostKeys *hostKeysManager, status *listenerStatus) error {
 	logger.Info(logSender, "", "server listener registered, address: %s", listener.Addr().String())
+	status.setStarted(listener.Addr())
 	var tempDelay time.Duration // how long to sleep on accept failure
 
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
+			status.addAcceptError()
 			// see https://github.com/golang/go/blob/4aa1efed4853ea067d665a952eee77c52faac774/src/net/http/server.go#L3046
 			if ne, ok := err.(net.Error); ok && ne.Temporary() { //nolint:staticcheck
 				if tempDelay == 0 {
@@ -408,11 +688,17 @@ func (c *Configuration) serve(listener net.Listener, serverConfig *ssh.ServerCon
 				continue
 			}
 			logger.Warn(logSender, "", "unrecoverable accept error: %v", err)
+			status.setError(err)
 			return err
 		}
 		tempDelay = 0
 
-		go c.AcceptInboundConnection(conn, serverConfig)
+		status.addConnections(1)
+		go func(conn net.Conn) {
+			defer status.addConnections(-1)
+
+			c.AcceptInboundConnection(conn, hostKeys.getServerConfig())
+		}(conn)
 	}
 }
 
@@ -439,7 +725,6 @@ func (c *Configuration) configureKeyAlgos(serverConfig *ssh.ServerConfig) error
 		c.PublicKeyAlgorithms = preferredPublicKeyAlgos
 	}
 	serverConfig.PublicKeyAuthAlgorithms = c.PublicKeyAlgorithms
-	serviceStatus.PublicKeyAlgorithms = c.PublicKeyAlgorithms
 
 	return nil
 }
@@ -482,7 +767,6 @@ func (c *Configuration) configureSecurityOptions(serverConfig *ssh.ServerConfig)
 		c.checkKeyExchangeAlgorithms()
 	}
 	serverConfig.KeyExchanges = c.KexAlgorithms
-	serviceStatus.KexAlgorithms = c.KexAlgorithms
 
 	if len(c.Ciphers) > 0 {
 		c.Ciphers = util.RemoveDuplicates(c.Ciphers, true)
@@ -495,7 +779,6 @@ func (c *Configuration) configureSecurityOptions(serverConfig *ssh.ServerConfig)
 		c.Ciphers = preferredCiphers
 	}
 	serverConfig.Ciphers = c.Ciphers
-	serviceStatus.Ciphers = c.Ciphers
 
 	if len(c.MACs) > 0 {
 		c.MACs = util.RemoveDuplicates(c.MACs, true)
@@ -508,28 +791,55 @@ func (c *Configuration) configureSecurityOptions(serverConfig *ssh.ServerConfig)
 		c.MACs = preferredMACs
 	}
 	serverConfig.MACs = c.MACs
-	serviceStatus.MACs = c.MACs
 
 	return nil
 }
 
+// setSecurityOptionsStatus reports the configured algorithms in the specified
+// service status, the caller must hold the service status lock
+func (c *Configuration) setSecurityOptionsStatus(s *ServiceStatus) {
+	s.HostKeyAlgorithms = c.HostKeyAlgorithms
+	s.PublicKeyAlgorithms = c.PublicKeyAlgorithms
+	s.KexAlgorithms = c.KexAlgorithms
+	s.Ciphers = c.Ciphers
+	s.MACs = c.MACs
+	s.ConfigsGeneration++
+	s.ConfigsAppliedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+
+	logger.Info(logSender, "", "effective algorithms, host keys: %+v, public keys: %+v, KEXs: %+v, ciphers: %+v, MACs: %+v",
+		c.HostKeyAlgorithms, c.PublicKeyAlgorithms, c.KexAlgorithms, c.Ciphers, c.MACs)
+}
+
+func (c *Configuration) initializeGeneratedFiles() error {
+	files, err := common.ValidateGeneratedFiles(c.GeneratedFiles)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if c.MOTD.isVirtualFile(f.Path) {
+			return fmt.Errorf("generated file %q conflicts with the MOTD virtual file", f.Path)
+		}
+		logger.Debug(logSender, "", "generated file %q configured, generator: %q", f.Path, f.Generator)
+	}
+	c.GeneratedFiles = files
+	return nil
+}
+
+// configureLoginBanner sets the callback for the login banner. The callback is
+// always set since the users can have their own banner
 func (c *Configuration) configureLoginBanner(serverConfig *ssh.ServerConfig, configDir string) {
+	var bannerFilePath string
 	if c.LoginBannerFile != "" {
-		bannerFilePath := c.LoginBannerFile
+		bannerFilePath = c.LoginBannerFile
 		if !filepath.IsAbs(bannerFilePath) {
 			bannerFilePath = filepath.Join(configDir, bannerFilePath)
 		}
-		bannerContent, err := os.ReadFile(bannerFilePath)
-		if err == nil {
-			banner := util.BytesToString(bannerContent)
-			serverConfig.BannerCallback = func(_ ssh.ConnMetadata) string {
-				return banner
-			}
-		} else {
+		if _, err := os.ReadFile(bannerFilePath); err != nil {
 			logger.WarnToConsole("unable to read SFTPD login banner file: %v", err)
 			logger.Warn(logSender, "", "unable to read login banner file: %v", err)
 		}
 	}
+	serverConfig.BannerCallback = newLoginBanner(bannerFilePath).callback
 }
 
 func (c *Configuration) configureKeyboardInteractiveAuth(serverConfig *ssh.ServerConfig) {
@@ -559,7 +869,7 @@ func (c *Configuration) configureKeyboardInteractiveAuth(serverConfig *ssh.Serve
 		return c.validateKeyboardInteractiveCredentials(conn, client, dataprovider.SSHLoginMethodKeyboardInteractive, false)
 	}
 
-	serviceStatus.Authentications = append(serviceStatus.Authentications, dataprovider.SSHLoginMethodKeyboardInteractive)
+	c.addSupportedAuthentication(dataprovider.SSHLoginMethodKeyboardInteractive)
 }
 
 // AcceptInboundConnection handles an inbound connection to the server instance and determines if the request should be served or not.
@@ -570,19 +880,36 @@ func (c *Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.Serve
 		}
 	}()
 
+	if common.IsProxyHeaderMissing(conn) {
+		logger.Warn(logSender, "", "connection from %q dropped: proxy protocol header required but not received",
+			conn.RemoteAddr().String())
+		common.EmitConnectionDenied(util.GetIPFromRemoteAddress(conn.RemoteAddr().String()), conn.LocalAddr().String(),
+			common.ProtocolSSH, common.ConnectionDenyReasonProxyHeader)
+		conn.Close()
+		return
+	}
+	// record the transport peer and if the client address comes from a trusted proxy
+	defer common.TrackConnectionSource(conn)()
 	ipAddr := util.GetIPFromRemoteAddress(conn.RemoteAddr().String())
 	common.Connections.AddClientConnection(ipAddr)
 	defer common.Connections.RemoveClientConnection(ipAddr)
 
-	if !canAcceptConnection(ipAddr) {
+	if ok, reason := canAcceptConnection(ipAddr, conn.LocalAddr().String()); !ok {
+		common.EmitConnectionDenied(ipAddr, conn.LocalAddr().String(), common.ProtocolSSH, reason)
 		conn.Close()
 		return
 	}
+	common.EmitConnectionAccepted(ipAddr, conn.LocalAddr().String(), common.ProtocolSSH)
+	var counters *countingConn
+	if logger.IsEventStreamEnabled() {
+		counters = &countingConn{Conn: conn}
+		conn = counters
+	}
 	// Before beginning a handshake must be performed on the incoming net.Conn
 	// we'll set a Deadline for handshake to complete, the default is 2 minutes as OpenSSH
 	conn.SetDeadline(time.Now().Add(handshakeTimeout)) //nolint:errcheck
 
-	sconn, chans, reqs, err := ssh.NewServerConn(conn, config)
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, c.getServerConfigForConn(config, conn, ipAddr))
 	if err != nil {
 		logger.Debug(logSender, "", "failed to accept an incoming connection from ip %q: %v", ipAddr, err)
 		checkAuthError(ipAddr, err)
@@ -609,38 +936,79 @@ func (c *Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.Serve
 		return
 	}
 
+	algos := sconn.Conn.(ssh.AlgorithmsConnMetadata).Algorithms()
+	algoFields := logger.SSHConnectionFields{
+		KeyExchange: algos.KeyExchange,
+		Cipher:      algos.Read.Cipher,
+		MAC:         algos.Read.MAC,
+		HostKeyAlgo: algos.HostKey,
+	}
+	common.Config.NotifyPostConnectHandshake(&common.PostConnectInfo{
+		IP:            ipAddr,
+		Protocol:      common.ProtocolSSH,
+		LocalAddress:  conn.LocalAddr().String(),
+		ClientVersion: util.BytesToString(sconn.ClientVersion()),
+		KeyExchange:   algoFields.KeyExchange,
+		Cipher:        algoFields.Cipher,
+		MAC:           algoFields.MAC,
+		HostKeyAlgo:   algoFields.HostKeyAlgo,
+	}, connectionID)
+	// the login log already includes the client version and the login method
 	logger.LoginLog(user.Username, ipAddr, loginType, common.ProtocolSSH, connectionID,
-		util.BytesToString(sconn.ClientVersion()), true,
-		fmt.Sprintf("negotiated algorithms: %+v", sconn.Conn.(ssh.AlgorithmsConnMetadata).Algorithms()))
+		util.BytesToString(sconn.ClientVersion()), true, &algoFields)
+	sessionStart := time.Now()
+	channelCounter := int64(0)
+	common.EmitSessionStart(connectionID, ipAddr, common.ProtocolSSH, user.Username,
+		util.BytesToString(sconn.ClientVersion()))
+	if counters != nil {
+		defer func() {
+			common.EmitSessionEnd(connectionID, ipAddr, common.ProtocolSSH, user.Username, sessionStart,
+				common.SessionCounters{
+					Channels:      channelCounter,
+					BytesReceived: counters.bytesReceived.Load(),
+					BytesSent:     counters.bytesSent.Load(),
+				})
+		}()
+	}
+
+	logFields := algoFields
+	logFields.ClientVersion = util.BytesToString(sconn.ClientVersion())
+	logFields.LoginMethod = loginType
+	forceDebugLog := common.IsDebugLogForced(user.Username, ipAddr)
 
 	dataprovider.UpdateLastLogin(&user)
+	defer common.HandleSessionEnd(&user, ipAddr, common.ProtocolSSH)
+
+	traceCtx, span := tracing.StartConnectionSpan(connectionID, user.Username, ipAddr, logFields.ClientVersion)
+	defer span.End()
 
 	sshConnection := common.NewSSHConnection(connectionID, sconn)
 	common.Connections.AddSSHConnection(sshConnection)
 
 	defer common.Connections.RemoveSSHConnection(connectionID)
 
-	channelCounter := int64(0)
 	for newChannel := range chans {
 		// If its not a session channel we just move on because its not something we
 		// know how to handle at this point.
 		if newChannel.ChannelType() != "session" {
-			logger.Log(logger.LevelDebug, common.ProtocolSSH, connectionID, "received an unknown channel type: %v",
-				newChannel.ChannelType())
+			logger.ConnectionLog(logger.LevelDebug, common.ProtocolSSH, connectionID, forceDebugLog, &logFields,
+				"received an unknown channel type: %v", newChannel.ChannelType())
 			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type") //nolint:errcheck
 			continue
 		}
 
 		channel, requests, err := newChannel.Accept()
 		if err != nil {
-			logger.Log(logger.LevelWarn, common.ProtocolSSH, connectionID, "could not accept a channel: %v", err)
+			logger.ConnectionLog(logger.LevelWarn, common.ProtocolSSH, connectionID, forceDebugLog, &logFields,
+				"could not accept a channel: %v", err)
 			continue
 		}
 
 		channelCounter++
 		sshConnection.UpdateLastActivity()
 		// Channels have a type that is dependent on the protocol. For SFTP this is "subsystem"
-		// with a payload that (should) be "sftp". Discard anything else we receive ("pty", "shell", etc)
+		// with a payload that (should) be "sftp". Discard anything else we receive ("pty", etc),
+		// a "shell" request is only accepted to send the message of the day
 		go func(in <-chan *ssh.Request, counter int64) {
 			for req := range in {
 				ok := false
@@ -653,11 +1021,17 @@ func (c *Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.Serve
 						connection := &Connection{
 							BaseConnection: common.NewBaseConnection(connID, common.ProtocolSFTP, conn.LocalAddr().String(),
 								conn.RemoteAddr().String(), user),
-							ClientVersion: util.BytesToString(sconn.ClientVersion()),
-							RemoteAddr:    conn.RemoteAddr(),
-							LocalAddr:     conn.LocalAddr(),
-							channel:       channel,
+							ClientVersion:   util.BytesToString(sconn.ClientVersion()),
+							RemoteAddr:      conn.RemoteAddr(),
+							LocalAddr:       conn.LocalAddr(),
+							channel:         channel,
+							logFields:       &logFields,
+							motd:            &c.MOTD,
+							recordingConfig: &c.SessionRecording,
 						}
+						connection.SetGeneratedFiles(c.GeneratedFiles)
+						connection.maxHandles = c.Limits.getMaxOpenHandles()
+						tracing.AddConnectionContext(traceCtx, connID)
 						go c.handleSftpConnection(channel, connection)
 					}
 				case "exec":
@@ -665,12 +1039,33 @@ func (c *Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.Serve
 					connection := Connection{
 						BaseConnection: common.NewBaseConnection(connID, "sshd_exec", conn.LocalAddr().String(),
 							conn.RemoteAddr().String(), user),
+						ClientVersion:   util.BytesToString(sconn.ClientVersion()),
+						RemoteAddr:      conn.RemoteAddr(),
+						LocalAddr:       conn.LocalAddr(),
+						channel:         channel,
+						logFields:       &logFields,
+						motd:            &c.MOTD,
+						recordingConfig: &c.SessionRecording,
+					}
+					ok = processSSHCommand(req.Payload, &connection, c.EnabledSSHCommands)
+				case "shell":
+					// shells are not supported, we only send the message of the day, if any
+					connection := &Connection{
+						BaseConnection: common.NewBaseConnection(connID, common.ProtocolSSH, conn.LocalAddr().String(),
+							conn.RemoteAddr().String(), user),
 						ClientVersion: util.BytesToString(sconn.ClientVersion()),
 						RemoteAddr:    conn.RemoteAddr(),
 						LocalAddr:     conn.LocalAddr(),
 						channel:       channel,
+						logFields:     &logFields,
+						motd:          &c.MOTD,
+					}
+					if len(connection.getMOTD()) > 0 {
+						ok = true
+						go connection.handleShell(channel)
+					} else {
+						connection.CloseFS() //nolint:errcheck
 					}
-					ok = processSSHCommand(req.Payload, &connection, c.EnabledSSHCommands)
 				}
 				if req.WantReply {
 					req.Reply(ok, nil) //nolint:errcheck
@@ -686,6 +1081,8 @@ func (c *Configuration) handleSftpConnection(channel ssh.Channel, connection *Co
 			logger.Error(logSender, "", "panic in handleSftpConnection: %q stack trace: %v", r, string(debug.Stack()))
 		}
 	}()
+	defer tracing.RemoveConnectionContext(connection.GetID())
+
 	if err := common.Connections.Add(connection); err != nil {
 		errClose := connection.Disconnect()
 		logger.Info(logSender, "", "unable to add connection: %v, close err: %v", err, errClose)
@@ -693,9 +1090,17 @@ func (c *Configuration) handleSftpConnection(channel ssh.Channel, connection *Co
 	}
 	defer common.Connections.Remove(connection.GetID())
 
+	if err := connection.startRecording(); err != nil {
+		connection.Disconnect() //nolint:errcheck
+		return
+	}
+	defer connection.stopRecording()
+
 	// Create the server instance for the channel using the handler we created above.
-	server := sftp.NewRequestServer(channel, c.createHandlers(connection),
-		sftp.WithStartDirectory(connection.User.Filters.StartDirectory))
+	server := sftp.NewRequestServer(newLimitsChannel(newDisconnectChannel(channel, connection), &c.Limits),
+		c.createHandlers(connection),
+		sftp.WithStartDirectory(connection.User.Filters.StartDirectory),
+		sftp.WithRSMaxTxPacket(uint32(c.Limits.MaxReadLength)))
 
 	defer server.Close()
 	if err := server.Serve(); errors.Is(err, io.EOF) {
@@ -707,6 +1112,30 @@ func (c *Configuration) handleSftpConnection(channel ssh.Channel, connection *Co
 	}
 }
 
+// disconnectChannel signals the connection as soon as reading from the channel
+// fails, so the running filesystem operations are canceled without waiting
+// for the request server to exit. The request server waits for the pending
+// requests before returning
+type disconnectChannel struct {
+	io.ReadWriteCloser
+	connection *Connection
+}
+
+func newDisconnectChannel(channel io.ReadWriteCloser, connection *Connection) *disconnectChannel {
+	return &disconnectChannel{
+		ReadWriteCloser: channel,
+		connection:      connection,
+	}
+}
+
+func (c *disconnectChannel) Read(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Read(p)
+	if err != nil {
+		c.connection.SignalClientDisconnected()
+	}
+	return n, err
+}
+
 func (c *Configuration) createHandlers(connection *Connection) sftp.Handlers {
 	return sftp.Handlers{
 		FileGet:  connection,
@@ -716,23 +1145,47 @@ func (c *Configuration) createHandlers(connection *Connection) sftp.Handlers {
 	}
 }
 
-func canAcceptConnection(ip string) bool {
+// canAcceptConnection returns false and the reason if the connection must be refused
+func canAcceptConnection(ip, localAddr string) (bool, string) {
 	if common.IsBanned(ip, common.ProtocolSSH) {
 		logger.Log(logger.LevelDebug, common.ProtocolSSH, "", "connection refused, ip %q is banned", ip)
-		return false
+		return false, common.ConnectionDenyReasonBanned
 	}
 	if err := common.Connections.IsNewConnectionAllowed(ip, common.ProtocolSSH); err != nil {
 		logger.Log(logger.LevelDebug, common.ProtocolSSH, "", "connection not allowed from ip %q: %v", ip, err)
-		return false
+		return false, common.ConnectionDenyReasonLimit
 	}
 	_, err := common.LimitRate(common.ProtocolSSH, ip)
 	if err != nil {
-		return false
+		return false, common.ConnectionDenyReasonRateLimited
 	}
-	if err := common.Config.ExecutePostConnectHook(ip, common.ProtocolSSH); err != nil {
-		return false
+	if err := common.Config.ExecutePostConnectHookWithInfo(&common.PostConnectInfo{
+		IP:           ip,
+		Protocol:     common.ProtocolSSH,
+		LocalAddress: localAddr,
+	}); err != nil {
+		return false, common.ConnectionDenyReasonPostConnectHook
 	}
-	return true
+	return true, ""
+}
+
+// countingConn counts the bytes received and sent on a connection
+type countingConn struct {
+	net.Conn
+	bytesReceived atomic.Int64
+	bytesSent     atomic.Int64
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.bytesReceived.Add(int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.bytesSent.Add(int64(n))
+	return n, err
 }
 
 func discardAllChannels(in <-chan ssh.NewChannel, message, connectionID string) {
@@ -757,16 +1210,21 @@ func checkAuthError(ip string, err error) {
 						logEv = notifier.LogEventTypeLoginNoUser
 					}
 					common.AddDefenderEvent(ip, common.ProtocolSSH, event)
+					common.UpdateAccountLockout(sftpAuthErr.getUsername(), ip, common.ProtocolSSH, err)
 					plugin.Handler.NotifyLogEvent(logEv, common.ProtocolSSH, sftpAuthErr.getUsername(), ip, "", err)
+					dataprovider.AddLoginEvent(sftpAuthErr.getUsername(), ip, common.ProtocolSSH,
+						dataprovider.SSHLoginMethodPublicKey, "", err)
 					return
 				}
 			}
 		}
 	} else {
-		logger.ConnectionFailedLog("", ip, dataprovider.LoginMethodNoAuthTried, common.ProtocolSSH, err.Error())
+		logger.ConnectionFailedLog("", ip, dataprovider.LoginMethodNoAuthTried, common.ProtocolSSH, "", err.Error())
 		metric.AddNoAuthTried()
 		common.AddDefenderEvent(ip, common.ProtocolSSH, common.HostEventNoLoginTried)
+		common.EmitAuthAttempt(ip, common.ProtocolSSH, "", dataprovider.LoginMethodNoAuthTried, err)
 		dataprovider.ExecutePostLoginHook(&dataprovider.User{}, dataprovider.LoginMethodNoAuthTried, ip, common.ProtocolSSH, err)
+		dataprovider.AddLoginEvent("", ip, common.ProtocolSSH, dataprovider.LoginMethodNoAuthTried, "", err)
 		logEv := notifier.LogEventTypeNoLoginTried
 		var negotiationError *ssh.AlgorithmNegotiationError
 		if errors.As(err, &negotiationError) {
@@ -803,17 +1261,26 @@ func loginUser(user *dataprovider.User, loginMethod, publicKey string, conn ssh.
 			user.Username, loginMethod)
 		return nil, fmt.Errorf("login method %q is not allowed for user %q", loginMethod, user.Username)
 	}
-	if user.MustSetSecondFactorForProtocol(common.ProtocolSSH) {
+	remoteAddr := util.GetIPFromRemoteAddress(conn.RemoteAddr().String())
+	if user.MustSetSecondFactorForProtocol(common.ProtocolSSH) && !user.IsSecondFactorSkipped(remoteAddr, common.ProtocolSSH) {
 		logger.Info(logSender, connectionID, "cannot login user %q, second factor authentication is not set",
 			user.Username)
 		return nil, fmt.Errorf("second factor authentication is not set for user %q", user.Username)
 	}
-	remoteAddr := util.GetIPFromRemoteAddress(conn.RemoteAddr().String())
 	if !user.IsLoginFromAddrAllowed(remoteAddr) {
 		logger.Info(logSender, connectionID, "cannot login user %q, remote address is not allowed: %v",
 			user.Username, remoteAddr)
 		return nil, fmt.Errorf("login for user %q is not allowed from this address: %v", user.Username, remoteAddr)
 	}
+	source := common.GetConnectionSource(conn.RemoteAddr())
+	if !user.IsSourceAddrProvenanceAllowed(source.Provenance) {
+		logger.Info(logSender, connectionID, "cannot login user %q, the remote address %v is not trusted, peer: %v, provenance: %q",
+			user.Username, remoteAddr, source.PeerIP, source.Provenance)
+		return nil, fmt.Errorf("login for user %q is not allowed from an untrusted address: %v", user.Username, remoteAddr)
+	}
+	if err := common.ConsumeLogin(user, remoteAddr, common.ProtocolSSH); err != nil {
+		return nil, err
+	}
 
 	json, err := json.Marshal(user)
 	if err != nil {
@@ -852,18 +1319,14 @@ func (c *Configuration) checkSSHCommands() {
 func (c *Configuration) generateDefaultHostKeys(configDir string) error {
 	var err error
 	defaultHostKeys := []string{defaultPrivateRSAKeyName, defaultPrivateECDSAKeyName, defaultPrivateEd25519KeyName}
-	for _, k := range defaultHostKeys {
+	for idx, k := range defaultHostKeys {
 		autoFile := filepath.Join(configDir, k)
 		if _, err = os.Stat(autoFile); errors.Is(err, fs.ErrNotExist) {
 			logger.Info(logSender, "", "No host keys configured and %q does not exist; try to create a new host key", autoFile)
 			logger.InfoToConsole("No host keys configured and %q does not exist; try to create a new host key", autoFile)
-			switch k {
-			case defaultPrivateRSAKeyName:
-				err = util.GenerateRSAKeys(autoFile)
-			case defaultPrivateECDSAKeyName:
-				err = util.GenerateECDSAKeys(autoFile)
-			default:
-				err = util.GenerateEd25519Keys(autoFile)
+			err = c.getHostKeyGenerator(k)(autoFile)
+			if err == nil {
+				err = c.encryptGeneratedHostKey(idx, autoFile, configDir)
 			}
 			if err != nil {
 				logger.Warn(logSender, "", "error creating host key %q: %v", autoFile, err)
@@ -877,43 +1340,78 @@ func (c *Configuration) generateDefaultHostKeys(configDir string) error {
 	return err
 }
 
+func (c *Configuration) validateHostKeysGeneration() error {
+	if err := util.ValidateRSAKeySize(c.HostKeyRSABits); err != nil {
+		return fmt.Errorf("invalid host key generation parameters: %w", err)
+	}
+	if _, err := util.GetECDSACurve(c.HostKeyECDSACurve); err != nil {
+		return fmt.Errorf("invalid host key generation parameters: %w", err)
+	}
+	return nil
+}
+
+// getHostKeyGenerator returns the function to generate the host key with the specified
+// file name or nil if the key cannot be generated
+func (c *Configuration) getHostKeyGenerator(keyName string) func(string) error {
+	switch keyName {
+	case defaultPrivateRSAKeyName:
+		return func(file string) error {
+			logger.Info(logSender, "", "generating RSA host key %q, size: %d bits", file, c.getHostKeyRSABits())
+			return util.GenerateRSAKeys(file, c.HostKeyRSABits)
+		}
+	case defaultPrivateECDSAKeyName:
+		return func(file string) error {
+			logger.Info(logSender, "", "generating ECDSA host key %q, curve: %s", file, c.getHostKeyECDSACurve())
+			return util.GenerateECDSAKeys(file, c.HostKeyECDSACurve)
+		}
+	case defaultPrivateEd25519KeyName:
+		return func(file string) error {
+			logger.Info(logSender, "", "generating Ed25519 host key %q", file)
+			return util.GenerateEd25519Keys(file)
+		}
+	default:
+		return nil
+	}
+}
+
+func (c *Configuration) getHostKeyRSABits() int {
+	if c.HostKeyRSABits == 0 {
+		return util.DefaultRSAKeySize
+	}
+	return c.HostKeyRSABits
+}
+
+func (c *Configuration) getHostKeyECDSACurve() string {
+	if c.HostKeyECDSACurve == "" {
+		return util.DefaultECDSACurve
+	}
+	return c.HostKeyECDSACurve
+}
+
 func (c *Configuration) checkHostKeyAutoGeneration(configDir string) error {
-	for _, k := range c.HostKeys {
+	if err := c.validateHostKeysGeneration(); err != nil {
+		return err
+	}
+	for idx, k := range c.HostKeys {
 		k = strings.TrimSpace(k)
 		if filepath.IsAbs(k) {
 			if _, err := os.Stat(k); errors.Is(err, fs.ErrNotExist) {
-				keyName := filepath.Base(k)
-				switch keyName {
-				case defaultPrivateRSAKeyName:
-					logger.Info(logSender, "", "try to create non-existent host key %q", k)
-					logger.InfoToConsole("try to create non-existent host key %q", k)
-					err = util.GenerateRSAKeys(k)
-					if err != nil {
-						logger.Warn(logSender, "", "error creating host key %q: %v", k, err)
-						logger.WarnToConsole("error creating host key %q: %v", k, err)
-						return err
-					}
-				case defaultPrivateECDSAKeyName:
-					logger.Info(logSender, "", "try to create non-existent host key %q", k)
-					logger.InfoToConsole("try to create non-existent host key %q", k)
-					err = util.GenerateECDSAKeys(k)
-					if err != nil {
-						logger.Warn(logSender, "", "error creating host key %q: %v", k, err)
-						logger.WarnToConsole("error creating host key %q: %v", k, err)
-						return err
-					}
-				case defaultPrivateEd25519KeyName:
-					logger.Info(logSender, "", "try to create non-existent host key %q", k)
-					logger.InfoToConsole("try to create non-existent host key %q", k)
-					err = util.GenerateEd25519Keys(k)
-					if err != nil {
-						logger.Warn(logSender, "", "error creating host key %q: %v", k, err)
-						logger.WarnToConsole("error creating host key %q: %v", k, err)
-						return err
-					}
-				default:
+				generate := c.getHostKeyGenerator(filepath.Base(k))
+				if generate == nil {
 					logger.Warn(logSender, "", "non-existent host key %q will not be created", k)
 					logger.WarnToConsole("non-existent host key %q will not be created", k)
+					continue
+				}
+				logger.Info(logSender, "", "try to create non-existent host key %q", k)
+				logger.InfoToConsole("try to create non-existent host key %q", k)
+				err = generate(k)
+				if err == nil {
+					err = c.encryptGeneratedHostKey(idx, k, configDir)
+				}
+				if err != nil {
+					logger.Warn(logSender, "", "error creating host key %q: %v", k, err)
+					logger.WarnToConsole("error creating host key %q: %v", k, err)
+					return err
 				}
 			}
 		}
@@ -937,46 +1435,53 @@ func (c *Configuration) getHostKeyAlgorithms(keyFormat string) []string {
 }
 
 // If no host keys are defined we try to use or generate the default ones.
-func (c *Configuration) checkAndLoadHostKeys(configDir string, serverConfig *ssh.ServerConfig) error {
+// The details for the loaded host keys are returned, the service status is
+// not modified
+func (c *Configuration) checkAndLoadHostKeys(configDir string, serverConfig *ssh.ServerConfig) ([]HostKey, error) {
 	if err := c.checkHostKeyAutoGeneration(configDir); err != nil {
-		return err
+		return nil, err
 	}
 	hostCertificates, err := c.loadHostCertificates(configDir)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	serviceStatus.HostKeys = nil
-	for _, hostKey := range c.HostKeys {
+	var hostKeys []HostKey
+	for idx, hostKey := range c.HostKeys {
 		hostKey = strings.TrimSpace(hostKey)
-		if !util.IsFileInputValid(hostKey) {
-			logger.Warn(logSender, "", "unable to load invalid host key %q", hostKey)
-			logger.WarnToConsole("unable to load invalid host key %q", hostKey)
-			continue
-		}
-		if !filepath.IsAbs(hostKey) {
-			hostKey = filepath.Join(configDir, hostKey)
-		}
-		logger.Info(logSender, "", "Loading private host key %q", hostKey)
+		var private ssh.Signer
+		if strings.HasPrefix(hostKey, providerHostKeyPrefix) {
+			logger.Info(logSender, "", "Loading private host key %q from the data provider", hostKey)
 
-		privateBytes, err := os.ReadFile(hostKey)
-		if err != nil {
-			return err
-		}
+			private, err = c.loadHostKeyFromProvider(strings.TrimPrefix(hostKey, providerHostKeyPrefix))
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			if !util.IsFileInputValid(hostKey) {
+				logger.Warn(logSender, "", "unable to load invalid host key %q", hostKey)
+				logger.WarnToConsole("unable to load invalid host key %q", hostKey)
+				continue
+			}
+			if !filepath.IsAbs(hostKey) {
+				hostKey = filepath.Join(configDir, hostKey)
+			}
+			logger.Info(logSender, "", "Loading private host key %q", hostKey)
 
-		private, err := ssh.ParsePrivateKey(privateBytes)
-		if err != nil {
-			return err
-		}
-		k := HostKey{
-			Path:        hostKey,
-			Fingerprint: ssh.FingerprintSHA256(private.PublicKey()),
-			Algorithms:  c.getHostKeyAlgorithms(private.PublicKey().Type()),
+			passphrase, err := c.getHostKeyPassphrase(idx, configDir)
+			if err != nil {
+				return nil, fmt.Errorf("unable to get the passphrase for host key %q: %w", hostKey, err)
+			}
+			private, err = loadHostKeyFromFile(hostKey, passphrase)
+			if err != nil {
+				return nil, err
+			}
 		}
+		k := newHostKeyStatus(hostKey, private.PublicKey(), c.getHostKeyAlgorithms(private.PublicKey().Type()))
 		mas, err := ssh.NewSignerWithAlgorithms(private.(ssh.AlgorithmSigner), k.Algorithms)
 		if err != nil {
-			return fmt.Errorf("could not create signer for key %q with algorithms %+v: %w", k.Path, k.Algorithms, err)
+			return nil, fmt.Errorf("could not create signer for key %q with algorithms %+v: %w", k.Path, k.Algorithms, err)
 		}
-		serviceStatus.HostKeys = append(serviceStatus.HostKeys, k)
+		hostKeys = append(hostKeys, k)
 		logger.Info(logSender, "", "Host key %q loaded, type %q, fingerprint %q, algorithms %+v", hostKey,
 			private.PublicKey().Type(), k.Fingerprint, k.Algorithms)
 
@@ -993,24 +1498,137 @@ func (c *Configuration) checkAndLoadHostKeys(configDir string, serverConfig *ssh
 						}
 					}
 				}
-				serviceStatus.HostKeys = append(serviceStatus.HostKeys, HostKey{
-					Path:        cert.Path,
-					Fingerprint: ssh.FingerprintSHA256(signer.PublicKey()),
-					Algorithms:  algos,
-				})
+				certKey := newHostKeyStatus(cert.Path, signer.PublicKey(), algos)
+				certKey.ValidBefore = getCertificateValidBefore(cert.Certificate)
+				hostKeys = append(hostKeys, certKey)
 				serverConfig.AddHostKey(signer)
 				logger.Info(logSender, "", "Host certificate loaded for host key %q, fingerprint %q, algorithms %+v",
 					hostKey, ssh.FingerprintSHA256(signer.PublicKey()), algos)
 			}
 		}
 	}
+	return hostKeys, nil
+}
+
+// setHostKeysFingerprints sets the fingerprints for the loaded host keys
+func setHostKeysFingerprints(hostKeys []HostKey) {
 	var fp []string
-	for idx := range serviceStatus.HostKeys {
-		h := &serviceStatus.HostKeys[idx]
-		fp = append(fp, h.Fingerprint)
+	for idx := range hostKeys {
+		fp = append(fp, hostKeys[idx].Fingerprint)
 	}
 	vfs.SetSFTPFingerprints(fp)
-	return nil
+}
+
+// getHostKeyPassphrase returns the passphrase for the host key at the specified position
+func (c *Configuration) getHostKeyPassphrase(idx int, configDir string) (string, error) {
+	if idx >= len(c.HostKeyPassphrases) {
+		return "", nil
+	}
+	passphrase := strings.TrimSpace(c.HostKeyPassphrases[idx])
+	switch {
+	case strings.HasPrefix(passphrase, "env://"):
+		name := strings.TrimPrefix(passphrase, "env://")
+		val, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %q is not set", name)
+		}
+		return val, nil
+	case strings.HasPrefix(passphrase, "file://"):
+		return util.ReadConfigFromFile(strings.TrimPrefix(passphrase, "file://"), configDir)
+	default:
+		return passphrase, nil
+	}
+}
+
+// encryptGeneratedHostKey encrypts the generated host key at the specified position
+// if a passphrase is configured for it
+func (c *Configuration) encryptGeneratedHostKey(idx int, file, configDir string) error {
+	passphrase, err := c.getHostKeyPassphrase(idx, configDir)
+	if err != nil || passphrase == "" {
+		return err
+	}
+	privateBytes, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	key, err := ssh.ParseRawPrivateKey(privateBytes)
+	if err != nil {
+		return err
+	}
+	if k, ok := key.(*ed25519.PrivateKey); ok {
+		key = *k
+	}
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(key, "", []byte(passphrase))
+	if err != nil {
+		return err
+	}
+	logger.Info(logSender, "", "host key %q encrypted using the configured passphrase", file)
+	return os.WriteFile(file, pem.EncodeToMemory(block), 0600)
+}
+
+func loadHostKeyFromFile(hostKey, passphrase string) (ssh.Signer, error) {
+	privateBytes, err := os.ReadFile(hostKey)
+	if err != nil {
+		return nil, err
+	}
+	var private ssh.Signer
+	if passphrase != "" {
+		private, err = ssh.ParsePrivateKeyWithPassphrase(privateBytes, []byte(passphrase))
+	} else {
+		private, err = ssh.ParsePrivateKey(privateBytes)
+	}
+	if err != nil {
+		var errMissing *ssh.PassphraseMissingError
+		if errors.As(err, &errMissing) {
+			return nil, fmt.Errorf("host key %q is encrypted, a passphrase is required", hostKey)
+		}
+		if errors.Is(err, x509.IncorrectPasswordError) {
+			return nil, fmt.Errorf("invalid passphrase for host key %q", hostKey)
+		}
+		return nil, fmt.Errorf("unable to parse host key %q: %w", hostKey, err)
+	}
+	return private, nil
+}
+
+// getProviderHostKeyType returns the type of the key to generate based on the key name
+func getProviderHostKeyType(name string) string {
+	for _, keyType := range []string{"ed25519", "ecdsa", "rsa"} {
+		if strings.HasSuffix(name, keyType) {
+			return keyType
+		}
+	}
+	return ""
+}
+
+func (c *Configuration) loadHostKeyFromProvider(name string) (ssh.Signer, error) {
+	if name == "" {
+		return nil, errors.New("invalid host key, the name to load from the data provider is empty")
+	}
+	key, err := dataprovider.GetOrCreateSFTPDHostKey(name, func() ([]byte, error) {
+		keyType := getProviderHostKeyType(name)
+		if keyType == "" {
+			return nil, fmt.Errorf("host key %q does not exist and its type cannot be detected from the name", name)
+		}
+		switch keyType {
+		case "rsa":
+			logger.Info(logSender, "", "host key %q does not exist in the data provider; try to create a new %s key, size: %d bits",
+				name, keyType, c.getHostKeyRSABits())
+		case "ecdsa":
+			logger.Info(logSender, "", "host key %q does not exist in the data provider; try to create a new %s key, curve: %s",
+				name, keyType, c.getHostKeyECDSACurve())
+		default:
+			logger.Info(logSender, "", "host key %q does not exist in the data provider; try to create a new %s key",
+				name, keyType)
+		}
+		return util.GeneratePrivateKey(keyType, c.HostKeyRSABits, c.HostKeyECDSACurve)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if passphrase := key.Passphrase.GetPayload(); passphrase != "" {
+		return ssh.ParsePrivateKeyWithPassphrase([]byte(key.PrivateKey.GetPayload()), []byte(passphrase))
+	}
+	return ssh.ParsePrivateKey([]byte(key.PrivateKey.GetPayload()))
 }
 
 func (c *Configuration) loadHostCertificates(configDir string) ([]hostCertificate, error) {
@@ -1040,6 +1658,21 @@ func (c *Configuration) loadHostCertificates(configDir string) ([]hostCertificat
 		if cert.CertType != ssh.HostCert {
 			return nil, fmt.Errorf("the file %q is not an host certificate", certPath)
 		}
+		if validBefore := getCertificateValidBefore(cert); validBefore > 0 {
+			expiresAt := util.GetTimeFromMsecSinceEpoch(validBefore)
+			if expiresAt.Before(time.Now()) {
+				logger.Warn(logSender, "", "host certificate %q expired on %s, it will be ignored", certPath,
+					expiresAt.UTC().Format(time.RFC3339))
+				logger.WarnToConsole("host certificate %q expired on %s, it will be ignored", certPath,
+					expiresAt.UTC().Format(time.RFC3339))
+				continue
+			}
+			if c.isHostCertificateExpiring(expiresAt) {
+				logger.Warn(logSender, "", "host certificate %q expires on %s", certPath,
+					expiresAt.UTC().Format(time.RFC3339))
+				logger.WarnToConsole("host certificate %q expires on %s", certPath, expiresAt.UTC().Format(time.RFC3339))
+			}
+		}
 		certs = append(certs, hostCertificate{
 			Path:        certPath,
 			Certificate: cert,
@@ -1130,30 +1763,30 @@ func (c *Configuration) validatePublicKeyCredentials(conn ssh.ConnMetadata, pubK
 		if cert.CertType != ssh.UserCert {
 			err = fmt.Errorf("ssh: cert has type %d", cert.CertType)
 			user.Username = conn.User()
-			updateLoginMetrics(&user, ipAddr, method, err)
+			updateLoginMetrics(&user, ipAddr, method, conn, err)
 			return nil, err
 		}
 		if !c.certChecker.IsUserAuthority(cert.SignatureKey) {
 			err = errors.New("ssh: certificate signed by unrecognized authority")
 			user.Username = conn.User()
-			updateLoginMetrics(&user, ipAddr, method, err)
+			updateLoginMetrics(&user, ipAddr, method, conn, err)
 			return nil, err
 		}
 		if len(cert.ValidPrincipals) == 0 {
 			err = fmt.Errorf("ssh: certificate %s has no valid principals, user: \"%s\"", certFingerprint, conn.User())
 			user.Username = conn.User()
-			updateLoginMetrics(&user, ipAddr, method, err)
+			updateLoginMetrics(&user, ipAddr, method, conn, err)
 			return nil, err
 		}
 		if revokedCertManager.isRevoked(certFingerprint) {
 			err = fmt.Errorf("ssh: certificate %s is revoked", certFingerprint)
 			user.Username = conn.User()
-			updateLoginMetrics(&user, ipAddr, method, err)
+			updateLoginMetrics(&user, ipAddr, method, conn, err)
 			return nil, err
 		}
 		if err := c.certChecker.CheckCert(conn.User(), cert); err != nil {
 			user.Username = conn.User()
-			updateLoginMetrics(&user, ipAddr, method, err)
+			updateLoginMetrics(&user, ipAddr, method, conn, err)
 			return nil, err
 		}
 		certPerm = &cert.Permissions
@@ -1162,6 +1795,8 @@ func (c *Configuration) validatePublicKeyCredentials(conn ssh.ConnMetadata, pubK
 		if ok {
 			keyID = fmt.Sprintf("%s: ID: %s, serial: %v, CA %s %s", certFingerprint,
 				cert.KeyId, cert.Serial, cert.Type(), ssh.FingerprintSHA256(cert.SignatureKey))
+		} else {
+			dataprovider.UpdatePublicKeyLastUse(&user, pubKey.Marshal())
 		}
 		if user.IsPartialAuth() {
 			logger.Debug(logSender, connectionID, "user %q authenticated with partial success", conn.User())
@@ -1180,7 +1815,7 @@ func (c *Configuration) validatePublicKeyCredentials(conn ssh.ConnMetadata, pubK
 		}
 	}
 	user.Username = conn.User()
-	updateLoginMetrics(&user, ipAddr, method, err)
+	updateLoginMetrics(&user, ipAddr, method, conn, err)
 	return sshPerm, err
 }
 
@@ -1190,13 +1825,17 @@ func (c *Configuration) validatePasswordCredentials(conn ssh.ConnMetadata, pass
 	var sshPerm *ssh.Permissions
 
 	ipAddr := util.GetIPFromRemoteAddress(conn.RemoteAddr().String())
-	if user, err = dataprovider.CheckUserAndPass(conn.User(), util.BytesToString(pass), ipAddr, common.ProtocolSSH); err == nil {
-		sshPerm, err = loginUser(&user, method, "", conn)
+	if err = common.CheckLoginFailuresRestrictions(conn.User(), method); err == nil {
+		if user, err = dataprovider.CheckUserAndPass(conn.User(), util.BytesToString(pass), ipAddr, common.ProtocolSSH); err == nil {
+			sshPerm, err = loginUser(&user, method, "", conn)
+		} else if c.UniformAuthFailures && errors.Is(err, util.ErrNotFound) {
+			dataprovider.VerifyDummyPassword(util.BytesToString(pass))
+		}
 	}
 	user.Username = conn.User()
-	updateLoginMetrics(&user, ipAddr, method, err)
+	updateLoginMetrics(&user, ipAddr, method, conn, err)
 	if err != nil {
-		return nil, newAuthenticationError(fmt.Errorf("could not validate password credentials: %w", err), method, conn.User())
+		return nil, c.newAuthenticationError(fmt.Errorf("could not validate password credentials: %w", err), method, conn.User())
 	}
 	return sshPerm, nil
 }
@@ -1209,36 +1848,51 @@ func (c *Configuration) validateKeyboardInteractiveCredentials(conn ssh.ConnMeta
 	var sshPerm *ssh.Permissions
 
 	ipAddr := util.GetIPFromRemoteAddress(conn.RemoteAddr().String())
-	if user, err = dataprovider.CheckKeyboardInteractiveAuth(conn.User(), c.KeyboardInteractiveHook, client,
-		ipAddr, common.ProtocolSSH, isPartialAuth); err == nil {
-		sshPerm, err = loginUser(&user, method, "", conn)
+	if err = common.CheckLoginFailuresRestrictions(conn.User(), method); err == nil {
+		if user, err = dataprovider.CheckKeyboardInteractiveAuth(conn.User(), c.KeyboardInteractiveHook, client,
+			ipAddr, common.ProtocolSSH, isPartialAuth); err == nil {
+			sshPerm, err = loginUser(&user, method, "", conn)
+		} else if c.UniformAuthFailures && !isPartialAuth && errors.Is(err, util.ErrNotFound) {
+			// send the same prompt as for the existing users
+			answers, errChallenge := client("", "", []string{"Password: "}, []bool{false})
+			if errChallenge == nil && len(answers) == 1 {
+				dataprovider.VerifyDummyPassword(answers[0])
+			}
+		}
 	}
 	user.Username = conn.User()
-	updateLoginMetrics(&user, ipAddr, method, err)
+	updateLoginMetrics(&user, ipAddr, method, conn, err)
 	if err != nil {
-		return nil, newAuthenticationError(fmt.Errorf("could not validate keyboard interactive credentials: %w", err), method, conn.User())
+		return nil, c.newAuthenticationError(fmt.Errorf("could not validate keyboard interactive credentials: %w", err), method, conn.User())
 	}
 	return sshPerm, nil
 }
 
-func updateLoginMetrics(user *dataprovider.User, ip, method string, err error) {
+func updateLoginMetrics(user *dataprovider.User, ip, method string, conn ssh.ConnMetadata, err error) {
 	metric.AddLoginAttempt(method)
+	if err == nil || method != dataprovider.SSHLoginMethodPublicKey {
+		dataprovider.AddLoginEvent(user.Username, ip, common.ProtocolSSH, method, string(conn.ClientVersion()), err)
+	}
 	if err == nil {
 		plugin.Handler.NotifyLogEvent(notifier.LogEventTypeLoginOK, common.ProtocolSSH, user.Username, ip, "", err)
+		common.AddLoginResult(user.Username, ip, common.ProtocolSSH, nil)
+		common.UpdateAccountLockout(user.Username, ip, common.ProtocolSSH, nil)
 		common.DelayLogin(nil)
 	} else {
-		logger.ConnectionFailedLog(user.Username, ip, method, common.ProtocolSSH, err.Error())
+		logger.ConnectionFailedLog(user.Username, ip, method, common.ProtocolSSH, hex.EncodeToString(conn.SessionID()),
+			err.Error())
 		if method != dataprovider.SSHLoginMethodPublicKey {
 			// some clients try all available public keys for a user, we
 			// record failed login key auth only once for session if the
 			// authentication fails in checkAuthError
-			event := common.HostEventLoginFailed
+			event := common.GetDefenderEventForLoginError(err)
 			logEv := notifier.LogEventTypeLoginFailed
 			if errors.Is(err, util.ErrNotFound) {
-				event = common.HostEventUserNotFound
 				logEv = notifier.LogEventTypeLoginNoUser
 			}
 			common.AddDefenderEvent(ip, common.ProtocolSSH, event)
+			common.AddLoginResult(user.Username, ip, common.ProtocolSSH, err)
+			common.UpdateAccountLockout(user.Username, ip, common.ProtocolSSH, err)
 			plugin.Handler.NotifyLogEvent(logEv, common.ProtocolSSH, user.Username, ip, "", err)
 			if method != dataprovider.SSHLoginMethodPublicKey {
 				common.DelayLogin(err)
@@ -1246,7 +1900,9 @@ func updateLoginMetrics(user *dataprovider.User, ip, method string, err error) {
 		}
 	}
 	metric.AddLoginResult(method, err)
-	dataprovider.ExecutePostLoginHook(user, method, ip, common.ProtocolSSH, err)
+	common.EmitAuthAttempt(ip, common.ProtocolSSH, user.Username, method, err)
+	dataprovider.ExecutePostLoginHookWithSource(user, method, ip, common.ProtocolSSH,
+		common.GetConnectionSource(conn.RemoteAddr()), err)
 }
 
 type revokedCertificates struct {
@@ -1297,9 +1953,21 @@ func (r *revokedCertificates) isRevoked(fp string) bool {
 	return r.certs[fp]
 }
 
-// Reload reloads the list of revoked user certificates
+// Reload reloads the list of revoked user certificates and the host keys and certificates.
+// The host keys are reloaded even if the revoked certificates cannot be loaded,
+// the returned error combines both failures
 func Reload() error {
-	return revokedCertManager.load()
+	errRevokedCerts := revokedCertManager.load()
+	if errRevokedCerts != nil {
+		logger.Warn(logSender, "", "unable to reload revoked user certificates: %v", errRevokedCerts)
+	}
+	return errors.Join(errRevokedCerts, reloadHostKeys())
+}
+
+// ApplyProviderConfigs applies the algorithms defined in the data provider
+// to new connections if they changed, existing connections are not affected
+func ApplyProviderConfigs() {
+	checkProviderConfigs()
 }
 
 func algorithmsForKeyFormat(keyFormat string) []string {
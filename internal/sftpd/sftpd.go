@@ -18,7 +18,9 @@
 package sftpd
 
 import (
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/crypto/ssh"
@@ -35,6 +37,7 @@ var (
 	defaultSSHCommands = []string{"md5sum", "sha1sum", "sha256sum", "cd", "pwd", "scp"}
 	sshHashCommands    = []string{"md5sum", "sha1sum", "sha256sum", "sha384sum", "sha512sum"}
 	systemCommands     = []string{"git-receive-pack", "git-upload-pack", "git-upload-archive", "rsync"}
+	serviceStatusMu    sync.RWMutex
 	serviceStatus      ServiceStatus
 	certKeyAlgoNames   = map[string]string{
 		ssh.CertAlgoRSAv01:         ssh.KeyAlgoRSA,
@@ -65,9 +68,18 @@ type hostCertificate struct {
 
 // HostKey defines the details for a used host key
 type HostKey struct {
-	Path        string   `json:"path"`
-	Fingerprint string   `json:"fingerprint"`
-	Algorithms  []string `json:"algorithms"`
+	Path        string `json:"path"`
+	Fingerprint string `json:"fingerprint"`
+	// FingerprintMD5 is the legacy MD5 fingerprint in colon-hex format
+	FingerprintMD5 string `json:"fingerprint_md5"`
+	// Type is the key algorithm name, for example "ssh-ed25519"
+	Type string `json:"type"`
+	// Bits is the key size in bits, 0 if not available
+	Bits       int      `json:"bits,omitempty"`
+	Algorithms []string `json:"algorithms"`
+	// ValidBefore is the expiration date, as unix timestamp in milliseconds,
+	// for host certificates. 0 means no expiration
+	ValidBefore int64 `json:"valid_before,omitempty"`
 }
 
 // GetAlgosAsString returns the host key algorithms as comma separated string
@@ -82,10 +94,27 @@ type ServiceStatus struct {
 	SSHCommands         []string  `json:"ssh_commands"`
 	HostKeys            []HostKey `json:"host_keys"`
 	Authentications     []string  `json:"authentications"`
+	HostKeyAlgorithms   []string  `json:"host_key_algorithms"`
 	MACs                []string  `json:"macs"`
 	KexAlgorithms       []string  `json:"kex_algorithms"`
 	Ciphers             []string  `json:"ciphers"`
 	PublicKeyAlgorithms []string  `json:"public_key_algorithms"`
+	// Listeners is the runtime status for the listeners of the configured bindings
+	Listeners []ListenerStatus `json:"listeners"`
+	// ConfigsGeneration is incremented each time the algorithms are applied
+	ConfigsGeneration int `json:"configs_generation"`
+	// ConfigsAppliedAt is the last time the algorithms were applied as
+	// unix timestamp in milliseconds
+	ConfigsAppliedAt int64 `json:"configs_applied_at"`
+	// AuxiliaryFiles is the result of the last health check for the auxiliary
+	// files and hooks
+	AuxiliaryFiles []AuxiliaryFileStatus `json:"auxiliary_files"`
+}
+
+// GetConfigsAppliedAtAsString returns the last time the algorithms were applied
+// formatted as RFC 3339 string
+func (s *ServiceStatus) GetConfigsAppliedAtAsString() string {
+	return getMsTimeAsString(s.ConfigsAppliedAt)
 }
 
 // GetSSHCommandsAsString returns enabled SSH commands as comma separated string
@@ -98,6 +127,11 @@ func (s *ServiceStatus) GetSupportedAuthsAsString() string {
 	return strings.Join(s.Authentications, ", ")
 }
 
+// GetHostKeyAlgosAsString returns the enabled host key algorithms as comma separated string
+func (s *ServiceStatus) GetHostKeyAlgosAsString() string {
+	return strings.Join(s.HostKeyAlgorithms, ", ")
+}
+
 // GetMACsAsString returns the enabled MAC algorithms as comma separated string
 func (s *ServiceStatus) GetMACsAsString() string {
 	return strings.Join(s.MACs, ", ")
@@ -119,9 +153,41 @@ func (s *ServiceStatus) GetPublicKeysAlgosAsString() string {
 	return strings.Join(s.PublicKeyAlgorithms, ", ")
 }
 
+// updateServiceStatus applies the specified changes to the service status.
+// The slices in the status must be replaced and never modified in place,
+// the returned status copies share them
+func updateServiceStatus(fn func(s *ServiceStatus)) {
+	serviceStatusMu.Lock()
+	defer serviceStatusMu.Unlock()
+
+	fn(&serviceStatus)
+}
+
+func getHostKeysStatus() []HostKey {
+	serviceStatusMu.RLock()
+	defer serviceStatusMu.RUnlock()
+
+	return serviceStatus.HostKeys
+}
+
 // GetStatus returns the server status
 func GetStatus() ServiceStatus {
-	return serviceStatus
+	serviceStatusMu.RLock()
+	status := serviceStatus
+	serviceStatusMu.RUnlock()
+
+	status.Listeners = getListenersStatus()
+	status.AuxiliaryFiles = getAuxiliaryFilesStatus()
+	if len(status.Listeners) == len(status.Bindings) {
+		// report the ports chosen by the OS for bindings with port 0
+		status.Bindings = slices.Clone(status.Bindings)
+		for idx := range status.Bindings {
+			if status.Bindings[idx].Port == 0 {
+				status.Bindings[idx].Port = status.Listeners[idx].Port
+			}
+		}
+	}
+	return status
 }
 
 // GetDefaultSSHCommands returns the SSH commands enabled as default
@@ -58,8 +58,10 @@ import (
 	sdkkms "github.com/sftpgo/sdk/kms"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
 	"golang.org/x/crypto/ssh"
 
+	"github.com/drakkan/sftpgo/v2/internal/command"
 	"github.com/drakkan/sftpgo/v2/internal/common"
 	"github.com/drakkan/sftpgo/v2/internal/config"
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
@@ -273,6 +275,7 @@ func TestMain(m *testing.M) {
 	}
 	sftpdConf.KeyboardInteractiveAuthentication = true
 	sftpdConf.KeyboardInteractiveHook = keyIntAuthPath
+	sftpdConf.SessionRecording.PathTemplate = filepath.Join(homeBasePath, "recordings", "%username%", "%connection_id%.jsonl")
 
 	createInitialFiles(scriptArgs)
 	sftpdConf.TrustedUserCAKeys = append(sftpdConf.TrustedUserCAKeys, trustedCAUserKey)
@@ -382,7 +385,7 @@ func TestInitialization(t *testing.T) {
 			ApplyProxyConfig: true,
 		},
 		{
-			Port: 0,
+			Port: -1,
 		},
 	}
 	sftpdConf.LoginBannerFile = "invalid_file"
@@ -409,6 +412,12 @@ func TestInitialization(t *testing.T) {
 	common.Config.ProxyProtocol = 1
 	assert.True(t, sftpdConf.Bindings[0].HasProxy())
 	common.Config.ProxyProtocol = 0
+	sftpdConf.Bindings[0].TCPKeepAlive = 3
+	err = sftpdConf.Initialize(configDir)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "invalid TCP keepalive")
+	}
+	sftpdConf.Bindings[0].TCPKeepAlive = 0
 	sftpdConf.HostKeys = []string{"missing key"}
 	err = sftpdConf.Initialize(configDir)
 	assert.Error(t, err)
@@ -518,6 +527,166 @@ func TestInitialization(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestUserLoginBanner(t *testing.T) {
+	dialWithBanner := func(user dataprovider.User) (string, error) {
+		var banner string
+		config := &ssh.ClientConfig{
+			User:            user.Username,
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			Auth:            []ssh.AuthMethod{ssh.Password(defaultPassword)},
+			BannerCallback: func(message string) error {
+				banner = message
+				return nil
+			},
+			Timeout: 5 * time.Second,
+		}
+		conn, err := ssh.Dial("tcp", sftpServerAddr, config)
+		if err != nil {
+			return "", err
+		}
+		return banner, conn.Close()
+	}
+
+	// the user banners are cached, use usernames not used in other tests
+	u := getTestUser(false)
+	u.Username += "_banner"
+	u.Filters.LoginBanner.Text = "banner"
+	u.Filters.LoginBanner.File = "/tmp/banner"
+	_, resp, err := httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err, string(resp))
+	assert.Contains(t, string(resp), "not both")
+	u.Filters.LoginBanner.File = ""
+	u.Filters.LoginBanner.Text = "user banner from %r"
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	banner, err := dialWithBanner(user)
+	assert.NoError(t, err)
+	assert.Equal(t, "user banner from 127.0.0.1", banner)
+	// the global banner is used for users without their own banner
+	u1 := getTestUser(false)
+	u1.Username += "_nobanner"
+	user1, _, err := httpdtest.AddUser(u1, http.StatusCreated)
+	assert.NoError(t, err)
+	banner, err = dialWithBanner(user1)
+	assert.NoError(t, err)
+	assert.Equal(t, "simple login banner\n", banner)
+
+	_, err = httpdtest.RemoveUser(user1, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user1.GetHomeDir())
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(u, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(u.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestRequireTrustedSourceAddr(t *testing.T) {
+	usePubKey := true
+	u := getTestUser(usePubKey)
+	u.Filters.RequireTrustedSourceAddr = true
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	assert.True(t, user.Filters.RequireTrustedSourceAddr)
+	conn, client, err := getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+		assert.NoError(t, checkBasicSFTP(client))
+	}
+	// the proxy protocol is enabled and the proxy header is ignored, there
+	// are no trusted proxies
+	_, _, err = getSftpClientWithAddr(user, usePubKey, sftpSrvAddr2222)
+	assert.Error(t, err)
+
+	user.Filters.RequireTrustedSourceAddr = false
+	_, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	conn, client, err = getSftpClientWithAddr(user, usePubKey, sftpSrvAddr2222)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+		assert.NoError(t, checkBasicSFTP(client))
+	}
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestEventStream(t *testing.T) {
+	streamFile := filepath.Join(os.TempDir(), "sftpd_event_stream", "events.jsonl")
+	err := logger.InitEventStream(logger.EventStreamConfig{
+		FilePath: streamFile,
+	})
+	require.NoError(t, err)
+
+	usePubKey := false
+	user, _, err := httpdtest.AddUser(getTestUser(usePubKey), http.StatusCreated)
+	assert.NoError(t, err)
+	conn, client, err := getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		assert.NoError(t, checkBasicSFTP(client))
+		client.Close()
+		conn.Close()
+	}
+	assert.Eventually(t, func() bool { return len(common.Connections.GetStats("")) == 0 }, 2*time.Second, 100*time.Millisecond)
+	user.Password = "wrong password"
+	_, _, err = getSftpClient(user, usePubKey)
+	assert.Error(t, err)
+	assert.Eventually(t, func() bool { return len(common.Connections.GetStats("")) == 0 }, 2*time.Second, 100*time.Millisecond)
+	logger.CloseEventStream()
+
+	f, err := os.Open(streamFile)
+	require.NoError(t, err)
+	var events []logger.StreamEvent
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var event logger.StreamEvent
+		err = json.Unmarshal(scanner.Bytes(), &event)
+		assert.NoError(t, err)
+		events = append(events, event)
+	}
+	err = f.Close()
+	assert.NoError(t, err)
+
+	var eventTypes []string
+	for _, event := range events {
+		assert.Equal(t, logger.EventStreamSchemaVersion, event.SchemaVersion)
+		assert.Equal(t, common.ProtocolSSH, event.Protocol)
+		assert.Equal(t, "127.0.0.1", event.IP)
+		eventTypes = append(eventTypes, event.Event)
+		switch event.Event {
+		case logger.StreamEventAuthAttempt:
+			if assert.NotNil(t, event.Status) && *event.Status == 0 {
+				assert.Equal(t, user.Username, event.Username)
+				assert.NotEmpty(t, event.ErrorClass)
+			}
+		case logger.StreamEventSessionEnd:
+			assert.Equal(t, user.Username, event.Username)
+			assert.NotEmpty(t, event.ConnectionID)
+			if assert.NotNil(t, event.Channels) {
+				assert.Equal(t, int64(1), *event.Channels)
+			}
+			if assert.NotNil(t, event.BytesReceived) && assert.NotNil(t, event.BytesSent) {
+				assert.Greater(t, *event.BytesReceived, int64(0))
+				assert.Greater(t, *event.BytesSent, int64(0))
+			}
+		}
+	}
+	assert.Equal(t, []string{logger.StreamEventConnectionAccepted, logger.StreamEventAuthAttempt,
+		logger.StreamEventSessionStart, logger.StreamEventSessionEnd, logger.StreamEventConnectionAccepted,
+		logger.StreamEventAuthAttempt}, slices.Compact(eventTypes))
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	err = os.RemoveAll(filepath.Dir(streamFile))
+	assert.NoError(t, err)
+}
+
 func TestBasicSFTPHandling(t *testing.T) {
 	usePubKey := false
 	u := getTestUser(usePubKey)
@@ -845,6 +1014,186 @@ func TestGroupSettingsOverride(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestCreateModes(t *testing.T) {
+	if runtime.GOOS == osWindows {
+		t.Skip("this test is not available on Windows")
+	}
+	usePubKey := true
+	g := getTestGroup()
+	g.UserSettings.CreateModes = dataprovider.CreateModes{
+		File: "0640",
+		Dir:  "0750",
+	}
+	group, _, err := httpdtest.AddGroup(g, http.StatusCreated)
+	assert.NoError(t, err)
+	u := getTestUser(usePubKey)
+	u.Filters.CreateModes.File = "0800"
+	_, _, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+	u.Filters.CreateModes.File = ""
+	u.Groups = []sdk.GroupMapping{
+		{
+			Name: group.Name,
+			Type: sdk.GroupTypePrimary,
+		},
+	}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	conn, client, err := getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		err = writeSFTPFile(testFileName, 100, client)
+		assert.NoError(t, err)
+		err = client.Mkdir("adir")
+		assert.NoError(t, err)
+		info, err := os.Stat(filepath.Join(user.GetHomeDir(), testFileName))
+		if assert.NoError(t, err) {
+			assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+		}
+		info, err = os.Stat(filepath.Join(user.GetHomeDir(), "adir"))
+		if assert.NoError(t, err) {
+			assert.Equal(t, os.FileMode(0750), info.Mode().Perm())
+		}
+		// the mode is applied to new files only
+		err = client.Chmod(testFileName, 0600)
+		assert.NoError(t, err)
+		err = writeSFTPFile(testFileName, 100, client)
+		assert.NoError(t, err)
+		info, err = os.Stat(filepath.Join(user.GetHomeDir(), testFileName))
+		if assert.NoError(t, err) {
+			assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+		}
+	}
+	// the user settings take precedence over the group ones
+	user.Filters.CreateModes = dataprovider.CreateModes{
+		File: "0600",
+	}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	conn, client, err = getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		err = writeSFTPFile(testFileName+"_1", 100, client)
+		assert.NoError(t, err)
+		info, err := os.Stat(filepath.Join(user.GetHomeDir(), testFileName+"_1"))
+		if assert.NoError(t, err) {
+			assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+		}
+	}
+	if scpPath != "" {
+		// the mode included in the SCP upload message takes precedence, unless forced
+		testFilePath := filepath.Join(homeBasePath, testFileName)
+		err = createTestFile(testFilePath, 100)
+		assert.NoError(t, err)
+		err = os.Chmod(testFilePath, 0644)
+		assert.NoError(t, err)
+		err = scpUpload(testFilePath, fmt.Sprintf("%v@127.0.0.1:%v", user.Username, "/scp1"), false, false)
+		assert.NoError(t, err)
+		info, err := os.Stat(filepath.Join(user.GetHomeDir(), "scp1"))
+		if assert.NoError(t, err) {
+			assert.Equal(t, os.FileMode(0644), info.Mode().Perm())
+		}
+		user.Filters.CreateModes.Force = true
+		user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+		assert.NoError(t, err)
+		err = scpUpload(testFilePath, fmt.Sprintf("%v@127.0.0.1:%v", user.Username, "/scp2"), false, false)
+		assert.NoError(t, err)
+		info, err = os.Stat(filepath.Join(user.GetHomeDir(), "scp2"))
+		if assert.NoError(t, err) {
+			assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+		}
+		err = os.Remove(testFilePath)
+		assert.NoError(t, err)
+	}
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveGroup(group, http.StatusOK)
+	assert.NoError(t, err)
+}
+
+func TestChownPolicy(t *testing.T) {
+	if runtime.GOOS == osWindows {
+		t.Skip("this test is not available on Windows")
+	}
+	usePubKey := true
+	g := getTestGroup()
+	g.UserSettings.ChownPolicy = dataprovider.ChownPolicy{
+		Mode: dataprovider.ChownPolicyMap,
+		UID:  -1,
+		GID:  os.Getgid(),
+	}
+	group, _, err := httpdtest.AddGroup(g, http.StatusCreated)
+	assert.NoError(t, err)
+	u := getTestUser(usePubKey)
+	u.Filters.ChownPolicy.Mode = "unknown"
+	_, _, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+	u.Filters.ChownPolicy = dataprovider.ChownPolicy{
+		Mode: dataprovider.ChownPolicyMap,
+		UID:  -1,
+		GID:  -1,
+	}
+	_, _, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+	u.Filters.ChownPolicy = dataprovider.ChownPolicy{}
+	u.Groups = []sdk.GroupMapping{
+		{
+			Name: group.Name,
+			Type: sdk.GroupTypePrimary,
+		},
+	}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	conn, client, err := getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		err = writeSFTPFile(testFileName, 100, client)
+		assert.NoError(t, err)
+		// the group policy maps the requested owner to the configured one
+		err = client.Chown(testFileName, os.Getuid(), os.Getgid()+1000)
+		assert.NoError(t, err)
+		info, err := client.Stat(testFileName)
+		if assert.NoError(t, err) {
+			assert.Equal(t, uint32(os.Getgid()), info.Sys().(*sftp.FileStat).GID)
+		}
+	}
+	// the user policy takes precedence over the group one and doesn't require the chown permission
+	user.Filters.ChownPolicy.Mode = dataprovider.ChownPolicyIgnore
+	user.Permissions["/"] = []string{dataprovider.PermListItems, dataprovider.PermDownload, dataprovider.PermUpload,
+		dataprovider.PermOverwrite}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, user.Filters.ChownPolicy.GID)
+	conn, client, err = getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		err = client.Chown(testFileName, os.Getuid(), os.Getgid()+1000)
+		assert.NoError(t, err)
+		info, err := client.Stat(testFileName)
+		if assert.NoError(t, err) {
+			assert.Equal(t, uint32(os.Getgid()), info.Sys().(*sftp.FileStat).GID)
+		}
+	}
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveGroup(group, http.StatusOK)
+	assert.NoError(t, err)
+}
+
 func TestStartDirectory(t *testing.T) {
 	usePubKey := false
 	startDir := "/st@ rt/dir"
@@ -2469,6 +2818,72 @@ func TestLoginUserExpiration(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestLoginPublicKeyExpiration(t *testing.T) {
+	usePubKey := true
+	u := getTestUser(usePubKey)
+	u.PublicKeysMetadata = []dataprovider.UserPublicKey{
+		{
+			Key:       testPubKey,
+			Comment:   "test key",
+			ExpiresAt: util.GetTimeAsMsSinceEpoch(time.Now()) + 120000,
+		},
+	}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	conn, client, err := getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+		assert.NoError(t, checkBasicSFTP(client))
+	}
+	user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+	assert.NoError(t, err)
+	if assert.Len(t, user.PublicKeysMetadata, 1) {
+		assert.Equal(t, "test key", user.PublicKeysMetadata[0].Comment)
+		assert.Greater(t, user.PublicKeysMetadata[0].LastUsedAt, int64(0))
+	}
+	lastUse := user.PublicKeysMetadata[0].LastUsedAt
+	// metadata omitted on update must be preserved
+	user.PublicKeysMetadata = nil
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+	assert.NoError(t, err)
+	if assert.Len(t, user.PublicKeysMetadata, 1) {
+		assert.Equal(t, "test key", user.PublicKeysMetadata[0].Comment)
+		assert.Equal(t, lastUse, user.PublicKeysMetadata[0].LastUsedAt)
+	}
+	user.PublicKeysMetadata[0].ExpiresAt = util.GetTimeAsMsSinceEpoch(time.Now()) - 120000
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	conn, client, err = getSftpClient(user, usePubKey)
+	if !assert.Error(t, err, "login with an expired key must fail") {
+		client.Close()
+		conn.Close()
+	}
+	pubKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(testPubKey)) //nolint:dogsled
+	assert.NoError(t, err)
+	_, _, err = dataprovider.CheckUserAndPubKey(user.Username, pubKey.Marshal(), "1.2.3.4", common.ProtocolSSH, false)
+	assert.ErrorIs(t, err, dataprovider.ErrPublicKeyExpired)
+	// a negative expiration is not valid
+	user.PublicKeysMetadata[0].ExpiresAt = -1
+	_, _, err = httpdtest.UpdateUser(user, http.StatusBadRequest, "")
+	assert.NoError(t, err)
+	// public keys stored as plain strings must be supported
+	var keys []dataprovider.UserPublicKey
+	err = json.Unmarshal([]byte(fmt.Sprintf("[%q]", testPubKey)), &keys)
+	assert.NoError(t, err)
+	if assert.Len(t, keys, 1) {
+		assert.Equal(t, testPubKey, keys[0].Key)
+		assert.Equal(t, int64(0), keys[0].ExpiresAt)
+	}
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestLoginWithDatabaseCredentials(t *testing.T) {
 	usePubKey := true
 	u := getTestUser(usePubKey)
@@ -2691,15 +3106,59 @@ func TestAnonymousGroupInheritance(t *testing.T) {
 	assert.NoError(t, err)
 }
 
-func TestLoginAfterUserUpdateEmptyPwd(t *testing.T) {
-	usePubKey := false
-	user, _, err := httpdtest.AddUser(getTestUser(usePubKey), http.StatusCreated)
-	assert.NoError(t, err)
-	user.Password = ""
-	// password should remain unchanged
-	_, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+func TestAllowedKeyAlgos(t *testing.T) {
+	g := getTestGroup()
+	g.UserSettings.AllowedKeyAlgos = []string{ssh.KeyAlgoED25519}
+	group, _, err := httpdtest.AddGroup(g, http.StatusCreated)
 	assert.NoError(t, err)
-	conn, client, err := getSftpClient(user, usePubKey)
+	usePubKey := true
+	u := getTestUser(usePubKey)
+	u.Groups = []sdk.GroupMapping{
+		{
+			Name: group.Name,
+			Type: sdk.GroupTypePrimary,
+		},
+	}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	// RSA keys are not allowed by the primary group
+	_, _, err = getSftpClient(user, usePubKey)
+	assert.Error(t, err)
+
+	user.Filters.AllowedKeyAlgos = []string{ssh.KeyAlgoRSASHA256}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	conn, client, err := getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+		assert.NoError(t, checkBasicSFTP(client))
+	}
+
+	user.Filters.AllowedKeyAlgos = []string{"unsupported algo"}
+	_, _, err = httpdtest.UpdateUser(user, http.StatusBadRequest, "")
+	assert.NoError(t, err)
+	group.UserSettings.AllowedKeyAlgos = []string{"unsupported algo"}
+	_, _, err = httpdtest.UpdateGroup(group, http.StatusBadRequest)
+	assert.NoError(t, err)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveGroup(group, http.StatusOK)
+	assert.NoError(t, err)
+}
+
+func TestLoginAfterUserUpdateEmptyPwd(t *testing.T) {
+	usePubKey := false
+	user, _, err := httpdtest.AddUser(getTestUser(usePubKey), http.StatusCreated)
+	assert.NoError(t, err)
+	user.Password = ""
+	// password should remain unchanged
+	_, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	conn, client, err := getSftpClient(user, usePubKey)
 	if assert.NoError(t, err) {
 		defer conn.Close()
 		defer client.Close()
@@ -2916,6 +3375,158 @@ func TestMustChangePasswordRequirement(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestPasswordPolicyExpiration(t *testing.T) {
+	err := dataprovider.Close()
+	assert.NoError(t, err)
+	err = config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	providerConf := config.GetProviderConf()
+	providerConf.PasswordPolicy.MaxAge = 30
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.NoError(t, err)
+
+	usePubKey := true
+	u := getTestUser(usePubKey)
+	hashedPwd, err := bcrypt.GenerateFromPassword([]byte(defaultPassword), bcrypt.MinCost)
+	assert.NoError(t, err)
+	// a pre-hashed password has no last change date, so it is already expired
+	u.Password = string(hashedPwd)
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	providerUser, err := dataprovider.UserExists(user.Username, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 30, providerUser.GetPasswordExpiration())
+	assert.True(t, providerUser.MustChangePassword())
+	// public key auth works even if the password is expired
+	conn, client, err := getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+		assert.NoError(t, checkBasicSFTP(client))
+	}
+	_, _, err = getSftpClient(user, false)
+	assert.Error(t, err)
+	_, err = dataprovider.CheckUserAndPass(user.Username, defaultPassword, "127.0.0.1", common.ProtocolSSH)
+	assert.ErrorIs(t, err, dataprovider.ErrPasswordExpired)
+	// the per-user password expiration takes precedence
+	providerUser.Filters.PasswordExpiration = 10
+	assert.Equal(t, 10, providerUser.GetPasswordExpiration())
+
+	err = dataprovider.UpdateUserPassword(user.Username, defaultPassword, "", "", "")
+	assert.NoError(t, err)
+	providerUser, err = dataprovider.UserExists(user.Username, "")
+	assert.NoError(t, err)
+	assert.False(t, providerUser.MustChangePassword())
+	assert.Equal(t, 30, providerUser.PasswordExpiresIn())
+	conn, client, err = getSftpClient(user, false)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+		assert.NoError(t, checkBasicSFTP(client))
+	}
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	err = dataprovider.Close()
+	assert.NoError(t, err)
+	err = config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	providerConf = config.GetProviderConf()
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.NoError(t, err)
+}
+
+func TestSessionRecording(t *testing.T) {
+	usePubKey := true
+	u := getTestUser(usePubKey)
+	u.Filters.SessionRecording = true
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	recordingsDir := filepath.Join(homeBasePath, "recordings", user.Username)
+
+	conn, client, err := getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		testFileSize := int64(65535)
+		assert.NoError(t, checkBasicSFTP(client))
+		assert.NoError(t, client.Mkdir("dir"))
+		assert.NoError(t, writeSFTPFile(testFileName, testFileSize, client))
+		assert.NoError(t, client.Rename(testFileName, path.Join("dir", testFileName)))
+		assert.NoError(t, client.Remove(path.Join("dir", testFileName)))
+		_, err = client.Stat("missing")
+		assert.Error(t, err)
+		client.Close()
+		conn.Close()
+		assert.Eventually(t, func() bool { return len(common.Connections.GetStats("")) == 0 }, 2*time.Second, 100*time.Millisecond)
+
+		records := readSessionRecordings(t, recordingsDir)
+		if assert.Len(t, records, 1) {
+			var ops []string
+			for _, record := range records[0] {
+				ops = append(ops, record["op"].(string))
+				assert.NotEmpty(t, record["connection_id"])
+				assert.Equal(t, common.ProtocolSFTP, record["protocol"])
+				switch record["op"] {
+				case "start":
+					assert.Equal(t, user.Username, record["username"])
+				case "open":
+					assert.Equal(t, "/"+testFileName, record["path"])
+					assert.Contains(t, record["flags"], "write")
+				case "close":
+					assert.Equal(t, float64(testFileSize), record["size"])
+					assert.Equal(t, "ok", record["result"])
+				case "rename":
+					assert.Equal(t, "/"+testFileName, record["path"])
+					assert.Equal(t, "/dir/"+testFileName, record["target"])
+				case "stat":
+					if record["path"] == "/missing" {
+						assert.Equal(t, "error", record["result"])
+						assert.NotEmpty(t, record["error"])
+					}
+				}
+			}
+			assert.Equal(t, "start", ops[0])
+			assert.Equal(t, "end", ops[len(ops)-1])
+			for _, op := range []string{"realpath", "list", "mkdir", "open", "close", "rename", "remove", "stat"} {
+				assert.Contains(t, ops, op)
+			}
+		}
+	}
+	err = os.RemoveAll(recordingsDir)
+	assert.NoError(t, err)
+
+	_, err = runSSHCommand("sha1sum", user, usePubKey)
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool { return len(common.Connections.GetStats("")) == 0 }, 2*time.Second, 100*time.Millisecond)
+	records := readSessionRecordings(t, recordingsDir)
+	if assert.Len(t, records, 1) && assert.Len(t, records[0], 3) {
+		assert.Equal(t, "exec", records[0][1]["op"])
+		assert.Equal(t, "sha1sum", records[0][1]["command"])
+		assert.Equal(t, common.ProtocolSSH, records[0][1]["protocol"])
+		assert.Equal(t, "ok", records[0][1]["result"])
+	}
+	err = os.RemoveAll(recordingsDir)
+	assert.NoError(t, err)
+	// the recording cannot be started
+	err = os.WriteFile(recordingsDir, []byte("not a directory"), 0600)
+	assert.NoError(t, err)
+	// the test server uses the default fail open policy
+	conn, client, err = getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		assert.NoError(t, checkBasicSFTP(client))
+		client.Close()
+		conn.Close()
+	}
+	err = os.Remove(recordingsDir)
+	assert.NoError(t, err)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestSecondFactorRequirement(t *testing.T) {
 	usePubKey := true
 	u := getTestUser(usePubKey)
@@ -2951,6 +3562,42 @@ func TestSecondFactorRequirement(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestSecondFactorTrustedNetworks(t *testing.T) {
+	usePubKey := true
+	u := getTestUser(usePubKey)
+	u.Filters.TwoFactorAuthProtocols = []string{common.ProtocolSSH}
+	u.Filters.TwoFactorTrustedNetworks = []string{"invalid network"}
+	_, _, err := httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+	u.Filters.TwoFactorTrustedNetworks = []string{"192.168.1.0/24"}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+
+	_, _, err = getSftpClient(user, usePubKey)
+	assert.Error(t, err)
+
+	user.Filters.TwoFactorTrustedNetworks = []string{"192.168.1.0/24", "127.0.0.0/8"}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	conn, client, err := getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+		assert.NoError(t, checkBasicSFTP(client))
+	}
+	assert.Equal(t, dataprovider.SecondFactorStatusSkippedByNetwork,
+		user.GetSecondFactorStatus("127.0.0.1", common.ProtocolSSH, nil))
+	assert.Equal(t, dataprovider.SecondFactorStatusRequired,
+		user.GetSecondFactorStatus("10.8.0.1", common.ProtocolSSH, errors.New("second factor required")))
+	assert.Equal(t, dataprovider.SecondFactorStatusNotConfigured,
+		user.GetSecondFactorStatus("127.0.0.1", common.ProtocolFTP, nil))
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestNamingRules(t *testing.T) {
 	err := dataprovider.Close()
 	assert.NoError(t, err)
@@ -4060,6 +4707,270 @@ func TestLoginExternalAuthCache(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestLoginExternalAuthResultsCache(t *testing.T) {
+	if runtime.GOOS == osWindows {
+		t.Skip("this test is not available on Windows")
+	}
+	u := getTestUser(false)
+	err := dataprovider.Close()
+	assert.NoError(t, err)
+	err = config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	providerConf := config.GetProviderConf()
+	err = os.WriteFile(extAuthPath, getExtAuthScriptContent(u, false, false, ""), os.ModePerm)
+	assert.NoError(t, err)
+	providerConf.ExternalAuthHook = extAuthPath
+	providerConf.ExternalAuthScope = 1
+	providerConf.ExternalAuthCache.Enabled = true
+	providerConf.ExternalAuthCache.TTL = 0
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.Error(t, err)
+	providerConf.ExternalAuthCache.TTL = 60
+	providerConf.ExternalAuthCache.MaxSize = 0
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.Error(t, err)
+	providerConf.ExternalAuthCache.MaxSize = 10
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.NoError(t, err)
+	conn, client, err := getSftpClient(u, false)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+		assert.NoError(t, checkBasicSFTP(client))
+	}
+	// the successful authentication is now cached, the hook must not be executed
+	err = os.WriteFile(extAuthPath, getExtAuthScriptContent(u, true, false, ""), os.ModePerm)
+	assert.NoError(t, err)
+	conn, client, err = getSftpClient(u, false)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+		assert.NoError(t, checkBasicSFTP(client))
+	}
+	// a different password is a cache miss and the hook returns an error
+	u.Password = defaultPassword + "_mod"
+	conn, client, err = getSftpClient(u, false)
+	if !assert.Error(t, err) {
+		client.Close()
+		conn.Close()
+	}
+	user, _, err := httpdtest.GetUserByUsername(defaultUsername, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	// the cached user was removed so the authentication must fail
+	u.Password = defaultPassword
+	conn, client, err = getSftpClient(u, false)
+	if !assert.Error(t, err) {
+		client.Close()
+		conn.Close()
+	}
+
+	err = dataprovider.Close()
+	assert.NoError(t, err)
+	err = config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	providerConf = config.GetProviderConf()
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.NoError(t, err)
+	err = os.Remove(extAuthPath)
+	assert.NoError(t, err)
+}
+
+func TestLoginUserCache(t *testing.T) {
+	err := dataprovider.Close()
+	assert.NoError(t, err)
+	err = config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	providerConf := config.GetProviderConf()
+	providerConf.UserCache.Enabled = true
+	providerConf.UserCache.TTL = 0
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.Error(t, err)
+	providerConf.UserCache.TTL = 60
+	providerConf.UserCache.MaxSize = 0
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.Error(t, err)
+	providerConf.UserCache.MaxSize = 10
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.NoError(t, err)
+
+	u := getTestUser(false)
+	u.PublicKeys = []string{testPubKey}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	for range 2 {
+		conn, client, err := getSftpClient(u, false)
+		if assert.NoError(t, err) {
+			assert.NoError(t, checkBasicSFTP(client))
+			client.Close()
+			conn.Close()
+		}
+	}
+	conn, client, err := getSftpClient(u, true)
+	if assert.NoError(t, err) {
+		assert.NoError(t, checkBasicSFTP(client))
+		client.Close()
+		conn.Close()
+	}
+	// the credentials are verified for each authentication attempt
+	u.Password = defaultPassword + "_wrong"
+	conn, client, err = getSftpClient(u, false)
+	if !assert.Error(t, err) {
+		client.Close()
+		conn.Close()
+	}
+	// a local update invalidates the cached user
+	user.Password = defaultPassword + "_mod"
+	_, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	u.Password = defaultPassword
+	conn, client, err = getSftpClient(u, false)
+	if !assert.Error(t, err) {
+		client.Close()
+		conn.Close()
+	}
+	u.Password = user.Password
+	conn, client, err = getSftpClient(u, false)
+	if assert.NoError(t, err) {
+		assert.NoError(t, checkBasicSFTP(client))
+		client.Close()
+		conn.Close()
+	}
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	conn, client, err = getSftpClient(u, false)
+	if !assert.Error(t, err) {
+		client.Close()
+		conn.Close()
+	}
+
+	err = dataprovider.Close()
+	assert.NoError(t, err)
+	err = config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	providerConf = config.GetProviderConf()
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.NoError(t, err)
+}
+
+func TestLoginReadReplicas(t *testing.T) {
+	err := dataprovider.Close()
+	assert.NoError(t, err)
+	err = config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	providerConf := config.GetProviderConf()
+	if providerConf.Driver != dataprovider.SQLiteDataProviderName || providerConf.ConnectionString != "" {
+		err = dataprovider.Initialize(providerConf, configDir, true)
+		assert.NoError(t, err)
+		t.Skip("this test is only available with the default sqlite provider")
+	}
+	dbPath := providerConf.Name
+	if !filepath.IsAbs(dbPath) {
+		dbPath = filepath.Join(configDir, dbPath)
+	}
+	providerConf.ReadReplicas.ConnectionStrings = []string{
+		fmt.Sprintf("file:%s?mode=ro", dbPath),
+		fmt.Sprintf("file:%s?mode=ro", filepath.Join(os.TempDir(), "missing_dir", "replica.db")),
+	}
+	providerConf.ReadReplicas.HealthCheckInterval = 0
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.Error(t, err)
+	providerConf.ReadReplicas.HealthCheckInterval = 10
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.NoError(t, err)
+
+	u := getTestUser(false)
+	u.PublicKeys = []string{testPubKey}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	for _, usePubKey := range []bool{false, true, false} {
+		conn, client, err := getSftpClient(u, usePubKey)
+		if assert.NoError(t, err) {
+			assert.NoError(t, checkBasicSFTP(client))
+			client.Close()
+			conn.Close()
+		}
+	}
+	users, _, err := httpdtest.GetUsers(0, 0, http.StatusOK)
+	assert.NoError(t, err)
+	found := false
+	for _, listedUser := range users {
+		if listedUser.Username == user.Username {
+			found = true
+		}
+	}
+	assert.True(t, found)
+	u.Password = defaultPassword + "_wrong"
+	conn, client, err := getSftpClient(u, false)
+	if !assert.Error(t, err) {
+		client.Close()
+		conn.Close()
+	}
+	// the listings always use the primary database
+	err = dataprovider.Close()
+	assert.NoError(t, err)
+	providerConf.ReadReplicas.ListingsMaxLag = -1
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.NoError(t, err)
+	_, _, err = httpdtest.GetUsers(0, 0, http.StatusOK)
+	assert.NoError(t, err)
+	// the replication lag cannot be measured for SQLite: the user lookups while
+	// authenticating use the primary database, the listings use the replica
+	user.Description = "replicated"
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	err = dataprovider.Close()
+	assert.NoError(t, err)
+	replicaPath := filepath.Join(os.TempDir(), "stale_replica.db")
+	data, err := os.ReadFile(dbPath)
+	assert.NoError(t, err)
+	err = os.WriteFile(replicaPath, data, 0600)
+	assert.NoError(t, err)
+	providerConf.ReadReplicas.ConnectionStrings = []string{fmt.Sprintf("file:%s?mode=ro", replicaPath)}
+	providerConf.ReadReplicas.ListingsMaxLag = 0
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.NoError(t, err)
+	user.Description = "not replicated"
+	user.Password = defaultPassword + "_changed"
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	u.Password = defaultPassword + "_changed"
+	conn, client, err = getSftpClient(u, false)
+	if assert.NoError(t, err) {
+		assert.NoError(t, checkBasicSFTP(client))
+		client.Close()
+		conn.Close()
+	}
+	users, _, err = httpdtest.GetUsers(0, 0, http.StatusOK)
+	assert.NoError(t, err)
+	idx := slices.IndexFunc(users, func(listedUser dataprovider.User) bool {
+		return listedUser.Username == user.Username
+	})
+	if assert.GreaterOrEqual(t, idx, 0) {
+		assert.Equal(t, "replicated", users[idx].Description)
+	}
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+
+	err = dataprovider.Close()
+	assert.NoError(t, err)
+	err = os.Remove(replicaPath)
+	assert.NoError(t, err)
+	err = config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	providerConf = config.GetProviderConf()
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.NoError(t, err)
+}
+
 func TestLoginExternalAuthInteractive(t *testing.T) {
 	if runtime.GOOS == osWindows {
 		t.Skip("this test is not available on Windows")
@@ -4150,7 +5061,62 @@ func TestLoginExternalAuthErrors(t *testing.T) {
 	}
 	_, _, err = httpdtest.GetUserByUsername(defaultUsername, http.StatusNotFound)
 	assert.NoError(t, err)
-
+
+	err = dataprovider.Close()
+	assert.NoError(t, err)
+	err = config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	providerConf = config.GetProviderConf()
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.NoError(t, err)
+	err = os.Remove(extAuthPath)
+	assert.NoError(t, err)
+}
+
+func TestLoginExternalAuthTimeout(t *testing.T) {
+	if runtime.GOOS == osWindows {
+		t.Skip("this test is not available on Windows")
+	}
+	u := getTestUser(false)
+	err := dataprovider.Close()
+	assert.NoError(t, err)
+	err = config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	providerConf := config.GetProviderConf()
+	err = os.WriteFile(extAuthPath, []byte("#!/bin/sh\n\nsleep 10\n"), os.ModePerm)
+	assert.NoError(t, err)
+	providerConf.ExternalAuthHook = extAuthPath
+	providerConf.ExternalAuthScope = 1
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.NoError(t, err)
+	commandConfig := command.Config{
+		Timeout: 30,
+		Commands: []command.Command{
+			{
+				Path:    extAuthPath,
+				Timeout: 1,
+				Hook:    command.HookExternalAuth,
+			},
+		},
+	}
+	err = commandConfig.Initialize()
+	assert.NoError(t, err)
+
+	startTime := time.Now()
+	conn, client, err := getSftpClient(u, false)
+	if !assert.Error(t, err, "login must fail, external auth hook timed out") {
+		client.Close()
+		conn.Close()
+	}
+	assert.Less(t, time.Since(startTime), 5*time.Second)
+	_, _, err = httpdtest.GetUserByUsername(defaultUsername, http.StatusNotFound)
+	assert.NoError(t, err)
+
+	commandConfig = command.Config{
+		Timeout: 30,
+	}
+	err = commandConfig.Initialize()
+	assert.NoError(t, err)
 	err = dataprovider.Close()
 	assert.NoError(t, err)
 	err = config.LoadConfig(configDir, "")
@@ -5231,6 +6197,108 @@ func TestVirtualFolders(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestVirtualFolderPermissions(t *testing.T) {
+	usePubKey := false
+	u := getTestUser(usePubKey)
+	u.QuotaFiles = 100
+	mappedPath1 := filepath.Join(os.TempDir(), "vdir1")
+	folderName1 := filepath.Base(mappedPath1)
+	vdirPath1 := "/vdir1"
+	mappedPath2 := filepath.Join(os.TempDir(), "vdir2")
+	folderName2 := filepath.Base(mappedPath2)
+	vdirPath2 := "/vdir1/sub"
+	u.VirtualFolders = append(u.VirtualFolders, vfs.VirtualFolder{
+		BaseVirtualFolder: vfs.BaseVirtualFolder{
+			Name: folderName1,
+		},
+		VirtualPath: vdirPath1,
+		QuotaSize:   -1,
+		QuotaFiles:  -1,
+		Permissions: []string{dataprovider.PermListItems, dataprovider.PermDownload},
+	})
+	u.VirtualFolders = append(u.VirtualFolders, vfs.VirtualFolder{
+		BaseVirtualFolder: vfs.BaseVirtualFolder{
+			Name: folderName2,
+		},
+		VirtualPath: vdirPath2,
+		QuotaSize:   -1,
+		QuotaFiles:  -1,
+	})
+	f1 := vfs.BaseVirtualFolder{
+		Name:       folderName1,
+		MappedPath: mappedPath1,
+	}
+	_, _, err := httpdtest.AddFolder(f1, http.StatusCreated)
+	assert.NoError(t, err)
+	f2 := vfs.BaseVirtualFolder{
+		Name:       folderName2,
+		MappedPath: mappedPath2,
+	}
+	_, _, err = httpdtest.AddFolder(f2, http.StatusCreated)
+	assert.NoError(t, err)
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{dataprovider.PermListItems, dataprovider.PermDownload}, user.VirtualFolders[0].Permissions)
+	testFileSize := int64(65535)
+	err = os.MkdirAll(mappedPath1, os.ModePerm)
+	assert.NoError(t, err)
+	err = createTestFile(filepath.Join(mappedPath1, testFileName), testFileSize)
+	assert.NoError(t, err)
+	conn, client, err := getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		testFilePath := filepath.Join(homeBasePath, testFileName)
+		err = createTestFile(testFilePath, testFileSize)
+		assert.NoError(t, err)
+		localDownloadPath := filepath.Join(homeBasePath, testDLFileName)
+		err = sftpDownloadFile(path.Join(vdirPath1, testFileName), localDownloadPath, testFileSize, client)
+		assert.NoError(t, err)
+		err = sftpUploadFile(testFilePath, path.Join(vdirPath1, testFileName+"1"), testFileSize, client)
+		assert.ErrorIs(t, err, os.ErrPermission)
+		err = client.Mkdir(path.Join(vdirPath1, "dir"))
+		assert.ErrorIs(t, err, os.ErrPermission)
+		err = client.Remove(path.Join(vdirPath1, testFileName))
+		assert.ErrorIs(t, err, os.ErrPermission)
+		err = client.Rename(path.Join(vdirPath1, testFileName), path.Join(vdirPath1, testFileName+"1"))
+		assert.ErrorIs(t, err, os.ErrPermission)
+		err = client.Rename(path.Join(vdirPath1, testFileName), testFileName)
+		assert.ErrorIs(t, err, os.ErrPermission)
+		// the nested virtual folder has no restrictions
+		err = sftpUploadFile(testFilePath, path.Join(vdirPath2, testFileName), testFileSize, client)
+		assert.NoError(t, err)
+		err = sftpUploadFile(testFilePath, testFileName, testFileSize, client)
+		assert.NoError(t, err)
+		err = client.Rename(testFileName, path.Join(vdirPath1, testFileName+"1"))
+		assert.ErrorIs(t, err, os.ErrPermission)
+		_, err = client.Stat(path.Join(vdirPath1, testFileName+"1"))
+		assert.ErrorIs(t, err, os.ErrNotExist)
+
+		user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, user.UsedQuotaFiles)
+		assert.Equal(t, 2*testFileSize, user.UsedQuotaSize)
+
+		err = os.Remove(testFilePath)
+		assert.NoError(t, err)
+		err = os.Remove(localDownloadPath)
+		assert.NoError(t, err)
+	}
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveFolder(vfs.BaseVirtualFolder{Name: folderName1}, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveFolder(vfs.BaseVirtualFolder{Name: folderName2}, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	err = os.RemoveAll(mappedPath1)
+	assert.NoError(t, err)
+	err = os.RemoveAll(mappedPath2)
+	assert.NoError(t, err)
+}
+
 func TestVirtualFoldersQuotaLimit(t *testing.T) {
 	usePubKey := false
 	u1 := getTestUser(usePubKey)
@@ -7665,6 +8733,9 @@ func TestHashedPasswords(t *testing.T) {
 	pwdMapping["{MD5}5f4dcc3b5aa765d61d8327deb882cf99"] = plainPwd
 	pwdMapping["{SHA256}5e884898da28047151d0e56f8dc6292773603d0d6aabbdd62a11ef721d1542d8"] = plainPwd
 	pwdMapping["{SHA512}b109f3bbbc244eb82441917ed06d618b9008dd09b3befd1b5e07394c706a8bb980b1d7785e5976ec049b46df5f1326af5a2ea6d103fd07c95385ffab0cacbc86"] = plainPwd
+	pwdMapping["{SSHA512}o9FQwV8amk4H05j62UVoRg47BEVwtjK/Z+J9OiMaBOSTGq2ho772vZTQiAHODGhP7UUHBYZ99vB/oKOVxCVgcTEyMzQ1Njc4"] = plainPwd
+	pwdMapping["{CRYPT}$6$abcdefgh$yVfUwsw5T.JApa8POvClA1pQ5peiq97DUNyXCZN5IrF.BMSkiaLQ5kvpuEm/VQ1Tvh/KV2TcaWh8qinoW5dhA1"] = plainPwd
+	pwdMapping["$S$Dx2ZRiBxV.6mhHmdwThXMehZeTQlykRiAxLwbfnINXcusfViELs4"] = plainPwd
 	for pwd, clearPwd := range pwdMapping {
 		u := getTestUser(usePubKey)
 		u.Password = pwd
@@ -7715,6 +8786,52 @@ func TestHashedPasswords(t *testing.T) {
 	}
 }
 
+func TestPasswordRehash(t *testing.T) {
+	usePubKey := false
+	plainPwd := "password"
+	hashedPwd, err := bcrypt.GenerateFromPassword([]byte(plainPwd), bcrypt.MinCost)
+	assert.NoError(t, err)
+	u := getTestUser(usePubKey)
+	u.Password = string(hashedPwd)
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	user.Password = plainPwd
+	conn, client, err := getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		assert.NoError(t, checkBasicSFTP(client))
+		conn.Close()
+		client.Close()
+	}
+	// the weak hash must be upgraded using the configured cost
+	user, err = dataprovider.UserExists(user.Username, "")
+	assert.NoError(t, err)
+	assert.NotEqual(t, string(hashedPwd), user.Password)
+	cost, err := bcrypt.Cost([]byte(user.Password))
+	assert.NoError(t, err)
+	assert.Greater(t, cost, bcrypt.MinCost)
+	upgradedPwd := user.Password
+	// update the user to invalidate the cached password and force a new check
+	user.Password = ""
+	_, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	user.Password = plainPwd
+	conn, client, err = getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		assert.NoError(t, checkBasicSFTP(client))
+		conn.Close()
+		client.Close()
+	}
+	// the upgraded hash must not be changed again
+	user, err = dataprovider.UserExists(user.Username, "")
+	assert.NoError(t, err)
+	assert.Equal(t, upgradedPwd, user.Password)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestPasswordsHashPbkdf2Sha256_389DS(t *testing.T) {
 	pbkdf389dsPwd := "{PBKDF2_SHA256}AAAIAMZIKG4ie44zJY4HOXI+upFR74PzWLUQV63jg+zzkbEjCK3N4qW583WF7EdcpeoOMQ4HY3aWEXB6lnXhXJixbJkU4vVSJkL6YCbU3TrD0qn1uUUVSkaIgAOtmZENitwbhYhiWfEzGyAtFqkFd75P5xhWJEog9XhQKYrR0f7S3WGGZq03JRcLJ460xpU97bE/sWRn7sshgkWzLuyrs0I+XRKmK7FJeaA9zd+1m44Y3IVmZ2YLdKATzjRHAIgpBC6i1TWOcpKJT1+feP1C9hrxH8vU9baw9thNiO8jSHaZlwb//KpJFe0ahVnG/1ubiG8cO0+CCqDqXVJR6Vr4QZxHP+4pwooW+4TP/L+HFdyA1y6z4gKfqYnBsmb3sD1R1TbxfH4btTdvgZAnBk9CmR3QASkFXxeTYsrmNd5+9IAHc6dm"
 	pbkdf389dsPwd = pbkdf389dsPwd[15:]
@@ -7830,6 +8947,98 @@ func TestPermDownload(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestPermDropbox(t *testing.T) {
+	usePubKey := true
+	u := getTestUser(usePubKey)
+	u.Permissions["/"] = []string{dataprovider.PermDropbox, dataprovider.PermListItems}
+	_, _, err := httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+	u.Permissions["/"] = []string{dataprovider.PermDropbox, dataprovider.PermDownload}
+	_, _, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+	u.Permissions["/"] = []string{dataprovider.PermDropbox}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	assert.True(t, user.HasPerms([]string{dataprovider.PermUpload, dataprovider.PermCreateDirs}, "/"))
+	assert.False(t, user.HasAnyPerm([]string{dataprovider.PermListItems, dataprovider.PermDownload}, "/"))
+	err = os.MkdirAll(user.GetHomeDir(), os.ModePerm)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(user.GetHomeDir(), "existing.txt"), []byte("content"), 0666)
+	assert.NoError(t, err)
+	testFileSize := int64(65535)
+	conn, client, err := getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+		// the initial realpath and the stat for the start directory must work
+		wd, err := client.Getwd()
+		assert.NoError(t, err)
+		assert.Equal(t, "/", wd)
+		info, err := client.Stat(".")
+		if assert.NoError(t, err) {
+			assert.True(t, info.IsDir())
+		}
+		entries, err := client.ReadDir(".")
+		assert.NoError(t, err)
+		assert.Len(t, entries, 0)
+		_, err = client.Stat("existing.txt")
+		assert.ErrorIs(t, err, os.ErrNotExist)
+		_, err = client.Lstat("existing.txt")
+		assert.ErrorIs(t, err, os.ErrNotExist)
+		_, err = client.Open("existing.txt")
+		assert.ErrorIs(t, err, os.ErrPermission)
+		// writeSFTPFile checks the size of the uploaded file
+		err = writeSFTPFile(testFileName, testFileSize, client)
+		assert.NoError(t, err)
+		// some clients open the file for reading too
+		f, err := client.OpenFile("rw_file", os.O_RDWR|os.O_CREATE|os.O_TRUNC)
+		if assert.NoError(t, err) {
+			_, err = f.Write([]byte("content"))
+			assert.NoError(t, err)
+			err = f.Close()
+			assert.NoError(t, err)
+		}
+		info, err = client.Lstat("rw_file")
+		if assert.NoError(t, err) {
+			assert.Equal(t, int64(7), info.Size())
+		}
+		err = client.Mkdir("sub")
+		assert.NoError(t, err)
+		info, err = client.Stat("sub")
+		if assert.NoError(t, err) {
+			assert.True(t, info.IsDir())
+		}
+		err = writeSFTPFile(path.Join("sub", testFileName), testFileSize, client)
+		assert.NoError(t, err)
+		entries, err = client.ReadDir("sub")
+		assert.NoError(t, err)
+		assert.Len(t, entries, 0)
+		localDownloadPath := filepath.Join(homeBasePath, testDLFileName)
+		err = sftpDownloadFile(testFileName, localDownloadPath, testFileSize, client)
+		assert.Error(t, err)
+		err = os.Remove(localDownloadPath)
+		assert.NoError(t, err)
+	}
+	// the paths uploaded within another session cannot be stat or overwritten
+	conn, client, err = getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+		_, err = client.Stat(testFileName)
+		assert.ErrorIs(t, err, os.ErrNotExist)
+		_, err = client.Stat("sub")
+		assert.ErrorIs(t, err, os.ErrNotExist)
+		err = writeSFTPFile(testFileName, testFileSize, client)
+		assert.Error(t, err)
+	}
+	assert.FileExists(t, filepath.Join(user.GetHomeDir(), "sub", testFileName))
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestPermUpload(t *testing.T) {
 	usePubKey := false
 	u := getTestUser(usePubKey)
@@ -8631,6 +9840,52 @@ func TestUserPerms(t *testing.T) {
 	assert.True(t, user.HasPerm(dataprovider.PermDownload, "/p/1/test/file.dat"))
 }
 
+func TestVirtualFolderPerms(t *testing.T) {
+	user := getTestUser(true)
+	user.Permissions = make(map[string][]string)
+	user.Permissions["/"] = []string{dataprovider.PermAny}
+	user.Permissions["/vdir/sub/dir"] = []string{dataprovider.PermListItems, dataprovider.PermUpload}
+	user.VirtualFolders = []vfs.VirtualFolder{
+		{
+			BaseVirtualFolder: vfs.BaseVirtualFolder{
+				Name: "vdir",
+			},
+			VirtualPath: "/vdir",
+			Permissions: []string{dataprovider.PermListItems, dataprovider.PermDownload},
+		},
+		{
+			BaseVirtualFolder: vfs.BaseVirtualFolder{
+				Name: "sub",
+			},
+			VirtualPath: "/vdir/sub",
+			Permissions: []string{dataprovider.PermListItems, dataprovider.PermUpload, dataprovider.PermCreateDirs},
+		},
+		{
+			BaseVirtualFolder: vfs.BaseVirtualFolder{
+				Name: "open",
+			},
+			VirtualPath: "/vdir/open",
+		},
+	}
+	assert.Equal(t, []string{dataprovider.PermAny}, user.GetPermissionsForPath("/"))
+	assert.True(t, user.HasPerm(dataprovider.PermDelete, "/dir/file"))
+	assert.Equal(t, []string{dataprovider.PermListItems, dataprovider.PermDownload}, user.GetPermissionsForPath("/vdir"))
+	assert.True(t, user.HasPerm(dataprovider.PermDownload, "/vdir/dir"))
+	assert.False(t, user.HasPerm(dataprovider.PermUpload, "/vdir/dir"))
+	assert.False(t, user.HasAnyPerm([]string{dataprovider.PermDelete, dataprovider.PermRename}, "/vdir"))
+	assert.False(t, user.HasPerms([]string{dataprovider.PermListItems, dataprovider.PermUpload}, "/vdir/dir/subdir"))
+	// nested virtual folders have their own permissions
+	assert.True(t, user.HasPerm(dataprovider.PermUpload, "/vdir/sub"))
+	assert.True(t, user.HasPerm(dataprovider.PermCreateDirs, "/vdir/sub/other"))
+	assert.False(t, user.HasPerm(dataprovider.PermDownload, "/vdir/sub"))
+	assert.Equal(t, []string{dataprovider.PermListItems, dataprovider.PermUpload}, user.GetPermissionsForPath("/vdir/sub/dir"))
+	assert.False(t, user.HasPerm(dataprovider.PermCreateDirs, "/vdir/sub/dir/subdir"))
+	assert.True(t, user.HasPerm(dataprovider.PermDelete, "/vdir/open/file"))
+	// virtual folders without restrictions don't change the user permissions
+	user.VirtualFolders[0].Permissions = []string{dataprovider.PermAny}
+	assert.True(t, user.HasPerm(dataprovider.PermUpload, "/vdir/dir"))
+}
+
 func TestWildcardPermissions(t *testing.T) {
 	user := getTestUser(true)
 	user.Permissions = make(map[string][]string)
@@ -9046,6 +10301,64 @@ func TestStatVFS(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestStatVFSQuota(t *testing.T) {
+	usePubKey := false
+	u := getTestUser(usePubKey)
+	u.QuotaSize = 1024 * 1024 * 1024
+	mappedPath := filepath.Join(os.TempDir(), "vdir")
+	folderName := filepath.Base(mappedPath)
+	vdirPath := "/vdir"
+	u.VirtualFolders = append(u.VirtualFolders, vfs.VirtualFolder{
+		BaseVirtualFolder: vfs.BaseVirtualFolder{
+			Name: folderName,
+		},
+		VirtualPath: vdirPath,
+		QuotaFiles:  10,
+		QuotaSize:   100 * 1024 * 1024,
+	})
+	f := vfs.BaseVirtualFolder{
+		Name:       folderName,
+		MappedPath: mappedPath,
+	}
+	_, _, err := httpdtest.AddFolder(f, http.StatusCreated)
+	assert.NoError(t, err)
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	err = dataprovider.UpdateUserQuota(&user, 10, 600*1024*1024, true)
+	assert.NoError(t, err)
+	err = dataprovider.UpdateVirtualFolderQuota(&f, 4, 60*1024*1024, true)
+	assert.NoError(t, err)
+	conn, client, err := getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		stat, err := client.StatVFS("/")
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(1024*1024*1024), stat.TotalSpace())
+		// the free space is limited to the real free space too
+		assert.LessOrEqual(t, stat.FreeSpace(), uint64(424*1024*1024))
+		assert.Greater(t, stat.FreeSpace(), uint64(0))
+		// the virtual folder has its own quota
+		stat, err = client.StatVFS(vdirPath)
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(100*1024*1024), stat.TotalSpace())
+		assert.LessOrEqual(t, stat.FreeSpace(), uint64(40*1024*1024))
+		assert.Greater(t, stat.FreeSpace(), uint64(0))
+		assert.Equal(t, uint64(10), stat.Files)
+		assert.Equal(t, uint64(6), stat.Ffree)
+	}
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveFolder(vfs.BaseVirtualFolder{Name: folderName}, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	err = os.RemoveAll(mappedPath)
+	assert.NoError(t, err)
+}
+
 func TestStatVFSCloudBackend(t *testing.T) {
 	usePubKey := true
 	u := getTestUser(usePubKey)
@@ -10580,6 +11893,26 @@ func TestSCPUploadMaxSize(t *testing.T) {
 	assert.Error(t, err)
 	err = scpUpload(testFilePath, remoteUpPath, false, false)
 	assert.NoError(t, err)
+	// the upload size limits take precedence over the user limit
+	user.Filters.UploadSizeLimits = []dataprovider.UploadSizeLimit{
+		{
+			Pattern: testFileName1,
+			MaxSize: testFileSize1,
+		},
+		{
+			Pattern: "*.dat",
+			MaxSize: 1024,
+		},
+	}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	err = scpUpload(testFilePath1, remoteUpPath, false, false)
+	assert.NoError(t, err)
+	err = os.Remove(filepath.Join(user.GetHomeDir(), testFileName))
+	assert.NoError(t, err)
+	err = scpUpload(testFilePath, remoteUpPath, false, false)
+	assert.Error(t, err)
+	assert.NoFileExists(t, filepath.Join(user.GetHomeDir(), testFileName))
 	err = os.Remove(testFilePath)
 	assert.NoError(t, err)
 	err = os.Remove(testFilePath1)
@@ -11524,6 +12857,29 @@ func appendToTestFile(path string, size int64) error {
 	return nil
 }
 
+func readSessionRecordings(t *testing.T, dir string) [][]map[string]any {
+	var recordings [][]map[string]any
+	entries, err := os.ReadDir(dir)
+	if !assert.NoError(t, err) {
+		return recordings
+	}
+	for _, entry := range entries {
+		content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if !assert.NoError(t, err) {
+			continue
+		}
+		var records []map[string]any
+		for _, line := range bytes.Split(bytes.TrimSpace(content), []byte("\n")) {
+			record := make(map[string]any)
+			if assert.NoError(t, json.Unmarshal(line, &record)) {
+				records = append(records, record)
+			}
+		}
+		recordings = append(recordings, records)
+	}
+	return recordings
+}
+
 func checkBasicSFTP(client *sftp.Client) error {
 	_, err := client.Getwd()
 	if err != nil {
@@ -11808,6 +13164,11 @@ func checkSystemCommands() {
 }
 
 func initGitRepo(path string) ([]byte, error) {
+	// a relative path, for example if the test user was not added and so its
+	// home dir is empty, would create the repository inside the package directory
+	if !filepath.IsAbs(path) {
+		return nil, fmt.Errorf("refusing to create a git repository at relative path %q", path)
+	}
 	err := os.MkdirAll(path, os.ModePerm)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,133 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package sftpd
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+)
+
+// socket options not supported on the current platform are logged only once
+// for each binding
+var unsupportedSocketOptions sync.Map
+
+func (b *Binding) validateSocketOptions() error {
+	if b.TCPKeepAlive < 0 || b.TCPKeepAlive > 2 {
+		return fmt.Errorf("invalid TCP keepalive %d for binding %q, 0, 1 and 2 are supported",
+			b.TCPKeepAlive, b.GetAddress())
+	}
+	if b.TCPKeepAliveInterval < 0 {
+		return fmt.Errorf("invalid TCP keepalive interval %d for binding %q", b.TCPKeepAliveInterval, b.GetAddress())
+	}
+	if b.TCPKeepAliveCount < 0 {
+		return fmt.Errorf("invalid TCP keepalive count %d for binding %q", b.TCPKeepAliveCount, b.GetAddress())
+	}
+	if b.TCPNoDelay < 0 || b.TCPNoDelay > 2 {
+		return fmt.Errorf("invalid TCP no delay %d for binding %q, 0, 1 and 2 are supported",
+			b.TCPNoDelay, b.GetAddress())
+	}
+	if b.ReceiveBufferSize < 0 {
+		return fmt.Errorf("invalid receive buffer size %d for binding %q", b.ReceiveBufferSize, b.GetAddress())
+	}
+	if b.SendBufferSize < 0 {
+		return fmt.Errorf("invalid send buffer size %d for binding %q", b.SendBufferSize, b.GetAddress())
+	}
+	return nil
+}
+
+// getListenConfig returns the configuration to create the listener for this
+// binding. The buffer sizes are set on the listening socket, the accepted
+// connections inherit them and setting them before listening allows to
+// negotiate a suitable TCP window scale
+func (b *Binding) getListenConfig() *net.ListenConfig {
+	lc := &net.ListenConfig{}
+	if b.ReceiveBufferSize == 0 && b.SendBufferSize == 0 {
+		return lc
+	}
+	lc.Control = func(_, _ string, c syscall.RawConn) error {
+		return c.Control(func(fd uintptr) {
+			if b.ReceiveBufferSize > 0 {
+				if err := setSocketBufferSize(fd, syscall.SO_RCVBUF, b.ReceiveBufferSize); err != nil {
+					b.logUnsupportedSocketOption("receive buffer size", err)
+				}
+			}
+			if b.SendBufferSize > 0 {
+				if err := setSocketBufferSize(fd, syscall.SO_SNDBUF, b.SendBufferSize); err != nil {
+					b.logUnsupportedSocketOption("send buffer size", err)
+				}
+			}
+		})
+	}
+	return lc
+}
+
+// applySocketOptions applies the TCP keepalive and no delay options to an
+// accepted connection. Options not supported on the current platform are
+// logged and ignored
+func (b *Binding) applySocketOptions(conn *net.TCPConn) {
+	switch b.TCPKeepAlive {
+	case 1:
+		config := net.KeepAliveConfig{
+			Enable: true,
+			Count:  b.TCPKeepAliveCount,
+		}
+		if b.TCPKeepAliveInterval > 0 {
+			config.Idle = time.Duration(b.TCPKeepAliveInterval) * time.Second
+			config.Interval = config.Idle
+		}
+		if err := conn.SetKeepAliveConfig(config); err != nil {
+			b.logUnsupportedSocketOption("TCP keepalive", err)
+		}
+	case 2:
+		if err := conn.SetKeepAlive(false); err != nil {
+			b.logUnsupportedSocketOption("TCP keepalive", err)
+		}
+	}
+	if b.TCPNoDelay > 0 {
+		if err := conn.SetNoDelay(b.TCPNoDelay == 1); err != nil {
+			b.logUnsupportedSocketOption("TCP no delay", err)
+		}
+	}
+}
+
+func (b *Binding) logUnsupportedSocketOption(option string, err error) {
+	if _, loaded := unsupportedSocketOptions.LoadOrStore(b.GetAddress()+"|"+option, true); loaded {
+		return
+	}
+	logger.Warn(logSender, "", "unable to set %s for binding %q, the option will be ignored: %v",
+		option, b.GetAddress(), err)
+}
+
+// socketOptionsListener applies the binding socket options to the accepted connections
+type socketOptionsListener struct {
+	net.Listener
+	binding Binding
+}
+
+func (l *socketOptionsListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return conn, err
+	}
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		l.binding.applySocketOptions(tcpConn)
+	}
+	return conn, nil
+}
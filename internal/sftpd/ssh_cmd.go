@@ -139,6 +139,13 @@ func (c *sshCommand) handle() (err error) {
 	}
 	defer common.Connections.Remove(c.connection.GetID())
 
+	if err := c.connection.startRecording(); err != nil {
+		c.connection.Disconnect() //nolint:errcheck
+		return err
+	}
+	defer c.connection.stopRecording()
+	defer func() { c.connection.recordCommand(err) }()
+
 	c.connection.UpdateLastActivity()
 	if slices.Contains(sshHashCommands, c.command) {
 		return c.handleHashCommands()
@@ -149,8 +156,10 @@ func (c *sshCommand) handle() (err error) {
 		}
 		return c.executeSystemCommand(command)
 	} else if c.command == "cd" {
+		c.sendMOTD()
 		c.sendExitStatus(nil)
 	} else if c.command == "pwd" {
+		c.sendMOTD()
 		// hard coded response to the start directory
 		c.connection.channel.Write([]byte(util.CleanPath(c.connection.User.Filters.StartDirectory) + "\n")) //nolint:errcheck
 		c.sendExitStatus(nil)
@@ -236,7 +245,7 @@ func (c *sshCommand) handleHashCommands() error {
 		if !c.connection.User.HasPerm(dataprovider.PermListItems, sshPath) {
 			return c.sendErrorResponse(c.connection.GetPermissionDeniedError())
 		}
-		hash, err := c.computeHashForFile(fs, h, fsPath)
+		hash, err := c.getHashForFile(fs, h, fsPath)
 		if err != nil {
 			return c.sendErrorResponse(c.connection.GetFsError(fs, err))
 		}
@@ -609,6 +618,14 @@ func (c *sshCommand) getSizeForPath(fs vfs.Fs, name string) (int, int64, error)
 	return 0, 0, nil
 }
 
+// sendMOTD sends the message of the day, if any, to stderr, the clients
+// could parse the standard output to get the initial directory
+func (c *sshCommand) sendMOTD() {
+	if channel, ok := c.connection.channel.(ssh.Channel); ok {
+		c.connection.sendMOTD(channel.Stderr())
+	}
+}
+
 func (c *sshCommand) sendErrorResponse(err error) error {
 	errorString := fmt.Sprintf("%v: %v %v\n", c.command, c.getDestPath(), err)
 	c.connection.channel.Write([]byte(errorString)) //nolint:errcheck
@@ -663,6 +680,24 @@ func (c *sshCommand) sendExitStatus(err error) {
 	}
 }
 
+// getHashForFile returns the checksum stored by the filesystem for the
+// specified file, if available, otherwise the file is read to compute it
+func (c *sshCommand) getHashForFile(fs vfs.Fs, hasher hash.Hash, fsPath string) (string, error) {
+	if getter, ok := vfs.UnwrapFs(fs).(vfs.FsChecksumGetter); ok {
+		checksum, err := getter.GetStoredChecksum(fsPath, strings.TrimSuffix(c.command, "sum"))
+		if err == nil {
+			c.connection.Log(logger.LevelDebug, "command %q, using the stored checksum for file %q",
+				c.command, fsPath)
+			return checksum, nil
+		}
+		if !errors.Is(err, vfs.ErrChecksumUnavailable) {
+			return "", err
+		}
+	}
+	c.connection.Log(logger.LevelDebug, "command %q, reading file %q to compute the checksum", c.command, fsPath)
+	return c.computeHashForFile(fs, hasher, fsPath)
+}
+
 func (c *sshCommand) computeHashForFile(fs vfs.Fs, hasher hash.Hash, path string) (string, error) {
 	hash := ""
 	f, r, _, err := fs.Open(path, 0)
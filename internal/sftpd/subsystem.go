@@ -75,7 +75,7 @@ func ServeSubSystemConnection(user *dataprovider.User, connectionID string, read
 
 	dataprovider.UpdateLastLogin(user)
 	sftp.SetSFTPExtensions(sftpExtensions...) //nolint:errcheck
-	server := sftp.NewRequestServer(connection.channel, sftp.Handlers{
+	server := sftp.NewRequestServer(newDisconnectChannel(connection.channel, connection), sftp.Handlers{
 		FileGet:  connection,
 		FilePut:  connection,
 		FileCmd:  connection,
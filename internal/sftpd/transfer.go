@@ -17,12 +17,20 @@ package sftpd
 import (
 	"fmt"
 	"io"
+	"time"
 
 	"github.com/drakkan/sftpgo/v2/internal/common"
 	"github.com/drakkan/sftpgo/v2/internal/metric"
 	"github.com/drakkan/sftpgo/v2/internal/vfs"
 )
 
+const (
+	// buffer size for the SCP and SSH commands copy loops
+	transferBufferSize = 256 * 1024
+	// minimum buffer size for the throttled transfers
+	minTransferBufferSize = 32768
+)
+
 type writerAtCloser interface {
 	io.WriterAt
 	io.Closer
@@ -56,6 +64,13 @@ type transfer struct {
 	writerAt   writerAtCloser
 	readerAt   readerAtCloser
 	isFinished bool
+	// filesystem provider for the SFTP operations latency metric,
+	// -1 means that the metric is not updated for this transfer
+	fsProvider int
+	// releases the SFTP handle for this transfer, if any
+	releaseHandle func()
+	// connection to record the transfer result to, nil if the session is not recorded
+	recordingConn *Connection
 }
 
 func newTransfer(baseTransfer *common.BaseTransfer, pipeWriter vfs.PipeWriter, pipeReader vfs.PipeReader,
@@ -92,15 +107,17 @@ func newTransfer(baseTransfer *common.BaseTransfer, pipeWriter vfs.PipeWriter, p
 	}
 	return &transfer{
 		BaseTransfer: baseTransfer,
-		writerAt:     writer,
+		writerAt:     baseTransfer.WrapUploadWriterAt(writer),
 		readerAt:     reader,
 		isFinished:   false,
+		fsProvider:   -1,
 	}
 }
 
 // ReadAt reads len(p) bytes from the File to download starting at byte offset off and updates the bytes sent.
 // It handles download bandwidth throttling too
 func (t *transfer) ReadAt(p []byte, off int64) (n int, err error) {
+	defer t.operationCompleted(metric.SFTPOperationRead, t.operationStart())
 	t.Connection.UpdateLastActivity()
 
 	n, err = t.readerAt.ReadAt(p, off)
@@ -123,6 +140,7 @@ func (t *transfer) ReadAt(p []byte, off int64) (n int, err error) {
 // WriteAt writes len(p) bytes to the uploaded file starting at byte offset off and updates the bytes received.
 // It handles upload bandwidth throttling too
 func (t *transfer) WriteAt(p []byte, off int64) (n int, err error) {
+	defer t.operationCompleted(metric.SFTPOperationWrite, t.operationStart())
 	t.Connection.UpdateLastActivity()
 	if off < t.MinWriteOffset {
 		err := fmt.Errorf("invalid write offset: %v minimum valid value: %v", off, t.MinWriteOffset)
@@ -132,6 +150,7 @@ func (t *transfer) WriteAt(p []byte, off int64) (n int, err error) {
 
 	n, err = t.writerAt.WriteAt(p, off)
 	t.BytesReceived.Add(int64(n))
+	t.UpdateChecksum(p[:n], off)
 
 	if err == nil {
 		err = t.CheckWrite()
@@ -151,6 +170,10 @@ func (t *transfer) WriteAt(p []byte, off int64) (n int, err error) {
 // If there is an error no action will be executed and, in atomic mode, we try to delete
 // the temporary file
 func (t *transfer) Close() error {
+	defer t.operationCompleted(metric.SFTPOperationClose, t.operationStart())
+	if t.releaseHandle != nil {
+		defer t.releaseHandle()
+	}
 	if err := t.setFinished(); err != nil {
 		return err
 	}
@@ -159,14 +182,19 @@ func (t *transfer) Close() error {
 	if errBaseClose != nil {
 		err = errBaseClose
 	}
+	if t.recordingConn != nil {
+		t.recordingConn.recordTransferClose(t, err)
+	}
 	return t.Connection.GetFsError(t.Fs, err)
 }
 
 func (t *transfer) closeIO() error {
+	t.CompleteUploadInspection()
 	var err error
 	if t.File != nil {
 		err = t.File.Close()
 	} else if t.writerAt != nil {
+		t.CancelUploadOnError()
 		err = t.writerAt.Close()
 		t.Lock()
 		// we set ErrTransfer here so quota is not updated, in this case the uploads are atomic
@@ -183,6 +211,20 @@ func (t *transfer) closeIO() error {
 	return err
 }
 
+func (t *transfer) operationStart() time.Time {
+	if t.fsProvider < 0 {
+		return time.Time{}
+	}
+	return operationStart()
+}
+
+func (t *transfer) operationCompleted(operation int, start time.Time) {
+	if start.IsZero() {
+		return
+	}
+	metric.SFTPOperationCompleted(operation, t.fsProvider, start)
+}
+
 func (t *transfer) setFinished() error {
 	t.Lock()
 	defer t.Unlock()
@@ -193,6 +235,48 @@ func (t *transfer) setFinished() error {
 	return nil
 }
 
+// getBufferSize returns the buffer size for the copy loops. Throttled transfers
+// use smaller buffers, about 100 ms of data, so the bandwidth limit is applied
+// in small steps
+func (t *transfer) getBufferSize() int {
+	bandwidth := t.Connection.User.UploadBandwidth
+	if t.GetType() == common.TransferDownload {
+		bandwidth = t.Connection.User.DownloadBandwidth
+	}
+	if bandwidth > 0 {
+		return int(min(max(bandwidth*1024/10, minTransferBufferSize), transferBufferSize))
+	}
+	return transferBufferSize
+}
+
+// copyToWriter sends the file to download to dst, it is used for SCP downloads.
+// The file is read, until EOF, in pooled buffers sized by getBufferSize. Bytes
+// accounting, quota checks and throttling are handled by ReadAt
+func (t *transfer) copyToWriter(dst io.Writer) (int64, error) {
+	buf, releaseBuf := vfs.GetTransferBuffer(t.getBufferSize())
+	defer releaseBuf()
+
+	var written int64
+	for {
+		n, err := t.ReadAt(buf, written)
+		if n > 0 && (err == nil || err == io.EOF) {
+			nw, ew := dst.Write(buf[:n])
+			if ew != nil {
+				err = ew
+			} else if nw != n {
+				err = io.ErrShortWrite
+			}
+		}
+		written += int64(n)
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+}
+
 // used for ssh commands.
 // It reads from src until EOF so it does not treat an EOF from Read as an error to be reported.
 // EOF from Write is reported as error
@@ -206,7 +290,9 @@ func (t *transfer) copyFromReaderToWriter(dst io.Writer, src io.Reader) (int64,
 		return 0, common.ErrQuotaExceeded
 	}
 	isDownload := t.GetType() == common.TransferDownload
-	buf := make([]byte, 32768)
+	buf, releaseBuf := vfs.GetTransferBuffer(t.getBufferSize())
+	defer releaseBuf()
+
 	for {
 		t.Connection.UpdateLastActivity()
 		nr, er := src.Read(buf)
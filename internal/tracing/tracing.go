@@ -0,0 +1,246 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+// Package tracing provides OpenTelemetry tracing for SFTP sessions.
+// Spans are exported using OTLP over HTTP and only if an exporter endpoint
+// is configured, if tracing is disabled the exported functions return
+// no-op spans without allocating
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/version"
+)
+
+const (
+	logSender       = "tracing"
+	serviceName     = "sftpgo"
+	tracerName      = "github.com/drakkan/sftpgo/v2"
+	shutdownTimeout = 10 * time.Second
+)
+
+// Supported samplers
+const (
+	SamplerRatio       = "ratio"
+	SamplerParentBased = "parent_based"
+)
+
+var (
+	enabled        atomic.Bool
+	tracerProvider *sdktrace.TracerProvider
+	tracer         trace.Tracer
+	connections    connectionContexts
+	noopSpan       = trace.SpanFromContext(context.Background())
+)
+
+// Config defines the configuration for the OpenTelemetry tracing
+type Config struct {
+	// OTLP HTTP exporter endpoint as host:port, for example "localhost:4318".
+	// Empty means disabled
+	Endpoint string
+	// URL path for the exporter endpoint, empty means "/v1/traces"
+	URLPath string
+	// Insecure disables TLS for the exporter connection
+	Insecure bool
+	// Sampler defines the sampling strategy: "ratio" or "parent_based".
+	// Parent based sampling uses the ratio for the root spans
+	Sampler string
+	// SamplingRatio defines the fraction of traces to sample, from 0 to 1
+	SamplingRatio float64
+}
+
+func (c *Config) getSampler() (sdktrace.Sampler, error) {
+	if c.SamplingRatio < 0 || c.SamplingRatio > 1 {
+		return nil, fmt.Errorf("invalid sampling ratio %v, it must be between 0 and 1", c.SamplingRatio)
+	}
+	ratioSampler := sdktrace.TraceIDRatioBased(c.SamplingRatio)
+	switch c.Sampler {
+	case "", SamplerRatio:
+		return ratioSampler, nil
+	case SamplerParentBased:
+		return sdktrace.ParentBased(ratioSampler), nil
+	default:
+		return nil, fmt.Errorf("unsupported sampler %q", c.Sampler)
+	}
+}
+
+// Initialize configures the tracing. Any previously configured
+// tracer provider is shut down
+func Initialize(config Config) error {
+	Shutdown()
+
+	if config.Endpoint == "" {
+		return nil
+	}
+	sampler, err := config.getSampler()
+	if err != nil {
+		return err
+	}
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(config.Endpoint)}
+	if config.URLPath != "" {
+		opts = append(opts, otlptracehttp.WithURLPath(config.URLPath))
+	}
+	if config.Insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	// the exporter does not connect to the endpoint here
+	exporter, err := otlptracehttp.New(context.Background(), opts...)
+	if err != nil {
+		return fmt.Errorf("unable to create the OTLP exporter: %w", err)
+	}
+	res := resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName(serviceName),
+		semconv.ServiceVersion(version.Get().Version),
+	)
+	tracerProvider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sampler),
+		sdktrace.WithResource(res),
+	)
+	tracer = tracerProvider.Tracer(tracerName)
+	enabled.Store(true)
+	logger.Info(logSender, "", "tracing enabled, endpoint %q, sampler %q, ratio %v", config.Endpoint,
+		config.Sampler, config.SamplingRatio)
+	return nil
+}
+
+// Shutdown disables the tracing and flushes the pending spans
+func Shutdown() {
+	if !enabled.Swap(false) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := tracerProvider.Shutdown(ctx); err != nil {
+		logger.Warn(logSender, "", "unable to shutdown the tracer provider: %v", err)
+	}
+}
+
+// IsEnabled returns true if the tracing is enabled
+func IsEnabled() bool {
+	return enabled.Load()
+}
+
+// StartConnectionSpan starts the span for an SSH connection
+func StartConnectionSpan(connectionID, username, ip, clientVersion string) (context.Context, trace.Span) {
+	ctx := context.Background()
+	if !enabled.Load() {
+		return ctx, trace.SpanFromContext(ctx)
+	}
+	return tracer.Start(ctx, "ssh.connection", trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("sftpgo.connection_id", connectionID),
+			attribute.String("enduser.id", username),
+			semconv.ClientAddress(ip),
+			attribute.String("ssh.client_version", clientVersion),
+		))
+}
+
+// StartSpan starts a child span of the span in the specified context
+func StartSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if !enabled.Load() {
+		return ctx, trace.SpanFromContext(ctx)
+	}
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// StartConnectionChildSpan starts a child span of the span registered for the
+// specified connection ID. It returns a no-op span if no span is registered
+func StartConnectionChildSpan(connectionID, name string, attrs ...attribute.KeyValue) trace.Span {
+	if !enabled.Load() {
+		return noopSpan
+	}
+	ctx, ok := connections.get(connectionID)
+	if !ok {
+		return noopSpan
+	}
+	_, span := tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+	return span
+}
+
+// NoopSpan returns a span that does nothing
+func NoopSpan() trace.Span {
+	return noopSpan
+}
+
+// EndSpan ends the specified span and records the error, if any
+func EndSpan(span trace.Span, err error) {
+	if !span.IsRecording() {
+		return
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// AddConnectionContext registers the context, containing the parent span,
+// for the specified connection ID
+func AddConnectionContext(ctx context.Context, connectionID string) {
+	if !enabled.Load() || !trace.SpanFromContext(ctx).IsRecording() {
+		return
+	}
+	connections.add(ctx, connectionID)
+}
+
+// RemoveConnectionContext removes the context for the specified connection ID
+func RemoveConnectionContext(connectionID string) {
+	connections.remove(connectionID)
+}
+
+type connectionContexts struct {
+	sync.RWMutex
+	contexts map[string]context.Context
+}
+
+func (c *connectionContexts) add(ctx context.Context, connectionID string) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.contexts == nil {
+		c.contexts = make(map[string]context.Context)
+	}
+	c.contexts[connectionID] = ctx
+}
+
+func (c *connectionContexts) remove(connectionID string) {
+	c.Lock()
+	defer c.Unlock()
+
+	delete(c.contexts, connectionID)
+}
+
+func (c *connectionContexts) get(connectionID string) (context.Context, bool) {
+	c.RLock()
+	defer c.RUnlock()
+
+	ctx, ok := c.contexts[connectionID]
+	return ctx, ok
+}
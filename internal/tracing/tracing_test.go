@@ -0,0 +1,119 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package tracing
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTracingDisabled(t *testing.T) {
+	err := Initialize(Config{})
+	require.NoError(t, err)
+	assert.False(t, IsEnabled())
+
+	ctx, span := StartConnectionSpan("id", "user", "127.0.0.1", "SSH-2.0-client")
+	assert.False(t, span.IsRecording())
+	AddConnectionContext(ctx, "id")
+	_, ok := connections.get("id")
+	assert.False(t, ok)
+	childSpan := StartConnectionChildSpan("id", "child")
+	assert.False(t, childSpan.IsRecording())
+	_, childSpan = StartSpan(ctx, "child")
+	assert.False(t, childSpan.IsRecording())
+	EndSpan(childSpan, errors.New("test error"))
+	span.End()
+	Shutdown()
+}
+
+func TestTracingConfigErrors(t *testing.T) {
+	err := Initialize(Config{
+		Endpoint:      "127.0.0.1:4318",
+		SamplingRatio: 2,
+	})
+	assert.Error(t, err)
+	err = Initialize(Config{
+		Endpoint:      "127.0.0.1:4318",
+		SamplingRatio: -1,
+	})
+	assert.Error(t, err)
+	err = Initialize(Config{
+		Endpoint:      "127.0.0.1:4318",
+		Sampler:       "unknown",
+		SamplingRatio: 1,
+	})
+	assert.Error(t, err)
+	assert.False(t, IsEnabled())
+}
+
+func TestTracingSpans(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/custom/traces" {
+			requests.Add(1)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	err := Initialize(Config{
+		Endpoint:      strings.TrimPrefix(server.URL, "http://"),
+		URLPath:       "/custom/traces",
+		Insecure:      true,
+		Sampler:       SamplerParentBased,
+		SamplingRatio: 1,
+	})
+	require.NoError(t, err)
+	assert.True(t, IsEnabled())
+
+	ctx, span := StartConnectionSpan("id", "user", "127.0.0.1", "SSH-2.0-client")
+	assert.True(t, span.IsRecording())
+	childSpan := StartConnectionChildSpan("id_1", "child")
+	assert.False(t, childSpan.IsRecording())
+	AddConnectionContext(ctx, "id_1")
+	childSpan = StartConnectionChildSpan("id_1", "child")
+	assert.True(t, childSpan.IsRecording())
+	assert.Equal(t, span.SpanContext().TraceID(), childSpan.SpanContext().TraceID())
+	EndSpan(childSpan, errors.New("test error"))
+	assert.False(t, childSpan.IsRecording())
+	_, childSpan = StartSpan(ctx, "child")
+	assert.True(t, childSpan.IsRecording())
+	EndSpan(childSpan, nil)
+	RemoveConnectionContext("id_1")
+	childSpan = StartConnectionChildSpan("id_1", "child")
+	assert.False(t, childSpan.IsRecording())
+	span.End()
+	// shutdown flushes the pending spans
+	Shutdown()
+	assert.False(t, IsEnabled())
+	assert.Greater(t, requests.Load(), int32(0))
+
+	err = Initialize(Config{
+		Endpoint:      "127.0.0.1:4318",
+		SamplingRatio: 0,
+	})
+	require.NoError(t, err)
+	_, span = StartConnectionSpan("id", "user", "127.0.0.1", "SSH-2.0-client")
+	assert.False(t, span.IsRecording())
+	span.End()
+	Shutdown()
+}
@@ -15,8 +15,11 @@
 package util
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"net"
+	"strings"
 )
 
 const (
@@ -28,6 +31,23 @@ const (
 // MaxRecursion defines the maximum number of allowed recursions
 const MaxRecursion = 1000
 
+// stable error codes, API consumers can use them to identify errors
+// regardless of the error messages
+const (
+	ErrorCodeHomeDirRequired       = "home_dir_required"
+	ErrorCodeHomeDirInvalid        = "home_dir_invalid"
+	ErrorCodeDeniedProtocolInvalid = "denied_protocol_invalid"
+	ErrorCodeQuotaInvalid          = "quota_invalid"
+	ErrorCodeQuotaExceeded         = "quota_exceeded"
+	ErrorCodeReadQuotaExceeded     = "read_quota_exceeded"
+	ErrorCodePasswordTooShort      = "password_too_short"
+	ErrorCodePasswordNoUppercase   = "password_no_uppercase"
+	ErrorCodePasswordNoLowercase   = "password_no_lowercase"
+	ErrorCodePasswordNoDigit       = "password_no_digit"
+	ErrorCodePasswordNoSpecial     = "password_no_special"
+	ErrorCodePasswordBreached      = "password_breached"
+)
+
 // errors definitions
 var (
 	ErrValidation       = NewValidationError("")
@@ -37,19 +57,56 @@ var (
 	ErrRecursionTooDeep = errors.New("recursion too deep")
 )
 
+// FieldError defines a validation issue for a specific field
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+	Code    string `json:"code,omitempty"`
+}
+
+func (e *FieldError) String() string {
+	if e.Field == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
 // ValidationError raised if input data is not valid
 type ValidationError struct {
-	err string
+	err    string
+	code   string
+	fields []FieldError
 }
 
 // Validation error details
 func (e *ValidationError) Error() string {
-	return fmt.Sprintf("Validation error: %s", e.err)
+	return fmt.Sprintf("Validation error: %s", e.GetErrorString())
 }
 
-// GetErrorString returns the unmodified error string
+// GetErrorString returns the unmodified error string, field issues,
+// if any, are appended
 func (e *ValidationError) GetErrorString() string {
-	return e.err
+	if len(e.fields) == 0 {
+		return e.err
+	}
+	messages := make([]string, 0, len(e.fields)+1)
+	if e.err != "" {
+		messages = append(messages, e.err)
+	}
+	for idx := range e.fields {
+		messages = append(messages, e.fields[idx].String())
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Append adds the specified field issues
+func (e *ValidationError) Append(fields ...FieldError) {
+	e.fields = append(e.fields, fields...)
+}
+
+// GetFields returns the field issues
+func (e *ValidationError) GetFields() []FieldError {
+	return e.fields
 }
 
 // Is reports if target matches
@@ -58,6 +115,11 @@ func (e *ValidationError) Is(target error) bool {
 	return ok
 }
 
+// Code returns the error code, if any
+func (e *ValidationError) Code() string {
+	return e.code
+}
+
 // NewValidationError returns a validation errors
 func NewValidationError(errorString string) *ValidationError {
 	return &ValidationError{
@@ -65,9 +127,30 @@ func NewValidationError(errorString string) *ValidationError {
 	}
 }
 
+// NewFieldValidationError returns a validation error for the specified field
+func NewFieldValidationError(field, message string) *ValidationError {
+	return &ValidationError{
+		fields: []FieldError{
+			{
+				Field:   field,
+				Message: message,
+			},
+		},
+	}
+}
+
+// NewValidationErrorWithCode returns a validation error with the specified error code
+func NewValidationErrorWithCode(errorString, code string) *ValidationError {
+	return &ValidationError{
+		err:  errorString,
+		code: code,
+	}
+}
+
 // RecordNotFoundError raised if a requested object is not found
 type RecordNotFoundError struct {
-	err string
+	err  string
+	code string
 }
 
 func (e *RecordNotFoundError) Error() string {
@@ -80,6 +163,11 @@ func (e *RecordNotFoundError) Is(target error) bool {
 	return ok
 }
 
+// Code returns the error code, if any
+func (e *RecordNotFoundError) Code() string {
+	return e.code
+}
+
 // NewRecordNotFoundError returns a not found error
 func NewRecordNotFoundError(errorString string) *RecordNotFoundError {
 	return &RecordNotFoundError{
@@ -87,11 +175,20 @@ func NewRecordNotFoundError(errorString string) *RecordNotFoundError {
 	}
 }
 
+// NewRecordNotFoundErrorWithCode returns a not found error with the specified error code
+func NewRecordNotFoundErrorWithCode(errorString, code string) *RecordNotFoundError {
+	return &RecordNotFoundError{
+		err:  errorString,
+		code: code,
+	}
+}
+
 // MethodDisabledError raised if a method is disabled in config file.
 // For example, if user management is disabled, this error is raised
 // every time a user operation is done using the REST API
 type MethodDisabledError struct {
-	err string
+	err  string
+	code string
 }
 
 // Method disabled error details
@@ -105,6 +202,11 @@ func (e *MethodDisabledError) Is(target error) bool {
 	return ok
 }
 
+// Code returns the error code, if any
+func (e *MethodDisabledError) Code() string {
+	return e.code
+}
+
 // NewMethodDisabledError returns a method disabled error
 func NewMethodDisabledError(errorString string) *MethodDisabledError {
 	return &MethodDisabledError{
@@ -112,9 +214,18 @@ func NewMethodDisabledError(errorString string) *MethodDisabledError {
 	}
 }
 
+// NewMethodDisabledErrorWithCode returns a method disabled error with the specified error code
+func NewMethodDisabledErrorWithCode(errorString, code string) *MethodDisabledError {
+	return &MethodDisabledError{
+		err:  errorString,
+		code: code,
+	}
+}
+
 // GenericError raised for not well categorized error
 type GenericError struct {
-	err string
+	err  string
+	code string
 }
 
 func (e *GenericError) Error() string {
@@ -127,9 +238,44 @@ func (e *GenericError) Is(target error) bool {
 	return ok
 }
 
+// Code returns the error code, if any
+func (e *GenericError) Code() string {
+	return e.code
+}
+
 // NewGenericError returns a generic error
 func NewGenericError(errorString string) *GenericError {
 	return &GenericError{
 		err: errorString,
 	}
 }
+
+// NewGenericErrorWithCode returns a generic error with the specified error code
+func NewGenericErrorWithCode(errorString, code string) *GenericError {
+	return &GenericError{
+		err:  errorString,
+		code: code,
+	}
+}
+
+// ErrorCode returns the code for the first error, in the specified error chain,
+// that defines one. An empty string is returned if no error code is found
+func ErrorCode(err error) string {
+	for err != nil {
+		if e, ok := err.(interface{ Code() string }); ok && e.Code() != "" {
+			return e.Code()
+		}
+		err = errors.Unwrap(err)
+	}
+	return ""
+}
+
+// IsTimeoutError returns true if the error is caused by an expired context
+// deadline or by a network timeout
+func IsTimeoutError(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
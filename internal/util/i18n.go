@@ -111,6 +111,7 @@ const (
 	I18nErrorProtocolForbidden         = "general.err_protocol_forbidden"
 	I18nErrorPwdLoginForbidden         = "general.pwd_login_forbidden"
 	I18nErrorIPForbidden               = "general.ip_forbidden"
+	I18nErrorLoginBudgetExhausted      = "general.login_budget_exhausted"
 	I18nErrorConnectionForbidden       = "general.connection_forbidden"
 	I18nErrorReservedUsername          = "user.username_reserved"
 	I18nErrorInvalidEmail              = "general.email_invalid"
@@ -176,6 +177,7 @@ const (
 	I18nProfileUpdated                 = "general.profile_updated"
 	I18nShareLoginOK                   = "general.share_ok"
 	I18n2FADisabled                    = "2fa.disabled"
+	I18nError2FATooManyAttempts        = "2fa.too_many_attempts"
 	I18nOIDCTokenExpired               = "oidc.token_expired"
 	I18nOIDCTokenInvalidAdmin          = "oidc.token_invalid_webadmin"
 	I18nOIDCTokenInvalidUser           = "oidc.token_invalid_webclient"
@@ -290,6 +292,8 @@ const (
 	I18nTriggerCertificateRenewEvent   = "rules.triggers.certificate_renewal"
 	I18nTriggerOnDemandEvent           = "rules.triggers.on_demand"
 	I18nTriggerIDPLoginEvent           = "rules.triggers.idp_login"
+	I18nTriggerLoginFailuresEvent      = "rules.triggers.login_failures"
+	I18nTriggerAccountLockoutEvent     = "rules.triggers.account_lockout"
 	I18nTriggerScheduleEvent           = "rules.triggers.schedule"
 	I18nErrorInvalidMinSize            = "rules.invalid_fs_min_size"
 	I18nErrorInvalidMaxSize            = "rules.invalid_fs_max_size"
@@ -362,6 +366,16 @@ func (e *I18nError) Is(target error) bool {
 	return ok
 }
 
+// WithError returns a copy of the error, with the same localization message
+// and arguments, wrapping the specified error
+func (e *I18nError) WithError(err error) *I18nError {
+	return &I18nError{
+		err:     err,
+		Message: e.Message,
+		args:    e.args,
+	}
+}
+
 // HasArgs returns true if the error has i18n args.
 func (e *I18nError) HasArgs() bool {
 	return len(e.args) > 0
@@ -62,6 +62,12 @@ const (
 	pubKeySuffix = ".pub"
 )
 
+// Defaults for the generated RSA and ECDSA keys
+const (
+	DefaultRSAKeySize = 3072
+	DefaultECDSACurve = "P-256"
+)
+
 var (
 	emailRegex = regexp.MustCompile("^(?:(?:(?:(?:[a-zA-Z]|\\d|[!#\\$%&'\\*\\+\\-\\/=\\?\\^_`{\\|}~]|[\\x{00A0}-\\x{D7FF}\\x{F900}-\\x{FDCF}\\x{FDF0}-\\x{FFEF}])+(?:\\.([a-zA-Z]|\\d|[!#\\$%&'\\*\\+\\-\\/=\\?\\^_`{\\|}~]|[\\x{00A0}-\\x{D7FF}\\x{F900}-\\x{FDCF}\\x{FDF0}-\\x{FFEF}])+)*)|(?:(?:\\x22)(?:(?:(?:(?:\\x20|\\x09)*(?:\\x0d\\x0a))?(?:\\x20|\\x09)+)?(?:(?:[\\x01-\\x08\\x0b\\x0c\\x0e-\\x1f\\x7f]|\\x21|[\\x23-\\x5b]|[\\x5d-\\x7e]|[\\x{00A0}-\\x{D7FF}\\x{F900}-\\x{FDCF}\\x{FDF0}-\\x{FFEF}])|(?:(?:[\\x01-\\x09\\x0b\\x0c\\x0d-\\x7f]|[\\x{00A0}-\\x{D7FF}\\x{F900}-\\x{FDCF}\\x{FDF0}-\\x{FFEF}]))))*(?:(?:(?:\\x20|\\x09)*(?:\\x0d\\x0a))?(\\x20|\\x09)+)?(?:\\x22))))@(?:(?:(?:[a-zA-Z]|\\d|[\\x{00A0}-\\x{D7FF}\\x{F900}-\\x{FDCF}\\x{FDF0}-\\x{FFEF}])|(?:(?:[a-zA-Z]|\\d|[\\x{00A0}-\\x{D7FF}\\x{F900}-\\x{FDCF}\\x{FDF0}-\\x{FFEF}])(?:[a-zA-Z]|\\d|-|\\.|~|[\\x{00A0}-\\x{D7FF}\\x{F900}-\\x{FDCF}\\x{FDF0}-\\x{FFEF}])*(?:[a-zA-Z]|\\d|[\\x{00A0}-\\x{D7FF}\\x{F900}-\\x{FDCF}\\x{FDF0}-\\x{FFEF}])))\\.)+(?:(?:[a-zA-Z]|[\\x{00A0}-\\x{D7FF}\\x{F900}-\\x{FDCF}\\x{FDF0}-\\x{FFEF}])|(?:(?:[a-zA-Z]|[\\x{00A0}-\\x{D7FF}\\x{F900}-\\x{FDCF}\\x{FDF0}-\\x{FFEF}])(?:[a-zA-Z]|\\d|-|\\.|~|[\\x{00A0}-\\x{D7FF}\\x{F900}-\\x{FDCF}\\x{FDF0}-\\x{FFEF}])*(?:[a-zA-Z]|[\\x{00A0}-\\x{D7FF}\\x{F900}-\\x{FDCF}\\x{FDF0}-\\x{FFEF}])))\\.?$")
 	// this can be set at build time
@@ -297,10 +303,15 @@ func StringToBytes(s string) []byte {
 // If the given remote address cannot be parsed it will be returned unchanged
 func GetIPFromRemoteAddress(remoteAddress string) string {
 	ip, _, err := net.SplitHostPort(remoteAddress)
-	if err == nil {
-		return ip
+	if err != nil {
+		ip = remoteAddress
+	}
+	// IPv4-mapped IPv6 addresses are returned as IPv4 and IPv6 addresses
+	// in their canonical form, so the same client always has the same IP
+	if parsed := net.ParseIP(ip); parsed != nil {
+		return parsed.String()
 	}
-	return remoteAddress
+	return ip
 }
 
 // GetIPFromNetAddr returns the IP from the network address
@@ -353,14 +364,50 @@ func GetTimeFromPointer(val *time.Time) time.Time {
 	return *val
 }
 
+// GetECDSACurve returns the elliptic curve with the specified name, "P-256",
+// "P-384" or "P-521". An empty name means "P-256"
+func GetECDSACurve(name string) (elliptic.Curve, error) {
+	switch name {
+	case "", DefaultECDSACurve:
+		return elliptic.P256(), nil
+	case "P-384":
+		return elliptic.P384(), nil
+	case "P-521":
+		return elliptic.P521(), nil
+	default:
+		return nil, fmt.Errorf("unsupported ECDSA curve %q", name)
+	}
+}
+
+// ValidateRSAKeySize returns an error if the specified RSA key size is not
+// 2048, 3072 or 4096. 0 means the default size
+func ValidateRSAKeySize(bits int) error {
+	switch bits {
+	case 0, 2048, 3072, 4096:
+		return nil
+	default:
+		return fmt.Errorf("unsupported RSA key size %d", bits)
+	}
+}
+
+func getRSAKeySize(bits int) int {
+	if bits == 0 {
+		return DefaultRSAKeySize
+	}
+	return bits
+}
+
 // GenerateRSAKeys generate rsa private and public keys and write the
 // private key to specified file and the public key to the specified
-// file adding the .pub suffix
-func GenerateRSAKeys(file string) error {
+// file adding the .pub suffix. 0 bits means the default size
+func GenerateRSAKeys(file string, bits int) error {
+	if err := ValidateRSAKeySize(bits); err != nil {
+		return err
+	}
 	if err := createDirPathIfMissing(file, 0700); err != nil {
 		return err
 	}
-	key, err := rsa.GenerateKey(rand.Reader, 3072)
+	key, err := rsa.GenerateKey(rand.Reader, getRSAKeySize(bits))
 	if err != nil {
 		return err
 	}
@@ -389,12 +436,16 @@ func GenerateRSAKeys(file string) error {
 
 // GenerateECDSAKeys generate ecdsa private and public keys and write the
 // private key to specified file and the public key to the specified
-// file adding the .pub suffix
-func GenerateECDSAKeys(file string) error {
+// file adding the .pub suffix. An empty curve means "P-256"
+func GenerateECDSAKeys(file, curve string) error {
+	ellipticCurve, err := GetECDSACurve(curve)
+	if err != nil {
+		return err
+	}
 	if err := createDirPathIfMissing(file, 0700); err != nil {
 		return err
 	}
-	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	key, err := ecdsa.GenerateKey(ellipticCurve, rand.Reader)
 	if err != nil {
 		return err
 	}
@@ -457,6 +508,60 @@ func GenerateEd25519Keys(file string) error {
 	return os.WriteFile(file+pubKeySuffix, ssh.MarshalAuthorizedKey(pub), 0600)
 }
 
+// GeneratePrivateKey generates a private key of the specified type, "rsa",
+// "ecdsa" or "ed25519", and returns it PEM encoded. rsaBits and ecdsaCurve
+// apply to RSA and ECDSA keys, zero values mean the defaults
+func GeneratePrivateKey(keyType string, rsaBits int, ecdsaCurve string) ([]byte, error) {
+	var priv *pem.Block
+	switch keyType {
+	case "rsa":
+		if err := ValidateRSAKeySize(rsaBits); err != nil {
+			return nil, err
+		}
+		key, err := rsa.GenerateKey(rand.Reader, getRSAKeySize(rsaBits))
+		if err != nil {
+			return nil, err
+		}
+		priv = &pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(key),
+		}
+	case "ecdsa":
+		curve, err := GetECDSACurve(ecdsaCurve)
+		if err != nil {
+			return nil, err
+		}
+		key, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		keyBytes, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		priv = &pem.Block{
+			Type:  "EC PRIVATE KEY",
+			Bytes: keyBytes,
+		}
+	case "ed25519":
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		keyBytes, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		priv = &pem.Block{
+			Type:  "PRIVATE KEY",
+			Bytes: keyBytes,
+		}
+	default:
+		return nil, fmt.Errorf("unsupported private key type %q", keyType)
+	}
+	return pem.EncodeToMemory(priv), nil
+}
+
 // IsDirOverlapped returns true if dir1 and dir2 overlap
 func IsDirOverlapped(dir1, dir2 string, fullCheck bool, separator string) bool {
 	if dir1 == dir2 {
@@ -0,0 +1,80 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/rs/xid"
+)
+
+const (
+	// upload mode bit enabling atomic uploads for S3 and Google Cloud Storage
+	uploadModeCloudAtomic = 32
+	// default prefix for the temporary objects of the cloud atomic uploads
+	defaultAtomicUploadPrefix = ".sftpgo-tmp"
+)
+
+// prefix, relative to the filesystem key prefix, for the temporary
+// objects of the cloud atomic uploads, without trailing slash
+var atomicUploadPrefix = defaultAtomicUploadPrefix
+
+// SetAtomicUploadPrefix validates and sets the prefix, relative to the
+// filesystem key prefix, for the temporary objects used for atomic uploads
+// to S3 and Google Cloud Storage. Empty means the default
+func SetAtomicUploadPrefix(prefix string) error {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		atomicUploadPrefix = defaultAtomicUploadPrefix
+		return nil
+	}
+	if prefix == "." || prefix == ".." || strings.Contains(prefix, "/") {
+		return fmt.Errorf("invalid atomic upload prefix %q, it must be a single path element", prefix)
+	}
+	atomicUploadPrefix = prefix
+	return nil
+}
+
+// HasCloudAtomicUploads returns true if the uploads to the specified fs are
+// written to a temporary object and moved to the requested path, using a
+// server-side copy, when completed
+func HasCloudAtomicUploads(fs Fs) bool {
+	if uploadMode&uploadModeCloudAtomic == 0 {
+		return false
+	}
+	return strings.HasPrefix(fs.Name(), s3fsName) || strings.HasPrefix(fs.Name(), gcsfsName)
+}
+
+// getCloudAtomicUploadPath returns a temporary object name, inside the atomic
+// upload prefix, for the specified object. The base name is preserved so the
+// content type and the upload rules are resolved as for the requested path
+func getCloudAtomicUploadPath(keyPrefix, name string) string {
+	guid := xid.New().String()
+	return path.Join(keyPrefix, atomicUploadPrefix, guid+"."+path.Base(name))
+}
+
+// isCloudAtomicUploadKey returns true if the specified object name is the
+// atomic upload prefix or is inside it. The prefix is reserved only if the
+// cloud atomic uploads are enabled
+func isCloudAtomicUploadKey(keyPrefix, name string) bool {
+	if uploadMode&uploadModeCloudAtomic == 0 {
+		return false
+	}
+	dir := path.Join(keyPrefix, atomicUploadPrefix)
+	name = strings.TrimSuffix(name, "/")
+	return name == dir || strings.HasPrefix(name, dir+"/")
+}
@@ -0,0 +1,305 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// Metadata keys for the attributes recorded by the folders using the record
+// setstat mode. Azure Blob metadata keys must be valid C# identifiers, so
+// underscores are used. The modification time is recorded using the same key
+// used by the backends supporting chtimes
+const (
+	modeMetadataKey = "sftpgo_mode"
+	uidMetadataKey  = "sftpgo_uid"
+	gidMetadataKey  = "sftpgo_gid"
+)
+
+// metadataGetter is implemented by the filesystems able to return the
+// metadata stored for a file
+type metadataGetter interface {
+	getObjectMetadata(name string) (map[string]string, error)
+}
+
+// attrsFs wraps a cloud storage Fs and records the attributes that the backend
+// cannot represent natively, mode, owner and modification time, as object
+// metadata. The recorded attributes are returned by stat and, for the backends
+// returning the metadata while listing, in directory listings
+type attrsFs struct {
+	Fs
+	setter MetadataSetter
+	getter metadataGetter
+}
+
+// attrsFileCopierFs is an attrsFs for the filesystems implementing FsFileCopier
+type attrsFileCopierFs struct {
+	attrsFs
+}
+
+func (fs *attrsFileCopierFs) CopyFile(source, target string, srcInfo os.FileInfo) (int, int64, error) {
+	return fs.Fs.(FsFileCopier).CopyFile(source, target, srcInfo)
+}
+
+// newAttrsFs returns a Fs recording the attributes, not supported by the
+// specified Fs, as object metadata
+func newAttrsFs(fs Fs) (Fs, error) {
+	setter, ok := fs.(MetadataSetter)
+	if !ok {
+		return nil, fmt.Errorf("filesystem %q does not support metadata", fs.Name())
+	}
+	getter, ok := fs.(metadataGetter)
+	if !ok {
+		return nil, fmt.Errorf("filesystem %q does not support metadata", fs.Name())
+	}
+	base := attrsFs{
+		Fs:     fs,
+		setter: setter,
+		getter: getter,
+	}
+	if _, ok := fs.(FsFileCopier); ok {
+		return &attrsFileCopierFs{base}, nil
+	}
+	return &base, nil
+}
+
+// SetMetadata implements the MetadataSetter interface
+func (fs *attrsFs) SetMetadata(name string, metadata map[string]string) error {
+	return fs.setter.SetMetadata(name, metadata)
+}
+
+// Chmod records the permissions as object metadata
+func (fs *attrsFs) Chmod(name string, mode os.FileMode) error {
+	return fs.recordAttributes(name, map[string]string{
+		modeMetadataKey: strconv.FormatUint(uint64(mode.Perm()), 8),
+	})
+}
+
+// Chown records the owner and the group as object metadata
+func (fs *attrsFs) Chown(name string, uid int, gid int) error {
+	attrs := make(map[string]string)
+	if uid >= 0 {
+		attrs[uidMetadataKey] = strconv.Itoa(uid)
+	}
+	if gid >= 0 {
+		attrs[gidMetadataKey] = strconv.Itoa(gid)
+	}
+	if len(attrs) == 0 {
+		return nil
+	}
+	return fs.recordAttributes(name, attrs)
+}
+
+// Chtimes changes the modification time using the wrapped Fs, if supported,
+// otherwise the modification time is recorded as object metadata
+func (fs *attrsFs) Chtimes(name string, atime, mtime time.Time, isUploading bool) error {
+	err := fs.Fs.Chtimes(name, atime, mtime, isUploading)
+	if !errors.Is(err, ErrVfsUnsupported) {
+		return err
+	}
+	if isUploading {
+		// the object does not exist yet, the times are set again when the upload completes
+		return nil
+	}
+	return fs.recordAttributes(name, map[string]string{
+		lastModifiedField: strconv.FormatInt(mtime.UnixMilli(), 10),
+	})
+}
+
+func (fs *attrsFs) recordAttributes(name string, attrs map[string]string) error {
+	info, err := fs.Fs.Stat(name)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		// directories are prefixes or empty marker objects, the attributes are accepted but not recorded
+		fsLog(fs, logger.LevelDebug, "attributes for directory %q not recorded: %+v", name, attrs)
+		return nil
+	}
+	err = fs.setter.SetMetadata(name, attrs)
+	if errors.Is(err, ErrVfsUnsupported) {
+		fsLog(fs, logger.LevelWarn, "unable to record attributes %+v for %q: %v", attrs, name, err)
+		return nil
+	}
+	fsLog(fs, logger.LevelDebug, "attributes %+v recorded for %q, err: %v", attrs, name, err)
+	return err
+}
+
+// Stat returns a FileInfo describing the named file, including the recorded attributes
+func (fs *attrsFs) Stat(name string) (os.FileInfo, error) {
+	info, err := fs.Fs.Stat(name)
+	if err != nil {
+		return info, err
+	}
+	return fs.addRecordedAttributes(name, info), nil
+}
+
+// Lstat returns a FileInfo describing the named file, including the recorded attributes
+func (fs *attrsFs) Lstat(name string) (os.FileInfo, error) {
+	info, err := fs.Fs.Lstat(name)
+	if err != nil {
+		return info, err
+	}
+	return fs.addRecordedAttributes(name, info), nil
+}
+
+// ReadDir reads the directory named by dirname and returns a list of directory
+// entries including the recorded attributes, if returned by the backend
+func (fs *attrsFs) ReadDir(dirname string) (DirLister, error) {
+	lister, err := fs.Fs.ReadDir(dirname)
+	if err != nil {
+		return lister, err
+	}
+	return &attrsDirLister{DirLister: lister}, nil
+}
+
+func (fs *attrsFs) addRecordedAttributes(name string, info os.FileInfo) os.FileInfo {
+	if info.IsDir() {
+		return info
+	}
+	metadata, ok := getFileInfoMetadata(info)
+	if !ok {
+		// the metadata are not available, for example for cached listings
+		md, err := fs.getter.getObjectMetadata(name)
+		if err != nil {
+			fsLog(fs, logger.LevelDebug, "unable to get metadata for %q: %v", name, err)
+			return info
+		}
+		metadata = md
+	}
+	return getFileInfoWithAttributes(info, metadata)
+}
+
+type attrsDirLister struct {
+	DirLister
+}
+
+func (l *attrsDirLister) Next(limit int) ([]os.FileInfo, error) {
+	files, err := l.DirLister.Next(limit)
+	for idx, info := range files {
+		if info.IsDir() {
+			continue
+		}
+		if metadata, ok := getFileInfoMetadata(info); ok {
+			files[idx] = getFileInfoWithAttributes(info, metadata)
+		}
+	}
+	return files, err
+}
+
+// fileInfoWithOwner is a FileInfo with recorded owner and group,
+// it implements the sftp.FileInfoUidGid interface
+type fileInfoWithOwner struct {
+	os.FileInfo
+	uid uint32
+	gid uint32
+}
+
+// Uid returns the recorded owner
+func (fi *fileInfoWithOwner) Uid() uint32 { //nolint:revive
+	return fi.uid
+}
+
+// Gid returns the recorded group
+func (fi *fileInfoWithOwner) Gid() uint32 {
+	return fi.gid
+}
+
+// getFileInfoMetadata returns the metadata for the specified FileInfo and
+// false if they are not available, an empty map is returned for files
+// without metadata
+func getFileInfoMetadata(info os.FileInfo) (map[string]string, bool) {
+	if fi, ok := info.(*FileInfo); ok && fi.metadata != nil {
+		return fi.metadata, true
+	}
+	return nil, false
+}
+
+func getMetadataValue(metadata map[string]string, key string) string {
+	for k, v := range metadata {
+		// Azure Blob metadata keys are case insensitive
+		if strings.EqualFold(k, key) {
+			return v
+		}
+	}
+	return ""
+}
+
+// getFileInfoWithAttributes returns a FileInfo with the attributes recorded
+// in the specified metadata, if any
+func getFileInfoWithAttributes(info os.FileInfo, metadata map[string]string) os.FileInfo {
+	if len(metadata) == 0 {
+		return info
+	}
+	var result *FileInfo
+	if fi, ok := info.(*FileInfo); ok {
+		c := *fi
+		result = &c
+	} else {
+		result = NewFileInfo(info.Name(), info.IsDir(), info.Size(), info.ModTime(), true)
+		result.mode = info.Mode()
+	}
+	if val := getMetadataValue(metadata, modeMetadataKey); val != "" {
+		if perm, err := strconv.ParseUint(val, 8, 32); err == nil {
+			result.mode = (result.mode &^ os.ModePerm) | (os.FileMode(perm) & os.ModePerm)
+		}
+	}
+	if val := getMetadataValue(metadata, lastModifiedField); val != "" {
+		if mtime, err := strconv.ParseInt(val, 10, 64); err == nil && mtime > 0 {
+			result.modTime = util.GetTimeFromMsecSinceEpoch(mtime)
+		}
+	}
+	uid, errUID := strconv.ParseUint(getMetadataValue(metadata, uidMetadataKey), 10, 32)
+	gid, errGID := strconv.ParseUint(getMetadataValue(metadata, gidMetadataKey), 10, 32)
+	if errUID != nil && errGID != nil {
+		return result
+	}
+	return &fileInfoWithOwner{
+		FileInfo: result,
+		uid:      uint32(uid),
+		gid:      uint32(gid),
+	}
+}
+
+// IsAttrsFs returns true if the specified Fs records the attributes not
+// supported by the storage backend as object metadata
+func IsAttrsFs(fs Fs) bool {
+	switch f := fs.(type) {
+	case *attrsFs, *attrsFileCopierFs:
+		return true
+	case *tracedFs:
+		return IsAttrsFs(f.Fs)
+	case *tracedRealPatherFs:
+		return IsAttrsFs(f.Fs)
+	case *tracedFileCopierFs:
+		return IsAttrsFs(f.Fs)
+	case *pgpFs:
+		return IsAttrsFs(f.Fs)
+	case *pgpRealPatherFs:
+		return IsAttrsFs(f.Fs)
+	case *pgpFileCopierFs:
+		return IsAttrsFs(f.Fs)
+	default:
+		return false
+	}
+}
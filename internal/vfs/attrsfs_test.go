@@ -0,0 +1,164 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/sftpgo/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// metadataTestFs simulates a cloud backend storing metadata but not
+// supporting chtimes natively
+type metadataTestFs struct {
+	Fs
+	metadata map[string]map[string]string
+}
+
+func (fs *metadataTestFs) Chtimes(_ string, _, _ time.Time, _ bool) error {
+	return ErrVfsUnsupported
+}
+
+func (fs *metadataTestFs) SetMetadata(name string, metadata map[string]string) error {
+	md := fs.metadata[name]
+	if md == nil {
+		md = make(map[string]string)
+	}
+	for k, v := range metadata {
+		md[k] = v
+	}
+	fs.metadata[name] = md
+	return nil
+}
+
+func (fs *metadataTestFs) getObjectMetadata(name string) (map[string]string, error) {
+	return fs.metadata[name], nil
+}
+
+func TestAttrsFs(t *testing.T) {
+	rootDir := filepath.Join(os.TempDir(), "attrsfs_root")
+	err := os.MkdirAll(filepath.Join(rootDir, "dir"), os.ModePerm)
+	require.NoError(t, err)
+	defer os.RemoveAll(rootDir)
+
+	osFs := NewOsFs("conn", rootDir, "", nil)
+	_, err = newAttrsFs(osFs)
+	assert.Error(t, err)
+
+	baseFs := &metadataTestFs{
+		Fs:       osFs,
+		metadata: make(map[string]map[string]string),
+	}
+	fs, err := newAttrsFs(baseFs)
+	require.NoError(t, err)
+	assert.True(t, IsAttrsFs(fs))
+	assert.True(t, IsAttrsFs(&tracedFs{Fs: fs}))
+	assert.False(t, IsAttrsFs(osFs))
+	assert.Equal(t, baseFs, UnwrapFs(fs))
+
+	filePath := filepath.Join(rootDir, "file.txt")
+	err = os.WriteFile(filePath, []byte("content"), 0600)
+	require.NoError(t, err)
+	mtime := time.Now().Add(-48 * time.Hour).Truncate(time.Millisecond)
+	require.NoError(t, fs.Chmod(filePath, 0640))
+	require.NoError(t, fs.Chown(filePath, 1000, 1001))
+	require.NoError(t, fs.Chown(filePath, -1, -1))
+	require.NoError(t, fs.Chtimes(filePath, mtime, mtime, true))
+	assert.Len(t, baseFs.metadata[filePath], 3)
+	require.NoError(t, fs.Chtimes(filePath, mtime, mtime, false))
+	assert.Len(t, baseFs.metadata[filePath], 4)
+	// directories are accepted but not recorded
+	dirPath := filepath.Join(rootDir, "dir")
+	require.NoError(t, fs.Chmod(dirPath, 0700))
+	assert.Empty(t, baseFs.metadata[dirPath])
+	assert.ErrorIs(t, fs.Chmod(filepath.Join(rootDir, "missing"), 0600), os.ErrNotExist)
+
+	info, err := fs.Stat(filePath)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+	assert.Equal(t, mtime.UnixMilli(), info.ModTime().UnixMilli())
+	ownerInfo, ok := info.(sftp.FileInfoUidGid)
+	if assert.True(t, ok) {
+		assert.Equal(t, uint32(1000), ownerInfo.Uid())
+		assert.Equal(t, uint32(1001), ownerInfo.Gid())
+	}
+	info, err = fs.Lstat(filePath)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+	info, err = fs.Stat(dirPath)
+	require.NoError(t, err)
+	assert.True(t, info.IsDir())
+}
+
+func TestAttrsDirLister(t *testing.T) {
+	withMetadata := NewFileInfo("a", false, 10, time.Now(), false)
+	withMetadata.setMetadata(map[string]string{
+		"SFTPGO_MODE":  "600",
+		uidMetadataKey: "10",
+	})
+	withoutMetadata := NewFileInfo("b", false, 10, time.Now(), false)
+	dir := NewFileInfo("c", true, 0, time.Now(), false)
+	lister := &attrsDirLister{
+		DirLister: &baseDirLister{cache: []os.FileInfo{withMetadata, withoutMetadata, dir}},
+	}
+	files, err := lister.Next(ListerBatchSize)
+	assert.ErrorIs(t, err, io.EOF)
+	require.Len(t, files, 3)
+	assert.Equal(t, os.FileMode(0600), files[0].Mode().Perm())
+	ownerInfo, ok := files[0].(sftp.FileInfoUidGid)
+	if assert.True(t, ok) {
+		assert.Equal(t, uint32(10), ownerInfo.Uid())
+		assert.Equal(t, uint32(0), ownerInfo.Gid())
+	}
+	assert.Equal(t, withoutMetadata, files[1])
+	assert.Equal(t, dir, files[2])
+	require.NoError(t, lister.Close())
+	// invalid values are ignored
+	info := getFileInfoWithAttributes(withoutMetadata, map[string]string{
+		modeMetadataKey:   "invalid",
+		lastModifiedField: "-1",
+	})
+	assert.Equal(t, withoutMetadata.Mode(), info.Mode())
+	assert.Equal(t, withoutMetadata.ModTime(), info.ModTime())
+}
+
+func TestValidateSetstatMode(t *testing.T) {
+	folder := BaseVirtualFolder{
+		Name:       "folder",
+		MappedPath: filepath.Join(os.TempDir(), "folder"),
+		FsConfig: Filesystem{
+			Provider: sdk.LocalFilesystemProvider,
+		},
+	}
+	require.NoError(t, folder.ValidateSetstatMode())
+	folder.SetstatMode = FolderSetstatModeRecord
+	assert.Error(t, folder.ValidateSetstatMode())
+	folder.SetstatMode = 2
+	assert.Error(t, folder.ValidateSetstatMode())
+	for _, provider := range []sdk.FilesystemProvider{sdk.S3FilesystemProvider, sdk.GCSFilesystemProvider,
+		sdk.AzureBlobFilesystemProvider} {
+		folder.FsConfig.Provider = provider
+		folder.SetstatMode = FolderSetstatModeRecord
+		assert.NoError(t, folder.ValidateSetstatMode())
+		assert.Equal(t, FolderSetstatModeRecord, folder.GetACopy().SetstatMode)
+	}
+}
@@ -23,7 +23,6 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"mime"
 	"net/http"
 	"os"
 	"path"
@@ -40,6 +39,7 @@ import (
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
 	"github.com/google/uuid"
@@ -56,10 +56,6 @@ const (
 	azFolderKey          = "hdi_isfolder"
 )
 
-var (
-	azureBlobDefaultPageSize = int32(5000)
-)
-
 // AzureBlobFs is a Fs implementation for Azure Blob storage.
 type AzureBlobFs struct {
 	connectionID string
@@ -70,6 +66,8 @@ type AzureBlobFs struct {
 	containerClient *container.Client
 	ctxTimeout      time.Duration
 	ctxLongTimeout  time.Duration
+	listingLimit
+	cancelSignal *cancelSignal
 }
 
 func init() {
@@ -88,6 +86,7 @@ func NewAzBlobFs(connectionID, localTempDir, mountPath string, config AzBlobFsCo
 		config:         &config,
 		ctxTimeout:     30 * time.Second,
 		ctxLongTimeout: 90 * time.Second,
+		cancelSignal:   newCancelSignal(),
 	}
 	if err := fs.config.validate(); err != nil {
 		return fs, err
@@ -115,18 +114,26 @@ func NewAzBlobFs(connectionID, localTempDir, mountPath string, config AzBlobFsCo
 		if err != nil {
 			return fs, fmt.Errorf("invalid credentials: %v", err)
 		}
-		svc, err := container.NewClientWithSharedKeyCredential(containerURL, credential, getAzContainerClientOptions())
+		svc, err := container.NewClientWithSharedKeyCredential(containerURL, credential, getAzContainerClientOptions(fs.config.OutboundBindAddress))
 		if err != nil {
 			return fs, fmt.Errorf("unable to create the storage client using shared key credentials: %v", err)
 		}
 		fs.containerClient = svc
 		return fs, err
 	}
-	credential, err := azidentity.NewDefaultAzureCredential(nil)
+	var credentialOptions *azidentity.DefaultAzureCredentialOptions
+	if transport := getAzTransport(fs.config.OutboundBindAddress); transport != nil {
+		credentialOptions = &azidentity.DefaultAzureCredentialOptions{
+			ClientOptions: azcore.ClientOptions{
+				Transport: transport,
+			},
+		}
+	}
+	credential, err := azidentity.NewDefaultAzureCredential(credentialOptions)
 	if err != nil {
 		return fs, fmt.Errorf("invalid default azure credentials: %v", err)
 	}
-	svc, err := container.NewClient(containerURL, credential, getAzContainerClientOptions())
+	svc, err := container.NewClient(containerURL, credential, getAzContainerClientOptions(fs.config.OutboundBindAddress))
 	if err != nil {
 		return fs, fmt.Errorf("unable to create the storage client using azure credentials: %v", err)
 	}
@@ -147,7 +154,7 @@ func (fs *AzureBlobFs) initFromSASURL() (Fs, error) {
 			return fs, fmt.Errorf("container name in SAS URL %q and container provided %q do not match",
 				parts.ContainerName, fs.config.Container)
 		}
-		svc, err := container.NewClientWithNoCredential(fs.config.SASURL.GetPayload(), getAzContainerClientOptions())
+		svc, err := container.NewClientWithNoCredential(fs.config.SASURL.GetPayload(), getAzContainerClientOptions(fs.config.OutboundBindAddress))
 		if err != nil {
 			return fs, fmt.Errorf("invalid credentials: %v", err)
 		}
@@ -159,7 +166,7 @@ func (fs *AzureBlobFs) initFromSASURL() (Fs, error) {
 		return fs, errors.New("container is required with this SAS URL")
 	}
 	sasURL := runtime.JoinPaths(fs.config.SASURL.GetPayload(), fs.config.Container)
-	svc, err := container.NewClientWithNoCredential(sasURL, getAzContainerClientOptions())
+	svc, err := container.NewClientWithNoCredential(sasURL, getAzContainerClientOptions(fs.config.OutboundBindAddress))
 	if err != nil {
 		return fs, fmt.Errorf("invalid credentials: %v", err)
 	}
@@ -200,6 +207,9 @@ func (fs *AzureBlobFs) Stat(name string) (os.FileInfo, error) {
 		info := NewFileInfo(name, isDir, util.GetIntFromPointer(attrs.ContentLength), lastModified, false)
 		if !isDir {
 			info.setMetadataFromPointerVal(attrs.Metadata)
+			if attrs.CreationTime != nil {
+				info.setUploadTime(*attrs.CreationTime)
+			}
 		}
 		return info, nil
 	}
@@ -266,27 +276,35 @@ func (fs *AzureBlobFs) Create(name string, flag, checks int) (File, PipeWriter,
 	}
 	headers := blob.HTTPHeaders{}
 	var contentType string
+	var sniff bool
 	var metadata map[string]*string
+	accessTier := fs.config.AccessTier
 	if flag == -1 {
 		contentType = dirMimeType
 		metadata = map[string]*string{
 			azFolderKey: util.NilIfEmpty("true"),
 		}
 	} else {
-		contentType = mime.TypeByExtension(path.Ext(name))
-	}
-	if contentType != "" {
-		headers.BlobContentType = &contentType
+		contentType, sniff = getUploadContentType(name, fs.config.ContentTypes)
+		accessTier = fs.getAccessTier(name)
 	}
 
 	go func() {
 		defer cancelFn()
 
+		var reader io.Reader = r
+		if sniff {
+			contentType, reader = sniffContentType(r)
+		}
+		if contentType != "" {
+			headers.BlobContentType = &contentType
+		}
 		blockBlob := fs.containerClient.NewBlockBlobClient(name)
-		err := fs.handleMultipartUpload(ctx, r, blockBlob, &headers, metadata)
+		err := fs.handleMultipartUpload(ctx, reader, blockBlob, &headers, metadata, accessTier)
 		r.CloseWithError(err) //nolint:errcheck
 		p.Done(err)
-		fsLog(fs, logger.LevelDebug, "upload completed, path: %q, readed bytes: %v, err: %+v", name, r.GetReadedBytes(), err)
+		fsLog(fs, logger.LevelDebug, "upload completed, path: %q, content type: %q, access tier: %q, readed bytes: %v, "+
+			"err: %+v", name, contentType, accessTier, r.GetReadedBytes(), err)
 		metric.AZTransferCompleted(r.GetReadedBytes(), 0, err)
 	}()
 
@@ -369,6 +387,12 @@ func (fs *AzureBlobFs) Remove(name string, isDir bool) error {
 	return err
 }
 
+// RemoveFiles implements the FsBatchRemover interface, the files are removed
+// in parallel
+func (fs *AzureBlobFs) RemoveFiles(names []string) ([]string, error) {
+	return removeFilesParallel(fs.cancelSignal.context(), fs, names)
+}
+
 // Mkdir creates a new directory with the specified name and default permissions
 func (fs *AzureBlobFs) Mkdir(name string) error {
 	_, err := fs.Stat(name)
@@ -430,6 +454,45 @@ func (fs *AzureBlobFs) Chtimes(name string, _, mtime time.Time, isUploading bool
 	return err
 }
 
+func (fs *AzureBlobFs) getObjectMetadata(name string) (map[string]string, error) {
+	props, err := fs.headObject(name)
+	if err != nil {
+		return nil, err
+	}
+	metadata := make(map[string]string, len(props.Metadata))
+	for k, v := range props.Metadata {
+		metadata[k] = util.GetStringFromPointer(v)
+	}
+	return metadata, nil
+}
+
+// SetMetadata adds the specified metadata to the named file
+func (fs *AzureBlobFs) SetMetadata(name string, metadata map[string]string) error {
+	props, err := fs.headObject(name)
+	if err != nil {
+		return err
+	}
+	objMetadata := props.Metadata
+	if objMetadata == nil {
+		objMetadata = make(map[string]*string)
+	}
+	for key, value := range metadata {
+		// Azure metadata keys are case insensitive
+		for k := range objMetadata {
+			if strings.EqualFold(k, key) {
+				delete(objMetadata, k)
+			}
+		}
+		objMetadata[key] = to.Ptr(value)
+	}
+
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	_, err = fs.containerClient.NewBlockBlobClient(name).SetMetadata(ctx, objMetadata, &blob.SetMetadataOptions{})
+	return err
+}
+
 // Truncate changes the size of the named file.
 // Truncate by path is not supported, while truncating an opened
 // file is handled inside base transfer
@@ -447,15 +510,16 @@ func (fs *AzureBlobFs) ReadDir(dirname string) (DirLister, error) {
 			Metadata: true,
 		},
 		Prefix:     &prefix,
-		MaxResults: &azureBlobDefaultPageSize,
+		MaxResults: to.Ptr(getListingPageSize()),
 	})
 
-	return &azureBlobDirLister{
+	return fs.limitDirLister(fs, dirname, &azureBlobDirLister{
 		paginator: pager,
+		ctx:       fs.cancelSignal.context(),
 		timeout:   fs.ctxTimeout,
 		prefix:    prefix,
 		prefixes:  make(map[string]bool),
-	}, nil
+	}), nil
 }
 
 // IsUploadResumeSupported returns true if resuming uploads is supported.
@@ -497,6 +561,9 @@ func (*AzureBlobFs) IsPermission(err error) bool {
 	if err == nil {
 		return false
 	}
+	if errors.Is(err, os.ErrPermission) {
+		return true
+	}
 	var respErr *azcore.ResponseError
 	if errors.As(err, &respErr) {
 		return respErr.StatusCode == http.StatusForbidden || respErr.StatusCode == http.StatusUnauthorized
@@ -548,11 +615,11 @@ func (fs *AzureBlobFs) GetDirSize(dirname string) (int, int64, error) {
 			Metadata: true,
 		},
 		Prefix:     &prefix,
-		MaxResults: &azureBlobDefaultPageSize,
+		MaxResults: to.Ptr(getListingPageSize()),
 	})
 
 	for pager.More() {
-		ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+		ctx, cancelFn := context.WithDeadline(fs.cancelSignal.context(), time.Now().Add(fs.ctxTimeout))
 		defer cancelFn()
 
 		resp, err := pager.NextPage(ctx)
@@ -616,11 +683,11 @@ func (fs *AzureBlobFs) Walk(root string, walkFn filepath.WalkFunc) error {
 			Metadata: true,
 		},
 		Prefix:     &prefix,
-		MaxResults: &azureBlobDefaultPageSize,
+		MaxResults: to.Ptr(getListingPageSize()),
 	})
 
 	for pager.More() {
-		ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+		ctx, cancelFn := context.WithDeadline(fs.cancelSignal.context(), time.Now().Add(fs.ctxTimeout))
 		defer cancelFn()
 
 		resp, err := pager.NextPage(ctx)
@@ -715,8 +782,15 @@ func (fs *AzureBlobFs) GetMimeType(name string) (string, error) {
 	return util.GetStringFromPointer(response.ContentType), nil
 }
 
-// Close closes the fs
-func (*AzureBlobFs) Close() error {
+// CancelOperations implements the FsOperationsCanceler interface
+func (fs *AzureBlobFs) CancelOperations() {
+	fs.cancelSignal.cancel()
+}
+
+// Close closes the fs, the running listings, copies and recursive deletes
+// are canceled
+func (fs *AzureBlobFs) Close() error {
+	fs.cancelSignal.cancel()
 	return nil
 }
 
@@ -774,13 +848,19 @@ func (fs *AzureBlobFs) setConfigDefaults() {
 }
 
 func (fs *AzureBlobFs) copyFileInternal(source, target string, srcInfo os.FileInfo, updateModTime bool) error {
-	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxLongTimeout))
+	ctx, cancelFn := context.WithDeadline(fs.cancelSignal.context(), time.Now().Add(fs.ctxLongTimeout))
 	defer cancelFn()
 
 	srcBlob := fs.containerClient.NewBlockBlobClient(source)
 	dstBlob := fs.containerClient.NewBlockBlobClient(target)
-	resp, err := dstBlob.StartCopyFromURL(ctx, srcBlob.URL(), fs.getCopyOptions(srcInfo, updateModTime))
+	accessTier, err := fs.getCopyAccessTier(ctx, srcBlob, target)
+	if err != nil {
+		metric.AZCopyObjectCompleted(err)
+		return err
+	}
+	resp, err := dstBlob.StartCopyFromURL(ctx, srcBlob.URL(), fs.getCopyOptions(srcInfo, updateModTime, accessTier))
 	if err != nil {
+		err = checkAccessTierError(err, accessTier)
 		metric.AZCopyObjectCompleted(err)
 		return err
 	}
@@ -832,7 +912,8 @@ func (fs *AzureBlobFs) renameInternal(source, target string, srcInfo os.FileInfo
 			return numFiles, filesSize, err
 		}
 		if renameMode == 1 {
-			files, size, err := doRecursiveRename(fs, source, target, fs.renameInternal, recursion, updateModTime)
+			files, size, err := doRecursiveRename(fs.cancelSignal.context(), fs, source, target, fs.renameInternal,
+				fs.copyFileInternal, recursion, updateModTime)
 			numFiles += files
 			filesSize += size
 			if err != nil {
@@ -876,7 +957,7 @@ func (fs *AzureBlobFs) hasContents(name string) (bool, error) {
 	})
 
 	if pager.More() {
-		ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+		ctx, cancelFn := context.WithDeadline(fs.cancelSignal.context(), time.Now().Add(fs.ctxTimeout))
 		defer cancelFn()
 
 		resp, err := pager.NextPage(ctx)
@@ -1005,7 +1086,7 @@ func (fs *AzureBlobFs) handleMultipartDownload(ctx context.Context, blockBlob *b
 }
 
 func (fs *AzureBlobFs) handleMultipartUpload(ctx context.Context, reader io.Reader,
-	blockBlob *blockblob.Client, httpHeaders *blob.HTTPHeaders, metadata map[string]*string,
+	blockBlob *blockblob.Client, httpHeaders *blob.HTTPHeaders, metadata map[string]*string, accessTier string,
 ) error {
 	partSize := fs.config.UploadPartSize
 	guard := make(chan struct{}, fs.config.UploadConcurrency)
@@ -1097,12 +1178,12 @@ func (fs *AzureBlobFs) handleMultipartUpload(ctx context.Context, reader io.Read
 		HTTPHeaders: httpHeaders,
 		Metadata:    metadata,
 	}
-	if fs.config.AccessTier != "" {
-		commitOptions.Tier = (*blob.AccessTier)(&fs.config.AccessTier)
+	if accessTier != "" {
+		commitOptions.Tier = (*blob.AccessTier)(&accessTier)
 	}
 
 	_, err := blockBlob.CommitBlockList(ctx, blocks, &commitOptions)
-	return err
+	return checkAccessTierError(err, accessTier)
 }
 
 func (*AzureBlobFs) writeAtFull(w io.WriterAt, buf []byte, offset int64, count int) (int, error) {
@@ -1127,10 +1208,26 @@ func (*AzureBlobFs) readFill(r io.Reader, buf []byte) (n int, err error) {
 	return n, err
 }
 
-func (fs *AzureBlobFs) getCopyOptions(srcInfo os.FileInfo, updateModTime bool) *blob.StartCopyFromURLOptions {
+// getCopyAccessTier returns the access tier for the copy of the source blob.
+// The tier explicitly set for the source blob is preserved, otherwise the
+// tier configured for the target name, if any, is used
+func (fs *AzureBlobFs) getCopyAccessTier(ctx context.Context, srcBlob *blockblob.Client, target string) (string, error) {
+	resp, err := srcBlob.GetProperties(ctx, &blob.GetPropertiesOptions{})
+	if err != nil {
+		return "", err
+	}
+	isInferred := resp.AccessTierInferred != nil && *resp.AccessTierInferred
+	if accessTier := util.GetStringFromPointer(resp.AccessTier); accessTier != "" && !isInferred {
+		return accessTier, nil
+	}
+	return fs.getAccessTier(target), nil
+}
+
+func (fs *AzureBlobFs) getCopyOptions(srcInfo os.FileInfo, updateModTime bool, accessTier string,
+) *blob.StartCopyFromURLOptions {
 	copyOptions := &blob.StartCopyFromURLOptions{}
-	if fs.config.AccessTier != "" {
-		copyOptions.Tier = (*blob.AccessTier)(&fs.config.AccessTier)
+	if accessTier != "" {
+		copyOptions.Tier = (*blob.AccessTier)(&accessTier)
 	}
 	if updateModTime {
 		metadata := make(map[string]*string)
@@ -1151,6 +1248,26 @@ func (fs *AzureBlobFs) getCopyOptions(srcInfo os.FileInfo, updateModTime bool) *
 	return copyOptions
 }
 
+// getAccessTier returns the access tier for the named blob, the first
+// matching override wins. An empty string means the account default tier
+func (fs *AzureBlobFs) getAccessTier(name string) string {
+	for _, o := range fs.config.AccessTiers {
+		if matchesObjectKey(o.Pattern, name) {
+			return o.AccessTier
+		}
+	}
+	return fs.config.AccessTier
+}
+
+// checkAccessTierError returns a more descriptive error if the storage
+// account does not support the requested access tier
+func checkAccessTierError(err error, accessTier string) error {
+	if err != nil && accessTier != "" && bloberror.HasCode(err, bloberror.InvalidBlobTier) {
+		return fmt.Errorf("access tier %q is not supported for this storage account: %w", accessTier, err)
+	}
+	return err
+}
+
 func (fs *AzureBlobFs) downloadToWriter(name string, w PipeWriter) (int64, error) {
 	fsLog(fs, logger.LevelDebug, "starting download before resuming upload, path %q", name)
 	ctx, cancelFn := context.WithTimeout(context.Background(), preResumeTimeout)
@@ -1177,16 +1294,29 @@ func checkDirectoryMarkers(contentType string, metadata map[string]*string) bool
 	return false
 }
 
-func getAzContainerClientOptions() *container.ClientOptions {
+func getAzContainerClientOptions(bindAddress string) *container.ClientOptions {
 	return &container.ClientOptions{
 		ClientOptions: azcore.ClientOptions{
 			Telemetry: policy.TelemetryOptions{
 				ApplicationID: version.GetVersionHash(),
 			},
+			Transport: getAzTransport(bindAddress),
 		},
 	}
 }
 
+// getAzTransport returns an HTTP client bound to the configured local
+// address. Nil means the SDK default
+func getAzTransport(bindAddress string) policy.Transporter {
+	transport := getOutboundHTTPTransport(bindAddress)
+	if transport == nil {
+		return nil
+	}
+	return &http.Client{
+		Transport: transport,
+	}
+}
+
 type bytesReaderWrapper struct {
 	*bytes.Reader
 }
@@ -1250,6 +1380,7 @@ func (b *bufferAllocator) free() {
 type azureBlobDirLister struct {
 	baseDirLister
 	paginator     *runtime.Pager[container.ListBlobsHierarchyResponse]
+	ctx           context.Context
 	timeout       time.Duration
 	prefix        string
 	prefixes      map[string]bool
@@ -1270,7 +1401,7 @@ func (l *azureBlobDirLister) Next(limit int) ([]os.FileInfo, error) {
 		}
 		return l.returnFromCache(limit), io.EOF
 	}
-	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(l.timeout))
+	ctx, cancelFn := context.WithDeadline(l.ctx, time.Now().Add(l.timeout))
 	defer cancelFn()
 
 	page, err := l.paginator.NextPage(ctx)
@@ -1300,6 +1431,7 @@ func (l *azureBlobDirLister) Next(limit int) ([]os.FileInfo, error) {
 		size := int64(0)
 		isDir := false
 		var metadata map[string]*string
+		var uploadTime time.Time
 		modTime := time.Unix(0, 0)
 		if blobItem.Properties != nil {
 			size = util.GetIntFromPointer(blobItem.Properties.ContentLength)
@@ -1314,6 +1446,9 @@ func (l *azureBlobDirLister) Next(limit int) ([]os.FileInfo, error) {
 				l.prefixes[name] = true
 			} else {
 				metadata = blobItem.Metadata
+				if blobItem.Properties.CreationTime != nil {
+					uploadTime = *blobItem.Properties.CreationTime
+				}
 			}
 			if val := getAzureLastModified(blobItem.Metadata); val > 0 {
 				modTime = util.GetTimeFromMsecSinceEpoch(val)
@@ -1321,6 +1456,7 @@ func (l *azureBlobDirLister) Next(limit int) ([]os.FileInfo, error) {
 		}
 		info := NewFileInfo(name, isDir, size, modTime, false)
 		info.setMetadataFromPointerVal(metadata)
+		info.setUploadTime(uploadTime)
 		l.cache = append(l.cache, info)
 	}
 
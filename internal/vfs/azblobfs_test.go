@@ -0,0 +1,78 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !noazblob
+
+package vfs
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAzBlobAccessTiers(t *testing.T) {
+	config := AzBlobFsConfig{
+		AccessTiers: []AzBlobAccessTierOverride{
+			{
+				Pattern:    " *.zip ",
+				AccessTier: "Cool",
+			},
+			{
+				Pattern:    "/prefix/archive/",
+				AccessTier: "Archive",
+			},
+			{
+				Pattern:    "prefix/*.log",
+				AccessTier: "Cold",
+			},
+		},
+	}
+	config.AccessTier = "Hot"
+	require.NoError(t, validateAzAccessTierOverrides(config.AccessTiers))
+	assert.Equal(t, "*.zip", config.AccessTiers[0].Pattern)
+	assert.Equal(t, "prefix/archive/", config.AccessTiers[1].Pattern)
+
+	fs := &AzureBlobFs{config: &config}
+	assert.Equal(t, "Cool", fs.getAccessTier("prefix/dir/file.zip"))
+	assert.Equal(t, "Cool", fs.getAccessTier("prefix/archive/file.zip"))
+	assert.Equal(t, "Archive", fs.getAccessTier("prefix/archive/sub/file.txt"))
+	assert.Equal(t, "Cold", fs.getAccessTier("prefix/file.log"))
+	assert.Equal(t, "Hot", fs.getAccessTier("prefix/dir/file.log"))
+	assert.Equal(t, "Hot", fs.getAccessTier("prefix/archive"))
+
+	other := cloneAzAccessTierOverrides(config.AccessTiers)
+	assert.True(t, areAzAccessTierOverridesEqual(config.AccessTiers, other))
+	other[0].AccessTier = "Hot"
+	assert.False(t, areAzAccessTierOverridesEqual(config.AccessTiers, other))
+	assert.Nil(t, cloneAzAccessTierOverrides(nil))
+
+	err := validateAzAccessTierOverrides([]AzBlobAccessTierOverride{{Pattern: "*.zip", AccessTier: "P10"}})
+	assert.ErrorContains(t, err, "invalid access tier")
+	err = validateAzAccessTierOverrides([]AzBlobAccessTierOverride{{Pattern: " ", AccessTier: "Cool"}})
+	assert.ErrorContains(t, err, "pattern cannot be empty")
+
+	respErr := &azcore.ResponseError{ErrorCode: string(bloberror.InvalidBlobTier)}
+	err = checkAccessTierError(respErr, "Cold")
+	assert.ErrorContains(t, err, `access tier "Cold" is not supported`)
+	assert.ErrorIs(t, err, respErr)
+	assert.Equal(t, respErr, checkAccessTierError(respErr, ""))
+	otherErr := errors.New("other error")
+	assert.Equal(t, otherErr, checkAccessTierError(otherErr, "Cold"))
+	assert.NoError(t, checkAccessTierError(nil, "Cold"))
+}
@@ -0,0 +1,70 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+)
+
+// AzBlobAccessTierOverride defines the access tier to set for the uploaded
+// blobs whose name matches the specified pattern.
+// Patterns are matched against the blob name, including the key prefix, if
+// any, as for the S3 upload rules:
+//   - patterns without a "/", for example "*.zip", are matched against the file name
+//   - patterns ending with a "/", for example "prefix/archive/", match all the blobs
+//     inside the matching directories
+//   - other patterns are matched against the whole blob name
+type AzBlobAccessTierOverride struct {
+	Pattern string `json:"pattern"`
+	// Access tier to use instead of the one defined for the filesystem
+	AccessTier string `json:"access_tier"`
+}
+
+func (o *AzBlobAccessTierOverride) validate() error {
+	o.Pattern = strings.TrimPrefix(strings.TrimSpace(o.Pattern), "/")
+	if o.Pattern == "" || o.Pattern == "/" {
+		return errors.New("access tier override: pattern cannot be empty")
+	}
+	if _, err := path.Match(strings.TrimSuffix(o.Pattern, "/"), "abc"); err != nil {
+		return fmt.Errorf("access tier override: invalid pattern %q: %w", o.Pattern, err)
+	}
+	o.AccessTier = strings.TrimSpace(o.AccessTier)
+	if o.AccessTier == "" || !slices.Contains(validAzAccessTier, o.AccessTier) {
+		return fmt.Errorf("access tier override %q: invalid access tier %q, valid values: %s", o.Pattern,
+			o.AccessTier, strings.Join(validAzAccessTier[1:], ", "))
+	}
+	return nil
+}
+
+func validateAzAccessTierOverrides(overrides []AzBlobAccessTierOverride) error {
+	for idx := range overrides {
+		if err := overrides[idx].validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func areAzAccessTierOverridesEqual(overrides, other []AzBlobAccessTierOverride) bool {
+	return slices.Equal(overrides, other)
+}
+
+func cloneAzAccessTierOverrides(overrides []AzBlobAccessTierOverride) []AzBlobAccessTierOverride {
+	return slices.Clone(overrides)
+}
@@ -0,0 +1,255 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	// maximum number of parallel per-object operations for the recursive
+	// deletes and renames on the cloud storage backends
+	cloudOperationsConcurrency = 16
+	// maximum number of failed files included in a BatchError message
+	batchErrorMaxFiles = 10
+)
+
+// FsBatchRemover is a Fs that can remove many files at once more efficiently
+// than removing them one by one, for example removing many objects with a
+// single request or removing them in parallel
+type FsBatchRemover interface {
+	Fs
+	// RemoveFiles removes the specified files and returns the removed ones.
+	// If some files cannot be removed a *BatchError is returned too
+	RemoveFiles(names []string) ([]string, error)
+}
+
+// GetBatchRemover returns the FsBatchRemover for the specified Fs, if any.
+// The wrappers for tracing, PGP encryption or attributes recording don't
+// change how the files are removed and so they are skipped
+func GetBatchRemover(fs Fs) (FsBatchRemover, bool) {
+	remover, ok := UnwrapFs(fs).(FsBatchRemover)
+	return remover, ok
+}
+
+// BatchError is returned if a batch operation fails for some files
+type BatchError struct {
+	// Operation is the failed operation, for example "remove"
+	Operation string
+	// Failed maps the failed files to the related errors
+	Failed map[string]error
+}
+
+// Error implements the error interface. Up to 10 failed files are included
+func (e *BatchError) Error() string {
+	names := make([]string, 0, len(e.Failed))
+	for name := range e.Failed {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "unable to %s %d files:", e.Operation, len(names))
+	for idx, name := range names {
+		if idx == batchErrorMaxFiles {
+			fmt.Fprintf(&sb, " and %d more", len(names)-batchErrorMaxFiles)
+			break
+		}
+		if idx > 0 {
+			sb.WriteString(",")
+		}
+		fmt.Fprintf(&sb, " %q: %v", name, e.Failed[name])
+	}
+	return sb.String()
+}
+
+// Unwrap returns the errors for the failed files
+func (e *BatchError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Failed))
+	for _, err := range e.Failed {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+func newBatchError(operation string, failed map[string]error) error {
+	if len(failed) == 0 {
+		return nil
+	}
+	return &BatchError{
+		Operation: operation,
+		Failed:    failed,
+	}
+}
+
+// forEachParallel calls fn for each index, up to cloudOperationsConcurrency
+// calls at a time, and returns the errors indexed as the calls. If ctx is done
+// no other call is started and ctx.Err() is returned for the skipped calls
+func forEachParallel(ctx context.Context, count int, fn func(idx int) error) []error {
+	errs := make([]error, count)
+	guard := make(chan struct{}, cloudOperationsConcurrency)
+	var wg sync.WaitGroup
+
+	for idx := range count {
+		select {
+		case guard <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if err := ctx.Err(); err != nil {
+			for i := idx; i < count; i++ {
+				errs[i] = err
+			}
+			break
+		}
+		wg.Add(1)
+		go func(idx int) {
+			defer func() {
+				<-guard
+				wg.Done()
+			}()
+
+			errs[idx] = fn(idx)
+		}(idx)
+	}
+	wg.Wait()
+	return errs
+}
+
+// removeFilesParallel removes the specified files, in parallel, using the Remove
+// method of the specified Fs
+func removeFilesParallel(ctx context.Context, fs Fs, names []string) ([]string, error) {
+	errs := forEachParallel(ctx, len(names), func(idx int) error {
+		return fs.Remove(names[idx], false)
+	})
+	removed := make([]string, 0, len(names))
+	failed := make(map[string]error)
+	for idx, err := range errs {
+		if err != nil {
+			failed[names[idx]] = err
+			continue
+		}
+		removed = append(removed, names[idx])
+	}
+	return removed, newBatchError("remove", failed)
+}
+
+// doRecursiveRename renames the contents of the source directory to the target
+// one. Directories are renamed one by one using renameFn. The files in each
+// listed batch are copied in parallel using copyFn and then the copied files
+// are removed using the batch remover. The number and the size of the copied
+// files are returned, they are returned even if the sources cannot be removed
+func doRecursiveRename(ctx context.Context, fs FsBatchRemover, source, target string,
+	renameFn func(string, string, os.FileInfo, int, bool) (int, int64, error),
+	copyFn func(string, string, os.FileInfo, bool) error,
+	recursion int, updateModTime bool,
+) (int, int64, error) {
+	var numFiles int
+	var filesSize int64
+
+	if recursion > util.MaxRecursion {
+		return numFiles, filesSize, util.ErrRecursionTooDeep
+	}
+	recursion++
+
+	lister, err := fs.ReadDir(source)
+	if err != nil {
+		return numFiles, filesSize, err
+	}
+	defer lister.Close()
+
+	for {
+		entries, err := lister.Next(ListerBatchSize)
+		finished := errors.Is(err, io.EOF)
+		if err != nil && !finished {
+			return numFiles, filesSize, err
+		}
+		var files []os.FileInfo
+		for _, info := range entries {
+			if !info.IsDir() {
+				files = append(files, info)
+				continue
+			}
+			sourceEntry := fs.Join(source, info.Name())
+			targetEntry := fs.Join(target, info.Name())
+			files, size, err := renameFn(sourceEntry, targetEntry, info, recursion, updateModTime)
+			if err != nil {
+				if fs.IsNotExist(err) {
+					fsLog(fs, logger.LevelInfo, "skipping rename for %q: %v", sourceEntry, err)
+					continue
+				}
+				return numFiles, filesSize, err
+			}
+			numFiles += files
+			filesSize += size
+		}
+		copied, size, err := renameFiles(ctx, fs, source, target, files, copyFn, updateModTime)
+		numFiles += copied
+		filesSize += size
+		if err != nil {
+			return numFiles, filesSize, err
+		}
+		if finished {
+			return numFiles, filesSize, nil
+		}
+	}
+}
+
+// renameFiles copies the specified files, in parallel, and removes the copied
+// sources. It returns the number and the size of the copied files
+func renameFiles(ctx context.Context, fs FsBatchRemover, source, target string, files []os.FileInfo,
+	copyFn func(string, string, os.FileInfo, bool) error, updateModTime bool,
+) (int, int64, error) {
+	var numFiles int
+	var filesSize int64
+
+	if len(files) == 0 {
+		return numFiles, filesSize, nil
+	}
+	errs := forEachParallel(ctx, len(files), func(idx int) error {
+		return copyFn(fs.Join(source, files[idx].Name()), fs.Join(target, files[idx].Name()), files[idx],
+			updateModTime)
+	})
+	copied := make([]string, 0, len(files))
+	failed := make(map[string]error)
+	for idx, err := range errs {
+		sourceEntry := fs.Join(source, files[idx].Name())
+		if err != nil {
+			if fs.IsNotExist(err) {
+				fsLog(fs, logger.LevelInfo, "skipping rename for %q: %v", sourceEntry, err)
+				continue
+			}
+			failed[sourceEntry] = err
+			continue
+		}
+		copied = append(copied, sourceEntry)
+		numFiles++
+		filesSize += files[idx].Size()
+	}
+	_, err := fs.RemoveFiles(copied)
+	if err != nil {
+		fsLog(fs, logger.LevelError, "unable to remove the renamed files: %v", err)
+		return numFiles, filesSize, err
+	}
+	return numFiles, filesSize, newBatchError("rename", failed)
+}
@@ -0,0 +1,87 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatchError(t *testing.T) {
+	assert.NoError(t, newBatchError("remove", nil))
+	errRemove := errors.New("remove error")
+	failed := make(map[string]error)
+	for i := range batchErrorMaxFiles + 2 {
+		failed[fmt.Sprintf("file%02d", i)] = errRemove
+	}
+	err := newBatchError("remove", failed)
+	assert.ErrorIs(t, err, errRemove)
+	assert.Contains(t, err.Error(), "unable to remove 12 files:")
+	assert.Contains(t, err.Error(), `"file00": remove error`)
+	assert.Contains(t, err.Error(), `"file09": remove error`)
+	assert.NotContains(t, err.Error(), "file10")
+	assert.Contains(t, err.Error(), "and 2 more")
+}
+
+func TestForEachParallel(t *testing.T) {
+	var running, maxRunning atomic.Int32
+	errs := forEachParallel(context.Background(), 100, func(idx int) error {
+		n := running.Add(1)
+		defer running.Add(-1)
+
+		for {
+			current := maxRunning.Load()
+			if n <= current || maxRunning.CompareAndSwap(current, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		if idx%10 == 0 {
+			return fmt.Errorf("error %d", idx)
+		}
+		return nil
+	})
+	assert.Len(t, errs, 100)
+	for idx, err := range errs {
+		if idx%10 == 0 {
+			assert.EqualError(t, err, fmt.Sprintf("error %d", idx))
+		} else {
+			assert.NoError(t, err)
+		}
+	}
+	assert.LessOrEqual(t, maxRunning.Load(), int32(cloudOperationsConcurrency))
+
+	signal := newCancelSignal()
+	signal.cancel()
+	var calls atomic.Int32
+	errs = forEachParallel(signal.context(), 10, func(_ int) error {
+		calls.Add(1)
+		return nil
+	})
+	assert.Equal(t, int32(0), calls.Load())
+	for _, err := range errs {
+		assert.ErrorIs(t, err, context.Canceled)
+	}
+	// a nil signal is never canceled
+	var nilSignal *cancelSignal
+	nilSignal.cancel()
+	assert.NoError(t, nilSignal.context().Err())
+}
@@ -0,0 +1,116 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+)
+
+const logSenderBindAddress = "outboundBind"
+
+var (
+	defaultBindAddress string
+	// allow to mock the local addresses in test cases
+	getInterfaceAddrs = net.InterfaceAddrs
+)
+
+// SetOutboundBindAddress sets the default local address for the outbound
+// connections to the storage backends. It can be overridden for each
+// filesystem. An address not yet assigned to a local interface is accepted
+// with a warning, virtual IPs can be moved between nodes
+func SetOutboundBindAddress(address string) error {
+	address = strings.TrimSpace(address)
+	if err := validateOutboundBindAddress(address); err != nil {
+		return err
+	}
+	if address != "" && !isLocalAddress(address) {
+		logger.Warn(logSenderBindAddress, "", "the outbound bind address %q is not assigned to any local interface", address)
+		logger.WarnToConsole("the outbound bind address %q is not assigned to any local interface", address)
+	}
+	defaultBindAddress = address
+	return nil
+}
+
+func validateOutboundBindAddress(address string) error {
+	if address == "" {
+		return nil
+	}
+	if net.ParseIP(address) == nil {
+		return fmt.Errorf("invalid outbound bind address %q, an IP address is required", address)
+	}
+	return nil
+}
+
+func isLocalAddress(address string) bool {
+	ip := net.ParseIP(address)
+	addrs, err := getInterfaceAddrs()
+	if err != nil {
+		logger.Warn(logSenderBindAddress, "", "unable to get the local interface addresses: %v", err)
+		return false
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// getLocalTCPAddr returns the local address to bind the outbound connections
+// to, the filesystem specific address has precedence over the global one.
+// Nil means any local address
+func getLocalTCPAddr(bindAddress string) *net.TCPAddr {
+	if bindAddress == "" {
+		bindAddress = defaultBindAddress
+	}
+	if bindAddress == "" {
+		return nil
+	}
+	return &net.TCPAddr{IP: net.ParseIP(bindAddress)}
+}
+
+// setDialerLocalAddr binds the dialer to the configured local address, if any
+func setDialerLocalAddr(d *net.Dialer, bindAddress string) {
+	if addr := getLocalTCPAddr(bindAddress); addr != nil {
+		d.LocalAddr = addr
+	}
+}
+
+func newOutboundDialer(bindAddress string, timeout time.Duration) *net.Dialer {
+	d := &net.Dialer{
+		Timeout:   timeout,
+		KeepAlive: 30 * time.Second,
+	}
+	setDialerLocalAddr(d, bindAddress)
+	return d
+}
+
+// getOutboundHTTPTransport returns an HTTP transport bound to the configured
+// local address. Nil means that no bind address is configured and the SDK
+// default transport can be used
+func getOutboundHTTPTransport(bindAddress string) *http.Transport {
+	if getLocalTCPAddr(bindAddress) == nil {
+		return nil
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = newOutboundDialer(bindAddress, 30*time.Second).DialContext
+	return transport
+}
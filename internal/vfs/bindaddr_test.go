@@ -0,0 +1,129 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetOutboundBindAddress(t *testing.T) {
+	t.Cleanup(func() {
+		defaultBindAddress = ""
+		getInterfaceAddrs = net.InterfaceAddrs
+	})
+
+	err := SetOutboundBindAddress("invalid")
+	assert.Error(t, err)
+	err = SetOutboundBindAddress("127.0.0.1:2022")
+	assert.Error(t, err)
+	assert.Empty(t, defaultBindAddress)
+
+	err = SetOutboundBindAddress(" 127.0.0.1 ")
+	assert.NoError(t, err)
+	assert.Equal(t, "127.0.0.1", defaultBindAddress)
+	assert.True(t, isLocalAddress("127.0.0.1"))
+	// an address not assigned to a local interface is accepted
+	getInterfaceAddrs = func() ([]net.Addr, error) {
+		return nil, errors.New("unable to get addresses")
+	}
+	assert.False(t, isLocalAddress("192.0.2.10"))
+	err = SetOutboundBindAddress("192.0.2.10")
+	assert.NoError(t, err)
+	assert.Equal(t, "192.0.2.10", defaultBindAddress)
+	getInterfaceAddrs = func() ([]net.Addr, error) {
+		return []net.Addr{
+			&net.IPNet{IP: net.ParseIP("192.0.2.10"), Mask: net.CIDRMask(24, 32)},
+		}, nil
+	}
+	assert.True(t, isLocalAddress("192.0.2.10"))
+	assert.False(t, isLocalAddress("192.0.2.11"))
+
+	err = SetOutboundBindAddress("")
+	assert.NoError(t, err)
+	assert.Empty(t, defaultBindAddress)
+}
+
+func TestLocalTCPAddr(t *testing.T) {
+	t.Cleanup(func() {
+		defaultBindAddress = ""
+	})
+
+	assert.Nil(t, getLocalTCPAddr(""))
+	assert.Nil(t, getOutboundHTTPTransport(""))
+	d := newOutboundDialer("", time.Second)
+	assert.Nil(t, d.LocalAddr)
+
+	addr := getLocalTCPAddr("::1")
+	require.NotNil(t, addr)
+	assert.True(t, addr.IP.Equal(net.ParseIP("::1")))
+	assert.Equal(t, 0, addr.Port)
+
+	defaultBindAddress = "127.0.0.1"
+	addr = getLocalTCPAddr("")
+	require.NotNil(t, addr)
+	assert.True(t, addr.IP.Equal(net.ParseIP("127.0.0.1")))
+	// the filesystem specific address has precedence
+	addr = getLocalTCPAddr("::1")
+	require.NotNil(t, addr)
+	assert.True(t, addr.IP.Equal(net.ParseIP("::1")))
+
+	d = newOutboundDialer("", time.Second)
+	require.NotNil(t, d.LocalAddr)
+	assert.Equal(t, "127.0.0.1:0", d.LocalAddr.String())
+	assert.NotNil(t, getOutboundHTTPTransport(""))
+
+	p, err := newOutboundProxy("socks5://127.0.0.1:1080", "", "")
+	require.NoError(t, err)
+	bound := p.withBindAddress("")
+	require.NotNil(t, bound.localAddr)
+	assert.Equal(t, "127.0.0.1:0", bound.localAddr.String())
+	assert.Nil(t, p.localAddr)
+	defaultBindAddress = ""
+	assert.Equal(t, p, p.withBindAddress(""))
+}
+
+func TestOutboundHTTPTransportBind(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("binding to 127.0.0.2 requires Linux")
+	}
+	remoteAddrs := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remoteAddrs <- r.RemoteAddr
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	transport := getOutboundHTTPTransport("127.0.0.2")
+	require.NotNil(t, transport)
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Transport: transport}
+	resp, err := client.Get(server.URL)
+	require.NoError(t, err)
+	assert.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	host, _, err := net.SplitHostPort(<-remoteAddrs)
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.2", host)
+}
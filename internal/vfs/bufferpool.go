@@ -0,0 +1,227 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/metric"
+)
+
+const (
+	logSenderBufferPool = "bufferPool"
+	// buffer sizes are rounded up to a multiple of this size, so buffers
+	// requested with similar sizes can be reused
+	bufferPoolSizeClass = 32 * 1024
+	// default maximum time to wait for a buffer from an exhausted pool
+	defaultBufferPoolMaxWait = 10 * time.Second
+)
+
+var bufferPool atomic.Pointer[transferBufferPool]
+
+// BufferPoolConfig defines the shared pool for the transfer buffers
+type BufferPoolConfig struct {
+	// Maximum size, in MB, for the buffers owned by the pool. 0 means disabled
+	MaxSize int64
+	// Maximum time, in seconds, to wait for a buffer if the pool is exhausted.
+	// After this time the buffer is allocated outside the pool. 0 means 10
+	MaxWait int
+}
+
+// SetBufferPoolConfig validates and sets the configuration for the shared
+// transfer buffers pool
+func SetBufferPoolConfig(config BufferPoolConfig) error {
+	if config.MaxSize < 0 {
+		return fmt.Errorf("invalid buffer pool max size: %d", config.MaxSize)
+	}
+	if config.MaxWait < 0 {
+		return fmt.Errorf("invalid buffer pool max wait: %d", config.MaxWait)
+	}
+	if config.MaxSize == 0 {
+		bufferPool.Store(nil)
+		metric.UpdateBufferPoolSize(0, 0, 0)
+		return nil
+	}
+	maxWait := defaultBufferPoolMaxWait
+	if config.MaxWait > 0 {
+		maxWait = time.Duration(config.MaxWait) * time.Second
+	}
+	bufferPool.Store(newTransferBufferPool(config.MaxSize*1024*1024, maxWait))
+	logger.Debug(logSenderBufferPool, "", "buffer pool configured, max size: %d MB, max wait: %s",
+		config.MaxSize, maxWait)
+	return nil
+}
+
+// GetTransferBuffer returns a buffer with the specified length and the
+// function to call to release it. The buffer is taken from the shared pool,
+// if enabled, waiting for other transfers to release their buffers if the
+// pool is exhausted. The released buffer must not be used anymore
+func GetTransferBuffer(size int) ([]byte, func()) {
+	p := bufferPool.Load()
+	if p == nil {
+		return make([]byte, size), func() {}
+	}
+	return p.get(size)
+}
+
+// transferBufferPool is a size bounded pool of buffers shared by all the
+// transfers. Released buffers are kept, grouped by capacity, for reuse
+// and idle buffers are dropped, if required, to allocate buffers of a
+// different capacity
+type transferBufferPool struct {
+	maxSize int64
+	maxWait time.Duration
+	mu      sync.Mutex
+	// bytes owned by the pool, idle and in use
+	allocated int64
+	inUse     int64
+	free      map[int][][]byte
+	// created when a transfer has to wait and closed when a buffer is released
+	released chan struct{}
+}
+
+func newTransferBufferPool(maxSize int64, maxWait time.Duration) *transferBufferPool {
+	p := &transferBufferPool{
+		maxSize: maxSize,
+		maxWait: maxWait,
+		free:    make(map[int][][]byte),
+	}
+	p.updateMetrics()
+	return p
+}
+
+func (p *transferBufferPool) get(size int) ([]byte, func()) {
+	capacity := getBufferPoolCapacity(size)
+	if int64(capacity) > p.maxSize {
+		return make([]byte, size), func() {}
+	}
+	buf, released := p.tryGet(capacity)
+	if buf == nil {
+		buf = p.wait(capacity, released)
+		if buf == nil {
+			return make([]byte, size), func() {}
+		}
+	}
+	var once sync.Once
+	return buf[:size], func() {
+		once.Do(func() {
+			p.release(buf)
+		})
+	}
+}
+
+// tryGet returns a pooled buffer with the specified capacity, or nil and
+// a channel closed when a buffer is released if the pool is exhausted
+func (p *transferBufferPool) tryGet(capacity int) ([]byte, chan struct{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if buffers := p.free[capacity]; len(buffers) > 0 {
+		buf := buffers[len(buffers)-1]
+		buffers[len(buffers)-1] = nil
+		p.free[capacity] = buffers[:len(buffers)-1]
+		p.inUse += int64(capacity)
+		p.updateMetrics()
+		return buf, nil
+	}
+	if p.allocated+int64(capacity) > p.maxSize && p.maxSize-p.inUse >= int64(capacity) {
+		p.dropIdle(p.allocated + int64(capacity) - p.maxSize)
+	}
+	if p.allocated+int64(capacity) > p.maxSize {
+		if p.released == nil {
+			p.released = make(chan struct{})
+		}
+		return nil, p.released
+	}
+	p.allocated += int64(capacity)
+	p.inUse += int64(capacity)
+	p.updateMetrics()
+	return make([]byte, capacity), nil
+}
+
+func (p *transferBufferPool) wait(capacity int, released chan struct{}) []byte {
+	metric.BufferPoolWaitersUpdated(1)
+	defer metric.BufferPoolWaitersUpdated(-1)
+
+	timer := time.NewTimer(p.maxWait)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-released:
+			var buf []byte
+			buf, released = p.tryGet(capacity)
+			if buf != nil {
+				metric.BufferPoolWaitCompleted(false)
+				return buf
+			}
+		case <-timer.C:
+			logger.Warn(logSenderBufferPool, "", "no free buffer after %s, allocating %d bytes outside the pool, "+
+				"max size: %d", p.maxWait, capacity, p.maxSize)
+			metric.BufferPoolWaitCompleted(true)
+			return nil
+		}
+	}
+}
+
+// dropIdle removes idle buffers, the caller must hold the lock
+func (p *transferBufferPool) dropIdle(size int64) {
+	for capacity, buffers := range p.free {
+		for len(buffers) > 0 && size > 0 {
+			buffers[len(buffers)-1] = nil
+			buffers = buffers[:len(buffers)-1]
+			p.allocated -= int64(capacity)
+			size -= int64(capacity)
+		}
+		if len(buffers) == 0 {
+			delete(p.free, capacity)
+		} else {
+			p.free[capacity] = buffers
+		}
+		if size <= 0 {
+			return
+		}
+	}
+}
+
+func (p *transferBufferPool) release(buf []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.inUse -= int64(cap(buf))
+	p.free[cap(buf)] = append(p.free[cap(buf)], buf)
+	if p.released != nil {
+		close(p.released)
+		p.released = nil
+	}
+	p.updateMetrics()
+}
+
+// updateMetrics updates the pool metrics, the caller must hold the lock
+// or have exclusive access to the pool
+func (p *transferBufferPool) updateMetrics() {
+	metric.UpdateBufferPoolSize(p.maxSize, p.allocated-p.inUse, p.inUse)
+}
+
+func getBufferPoolCapacity(size int) int {
+	if size <= 0 {
+		return bufferPoolSizeClass
+	}
+	return (size + bufferPoolSizeClass - 1) / bufferPoolSizeClass * bufferPoolSizeClass
+}
@@ -0,0 +1,146 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBufferPoolConfig(t *testing.T) {
+	err := SetBufferPoolConfig(BufferPoolConfig{MaxSize: -1})
+	assert.Error(t, err)
+	err = SetBufferPoolConfig(BufferPoolConfig{MaxSize: 1, MaxWait: -1})
+	assert.Error(t, err)
+	err = SetBufferPoolConfig(BufferPoolConfig{MaxSize: 1})
+	require.NoError(t, err)
+	p := bufferPool.Load()
+	if assert.NotNil(t, p) {
+		assert.Equal(t, int64(1024*1024), p.maxSize)
+		assert.Equal(t, defaultBufferPoolMaxWait, p.maxWait)
+	}
+	err = SetBufferPoolConfig(BufferPoolConfig{MaxSize: 2, MaxWait: 3})
+	require.NoError(t, err)
+	p = bufferPool.Load()
+	if assert.NotNil(t, p) {
+		assert.Equal(t, 3*time.Second, p.maxWait)
+	}
+	err = SetBufferPoolConfig(BufferPoolConfig{})
+	require.NoError(t, err)
+	assert.Nil(t, bufferPool.Load())
+	buf, release := GetTransferBuffer(100)
+	assert.Len(t, buf, 100)
+	release()
+
+	assert.Equal(t, bufferPoolSizeClass, getBufferPoolCapacity(0))
+	assert.Equal(t, bufferPoolSizeClass, getBufferPoolCapacity(1))
+	assert.Equal(t, bufferPoolSizeClass, getBufferPoolCapacity(bufferPoolSizeClass))
+	assert.Equal(t, 2*bufferPoolSizeClass, getBufferPoolCapacity(bufferPoolSizeClass+1))
+}
+
+func TestBufferPoolReuse(t *testing.T) {
+	p := newTransferBufferPool(4*bufferPoolSizeClass, time.Second)
+	buf, release := p.get(1000)
+	assert.Len(t, buf, 1000)
+	assert.Equal(t, bufferPoolSizeClass, cap(buf))
+	assert.Equal(t, int64(bufferPoolSizeClass), p.inUse)
+	release()
+	// releasing more than once has no effect
+	release()
+	assert.Equal(t, int64(0), p.inUse)
+	assert.Equal(t, int64(bufferPoolSizeClass), p.allocated)
+	buf1, release1 := p.get(bufferPoolSizeClass)
+	assert.Equal(t, &buf[:1][0], &buf1[0])
+	// buffers bigger than the pool are allocated outside it
+	buf2, release2 := p.get(5 * bufferPoolSizeClass)
+	assert.Len(t, buf2, 5*bufferPoolSizeClass)
+	assert.Equal(t, int64(bufferPoolSizeClass), p.allocated)
+	release2()
+	assert.Equal(t, int64(bufferPoolSizeClass), p.inUse)
+	release1()
+	// idle buffers are dropped to allocate buffers with a different size
+	buf3, release3 := p.get(4 * bufferPoolSizeClass)
+	assert.Len(t, buf3, 4*bufferPoolSizeClass)
+	assert.Equal(t, int64(4*bufferPoolSizeClass), p.allocated)
+	assert.Equal(t, int64(4*bufferPoolSizeClass), p.inUse)
+	assert.Len(t, p.free, 0)
+	release3()
+	assert.Equal(t, int64(0), p.inUse)
+}
+
+func TestBufferPoolExhausted(t *testing.T) {
+	p := newTransferBufferPool(2*bufferPoolSizeClass, 5*time.Second)
+	_, release1 := p.get(bufferPoolSizeClass)
+	_, release2 := p.get(bufferPoolSizeClass)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		buf, release := p.get(bufferPoolSizeClass)
+		assert.Len(t, buf, bufferPoolSizeClass)
+		release()
+	}()
+	// give the goroutine time to wait for a buffer
+	time.Sleep(100 * time.Millisecond)
+	release1()
+	wg.Wait()
+	assert.Equal(t, int64(bufferPoolSizeClass), p.inUse)
+	assert.Equal(t, int64(2*bufferPoolSizeClass), p.allocated)
+	release2()
+	assert.Equal(t, int64(0), p.inUse)
+
+	// after the max wait the buffer is allocated outside the pool
+	p = newTransferBufferPool(bufferPoolSizeClass, 50*time.Millisecond)
+	_, release1 = p.get(bufferPoolSizeClass)
+	buf, release := p.get(bufferPoolSizeClass)
+	assert.Len(t, buf, bufferPoolSizeClass)
+	assert.Equal(t, int64(bufferPoolSizeClass), p.allocated)
+	release()
+	assert.Equal(t, int64(bufferPoolSizeClass), p.inUse)
+	release1()
+	assert.Equal(t, int64(0), p.inUse)
+}
+
+var benchmarkBuffer []byte
+
+func BenchmarkTransferBuffer(b *testing.B) {
+	b.Run("pooled", func(b *testing.B) {
+		p := newTransferBufferPool(64*1024*1024, time.Second)
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				buf, release := p.get(32768)
+				buf[0] = 1
+				release()
+			}
+		})
+	})
+	b.Run("unpooled", func(b *testing.B) {
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				buf := make([]byte, 32768)
+				buf[0] = 1
+				benchmarkBuffer = buf
+			}
+		})
+	})
+}
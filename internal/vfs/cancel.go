@@ -0,0 +1,65 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"context"
+)
+
+// FsOperationsCanceler is a Fs that can cancel its long running operations,
+// such as listings, server side copies and recursive deletes
+type FsOperationsCanceler interface {
+	Fs
+	// CancelOperations cancels the running operations and makes the new ones
+	// fail with context.Canceled. The transfers are not affected
+	CancelOperations()
+}
+
+// CancelFsOperations cancels the long running operations for the specified
+// Fs, if supported. It is used when the client disconnects
+func CancelFsOperations(fs Fs) {
+	if canceler, ok := UnwrapFs(fs).(FsOperationsCanceler); ok {
+		canceler.CancelOperations()
+	}
+}
+
+// cancelSignal provides the parent context for the long running operations
+// of a Fs. The context is canceled when the Fs is closed or the client
+// disconnects. A nil cancelSignal is never canceled
+type cancelSignal struct {
+	ctx      context.Context
+	cancelFn context.CancelFunc
+}
+
+func newCancelSignal() *cancelSignal {
+	ctx, cancelFn := context.WithCancel(context.Background())
+	return &cancelSignal{
+		ctx:      ctx,
+		cancelFn: cancelFn,
+	}
+}
+
+func (s *cancelSignal) context() context.Context {
+	if s == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+
+func (s *cancelSignal) cancel() {
+	if s != nil {
+		s.cancelFn()
+	}
+}
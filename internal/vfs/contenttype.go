@@ -0,0 +1,126 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// number of bytes used to sniff the content type
+const contentTypeSniffLen = 512
+
+var (
+	contentTypeDetection bool
+	contentTypeSniff     bool
+)
+
+// ContentTypeOverride defines the content type to set for the uploaded files
+// matching the specified pattern
+type ContentTypeOverride struct {
+	// Shell like pattern matched, case insensitive, against the file name
+	Pattern string `json:"pattern"`
+	// Content type to set, for example "text/plain; charset=utf-8"
+	ContentType string `json:"content_type"`
+}
+
+// SetContentTypeDetection enables the content type detection for the objects
+// uploaded to the cloud storage backends. If sniff is true the first bytes of
+// the files are inspected if the content type cannot be detected from the
+// file extension
+func SetContentTypeDetection(enabled, sniff bool) {
+	contentTypeDetection = enabled
+	contentTypeSniff = enabled && sniff
+}
+
+func validateContentTypeOverrides(overrides []ContentTypeOverride) error {
+	for idx := range overrides {
+		o := &overrides[idx]
+		o.Pattern = strings.ToLower(strings.TrimSpace(o.Pattern))
+		o.ContentType = strings.TrimSpace(o.ContentType)
+		if o.Pattern == "" {
+			return errors.New("content type override: pattern cannot be empty")
+		}
+		if _, err := path.Match(o.Pattern, "abc"); err != nil {
+			return fmt.Errorf("content type override: invalid pattern %q: %w", o.Pattern, err)
+		}
+		mediaType, _, err := mime.ParseMediaType(o.ContentType)
+		if err != nil {
+			return fmt.Errorf("content type override: invalid content type %q: %w", o.ContentType, err)
+		}
+		if !strings.Contains(mediaType, "/") {
+			return fmt.Errorf("content type override: invalid content type %q", o.ContentType)
+		}
+	}
+	return nil
+}
+
+func areContentTypeOverridesEqual(overrides, other []ContentTypeOverride) bool {
+	if len(overrides) != len(other) {
+		return false
+	}
+	for idx := range overrides {
+		if overrides[idx] != other[idx] {
+			return false
+		}
+	}
+	return true
+}
+
+func cloneContentTypeOverrides(overrides []ContentTypeOverride) []ContentTypeOverride {
+	if overrides == nil {
+		return nil
+	}
+	result := make([]ContentTypeOverride, len(overrides))
+	copy(result, overrides)
+	return result
+}
+
+// getUploadContentType returns the content type for the named file and true
+// if it must be detected from the file contents
+func getUploadContentType(name string, overrides []ContentTypeOverride) (string, bool) {
+	if contentTypeDetection {
+		baseName := strings.ToLower(path.Base(name))
+		for _, o := range overrides {
+			if matched, _ := path.Match(o.Pattern, baseName); matched {
+				return o.ContentType, false
+			}
+		}
+	}
+	contentType := mime.TypeByExtension(path.Ext(name))
+	return contentType, contentType == "" && contentTypeSniff
+}
+
+// sniffContentType detects the content type from the first bytes read from
+// the given reader and returns a reader for the full contents
+func sniffContentType(reader io.Reader) (string, io.Reader) {
+	buf := make([]byte, contentTypeSniffLen)
+	n, err := io.ReadFull(reader, buf)
+	buf = buf[:n]
+	reader = io.MultiReader(bytes.NewReader(buf), reader)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return "", reader
+	}
+	if n == 0 {
+		return "", reader
+	}
+	return http.DetectContentType(buf), reader
+}
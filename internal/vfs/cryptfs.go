@@ -17,8 +17,10 @@ package vfs
 import (
 	"bufio"
 	"bytes"
+	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -36,13 +38,41 @@ const (
 	version10     byte  = 0x10
 	nonceV10Size  int   = 32
 	headerV10Size int64 = 33 // 1 (version byte) + 32 (nonce size)
+	// version11 adds the ID of the passphrase used to encrypt the file, the
+	// header size is unchanged: 1 (version byte) + 4 (key ID) + 28 (nonce size)
+	version11    byte = 0x11
+	keyIDV11Size int  = 4
+	nonceV11Size int  = 28
 )
 
+// ErrCryptFsKeyNotFound is returned if none of the configured passphrases can decrypt a file
+var ErrCryptFsKeyNotFound = errors.New("no configured passphrase can decrypt the file")
+
+// cryptFsKey defines a passphrase and its ID
+type cryptFsKey struct {
+	id        [4]byte
+	masterKey []byte
+}
+
+func newCryptFsKey(passphrase string) cryptFsKey {
+	key := cryptFsKey{
+		masterKey: []byte(passphrase),
+	}
+	// the ID is derived from the passphrase, so no state is required to
+	// identify the passphrase used for a file
+	mac := hmac.New(sha256.New, key.masterKey)
+	mac.Write([]byte("sftpgo cryptfs key id")) //nolint:errcheck
+	copy(key.id[:], mac.Sum(nil))
+	return key
+}
+
 // CryptFs is a Fs implementation that allows to encrypts/decrypts local files
 type CryptFs struct {
 	*OsFs
 	localTempDir string
-	masterKey    []byte
+	// the first key is the current one, used for writing, the other ones are
+	// the retired keys, used only for reading the existing files
+	keys []cryptFsKey
 }
 
 // NewCryptFs returns a CryptFs object
@@ -53,6 +83,13 @@ func NewCryptFs(connectionID, rootDir, mountPath string, config CryptFsConfig) (
 	if err := config.Passphrase.TryDecrypt(); err != nil {
 		return nil, err
 	}
+	keys := []cryptFsKey{newCryptFsKey(config.Passphrase.GetPayload())}
+	for _, passphrase := range config.RetiredPassphrases {
+		if err := passphrase.TryDecrypt(); err != nil {
+			return nil, err
+		}
+		keys = append(keys, newCryptFsKey(passphrase.GetPayload()))
+	}
 	fs := &CryptFs{
 		OsFs: &OsFs{
 			name:            cryptFsName,
@@ -62,7 +99,7 @@ func NewCryptFs(connectionID, rootDir, mountPath string, config CryptFsConfig) (
 			readBufferSize:  config.ReadBufferSize * 1024 * 1024,
 			writeBufferSize: config.WriteBufferSize * 1024 * 1024,
 		},
-		masterKey: []byte(config.Passphrase.GetPayload()),
+		keys: keys,
 	}
 	if tempPath == "" {
 		fs.localTempDir = rootDir
@@ -154,22 +191,15 @@ func (fs *CryptFs) Open(name string, offset int64) (File, PipeReader, func(), er
 
 // Create creates or opens the named file for writing
 func (fs *CryptFs) Create(name string, _, _ int) (File, PipeWriter, func(), error) {
+	isNewFile := fs.isNewFile(name)
 	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
 	if err != nil {
 		return nil, nil, nil, err
 	}
-	header := encryptedFileHeader{
-		version: version10,
-		nonce:   make([]byte, 32),
-	}
-	_, err = io.ReadFull(rand.Reader, header.nonce)
-	if err != nil {
-		f.Close()
-		return nil, nil, nil, err
+	if isNewFile {
+		fs.applyFileMode(fs, name)
 	}
-	var key [32]byte
-	kdf := hkdf.New(sha256.New, fs.masterKey, header.nonce, nil)
-	_, err = io.ReadFull(kdf, key[:])
+	header, key, err := fs.newEncryptedFileHeader()
 	if err != nil {
 		f.Close()
 		return nil, nil, nil, err
@@ -229,7 +259,7 @@ func (fs *CryptFs) ReadDir(dirname string) (DirLister, error) {
 		return nil, err
 	}
 
-	return &cryptFsDirLister{f}, nil
+	return fs.limitDirLister(fs, dirname, &cryptFsDirLister{f}), nil
 }
 
 // IsUploadResumeSupported returns false sio does not support random access writes
@@ -298,8 +328,7 @@ func (fs *CryptFs) getFileAndEncryptionKey(name string) (*os.File, [32]byte, err
 		f.Close()
 		return nil, key, err
 	}
-	kdf := hkdf.New(sha256.New, fs.masterKey, header.nonce, nil)
-	_, err = io.ReadFull(kdf, key[:])
+	key, err = fs.getEncryptionKey(f, &header)
 	if err != nil {
 		f.Close()
 		return nil, key, err
@@ -307,6 +336,75 @@ func (fs *CryptFs) getFileAndEncryptionKey(name string) (*os.File, [32]byte, err
 	return f, key, err
 }
 
+// newEncryptedFileHeader returns a new header and the encryption key for
+// the current passphrase
+func (fs *CryptFs) newEncryptedFileHeader() (encryptedFileHeader, [32]byte, error) {
+	var key [32]byte
+	header := encryptedFileHeader{
+		version: version11,
+		keyID:   fs.keys[0].id,
+		nonce:   make([]byte, nonceV11Size),
+	}
+	_, err := io.ReadFull(rand.Reader, header.nonce)
+	if err != nil {
+		return header, key, err
+	}
+	key, err = deriveCryptFsKey(fs.keys[0].masterKey, header.nonce)
+	return header, key, err
+}
+
+// getEncryptionKey returns the encryption key for the file with the specified
+// header. The key ID identifies the passphrase for version 1.1 files, for
+// version 1.0 files the configured passphrases are tried in order
+func (fs *CryptFs) getEncryptionKey(f *os.File, header *encryptedFileHeader) ([32]byte, error) {
+	var key [32]byte
+	var candidates []cryptFsKey
+	for _, k := range fs.keys {
+		if header.version == version10 || k.id == header.keyID {
+			candidates = append(candidates, k)
+		}
+	}
+	if len(candidates) == 0 {
+		return key, fmt.Errorf("%w, key ID: %x", ErrCryptFsKeyNotFound, header.keyID)
+	}
+	for _, k := range candidates {
+		key, err := deriveCryptFsKey(k.masterKey, header.nonce)
+		if err != nil {
+			return key, err
+		}
+		if len(candidates) == 1 || fs.isValidEncryptionKey(f, key) {
+			return key, nil
+		}
+	}
+	return key, ErrCryptFsKeyNotFound
+}
+
+// isValidEncryptionKey returns true if the first package of the file can be
+// decrypted and authenticated using the specified key
+func (fs *CryptFs) isValidEncryptionKey(f *os.File, key [32]byte) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	if info.Size() <= headerV10Size {
+		return true
+	}
+	readerAt, err := sio.DecryptReaderAt(&cryptedFileWrapper{File: f}, fs.getSIOConfig(key))
+	if err != nil {
+		return false
+	}
+	buf := make([]byte, 1)
+	_, err = readerAt.ReadAt(buf, 0)
+	return err == nil || err == io.EOF
+}
+
+func deriveCryptFsKey(masterKey, nonce []byte) ([32]byte, error) {
+	var key [32]byte
+	kdf := hkdf.New(sha256.New, masterKey, nonce, nil)
+	_, err := io.ReadFull(kdf, key[:])
+	return key, err
+}
+
 func (*CryptFs) encryptWrapper(dst io.Writer, src io.Reader, config sio.Config) (int64, error) {
 	encReader, err := sio.EncryptReader(src, config)
 	if err != nil {
@@ -366,12 +464,16 @@ func convertCryptFsInfo(info os.FileInfo) os.FileInfo {
 
 type encryptedFileHeader struct {
 	version byte
+	keyID   [4]byte
 	nonce   []byte
 }
 
 func (h *encryptedFileHeader) Store(f *os.File) error {
 	buf := make([]byte, 0, headerV10Size)
-	buf = append(buf, version10)
+	buf = append(buf, h.version)
+	if h.version == version11 {
+		buf = append(buf, h.keyID[:]...)
+	}
 	buf = append(buf, h.nonce...)
 	_, err := f.Write(buf)
 	return err
@@ -384,9 +486,14 @@ func (h *encryptedFileHeader) Load(f *os.File) error {
 		return err
 	}
 	h.version = header[0]
-	if h.version == version10 {
+	switch h.version {
+	case version10:
 		h.nonce = header[1:]
 		return nil
+	case version11:
+		copy(h.keyID[:], header[1:1+keyIDV11Size])
+		h.nonce = header[1+keyIDV11Size:]
+		return nil
 	}
 	return fmt.Errorf("unsupported encryption version: %v", h.version)
 }
@@ -0,0 +1,242 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/minio/sio"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+
+	"github.com/drakkan/sftpgo/v2/internal/kms"
+)
+
+func newTestCryptFs(t *testing.T, rootDir, passphrase string, retired ...string) *CryptFs {
+	config := CryptFsConfig{
+		Passphrase: kms.NewPlainSecret(passphrase),
+	}
+	for _, p := range retired {
+		config.RetiredPassphrases = append(config.RetiredPassphrases, kms.NewPlainSecret(p))
+	}
+	fs, err := NewCryptFs("conn", rootDir, "", config)
+	require.NoError(t, err)
+	return fs.(*CryptFs)
+}
+
+func writeTestCryptFsFile(t *testing.T, fs *CryptFs, name string, data []byte) {
+	_, w, _, err := fs.Create(name, 0, 0)
+	require.NoError(t, err)
+	_, err = w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+}
+
+// writeTestCryptFsV10File writes a file using the version 1.0 header
+func writeTestCryptFsV10File(t *testing.T, name, passphrase string, data []byte) {
+	f, err := os.Create(name)
+	require.NoError(t, err)
+	defer f.Close()
+
+	header := encryptedFileHeader{
+		version: version10,
+		nonce:   make([]byte, nonceV10Size),
+	}
+	_, err = io.ReadFull(rand.Reader, header.nonce)
+	require.NoError(t, err)
+	key, err := deriveCryptFsKey([]byte(passphrase), header.nonce)
+	require.NoError(t, err)
+	require.NoError(t, header.Store(f))
+	_, err = sio.Encrypt(f, bytes.NewReader(data), sio.Config{
+		MinVersion: sio.Version20,
+		MaxVersion: sio.Version20,
+		Key:        key[:],
+	})
+	require.NoError(t, err)
+}
+
+func readTestCryptFsFile(fs *CryptFs, name string) ([]byte, error) {
+	_, r, _, err := fs.Open(name, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
+
+func getTestCryptFsHeader(t *testing.T, name string) encryptedFileHeader {
+	f, err := os.Open(name)
+	require.NoError(t, err)
+	defer f.Close()
+
+	header := encryptedFileHeader{}
+	require.NoError(t, header.Load(f))
+	return header
+}
+
+func TestCryptFsRetiredPassphrases(t *testing.T) {
+	rootDir := t.TempDir()
+	data := make([]byte, 150*1024)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+
+	legacyFile := filepath.Join(rootDir, "legacy")
+	writeTestCryptFsV10File(t, legacyFile, "old", data)
+	oldFs := newTestCryptFs(t, rootDir, "old")
+	oldFile := filepath.Join(rootDir, "old")
+	writeTestCryptFsFile(t, oldFs, oldFile, data)
+	header := getTestCryptFsHeader(t, oldFile)
+	assert.Equal(t, version11, header.version)
+	assert.Equal(t, newCryptFsKey("old").id, header.keyID)
+	info, err := os.Stat(oldFile)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), convertCryptFsInfo(info).Size())
+
+	newFs := newTestCryptFs(t, rootDir, "new", "other", "old")
+	newFile := filepath.Join(rootDir, "new")
+	writeTestCryptFsFile(t, newFs, newFile, data)
+	assert.Equal(t, newCryptFsKey("new").id, getTestCryptFsHeader(t, newFile).keyID)
+	for _, name := range []string{legacyFile, oldFile, newFile} {
+		content, err := readTestCryptFsFile(newFs, name)
+		assert.NoError(t, err)
+		assert.Equal(t, data, content, "unexpected content for %q", name)
+	}
+	// the new file cannot be read without the new passphrase
+	_, err = readTestCryptFsFile(oldFs, newFile)
+	assert.ErrorIs(t, err, ErrCryptFsKeyNotFound)
+	_, err = readTestCryptFsFile(newTestCryptFs(t, rootDir, "new"), legacyFile)
+	assert.Error(t, err)
+
+	config := CryptFsConfig{
+		Passphrase:         kms.NewPlainSecret("new"),
+		RetiredPassphrases: []*kms.Secret{kms.NewEmptySecret()},
+	}
+	assert.Error(t, config.validate())
+	config.RetiredPassphrases = []*kms.Secret{nil}
+	assert.Error(t, config.validate())
+	config.RetiredPassphrases = []*kms.Secret{kms.NewPlainSecret("old")}
+	assert.NoError(t, config.validate())
+	other := CryptFsConfig{
+		Passphrase:         kms.NewPlainSecret("new"),
+		RetiredPassphrases: []*kms.Secret{kms.NewPlainSecret("old")},
+	}
+	assert.True(t, config.isEqual(other))
+	other.RetiredPassphrases = nil
+	assert.False(t, config.isEqual(other))
+}
+
+func TestCryptFsReEncrypt(t *testing.T) {
+	rootDir := t.TempDir()
+	data := make([]byte, 100*1024)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+
+	subDir := filepath.Join(rootDir, "sub")
+	require.NoError(t, os.Mkdir(subDir, os.ModePerm))
+	writeTestCryptFsV10File(t, filepath.Join(rootDir, "legacy"), "old", data)
+	oldFs := newTestCryptFs(t, rootDir, "old")
+	writeTestCryptFsFile(t, oldFs, filepath.Join(subDir, "old"), data)
+	writeTestCryptFsFile(t, oldFs, filepath.Join(subDir, "empty"), nil)
+	writeTestCryptFsFile(t, newTestCryptFs(t, rootDir, "unknown"), filepath.Join(rootDir, "unknown"), data)
+	newFs := newTestCryptFs(t, rootDir, "new", "old")
+	writeTestCryptFsFile(t, newFs, filepath.Join(rootDir, "new"), data)
+	tmpFile := filepath.Join(rootDir, cryptFsReEncryptPrefix+"123")
+	require.NoError(t, os.WriteFile(tmpFile, []byte("data"), 0666))
+
+	type result struct {
+		reEncrypted bool
+		size        int64
+		err         error
+	}
+	var mu sync.Mutex
+	results := make(map[string]result)
+	fn := func(virtualPath string, size int64, reEncrypted bool, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		results[virtualPath] = result{reEncrypted: reEncrypted, size: size, err: err}
+	}
+	limiter := rate.NewLimiter(rate.Limit(10*1024*1024), 1024*1024)
+	err = newFs.ReEncrypt(context.Background(), limiter, fn)
+	require.NoError(t, err)
+	assert.Len(t, results, 5)
+	assert.True(t, results["/legacy"].reEncrypted)
+	assert.Equal(t, int64(len(data)), results["/legacy"].size)
+	assert.True(t, results["/sub/old"].reEncrypted)
+	assert.True(t, results["/sub/empty"].reEncrypted)
+	assert.False(t, results["/new"].reEncrypted)
+	assert.NoError(t, results["/new"].err)
+	assert.ErrorIs(t, results["/unknown"].err, ErrCryptFsKeyNotFound)
+	assert.FileExists(t, tmpFile)
+	// the re-encrypted files can be read without the retired passphrase
+	currentFs := newTestCryptFs(t, rootDir, "new")
+	for _, name := range []string{"legacy", "new", filepath.Join("sub", "old")} {
+		content, err := readTestCryptFsFile(currentFs, filepath.Join(rootDir, name))
+		assert.NoError(t, err)
+		assert.Equal(t, data, content, "unexpected content for %q", name)
+	}
+	content, err := readTestCryptFsFile(currentFs, filepath.Join(subDir, "empty"))
+	assert.NoError(t, err)
+	assert.Empty(t, content)
+	entries, err := os.ReadDir(subDir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+	// a new run skips the files already re-encrypted
+	results = make(map[string]result)
+	err = newFs.ReEncrypt(context.Background(), nil, fn)
+	require.NoError(t, err)
+	for name, res := range results {
+		assert.False(t, res.reEncrypted, "unexpected re-encryption for %q", name)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = newFs.ReEncrypt(ctx, nil, fn)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	err = checkCryptFsFileUnchanged(filepath.Join(rootDir, "new"), &FileInfo{})
+	assert.ErrorIs(t, err, errCryptFsFileModified)
+	missingFs := newTestCryptFs(t, filepath.Join(rootDir, "missing"), "new", "old")
+	err = missingFs.ReEncrypt(context.Background(), nil, fn)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestCryptFsReEncryptOwner(t *testing.T) {
+	if runtime.GOOS == "windows" || os.Geteuid() != 0 {
+		t.Skip("this test requires root privileges and it is not available on Windows")
+	}
+	rootDir := t.TempDir()
+	name := filepath.Join(rootDir, "owned")
+	writeTestCryptFsFile(t, newTestCryptFs(t, rootDir, "old"), name, []byte("data"))
+	require.NoError(t, os.Chown(name, 1000, 1001))
+
+	reEncrypted, err := newTestCryptFs(t, rootDir, "new", "old").reEncryptFile(context.Background(), name, nil)
+	require.NoError(t, err)
+	assert.True(t, reEncrypted)
+	info, err := os.Stat(name)
+	require.NoError(t, err)
+	uid, gid := getFileOwner(info)
+	assert.Equal(t, 1000, uid)
+	assert.Equal(t, 1001, gid)
+}
@@ -0,0 +1,227 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/minio/sio"
+	"golang.org/x/time/rate"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+)
+
+// prefix for the temporary files created while re-encrypting
+const cryptFsReEncryptPrefix = ".sftpgo-reencrypt."
+
+var errCryptFsFileModified = errors.New("the file was modified during the re-encryption")
+
+// ReEncryptFunc is called for each file processed by ReEncrypt. The path is
+// relative to the filesystem root, size is the decrypted size, reEncrypted is
+// false if the file already uses the current passphrase
+type ReEncryptFunc func(virtualPath string, size int64, reEncrypted bool, err error)
+
+// ReEncrypt walks the filesystem root and rewrites the files encrypted using
+// a retired passphrase with the current one. The files already using the
+// current passphrase are skipped, so an interrupted re-encryption can be
+// resumed by starting it again. The limiter, if not nil, limits the read
+// bandwidth as bytes per second. The failures for the single files are
+// reported to fn and do not stop the re-encryption
+func (fs *CryptFs) ReEncrypt(ctx context.Context, limiter *rate.Limiter, fn ReEncryptFunc) error {
+	return filepath.WalkDir(fs.rootDir, func(walkedPath string, d os.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err != nil {
+			if walkedPath == fs.rootDir {
+				return err
+			}
+			fn(fs.GetRelativePath(walkedPath), 0, false, err)
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		if strings.HasPrefix(d.Name(), cryptFsReEncryptPrefix) {
+			// temporary file, it could be a leftover from a crash
+			fsLog(fs, logger.LevelDebug, "skipping re-encryption temporary file %q", walkedPath)
+			return nil
+		}
+		var size int64
+		if info, err := d.Info(); err == nil {
+			size = convertCryptFsInfo(info).Size()
+		}
+		reEncrypted, err := fs.reEncryptFile(ctx, walkedPath, limiter)
+		if err != nil && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		fn(fs.GetRelativePath(walkedPath), size, reEncrypted, err)
+		return nil
+	})
+}
+
+// reEncryptFile rewrites the named file using the current passphrase, if
+// required. The file is written to a temporary file inside the same directory
+// and then renamed, so it is never left partially written
+func (fs *CryptFs) reEncryptFile(ctx context.Context, name string, limiter *rate.Limiter) (bool, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return false, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return false, err
+	}
+	header := encryptedFileHeader{}
+	if err := header.Load(f); err != nil {
+		f.Close()
+		return false, err
+	}
+	if fs.isEncryptedWithCurrentKey(f, &header) {
+		f.Close()
+		return false, nil
+	}
+	key, err := fs.getEncryptionKey(f, &header)
+	if err != nil {
+		f.Close()
+		return false, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(name), cryptFsReEncryptPrefix+"*")
+	if err != nil {
+		f.Close()
+		return false, err
+	}
+	tmpName := tmp.Name()
+	err = fs.reEncryptContent(ctx, tmp, f, info.Size(), key, limiter)
+	f.Close()
+	errClose := tmp.Close()
+	if err == nil {
+		err = errClose
+	}
+	if err == nil {
+		err = os.Chmod(tmpName, info.Mode().Perm())
+	}
+	if err == nil {
+		err = restoreFileOwner(tmpName, info)
+	}
+	if err == nil {
+		err = os.Chtimes(tmpName, info.ModTime(), info.ModTime())
+	}
+	if err == nil {
+		err = checkCryptFsFileUnchanged(name, info)
+	}
+	if err == nil {
+		err = os.Rename(tmpName, name)
+	}
+	if err != nil {
+		os.Remove(tmpName) //nolint:errcheck
+		return false, err
+	}
+	return true, nil
+}
+
+// restoreFileOwner sets the uid and gid of the original file, if they differ,
+// the temporary file is owned by the SFTPGo process
+func restoreFileOwner(name string, info os.FileInfo) error {
+	uid, gid := getFileOwner(info)
+	if uid == -1 && gid == -1 {
+		return nil
+	}
+	tmpInfo, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+	tmpUID, tmpGID := getFileOwner(tmpInfo)
+	if uid == tmpUID && gid == tmpGID {
+		return nil
+	}
+	return os.Chown(name, uid, gid)
+}
+
+func (fs *CryptFs) reEncryptContent(ctx context.Context, dst *os.File, src *os.File, size int64, key [32]byte,
+	limiter *rate.Limiter,
+) error {
+	header, newKey, err := fs.newEncryptedFileHeader()
+	if err != nil {
+		return err
+	}
+	if err := header.Store(dst); err != nil {
+		return err
+	}
+	if size <= headerV10Size {
+		return nil
+	}
+	decReader, err := sio.DecryptReader(&rateLimitedReader{ctx: ctx, r: src, limiter: limiter}, fs.getSIOConfig(key))
+	if err != nil {
+		return err
+	}
+	_, err = sio.Encrypt(dst, decReader, fs.getSIOConfig(newKey))
+	return err
+}
+
+// isEncryptedWithCurrentKey returns true if the file uses the current passphrase
+func (fs *CryptFs) isEncryptedWithCurrentKey(f *os.File, header *encryptedFileHeader) bool {
+	if header.version == version11 {
+		return header.keyID == fs.keys[0].id
+	}
+	key, err := deriveCryptFsKey(fs.keys[0].masterKey, header.nonce)
+	if err != nil {
+		return false
+	}
+	return fs.isValidEncryptionKey(f, key)
+}
+
+func checkCryptFsFileUnchanged(name string, info os.FileInfo) error {
+	current, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+	if current.Size() != info.Size() || !current.ModTime().Equal(info.ModTime()) {
+		return errCryptFsFileModified
+	}
+	return nil
+}
+
+// rateLimitedReader limits the read bandwidth using the specified limiter
+type rateLimitedReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *rate.Limiter
+}
+
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	if r.limiter == nil {
+		return r.r.Read(p)
+	}
+	if burst := r.limiter.Burst(); burst > 0 && len(p) > burst {
+		p = p[:burst]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if errWait := r.limiter.WaitN(r.ctx, n); errWait != nil {
+			return n, errWait
+		}
+	}
+	return n, err
+}
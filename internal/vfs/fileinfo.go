@@ -29,6 +29,9 @@ type FileInfo struct {
 	modTime     time.Time
 	mode        os.FileMode
 	metadata    map[string]string
+	// time the file was stored in the backend, set for the backends
+	// allowing to change the modification time as object metadata
+	uploadTime time.Time
 }
 
 // NewFileInfo creates file info.
@@ -104,6 +107,19 @@ func (fi *FileInfo) setMetadataFromPointerVal(value map[string]*string) {
 	}
 }
 
+func (fi *FileInfo) setUploadTime(value time.Time) {
+	fi.uploadTime = value
+}
+
+// GetUploadTime returns the time the file was stored in the backend, if known,
+// otherwise the modification time
+func GetUploadTime(fi os.FileInfo) time.Time {
+	if info, ok := fi.(*FileInfo); ok && !info.uploadTime.IsZero() {
+		return info.uploadTime
+	}
+	return fi.ModTime()
+}
+
 func getMetadata(fi os.FileInfo) map[string]string {
 	if fi.Sys() == nil {
 		return nil
@@ -15,6 +15,8 @@
 package vfs
 
 import (
+	"fmt"
+	"maps"
 	"os"
 
 	"github.com/sftpgo/sdk"
@@ -34,6 +36,9 @@ type Filesystem struct {
 	CryptConfig    CryptFsConfig          `json:"cryptconfig,omitempty"`
 	SFTPConfig     SFTPFsConfig           `json:"sftpconfig,omitempty"`
 	HTTPConfig     HTTPFsConfig           `json:"httpconfig,omitempty"`
+	// Maximum number of entries a directory listing may return, it overrides
+	// the global setting. 0 means the global setting, -1 means no limit
+	ListingMaxEntries int `json:"listing_max_entries,omitempty"`
 }
 
 // SetEmptySecrets sets the secrets to empty
@@ -47,8 +52,10 @@ func (f *Filesystem) SetEmptySecrets() {
 	f.SFTPConfig.Password = kms.NewEmptySecret()
 	f.SFTPConfig.PrivateKey = kms.NewEmptySecret()
 	f.SFTPConfig.KeyPassphrase = kms.NewEmptySecret()
+	f.SFTPConfig.ProxyPassword = kms.NewEmptySecret()
 	f.HTTPConfig.Password = kms.NewEmptySecret()
 	f.HTTPConfig.APIKey = kms.NewEmptySecret()
+	f.HTTPConfig.ProxyPassword = kms.NewEmptySecret()
 }
 
 // SetEmptySecretsIfNil sets the secrets to empty if nil
@@ -80,12 +87,18 @@ func (f *Filesystem) SetEmptySecretsIfNil() {
 	if f.SFTPConfig.KeyPassphrase == nil {
 		f.SFTPConfig.KeyPassphrase = kms.NewEmptySecret()
 	}
+	if f.SFTPConfig.ProxyPassword == nil {
+		f.SFTPConfig.ProxyPassword = kms.NewEmptySecret()
+	}
 	if f.HTTPConfig.Password == nil {
 		f.HTTPConfig.Password = kms.NewEmptySecret()
 	}
 	if f.HTTPConfig.APIKey == nil {
 		f.HTTPConfig.APIKey = kms.NewEmptySecret()
 	}
+	if f.HTTPConfig.ProxyPassword == nil {
+		f.HTTPConfig.ProxyPassword = kms.NewEmptySecret()
+	}
 }
 
 // SetNilSecretsIfEmpty set the secrets to nil if empty.
@@ -119,6 +132,9 @@ func (f *Filesystem) IsEqual(other Filesystem) bool {
 	if f.Provider != other.Provider {
 		return false
 	}
+	if f.ListingMaxEntries != other.ListingMaxEntries {
+		return false
+	}
 	switch f.Provider {
 	case sdk.S3FilesystemProvider:
 		return f.S3Config.isEqual(other.S3Config)
@@ -173,6 +189,12 @@ func (f *Filesystem) GetPathSeparator() string {
 // Validate verifies the FsConfig matching the configured provider and sets all other
 // Filesystem.*Config to their zero value if successful
 func (f *Filesystem) Validate(additionalData string) error {
+	if f.ListingMaxEntries < -1 {
+		return util.NewI18nError(
+			util.NewValidationError(fmt.Sprintf("invalid listing max entries: %d", f.ListingMaxEntries)),
+			util.I18nErrorFsValidation,
+		)
+	}
 	switch f.Provider {
 	case sdk.S3FilesystemProvider:
 		if err := f.S3Config.ValidateAndEncryptCredentials(additionalData); err != nil {
@@ -273,7 +295,7 @@ func (f *Filesystem) HasRedactedSecret() bool {
 		}
 		return f.AzBlobConfig.SASURL.IsRedacted()
 	case sdk.CryptedFilesystemProvider:
-		return f.CryptConfig.Passphrase.IsRedacted()
+		return f.CryptConfig.hasRedactedSecret()
 	case sdk.SFTPFilesystemProvider:
 		if f.SFTPConfig.Password.IsRedacted() {
 			return true
@@ -281,12 +303,18 @@ func (f *Filesystem) HasRedactedSecret() bool {
 		if f.SFTPConfig.PrivateKey.IsRedacted() {
 			return true
 		}
-		return f.SFTPConfig.KeyPassphrase.IsRedacted()
+		if f.SFTPConfig.KeyPassphrase.IsRedacted() {
+			return true
+		}
+		return f.SFTPConfig.ProxyPassword.IsRedacted()
 	case sdk.HTTPFilesystemProvider:
 		if f.HTTPConfig.Password.IsRedacted() {
 			return true
 		}
-		return f.HTTPConfig.APIKey.IsRedacted()
+		if f.HTTPConfig.APIKey.IsRedacted() {
+			return true
+		}
+		return f.HTTPConfig.ProxyPassword.IsRedacted()
 	}
 
 	return false
@@ -314,7 +342,8 @@ func (f *Filesystem) HideConfidentialData() {
 func (f *Filesystem) GetACopy() Filesystem {
 	f.SetEmptySecretsIfNil()
 	fs := Filesystem{
-		Provider: f.Provider,
+		Provider:          f.Provider,
+		ListingMaxEntries: f.ListingMaxEntries,
 		OSConfig: sdk.OSFsConfig{
 			ReadBufferSize:  f.OSConfig.ReadBufferSize,
 			WriteBufferSize: f.OSConfig.WriteBufferSize,
@@ -338,8 +367,15 @@ func (f *Filesystem) GetACopy() Filesystem {
 				ForcePathStyle:      f.S3Config.ForcePathStyle,
 				SkipTLSVerify:       f.S3Config.SkipTLSVerify,
 			},
-			AccessSecret:   f.S3Config.AccessSecret.Clone(),
-			SSECustomerKey: f.S3Config.SSECustomerKey.Clone(),
+			AccessSecret:        f.S3Config.AccessSecret.Clone(),
+			SSECustomerKey:      f.S3Config.SSECustomerKey.Clone(),
+			ContentTypes:        cloneContentTypeOverrides(f.S3Config.ContentTypes),
+			UploadRules:         cloneS3UploadRules(f.S3Config.UploadRules),
+			RequestPayer:        f.S3Config.RequestPayer,
+			ExternalID:          f.S3Config.ExternalID,
+			RoleSessionName:     f.S3Config.RoleSessionName,
+			OutboundBindAddress: f.S3Config.OutboundBindAddress,
+			UseStoredChecksums:  f.S3Config.UseStoredChecksums,
 		},
 		GCSConfig: GCSFsConfig{
 			BaseGCSFsConfig: sdk.BaseGCSFsConfig{
@@ -351,7 +387,11 @@ func (f *Filesystem) GetACopy() Filesystem {
 				UploadPartSize:       f.GCSConfig.UploadPartSize,
 				UploadPartMaxTime:    f.GCSConfig.UploadPartMaxTime,
 			},
-			Credentials: f.GCSConfig.Credentials.Clone(),
+			Credentials:         f.GCSConfig.Credentials.Clone(),
+			ContentTypes:        cloneContentTypeOverrides(f.GCSConfig.ContentTypes),
+			KMSKeyName:          f.GCSConfig.KMSKeyName,
+			ObjectMetadata:      maps.Clone(f.GCSConfig.ObjectMetadata),
+			OutboundBindAddress: f.GCSConfig.OutboundBindAddress,
 		},
 		AzBlobConfig: AzBlobFsConfig{
 			BaseAzBlobFsConfig: sdk.BaseAzBlobFsConfig{
@@ -366,15 +406,19 @@ func (f *Filesystem) GetACopy() Filesystem {
 				UseEmulator:         f.AzBlobConfig.UseEmulator,
 				AccessTier:          f.AzBlobConfig.AccessTier,
 			},
-			AccountKey: f.AzBlobConfig.AccountKey.Clone(),
-			SASURL:     f.AzBlobConfig.SASURL.Clone(),
+			AccountKey:          f.AzBlobConfig.AccountKey.Clone(),
+			SASURL:              f.AzBlobConfig.SASURL.Clone(),
+			ContentTypes:        cloneContentTypeOverrides(f.AzBlobConfig.ContentTypes),
+			AccessTiers:         cloneAzAccessTierOverrides(f.AzBlobConfig.AccessTiers),
+			OutboundBindAddress: f.AzBlobConfig.OutboundBindAddress,
 		},
 		CryptConfig: CryptFsConfig{
 			OSFsConfig: sdk.OSFsConfig{
 				ReadBufferSize:  f.CryptConfig.ReadBufferSize,
 				WriteBufferSize: f.CryptConfig.WriteBufferSize,
 			},
-			Passphrase: f.CryptConfig.Passphrase.Clone(),
+			Passphrase:         f.CryptConfig.Passphrase.Clone(),
+			RetiredPassphrases: f.CryptConfig.getRetiredPassphrasesCopy(),
 		},
 		SFTPConfig: SFTPFsConfig{
 			BaseSFTPFsConfig: sdk.BaseSFTPFsConfig{
@@ -385,9 +429,15 @@ func (f *Filesystem) GetACopy() Filesystem {
 				BufferSize:              f.SFTPConfig.BufferSize,
 				EqualityCheckMode:       f.SFTPConfig.EqualityCheckMode,
 			},
-			Password:      f.SFTPConfig.Password.Clone(),
-			PrivateKey:    f.SFTPConfig.PrivateKey.Clone(),
-			KeyPassphrase: f.SFTPConfig.KeyPassphrase.Clone(),
+			Password:            f.SFTPConfig.Password.Clone(),
+			PrivateKey:          f.SFTPConfig.PrivateKey.Clone(),
+			KeyPassphrase:       f.SFTPConfig.KeyPassphrase.Clone(),
+			KnownHostsFile:      f.SFTPConfig.KnownHostsFile,
+			PoolSize:            f.SFTPConfig.PoolSize,
+			ProxyURL:            f.SFTPConfig.ProxyURL,
+			ProxyUsername:       f.SFTPConfig.ProxyUsername,
+			ProxyPassword:       f.SFTPConfig.ProxyPassword.Clone(),
+			OutboundBindAddress: f.SFTPConfig.OutboundBindAddress,
 		},
 		HTTPConfig: HTTPFsConfig{
 			BaseHTTPFsConfig: sdk.BaseHTTPFsConfig{
@@ -396,13 +446,26 @@ func (f *Filesystem) GetACopy() Filesystem {
 				SkipTLSVerify:     f.HTTPConfig.SkipTLSVerify,
 				EqualityCheckMode: f.HTTPConfig.EqualityCheckMode,
 			},
-			Password: f.HTTPConfig.Password.Clone(),
-			APIKey:   f.HTTPConfig.APIKey.Clone(),
+			Password:                f.HTTPConfig.Password.Clone(),
+			APIKey:                  f.HTTPConfig.APIKey.Clone(),
+			ProxyURL:                f.HTTPConfig.ProxyURL,
+			ProxyUsername:           f.HTTPConfig.ProxyUsername,
+			ProxyPassword:           f.HTTPConfig.ProxyPassword.Clone(),
+			OutboundBindAddress:     f.HTTPConfig.OutboundBindAddress,
+			RetryMaxAttempts:        f.HTTPConfig.RetryMaxAttempts,
+			RetryBaseDelay:          f.HTTPConfig.RetryBaseDelay,
+			RetryIdempotentWrites:   f.HTTPConfig.RetryIdempotentWrites,
+			CircuitBreakerThreshold: f.HTTPConfig.CircuitBreakerThreshold,
+			CircuitBreakerCooldown:  f.HTTPConfig.CircuitBreakerCooldown,
 		},
 	}
 	if len(f.SFTPConfig.Fingerprints) > 0 {
 		fs.SFTPConfig.Fingerprints = make([]string, len(f.SFTPConfig.Fingerprints))
 		copy(fs.SFTPConfig.Fingerprints, f.SFTPConfig.Fingerprints)
 	}
+	if len(f.SFTPConfig.KnownHosts) > 0 {
+		fs.SFTPConfig.KnownHosts = make([]string, len(f.SFTPConfig.KnownHosts))
+		copy(fs.SFTPConfig.KnownHosts, f.SFTPConfig.KnownHosts)
+	}
 	return fs
 }
@@ -17,10 +17,15 @@ package vfs
 import (
 	"errors"
 	"fmt"
+	"path"
+	"slices"
 	"strings"
 
 	"github.com/rs/xid"
 	"github.com/sftpgo/sdk"
+
+	"github.com/drakkan/sftpgo/v2/internal/kms"
+	"github.com/drakkan/sftpgo/v2/internal/util"
 )
 
 // BaseVirtualFolder defines the path for the virtual folder and the used quota limits.
@@ -42,6 +47,165 @@ type BaseVirtualFolder struct {
 	Groups []string `json:"groups,omitempty"`
 	// Filesystem configuration details
 	FsConfig Filesystem `json:"filesystem"`
+	// Automatic retention for the files inside the folder
+	Retention FolderRetention `json:"retention"`
+	// Results of the last quota scan, nil if the folder was never scanned
+	QuotaScan *FolderQuotaScan `json:"quota_scan,omitempty"`
+	// Maximum size, in bytes, for a single file uploaded inside the folder.
+	// It overrides the user level limit, 0 means no folder specific limit
+	MaxUploadFileSize int64 `json:"max_upload_file_size,omitempty"`
+	// Automatic extraction for the archives uploaded inside the folder
+	Extraction FolderExtraction `json:"extraction"`
+	// Transformation applied to the files uploaded inside the folder
+	Transform FolderTransform `json:"transform"`
+	// SetstatMode defines how to handle the requests for changing permissions,
+	// owner and modification time not supported by the storage backend.
+	// 0 means the global setstat mode, 1 means that the attributes are
+	// recorded as object metadata. Only supported for cloud storage backends
+	SetstatMode int `json:"setstat_mode,omitempty"`
+}
+
+// Supported setstat modes for virtual folders
+const (
+	// FolderSetstatModeDefault applies the global setstat mode
+	FolderSetstatModeDefault = iota
+	// FolderSetstatModeRecord accepts the requests for changing permissions,
+	// owner and modification time and records the attributes as object metadata
+	FolderSetstatModeRecord
+)
+
+// FolderQuotaScan defines the results of the last quota scan for a virtual folder
+type FolderQuotaScan struct {
+	// Scan completion as unix timestamp in milliseconds
+	LastScan int64 `json:"last_scan"`
+	// Files and size found by the scan
+	ScannedFiles int   `json:"scanned_files"`
+	ScannedSize  int64 `json:"scanned_size"`
+	// Tracked minus scanned usage when the scan completed. A positive value
+	// means that the incremental tracking counted files no longer present
+	DriftFiles int   `json:"drift_files"`
+	DriftSize  int64 `json:"drift_size"`
+}
+
+// Default limits for the archives extraction
+const (
+	DefaultExtractionMaxEntries = 10000
+	DefaultExtractionMaxSize    = int64(1073741824)
+	DefaultExtractionMaxRatio   = 100
+)
+
+// FolderExtraction defines the automatic extraction of the archives uploaded
+// inside a virtual folder
+type FolderExtraction struct {
+	// Uploaded files with a name matching these shell patterns are extracted,
+	// empty means disabled. Supported formats: zip, tar, tar.gz and tgz
+	Patterns []string `json:"patterns,omitempty"`
+	// Directory, relative to the folder root, for the extracted files.
+	// Empty means the directory containing the archive
+	TargetDir string `json:"target_dir,omitempty"`
+	// Maximum number of entries in an archive, 0 means the default limit
+	MaxEntries int `json:"max_entries,omitempty"`
+	// Maximum total uncompressed size as bytes, 0 means the default limit
+	MaxSize int64 `json:"max_size,omitempty"`
+	// Maximum ratio between the uncompressed size and the archive size,
+	// 0 means the default limit
+	MaxRatio int `json:"max_ratio,omitempty"`
+	// If true the archive is deleted after a successful extraction
+	DeleteArchive bool `json:"delete_archive,omitempty"`
+}
+
+// IsEnabled returns true if the extraction is configured
+func (e *FolderExtraction) IsEnabled() bool {
+	return len(e.Patterns) > 0
+}
+
+// Matches returns true if the file with the specified name must be extracted
+func (e *FolderExtraction) Matches(name string) bool {
+	for _, pattern := range e.Patterns {
+		if matched, err := path.Match(pattern, name); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// GetMaxEntries returns the maximum number of entries for an archive
+func (e *FolderExtraction) GetMaxEntries() int {
+	if e.MaxEntries == 0 {
+		return DefaultExtractionMaxEntries
+	}
+	return e.MaxEntries
+}
+
+// GetMaxSize returns the maximum uncompressed size for an archive
+func (e *FolderExtraction) GetMaxSize() int64 {
+	if e.MaxSize == 0 {
+		return DefaultExtractionMaxSize
+	}
+	return e.MaxSize
+}
+
+// GetMaxRatio returns the maximum compression ratio for an archive
+func (e *FolderExtraction) GetMaxRatio() int {
+	if e.MaxRatio == 0 {
+		return DefaultExtractionMaxRatio
+	}
+	return e.MaxRatio
+}
+
+func (e *FolderExtraction) getACopy() FolderExtraction {
+	patterns := make([]string, len(e.Patterns))
+	copy(patterns, e.Patterns)
+	return FolderExtraction{
+		Patterns:      patterns,
+		TargetDir:     e.TargetDir,
+		MaxEntries:    e.MaxEntries,
+		MaxSize:       e.MaxSize,
+		MaxRatio:      e.MaxRatio,
+		DeleteArchive: e.DeleteArchive,
+	}
+}
+
+// FolderTransform defines the transformation applied to the files uploaded
+// inside a virtual folder
+type FolderTransform struct {
+	// Armored OpenPGP public keys, the uploaded files are encrypted for all
+	// these recipients. Empty means disabled
+	PGPPublicKeys *kms.Secret `json:"pgp_public_keys,omitempty"`
+	// If true the encrypted files keep the uploaded name, otherwise the
+	// ".pgp" extension is added
+	PGPKeepName bool `json:"pgp_keep_name,omitempty"`
+}
+
+// IsEnabled returns true if the transformation is configured
+func (t *FolderTransform) IsEnabled() bool {
+	return t.PGPPublicKeys != nil && !t.PGPPublicKeys.IsEmpty()
+}
+
+func (t *FolderTransform) getACopy() FolderTransform {
+	var keys *kms.Secret
+	if t.PGPPublicKeys != nil {
+		keys = t.PGPPublicKeys.Clone()
+	}
+	return FolderTransform{
+		PGPPublicKeys: keys,
+		PGPKeepName:   t.PGPKeepName,
+	}
+}
+
+// FolderRetention defines the automatic retention for the files inside a virtual folder
+type FolderRetention struct {
+	// Files older than the specified hours are deleted, 0 means disabled
+	Hours int `json:"hours,omitempty"`
+	// If true the directories emptied by the retention are not removed
+	PreserveDirs bool `json:"preserve_dirs,omitempty"`
+	// If true the files to delete are only logged
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+// IsEnabled returns true if the retention is configured
+func (r *FolderRetention) IsEnabled() bool {
+	return r.Hours > 0
 }
 
 // GetEncryptionAdditionalData returns the additional data to use for AEAD
@@ -56,17 +220,40 @@ func (v *BaseVirtualFolder) GetACopy() BaseVirtualFolder {
 	groups := make([]string, len(v.Groups))
 	copy(groups, v.Groups)
 	return BaseVirtualFolder{
-		ID:              v.ID,
-		Name:            v.Name,
-		Description:     v.Description,
-		MappedPath:      v.MappedPath,
-		UsedQuotaSize:   v.UsedQuotaSize,
-		UsedQuotaFiles:  v.UsedQuotaFiles,
-		LastQuotaUpdate: v.LastQuotaUpdate,
-		Users:           users,
-		Groups:          v.Groups,
-		FsConfig:        v.FsConfig.GetACopy(),
+		ID:                v.ID,
+		Name:              v.Name,
+		Description:       v.Description,
+		MappedPath:        v.MappedPath,
+		UsedQuotaSize:     v.UsedQuotaSize,
+		UsedQuotaFiles:    v.UsedQuotaFiles,
+		LastQuotaUpdate:   v.LastQuotaUpdate,
+		Users:             users,
+		Groups:            v.Groups,
+		FsConfig:          v.FsConfig.GetACopy(),
+		Retention:         v.Retention,
+		QuotaScan:         v.getQuotaScanCopy(),
+		MaxUploadFileSize: v.MaxUploadFileSize,
+		Extraction:        v.Extraction.getACopy(),
+		Transform:         v.Transform.getACopy(),
+		SetstatMode:       v.SetstatMode,
+	}
+}
+
+func (v *BaseVirtualFolder) getQuotaScanCopy() *FolderQuotaScan {
+	if v.QuotaScan == nil {
+		return nil
 	}
+	scan := *v.QuotaScan
+	return &scan
+}
+
+// IsModifiedSinceQuotaScan returns true if the folder was never scanned or if
+// the tracked quota was updated after the last scan
+func (v *BaseVirtualFolder) IsModifiedSinceQuotaScan() bool {
+	if v.QuotaScan == nil {
+		return true
+	}
+	return v.LastQuotaUpdate > v.QuotaScan.LastScan
 }
 
 // IsLocalOrLocalCrypted returns true if the folder provider is local or local encrypted
@@ -90,6 +277,9 @@ func (v *BaseVirtualFolder) hideConfidentialData() {
 	case sdk.HTTPFilesystemProvider:
 		v.FsConfig.HTTPConfig.HideConfidentialData()
 	}
+	if v.Transform.PGPPublicKeys != nil {
+		v.Transform.PGPPublicKeys.Hide()
+	}
 }
 
 // PrepareForRendering prepares a folder for rendering.
@@ -102,11 +292,109 @@ func (v *BaseVirtualFolder) PrepareForRendering() {
 
 // HasRedactedSecret returns true if the folder has a redacted secret
 func (v *BaseVirtualFolder) HasRedactedSecret() bool {
+	if v.Transform.PGPPublicKeys != nil && v.Transform.PGPPublicKeys.IsRedacted() {
+		return true
+	}
 	return v.FsConfig.HasRedactedSecret()
 }
 
-// hasPathPlaceholder returns true if the folder has a path placeholder
-func (v *BaseVirtualFolder) hasPathPlaceholder() bool {
+// ValidateRetention returns an error if the retention is not valid
+func (v *BaseVirtualFolder) ValidateRetention() error {
+	if v.Retention.Hours < 0 {
+		return util.NewValidationError(fmt.Sprintf("invalid retention hours: %d", v.Retention.Hours))
+	}
+	if v.Retention.Hours == 0 {
+		v.Retention.PreserveDirs = false
+		v.Retention.DryRun = false
+		return nil
+	}
+	if v.HasPathPlaceholder() {
+		return util.NewValidationError("retention is not supported for folders with a path placeholder")
+	}
+	return nil
+}
+
+// ValidateExtraction returns an error if the archives extraction is not valid
+func (v *BaseVirtualFolder) ValidateExtraction() error {
+	var patterns []string
+	for _, pattern := range v.Extraction.Patterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if strings.Contains(pattern, "/") {
+			return util.NewValidationError(fmt.Sprintf("invalid extraction pattern %q, only file names are supported", pattern))
+		}
+		if _, err := path.Match(pattern, "abc"); err != nil {
+			return util.NewValidationError(fmt.Sprintf("invalid extraction pattern %q: %v", pattern, err))
+		}
+		patterns = append(patterns, pattern)
+	}
+	v.Extraction.Patterns = util.RemoveDuplicates(patterns, false)
+	if !v.Extraction.IsEnabled() {
+		v.Extraction = FolderExtraction{}
+		return nil
+	}
+	if v.Extraction.MaxEntries < 0 {
+		return util.NewValidationError(fmt.Sprintf("invalid extraction max entries: %d", v.Extraction.MaxEntries))
+	}
+	if v.Extraction.MaxSize < 0 {
+		return util.NewValidationError(fmt.Sprintf("invalid extraction max size: %d", v.Extraction.MaxSize))
+	}
+	if v.Extraction.MaxRatio < 0 {
+		return util.NewValidationError(fmt.Sprintf("invalid extraction max ratio: %d", v.Extraction.MaxRatio))
+	}
+	if v.Extraction.TargetDir != "" {
+		v.Extraction.TargetDir = util.CleanPath(v.Extraction.TargetDir)
+	}
+	return nil
+}
+
+// ValidateTransform returns an error if the transformation is not valid.
+// The PGP public keys are encrypted if they are in plain text
+func (v *BaseVirtualFolder) ValidateTransform() error {
+	if !v.Transform.IsEnabled() {
+		v.Transform = FolderTransform{}
+		return nil
+	}
+	if !v.Transform.PGPPublicKeys.IsValidInput() {
+		return util.NewValidationError("invalid PGP public keys")
+	}
+	if v.Extraction.IsEnabled() {
+		return util.NewValidationError("archives extraction is not supported for folders with PGP encryption")
+	}
+	if !v.Transform.PGPPublicKeys.IsPlain() {
+		return nil
+	}
+	if _, err := parsePGPPublicKeys(v.Transform.PGPPublicKeys.GetPayload()); err != nil {
+		return util.NewValidationError(err.Error())
+	}
+	v.Transform.PGPPublicKeys.SetAdditionalData(v.GetEncryptionAdditionalData())
+	if err := v.Transform.PGPPublicKeys.Encrypt(); err != nil {
+		return util.NewValidationError(fmt.Sprintf("could not encrypt the PGP public keys: %v", err))
+	}
+	return nil
+}
+
+// ValidateSetstatMode returns an error if the setstat mode is not valid
+func (v *BaseVirtualFolder) ValidateSetstatMode() error {
+	switch v.SetstatMode {
+	case FolderSetstatModeDefault:
+		return nil
+	case FolderSetstatModeRecord:
+		switch v.FsConfig.Provider {
+		case sdk.S3FilesystemProvider, sdk.GCSFilesystemProvider, sdk.AzureBlobFilesystemProvider:
+			return nil
+		default:
+			return util.NewValidationError("the record setstat mode is only supported for cloud storage backends")
+		}
+	default:
+		return util.NewValidationError(fmt.Sprintf("invalid setstat mode: %d", v.SetstatMode))
+	}
+}
+
+// HasPathPlaceholder returns true if the folder has a path placeholder
+func (v *BaseVirtualFolder) HasPathPlaceholder() bool {
 	placeholder := "%username%"
 	switch v.FsConfig.Provider {
 	case sdk.S3FilesystemProvider:
@@ -136,10 +424,43 @@ type VirtualFolder struct {
 	QuotaSize int64 `json:"quota_size"`
 	// Maximum number of files allowed. 0 means unlimited, -1 included in user quota
 	QuotaFiles int `json:"quota_files"`
+	// Permissions allowed for the paths inside this mount point, they are intersected
+	// with the user permissions. Empty means no restrictions
+	Permissions []string `json:"permissions,omitempty"`
 }
 
-// GetFilesystem returns the filesystem for this folder
+// GetFilesystem returns the filesystem for this folder.
+// The uploads are encrypted if the folder has the PGP transformation
 func (v *VirtualFolder) GetFilesystem(connectionID string, forbiddenSelfUsers []string) (Fs, error) {
+	fs, err := v.getBaseFilesystem(connectionID, forbiddenSelfUsers)
+	if err != nil {
+		return fs, err
+	}
+	if v.SetstatMode == FolderSetstatModeRecord {
+		attrsFs, err := newAttrsFs(fs)
+		if err != nil {
+			fs.Close()
+			return nil, err
+		}
+		fs = attrsFs
+	}
+	if !v.Transform.IsEnabled() {
+		return fs, nil
+	}
+	keys := v.Transform.PGPPublicKeys.Clone()
+	if err := keys.TryDecrypt(); err != nil {
+		fs.Close()
+		return nil, fmt.Errorf("unable to decrypt the PGP public keys: %w", err)
+	}
+	pgpFs, err := newPGPFs(fs, keys.GetPayload(), v.Transform.PGPKeepName)
+	if err != nil {
+		fs.Close()
+		return nil, err
+	}
+	return pgpFs, nil
+}
+
+func (v *VirtualFolder) getBaseFilesystem(connectionID string, forbiddenSelfUsers []string) (Fs, error) {
 	switch v.FsConfig.Provider {
 	case sdk.S3FilesystemProvider:
 		return NewS3Fs(connectionID, v.MappedPath, v.VirtualPath, v.FsConfig.S3Config)
@@ -160,7 +481,7 @@ func (v *VirtualFolder) GetFilesystem(connectionID string, forbiddenSelfUsers []
 
 // ScanQuota scans the folder and returns the number of files and their size
 func (v *VirtualFolder) ScanQuota() (int, int64, error) {
-	if v.hasPathPlaceholder() {
+	if v.HasPathPlaceholder() {
 		return 0, 0, errors.New("cannot scan quota: this folder has a path placeholder")
 	}
 	fs, err := v.GetFilesystem(xid.New().String(), nil)
@@ -192,5 +513,6 @@ func (v *VirtualFolder) GetACopy() VirtualFolder {
 		VirtualPath:       v.VirtualPath,
 		QuotaSize:         v.QuotaSize,
 		QuotaFiles:        v.QuotaFiles,
+		Permissions:       slices.Clone(v.Permissions),
 	}
 }
@@ -33,9 +33,11 @@ import (
 	"cloud.google.com/go/storage"
 	"github.com/pkg/sftp"
 	"github.com/rs/xid"
+	"golang.org/x/oauth2"
 	"google.golang.org/api/googleapi"
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
 
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/metric"
@@ -43,10 +45,6 @@ import (
 	"github.com/drakkan/sftpgo/v2/internal/version"
 )
 
-const (
-	defaultGCSPageSize = 5000
-)
-
 var (
 	gcsDefaultFieldsSelection = []string{"Name", "Size", "Deleted", "Updated", "ContentType", "Metadata"}
 )
@@ -61,6 +59,8 @@ type GCSFs struct {
 	svc            *storage.Client
 	ctxTimeout     time.Duration
 	ctxLongTimeout time.Duration
+	listingLimit
+	cancelSignal *cancelSignal
 }
 
 func init() {
@@ -81,6 +81,7 @@ func NewGCSFs(connectionID, localTempDir, mountPath string, config GCSFsConfig)
 		config:         &config,
 		ctxTimeout:     30 * time.Second,
 		ctxLongTimeout: 300 * time.Second,
+		cancelSignal:   newCancelSignal(),
 	}
 	if err = fs.config.validate(); err != nil {
 		return fs, err
@@ -88,25 +89,42 @@ func NewGCSFs(connectionID, localTempDir, mountPath string, config GCSFsConfig)
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	if fs.config.AutomaticCredentials > 0 {
-		fs.svc, err = storage.NewClient(ctx,
-			storage.WithJSONReads(),
-			option.WithUserAgent(version.GetVersionHash()),
-		)
-	} else {
+	opts := []option.ClientOption{
+		option.WithUserAgent(version.GetVersionHash()),
+	}
+	if fs.config.AutomaticCredentials == 0 {
 		err = fs.config.Credentials.TryDecrypt()
 		if err != nil {
 			return fs, err
 		}
-		fs.svc, err = storage.NewClient(ctx,
-			storage.WithJSONReads(),
-			option.WithUserAgent(version.GetVersionHash()),
-			option.WithCredentialsJSON([]byte(fs.config.Credentials.GetPayload())),
-		)
+		opts = append(opts, option.WithCredentialsJSON([]byte(fs.config.Credentials.GetPayload())))
 	}
+	if transport := getOutboundHTTPTransport(fs.config.OutboundBindAddress); transport != nil {
+		opts, err = getGCSBoundClientOptions(transport, opts)
+		if err != nil {
+			return fs, err
+		}
+	}
+	fs.svc, err = storage.NewClient(ctx, append([]option.ClientOption{storage.WithJSONReads()}, opts...)...)
 	return fs, err
 }
 
+// getGCSBoundClientOptions returns the client options to use an HTTP client
+// bound to a local address. A custom HTTP client is used as is by the storage
+// client, so the authentication is added here. The token requests use the
+// same transport
+func getGCSBoundClientOptions(transport *http.Transport, opts []option.ClientOption) ([]option.ClientOption, error) {
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{Transport: transport})
+	opts = append(opts, option.WithScopes(storage.ScopeFullControl, "https://www.googleapis.com/auth/cloud-platform"))
+	rt, err := htransport.NewTransport(ctx, transport, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create the GCS transport: %w", err)
+	}
+	return []option.ClientOption{
+		option.WithHTTPClient(&http.Client{Transport: rt}),
+	}, nil
+}
+
 // Name returns the name for the Fs implementation
 func (fs *GCSFs) Name() string {
 	return fmt.Sprintf("%s bucket %q", gcsfsName, fs.config.Bucket)
@@ -177,7 +195,8 @@ func (fs *GCSFs) Open(name string, offset int64) (File, PipeReader, func(), erro
 
 // Create creates or opens the named file for writing
 func (fs *GCSFs) Create(name string, flag, checks int) (File, PipeWriter, func(), error) {
-	if checks&CheckParentDir != 0 {
+	// for atomic uploads the parent dir is checked when the temporary object is moved
+	if checks&CheckParentDir != 0 && !isCloudAtomicUploadKey(fs.config.KeyPrefix, name) {
 		_, err := fs.Stat(path.Dir(name))
 		if err != nil {
 			return nil, nil, nil, err
@@ -235,12 +254,16 @@ func (fs *GCSFs) Create(name string, flag, checks int) (File, PipeWriter, func()
 	if fs.config.UploadPartMaxTime > 0 {
 		objectWriter.ChunkRetryDeadline = time.Duration(fs.config.UploadPartMaxTime) * time.Second
 	}
-	fs.setWriterAttrs(objectWriter, flag, name)
+	sniff := fs.setWriterAttrs(objectWriter, flag, name)
 
 	go func() {
 		defer cancelFn()
 
-		n, err := io.Copy(objectWriter, r)
+		var reader io.Reader = r
+		if sniff {
+			objectWriter.ContentType, reader = sniffContentType(r)
+		}
+		n, err := io.Copy(objectWriter, reader)
 		closeErr := objectWriter.Close()
 		if err == nil {
 			err = closeErr
@@ -252,8 +275,8 @@ func (fs *GCSFs) Create(name string, flag, checks int) (File, PipeWriter, func()
 		}
 		r.CloseWithError(err) //nolint:errcheck
 		p.Done(err)
-		fsLog(fs, logger.LevelDebug, "upload completed, path: %q, acl: %q, readed bytes: %v, err: %+v",
-			name, fs.config.ACL, n, err)
+		fsLog(fs, logger.LevelDebug, "upload completed, path: %q, acl: %q, content type: %q, kms key name: %q, "+
+			"readed bytes: %v, err: %+v", name, fs.config.ACL, objectWriter.ContentType, fs.config.KMSKeyName, n, err)
 		metric.GCSTransferCompleted(n, 0, err)
 	}()
 
@@ -319,6 +342,12 @@ func (fs *GCSFs) Remove(name string, isDir bool) error {
 	return err
 }
 
+// RemoveFiles implements the FsBatchRemover interface, the files are removed
+// in parallel
+func (fs *GCSFs) RemoveFiles(names []string) ([]string, error) {
+	return removeFilesParallel(fs.cancelSignal.context(), fs, names)
+}
+
 // Mkdir creates a new directory with the specified name and default permissions
 func (fs *GCSFs) Mkdir(name string) error {
 	_, err := fs.Stat(name)
@@ -377,6 +406,42 @@ func (fs *GCSFs) Chtimes(name string, _, mtime time.Time, isUploading bool) erro
 	return err
 }
 
+func (fs *GCSFs) getObjectMetadata(name string) (map[string]string, error) {
+	attrs, err := fs.headObject(name)
+	if err != nil {
+		return nil, err
+	}
+	if attrs.Metadata == nil {
+		return map[string]string{}, nil
+	}
+	return attrs.Metadata, nil
+}
+
+// SetMetadata adds the specified metadata to the named file
+func (fs *GCSFs) SetMetadata(name string, metadata map[string]string) error {
+	obj := fs.svc.Bucket(fs.config.Bucket).Object(name)
+	attrs, err := fs.headObject(name)
+	if err != nil {
+		return err
+	}
+	obj = obj.If(storage.Conditions{MetagenerationMatch: attrs.Metageneration})
+
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	objMetadata := attrs.Metadata
+	if objMetadata == nil {
+		objMetadata = make(map[string]string)
+	}
+	for k, v := range metadata {
+		objMetadata[k] = v
+	}
+	_, err = obj.Update(ctx, storage.ObjectAttrsToUpdate{
+		Metadata: objMetadata,
+	})
+	return err
+}
+
 // Truncate changes the size of the named file.
 // Truncate by path is not supported, while truncating an opened
 // file is handled inside base transfer
@@ -396,13 +461,15 @@ func (fs *GCSFs) ReadDir(dirname string) (DirLister, error) {
 	}
 	bkt := fs.svc.Bucket(fs.config.Bucket)
 
-	return &gcsDirLister{
-		bucket:   bkt,
-		query:    query,
-		timeout:  fs.ctxTimeout,
-		prefix:   prefix,
-		prefixes: make(map[string]bool),
-	}, nil
+	return fs.limitDirLister(fs, dirname, &gcsDirLister{
+		bucket:    bkt,
+		query:     query,
+		ctx:       fs.cancelSignal.context(),
+		timeout:   fs.ctxTimeout,
+		prefix:    prefix,
+		prefixes:  make(map[string]bool),
+		keyPrefix: fs.config.KeyPrefix,
+	}), nil
 }
 
 // IsUploadResumeSupported returns true if resuming uploads is supported.
@@ -418,10 +485,12 @@ func (*GCSFs) IsConditionalUploadResumeSupported(_ int64) bool {
 }
 
 // IsAtomicUploadSupported returns true if atomic upload is supported.
-// S3 uploads are already atomic, we don't need to upload to a temporary
-// file
-func (*GCSFs) IsAtomicUploadSupported() bool {
-	return false
+// GCS uploads are already atomic, but the objects are visible as soon as the
+// upload completes. If the cloud atomic uploads are enabled the files are
+// uploaded to a temporary object and moved to the requested path after the
+// post-upload checks
+func (fs *GCSFs) IsAtomicUploadSupported() bool {
+	return HasCloudAtomicUploads(fs)
 }
 
 // IsNotExist returns a boolean indicating whether the error is known to
@@ -448,6 +517,9 @@ func (*GCSFs) IsPermission(err error) bool {
 	if err == nil {
 		return false
 	}
+	if errors.Is(err, os.ErrPermission) {
+		return true
+	}
 	var apiErr *googleapi.Error
 	if errors.As(err, &apiErr) {
 		if apiErr.Code == http.StatusForbidden || apiErr.Code == http.StatusUnauthorized {
@@ -492,12 +564,12 @@ func (fs *GCSFs) GetDirSize(dirname string) (int, int64, error) {
 	}
 
 	iteratePage := func(nextPageToken string) (string, error) {
-		ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+		ctx, cancelFn := context.WithDeadline(fs.cancelSignal.context(), time.Now().Add(fs.ctxTimeout))
 		defer cancelFn()
 
 		bkt := fs.svc.Bucket(fs.config.Bucket)
 		it := bkt.Objects(ctx, query)
-		pager := iterator.NewPager(it, defaultGCSPageSize, nextPageToken)
+		pager := iterator.NewPager(it, int(getListingPageSize()), nextPageToken)
 
 		var objects []*storage.ObjectAttrs
 		pageToken, err := pager.NextPage(&objects)
@@ -509,7 +581,7 @@ func (fs *GCSFs) GetDirSize(dirname string) (int, int64, error) {
 				continue
 			}
 			isDir := strings.HasSuffix(attrs.Name, "/") || attrs.ContentType == dirMimeType
-			if isDir && attrs.Size == 0 {
+			if (isDir && attrs.Size == 0) || isCloudAtomicUploadKey(fs.config.KeyPrefix, attrs.Name) {
 				continue
 			}
 			numFiles++
@@ -536,9 +608,9 @@ func (fs *GCSFs) GetDirSize(dirname string) (int, int64, error) {
 }
 
 // GetAtomicUploadPath returns the path to use for an atomic upload.
-// GCS uploads are already atomic, we never call this method for GCS
-func (*GCSFs) GetAtomicUploadPath(_ string) string {
-	return ""
+// This is a temporary object inside the configured atomic upload prefix
+func (fs *GCSFs) GetAtomicUploadPath(name string) string {
+	return getCloudAtomicUploadPath(fs.config.KeyPrefix, name)
 }
 
 // GetRelativePath returns the path for a file relative to the user's home dir.
@@ -576,12 +648,12 @@ func (fs *GCSFs) Walk(root string, walkFn filepath.WalkFunc) error {
 	}
 
 	iteratePage := func(nextPageToken string) (string, error) {
-		ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+		ctx, cancelFn := context.WithDeadline(fs.cancelSignal.context(), time.Now().Add(fs.ctxTimeout))
 		defer cancelFn()
 
 		bkt := fs.svc.Bucket(fs.config.Bucket)
 		it := bkt.Objects(ctx, query)
-		pager := iterator.NewPager(it, defaultGCSPageSize, nextPageToken)
+		pager := iterator.NewPager(it, int(getListingPageSize()), nextPageToken)
 
 		var objects []*storage.ObjectAttrs
 		pageToken, err := pager.NextPage(&objects)
@@ -645,7 +717,12 @@ func (fs *GCSFs) ResolvePath(virtualPath string) (string, error) {
 	if !path.IsAbs(virtualPath) {
 		virtualPath = path.Clean("/" + virtualPath)
 	}
-	return fs.Join(fs.config.KeyPrefix, strings.TrimPrefix(virtualPath, "/")), nil
+	name := fs.Join(fs.config.KeyPrefix, strings.TrimPrefix(virtualPath, "/"))
+	if isCloudAtomicUploadKey(fs.config.KeyPrefix, name) {
+		// the temporary objects of the atomic uploads are not accessible by clients
+		return "", os.ErrPermission
+	}
+	return name, nil
 }
 
 // CopyFile implements the FsFileCopier interface
@@ -695,6 +772,7 @@ func (fs *GCSFs) getObjectStat(name string) (os.FileInfo, error) {
 		info := NewFileInfo(name, isDir, objSize, objectModTime, false)
 		if !isDir {
 			info.setMetadata(attrs.Metadata)
+			info.setUploadTime(attrs.Created)
 		}
 		return info, nil
 	}
@@ -721,12 +799,15 @@ func (fs *GCSFs) getObjectStat(name string) (os.FileInfo, error) {
 	return NewFileInfo(name, true, attrs.Size, objectModTime, false), nil
 }
 
-func (fs *GCSFs) setWriterAttrs(objectWriter *storage.Writer, flag int, name string) {
+// setWriterAttrs sets the attributes for the object writer and returns true
+// if the content type must be detected from the file contents
+func (fs *GCSFs) setWriterAttrs(objectWriter *storage.Writer, flag int, name string) bool {
 	var contentType string
+	var sniff bool
 	if flag == -1 {
 		contentType = dirMimeType
 	} else {
-		contentType = mime.TypeByExtension(path.Ext(name))
+		contentType, sniff = getUploadContentType(name, fs.config.ContentTypes)
 	}
 	if contentType != "" {
 		objectWriter.ContentType = contentType
@@ -737,6 +818,9 @@ func (fs *GCSFs) setWriterAttrs(objectWriter *storage.Writer, flag int, name str
 	if fs.config.ACL != "" {
 		objectWriter.PredefinedACL = fs.config.ACL
 	}
+	objectWriter.KMSKeyName = fs.config.KMSKeyName
+	objectWriter.Metadata = getGCSObjectMetadata(fs.config.ObjectMetadata, nil)
+	return sniff
 }
 
 func (fs *GCSFs) composeObjects(ctx context.Context, dst, partialObject *storage.ObjectHandle) error {
@@ -749,6 +833,8 @@ func (fs *GCSFs) composeObjects(ctx context.Context, dst, partialObject *storage
 	if fs.config.ACL != "" {
 		composer.PredefinedACL = fs.config.ACL
 	}
+	composer.KMSKeyName = fs.config.KMSKeyName
+	composer.Metadata = getGCSObjectMetadata(fs.config.ObjectMetadata, nil)
 	contentType := mime.TypeByExtension(path.Ext(dst.ObjectName()))
 	if contentType != "" {
 		composer.ContentType = contentType
@@ -785,7 +871,7 @@ func (fs *GCSFs) copyFileInternal(source, target string, conditions *storage.Con
 		}
 	}
 
-	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxLongTimeout))
+	ctx, cancelFn := context.WithDeadline(fs.cancelSignal.context(), time.Now().Add(fs.ctxLongTimeout))
 	defer cancelFn()
 
 	copier := dst.CopierFrom(src)
@@ -795,6 +881,7 @@ func (fs *GCSFs) copyFileInternal(source, target string, conditions *storage.Con
 	if fs.config.ACL != "" {
 		copier.PredefinedACL = fs.config.ACL
 	}
+	copier.DestinationKMSKeyName = fs.config.KMSKeyName
 	contentType := mime.TypeByExtension(path.Ext(source))
 	if contentType != "" {
 		copier.ContentType = contentType
@@ -803,6 +890,7 @@ func (fs *GCSFs) copyFileInternal(source, target string, conditions *storage.Con
 	if updateModTime && len(metadata) > 0 {
 		delete(metadata, lastModifiedField)
 	}
+	metadata = getGCSObjectMetadata(fs.config.ObjectMetadata, metadata)
 	if len(metadata) > 0 {
 		copier.Metadata = metadata
 	}
@@ -831,7 +919,10 @@ func (fs *GCSFs) renameInternal(source, target string, srcInfo os.FileInfo, recu
 			return numFiles, filesSize, err
 		}
 		if renameMode == 1 {
-			files, size, err := doRecursiveRename(fs, source, target, fs.renameInternal, recursion, updateModTime)
+			files, size, err := doRecursiveRename(fs.cancelSignal.context(), fs, source, target, fs.renameInternal,
+				func(source, target string, srcInfo os.FileInfo, updateModTime bool) error {
+					return fs.copyFileInternal(source, target, nil, srcInfo, updateModTime)
+				}, recursion, updateModTime)
 			numFiles += files
 			filesSize += size
 			if err != nil {
@@ -871,7 +962,7 @@ func (fs *GCSFs) hasContents(name string) (bool, error) {
 	if err != nil {
 		return result, err
 	}
-	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	ctx, cancelFn := context.WithDeadline(fs.cancelSignal.context(), time.Now().Add(fs.ctxTimeout))
 	defer cancelFn()
 
 	bkt := fs.svc.Bucket(fs.config.Bucket)
@@ -931,8 +1022,15 @@ func (fs *GCSFs) GetMimeType(name string) (string, error) {
 	return attrs.ContentType, nil
 }
 
-// Close closes the fs
+// CancelOperations implements the FsOperationsCanceler interface
+func (fs *GCSFs) CancelOperations() {
+	fs.cancelSignal.cancel()
+}
+
+// Close closes the fs, the running listings, copies and recursive deletes
+// are canceled
 func (fs *GCSFs) Close() error {
+	fs.cancelSignal.cancel()
 	return nil
 }
 
@@ -951,12 +1049,14 @@ type gcsDirLister struct {
 	baseDirLister
 	bucket        *storage.BucketHandle
 	query         *storage.Query
+	ctx           context.Context
 	timeout       time.Duration
 	nextPageToken string
 	noMorePages   bool
 	prefix        string
 	prefixes      map[string]bool
 	metricUpdated bool
+	keyPrefix     string
 }
 
 func (l *gcsDirLister) resolve(name, contentType string) (string, bool) {
@@ -987,11 +1087,11 @@ func (l *gcsDirLister) Next(limit int) ([]os.FileInfo, error) {
 		return l.returnFromCache(limit), io.EOF
 	}
 
-	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(l.timeout))
+	ctx, cancelFn := context.WithDeadline(l.ctx, time.Now().Add(l.timeout))
 	defer cancelFn()
 
 	it := l.bucket.Objects(ctx, l.query)
-	paginator := iterator.NewPager(it, defaultGCSPageSize, l.nextPageToken)
+	paginator := iterator.NewPager(it, int(getListingPageSize()), l.nextPageToken)
 	var objects []*storage.ObjectAttrs
 
 	pageToken, err := paginator.NextPage(&objects)
@@ -1003,7 +1103,7 @@ func (l *gcsDirLister) Next(limit int) ([]os.FileInfo, error) {
 	for _, attrs := range objects {
 		if attrs.Prefix != "" {
 			name, _ := l.resolve(attrs.Prefix, attrs.ContentType)
-			if name == "" {
+			if name == "" || isCloudAtomicUploadKey(l.keyPrefix, attrs.Prefix) {
 				continue
 			}
 			if _, ok := l.prefixes[name]; ok {
@@ -1032,6 +1132,7 @@ func (l *gcsDirLister) Next(limit int) ([]os.FileInfo, error) {
 			}
 			info := NewFileInfo(name, isDir, attrs.Size, modTime, false)
 			info.setMetadata(attrs.Metadata)
+			info.setUploadTime(attrs.Created)
 			l.cache = append(l.cache, info)
 		}
 	}
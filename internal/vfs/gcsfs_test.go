@@ -0,0 +1,72 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !nogcs
+
+package vfs
+
+import (
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGCSObjectMetadata(t *testing.T) {
+	static := map[string]string{
+		"cost-center": "sftp",
+		"team":        "storage",
+	}
+	assert.Nil(t, getGCSObjectMetadata(nil, nil))
+	metadata := map[string]string{
+		lastModifiedField: "1000",
+		"team":            "other",
+	}
+	assert.Equal(t, metadata, getGCSObjectMetadata(nil, metadata))
+	result := getGCSObjectMetadata(static, metadata)
+	assert.Equal(t, map[string]string{
+		lastModifiedField: "1000",
+		"cost-center":     "sftp",
+		"team":            "storage",
+	}, result)
+	// the source maps are not modified
+	assert.Equal(t, "other", metadata["team"])
+	assert.Len(t, static, 2)
+	assert.Equal(t, static, getGCSObjectMetadata(static, nil))
+
+	fs := &GCSFs{
+		config: &GCSFsConfig{
+			KMSKeyName:     "projects/p/locations/us/keyRings/r/cryptoKeys/k",
+			ObjectMetadata: static,
+		},
+	}
+	w := &storage.Writer{}
+	fs.setWriterAttrs(w, 0, "file.txt")
+	assert.Equal(t, fs.config.KMSKeyName, w.KMSKeyName)
+	assert.Equal(t, static, w.Metadata)
+	w = &storage.Writer{}
+	fs.setWriterAttrs(w, -1, "dir")
+	assert.Equal(t, fs.config.KMSKeyName, w.KMSKeyName)
+	assert.Equal(t, dirMimeType, w.ContentType)
+
+	config := GCSFsConfig{
+		KMSKeyName: " projects/p/locations/us/keyRings/r/cryptoKeys/k ",
+	}
+	assert.NoError(t, config.validateKMSKeyName())
+	assert.Equal(t, "projects/p/locations/us/keyRings/r/cryptoKeys/k", config.KMSKeyName)
+	config.KMSKeyName = "projects/p/locations/us/keyRings//cryptoKeys/k"
+	assert.Error(t, config.validateKMSKeyName())
+	assert.NoError(t, validateGCSObjectMetadata(static))
+	assert.Error(t, validateGCSObjectMetadata(map[string]string{"": "value"}))
+}
@@ -0,0 +1,80 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// max size for the custom metadata of a GCS object, keys and values included
+const gcsMaxObjectMetadataSize = 8 * 1024
+
+var (
+	gcsKMSKeyNameRegex  = regexp.MustCompile(`^projects/[^/\s]+/locations/[^/\s]+/keyRings/[^/\s]+/cryptoKeys/[^/\s]+$`)
+	gcsMetadataKeyRegex = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+)
+
+// validateKMSKeyName returns an error if the KMS key name, if set, is not a
+// Cloud KMS key resource path
+func (c *GCSFsConfig) validateKMSKeyName() error {
+	c.KMSKeyName = strings.TrimSpace(c.KMSKeyName)
+	if c.KMSKeyName == "" {
+		return nil
+	}
+	if !gcsKMSKeyNameRegex.MatchString(c.KMSKeyName) {
+		return fmt.Errorf("invalid KMS key name %q, the expected format is "+
+			"\"projects/<project>/locations/<location>/keyRings/<key ring>/cryptoKeys/<key>\"", c.KMSKeyName)
+	}
+	return nil
+}
+
+func validateGCSObjectMetadata(metadata map[string]string) error {
+	size := 0
+	for k, v := range metadata {
+		if !gcsMetadataKeyRegex.MatchString(k) {
+			return fmt.Errorf("object metadata: invalid key %q, only letters, digits, \"_\", \".\" and \"-\" are allowed", k)
+		}
+		if strings.EqualFold(k, lastModifiedField) {
+			return fmt.Errorf("object metadata: the key %q is reserved", k)
+		}
+		if strings.ContainsFunc(v, unicode.IsControl) {
+			return fmt.Errorf("object metadata: the value for key %q contains invalid characters", k)
+		}
+		size += len(k) + len(v)
+	}
+	if size > gcsMaxObjectMetadataSize {
+		return errors.New("object metadata: the total size cannot exceed 8 KB")
+	}
+	return nil
+}
+
+// getGCSObjectMetadata returns the metadata for an object write, the static
+// metadata is added to the object specific one and takes precedence
+func getGCSObjectMetadata(static, metadata map[string]string) map[string]string {
+	if len(static) == 0 {
+		return metadata
+	}
+	result := maps.Clone(metadata)
+	if result == nil {
+		result = make(map[string]string)
+	}
+	maps.Copy(result, static)
+	return result
+}
@@ -56,6 +56,31 @@ type HTTPFsConfig struct {
 	sdk.BaseHTTPFsConfig
 	Password *kms.Secret `json:"password,omitempty"`
 	APIKey   *kms.Secret `json:"api_key,omitempty"`
+	// Proxy URL for the connections to the HTTP endpoint. Empty means the
+	// default outbound proxy, if any, "direct" disables the proxy
+	ProxyURL      string      `json:"proxy_url,omitempty"`
+	ProxyUsername string      `json:"proxy_username,omitempty"`
+	ProxyPassword *kms.Secret `json:"proxy_password,omitempty"`
+	// Local IP address for the connections to the HTTP endpoint, ignored for
+	// unix domain sockets. Empty means the global setting, if any
+	OutboundBindAddress string `json:"outbound_bind_address,omitempty"`
+	// Maximum number of attempts for the requests failed with a connection
+	// error or a 5xx status code. Only the read requests, such as stat, list
+	// and download, are retried unless RetryIdempotentWrites is set.
+	// Interrupted downloads are resumed from the last received byte.
+	// 0 or 1 means no retries
+	RetryMaxAttempts int `json:"retry_max_attempts,omitempty"`
+	// Initial delay, as milliseconds, between the attempts. It doubles for
+	// each attempt and a random jitter is added. 0 means the default (200)
+	RetryBaseDelay int `json:"retry_base_delay,omitempty"`
+	// If set the backend API is idempotent and the write requests, uploads
+	// excluded, are retried too
+	RetryIdempotentWrites bool `json:"retry_idempotent_writes,omitempty"`
+	// Number of consecutive failed requests after which the requests to the
+	// endpoint fail fast until the cool down period expires. 0 means disabled
+	CircuitBreakerThreshold int `json:"circuit_breaker_threshold,omitempty"`
+	// Cool down period, as seconds. 0 means the default (30)
+	CircuitBreakerCooldown int `json:"circuit_breaker_cooldown,omitempty"`
 }
 
 func (c *HTTPFsConfig) isUnixDomainSocket() bool {
@@ -70,6 +95,9 @@ func (c *HTTPFsConfig) HideConfidentialData() {
 	if c.APIKey != nil {
 		c.APIKey.Hide()
 	}
+	if c.ProxyPassword != nil {
+		c.ProxyPassword.Hide()
+	}
 }
 
 func (c *HTTPFsConfig) setNilSecretsIfEmpty() {
@@ -79,6 +107,9 @@ func (c *HTTPFsConfig) setNilSecretsIfEmpty() {
 	if c.APIKey != nil && c.APIKey.IsEmpty() {
 		c.APIKey = nil
 	}
+	if c.ProxyPassword != nil && c.ProxyPassword.IsEmpty() {
+		c.ProxyPassword = nil
+	}
 }
 
 func (c *HTTPFsConfig) setEmptyCredentialsIfNil() {
@@ -88,6 +119,9 @@ func (c *HTTPFsConfig) setEmptyCredentialsIfNil() {
 	if c.APIKey == nil {
 		c.APIKey = kms.NewEmptySecret()
 	}
+	if c.ProxyPassword == nil {
+		c.ProxyPassword = kms.NewEmptySecret()
+	}
 }
 
 func (c *HTTPFsConfig) isEqual(other HTTPFsConfig) bool {
@@ -100,11 +134,28 @@ func (c *HTTPFsConfig) isEqual(other HTTPFsConfig) bool {
 	if c.SkipTLSVerify != other.SkipTLSVerify {
 		return false
 	}
+	if c.ProxyURL != other.ProxyURL || c.ProxyUsername != other.ProxyUsername {
+		return false
+	}
+	if c.OutboundBindAddress != other.OutboundBindAddress {
+		return false
+	}
+	if c.RetryMaxAttempts != other.RetryMaxAttempts || c.RetryBaseDelay != other.RetryBaseDelay ||
+		c.RetryIdempotentWrites != other.RetryIdempotentWrites {
+		return false
+	}
+	if c.CircuitBreakerThreshold != other.CircuitBreakerThreshold ||
+		c.CircuitBreakerCooldown != other.CircuitBreakerCooldown {
+		return false
+	}
 	c.setEmptyCredentialsIfNil()
 	other.setEmptyCredentialsIfNil()
 	if !c.Password.IsEqual(other.Password) {
 		return false
 	}
+	if !c.ProxyPassword.IsEqual(other.ProxyPassword) {
+		return false
+	}
 	return c.APIKey.IsEqual(other.APIKey)
 }
 
@@ -158,7 +209,18 @@ func (c *HTTPFsConfig) validate() error {
 	if !c.APIKey.IsEmpty() && !c.APIKey.IsValidInput() {
 		return errors.New("httpfs: invalid API key")
 	}
-	return nil
+	c.ProxyURL = strings.TrimSpace(c.ProxyURL)
+	if c.ProxyURL != "" && c.ProxyURL != ProxyURLDirect && endpointURL.Host == "unix" {
+		return errors.New("httpfs: a proxy cannot be used for unix domain sockets")
+	}
+	if err := validateProxySettings(c.ProxyURL, c.ProxyUsername, c.ProxyPassword); err != nil {
+		return fmt.Errorf("httpfs: %w", err)
+	}
+	c.OutboundBindAddress = strings.TrimSpace(c.OutboundBindAddress)
+	if err := validateOutboundBindAddress(c.OutboundBindAddress); err != nil {
+		return fmt.Errorf("httpfs: %w", err)
+	}
+	return c.validateRetrySettings()
 }
 
 // ValidateAndEncryptCredentials validates the config and encrypts credentials if they are in plain text
@@ -190,6 +252,15 @@ func (c *HTTPFsConfig) ValidateAndEncryptCredentials(additionalData string) erro
 			)
 		}
 	}
+	if c.ProxyPassword.IsPlain() {
+		c.ProxyPassword.SetAdditionalData(additionalData)
+		if err := c.ProxyPassword.Encrypt(); err != nil {
+			return util.NewI18nError(
+				util.NewValidationError(fmt.Sprintf("could not encrypt HTTP fs proxy password: %v", err)),
+				util.I18nErrorFsValidation,
+			)
+		}
+	}
 	return nil
 }
 
@@ -202,6 +273,9 @@ type HTTPFs struct {
 	config     *HTTPFsConfig
 	client     *http.Client
 	ctxTimeout time.Duration
+	// nil if the circuit breaker is disabled
+	breaker *httpFsCircuitBreaker
+	listingLimit
 }
 
 // NewHTTPFs returns an HTTPFs object that allows to interact with SFTPGo HTTP filesystem backends
@@ -220,6 +294,11 @@ func NewHTTPFs(connectionID, localTempDir, mountPath string, config HTTPFsConfig
 			return nil, err
 		}
 	}
+	if !config.ProxyPassword.IsEmpty() {
+		if err := config.ProxyPassword.TryDecrypt(); err != nil {
+			return nil, err
+		}
+	}
 	fs := &HTTPFs{
 		connectionID: connectionID,
 		localTempDir: localTempDir,
@@ -262,6 +341,8 @@ func NewHTTPFs(connectionID, localTempDir, mountPath string, config HTTPFsConfig
 			endpointURL.RawFragment = ""
 			fs.config.Endpoint = endpointURL.String()
 		}
+	} else if err := fs.setProxy(transport); err != nil {
+		return nil, err
 	}
 	if config.SkipTLSVerify {
 		if transport.TLSClientConfig != nil {
@@ -273,9 +354,32 @@ func NewHTTPFs(connectionID, localTempDir, mountPath string, config HTTPFsConfig
 	fs.client = &http.Client{
 		Transport: transport,
 	}
+	fs.breaker = httpFsBreakers.Get(fs.config)
 	return fs, nil
 }
 
+// setProxy configures the transport to connect through the configured proxy, if any.
+// The proxies defined using environment variables are used only if no proxy is configured.
+// The connections are bound to the configured local address, if any
+func (fs *HTTPFs) setProxy(transport *http.Transport) error {
+	if getLocalTCPAddr(fs.config.OutboundBindAddress) != nil {
+		transport.DialContext = newOutboundDialer(fs.config.OutboundBindAddress, 30*time.Second).DialContext
+	}
+	if fs.config.ProxyURL == ProxyURLDirect {
+		transport.Proxy = nil
+		return nil
+	}
+	p, err := getOutboundProxy(fs.config.ProxyURL, fs.config.ProxyUsername, fs.config.ProxyPassword)
+	if err != nil {
+		return err
+	}
+	if p != nil {
+		transport.Proxy = nil
+		transport.DialContext = p.withBindAddress(fs.config.OutboundBindAddress).DialContext
+	}
+	return nil
+}
+
 // Name returns the name for the Fs implementation
 func (fs *HTTPFs) Name() string {
 	return fmt.Sprintf("%v %q", httpFsName, fs.config.Endpoint)
@@ -323,23 +427,16 @@ func (fs *HTTPFs) Open(name string, offset int64) (File, PipeReader, func(), err
 	p := NewPipeReader(r)
 	ctx, cancelFn := context.WithCancel(context.Background())
 
-	var queryString string
-	if offset > 0 {
-		queryString = fmt.Sprintf("?offset=%d", offset)
-	}
-
 	go func() {
 		defer cancelFn()
 
-		resp, err := fs.sendHTTPRequest(ctx, http.MethodGet, "open", name, queryString, "", nil)
-		if err != nil {
+		n, err := fs.download(ctx, name, offset, w)
+		if err != nil && n == 0 {
 			fsLog(fs, logger.LevelError, "download error, path %q, err: %v", name, err)
 			w.CloseWithError(err) //nolint:errcheck
 			metric.HTTPFsTransferCompleted(0, 1, err)
 			return
 		}
-		defer resp.Body.Close()
-		n, err := io.Copy(w, resp.Body)
 		w.CloseWithError(err) //nolint:errcheck
 		fsLog(fs, logger.LevelDebug, "download completed, path %q size: %v, err: %+v", name, n, err)
 		metric.HTTPFsTransferCompleted(n, 1, err)
@@ -513,7 +610,7 @@ func (fs *HTTPFs) ReadDir(dirname string) (DirLister, error) {
 	for _, stat := range response {
 		result = append(result, stat.getFileInfo())
 	}
-	return &baseDirLister{result}, nil
+	return fs.limitDirLister(fs, dirname, &baseDirLister{result}), nil
 }
 
 // IsUploadResumeSupported returns true if resuming uploads is supported.
@@ -700,13 +797,89 @@ func (fs *HTTPFs) GetAvailableDiskSize(dirName string) (*sftp.StatVFS, error) {
 	return response.toSFTPStatVFS(), nil
 }
 
+// download writes the file contents, starting from the specified offset, to w.
+// If reading the response body fails, the download is resumed from the last
+// received byte using a new request, if retries are enabled
+func (fs *HTTPFs) download(ctx context.Context, name string, offset int64, w io.Writer) (int64, error) {
+	var written int64
+	maxAttempts := fs.config.getRetryMaxAttempts(http.MethodGet)
+
+	for attempt := 1; ; attempt++ {
+		var queryString string
+		if offset+written > 0 {
+			queryString = fmt.Sprintf("?offset=%d", offset+written)
+		}
+		resp, err := fs.sendHTTPRequest(ctx, http.MethodGet, "open", name, queryString, "", nil)
+		if err != nil {
+			return written, err
+		}
+		body := &httpFsResponseReader{reader: resp.Body}
+		n, err := io.Copy(w, body)
+		resp.Body.Close()
+		written += n
+		// errors writing to w are not retried
+		if err == nil || body.err == nil || attempt >= maxAttempts {
+			return written, err
+		}
+		fsLog(fs, logger.LevelWarn, "download interrupted, path %q, received bytes: %d, attempt %d/%d, err: %v",
+			name, written, attempt, maxAttempts, err)
+		if errWait := fs.waitRetry(ctx, "open", attempt); errWait != nil {
+			return written, err
+		}
+	}
+}
+
+// waitRetry waits before the next attempt, it returns an error if the
+// context is done while waiting
+func (fs *HTTPFs) waitRetry(ctx context.Context, base string, attempt int) error {
+	metric.HTTPFsRequestRetried(base)
+	timer := time.NewTimer(fs.config.getRetryDelay(attempt))
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
 func (fs *HTTPFs) sendHTTPRequest(ctx context.Context, method, base, name, queryString, contentType string,
 	body io.Reader,
 ) (*http.Response, error) {
+	// requests with a body, the uploads, are never retried
+	maxAttempts := 1
+	if body == nil {
+		maxAttempts = fs.config.getRetryMaxAttempts(method)
+	}
+	for attempt := 1; ; attempt++ {
+		resp, retryable, err := fs.doHTTPRequest(ctx, method, base, name, queryString, contentType, body)
+		if err == nil || !retryable || attempt >= maxAttempts {
+			return resp, err
+		}
+		fsLog(fs, logger.LevelWarn, "%s request failed, path %q, attempt %d/%d, err: %v",
+			base, name, attempt, maxAttempts, err)
+		if errWait := fs.waitRetry(ctx, base, attempt); errWait != nil {
+			return nil, err
+		}
+	}
+}
+
+// doHTTPRequest sends a single HTTP request. The returned bool is true if
+// the request failed with a connection error or a 5xx status code and so
+// it can be retried
+func (fs *HTTPFs) doHTTPRequest(ctx context.Context, method, base, name, queryString, contentType string,
+	body io.Reader,
+) (*http.Response, bool, error) {
 	url := fmt.Sprintf("%s/%s/%s%s", fs.config.Endpoint, base, url.PathEscape(name), queryString)
 	req, err := http.NewRequest(method, url, body)
 	if err != nil {
-		return nil, err
+		return nil, false, err
+	}
+	if fs.breaker != nil {
+		if err := fs.breaker.allow(); err != nil {
+			return nil, false, err
+		}
 	}
 	if contentType != "" {
 		req.Header.Set("Content-Type", contentType)
@@ -719,13 +892,27 @@ func (fs *HTTPFs) sendHTTPRequest(ctx context.Context, method, base, name, query
 	}
 	resp, err := fs.client.Do(req.WithContext(ctx))
 	if err != nil {
-		return nil, fmt.Errorf("unable to send HTTP request to URL %v: %w", url, err)
+		fs.updateCircuitBreaker(ctx, false)
+		return nil, ctx.Err() == nil, fmt.Errorf("unable to send HTTP request to URL %v: %w", url, err)
 	}
+	retryable := isRetryableStatusCode(resp.StatusCode)
+	fs.updateCircuitBreaker(ctx, !retryable)
 	if err = getErrorFromResponseCode(resp.StatusCode); err != nil {
 		resp.Body.Close()
-		return nil, err
+		return nil, retryable, err
 	}
-	return resp, nil
+	return resp, false, nil
+}
+
+func (fs *HTTPFs) updateCircuitBreaker(ctx context.Context, success bool) {
+	if fs.breaker == nil {
+		return
+	}
+	if errors.Is(ctx.Err(), context.Canceled) {
+		fs.breaker.onCanceled()
+		return
+	}
+	fs.breaker.onResult(success)
 }
 
 // walk recursively descends path, calling walkFn.
@@ -783,6 +970,20 @@ func getInsecureTLSConfig() *tls.Config {
 	}
 }
 
+// httpFsResponseReader records the errors reading an HTTP response body
+type httpFsResponseReader struct {
+	reader io.Reader
+	err    error
+}
+
+func (r *httpFsResponseReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
+
 type wrapReader struct {
 	reader io.Reader
 }
@@ -0,0 +1,222 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/metric"
+)
+
+const (
+	logSenderHTTPFsBreaker          = "httpfsBreaker"
+	maxHTTPFsRetryAttempts          = 10
+	maxHTTPFsRetryBaseDelay         = 10000
+	maxHTTPFsCircuitBreakerCooldown = 3600
+	defaultHTTPFsRetryBaseDelay     = 200 * time.Millisecond
+	maxHTTPFsRetryDelay             = 10 * time.Second
+	defaultHTTPFsBreakerCooldown    = 30 * time.Second
+)
+
+const (
+	circuitBreakerClosed   = "closed"
+	circuitBreakerOpen     = "open"
+	circuitBreakerHalfOpen = "half-open"
+)
+
+var (
+	// ErrHTTPFsCircuitOpen defines the error to return if the requests to an
+	// HTTPFs backend are rejected because the circuit breaker is open
+	ErrHTTPFsCircuitOpen = errors.New("httpfs: circuit breaker open, the backend is unavailable")
+	httpFsBreakers       = &httpFsCircuitBreakers{
+		items: make(map[string]*httpFsCircuitBreaker),
+	}
+)
+
+// isRetryableStatusCode returns true if the request failed with a 5xx
+// status code. 501 means not supported and so it is not retried
+func isRetryableStatusCode(code int) bool {
+	return code >= http.StatusInternalServerError && code != http.StatusNotImplemented
+}
+
+// getRetryDelay returns the delay before the specified retry attempt using
+// an exponential backoff with jitter
+func (c *HTTPFsConfig) getRetryDelay(attempt int) time.Duration {
+	delay := defaultHTTPFsRetryBaseDelay
+	if c.RetryBaseDelay > 0 {
+		delay = time.Duration(c.RetryBaseDelay) * time.Millisecond
+	}
+	for i := 1; i < attempt && delay < maxHTTPFsRetryDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, maxHTTPFsRetryDelay)
+	// half of the delay is fixed, the other half is random
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// getRetryMaxAttempts returns the maximum number of attempts for a request
+// with the specified method
+func (c *HTTPFsConfig) getRetryMaxAttempts(method string) int {
+	if c.RetryMaxAttempts <= 1 {
+		return 1
+	}
+	if method == http.MethodGet || c.RetryIdempotentWrites {
+		return c.RetryMaxAttempts
+	}
+	return 1
+}
+
+func (c *HTTPFsConfig) validateRetrySettings() error {
+	if c.RetryMaxAttempts < 0 || c.RetryMaxAttempts > maxHTTPFsRetryAttempts {
+		return fmt.Errorf("httpfs: invalid retry_max_attempts, valid range is 0-%d", maxHTTPFsRetryAttempts)
+	}
+	if c.RetryBaseDelay < 0 || c.RetryBaseDelay > maxHTTPFsRetryBaseDelay {
+		return fmt.Errorf("httpfs: invalid retry_base_delay, valid range is 0-%d", maxHTTPFsRetryBaseDelay)
+	}
+	if c.CircuitBreakerThreshold < 0 {
+		return errors.New("httpfs: invalid circuit_breaker_threshold")
+	}
+	if c.CircuitBreakerCooldown < 0 || c.CircuitBreakerCooldown > maxHTTPFsCircuitBreakerCooldown {
+		return fmt.Errorf("httpfs: invalid circuit_breaker_cooldown, valid range is 0-%d",
+			maxHTTPFsCircuitBreakerCooldown)
+	}
+	return nil
+}
+
+// httpFsCircuitBreaker rejects the requests to an HTTPFs backend after the
+// configured number of consecutive failures. After the cool down period a
+// single trial request is allowed: if it succeeds the breaker is closed
+// otherwise it is opened again
+type httpFsCircuitBreaker struct {
+	endpoint  string
+	threshold int
+	cooldown  time.Duration
+	mu        sync.Mutex
+	state     string
+	failures  int
+	openedAt  time.Time
+	// true if the trial request for the half-open state is in progress
+	trialInProgress bool
+}
+
+func (b *httpFsCircuitBreaker) setState(state string) {
+	if b.state == state {
+		return
+	}
+	logger.Info(logSenderHTTPFsBreaker, "", "circuit breaker for endpoint %q changed state from %q to %q, consecutive failures: %d",
+		b.endpoint, b.state, state, b.failures)
+	metric.HTTPFsCircuitBreakerStateChanged(b.state, state)
+	b.state = state
+}
+
+// allow returns an error if the request must be rejected
+func (b *httpFsCircuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case circuitBreakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			metric.HTTPFsCircuitBreakerRejected()
+			return ErrHTTPFsCircuitOpen
+		}
+		b.setState(circuitBreakerHalfOpen)
+		b.trialInProgress = true
+		return nil
+	case circuitBreakerHalfOpen:
+		if b.trialInProgress {
+			metric.HTTPFsCircuitBreakerRejected()
+			return ErrHTTPFsCircuitOpen
+		}
+		b.trialInProgress = true
+		return nil
+	default:
+		return nil
+	}
+}
+
+// onResult updates the breaker state after an allowed request
+func (b *httpFsCircuitBreaker) onResult(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.trialInProgress = false
+	if success {
+		b.failures = 0
+		b.setState(circuitBreakerClosed)
+		return
+	}
+	b.failures++
+	if b.state == circuitBreakerHalfOpen || b.failures >= b.threshold {
+		b.openedAt = time.Now()
+		b.setState(circuitBreakerOpen)
+	}
+}
+
+// onCanceled must be called if an allowed request is canceled by the client
+// and so the backend availability is unknown
+func (b *httpFsCircuitBreaker) onCanceled() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.trialInProgress = false
+}
+
+func (b *httpFsCircuitBreaker) getState() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state
+}
+
+// httpFsCircuitBreakers holds the circuit breakers, they are shared by all
+// the filesystems using the same endpoint and breaker settings
+type httpFsCircuitBreakers struct {
+	sync.Mutex
+	items map[string]*httpFsCircuitBreaker
+}
+
+// Get returns the circuit breaker for the specified config, nil if disabled
+func (c *httpFsCircuitBreakers) Get(config *HTTPFsConfig) *httpFsCircuitBreaker {
+	if config.CircuitBreakerThreshold <= 0 {
+		return nil
+	}
+	cooldown := defaultHTTPFsBreakerCooldown
+	if config.CircuitBreakerCooldown > 0 {
+		cooldown = time.Duration(config.CircuitBreakerCooldown) * time.Second
+	}
+	key := fmt.Sprintf("%s_%d_%s", config.Endpoint, config.CircuitBreakerThreshold, cooldown)
+
+	c.Lock()
+	defer c.Unlock()
+
+	if b, ok := c.items[key]; ok {
+		return b
+	}
+	b := &httpFsCircuitBreaker{
+		endpoint:  config.Endpoint,
+		threshold: config.CircuitBreakerThreshold,
+		cooldown:  cooldown,
+		state:     circuitBreakerClosed,
+	}
+	c.items[key] = b
+	return b
+}
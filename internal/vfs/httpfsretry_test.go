@@ -0,0 +1,295 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sftpgo/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testHTTPFsBackend struct {
+	sync.Mutex
+	failures map[string]int
+	requests map[string]int
+	status   int
+	content  []byte
+}
+
+func (b *testHTTPFsBackend) setFailures(base string, n int) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.failures[base] = n
+}
+
+func (b *testHTTPFsBackend) getRequests(base string) int {
+	b.Lock()
+	defer b.Unlock()
+
+	return b.requests[base]
+}
+
+func (b *testHTTPFsBackend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	base := r.PathValue("base")
+	b.Lock()
+	b.requests[base]++
+	fail := b.failures[base] > 0
+	if fail {
+		b.failures[base]--
+	}
+	status := b.status
+	b.Unlock()
+
+	if fail {
+		w.WriteHeader(status)
+		return
+	}
+	switch base {
+	case "stat":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(statResponse{ //nolint:errcheck
+			Name:         r.PathValue("name"),
+			Size:         int64(len(b.content)),
+			Mode:         0644,
+			LastModified: time.Now(),
+		})
+	case "readdir":
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("[]")) //nolint:errcheck
+	case "open":
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		data := b.content[offset:]
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.WriteHeader(http.StatusOK)
+		if offset == 0 {
+			// send only half of the content and then close the connection
+			w.Write(data[:len(data)/2]) //nolint:errcheck
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		w.Write(data) //nolint:errcheck
+	default:
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func startTestHTTPFsBackend(t *testing.T, status int) (*testHTTPFsBackend, string) {
+	backend := &testHTTPFsBackend{
+		failures: make(map[string]int),
+		requests: make(map[string]int),
+		status:   status,
+		content:  bytes.Repeat([]byte("sftpgo httpfs retry test data"), 1000),
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/api/v1/{base}/{name}", backend)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return backend, server.URL + "/api/v1"
+}
+
+func getTestHTTPFsRetryConfig(endpoint string) HTTPFsConfig {
+	return HTTPFsConfig{
+		BaseHTTPFsConfig: sdk.BaseHTTPFsConfig{
+			Endpoint: endpoint,
+		},
+		RetryMaxAttempts: 3,
+		RetryBaseDelay:   1,
+	}
+}
+
+func TestHTTPFsRetrySettingsValidation(t *testing.T) {
+	config := getTestHTTPFsRetryConfig("http://127.0.0.1:8080/api/v1")
+	require.NoError(t, config.validate())
+	config.RetryMaxAttempts = maxHTTPFsRetryAttempts + 1
+	assert.ErrorContains(t, config.validate(), "retry_max_attempts")
+	config.RetryMaxAttempts = -1
+	assert.ErrorContains(t, config.validate(), "retry_max_attempts")
+	config.RetryMaxAttempts = 2
+	config.RetryBaseDelay = maxHTTPFsRetryBaseDelay + 1
+	assert.ErrorContains(t, config.validate(), "retry_base_delay")
+	config.RetryBaseDelay = 0
+	config.CircuitBreakerThreshold = -1
+	assert.ErrorContains(t, config.validate(), "circuit_breaker_threshold")
+	config.CircuitBreakerThreshold = 5
+	config.CircuitBreakerCooldown = maxHTTPFsCircuitBreakerCooldown + 1
+	assert.ErrorContains(t, config.validate(), "circuit_breaker_cooldown")
+	config.CircuitBreakerCooldown = 60
+	assert.NoError(t, config.validate())
+
+	assert.Equal(t, 2, config.getRetryMaxAttempts(http.MethodGet))
+	assert.Equal(t, 1, config.getRetryMaxAttempts(http.MethodPatch))
+	config.RetryIdempotentWrites = true
+	assert.Equal(t, 2, config.getRetryMaxAttempts(http.MethodPatch))
+	config.RetryMaxAttempts = 0
+	assert.Equal(t, 1, config.getRetryMaxAttempts(http.MethodGet))
+
+	for attempt := 1; attempt <= 10; attempt++ {
+		delay := config.getRetryDelay(attempt)
+		maxDelay := min(defaultHTTPFsRetryBaseDelay<<(attempt-1), maxHTTPFsRetryDelay)
+		assert.GreaterOrEqual(t, delay, maxDelay/2)
+		assert.LessOrEqual(t, delay, maxDelay)
+	}
+	config.RetryBaseDelay = 1000
+	delay := config.getRetryDelay(1)
+	assert.GreaterOrEqual(t, delay, 500*time.Millisecond)
+	assert.LessOrEqual(t, delay, time.Second)
+
+	assert.True(t, isRetryableStatusCode(http.StatusBadGateway))
+	assert.True(t, isRetryableStatusCode(http.StatusServiceUnavailable))
+	assert.False(t, isRetryableStatusCode(http.StatusNotImplemented))
+	assert.False(t, isRetryableStatusCode(http.StatusNotFound))
+}
+
+func TestHTTPFsRetry(t *testing.T) {
+	backend, endpoint := startTestHTTPFsBackend(t, http.StatusBadGateway)
+	config := getTestHTTPFsRetryConfig(endpoint)
+	fs, err := NewHTTPFs("conn", "", "", config)
+	require.NoError(t, err)
+
+	backend.setFailures("stat", 2)
+	_, err = fs.Stat("file")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, backend.getRequests("stat"))
+	// the attempts are exhausted
+	backend.setFailures("readdir", 3)
+	_, err = fs.ReadDir("dir")
+	assert.ErrorContains(t, err, "unexpected response code")
+	assert.Equal(t, 3, backend.getRequests("readdir"))
+	backend.setFailures("readdir", 1)
+	lister, err := fs.ReadDir("dir")
+	if assert.NoError(t, err) {
+		lister.Close()
+	}
+	assert.Equal(t, 5, backend.getRequests("readdir"))
+	// write requests are not retried by default
+	backend.setFailures("remove", 1)
+	err = fs.Remove("file", false)
+	assert.Error(t, err)
+	assert.Equal(t, 1, backend.getRequests("remove"))
+
+	config.RetryIdempotentWrites = true
+	fs, err = NewHTTPFs("conn", "", "", config)
+	require.NoError(t, err)
+	backend.setFailures("remove", 1)
+	err = fs.Remove("file", false)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, backend.getRequests("remove"))
+	// 501 is not retried
+	backend, endpoint = startTestHTTPFsBackend(t, http.StatusNotImplemented)
+	config.Endpoint = endpoint
+	fs, err = NewHTTPFs("conn", "", "", config)
+	require.NoError(t, err)
+	backend.setFailures("stat", 1)
+	_, err = fs.Stat("file")
+	assert.ErrorIs(t, err, ErrVfsUnsupported)
+	assert.Equal(t, 1, backend.getRequests("stat"))
+}
+
+func TestHTTPFsDownloadResume(t *testing.T) {
+	backend, endpoint := startTestHTTPFsBackend(t, http.StatusBadGateway)
+	config := getTestHTTPFsRetryConfig(endpoint)
+	fs, err := NewHTTPFs("conn", "", "", config)
+	require.NoError(t, err)
+	httpFs := fs.(*HTTPFs)
+
+	var buf bytes.Buffer
+	n, err := httpFs.download(context.Background(), "file", 0, &buf)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(backend.content)), n)
+	assert.Equal(t, backend.content, buf.Bytes())
+	assert.Equal(t, 2, backend.getRequests("open"))
+	// without retries the interrupted download is not resumed
+	config.RetryMaxAttempts = 0
+	fs, err = NewHTTPFs("conn", "", "", config)
+	require.NoError(t, err)
+	httpFs = fs.(*HTTPFs)
+	buf.Reset()
+	n, err = httpFs.download(context.Background(), "file", 0, &buf)
+	assert.Error(t, err)
+	assert.Equal(t, int64(len(backend.content)/2), n)
+	assert.Equal(t, 3, backend.getRequests("open"))
+}
+
+func TestHTTPFsCircuitBreaker(t *testing.T) {
+	backend, endpoint := startTestHTTPFsBackend(t, http.StatusServiceUnavailable)
+	config := getTestHTTPFsRetryConfig(endpoint)
+	config.RetryMaxAttempts = 0
+	config.CircuitBreakerThreshold = 2
+	config.CircuitBreakerCooldown = 60
+	require.NoError(t, config.validate())
+	fs, err := NewHTTPFs("conn", "", "", config)
+	require.NoError(t, err)
+	breaker := fs.(*HTTPFs).breaker
+	require.NotNil(t, breaker)
+	// the breaker is shared by the filesystems with the same settings
+	fs1, err := NewHTTPFs("conn1", "", "", config)
+	require.NoError(t, err)
+	assert.Equal(t, breaker, fs1.(*HTTPFs).breaker)
+
+	backend.setFailures("stat", 2)
+	_, err = fs.Stat("file")
+	assert.ErrorContains(t, err, "unexpected response code")
+	assert.Equal(t, circuitBreakerClosed, breaker.getState())
+	_, err = fs.Stat("file")
+	assert.ErrorContains(t, err, "unexpected response code")
+	assert.Equal(t, circuitBreakerOpen, breaker.getState())
+	_, err = fs1.Stat("file")
+	assert.ErrorIs(t, err, ErrHTTPFsCircuitOpen)
+	assert.Equal(t, 2, backend.getRequests("stat"))
+	// after the cool down a trial request is allowed
+	breaker.mu.Lock()
+	breaker.openedAt = time.Now().Add(-2 * time.Minute)
+	breaker.mu.Unlock()
+	backend.setFailures("stat", 1)
+	_, err = fs.Stat("file")
+	assert.ErrorContains(t, err, "unexpected response code")
+	assert.Equal(t, circuitBreakerOpen, breaker.getState())
+	breaker.mu.Lock()
+	breaker.openedAt = time.Now().Add(-2 * time.Minute)
+	breaker.mu.Unlock()
+	assert.NoError(t, breaker.allow())
+	assert.Equal(t, circuitBreakerHalfOpen, breaker.getState())
+	// only one trial request is allowed
+	assert.ErrorIs(t, breaker.allow(), ErrHTTPFsCircuitOpen)
+	breaker.onCanceled()
+	_, err = fs.Stat("file")
+	assert.NoError(t, err)
+	assert.Equal(t, circuitBreakerClosed, breaker.getState())
+	assert.Equal(t, 4, backend.getRequests("stat"))
+	// client errors do not open the breaker
+	backend.Lock()
+	backend.status = http.StatusNotFound
+	backend.Unlock()
+	backend.setFailures("stat", 3)
+	for range 3 {
+		_, err = fs.Stat("file")
+		assert.True(t, fs.IsNotExist(err), "unexpected error: %v", err)
+	}
+	assert.Equal(t, circuitBreakerClosed, breaker.getState())
+
+	config.CircuitBreakerThreshold = 0
+	assert.Nil(t, httpFsBreakers.Get(&config))
+}
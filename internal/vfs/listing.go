@@ -0,0 +1,275 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+)
+
+const (
+	// default and maximum number of entries requested for each listing page
+	defaultListingPageSize = 5000
+	// maximum number of entries cached for each filesystem
+	listingCacheMaxEntries = 100000
+	// name of the entry added to the truncated listings
+	listingTruncatedName = "...truncated"
+)
+
+// Supported actions if a directory listing exceeds the maximum allowed entries
+const (
+	ListingMaxEntriesFail = iota
+	ListingMaxEntriesTruncate
+)
+
+var (
+	// ErrTooManyEntries is returned if a directory listing exceeds the configured
+	// maximum number of entries
+	ErrTooManyEntries = errors.New("too many directory entries")
+	listingConfig     = ListingConfig{
+		PageSize: defaultListingPageSize,
+	}
+)
+
+// ListingConfig defines the directory listing settings. The page size and
+// the cache apply to the cloud storage backends
+type ListingConfig struct {
+	// Number of entries requested for each listing page. 0 means the default
+	PageSize int
+	// Maximum number of entries returned when listing a directory. 0 means no limit.
+	// It can be overridden for each filesystem
+	MaxEntries int
+	// Action to take if a listing exceeds the maximum allowed entries:
+	// ListingMaxEntriesFail or ListingMaxEntriesTruncate
+	MaxEntriesMode int
+	// Time to live, as seconds, for the S3 listing cache. The listed entries
+	// are used to answer the stat requests for the listed files. 0 means disabled
+	CacheTTL int
+}
+
+// SetListingConfig validates and sets the directory listing settings
+func SetListingConfig(config ListingConfig) error {
+	if config.PageSize < 0 || config.PageSize > defaultListingPageSize {
+		return fmt.Errorf("invalid listing page size %d, allowed range: 0-%d", config.PageSize, defaultListingPageSize)
+	}
+	if config.MaxEntries < 0 {
+		return fmt.Errorf("invalid listing max entries: %d", config.MaxEntries)
+	}
+	if config.MaxEntriesMode != ListingMaxEntriesFail && config.MaxEntriesMode != ListingMaxEntriesTruncate {
+		return fmt.Errorf("invalid listing max entries mode: %d", config.MaxEntriesMode)
+	}
+	if config.CacheTTL < 0 {
+		return fmt.Errorf("invalid listing cache TTL: %d", config.CacheTTL)
+	}
+	if config.PageSize == 0 {
+		config.PageSize = defaultListingPageSize
+	}
+	listingConfig = config
+	return nil
+}
+
+func getListingPageSize() int32 {
+	return int32(listingConfig.PageSize)
+}
+
+// SetMaxListEntries sets the maximum number of entries a directory listing
+// may return for the specified filesystem, overriding the global default.
+// 0 means the global default, -1 means no limit
+func SetMaxListEntries(fs Fs, maxEntries int) {
+	if f, ok := UnwrapFs(fs).(interface{ setMaxListEntries(int) }); ok {
+		f.setMaxListEntries(maxEntries)
+	}
+}
+
+// listingLimit is embedded in the Fs implementations to limit the number
+// of entries returned by the directory listings
+type listingLimit struct {
+	maxEntries int
+}
+
+func (l *listingLimit) setMaxListEntries(maxEntries int) {
+	l.maxEntries = maxEntries
+}
+
+// limitDirLister wraps lister so that it returns at most the configured
+// number of entries
+func (l *listingLimit) limitDirLister(fs Fs, dirname string, lister DirLister) DirLister {
+	maxEntries := l.maxEntries
+	if maxEntries == 0 {
+		maxEntries = listingConfig.MaxEntries
+	}
+	if maxEntries <= 0 {
+		return lister
+	}
+	return &limitedDirLister{
+		DirLister:  lister,
+		fs:         fs,
+		dirname:    dirname,
+		maxEntries: maxEntries,
+		truncate:   listingConfig.MaxEntriesMode == ListingMaxEntriesTruncate,
+	}
+}
+
+// limitedDirLister fails or truncates the listings exceeding the maximum
+// allowed entries. The truncated listings end with a synthetic entry
+type limitedDirLister struct {
+	DirLister
+	fs         Fs
+	dirname    string
+	maxEntries int
+	numEntries int
+	truncate   bool
+	truncated  bool
+	addMarker  bool
+}
+
+func (l *limitedDirLister) Next(limit int) ([]os.FileInfo, error) {
+	if limit <= 0 {
+		return nil, errInvalidDirListerLimit
+	}
+	if l.truncated {
+		if l.addMarker {
+			l.addMarker = false
+			return []os.FileInfo{NewFileInfo(listingTruncatedName, false, 0, time.Now(), false)}, io.EOF
+		}
+		return nil, io.EOF
+	}
+	files, err := l.DirLister.Next(limit)
+	l.numEntries += len(files)
+	if l.numEntries <= l.maxEntries {
+		return files, err
+	}
+	files = files[:len(files)-(l.numEntries-l.maxEntries)]
+	if !l.truncate {
+		fsLog(l.fs, logger.LevelWarn, "listing for dir %q failed, more than %d entries", l.dirname, l.maxEntries)
+		return nil, fmt.Errorf("%w, the maximum allowed is %d", ErrTooManyEntries, l.maxEntries)
+	}
+	fsLog(l.fs, logger.LevelWarn, "listing for dir %q truncated to %d entries", l.dirname, l.maxEntries)
+	l.truncated = true
+	if len(files) < limit {
+		return append(files, NewFileInfo(listingTruncatedName, false, 0, time.Now(), false)), io.EOF
+	}
+	l.addMarker = true
+	return files, nil
+}
+
+// newListingCache returns a listing cache or nil if the cache is disabled
+func newListingCache() *listingCache {
+	if listingConfig.CacheTTL <= 0 {
+		return nil
+	}
+	return &listingCache{
+		ttl:  time.Duration(listingConfig.CacheTTL) * time.Second,
+		dirs: make(map[string]*cachedListing),
+	}
+}
+
+type cachedListing struct {
+	expiresAt time.Time
+	files     map[string]os.FileInfo
+}
+
+// listingCache stores, for a short time, the entries listed for each prefix
+// so a client that stats the listed files does not trigger a request for
+// each of them. A nil listingCache is valid and caches nothing
+type listingCache struct {
+	ttl  time.Duration
+	mu   sync.Mutex
+	dirs map[string]*cachedListing
+	size int
+}
+
+// add adds the specified entries to the listing for prefix
+func (c *listingCache) add(prefix string, files []os.FileInfo) {
+	if c == nil || len(files) == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.removeExpired()
+	if c.size+len(files) > listingCacheMaxEntries {
+		return
+	}
+	listing, ok := c.dirs[prefix]
+	if !ok {
+		listing = &cachedListing{
+			expiresAt: time.Now().Add(c.ttl),
+			files:     make(map[string]os.FileInfo),
+		}
+		c.dirs[prefix] = listing
+	}
+	for _, fi := range files {
+		if _, ok := listing.files[fi.Name()]; !ok {
+			c.size++
+		}
+		listing.files[fi.Name()] = fi
+	}
+}
+
+// get returns the cached entry for the specified name inside prefix, if any
+func (c *listingCache) get(prefix, name string) (os.FileInfo, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	listing, ok := c.dirs[prefix]
+	if !ok {
+		return nil, false
+	}
+	if listing.expiresAt.Before(time.Now()) {
+		c.remove(prefix)
+		return nil, false
+	}
+	fi, ok := listing.files[name]
+	return fi, ok
+}
+
+// invalidate removes the cached listing for the specified prefixes
+func (c *listingCache) invalidate(prefixes ...string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, prefix := range prefixes {
+		c.remove(prefix)
+	}
+}
+
+func (c *listingCache) remove(prefix string) {
+	if listing, ok := c.dirs[prefix]; ok {
+		c.size -= len(listing.files)
+		delete(c.dirs, prefix)
+	}
+}
+
+func (c *listingCache) removeExpired() {
+	now := time.Now()
+	for prefix, listing := range c.dirs {
+		if listing.expiresAt.Before(now) {
+			c.remove(prefix)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readAllEntries(t *testing.T, fs Fs, dirname string, batchSize int) ([]os.FileInfo, error) {
+	lister, err := fs.ReadDir(dirname)
+	require.NoError(t, err)
+	defer lister.Close()
+
+	var result []os.FileInfo
+	for {
+		files, err := lister.Next(batchSize)
+		result = append(result, files...)
+		if err == io.EOF {
+			return result, nil
+		}
+		if err != nil {
+			return result, err
+		}
+	}
+}
+
+func TestOsFsReadDirMaxEntries(t *testing.T) {
+	dir := t.TempDir()
+	for i := 0; i < 5; i++ {
+		err := os.WriteFile(filepath.Join(dir, strconv.Itoa(i)), []byte("data"), 0666)
+		require.NoError(t, err)
+	}
+	assert.Error(t, SetListingConfig(ListingConfig{MaxEntriesMode: 2}))
+	err := SetListingConfig(ListingConfig{
+		MaxEntries: 3,
+	})
+	require.NoError(t, err)
+	defer SetListingConfig(ListingConfig{}) //nolint:errcheck
+
+	fs := NewOsFs("", dir, "", nil)
+	_, err = readAllEntries(t, fs, dir, 2)
+	assert.ErrorIs(t, err, ErrTooManyEntries)
+	// the per filesystem setting overrides the global one
+	SetMaxListEntries(fs, 5)
+	files, err := readAllEntries(t, fs, dir, 2)
+	assert.NoError(t, err)
+	assert.Len(t, files, 5)
+	SetMaxListEntries(fs, -1)
+	files, err = readAllEntries(t, fs, dir, 2)
+	assert.NoError(t, err)
+	assert.Len(t, files, 5)
+
+	err = SetListingConfig(ListingConfig{
+		MaxEntries:     3,
+		MaxEntriesMode: ListingMaxEntriesTruncate,
+	})
+	require.NoError(t, err)
+	SetMaxListEntries(fs, 0)
+	for _, batchSize := range []int{1, 2, 3, ListerBatchSize} {
+		files, err = readAllEntries(t, fs, dir, batchSize)
+		assert.NoError(t, err)
+		if assert.Len(t, files, 4, "batch size %d", batchSize) {
+			assert.Equal(t, listingTruncatedName, files[3].Name())
+			assert.False(t, files[3].IsDir())
+		}
+	}
+	// the traced filesystems are unwrapped
+	SetMaxListEntries(&tracedFs{Fs: fs}, 2)
+	files, err = readAllEntries(t, fs, dir, ListerBatchSize)
+	assert.NoError(t, err)
+	assert.Len(t, files, 3)
+}
@@ -24,6 +24,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"runtime"
 	"slices"
 	"strings"
 	"time"
@@ -59,6 +60,8 @@ type OsFs struct {
 	localTempDir    string
 	readBufferSize  int
 	writeBufferSize int
+	listingLimit
+	createModes
 }
 
 // NewOsFs returns an OsFs object that allows to interact with local Os filesystem
@@ -134,6 +137,7 @@ func (fs *OsFs) Open(name string, offset int64) (File, PipeReader, func(), error
 
 // Create creates or opens the named file for writing
 func (fs *OsFs) Create(name string, flag, _ int) (File, PipeWriter, func(), error) {
+	isNewFile := fs.isNewFile(name)
 	if !fs.useWriteBuffering(flag) {
 		var err error
 		var f *os.File
@@ -142,12 +146,18 @@ func (fs *OsFs) Create(name string, flag, _ int) (File, PipeWriter, func(), erro
 		} else {
 			f, err = os.OpenFile(name, flag, 0666)
 		}
+		if err == nil && isNewFile {
+			fs.applyFileMode(fs, name)
+		}
 		return f, nil, nil, err
 	}
 	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
 	if err != nil {
 		return nil, nil, nil, err
 	}
+	if isNewFile {
+		fs.applyFileMode(fs, name)
+	}
 	r, w, err := createPipeFn(fs.localTempDir, 0)
 	if err != nil {
 		f.Close()
@@ -216,8 +226,17 @@ func (*OsFs) Remove(name string, _ bool) error {
 }
 
 // Mkdir creates a new directory with the specified name and default permissions
-func (*OsFs) Mkdir(name string) error {
-	return os.Mkdir(name, os.ModePerm)
+func (fs *OsFs) Mkdir(name string) error {
+	if fs.dirMode == 0 {
+		return os.Mkdir(name, os.ModePerm)
+	}
+	err := os.Mkdir(name, fs.dirMode)
+	if err == nil {
+		// the mode passed to mkdir is filtered by the process umask
+		err = os.Chmod(name, fs.dirMode)
+	}
+	fsLog(fs, logger.LevelDebug, "mkdir %q, mode: %v, err: %v", name, fs.dirMode, err)
+	return err
 }
 
 // Symlink creates source as a symbolic link to target.
@@ -263,7 +282,7 @@ func (*OsFs) Truncate(name string, size int64) error {
 
 // ReadDir reads the directory named by dirname and returns
 // a list of directory entries.
-func (*OsFs) ReadDir(dirname string) (DirLister, error) {
+func (fs *OsFs) ReadDir(dirname string) (DirLister, error) {
 	f, err := os.Open(dirname)
 	if err != nil {
 		if isInvalidNameError(err) {
@@ -271,7 +290,7 @@ func (*OsFs) ReadDir(dirname string) (DirLister, error) {
 		}
 		return nil, err
 	}
-	return &osFsDirLister{f}, nil
+	return fs.limitDirLister(fs, dirname, &osFsDirLister{f}), nil
 }
 
 // IsUploadResumeSupported returns true if resuming uploads is supported
@@ -614,3 +633,48 @@ func (l *osFsDirLister) Next(limit int) ([]os.FileInfo, error) {
 func (l *osFsDirLister) Close() error {
 	return l.f.Close()
 }
+
+// createModes is embedded in the local Fs implementations to define the
+// permissions for new files and directories. 0 means the permissions
+// derived from the process umask
+type createModes struct {
+	fileMode os.FileMode
+	dirMode  os.FileMode
+}
+
+type createModesSetter interface {
+	setCreateModes(fileMode, dirMode os.FileMode)
+}
+
+func (m *createModes) setCreateModes(fileMode, dirMode os.FileMode) {
+	m.fileMode = fileMode & os.ModePerm
+	m.dirMode = dirMode & os.ModePerm
+}
+
+// isNewFile returns true if a file mode is configured and the named file
+// does not exist, the configured mode is applied to new files only
+func (m *createModes) isNewFile(name string) bool {
+	if m.fileMode == 0 {
+		return false
+	}
+	_, err := os.Lstat(name)
+	return errors.Is(err, fs.ErrNotExist)
+}
+
+func (m *createModes) applyFileMode(fs Fs, name string) {
+	err := os.Chmod(name, m.fileMode)
+	fsLog(fs, logger.LevelDebug, "open new file %q, mode: %v, err: %v", name, m.fileMode, err)
+}
+
+// SetCreateModes sets the permissions for the files and directories created
+// within the specified filesystem. It only applies to the local filesystems,
+// 0 means the permissions derived from the process umask. The permissions are
+// ignored on Windows
+func SetCreateModes(fs Fs, fileMode, dirMode os.FileMode) {
+	if runtime.GOOS == "windows" {
+		return
+	}
+	if f, ok := UnwrapFs(fs).(createModesSetter); ok {
+		f.setCreateModes(fileMode, dirMode)
+	}
+}
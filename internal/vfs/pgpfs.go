@@ -0,0 +1,236 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+)
+
+// PGPFileExtension is the extension added to the files encrypted on upload,
+// unless the folder is configured to keep the original name
+const PGPFileExtension = ".pgp"
+
+// ErrPGPResumeUnsupported is returned for resumed and appended uploads to the
+// folders with PGP encryption
+var ErrPGPResumeUnsupported = errors.New("resumed and partial uploads are not supported for folders with PGP encryption")
+
+// pgpFs wraps a Fs and encrypts the uploaded files, using OpenPGP, for the
+// configured recipients. The encryption is streamed to the wrapped Fs.
+// Downloads return the stored ciphertext
+type pgpFs struct {
+	Fs
+	recipients openpgp.EntityList
+	keepName   bool
+}
+
+// pgpRealPatherFs is a pgpFs for the filesystems implementing FsRealPather
+type pgpRealPatherFs struct {
+	pgpFs
+}
+
+func (fs *pgpRealPatherFs) RealPath(p string) (string, error) {
+	return fs.Fs.(FsRealPather).RealPath(p)
+}
+
+// pgpFileCopierFs is a pgpFs for the filesystems implementing FsFileCopier
+type pgpFileCopierFs struct {
+	pgpFs
+}
+
+func (fs *pgpFileCopierFs) CopyFile(source, target string, srcInfo os.FileInfo) (int, int64, error) {
+	return fs.Fs.(FsFileCopier).CopyFile(source, target, srcInfo)
+}
+
+// newPGPFs returns a Fs that encrypts the uploads for the recipients defined
+// in the specified armored public keys
+func newPGPFs(fs Fs, armoredKeys string, keepName bool) (Fs, error) {
+	recipients, err := parsePGPPublicKeys(armoredKeys)
+	if err != nil {
+		return nil, err
+	}
+	base := pgpFs{
+		Fs:         fs,
+		recipients: recipients,
+		keepName:   keepName,
+	}
+	switch fs.(type) {
+	case FsRealPather:
+		return &pgpRealPatherFs{base}, nil
+	case FsFileCopier:
+		return &pgpFileCopierFs{base}, nil
+	default:
+		return &base, nil
+	}
+}
+
+// parsePGPPublicKeys parses the armored public keys and checks that each
+// key can be used for encryption. Multiple armored blocks are allowed
+func parsePGPPublicKeys(armoredKeys string) (openpgp.EntityList, error) {
+	var entities openpgp.EntityList
+	// armor.Decode reuses a large enough bufio.Reader, so the data buffered
+	// while decoding a block are not lost for the next ones
+	reader := bufio.NewReader(strings.NewReader(armoredKeys))
+	for {
+		block, err := armor.Decode(reader)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse the PGP public keys: %w", err)
+		}
+		if block.Type != openpgp.PublicKeyType {
+			return nil, fmt.Errorf("unexpected PGP block %q, only public keys are allowed", block.Type)
+		}
+		keys, err := openpgp.ReadKeyRing(block.Body)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse the PGP public keys: %w", err)
+		}
+		entities = append(entities, keys...)
+	}
+	if len(entities) == 0 {
+		return nil, errors.New("no PGP public key found")
+	}
+	now := time.Now()
+	for _, entity := range entities {
+		if entity.PrivateKey != nil {
+			return nil, fmt.Errorf("PGP key %s is a private key, only public keys are allowed",
+				entity.PrimaryKey.KeyIdString())
+		}
+		if _, ok := entity.EncryptionKey(now); !ok {
+			return nil, fmt.Errorf("PGP key %s cannot be used for encryption", entity.PrimaryKey.KeyIdString())
+		}
+	}
+	return entities, nil
+}
+
+// Create creates or truncates the named file and returns a writer that
+// encrypts the written data
+func (fs *pgpFs) Create(name string, flag, checks int) (File, PipeWriter, func(), error) {
+	if flag&os.O_APPEND != 0 || checks&CheckResume != 0 {
+		return nil, nil, nil, ErrPGPResumeUnsupported
+	}
+	// the ciphertext cannot be written at arbitrary offsets, so always truncate
+	f, w, cancelFn, err := fs.Fs.Create(name, 0, checks)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var dst io.WriteCloser
+	if f != nil {
+		dst = f
+	} else {
+		dst = w
+	}
+	r, pw, err := createPipeFn(getLocalTempDir(), 0)
+	if err != nil {
+		if cancelFn != nil {
+			cancelFn()
+		}
+		dst.Close()
+		return nil, nil, nil, err
+	}
+	p := NewPipeWriter(pw)
+
+	go func() {
+		n, err := fs.encrypt(dst, r)
+		errClose := dst.Close()
+		if err == nil && errClose != nil {
+			err = errClose
+		}
+		r.CloseWithError(err) //nolint:errcheck
+		p.Done(err)
+		fsLog(fs, logger.LevelDebug, "PGP upload completed, path: %q, plaintext bytes: %d, err: %v", name, n, err)
+	}()
+
+	return nil, p, cancelFn, nil
+}
+
+func (fs *pgpFs) encrypt(w io.Writer, r io.Reader) (int64, error) {
+	plaintext, err := openpgp.Encrypt(w, fs.recipients, nil, &openpgp.FileHints{IsBinary: true}, nil)
+	if err != nil {
+		return 0, err
+	}
+	n, err := doCopy(plaintext, r, nil)
+	errClose := plaintext.Close()
+	if err == nil && errClose != nil {
+		err = errClose
+	}
+	return n, err
+}
+
+// IsUploadResumeSupported returns false, the ciphertext cannot be extended
+func (*pgpFs) IsUploadResumeSupported() bool {
+	return false
+}
+
+// IsConditionalUploadResumeSupported returns false, the ciphertext cannot be extended
+func (*pgpFs) IsConditionalUploadResumeSupported(_ int64) bool {
+	return false
+}
+
+// IsNotSupported returns true if the error indicate an unsupported operation
+func (fs *pgpFs) IsNotSupported(err error) bool {
+	if errors.Is(err, ErrPGPResumeUnsupported) {
+		return true
+	}
+	return fs.Fs.IsNotSupported(err)
+}
+
+// getPGPFs returns the pgpFs wrapped by the specified Fs, if any
+func getPGPFs(fs Fs) *pgpFs {
+	switch f := fs.(type) {
+	case *pgpFs:
+		return f
+	case *pgpRealPatherFs:
+		return &f.pgpFs
+	case *pgpFileCopierFs:
+		return &f.pgpFs
+	case *tracedFs:
+		return getPGPFs(f.Fs)
+	case *tracedRealPatherFs:
+		return getPGPFs(f.Fs)
+	case *tracedFileCopierFs:
+		return getPGPFs(f.Fs)
+	default:
+		return nil
+	}
+}
+
+// IsPGPFs returns true if the specified Fs encrypts the uploads using OpenPGP
+func IsPGPFs(fs Fs) bool {
+	return getPGPFs(fs) != nil
+}
+
+// GetPGPFileName returns the name for a file encrypted on upload by the
+// specified Fs. The name is returned unchanged if the Fs does not encrypt
+// the uploads, if the original name must be kept or if the name already
+// has the PGP extension
+func GetPGPFileName(fs Fs, name string) string {
+	f := getPGPFs(fs)
+	if f == nil || f.keepName || strings.HasSuffix(strings.ToLower(name), PGPFileExtension) {
+		return name
+	}
+	return name + PGPFileExtension
+}
@@ -0,0 +1,170 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/sftpgo/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/kms"
+)
+
+func newTestPGPEntity(t *testing.T) (*openpgp.Entity, string) {
+	entity, err := openpgp.NewEntity("sftpgo", "", "sftpgo@example.com", &packet.Config{
+		Algorithm: packet.PubKeyAlgoEdDSA,
+	})
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, openpgp.PublicKeyType, nil)
+	require.NoError(t, err)
+	require.NoError(t, entity.Serialize(w))
+	require.NoError(t, w.Close())
+	return entity, buf.String()
+}
+
+func TestParsePGPPublicKeys(t *testing.T) {
+	entity, publicKey := newTestPGPEntity(t)
+	_, otherKey := newTestPGPEntity(t)
+	recipients, err := parsePGPPublicKeys(publicKey + "\n" + otherKey)
+	require.NoError(t, err)
+	assert.Len(t, recipients, 2)
+
+	_, err = parsePGPPublicKeys("invalid key")
+	assert.Error(t, err)
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, openpgp.PrivateKeyType, nil)
+	require.NoError(t, err)
+	require.NoError(t, entity.SerializePrivate(w, nil))
+	require.NoError(t, w.Close())
+	_, err = parsePGPPublicKeys(buf.String())
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "only public keys are allowed")
+	}
+	_, err = parsePGPPublicKeys("")
+	assert.Error(t, err)
+}
+
+func TestPGPFs(t *testing.T) {
+	entity, publicKey := newTestPGPEntity(t)
+	rootDir := filepath.Join(os.TempDir(), "pgpfs_root")
+	err := os.MkdirAll(rootDir, os.ModePerm)
+	require.NoError(t, err)
+	defer os.RemoveAll(rootDir)
+
+	fs, err := newPGPFs(NewOsFs("conn", rootDir, "", nil), publicKey, false)
+	require.NoError(t, err)
+	assert.True(t, IsPGPFs(fs))
+	assert.True(t, IsPGPFs(&tracedRealPatherFs{tracedFs{Fs: fs}}))
+	assert.False(t, IsPGPFs(NewOsFs("conn", rootDir, "", nil)))
+	assert.True(t, IsLocalOsFs(fs))
+	assert.False(t, fs.IsUploadResumeSupported())
+	assert.False(t, fs.IsConditionalUploadResumeSupported(10))
+	_, ok := UnwrapFs(&tracedRealPatherFs{tracedFs{Fs: fs}}).(*OsFs)
+	assert.True(t, ok)
+	assert.Equal(t, "file.txt.pgp", GetPGPFileName(fs, "file.txt"))
+	assert.Equal(t, "file.PGP", GetPGPFileName(fs, "file.PGP"))
+
+	name := filepath.Join(rootDir, "file.txt")
+	_, _, _, err = fs.Create(name, os.O_WRONLY|os.O_APPEND, 0)
+	assert.ErrorIs(t, err, ErrPGPResumeUnsupported)
+	assert.True(t, fs.IsNotSupported(err))
+	_, _, _, err = fs.Create(name, 0, CheckResume)
+	assert.ErrorIs(t, err, ErrPGPResumeUnsupported)
+
+	data := bytes.Repeat([]byte("plaintext data "), 1000)
+	f, w, _, err := fs.Create(name, os.O_WRONLY, 0)
+	require.NoError(t, err)
+	assert.Nil(t, f)
+	_, err = w.Write(data)
+	require.NoError(t, err)
+	err = w.Close()
+	require.NoError(t, err)
+
+	encrypted, err := os.ReadFile(name)
+	require.NoError(t, err)
+	assert.NotContains(t, string(encrypted), "plaintext data")
+	md, err := openpgp.ReadMessage(bytes.NewReader(encrypted), openpgp.EntityList{entity}, nil, nil)
+	require.NoError(t, err)
+	decrypted, err := io.ReadAll(md.UnverifiedBody)
+	require.NoError(t, err)
+	assert.Equal(t, data, decrypted)
+	// downloads return the stored ciphertext
+	file, _, _, err := fs.Open(name, 0)
+	require.NoError(t, err)
+	stored, err := io.ReadAll(file)
+	require.NoError(t, err)
+	assert.Equal(t, encrypted, stored)
+	assert.NoError(t, file.Close())
+
+	fs, err = newPGPFs(NewOsFs("conn", rootDir, "", nil), publicKey, true)
+	require.NoError(t, err)
+	assert.Equal(t, "file.txt", GetPGPFileName(fs, "file.txt"))
+	_, err = newPGPFs(NewOsFs("conn", rootDir, "", nil), "invalid", false)
+	assert.Error(t, err)
+}
+
+func TestFolderTransform(t *testing.T) {
+	_, publicKey := newTestPGPEntity(t)
+	folder := VirtualFolder{
+		BaseVirtualFolder: BaseVirtualFolder{
+			Name:       "pgp_folder",
+			MappedPath: filepath.Join(os.TempDir(), "pgp_folder"),
+			FsConfig: Filesystem{
+				Provider: sdk.LocalFilesystemProvider,
+			},
+			Transform: FolderTransform{
+				PGPPublicKeys: kms.NewPlainSecret("invalid"),
+			},
+		},
+		VirtualPath: "/pgp",
+	}
+	assert.Error(t, folder.ValidateTransform())
+	folder.Transform.PGPPublicKeys = kms.NewPlainSecret(publicKey)
+	folder.Extraction.Patterns = []string{"*.zip"}
+	assert.Error(t, folder.ValidateTransform())
+	folder.Extraction = FolderExtraction{}
+	require.NoError(t, folder.ValidateTransform())
+	assert.True(t, folder.Transform.PGPPublicKeys.IsEncrypted())
+	assert.Equal(t, folder.GetEncryptionAdditionalData(), folder.Transform.PGPPublicKeys.GetAdditionalData())
+
+	fs, err := folder.GetFilesystem("conn", nil)
+	require.NoError(t, err)
+	assert.True(t, IsPGPFs(fs))
+	// the folder configuration is not modified
+	assert.True(t, folder.Transform.PGPPublicKeys.IsEncrypted())
+
+	folderCopy := folder.GetACopy()
+	folderCopy.PrepareForRendering()
+	assert.Empty(t, folderCopy.Transform.PGPPublicKeys.GetAdditionalData())
+	assert.NotEmpty(t, folder.Transform.PGPPublicKeys.GetAdditionalData())
+
+	folder.Transform.PGPPublicKeys = kms.NewEmptySecret()
+	folder.Transform.PGPKeepName = true
+	require.NoError(t, folder.ValidateTransform())
+	assert.Equal(t, FolderTransform{}, folder.Transform)
+	fs, err = folder.GetFilesystem("conn", nil)
+	require.NoError(t, err)
+	assert.False(t, IsPGPFs(fs))
+}
@@ -0,0 +1,381 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/proxy"
+
+	"github.com/drakkan/sftpgo/v2/internal/kms"
+)
+
+const (
+	// ProxyURLDirect disables the default outbound proxy for a filesystem
+	ProxyURLDirect     = "direct"
+	proxyDialTimeout   = 15 * time.Second
+	maxProxyHeaderSize = 1 << 16
+)
+
+var (
+	// ErrProxyConnection defines the error to return if the outbound proxy
+	// cannot be reached or rejects the connection request
+	ErrProxyConnection = errors.New("proxy connection failed")
+	// ErrProxyTarget defines the error to return if the outbound proxy cannot
+	// connect to the target endpoint
+	ErrProxyTarget = errors.New("proxy target connection failed")
+	defaultProxy   *outboundProxy
+)
+
+// OutboundProxyConfig defines the default proxy for the outbound connections
+// of the SFTP and HTTP filesystems
+type OutboundProxyConfig struct {
+	// Proxy URL. Supported schemes: socks5, socks5h, http, https.
+	// For socks5h the target host names are resolved by the proxy.
+	// Empty means no proxy
+	URL string
+	// Username and password for the proxy authentication, if required
+	Username string
+	Password string
+}
+
+// SetOutboundProxy sets the default proxy for the outbound connections.
+// It can be overridden or disabled for each filesystem
+func SetOutboundProxy(config OutboundProxyConfig) error {
+	if config.URL == "" {
+		defaultProxy = nil
+		return nil
+	}
+	if config.URL == ProxyURLDirect {
+		return fmt.Errorf("invalid outbound proxy URL %q", config.URL)
+	}
+	p, err := newOutboundProxy(config.URL, config.Username, config.Password)
+	if err != nil {
+		return fmt.Errorf("invalid outbound proxy: %w", err)
+	}
+	defaultProxy = p
+	return nil
+}
+
+type outboundProxy struct {
+	url      *url.URL
+	username string
+	password string
+	// local address for the connections to the proxy server, nil means any
+	localAddr *net.TCPAddr
+}
+
+func newOutboundProxy(rawURL, username, password string) (*outboundProxy, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse proxy URL %q: %w", rawURL, err)
+	}
+	switch u.Scheme {
+	case "socks5", "socks5h", "http", "https":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q, supported schemes: socks5, socks5h, http, https", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("proxy URL %q has no host", rawURL)
+	}
+	if u.User != nil {
+		return nil, errors.New("proxy credentials must be set using the username and password")
+	}
+	if u.Port() == "" {
+		port := "1080"
+		switch u.Scheme {
+		case "http":
+			port = "80"
+		case "https":
+			port = "443"
+		}
+		u.Host = net.JoinHostPort(u.Hostname(), port)
+	}
+	return &outboundProxy{
+		url:      u,
+		username: username,
+		password: password,
+	}, nil
+}
+
+// validateProxySettings returns an error if the proxy settings for a filesystem are not valid
+func validateProxySettings(rawURL, username string, password *kms.Secret) error {
+	if password.IsEncrypted() && !password.IsValid() {
+		return errors.New("invalid encrypted proxy password")
+	}
+	if !password.IsEmpty() && !password.IsValidInput() {
+		return errors.New("invalid proxy password")
+	}
+	if rawURL == "" || rawURL == ProxyURLDirect {
+		if username != "" || !password.IsEmpty() {
+			return errors.New("proxy credentials require a proxy URL")
+		}
+		return nil
+	}
+	_, err := newOutboundProxy(rawURL, username, "")
+	return err
+}
+
+// getOutboundProxy returns the proxy to use for the specified filesystem
+// settings, nil means a direct connection. The password must be decrypted
+func getOutboundProxy(rawURL, username string, password *kms.Secret) (*outboundProxy, error) {
+	switch rawURL {
+	case "":
+		return defaultProxy, nil
+	case ProxyURLDirect:
+		return nil, nil
+	default:
+		return newOutboundProxy(rawURL, username, password.GetPayload())
+	}
+}
+
+// withBindAddress returns a copy of the proxy connecting to the proxy server
+// from the configured local address, if any
+func (p *outboundProxy) withBindAddress(bindAddress string) *outboundProxy {
+	localAddr := getLocalTCPAddr(bindAddress)
+	if localAddr == nil {
+		return p
+	}
+	bound := *p
+	bound.localAddr = localAddr
+	return &bound
+}
+
+func (p *outboundProxy) String() string {
+	return p.url.Redacted()
+}
+
+// DialContext connects to the specified address through the proxy. The returned
+// error wraps ErrProxyConnection or ErrProxyTarget so the callers can know if the
+// proxy or the target failed
+func (p *outboundProxy) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if p.url.Scheme == "http" || p.url.Scheme == "https" {
+		return p.dialHTTPConnect(ctx, addr)
+	}
+	return p.dialSOCKS5(ctx, network, addr)
+}
+
+func (p *outboundProxy) getProxyError(err error) error {
+	return fmt.Errorf("%w: unable to connect to proxy %q: %v", ErrProxyConnection, p, err)
+}
+
+func (p *outboundProxy) getTargetError(addr string, err error) error {
+	return fmt.Errorf("%w: unable to connect to %q through proxy %q: %v", ErrProxyTarget, addr, p, err)
+}
+
+// dialProxy connects to the proxy server
+func (p *outboundProxy) dialProxy(ctx context.Context) (net.Conn, error) {
+	d := &net.Dialer{Timeout: proxyDialTimeout}
+	if p.localAddr != nil {
+		d.LocalAddr = p.localAddr
+	}
+	conn, err := d.DialContext(ctx, "tcp", p.url.Host)
+	if err != nil {
+		return nil, &proxyDialError{err: err}
+	}
+	return conn, nil
+}
+
+func (p *outboundProxy) dialSOCKS5(ctx context.Context, network, addr string) (net.Conn, error) {
+	var auth *proxy.Auth
+	if p.username != "" || p.password != "" {
+		auth = &proxy.Auth{
+			User:     p.username,
+			Password: p.password,
+		}
+	}
+	dialer, err := proxy.SOCKS5("tcp", p.url.Host, auth, proxyDialerFunc(p.dialProxy))
+	if err != nil {
+		return nil, p.getProxyError(err)
+	}
+	if p.url.Scheme == "socks5h" {
+		// the target host name is sent to the proxy and resolved proxy-side
+		return p.dialSOCKS5Addr(ctx, dialer.(proxy.ContextDialer), network, addr)
+	}
+	addrs, err := resolveTargetAddr(ctx, addr)
+	if err != nil {
+		return nil, p.getTargetError(addr, err)
+	}
+	for idx, resolved := range addrs {
+		conn, err := p.dialSOCKS5Addr(ctx, dialer.(proxy.ContextDialer), network, resolved)
+		if err == nil || idx == len(addrs)-1 || !errors.Is(err, ErrProxyTarget) {
+			return conn, err
+		}
+	}
+	return nil, p.getTargetError(addr, errors.New("no address found"))
+}
+
+func (p *outboundProxy) dialSOCKS5Addr(ctx context.Context, dialer proxy.ContextDialer, network, addr string) (net.Conn, error) {
+	conn, err := dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		var dialErr *proxyDialError
+		if errors.As(err, &dialErr) {
+			return nil, p.getProxyError(dialErr.err)
+		}
+		// the proxy replies with an error code if the connection to the target fails
+		var opErr *net.OpError
+		if errors.As(err, &opErr) && strings.HasPrefix(opErr.Err.Error(), "unknown error ") {
+			return nil, p.getTargetError(addr, errors.New(strings.TrimPrefix(opErr.Err.Error(), "unknown error ")))
+		}
+		return nil, p.getProxyError(err)
+	}
+	return conn, nil
+}
+
+func (p *outboundProxy) dialHTTPConnect(ctx context.Context, addr string) (net.Conn, error) {
+	conn, err := p.dialProxy(ctx)
+	if err != nil {
+		return nil, p.getProxyError(errors.Unwrap(err))
+	}
+	if p.url.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{
+			ServerName: p.url.Hostname(),
+			MinVersion: tls.VersionTLS12,
+		})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, p.getProxyError(err)
+		}
+		conn = tlsConn
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline) //nolint:errcheck
+	} else {
+		conn.SetDeadline(time.Now().Add(proxyDialTimeout)) //nolint:errcheck
+	}
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if p.username != "" || p.password != "" {
+		credentials := base64.StdEncoding.EncodeToString([]byte(p.username + ":" + p.password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, p.getProxyError(err)
+	}
+	br := bufio.NewReaderSize(conn, 4096)
+	resp, err := http.ReadResponse(bufio.NewReader(&limitedReader{r: br, n: maxProxyHeaderSize}), req)
+	if err != nil {
+		conn.Close()
+		return nil, p.getProxyError(err)
+	}
+	// the response body is not closed, for a successful CONNECT it is the tunnel
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		if resp.StatusCode == http.StatusProxyAuthRequired {
+			return nil, p.getProxyError(errors.New(resp.Status))
+		}
+		return nil, p.getTargetError(addr, errors.New(resp.Status))
+	}
+	conn.SetDeadline(time.Time{}) //nolint:errcheck
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// resolveTargetAddr resolves the host for the specified address locally
+func resolveTargetAddr(ctx context.Context, addr string) ([]string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if net.ParseIP(host) != nil {
+		return []string{addr}, nil
+	}
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no address found for host %q", host)
+	}
+	addrs := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		addrs = append(addrs, net.JoinHostPort(ip.String(), port))
+	}
+	return addrs, nil
+}
+
+// proxyDialError is returned if the connection to the proxy server fails
+type proxyDialError struct {
+	err error
+}
+
+func (e *proxyDialError) Error() string {
+	return e.err.Error()
+}
+
+func (e *proxyDialError) Unwrap() error {
+	return e.err
+}
+
+type proxyDialerFunc func(context.Context) (net.Conn, error)
+
+func (f proxyDialerFunc) Dial(_, _ string) (net.Conn, error) {
+	return f(context.Background())
+}
+
+func (f proxyDialerFunc) DialContext(ctx context.Context, _, _ string) (net.Conn, error) {
+	return f(ctx)
+}
+
+// bufferedConn is a net.Conn that reads the data already buffered while
+// reading the proxy response before reading from the connection
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// limitedReader limits the size of the proxy response headers. The reads
+// are byte by byte so no data after the headers is consumed
+type limitedReader struct {
+	r *bufio.Reader
+	n int
+}
+
+func (l *limitedReader) Read(b []byte) (int, error) {
+	if l.n <= 0 {
+		return 0, errors.New("proxy response headers too large")
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+	c, err := l.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	l.n--
+	b[0] = c
+	return 1, nil
+}
@@ -17,11 +17,13 @@
 package vfs
 
 import (
+	"bytes"
 	"context"
 	"crypto/md5"
 	"crypto/sha256"
 	"crypto/tls"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -48,7 +50,10 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
 	"github.com/pkg/sftp"
+	"golang.org/x/time/rate"
 
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/metric"
@@ -61,11 +66,14 @@ const (
 	s3DirMimeType         = "application/x-directory"
 	s3TransferBufferSize  = 256 * 1024
 	s3CopyObjectThreshold = 500 * 1024 * 1024
+	// maximum number of parts copied in parallel for multipart copies
+	s3MultipartCopyConcurrency = 10
+	// the assumed role credentials are refreshed this time before they expire
+	s3AssumeRoleExpiryWindow = 5 * time.Minute
 )
 
 var (
-	s3DirMimeTypes    = []string{s3DirMimeType, "httpd/unix-directory"}
-	s3DefaultPageSize = int32(5000)
+	s3DirMimeTypes = []string{s3DirMimeType, "httpd/unix-directory"}
 )
 
 // S3Fs is a Fs implementation for AWS S3 compatible object storages
@@ -80,6 +88,9 @@ type S3Fs struct {
 	sseCustomerKey    string
 	sseCustomerKeyMD5 string
 	sseCustomerAlgo   string
+	listingCache      *listingCache
+	listingLimit
+	cancelSignal *cancelSignal
 }
 
 func init() {
@@ -98,6 +109,8 @@ func NewS3Fs(connectionID, localTempDir, mountPath string, s3Config S3FsConfig)
 		mountPath:    getMountPath(mountPath),
 		config:       &s3Config,
 		ctxTimeout:   30 * time.Second,
+		cancelSignal: newCancelSignal(),
+		listingCache: newListingCache(),
 	}
 	if err := fs.config.validate(); err != nil {
 		return fs, err
@@ -106,7 +119,7 @@ func NewS3Fs(connectionID, localTempDir, mountPath string, s3Config S3FsConfig)
 	defer cancel()
 
 	awsConfig, err := config.LoadDefaultConfig(ctx, config.WithHTTPClient(
-		getAWSHTTPClient(0, 30*time.Second, fs.config.SkipTLSVerify)),
+		getAWSHTTPClient(0, 30*time.Second, fs.config.SkipTLSVerify, fs.config.OutboundBindAddress)),
 	)
 	if err != nil {
 		return fs, fmt.Errorf("unable to get AWS config: %w", err)
@@ -146,9 +159,7 @@ func NewS3Fs(connectionID, localTempDir, mountPath string, s3Config S3FsConfig)
 	fs.setConfigDefaults()
 
 	if fs.config.RoleARN != "" {
-		client := sts.NewFromConfig(awsConfig)
-		creds := stscreds.NewAssumeRoleProvider(client, fs.config.RoleARN)
-		awsConfig.Credentials = creds
+		awsConfig.Credentials = getS3AssumeRoleCredentials(awsConfig, fs.config)
 	}
 	fs.svc = s3.NewFromConfig(awsConfig, func(o *s3.Options) {
 		o.AppID = version.GetVersionHash()
@@ -158,6 +169,9 @@ func NewS3Fs(connectionID, localTempDir, mountPath string, s3Config S3FsConfig)
 		if fs.config.Endpoint != "" {
 			o.BaseEndpoint = aws.String(fs.config.Endpoint)
 		}
+		if fs.config.RequestPayer != "" {
+			o.APIOptions = append(o.APIOptions, addS3RequestPayer(fs.config.RequestPayer))
+		}
 	})
 	return fs, nil
 }
@@ -181,6 +195,9 @@ func (fs *S3Fs) Stat(name string) (os.FileInfo, error) {
 	if fs.config.KeyPrefix == name+"/" {
 		return NewFileInfo(name, true, 0, time.Unix(0, 0), false), nil
 	}
+	if info, ok := fs.listingCache.get(fs.getPrefix(path.Dir(name)), path.Base(name)); ok {
+		return info, nil
+	}
 	obj, err := fs.headObject(name)
 	if err == nil {
 		// Some S3 providers (like SeaweedFS) remove the trailing '/' from object keys.
@@ -191,6 +208,9 @@ func (fs *S3Fs) Stat(name string) (os.FileInfo, error) {
 			isDir = err == nil
 		}
 		info := NewFileInfo(name, isDir, util.GetIntFromPointer(obj.ContentLength), util.GetTimeFromPointer(obj.LastModified), false)
+		if !isDir {
+			info.setMetadata(obj.Metadata)
+		}
 		return info, nil
 	}
 	if !fs.IsNotExist(err) {
@@ -248,7 +268,7 @@ func (fs *S3Fs) Open(name string, offset int64) (File, PipeReader, func(), error
 		if offset == 0 && fs.config.DownloadPartMaxTime > 0 {
 			d.ClientOptions = append(d.ClientOptions, func(o *s3.Options) {
 				o.HTTPClient = getAWSHTTPClient(fs.config.DownloadPartMaxTime, 100*time.Millisecond,
-					fs.config.SkipTLSVerify)
+					fs.config.SkipTLSVerify, fs.config.OutboundBindAddress)
 			})
 		}
 	})
@@ -278,7 +298,8 @@ func (fs *S3Fs) Open(name string, offset int64) (File, PipeReader, func(), error
 
 // Create creates or opens the named file for writing
 func (fs *S3Fs) Create(name string, flag, checks int) (File, PipeWriter, func(), error) {
-	if checks&CheckParentDir != 0 {
+	// for atomic uploads the parent dir is checked when the temporary object is moved
+	if checks&CheckParentDir != 0 && !isCloudAtomicUploadKey(fs.config.KeyPrefix, name) {
 		_, err := fs.Stat(path.Dir(name))
 		if err != nil {
 			return nil, nil, nil, err
@@ -288,6 +309,7 @@ func (fs *S3Fs) Create(name string, flag, checks int) (File, PipeWriter, func(),
 	if err != nil {
 		return nil, nil, nil, err
 	}
+	fs.invalidateListingCache(name)
 	var p PipeWriter
 	if checks&CheckResume != 0 {
 		p = newPipeWriterAtOffset(w, 0)
@@ -295,41 +317,47 @@ func (fs *S3Fs) Create(name string, flag, checks int) (File, PipeWriter, func(),
 		p = NewPipeWriter(w)
 	}
 	ctx, cancelFn := context.WithCancel(context.Background())
-	uploader := manager.NewUploader(fs.svc, func(u *manager.Uploader) {
-		u.Concurrency = fs.config.UploadConcurrency
-		u.PartSize = fs.config.UploadPartSize
-		if fs.config.UploadPartMaxTime > 0 {
-			u.ClientOptions = append(u.ClientOptions, func(o *s3.Options) {
-				o.HTTPClient = getAWSHTTPClient(fs.config.UploadPartMaxTime, 100*time.Millisecond,
-					fs.config.SkipTLSVerify)
-			})
-		}
-	})
+	var optFns []func(*s3.Options)
+	if fs.config.UploadPartMaxTime > 0 {
+		optFns = append(optFns, func(o *s3.Options) {
+			o.HTTPClient = getAWSHTTPClient(fs.config.UploadPartMaxTime, 100*time.Millisecond,
+				fs.config.SkipTLSVerify, fs.config.OutboundBindAddress)
+		})
+	}
 
 	go func() {
 		defer cancelFn()
 
-		var contentType string
+		var contentType, tagging string
+		var body io.Reader = r
+		storageClass := types.StorageClass(fs.config.StorageClass)
 		if flag == -1 {
 			contentType = s3DirMimeType
 		} else {
-			contentType = mime.TypeByExtension(path.Ext(name))
+			storageClass, tagging = fs.getUploadAttrs(name)
+			var sniff bool
+			contentType, sniff = getUploadContentType(name, fs.config.ContentTypes)
+			if sniff {
+				contentType, body = sniffContentType(r)
+			}
 		}
-		_, err := uploader.Upload(ctx, &s3.PutObjectInput{
+		err := fs.uploadMultipart(ctx, body, &s3.PutObjectInput{
 			Bucket:               aws.String(fs.config.Bucket),
 			Key:                  aws.String(name),
-			Body:                 r,
+			Body:                 body,
 			ACL:                  types.ObjectCannedACL(fs.config.ACL),
-			StorageClass:         types.StorageClass(fs.config.StorageClass),
+			StorageClass:         storageClass,
 			ContentType:          util.NilIfEmpty(contentType),
+			Tagging:              util.NilIfEmpty(tagging),
 			SSECustomerKey:       util.NilIfEmpty(fs.sseCustomerKey),
 			SSECustomerAlgorithm: util.NilIfEmpty(fs.sseCustomerAlgo),
 			SSECustomerKeyMD5:    util.NilIfEmpty(fs.sseCustomerKeyMD5),
-		})
+		}, optFns...)
+		fs.invalidateListingCache(name)
 		r.CloseWithError(err) //nolint:errcheck
 		p.Done(err)
-		fsLog(fs, logger.LevelDebug, "upload completed, path: %q, acl: %q, readed bytes: %d, err: %+v",
-			name, fs.config.ACL, r.GetReadedBytes(), err)
+		fsLog(fs, logger.LevelDebug, "upload completed, path: %q, acl: %q, content type: %q, readed bytes: %d, err: %+v",
+			name, fs.config.ACL, contentType, r.GetReadedBytes(), err)
 		metric.S3TransferCompleted(r.GetReadedBytes(), 0, err)
 	}()
 
@@ -399,9 +427,63 @@ func (fs *S3Fs) Remove(name string, isDir bool) error {
 		Key:    aws.String(name),
 	})
 	metric.S3DeleteObjectCompleted(err)
+	fs.invalidateListingCache(name)
 	return err
 }
 
+// RemoveFiles implements the FsBatchRemover interface. The files are removed
+// using DeleteObjects requests, each request removes up to 1000 objects
+func (fs *S3Fs) RemoveFiles(names []string) ([]string, error) {
+	ctx := fs.cancelSignal.context()
+	removed := make([]string, 0, len(names))
+	failed := make(map[string]error)
+
+	for start := 0; start < len(names); start += s3DeleteObjectsMaxKeys {
+		batch := names[start:min(start+s3DeleteObjectsMaxKeys, len(names))]
+		if err := ctx.Err(); err != nil {
+			for _, name := range names[start:] {
+				failed[name] = err
+			}
+			break
+		}
+		objects := make([]types.ObjectIdentifier, 0, len(batch))
+		for _, name := range batch {
+			objects = append(objects, types.ObjectIdentifier{Key: aws.String(name)})
+		}
+		deleteCtx, cancelFn := context.WithDeadline(ctx, time.Now().Add(fs.ctxTimeout))
+		res, err := fs.svc.DeleteObjects(deleteCtx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(fs.config.Bucket),
+			Delete: &types.Delete{
+				Objects: objects,
+				Quiet:   aws.Bool(true),
+			},
+		})
+		cancelFn()
+		metric.S3DeleteObjectCompleted(err)
+		if err != nil {
+			for _, name := range batch {
+				failed[name] = err
+			}
+			continue
+		}
+		batchFailed := make(map[string]bool)
+		for _, e := range res.Errors {
+			name := util.GetStringFromPointer(e.Key)
+			batchFailed[name] = true
+			failed[name] = fmt.Errorf("%s: %s", util.GetStringFromPointer(e.Code), util.GetStringFromPointer(e.Message))
+		}
+		for _, name := range batch {
+			if !batchFailed[name] {
+				removed = append(removed, name)
+			}
+		}
+	}
+	for _, name := range names {
+		fs.invalidateListingCache(name)
+	}
+	return removed, newBatchError("remove", failed)
+}
+
 // Mkdir creates a new directory with the specified name and default permissions
 func (fs *S3Fs) Mkdir(name string) error {
 	_, err := fs.Stat(name)
@@ -452,15 +534,21 @@ func (fs *S3Fs) ReadDir(dirname string) (DirLister, error) {
 		Bucket:    aws.String(fs.config.Bucket),
 		Prefix:    aws.String(prefix),
 		Delimiter: aws.String("/"),
-		MaxKeys:   &s3DefaultPageSize,
+		MaxKeys:   aws.Int32(getListingPageSize()),
 	})
 
-	return &s3DirLister{
-		paginator: paginator,
-		timeout:   fs.ctxTimeout,
-		prefix:    prefix,
-		prefixes:  make(map[string]bool),
-	}, nil
+	// the listing is cached again while the pages are read
+	fs.listingCache.invalidate(prefix)
+
+	return fs.limitDirLister(fs, dirname, &s3DirLister{
+		paginator:    paginator,
+		ctx:          fs.cancelSignal.context(),
+		timeout:      fs.ctxTimeout,
+		prefix:       prefix,
+		prefixes:     make(map[string]bool),
+		listingCache: fs.listingCache,
+		keyPrefix:    fs.config.KeyPrefix,
+	}), nil
 }
 
 // IsUploadResumeSupported returns true if resuming uploads is supported.
@@ -476,10 +564,12 @@ func (*S3Fs) IsConditionalUploadResumeSupported(size int64) bool {
 }
 
 // IsAtomicUploadSupported returns true if atomic upload is supported.
-// S3 uploads are already atomic, we don't need to upload to a temporary
-// file
-func (*S3Fs) IsAtomicUploadSupported() bool {
-	return false
+// S3 uploads are already atomic, but the objects are visible as soon as the
+// upload completes. If the cloud atomic uploads are enabled the files are
+// uploaded to a temporary object and moved to the requested path after the
+// post-upload checks
+func (fs *S3Fs) IsAtomicUploadSupported() bool {
+	return HasCloudAtomicUploads(fs)
 }
 
 // IsNotExist returns a boolean indicating whether the error is known to
@@ -504,6 +594,9 @@ func (*S3Fs) IsPermission(err error) bool {
 	if err == nil {
 		return false
 	}
+	if errors.Is(err, os.ErrPermission) {
+		return true
+	}
 
 	var re *awshttp.ResponseError
 	if errors.As(err, &re) {
@@ -546,11 +639,11 @@ func (fs *S3Fs) GetDirSize(dirname string) (int, int64, error) {
 	paginator := s3.NewListObjectsV2Paginator(fs.svc, &s3.ListObjectsV2Input{
 		Bucket:  aws.String(fs.config.Bucket),
 		Prefix:  aws.String(prefix),
-		MaxKeys: &s3DefaultPageSize,
+		MaxKeys: aws.Int32(getListingPageSize()),
 	})
 
 	for paginator.HasMorePages() {
-		ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+		ctx, cancelFn := context.WithDeadline(fs.cancelSignal.context(), time.Now().Add(fs.ctxTimeout))
 		defer cancelFn()
 
 		page, err := paginator.NextPage(ctx)
@@ -559,9 +652,10 @@ func (fs *S3Fs) GetDirSize(dirname string) (int, int64, error) {
 			return numFiles, size, err
 		}
 		for _, fileObject := range page.Contents {
-			isDir := strings.HasSuffix(util.GetStringFromPointer(fileObject.Key), "/")
+			key := util.GetStringFromPointer(fileObject.Key)
+			isDir := strings.HasSuffix(key, "/")
 			objectSize := util.GetIntFromPointer(fileObject.Size)
-			if isDir && objectSize == 0 {
+			if (isDir && objectSize == 0) || isCloudAtomicUploadKey(fs.config.KeyPrefix, key) {
 				continue
 			}
 			numFiles++
@@ -575,9 +669,9 @@ func (fs *S3Fs) GetDirSize(dirname string) (int, int64, error) {
 }
 
 // GetAtomicUploadPath returns the path to use for an atomic upload.
-// S3 uploads are already atomic, we never call this method for S3
-func (*S3Fs) GetAtomicUploadPath(_ string) string {
-	return ""
+// This is a temporary object inside the configured atomic upload prefix
+func (fs *S3Fs) GetAtomicUploadPath(name string) string {
+	return getCloudAtomicUploadPath(fs.config.KeyPrefix, name)
 }
 
 // GetRelativePath returns the path for a file relative to the user's home dir.
@@ -610,11 +704,11 @@ func (fs *S3Fs) Walk(root string, walkFn filepath.WalkFunc) error {
 	paginator := s3.NewListObjectsV2Paginator(fs.svc, &s3.ListObjectsV2Input{
 		Bucket:  aws.String(fs.config.Bucket),
 		Prefix:  aws.String(prefix),
-		MaxKeys: &s3DefaultPageSize,
+		MaxKeys: aws.Int32(getListingPageSize()),
 	})
 
 	for paginator.HasMorePages() {
-		ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+		ctx, cancelFn := context.WithDeadline(fs.cancelSignal.context(), time.Now().Add(fs.ctxTimeout))
 		defer cancelFn()
 
 		page, err := paginator.NextPage(ctx)
@@ -660,7 +754,12 @@ func (fs *S3Fs) ResolvePath(virtualPath string) (string, error) {
 	if !path.IsAbs(virtualPath) {
 		virtualPath = path.Clean("/" + virtualPath)
 	}
-	return fs.Join(fs.config.KeyPrefix, strings.TrimPrefix(virtualPath, "/")), nil
+	name := fs.Join(fs.config.KeyPrefix, strings.TrimPrefix(virtualPath, "/"))
+	if isCloudAtomicUploadKey(fs.config.KeyPrefix, name) {
+		// the temporary objects of the atomic uploads are not accessible by clients
+		return "", os.ErrPermission
+	}
+	return name, nil
 }
 
 // CopyFile implements the FsFileCopier interface
@@ -692,6 +791,18 @@ func (fs *S3Fs) resolve(name *string, prefix string) (string, bool) {
 }
 
 func (fs *S3Fs) setConfigDefaults() {
+	if fs.config.UploadPartSize == 0 {
+		fs.config.UploadPartSize = s3TransferDefaults.UploadPartSize
+	}
+	if fs.config.UploadConcurrency == 0 {
+		fs.config.UploadConcurrency = s3TransferDefaults.UploadConcurrency
+	}
+	if fs.config.DownloadPartSize == 0 {
+		fs.config.DownloadPartSize = s3TransferDefaults.DownloadPartSize
+	}
+	if fs.config.DownloadConcurrency == 0 {
+		fs.config.DownloadConcurrency = s3TransferDefaults.DownloadConcurrency
+	}
 	if fs.config.UploadPartSize == 0 {
 		fs.config.UploadPartSize = manager.DefaultUploadPartSize
 	} else {
@@ -717,22 +828,46 @@ func (fs *S3Fs) setConfigDefaults() {
 func (fs *S3Fs) copyFileInternal(source, target string, srcInfo os.FileInfo) error {
 	contentType := mime.TypeByExtension(path.Ext(source))
 	copySource := pathEscape(fs.Join(fs.config.Bucket, source))
+	storageClass, tagging := fs.getUploadAttrs(target)
+	defer fs.invalidateListingCache(target)
 
 	if srcInfo.Size() > s3CopyObjectThreshold {
 		fsLog(fs, logger.LevelDebug, "renaming file %q with size %d using multipart copy",
 			source, srcInfo.Size())
-		err := fs.doMultipartCopy(copySource, target, contentType, srcInfo.Size())
+		// multipart uploads don't copy the source metadata and tags
+		obj, err := fs.headObject(source)
+		if err != nil {
+			return err
+		}
+		if srcContentType := util.GetStringFromPointer(obj.ContentType); srcContentType != "" {
+			contentType = srcContentType
+		}
+		if tagging == "" {
+			tagging = fs.getObjectTagging(source)
+		}
+		err = fs.doMultipartCopy(copySource, target, &s3.CreateMultipartUploadInput{
+			Bucket:               aws.String(fs.config.Bucket),
+			Key:                  aws.String(target),
+			StorageClass:         storageClass,
+			ACL:                  types.ObjectCannedACL(fs.config.ACL),
+			ContentType:          util.NilIfEmpty(contentType),
+			Metadata:             obj.Metadata,
+			Tagging:              util.NilIfEmpty(tagging),
+			SSECustomerKey:       util.NilIfEmpty(fs.sseCustomerKey),
+			SSECustomerAlgorithm: util.NilIfEmpty(fs.sseCustomerAlgo),
+			SSECustomerKeyMD5:    util.NilIfEmpty(fs.sseCustomerKeyMD5),
+		}, srcInfo.Size())
 		metric.S3CopyObjectCompleted(err)
 		return err
 	}
-	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	ctx, cancelFn := context.WithDeadline(fs.cancelSignal.context(), time.Now().Add(fs.ctxTimeout))
 	defer cancelFn()
 
 	copyObject := &s3.CopyObjectInput{
 		Bucket:                         aws.String(fs.config.Bucket),
 		CopySource:                     aws.String(copySource),
 		Key:                            aws.String(target),
-		StorageClass:                   types.StorageClass(fs.config.StorageClass),
+		StorageClass:                   storageClass,
 		ACL:                            types.ObjectCannedACL(fs.config.ACL),
 		ContentType:                    util.NilIfEmpty(contentType),
 		CopySourceSSECustomerKey:       util.NilIfEmpty(fs.sseCustomerKey),
@@ -742,6 +877,11 @@ func (fs *S3Fs) copyFileInternal(source, target string, srcInfo os.FileInfo) err
 		SSECustomerAlgorithm:           util.NilIfEmpty(fs.sseCustomerAlgo),
 		SSECustomerKeyMD5:              util.NilIfEmpty(fs.sseCustomerKeyMD5),
 	}
+	if tagging != "" {
+		// the source tags are copied by default
+		copyObject.Tagging = aws.String(tagging)
+		copyObject.TaggingDirective = types.TaggingDirectiveReplace
+	}
 
 	_, err := fs.svc.CopyObject(ctx, copyObject)
 
@@ -769,7 +909,10 @@ func (fs *S3Fs) renameInternal(source, target string, srcInfo os.FileInfo, recur
 			return numFiles, filesSize, err
 		}
 		if renameMode == 1 {
-			files, size, err := doRecursiveRename(fs, source, target, fs.renameInternal, recursion, updateModTime)
+			files, size, err := doRecursiveRename(fs.cancelSignal.context(), fs, source, target, fs.renameInternal,
+				func(source, target string, srcInfo os.FileInfo, _ bool) error {
+					return fs.copyFileInternal(source, target, srcInfo)
+				}, recursion, updateModTime)
 			numFiles += files
 			filesSize += size
 			if err != nil {
@@ -811,7 +954,7 @@ func (fs *S3Fs) hasContents(name string) (bool, error) {
 	})
 
 	if paginator.HasMorePages() {
-		ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+		ctx, cancelFn := context.WithDeadline(fs.cancelSignal.context(), time.Now().Add(fs.ctxTimeout))
 		defer cancelFn()
 
 		page, err := paginator.NextPage(ctx)
@@ -834,20 +977,15 @@ func (fs *S3Fs) hasContents(name string) (bool, error) {
 	return false, nil
 }
 
-func (fs *S3Fs) doMultipartCopy(source, target, contentType string, fileSize int64) error {
+// doMultipartCopy copies the source object to the specified target using a
+// multipart upload, up to s3MultipartCopyConcurrency parts are copied in parallel.
+// The multipart upload is aborted on error
+func (fs *S3Fs) doMultipartCopy(source, target string, input *s3.CreateMultipartUploadInput, fileSize int64) error {
+	startTime := time.Now()
 	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
 	defer cancelFn()
 
-	res, err := fs.svc.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
-		Bucket:               aws.String(fs.config.Bucket),
-		Key:                  aws.String(target),
-		StorageClass:         types.StorageClass(fs.config.StorageClass),
-		ACL:                  types.ObjectCannedACL(fs.config.ACL),
-		ContentType:          util.NilIfEmpty(contentType),
-		SSECustomerKey:       util.NilIfEmpty(fs.sseCustomerKey),
-		SSECustomerAlgorithm: util.NilIfEmpty(fs.sseCustomerAlgo),
-		SSECustomerKeyMD5:    util.NilIfEmpty(fs.sseCustomerKeyMD5),
-	})
+	res, err := fs.svc.CreateMultipartUpload(ctx, input)
 	if err != nil {
 		return fmt.Errorf("unable to create multipart copy request: %w", err)
 	}
@@ -855,13 +993,67 @@ func (fs *S3Fs) doMultipartCopy(source, target, contentType string, fileSize int
 	if uploadID == "" {
 		return errors.New("unable to get multipart copy upload ID")
 	}
-	// We use 32 MB part size and copy 10 parts in parallel.
-	// These values are arbitrary. We don't want to start too many goroutines
-	maxPartSize := int64(32 * 1024 * 1024)
+	completedParts, err := fs.copyParts(source, target, uploadID, fileSize)
+	if err == nil {
+		completeCtx, completeCancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+		defer completeCancelFn()
+
+		_, err = fs.svc.CompleteMultipartUpload(completeCtx, &s3.CompleteMultipartUploadInput{
+			Bucket:   aws.String(fs.config.Bucket),
+			Key:      aws.String(target),
+			UploadId: aws.String(uploadID),
+			MultipartUpload: &types.CompletedMultipartUpload{
+				Parts: completedParts,
+			},
+			SSECustomerKey:       util.NilIfEmpty(fs.sseCustomerKey),
+			SSECustomerAlgorithm: util.NilIfEmpty(fs.sseCustomerAlgo),
+			SSECustomerKeyMD5:    util.NilIfEmpty(fs.sseCustomerKeyMD5),
+		})
+		if err != nil {
+			err = fmt.Errorf("unable to complete multipart upload: %w", err)
+		}
+	}
+	if err != nil {
+		abortCtx, abortCancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+		defer abortCancelFn()
+
+		_, errAbort := fs.svc.AbortMultipartUpload(abortCtx, &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(fs.config.Bucket),
+			Key:      aws.String(target),
+			UploadId: aws.String(uploadID),
+		})
+		if errAbort != nil {
+			fsLog(fs, logger.LevelError, "unable to abort multipart copy: %+v", errAbort)
+		}
+		return err
+	}
+	fsLog(fs, logger.LevelDebug, "multipart copy completed, source: %q, target: %q, size: %d, parts: %d, elapsed: %s",
+		source, target, fileSize, len(completedParts), time.Since(startTime))
+	return nil
+}
+
+// getMultipartCopyPartSize returns the part size for a multipart copy.
+// We use 32 MB part size for files up to 100GB, 500MB for larger files.
+// These values are arbitrary, the part size is increased if required to
+// respect the S3 parts limit
+func getMultipartCopyPartSize(fileSize int64) int64 {
+	partSize := int64(32 * 1024 * 1024)
 	if fileSize > int64(100*1024*1024*1024) {
-		maxPartSize = int64(500 * 1024 * 1024)
+		partSize = int64(500 * 1024 * 1024)
+	}
+	if minPartSize := (fileSize + s3MaxUploadParts - 1) / s3MaxUploadParts; minPartSize > partSize {
+		partSize = min(minPartSize, s3MaxPartSize)
 	}
-	guard := make(chan struct{}, 10)
+	return partSize
+}
+
+// copyParts copies the source object, in parallel, as parts of the specified
+// multipart upload and returns the completed parts sorted by part number
+func (fs *S3Fs) copyParts(source, target, uploadID string, fileSize int64) ([]types.CompletedPart, error) {
+	maxPartSize := getMultipartCopyPartSize(fileSize)
+	// the timeout for each part copy is proportional to the part size
+	partTimeout := fs.ctxTimeout * time.Duration(max(1, maxPartSize/(32*1024*1024)))
+	guard := make(chan struct{}, s3MultipartCopyConcurrency)
 	finished := false
 	var completedParts []types.CompletedPart
 	var partMutex sync.Mutex
@@ -872,7 +1064,7 @@ func (fs *S3Fs) doMultipartCopy(source, target, contentType string, fileSize int
 	var partNumber int32
 	var offset int64
 
-	opCtx, opCancel := context.WithCancel(context.Background())
+	opCtx, opCancel := context.WithCancel(fs.cancelSignal.context())
 	defer opCancel()
 
 	for partNumber = 1; !finished; partNumber++ {
@@ -897,7 +1089,7 @@ func (fs *S3Fs) doMultipartCopy(source, target, contentType string, fileSize int
 				wg.Done()
 			}()
 
-			innerCtx, innerCancelFn := context.WithDeadline(opCtx, time.Now().Add(fs.ctxTimeout))
+			innerCtx, innerCancelFn := context.WithDeadline(opCtx, time.Now().Add(partTimeout))
 			defer innerCancelFn()
 
 			partResp, err := fs.svc.UploadPartCopy(innerCtx, &s3.UploadPartCopyInput{
@@ -920,18 +1112,6 @@ func (fs *S3Fs) doMultipartCopy(source, target, contentType string, fileSize int
 					hasError.Store(true)
 					copyError = fmt.Errorf("error copying part number %d: %w", partNum, err)
 					opCancel()
-
-					abortCtx, abortCancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
-					defer abortCancelFn()
-
-					_, errAbort := fs.svc.AbortMultipartUpload(abortCtx, &s3.AbortMultipartUploadInput{
-						Bucket:   aws.String(fs.config.Bucket),
-						Key:      aws.String(target),
-						UploadId: aws.String(uploadID),
-					})
-					if errAbort != nil {
-						fsLog(fs, logger.LevelError, "unable to abort multipart copy: %+v", errAbort)
-					}
 				})
 				return
 			}
@@ -949,34 +1129,254 @@ func (fs *S3Fs) doMultipartCopy(source, target, contentType string, fileSize int
 	close(guard)
 
 	if copyError != nil {
-		return copyError
+		return nil, copyError
 	}
 	sort.Slice(completedParts, func(i, j int) bool {
-		getPartNumber := func(number *int32) int32 {
-			if number == nil {
-				return 0
-			}
-			return *number
+		return aws.ToInt32(completedParts[i].PartNumber) < aws.ToInt32(completedParts[j].PartNumber)
+	})
+	return completedParts, nil
+}
+
+// uploadMultipart uploads the contents read from the given reader.
+// Contents smaller than the part size are uploaded using a single PutObject
+// request, otherwise a multipart upload is started and up to UploadConcurrency
+// parts are uploaded in parallel. Each part is buffered in memory, so a single
+// upload uses up to (UploadConcurrency + 1) * part size bytes, for example 30MB
+// using 5MB parts and a concurrency of 5. The part buffers are taken from the
+// shared transfer buffers pool, if enabled. The part size grows as described in
+// getS3UploadPartSize, so files larger than 10000 * UploadPartSize can be
+// uploaded without exceeding the S3 parts limit
+func (fs *S3Fs) uploadMultipart(ctx context.Context, reader io.Reader, input *s3.PutObjectInput,
+	optFns ...func(*s3.Options),
+) error {
+	buf, releaseBuf := GetTransferBuffer(int(getS3UploadPartSize(fs.config.UploadPartSize, 1)))
+	n, err := io.ReadFull(reader, buf)
+	if err != nil {
+		defer releaseBuf()
+
+		if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return err
 		}
+		input.Body = bytes.NewReader(buf[:n])
+		input.ContentLength = aws.Int64(int64(n))
+		_, err = fs.svc.PutObject(ctx, input, optFns...)
+		return err
+	}
 
-		return getPartNumber(completedParts[i].PartNumber) < getPartNumber(completedParts[j].PartNumber)
+	res, err := fs.svc.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:               input.Bucket,
+		Key:                  input.Key,
+		ACL:                  input.ACL,
+		StorageClass:         input.StorageClass,
+		ContentType:          input.ContentType,
+		Tagging:              input.Tagging,
+		SSECustomerKey:       input.SSECustomerKey,
+		SSECustomerAlgorithm: input.SSECustomerAlgorithm,
+		SSECustomerKeyMD5:    input.SSECustomerKeyMD5,
 	})
+	if err != nil {
+		releaseBuf()
+		return fmt.Errorf("unable to create multipart upload request: %w", err)
+	}
+	uploadID := util.GetStringFromPointer(res.UploadId)
+	if uploadID == "" {
+		releaseBuf()
+		return errors.New("unable to get multipart upload ID")
+	}
+	completedParts, err := fs.uploadParts(ctx, reader, input, uploadID, buf, releaseBuf, optFns...)
+	if err == nil {
+		_, err = fs.svc.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+			Bucket:   input.Bucket,
+			Key:      input.Key,
+			UploadId: aws.String(uploadID),
+			MultipartUpload: &types.CompletedMultipartUpload{
+				Parts: completedParts,
+			},
+			SSECustomerKey:       input.SSECustomerKey,
+			SSECustomerAlgorithm: input.SSECustomerAlgorithm,
+			SSECustomerKeyMD5:    input.SSECustomerKeyMD5,
+		})
+		if err != nil {
+			err = fmt.Errorf("unable to complete multipart upload: %w", err)
+		}
+	}
+	if err != nil {
+		abortCtx, abortCancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+		defer abortCancelFn()
+
+		_, errAbort := fs.svc.AbortMultipartUpload(abortCtx, &s3.AbortMultipartUploadInput{
+			Bucket:   input.Bucket,
+			Key:      input.Key,
+			UploadId: aws.String(uploadID),
+		})
+		if errAbort != nil {
+			fsLog(fs, logger.LevelError, "unable to abort multipart upload: %+v", errAbort)
+		}
+	}
+	return err
+}
+
+// uploadParts uploads the parts for the specified multipart upload, the first
+// part is already read in buf. The part buffers are released after uploading
+// the parts
+func (fs *S3Fs) uploadParts(ctx context.Context, reader io.Reader, input *s3.PutObjectInput, uploadID string,
+	buf []byte, releaseBuf func(), optFns ...func(*s3.Options),
+) ([]types.CompletedPart, error) {
+	guard := make(chan struct{}, fs.config.UploadConcurrency)
+	finished := false
+	var completedParts []types.CompletedPart
+	var partMutex sync.Mutex
+	var wg sync.WaitGroup
+	var hasError atomic.Bool
+	var errOnce sync.Once
+	var uploadError error
+	var partNumber int32
+
+	opCtx, opCancel := context.WithCancel(ctx)
+	defer opCancel()
+
+	n := len(buf)
+	for partNumber = 1; !finished; partNumber++ {
+		if partNumber > 1 {
+			var err error
+			buf, releaseBuf = GetTransferBuffer(int(getS3UploadPartSize(fs.config.UploadPartSize, partNumber)))
+			n, err = io.ReadFull(reader, buf)
+			if err != nil {
+				if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+					releaseBuf()
+					errOnce.Do(func() {
+						hasError.Store(true)
+						uploadError = err
+						opCancel()
+					})
+					break
+				}
+				if n == 0 {
+					releaseBuf()
+					break
+				}
+				finished = true
+			}
+		}
+		if partNumber > s3MaxUploadParts {
+			releaseBuf()
+			errOnce.Do(func() {
+				hasError.Store(true)
+				uploadError = fmt.Errorf("the file exceeds the maximum number of parts: %d", s3MaxUploadParts)
+				opCancel()
+			})
+			break
+		}
 
-	completeCtx, completeCancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
-	defer completeCancelFn()
+		guard <- struct{}{}
+		if hasError.Load() {
+			releaseBuf()
+			fsLog(fs, logger.LevelDebug, "previous multipart upload error, upload for part %d not started", partNumber)
+			break
+		}
 
-	_, err = fs.svc.CompleteMultipartUpload(completeCtx, &s3.CompleteMultipartUploadInput{
-		Bucket:   aws.String(fs.config.Bucket),
-		Key:      aws.String(target),
-		UploadId: aws.String(uploadID),
-		MultipartUpload: &types.CompletedMultipartUpload{
-			Parts: completedParts,
-		},
+		wg.Add(1)
+		go func(partNum int32, partData []byte, releasePart func()) {
+			defer func() {
+				releasePart()
+				<-guard
+				wg.Done()
+			}()
+
+			partResp, err := fs.svc.UploadPart(opCtx, &s3.UploadPartInput{
+				Bucket:               input.Bucket,
+				Key:                  input.Key,
+				PartNumber:           &partNum,
+				UploadId:             aws.String(uploadID),
+				Body:                 bytes.NewReader(partData),
+				ContentLength:        aws.Int64(int64(len(partData))),
+				SSECustomerKey:       input.SSECustomerKey,
+				SSECustomerAlgorithm: input.SSECustomerAlgorithm,
+				SSECustomerKeyMD5:    input.SSECustomerKeyMD5,
+			}, optFns...)
+			if err != nil {
+				errOnce.Do(func() {
+					fsLog(fs, logger.LevelError, "unable to upload part number %d: %+v", partNum, err)
+					hasError.Store(true)
+					uploadError = fmt.Errorf("error uploading part number %d: %w", partNum, err)
+					opCancel()
+				})
+				return
+			}
+
+			partMutex.Lock()
+			completedParts = append(completedParts, types.CompletedPart{
+				ETag:       partResp.ETag,
+				PartNumber: &partNum,
+			})
+			partMutex.Unlock()
+		}(partNumber, buf[:n], releaseBuf)
+	}
+
+	wg.Wait()
+	close(guard)
+
+	if uploadError != nil {
+		return nil, uploadError
+	}
+	sort.Slice(completedParts, func(i, j int) bool {
+		return aws.ToInt32(completedParts[i].PartNumber) < aws.ToInt32(completedParts[j].PartNumber)
 	})
-	if err != nil {
-		return fmt.Errorf("unable to complete multipart upload: %w", err)
+	return completedParts, nil
+}
+
+// AbortIncompleteUploads aborts the multipart uploads, inside the configured
+// key prefix, initiated before the specified time. The limiter is used for both
+// the list and the abort requests. It returns the number of aborted uploads
+func (fs *S3Fs) AbortIncompleteUploads(ctx context.Context, initiatedBefore time.Time, limiter *rate.Limiter,
+) (int, error) {
+	paginator := s3.NewListMultipartUploadsPaginator(fs.svc, &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(fs.config.Bucket),
+		Prefix: util.NilIfEmpty(fs.config.KeyPrefix),
+	})
+	aborted := 0
+	var abortErr error
+
+	for paginator.HasMorePages() {
+		if err := limiter.Wait(ctx); err != nil {
+			return aborted, err
+		}
+		listCtx, cancelFn := context.WithDeadline(ctx, time.Now().Add(fs.ctxTimeout))
+		page, err := paginator.NextPage(listCtx)
+		cancelFn()
+		if err != nil {
+			metric.S3ListObjectsCompleted(err)
+			return aborted, err
+		}
+		for _, upload := range page.Uploads {
+			if upload.Initiated == nil || !upload.Initiated.Before(initiatedBefore) {
+				continue
+			}
+			if err := limiter.Wait(ctx); err != nil {
+				return aborted, err
+			}
+			key := util.GetStringFromPointer(upload.Key)
+			abortCtx, abortCancelFn := context.WithDeadline(ctx, time.Now().Add(fs.ctxTimeout))
+			_, err := fs.svc.AbortMultipartUpload(abortCtx, &s3.AbortMultipartUploadInput{
+				Bucket:   aws.String(fs.config.Bucket),
+				Key:      upload.Key,
+				UploadId: upload.UploadId,
+			})
+			abortCancelFn()
+			if err != nil {
+				fsLog(fs, logger.LevelWarn, "unable to abort multipart upload for key %q, initiated at %s: %v",
+					key, upload.Initiated, err)
+				abortErr = err
+				continue
+			}
+			fsLog(fs, logger.LevelDebug, "incomplete multipart upload for key %q, initiated at %s, aborted",
+				key, upload.Initiated)
+			aborted++
+		}
 	}
-	return nil
+	metric.S3ListObjectsCompleted(nil)
+	metric.S3MultipartUploadsAborted(aborted)
+	return aborted, abortErr
 }
 
 func (fs *S3Fs) getPrefix(name string) string {
@@ -990,6 +1390,13 @@ func (fs *S3Fs) getPrefix(name string) string {
 	return prefix
 }
 
+// invalidateListingCache removes the cached listings for the parent directory
+// of the specified name and for name itself, if it is a directory
+func (fs *S3Fs) invalidateListingCache(name string) {
+	name = strings.TrimSuffix(name, "/")
+	fs.listingCache.invalidate(fs.getPrefix(path.Dir(name)), fs.getPrefix(name))
+}
+
 func (fs *S3Fs) headObject(name string) (*s3.HeadObjectOutput, error) {
 	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
 	defer cancelFn()
@@ -1005,6 +1412,121 @@ func (fs *S3Fs) headObject(name string) (*s3.HeadObjectOutput, error) {
 	return obj, err
 }
 
+// GetStoredChecksum implements the FsChecksumGetter interface.
+// The ETag is the MD5 checksum only for the objects uploaded in a single part
+// and not encrypted using KMS or customer provided keys. The SHA-256 checksum
+// is available if saved as object metadata on upload
+func (fs *S3Fs) GetStoredChecksum(name, algo string) (string, error) {
+	if !fs.config.UseStoredChecksums || (algo != ChecksumMD5 && algo != ChecksumSHA256) {
+		return "", ErrChecksumUnavailable
+	}
+	obj, err := fs.headObject(name)
+	if err != nil {
+		return "", err
+	}
+	if algo == ChecksumSHA256 {
+		return getHexChecksum(obj.Metadata[ChecksumSHA256MetadataKey], sha256.Size)
+	}
+	switch obj.ServerSideEncryption {
+	case types.ServerSideEncryptionAwsKms, types.ServerSideEncryptionAwsKmsDsse:
+		return "", ErrChecksumUnavailable
+	}
+	if obj.SSECustomerAlgorithm != nil {
+		return "", ErrChecksumUnavailable
+	}
+	// the ETag for multipart uploads has a "-<number of parts>" suffix
+	// and so it is not a valid hex checksum
+	return getHexChecksum(strings.Trim(util.GetStringFromPointer(obj.ETag), `"`), md5.Size)
+}
+
+func (fs *S3Fs) getObjectMetadata(name string) (map[string]string, error) {
+	obj, err := fs.headObject(name)
+	if err != nil {
+		return nil, err
+	}
+	if obj.Metadata == nil {
+		return map[string]string{}, nil
+	}
+	return obj.Metadata, nil
+}
+
+// SetMetadata adds the specified metadata to the named file.
+// S3 metadata cannot be updated in place, so the object is copied onto itself,
+// objects larger than the multipart copy threshold are not supported
+func (fs *S3Fs) SetMetadata(name string, metadata map[string]string) error {
+	obj, err := fs.headObject(name)
+	if err != nil {
+		return err
+	}
+	if size := util.GetIntFromPointer(obj.ContentLength); size > s3CopyObjectThreshold {
+		return fmt.Errorf("%w: unable to set metadata for %q, size %d exceeds %d bytes", ErrVfsUnsupported,
+			name, size, s3CopyObjectThreshold)
+	}
+	objMetadata := make(map[string]string)
+	for k, v := range obj.Metadata {
+		objMetadata[k] = v
+	}
+	for k, v := range metadata {
+		objMetadata[k] = v
+	}
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	_, err = fs.svc.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:                         aws.String(fs.config.Bucket),
+		CopySource:                     aws.String(pathEscape(fs.Join(fs.config.Bucket, name))),
+		Key:                            aws.String(name),
+		StorageClass:                   obj.StorageClass,
+		ACL:                            types.ObjectCannedACL(fs.config.ACL),
+		ContentType:                    obj.ContentType,
+		Metadata:                       objMetadata,
+		MetadataDirective:              types.MetadataDirectiveReplace,
+		CopySourceSSECustomerKey:       util.NilIfEmpty(fs.sseCustomerKey),
+		CopySourceSSECustomerAlgorithm: util.NilIfEmpty(fs.sseCustomerAlgo),
+		CopySourceSSECustomerKeyMD5:    util.NilIfEmpty(fs.sseCustomerKeyMD5),
+		SSECustomerKey:                 util.NilIfEmpty(fs.sseCustomerKey),
+		SSECustomerAlgorithm:           util.NilIfEmpty(fs.sseCustomerAlgo),
+		SSECustomerKeyMD5:              util.NilIfEmpty(fs.sseCustomerKeyMD5),
+	})
+	metric.S3CopyObjectCompleted(err)
+	return err
+}
+
+// getUploadAttrs returns the storage class and the encoded tags to use for the
+// specified object key, based on the configured upload rules
+func (fs *S3Fs) getUploadAttrs(name string) (types.StorageClass, string) {
+	storageClass, tags := getS3UploadAttrs(fs.config.UploadRules, name)
+	if storageClass == "" && len(tags) == 0 {
+		return types.StorageClass(fs.config.StorageClass), ""
+	}
+	fsLog(fs, logger.LevelDebug, "upload rules applied for %q, storage class: %q, tags: %v", name, storageClass, tags)
+	if storageClass == "" {
+		storageClass = fs.config.StorageClass
+	}
+	return types.StorageClass(storageClass), encodeS3Tags(tags)
+}
+
+// getObjectTagging returns the encoded tags for the specified object,
+// errors are logged and ignored
+func (fs *S3Fs) getObjectTagging(name string) string {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	res, err := fs.svc.GetObjectTagging(ctx, &s3.GetObjectTaggingInput{
+		Bucket: aws.String(fs.config.Bucket),
+		Key:    aws.String(name),
+	})
+	if err != nil {
+		fsLog(fs, logger.LevelWarn, "unable to get tags for object %q: %v", name, err)
+		return ""
+	}
+	tags := make(map[string]string, len(res.TagSet))
+	for _, tag := range res.TagSet {
+		tags[util.GetStringFromPointer(tag.Key)] = util.GetStringFromPointer(tag.Value)
+	}
+	return encodeS3Tags(tags)
+}
+
 // GetMimeType returns the content type
 func (fs *S3Fs) GetMimeType(name string) (string, error) {
 	obj, err := fs.headObject(name)
@@ -1014,8 +1536,15 @@ func (fs *S3Fs) GetMimeType(name string) (string, error) {
 	return util.GetStringFromPointer(obj.ContentType), nil
 }
 
-// Close closes the fs
-func (*S3Fs) Close() error {
+// CancelOperations implements the FsOperationsCanceler interface
+func (fs *S3Fs) CancelOperations() {
+	fs.cancelSignal.cancel()
+}
+
+// Close closes the fs, the running listings, copies and recursive deletes
+// are canceled
+func (fs *S3Fs) Close() error {
+	fs.cancelSignal.cancel()
 	return nil
 }
 
@@ -1035,7 +1564,7 @@ func (fs *S3Fs) downloadToWriter(name string, w PipeWriter) (int64, error) {
 		if fs.config.DownloadPartMaxTime > 0 {
 			d.ClientOptions = append(d.ClientOptions, func(o *s3.Options) {
 				o.HTTPClient = getAWSHTTPClient(fs.config.DownloadPartMaxTime, 100*time.Millisecond,
-					fs.config.SkipTLSVerify)
+					fs.config.SkipTLSVerify, fs.config.OutboundBindAddress)
 			})
 		}
 	})
@@ -1056,10 +1585,13 @@ func (fs *S3Fs) downloadToWriter(name string, w PipeWriter) (int64, error) {
 type s3DirLister struct {
 	baseDirLister
 	paginator     *s3.ListObjectsV2Paginator
+	ctx           context.Context
 	timeout       time.Duration
 	prefix        string
 	prefixes      map[string]bool
 	metricUpdated bool
+	listingCache  *listingCache
+	keyPrefix     string
 }
 
 func (l *s3DirLister) resolve(name *string) (string, bool) {
@@ -1085,7 +1617,7 @@ func (l *s3DirLister) Next(limit int) ([]os.FileInfo, error) {
 		}
 		return l.returnFromCache(limit), io.EOF
 	}
-	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(l.timeout))
+	ctx, cancelFn := context.WithDeadline(l.ctx, time.Now().Add(l.timeout))
 	defer cancelFn()
 
 	page, err := l.paginator.NextPage(ctx)
@@ -1093,10 +1625,11 @@ func (l *s3DirLister) Next(limit int) ([]os.FileInfo, error) {
 		metric.S3ListObjectsCompleted(err)
 		return l.cache, err
 	}
+	numCached := len(l.cache)
 	for _, p := range page.CommonPrefixes {
 		// prefixes have a trailing slash
 		name, _ := l.resolve(p.Prefix)
-		if name == "" {
+		if name == "" || isCloudAtomicUploadKey(l.keyPrefix, util.GetStringFromPointer(p.Prefix)) {
 			continue
 		}
 		if _, ok := l.prefixes[name]; ok {
@@ -1121,6 +1654,7 @@ func (l *s3DirLister) Next(limit int) ([]os.FileInfo, error) {
 
 		l.cache = append(l.cache, NewFileInfo(name, (isDir && objectSize == 0), objectSize, objectModTime, false))
 	}
+	l.listingCache.add(l.prefix, l.cache[numCached:])
 	return l.returnFromCache(limit), nil
 }
 
@@ -1128,10 +1662,25 @@ func (l *s3DirLister) Close() error {
 	return l.baseDirLister.Close()
 }
 
-func getAWSHTTPClient(timeout int, idleConnectionTimeout time.Duration, skipTLSVerify bool) *awshttp.BuildableClient {
+// getHexChecksum returns the given checksum, lower cased, if it is a valid
+// hex encoded checksum of the specified size
+func getHexChecksum(checksum string, size int) (string, error) {
+	if len(checksum) != hex.EncodedLen(size) {
+		return "", ErrChecksumUnavailable
+	}
+	if _, err := hex.DecodeString(checksum); err != nil {
+		return "", ErrChecksumUnavailable
+	}
+	return strings.ToLower(checksum), nil
+}
+
+func getAWSHTTPClient(timeout int, idleConnectionTimeout time.Duration, skipTLSVerify bool,
+	bindAddress string,
+) *awshttp.BuildableClient {
 	c := awshttp.NewBuildableClient().
 		WithDialerOptions(func(d *net.Dialer) {
 			d.Timeout = 8 * time.Second
+			setDialerLocalAddr(d, bindAddress)
 		}).
 		WithTransportOptions(func(tr *http.Transport) {
 			tr.IdleConnTimeout = idleConnectionTimeout
@@ -1154,6 +1703,59 @@ func getAWSHTTPClient(timeout int, idleConnectionTimeout time.Duration, skipTLSV
 	return c
 }
 
+// s3AssumeRoleProvider wraps the AssumeRole credentials provider so that
+// the errors include the role ARN
+type s3AssumeRoleProvider struct {
+	provider aws.CredentialsProvider
+	roleARN  string
+}
+
+func (p *s3AssumeRoleProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	creds, err := p.provider.Retrieve(ctx)
+	if err != nil {
+		return creds, fmt.Errorf("unable to assume role %q: %w", p.roleARN, err)
+	}
+	return creds, nil
+}
+
+// getS3AssumeRoleCredentials returns a provider that assumes the configured
+// role using the credentials defined in awsConfig. The temporary credentials
+// are cached and refreshed before they expire. Each filesystem has its own
+// provider so different roles can be assumed concurrently
+func getS3AssumeRoleCredentials(awsConfig aws.Config, config *S3FsConfig) aws.CredentialsProvider {
+	client := sts.NewFromConfig(awsConfig)
+	provider := stscreds.NewAssumeRoleProvider(client, config.RoleARN, func(o *stscreds.AssumeRoleOptions) {
+		if config.ExternalID != "" {
+			o.ExternalID = aws.String(config.ExternalID)
+		}
+		if config.RoleSessionName != "" {
+			o.RoleSessionName = config.RoleSessionName
+		}
+	})
+	return aws.NewCredentialsCache(&s3AssumeRoleProvider{
+		provider: provider,
+		roleARN:  config.RoleARN,
+	}, func(o *aws.CredentialsCacheOptions) {
+		o.ExpiryWindow = s3AssumeRoleExpiryWindow
+	})
+}
+
+// addS3RequestPayer returns a middleware that adds the request payer header to
+// all the S3 requests. The header is added before signing the request
+func addS3RequestPayer(requestPayer string) func(*middleware.Stack) error {
+	return func(stack *middleware.Stack) error {
+		return stack.Build.Add(middleware.BuildMiddlewareFunc("SFTPGoRequestPayer",
+			func(ctx context.Context, in middleware.BuildInput, next middleware.BuildHandler) (
+				middleware.BuildOutput, middleware.Metadata, error,
+			) {
+				if req, ok := in.Request.(*smithyhttp.Request); ok {
+					req.Header.Set("X-Amz-Request-Payer", requestPayer)
+				}
+				return next.HandleBuild(ctx, in)
+			}), middleware.After)
+	}
+}
+
 // ideally we should simply use url.PathEscape:
 //
 // https://github.com/awsdocs/aws-doc-sdk-examples/blob/master/go/example_code/s3/s3_copy_object.go#L65
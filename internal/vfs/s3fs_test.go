@@ -0,0 +1,1119 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !nos3
+
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/sftpgo/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+
+	"github.com/drakkan/sftpgo/v2/internal/kms"
+)
+
+// mockS3Server implements the S3 APIs required for uploads and copies
+type mockS3Server struct {
+	sync.Mutex
+	objects       map[string][]byte
+	heads         map[string]http.Header
+	parts         map[int][]byte
+	copyRanges    map[int]string
+	createHeaders http.Header
+	deleted       []string
+	maxConcurrent int
+	concurrent    int
+	aborted       bool
+	abortedIDs    []string
+	listPrefix    string
+	listKeys      []string
+	listMaxKeys   []string
+	numHeads      int
+	// keys that cannot be removed using DeleteObjects
+	deleteErrors   map[string]bool
+	deleteRequests int
+}
+
+func (s *mockS3Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Has("partNumber") {
+		s.Lock()
+		s.concurrent++
+		s.maxConcurrent = max(s.maxConcurrent, s.concurrent)
+		s.Unlock()
+		defer func() {
+			s.Lock()
+			s.concurrent--
+			s.Unlock()
+		}()
+		time.Sleep(50 * time.Millisecond)
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	switch {
+	case r.Method == http.MethodGet && query.Has("uploads"):
+		s.Lock()
+		s.listPrefix = query.Get("prefix")
+		s.Unlock()
+		fmt.Fprintf(w, "<ListMultipartUploadsResult><Bucket>bucket</Bucket><IsTruncated>false</IsTruncated>"+
+			"<Upload><Key>prefix/old.bin</Key><UploadId>old</UploadId><Initiated>%s</Initiated></Upload>"+
+			"<Upload><Key>prefix/new.bin</Key><UploadId>new</UploadId><Initiated>%s</Initiated></Upload>"+
+			"</ListMultipartUploadsResult>", time.Now().Add(-48*time.Hour).UTC().Format(time.RFC3339),
+			time.Now().UTC().Format(time.RFC3339))
+	case r.Method == http.MethodGet && query.Get("list-type") == "2":
+		s.listObjects(w, query)
+	case r.Method == http.MethodHead:
+		s.Lock()
+		s.numHeads++
+		headers, ok := s.heads[r.URL.Path]
+		s.Unlock()
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		for k, v := range headers {
+			w.Header()[k] = v
+		}
+	case r.Method == http.MethodGet && query.Has("tagging"):
+		fmt.Fprintf(w, "<Tagging><TagSet><Tag><Key>team</Key><Value>data</Value></Tag></TagSet></Tagging>")
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		s.Lock()
+		s.createHeaders = r.Header.Clone()
+		s.Unlock()
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><UploadId>upload1</UploadId></InitiateMultipartUploadResult>")
+	case r.Method == http.MethodPut && query.Has("partNumber"):
+		partNumber, _ := strconv.Atoi(query.Get("partNumber"))
+		s.Lock()
+		s.parts[partNumber] = data
+		s.Unlock()
+		if r.Header.Get("X-Amz-Copy-Source") != "" {
+			s.Lock()
+			s.copyRanges[partNumber] = r.Header.Get("X-Amz-Copy-Source-Range")
+			s.Unlock()
+			fmt.Fprintf(w, "<CopyPartResult><ETag>\"etag%d\"</ETag></CopyPartResult>", partNumber)
+			return
+		}
+		w.Header().Set("ETag", fmt.Sprintf("\"etag%d\"", partNumber))
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		s.Lock()
+		var content []byte
+		keys := make([]int, 0, len(s.parts))
+		for k := range s.parts {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		for _, k := range keys {
+			content = append(content, s.parts[k]...)
+		}
+		s.objects[r.URL.Path] = content
+		s.Unlock()
+		fmt.Fprintf(w, "<CompleteMultipartUploadResult><ETag>\"etag\"</ETag></CompleteMultipartUploadResult>")
+	case r.Method == http.MethodPost && query.Has("delete"):
+		s.deleteObjects(w, data)
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		s.Lock()
+		s.aborted = true
+		s.abortedIDs = append(s.abortedIDs, query.Get("uploadId"))
+		s.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodDelete:
+		s.Lock()
+		s.deleted = append(s.deleted, r.URL.Path)
+		s.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		fmt.Fprintf(w, "<CopyObjectResult><ETag>\"etag\"</ETag></CopyObjectResult>")
+	case r.Method == http.MethodPut:
+		s.Lock()
+		s.objects[r.URL.Path] = data
+		s.Unlock()
+		w.Header().Set("ETag", "\"etag\"")
+	case r.Method == http.MethodGet:
+		s.Lock()
+		content, ok := s.objects[r.URL.Path]
+		s.Unlock()
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+// listObjects returns the configured keys, the continuation token is the
+// index of the first key to return. If a delimiter is requested, the keys
+// containing a slash are returned as common prefixes
+func (s *mockS3Server) listObjects(w http.ResponseWriter, query url.Values) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.listMaxKeys = append(s.listMaxKeys, query.Get("max-keys"))
+	maxKeys, _ := strconv.Atoi(query.Get("max-keys"))
+	start, _ := strconv.Atoi(query.Get("continuation-token"))
+	end := min(start+maxKeys, len(s.listKeys))
+	fmt.Fprintf(w, "<ListBucketResult><Name>bucket</Name><IsTruncated>%t</IsTruncated>", end < len(s.listKeys))
+	if end < len(s.listKeys) {
+		fmt.Fprintf(w, "<NextContinuationToken>%d</NextContinuationToken>", end)
+	}
+	for _, key := range s.listKeys[start:end] {
+		if dir, _, ok := strings.Cut(key, "/"); ok && query.Get("delimiter") == "/" {
+			fmt.Fprintf(w, "<CommonPrefixes><Prefix>%s%s/</Prefix></CommonPrefixes>", query.Get("prefix"), dir)
+			continue
+		}
+		fmt.Fprintf(w, "<Contents><Key>%s%s</Key><Size>%d</Size><LastModified>%s</LastModified></Contents>",
+			query.Get("prefix"), key, len(key), time.Now().UTC().Format(time.RFC3339))
+	}
+	fmt.Fprintf(w, "</ListBucketResult>")
+}
+
+// deleteObjects handles the DeleteObjects requests, the configured keys are
+// reported as failed
+func (s *mockS3Server) deleteObjects(w http.ResponseWriter, data []byte) {
+	var req struct {
+		Objects []struct {
+			Key string `xml:"Key"`
+		} `xml:"Object"`
+	}
+	if err := xml.Unmarshal(data, &req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	s.deleteRequests++
+	fmt.Fprintf(w, "<DeleteResult>")
+	for _, obj := range req.Objects {
+		if s.deleteErrors[obj.Key] {
+			fmt.Fprintf(w, "<Error><Key>%s</Key><Code>AccessDenied</Code><Message>Access Denied</Message></Error>",
+				obj.Key)
+			continue
+		}
+		s.deleted = append(s.deleted, "/bucket/"+obj.Key)
+	}
+	fmt.Fprintf(w, "</DeleteResult>")
+}
+
+func newMockS3Server() *mockS3Server {
+	return &mockS3Server{
+		objects:    make(map[string][]byte),
+		heads:      make(map[string]http.Header),
+		parts:      make(map[int][]byte),
+		copyRanges: make(map[int]string),
+	}
+}
+
+func newMockS3Fs(t testing.TB, endpoint string, partSize int64, concurrency int) *S3Fs {
+	config := S3FsConfig{
+		BaseS3FsConfig: sdk.BaseS3FsConfig{
+			Bucket:            "bucket",
+			Region:            "us-east-1",
+			AccessKey:         "access-key",
+			Endpoint:          endpoint,
+			UploadPartSize:    partSize,
+			UploadConcurrency: concurrency,
+			ForcePathStyle:    true,
+		},
+		AccessSecret: kms.NewPlainSecret("access-secret"),
+	}
+	fs, err := NewS3Fs("id", "", "", config)
+	require.NoError(t, err)
+	return fs.(*S3Fs)
+}
+
+func uploadToS3Fs(fs *S3Fs, name string, data []byte) error {
+	_, w, _, err := fs.Create(name, 0, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func TestS3MultipartUpload(t *testing.T) {
+	s := newMockS3Server()
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	partSize := int64(5)
+	fs := newMockS3Fs(t, server.URL, partSize, 2)
+	assert.Equal(t, partSize*1024*1024, fs.config.UploadPartSize)
+	// each upload buffers up to 3 parts: 2 in flight and the one being read
+	data := make([]byte, 2*fs.config.UploadPartSize+1024*1024)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+	err = uploadToS3Fs(fs, "dir/file.bin", data)
+	require.NoError(t, err)
+	s.Lock()
+	assert.Len(t, s.parts, 3)
+	assert.Len(t, s.parts[1], int(fs.config.UploadPartSize))
+	assert.Len(t, s.parts[3], 1024*1024)
+	assert.Equal(t, 2, s.maxConcurrent)
+	assert.True(t, bytes.Equal(data, s.objects["/bucket/dir/file.bin"]))
+	assert.False(t, s.aborted)
+	s.Unlock()
+	// a file smaller than the part size is uploaded using a single request
+	err = uploadToS3Fs(fs, "small.txt", data[:1024])
+	require.NoError(t, err)
+	s.Lock()
+	assert.True(t, bytes.Equal(data[:1024], s.objects["/bucket/small.txt"]))
+	s.Unlock()
+}
+
+func TestS3MultipartUploadError(t *testing.T) {
+	s := newMockS3Server()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("partNumber") == "2" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		s.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	fs := newMockS3Fs(t, server.URL, 5, 1)
+	data := make([]byte, 2*fs.config.UploadPartSize+1)
+	err := uploadToS3Fs(fs, "file.bin", data)
+	assert.Error(t, err)
+	s.Lock()
+	assert.True(t, s.aborted)
+	assert.NotContains(t, s.objects, "/bucket/file.bin")
+	s.Unlock()
+}
+
+func TestS3UploadPartSize(t *testing.T) {
+	partSize := int64(5 * 1024 * 1024)
+	assert.Equal(t, partSize, getS3UploadPartSize(partSize, 1))
+	assert.Equal(t, partSize, getS3UploadPartSize(partSize, 1000))
+	assert.Equal(t, 2*partSize, getS3UploadPartSize(partSize, 1001))
+	assert.Equal(t, 512*partSize, getS3UploadPartSize(partSize, 10000))
+	assert.Equal(t, int64(s3MaxPartSize), getS3UploadPartSize(5000*1024*1024, 1001))
+	// the maximum number of parts must address at least 5TB using the minimum part size
+	var total int64
+	for partNumber := int32(1); partNumber <= s3MaxUploadParts; partNumber++ {
+		total += getS3UploadPartSize(partSize, partNumber)
+	}
+	assert.Greater(t, total, int64(48*1024*1024*1024*1024/10))
+}
+
+func TestS3TransferDefaults(t *testing.T) {
+	err := SetS3TransferDefaults(S3TransferDefaults{UploadPartSize: 1})
+	assert.Error(t, err)
+	err = SetS3TransferDefaults(S3TransferDefaults{UploadConcurrency: 65})
+	assert.Error(t, err)
+	err = SetS3TransferDefaults(S3TransferDefaults{DownloadPartSize: 5001})
+	assert.Error(t, err)
+	err = SetS3TransferDefaults(S3TransferDefaults{
+		UploadPartSize:      10,
+		UploadConcurrency:   3,
+		DownloadPartSize:    20,
+		DownloadConcurrency: 4,
+	})
+	require.NoError(t, err)
+	defer func() {
+		err = SetS3TransferDefaults(S3TransferDefaults{})
+		assert.NoError(t, err)
+	}()
+
+	fs := newMockS3Fs(t, "http://127.0.0.1:9000", 0, 0)
+	assert.Equal(t, int64(10*1024*1024), fs.config.UploadPartSize)
+	assert.Equal(t, 3, fs.config.UploadConcurrency)
+	assert.Equal(t, int64(20*1024*1024), fs.config.DownloadPartSize)
+	assert.Equal(t, 4, fs.config.DownloadConcurrency)
+	// the filesystem settings take precedence
+	fs = newMockS3Fs(t, "http://127.0.0.1:9000", 5, 1)
+	assert.Equal(t, int64(5*1024*1024), fs.config.UploadPartSize)
+	assert.Equal(t, 1, fs.config.UploadConcurrency)
+}
+
+func TestS3OutboundBindAddress(t *testing.T) {
+	client := getAWSHTTPClient(0, 10*time.Second, false, "")
+	assert.Nil(t, client.GetDialer().LocalAddr)
+	client = getAWSHTTPClient(0, 10*time.Second, false, "127.0.0.1")
+	require.NotNil(t, client.GetDialer().LocalAddr)
+	assert.Equal(t, "127.0.0.1:0", client.GetDialer().LocalAddr.String())
+
+	config := S3FsConfig{}
+	config.Bucket = "bucket"
+	config.Region = "us-east-1"
+	config.OutboundBindAddress = "invalid"
+	err := config.validate()
+	assert.Error(t, err)
+	config.OutboundBindAddress = " 127.0.0.1 "
+	err = config.validate()
+	assert.NoError(t, err)
+	assert.Equal(t, "127.0.0.1", config.OutboundBindAddress)
+}
+
+func TestS3StoredChecksums(t *testing.T) {
+	md5Checksum := "9E107D9D372BB6826BD81D3542A419D6"
+	sha256Checksum := "d7a8fbb307d7809469ca9abcb0082e4f8d5651e46d3cdb762d02d0bf37c9e592"
+	lastModified := time.Now().UTC().Format(http.TimeFormat)
+	s := newMockS3Server()
+	s.heads["/bucket/plain.bin"] = http.Header{
+		"Content-Length":           []string{"43"},
+		"Last-Modified":            []string{lastModified},
+		"Etag":                     []string{`"` + md5Checksum + `"`},
+		"X-Amz-Meta-Sftpgo_sha256": []string{sha256Checksum},
+	}
+	s.heads["/bucket/multipart.bin"] = http.Header{
+		"Content-Length": []string{"43"},
+		"Last-Modified":  []string{lastModified},
+		"Etag":           []string{`"9e107d9d372bb6826bd81d3542a419d6-2"`},
+	}
+	s.heads["/bucket/kms.bin"] = http.Header{
+		"Content-Length":               []string{"43"},
+		"Last-Modified":                []string{lastModified},
+		"Etag":                         []string{`"9e107d9d372bb6826bd81d3542a419d6"`},
+		"X-Amz-Server-Side-Encryption": []string{"aws:kms"},
+	}
+	s.heads["/bucket/ssec.bin"] = http.Header{
+		"Content-Length": []string{"43"},
+		"Last-Modified":  []string{lastModified},
+		"Etag":           []string{`"9e107d9d372bb6826bd81d3542a419d6"`},
+		"X-Amz-Server-Side-Encryption-Customer-Algorithm": []string{"AES256"},
+	}
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	fs := newMockS3Fs(t, server.URL, 5, 1)
+	// disabled by default, no request is sent
+	_, err := fs.GetStoredChecksum("plain.bin", ChecksumMD5)
+	assert.ErrorIs(t, err, ErrChecksumUnavailable)
+	assert.Equal(t, 0, s.numHeads)
+
+	fs.config.UseStoredChecksums = true
+	checksum, err := fs.GetStoredChecksum("plain.bin", ChecksumMD5)
+	assert.NoError(t, err)
+	assert.Equal(t, strings.ToLower(md5Checksum), checksum)
+	checksum, err = fs.GetStoredChecksum("plain.bin", ChecksumSHA256)
+	assert.NoError(t, err)
+	assert.Equal(t, sha256Checksum, checksum)
+	_, err = fs.GetStoredChecksum("plain.bin", "sha1")
+	assert.ErrorIs(t, err, ErrChecksumUnavailable)
+	assert.Equal(t, 2, s.numHeads)
+	for _, name := range []string{"multipart.bin", "kms.bin", "ssec.bin"} {
+		_, err = fs.GetStoredChecksum(name, ChecksumMD5)
+		assert.ErrorIs(t, err, ErrChecksumUnavailable, name)
+	}
+	_, err = fs.GetStoredChecksum("multipart.bin", ChecksumSHA256)
+	assert.ErrorIs(t, err, ErrChecksumUnavailable)
+	_, err = fs.GetStoredChecksum("missing.bin", ChecksumMD5)
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrChecksumUnavailable)
+}
+
+func TestS3AbortIncompleteUploads(t *testing.T) {
+	s := newMockS3Server()
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	fs := newMockS3Fs(t, server.URL, 0, 0)
+	fs.config.KeyPrefix = "prefix/"
+	aborted, err := fs.AbortIncompleteUploads(context.Background(), time.Now().Add(-24*time.Hour),
+		rate.NewLimiter(rate.Inf, 0))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, aborted)
+	s.Lock()
+	assert.Equal(t, "prefix/", s.listPrefix)
+	assert.Equal(t, []string{"old"}, s.abortedIDs)
+	s.Unlock()
+
+	ctx, cancelFn := context.WithCancel(context.Background())
+	cancelFn()
+	_, err = fs.AbortIncompleteUploads(ctx, time.Now(), rate.NewLimiter(1, 1))
+	assert.Error(t, err)
+}
+
+func TestS3MultipartCopy(t *testing.T) {
+	s := newMockS3Server()
+	fileSize := int64(600 * 1024 * 1024)
+	s.heads["/bucket/big.bin"] = http.Header{
+		"Content-Length":   []string{strconv.FormatInt(fileSize, 10)},
+		"Content-Type":     []string{"application/x-custom"},
+		"Last-Modified":    []string{time.Now().UTC().Format(http.TimeFormat)},
+		"X-Amz-Meta-Owner": []string{"sftpgo"},
+	}
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	fs := newMockS3Fs(t, server.URL, 0, 0)
+	numFiles, size, err := fs.Rename("big.bin", "renamed.bin", 0)
+	require.NoError(t, err)
+	assert.Equal(t, 1, numFiles)
+	assert.Equal(t, fileSize, size)
+	s.Lock()
+	assert.Equal(t, "application/x-custom", s.createHeaders.Get("Content-Type"))
+	assert.Equal(t, "sftpgo", s.createHeaders.Get("X-Amz-Meta-Owner"))
+	assert.Equal(t, "team=data", s.createHeaders.Get("X-Amz-Tagging"))
+	partSize := getMultipartCopyPartSize(fileSize)
+	numParts := int((fileSize + partSize - 1) / partSize)
+	assert.Len(t, s.copyRanges, numParts)
+	assert.Equal(t, fmt.Sprintf("bytes=0-%d", partSize-1), s.copyRanges[1])
+	assert.Equal(t, fmt.Sprintf("bytes=%d-%d", int64(numParts-1)*partSize, fileSize-1), s.copyRanges[numParts])
+	assert.LessOrEqual(t, s.maxConcurrent, s3MultipartCopyConcurrency)
+	assert.Contains(t, s.objects, "/bucket/renamed.bin")
+	assert.Equal(t, []string{"/bucket/big.bin"}, s.deleted)
+	assert.False(t, s.aborted)
+	s.Unlock()
+}
+
+func TestS3MultipartCopyError(t *testing.T) {
+	s := newMockS3Server()
+	fileSize := int64(600 * 1024 * 1024)
+	s.heads["/bucket/big.bin"] = http.Header{
+		"Content-Length": []string{strconv.FormatInt(fileSize, 10)},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("partNumber") == "3" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		s.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	fs := newMockS3Fs(t, server.URL, 0, 0)
+	_, _, err := fs.Rename("big.bin", "renamed.bin", 0)
+	assert.Error(t, err)
+	s.Lock()
+	assert.True(t, s.aborted)
+	assert.NotContains(t, s.objects, "/bucket/renamed.bin")
+	assert.Empty(t, s.deleted)
+	s.Unlock()
+}
+
+func TestMultipartCopyPartSize(t *testing.T) {
+	assert.Equal(t, int64(32*1024*1024), getMultipartCopyPartSize(600*1024*1024))
+	assert.Equal(t, int64(500*1024*1024), getMultipartCopyPartSize(200*1024*1024*1024))
+	fileSize := int64(4 * 1024 * 1024 * 1024 * 1024)
+	partSize := getMultipartCopyPartSize(fileSize)
+	assert.LessOrEqual(t, (fileSize+partSize-1)/partSize, int64(s3MaxUploadParts))
+	assert.LessOrEqual(t, partSize, int64(s3MaxPartSize))
+}
+
+func TestS3RequestPayer(t *testing.T) {
+	s := newMockS3Server()
+	s.heads["/bucket/file.bin"] = http.Header{
+		"Content-Length": []string{"10"},
+		"Last-Modified":  []string{time.Now().UTC().Format(http.TimeFormat)},
+	}
+	var mu sync.Mutex
+	var requests []string
+	var payers []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.Method+" "+r.URL.RawQuery)
+		payers = append(payers, r.Header.Get("X-Amz-Request-Payer"))
+		mu.Unlock()
+		s.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	fs := newMockS3Fs(t, server.URL, 5, 1)
+	fs.config.KeyPrefix = "prefix/"
+	_, err := fs.Stat("file.bin")
+	assert.NoError(t, err)
+	mu.Lock()
+	assert.Equal(t, []string{""}, payers)
+	requests = nil
+	payers = nil
+	mu.Unlock()
+
+	config := S3FsConfig{
+		BaseS3FsConfig: sdk.BaseS3FsConfig{
+			Bucket:         "bucket",
+			Region:         "us-east-1",
+			AccessKey:      "access-key",
+			Endpoint:       server.URL,
+			ForcePathStyle: true,
+		},
+		AccessSecret: kms.NewPlainSecret("access-secret"),
+		RequestPayer: "bucketowner",
+	}
+	assert.Error(t, config.validate())
+	config.RequestPayer = "Requester "
+	err = config.validate()
+	require.NoError(t, err)
+	assert.Equal(t, s3RequestPayerRequester, config.RequestPayer)
+
+	config.UploadPartSize = 5
+	config.UploadConcurrency = 1
+	newFs, err := NewS3Fs("id", "", "", config)
+	require.NoError(t, err)
+	fs = newFs.(*S3Fs)
+	_, err = fs.Stat("file.bin")
+	assert.NoError(t, err)
+	err = uploadToS3Fs(fs, "small.bin", []byte("data"))
+	assert.NoError(t, err)
+	err = uploadToS3Fs(fs, "big.bin", make([]byte, 6*1024*1024))
+	assert.NoError(t, err)
+	_, _, err = fs.Rename("file.bin", "renamed.bin", 0)
+	assert.NoError(t, err)
+	_, err = fs.AbortIncompleteUploads(context.Background(), time.Now().Add(-24*time.Hour),
+		rate.NewLimiter(rate.Inf, 0))
+	assert.NoError(t, err)
+
+	mu.Lock()
+	defer mu.Unlock()
+	// head, put, create, 2 parts, complete, head, copy, delete, list, abort
+	assert.GreaterOrEqual(t, len(requests), 11)
+	for idx, payer := range payers {
+		assert.Equal(t, s3RequestPayerRequester, payer, "request %q", requests[idx])
+	}
+}
+
+func TestS3AssumeRole(t *testing.T) {
+	var mu sync.Mutex
+	var calls int
+	var roles []string
+	expiration := time.Now().Add(time.Minute)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		roleARN := r.PostForm.Get("RoleArn")
+		roles = append(roles, roleARN)
+		if strings.HasSuffix(roleARN, "/denied") {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprintf(w, "<ErrorResponse><Error><Type>Sender</Type><Code>AccessDenied</Code>"+
+				"<Message>not authorized</Message></Error></ErrorResponse>")
+			return
+		}
+		assert.Equal(t, "ext-id", r.PostForm.Get("ExternalId"))
+		assert.Equal(t, "sftpgo-session", r.PostForm.Get("RoleSessionName"))
+		calls++
+		fmt.Fprintf(w, "<AssumeRoleResponse><AssumeRoleResult><Credentials><AccessKeyId>key%d</AccessKeyId>"+
+			"<SecretAccessKey>secret</SecretAccessKey><SessionToken>token</SessionToken>"+
+			"<Expiration>%s</Expiration></Credentials></AssumeRoleResult></AssumeRoleResponse>",
+			calls, expiration.UTC().Format(time.RFC3339))
+		// the next credentials are valid for longer than the expiry window
+		expiration = time.Now().Add(time.Hour)
+	}))
+	defer server.Close()
+
+	awsConfig := aws.Config{
+		Region:       "us-east-1",
+		Credentials:  credentials.NewStaticCredentialsProvider("access-key", "access-secret", ""),
+		BaseEndpoint: aws.String(server.URL),
+	}
+	config := &S3FsConfig{
+		BaseS3FsConfig: sdk.BaseS3FsConfig{
+			RoleARN: "arn:aws:iam::123456789012:role/role1",
+		},
+		ExternalID:      "ext-id",
+		RoleSessionName: "sftpgo-session",
+	}
+	provider := getS3AssumeRoleCredentials(awsConfig, config)
+	creds, err := provider.Retrieve(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "key1", creds.AccessKeyID)
+	// the first credentials expire within the expiry window and so they are refreshed
+	creds, err = provider.Retrieve(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "key2", creds.AccessKeyID)
+	creds, err = provider.Retrieve(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "key2", creds.AccessKeyID)
+	mu.Lock()
+	assert.Equal(t, 2, calls)
+	mu.Unlock()
+	// different roles can be assumed concurrently
+	config2 := *config
+	config2.RoleARN = "arn:aws:iam::123456789012:role/role2"
+	provider2 := getS3AssumeRoleCredentials(awsConfig, &config2)
+	_, err = provider2.Retrieve(context.Background())
+	require.NoError(t, err)
+	mu.Lock()
+	assert.Equal(t, []string{config.RoleARN, config.RoleARN, config2.RoleARN}, roles)
+	mu.Unlock()
+	// refresh errors include the role
+	config2.RoleARN = "arn:aws:iam::123456789012:role/denied"
+	provider2 = getS3AssumeRoleCredentials(awsConfig, &config2)
+	_, err = provider2.Retrieve(context.Background())
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unable to assume role")
+		assert.Contains(t, err.Error(), config2.RoleARN)
+	}
+}
+
+func TestS3AssumeRoleValidation(t *testing.T) {
+	config := S3FsConfig{
+		BaseS3FsConfig: sdk.BaseS3FsConfig{
+			Bucket: "bucket",
+			Region: "us-east-1",
+		},
+		ExternalID: "ext-id",
+	}
+	assert.Error(t, config.validate())
+	config.ExternalID = ""
+	config.RoleSessionName = "session"
+	assert.Error(t, config.validate())
+	config.RoleARN = " arn:aws:iam::123456789012:role/role1 "
+	config.ExternalID = "a"
+	assert.Error(t, config.validate())
+	config.ExternalID = "ext id"
+	assert.Error(t, config.validate())
+	config.ExternalID = "ext:id/1"
+	assert.NoError(t, config.validate())
+	assert.Equal(t, "arn:aws:iam::123456789012:role/role1", config.RoleARN)
+	config.RoleSessionName = strings.Repeat("a", 65)
+	assert.Error(t, config.validate())
+	config.RoleSessionName = "session:1"
+	assert.Error(t, config.validate())
+	config.RoleSessionName = "session@sftpgo"
+	assert.NoError(t, config.validate())
+}
+
+func TestS3ReadDirPages(t *testing.T) {
+	err := SetListingConfig(ListingConfig{
+		PageSize: 2,
+		CacheTTL: 60,
+	})
+	require.NoError(t, err)
+	defer SetListingConfig(ListingConfig{}) //nolint:errcheck
+
+	s := newMockS3Server()
+	s.listKeys = []string{"a", "bb", "ccc", "dddd", "eeeee"}
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	fs := newMockS3Fs(t, server.URL, 0, 0)
+	lister, err := fs.ReadDir("dir")
+	require.NoError(t, err)
+	// each page is returned as soon as it is received
+	files, err := lister.Next(ListerBatchSize)
+	require.NoError(t, err)
+	if assert.Len(t, files, 2) {
+		assert.Equal(t, "a", files[0].Name())
+		assert.Equal(t, "bb", files[1].Name())
+	}
+	s.Lock()
+	assert.Equal(t, []string{"2"}, s.listMaxKeys)
+	s.Unlock()
+	var names []string
+	for {
+		files, err = lister.Next(ListerBatchSize)
+		for _, fi := range files {
+			names = append(names, fi.Name())
+		}
+		if err != nil {
+			break
+		}
+	}
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Equal(t, []string{"ccc", "dddd", "eeeee"}, names)
+	err = lister.Close()
+	assert.NoError(t, err)
+	s.Lock()
+	assert.Len(t, s.listMaxKeys, 3)
+	s.Unlock()
+	// the listed files are returned from the cache
+	info, err := fs.Stat("dir/dddd")
+	require.NoError(t, err)
+	assert.Equal(t, "dddd", info.Name())
+	assert.Equal(t, int64(4), info.Size())
+	assert.False(t, info.IsDir())
+	s.Lock()
+	assert.Equal(t, 0, s.numHeads)
+	s.Unlock()
+	// removing a file invalidates the cached listing
+	err = fs.Remove("dir/a", false)
+	assert.NoError(t, err)
+	_, err = fs.Stat("dir/dddd")
+	assert.NoError(t, err)
+	s.Lock()
+	assert.Equal(t, 1, s.numHeads)
+	s.Unlock()
+}
+
+func TestS3ReadDirMaxEntries(t *testing.T) {
+	err := SetListingConfig(ListingConfig{
+		PageSize:   2,
+		MaxEntries: 3,
+	})
+	require.NoError(t, err)
+	defer SetListingConfig(ListingConfig{}) //nolint:errcheck
+
+	s := newMockS3Server()
+	s.listKeys = []string{"a", "b", "c", "d"}
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	fs := newMockS3Fs(t, server.URL, 0, 0)
+	assert.Nil(t, fs.listingCache)
+	lister, err := fs.ReadDir("")
+	require.NoError(t, err)
+	defer lister.Close()
+
+	files, err := lister.Next(ListerBatchSize)
+	assert.NoError(t, err)
+	assert.Len(t, files, 2)
+	_, err = lister.Next(ListerBatchSize)
+	assert.ErrorIs(t, err, ErrTooManyEntries)
+
+	SetMaxListEntries(fs, -1)
+	files, err = readAllEntries(t, fs, "", ListerBatchSize)
+	assert.NoError(t, err)
+	assert.Len(t, files, 4)
+
+	err = SetListingConfig(ListingConfig{
+		PageSize:       2,
+		MaxEntries:     3,
+		MaxEntriesMode: ListingMaxEntriesTruncate,
+	})
+	require.NoError(t, err)
+	SetMaxListEntries(fs, 0)
+	files, err = readAllEntries(t, fs, "", ListerBatchSize)
+	assert.NoError(t, err)
+	if assert.Len(t, files, 4) {
+		assert.Equal(t, "c", files[2].Name())
+		assert.Equal(t, listingTruncatedName, files[3].Name())
+	}
+}
+
+func TestS3CloudAtomicUploads(t *testing.T) {
+	assert.Error(t, SetAtomicUploadPrefix("a/b"))
+	assert.Error(t, SetAtomicUploadPrefix(".."))
+	assert.NoError(t, SetAtomicUploadPrefix("/.tmp/"))
+	assert.Equal(t, ".tmp", atomicUploadPrefix)
+	assert.NoError(t, SetAtomicUploadPrefix(""))
+	assert.Equal(t, defaultAtomicUploadPrefix, atomicUploadPrefix)
+
+	s := newMockS3Server()
+	s.listKeys = []string{"a", ".sftpgo-tmp/file.bin", "dir/file.bin"}
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	fs := newMockS3Fs(t, server.URL, 0, 0)
+	fs.config.KeyPrefix = "prefix/"
+	assert.False(t, fs.IsAtomicUploadSupported())
+	_, err := fs.ResolvePath("/.sftpgo-tmp/file.bin")
+	assert.NoError(t, err)
+	files, err := readAllEntries(t, fs, "prefix", ListerBatchSize)
+	assert.NoError(t, err)
+	assert.Len(t, files, 3)
+
+	SetUploadMode(uploadModeCloudAtomic)
+	defer SetUploadMode(0)
+
+	assert.True(t, fs.IsAtomicUploadSupported())
+	assert.False(t, IsUploadResumeSupported(fs, 0))
+	for _, p := range []string{"/.sftpgo-tmp", "/.sftpgo-tmp/file.bin", ".sftpgo-tmp/dir/"} {
+		_, err = fs.ResolvePath(p)
+		assert.ErrorIs(t, err, os.ErrPermission, p)
+		assert.True(t, fs.IsPermission(err))
+	}
+	name, err := fs.ResolvePath("/.sftpgo-tmpdir/file.bin")
+	assert.NoError(t, err)
+	assert.Equal(t, "prefix/.sftpgo-tmpdir/file.bin", name)
+	// the listings and the size scans don't include the temporary objects
+	files, err = readAllEntries(t, fs, "prefix", ListerBatchSize)
+	assert.NoError(t, err)
+	if assert.Len(t, files, 2) {
+		assert.Equal(t, "dir", files[0].Name())
+		assert.Equal(t, "a", files[1].Name())
+	}
+	numFiles, size, err := fs.GetDirSize("prefix")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, numFiles)
+	assert.Equal(t, int64(13), size)
+
+	tempPath := fs.GetAtomicUploadPath("prefix/dir/file.txt")
+	assert.True(t, strings.HasPrefix(tempPath, "prefix/.sftpgo-tmp/"), tempPath)
+	assert.True(t, strings.HasSuffix(tempPath, ".file.txt"), tempPath)
+	assert.NotEqual(t, tempPath, fs.GetAtomicUploadPath("prefix/dir/file.txt"))
+	// the parent dir is not checked for the temporary objects
+	s.Lock()
+	s.listKeys = nil
+	s.Unlock()
+	_, _, _, err = fs.Create("prefix/dir/file.txt", 0, CheckParentDir)
+	assert.Error(t, err)
+	_, w, _, err := fs.Create(tempPath, 0, CheckParentDir)
+	require.NoError(t, err)
+	_, err = w.Write([]byte("data"))
+	assert.NoError(t, err)
+	err = w.Close()
+	assert.NoError(t, err)
+	s.Lock()
+	assert.Equal(t, []byte("data"), s.objects["/bucket/"+tempPath])
+	s.Unlock()
+}
+
+func TestListingCache(t *testing.T) {
+	assert.Error(t, SetListingConfig(ListingConfig{PageSize: -1}))
+	assert.Error(t, SetListingConfig(ListingConfig{PageSize: defaultListingPageSize + 1}))
+	assert.Error(t, SetListingConfig(ListingConfig{MaxEntries: -1}))
+	assert.Error(t, SetListingConfig(ListingConfig{CacheTTL: -1}))
+	assert.Equal(t, int32(defaultListingPageSize), getListingPageSize())
+	assert.Nil(t, newListingCache())
+
+	var nilCache *listingCache
+	nilCache.add("dir/", []os.FileInfo{NewFileInfo("a", false, 1, time.Now(), false)})
+	_, ok := nilCache.get("dir/", "a")
+	assert.False(t, ok)
+	nilCache.invalidate("dir/")
+
+	cache := &listingCache{
+		ttl:  time.Minute,
+		dirs: make(map[string]*cachedListing),
+	}
+	cache.add("dir/", []os.FileInfo{NewFileInfo("a", false, 1, time.Now(), false)})
+	cache.add("dir/", []os.FileInfo{NewFileInfo("a", false, 2, time.Now(), false)})
+	assert.Equal(t, 1, cache.size)
+	info, ok := cache.get("dir/", "a")
+	if assert.True(t, ok) {
+		assert.Equal(t, int64(2), info.Size())
+	}
+	_, ok = cache.get("", "a")
+	assert.False(t, ok)
+	cache.dirs["dir/"].expiresAt = time.Now().Add(-time.Second)
+	_, ok = cache.get("dir/", "a")
+	assert.False(t, ok)
+	assert.Equal(t, 0, cache.size)
+
+	files := make([]os.FileInfo, 0, listingCacheMaxEntries+1)
+	for i := 0; i <= listingCacheMaxEntries; i++ {
+		files = append(files, NewFileInfo(strconv.Itoa(i), false, 1, time.Now(), false))
+	}
+	cache.add("dir/", files)
+	assert.Empty(t, cache.dirs)
+	cache.add("dir/", files[:10])
+	cache.add("dir/sub/", files[:10])
+	assert.Equal(t, 20, cache.size)
+	cache.invalidate("dir/", "dir/sub/")
+	assert.Equal(t, 0, cache.size)
+}
+
+func TestS3RemoveFiles(t *testing.T) {
+	s := newMockS3Server()
+	s.deleteErrors = map[string]bool{
+		"file10":   true,
+		"file1500": true,
+	}
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	fs := newMockS3Fs(t, server.URL, 0, 0)
+	var names []string
+	for i := range 2500 {
+		names = append(names, fmt.Sprintf("file%d", i))
+	}
+	removed, err := fs.RemoveFiles(names)
+	var batchErr *BatchError
+	require.ErrorAs(t, err, &batchErr)
+	assert.Equal(t, "remove", batchErr.Operation)
+	assert.Len(t, batchErr.Failed, 2)
+	assert.Contains(t, batchErr.Failed, "file10")
+	assert.Contains(t, batchErr.Failed, "file1500")
+	assert.Contains(t, err.Error(), "AccessDenied")
+	assert.Len(t, removed, 2498)
+	assert.NotContains(t, removed, "file10")
+	s.Lock()
+	assert.Equal(t, 3, s.deleteRequests)
+	assert.Len(t, s.deleted, 2498)
+	s.Unlock()
+
+	removed, err = fs.RemoveFiles([]string{"file1", "file2"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"file1", "file2"}, removed)
+	// no other request is sent after closing the fs
+	err = fs.Close()
+	assert.NoError(t, err)
+	removed, err = fs.RemoveFiles(names)
+	require.ErrorAs(t, err, &batchErr)
+	assert.Len(t, batchErr.Failed, len(names))
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Len(t, removed, 0)
+	s.Lock()
+	assert.Equal(t, 4, s.deleteRequests)
+	s.Unlock()
+}
+
+func TestS3RecursiveRename(t *testing.T) {
+	s := newMockS3Server()
+	s.listKeys = []string{"a", "b", "c", "sub/d"}
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	fs := newMockS3Fs(t, server.URL, 0, 0)
+	var mu sync.Mutex
+	var copied, renamedDirs []string
+	errCopy := errors.New("copy error")
+	renameFn := func(source, target string, _ os.FileInfo, recursion int, _ bool) (int, int64, error) {
+		assert.Equal(t, 1, recursion)
+		renamedDirs = append(renamedDirs, source+">"+target)
+		return 1, 10, nil
+	}
+	copyFn := func(source, target string, _ os.FileInfo, _ bool) error {
+		if source == "dir/b" {
+			return errCopy
+		}
+		mu.Lock()
+		copied = append(copied, source+">"+target)
+		mu.Unlock()
+		return nil
+	}
+	numFiles, size, err := doRecursiveRename(context.Background(), fs, "dir", "newdir", renameFn, copyFn, 0, false)
+	var batchErr *BatchError
+	require.ErrorAs(t, err, &batchErr)
+	assert.Equal(t, "rename", batchErr.Operation)
+	assert.Equal(t, map[string]error{"dir/b": errCopy}, batchErr.Failed)
+	// the renamed sub directory and the two copied files
+	assert.Equal(t, 3, numFiles)
+	assert.Equal(t, int64(10+2), size)
+	assert.Equal(t, []string{"dir/sub>newdir/sub"}, renamedDirs)
+	slices.Sort(copied)
+	assert.Equal(t, []string{"dir/a>newdir/a", "dir/c>newdir/c"}, copied)
+	s.Lock()
+	// only the copied files are removed
+	assert.Equal(t, 1, s.deleteRequests)
+	slices.Sort(s.deleted)
+	assert.Equal(t, []string{"/bucket/dir/a", "/bucket/dir/c"}, s.deleted)
+	s.Unlock()
+}
+
+func TestS3ListingCanceled(t *testing.T) {
+	s := newMockS3Server()
+	listing := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("list-type") == "2" {
+			// simulate a slow listing, it ends when the request is canceled
+			listing <- struct{}{}
+			<-r.Context().Done()
+			return
+		}
+		s.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	fs := newMockS3Fs(t, server.URL, 0, 0)
+	fs.ctxTimeout = time.Minute
+	lister, err := fs.ReadDir("dir")
+	require.NoError(t, err)
+	defer lister.Close()
+
+	listErr := make(chan error, 1)
+	go func() {
+		_, err := lister.Next(ListerBatchSize)
+		listErr <- err
+	}()
+	select {
+	case <-listing:
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "listing not started")
+	}
+	// simulate a client disconnection
+	CancelFsOperations(&tracedFs{Fs: fs})
+	select {
+	case err := <-listErr:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "listing not canceled")
+	}
+	// the new operations are canceled too, the transfers are not affected
+	_, _, err = fs.GetDirSize("dir")
+	assert.ErrorIs(t, err, context.Canceled)
+	err = uploadToS3Fs(fs, "file.txt", []byte("data"))
+	assert.NoError(t, err)
+}
+
+// BenchmarkS3Download compares the streamed download used by Open with a
+// synchronous ranged GET for each 32KB client read. Each request to the
+// backend has 100ms of latency
+func BenchmarkS3Download(b *testing.B) {
+	s := newMockS3Server()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		s.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	data := make([]byte, 32*1024*1024)
+	_, err := rand.Read(data)
+	require.NoError(b, err)
+	s.objects["/bucket/file.bin"] = data
+	readSize := 32 * 1024
+
+	for _, concurrency := range []int{1, 5} {
+		b.Run(fmt.Sprintf("Open/concurrency%d", concurrency), func(b *testing.B) {
+			fs := newMockS3Fs(b, server.URL, 5, 1)
+			fs.localTempDir = b.TempDir()
+			fs.config.DownloadPartSize = 5 * 1024 * 1024
+			fs.config.DownloadConcurrency = concurrency
+			b.SetBytes(int64(len(data)))
+			for b.Loop() {
+				_, r, _, err := fs.Open("file.bin", 0)
+				require.NoError(b, err)
+				n, err := io.CopyBuffer(io.Discard, r, make([]byte, readSize))
+				require.NoError(b, err)
+				require.Equal(b, int64(len(data)), n)
+				require.NoError(b, r.Close())
+			}
+		})
+	}
+	b.Run("RangedGET", func(b *testing.B) {
+		fs := newMockS3Fs(b, server.URL, 5, 1)
+		size := 1024 * 1024
+		b.SetBytes(int64(size))
+		for b.Loop() {
+			for offset := 0; offset < size; offset += readSize {
+				out, err := fs.svc.GetObject(context.Background(), &s3.GetObjectInput{
+					Bucket: aws.String("bucket"),
+					Key:    aws.String("file.bin"),
+					Range:  aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+readSize-1)),
+				})
+				require.NoError(b, err)
+				n, err := io.Copy(io.Discard, out.Body)
+				require.NoError(b, err)
+				require.Equal(b, int64(readSize), n)
+				out.Body.Close()
+			}
+		}
+	})
+}
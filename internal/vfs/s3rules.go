@@ -0,0 +1,222 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	s3MaxObjectTags     = 10
+	s3MaxTagKeyLength   = 128
+	s3MaxTagValueLength = 256
+	// the only allowed value for the request payer
+	s3RequestPayerRequester = "requester"
+	// length limits for the AssumeRole parameters
+	s3MaxExternalIDLength      = 1224
+	s3MaxRoleSessionNameLength = 64
+)
+
+var (
+	validS3StorageClasses = []string{"STANDARD", "REDUCED_REDUNDANCY", "STANDARD_IA", "ONEZONE_IA",
+		"INTELLIGENT_TIERING", "GLACIER", "DEEP_ARCHIVE", "OUTPOSTS", "GLACIER_IR", "SNOW", "EXPRESS_ONEZONE"}
+	s3TagRegex             = regexp.MustCompile(`^[\p{L}\p{Z}\p{N}_.:/=+\-@]*$`)
+	s3ExternalIDRegex      = regexp.MustCompile(`^[\w+=,.@:/-]+$`)
+	s3RoleSessionNameRegex = regexp.MustCompile(`^[\w+=,.@-]+$`)
+)
+
+// S3UploadRule defines the storage class and the tags to apply to the objects
+// whose key matches the specified pattern.
+// Patterns are matched against the object key, including the key prefix, if any:
+//   - patterns without a "/", for example "*.zip", are matched against the file name
+//   - patterns ending with a "/", for example "prefix/raw/", match all the objects
+//     inside the matching directories
+//   - other patterns are matched against the whole object key
+type S3UploadRule struct {
+	Pattern string `json:"pattern"`
+	// Storage class to use instead of the one defined for the filesystem
+	StorageClass string `json:"storage_class,omitempty"`
+	// Tags to add to the objects
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+func (r *S3UploadRule) validate() error {
+	r.Pattern = strings.TrimPrefix(strings.TrimSpace(r.Pattern), "/")
+	if r.Pattern == "" || r.Pattern == "/" {
+		return errors.New("upload rule: pattern cannot be empty")
+	}
+	if _, err := path.Match(strings.TrimSuffix(r.Pattern, "/"), "abc"); err != nil {
+		return fmt.Errorf("upload rule: invalid pattern %q: %w", r.Pattern, err)
+	}
+	r.StorageClass = strings.TrimSpace(r.StorageClass)
+	if r.StorageClass != "" && !slices.Contains(validS3StorageClasses, r.StorageClass) {
+		return fmt.Errorf("upload rule: invalid storage class %q, valid values: %s", r.StorageClass,
+			strings.Join(validS3StorageClasses, ", "))
+	}
+	if r.StorageClass == "" && len(r.Tags) == 0 {
+		return fmt.Errorf("upload rule %q: a storage class or at least a tag is required", r.Pattern)
+	}
+	return validateS3Tags(r.Tags)
+}
+
+func (r *S3UploadRule) matches(key string) bool {
+	return matchesObjectKey(r.Pattern, key)
+}
+
+// matchesObjectKey reports whether the object key matches the specified
+// pattern, see S3UploadRule for the supported patterns
+func matchesObjectKey(pattern, key string) bool {
+	if dirPattern, ok := strings.CutSuffix(pattern, "/"); ok {
+		for dir := path.Dir(key); dir != "." && dir != "/"; dir = path.Dir(dir) {
+			if matched, _ := path.Match(dirPattern, dir); matched {
+				return true
+			}
+		}
+		return false
+	}
+	if !strings.Contains(pattern, "/") {
+		key = path.Base(key)
+	}
+	matched, _ := path.Match(pattern, key)
+	return matched
+}
+
+func validateS3Tags(tags map[string]string) error {
+	if len(tags) > s3MaxObjectTags {
+		return fmt.Errorf("upload rule: too many tags %d, max allowed: %d", len(tags), s3MaxObjectTags)
+	}
+	for k, v := range tags {
+		if k == "" || utf8.RuneCountInString(k) > s3MaxTagKeyLength {
+			return fmt.Errorf("upload rule: invalid tag key %q, the length must be between 1 and %d",
+				k, s3MaxTagKeyLength)
+		}
+		if strings.HasPrefix(strings.ToLower(k), "aws:") {
+			return fmt.Errorf("upload rule: invalid tag key %q, the \"aws:\" prefix is reserved", k)
+		}
+		if utf8.RuneCountInString(v) > s3MaxTagValueLength {
+			return fmt.Errorf("upload rule: invalid value for tag %q, the max length is %d", k, s3MaxTagValueLength)
+		}
+		if !s3TagRegex.MatchString(k) || !s3TagRegex.MatchString(v) {
+			return fmt.Errorf("upload rule: tag %q=%q contains invalid characters", k, v)
+		}
+	}
+	return nil
+}
+
+func validateS3UploadRules(rules []S3UploadRule) error {
+	for idx := range rules {
+		if err := rules[idx].validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func areS3UploadRulesEqual(rules, other []S3UploadRule) bool {
+	return slices.EqualFunc(rules, other, func(r1, r2 S3UploadRule) bool {
+		return r1.Pattern == r2.Pattern && r1.StorageClass == r2.StorageClass && maps.Equal(r1.Tags, r2.Tags)
+	})
+}
+
+func cloneS3UploadRules(rules []S3UploadRule) []S3UploadRule {
+	if rules == nil {
+		return nil
+	}
+	result := make([]S3UploadRule, 0, len(rules))
+	for _, r := range rules {
+		result = append(result, S3UploadRule{
+			Pattern:      r.Pattern,
+			StorageClass: r.StorageClass,
+			Tags:         maps.Clone(r.Tags),
+		})
+	}
+	return result
+}
+
+// getS3UploadAttrs returns the storage class and the tags for the specified
+// object key. The storage class is the one defined in the first matching rule
+// that sets it, the tags are merged from all the matching rules, for duplicate
+// keys the first matching rule wins
+func getS3UploadAttrs(rules []S3UploadRule, key string) (string, map[string]string) {
+	var storageClass string
+	var tags map[string]string
+
+	for _, r := range rules {
+		if !r.matches(key) {
+			continue
+		}
+		if storageClass == "" {
+			storageClass = r.StorageClass
+		}
+		for k, v := range r.Tags {
+			if tags == nil {
+				tags = make(map[string]string)
+			}
+			if _, ok := tags[k]; !ok {
+				tags[k] = v
+			}
+		}
+	}
+	return storageClass, tags
+}
+
+// encodeS3Tags encodes the tags as URL query parameters, the format required
+// for the tagging header
+func encodeS3Tags(tags map[string]string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	values := url.Values{}
+	for k, v := range tags {
+		values.Set(k, v)
+	}
+	return values.Encode()
+}
+
+// checkAssumeRole validates the parameters used to assume the configured role
+func (c *S3FsConfig) checkAssumeRole() error {
+	c.RoleARN = strings.TrimSpace(c.RoleARN)
+	c.ExternalID = strings.TrimSpace(c.ExternalID)
+	c.RoleSessionName = strings.TrimSpace(c.RoleSessionName)
+	if c.RoleARN == "" {
+		if c.ExternalID != "" || c.RoleSessionName != "" {
+			return errors.New("external_id and role_session_name require a role_arn")
+		}
+		return nil
+	}
+	if c.ExternalID != "" {
+		if len(c.ExternalID) < 2 || len(c.ExternalID) > s3MaxExternalIDLength ||
+			!s3ExternalIDRegex.MatchString(c.ExternalID) {
+			return fmt.Errorf("invalid external_id, it must be 2-%d characters long and can contain only "+
+				"alphanumeric characters and =,.@:/-_+", s3MaxExternalIDLength)
+		}
+	}
+	if c.RoleSessionName != "" {
+		if len(c.RoleSessionName) < 2 || len(c.RoleSessionName) > s3MaxRoleSessionNameLength ||
+			!s3RoleSessionNameRegex.MatchString(c.RoleSessionName) {
+			return fmt.Errorf("invalid role_session_name %q, it must be 2-%d characters long and can contain only "+
+				"alphanumeric characters and =,.@-_+", c.RoleSessionName, s3MaxRoleSessionNameLength)
+		}
+	}
+	return nil
+}
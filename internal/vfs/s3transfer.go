@@ -0,0 +1,74 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"github.com/sftpgo/sdk"
+)
+
+const (
+	s3MaxPartSize    = 5 * 1024 * 1024 * 1024
+	s3MaxUploadParts = 10000
+	// maximum number of keys for a DeleteObjects request
+	s3DeleteObjectsMaxKeys = 1000
+	// the upload part size is doubled every s3PartSizeGrowthInterval parts
+	s3PartSizeGrowthInterval = 1000
+)
+
+var s3TransferDefaults S3TransferDefaults
+
+// S3TransferDefaults defines the multipart settings for the S3 filesystems
+// that don't define their own. Part sizes are in MB, 0 means the SDK default
+type S3TransferDefaults struct {
+	UploadPartSize      int64
+	UploadConcurrency   int
+	DownloadPartSize    int64
+	DownloadConcurrency int
+}
+
+// SetS3TransferDefaults validates and sets the default multipart settings for
+// the S3 filesystems
+func SetS3TransferDefaults(defaults S3TransferDefaults) error {
+	config := S3FsConfig{
+		BaseS3FsConfig: sdk.BaseS3FsConfig{
+			UploadPartSize:      defaults.UploadPartSize,
+			UploadConcurrency:   defaults.UploadConcurrency,
+			DownloadPartSize:    defaults.DownloadPartSize,
+			DownloadConcurrency: defaults.DownloadConcurrency,
+		},
+	}
+	if err := config.checkPartSizeAndConcurrency(); err != nil {
+		return err
+	}
+	s3TransferDefaults = defaults
+	return nil
+}
+
+// getS3UploadPartSize returns the size, in bytes, for the specified part
+// number, starting from 1. The configured part size is doubled every 1000
+// parts, up to the 5GB S3 limit, so that files of any size can be uploaded
+// within 10000 parts: using 5MB parts the first 1000 parts address 5GB and
+// the full 10000 parts about 5TB, the S3 object size limit
+func getS3UploadPartSize(partSize int64, partNumber int32) int64 {
+	if partNumber < 1 {
+		partNumber = 1
+	}
+	growth := (partNumber - 1) / s3PartSizeGrowthInterval
+	size := partSize << growth
+	if size > s3MaxPartSize || size <= 0 {
+		return s3MaxPartSize
+	}
+	return size
+}
@@ -17,6 +17,7 @@ package vfs
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"crypto/rsa"
 	"crypto/sha256"
 	"encoding/hex"
@@ -57,16 +58,43 @@ const (
 
 var (
 	// ErrSFTPLoop defines the error to return if an SFTP loop is detected
-	ErrSFTPLoop    = errors.New("SFTP loop or nested local SFTP folders detected")
-	sftpConnsCache = newSFTPConnectionCache()
+	ErrSFTPLoop = errors.New("SFTP loop or nested local SFTP folders detected")
+	// ErrSFTPHostKeyUnknown defines the error to return if the SFTP server host
+	// key is not found in the configured known hosts
+	ErrSFTPHostKeyUnknown = errors.New("unknown host key")
+	// ErrSFTPHostKeyChanged defines the error to return if the SFTP server host
+	// key does not match the ones configured in the known hosts for the same host
+	ErrSFTPHostKeyChanged = errors.New("changed host key")
+	sftpConnsCache        = newSFTPConnectionCache()
 )
 
 // SFTPFsConfig defines the configuration for SFTP based filesystem
 type SFTPFsConfig struct {
 	sdk.BaseSFTPFsConfig
-	Password               *kms.Secret `json:"password,omitempty"`
-	PrivateKey             *kms.Secret `json:"private_key,omitempty"`
-	KeyPassphrase          *kms.Secret `json:"key_passphrase,omitempty"`
+	Password      *kms.Secret `json:"password,omitempty"`
+	PrivateKey    *kms.Secret `json:"private_key,omitempty"`
+	KeyPassphrase *kms.Secret `json:"key_passphrase,omitempty"`
+	// Accepted host keys for the SFTP server in OpenSSH known_hosts format,
+	// one entry per item. Hashed host names and multiple keys for the same
+	// host are supported. If fingerprints are also set both must match
+	KnownHosts []string `json:"known_hosts,omitempty"`
+	// Absolute path to an OpenSSH known_hosts file with the accepted host keys.
+	// It is read for each new connection and it is used together with the
+	// inline entries
+	KnownHostsFile string `json:"known_hosts_file,omitempty"`
+	// Maximum number of connections to the SFTP server shared, from a pool,
+	// by all the sessions using the same endpoint and credentials.
+	// Each request checks out a connection from the pool, open files keep
+	// their connection checked out until closed. 0 means disabled
+	PoolSize int `json:"pool_size,omitempty"`
+	// Local IP address for the connections to the SFTP server. Empty means
+	// the global setting, if any
+	OutboundBindAddress string `json:"outbound_bind_address,omitempty"`
+	// Proxy URL for the connections to the SFTP server. Empty means the
+	// default outbound proxy, if any, "direct" disables the proxy
+	ProxyURL               string      `json:"proxy_url,omitempty"`
+	ProxyUsername          string      `json:"proxy_username,omitempty"`
+	ProxyPassword          *kms.Secret `json:"proxy_password,omitempty"`
 	forbiddenSelfUsernames []string    `json:"-"`
 }
 
@@ -92,6 +120,9 @@ func (c *SFTPFsConfig) HideConfidentialData() {
 	if c.KeyPassphrase != nil {
 		c.KeyPassphrase.Hide()
 	}
+	if c.ProxyPassword != nil {
+		c.ProxyPassword.Hide()
+	}
 }
 
 func (c *SFTPFsConfig) setNilSecretsIfEmpty() {
@@ -104,6 +135,9 @@ func (c *SFTPFsConfig) setNilSecretsIfEmpty() {
 	if c.KeyPassphrase != nil && c.KeyPassphrase.IsEmpty() {
 		c.KeyPassphrase = nil
 	}
+	if c.ProxyPassword != nil && c.ProxyPassword.IsEmpty() {
+		c.ProxyPassword = nil
+	}
 }
 
 func (c *SFTPFsConfig) isEqual(other SFTPFsConfig) bool {
@@ -122,6 +156,9 @@ func (c *SFTPFsConfig) isEqual(other SFTPFsConfig) bool {
 	if c.BufferSize != other.BufferSize {
 		return false
 	}
+	if c.PoolSize != other.PoolSize {
+		return false
+	}
 	if len(c.Fingerprints) != len(other.Fingerprints) {
 		return false
 	}
@@ -130,11 +167,23 @@ func (c *SFTPFsConfig) isEqual(other SFTPFsConfig) bool {
 			return false
 		}
 	}
+	if !slices.Equal(c.KnownHosts, other.KnownHosts) || c.KnownHostsFile != other.KnownHostsFile {
+		return false
+	}
+	if c.ProxyURL != other.ProxyURL || c.ProxyUsername != other.ProxyUsername {
+		return false
+	}
+	if c.OutboundBindAddress != other.OutboundBindAddress {
+		return false
+	}
 	c.setEmptyCredentialsIfNil()
 	other.setEmptyCredentialsIfNil()
 	if !c.Password.IsEqual(other.Password) {
 		return false
 	}
+	if !c.ProxyPassword.IsEqual(other.ProxyPassword) {
+		return false
+	}
 	if !c.KeyPassphrase.IsEqual(other.KeyPassphrase) {
 		return false
 	}
@@ -151,6 +200,9 @@ func (c *SFTPFsConfig) setEmptyCredentialsIfNil() {
 	if c.KeyPassphrase == nil {
 		c.KeyPassphrase = kms.NewEmptySecret()
 	}
+	if c.ProxyPassword == nil {
+		c.ProxyPassword = kms.NewEmptySecret()
+	}
 }
 
 func (c *SFTPFsConfig) isSameResource(other SFTPFsConfig) bool {
@@ -181,12 +233,26 @@ func (c *SFTPFsConfig) validate() error {
 	if c.BufferSize < 0 || c.BufferSize > 16 {
 		return errors.New("invalid buffer_size, valid range is 0-16")
 	}
+	if c.PoolSize < 0 || c.PoolSize > maxSFTPPoolSize {
+		return fmt.Errorf("invalid pool_size, valid range is 0-%d", maxSFTPPoolSize)
+	}
 	if !isEqualityCheckModeValid(c.EqualityCheckMode) {
 		return errors.New("invalid equality_check_mode")
 	}
 	if err := c.validateCredentials(); err != nil {
 		return err
 	}
+	if err := c.validateKnownHosts(); err != nil {
+		return err
+	}
+	c.ProxyURL = strings.TrimSpace(c.ProxyURL)
+	if err := validateProxySettings(c.ProxyURL, c.ProxyUsername, c.ProxyPassword); err != nil {
+		return err
+	}
+	c.OutboundBindAddress = strings.TrimSpace(c.OutboundBindAddress)
+	if err := validateOutboundBindAddress(c.OutboundBindAddress); err != nil {
+		return err
+	}
 	if c.Prefix != "" {
 		c.Prefix = util.CleanPath(c.Prefix)
 	} else {
@@ -280,6 +346,15 @@ func (c *SFTPFsConfig) ValidateAndEncryptCredentials(additionalData string) erro
 			)
 		}
 	}
+	if c.ProxyPassword.IsPlain() {
+		c.ProxyPassword.SetAdditionalData(additionalData)
+		if err := c.ProxyPassword.Encrypt(); err != nil {
+			return util.NewI18nError(
+				util.NewValidationError(fmt.Sprintf("could not encrypt SFTP fs proxy password: %v", err)),
+				util.I18nErrorFsValidation,
+			)
+		}
+	}
 	return nil
 }
 
@@ -291,11 +366,16 @@ func (c *SFTPFsConfig) getUniqueID(partition int) string {
 	b.WriteString(c.Endpoint)
 	b.WriteString(c.Username)
 	b.WriteString(strings.Join(c.Fingerprints, ""))
+	b.WriteString(strings.Join(c.KnownHosts, "\n"))
+	b.WriteString(c.KnownHostsFile)
 	b.WriteString(strconv.FormatBool(c.DisableCouncurrentReads))
 	b.WriteString(strconv.FormatInt(c.BufferSize, 10))
 	b.WriteString(c.Password.GetPayload())
 	b.WriteString(c.PrivateKey.GetPayload())
 	b.WriteString(c.KeyPassphrase.GetPayload())
+	b.WriteString(c.ProxyURL)
+	b.WriteString(c.ProxyUsername)
+	b.WriteString(c.ProxyPassword.GetPayload())
 	if allowSelfConnections != 0 {
 		b.WriteString(strings.Join(c.forbiddenSelfUsernames, ""))
 	}
@@ -313,6 +393,9 @@ type SFTPFs struct {
 	localTempDir string
 	config       *SFTPFsConfig
 	conn         *sftpConnection
+	// not nil if the connections are checked out from a pool
+	pool *sftpConnectionPool
+	listingLimit
 }
 
 // NewSFTPFs returns an SFTPFs object that allows to interact with an SFTP server
@@ -338,7 +421,19 @@ func NewSFTPFs(connectionID, mountPath, localTempDir string, forbiddenSelfUserna
 			return nil, err
 		}
 	}
-	conn, err := sftpConnsCache.Get(&config, connectionID)
+	if !config.ProxyPassword.IsEmpty() {
+		if err := config.ProxyPassword.TryDecrypt(); err != nil {
+			return nil, err
+		}
+	}
+	var conn *sftpConnection
+	var pool *sftpConnectionPool
+	var err error
+	if config.PoolSize > 0 {
+		pool, err = sftpPools.Get(&config, connectionID)
+	} else {
+		conn, err = sftpConnsCache.Get(&config, connectionID)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -349,6 +444,7 @@ func NewSFTPFs(connectionID, mountPath, localTempDir string, forbiddenSelfUserna
 		localTempDir: localTempDir,
 		config:       &config,
 		conn:         conn,
+		pool:         pool,
 	}
 	err = sftpFs.createConnection()
 	if err != nil {
@@ -369,32 +465,36 @@ func (fs *SFTPFs) ConnectionID() string {
 
 // Stat returns a FileInfo describing the named file
 func (fs *SFTPFs) Stat(name string) (os.FileInfo, error) {
-	client, err := fs.conn.getClient()
+	client, release, err := fs.getClient()
 	if err != nil {
 		return nil, err
 	}
+	defer release()
 	return client.Stat(name)
 }
 
 // Lstat returns a FileInfo describing the named file
 func (fs *SFTPFs) Lstat(name string) (os.FileInfo, error) {
-	client, err := fs.conn.getClient()
+	client, release, err := fs.getClient()
 	if err != nil {
 		return nil, err
 	}
+	defer release()
 	return client.Lstat(name)
 }
 
 // Open opens the named file for reading
 func (fs *SFTPFs) Open(name string, offset int64) (File, PipeReader, func(), error) {
-	client, err := fs.conn.getClient()
+	client, release, err := fs.getClient()
 	if err != nil {
 		return nil, nil, nil, err
 	}
-	f, err := client.Open(name)
+	sftpFile, err := client.Open(name)
 	if err != nil {
+		release()
 		return nil, nil, nil, err
 	}
+	f := fs.getFile(sftpFile, release)
 	if offset > 0 {
 		_, err = f.Seek(offset, io.SeekStart)
 		if err != nil {
@@ -427,24 +527,30 @@ func (fs *SFTPFs) Open(name string, offset int64) (File, PipeReader, func(), err
 
 // Create creates or opens the named file for writing
 func (fs *SFTPFs) Create(name string, flag, _ int) (File, PipeWriter, func(), error) {
-	client, err := fs.conn.getClient()
+	client, release, err := fs.getClient()
 	if err != nil {
 		return nil, nil, nil, err
 	}
 	if fs.config.BufferSize == 0 {
-		var f File
+		var f *sftp.File
 		if flag == 0 {
 			f, err = client.Create(name)
 		} else {
 			f, err = client.OpenFile(name, flag)
 		}
-		return f, nil, nil, err
+		if err != nil {
+			release()
+			return nil, nil, nil, err
+		}
+		return fs.getFile(f, release), nil, nil, nil
 	}
 	// buffering is enabled
-	f, err := client.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	sftpFile, err := client.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
 	if err != nil {
+		release()
 		return nil, nil, nil, err
 	}
+	f := fs.getFile(sftpFile, release)
 	r, w, err := createPipeFn(fs.localTempDir, 0)
 	if err != nil {
 		f.Close()
@@ -483,30 +589,32 @@ func (fs *SFTPFs) Rename(source, target string, checks int) (int, int64, error)
 	if source == target {
 		return -1, -1, nil
 	}
-	client, err := fs.conn.getClient()
+	client, release, err := fs.getClient()
 	if err != nil {
 		return -1, -1, err
 	}
+	defer release()
 	if _, ok := client.HasExtension("posix-rename@openssh.com"); ok {
 		err := client.PosixRename(source, target)
 		if checks&CheckUpdateModTime != 0 && err == nil {
-			fs.Chtimes(target, time.Now(), time.Now(), false) //nolint:errcheck
+			client.Chtimes(target, time.Now(), time.Now()) //nolint:errcheck
 		}
 		return -1, -1, err
 	}
 	err = client.Rename(source, target)
 	if checks&CheckUpdateModTime != 0 && err == nil {
-		fs.Chtimes(target, time.Now(), time.Now(), false) //nolint:errcheck
+		client.Chtimes(target, time.Now(), time.Now()) //nolint:errcheck
 	}
 	return -1, -1, err
 }
 
 // Remove removes the named file or (empty) directory.
 func (fs *SFTPFs) Remove(name string, isDir bool) error {
-	client, err := fs.conn.getClient()
+	client, release, err := fs.getClient()
 	if err != nil {
 		return err
 	}
+	defer release()
 	if isDir {
 		return client.RemoveDirectory(name)
 	}
@@ -515,28 +623,31 @@ func (fs *SFTPFs) Remove(name string, isDir bool) error {
 
 // Mkdir creates a new directory with the specified name and default permissions
 func (fs *SFTPFs) Mkdir(name string) error {
-	client, err := fs.conn.getClient()
+	client, release, err := fs.getClient()
 	if err != nil {
 		return err
 	}
+	defer release()
 	return client.Mkdir(name)
 }
 
 // Symlink creates source as a symbolic link to target.
 func (fs *SFTPFs) Symlink(source, target string) error {
-	client, err := fs.conn.getClient()
+	client, release, err := fs.getClient()
 	if err != nil {
 		return err
 	}
+	defer release()
 	return client.Symlink(source, target)
 }
 
 // Readlink returns the destination of the named symbolic link
 func (fs *SFTPFs) Readlink(name string) (string, error) {
-	client, err := fs.conn.getClient()
+	client, release, err := fs.getClient()
 	if err != nil {
 		return "", err
 	}
+	defer release()
 	resolved, err := client.ReadLink(name)
 	if err != nil {
 		return resolved, err
@@ -551,52 +662,57 @@ func (fs *SFTPFs) Readlink(name string) (string, error) {
 
 // Chown changes the numeric uid and gid of the named file.
 func (fs *SFTPFs) Chown(name string, uid int, gid int) error {
-	client, err := fs.conn.getClient()
+	client, release, err := fs.getClient()
 	if err != nil {
 		return err
 	}
+	defer release()
 	return client.Chown(name, uid, gid)
 }
 
 // Chmod changes the mode of the named file to mode.
 func (fs *SFTPFs) Chmod(name string, mode os.FileMode) error {
-	client, err := fs.conn.getClient()
+	client, release, err := fs.getClient()
 	if err != nil {
 		return err
 	}
+	defer release()
 	return client.Chmod(name, mode)
 }
 
 // Chtimes changes the access and modification times of the named file.
 func (fs *SFTPFs) Chtimes(name string, atime, mtime time.Time, _ bool) error {
-	client, err := fs.conn.getClient()
+	client, release, err := fs.getClient()
 	if err != nil {
 		return err
 	}
+	defer release()
 	return client.Chtimes(name, atime, mtime)
 }
 
 // Truncate changes the size of the named file.
 func (fs *SFTPFs) Truncate(name string, size int64) error {
-	client, err := fs.conn.getClient()
+	client, release, err := fs.getClient()
 	if err != nil {
 		return err
 	}
+	defer release()
 	return client.Truncate(name, size)
 }
 
 // ReadDir reads the directory named by dirname and returns
 // a list of directory entries.
 func (fs *SFTPFs) ReadDir(dirname string) (DirLister, error) {
-	client, err := fs.conn.getClient()
+	client, release, err := fs.getClient()
 	if err != nil {
 		return nil, err
 	}
+	defer release()
 	files, err := client.ReadDir(dirname)
 	if err != nil {
 		return nil, err
 	}
-	return &baseDirLister{files}, nil
+	return fs.limitDirLister(fs, dirname, &baseDirLister{files}), nil
 }
 
 // IsUploadResumeSupported returns true if resuming uploads is supported.
@@ -646,10 +762,11 @@ func (fs *SFTPFs) CheckRootPath(username string, uid int, gid int) bool {
 	if fs.config.Prefix == "/" {
 		return true
 	}
-	client, err := fs.conn.getClient()
+	client, release, err := fs.getClient()
 	if err != nil {
 		return false
 	}
+	defer release()
 	if err := client.MkdirAll(fs.config.Prefix); err != nil {
 		fsLog(fs, logger.LevelDebug, "error creating root directory %q for user %q: %v", fs.config.Prefix, username, err)
 		return false
@@ -700,10 +817,11 @@ func (fs *SFTPFs) GetRelativePath(name string) string {
 // Walk walks the file tree rooted at root, calling walkFn for each file or
 // directory in the tree, including root
 func (fs *SFTPFs) Walk(root string, walkFn filepath.WalkFunc) error {
-	client, err := fs.conn.getClient()
+	client, release, err := fs.getClient()
 	if err != nil {
 		return err
 	}
+	defer release()
 	walker := client.Walk(root)
 	for walker.Step() {
 		err := walker.Err()
@@ -774,10 +892,11 @@ func (fs *SFTPFs) ResolvePath(virtualPath string) (string, error) {
 
 // RealPath implements the FsRealPather interface
 func (fs *SFTPFs) RealPath(p string) (string, error) {
-	client, err := fs.conn.getClient()
+	client, release, err := fs.getClient()
 	if err != nil {
 		return "", err
 	}
+	defer release()
 	resolved, err := client.RealPath(p)
 	if err != nil {
 		return "", err
@@ -794,10 +913,11 @@ func (fs *SFTPFs) RealPath(p string) (string, error) {
 
 // getRealPath returns the real remote path trying to resolve symbolic links if any
 func (fs *SFTPFs) getRealPath(name string) (string, error) {
-	client, err := fs.conn.getClient()
+	client, release, err := fs.getClient()
 	if err != nil {
 		return "", err
 	}
+	defer release()
 	linksWalked := 0
 	for {
 		info, err := client.Lstat(name)
@@ -845,12 +965,13 @@ func (fs *SFTPFs) isSubDir(name string) error {
 func (fs *SFTPFs) GetDirSize(dirname string) (int, int64, error) {
 	numFiles := 0
 	size := int64(0)
-	client, err := fs.conn.getClient()
+	client, release, err := fs.getClient()
 	if err != nil {
 		return numFiles, size, err
 	}
-	isDir, err := isDirectory(fs, dirname)
-	if err == nil && isDir {
+	defer release()
+	info, err := client.Stat(dirname)
+	if err == nil && info.IsDir() {
 		walker := client.Walk(dirname)
 		for walker.Step() {
 			err := walker.Err()
@@ -871,10 +992,11 @@ func (fs *SFTPFs) GetDirSize(dirname string) (int, int64, error) {
 
 // GetMimeType returns the content type
 func (fs *SFTPFs) GetMimeType(name string) (string, error) {
-	client, err := fs.conn.getClient()
+	client, release, err := fs.getClient()
 	if err != nil {
 		return "", err
 	}
+	defer release()
 	f, err := client.OpenFile(name, os.O_RDONLY)
 	if err != nil {
 		return "", err
@@ -893,10 +1015,11 @@ func (fs *SFTPFs) GetMimeType(name string) (string, error) {
 
 // GetAvailableDiskSize returns the available size for the specified path
 func (fs *SFTPFs) GetAvailableDiskSize(dirName string) (*sftp.StatVFS, error) {
-	client, err := fs.conn.getClient()
+	client, release, err := fs.getClient()
 	if err != nil {
 		return nil, err
 	}
+	defer release()
 	if _, ok := client.HasExtension("statvfs@openssh.com"); !ok {
 		return nil, ErrStorageSizeUnavailable
 	}
@@ -905,12 +1028,52 @@ func (fs *SFTPFs) GetAvailableDiskSize(dirName string) (*sftp.StatVFS, error) {
 
 // Close the connection
 func (fs *SFTPFs) Close() error {
+	if fs.pool != nil {
+		fs.pool.RemoveSession(fs.connectionID)
+		return nil
+	}
 	fs.conn.RemoveSession(fs.connectionID)
 	return nil
 }
 
+// getClient returns the SFTP client to use for a request and the function to
+// call when the request completes. For pooled filesystems the client is
+// checked out from the pool and the returned function releases it
+func (fs *SFTPFs) getClient() (*sftp.Client, func(), error) {
+	if fs.pool != nil {
+		return fs.pool.getClient()
+	}
+	client, err := fs.conn.getClient()
+	if err != nil {
+		return nil, nil, err
+	}
+	return client, func() {}, nil
+}
+
+// getFile returns the File for the specified handle. For pooled filesystems
+// the client used to open the handle is released when the file is closed, so
+// a handle is never used after its connection is returned to the pool
+func (fs *SFTPFs) getFile(f *sftp.File, release func()) File {
+	if fs.pool == nil {
+		return f
+	}
+	return &sftpPooledFile{
+		File:    f,
+		release: release,
+	}
+}
+
 func (fs *SFTPFs) createConnection() error {
-	err := fs.conn.OpenConnection()
+	var err error
+	if fs.pool != nil {
+		var release func()
+		_, release, err = fs.pool.getClient()
+		if err == nil {
+			release()
+		}
+	} else {
+		err = fs.conn.OpenConnection()
+	}
 	if err != nil {
 		fsLog(fs, logger.LevelError, "error opening connection: %v", err)
 		return err
@@ -959,7 +1122,7 @@ func (c *sftpConnection) openConnNoLock() error {
 	logger.Debug(c.logSender, "", "try to open a new connection")
 	clientConfig := &ssh.ClientConfig{
 		User: c.config.Username,
-		HostKeyCallback: func(_ string, _ net.Addr, key ssh.PublicKey) error {
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
 			fp := ssh.FingerprintSHA256(key)
 			if slices.Contains(sftpFingerprints, fp) {
 				if allowSelfConnections == 0 {
@@ -973,15 +1136,16 @@ func (c *sftpConnection) openConnNoLock() error {
 					return ErrSFTPLoop
 				}
 			}
-			if len(c.config.Fingerprints) > 0 {
-				for _, provided := range c.config.Fingerprints {
-					if provided == fp {
-						return nil
-					}
-				}
+			if len(c.config.Fingerprints) > 0 && !slices.Contains(c.config.Fingerprints, fp) {
 				return fmt.Errorf("invalid fingerprint %q", fp)
 			}
-			logger.Log(logger.LevelWarn, c.logSender, "", "login without host key validation, please provide at least a fingerprint!")
+			if len(c.config.KnownHosts) > 0 || c.config.KnownHostsFile != "" {
+				return c.checkKnownHosts(hostname, remote, key)
+			}
+			if len(c.config.Fingerprints) == 0 {
+				logger.Log(logger.LevelWarn, c.logSender, "",
+					"login without host key validation, please provide at least a fingerprint or a known host!")
+			}
 			return nil
 		},
 		Timeout:       15 * time.Second,
@@ -1000,7 +1164,7 @@ func (c *sftpConnection) openConnNoLock() error {
 		ssh.InsecureCipherAES192CBC, ssh.InsecureCipherAES256CBC)
 	clientConfig.KeyExchanges = append(supportedAlgos.KeyExchanges, insecureAlgos.KeyExchanges...)
 	clientConfig.MACs = append(supportedAlgos.MACs, insecureAlgos.MACs...)
-	sshClient, err := ssh.Dial("tcp", c.config.Endpoint, clientConfig)
+	sshClient, err := c.dial(clientConfig)
 	if err != nil {
 		return fmt.Errorf("sftpfs: unable to connect: %w", err)
 	}
@@ -1016,6 +1180,39 @@ func (c *sftpConnection) openConnNoLock() error {
 	return nil
 }
 
+// dial connects to the SFTP server, through the configured proxy if any.
+// The connections are bound to the configured local address, if any
+func (c *sftpConnection) dial(clientConfig *ssh.ClientConfig) (*ssh.Client, error) {
+	p, err := getOutboundProxy(c.config.ProxyURL, c.config.ProxyUsername, c.config.ProxyPassword)
+	if err != nil {
+		return nil, err
+	}
+	var conn net.Conn
+	if p == nil {
+		conn, err = newOutboundDialer(c.config.OutboundBindAddress, clientConfig.Timeout).Dial("tcp", c.config.Endpoint)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		p = p.withBindAddress(c.config.OutboundBindAddress)
+		logger.Debug(c.logSender, "", "connecting through proxy %q", p)
+		ctx, cancel := context.WithTimeout(context.Background(), clientConfig.Timeout)
+		defer cancel()
+
+		conn, err = p.DialContext(ctx, "tcp", c.config.Endpoint)
+		if err != nil {
+			logger.Warn(c.logSender, "", "unable to connect through proxy %q: %v", p, err)
+			return nil, err
+		}
+	}
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, c.config.Endpoint, clientConfig)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(sshConn, chans, reqs), nil
+}
+
 func (c *sftpConnection) getClientOptions() []sftp.ClientOption {
 	var options []sftp.ClientOption
 	if c.config.DisableCouncurrentReads {
@@ -1040,6 +1237,13 @@ func (c *sftpConnection) getClient() (*sftp.Client, error) {
 	return c.sftpClient, err
 }
 
+func (c *sftpConnection) IsConnected() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.isConnected
+}
+
 func (c *sftpConnection) Wait() {
 	done := make(chan struct{})
 
@@ -0,0 +1,314 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"github.com/rs/xid"
+	"github.com/sftpgo/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/drakkan/sftpgo/v2/internal/kms"
+)
+
+const (
+	testSFTPFsUsername = "sftpfsuser"
+	testSFTPFsPassword = "sftpfspwd"
+)
+
+// testSFTPServer is an in-process SFTP server counting the SSH connections
+type testSFTPServer struct {
+	listener    net.Listener
+	connections atomic.Int32
+	active      atomic.Int32
+	mu          sync.Mutex
+	config      *ssh.ServerConfig
+}
+
+func startTestSFTPServer(t *testing.T) *testSFTPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &testSFTPServer{
+		listener: listener,
+	}
+	s.setHostKeys(newTestHostKey(t))
+	t.Cleanup(func() {
+		listener.Close()
+	})
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.handleConn(conn)
+		}
+	}()
+	return s
+}
+
+func newTestHostKey(t *testing.T) ssh.Signer {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(priv)
+	require.NoError(t, err)
+	return signer
+}
+
+// setHostKeys replaces the server host keys, the new keys are used for the
+// next connections
+func (s *testSFTPServer) setHostKeys(signers ...ssh.Signer) {
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if c.User() == testSFTPFsUsername && string(pass) == testSFTPFsPassword {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("invalid credentials for %q", c.User())
+		},
+	}
+	for _, signer := range signers {
+		config.AddHostKey(signer)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.config = config
+}
+
+func (s *testSFTPServer) handleConn(conn net.Conn) {
+	s.mu.Lock()
+	config := s.config
+	s.mu.Unlock()
+
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	s.connections.Add(1)
+	s.active.Add(1)
+	defer s.active.Add(-1)
+	defer sconn.Close()
+
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unsupported channel type") //nolint:errcheck
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go func(in <-chan *ssh.Request) {
+			for req := range in {
+				ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil) //nolint:errcheck
+			}
+		}(requests)
+		go func() {
+			server, err := sftp.NewServer(channel)
+			if err != nil {
+				channel.Close()
+				return
+			}
+			server.Serve() //nolint:errcheck
+			server.Close()
+		}()
+	}
+}
+
+func (s *testSFTPServer) getFsConfig(poolSize int) SFTPFsConfig {
+	return SFTPFsConfig{
+		BaseSFTPFsConfig: sdk.BaseSFTPFsConfig{
+			Endpoint: s.listener.Addr().String(),
+			Username: testSFTPFsUsername,
+		},
+		Password: kms.NewPlainSecret(testSFTPFsPassword),
+		PoolSize: poolSize,
+	}
+}
+
+// closeConnections removes the cached connections and the pools for
+// this server, so the next sessions open new connections
+func (s *testSFTPServer) closeConnections() {
+	endpoint := s.listener.Addr().String()
+
+	sftpConnsCache.Lock()
+	for k, conn := range sftpConnsCache.items {
+		if conn.config.Endpoint == endpoint {
+			delete(sftpConnsCache.items, k)
+			conn.Close() //nolint:errcheck
+		}
+	}
+	sftpConnsCache.Unlock()
+
+	sftpPools.Lock()
+	defer sftpPools.Unlock()
+
+	for k, pool := range sftpPools.items {
+		if pool.config.Endpoint == endpoint {
+			delete(sftpPools.items, k)
+			pool.Close()
+		}
+	}
+}
+
+func getKnownHostsLine(host string, key ssh.PublicKey) string {
+	return fmt.Sprintf("%s %s", host, strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))))
+}
+
+func connectTestSFTPServer(s *testSFTPServer, config SFTPFsConfig) error {
+	defer s.closeConnections()
+
+	fs, err := NewSFTPFs(xid.New().String(), "", "", nil, config)
+	if err != nil {
+		return err
+	}
+	return fs.Close()
+}
+
+func TestSFTPFsKnownHostsValidation(t *testing.T) {
+	config := SFTPFsConfig{
+		BaseSFTPFsConfig: sdk.BaseSFTPFsConfig{
+			Endpoint: "127.0.0.1:2022",
+			Username: testSFTPFsUsername,
+		},
+		Password:   kms.NewPlainSecret(testSFTPFsPassword),
+		KnownHosts: []string{"[127.0.0.1]:2022 ssh-ed25519 invalid"},
+	}
+	err := config.validate()
+	assert.ErrorContains(t, err, "invalid known_hosts entry")
+
+	key := newTestHostKey(t).PublicKey()
+	line := getKnownHostsLine("[127.0.0.1]:2022", key)
+	config.KnownHosts = []string{"", " # comment", " " + line + " "}
+	config.KnownHostsFile = "known_hosts"
+	err = config.validate()
+	assert.ErrorContains(t, err, "must be an absolute path")
+	config.KnownHostsFile = filepath.Join(os.TempDir(), "known_hosts")
+	err = config.validate()
+	require.NoError(t, err)
+	assert.Equal(t, []string{line}, config.KnownHosts)
+
+	other := config
+	other.KnownHosts = nil
+	assert.False(t, config.isEqual(other))
+	assert.NotEqual(t, config.getUniqueID(0), other.getUniqueID(0))
+	other.KnownHosts = []string{line}
+	assert.True(t, config.isEqual(other))
+	other.KnownHostsFile = ""
+	assert.False(t, config.isEqual(other))
+	assert.NotEqual(t, config.getUniqueID(0), other.getUniqueID(0))
+
+	fs := Filesystem{
+		Provider:   sdk.SFTPFilesystemProvider,
+		SFTPConfig: config,
+	}
+	fsCopy := fs.GetACopy()
+	assert.Equal(t, config.KnownHosts, fsCopy.SFTPConfig.KnownHosts)
+	assert.Equal(t, config.KnownHostsFile, fsCopy.SFTPConfig.KnownHostsFile)
+	fsCopy.SFTPConfig.KnownHosts[0] = "changed"
+	assert.Equal(t, line, fs.SFTPConfig.KnownHosts[0])
+}
+
+func TestSFTPFsKnownHosts(t *testing.T) {
+	server := startTestSFTPServer(t)
+	oldKey := newTestHostKey(t)
+	newKey := newTestHostKey(t)
+	server.setHostKeys(oldKey)
+	host := knownhosts.Normalize(server.listener.Addr().String())
+
+	config := server.getFsConfig(0)
+	config.KnownHosts = []string{getKnownHostsLine(host, oldKey.PublicKey())}
+	assert.NoError(t, connectTestSFTPServer(server, config))
+	// a pinned key different from the fingerprints is rejected
+	config.Fingerprints = []string{ssh.FingerprintSHA256(newKey.PublicKey())}
+	assert.ErrorContains(t, connectTestSFTPServer(server, config), "invalid fingerprint")
+	config.Fingerprints = []string{ssh.FingerprintSHA256(oldKey.PublicKey())}
+	assert.NoError(t, connectTestSFTPServer(server, config))
+	config.Fingerprints = nil
+	// rotate the server host key
+	server.setHostKeys(newKey)
+	err := connectTestSFTPServer(server, config)
+	assert.ErrorIs(t, err, ErrSFTPHostKeyChanged)
+	assert.NotErrorIs(t, err, ErrSFTPHostKeyUnknown)
+	// the new key is accepted if listed together with the old one
+	config.KnownHosts = append(config.KnownHosts, getKnownHostsLine(host, newKey.PublicKey()))
+	assert.NoError(t, connectTestSFTPServer(server, config))
+	server.setHostKeys(oldKey)
+	assert.NoError(t, connectTestSFTPServer(server, config))
+	// hashed host names
+	config.KnownHosts = []string{getKnownHostsLine(knownhosts.HashHostname(host), oldKey.PublicKey())}
+	assert.NoError(t, connectTestSFTPServer(server, config))
+	server.setHostKeys(newKey)
+	assert.ErrorIs(t, connectTestSFTPServer(server, config), ErrSFTPHostKeyChanged)
+	// keys for other hosts only
+	config.KnownHosts = []string{
+		getKnownHostsLine(knownhosts.HashHostname("otherhost"), newKey.PublicKey()),
+		getKnownHostsLine("[127.0.0.1]:1", newKey.PublicKey()),
+	}
+	err = connectTestSFTPServer(server, config)
+	assert.ErrorIs(t, err, ErrSFTPHostKeyUnknown)
+	assert.NotErrorIs(t, err, ErrSFTPHostKeyChanged)
+	// revoked keys
+	config.KnownHosts = []string{
+		getKnownHostsLine(host, newKey.PublicKey()),
+		getKnownHostsLine("@revoked *", newKey.PublicKey()),
+	}
+	assert.ErrorContains(t, connectTestSFTPServer(server, config), "revoked host key")
+	// known_hosts file, it is read for each new connection
+	knownHostsFile := filepath.Join(t.TempDir(), "known_hosts")
+	config.KnownHosts = nil
+	config.KnownHostsFile = knownHostsFile
+	assert.ErrorContains(t, connectTestSFTPServer(server, config), "unable to load the known hosts")
+	content := fmt.Sprintf("# comment\n%s\n", getKnownHostsLine(host, oldKey.PublicKey()))
+	err = os.WriteFile(knownHostsFile, []byte(content), 0600)
+	require.NoError(t, err)
+	assert.ErrorIs(t, connectTestSFTPServer(server, config), ErrSFTPHostKeyChanged)
+	content += getKnownHostsLine(knownhosts.HashHostname(host), newKey.PublicKey()) + "\n"
+	err = os.WriteFile(knownHostsFile, []byte(content), 0600)
+	require.NoError(t, err)
+	assert.NoError(t, connectTestSFTPServer(server, config))
+	// inline entries and file are used together
+	server.setHostKeys(oldKey)
+	err = os.WriteFile(knownHostsFile, []byte(getKnownHostsLine(host, newKey.PublicKey())), 0600)
+	require.NoError(t, err)
+	assert.ErrorIs(t, connectTestSFTPServer(server, config), ErrSFTPHostKeyChanged)
+	config.KnownHosts = []string{getKnownHostsLine(host, oldKey.PublicKey())}
+	assert.NoError(t, connectTestSFTPServer(server, config))
+	// pooled connections
+	config.PoolSize = 2
+	assert.NoError(t, connectTestSFTPServer(server, config))
+	server.setHostKeys(newKey)
+	config.KnownHostsFile = ""
+	assert.ErrorIs(t, connectTestSFTPServer(server, config), ErrSFTPHostKeyChanged)
+}
@@ -0,0 +1,124 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+)
+
+// validateKnownHosts removes the empty and comment lines from the inline
+// known_hosts entries and returns an error if an entry or the known_hosts
+// file path is not valid
+func (c *SFTPFsConfig) validateKnownHosts() error {
+	var entries []string
+	for _, entry := range c.KnownHosts {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		if _, _, _, _, _, err := ssh.ParseKnownHosts([]byte(entry)); err != nil {
+			return fmt.Errorf("invalid known_hosts entry %q: %w", entry, err)
+		}
+		entries = append(entries, entry)
+	}
+	c.KnownHosts = entries
+	c.KnownHostsFile = strings.TrimSpace(c.KnownHostsFile)
+	if c.KnownHostsFile != "" && !filepath.IsAbs(c.KnownHostsFile) {
+		return fmt.Errorf("invalid known_hosts_file %q, it must be an absolute path", c.KnownHostsFile)
+	}
+	return nil
+}
+
+// getKnownHostsCallback returns a callback that verifies the host keys using
+// the configured known_hosts entries and file, nil if none is configured.
+// The known_hosts file is read each time a callback is requested so the
+// updated host keys are used for the next connections
+func (c *SFTPFsConfig) getKnownHostsCallback() (ssh.HostKeyCallback, error) {
+	var files []string
+	if len(c.KnownHosts) > 0 {
+		f, err := os.CreateTemp("", "known_hosts")
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(f.Name())
+
+		_, err = f.WriteString(strings.Join(c.KnownHosts, "\n"))
+		if errClose := f.Close(); err == nil {
+			err = errClose
+		}
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f.Name())
+	}
+	if c.KnownHostsFile != "" {
+		files = append(files, c.KnownHostsFile)
+	}
+	if len(files) == 0 {
+		return nil, nil
+	}
+	return knownhosts.New(files...)
+}
+
+// checkKnownHosts verifies the host key for the specified host using the
+// configured known_hosts entries, if any
+func (c *sftpConnection) checkKnownHosts(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	callback, err := c.config.getKnownHostsCallback()
+	if err != nil {
+		logger.Log(logger.LevelError, c.logSender, "", "unable to load the known hosts: %v", err)
+		return fmt.Errorf("unable to load the known hosts: %w", err)
+	}
+	if callback == nil {
+		return nil
+	}
+	err = callback(hostname, remote, key)
+	if err == nil {
+		return nil
+	}
+	fp := ssh.FingerprintSHA256(key)
+	var keyErr *knownhosts.KeyError
+	var revokedErr *knownhosts.RevokedError
+	switch {
+	case errors.As(err, &keyErr) && len(keyErr.Want) == 0:
+		logger.Log(logger.LevelError, c.logSender, "", "unknown host key %q for host %q", fp, hostname)
+		return fmt.Errorf("%w: host %q, key %q", ErrSFTPHostKeyUnknown, hostname, fp)
+	case errors.As(err, &keyErr):
+		known := make([]string, 0, len(keyErr.Want))
+		for _, k := range keyErr.Want {
+			known = append(known, ssh.FingerprintSHA256(k.Key))
+		}
+		logger.Log(logger.LevelError, c.logSender, "",
+			"host key %q for host %q does not match the known keys %+v, possible man-in-the-middle attack",
+			fp, hostname, known)
+		return fmt.Errorf("%w: host %q, key %q", ErrSFTPHostKeyChanged, hostname, fp)
+	case errors.As(err, &revokedErr):
+		logger.Log(logger.LevelError, c.logSender, "", "revoked host key %q for host %q", fp, hostname)
+		return fmt.Errorf("revoked host key %q for host %q", fp, hostname)
+	default:
+		logger.Log(logger.LevelError, c.logSender, "", "unable to verify host key %q for host %q: %v",
+			fp, hostname, err)
+		return err
+	}
+}
@@ -0,0 +1,352 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/robfig/cron/v3"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/metric"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	logSenderSFTPPool = "sftpPool"
+	// maximum number of connections for an SFTP connections pool
+	maxSFTPPoolSize = 100
+	// maximum time to wait for a free connection from an exhausted pool
+	sftpPoolCheckoutTimeout = 30 * time.Second
+	// pooled connections idle for more than this time are closed
+	sftpPoolMaxIdleTime = 2 * time.Minute
+	// pools without sessions and checked out connections for more than
+	// this time are removed
+	sftpPoolMaxInactiveTime = 30 * time.Second
+)
+
+var (
+	errSFTPPoolTimeout = errors.New("sftpfs: timeout waiting for a pooled connection")
+	sftpPools          = newSFTPConnectionPools()
+)
+
+// sftpPooledFile is a file opened using a pooled connection, the
+// connection is released when the file is closed
+type sftpPooledFile struct {
+	*sftp.File
+	releaseOnce sync.Once
+	release     func()
+}
+
+func (f *sftpPooledFile) Close() error {
+	err := f.File.Close()
+	f.releaseOnce.Do(f.release)
+	return err
+}
+
+type idleSFTPConnection struct {
+	conn  *sftpConnection
+	since time.Time
+}
+
+// sftpConnectionPool is a bounded set of connections to an SFTP server
+// shared by all the sessions using the same endpoint and credentials.
+// A connection is checked out for a single request, or for the lifetime
+// of a file handle, and so it is never used by two requests at the same time
+type sftpConnectionPool struct {
+	config          *SFTPFsConfig
+	signer          ssh.Signer
+	logSender       string
+	checkoutTimeout time.Duration
+	// a token is sent for each checked out connection and received when it
+	// is released, so at most cap(slots) connections are in use
+	slots        chan struct{}
+	mu           sync.Mutex
+	idle         []idleSFTPConnection
+	sessions     map[string]bool
+	lastActivity time.Time
+	isClosed     bool
+}
+
+func newSFTPConnectionPool(config *SFTPFsConfig, signer ssh.Signer) *sftpConnectionPool {
+	return &sftpConnectionPool{
+		config:          config,
+		signer:          signer,
+		logSender:       fmt.Sprintf(`%s pool "%s@%s"`, sftpFsName, config.Username, config.Endpoint),
+		checkoutTimeout: sftpPoolCheckoutTimeout,
+		slots:           make(chan struct{}, config.PoolSize),
+		sessions:        make(map[string]bool),
+		lastActivity:    time.Now().UTC(),
+	}
+}
+
+// getClient checks out a connection and returns its SFTP client and the
+// function to call to release it
+func (p *sftpConnectionPool) getClient() (*sftp.Client, func(), error) {
+	conn, err := p.checkout()
+	if err != nil {
+		return nil, nil, err
+	}
+	client, err := conn.getClient()
+	if err != nil {
+		p.release(conn)
+		return nil, nil, err
+	}
+	return client, func() {
+		p.release(conn)
+	}, nil
+}
+
+// checkout returns a connection waiting for a free one if the pool is
+// exhausted. Idle connections are reused, most recently used first, after
+// checking that they are still connected
+func (p *sftpConnectionPool) checkout() (*sftpConnection, error) {
+	start := time.Now()
+	conn, err := p.getConnection()
+	metric.SFTPFsPoolCheckoutCompleted(time.Since(start), err)
+	return conn, err
+}
+
+func (p *sftpConnectionPool) getConnection() (*sftpConnection, error) {
+	if err := p.acquireSlot(); err != nil {
+		return nil, err
+	}
+	for {
+		conn := p.popIdle()
+		if conn == nil {
+			break
+		}
+		if conn.IsConnected() {
+			metric.SFTPFsPoolConnectionsUpdated(-1, 1)
+			return conn, nil
+		}
+		logger.Debug(p.logSender, "", "discarding disconnected idle connection")
+		metric.SFTPFsPoolConnectionsUpdated(-1, 0)
+		conn.Close() //nolint:errcheck
+	}
+	conn := &sftpConnection{
+		config:       p.config,
+		logSender:    p.logSender,
+		sessions:     make(map[string]bool),
+		lastActivity: time.Now().UTC(),
+		signer:       p.signer,
+	}
+	if err := conn.OpenConnection(); err != nil {
+		<-p.slots
+		return nil, err
+	}
+	metric.SFTPFsPoolConnectionsUpdated(0, 1)
+	return conn, nil
+}
+
+func (p *sftpConnectionPool) acquireSlot() error {
+	select {
+	case p.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	metric.SFTPFsPoolWaitersUpdated(1)
+	defer metric.SFTPFsPoolWaitersUpdated(-1)
+
+	timer := time.NewTimer(p.checkoutTimeout)
+	defer timer.Stop()
+
+	select {
+	case p.slots <- struct{}{}:
+		return nil
+	case <-timer.C:
+		logger.Warn(p.logSender, "", "no free connection after %s, pool size: %d", p.checkoutTimeout, cap(p.slots))
+		return errSFTPPoolTimeout
+	}
+}
+
+func (p *sftpConnectionPool) popIdle() *sftpConnection {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.idle) == 0 {
+		return nil
+	}
+	conn := p.idle[len(p.idle)-1].conn
+	p.idle = p.idle[:len(p.idle)-1]
+	return conn
+}
+
+// release returns a checked out connection to the pool. Disconnected
+// connections and the ones released after the pool is closed are closed
+func (p *sftpConnectionPool) release(conn *sftpConnection) {
+	defer func() {
+		<-p.slots
+	}()
+
+	p.mu.Lock()
+	if !p.isClosed && conn.IsConnected() {
+		p.idle = append(p.idle, idleSFTPConnection{
+			conn:  conn,
+			since: time.Now(),
+		})
+		p.mu.Unlock()
+		metric.SFTPFsPoolConnectionsUpdated(1, -1)
+		return
+	}
+	p.mu.Unlock()
+
+	metric.SFTPFsPoolConnectionsUpdated(0, -1)
+	conn.Close() //nolint:errcheck
+}
+
+// evictIdle closes the connections idle for more than maxIdle
+func (p *sftpConnectionPool) evictIdle(maxIdle time.Duration) {
+	idleBefore := time.Now().Add(-maxIdle)
+
+	p.mu.Lock()
+	var evicted []*sftpConnection
+	idle := p.idle[:0]
+	for _, c := range p.idle {
+		if c.since.Before(idleBefore) {
+			evicted = append(evicted, c.conn)
+		} else {
+			idle = append(idle, c)
+		}
+	}
+	clear(p.idle[len(idle):])
+	p.idle = idle
+	p.mu.Unlock()
+
+	if len(evicted) > 0 {
+		logger.Debug(p.logSender, "", "closing %d idle connections, remaining idle connections: %d",
+			len(evicted), len(idle))
+		metric.SFTPFsPoolConnectionsUpdated(-len(evicted), 0)
+	}
+	for _, conn := range evicted {
+		conn.Close() //nolint:errcheck
+	}
+}
+
+// Close closes the idle connections, the checked out ones are closed when released
+func (p *sftpConnectionPool) Close() {
+	p.mu.Lock()
+	p.isClosed = true
+	p.mu.Unlock()
+
+	p.evictIdle(-time.Hour)
+}
+
+func (p *sftpConnectionPool) AddSession(sessionID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.sessions[sessionID] = true
+	logger.Debug(p.logSender, "", "added session %s, active sessions: %d", sessionID, len(p.sessions))
+}
+
+func (p *sftpConnectionPool) RemoveSession(sessionID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.sessions, sessionID)
+	logger.Debug(p.logSender, "", "removed session %s, active sessions: %d", sessionID, len(p.sessions))
+	if len(p.sessions) == 0 {
+		p.lastActivity = time.Now().UTC()
+	}
+}
+
+// isInactive returns true if the pool has no sessions and no checked out
+// connections since the specified time
+func (p *sftpConnectionPool) isInactive(since time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.sessions) == 0 && len(p.slots) == 0 && p.lastActivity.Before(since)
+}
+
+// getStats returns the number of idle and checked out connections
+func (p *sftpConnectionPool) getStats() (int, int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.idle), len(p.slots)
+}
+
+type sftpConnectionPools struct {
+	scheduler *cron.Cron
+	sync.Mutex
+	items map[string]*sftpConnectionPool
+}
+
+func newSFTPConnectionPools() *sftpConnectionPools {
+	c := &sftpConnectionPools{
+		scheduler: cron.New(cron.WithLocation(time.UTC), cron.WithLogger(cron.DiscardLogger)),
+		items:     make(map[string]*sftpConnectionPool),
+	}
+	_, err := c.scheduler.AddFunc("@every 1m", c.Cleanup)
+	util.PanicOnError(err)
+	c.scheduler.Start()
+	return c
+}
+
+// Get returns the pool for the specified configuration and adds the
+// specified session to it
+func (c *sftpConnectionPools) Get(config *SFTPFsConfig, sessionID string) (*sftpConnectionPool, error) {
+	key := fmt.Sprintf("%s_%d", config.getUniqueID(0), config.PoolSize)
+
+	c.Lock()
+	defer c.Unlock()
+
+	if pool, ok := c.items[key]; ok {
+		pool.AddSession(sessionID)
+		return pool, nil
+	}
+	signer, err := config.getKeySigner()
+	if err != nil {
+		return nil, fmt.Errorf("sftpfs: unable to parse the private key: %w", err)
+	}
+	pool := newSFTPConnectionPool(config, signer)
+	pool.AddSession(sessionID)
+	c.items[key] = pool
+	logger.Debug(logSenderSFTPPool, "", "adding new pool for session ID %q, size: %d, key: %s, active pools: %d",
+		sessionID, config.PoolSize, key, len(c.items))
+	return pool, nil
+}
+
+// Cleanup removes the inactive pools and closes the idle connections
+// for the active ones
+func (c *sftpConnectionPools) Cleanup() {
+	var removed []*sftpConnectionPool
+	inactiveSince := time.Now().UTC().Add(-sftpPoolMaxInactiveTime)
+
+	c.Lock()
+	for key, pool := range c.items {
+		if pool.isInactive(inactiveSince) {
+			delete(c.items, key)
+			removed = append(removed, pool)
+			logger.Debug(logSenderSFTPPool, "", "removed inactive pool with key %s, active pools: %d",
+				key, len(c.items))
+			continue
+		}
+		pool.evictIdle(sftpPoolMaxIdleTime)
+	}
+	c.Unlock()
+
+	for _, pool := range removed {
+		pool.Close()
+	}
+}
@@ -0,0 +1,250 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sftpgo/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/kms"
+)
+
+func getTestSFTPPool(t *testing.T, fs Fs) *sftpConnectionPool {
+	sftpFs, ok := fs.(*SFTPFs)
+	require.True(t, ok)
+	require.NotNil(t, sftpFs.pool)
+	return sftpFs.pool
+}
+
+func TestSFTPFsPoolSizeValidation(t *testing.T) {
+	config := SFTPFsConfig{
+		BaseSFTPFsConfig: sdk.BaseSFTPFsConfig{
+			Endpoint: "127.0.0.1:22",
+			Username: testSFTPFsUsername,
+		},
+		Password: kms.NewPlainSecret(testSFTPFsPassword),
+		PoolSize: -1,
+	}
+	err := config.validate()
+	assert.ErrorContains(t, err, "invalid pool_size")
+	config.PoolSize = maxSFTPPoolSize + 1
+	err = config.validate()
+	assert.ErrorContains(t, err, "invalid pool_size")
+	config.PoolSize = maxSFTPPoolSize
+	assert.NoError(t, config.validate())
+
+	other := config
+	other.PoolSize = 1
+	assert.False(t, config.isEqual(other))
+	fs := Filesystem{
+		Provider:   sdk.SFTPFilesystemProvider,
+		SFTPConfig: config,
+	}
+	assert.Equal(t, maxSFTPPoolSize, fs.GetACopy().SFTPConfig.PoolSize)
+}
+
+func TestSFTPFsPoolSharedConnections(t *testing.T) {
+	server := startTestSFTPServer(t)
+	dir := t.TempDir()
+
+	var filesystems []Fs
+	for i := 0; i < 3; i++ {
+		fs, err := NewSFTPFs(fmt.Sprintf("conn%d", i), "", "", nil, server.getFsConfig(2))
+		require.NoError(t, err)
+		filesystems = append(filesystems, fs)
+	}
+	defer server.closeConnections()
+	pool := getTestSFTPPool(t, filesystems[0])
+	for _, fs := range filesystems {
+		assert.Equal(t, pool, getTestSFTPPool(t, fs))
+	}
+	// the connection used to check the login is now idle
+	idle, inUse := pool.getStats()
+	assert.Equal(t, 1, idle)
+	assert.Equal(t, 0, inUse)
+	assert.Equal(t, int32(1), server.connections.Load())
+
+	files := make([]File, 0, len(filesystems))
+	for idx, fs := range filesystems[:2] {
+		f, _, _, err := fs.Create(path.Join(dir, fmt.Sprintf("file%d", idx)), 0, 0)
+		require.NoError(t, err)
+		files = append(files, f)
+	}
+	// open files keep their connection checked out
+	idle, inUse = pool.getStats()
+	assert.Equal(t, 0, idle)
+	assert.Equal(t, 2, inUse)
+	assert.Equal(t, int32(2), server.connections.Load())
+	// the pool is exhausted
+	pool.checkoutTimeout = 100 * time.Millisecond
+	_, err := filesystems[2].Stat(dir)
+	assert.ErrorIs(t, err, errSFTPPoolTimeout)
+	// a waiting request gets the connection released by a closed file
+	pool.checkoutTimeout = 5 * time.Second
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := filesystems[2].Stat(dir)
+		errCh <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+	_, err = files[0].Write([]byte("data"))
+	assert.NoError(t, err)
+	assert.NoError(t, files[0].Close())
+	assert.NoError(t, <-errCh)
+	assert.NoError(t, files[1].Close())
+
+	idle, inUse = pool.getStats()
+	assert.Equal(t, 2, idle)
+	assert.Equal(t, 0, inUse)
+	assert.Equal(t, int32(2), server.connections.Load())
+	info, err := filesystems[2].Stat(path.Join(dir, "file0"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(4), info.Size())
+	}
+
+	for _, fs := range filesystems {
+		assert.NoError(t, fs.Close())
+	}
+	assert.True(t, pool.isInactive(time.Now().Add(time.Second)))
+	assert.False(t, pool.isInactive(time.Now().Add(-time.Minute)))
+	// sessions with a different pool size use a different pool
+	fs, err := NewSFTPFs("conn", "", "", nil, server.getFsConfig(1))
+	require.NoError(t, err)
+	otherPool := getTestSFTPPool(t, fs)
+	assert.NotEqual(t, pool, otherPool)
+	assert.NoError(t, fs.Close())
+	// a disabled pool uses the connections cache
+	fs, err = NewSFTPFs("conn", "", "", nil, server.getFsConfig(0))
+	require.NoError(t, err)
+	assert.Nil(t, fs.(*SFTPFs).pool)
+	assert.NoError(t, fs.Close())
+}
+
+func TestSFTPFsPoolHandles(t *testing.T) {
+	server := startTestSFTPServer(t)
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "file"), []byte("content"), 0666)
+	require.NoError(t, err)
+
+	fs, err := NewSFTPFs("conn", "", "", nil, server.getFsConfig(1))
+	require.NoError(t, err)
+	defer fs.Close()
+	defer server.closeConnections()
+	pool := getTestSFTPPool(t, fs)
+	pool.checkoutTimeout = 100 * time.Millisecond
+
+	f, _, _, err := fs.Open(path.Join(dir, "file"), 2)
+	require.NoError(t, err)
+	_, ok := f.(*sftpPooledFile)
+	assert.True(t, ok)
+	data, err := io.ReadAll(f)
+	assert.NoError(t, err)
+	assert.Equal(t, "ntent", string(data))
+	assert.NoError(t, f.Close())
+	// the handle cannot be used after the connection is released and a
+	// second close does not release the connection again
+	_, err = f.Read(make([]byte, 1))
+	assert.Error(t, err)
+	assert.Error(t, f.Close())
+	idle, inUse := pool.getStats()
+	assert.Equal(t, 1, idle)
+	assert.Equal(t, 0, inUse)
+	// failed opens release the connection
+	_, _, _, err = fs.Open(path.Join(dir, "missing"), 0)
+	assert.True(t, fs.IsNotExist(err))
+	_, _, _, err = fs.Create(path.Join(dir, "missing", "file"), 0, 0)
+	assert.Error(t, err)
+	idle, inUse = pool.getStats()
+	assert.Equal(t, 1, idle)
+	assert.Equal(t, 0, inUse)
+	// requests using more than one round trip check out a single connection
+	_, _, err = fs.Rename(path.Join(dir, "file"), path.Join(dir, "renamed"), CheckUpdateModTime)
+	assert.NoError(t, err)
+	numFiles, size, err := fs.GetDirSize(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, numFiles)
+	assert.Equal(t, int64(7), size)
+	assert.Equal(t, int32(1), server.connections.Load())
+}
+
+func TestSFTPFsPoolHealthCheck(t *testing.T) {
+	server := startTestSFTPServer(t)
+	dir := t.TempDir()
+
+	fs, err := NewSFTPFs("conn", "", "", nil, server.getFsConfig(2))
+	require.NoError(t, err)
+	defer fs.Close()
+	defer server.closeConnections()
+	pool := getTestSFTPPool(t, fs)
+
+	idle, _ := pool.getStats()
+	require.Equal(t, 1, idle)
+	conn := pool.idle[0].conn
+	// simulate a broken connection, it is discarded on checkout
+	err = conn.sshClient.Close()
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return !conn.IsConnected()
+	}, 2*time.Second, 50*time.Millisecond)
+	_, err = fs.Stat(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), server.connections.Load())
+	idle, inUse := pool.getStats()
+	assert.Equal(t, 1, idle)
+	assert.Equal(t, 0, inUse)
+	assert.NotEqual(t, conn, pool.idle[0].conn)
+	// the idle connections are evicted after the max idle time
+	pool.evictIdle(time.Hour)
+	idle, _ = pool.getStats()
+	assert.Equal(t, 1, idle)
+	pool.evictIdle(-time.Second)
+	idle, _ = pool.getStats()
+	assert.Equal(t, 0, idle)
+	assert.Eventually(t, func() bool {
+		return server.active.Load() == 0
+	}, 2*time.Second, 50*time.Millisecond)
+	// connections released after the pool is closed are closed
+	client, release, err := pool.getClient()
+	require.NoError(t, err)
+	_, err = client.Stat(dir)
+	assert.NoError(t, err)
+	pool.Close()
+	release()
+	idle, inUse = pool.getStats()
+	assert.Equal(t, 0, idle)
+	assert.Equal(t, 0, inUse)
+	// login errors release the slot
+	config := server.getFsConfig(1)
+	config.Password = kms.NewPlainSecret("wrong")
+	_, err = NewSFTPFs("conn", "", "", nil, config)
+	assert.Error(t, err)
+	sftpPools.Lock()
+	defer sftpPools.Unlock()
+	for _, p := range sftpPools.items {
+		if p.config.Endpoint == config.Endpoint && p != pool {
+			_, inUse := p.getStats()
+			assert.Equal(t, 0, inUse)
+		}
+	}
+}
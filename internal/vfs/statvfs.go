@@ -0,0 +1,98 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"errors"
+
+	"github.com/pkg/sftp"
+)
+
+const (
+	// size and number of files available, in addition to the used ones, for
+	// the storage backends without a size limit, such as the cloud storages
+	unlimitedStorageSize  = int64(8 * 1024 * 1024 * 1024 * 1024) // 8TB
+	unlimitedStorageFiles = 1000000
+)
+
+// GetStatVFS returns the statistics for the filesystem containing the
+// specified path, as seen by a user with the specified quota. The quota
+// limits the total and free size and files, the remaining quota is further
+// limited to the real free space and files if the backend reports them.
+// Backends without a size limit, such as the cloud storages, report the used
+// size and files plus 8TB and 1 million files if there is no quota
+func GetStatVFS(fs Fs, name string, quota QuotaCheckResult) (*sftp.StatVFS, error) {
+	s, err := fs.GetAvailableDiskSize(name)
+	if err != nil {
+		if !errors.Is(err, ErrStorageSizeUnavailable) {
+			return nil, err
+		}
+		s = nil
+	}
+	if s != nil && quota.HasSpace && quota.QuotaSize == 0 && quota.QuotaFiles == 0 {
+		return s, nil
+	}
+
+	totalSize := quota.QuotaSize
+	totalFiles := int64(quota.QuotaFiles)
+	if s != nil {
+		if totalSize == 0 || totalSize > int64(s.TotalSpace()) {
+			totalSize = int64(s.TotalSpace())
+		}
+		if totalFiles == 0 || totalFiles > int64(s.Files) {
+			totalFiles = int64(s.Files)
+		}
+	}
+	if totalSize == 0 {
+		totalSize = quota.UsedSize + unlimitedStorageSize
+	}
+	if totalFiles == 0 {
+		totalFiles = int64(quota.UsedFiles) + unlimitedStorageFiles
+	}
+	freeSize := totalSize - quota.UsedSize
+	freeFiles := totalFiles - int64(quota.UsedFiles)
+	if s != nil {
+		// the remaining quota cannot exceed the real free space
+		if quota.QuotaSize > 0 {
+			freeSize = min(freeSize, int64(s.Frsize*s.Bavail))
+		}
+		if quota.QuotaFiles > 0 {
+			freeFiles = min(freeFiles, int64(s.Favail))
+		}
+	}
+	if !quota.HasSpace {
+		freeSize = 0
+		freeFiles = 0
+	}
+
+	bsize := uint64(4096)
+	for bsize > uint64(totalSize) {
+		bsize /= 4
+	}
+	bfree := uint64(max(freeSize, 0)) / bsize
+	ffree := uint64(max(freeFiles, 0))
+
+	return &sftp.StatVFS{
+		Bsize:   bsize,
+		Frsize:  bsize,
+		Blocks:  uint64(totalSize) / bsize,
+		Bfree:   bfree,
+		Bavail:  bfree,
+		Files:   uint64(totalFiles),
+		Ffree:   ffree,
+		Favail:  ffree,
+		Namemax: 255,
+	}, nil
+}
@@ -0,0 +1,117 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// statVFSTestFs is a local filesystem reporting the configured statistics
+type statVFSTestFs struct {
+	*OsFs
+	stat *sftp.StatVFS
+	err  error
+}
+
+func (fs *statVFSTestFs) GetAvailableDiskSize(_ string) (*sftp.StatVFS, error) {
+	return fs.stat, fs.err
+}
+
+func TestStatVFSWithQuota(t *testing.T) {
+	const mb = int64(1024 * 1024)
+	quota := QuotaCheckResult{
+		HasSpace:   true,
+		QuotaSize:  1024 * mb,
+		UsedSize:   600 * mb,
+		QuotaFiles: 0,
+		UsedFiles:  10,
+	}
+	// cloud backend, the quota figures are reported
+	s, err := GetStatVFS(&S3Fs{}, "/", quota)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(4096), s.Bsize)
+	assert.Equal(t, uint64(1024*mb), s.TotalSpace())
+	assert.Equal(t, uint64(424*mb), s.FreeSpace())
+	assert.Equal(t, uint64(10+unlimitedStorageFiles), s.Files)
+	assert.Equal(t, uint64(unlimitedStorageFiles), s.Favail)
+	// local backend with enough free space
+	fs := &statVFSTestFs{
+		OsFs: NewOsFs("", os.TempDir(), "", nil).(*OsFs),
+		stat: &sftp.StatVFS{
+			Bsize:  4096,
+			Frsize: 4096,
+			Blocks: uint64(10240 * mb / 4096),
+			Bfree:  uint64(5120 * mb / 4096),
+			Bavail: uint64(5120 * mb / 4096),
+			Files:  100000,
+			Ffree:  50000,
+			Favail: 50000,
+		},
+	}
+	s, err = GetStatVFS(fs, "/", quota)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1024*mb), s.TotalSpace())
+	assert.Equal(t, uint64(424*mb), s.FreeSpace())
+	assert.Equal(t, uint64(100000), s.Files)
+	assert.Equal(t, uint64(100000-10), s.Favail)
+	// the free space is limited to the real one
+	fs.stat.Bavail = uint64(300 * mb / 4096)
+	s, err = GetStatVFS(fs, "/", quota)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1024*mb), s.TotalSpace())
+	assert.Equal(t, uint64(300*mb), s.FreeSpace())
+	// no quota, the real figures are reported
+	s, err = GetStatVFS(fs, "/", QuotaCheckResult{HasSpace: true})
+	require.NoError(t, err)
+	assert.Equal(t, fs.stat, s)
+	// quota exceeded
+	quota.UsedSize = 1100 * mb
+	quota.HasSpace = false
+	s, err = GetStatVFS(fs, "/", quota)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1024*mb), s.TotalSpace())
+	assert.Equal(t, uint64(0), s.FreeSpace())
+	assert.Equal(t, uint64(0), s.Favail)
+
+	fs.err = errors.New("statvfs error")
+	_, err = GetStatVFS(fs, "/", quota)
+	assert.ErrorIs(t, err, fs.err)
+}
+
+func TestStatVFSUnlimited(t *testing.T) {
+	quota := QuotaCheckResult{
+		HasSpace:  true,
+		UsedSize:  8192,
+		UsedFiles: 2,
+	}
+	s, err := GetStatVFS(&S3Fs{}, "/", quota)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(8192+unlimitedStorageSize), s.TotalSpace())
+	assert.Equal(t, uint64(unlimitedStorageSize), s.FreeSpace())
+	assert.Equal(t, uint64(2+unlimitedStorageFiles), s.Files)
+	assert.Equal(t, uint64(unlimitedStorageFiles), s.Ffree)
+	// small quota
+	s, err = GetStatVFS(&S3Fs{}, "/", QuotaCheckResult{HasSpace: true, QuotaSize: 100, QuotaFiles: 5})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(64), s.Bsize)
+	assert.Equal(t, uint64(1), s.Blocks)
+	assert.Equal(t, uint64(5), s.Files)
+}
@@ -18,6 +18,8 @@ package vfs
 
 import (
 	"errors"
+	"os"
+	"syscall"
 
 	"golang.org/x/sys/unix"
 )
@@ -29,3 +31,11 @@ func isCrossDeviceError(err error) bool {
 func isInvalidNameError(_ error) bool {
 	return false
 }
+
+// getFileOwner returns the uid and gid for the specified file info, -1 if not available
+func getFileOwner(info os.FileInfo) (int, int) {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return int(stat.Uid), int(stat.Gid)
+	}
+	return -1, -1
+}
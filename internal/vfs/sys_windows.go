@@ -16,6 +16,7 @@ package vfs
 
 import (
 	"errors"
+	"os"
 
 	"golang.org/x/sys/windows"
 )
@@ -30,3 +31,8 @@ func isInvalidNameError(err error) bool {
 	}
 	return errors.Is(err, windows.ERROR_INVALID_NAME)
 }
+
+// getFileOwner returns -1, the ownership is not preserved on Windows
+func getFileOwner(_ os.FileInfo) (int, int) {
+	return -1, -1
+}
@@ -0,0 +1,203 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"os"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/drakkan/sftpgo/v2/internal/tracing"
+)
+
+// tracedFs wraps a Fs and adds a tracing span for each backend call,
+// as child of the span registered for the connection, if any
+type tracedFs struct {
+	Fs
+}
+
+// tracedRealPatherFs is a tracedFs for the filesystems implementing FsRealPather
+type tracedRealPatherFs struct {
+	tracedFs
+}
+
+func (fs *tracedRealPatherFs) RealPath(p string) (string, error) {
+	return fs.Fs.(FsRealPather).RealPath(p)
+}
+
+// tracedFileCopierFs is a tracedFs for the filesystems implementing FsFileCopier
+type tracedFileCopierFs struct {
+	tracedFs
+}
+
+func (fs *tracedFileCopierFs) CopyFile(source, target string, srcInfo os.FileInfo) (int, int64, error) {
+	span := fs.startSpan("CopyFile", source, attribute.String("fs.target", target))
+	numFiles, size, err := fs.Fs.(FsFileCopier).CopyFile(source, target, srcInfo)
+	tracing.EndSpan(span, err)
+	return numFiles, size, err
+}
+
+// NewTracedFs returns a Fs that adds a tracing span for each backend call.
+// The specified Fs is returned unchanged if the tracing is disabled
+func NewTracedFs(fs Fs) Fs {
+	if !tracing.IsEnabled() {
+		return fs
+	}
+	switch fs.(type) {
+	case FsRealPather:
+		return &tracedRealPatherFs{tracedFs{Fs: fs}}
+	case FsFileCopier:
+		return &tracedFileCopierFs{tracedFs{Fs: fs}}
+	default:
+		return &tracedFs{Fs: fs}
+	}
+}
+
+// UnwrapFs returns the Fs wrapped for tracing, PGP encryption or attributes
+// recording, if any
+func UnwrapFs(fs Fs) Fs {
+	switch f := fs.(type) {
+	case *tracedFs:
+		return UnwrapFs(f.Fs)
+	case *tracedRealPatherFs:
+		return UnwrapFs(f.Fs)
+	case *tracedFileCopierFs:
+		return UnwrapFs(f.Fs)
+	case *pgpFs:
+		return UnwrapFs(f.Fs)
+	case *pgpRealPatherFs:
+		return UnwrapFs(f.Fs)
+	case *pgpFileCopierFs:
+		return UnwrapFs(f.Fs)
+	case *attrsFs:
+		return UnwrapFs(f.Fs)
+	case *attrsFileCopierFs:
+		return UnwrapFs(f.Fs)
+	default:
+		return fs
+	}
+}
+
+func (fs *tracedFs) startSpan(operation, name string, attrs ...attribute.KeyValue) trace.Span {
+	attrs = append(attrs, attribute.String("fs.name", fs.Fs.Name()), attribute.String("fs.path", name))
+	return tracing.StartConnectionChildSpan(fs.Fs.ConnectionID(), "vfs."+operation, attrs...)
+}
+
+func (fs *tracedFs) Stat(name string) (os.FileInfo, error) {
+	span := fs.startSpan("Stat", name)
+	info, err := fs.Fs.Stat(name)
+	tracing.EndSpan(span, err)
+	return info, err
+}
+
+func (fs *tracedFs) Lstat(name string) (os.FileInfo, error) {
+	span := fs.startSpan("Lstat", name)
+	info, err := fs.Fs.Lstat(name)
+	tracing.EndSpan(span, err)
+	return info, err
+}
+
+func (fs *tracedFs) Open(name string, offset int64) (File, PipeReader, func(), error) {
+	span := fs.startSpan("Open", name)
+	f, r, cancelFn, err := fs.Fs.Open(name, offset)
+	tracing.EndSpan(span, err)
+	return f, r, cancelFn, err
+}
+
+func (fs *tracedFs) Create(name string, flag, checks int) (File, PipeWriter, func(), error) {
+	span := fs.startSpan("Create", name)
+	f, w, cancelFn, err := fs.Fs.Create(name, flag, checks)
+	tracing.EndSpan(span, err)
+	return f, w, cancelFn, err
+}
+
+func (fs *tracedFs) Rename(source, target string, checks int) (int, int64, error) {
+	span := fs.startSpan("Rename", source, attribute.String("fs.target", target))
+	numFiles, size, err := fs.Fs.Rename(source, target, checks)
+	tracing.EndSpan(span, err)
+	return numFiles, size, err
+}
+
+func (fs *tracedFs) Remove(name string, isDir bool) error {
+	span := fs.startSpan("Remove", name)
+	err := fs.Fs.Remove(name, isDir)
+	tracing.EndSpan(span, err)
+	return err
+}
+
+func (fs *tracedFs) Mkdir(name string) error {
+	span := fs.startSpan("Mkdir", name)
+	err := fs.Fs.Mkdir(name)
+	tracing.EndSpan(span, err)
+	return err
+}
+
+func (fs *tracedFs) Symlink(source, target string) error {
+	span := fs.startSpan("Symlink", source, attribute.String("fs.target", target))
+	err := fs.Fs.Symlink(source, target)
+	tracing.EndSpan(span, err)
+	return err
+}
+
+func (fs *tracedFs) Chown(name string, uid int, gid int) error {
+	span := fs.startSpan("Chown", name)
+	err := fs.Fs.Chown(name, uid, gid)
+	tracing.EndSpan(span, err)
+	return err
+}
+
+func (fs *tracedFs) Chmod(name string, mode os.FileMode) error {
+	span := fs.startSpan("Chmod", name)
+	err := fs.Fs.Chmod(name, mode)
+	tracing.EndSpan(span, err)
+	return err
+}
+
+func (fs *tracedFs) Chtimes(name string, atime, mtime time.Time, isUploading bool) error {
+	span := fs.startSpan("Chtimes", name)
+	err := fs.Fs.Chtimes(name, atime, mtime, isUploading)
+	tracing.EndSpan(span, err)
+	return err
+}
+
+func (fs *tracedFs) Truncate(name string, size int64) error {
+	span := fs.startSpan("Truncate", name)
+	err := fs.Fs.Truncate(name, size)
+	tracing.EndSpan(span, err)
+	return err
+}
+
+func (fs *tracedFs) ReadDir(dirname string) (DirLister, error) {
+	span := fs.startSpan("ReadDir", dirname)
+	lister, err := fs.Fs.ReadDir(dirname)
+	tracing.EndSpan(span, err)
+	return lister, err
+}
+
+func (fs *tracedFs) Readlink(name string) (string, error) {
+	span := fs.startSpan("Readlink", name)
+	target, err := fs.Fs.Readlink(name)
+	tracing.EndSpan(span, err)
+	return target, err
+}
+
+func (fs *tracedFs) GetDirSize(dirname string) (int, int64, error) {
+	span := fs.startSpan("GetDirSize", dirname)
+	numFiles, size, err := fs.Fs.GetDirSize(dirname)
+	tracing.EndSpan(span, err)
+	return numFiles, size, err
+}
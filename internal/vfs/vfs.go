@@ -16,9 +16,11 @@
 package vfs
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"net/url"
 	"os"
 	"path"
@@ -33,6 +35,7 @@ import (
 	"github.com/eikenb/pipeat"
 	"github.com/pkg/sftp"
 	"github.com/sftpgo/sdk"
+	"golang.org/x/time/rate"
 
 	"github.com/drakkan/sftpgo/v2/internal/kms"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
@@ -50,6 +53,15 @@ const (
 	ListerBatchSize = 1000
 )
 
+// Supported algorithms for the stored checksums
+const (
+	ChecksumMD5    = "md5"
+	ChecksumSHA256 = "sha256"
+	// ChecksumSHA256MetadataKey is the object metadata key for the SHA-256
+	// checksum saved on upload
+	ChecksumSHA256MetadataKey = "sftpgo_sha256"
+)
+
 // Additional checks for files
 const (
 	CheckParentDir     = 1
@@ -58,9 +70,11 @@ const (
 )
 
 var (
-	validAzAccessTier = []string{"", "Archive", "Hot", "Cool"}
+	validAzAccessTier = []string{"", "Archive", "Hot", "Cool", "Cold"}
 	// ErrStorageSizeUnavailable is returned if the storage backend does not support getting the size
 	ErrStorageSizeUnavailable = errors.New("unable to get available size for this storage backend")
+	// ErrChecksumUnavailable is returned if no usable checksum is stored for a file
+	ErrChecksumUnavailable = errors.New("stored checksum not available")
 	// ErrVfsUnsupported defines the error for an unsupported VFS operation
 	ErrVfsUnsupported        = errors.New("not supported")
 	errInvalidDirListerLimit = errors.New("dir lister: invalid limit, must be > 0")
@@ -170,6 +184,13 @@ type FsFileCopier interface {
 	CopyFile(source, target string, srcInfo os.FileInfo) (int, int64, error)
 }
 
+// FsChecksumGetter is a Fs that can return the checksum stored for a file,
+// if any, without reading its contents.
+type FsChecksumGetter interface {
+	Fs
+	GetStoredChecksum(name, algo string) (string, error)
+}
+
 // File defines an interface representing a SFTPGo file
 type File interface {
 	io.Reader
@@ -229,6 +250,18 @@ type Metadater interface {
 	Metadata() map[string]string
 }
 
+// MetadataSetter defines an interface to implement to add custom metadata to a file.
+// The specified keys are added to the existing metadata
+type MetadataSetter interface {
+	SetMetadata(name string, metadata map[string]string) error
+}
+
+// MultipartUploadsCleaner defines an interface to implement to abort the
+// incomplete multipart uploads left, for example, by server restarts
+type MultipartUploadsCleaner interface {
+	AbortIncompleteUploads(ctx context.Context, initiatedBefore time.Time, limiter *rate.Limiter) (int, error)
+}
+
 type baseDirLister struct {
 	cache []os.FileInfo
 }
@@ -291,6 +324,27 @@ type S3FsConfig struct {
 	sdk.BaseS3FsConfig
 	AccessSecret   *kms.Secret `json:"access_secret,omitempty"`
 	SSECustomerKey *kms.Secret `json:"sse_customer_key,omitempty"`
+	// Content types to set for the uploaded files matching the given patterns.
+	// Used if the content type detection is enabled
+	ContentTypes []ContentTypeOverride `json:"content_types,omitempty"`
+	// Storage class and tags to apply to the objects matching the given patterns
+	UploadRules []S3UploadRule `json:"upload_rules,omitempty"`
+	// Set to "requester" to access requester pays buckets, the requester is
+	// charged for the requests and the data transfer
+	RequestPayer string `json:"request_payer,omitempty"`
+	// External ID to use when assuming the configured role, required by
+	// some cross-account trust policies
+	ExternalID string `json:"external_id,omitempty"`
+	// Session name to use when assuming the configured role.
+	// If empty a default one is generated
+	RoleSessionName string `json:"role_session_name,omitempty"`
+	// Local IP address for the connections to the storage backend. Empty
+	// means the global setting, if any
+	OutboundBindAddress string `json:"outbound_bind_address,omitempty"`
+	// If enabled the hash SSH commands use the checksums stored by S3, if
+	// available, instead of reading the files: the ETag for md5sum and the
+	// checksum saved on upload for sha256sum
+	UseStoredChecksums bool `json:"use_stored_checksums,omitempty"`
 }
 
 // HideConfidentialData hides confidential data
@@ -319,6 +373,12 @@ func (c *S3FsConfig) isEqual(other S3FsConfig) bool {
 	if c.RoleARN != other.RoleARN {
 		return false
 	}
+	if c.ExternalID != other.ExternalID {
+		return false
+	}
+	if c.RoleSessionName != other.RoleSessionName {
+		return false
+	}
 	if c.Endpoint != other.Endpoint {
 		return false
 	}
@@ -337,6 +397,21 @@ func (c *S3FsConfig) isEqual(other S3FsConfig) bool {
 	if c.SkipTLSVerify != other.SkipTLSVerify {
 		return false
 	}
+	if !areContentTypeOverridesEqual(c.ContentTypes, other.ContentTypes) {
+		return false
+	}
+	if !areS3UploadRulesEqual(c.UploadRules, other.UploadRules) {
+		return false
+	}
+	if c.RequestPayer != other.RequestPayer {
+		return false
+	}
+	if c.UseStoredChecksums != other.UseStoredChecksums {
+		return false
+	}
+	if c.OutboundBindAddress != other.OutboundBindAddress {
+		return false
+	}
 	return c.isSecretEqual(other)
 }
 
@@ -499,6 +574,9 @@ func (c *S3FsConfig) validate() error {
 	if err := c.checkCredentials(); err != nil {
 		return err
 	}
+	if err := c.checkAssumeRole(); err != nil {
+		return err
+	}
 	if c.KeyPrefix != "" {
 		if strings.HasPrefix(c.KeyPrefix, "/") {
 			return util.NewI18nError(errors.New("key_prefix cannot start with /"), util.I18nErrorKeyPrefixInvalid)
@@ -510,6 +588,23 @@ func (c *S3FsConfig) validate() error {
 	}
 	c.StorageClass = strings.TrimSpace(c.StorageClass)
 	c.ACL = strings.TrimSpace(c.ACL)
+	if err := validateContentTypeOverrides(c.ContentTypes); err != nil {
+		return err
+	}
+	if err := validateS3UploadRules(c.UploadRules); err != nil {
+		return err
+	}
+	c.RequestPayer = strings.ToLower(strings.TrimSpace(c.RequestPayer))
+	if c.RequestPayer != "" && c.RequestPayer != s3RequestPayerRequester {
+		return util.NewI18nError(
+			fmt.Errorf("invalid request payer %q, allowed values: %q", c.RequestPayer, s3RequestPayerRequester),
+			util.I18nErrorFsValidation,
+		)
+	}
+	c.OutboundBindAddress = strings.TrimSpace(c.OutboundBindAddress)
+	if err := validateOutboundBindAddress(c.OutboundBindAddress); err != nil {
+		return err
+	}
 	return c.checkPartSizeAndConcurrency()
 }
 
@@ -517,6 +612,18 @@ func (c *S3FsConfig) validate() error {
 type GCSFsConfig struct {
 	sdk.BaseGCSFsConfig
 	Credentials *kms.Secret `json:"credentials,omitempty"`
+	// Content types to set for the uploaded files matching the given patterns.
+	// Used if the content type detection is enabled
+	ContentTypes []ContentTypeOverride `json:"content_types,omitempty"`
+	// Cloud KMS key used to encrypt the written objects, for example
+	// "projects/my-project/locations/us/keyRings/my-ring/cryptoKeys/my-key".
+	// Leave empty to use the bucket default encryption
+	KMSKeyName string `json:"kms_key_name,omitempty"`
+	// Custom metadata added to all the written objects
+	ObjectMetadata map[string]string `json:"object_metadata,omitempty"`
+	// Local IP address for the connections to the storage backend. Empty
+	// means the global setting, if any
+	OutboundBindAddress string `json:"outbound_bind_address,omitempty"`
 }
 
 // HideConfidentialData hides confidential data
@@ -571,6 +678,15 @@ func (c *GCSFsConfig) isEqual(other GCSFsConfig) bool {
 	if c.UploadPartMaxTime != other.UploadPartMaxTime {
 		return false
 	}
+	if !areContentTypeOverridesEqual(c.ContentTypes, other.ContentTypes) {
+		return false
+	}
+	if c.KMSKeyName != other.KMSKeyName {
+		return false
+	}
+	if !maps.Equal(c.ObjectMetadata, other.ObjectMetadata) {
+		return false
+	}
 	if c.Credentials == nil {
 		c.Credentials = kms.NewEmptySecret()
 	}
@@ -615,7 +731,17 @@ func (c *GCSFsConfig) validate() error {
 	if c.UploadPartMaxTime < 0 {
 		c.UploadPartMaxTime = 0
 	}
-	return nil
+	if err := c.validateKMSKeyName(); err != nil {
+		return err
+	}
+	if err := validateGCSObjectMetadata(c.ObjectMetadata); err != nil {
+		return err
+	}
+	c.OutboundBindAddress = strings.TrimSpace(c.OutboundBindAddress)
+	if err := validateOutboundBindAddress(c.OutboundBindAddress); err != nil {
+		return err
+	}
+	return validateContentTypeOverrides(c.ContentTypes)
 }
 
 // AzBlobFsConfig defines the configuration for Azure Blob Storage based filesystem
@@ -626,6 +752,16 @@ type AzBlobFsConfig struct {
 	AccountKey *kms.Secret `json:"account_key,omitempty"`
 	// Shared access signature URL, leave blank if using account/key
 	SASURL *kms.Secret `json:"sas_url,omitempty"`
+	// Content types to set for the uploaded files matching the given patterns.
+	// Used if the content type detection is enabled
+	ContentTypes []ContentTypeOverride `json:"content_types,omitempty"`
+	// Access tiers to set for the uploaded blobs matching the given patterns.
+	// The first matching override wins, the blobs not matching any override
+	// use the configured access tier
+	AccessTiers []AzBlobAccessTierOverride `json:"access_tiers,omitempty"`
+	// Local IP address for the connections to the storage backend. Empty
+	// means the global setting, if any
+	OutboundBindAddress string `json:"outbound_bind_address,omitempty"`
 }
 
 // HideConfidentialData hides confidential data
@@ -678,6 +814,12 @@ func (c *AzBlobFsConfig) isEqual(other AzBlobFsConfig) bool {
 	if c.AccessTier != other.AccessTier {
 		return false
 	}
+	if !areAzAccessTierOverridesEqual(c.AccessTiers, other.AccessTiers) {
+		return false
+	}
+	if !areContentTypeOverridesEqual(c.ContentTypes, other.ContentTypes) {
+		return false
+	}
 	return c.isSecretEqual(other)
 }
 
@@ -829,13 +971,25 @@ func (c *AzBlobFsConfig) validate() error {
 	if !slices.Contains(validAzAccessTier, c.AccessTier) {
 		return fmt.Errorf("invalid access tier %q, valid values: \"''%v\"", c.AccessTier, strings.Join(validAzAccessTier, ", "))
 	}
-	return nil
+	if err := validateAzAccessTierOverrides(c.AccessTiers); err != nil {
+		return err
+	}
+	c.OutboundBindAddress = strings.TrimSpace(c.OutboundBindAddress)
+	if err := validateOutboundBindAddress(c.OutboundBindAddress); err != nil {
+		return err
+	}
+	return validateContentTypeOverrides(c.ContentTypes)
 }
 
 // CryptFsConfig defines the configuration to store local files as encrypted
 type CryptFsConfig struct {
 	sdk.OSFsConfig
 	Passphrase *kms.Secret `json:"passphrase,omitempty"`
+	// Passphrases used before the current one. They are used only to decrypt
+	// the existing files, new files are always encrypted using the current
+	// passphrase. The files can be re-encrypted using the current passphrase
+	// so the retired ones can be removed
+	RetiredPassphrases []*kms.Secret `json:"retired_passphrases,omitempty"`
 }
 
 // HideConfidentialData hides confidential data
@@ -843,6 +997,24 @@ func (c *CryptFsConfig) HideConfidentialData() {
 	if c.Passphrase != nil {
 		c.Passphrase.Hide()
 	}
+	for _, passphrase := range c.RetiredPassphrases {
+		if passphrase != nil {
+			passphrase.Hide()
+		}
+	}
+}
+
+// hasRedactedSecret returns true if the configuration has a redacted secret
+func (c *CryptFsConfig) hasRedactedSecret() bool {
+	if c.Passphrase.IsRedacted() {
+		return true
+	}
+	for _, passphrase := range c.RetiredPassphrases {
+		if passphrase != nil && passphrase.IsRedacted() {
+			return true
+		}
+	}
+	return false
 }
 
 func (c *CryptFsConfig) isEqual(other CryptFsConfig) bool {
@@ -852,9 +1024,38 @@ func (c *CryptFsConfig) isEqual(other CryptFsConfig) bool {
 	if other.Passphrase == nil {
 		other.Passphrase = kms.NewEmptySecret()
 	}
+	if len(c.RetiredPassphrases) != len(other.RetiredPassphrases) {
+		return false
+	}
+	for idx, passphrase := range c.RetiredPassphrases {
+		if passphrase == nil || other.RetiredPassphrases[idx] == nil {
+			if passphrase != other.RetiredPassphrases[idx] {
+				return false
+			}
+			continue
+		}
+		if !passphrase.IsEqual(other.RetiredPassphrases[idx]) {
+			return false
+		}
+	}
 	return c.Passphrase.IsEqual(other.Passphrase)
 }
 
+func (c *CryptFsConfig) getRetiredPassphrasesCopy() []*kms.Secret {
+	if len(c.RetiredPassphrases) == 0 {
+		return nil
+	}
+	passphrases := make([]*kms.Secret, 0, len(c.RetiredPassphrases))
+	for _, passphrase := range c.RetiredPassphrases {
+		if passphrase == nil {
+			passphrases = append(passphrases, nil)
+			continue
+		}
+		passphrases = append(passphrases, passphrase.Clone())
+	}
+	return passphrases
+}
+
 // ValidateAndEncryptCredentials validates the configuration and encrypts the passphrase if it is in plain text
 func (c *CryptFsConfig) ValidateAndEncryptCredentials(additionalData string) error {
 	if err := c.validate(); err != nil {
@@ -874,6 +1075,17 @@ func (c *CryptFsConfig) ValidateAndEncryptCredentials(additionalData string) err
 			)
 		}
 	}
+	for idx, passphrase := range c.RetiredPassphrases {
+		if passphrase.IsPlain() {
+			passphrase.SetAdditionalData(additionalData)
+			if err := passphrase.Encrypt(); err != nil {
+				return util.NewI18nError(
+					util.NewValidationError(fmt.Sprintf("could not encrypt Crypt fs retired passphrase %d: %v", idx, err)),
+					util.I18nErrorFsValidation,
+				)
+			}
+		}
+	}
 	return nil
 }
 
@@ -892,6 +1104,14 @@ func (c *CryptFsConfig) validate() error {
 	if c.Passphrase.IsEncrypted() && !c.Passphrase.IsValid() {
 		return errors.New("invalid encrypted passphrase")
 	}
+	for idx, passphrase := range c.RetiredPassphrases {
+		if passphrase == nil || passphrase.IsEmpty() || !passphrase.IsValidInput() {
+			return fmt.Errorf("retired passphrase %d cannot be empty or invalid", idx)
+		}
+		if passphrase.IsEncrypted() && !passphrase.IsValid() {
+			return fmt.Errorf("invalid encrypted retired passphrase %d", idx)
+		}
+	}
 	return nil
 }
 
@@ -1045,7 +1265,7 @@ func IsHTTPFs(fs Fs) bool {
 
 // IsBufferedLocalOrSFTPFs returns true if this is a buffered SFTP or local filesystem
 func IsBufferedLocalOrSFTPFs(fs Fs) bool {
-	if osFs, ok := fs.(*OsFs); ok {
+	if osFs, ok := UnwrapFs(fs).(*OsFs); ok {
 		return osFs.writeBufferSize > 0
 	}
 	if !IsSFTPFs(fs) {
@@ -1056,6 +1276,7 @@ func IsBufferedLocalOrSFTPFs(fs Fs) bool {
 
 // FsOpenReturnsFile returns true if fs.Open returns a *os.File handle
 func FsOpenReturnsFile(fs Fs) bool {
+	fs = UnwrapFs(fs)
 	if osFs, ok := fs.(*OsFs); ok {
 		return osFs.readBufferSize == 0
 	}
@@ -1141,6 +1362,10 @@ func IsUploadResumeSupported(fs Fs, size int64) bool {
 	if fs.IsUploadResumeSupported() {
 		return true
 	}
+	if HasCloudAtomicUploads(fs) {
+		// the emulated resume rewrites the existing object, this is not atomic
+		return false
+	}
 	return fs.IsConditionalUploadResumeSupported(size)
 }
 
@@ -1227,50 +1452,6 @@ func getLocalTempDir() string {
 	return filepath.Clean(os.TempDir())
 }
 
-func doRecursiveRename(fs Fs, source, target string,
-	renameFn func(string, string, os.FileInfo, int, bool) (int, int64, error),
-	recursion int, updateModTime bool,
-) (int, int64, error) {
-	var numFiles int
-	var filesSize int64
-
-	if recursion > util.MaxRecursion {
-		return numFiles, filesSize, util.ErrRecursionTooDeep
-	}
-	recursion++
-
-	lister, err := fs.ReadDir(source)
-	if err != nil {
-		return numFiles, filesSize, err
-	}
-	defer lister.Close()
-
-	for {
-		entries, err := lister.Next(ListerBatchSize)
-		finished := errors.Is(err, io.EOF)
-		if err != nil && !finished {
-			return numFiles, filesSize, err
-		}
-		for _, info := range entries {
-			sourceEntry := fs.Join(source, info.Name())
-			targetEntry := fs.Join(target, info.Name())
-			files, size, err := renameFn(sourceEntry, targetEntry, info, recursion, updateModTime)
-			if err != nil {
-				if fs.IsNotExist(err) {
-					fsLog(fs, logger.LevelInfo, "skipping rename for %q: %v", sourceEntry, err)
-					continue
-				}
-				return numFiles, filesSize, err
-			}
-			numFiles += files
-			filesSize += size
-		}
-		if finished {
-			return numFiles, filesSize, nil
-		}
-	}
-}
-
 func fsLog(fs Fs, level logger.LogLevel, format string, v ...any) {
 	logger.Log(level, fs.Name(), fs.ConnectionID(), format, v...)
 }
@@ -64,7 +64,7 @@ func newWebDavFile(baseTransfer *common.BaseTransfer, pipeWriter vfs.PipeWriter,
 	}
 	f := &webDavFile{
 		BaseTransfer: baseTransfer,
-		writer:       writer,
+		writer:       baseTransfer.WrapUploadWriter(writer),
 		reader:       reader,
 		isFinished:   false,
 		startOffset:  0,
@@ -150,7 +150,7 @@ func (f *webDavFile) Stat() (os.FileInfo, error) {
 		return nil, f.Connection.GetFsError(f.Fs, err)
 	}
 	if vfs.IsCryptOsFs(f.Fs) {
-		info = f.Fs.(*vfs.CryptFs).ConvertFileInfo(info)
+		info = vfs.UnwrapFs(f.Fs).(*vfs.CryptFs).ConvertFileInfo(info)
 	}
 	fi := &webDavFileInfo{
 		FileInfo:    info,
@@ -246,6 +246,7 @@ func (f *webDavFile) Write(p []byte) (n int, err error) {
 
 	n, err = f.writer.Write(p)
 	f.BytesReceived.Add(int64(n))
+	f.UpdateChecksum(p[:n], -1)
 
 	if err == nil {
 		err = f.CheckWrite()
@@ -268,7 +269,7 @@ func (f *webDavFile) updateStatInfo() error {
 		return err
 	}
 	if vfs.IsCryptOsFs(f.Fs) {
-		info = f.Fs.(*vfs.CryptFs).ConvertFileInfo(info)
+		info = vfs.UnwrapFs(f.Fs).(*vfs.CryptFs).ConvertFileInfo(info)
 	}
 	f.info = info
 	return nil
@@ -390,10 +391,12 @@ func (f *webDavFile) Close() error {
 }
 
 func (f *webDavFile) closeIO() error {
+	f.CompleteUploadInspection()
 	var err error
 	if f.File != nil {
 		err = f.File.Close()
 	} else if f.writer != nil {
+		f.CancelUploadOnError()
 		err = f.writer.Close()
 		f.Lock()
 		// we set ErrTransfer here so quota is not updated, in this case the uploads are atomic
@@ -441,11 +441,13 @@ func writeLog(r *http.Request, status int, err error) {
 func updateLoginMetrics(user *dataprovider.User, ip, loginMethod string, err error, r *http.Request) {
 	metric.AddLoginAttempt(loginMethod)
 	if err == nil {
-		logger.LoginLog(user.Username, ip, loginMethod, common.ProtocolWebDAV, "", r.UserAgent(), r.TLS != nil, "")
+		logger.LoginLog(user.Username, ip, loginMethod, common.ProtocolWebDAV, "", r.UserAgent(), r.TLS != nil, nil)
 		plugin.Handler.NotifyLogEvent(notifier.LogEventTypeLoginOK, common.ProtocolWebDAV, user.Username, ip, "", nil)
+		common.AddLoginResult(user.Username, ip, common.ProtocolWebDAV, nil)
+		common.UpdateAccountLockout(user.Username, ip, common.ProtocolWebDAV, nil)
 		common.DelayLogin(nil)
 	} else if err != common.ErrInternalFailure && err != common.ErrNoCredentials {
-		logger.ConnectionFailedLog(user.Username, ip, loginMethod, common.ProtocolWebDAV, err.Error())
+		logger.ConnectionFailedLog(user.Username, ip, loginMethod, common.ProtocolWebDAV, "", err.Error())
 		event := common.HostEventLoginFailed
 		logEv := notifier.LogEventTypeLoginFailed
 		if errors.Is(err, util.ErrNotFound) {
@@ -453,11 +455,16 @@ func updateLoginMetrics(user *dataprovider.User, ip, loginMethod string, err err
 			logEv = notifier.LogEventTypeLoginNoUser
 		}
 		common.AddDefenderEvent(ip, common.ProtocolWebDAV, event)
+		common.AddLoginResult(user.Username, ip, common.ProtocolWebDAV, err)
+		common.UpdateAccountLockout(user.Username, ip, common.ProtocolWebDAV, err)
 		plugin.Handler.NotifyLogEvent(logEv, common.ProtocolWebDAV, user.Username, ip, "", err)
 		if loginMethod != dataprovider.LoginMethodTLSCertificate {
 			common.DelayLogin(err)
 		}
 	}
 	metric.AddLoginResult(loginMethod, err)
+	if err != common.ErrInternalFailure && err != common.ErrNoCredentials {
+		dataprovider.AddLoginEvent(user.Username, ip, common.ProtocolWebDAV, loginMethod, r.UserAgent(), err)
+	}
 	dataprovider.ExecutePostLoginHook(user, loginMethod, ip, common.ProtocolWebDAV, err)
 }